@@ -17,6 +17,7 @@ var (
 	BindPaths          []string
 	HomePath           string
 	OverlayPath        []string
+	OverlayKey         string
 	ScratchPath        []string
 	WorkdirPath        string
 	PwdPath            string
@@ -24,16 +25,35 @@ var (
 	Hostname           string
 	Network            string
 	NetworkArgs        []string
+	Publish            []string
+	JoinSpec           string
 	DNS                string
+	DNSSearch          string
+	AddHosts           []string
 	Security           []string
+	UserSpec           string
+	Umask              string
+	Groups             []string
 	CgroupsPath        string
+	MemoryLimit        string
+	CPUsLimit          string
+	PidsLimit          string
+	BlkioWeight        string
+	Devices            []string
 	VMRAM              string
 	VMCPU              string
 	VMIP               string
 	ContainLibsPath    []string
 	FuseMount          []string
+	SifParts           []string
 	SingularityEnv     []string
 	SingularityEnvFile string
+	PreserveEnv        []string
+	EnvPrecedence      string
+	Timeout            string
+	TestReport         string
+	TestReportFormat   string
+	CommitPath         string
 
 	IsBoot          bool
 	IsFakeroot      bool
@@ -42,12 +62,19 @@ var (
 	IsContainAll    bool
 	IsWritable      bool
 	IsWritableTmpfs bool
+	LazyImage       bool
 	Nvidia          bool
+	NvCCLI          bool
 	Rocm            bool
 	NoHome          bool
 	NoInit          bool
+	NoSupplementary bool
+	Pty             bool
+	NoPty           bool
+	Interactive     bool
 	NoNvidia        bool
 	NoRocm          bool
+	VerifyImage     bool
 	VM              bool
 	VMErr           bool
 	NoNet           bool
@@ -85,7 +112,7 @@ var actionBindFlag = cmdline.Flag{
 	DefaultValue: []string{},
 	Name:         "bind",
 	ShortHand:    "B",
-	Usage:        "a user-bind path specification.  spec has the format src[:dest[:opts]], where src and dest are outside and inside paths.  If dest is not given, it is set equal to src.  Mount options ('opts') may be specified as 'ro' (read-only) or 'rw' (read/write, which is the default). Multiple bind paths can be given by a comma separated list.",
+	Usage:        "a user-bind path specification.  spec has the format src[:dest[:opts]], where src and dest are outside and inside paths.  If dest is not given, it is set equal to src.  Mount options ('opts') may be specified as 'ro' (read-only) or 'rw' (read/write, which is the default). If src is a SIF, squashfs, ext3 or erofs image, its file system is mounted and bound to dest, a path of the image file system may be selected with the 'image-src=<path>' option and a SIF partition with 'id=<id>', the 'file' option binds the image file itself. Multiple bind paths can be given by a comma separated list.",
 	EnvKeys:      []string{"BIND", "BINDPATH"},
 	Tag:          "<spec>",
 	EnvHandler:   cmdline.EnvAppendValue,
@@ -110,12 +137,24 @@ var actionOverlayFlag = cmdline.Flag{
 	DefaultValue: []string{},
 	Name:         "overlay",
 	ShortHand:    "o",
-	Usage:        "use an overlayFS image for persistent data storage or as read-only layer of container",
+	Usage:        "use an overlayFS image for persistent data storage or as read-only layer of container. Multiple overlays are stacked in the given order, each one on top of the previous ones, the only writable overlay is the top layer, use '<path>:ro' for the other ones",
 	EnvKeys:      []string{"OVERLAY", "OVERLAYIMAGE"},
 	Tag:          "<path>",
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --overlay-key
+var actionOverlayKeyFlag = cmdline.Flag{
+	ID:           "actionOverlayKeyFlag",
+	Value:        &OverlayKey,
+	DefaultValue: "",
+	Name:         "overlay-key",
+	Usage:        "passphrase of the encrypted overlays, the source is env:<variable>, keyring:<key> or passphrase to prompt for it",
+	EnvKeys:      []string{"OVERLAY_KEY"},
+	Tag:          "<source>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // -S|--scratch
 var actionScratchFlag = cmdline.Flag{
 	ID:           "actionScratchFlag",
@@ -195,7 +234,7 @@ var actionNetworkFlag = cmdline.Flag{
 	Value:        &Network,
 	DefaultValue: "bridge",
 	Name:         "network",
-	Usage:        "specify desired network type separated by commas, each network will bring up a dedicated interface inside container",
+	Usage:        "specify desired network type separated by commas, each network will bring up a dedicated interface inside container, or 'container:<name>' to share the network of the instance name (implies --net)",
 	EnvKeys:      []string{"NETWORK"},
 	Tag:          "<name>",
 	ExcludedOS:   []string{cmdline.Darwin},
@@ -213,6 +252,30 @@ var actionNetworkArgsFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --publish
+var actionPublishFlag = cmdline.Flag{
+	ID:           "actionPublishFlag",
+	Value:        &Publish,
+	DefaultValue: []string{},
+	Name:         "publish",
+	Usage:        "publish a container port on the host with the portmap CNI plugin (requires --net). spec has the format [hostIP:]hostPort[:containerPort][/protocol], an IPv6 host IP is given in brackets, the protocol is tcp (default) or udp. Multiple ports can be given by a comma separated list.",
+	EnvKeys:      []string{"PUBLISH"},
+	Tag:          "<spec>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --join
+var actionJoinFlag = cmdline.Flag{
+	ID:           "actionJoinFlag",
+	Value:        &JoinSpec,
+	DefaultValue: "",
+	Name:         "join",
+	Usage:        "join the namespaces of a running process, spec has the format <pid>[:<namespaces>] where namespaces is a comma separated list of net, ipc, uts and pid (default all of them), the container keeps its own root filesystem",
+	EnvKeys:      []string{"JOIN"},
+	Tag:          "<spec>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --dns
 var actionDNSFlag = cmdline.Flag{
 	ID:           "actionDnsFlag",
@@ -224,6 +287,29 @@ var actionDNSFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --dns-search
+var actionDNSSearchFlag = cmdline.Flag{
+	ID:           "actionDNSSearchFlag",
+	Value:        &DNSSearch,
+	DefaultValue: "",
+	Name:         "dns-search",
+	Usage:        "list of DNS search domains separated by commas replacing those of resolv.conf",
+	EnvKeys:      []string{"DNS_SEARCH"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --add-host
+var actionAddHostFlag = cmdline.Flag{
+	ID:           "actionAddHostFlag",
+	Value:        &AddHosts,
+	DefaultValue: []string{},
+	Name:         "add-host",
+	Usage:        "add a host entry in /etc/hosts of the container, spec has the format <name>:<IP>. Multiple entries can be given by a comma separated list.",
+	EnvKeys:      []string{"ADD_HOST"},
+	Tag:          "<spec>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --security
 var actionSecurityFlag = cmdline.Flag{
 	ID:           "actionSecurityFlag",
@@ -235,17 +321,124 @@ var actionSecurityFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --user
+var actionUserFlag = cmdline.Flag{
+	ID:           "actionUserFlag",
+	Value:        &UserSpec,
+	DefaultValue: "",
+	Name:         "user",
+	Usage:        "run the container process as an account of the container, spec has the format name|uid[:group|gid], requires root privileges or --fakeroot",
+	EnvKeys:      []string{"USER"},
+	Tag:          "<spec>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --umask
+var actionUmaskFlag = cmdline.Flag{
+	ID:           "actionUmaskFlag",
+	Value:        &Umask,
+	DefaultValue: "",
+	Name:         "umask",
+	Usage:        "set the umask of the container process, an octal mask such as 0027 (default 0022)",
+	EnvKeys:      []string{"UMASK"},
+	Tag:          "<mask>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --groups
+var actionGroupsFlag = cmdline.Flag{
+	ID:           "actionGroupsFlag",
+	Value:        &Groups,
+	DefaultValue: []string{},
+	Name:         "groups",
+	Usage:        "add host groups to the supplementary groups of the container process, a comma separated list of group names or IDs. Users can only add their own groups.",
+	EnvKeys:      []string{"GROUPS"},
+	Tag:          "<groups>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --no-supplementary-groups
+var actionNoSupplementaryFlag = cmdline.Flag{
+	ID:           "actionNoSupplementaryFlag",
+	Value:        &NoSupplementary,
+	DefaultValue: false,
+	Name:         "no-supplementary-groups",
+	Usage:        "drop the supplementary groups of the container process, except those added by --groups (root only)",
+	EnvKeys:      []string{"NO_SUPPLEMENTARY_GROUPS"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --apply-cgroups
 var actionApplyCgroupsFlag = cmdline.Flag{
 	ID:           "actionApplyCgroupsFlag",
 	Value:        &CgroupsPath,
 	DefaultValue: "",
 	Name:         "apply-cgroups",
-	Usage:        "apply cgroups from file for container processes (root only)",
+	Usage:        "apply cgroups from file for container processes (root only, or with cgroups v2 delegated by systemd)",
 	EnvKeys:      []string{"APPLY_CGROUPS"},
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --memory
+var actionMemoryFlag = cmdline.Flag{
+	ID:           "actionMemoryFlag",
+	Value:        &MemoryLimit,
+	DefaultValue: "",
+	Name:         "memory",
+	Usage:        "memory limit of the container processes, applied with cgroups (e.g. 8G)",
+	Tag:          "<size>",
+	EnvKeys:      []string{"MEMORY"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --cpus
+var actionCPUsFlag = cmdline.Flag{
+	ID:           "actionCPUsFlag",
+	Value:        &CPUsLimit,
+	DefaultValue: "",
+	Name:         "cpus",
+	Usage:        "number of CPUs available to the container processes, applied with cgroups (e.g. 1.5)",
+	Tag:          "<number>",
+	EnvKeys:      []string{"CPUS"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --pids-limit
+var actionPidsLimitFlag = cmdline.Flag{
+	ID:           "actionPidsLimitFlag",
+	Value:        &PidsLimit,
+	DefaultValue: "",
+	Name:         "pids-limit",
+	Usage:        "maximum number of container processes, applied with cgroups",
+	Tag:          "<number>",
+	EnvKeys:      []string{"PIDS_LIMIT"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --blkio-weight
+var actionBlkioWeightFlag = cmdline.Flag{
+	ID:           "actionBlkioWeightFlag",
+	Value:        &BlkioWeight,
+	DefaultValue: "",
+	Name:         "blkio-weight",
+	Usage:        "relative block IO weight of the container processes between 10 and 1000, applied with cgroups",
+	Tag:          "<weight>",
+	EnvKeys:      []string{"BLKIO_WEIGHT"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --device
+var actionDeviceFlag = cmdline.Flag{
+	ID:           "actionDeviceFlag",
+	Value:        &Devices,
+	DefaultValue: []string{},
+	Name:         "device",
+	Usage:        "bind a host device into the container and allow its access with cgroups, perms is a combination of r, w and m (default rwm)",
+	Tag:          "<device[:perms]>",
+	EnvKeys:      []string{"DEVICE"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --vm-ram
 var actionVMRAMFlag = cmdline.Flag{
 	ID:           "actionVMRAMFlag",
@@ -306,11 +499,23 @@ var actionFuseMountFlag = cmdline.Flag{
 	Value:        &FuseMount,
 	DefaultValue: []string{},
 	Name:         "fusemount",
-	Usage:        "A FUSE filesystem mount specification of the form '<type>:<fuse command> <mountpoint>' - where <type> is 'container' or 'host', specifying where the mount will be performed ('container-daemon' or 'host-daemon' will run the FUSE process detached). <fuse command> is the path to the FUSE executable, plus options for the mount. <mountpoint> is the location in the container to which the FUSE mount will be attached. E.g. 'container:sshfs 10.0.0.1:/ /sshfs'. Implies --pid.",
+	Usage:        "A FUSE filesystem mount specification of the form '<type>:<fuse command> <mountpoint>' - where <type> is 'container' or 'host', specifying where the mount will be performed ('container-daemon' or 'host-daemon' will run the FUSE process detached). <fuse command> is the path to the FUSE executable, plus options for the mount. <mountpoint> is the location in the container to which the FUSE mount will be attached. E.g. 'container:sshfs 10.0.0.1:/ /sshfs'. <type> can also be a built-in driver run from host, 'sshfs', 's3fs', 'goofys' or 'squashfuse', followed by the source to mount, its options and the mount point, e.g. 'squashfuse:data.sqfs /data', or 'profile' followed by the name of a profile defined in singularity.conf and the mount point, e.g. 'profile:scratch /scratch'. Implies --pid.",
 	EnvKeys:      []string{"FUSESPEC"},
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --sif-part
+var actionSifPartFlag = cmdline.Flag{
+	ID:           "actionSifPartFlag",
+	Value:        &SifParts,
+	DefaultValue: []string{},
+	Name:         "sif-part",
+	Usage:        "mount a data partition of the SIF image, selected by its ID or name, in the container. spec has the format <id|name>:<dest>[:ro], where dest is the inside path. Multiple partitions can be given by a comma separated list.",
+	EnvKeys:      []string{"SIF_PART"},
+	Tag:          "<spec>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // hidden flag to handle SINGULARITY_TMPDIR environment variable
 var actionTmpDirFlag = cmdline.Flag{
 	ID:           "actionTmpDirFlag",
@@ -392,6 +597,17 @@ var actionNvidiaFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --nvccli
+var actionNvCCLIFlag = cmdline.Flag{
+	ID:           "actionNvCCLIFlag",
+	Value:        &NvCCLI,
+	DefaultValue: false,
+	Name:         "nvccli",
+	Usage:        "use nvidia-container-cli for GPU setup, requires root, --fakeroot or --userns (experimental)",
+	EnvKeys:      []string{"NVCCLI"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --rocm flag to automatically bind
 var actionRocmFlag = cmdline.Flag{
 	ID:           "actionRocmFlag",
@@ -426,6 +642,29 @@ var actionWritableTmpfsFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --commit
+var actionCommitFlag = cmdline.Flag{
+	ID:           "actionCommitFlag",
+	Value:        &CommitPath,
+	DefaultValue: "",
+	Name:         "commit",
+	Usage:        "save the changes made in the writable tmpfs to a squashfs overlay image when the container exits, the image can be reused with --overlay (implies --writable-tmpfs, requires root, --fakeroot or --userns)",
+	EnvKeys:      []string{"COMMIT"},
+	Tag:          "<path.img>",
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --lazy
+var actionLazyFlag = cmdline.Flag{
+	ID:           "actionLazyFlag",
+	Value:        &LazyImage,
+	DefaultValue: false,
+	Name:         "lazy",
+	Usage:        "mount http(s) SIF images with FUSE and fetch only the blocks read instead of downloading them first",
+	EnvKeys:      []string{"LAZY"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --no-home
 var actionNoHomeFlag = cmdline.Flag{
 	ID:           "actionNoHomeFlag",
@@ -470,6 +709,17 @@ var actionNoRocmFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --verify
+var actionVerifyFlag = cmdline.Flag{
+	ID:           "actionVerifyFlag",
+	Value:        &VerifyImage,
+	DefaultValue: false,
+	Name:         "verify",
+	Usage:        "verify the image signature with the signature keyring of singularity.conf and your public keyring before running it",
+	EnvKeys:      []string{"VERIFY"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 // --vm
 var actionVMFlag = cmdline.Flag{
 	ID:           "actionVMFlag",
@@ -636,6 +886,99 @@ var actionEnvFileFlag = cmdline.Flag{
 	ExcludedOS:   []string{cmdline.Darwin},
 }
 
+// --preserve-env
+var actionPreserveEnvFlag = cmdline.Flag{
+	ID:           "actionPreserveEnvFlag",
+	Value:        &PreserveEnv,
+	DefaultValue: []string{},
+	Name:         "preserve-env",
+	Usage:        "forward host environment variables matching the shell patterns with --cleanenv",
+	Tag:          "<pattern>",
+	EnvKeys:      []string{"PRESERVE_ENV"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --env-precedence
+var actionEnvPrecedenceFlag = cmdline.Flag{
+	ID:           "actionEnvPrecedenceFlag",
+	Value:        &EnvPrecedence,
+	DefaultValue: "image",
+	Name:         "env-precedence",
+	Usage:        "precedence of the host environment variables over the image %environment (host|image), the --env, --env-file and SINGULARITYENV_ variables always take precedence",
+	Tag:          "<host|image>",
+	EnvKeys:      []string{"ENV_PRECEDENCE"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --pty
+var actionPtyFlag = cmdline.Flag{
+	ID:           "actionPtyFlag",
+	Value:        &Pty,
+	DefaultValue: false,
+	Name:         "pty",
+	Usage:        "run the container process in a pseudo terminal, even if the standard streams aren't terminals",
+	EnvKeys:      []string{"PTY"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --no-pty
+var actionNoPtyFlag = cmdline.Flag{
+	ID:           "actionNoPtyFlag",
+	Value:        &NoPty,
+	DefaultValue: false,
+	Name:         "no-pty",
+	Usage:        "run the container process without terminal, the standard streams which are terminals are relayed by pipes",
+	EnvKeys:      []string{"NO_PTY"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --interactive
+var actionInteractiveFlag = cmdline.Flag{
+	ID:           "actionInteractiveFlag",
+	Value:        &Interactive,
+	DefaultValue: false,
+	Name:         "interactive",
+	Usage:        "relay the standard input to the container process with --pty or --no-pty, it reads /dev/null otherwise",
+	EnvKeys:      []string{"INTERACTIVE"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --timeout
+var actionTimeoutFlag = cmdline.Flag{
+	ID:           "actionTimeoutFlag",
+	Value:        &Timeout,
+	DefaultValue: "",
+	Name:         "timeout",
+	Usage:        "send SIGTERM to the container process after duration (e.g. 90s, 2h30m), then SIGKILL after the grace period (default 10s, 0 to send SIGKILL right away), the exit code is 124 after a timeout",
+	Tag:          "<duration>[:<grace>]",
+	EnvKeys:      []string{"TIMEOUT"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --report
+var actionTestReportFlag = cmdline.Flag{
+	ID:           "actionTestReportFlag",
+	Value:        &TestReport,
+	DefaultValue: "",
+	Name:         "report",
+	Usage:        "write a report of the test results to path inside the container",
+	Tag:          "<path>",
+	EnvKeys:      []string{"TEST_REPORT"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
+// --report-format
+var actionTestReportFormatFlag = cmdline.Flag{
+	ID:           "actionTestReportFormatFlag",
+	Value:        &TestReportFormat,
+	DefaultValue: "",
+	Name:         "report-format",
+	Usage:        "format of the test report, json or junit (default junit for a .xml report, json otherwise)",
+	Tag:          "<format>",
+	EnvKeys:      []string{"TEST_REPORT_FORMAT"},
+	ExcludedOS:   []string{cmdline.Darwin},
+}
+
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterCmd(ExecCmd)
@@ -661,12 +1004,19 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&actionAppFlag, actionsCmd...)
 		cmdManager.RegisterFlagForCmd(&actionApplyCgroupsFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionBindFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionSifPartFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionBlkioWeightFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionCleanEnvFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionCommitFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionContainAllFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionContainFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionContainLibsFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionCPUsFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionDeviceFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionDisableCacheFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionDNSFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionDNSSearchFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionAddHostFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionDropCapsFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionFakerootFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionFuseMountFlag, actionsInstanceCmd...)
@@ -674,8 +1024,12 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&actionHostnameFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionIpcNamespaceFlag, actionsCmd...)
 		cmdManager.RegisterFlagForCmd(&actionKeepPrivsFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionLazyFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionMemoryFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNetNamespaceFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNetworkArgsFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionPublishFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionJoinFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNetworkFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNoHomeFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNoInitFlag, actionsInstanceCmd...)
@@ -684,19 +1038,29 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&actionNoRocmFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNoPrivsFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionNvidiaFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionNvCCLIFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionRocmFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionOverlayFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionOverlayKeyFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&commonPromptForPassphraseFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&commonPEMFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionPidNamespaceFlag, actionsCmd...)
+		cmdManager.RegisterFlagForCmd(&actionPidsLimitFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionPwdFlag, actionsCmd...)
 		cmdManager.RegisterFlagForCmd(&actionScratchFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionSecurityFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionUserFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionUmaskFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionGroupsFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionNoSupplementaryFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionShellFlag, ShellCmd)
 		cmdManager.RegisterFlagForCmd(&actionSyOSFlag, ShellCmd)
+		cmdManager.RegisterFlagForCmd(&actionTestReportFlag, TestCmd)
+		cmdManager.RegisterFlagForCmd(&actionTestReportFormatFlag, TestCmd)
 		cmdManager.RegisterFlagForCmd(&actionTmpDirFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionUserNamespaceFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionUtsNamespaceFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionVerifyFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionVMCPUFlag, actionsCmd...)
 		cmdManager.RegisterFlagForCmd(&actionVMErrFlag, actionsCmd...)
 		cmdManager.RegisterFlagForCmd(&actionVMFlag, actionsCmd...)
@@ -711,5 +1075,11 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&dockerUsernameFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionEnvFlag, actionsInstanceCmd...)
 		cmdManager.RegisterFlagForCmd(&actionEnvFileFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionPreserveEnvFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionEnvPrecedenceFlag, actionsInstanceCmd...)
+		cmdManager.RegisterFlagForCmd(&actionPtyFlag, actionsCmd...)
+		cmdManager.RegisterFlagForCmd(&actionNoPtyFlag, actionsCmd...)
+		cmdManager.RegisterFlagForCmd(&actionInteractiveFlag, actionsCmd...)
+		cmdManager.RegisterFlagForCmd(&actionTimeoutFlag, actionsCmd...)
 	})
 }
@@ -31,6 +31,10 @@ const (
 	defaultPath = "/bin:/usr/bin:/sbin:/usr/sbin:/usr/local/bin:/usr/local/sbin"
 )
 
+// imageURI holds the URI of the image replaced by replaceURIWithImage,
+// it's checked against the exempted URIs of the signature policy.
+var imageURI string
+
 func getCacheHandle(cfg cache.Config) *cache.Handle {
 	h, err := cache.New(cache.Config{
 		ParentDir: os.Getenv(cache.DirEnv),
@@ -92,6 +96,13 @@ func handleShub(ctx context.Context, imgCache *cache.Handle, pullFrom string) (s
 }
 
 func handleNet(ctx context.Context, imgCache *cache.Handle, pullFrom string) (string, error) {
+	if LazyImage {
+		image, err := mountLazyImage(imgCache, pullFrom)
+		if err == nil {
+			return image, nil
+		}
+		sylog.Warningf("Lazy access to %s not possible, downloading the image: %v", pullFrom, err)
+	}
 	return net.Pull(ctx, imgCache, pullFrom, tmpDir)
 }
 
@@ -128,6 +139,7 @@ func replaceURIWithImage(ctx context.Context, imgCache *cache.Handle, cmd *cobra
 		sylog.Fatalf("Unable to handle %s uri: %v", args[0], err)
 	}
 
+	imageURI = args[0]
 	args[0] = image
 }
 
@@ -247,6 +259,9 @@ var TestCmd = &cobra.Command{
 	Args:                  cobra.MinimumNArgs(1),
 	PreRun:                actionPreRun,
 	Run: func(cmd *cobra.Command, args []string) {
+		if TestReportFormat != "" && TestReportFormat != "json" && TestReportFormat != "junit" {
+			sylog.Fatalf("Unknown test report format %s, must be json or junit", TestReportFormat)
+		}
 		a := append([]string{"/.singularity.d/actions/test"}, args[1:]...)
 		setVM(cmd)
 		if VM {
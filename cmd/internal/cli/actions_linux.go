@@ -6,6 +6,8 @@
 package cli
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -16,8 +18,15 @@ import (
 	"syscall"
 	"time"
 
+	units "github.com/docker/go-units"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/spf13/cobra"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/build"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/cache"
+	"github.com/sylabs/singularity/internal/pkg/cgroups"
+	"github.com/sylabs/singularity/internal/pkg/client/net"
 	"github.com/sylabs/singularity/internal/pkg/instance"
 	"github.com/sylabs/singularity/internal/pkg/plugin"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engine/config/oci"
@@ -25,11 +34,16 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/security"
 	"github.com/sylabs/singularity/internal/pkg/util/env"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/erofs"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/files"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/fuse"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
 	"github.com/sylabs/singularity/internal/pkg/util/shell/interpreter"
 	"github.com/sylabs/singularity/internal/pkg/util/starter"
 	"github.com/sylabs/singularity/internal/pkg/util/user"
 	imgutil "github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/image/unpacker"
+	"github.com/sylabs/singularity/pkg/network"
 	clicallback "github.com/sylabs/singularity/pkg/plugin/callback/cli"
 	singularitycallback "github.com/sylabs/singularity/pkg/plugin/callback/runtime/engine/singularity"
 	"github.com/sylabs/singularity/pkg/runtime/engine/config"
@@ -45,6 +59,20 @@ import (
 	"golang.org/x/sys/unix"
 )
 
+// lazyImage is true when the image is an http(s) image mounted by --lazy.
+var lazyImage bool
+
+// mountLazyImage mounts the http(s) SIF image pullFrom with FUSE, root is
+// allowed to access it for the setuid workflow if fusermount permits it.
+func mountLazyImage(imgCache *cache.Handle, pullFrom string) (string, error) {
+	image, err := net.MountLazy(imgCache, pullFrom, tmpDir, os.Geteuid() != 0 && fuse.UserAllowOther())
+	if err != nil {
+		return "", err
+	}
+	lazyImage = true
+	return image, nil
+}
+
 func convertImage(filename string, unsquashfsPath string) (string, error) {
 	img, err := imgutil.Init(filename, false)
 	if err != nil {
@@ -72,6 +100,202 @@ func convertImage(filename string, unsquashfsPath string) (string, error) {
 		s.UnsquashfsPath = unsquashfsPath
 	}
 
+	// create temporary sandbox
+	dir, err := ioutil.TempDir(actionTmpDir(), "rootfs-")
+	if err != nil {
+		return "", fmt.Errorf("could not create temporary sandbox: %s", err)
+	}
+
+	// extract root filesystem
+	if err := s.ExtractAll(reader, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("root filesystem extraction failed: %s", err)
+	}
+
+	return dir, err
+}
+
+// readIdentityFile returns the content of the passwd or group file path of
+// an image, nil if it doesn't exist or isn't a regular file.
+func readIdentityFile(path string) ([]byte, error) {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		sylog.Verbosef("Ignoring %s, not a regular file", path)
+		return nil, nil
+	}
+	return ioutil.ReadFile(path)
+}
+
+// imageIdentityFiles returns the content of the passwd and group files
+// of a sandbox image or an image with a squashfs root filesystem.
+func imageIdentityFiles(filename string) (passwd, group []byte, err error) {
+	img, err := imgutil.Init(filename, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not open image %s: %s", filename, err)
+	}
+	defer img.File.Close()
+
+	rootfs := filename
+	if img.Type != imgutil.SANDBOX {
+		part, err := img.GetRootFsPartition()
+		if err != nil {
+			return nil, nil, fmt.Errorf("while getting root filesystem in %s: %s", filename, err)
+		}
+		if part.Type != imgutil.SQUASHFS {
+			return nil, nil, fmt.Errorf("not a squashfs root filesystem")
+		}
+		reader, err := imgutil.NewPartitionReader(img, "", 0)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not read root filesystem: %s", err)
+		}
+		s := unpacker.NewSquashfs()
+		if !s.HasUnsquashfs() {
+			return nil, nil, fmt.Errorf("unsquashfs is required to read the passwd and group files")
+		}
+
+		if rootfs, err = ioutil.TempDir(actionTmpDir(), "identity-"); err != nil {
+			return nil, nil, fmt.Errorf("could not create temporary directory: %s", err)
+		}
+		defer os.RemoveAll(rootfs)
+
+		if err := s.ExtractFiles([]string{"/etc/passwd", "/etc/group"}, reader, rootfs); err != nil {
+			return nil, nil, fmt.Errorf("while extracting passwd and group files: %s", err)
+		}
+	}
+
+	if passwd, err = readIdentityFile(filepath.Join(rootfs, "etc", "passwd")); err != nil {
+		return nil, nil, fmt.Errorf("while reading passwd file: %s", err)
+	}
+	if group, err = readIdentityFile(filepath.Join(rootfs, "etc", "group")); err != nil {
+		return nil, nil, fmt.Errorf("while reading group file: %s", err)
+	}
+	return passwd, group, nil
+}
+
+// containerUser returns the account of the --user spec in the image, the
+// numeric IDs of a spec are used as is if the image files can't be read.
+func containerUser(image, spec string) (*files.ContainerUser, error) {
+	passwd, group, err := imageIdentityFiles(image)
+	if err != nil {
+		u, lookupErr := files.LookupUser(nil, nil, spec)
+		if lookupErr != nil {
+			return nil, err
+		}
+		sylog.Verbosef("Using numeric IDs of user %s: %s", spec, err)
+		return u, nil
+	}
+	return files.LookupUser(passwd, group, spec)
+}
+
+// hostGroups returns the IDs of the host groups given by name or ID.
+func hostGroups(groups []string) ([]int, error) {
+	gids := make([]int, 0, len(groups))
+	for _, g := range groups {
+		if gid, err := strconv.ParseUint(g, 10, 32); err == nil {
+			gids = append(gids, int(gid))
+			continue
+		}
+		gr, err := user.GetGrNam(g)
+		if err != nil {
+			return nil, fmt.Errorf("group %s not found: %v", g, err)
+		}
+		gids = append(gids, int(gr.GID))
+	}
+	return gids, nil
+}
+
+// setTerminal sets the terminal of the container process selected by the
+// --pty, --no-pty and --interactive flags.
+func setTerminal(engineConfig *singularityConfig.EngineConfig, pty, noPty, interactive bool) error {
+	if pty && noPty {
+		return fmt.Errorf("--pty and --no-pty are mutually exclusive")
+	} else if interactive && !pty && !noPty {
+		sylog.Warningf("--interactive has no effect without --pty or --no-pty, the standard input is always relayed")
+	}
+	engineConfig.SetPty(pty)
+	engineConfig.SetNoPty(noPty)
+	engineConfig.SetInteractive(interactive)
+	return nil
+}
+
+// parseUmask returns the --umask octal mask.
+func parseUmask(spec string) (int, error) {
+	mask, err := strconv.ParseUint(spec, 8, 32)
+	if err != nil || mask > 0777 {
+		return 0, fmt.Errorf("%q must be an octal mask such as 0027", spec)
+	}
+	return int(mask), nil
+}
+
+// parseTimeout returns the deadline and the grace period of the --timeout
+// spec '<duration>[:<grace>]', durations without unit are in seconds.
+func parseTimeout(spec string) (timeout, grace time.Duration, err error) {
+	parse := func(s string) (time.Duration, error) {
+		if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+			return time.Duration(n) * time.Second, nil
+		}
+		d, err := time.ParseDuration(s)
+		if err == nil && d < 0 {
+			err = fmt.Errorf("negative duration %s", s)
+		}
+		return d, err
+	}
+
+	splitted := strings.SplitN(spec, ":", 2)
+	if timeout, err = parse(splitted[0]); err != nil {
+		return 0, 0, err
+	} else if timeout == 0 {
+		return 0, 0, fmt.Errorf("timeout must be greater than 0")
+	}
+	grace = singularityConfig.DefaultTimeoutGrace
+	if len(splitted) == 2 {
+		if grace, err = parse(splitted[1]); err != nil {
+			return 0, 0, fmt.Errorf("grace period: %s", err)
+		}
+	}
+	return timeout, grace, nil
+}
+
+// joinNamespaces are the namespaces joined by --join by default.
+var joinNamespaces = []string{"net", "ipc", "uts", "pid"}
+
+// parseJoin parses the --join spec <pid>[:<namespaces>] and returns the
+// process ID and the list of namespaces to join.
+func parseJoin(spec string) (int, []string, error) {
+	splitted := strings.SplitN(spec, ":", 2)
+	pid, err := strconv.Atoi(splitted[0])
+	if err != nil || pid <= 1 {
+		return 0, nil, fmt.Errorf("invalid process ID %q", splitted[0])
+	}
+	if len(splitted) == 1 {
+		return pid, joinNamespaces, nil
+	}
+
+	var namespaces []string
+	for _, ns := range strings.Split(splitted[1], ",") {
+		ns = strings.TrimSpace(ns)
+		known := false
+		for _, n := range joinNamespaces {
+			if ns == n {
+				known = true
+			}
+		}
+		if !known {
+			return 0, nil, fmt.Errorf("unsupported namespace %q, supported namespaces are %s", ns, strings.Join(joinNamespaces, ", "))
+		}
+		namespaces = append(namespaces, ns)
+	}
+	return pid, namespaces, nil
+}
+
+// actionTmpDir returns the directory of the temporary files of the
+// container, the default temporary directory if empty.
+func actionTmpDir() string {
 	// keep compatibility with v2
 	tmpdir := os.Getenv("SINGULARITY_TMPDIR")
 	if tmpdir == "" {
@@ -80,20 +304,75 @@ func convertImage(filename string, unsquashfsPath string) (string, error) {
 			tmpdir = os.Getenv("SINGULARITY_CACHEDIR")
 		}
 	}
+	return tmpdir
+}
 
-	// create temporary sandbox
-	dir, err := ioutil.TempDir(tmpdir, "rootfs-")
+// commitDir checks that the changes of the writable tmpfs can be saved
+// to the squashfs overlay image path and returns its absolute path along
+// with the temporary directory holding the writable tmpfs layers, it
+// replaces the session tmpfs so the layers remain on the host once the
+// container exits.
+func commitDir(path string) (string, string, error) {
+	path, err := filepath.Abs(path)
 	if err != nil {
-		return "", fmt.Errorf("could not create temporary sandbox: %s", err)
+		return "", "", fmt.Errorf("while getting absolute path of %s: %s", path, err)
+	}
+	if _, err := os.Lstat(path); err == nil {
+		return "", "", fmt.Errorf("%s already exists", path)
+	}
+	if !fs.IsDir(filepath.Dir(path)) {
+		return "", "", fmt.Errorf("directory %s doesn't exist", filepath.Dir(path))
+	}
+	if _, err := squashfs.GetPath(); err != nil {
+		return "", "", fmt.Errorf("mksquashfs is required to save the writable tmpfs: %s", err)
 	}
 
-	// extract root filesystem
-	if err := s.ExtractAll(reader, dir); err != nil {
-		os.RemoveAll(dir)
-		return "", fmt.Errorf("root filesystem extraction failed: %s", err)
+	dir, err := ioutil.TempDir(actionTmpDir(), singularityConfig.CommitDirPrefix)
+	if err != nil {
+		return "", "", fmt.Errorf("could not create temporary directory: %s", err)
+	}
+	for _, d := range []string{"upper", "work"} {
+		if err := os.Mkdir(filepath.Join(dir, d), 0755); err != nil {
+			os.RemoveAll(dir)
+			return "", "", fmt.Errorf("could not create temporary directory: %s", err)
+		}
 	}
+	return path, dir, nil
+}
 
-	return dir, err
+// mountErofsImage mounts the erofs root filesystem of the image file with
+// erofsfuse when the kernel can't mount it, in a user namespace or without
+// kernel erofs support, and returns the mount point used as a sandbox. It
+// returns an empty string for the other images mounted by the runtime.
+func mountErofsImage(filename string, userns, useSuid bool, conf *singularityconf.File) (string, error) {
+	if !fs.IsFile(filename) {
+		return "", nil
+	}
+	img, err := imgutil.Init(filename, false)
+	if err != nil {
+		// reported by the runtime
+		return "", nil
+	}
+	defer img.File.Close()
+
+	part, err := img.GetRootFsPartition()
+	if err != nil || part.Type != imgutil.EROFS {
+		return "", nil
+	}
+	if !userns && erofs.KernelSupport() {
+		return "", nil
+	}
+
+	if os.Getuid() != 0 && !conf.AllowContainerErofs {
+		return "", fmt.Errorf("configuration disallows users from running erofs based containers")
+	}
+	// the setuid starter can't access the FUSE mount point without the
+	// allow_root option
+	if useSuid && !fuse.UserAllowOther() {
+		return "", fmt.Errorf("kernel doesn't support erofs and erofsfuse requires 'user_allow_other' in /etc/fuse.conf with setuid workflow, use --userns instead")
+	}
+	sylog.Verbosef("Mounting erofs root filesystem of %s with erofsfuse", filename)
+	return erofs.MountFuse(filename, part.Offset, tmpDir, useSuid)
 }
 
 // checkHidepid checks if hidepid is set on /proc mount point, when this
@@ -121,6 +400,11 @@ func hidepidProc() bool {
 func execStarter(cobraCmd *cobra.Command, image string, args []string, name string) {
 	var err error
 
+	// the span is ended by sylog.Flush when starter replaces
+	// this process or by the instance start completion
+	span := sylog.StartSpan("exec")
+	span.SetAttribute("image", image)
+
 	targetUID := 0
 	targetGID := make([]int, 0)
 
@@ -203,6 +487,7 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		UserNamespace = file.UserNs
 		generator.AddProcessEnv("SINGULARITY_CONTAINER", file.Image)
 		generator.AddProcessEnv("SINGULARITY_NAME", filepath.Base(file.Image))
+		sylog.SetContainerName(instanceName)
 		engineConfig.SetImage(image)
 		engineConfig.SetInstanceJoin(true)
 	} else {
@@ -213,6 +498,43 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 			sylog.Fatalf("Failed to determine image absolute path for %s: %s", image, err)
 		}
 		engineConfig.SetImage(abspath)
+		engineConfig.SetImageURI(imageURI)
+		engineConfig.SetVerifyImage(VerifyImage)
+		if name != "" {
+			sylog.SetContainerName(name)
+		} else {
+			sylog.SetContainerName(filepath.Base(abspath))
+		}
+	}
+
+	// the user of the container is resolved from the image, the fakeroot
+	// user namespace maps the IDs of the subordinate ranges
+	var userHome string
+	if UserSpec != "" {
+		if engineConfig.GetInstanceJoin() {
+			sylog.Fatalf("--user is not supported when joining an instance")
+		}
+		if uidParam != "" || gidParam != "" {
+			sylog.Fatalf("--user is mutually exclusive with the uid and gid security features")
+		}
+		if !isPrivileged && !IsFakeroot {
+			sylog.Fatalf("--user requires root privileges or --fakeroot")
+		}
+		u, err := containerUser(engineConfig.GetImage(), UserSpec)
+		if err != nil {
+			sylog.Fatalf("While resolving user %s: %v", UserSpec, err)
+		}
+		sylog.Debugf("Running as user %s, UID %d and GIDs %v", UserSpec, u.UID, u.GIDs)
+		targetUID = u.UID
+		targetGID = u.GIDs
+		if !IsFakeroot {
+			uid = uint32(targetUID)
+			gid = uint32(targetGID[0])
+		}
+		engineConfig.SetTargetUID(targetUID)
+		engineConfig.SetTargetGID(targetGID)
+		engineConfig.SetContainerUser(true)
+		userHome = u.Home
 	}
 
 	// privileged installation by default
@@ -254,11 +576,31 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		}
 	}
 
+	// the setuid starter can't access the FUSE mount of a lazy image
+	// without the allow_root option
+	if lazyImage && useSuid && !fuse.UserAllowOther() {
+		sylog.Fatalf("--lazy requires 'user_allow_other' in /etc/fuse.conf with setuid workflow, use --userns instead")
+	}
+
 	var libs, bins, ipcs []string
 	var gpuConfFile, gpuPlatform string
 	userPath := os.Getenv("USER_PATH")
 
-	if !NoNvidia && (Nvidia || engineConfig.File.AlwaysUseNv) {
+	if !NoNvidia && NvCCLI {
+		// nvidia-container-cli sets up the GPUs selected by the NVIDIA_*
+		// environment variables, it needs a writable root filesystem
+		// to create the mount points of the driver files
+		if useSuid && !IsFakeroot {
+			sylog.Fatalf("--nvccli is not supported with the setuid workflow, use --fakeroot or --userns")
+		}
+		Nvidia = true
+		engineConfig.SetNvCCLI(true)
+		engineConfig.SetNvCCLIEnv(gpu.NvCCLIEnv(os.Environ()))
+		if !IsWritable && !IsWritableTmpfs {
+			sylog.Verbosef("Enabling --writable-tmpfs for --nvccli")
+			IsWritableTmpfs = true
+		}
+	} else if !NoNvidia && (Nvidia || engineConfig.File.AlwaysUseNv) {
 		gpuPlatform = "nv"
 		gpuConfFile = filepath.Join(buildcfg.SINGULARITY_CONFDIR, "nvliblist.conf")
 
@@ -272,6 +614,10 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		ipcs = gpu.NvidiaIpcsPath(userPath)
 		libs, bins, err = gpu.NvidiaPaths(gpuConfFile, userPath)
 
+		// only the GPUs and MIG instances selected by
+		// NVIDIA_VISIBLE_DEVICES are bound into container
+		engineConfig.SetNvEnv(gpu.NvidiaEnv(os.Environ()))
+
 	} else if !NoRocm && (Rocm || engineConfig.File.AlwaysUseRocm) { // Mount rocm GPU
 		gpuPlatform = "rocm"
 		gpuConfFile = filepath.Join(buildcfg.SINGULARITY_CONFDIR, "rocmliblist.conf")
@@ -283,9 +629,13 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		}
 
 		libs, bins, err = gpu.RocmPaths(gpuConfFile, userPath)
+
+		// only the GPUs selected by ROCR_VISIBLE_DEVICES and
+		// HIP_VISIBLE_DEVICES are bound into container
+		engineConfig.SetRocmEnv(gpu.RocmEnv(os.Environ()))
 	}
 
-	if Nvidia || Rocm {
+	if (Nvidia && !NvCCLI) || Rocm {
 		if err != nil {
 			sylog.Warningf("Unable to capture %s bind points: %v", gpuPlatform, err)
 		} else {
@@ -321,7 +671,11 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		sylog.Debugf("Checking for encrypted system partition")
 		img, err := imgutil.Init(engineConfig.GetImage(), false)
 		if err != nil {
-			sylog.Fatalf("could not open image %s: %s", engineConfig.GetImage(), err)
+			code := sylog.ImageInvalid
+			if _, serr := os.Stat(engineConfig.GetImage()); os.IsNotExist(serr) {
+				code = sylog.ImageNotFound
+			}
+			sylog.Fatalf("could not open image %s: %s", engineConfig.GetImage(), sylog.WithCode(code, err))
 		}
 
 		part, err := img.GetRootFsPartition()
@@ -347,6 +701,17 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 			engineConfig.SetEncryptionKey(plaintextKey)
 		}
 
+		// ensure we have the passphrase of the encrypted overlays
+		if hasEncryptedOverlay(img, OverlayPath) {
+			sylog.Debugf("Encrypted overlay detected")
+
+			passphrase, err := overlayPassphrase(cobraCmd.Context(), OverlayKey)
+			if err != nil {
+				sylog.Fatalf("Cannot load passphrase of the encrypted overlay: %v", err)
+			}
+			engineConfig.SetOverlayEncryptionKey(passphrase)
+		}
+
 		// don't defer this call as in all cases it won't be
 		// called before execing starter, so it would leak the
 		// image file descriptor to the container process
@@ -357,6 +722,14 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	if err != nil {
 		sylog.Fatalf("while parsing bind path: %s", err)
 	}
+	setImageBinds(binds)
+	if len(SifParts) > 0 && !engineConfig.GetInstanceJoin() {
+		partBinds, err := sifPartBinds(engineConfig.GetImage(), SifParts)
+		if err != nil {
+			sylog.Fatalf("While setting --sif-part: %s", err)
+		}
+		binds = append(binds, partBinds...)
+	}
 	engineConfig.SetBindPath(binds)
 
 	if len(FuseMount) > 0 {
@@ -368,6 +741,15 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	}
 	engineConfig.SetNetwork(Network)
 	engineConfig.SetDNS(DNS)
+	engineConfig.SetDNSSearch(DNSSearch)
+	engineConfig.SetAddHosts(AddHosts)
+	for _, spec := range Publish {
+		pm, err := network.ParsePortMapping(spec)
+		if err != nil {
+			sylog.Fatalf("while parsing --publish %s: %s", spec, err)
+		}
+		NetworkArgs = append(NetworkArgs, "portmap="+pm.String())
+	}
 	engineConfig.SetNetworkArgs(NetworkArgs)
 	engineConfig.SetOverlayImage(OverlayPath)
 	engineConfig.SetWritableImage(IsWritable)
@@ -396,9 +778,64 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		generator.AddProcessEnv("SINGULARITY_SHELL", ShellPath)
 	}
 
-	checkPrivileges(CgroupsPath != "", "--apply-cgroups", func() {
+	limits, err := resourceLimits()
+	if err != nil {
+		sylog.Fatalf("While checking resource limits: %v", err)
+	}
+
+	devices, rules, err := deviceRules()
+	if err != nil {
+		sylog.Fatalf("While checking devices: %v", err)
+	}
+	engineConfig.SetDevices(devices)
+	if len(rules) > 0 {
+		if isPrivileged {
+			if limits == nil {
+				limits = &specs.LinuxResources{}
+			}
+			limits.Devices = rules
+		} else {
+			sylog.Warningf("Device cgroup rules require root privileges, access to other devices is not restricted")
+		}
+	}
+
+	// unprivileged users apply cgroups delegated by systemd with cgroups v2,
+	// without delegation the container runs without restriction
+	if (CgroupsPath != "" || limits != nil) && !isPrivileged {
+		if cgroups.CanDelegate() {
+			engineConfig.SetCgroupsPath(CgroupsPath)
+			engineConfig.SetCgroupsResources(limits)
+		} else {
+			sylog.Warningf("Resources restriction requires root privileges or a systemd user session with cgroups v2, ignoring --apply-cgroups, --memory, --cpus, --pids-limit and --blkio-weight")
+		}
+	} else {
 		engineConfig.SetCgroupsPath(CgroupsPath)
-	})
+		engineConfig.SetCgroupsResources(limits)
+	}
+
+	if CommitPath != "" && !engineConfig.GetInstanceJoin() {
+		if IsWritable {
+			sylog.Fatalf("--commit saves the writable tmpfs, it is mutually exclusive with --writable")
+		}
+		// overlay copy up runs with the credentials of the mounter
+		if useSuid && !IsFakeroot {
+			sylog.Fatalf("--commit is not supported with the setuid workflow, use --fakeroot or --userns")
+		}
+		path, dir, err := commitDir(CommitPath)
+		if err != nil {
+			sylog.Fatalf("while preparing --commit: %s", err)
+		}
+		// the directory is deleted by the engine once the changes
+		// are saved, remove it if a fatal error occurs before
+		sylog.RegisterExitHook(func() {
+			os.RemoveAll(dir)
+		})
+		engineConfig.SetCommitImage(path, dir)
+		if !IsWritableTmpfs {
+			sylog.Verbosef("Enabling --writable-tmpfs for --commit")
+			IsWritableTmpfs = true
+		}
+	}
 
 	if IsWritable && IsWritableTmpfs {
 		sylog.Warningf("Disabling --writable-tmpfs flag, mutually exclusive with --writable")
@@ -421,7 +858,7 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	}
 
 	// set home directory for the targeted UID if it exists on host system
-	if !homeFlag.Changed && targetUID != 0 {
+	if !homeFlag.Changed && targetUID != 0 && UserSpec == "" {
 		if targetUID > 500 {
 			if pwd, err := user.GetPwUID(uint32(targetUID)); err == nil {
 				sylog.Debugf("Target UID requested, set home directory to %s", pwd.Dir)
@@ -439,6 +876,17 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		}
 	}
 
+	// the home directory of a user of the container is its passwd entry,
+	// or / without entry, and isn't mounted from host
+	if !homeFlag.Changed && UserSpec != "" {
+		HomePath = "/"
+		if userHome != "" {
+			HomePath = userHome
+		}
+		sylog.Verbosef("Container user requested, home %s won't be mounted", HomePath)
+		engineConfig.SetNoHome(true)
+	}
+
 	if Hostname != "" {
 		UtsNamespace = true
 		engineConfig.SetHostname(Hostname)
@@ -476,6 +924,43 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		UserNamespace = true
 	}
 
+	if Umask != "" {
+		mask, err := parseUmask(Umask)
+		if err != nil {
+			sylog.Fatalf("Invalid --umask: %s", err)
+		}
+		engineConfig.SetUmask(mask)
+	}
+
+	// setgroups is denied in a user namespace, and the groups of the
+	// fakeroot user namespace aren't host groups
+	if len(Groups) > 0 || NoSupplementary {
+		if UserNamespace {
+			sylog.Fatalf("--groups and --no-supplementary-groups are not supported with --fakeroot or --userns")
+		}
+		gids, err := hostGroups(Groups)
+		if err != nil {
+			sylog.Fatalf("While checking groups: %v", err)
+		}
+		if NoSupplementary && os.Getuid() != 0 {
+			sylog.Fatalf("--no-supplementary-groups can only be used by root")
+		}
+		engineConfig.SetAddGroups(gids)
+		engineConfig.SetNoSupplementary(NoSupplementary)
+	}
+
+	if err := setTerminal(engineConfig, Pty, NoPty, Interactive); err != nil {
+		sylog.Fatalf("%s", err)
+	}
+
+	if Timeout != "" {
+		timeout, grace, err := parseTimeout(Timeout)
+		if err != nil {
+			sylog.Fatalf("Invalid --timeout: %s", err)
+		}
+		engineConfig.SetTimeout(timeout, grace)
+	}
+
 	/* if name submitted, run as instance */
 	if name != "" {
 		PidNamespace = true
@@ -516,6 +1001,54 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		procname = "Singularity runtime parent"
 	}
 
+	if strings.HasPrefix(Network, singularityConfig.ContainerNetworkPrefix) && !engineConfig.GetInstanceJoin() {
+		// the network namespace of the instance is joined in place
+		// of a new one
+		if IsFakeroot || UserNamespace {
+			sylog.Fatalf("--network %s is not supported with --fakeroot or --userns", Network)
+		}
+		if len(Publish) > 0 {
+			sylog.Fatalf("--publish can't be used with --network %s, the ports are published by the instance", Network)
+		}
+		NetNamespace = true
+	}
+
+	if JoinSpec != "" && !engineConfig.GetInstanceJoin() {
+		pid, namespaces, err := parseJoin(JoinSpec)
+		if err != nil {
+			sylog.Fatalf("While parsing --join: %s", err)
+		}
+		if IsFakeroot || UserNamespace {
+			sylog.Fatalf("--join is not supported with --fakeroot or --userns")
+		}
+		for _, ns := range namespaces {
+			switch ns {
+			case "net":
+				if strings.HasPrefix(Network, singularityConfig.ContainerNetworkPrefix) || len(Publish) > 0 {
+					sylog.Fatalf("--join with the net namespace can't be used with --network %s or --publish", Network)
+				}
+				NetNamespace = true
+			case "ipc":
+				IpcNamespace = true
+			case "uts":
+				if Hostname != "" {
+					sylog.Fatalf("--join with the uts namespace can't be used with --hostname")
+				}
+				UtsNamespace = true
+			case "pid":
+				// the container process isn't PID 1 of the
+				// joined namespace
+				PidNamespace = true
+				NoInit = true
+			}
+		}
+		engineConfig.SetJoin(pid, namespaces)
+	}
+
+	if len(Publish) > 0 && !NetNamespace {
+		sylog.Fatalf("--publish requires a network namespace, use it with --net")
+	}
+
 	if NetNamespace {
 		if IsFakeroot && Network != "none" {
 			engineConfig.SetNetwork("fakeroot")
@@ -588,10 +1121,52 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	// Copy and cache environment
 	environment := os.Environ()
 
-	// Clean environment
-	singularityEnv := env.SetContainerEnv(generator, environment, IsCleanEnv, engineConfig.GetHomeDest())
+	// Clean environment, by order of precedence the container environment
+	// is defined by --env, --env-file, SINGULARITYENV_ variables, the image
+	// %environment and the host environment, unless the host environment
+	// takes precedence over the image
+	if err := env.CheckPatterns(PreserveEnv); err != nil {
+		sylog.Fatalf("Invalid --preserve-env: %s", err)
+	}
+	if len(PreserveEnv) > 0 && !IsCleanEnv {
+		sylog.Warningf("--preserve-env has no effect without --cleanenv")
+	}
+	hostEnv := env.HostEnv{
+		Clean:    IsCleanEnv,
+		Preserve: PreserveEnv,
+	}
+	switch EnvPrecedence {
+	case "host":
+		hostEnv.OverrideImage = true
+	case "image":
+	default:
+		sylog.Fatalf("Invalid --env-precedence %q: must be host or image", EnvPrecedence)
+	}
+	singularityEnv := env.SetContainerEnv(generator, environment, hostEnv, engineConfig.GetHomeDest())
 	engineConfig.SetSingularityEnv(singularityEnv)
 
+	if Nvidia && !NvCCLI {
+		// CUDA uses the bound MIG instances selected by their UUID
+		nvEnv, err := gpu.NvidiaContainerEnv(engineConfig.GetNvEnv())
+		if err != nil {
+			sylog.Fatalf("While selecting nvidia GPUs: %s", err)
+		}
+		for _, e := range nvEnv {
+			kv := strings.SplitN(e, "=", 2)
+			generator.AddProcessEnv(kv[0], kv[1])
+		}
+	} else if Rocm {
+		// the bound GPUs are renumbered by ROCm in container
+		rocmEnv, err := gpu.RocmContainerEnv(engineConfig.GetRocmEnv())
+		if err != nil {
+			sylog.Fatalf("While selecting rocm GPUs: %s", err)
+		}
+		for _, e := range rocmEnv {
+			kv := strings.SplitN(e, "=", 2)
+			generator.AddProcessEnv(kv[0], kv[1])
+		}
+	}
+
 	if pwd, err := os.Getwd(); err == nil {
 		engineConfig.SetCwd(pwd)
 		if PwdPath != "" {
@@ -614,11 +1189,24 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 	}
 
 	generator.AddProcessEnv("SINGULARITY_APPNAME", AppName)
+	if TestReport != "" {
+		generator.AddProcessEnv("SINGULARITY_TEST_REPORT", TestReport)
+		generator.AddProcessEnv("SINGULARITY_TEST_REPORT_FORMAT", TestReportFormat)
+	}
+
+	// the kernel can't mount erofs root filesystems in a user namespace
+	// or without erofs support, erofsfuse mounts them instead
+	erofsDir, err := mountErofsImage(image, UserNamespace || insideUserNs, useSuid, engineConfig.File)
+	if err != nil {
+		sylog.Fatalf("While mounting erofs image %s: %s", image, err)
+	} else if erofsDir != "" {
+		engineConfig.SetImage(erofsDir)
+	}
 
 	// convert image file to sandbox if we are using user
 	// namespace or if we are currently running inside a
 	// user namespace
-	if (UserNamespace || insideUserNs) && fs.IsFile(image) {
+	if erofsDir == "" && (UserNamespace || insideUserNs) && fs.IsFile(image) {
 		convert := true
 
 		if engineConfig.File.ImageDriver != "" {
@@ -654,6 +1242,11 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 			if err != nil {
 				sylog.Fatalf("while extracting %s: %s", image, err)
 			}
+			// the sandbox is deleted by the engine once the container
+			// terminates, remove it if a fatal error occurs before
+			sylog.RegisterExitHook(func() {
+				os.RemoveAll(dir)
+			})
 			engineConfig.SetImage(dir)
 			engineConfig.SetDeleteImage(true)
 			generator.AddProcessEnv("SINGULARITY_CONTAINER", dir)
@@ -714,6 +1307,7 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 			starter.WithStderr(stderr),
 			starter.LoadOverlayModule(loadOverlay),
 		)
+		span.End()
 
 		if sylog.GetLevel() != 0 {
 			// starter can exit a bit before all errors has been reported
@@ -748,3 +1342,291 @@ func execStarter(cobraCmd *cobra.Command, image string, args []string, name stri
 		sylog.Fatalf("%s", err)
 	}
 }
+
+// hasEncryptedOverlay returns true if the image img or one of the overlay
+// images holds an encrypted overlay.
+func hasEncryptedOverlay(img *imgutil.Image, overlays []string) bool {
+	encrypted := func(img *imgutil.Image) bool {
+		for _, p := range img.Partitions {
+			if p.Type == imgutil.ENCRYPTEXT3 && p.AllowedUsage&imgutil.OverlayUsage != 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	if encrypted(img) {
+		return true
+	}
+	for _, overlay := range overlays {
+		path := strings.SplitN(overlay, ":", 2)[0]
+		img, err := imgutil.Init(path, false)
+		if err != nil {
+			// reported by the engine loading the overlay images
+			continue
+		}
+		found := encrypted(img)
+		img.File.Close()
+		if found {
+			return true
+		}
+	}
+	return false
+}
+
+// overlayPassphrase returns the passphrase unlocking the encrypted
+// overlays from the --overlay-key source, the user is prompted for it by
+// default.
+func overlayPassphrase(ctx context.Context, source string) ([]byte, error) {
+	if source == "" {
+		source = "passphrase"
+	}
+	k, err := encryptionKeyProvider(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	passphrase := k.Passphrase()
+	if passphrase == nil {
+		return nil, errors.New("encrypted overlays are only unlocked by passphrases")
+	}
+	return passphrase, nil
+}
+
+// resourceLimits returns the cgroups resources restrictions of the --memory,
+// --cpus, --pids-limit and --blkio-weight flags, it returns nil if there is
+// no limit.
+func resourceLimits() (*specs.LinuxResources, error) {
+	var memory int64
+	var cpus float64
+	var err error
+
+	if MemoryLimit != "" {
+		memory, err = units.RAMInBytes(MemoryLimit)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --memory value: %v", err)
+		} else if memory <= 0 {
+			return nil, fmt.Errorf("--memory must be greater than zero")
+		}
+	}
+	if CPUsLimit != "" {
+		cpus, err = strconv.ParseFloat(CPUsLimit, 64)
+		if err != nil || cpus <= 0 {
+			return nil, fmt.Errorf("--cpus must be a number greater than zero")
+		}
+	}
+
+	spec := specs.LinuxResources{}
+	if limits := build.Limits(memory, cpus); limits != nil {
+		if spec, err = limits.Spec(); err != nil {
+			return nil, err
+		}
+	}
+	if PidsLimit != "" {
+		pids, err := strconv.ParseInt(PidsLimit, 10, 64)
+		if err != nil || pids <= 0 {
+			return nil, fmt.Errorf("--pids-limit must be a number greater than zero")
+		}
+		spec.Pids = &specs.LinuxPids{Limit: pids}
+	}
+	if BlkioWeight != "" {
+		w, err := strconv.ParseUint(BlkioWeight, 10, 16)
+		if err != nil || w < 10 || w > 1000 {
+			return nil, fmt.Errorf("--blkio-weight must be a number between 10 and 1000")
+		}
+		weight := uint16(w)
+		spec.BlockIO = &specs.LinuxBlockIO{Weight: &weight}
+	}
+
+	if spec.Memory == nil && spec.CPU == nil && spec.Pids == nil && spec.BlockIO == nil {
+		return nil, nil
+	}
+	return &spec, nil
+}
+
+// defaultDeviceRules are the device cgroup rules of the --device flags
+// allowing the devices of a minimal /dev, the access to other devices is
+// denied.
+var defaultDeviceRules = []specs.LinuxDeviceCgroup{
+	{Allow: false, Access: "rwm"},
+	// null, zero, full, random and urandom
+	deviceCgroup("c", 1, 3, "rwm"),
+	deviceCgroup("c", 1, 5, "rwm"),
+	deviceCgroup("c", 1, 7, "rwm"),
+	deviceCgroup("c", 1, 8, "rwm"),
+	deviceCgroup("c", 1, 9, "rwm"),
+	// tty, console, ptmx and pseudo terminals
+	deviceCgroup("c", 5, 0, "rwm"),
+	deviceCgroup("c", 5, 1, "rwm"),
+	deviceCgroup("c", 5, 2, "rwm"),
+	deviceCgroup("c", 136, -1, "rwm"),
+}
+
+// deviceCgroup returns a device cgroup rule allowing access to the device
+// major:minor of type typ, a negative number matches all numbers.
+func deviceCgroup(typ string, major, minor int64, access string) specs.LinuxDeviceCgroup {
+	d := specs.LinuxDeviceCgroup{Allow: true, Type: typ, Access: access}
+	if major >= 0 {
+		d.Major = &major
+	}
+	if minor >= 0 {
+		d.Minor = &minor
+	}
+	return d
+}
+
+// deviceRules returns the host devices of the --device flags and their
+// device cgroup rules, which also allow the devices of --nv, --rocm and
+// --fusemount.
+func deviceRules() ([]string, []specs.LinuxDeviceCgroup, error) {
+	if len(Devices) == 0 {
+		return nil, nil, nil
+	}
+
+	rules := append([]specs.LinuxDeviceCgroup{}, defaultDeviceRules...)
+	paths := make([]string, 0, len(Devices))
+	seen := make(map[string]bool)
+
+	addRule := func(path, access string) error {
+		var st unix.Stat_t
+		if err := unix.Stat(path, &st); err != nil {
+			return fmt.Errorf("while getting %s device: %s", path, err)
+		}
+		typ := ""
+		switch st.Mode & unix.S_IFMT {
+		case unix.S_IFCHR:
+			typ = "c"
+		case unix.S_IFBLK:
+			typ = "b"
+		default:
+			return fmt.Errorf("%s is not a device", path)
+		}
+		major, minor := unix.Major(uint64(st.Rdev)), unix.Minor(uint64(st.Rdev))
+		rules = append(rules, deviceCgroup(typ, int64(major), int64(minor), access))
+		return nil
+	}
+
+	for _, d := range Devices {
+		path, access := d, "rwm"
+		if i := strings.LastIndex(d, ":"); i >= 0 {
+			path, access = d[:i], d[i+1:]
+			if access == "" || strings.Trim(access, "rwm") != "" {
+				return nil, nil, fmt.Errorf("invalid permissions %q of device %s, must be a combination of r, w and m", access, path)
+			}
+		}
+		// devices are bound at their location in the host /dev
+		resolved, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("while resolving device %s: %s", path, err)
+		}
+		if !strings.HasPrefix(resolved, "/dev/") {
+			return nil, nil, fmt.Errorf("device %s is not in /dev", path)
+		}
+		if err := addRule(resolved, access); err != nil {
+			return nil, nil, err
+		}
+		if !seen[resolved] {
+			seen[resolved] = true
+			paths = append(paths, resolved)
+		}
+	}
+
+	var others []string
+	if Nvidia {
+		others, _ = gpu.NvidiaVisibleDevices(gpu.NvidiaEnv(os.Environ()))
+	} else if Rocm {
+		others, _ = gpu.RocmVisibleDevices(gpu.RocmEnv(os.Environ()))
+	}
+	if len(FuseMount) > 0 {
+		others = append(others, "/dev/fuse")
+	}
+	for _, d := range others {
+		if err := addRule(d, "rwm"); err != nil {
+			sylog.Debugf("Not allowing device %s: %s", d, err)
+		}
+	}
+
+	return paths, rules, nil
+}
+
+// setImageBinds turns the binds of SIF, squashfs, ext3 and erofs image
+// files into image binds of the image file system, the file option binds
+// the image file itself.
+func setImageBinds(binds []singularityConfig.BindPath) {
+	for i := range binds {
+		b := &binds[i]
+		if b.ImageSrc() != "" || b.ID() != "" || b.ImageFile() {
+			continue
+		}
+		if fi, err := os.Stat(b.Source); err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		img, err := imgutil.Init(b.Source, false)
+		if err != nil {
+			continue
+		}
+		img.File.Close()
+
+		switch img.Type {
+		case imgutil.SIF, imgutil.SQUASHFS, imgutil.EXT3, imgutil.EROFS:
+		default:
+			continue
+		}
+		sylog.Verbosef("Binding file system of image %s to %s", b.Source, b.Destination)
+		if b.Options == nil {
+			b.Options = make(map[string]*singularityConfig.BindOption)
+		}
+		b.Options["image-src"] = &singularityConfig.BindOption{Value: "/"}
+	}
+}
+
+// sifPartBinds returns the image binds of the data partitions of the SIF
+// image path selected by specs of the form <id|name>:<dest>[:ro].
+func sifPartBinds(path string, specs []string) ([]singularityConfig.BindPath, error) {
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a SIF image: %s", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	var binds []singularityConfig.BindPath
+	for _, spec := range specs {
+		fields := strings.Split(spec, ":")
+		if len(fields) < 2 || len(fields) > 3 || fields[0] == "" || fields[1] == "" {
+			return nil, fmt.Errorf("partition spec %q must be of the form <id|name>:<dest>[:ro]", spec)
+		}
+
+		var part *sif.Descriptor
+		for i, d := range fimg.DescrArr {
+			if !d.Used || d.Datatype != sif.DataPartition {
+				continue
+			}
+			if fmt.Sprint(d.ID) == fields[0] || d.GetName() == fields[0] {
+				part = &fimg.DescrArr[i]
+				break
+			}
+		}
+		if part == nil {
+			return nil, fmt.Errorf("partition %s not found in %s", fields[0], path)
+		}
+		if ptype, err := part.GetPartType(); err != nil || ptype != sif.PartData {
+			return nil, fmt.Errorf("partition %s of %s is not a data partition", fields[0], path)
+		}
+
+		options := map[string]*singularityConfig.BindOption{
+			"id":        {Value: fmt.Sprint(part.ID)},
+			"image-src": {Value: "/"},
+		}
+		if len(fields) == 3 {
+			if fields[2] != "ro" {
+				return nil, fmt.Errorf("unknown option %q of partition spec %q", fields[2], spec)
+			}
+			options["ro"] = &singularityConfig.BindOption{}
+		}
+		binds = append(binds, singularityConfig.BindPath{
+			Source:      path,
+			Destination: fields[1],
+			Options:     options,
+		})
+	}
+	return binds, nil
+}
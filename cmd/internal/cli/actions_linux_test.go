@@ -0,0 +1,424 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engine/singularity/config"
+)
+
+const testSquash = "../../../pkg/image/testdata/squashfs.v4"
+
+// copyTestSquash copies the test squashfs image to path.
+func copyTestSquash(t *testing.T, path string) {
+	b, err := ioutil.ReadFile(testSquash)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", testSquash, err)
+	}
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatalf("failed to create %s: %s", path, err)
+	}
+}
+
+// createTestSIF creates the SIF image path with the test squashfs image
+// as root filesystem partition, with ID 1 and name rootfs, and as data
+// partition, with ID 2 and name data, if data is set.
+func createTestSIF(t *testing.T, path string, data bool) {
+	squash := path + ".sqfs"
+	copyTestSquash(t, squash)
+	defer os.Remove(squash)
+
+	parts := []sif.Parttype{sif.PartPrimSys}
+	if data {
+		parts = append(parts, sif.PartData)
+	}
+
+	var inputs []sif.DescriptorInput
+	for _, part := range parts {
+		fp, err := os.Open(squash)
+		if err != nil {
+			t.Fatalf("failed to open %s: %s", squash, err)
+		}
+		defer fp.Close()
+		fi, err := fp.Stat()
+		if err != nil {
+			t.Fatalf("failed to stat %s: %s", squash, err)
+		}
+
+		input := sif.DescriptorInput{
+			Datatype: sif.DataPartition,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     sif.DescrUnusedLink,
+			Size:     fi.Size(),
+			Fname:    "rootfs",
+			Fp:       fp,
+		}
+		if part == sif.PartData {
+			input.Fname = "data"
+		}
+		if err := input.SetPartExtra(sif.FsSquash, part, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, input)
+	}
+
+	if _, err := sif.CreateContainer(sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: inputs,
+	}); err != nil {
+		t.Fatalf("while creating SIF image: %s", err)
+	}
+}
+
+func TestParseJoin(t *testing.T) {
+	tests := []struct {
+		name       string
+		spec       string
+		pid        int
+		namespaces []string
+		wantErr    bool
+	}{
+		{name: "Default", spec: "1234", pid: 1234, namespaces: joinNamespaces},
+		{name: "Namespaces", spec: "1234:net,ipc", pid: 1234, namespaces: []string{"net", "ipc"}},
+		{name: "Spaces", spec: "1234:uts, pid", pid: 1234, namespaces: []string{"uts", "pid"}},
+		{name: "Init", spec: "1", wantErr: true},
+		{name: "Negative", spec: "-10", wantErr: true},
+		{name: "NoPid", spec: ":net", wantErr: true},
+		{name: "BadPid", spec: "init:net", wantErr: true},
+		{name: "MountNamespace", spec: "1234:mnt", wantErr: true},
+		{name: "UserNamespace", spec: "1234:net,user", wantErr: true},
+		{name: "EmptyNamespace", spec: "1234:", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pid, namespaces, err := parseJoin(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success for %q", tt.spec)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error for %q: %s", tt.spec, err)
+			}
+			if pid != tt.pid {
+				t.Errorf("got process ID %d instead of %d", pid, tt.pid)
+			}
+			if !reflect.DeepEqual(namespaces, tt.namespaces) {
+				t.Errorf("got namespaces %v instead of %v", namespaces, tt.namespaces)
+			}
+		})
+	}
+}
+
+func TestParseUmask(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		mask    int
+		wantErr bool
+	}{
+		{name: "Default", spec: "0022", mask: 0022},
+		{name: "NoLeadingZero", spec: "27", mask: 0027},
+		{name: "Zero", spec: "0", mask: 0},
+		{name: "All", spec: "0777", mask: 0777},
+		{name: "TooLarge", spec: "1000", wantErr: true},
+		{name: "NotOctal", spec: "0089", wantErr: true},
+		{name: "Negative", spec: "-022", wantErr: true},
+		{name: "Symbolic", spec: "u=rwx,g=rx", wantErr: true},
+		{name: "Empty", spec: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mask, err := parseUmask(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success for %q", tt.spec)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error for %q: %s", tt.spec, err)
+			}
+			if mask != tt.mask {
+				t.Errorf("got umask %#o instead of %#o", mask, tt.mask)
+			}
+		})
+	}
+}
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		timeout time.Duration
+		grace   time.Duration
+		wantErr bool
+	}{
+		{name: "Duration", spec: "1h30m", timeout: 90 * time.Minute, grace: singularityConfig.DefaultTimeoutGrace},
+		{name: "Seconds", spec: "90", timeout: 90 * time.Second, grace: singularityConfig.DefaultTimeoutGrace},
+		{name: "Grace", spec: "10m:30s", timeout: 10 * time.Minute, grace: 30 * time.Second},
+		{name: "GraceSeconds", spec: "600:5", timeout: 10 * time.Minute, grace: 5 * time.Second},
+		{name: "NoGrace", spec: "10m:0", timeout: 10 * time.Minute},
+		{name: "Zero", spec: "0", wantErr: true},
+		{name: "ZeroDuration", spec: "0s", wantErr: true},
+		{name: "Negative", spec: "-10s", wantErr: true},
+		{name: "NegativeSeconds", spec: "-10", wantErr: true},
+		{name: "NegativeGrace", spec: "10m:-5s", wantErr: true},
+		{name: "Garbage", spec: "forever", wantErr: true},
+		{name: "GarbageGrace", spec: "10m:soon", wantErr: true},
+		{name: "Empty", spec: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			timeout, grace, err := parseTimeout(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success for %q", tt.spec)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error for %q: %s", tt.spec, err)
+			}
+			if timeout != tt.timeout || grace != tt.grace {
+				t.Errorf("got %s:%s instead of %s:%s", timeout, grace, tt.timeout, tt.grace)
+			}
+		})
+	}
+}
+
+func TestSetTerminal(t *testing.T) {
+	tests := []struct {
+		name        string
+		pty         bool
+		noPty       bool
+		interactive bool
+		wantErr     bool
+	}{
+		{name: "Default"},
+		{name: "Pty", pty: true},
+		{name: "PtyInteractive", pty: true, interactive: true},
+		{name: "NoPty", noPty: true},
+		{name: "NoPtyInteractive", noPty: true, interactive: true},
+		{name: "Interactive", interactive: true},
+		{name: "Conflict", pty: true, noPty: true, wantErr: true},
+		{name: "ConflictInteractive", pty: true, noPty: true, interactive: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engineConfig := singularityConfig.NewConfig()
+			err := setTerminal(engineConfig, tt.pty, tt.noPty, tt.interactive)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if engineConfig.GetPty() != tt.pty || engineConfig.GetNoPty() != tt.noPty || engineConfig.GetInteractive() != tt.interactive {
+				t.Errorf("unexpected terminal configuration")
+			}
+		})
+	}
+}
+
+func TestSifPartBinds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sif-part-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	image := filepath.Join(dir, "image.sif")
+	createTestSIF(t, image, true)
+	squash := filepath.Join(dir, "image.sqfs")
+	copyTestSquash(t, squash)
+
+	bind := func(dest string, ro bool) singularityConfig.BindPath {
+		b := singularityConfig.BindPath{
+			Source:      image,
+			Destination: dest,
+			Options: map[string]*singularityConfig.BindOption{
+				"id":        {Value: "2"},
+				"image-src": {Value: "/"},
+			},
+		}
+		if ro {
+			b.Options["ro"] = &singularityConfig.BindOption{}
+		}
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		image    string
+		specs    []string
+		expected []singularityConfig.BindPath
+		wantErr  bool
+	}{
+		{
+			name:     "ID",
+			image:    image,
+			specs:    []string{"2:/data"},
+			expected: []singularityConfig.BindPath{bind("/data", false)},
+		},
+		{
+			name:     "Name",
+			image:    image,
+			specs:    []string{"data:/data:ro"},
+			expected: []singularityConfig.BindPath{bind("/data", true)},
+		},
+		{
+			name:     "Multiple",
+			image:    image,
+			specs:    []string{"data:/data", "2:/other:ro"},
+			expected: []singularityConfig.BindPath{bind("/data", false), bind("/other", true)},
+		},
+		{
+			name:    "UnknownID",
+			image:   image,
+			specs:   []string{"3:/data"},
+			wantErr: true,
+		},
+		{
+			name:    "UnknownName",
+			image:   image,
+			specs:   []string{"other:/data"},
+			wantErr: true,
+		},
+		{
+			name:    "RootFs",
+			image:   image,
+			specs:   []string{"rootfs:/data"},
+			wantErr: true,
+		},
+		{
+			name:    "NoDestination",
+			image:   image,
+			specs:   []string{"data"},
+			wantErr: true,
+		},
+		{
+			name:    "UnknownOption",
+			image:   image,
+			specs:   []string{"data:/data:rw"},
+			wantErr: true,
+		},
+		{
+			name:    "NotSIF",
+			image:   squash,
+			specs:   []string{"data:/data"},
+			wantErr: true,
+		},
+		{
+			name:    "NoImage",
+			image:   filepath.Join(dir, "none.sif"),
+			specs:   []string{"data:/data"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binds, err := sifPartBinds(tt.image, tt.specs)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success for %v", tt.specs)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error for %v: %s", tt.specs, err)
+			}
+			if !reflect.DeepEqual(binds, tt.expected) {
+				t.Errorf("got binds %+v instead of %+v", binds, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSetImageBinds(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-binds-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	image := filepath.Join(dir, "image.sif")
+	createTestSIF(t, image, false)
+	squash := filepath.Join(dir, "image.sqfs")
+	copyTestSquash(t, squash)
+	text := filepath.Join(dir, "text")
+	if err := ioutil.WriteFile(text, []byte("not an image"), 0644); err != nil {
+		t.Fatalf("failed to create %s: %s", text, err)
+	}
+
+	tests := []struct {
+		name     string
+		bind     string
+		options  []string
+		imageSrc string
+		wantErr  bool
+	}{
+		{name: "SIF", bind: image + ":/data", options: []string{"image-src"}, imageSrc: "/"},
+		{name: "Squashfs", bind: squash + ":/data", options: []string{"image-src"}, imageSrc: "/"},
+		{name: "ReadOnly", bind: squash + ":/data:ro", options: []string{"image-src", "ro"}, imageSrc: "/"},
+		{name: "File", bind: image + ":/data:file", options: []string{"file"}},
+		{name: "FileReadOnly", bind: image + ":/data:file,ro", options: []string{"file", "ro"}},
+		{name: "ImageSrc", bind: image + ":/data:image-src=/opt", options: []string{"image-src"}, imageSrc: "/opt"},
+		{name: "ID", bind: image + ":/data:id=1", options: []string{"id"}},
+		{name: "Directory", bind: dir + ":/data"},
+		{name: "NotImage", bind: text + ":/data"},
+		{name: "NoSource", bind: filepath.Join(dir, "none") + ":/data"},
+		{name: "UnknownOption", bind: image + ":/data:files", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binds, err := singularityConfig.ParseBindPath(tt.bind)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success for %q", tt.bind)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error for %q: %s", tt.bind, err)
+			} else if len(binds) != 1 {
+				t.Fatalf("got %d binds instead of 1", len(binds))
+			}
+
+			setImageBinds(binds)
+
+			b := binds[0]
+			if b.ImageSrc() != tt.imageSrc {
+				t.Errorf("got image-src %q instead of %q", b.ImageSrc(), tt.imageSrc)
+			}
+			var options []string
+			for o := range b.Options {
+				options = append(options, o)
+			}
+			sort.Strings(options)
+			if !reflect.DeepEqual(options, tt.options) {
+				t.Errorf("got options %v instead of %v", options, tt.options)
+			}
+		})
+	}
+}
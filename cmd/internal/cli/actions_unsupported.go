@@ -8,10 +8,17 @@
 package cli
 
 import (
+	"errors"
+
 	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/internal/pkg/cache"
 )
 
 // TODO: Let's stick this in another file so that that CLI is just CLI
 func execStarter(cobraCmd *cobra.Command, image string, args []string, name string) {
 	panic("starter is unsupported on this platform")
 }
+
+func mountLazyImage(imgCache *cache.Handle, pullFrom string) (string, error) {
+	return "", errors.New("lazy images are unsupported on this platform")
+}
@@ -6,38 +6,70 @@
 package cli
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 
 	ocitypes "github.com/containers/image/v5/types"
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/build/remotebuilder"
 	scs "github.com/sylabs/singularity/internal/pkg/remote"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
 	"github.com/sylabs/singularity/internal/pkg/util/interactive"
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/build/types/parser"
 	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/sylog"
 )
 
 var buildArgs struct {
-	sections   []string
-	arch       string
-	builderURL string
-	libraryURL string
-	detached   bool
-	encrypt    bool
-	fakeroot   bool
-	fixPerms   bool
-	isJSON     bool
-	noCleanUp  bool
-	noTest     bool
-	remote     bool
-	sandbox    bool
-	update     bool
+	sections         []string
+	buildArgs        []string
+	buildArgFile     string
+	arch             string
+	platform         string
+	sbom             string
+	testReport       string
+	binds            []string
+	encryptionKeys   []string
+	mounts           []string
+	cacheSections    bool
+	check            bool
+	network          string
+	outputFormat     string
+	memory           string
+	cpus             string
+	timeout          string
+	downloads        int
+	parallel         int
+	signKey          string
+	signKeyIdx       int
+	jsonFd           int
+	sign             bool
+	builderType      string
+	builderURL       string
+	libraryURL       string
+	compression      string
+	compressionLevel int
+	rootfsFormat     string
+	detached         bool
+	encrypt          bool
+	fakeroot         bool
+	fixPerms         bool
+	isJSON           bool
+	noBuildLog       bool
+	noCleanUp        bool
+	noTest           bool
+	remote           bool
+	sandbox          bool
+	update           bool
+	verity           bool
 }
 
 // -s|--sandbox
@@ -51,6 +83,16 @@ var buildSandboxFlag = cmdline.Flag{
 	EnvKeys:      []string{"SANDBOX"},
 }
 
+// --output-format
+var buildOutputFormatFlag = cmdline.Flag{
+	ID:           "buildOutputFormatFlag",
+	Value:        &buildArgs.outputFormat,
+	DefaultValue: "",
+	Name:         "output-format",
+	Usage:        "format of the built image (sif, sandbox, oci, docker-archive), the oci: and docker-archive: target prefixes also select a format",
+	EnvKeys:      []string{"BUILD_OUTPUT_FORMAT"},
+}
+
 // --section
 var buildSectionFlag = cmdline.Flag{
 	ID:           "buildSectionFlag",
@@ -61,14 +103,75 @@ var buildSectionFlag = cmdline.Flag{
 	EnvKeys:      []string{"SECTION"},
 }
 
+// --compression
+var buildCompressionFlag = cmdline.Flag{
+	ID:           "buildCompressionFlag",
+	Value:        &buildArgs.compression,
+	DefaultValue: "",
+	Name:         "compression",
+	Usage:        "squashfs compression algorithm of SIF images (gzip, zstd, lz4, xz, none), as <algorithm>[:<level>] or rootfs=<algorithm>[:<level>], overrides the definition file Compression header",
+	EnvKeys:      []string{"BUILD_COMPRESSION"},
+}
+
+// --compression-level
+var buildCompressionLevelFlag = cmdline.Flag{
+	ID:           "buildCompressionLevelFlag",
+	Value:        &buildArgs.compressionLevel,
+	DefaultValue: 0,
+	Name:         "compression-level",
+	Usage:        "squashfs compression level (1-9 for gzip, 1-22 for zstd), overrides the definition file CompressionLevel header",
+	EnvKeys:      []string{"BUILD_COMPRESSION_LEVEL"},
+}
+
+// --rootfs-format
+var buildRootfsFormatFlag = cmdline.Flag{
+	ID:           "buildRootfsFormatFlag",
+	Value:        &buildArgs.rootfsFormat,
+	DefaultValue: "squashfs",
+	Name:         "rootfs-format",
+	Usage:        "root filesystem format of SIF images (squashfs, erofs), erofs performs better with many small files and requires mkfs.erofs",
+	EnvKeys:      []string{"BUILD_ROOTFS_FORMAT"},
+}
+
+// --build-arg
+var buildArgFlag = cmdline.Flag{
+	ID:           "buildArgFlag",
+	Value:        &buildArgs.buildArgs,
+	DefaultValue: []string{},
+	Name:         "build-arg",
+	Usage:        "set the value of a definition file {{ variable }} with KEY=VALUE, may be specified multiple times",
+	Tag:          "<KEY=VALUE>",
+}
+
+// --build-arg-file
+var buildArgFileFlag = cmdline.Flag{
+	ID:           "buildArgFileFlag",
+	Value:        &buildArgs.buildArgFile,
+	DefaultValue: "",
+	Name:         "build-arg-file",
+	Usage:        "read definition file variable values from a file containing KEY=VALUE lines",
+	Tag:          "<path>",
+}
+
 // --json
 var buildJSONFlag = cmdline.Flag{
 	ID:           "buildJSONFlag",
 	Value:        &buildArgs.isJSON,
 	DefaultValue: false,
 	Name:         "json",
-	Usage:        "interpret build definition as JSON",
-	EnvKeys:      []string{"JSON"},
+	Usage:        "emit the build progress and result as JSON lines on stdout, the build output goes to stderr",
+	EnvKeys:      []string{"BUILD_JSON"},
+}
+
+// --json-fd
+var buildJSONFdFlag = cmdline.Flag{
+	ID:           "buildJSONFdFlag",
+	Value:        &buildArgs.jsonFd,
+	DefaultValue: -1,
+	Name:         "json-fd",
+	Usage:        "emit the JSON build progress and result on this file descriptor instead of stdout, implies --json",
+	Tag:          "<fd>",
+	EnvKeys:      []string{"BUILD_JSON_FD"},
 }
 
 // -u|--update
@@ -78,7 +181,7 @@ var buildUpdateFlag = cmdline.Flag{
 	DefaultValue: false,
 	Name:         "update",
 	ShortHand:    "u",
-	Usage:        "run definition over existing container (skips header)",
+	Usage:        "run definition over an existing sandbox or SIF image (skips header)",
 	EnvKeys:      []string{"UPDATE"},
 }
 
@@ -93,6 +196,26 @@ var buildNoTestFlag = cmdline.Flag{
 	EnvKeys:      []string{"NOTEST"},
 }
 
+// --no-build-log
+var buildNoBuildLogFlag = cmdline.Flag{
+	ID:           "buildNoBuildLogFlag",
+	Value:        &buildArgs.noBuildLog,
+	DefaultValue: false,
+	Name:         "no-build-log",
+	Usage:        "don't store the build output in the image, see inspect --build-log",
+	EnvKeys:      []string{"BUILD_NO_BUILD_LOG"},
+}
+
+// --check
+var buildCheckFlag = cmdline.Flag{
+	ID:           "buildCheckFlag",
+	Value:        &buildArgs.check,
+	DefaultValue: false,
+	Name:         "check",
+	Usage:        "check the %post and %runscript scripts before building, the build is aborted on syntax errors",
+	EnvKeys:      []string{"BUILD_CHECK"},
+}
+
 // -r|--remote
 var buildRemoteFlag = cmdline.Flag{
 	ID:           "buildRemoteFlag",
@@ -110,10 +233,173 @@ var buildArchFlag = cmdline.Flag{
 	Value:        &buildArgs.arch,
 	DefaultValue: runtime.GOARCH,
 	Name:         "arch",
-	Usage:        "architecture for remote build",
+	Usage:        "architecture of the image, %post and %test of foreign architectures run with qemu-user emulation",
 	EnvKeys:      []string{"BUILD_ARCH"},
 }
 
+// --platform
+var buildPlatformFlag = cmdline.Flag{
+	ID:           "buildPlatformFlag",
+	Value:        &buildArgs.platform,
+	DefaultValue: "",
+	Name:         "platform",
+	Usage:        "platform of the image selected from multi-platform OCI sources (e.g. linux/arm64/v8)",
+	Tag:          "<os/arch[/variant]>",
+	EnvKeys:      []string{"BUILD_PLATFORM"},
+}
+
+// -B|--bind
+var buildBindFlag = cmdline.Flag{
+	ID:           "buildBindFlag",
+	Value:        &buildArgs.binds,
+	DefaultValue: []string{},
+	Name:         "bind",
+	ShortHand:    "B",
+	Usage:        "a user-bind path specification mounted while running the %post section only, it has the format src[:dest[:opts]] with 'ro' or 'rw' opts. Multiple bind paths can be given by a comma separated list.",
+	Tag:          "<spec>",
+	EnvKeys:      []string{"BUILD_BIND"},
+	EnvHandler:   cmdline.EnvAppendValue,
+}
+
+// --mount
+var buildMountFlag = cmdline.Flag{
+	ID:           "buildMountFlag",
+	Value:        &buildArgs.mounts,
+	DefaultValue: []string{},
+	Name:         "mount",
+	Usage:        "a mount specification mounted while running the %post section only, it has the format type=bind,source=<src>[,destination=<dest>][,readonly]",
+	Tag:          "<spec>",
+	EnvKeys:      []string{"BUILD_MOUNT"},
+}
+
+// --cache-sections
+var buildCacheSectionsFlag = cmdline.Flag{
+	ID:           "buildCacheSectionsFlag",
+	Value:        &buildArgs.cacheSections,
+	DefaultValue: false,
+	Name:         "cache-sections",
+	Usage:        "cache the root filesystem once bootstrapped and once %post ran, they are reused if the bootstrap headers, %setup, %files and %post sections didn't change",
+	EnvKeys:      []string{"BUILD_CACHE_SECTIONS"},
+}
+
+// --network
+var buildNetworkFlag = cmdline.Flag{
+	ID:           "buildNetworkFlag",
+	Value:        &buildArgs.network,
+	DefaultValue: "",
+	Name:         "network",
+	Usage:        "network of the %post and %test sections: 'none' for loopback only, 'host' (default) or a comma separated list of CNI profiles",
+	Tag:          "<none|host|profiles>",
+	EnvKeys:      []string{"BUILD_NETWORK"},
+}
+
+// --memory
+var buildMemoryFlag = cmdline.Flag{
+	ID:           "buildMemoryFlag",
+	Value:        &buildArgs.memory,
+	DefaultValue: "",
+	Name:         "memory",
+	Usage:        "memory limit of the %post and %test sections, of the whole build with --fakeroot (e.g. 4G)",
+	Tag:          "<size>",
+	EnvKeys:      []string{"BUILD_MEMORY"},
+}
+
+// --cpus
+var buildCPUsFlag = cmdline.Flag{
+	ID:           "buildCPUsFlag",
+	Value:        &buildArgs.cpus,
+	DefaultValue: "",
+	Name:         "cpus",
+	Usage:        "number of CPUs available to the %post and %test sections, to the whole build with --fakeroot (e.g. 1.5)",
+	Tag:          "<number>",
+	EnvKeys:      []string{"BUILD_CPUS"},
+}
+
+// --build-timeout
+var buildTimeoutFlag = cmdline.Flag{
+	ID:           "buildTimeoutFlag",
+	Value:        &buildArgs.timeout,
+	DefaultValue: "",
+	Name:         "build-timeout",
+	Usage:        "abort the build and kill the running section after this duration (e.g. 90m)",
+	Tag:          "<duration>",
+	EnvKeys:      []string{"BUILD_TIMEOUT"},
+}
+
+// --downloads
+var buildDownloadsFlag = cmdline.Flag{
+	ID:           "buildDownloadsFlag",
+	Value:        &buildArgs.downloads,
+	DefaultValue: types.DefaultDownloads,
+	Name:         "downloads",
+	Usage:        "maximum number of concurrent downloads of the OCI layers and %files URL sources",
+	Tag:          "<n>",
+	EnvKeys:      []string{"BUILD_DOWNLOADS"},
+}
+
+// --parallel
+var buildParallelFlag = cmdline.Flag{
+	ID:           "buildParallelFlag",
+	Value:        &buildArgs.parallel,
+	DefaultValue: 1,
+	Name:         "parallel",
+	Usage:        "maximum number of independent stages of a multi-stage definition built concurrently",
+	Tag:          "<n>",
+	EnvKeys:      []string{"BUILD_PARALLEL"},
+}
+
+// --sign
+var buildSignFlag = cmdline.Flag{
+	ID:           "buildSignFlag",
+	Value:        &buildArgs.sign,
+	DefaultValue: false,
+	Name:         "sign",
+	Usage:        "sign the SIF image before moving it to its destination, the passphrase of an encrypted key is read from SINGULARITY_SIGN_PASSPHRASE or prompted",
+	EnvKeys:      []string{"BUILD_SIGN"},
+}
+
+// --keyidx
+var buildSignKeyIdxFlag = cmdline.Flag{
+	ID:           "buildSignKeyIdxFlag",
+	Value:        &buildArgs.signKeyIdx,
+	DefaultValue: 0,
+	Name:         "keyidx",
+	Usage:        "private key to sign with (index from 'key list'), requires --sign",
+}
+
+// --key
+var buildSignKeyFlag = cmdline.Flag{
+	ID:           "buildSignKeyFlag",
+	Value:        &buildArgs.signKey,
+	DefaultValue: "",
+	Name:         "key",
+	Usage:        "binary or ASCII armored private key file to sign with instead of the keyring, requires --sign",
+	Tag:          "<path>",
+	EnvKeys:      []string{"BUILD_SIGN_KEY"},
+}
+
+// --sbom
+var buildSBOMFlag = cmdline.Flag{
+	ID:           "buildSBOMFlag",
+	Value:        &buildArgs.sbom,
+	DefaultValue: "",
+	Name:         "sbom",
+	Usage:        "generate a software bill of materials of the image packages in the given format (spdx, cyclonedx)",
+	Tag:          "<format>",
+	EnvKeys:      []string{"BUILD_SBOM"},
+}
+
+// --test-report
+var buildTestReportFlag = cmdline.Flag{
+	ID:           "buildTestReportFlag",
+	Value:        &buildArgs.testReport,
+	DefaultValue: "",
+	Name:         "test-report",
+	Usage:        "write the report of the %test and %apptest sections to path, in the JUnit format for a .xml path and JSON otherwise",
+	Tag:          "<path>",
+	EnvKeys:      []string{"BUILD_TEST_REPORT"},
+}
+
 // -d|--detached
 var buildDetachedFlag = cmdline.Flag{
 	ID:           "buildDetachedFlag",
@@ -135,6 +421,16 @@ var buildBuilderFlag = cmdline.Flag{
 	EnvKeys:      []string{"BUILDER"},
 }
 
+// --builder-type
+var buildBuilderTypeFlag = cmdline.Flag{
+	ID:           "buildBuilderTypeFlag",
+	Value:        &buildArgs.builderType,
+	DefaultValue: "",
+	Name:         "builder-type",
+	Usage:        "type of the remote Build Service (" + strings.Join(remotebuilder.Backends(), ", ") + "), defaults to the remote endpoint builder type",
+	EnvKeys:      []string{"BUILDER_TYPE"},
+}
+
 // --library
 var buildLibraryFlag = cmdline.Flag{
 	ID:           "buildLibraryFlag",
@@ -186,6 +482,27 @@ var buildEncryptFlag = cmdline.Flag{
 	Usage:        "build an image with an encrypted file system",
 }
 
+// --encryption-key
+var buildEncryptionKeyFlag = cmdline.Flag{
+	ID:           "buildEncryptionKeyFlag",
+	Value:        &buildArgs.encryptionKeys,
+	DefaultValue: []string{},
+	Name:         "encryption-key",
+	Usage:        "encrypt the image for a recipient, the source is pem:<path>, env:<variable>, keyring:<key>, kms:<service>:<key> or passphrase to prompt for it. Multiple recipients can be given by a comma separated list, implies --encrypt",
+	Tag:          "<source>",
+	EnvKeys:      []string{"BUILD_ENCRYPTION_KEY"},
+	EnvHandler:   cmdline.EnvAppendValue,
+}
+
+// --verity
+var buildVerityFlag = cmdline.Flag{
+	ID:           "buildVerityFlag",
+	Value:        &buildArgs.verity,
+	DefaultValue: false,
+	Name:         "verity",
+	Usage:        "add a dm-verity hash tree of the root filesystem checked at read time by a privileged runtime",
+}
+
 // TODO: Deprecate at 3.6, remove at 3.8
 // --fix-perms
 var buildFixPermsFlag = cmdline.Flag{
@@ -202,20 +519,47 @@ func init() {
 		cmdManager.RegisterCmd(buildCmd)
 
 		cmdManager.RegisterFlagForCmd(&buildArchFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildPlatformFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildArgFlag, buildCmd, DefLintCmd)
+		cmdManager.RegisterFlagForCmd(&buildArgFileFlag, buildCmd, DefLintCmd)
+		cmdManager.RegisterFlagForCmd(&buildBindFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildBuilderFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildBuilderTypeFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildCacheSectionsFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildCheckFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildCompressionFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildCompressionLevelFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildCPUsFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildDetachedFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildDisableCacheFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildDownloadsFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildParallelFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildEncryptFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildEncryptionKeyFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildFakerootFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildFixPermsFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildJSONFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildJSONFdFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildSignKeyFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildSignKeyIdxFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildLibraryFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildMemoryFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildMountFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildNetworkFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildNoBuildLogFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildNoCleanupFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildNoTestFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildOutputFormatFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildRemoteFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildRootfsFormatFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildSBOMFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildTestReportFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildSandboxFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildSectionFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildSignFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildTimeoutFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&buildUpdateFlag, buildCmd)
+		cmdManager.RegisterFlagForCmd(&buildVerityFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&commonForceFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&commonNoHTTPSFlag, buildCmd)
 		cmdManager.RegisterFlagForCmd(&commonTmpDirFlag, buildCmd)
@@ -256,6 +600,65 @@ func preRun(cmd *cobra.Command, args []string) {
 	sylabsToken(cmd, args)
 }
 
+// buildOCIFormats are the output formats written by the containers/image
+// transport of the same name.
+var buildOCIFormats = []string{"oci", "docker-archive"}
+
+// buildTarget returns the output format and the path of the build target
+// dest, the format is selected by --sandbox, --output-format or the
+// transport prefix of OCI targets. The image reference of OCI targets
+// follows the path:
+//
+//	oci://dir[:tag]
+//	docker-archive:out.tar[:name:tag]
+func buildTarget(dest string) (format, path, ref string, err error) {
+	format = buildArgs.outputFormat
+	for _, t := range buildOCIFormats {
+		if !strings.HasPrefix(dest, t+":") {
+			continue
+		}
+		if format != "" && format != t {
+			return "", "", "", fmt.Errorf("%s target doesn't match --output-format %s", t, format)
+		}
+		format = t
+		dest = strings.TrimPrefix(strings.TrimPrefix(dest, t+":"), "//")
+	}
+	if buildArgs.sandbox {
+		if format != "" && format != "sandbox" {
+			return "", "", "", fmt.Errorf("--sandbox and %s output format are mutually exclusive", format)
+		}
+		format = "sandbox"
+	}
+
+	switch format {
+	case "":
+		format = "sif"
+	case "sif", "sandbox":
+	case "oci", "docker-archive":
+		split := strings.SplitN(dest, ":", 2)
+		dest = split[0]
+		if len(split) == 2 {
+			ref = split[1]
+		}
+	default:
+		return "", "", "", fmt.Errorf("unrecognized output format %s", format)
+	}
+	if dest == "" {
+		return "", "", "", fmt.Errorf("missing %s target path", format)
+	}
+	return format, dest, ref, nil
+}
+
+// isOCILayout returns true if the directory files are an OCI image layout.
+func isOCILayout(files []os.FileInfo) bool {
+	for _, f := range files {
+		if f.Name() == "oci-layout" {
+			return true
+		}
+	}
+	return false
+}
+
 // checkBuildTarget makes sure output target doesn't exist, or is ok to overwrite.
 // And checks that update flag will update an existing directory.
 func checkBuildTarget(path string) error {
@@ -264,12 +667,22 @@ func checkBuildTarget(path string) error {
 		return fmt.Errorf("failed to get absolute path for %q: %v", path, err)
 	}
 
-	if !buildArgs.sandbox && buildArgs.update {
-		return fmt.Errorf("only sandbox update is supported: --sandbox flag is missing")
-	}
 	if f, err := os.Stat(abspath); err == nil {
-		if buildArgs.update && !f.IsDir() {
-			return fmt.Errorf("only sandbox update is supported: %s is not a directory", abspath)
+		if buildArgs.update && buildArgs.sandbox && !f.IsDir() {
+			return fmt.Errorf("could not update sandbox %s: not a directory", abspath)
+		}
+		if buildArgs.update && !buildArgs.sandbox {
+			if f.IsDir() {
+				return fmt.Errorf("could not update sandbox %s: --sandbox flag is missing", abspath)
+			}
+			img, err := image.Init(abspath, false)
+			if err != nil {
+				return fmt.Errorf("could not update %s: %v", abspath, err)
+			}
+			img.File.Close()
+			if img.Type != image.SIF {
+				return fmt.Errorf("could not update %s: only SIF images and sandboxes can be updated", abspath)
+			}
 		}
 		// check if the sandbox image being overwritten looks like a Singularity
 		// image and inform users to check its content and use --force option if
@@ -286,7 +699,7 @@ func checkBuildTarget(path string) error {
 						required++
 					}
 				}
-				if required != 4 {
+				if required != 4 && !(buildArgs.outputFormat == "oci" && isOCILayout(files)) {
 					return fmt.Errorf("%s is not empty and is not a Singularity sandbox, check its content first and use --force if you want to overwrite it", abspath)
 				}
 			}
@@ -308,21 +721,63 @@ func checkBuildTarget(path string) error {
 			}
 			forceOverwrite = true
 		}
-	} else if os.IsNotExist(err) && buildArgs.update && buildArgs.sandbox {
-		return fmt.Errorf("could not update sandbox %s: doesn't exist", abspath)
+	} else if os.IsNotExist(err) && buildArgs.update {
+		return fmt.Errorf("could not update %s: doesn't exist", abspath)
 	}
 	return nil
 }
 
+// definitionBuildArgs returns the definition file variable values set with
+// --build-arg-file and --build-arg, the latter taking precedence.
+func definitionBuildArgs() (map[string]string, error) {
+	args := make(map[string]string)
+
+	if buildArgs.buildArgFile != "" {
+		fileArgs, err := parser.ReadBuildArgsFile(buildArgs.buildArgFile)
+		if err != nil {
+			return nil, err
+		}
+		args = fileArgs
+	}
+
+	flagArgs, err := parser.ParseBuildArgs(buildArgs.buildArgs)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range flagArgs {
+		args[k] = v
+	}
+
+	return args, nil
+}
+
 // definitionFromSpec is specifically for parsing specs for the remote builder
 // it uses a different version the the definition struct and parser
-func definitionFromSpec(spec string) (types.Definition, error) {
+func definitionFromSpec(spec string, args map[string]string) (types.Definition, error) {
 	// Try spec as URI first
 	def, err := types.NewDefinitionFromURI(spec)
 	if err == nil {
 		return def, nil
 	}
 
+	// Try spec as Dockerfile, the remote builder only
+	// supports a single stage
+	if parser.IsDockerfile(spec) {
+		f, err := os.Open(spec)
+		if err != nil {
+			return types.Definition{}, err
+		}
+		defer f.Close()
+
+		defs, err := parser.ParseDockerfile(f, filepath.Dir(spec), args)
+		if err != nil {
+			return types.Definition{}, err
+		} else if len(defs) > 1 {
+			return types.Definition{}, fmt.Errorf("multi-stage Dockerfiles are not supported by the remote builder")
+		}
+		return defs[0], nil
+	}
+
 	// Try spec as local file
 	var isValid bool
 	isValid, err = parser.IsValidDefinition(spec)
@@ -333,15 +788,28 @@ func definitionFromSpec(spec string) (types.Definition, error) {
 	if isValid {
 		sylog.Debugf("Found valid definition: %s\n", spec)
 		// File exists and contains valid definition
-		var defFile *os.File
-		defFile, err = os.Open(spec)
+		raw, err := parser.ReadDefinitionFile(spec)
 		if err != nil {
 			return types.Definition{}, err
 		}
 
-		defer defFile.Close()
+		raw, includes, err := parser.ResolveIncludes(raw, spec)
+		if err != nil {
+			return types.Definition{}, err
+		}
 
-		return parser.ParseDefinitionFile(defFile)
+		raw, err = parser.ApplyBuildArgs(raw, args)
+		if err != nil {
+			return types.Definition{}, err
+		}
+
+		def, err = parser.ParseDefinitionFile(bytes.NewReader(raw))
+		if err != nil {
+			return types.Definition{}, err
+		}
+		def.Includes = includes
+
+		return def, nil
 	}
 
 	// File exists and does NOT contain a valid definition
@@ -383,6 +851,7 @@ func makeDockerCredentials(cmd *cobra.Command) (authConf *ocitypes.DockerAuthCon
 	}
 
 	if usernameFlag.Changed || passwordFlag.Changed {
+		sylog.AddSecret(dockerAuthConfig.Password)
 		return &dockerAuthConfig, nil
 	}
 
@@ -405,12 +874,24 @@ func handleRemoteBuildFlags(cmd *cobra.Command) {
 	}
 
 	authToken = endpoint.Token
+	// the endpoint may use another build service than its own
+	var builder scs.Builder
+	if endpoint.Builder != nil {
+		builder = *endpoint.Builder
+	}
+	if !cmd.Flags().Lookup("builder-type").Changed {
+		buildArgs.builderType = builder.Type
+	}
 	if !cmd.Flags().Lookup("builder").Changed {
-		uri, err := endpoint.GetServiceURI("builder")
-		if err != nil {
-			sylog.Fatalf("Unable to get build service URI: %v", err)
+		if builder.URI != "" {
+			buildArgs.builderURL = builder.URI
+		} else {
+			uri, err := endpoint.GetServiceURI("builder")
+			if err != nil {
+				sylog.Fatalf("Unable to get build service URI: %v", err)
+			}
+			buildArgs.builderURL = uri
 		}
-		buildArgs.builderURL = uri
 	}
 	if !cmd.Flags().Lookup("library").Changed {
 		uri, err := endpoint.GetServiceURI("library")
@@ -6,8 +6,6 @@
 package cli
 
 import (
-	"context"
-
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/internal/pkg/build/remotebuilder"
 	"github.com/sylabs/singularity/pkg/sylog"
@@ -37,17 +35,22 @@ func runBuild(cmd *cobra.Command, args []string) {
 		sylog.Fatalf("Unable to submit build job: %v", remoteWarning)
 	}
 
-	def, err := definitionFromSpec(spec)
+	args, err := definitionBuildArgs()
+	if err != nil {
+		sylog.Fatalf("While parsing build arguments: %v", err)
+	}
+
+	def, err := definitionFromSpec(spec, args)
 	if err != nil {
 		sylog.Fatalf("Unable to build from %s: %v", spec, err)
 	}
 
-	b, err := remotebuilder.New(dest, buildArgs.libraryURL, def, buildArgs.detached, forceOverwrite, buildArgs.builderURL, authToken, buildArgs.arch)
+	b, err := remotebuilder.New(dest, buildArgs.libraryURL, def, buildArgs.detached, forceOverwrite, buildArgs.builderType, buildArgs.builderURL, authToken, buildArgs.arch)
 	if err != nil {
 		sylog.Fatalf("Failed to create builder: %v", err)
 	}
 
-	err = b.Build(context.TODO())
+	err = b.Build(cmd.Context())
 	if err != nil {
 		sylog.Fatalf("While performing build: %v", err)
 	}
@@ -11,25 +11,39 @@ import (
 	"io/ioutil"
 	"os"
 	osExec "os/exec"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
+	units "github.com/docker/go-units"
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/internal/pkg/build"
+	"github.com/sylabs/singularity/internal/pkg/build/events"
 	"github.com/sylabs/singularity/internal/pkg/build/remotebuilder"
+	"github.com/sylabs/singularity/internal/pkg/build/sbom"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	"github.com/sylabs/singularity/internal/pkg/cache"
+	"github.com/sylabs/singularity/internal/pkg/plugin"
 	scs "github.com/sylabs/singularity/internal/pkg/remote"
 	fakerootConfig "github.com/sylabs/singularity/internal/pkg/runtime/engine/fakeroot/config"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
 	"github.com/sylabs/singularity/internal/pkg/util/interactive"
+	"github.com/sylabs/singularity/internal/pkg/util/machine"
 	"github.com/sylabs/singularity/internal/pkg/util/starter"
 	"github.com/sylabs/singularity/internal/pkg/util/user"
 	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/build/types/parser"
 	"github.com/sylabs/singularity/pkg/image"
+	buildcallback "github.com/sylabs/singularity/pkg/plugin/callback/build"
 	"github.com/sylabs/singularity/pkg/runtime/engine/config"
+	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engine/singularity/config"
 	"github.com/sylabs/singularity/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/sypgp"
 	"github.com/sylabs/singularity/pkg/util/crypt"
+	"golang.org/x/crypto/openpgp"
 )
 
 func fakerootExec(cmdArgs []string) {
@@ -71,6 +85,20 @@ func fakerootExec(cmdArgs []string) {
 		BuildEnv: true,
 	}
 
+	// cgroups can't be managed from the fakeroot user namespace,
+	// the limits are applied to the whole build by the engine
+	memory, cpus, _, err := buildLimits()
+	if err != nil {
+		sylog.Fatalf("While checking resource limits: %v", err)
+	}
+	if limits := build.Limits(memory, cpus); limits != nil {
+		spec, err := limits.Spec()
+		if err != nil {
+			sylog.Fatalf("While converting resource limits: %v", err)
+		}
+		engineConfig.Resources = &spec
+	}
+
 	cfg := &config.Common{
 		EngineName:   fakerootConfig.Name,
 		ContainerID:  "fakeroot",
@@ -88,11 +116,58 @@ func fakerootExec(cmdArgs []string) {
 func runBuild(cmd *cobra.Command, args []string) {
 	ctx := context.TODO()
 
-	if buildArgs.arch != runtime.GOARCH && !buildArgs.remote {
-		sylog.Fatalf("Requested architecture (%s) does not match host (%s). Cannot build locally.", buildArgs.arch, runtime.GOARCH)
+	if buildArgs.remote && buildArgs.platform != "" {
+		sylog.Fatalf("--platform is not supported by remote builds, use --arch instead")
+	}
+	if buildArgs.remote && (len(buildArgs.binds) > 0 || len(buildArgs.mounts) > 0) {
+		sylog.Fatalf("--bind and --mount are not supported by remote builds")
+	}
+	if buildArgs.remote && buildArgs.network != "" {
+		sylog.Fatalf("--network is not supported by remote builds")
+	}
+	if buildArgs.remote && (buildArgs.memory != "" || buildArgs.cpus != "" || buildArgs.timeout != "") {
+		sylog.Fatalf("--memory, --cpus and --build-timeout are not supported by remote builds")
+	}
+	if buildArgs.remote && buildArgs.update {
+		sylog.Fatalf("--update is not supported by remote builds")
+	}
+	if buildArgs.remote && buildArgs.sign {
+		sylog.Fatalf("--sign is not supported by remote builds, sign the downloaded image instead")
+	}
+	if buildArgs.remote && (buildArgs.isJSON || buildArgs.jsonFd >= 0) {
+		sylog.Fatalf("--json and --json-fd are not supported by remote builds")
+	}
+	if buildArgs.sbom != "" {
+		if buildArgs.remote {
+			sylog.Fatalf("--sbom is not supported by remote builds")
+		}
+		if err := sbom.IsSupported(buildArgs.sbom); err != nil {
+			sylog.Fatalf("While checking --sbom: %v", err)
+		}
+	}
+	if buildArgs.testReport != "" {
+		if buildArgs.remote {
+			sylog.Fatalf("--test-report is not supported by remote builds")
+		}
+		if buildArgs.noTest {
+			sylog.Fatalf("--test-report and --notest are mutually exclusive")
+		}
+		path, err := filepath.Abs(buildArgs.testReport)
+		if err != nil {
+			sylog.Fatalf("While resolving --test-report path: %v", err)
+		}
+		buildArgs.testReport = path
 	}
 
-	dest := args[0]
+	format, dest, ref, err := buildTarget(args[0])
+	if err != nil {
+		sylog.Fatalf("While checking build target: %v", err)
+	}
+	buildArgs.outputFormat = format
+	buildArgs.sandbox = format == "sandbox"
+	if buildArgs.remote && format != "sif" && format != "sandbox" {
+		sylog.Fatalf("--output-format %s is not supported by remote builds", format)
+	}
 	spec := args[1]
 
 	// check if target collides with existing file
@@ -101,9 +176,10 @@ func runBuild(cmd *cobra.Command, args []string) {
 	}
 
 	if buildArgs.remote {
-		runBuildRemote(ctx, cmd, dest, spec)
+		// the remote build is canceled on interrupt
+		runBuildRemote(cmd.Context(), cmd, dest, spec)
 	} else {
-		runBuildLocal(ctx, cmd, dest, spec)
+		runBuildLocal(ctx, cmd, dest, ref, spec)
 	}
 	sylog.Infof("Build complete: %s", dest)
 }
@@ -113,6 +189,9 @@ func runBuildRemote(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 	if buildArgs.encrypt {
 		sylog.Fatalf("Building encrypted container with the remote builder is not currently supported.")
 	}
+	if buildArgs.verity {
+		sylog.Fatalf("Building container with dm-verity with the remote builder is not currently supported.")
+	}
 
 	handleRemoteBuildFlags(cmd)
 
@@ -121,7 +200,16 @@ func runBuildRemote(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 		sylog.Fatalf("Unable to submit build job: %v", remoteWarning)
 	}
 
-	def, err := definitionFromSpec(spec)
+	args, err := definitionBuildArgs()
+	if err != nil {
+		sylog.Fatalf("While parsing build arguments: %v", err)
+	}
+
+	if buildArgs.check {
+		checkScripts(spec, args, nil)
+	}
+
+	def, err := definitionFromSpec(spec, args)
 	if err != nil {
 		sylog.Fatalf("Unable to build from %s: %v", spec, err)
 	}
@@ -170,33 +258,33 @@ func runBuildRemote(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 			}
 
 			if err = b.Full(ctx); err != nil {
-				sylog.Fatalf("While performing build: %v", err)
+				sylog.Fatalf("While performing build: %v", sylog.WithCode(sylog.BuildFailed, err))
 			}
 		}()
 	}
 
-	b, err := remotebuilder.New(dst, buildArgs.libraryURL, def, buildArgs.detached, forceOverwrite, buildArgs.builderURL, authToken, buildArgs.arch)
+	b, err := remotebuilder.New(dst, buildArgs.libraryURL, def, buildArgs.detached, forceOverwrite, buildArgs.builderType, buildArgs.builderURL, authToken, buildArgs.arch)
 	if err != nil {
 		sylog.Fatalf("Failed to create builder: %v", err)
 	}
 	err = b.Build(ctx)
 	if err != nil {
-		sylog.Fatalf("While performing build: %v", err)
+		sylog.Fatalf("While performing build: %v", sylog.WithCode(sylog.BuildFailed, err))
 	}
 }
 
-func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
-	var keyInfo *crypt.KeyInfo
-	if buildArgs.encrypt || promptForPassphrase || cmd.Flags().Lookup("pem-path").Changed {
+func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, ref, spec string) {
+	var keys []crypt.KeyProvider
+	if buildArgs.encrypt || promptForPassphrase || cmd.Flags().Lookup("pem-path").Changed || len(buildArgs.encryptionKeys) > 0 {
 		if os.Getuid() != 0 {
 			sylog.Fatalf("You must be root to build an encrypted container")
 		}
 
-		k, err := getEncryptionMaterial(cmd)
+		k, err := getEncryptionKeys(ctx, cmd)
 		if err != nil {
 			sylog.Fatalf("While handling encryption material: %v", err)
 		}
-		keyInfo = &k
+		keys = k
 	} else {
 		_, passphraseEnvOK := os.LookupEnv("SINGULARITY_ENCRYPTION_PASSPHRASE")
 		_, pemPathEnvOK := os.LookupEnv("SINGULARITY_ENCRYPTION_PEM_PATH")
@@ -210,11 +298,48 @@ func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 		sylog.Fatalf("Failed to create an image cache handle")
 	}
 
+	platform, err := buildPlatform(cmd)
+	if err != nil {
+		sylog.Fatalf("While selecting build platform: %v", err)
+	}
+
+	binds, err := buildBindPaths()
+	if err != nil {
+		sylog.Fatalf("While checking bind paths: %v", err)
+	}
+
+	if err := checkNetwork(); err != nil {
+		sylog.Fatalf("While checking --network: %v", err)
+	}
+
+	memory, cpus, timeout, err := buildLimits()
+	if err != nil {
+		sylog.Fatalf("While checking resource limits: %v", err)
+	}
+
+	if buildArgs.downloads < 1 {
+		sylog.Fatalf("--downloads must be greater than zero")
+	}
+	if buildArgs.parallel < 1 {
+		sylog.Fatalf("--parallel must be greater than zero")
+	}
+
+	// the key is selected and decrypted before the build starts
+	signEntity, err := buildSignEntity(cmd)
+	if err != nil {
+		sylog.Fatalf("While selecting signing key: %v", err)
+	}
+
+	emitter, err := buildEvents()
+	if err != nil {
+		sylog.Fatalf("While checking --json-fd: %v", err)
+	}
+
 	if syscall.Getuid() != 0 && !buildArgs.fakeroot && fs.IsFile(spec) && !isImage(spec) {
 		sylog.Fatalf("You must be the root user, however you can use --remote or --fakeroot to build from a Singularity recipe file")
 	}
 
-	err := checkSections()
+	err = checkSections()
 	if err != nil {
 		sylog.Fatalf("Could not check build sections: %v", err)
 	}
@@ -224,8 +349,17 @@ func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 		sylog.Fatalf("While creating Docker credentials: %v", err)
 	}
 
+	args, err := definitionBuildArgs()
+	if err != nil {
+		sylog.Fatalf("While parsing build arguments: %v", err)
+	}
+
+	if buildArgs.check {
+		checkScripts(spec, args, binds)
+	}
+
 	// parse definition to determine build source
-	defs, err := build.MakeAllDefs(spec)
+	defs, err := build.MakeAllDefs(spec, args)
 	if err != nil {
 		sylog.Fatalf("Unable to build from %s: %v", spec, err)
 	}
@@ -238,35 +372,46 @@ func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 		}
 	}
 
-	buildFormat := "sif"
-	sandboxTarget := false
-	if buildArgs.sandbox {
-		buildFormat = "sandbox"
-		sandboxTarget = true
-
-	}
-
 	b, err := build.New(
 		defs,
 		build.Config{
 			Dest:      dst,
-			Format:    buildFormat,
+			Format:    buildArgs.outputFormat,
+			Reference: ref,
 			NoCleanUp: buildArgs.noCleanUp,
 			Opts: types.Options{
-				ImgCache:          imgCache,
-				TmpDir:            tmpDir,
-				NoCache:           disableCache,
-				Update:            buildArgs.update,
-				Force:             forceOverwrite,
-				Sections:          buildArgs.sections,
-				NoTest:            buildArgs.noTest,
-				NoHTTPS:           noHTTPS,
-				LibraryURL:        buildArgs.libraryURL,
-				LibraryAuthToken:  authToken,
-				DockerAuthConfig:  authConf,
-				EncryptionKeyInfo: keyInfo,
-				FixPerms:          buildArgs.fixPerms,
-				SandboxTarget:     sandboxTarget,
+				ImgCache:         imgCache,
+				TmpDir:           tmpDir,
+				NoCache:          disableCache,
+				Update:           buildArgs.update,
+				Force:            forceOverwrite,
+				Sections:         buildArgs.sections,
+				NoTest:           buildArgs.noTest,
+				NoBuildLog:       buildArgs.noBuildLog,
+				NoHTTPS:          noHTTPS,
+				LibraryURL:       buildArgs.libraryURL,
+				LibraryAuthToken: authToken,
+				DockerAuthConfig: authConf,
+				EncryptionKeys:   keys,
+				FixPerms:         buildArgs.fixPerms,
+				SandboxTarget:    buildArgs.sandbox,
+				Compression:      buildArgs.compression,
+				CompressionLevel: buildArgs.compressionLevel,
+				RootfsFormat:     buildArgs.rootfsFormat,
+				Platform:         platform,
+				SBOM:             buildArgs.sbom,
+				TestReport:       buildArgs.testReport,
+				Binds:            binds,
+				CacheSections:    buildArgs.cacheSections,
+				Network:          buildArgs.network,
+				Memory:           memory,
+				CPUs:             cpus,
+				Timeout:          timeout,
+				Downloads:        buildArgs.downloads,
+				Parallel:         buildArgs.parallel,
+				SignEntity:       signEntity,
+				Verity:           buildArgs.verity,
+				Events:           emitter,
 			},
 		})
 	if err != nil {
@@ -274,10 +419,182 @@ func runBuildLocal(ctx context.Context, cmd *cobra.Command, dst, spec string) {
 	}
 
 	if err = b.Full(ctx); err != nil {
-		sylog.Fatalf("While performing build: %v", err)
+		sylog.Fatalf("While performing build: %v", sylog.WithCode(sylog.BuildFailed, err))
 	}
 }
 
+// checkScripts reports the issues found by the static analysis of the
+// scripts of the definition file spec, errors abort the build.
+func checkScripts(spec string, args map[string]string, binds []string) {
+	diagnostics, err := build.CheckScripts(spec, args, binds)
+	if err != nil {
+		sylog.Fatalf("Unable to check %s: %v", spec, err)
+	}
+	for _, d := range diagnostics {
+		if d.Severity == parser.SeverityError {
+			sylog.Errorf("%s:%d: %s", spec, d.Line, d.Message)
+		} else {
+			sylog.Warningf("%s:%d: %s", spec, d.Line, d.Message)
+		}
+	}
+	if parser.HasErrors(diagnostics) {
+		sylog.Fatalf("Scripts of %s have errors, aborting build", spec)
+	}
+}
+
+// buildBindPaths returns the bind paths of --bind and --mount mounted while
+// running %post, sources are made absolute as %post doesn't run in the
+// current working directory.
+func buildBindPaths() ([]string, error) {
+	mounts, err := build.ParseMounts(buildArgs.mounts)
+	if err != nil {
+		return nil, err
+	}
+
+	bindPaths, err := singularityConfig.ParseBindPath(strings.Join(append(buildArgs.binds, mounts...), ","))
+	if err != nil {
+		return nil, err
+	}
+
+	binds := make([]string, 0, len(bindPaths))
+	for _, bp := range bindPaths {
+		if bp.ImageSrc() != "" || bp.ID() != "" {
+			return nil, fmt.Errorf("image-src and id bind options are not supported during build")
+		}
+		src, err := filepath.Abs(bp.Source)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(src); err != nil {
+			return nil, fmt.Errorf("bind source %s: %v", bp.Source, err)
+		}
+		if !filepath.IsAbs(bp.Destination) {
+			return nil, fmt.Errorf("bind destination %s must be an absolute path", bp.Destination)
+		}
+		bind := src + ":" + bp.Destination
+		if bp.Readonly() {
+			bind += ":ro"
+		}
+		binds = append(binds, bind)
+	}
+	return binds, nil
+}
+
+// checkNetwork checks the network of %post and %test, either none, host
+// or a list of CNI profiles which are only available to the root user.
+func checkNetwork() error {
+	if buildArgs.network == "" {
+		return nil
+	}
+
+	networks := strings.Split(buildArgs.network, ",")
+	for _, n := range networks {
+		switch n {
+		case "":
+			return fmt.Errorf("empty network name in %q", buildArgs.network)
+		case types.NoneNetwork, types.HostNetwork:
+			if len(networks) > 1 {
+				return fmt.Errorf("%s network can't be combined with other networks", n)
+			}
+		}
+	}
+
+	if n := networks[0]; n != types.NoneNetwork && n != types.HostNetwork && buildArgs.fakeroot {
+		return fmt.Errorf("CNI networks require to build as root, only %s and %s are supported with --fakeroot", types.NoneNetwork, types.HostNetwork)
+	}
+	return nil
+}
+
+// buildLimits returns the memory limit in bytes, the number of CPUs
+// and the timeout of the build set with --memory, --cpus and
+// --build-timeout.
+func buildLimits() (memory int64, cpus float64, timeout time.Duration, err error) {
+	if buildArgs.memory != "" {
+		memory, err = units.RAMInBytes(buildArgs.memory)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid --memory value: %v", err)
+		} else if memory <= 0 {
+			return 0, 0, 0, fmt.Errorf("--memory must be greater than zero")
+		}
+	}
+	if buildArgs.cpus != "" {
+		cpus, err = strconv.ParseFloat(buildArgs.cpus, 64)
+		if err != nil || cpus <= 0 {
+			return 0, 0, 0, fmt.Errorf("--cpus must be a number greater than zero")
+		}
+	}
+	if buildArgs.timeout != "" {
+		timeout, err = time.ParseDuration(buildArgs.timeout)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid --build-timeout value: %v", err)
+		} else if timeout <= 0 {
+			return 0, 0, 0, fmt.Errorf("--build-timeout must be greater than zero")
+		}
+	}
+	return memory, cpus, timeout, nil
+}
+
+// buildSignEntity returns the decrypted key signing the image with --sign,
+// it is selected from the private keyring or loaded from the --key file.
+// The passphrase of an encrypted key is read from SINGULARITY_SIGN_PASSPHRASE
+// so that images can be signed with a CI secret, or prompted.
+func buildSignEntity(cmd *cobra.Command) (*openpgp.Entity, error) {
+	keyIdx := cmd.Flags().Lookup(buildSignKeyIdxFlag.Name).Changed
+
+	if !buildArgs.sign {
+		if keyIdx || buildArgs.signKey != "" {
+			return nil, fmt.Errorf("--keyidx and --key require --sign")
+		}
+		return nil, nil
+	}
+	if buildArgs.outputFormat != "sif" {
+		return nil, fmt.Errorf("%s images can't be signed", buildArgs.outputFormat)
+	}
+	if keyIdx && buildArgs.signKey != "" {
+		return nil, fmt.Errorf("--keyidx and --key are mutually exclusive")
+	}
+
+	f := selectEntityInteractive()
+	if keyIdx {
+		f = selectEntityAtIndex(buildArgs.signKeyIdx)
+	}
+	if passphrase, ok := os.LookupEnv("SINGULARITY_SIGN_PASSPHRASE"); ok {
+		f = decryptSelectedEntity(f, passphrase)
+	} else {
+		f = decryptSelectedEntityInteractive(f)
+	}
+
+	if buildArgs.signKey != "" {
+		return sypgp.GetPrivateEntityFromFile(buildArgs.signKey, f)
+	}
+	return sypgp.GetPrivateEntity(f)
+}
+
+// buildPlatform returns the platform of the image selected from
+// multi-platform OCI sources with --platform or --arch, it returns
+// an empty platform if the host platform is selected.
+func buildPlatform(cmd *cobra.Command) (string, error) {
+	archChanged := cmd.Flags().Lookup("arch").Changed
+
+	platform := buildArgs.platform
+	if platform == "" {
+		if !archChanged || buildArgs.arch == runtime.GOARCH {
+			return "", nil
+		}
+		platform = "linux/" + buildArgs.arch
+	}
+
+	p, err := machine.ParsePlatform(platform)
+	if err != nil {
+		return "", err
+	}
+	if archChanged && p.Arch != buildArgs.arch {
+		return "", fmt.Errorf("architecture %s doesn't match platform %s", buildArgs.arch, p)
+	}
+
+	return p.String(), nil
+}
+
 func checkSections() error {
 	var all, none bool
 	for _, section := range buildArgs.sections {
@@ -411,3 +728,113 @@ func getEncryptionMaterial(cmd *cobra.Command) (crypt.KeyInfo, error) {
 
 	return crypt.KeyInfo{}, nil
 }
+
+// getEncryptionKeys returns the recipients the image is encrypted for, all
+// the --pem-path, --passphrase and --encryption-key sources are combined.
+// The SINGULARITY_ENCRYPTION_PEM_PATH and SINGULARITY_ENCRYPTION_PASSPHRASE
+// envvars are only used without any of these flags.
+func getEncryptionKeys(ctx context.Context, cmd *cobra.Command) ([]crypt.KeyProvider, error) {
+	var sources []string
+	if cmd.Flags().Lookup("pem-path").Changed {
+		sources = append(sources, "pem:"+encryptionPEMPath)
+	}
+	if promptForPassphrase {
+		sources = append(sources, "passphrase")
+	}
+	sources = append(sources, buildArgs.encryptionKeys...)
+
+	if len(sources) == 0 {
+		if pemPath, ok := os.LookupEnv("SINGULARITY_ENCRYPTION_PEM_PATH"); ok {
+			sources = append(sources, "pem:"+pemPath)
+		}
+		if _, ok := os.LookupEnv("SINGULARITY_ENCRYPTION_PASSPHRASE"); ok {
+			sources = append(sources, "env:SINGULARITY_ENCRYPTION_PASSPHRASE")
+		}
+	}
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("must supply encryption material through environment variables or flags")
+	}
+
+	keys := make([]crypt.KeyProvider, 0, len(sources))
+	for _, source := range sources {
+		k, err := encryptionKeyProvider(ctx, source)
+		if err != nil {
+			return nil, fmt.Errorf("while handling %s: %v", source, err)
+		}
+		sylog.Verbosef("Encrypting container for %s", k)
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// encryptionKeyProvider returns the provider of an --encryption-key
+// source: pem:<path>, env:<variable>, keyring:<key>, kms:<service>:<key>
+// or passphrase to prompt for a passphrase.
+func encryptionKeyProvider(ctx context.Context, source string) (crypt.KeyProvider, error) {
+	kind := strings.SplitN(source, ":", 2)
+	value := ""
+	if len(kind) == 2 {
+		value = kind[1]
+	}
+
+	switch kind[0] {
+	case "pem":
+		return crypt.NewPEMProvider(value)
+	case "env":
+		return crypt.NewEnvProvider(value)
+	case "keyring":
+		return crypt.NewKeyringProvider(value)
+	case "passphrase":
+		if value != "" {
+			return nil, fmt.Errorf("passphrases are prompted, use env:<variable> or keyring:<key> to pass them")
+		}
+		passphrase, err := interactive.AskQuestionNoEcho("Enter encryption passphrase: ")
+		if err != nil {
+			return nil, err
+		}
+		return crypt.NewPassphraseProvider("passphrase", []byte(passphrase))
+	case "kms":
+		kms := strings.SplitN(value, ":", 2)
+		if len(kms) != 2 || kms[0] == "" || kms[1] == "" {
+			return nil, fmt.Errorf("KMS recipients have the format kms:<service>:<key>")
+		}
+		return kmsKeyProvider(ctx, kms[0], kms[1])
+	default:
+		return nil, fmt.Errorf("unknown key source %q", kind[0])
+	}
+}
+
+// kmsKeyProvider returns the key provider of the plugin handling the key
+// management service.
+func kmsKeyProvider(ctx context.Context, service, key string) (crypt.KeyProvider, error) {
+	callbackType := (buildcallback.KMSKeyProvider)(nil)
+	callbacks, err := plugin.LoadCallbacks(callbackType)
+	if err != nil {
+		return nil, fmt.Errorf("while loading plugins callbacks '%T': %s", callbackType, err)
+	}
+	for _, cb := range callbacks {
+		p, err := cb.(buildcallback.KMSKeyProvider)(ctx, service, key)
+		if err != nil {
+			return nil, fmt.Errorf("plugin callback '%T' failed: %v", callbackType, err)
+		} else if p != nil {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no plugin handles the %s key management service", service)
+}
+
+// buildEvents returns the emitter of the build events requested with
+// --json or --json-fd, it returns nil if none was requested.
+func buildEvents() (*events.Emitter, error) {
+	if buildArgs.jsonFd >= 0 {
+		f := os.NewFile(uintptr(buildArgs.jsonFd), "json-fd")
+		if _, err := f.Stat(); err != nil {
+			return nil, fmt.Errorf("file descriptor %d is not open: %v", buildArgs.jsonFd, err)
+		}
+		return events.New(f), nil
+	}
+	if buildArgs.isJSON {
+		return events.New(os.Stdout), nil
+	}
+	return nil, nil
+}
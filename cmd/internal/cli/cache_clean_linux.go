@@ -9,8 +9,11 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
+	units "github.com/docker/go-units"
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/docs"
 	"github.com/sylabs/singularity/internal/app/singularity"
@@ -23,16 +26,20 @@ func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterFlagForCmd(&cacheCleanTypesFlag, cacheCleanCmd)
 		cmdManager.RegisterFlagForCmd(&cacheCleanDaysFlag, cacheCleanCmd)
+		cmdManager.RegisterFlagForCmd(&cacheCleanOlderThanFlag, cacheCleanCmd)
+		cmdManager.RegisterFlagForCmd(&cacheCleanMaxSizeFlag, cacheCleanCmd)
 		cmdManager.RegisterFlagForCmd(&cacheCleanDryFlag, cacheCleanCmd)
 		cmdManager.RegisterFlagForCmd(&cacheCleanForceFlag, cacheCleanCmd)
 	})
 }
 
 var (
-	cacheCleanTypes []string
-	cacheCleanDays  int
-	cacheCleanDry   bool
-	cacheCleanForce bool
+	cacheCleanTypes     []string
+	cacheCleanDays      int
+	cacheCleanOlderThan string
+	cacheCleanMaxSize   string
+	cacheCleanDry       bool
+	cacheCleanForce     bool
 
 	// -T|--type
 	cacheCleanTypesFlag = cmdline.Flag{
@@ -41,7 +48,7 @@ var (
 		DefaultValue: []string{"all"},
 		Name:         "type",
 		ShortHand:    "T",
-		Usage:        "a list of cache types to clean (possible values: library, oci, shub, blob, net, oras, all)",
+		Usage:        "a list of cache types to clean (possible values: library, oci, shub, blob, net, oras, conda, spack, sections, blocks, all)",
 	}
 
 	// -D|--days
@@ -54,6 +61,26 @@ var (
 		Usage:        "remove all cache entries older than specified number of days",
 	}
 
+	// --older-than
+	cacheCleanOlderThanFlag = cmdline.Flag{
+		ID:           "cacheCleanOlderThanFlag",
+		Value:        &cacheCleanOlderThan,
+		DefaultValue: "",
+		Name:         "older-than",
+		Usage:        "remove the cache entries not used for this duration (e.g. 12h, 30d)",
+		Tag:          "<duration>",
+	}
+
+	// --max-size
+	cacheCleanMaxSizeFlag = cmdline.Flag{
+		ID:           "cacheCleanMaxSizeFlag",
+		Value:        &cacheCleanMaxSize,
+		DefaultValue: "",
+		Name:         "max-size",
+		Usage:        "remove the least recently used entries until each cache type is at most this size (e.g. 10G)",
+		Tag:          "<size>",
+	}
+
 	// -n|--dry-run
 	cacheCleanDryFlag = cmdline.Flag{
 		ID:           "cacheCleanDryFlag",
@@ -91,11 +118,16 @@ var (
 )
 
 func cleanCache() error {
+	policy, err := cleanPolicy()
+	if err != nil {
+		return err
+	}
+
 	if cacheCleanDry {
 		fmt.Println("User requested a dry run. Not actually deleting any data!")
 	}
 	if !cacheCleanForce && !cacheCleanDry {
-		ok, err := cleanCachePrompt()
+		ok, err := cleanCachePrompt(policy)
 		if err != nil {
 			return fmt.Errorf("could not prompt user: %v", err)
 		}
@@ -107,17 +139,64 @@ func cleanCache() error {
 
 	// create a handle to access the current image cache
 	imgCache := getCacheHandle(cache.Config{})
-	err := singularity.CleanSingularityCache(imgCache, cacheCleanDry, cacheCleanTypes, cacheCleanDays)
+	err = singularity.CleanSingularityCache(imgCache, cacheCleanDry, cacheCleanTypes, policy)
 	if err != nil {
 		return fmt.Errorf("could not clean cache: %v", err)
 	}
 	return nil
 }
 
-func cleanCachePrompt() (bool, error) {
-	fmt.Print(`This will delete everything in your cache (containers from all sources and OCI blobs). 
+// cleanPolicy returns the policy selecting the cache entries to remove
+// from the --days, --older-than and --max-size values.
+func cleanPolicy() (cache.CleanPolicy, error) {
+	var policy cache.CleanPolicy
+
+	if cacheCleanDays > 0 {
+		policy.OlderThan = time.Duration(cacheCleanDays) * 24 * time.Hour
+	}
+	if cacheCleanOlderThan != "" {
+		d, err := parseAge(cacheCleanOlderThan)
+		if err != nil {
+			return policy, fmt.Errorf("invalid --older-than value: %v", err)
+		}
+		policy.OlderThan = d
+	}
+	if cacheCleanMaxSize != "" {
+		size, err := units.RAMInBytes(cacheCleanMaxSize)
+		if err != nil {
+			return policy, fmt.Errorf("invalid --max-size value: %v", err)
+		} else if size <= 0 {
+			return policy, fmt.Errorf("--max-size must be greater than zero")
+		}
+		policy.MaxSize = size
+	}
+	return policy, nil
+}
+
+// parseAge parses a duration, the d unit counts days.
+func parseAge(s string) (time.Duration, error) {
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.ParseUint(days, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid number of days %q", days)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err == nil && d <= 0 {
+		err = fmt.Errorf("duration must be greater than zero")
+	}
+	return d, err
+}
+
+func cleanCachePrompt(policy cache.CleanPolicy) (bool, error) {
+	what := "everything in your cache (containers from all sources and OCI blobs)"
+	if !policy.All() {
+		what = "the cache entries selected by --days, --older-than and --max-size"
+	}
+	fmt.Printf(`This will delete %s.
 Hint: You can see exactly what would be deleted by canceling and using the --dry-run option.
-Do you want to continue? [N/y] `)
+Do you want to continue? [N/y] `, what)
 
 	r := bufio.NewReader(os.Stdin)
 	input, err := r.ReadString('\n')
@@ -59,16 +59,56 @@ var fakerootConfigDisableFlag = cmdline.Flag{
 	Usage:        "disable a user fakeroot mapping entry preventing him to use the fakeroot feature (the user mapping must be present)",
 }
 
+// -l|--list
+var fakerootConfigList bool
+var fakerootConfigListFlag = cmdline.Flag{
+	ID:           "fakerootConfigListFlag",
+	Value:        &fakerootConfigList,
+	DefaultValue: false,
+	Name:         "list",
+	ShortHand:    "l",
+	Usage:        "list the fakeroot mapping entries of all users or of the user and report conflicting entries",
+}
+
+// --auto
+var fakerootConfigAuto bool
+var fakerootConfigAutoFlag = cmdline.Flag{
+	ID:           "fakerootConfigAutoFlag",
+	Value:        &fakerootConfigAuto,
+	DefaultValue: false,
+	Name:         "auto",
+	Usage:        "with --add, allocate the mapping in the SUB_UID_MIN-SUB_UID_MAX and SUB_GID_MIN-SUB_GID_MAX ranges of /etc/login.defs like useradd",
+}
+
 // configFakerootCmd singularity config fakeroot
 var configFakerootCmd = &cobra.Command{
-	Args:                  cobra.ExactArgs(1),
+	Args:                  cobra.RangeArgs(0, 1),
 	DisableFlagsInUseLine: true,
 	PreRun:                CheckRoot,
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if fakerootConfigAuto && !fakerootConfigAdd {
+			return fmt.Errorf("--auto requires --add")
+		}
+		if fakerootConfigList {
+			var username string
+			if len(args) > 0 {
+				username = args[0]
+			}
+			if err := singularity.FakerootList(username); err != nil {
+				sylog.Fatalf("%s", err)
+			}
+			return nil
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("you must specify a user")
+		}
+
 		username := args[0]
 		var op singularity.FakerootConfigOp
 
-		if fakerootConfigAdd {
+		if fakerootConfigAdd && fakerootConfigAuto {
+			op = singularity.FakerootAutoAddUser
+		} else if fakerootConfigAdd {
 			op = singularity.FakerootAddUser
 		} else if fakerootConfigRemove {
 			op = singularity.FakerootRemoveUser
@@ -77,7 +117,7 @@ var configFakerootCmd = &cobra.Command{
 		} else if fakerootConfigDisable {
 			op = singularity.FakerootDisableUser
 		} else {
-			return fmt.Errorf("you must specify an option (eg: --add/--remove)")
+			return fmt.Errorf("you must specify an option (eg: --add/--remove/--list)")
 		}
 
 		if err := singularity.FakerootConfig(username, op); err != nil {
@@ -99,5 +139,7 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&fakerootConfigRemoveFlag, configFakerootCmd)
 		cmdManager.RegisterFlagForCmd(&fakerootConfigEnableFlag, configFakerootCmd)
 		cmdManager.RegisterFlagForCmd(&fakerootConfigDisableFlag, configFakerootCmd)
+		cmdManager.RegisterFlagForCmd(&fakerootConfigListFlag, configFakerootCmd)
+		cmdManager.RegisterFlagForCmd(&fakerootConfigAutoFlag, configFakerootCmd)
 	})
 }
@@ -0,0 +1,36 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/pkg/cmdline"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(DefCmd)
+		cmdManager.RegisterSubCmd(DefCmd, DefLintCmd)
+		cmdManager.RegisterSubCmd(DefCmd, DefConvertCmd)
+	})
+}
+
+// DefCmd is the 'def' command that allows definition file management.
+var DefCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.DefUse,
+	Short:         docs.DefShort,
+	Long:          docs.DefLong,
+	Example:       docs.DefExample,
+	SilenceErrors: true,
+}
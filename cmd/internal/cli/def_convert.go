@@ -0,0 +1,65 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/pkg/build/types/parser"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// --to
+var defConvertTo string
+var defConvertToFlag = cmdline.Flag{
+	ID:           "defConvertToFlag",
+	Value:        &defConvertTo,
+	DefaultValue: "",
+	Name:         "to",
+	ShortHand:    "t",
+	Usage:        "output format: def, yaml or json (default def for YAML and JSON files, yaml otherwise)",
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&defConvertToFlag, DefConvertCmd)
+	})
+}
+
+// DefConvertCmd is 'singularity def convert' and converts a definition
+// file between the classic and the structured formats.
+var DefConvertCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := args[0]
+
+		format := defConvertTo
+		if format == "" {
+			format = parser.FormatYAML
+			if parser.StructuredFormat(path) != "" {
+				format = singularity.DefFormatClassic
+			}
+		}
+
+		b, err := singularity.DefConvert(path, format)
+		if err != nil {
+			sylog.Fatalf("Unable to convert %s: %v", path, err)
+		}
+		if _, err := os.Stdout.Write(b); err != nil {
+			sylog.Fatalf("While writing definition: %v", err)
+		}
+	},
+
+	Use:     docs.DefConvertUse,
+	Short:   docs.DefConvertShort,
+	Long:    docs.DefConvertLong,
+	Example: docs.DefConvertExample,
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/pkg/build/types/parser"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// --json
+var defLintJSON bool
+var defLintJSONFlag = cmdline.Flag{
+	ID:           "defLintJSONFlag",
+	Value:        &defLintJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print diagnostics in JSON format",
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&defLintJSONFlag, DefLintCmd)
+	})
+}
+
+// defLintResult is the JSON output of the def lint command.
+type defLintResult struct {
+	File        string              `json:"file"`
+	Valid       bool                `json:"valid"`
+	Diagnostics []parser.Diagnostic `json:"diagnostics"`
+}
+
+// DefLintCmd is 'singularity def lint' and checks a definition file.
+var DefLintCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		path := args[0]
+
+		defArgs, err := definitionBuildArgs()
+		if err != nil {
+			sylog.Fatalf("While parsing build arguments: %v", err)
+		}
+
+		diagnostics, err := singularity.DefLint(path, defArgs)
+		if err != nil {
+			sylog.Fatalf("Unable to check %s: %v", path, err)
+		}
+		valid := !parser.HasErrors(diagnostics)
+
+		if defLintJSON {
+			res := defLintResult{
+				File:        path,
+				Valid:       valid,
+				Diagnostics: diagnostics,
+			}
+			if res.Diagnostics == nil {
+				res.Diagnostics = []parser.Diagnostic{}
+			}
+			b, err := json.MarshalIndent(res, "", "\t")
+			if err != nil {
+				sylog.Fatalf("While marshaling diagnostics: %v", err)
+			}
+			fmt.Println(string(b))
+		} else {
+			for _, d := range diagnostics {
+				if d.Line > 0 {
+					fmt.Fprintf(os.Stdout, "%s:%d: %s: %s\n", path, d.Line, d.Severity, d.Message)
+				} else {
+					fmt.Fprintf(os.Stdout, "%s: %s: %s\n", path, d.Severity, d.Message)
+				}
+			}
+		}
+
+		if !valid {
+			sylog.Fatalf("%s is not a valid definition file", path)
+		}
+	},
+
+	Use:     docs.DefLintUse,
+	Short:   docs.DefLintShort,
+	Long:    docs.DefLintLong,
+	Example: docs.DefLintExample,
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// --format
+var exportFormat string
+var exportFormatFlag = cmdline.Flag{
+	ID:           "exportFormatFlag",
+	Value:        &exportFormat,
+	DefaultValue: singularity.ExportTar,
+	Name:         "format",
+	Usage:        "format of the export (tar, oci-layout or squashfs)",
+}
+
+// --partition
+var exportPartition string
+var exportPartitionFlag = cmdline.Flag{
+	ID:           "exportPartitionFlag",
+	Value:        &exportPartition,
+	DefaultValue: "",
+	Name:         "partition",
+	Usage:        "ID or name of the squashfs partition to export instead of the root filesystem",
+}
+
+// --app
+var exportApp string
+var exportAppFlag = cmdline.Flag{
+	ID:           "exportAppFlag",
+	Value:        &exportApp,
+	DefaultValue: "",
+	Name:         "app",
+	Usage:        "export the files of this app only",
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(ExportCmd)
+
+		cmdManager.RegisterFlagForCmd(&exportFormatFlag, ExportCmd)
+		cmdManager.RegisterFlagForCmd(&exportPartitionFlag, ExportCmd)
+		cmdManager.RegisterFlagForCmd(&exportAppFlag, ExportCmd)
+		cmdManager.RegisterFlagForCmd(&commonForceFlag, ExportCmd)
+		cmdManager.RegisterFlagForCmd(&commonTmpDirFlag, ExportCmd)
+	})
+}
+
+// ExportCmd is 'singularity export' and writes the root filesystem of an
+// image as a tar archive, an OCI image layout or a squashfs image.
+var ExportCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := singularity.ExportOptions{
+			Format:    exportFormat,
+			Partition: exportPartition,
+			App:       exportApp,
+			TmpDir:    tmpDir,
+			Force:     forceOverwrite,
+		}
+		if err := singularity.Export(args[0], args[1], opts); err != nil {
+			sylog.Fatalf("Unable to export image: %v", err)
+		}
+	},
+
+	Use:     docs.ExportUse,
+	Short:   docs.ExportShort,
+	Long:    docs.ExportLong,
+	Example: docs.ExportExample,
+}
@@ -17,11 +17,15 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"text/tabwriter"
 
+	"github.com/opencontainers/go-digest"
 	"github.com/spf13/cobra"
 	"github.com/sylabs/sif/pkg/sif"
 	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
 	"github.com/sylabs/singularity/internal/pkg/util/env"
+	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/cmdline"
 	"github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/inspect"
@@ -42,6 +46,10 @@ var (
 	labels      bool
 	deffile     bool
 	jsonfmt     bool
+	sbomfile    bool
+	buildlog    bool
+	provenance  bool
+	artifacts   bool
 )
 
 // -l|--labels
@@ -143,6 +151,42 @@ var inspectHelpfileFlag = cmdline.Flag{
 	Usage:        "inspect the runscript helpfile, if it exists",
 }
 
+// --sbom
+var inspectSBOMFlag = cmdline.Flag{
+	ID:           "inspectSBOMFlag",
+	Value:        &sbomfile,
+	DefaultValue: false,
+	Name:         "sbom",
+	Usage:        "show the software bill of materials generated with build --sbom",
+}
+
+// --build-log
+var inspectBuildLogFlag = cmdline.Flag{
+	ID:           "inspectBuildLogFlag",
+	Value:        &buildlog,
+	DefaultValue: false,
+	Name:         "build-log",
+	Usage:        "show the output of the build stored in the image",
+}
+
+// --provenance
+var inspectProvenanceFlag = cmdline.Flag{
+	ID:           "inspectProvenanceFlag",
+	Value:        &provenance,
+	DefaultValue: false,
+	Name:         "provenance",
+	Usage:        "show the builder version, definition digest and base images of the build",
+}
+
+// --artifacts
+var inspectArtifactsFlag = cmdline.Flag{
+	ID:           "inspectArtifactsFlag",
+	Value:        &artifacts,
+	DefaultValue: false,
+	Name:         "artifacts",
+	Usage:        "list the artifacts added with sif add --artifact",
+}
+
 // --all
 var inspectAllFlag = cmdline.Flag{
 	ID:           "inspectAllFlag",
@@ -167,6 +211,10 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&inspectTestFlag, InspectCmd)
 		cmdManager.RegisterFlagForCmd(&inspectAppsListFlag, InspectCmd)
 		cmdManager.RegisterFlagForCmd(&inspectAllFlag, InspectCmd)
+		cmdManager.RegisterFlagForCmd(&inspectSBOMFlag, InspectCmd)
+		cmdManager.RegisterFlagForCmd(&inspectBuildLogFlag, InspectCmd)
+		cmdManager.RegisterFlagForCmd(&inspectProvenanceFlag, InspectCmd)
+		cmdManager.RegisterFlagForCmd(&inspectArtifactsFlag, InspectCmd)
 	})
 }
 
@@ -270,6 +318,18 @@ func (c *command) setAttribute(section, value, file string) error {
 		}
 	case "startscript":
 		c.metadata.Data.Attributes.Startscript = value
+	case "app":
+		var meta struct {
+			Dependencies []string        `json:"dependencies"`
+			Environment  json.RawMessage `json:"environment"`
+		}
+		if err := json.Unmarshal([]byte(value), &meta); err != nil {
+			sylog.Warningf("Unable to parse app metadata: %s", err)
+		}
+		if app != "" {
+			c.metadata.Data.Attributes.Apps[app].Dependencies = meta.Dependencies
+			c.metadata.Data.Attributes.Apps[app].EnvironmentVariables = meta.Environment
+		}
 	case "environment":
 		if app != "" {
 			c.metadata.Data.Attributes.Apps[app].Environment[file] = value
@@ -387,6 +447,10 @@ func (c *command) addLabelsCommand() {
 	c.addSingleFileCommand("labels.json", "labels")
 }
 
+func (c *command) addAppMetadataCommand() {
+	c.addSingleFileCommand(types.AppMetadataFile, "app")
+}
+
 func (c *command) addRunscriptCommand() {
 	c.addSingleFileCommand("runscript", "runscript")
 }
@@ -471,6 +535,154 @@ func inspectDeffilePartition(img *image.Image) (string, error) {
 	return string(data), nil
 }
 
+// inspectSBOM returns the software bill of materials of the image, it is
+// read from its SIF descriptor or from the sandbox metadata.
+func inspectSBOM(img *image.Image) ([]byte, error) {
+	b, err := inspectObject(img, types.SBOMFile, sif.DataGenericJSON, types.SBOMJSON+".json")
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no SBOM found in %s, it must be built with --sbom", img.Path)
+	}
+	return b, err
+}
+
+// inspectBuildLog returns the output of the build stored in the image.
+func inspectBuildLog(img *image.Image) ([]byte, error) {
+	b, err := inspectObject(img, types.BuildLogFile, sif.DataGeneric, types.BuildLogObject)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no build log found in %s, it was built without it or with --no-build-log", img.Path)
+	}
+	return b, err
+}
+
+// inspectProvenance returns the build provenance of the image.
+func inspectProvenance(img *image.Image) ([]byte, error) {
+	b, err := inspectObject(img, types.ProvenanceFile, sif.DataGenericJSON, types.ProvenanceJSON+".json")
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no build provenance found in %s", img.Path)
+	}
+	return b, err
+}
+
+// inspectEnvironmentVariables returns the variables of the %environment
+// section recorded at build time.
+func inspectEnvironmentVariables(img *image.Image) ([]byte, error) {
+	return inspectObject(img, types.EnvironmentFile, sif.DataGenericJSON, types.EnvironmentJSON+".json")
+}
+
+// inspectObject returns the metadata object at path of sandboxes or the
+// SIF descriptor of type typ named name, an error satisfying os.IsNotExist
+// is returned if the image has none.
+func inspectObject(img *image.Image, path string, typ sif.Datatype, name string) ([]byte, error) {
+	if img.Type == image.SANDBOX {
+		return ioutil.ReadFile(filepath.Join(img.Path, path))
+	}
+	if img.Type != image.SIF {
+		return nil, errNoSIF
+	}
+
+	for i, section := range img.Sections {
+		if section.Type != uint32(typ) || section.Name != name {
+			continue
+		}
+		r, err := image.NewSectionReader(img, "", i)
+		if err != nil {
+			return nil, fmt.Errorf("while reading SIF section: %s", err)
+		}
+		return ioutil.ReadAll(r)
+	}
+	return nil, os.ErrNotExist
+}
+
+// inspectImageMetadata adds the data objects of SIF images, the SBOM
+// reference, the build provenance and the base images of the build to
+// the attributes attr.
+func inspectImageMetadata(img *image.Image, attr *inspect.Attributes) error {
+	switch img.Type {
+	case image.SIF:
+		fimg, err := sif.LoadContainer(img.Path, true)
+		if err != nil {
+			return fmt.Errorf("while loading SIF image: %s", err)
+		}
+		defer fimg.UnloadContainer()
+
+		attr.SIF, err = inspect.NewSIF(&fimg)
+		if err != nil {
+			return err
+		}
+		attr.SBOM = attr.SIF.Find(sif.DataGenericJSON, types.SBOMJSON+".json")
+	case image.SANDBOX:
+		if b, err := ioutil.ReadFile(filepath.Join(img.Path, types.SBOMFile)); err == nil {
+			attr.SBOM = &inspect.Object{
+				Name:   types.SBOMFile,
+				Size:   int64(len(b)),
+				Digest: digest.FromBytes(b).String(),
+			}
+		}
+	}
+
+	b, err := inspectObject(img, types.ProvenanceFile, sif.DataGenericJSON, types.ProvenanceJSON+".json")
+	if os.IsNotExist(err) || err == errNoSIF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var p struct {
+		Stages []struct {
+			Name      string           `json:"name"`
+			Bootstrap string           `json:"bootstrap"`
+			From      string           `json:"from"`
+			BaseImage *types.BaseImage `json:"baseImage"`
+		} `json:"stages"`
+	}
+	if err := json.Unmarshal(b, &p); err != nil {
+		return fmt.Errorf("while parsing build provenance: %s", err)
+	}
+	attr.Provenance = b
+
+	for _, s := range p.Stages {
+		src := inspect.Source{
+			Stage:     s.Name,
+			Bootstrap: s.Bootstrap,
+			Source:    s.From,
+		}
+		if s.BaseImage != nil {
+			src.Source = s.BaseImage.Source
+			src.Digest = s.BaseImage.Digest
+		}
+		attr.Sources = append(attr.Sources, src)
+	}
+	return nil
+}
+
+// printArtifacts lists the artifacts of the image, in JSON format with
+// --json.
+func printArtifacts(img *image.Image) error {
+	if img.Type != image.SIF {
+		return fmt.Errorf("%s is not a SIF image", img.Path)
+	}
+	list, err := singularity.SifArtifacts(img.Path)
+	if err != nil {
+		return err
+	}
+
+	if jsonfmt {
+		b, err := json.MarshalIndent(list, "", "\t")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", b)
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tGROUP\tMEDIA TYPE\tSIZE\tNAME")
+	for _, a := range list {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%d\t%s\n", a.ID, a.Group, a.MediaType, a.Size, a.Name)
+	}
+	return tw.Flush()
+}
+
 func printSortedApp(m map[string]*inspect.AppAttributes) {
 	sorted := make([]string, 0, len(m))
 	for k := range m {
@@ -515,6 +727,45 @@ var InspectCmd = &cobra.Command{
 			sylog.Fatalf("Failed to open image %s: %s", args[0], err)
 		}
 
+		if sbomfile {
+			b, err := inspectSBOM(img)
+			if err != nil {
+				sylog.Fatalf("While inspecting SBOM: %s", err)
+			}
+			fmt.Printf("%s\n", bytes.TrimRight(b, "\n"))
+			return
+		}
+
+		if buildlog {
+			b, err := inspectBuildLog(img)
+			if err != nil {
+				sylog.Fatalf("While inspecting build log: %s", err)
+			}
+			os.Stdout.Write(b)
+			return
+		}
+
+		if provenance {
+			b, err := inspectProvenance(img)
+			if err != nil {
+				sylog.Fatalf("While inspecting build provenance: %s", err)
+			}
+			fmt.Printf("%s\n", bytes.TrimRight(b, "\n"))
+			return
+		}
+
+		if artifacts {
+			if err := printArtifacts(img); err != nil {
+				sylog.Fatalf("While inspecting artifacts: %s", err)
+			}
+			return
+		}
+
+		// --json alone shows the complete metadata
+		if jsonfmt && AppName == "" && !labels && defaultToLabels() {
+			allData = true
+		}
+
 		if allData {
 			// display all data in JSON format only
 			jsonfmt = true
@@ -569,11 +820,31 @@ var InspectCmd = &cobra.Command{
 			sylog.Debugf("Listing all apps in container")
 		}
 
+		// the dependencies and environment variables of apps are only
+		// part of the JSON output
+		if jsonfmt {
+			inspectCmd.addAppMetadataCommand()
+		}
+
 		inspectData, err := inspectCmd.getMetadata()
 		if err != nil {
 			sylog.Fatalf("%s", err)
 		}
 
+		if (environment || allData) && jsonfmt && AppName == "" {
+			b, err := inspectEnvironmentVariables(img)
+			if err != nil && !os.IsNotExist(err) && err != errNoSIF {
+				sylog.Fatalf("While inspecting environment variables: %s", err)
+			}
+			inspectData.Data.Attributes.EnvironmentVariables = b
+		}
+
+		if allData {
+			if err := inspectImageMetadata(img, &inspectData.Data.Attributes); err != nil {
+				sylog.Fatalf("While inspecting image metadata: %s", err)
+			}
+		}
+
 		for app := range inspectData.Data.Attributes.Apps {
 			if !listApps && !allData && AppName != app {
 				delete(inspectData.Data.Attributes.Apps, app)
@@ -0,0 +1,60 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&instanceCheckpointLeaveRunningFlag, instanceCheckpointCmd)
+		cmdManager.RegisterFlagForCmd(&instanceCheckpointSIFFlag, instanceCheckpointCmd)
+	})
+}
+
+// --leave-running
+var instanceCheckpointLeaveRunning bool
+var instanceCheckpointLeaveRunningFlag = cmdline.Flag{
+	ID:           "instanceCheckpointLeaveRunningFlag",
+	Value:        &instanceCheckpointLeaveRunning,
+	DefaultValue: false,
+	Name:         "leave-running",
+	Usage:        "keep the instance running after the checkpoint",
+	EnvKeys:      []string{"LEAVE_RUNNING"},
+}
+
+// --sif
+var instanceCheckpointSIF bool
+var instanceCheckpointSIFFlag = cmdline.Flag{
+	ID:           "instanceCheckpointSIFFlag",
+	Value:        &instanceCheckpointSIF,
+	DefaultValue: false,
+	Name:         "sif",
+	Usage:        "store the checkpoint in a data object of the instance SIF image",
+	EnvKeys:      []string{"CHECKPOINT_SIF"},
+}
+
+// singularity instance checkpoint
+var instanceCheckpointCmd = &cobra.Command{
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := singularity.CheckpointInstance(args[0], instanceCheckpointLeaveRunning, instanceCheckpointSIF)
+		if err != nil {
+			sylog.Fatalf("Could not checkpoint instance: %v", err)
+		}
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.InstanceCheckpointUse,
+	Short:   docs.InstanceCheckpointShort,
+	Long:    docs.InstanceCheckpointLong,
+	Example: docs.InstanceCheckpointExample,
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&instanceLogsUserFlag, instanceLogsCmd)
+		cmdManager.RegisterFlagForCmd(&instanceLogsFollowFlag, instanceLogsCmd)
+	})
+}
+
+// -u|--user
+var instanceLogsUser string
+var instanceLogsUserFlag = cmdline.Flag{
+	ID:           "instanceLogsUserFlag",
+	Value:        &instanceLogsUser,
+	DefaultValue: "",
+	Name:         "user",
+	ShortHand:    "u",
+	Usage:        `if running as root, display logs of instance from "<username>"`,
+	Tag:          "<username>",
+	EnvKeys:      []string{"USER"},
+}
+
+// -f|--follow
+var instanceLogsFollow bool
+var instanceLogsFollowFlag = cmdline.Flag{
+	ID:           "instanceLogsFollowFlag",
+	Value:        &instanceLogsFollow,
+	DefaultValue: false,
+	Name:         "follow",
+	ShortHand:    "f",
+	Usage:        "keep displaying new log output until the instance exits",
+	EnvKeys:      []string{"FOLLOW"},
+}
+
+// singularity instance logs
+var instanceLogsCmd = &cobra.Command{
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		uid := os.Getuid()
+		if instanceLogsUser != "" && uid != 0 {
+			sylog.Fatalf("Only root user can display user's instance logs")
+		}
+
+		err := singularity.PrintInstanceLogs(os.Stdout, os.Stderr, args[0], instanceLogsUser, instanceLogsFollow)
+		if err != nil {
+			sylog.Fatalf("Could not display instance logs: %v", err)
+		}
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.InstanceLogsUse,
+	Short:   docs.InstanceLogsShort,
+	Long:    docs.InstanceLogsLong,
+	Example: docs.InstanceLogsExample,
+}
@@ -0,0 +1,29 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// singularity instance restore
+var instanceRestoreCmd = &cobra.Command{
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := singularity.RestoreInstance(args[0]); err != nil {
+			sylog.Fatalf("Could not restore instance: %v", err)
+		}
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:     docs.InstanceRestoreUse,
+	Short:   docs.InstanceRestoreShort,
+	Long:    docs.InstanceRestoreLong,
+	Example: docs.InstanceRestoreExample,
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// --size
+var overlaySize int
+var overlaySizeFlag = cmdline.Flag{
+	ID:           "overlaySizeFlag",
+	Value:        &overlaySize,
+	DefaultValue: 64,
+	Name:         "size",
+	ShortHand:    "s",
+	Usage:        "size of the overlay image in MiB",
+}
+
+// --sparse
+var overlaySparse bool
+var overlaySparseFlag = cmdline.Flag{
+	ID:           "overlaySparseFlag",
+	Value:        &overlaySparse,
+	DefaultValue: false,
+	Name:         "sparse",
+	Usage:        "don't allocate the unused blocks of the overlay image",
+}
+
+// --fakeroot
+var overlayFakeroot bool
+var overlayFakerootFlag = cmdline.Flag{
+	ID:           "overlayFakerootFlag",
+	Value:        &overlayFakeroot,
+	DefaultValue: false,
+	Name:         "fakeroot",
+	ShortHand:    "f",
+	Usage:        "make the overlay directories owned by root to use the overlay with --fakeroot",
+}
+
+// --create-dir
+var overlayDirs []string
+var overlayDirsFlag = cmdline.Flag{
+	ID:           "overlayDirsFlag",
+	Value:        &overlayDirs,
+	DefaultValue: []string{},
+	Name:         "create-dir",
+	Usage:        "directory to create in the overlay, can be specified multiple times",
+}
+
+// --encryption-key
+var overlayEncryptionKeys []string
+var overlayEncryptionKeyFlag = cmdline.Flag{
+	ID:           "overlayEncryptionKeyFlag",
+	Value:        &overlayEncryptionKeys,
+	DefaultValue: []string{},
+	Name:         "encryption-key",
+	Usage:        "encrypt the overlay with a passphrase, the source is env:<variable>, keyring:<key> or passphrase to prompt for it. Multiple passphrases can be given by a comma separated list",
+	Tag:          "<source>",
+}
+
+// --export
+var overlayExport bool
+var overlayExportFlag = cmdline.Flag{
+	ID:           "overlayExportFlag",
+	Value:        &overlayExport,
+	DefaultValue: false,
+	Name:         "export",
+	Usage:        "write the overlay image from the overlay partition of the SIF image",
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(OverlayCmd)
+		cmdManager.RegisterSubCmd(OverlayCmd, OverlayCreateCmd)
+		cmdManager.RegisterSubCmd(OverlayCmd, OverlayResizeCmd)
+		cmdManager.RegisterSubCmd(OverlayCmd, OverlaySyncCmd)
+
+		cmdManager.RegisterFlagForCmd(&overlaySizeFlag, OverlayCreateCmd, OverlayResizeCmd)
+		cmdManager.RegisterFlagForCmd(&overlaySparseFlag, OverlayCreateCmd, OverlayResizeCmd, OverlaySyncCmd)
+		cmdManager.RegisterFlagForCmd(&overlayFakerootFlag, OverlayCreateCmd)
+		cmdManager.RegisterFlagForCmd(&overlayDirsFlag, OverlayCreateCmd)
+		cmdManager.RegisterFlagForCmd(&overlayEncryptionKeyFlag, OverlayCreateCmd)
+		cmdManager.RegisterFlagForCmd(&overlayExportFlag, OverlaySyncCmd)
+	})
+}
+
+func overlayOptions() singularity.OverlayOptions {
+	return singularity.OverlayOptions{
+		Sparse:   overlaySparse,
+		Fakeroot: overlayFakeroot,
+		Dirs:     overlayDirs,
+	}
+}
+
+// OverlayCmd is 'singularity overlay' and manages the writable overlay
+// images.
+var OverlayCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.OverlayUse,
+	Short:         docs.OverlayShort,
+	Long:          docs.OverlayLong,
+	Example:       docs.OverlayExample,
+	SilenceErrors: true,
+}
+
+// OverlayCreateCmd is 'singularity overlay create' and creates an overlay
+// image or embeds one in a SIF image.
+var OverlayCreateCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := overlayOptions()
+		for _, source := range overlayEncryptionKeys {
+			k, err := encryptionKeyProvider(cmd.Context(), source)
+			if err != nil {
+				sylog.Fatalf("While handling %s: %v", source, err)
+			}
+			opts.EncryptionKeys = append(opts.EncryptionKeys, k)
+		}
+		if err := singularity.OverlayCreate(args[0], overlaySize, opts); err != nil {
+			sylog.Fatalf("Unable to create overlay: %v", err)
+		}
+	},
+
+	Use:     docs.OverlayCreateUse,
+	Short:   docs.OverlayCreateShort,
+	Long:    docs.OverlayCreateLong,
+	Example: docs.OverlayCreateExample,
+}
+
+// OverlayResizeCmd is 'singularity overlay resize' and resizes an overlay
+// image or the overlay partition of a SIF image.
+var OverlayResizeCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if !cmd.Flags().Changed(overlaySizeFlag.Name) {
+			sylog.Fatalf("The new size of the overlay must be set with --size")
+		}
+		if err := singularity.OverlayResize(args[0], overlaySize, overlayOptions()); err != nil {
+			sylog.Fatalf("Unable to resize overlay: %v", err)
+		}
+	},
+
+	Use:     docs.OverlayResizeUse,
+	Short:   docs.OverlayResizeShort,
+	Long:    docs.OverlayResizeLong,
+	Example: docs.OverlayResizeExample,
+}
+
+// OverlaySyncCmd is 'singularity overlay sync' and copies an overlay image
+// to or from the overlay partition of a SIF image.
+var OverlaySyncCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := singularity.OverlaySync(args[0], args[1], overlayExport, overlayOptions()); err != nil {
+			sylog.Fatalf("Unable to synchronize overlay: %v", err)
+		}
+	},
+
+	Use:     docs.OverlaySyncUse,
+	Short:   docs.OverlaySyncShort,
+	Long:    docs.OverlaySyncLong,
+	Example: docs.OverlaySyncExample,
+}
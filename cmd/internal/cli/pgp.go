@@ -93,6 +93,25 @@ func decryptSelectedEntityInteractive(f sypgp.EntitySelector) sypgp.EntitySelect
 	}
 }
 
+// decryptSelectedEntity wraps f, decrypting the private key in the selected entity with
+// passphrase.
+func decryptSelectedEntity(f sypgp.EntitySelector, passphrase string) sypgp.EntitySelector {
+	return func(el openpgp.EntityList) (*openpgp.Entity, error) {
+		e, err := f(el)
+		if err != nil {
+			return nil, err
+		}
+
+		if e.PrivateKey.Encrypted {
+			if err := e.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+				return nil, err
+			}
+		}
+
+		return e, nil
+	}
+}
+
 // decryptPrivateKeyInteractive decrypts the private key in e, prompting the user for a passphrase.
 func decryptPrivateKeyInteractive(e *openpgp.Entity) error {
 	passphrase, err := interactive.AskQuestionNoEcho("Enter key passphrase : ")
@@ -188,6 +207,15 @@ func outputVerify(f *sif.FileImage, r integrity.VerifyResult) bool {
 	return false
 }
 
+// outputVerifyGroup outputs the verification result of the object group to stdout.
+func outputVerifyGroup(groupID uint32, err error) {
+	if err != nil {
+		fmt.Printf("%-18v Object group %d: %v\n\n", color.New(color.FgRed).Sprint("[FAILED]"), groupID, err)
+		return
+	}
+	fmt.Printf("%-18v Object group %d\n\n", color.New(color.FgGreen).Sprint("[VERIFIED]"), groupID)
+}
+
 type key struct {
 	Signer keyEntity
 }
@@ -202,10 +230,18 @@ type keyEntity struct {
 	DataCheck   bool
 }
 
+// groupResult holds the verification result of an object group, used for json output.
+type groupResult struct {
+	Group    uint32
+	Verified bool
+	Error    string `json:",omitempty"`
+}
+
 // keyList is a list of one or more keys.
 type keyList struct {
 	Signatures int
 	SignerKeys []*key
+	Groups     []groupResult `json:",omitempty"`
 }
 
 // getJSONCallback returns a singularity.VerifyCallback that appends to kl.
@@ -269,6 +305,17 @@ func getJSONCallback(kl *keyList) singularity.VerifyCallback {
 	}
 }
 
+// getJSONGroupCallback returns a singularity.VerifyGroupCallback that appends to kl.
+func getJSONGroupCallback(kl *keyList) singularity.VerifyGroupCallback {
+	return func(groupID uint32, err error) {
+		r := groupResult{Group: groupID, Verified: err == nil}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		kl.Groups = append(kl.Groups, r)
+	}
+}
+
 // outputJSON outputs a JSON representation of kl to w.
 func outputJSON(w io.Writer, kl keyList) error {
 	e := json.NewEncoder(w)
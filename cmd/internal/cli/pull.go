@@ -39,6 +39,11 @@ const (
 	HTTPSProtocol = "https"
 	// OrasProtocol holds the oras URI.
 	OrasProtocol = "oras"
+	// DockerProtocol holds the docker URI, SIF images are pushed to
+	// docker registries as with oras.
+	DockerProtocol = "docker"
+	// pullAllArch is the --arch value pulling all architectures.
+	pullAllArch = "all"
 )
 
 var (
@@ -63,7 +68,7 @@ var pullArchFlag = cmdline.Flag{
 	Value:        &pullArch,
 	DefaultValue: runtime.GOARCH,
 	Name:         "arch",
-	Usage:        "architecture to pull from library",
+	Usage:        "architecture to pull from library, or all to assemble the images of all architectures",
 	EnvKeys:      []string{"PULL_ARCH"},
 }
 
@@ -215,9 +220,13 @@ func pullRun(cmd *cobra.Command, args []string) {
 			Logger:    (golog.Logger)(sylog.DebugLogger{}),
 		}
 
-		_, err = library.PullToFile(ctx, imgCache, pullTo, pullFrom, pullArch, tmpDir, libraryConfig, keyServerURL)
+		if pullArch == pullAllArch {
+			_, err = library.PullAllArchToFile(ctx, imgCache, pullTo, pullFrom, tmpDir, libraryConfig, keyServerURL)
+		} else {
+			_, err = library.PullToFile(ctx, imgCache, pullTo, pullFrom, pullArch, tmpDir, libraryConfig, keyServerURL)
+		}
 		if err != nil && err != library.ErrLibraryPullUnsigned {
-			sylog.Fatalf("While pulling library image: %v", err)
+			sylog.Fatalf("While pulling library image: %v", sylog.WithCode(sylog.PullFailed, err))
 		}
 		if err == library.ErrLibraryPullUnsigned {
 			sylog.Warningf("Skipping container verification")
@@ -225,7 +234,7 @@ func pullRun(cmd *cobra.Command, args []string) {
 	case ShubProtocol:
 		_, err := shub.PullToFile(ctx, imgCache, pullTo, pullFrom, tmpDir, noHTTPS)
 		if err != nil {
-			sylog.Fatalf("While pulling shub image: %v\n", err)
+			sylog.Fatalf("While pulling shub image: %v\n", sylog.WithCode(sylog.PullFailed, err))
 		}
 	case OrasProtocol:
 		ociAuth, err := makeDockerCredentials(cmd)
@@ -235,12 +244,12 @@ func pullRun(cmd *cobra.Command, args []string) {
 
 		_, err = oras.PullToFile(ctx, imgCache, pullTo, pullFrom, tmpDir, ociAuth)
 		if err != nil {
-			sylog.Fatalf("While pulling image from oci registry: %v", err)
+			sylog.Fatalf("While pulling image from oci registry: %v", sylog.WithCode(sylog.PullFailed, err))
 		}
 	case HTTPProtocol, HTTPSProtocol:
 		_, err := net.PullToFile(ctx, imgCache, pullTo, pullFrom, tmpDir)
 		if err != nil {
-			sylog.Fatalf("While pulling from image from http(s): %v\n", err)
+			sylog.Fatalf("While pulling from image from http(s): %v\n", sylog.WithCode(sylog.PullFailed, err))
 		}
 	case oci.IsSupported(transport):
 		ociAuth, err := makeDockerCredentials(cmd)
@@ -250,7 +259,7 @@ func pullRun(cmd *cobra.Command, args []string) {
 
 		_, err = oci.PullToFile(ctx, imgCache, pullTo, pullFrom, tmpDir, ociAuth, noHTTPS, buildArgs.noCleanUp)
 		if err != nil {
-			sylog.Fatalf("While making image from oci registry: %v", err)
+			sylog.Fatalf("While making image from oci registry: %v", sylog.WithCode(sylog.PullFailed, err))
 		}
 	default:
 		sylog.Fatalf("Unsupported transport type: %s", transport)
@@ -89,7 +89,7 @@ var PushCmd = &cobra.Command{
 			} else if err != nil {
 				sylog.Fatalf("Unable to push image to library: %v", err)
 			}
-		case OrasProtocol:
+		case OrasProtocol, DockerProtocol:
 			ociAuth, err := makeDockerCredentials(cmd)
 			if err != nil {
 				sylog.Fatalf("Unable to make docker oci credentials: %s", err)
@@ -8,11 +8,14 @@ package cli
 import (
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/docs"
 	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/internal/pkg/build/remotebuilder"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	scs "github.com/sylabs/singularity/internal/pkg/remote"
 	"github.com/sylabs/singularity/pkg/cmdline"
 	"github.com/sylabs/singularity/pkg/syfs"
 	"github.com/sylabs/singularity/pkg/sylog"
@@ -28,6 +31,8 @@ var (
 	remoteConfig   string
 	remoteNoLogin  bool
 	global         bool
+	builderType    string
+	builderURI     string
 )
 
 // assemble values of remoteConfig for user/sys locations
@@ -72,6 +77,24 @@ var remoteNoLoginFlag = cmdline.Flag{
 	Usage:        "skip automatic login step",
 }
 
+// --builder-type
+var remoteBuilderTypeFlag = cmdline.Flag{
+	ID:           "remoteBuilderTypeFlag",
+	Value:        &builderType,
+	DefaultValue: "",
+	Name:         "builder-type",
+	Usage:        "type of the remote build service of the endpoint (" + strings.Join(remotebuilder.Backends(), ", ") + ")",
+}
+
+// --builder-uri
+var remoteBuilderURIFlag = cmdline.Flag{
+	ID:           "remoteBuilderURIFlag",
+	Value:        &builderURI,
+	DefaultValue: "",
+	Name:         "builder-uri",
+	Usage:        "URI of the remote build service of the endpoint, instead of the endpoint build service",
+}
+
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterCmd(RemoteCmd)
@@ -90,6 +113,9 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&remoteGlobalFlag, RemoteAddCmd, RemoteRemoveCmd, RemoteUseCmd)
 		// add --no-login flag to add command
 		cmdManager.RegisterFlagForCmd(&remoteNoLoginFlag, RemoteAddCmd)
+		// add --builder-type and --builder-uri flags to add command
+		cmdManager.RegisterFlagForCmd(&remoteBuilderTypeFlag, RemoteAddCmd)
+		cmdManager.RegisterFlagForCmd(&remoteBuilderURIFlag, RemoteAddCmd)
 	})
 }
 
@@ -127,7 +153,15 @@ var RemoteAddCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		name := args[0]
 		uri := args[1]
-		if err := singularity.RemoteAdd(remoteConfig, name, uri, global); err != nil {
+
+		var builder *scs.Builder
+		if builderType != "" || builderURI != "" {
+			if !remotebuilder.IsBackend(builderType) {
+				sylog.Fatalf("Unknown remote build service type %q, available types are: %s", builderType, strings.Join(remotebuilder.Backends(), ", "))
+			}
+			builder = &scs.Builder{Type: builderType, URI: builderURI}
+		}
+		if err := singularity.RemoteAdd(remoteConfig, name, uri, global, builder); err != nil {
 			sylog.Fatalf("%s", err)
 		}
 		sylog.Infof("Remote %q added.", name)
@@ -0,0 +1,116 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// --artifact
+var sifAddArtifact string
+var sifAddArtifactFlag = cmdline.Flag{
+	ID:           "sifAddArtifactFlag",
+	Value:        &sifAddArtifact,
+	DefaultValue: "",
+	Name:         "artifact",
+	Usage:        "add a file as an artifact of this media type, the arguments are then <file> <containerfile>",
+}
+
+// -o|--output
+var sifExtractOutput string
+var sifExtractOutputFlag = cmdline.Flag{
+	ID:           "sifExtractOutputFlag",
+	Value:        &sifExtractOutput,
+	DefaultValue: "",
+	Name:         "output",
+	ShortHand:    "o",
+	Usage:        "write the artifact to this file instead of the standard output",
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&sifExtractOutputFlag, SifExtractCmd)
+
+		// 'sif add' comes from the siftool package, --artifact is
+		// handled before its own implementation
+		for _, c := range SiftoolCmd.Commands() {
+			if c.Name() != "add" {
+				continue
+			}
+			cmdManager.RegisterFlagForCmd(&sifAddArtifactFlag, c)
+			run := c.RunE
+			c.RunE = func(cmd *cobra.Command, args []string) error {
+				if sifAddArtifact == "" {
+					return run(cmd, args)
+				}
+				return addArtifact(cmd, args[0], args[1])
+			}
+		}
+	})
+}
+
+// addArtifact adds file to image as an artifact of the --artifact media
+// type, the --filename and --groupid flags of 'sif add' set its name
+// and group.
+func addArtifact(cmd *cobra.Command, file, image string) error {
+	name, err := cmd.Flags().GetString("filename")
+	if err != nil {
+		return err
+	}
+	group, err := cmd.Flags().GetInt64("groupid")
+	if err != nil {
+		return err
+	}
+
+	a, err := singularity.SifAddArtifact(image, file, sifAddArtifact, name, uint32(group))
+	if err != nil {
+		return fmt.Errorf("unable to add artifact: %v", err)
+	}
+	sylog.Infof("Added %s as artifact %d of type %s in group %d", a.Name, a.ID, a.MediaType, a.Group)
+	sylog.Infof("It may be signed with: singularity sign --group-id %d %s", a.Group, image)
+	return nil
+}
+
+// SifExtractCmd is 'singularity sif extract' and writes an artifact of
+// a SIF image.
+var SifExtractCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		var w io.Writer = os.Stdout
+		if sifExtractOutput != "" {
+			if fs.IsDir(sifExtractOutput) {
+				sylog.Fatalf("Output %s is a directory", sifExtractOutput)
+			}
+			f, err := os.OpenFile(sifExtractOutput, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+			if err != nil {
+				sylog.Fatalf("Unable to create %s: %v", sifExtractOutput, err)
+			}
+			defer f.Close()
+			w = f
+		}
+
+		a, err := singularity.SifExtractArtifact(args[0], args[1], w)
+		if err != nil {
+			sylog.Fatalf("Unable to extract artifact: %v", err)
+		}
+		sylog.Verbosef("Extracted artifact %d of type %s, digest %s", a.ID, a.MediaType, a.Digest)
+	},
+
+	Use:     docs.SifExtractUse,
+	Short:   docs.SifExtractShort,
+	Long:    docs.SifExtractLong,
+	Example: docs.SifExtractExample,
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// --json
+var sifCheckJSON bool
+var sifCheckJSONFlag = cmdline.Flag{
+	ID:           "sifCheckJSONFlag",
+	Value:        &sifCheckJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print checks in JSON format",
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&sifCheckJSONFlag, SifCheckCmd)
+	})
+}
+
+// SifCheckCmd is 'singularity sif check' and checks the integrity of SIF
+// images without verifying their signatures.
+var SifCheckCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		results := make(map[string]*singularity.SifCheckResult)
+		ok := true
+		for _, path := range args {
+			res, err := singularity.SifCheck(path)
+			if err != nil {
+				// the other images are checked
+				sylog.Errorf("Unable to check image: %v", err)
+				ok = false
+				continue
+			}
+			results[path] = res
+			ok = ok && res.OK()
+
+			if sifCheckJSON {
+				continue
+			}
+			for _, c := range res.Checks {
+				if c.Error != "" {
+					fmt.Printf("%s: %s %s (signature %d): %s\n", path, c.Status, c.Object, c.Signature, c.Error)
+				} else {
+					fmt.Printf("%s: %s %s (signature %d)\n", path, c.Status, c.Object, c.Signature)
+				}
+			}
+			for _, id := range res.Unchecked {
+				fmt.Printf("%s: unchecked object %d (no digest stored)\n", path, id)
+			}
+		}
+
+		if sifCheckJSON {
+			b, err := json.MarshalIndent(results, "", "\t")
+			if err != nil {
+				sylog.Fatalf("While marshaling checks: %v", err)
+			}
+			fmt.Println(string(b))
+		}
+
+		if !ok {
+			os.Exit(1)
+		}
+	},
+
+	Use:     docs.SifCheckUse,
+	Short:   docs.SifCheckShort,
+	Long:    docs.SifCheckLong,
+	Example: docs.SifCheckExample,
+}
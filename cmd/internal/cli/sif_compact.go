@@ -0,0 +1,84 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// --compression
+var sifCompactCompression string
+var sifCompactCompressionFlag = cmdline.Flag{
+	ID:           "sifCompactCompressionFlag",
+	Value:        &sifCompactCompression,
+	DefaultValue: "",
+	Name:         "compression",
+	Usage:        "recompress the squashfs partitions with gzip, zstd, lz4, xz or none, [<partition>=]<algorithm>[:<level>] selects the compression per partition ID or type (rootfs, system, data, overlay)",
+}
+
+// --strip
+var sifCompactStrip bool
+var sifCompactStripFlag = cmdline.Flag{
+	ID:           "sifCompactStripFlag",
+	Value:        &sifCompactStrip,
+	DefaultValue: false,
+	Name:         "strip",
+	Usage:        "remove the build log and the test report",
+}
+
+// --output
+var sifCompactOutput string
+var sifCompactOutputFlag = cmdline.Flag{
+	ID:           "sifCompactOutputFlag",
+	Value:        &sifCompactOutput,
+	DefaultValue: "",
+	Name:         "output",
+	ShortHand:    "o",
+	Usage:        "write the compacted image to this path instead of replacing the image",
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&sifCompactCompressionFlag, SifCompactCmd)
+		cmdManager.RegisterFlagForCmd(&sifCompactStripFlag, SifCompactCmd)
+		cmdManager.RegisterFlagForCmd(&sifCompactOutputFlag, SifCompactCmd)
+	})
+}
+
+// SifCompactCmd is 'singularity sif compact' and rewrites a SIF image
+// without its unused space.
+var SifCompactCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		opts := singularity.SifCompactOptions{
+			Compression: sifCompactCompression,
+			Strip:       sifCompactStrip,
+		}
+		res, err := singularity.SifCompact(args[0], sifCompactOutput, opts)
+		if err != nil {
+			sylog.Fatalf("Unable to compact image: %v", err)
+		}
+
+		for _, name := range res.Removed {
+			sylog.Infof("Removed object %s", name)
+		}
+		for _, group := range res.Unsigned {
+			sylog.Warningf("Removed the signatures of group %d, sign the image again", group&^sif.DescrGroupMask)
+		}
+		sylog.Infof("Image compacted from %d to %d bytes", res.OldSize, res.NewSize)
+	},
+
+	Use:     docs.SifCompactUse,
+	Short:   docs.SifCompactShort,
+	Long:    docs.SifCompactLong,
+	Example: docs.SifCompactExample,
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/pkg/sylog"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// SifDeltaCmd is 'singularity sif delta' and writes the delta between
+// two SIF images to the standard output.
+var SifDeltaCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if terminal.IsTerminal(int(os.Stdout.Fd())) {
+			sylog.Fatalf("Refusing to write a binary delta to a terminal, redirect the output to a file")
+		}
+		if err := singularity.SifDelta(args[0], args[1], os.Stdout); err != nil {
+			sylog.Fatalf("Unable to create delta: %v", err)
+		}
+	},
+
+	Use:     docs.SifDeltaUse,
+	Short:   docs.SifDeltaShort,
+	Long:    docs.SifDeltaLong,
+	Example: docs.SifDeltaExample,
+}
+
+// SifPatchCmd is 'singularity sif patch' and applies a delta written by
+// 'singularity sif delta' to a SIF image.
+var SifPatchCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := singularity.SifPatch(args[0], args[1], args[2]); err != nil {
+			sylog.Fatalf("Unable to apply delta: %v", err)
+		}
+		sylog.Infof("Image %s created", args[2])
+	},
+
+	Use:     docs.SifPatchUse,
+	Short:   docs.SifPatchShort,
+	Long:    docs.SifPatchLong,
+	Example: docs.SifPatchExample,
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// --json
+var sifDiffJSON bool
+var sifDiffJSONFlag = cmdline.Flag{
+	ID:           "sifDiffJSONFlag",
+	Value:        &sifDiffJSON,
+	DefaultValue: false,
+	Name:         "json",
+	ShortHand:    "j",
+	Usage:        "print differences in JSON format",
+}
+
+// --files
+var sifDiffFiles bool
+var sifDiffFilesFlag = cmdline.Flag{
+	ID:           "sifDiffFilesFlag",
+	Value:        &sifDiffFiles,
+	DefaultValue: false,
+	Name:         "files",
+	ShortHand:    "f",
+	Usage:        "compare the files of the primary system partitions",
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterFlagForCmd(&sifDiffJSONFlag, SifDiffCmd)
+		cmdManager.RegisterFlagForCmd(&sifDiffFilesFlag, SifDiffCmd)
+	})
+}
+
+// SifDiffCmd is 'singularity sif diff' and compares two SIF images.
+var SifDiffCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		res, err := singularity.SifDiff(args[0], args[1], sifDiffFiles)
+		if err != nil {
+			sylog.Fatalf("Unable to compare images: %v", err)
+		}
+
+		if sifDiffJSON {
+			b, err := json.MarshalIndent(res, "", "\t")
+			if err != nil {
+				sylog.Fatalf("While marshaling differences: %v", err)
+			}
+			fmt.Println(string(b))
+		} else {
+			for _, o := range res.Objects {
+				printSifDiff(o.Status, o.Object, o.Changes)
+			}
+			for _, f := range res.Files {
+				printSifDiff(f.Status, f.Path, f.Changes)
+			}
+		}
+
+		if !res.Identical() {
+			os.Exit(1)
+		}
+	},
+
+	Use:     docs.SifDiffUse,
+	Short:   docs.SifDiffShort,
+	Long:    docs.SifDiffLong,
+	Example: docs.SifDiffExample,
+}
+
+func printSifDiff(status, name string, changes []singularity.SifFieldChange) {
+	prefix := "~"
+	switch status {
+	case singularity.SifDiffAdded:
+		prefix = "+"
+	case singularity.SifDiffRemoved:
+		prefix = "-"
+	}
+	fmt.Printf("%s %s\n", prefix, name)
+	for _, c := range changes {
+		fmt.Printf("    %s: %s -> %s\n", c.Field, c.Old, c.New)
+	}
+}
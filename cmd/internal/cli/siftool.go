@@ -17,5 +17,11 @@ var SiftoolCmd = siftool.Siftool()
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterCmd(SiftoolCmd)
+		cmdManager.RegisterSubCmd(SiftoolCmd, SifDiffCmd)
+		cmdManager.RegisterSubCmd(SiftoolCmd, SifCheckCmd)
+		cmdManager.RegisterSubCmd(SiftoolCmd, SifDeltaCmd)
+		cmdManager.RegisterSubCmd(SiftoolCmd, SifPatchCmd)
+		cmdManager.RegisterSubCmd(SiftoolCmd, SifCompactCmd)
+		cmdManager.RegisterSubCmd(SiftoolCmd, SifExtractCmd)
 	})
 }
@@ -17,8 +17,10 @@ import (
 )
 
 var (
-	privKey int // -k encryption key (index from 'keys list') specification
-	signAll bool
+	privKey      int // -k encryption key (index from 'keys list') specification
+	signAll      bool
+	signVerity   bool
+	signUnsigned bool
 )
 
 // -g|--group-id
@@ -82,6 +84,24 @@ var signAllFlag = cmdline.Flag{
 	Deprecated:   "now the default behavior",
 }
 
+// --verity
+var signVerityFlag = cmdline.Flag{
+	ID:           "signVerityFlag",
+	Value:        &signVerity,
+	DefaultValue: false,
+	Name:         "verity",
+	Usage:        "add a dm-verity hash tree of the root filesystem before signing it",
+}
+
+// --unsigned-groups
+var signUnsignedGroupsFlag = cmdline.Flag{
+	ID:           "signUnsignedGroupsFlag",
+	Value:        &signUnsigned,
+	DefaultValue: false,
+	Name:         "unsigned-groups",
+	Usage:        "only sign the object groups without signature",
+}
+
 func init() {
 	addCmdInit(func(cmdManager *cmdline.CommandManager) {
 		cmdManager.RegisterCmd(SignCmd)
@@ -92,6 +112,8 @@ func init() {
 		cmdManager.RegisterFlagForCmd(&signSifDescIDFlag, SignCmd)
 		cmdManager.RegisterFlagForCmd(&signKeyIdxFlag, SignCmd)
 		cmdManager.RegisterFlagForCmd(&signAllFlag, SignCmd)
+		cmdManager.RegisterFlagForCmd(&signVerityFlag, SignCmd)
+		cmdManager.RegisterFlagForCmd(&signUnsignedGroupsFlag, SignCmd)
 	})
 }
 
@@ -134,6 +156,16 @@ func doSignCmd(cmd *cobra.Command, cpath string) {
 		opts = append(opts, singularity.OptSignObjects(sifDescID))
 	}
 
+	// Set unsigned groups option, if applicable.
+	if signUnsigned {
+		opts = append(opts, singularity.OptSignUnsignedGroups())
+	}
+
+	// Set dm-verity option, if applicable.
+	if signVerity {
+		opts = append(opts, singularity.OptSignVerity())
+	}
+
 	// Sign the image.
 	fmt.Printf("Signing image: %s\n", cpath)
 	if err := singularity.Sign(cpath, opts...); err != nil {
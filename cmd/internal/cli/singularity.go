@@ -22,6 +22,7 @@ import (
 	ocitypes "github.com/containers/image/v5/types"
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/pkg/audit"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	"github.com/sylabs/singularity/internal/pkg/plugin"
 	scs "github.com/sylabs/singularity/internal/pkg/remote"
@@ -73,6 +74,7 @@ const (
 // singularity command flags
 var (
 	debug   bool
+	trace   bool
 	nocolor bool
 	silent  bool
 	verbose bool
@@ -88,7 +90,16 @@ var singDebugFlag = cmdline.Flag{
 	DefaultValue: false,
 	Name:         "debug",
 	ShortHand:    "d",
-	Usage:        "print debugging information (highest verbosity)",
+	Usage:        "print debugging information",
+}
+
+// --trace
+var singTraceFlag = cmdline.Flag{
+	ID:           "singTraceFlag",
+	Value:        &trace,
+	DefaultValue: false,
+	Name:         "trace",
+	Usage:        "print debugging information and each low level operation like mounts, namespace joins and capability changes (highest verbosity)",
 }
 
 // --nocolor
@@ -252,7 +263,9 @@ func addCmdInit(cmdInit func(*cmdline.CommandManager)) {
 func setSylogMessageLevel() {
 	var level int
 
-	if debug {
+	if trace {
+		level = 6
+	} else if debug {
 		level = 5
 	} else if verbose {
 		level = 4
@@ -265,13 +278,120 @@ func setSylogMessageLevel() {
 	}
 
 	color := true
-	if nocolor || !terminal.IsTerminal(2) {
+	if nocolor || os.Getenv("NO_COLOR") != "" || !isLogTerminal() {
 		color = false
 	}
 
 	sylog.SetLevel(level, color)
 }
 
+// isLogTerminal returns true if messages are written to a terminal,
+// depending on the SINGULARITY_LOG_DESTINATION environment variable.
+func isLogTerminal() bool {
+	switch os.Getenv(envPrefix + "LOG_DESTINATION") {
+	case "", sylog.StderrDestination:
+		return terminal.IsTerminal(2)
+	case sylog.StdoutDestination:
+		return terminal.IsTerminal(1)
+	}
+	return false
+}
+
+// setSylogColor enables or disables colored messages as requested by
+// the color directive of the configuration file, the --nocolor option
+// and the NO_COLOR environment variable take precedence.
+func setSylogColor(config *singularityconf.File) {
+	color := isLogTerminal()
+
+	switch config.Color {
+	case "never":
+		color = false
+	case "always":
+		color = true
+	}
+	if nocolor || os.Getenv("NO_COLOR") != "" {
+		color = false
+	}
+
+	sylog.SetLevel(sylog.GetLevel(), color)
+}
+
+// setAuditLog sets the audit log destination of the configuration
+// file, records are only written by commands running as root.
+func setAuditLog(config *singularityconf.File) {
+	if err := audit.SetDestination(config.AuditLog); err != nil {
+		sylog.Warningf("Audit log disabled: %s", err)
+	}
+}
+
+// setSylogForwarding configures the message forwarding to the system
+// log as requested by the configuration file.
+func setSylogForwarding(config *singularityconf.File) {
+	if config.LogForward == sylog.NoBackend {
+		return
+	}
+
+	level, err := sylog.ParseLevel(config.LogForwardLevel)
+	if err != nil {
+		sylog.Warningf("Bad 'log forward level' configuration, messages won't be forwarded: %s", err)
+		return
+	}
+	if err := sylog.SetForwarding(config.LogForward, level); err != nil {
+		// not fatal, the system logger may be absent
+		sylog.Debugf("Could not enable log forwarding: %s", err)
+	}
+}
+
+// setSylogTimestamps enables message timestamps as requested by the
+// configuration file unless the SINGULARITY_LOG_TIMESTAMPS or the
+// SINGULARITY_LOGTIMESTAMPS environment variables are set.
+func setSylogTimestamps(config *singularityconf.File) {
+	if os.Getenv(envPrefix+"LOG_TIMESTAMPS") != "" || os.Getenv(envPrefix+"LOGTIMESTAMPS") != "" {
+		return
+	}
+	if err := sylog.SetTimestamps(config.LogTimestamps); err != nil {
+		sylog.Warningf("Bad 'log timestamps' configuration: %s", err)
+	}
+}
+
+// setSylogTracing configures the export of spans to an OpenTelemetry
+// collector based on the standard OTLP environment variables or on the
+// otlp endpoint directive.
+func setSylogTracing(config *singularityconf.File) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		endpoint = os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	}
+	if endpoint == "" {
+		endpoint = config.OtlpEndpoint
+	}
+	if endpoint == "" {
+		return
+	}
+
+	if err := sylog.SetOTLPEndpoint(endpoint); err != nil {
+		sylog.Warningf("Spans won't be exported: %s", err)
+	}
+}
+
+// setSylogOutputFile configures the log file either from the
+// SINGULARITY_LOGFILE environment variable or from the configuration
+// file.
+func setSylogOutputFile(config *singularityconf.File) {
+	path := os.Getenv(envPrefix + "LOGFILE")
+	if path == "" {
+		path = config.LogFile
+	}
+	if path == "" {
+		return
+	}
+
+	maxSize := int64(config.LogFileMaxSize) * 1024 * 1024
+	if err := sylog.SetOutputFile(path, maxSize, int(config.LogFileMaxBackups)); err != nil {
+		sylog.Warningf("Messages won't be written to log file: %s", err)
+	}
+}
+
 // handleRemoteConf will make sure your 'remote.yaml' config file
 // is the correct permission.
 func handleRemoteConf(remoteConfFile string) {
@@ -310,7 +430,7 @@ func handleConfDir(confDir string) {
 	}
 }
 
-func persistentPreRun(*cobra.Command, []string) {
+func persistentPreRun(cmd *cobra.Command, args []string) {
 	setSylogMessageLevel()
 	sylog.Debugf("Singularity version: %s", buildcfg.PACKAGE_VERSION)
 
@@ -323,10 +443,21 @@ func persistentPreRun(*cobra.Command, []string) {
 	sylog.Debugf("Parsing configuration file %s", configurationFile)
 	config, err := singularityconf.Parse(configurationFile)
 	if err != nil {
-		sylog.Fatalf("Couldn't not parse configuration file %s: %s", configurationFile, err)
+		sylog.Fatalf("Couldn't not parse configuration file %s: %s", configurationFile, sylog.WithCode(sylog.ConfigInvalid, err))
 	}
 	singularityconf.SetCurrentConfig(config)
 
+	setSylogColor(config)
+	setSylogForwarding(config)
+	setSylogTimestamps(config)
+	setSylogOutputFile(config)
+	setSylogTracing(config)
+	setAuditLog(config)
+
+	// root span of the command, ended and exported by
+	// sylog.Flush once the command terminates
+	sylog.StartSpan(cmd.CommandPath())
+
 	// Handle the config dir (~/.singularity),
 	// then check the remove conf file permission.
 	handleConfDir(syfs.ConfigDir())
@@ -358,6 +489,7 @@ func Init(loadPlugins bool) {
 	}
 
 	cmdManager.RegisterFlagForCmd(&singDebugFlag, singularityCmd)
+	cmdManager.RegisterFlagForCmd(&singTraceFlag, singularityCmd)
 	cmdManager.RegisterFlagForCmd(&singNoColorFlag, singularityCmd)
 	cmdManager.RegisterFlagForCmd(&singSilentFlag, singularityCmd)
 	cmdManager.RegisterFlagForCmd(&singQuietFlag, singularityCmd)
@@ -382,6 +514,15 @@ func Init(loadPlugins bool) {
 		for _, c := range callbacks {
 			c.(clicallback.Command)(cmdManager)
 		}
+
+		hookType := (clicallback.LogHook)(nil)
+		hooks, err := plugin.LoadCallbacks(hookType)
+		if err != nil {
+			sylog.Fatalf("Failed to load plugins callbacks '%T': %s", hookType, err)
+		}
+		for _, h := range hooks {
+			sylog.AddHook(sylog.Hook(h.(clicallback.LogHook)))
+		}
 	}
 
 	// any error reported by command manager is considered as fatal
@@ -449,6 +590,9 @@ func ExecuteSingularity() {
 		}
 	}()
 
+	// report repeated messages suppressed during execution
+	defer sylog.Flush()
+
 	if err := singularityCmd.ExecuteContext(ctx); err != nil {
 		// Find the subcommand to display more useful help, and the correct
 		// subcommand name in messages - i.e. 'run' not 'singularity'
@@ -460,6 +604,10 @@ func ExecuteSingularity() {
 		}
 
 		name := subCmd.Name()
+		if code, ok := sylog.CodeOf(err); ok {
+			sylog.Errorw(err.Error(), "code", code)
+			sylog.Exit(code.ExitCode())
+		}
 		switch err.(type) {
 		case cmdline.FlagError:
 			usage := subCmd.Flags().FlagUsagesWrapped(getColumns())
@@ -473,7 +621,7 @@ func ExecuteSingularity() {
 		}
 		singularityCmd.Printf("Run '%s --help' for more detailed usage information.\n",
 			singularityCmd.CommandPath())
-		os.Exit(1)
+		sylog.Exit(1)
 	}
 }
 
@@ -603,6 +751,7 @@ func sylabsRemote(filepath string) (*scs.EndPoint, error) {
 	if err != nil {
 		return endpoint, err
 	}
+	sylog.AddSecret(endpoint.Token)
 
 	// default remote without token, look for tokenfile to login with
 	if *endpoint == defaultRemote {
@@ -0,0 +1,165 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/docs"
+	"github.com/sylabs/singularity/internal/app/singularity"
+	"github.com/sylabs/singularity/internal/pkg/chunkstore"
+	"github.com/sylabs/singularity/pkg/cmdline"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// --name
+var storeAddName string
+var storeAddNameFlag = cmdline.Flag{
+	ID:           "storeAddNameFlag",
+	Value:        &storeAddName,
+	DefaultValue: "",
+	Name:         "name",
+	ShortHand:    "n",
+	Usage:        "name of the image in the store (default the file name without .sif)",
+}
+
+// --dry-run
+var storePruneDryRun bool
+var storePruneDryRunFlag = cmdline.Flag{
+	ID:           "storePruneDryRunFlag",
+	Value:        &storePruneDryRun,
+	DefaultValue: false,
+	Name:         "dry-run",
+	ShortHand:    "n",
+	Usage:        "show the space freed without removing chunks",
+}
+
+func init() {
+	addCmdInit(func(cmdManager *cmdline.CommandManager) {
+		cmdManager.RegisterCmd(StoreCmd)
+		cmdManager.RegisterSubCmd(StoreCmd, StoreAddCmd)
+		cmdManager.RegisterSubCmd(StoreCmd, StoreListCmd)
+		cmdManager.RegisterSubCmd(StoreCmd, StoreExtractCmd)
+		cmdManager.RegisterSubCmd(StoreCmd, StoreRemoveCmd)
+		cmdManager.RegisterSubCmd(StoreCmd, StorePruneCmd)
+
+		cmdManager.RegisterFlagForCmd(&storeAddNameFlag, StoreAddCmd)
+		cmdManager.RegisterFlagForCmd(&storePruneDryRunFlag, StorePruneCmd)
+	})
+}
+
+func openStore() *chunkstore.Store {
+	s, err := chunkstore.Open(chunkstore.DefaultDir())
+	if err != nil {
+		sylog.Fatalf("Unable to open the chunk store: %v", err)
+	}
+	return s
+}
+
+// StoreCmd is 'singularity store' and manages the local chunk store.
+var StoreCmd = &cobra.Command{
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return errors.New("invalid command")
+	},
+	DisableFlagsInUseLine: true,
+
+	Use:           docs.StoreUse,
+	Short:         docs.StoreShort,
+	Long:          docs.StoreLong,
+	Example:       docs.StoreExample,
+	SilenceErrors: true,
+}
+
+// StoreAddCmd is 'singularity store add' and adds an image to the store.
+var StoreAddCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		name := storeAddName
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(args[0]), ".sif")
+		}
+		if err := singularity.StoreAdd(openStore(), name, args[0]); err != nil {
+			sylog.Fatalf("Unable to add %s to the store: %v", args[0], err)
+		}
+	},
+
+	Use:     docs.StoreAddUse,
+	Short:   docs.StoreAddShort,
+	Long:    docs.StoreAddLong,
+	Example: docs.StoreAddExample,
+}
+
+// StoreListCmd is 'singularity store list' and lists the images of the
+// store.
+var StoreListCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := singularity.StoreList(openStore()); err != nil {
+			sylog.Fatalf("Unable to list the store: %v", err)
+		}
+	},
+
+	Use:     docs.StoreListUse,
+	Short:   docs.StoreListShort,
+	Long:    docs.StoreListLong,
+	Example: docs.StoreListExample,
+}
+
+// StoreExtractCmd is 'singularity store extract' and reconstructs an
+// image of the store.
+var StoreExtractCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := openStore().Extract(args[0], args[1]); err != nil {
+			sylog.Fatalf("Unable to extract %s: %v", args[0], err)
+		}
+	},
+
+	Use:     docs.StoreExtractUse,
+	Short:   docs.StoreExtractShort,
+	Long:    docs.StoreExtractLong,
+	Example: docs.StoreExtractExample,
+}
+
+// StoreRemoveCmd is 'singularity store remove' and removes an image of
+// the store.
+var StoreRemoveCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := openStore().Remove(args[0]); err != nil {
+			sylog.Fatalf("Unable to remove %s: %v", args[0], err)
+		}
+	},
+
+	Use:     docs.StoreRemoveUse,
+	Short:   docs.StoreRemoveShort,
+	Long:    docs.StoreRemoveLong,
+	Example: docs.StoreRemoveExample,
+}
+
+// StorePruneCmd is 'singularity store prune' and removes the chunks no
+// longer referenced.
+var StorePruneCmd = &cobra.Command{
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := singularity.StorePrune(openStore(), storePruneDryRun); err != nil {
+			sylog.Fatalf("Unable to prune the store: %v", err)
+		}
+	},
+
+	Use:     docs.StorePruneUse,
+	Short:   docs.StorePruneShort,
+	Long:    docs.StorePruneLong,
+	Example: docs.StorePruneExample,
+}
@@ -192,6 +192,7 @@ func doVerifyCmd(cmd *cobra.Command, cpath string) {
 		var kl keyList
 
 		opts = append(opts, singularity.OptVerifyCallback(getJSONCallback(&kl)))
+		opts = append(opts, singularity.OptVerifyGroupCallback(getJSONGroupCallback(&kl)))
 
 		verifyErr := singularity.Verify(cmd.Context(), cpath, opts...)
 
@@ -205,6 +206,7 @@ func doVerifyCmd(cmd *cobra.Command, cpath string) {
 		}
 	} else {
 		opts = append(opts, singularity.OptVerifyCallback(outputVerify))
+		opts = append(opts, singularity.OptVerifyGroupCallback(outputVerifyGroup))
 
 		fmt.Printf("Verifying image: %s\n", cpath)
 
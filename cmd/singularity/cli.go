@@ -7,14 +7,28 @@ package main
 
 import (
 	"github.com/sylabs/singularity/cmd/internal/cli"
+	"github.com/sylabs/singularity/internal/app/singularity"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/client/net"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/erofs"
 	_ "github.com/sylabs/singularity/internal/pkg/util/goversion"
+	"github.com/sylabs/singularity/internal/pkg/util/userns"
 	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
 )
 
 func main() {
+	// processes re-executed in a user namespace by unprivileged builds
+	userns.Child()
+
 	useragent.InitValue(buildcfg.PACKAGE_NAME, buildcfg.PACKAGE_VERSION)
 
+	// processes serving the http(s) images run with --lazy
+	net.LazyChild()
+	// processes running erofsfuse for erofs images
+	erofs.FuseChild()
+	// processes restoring instance checkpoints
+	singularity.RestoreChild()
+
 	// In cmd/internal/cli/singularity.go
 	cli.ExecuteSingularity()
 }
@@ -30,6 +30,7 @@
 #define VERBOSE2 3
 #define VERBOSE3 4
 #define DEBUG 5
+#define TRACE 6
 #define NO_COLOR 90
 
 #define ANSI_COLOR_RED          "\x1b[31m"
@@ -49,6 +50,14 @@
 #define ANSI_COLOR_RESET        "\x1b[0m"
 
 #define MSGLVL_ENV              "SINGULARITY_MESSAGELEVEL"
+#define LOGFORWARD_ENV          "SINGULARITY_LOGFORWARD"
+#define LOGFILE_ENV             "SINGULARITY_LOGFILE"
+#define LOGFILE_ROTATE_ENV      "SINGULARITY_LOGFILE_ROTATE"
+#define LOGTIMESTAMPS_ENV       "SINGULARITY_LOGTIMESTAMPS"
+#define LOGRELAY_ENV            "SINGULARITY_LOGRELAY"
+#define COLORS_ENV              "SINGULARITY_COLORS"
+#define LOGFORMAT_ENV           "SINGULARITY_LOG_FORMAT"
+#define LOGDESTINATION_ENV      "SINGULARITY_LOG_DESTINATION"
 
 void _print(int level, const char *function, const char *file, char *format, ...) __attribute__ ((__format__(printf, 4, 5)));
 
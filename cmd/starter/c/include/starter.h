@@ -15,6 +15,7 @@
 #define fatalf(b...)     singularity_message(ERROR, b); \
                          exit(1)
 #define debugf(b...)     singularity_message(DEBUG, b)
+#define tracef(b...)     singularity_message(TRACE, b)
 #define verbosef(b...)   singularity_message(VERBOSE, b)
 #define warningf(b...)   singularity_message(WARNING, b)
 #define errorf(b...)     singularity_message(ERROR, b)
@@ -41,7 +42,8 @@ enum goexec {
     STAGE1      = 1,
     STAGE2      = 2,
     MASTER      = 3,
-    RPC_SERVER  = 4
+    RPC_SERVER  = 4,
+    STAGE1_FAILURE = 5
 };
 
 #ifndef NS_CLONE_NEWPID
@@ -154,6 +156,8 @@ struct starter {
     bool masterPropagateMount;
     /* hybrid workflow where master process and container doesn't share user namespace */
     bool hybridWorkflow;
+    /* stage 1 failed after writing back the engine configuration */
+    bool stage1Failure;
 };
 
 /* engine configuration */
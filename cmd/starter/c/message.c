@@ -26,11 +26,29 @@
 #include <string.h>
 #include <stdarg.h>
 #include <libgen.h>
+#include <sys/socket.h>
+#include <sys/stat.h>
 
 #include "include/message.h"
 
 int messagelevel = -99;
 
+/* relay socket file descriptor, -2 when not yet initialized */
+static int relayfd = -2;
+
+static char has_color = 1;
+
+/*
+ * ANSI SGR parameters of the level colors indexed by level - ABRT,
+ * they can be overridden with the COLORS_ENV environment variable
+ */
+static char level_colors[TRACE - ABRT + 1][16] = {
+    [ABRT - ABRT] = "31",
+    [ERROR - ABRT] = "91",
+    [WARNING - ABRT] = "33",
+    [LOG - ABRT] = "34",
+};
+
 extern const char *__progname;
 
 int count_digit(int n) {
@@ -45,13 +63,132 @@ int count_digit(int n) {
     return count;
 }
 
+static int get_relay_fd(void) {
+    if ( relayfd == -2 ) {
+        char *relayfd_string = getenv(LOGRELAY_ENV);
+        struct stat st;
+
+        relayfd = -1;
+        if ( relayfd_string != NULL && relayfd_string[0] != '\0' ) {
+            int fd = atoi(relayfd_string);
+            if ( fd > 2 && fstat(fd, &st) == 0 && S_ISSOCK(st.st_mode) ) {
+                relayfd = fd;
+            }
+        }
+    }
+    return relayfd;
+}
+
+/*
+ * relay_message sends the message as a JSON record to the parent
+ * process, it returns -1 if messages are not relayed or if the
+ * parent process doesn't read them anymore.
+ */
+static int relay_message(int level, const char *function, const char *message) {
+    char record[2048];
+    int i, length;
+    int fd = get_relay_fd();
+
+    if ( fd < 0 ) {
+        return -1;
+    }
+    if ( function[0] == '_' ) {
+        function++;
+    }
+
+    length = snprintf(record, sizeof(record), "{\"level\":%d,\"uid\":%d,\"pid\":%d,\"func\":\"%s()\",\"msg\":\"", level, geteuid(), getpid(), function);
+    if ( length < 0 || length >= (int)sizeof(record) ) {
+        return -1;
+    }
+
+    for ( i = 0; message[i] != '\0' && length < (int)sizeof(record) - 16; i++ ) {
+        unsigned char c = message[i];
+
+        if ( c == '\n' && message[i+1] == '\0' ) {
+            break;
+        } else if ( c == '"' || c == '\\' ) {
+            record[length++] = '\\';
+            record[length++] = c;
+        } else if ( c == '\n' ) {
+            record[length++] = '\\';
+            record[length++] = 'n';
+        } else if ( c < 0x20 ) {
+            length += snprintf(record+length, sizeof(record)-length, "\\u%04x", c);
+        } else {
+            record[length++] = c;
+        }
+    }
+    length += snprintf(record+length, sizeof(record)-length, "\"}\n");
+
+    if ( send(fd, record, length, MSG_NOSIGNAL) != length ) {
+        relayfd = -1;
+        return -1;
+    }
+    return 0;
+}
+
+static int color_level(const char *name) {
+    static const struct {
+        const char *name;
+        int level;
+    } levels[] = {
+        {"fatal", ABRT},
+        {"error", ERROR},
+        {"warn", WARNING},
+        {"warning", WARNING},
+        {"log", LOG},
+        {"info", INFO},
+        {"verbose", VERBOSE},
+        {"debug", DEBUG},
+        {"trace", TRACE},
+        {NULL, 0},
+    };
+    int i;
+
+    for ( i = 0; levels[i].name != NULL; i++ ) {
+        if ( strcmp(levels[i].name, name) == 0 ) {
+            return levels[i].level;
+        }
+    }
+    return 0;
+}
+
+/*
+ * set_colors parses a colon separated list of level=SGR pairs
+ * (eg: "error=1;31:warning=35"), invalid pairs are ignored
+ */
+static void set_colors(const char *spec) {
+    char colors[256];
+    char *saveptr = NULL;
+    char *pair;
+
+    if ( snprintf(colors, sizeof(colors), "%s", spec) >= (int)sizeof(colors) ) {
+        return;
+    }
+
+    for ( pair = strtok_r(colors, ":", &saveptr); pair != NULL; pair = strtok_r(NULL, ":", &saveptr) ) {
+        char *sgr = strchr(pair, '=');
+        int level;
+
+        if ( sgr == NULL ) {
+            continue;
+        }
+        *sgr++ = '\0';
+
+        level = color_level(pair);
+        if ( level == 0 || strlen(sgr) >= sizeof(level_colors[0]) || strspn(sgr, "0123456789;") != strlen(sgr) ) {
+            continue;
+        }
+        strcpy(level_colors[level - ABRT], sgr);
+    }
+}
+
 void _print(int level, const char *function, const char *file_in, char *format, ...) {
     const char *file = file_in;
     char message[512];
+    char color[24] = "";
     char *prefix = NULL;
-    char *color = NULL;
-    char *color_reset = NULL;
-    char has_color = 1;
+    char *color_reset = "";
     va_list args;
 
     if ( messagelevel == -99 ) {
@@ -74,6 +211,9 @@ void _print(int level, const char *function, const char *file_in, char *format,
             }
             singularity_message(VERBOSE, "Set messagelevel to: %d\n", messagelevel);
         }
+        if ( getenv(COLORS_ENV) != NULL ) {
+            set_colors(getenv(COLORS_ENV));
+        }
     }
 
     if ( level == LOG && messagelevel <= INFO ) {
@@ -96,62 +236,36 @@ void _print(int level, const char *function, const char *file_in, char *format,
     switch (level) {
         case ABRT:
             prefix = "ABORT";
-            if ( has_color == 1 ) {
-                color = ANSI_COLOR_RED;
-                color_reset = ANSI_COLOR_RESET;
-            } else {
-                color = "";
-                color_reset = "";
-            }
             break;
         case ERROR:
             prefix = "ERROR";
-            if ( has_color == 1 ) {
-                color = ANSI_COLOR_LIGHTRED;
-                color_reset = ANSI_COLOR_RESET;
-            } else {
-                color = "";
-                color_reset = "";
-            }
             break;
         case WARNING:
             prefix = "WARNING";
-            if ( has_color == 1 ) {
-                color = ANSI_COLOR_YELLOW;
-                color_reset = ANSI_COLOR_RESET;
-            } else {
-                color = "";
-                color_reset = "";
-            }
             break;
         case LOG:
             prefix = "LOG";
-            if ( has_color == 1 ) {
-                color = ANSI_COLOR_BLUE;
-                color_reset = ANSI_COLOR_RESET;
-            } else {
-                color = "";
-                color_reset = "";
-            }
             break;
         case DEBUG:
             prefix = "DEBUG";
-            color = "";
-            color_reset = "";
+            break;
+        case TRACE:
+            prefix = "TRACE";
             break;
         case INFO:
             prefix = "INFO";
-            color = "";
-            color_reset = "";
             break;
         default:
             prefix = "VERBOSE";
-            color = "";
-            color_reset = "";
             break;
     }
 
-    if ( level <= messagelevel ) {
+    if ( has_color == 1 && level >= ABRT && level <= TRACE && level_colors[level - ABRT][0] != '\0' ) {
+        snprintf(color, sizeof(color), "\x1b[%sm", level_colors[level - ABRT]);
+        color_reset = ANSI_COLOR_RESET;
+    }
+
+    if ( level <= messagelevel && relay_message(level, function, message) < 0 ) {
         char header_string[100];
 
         if ( messagelevel >= DEBUG ) {
@@ -175,7 +289,7 @@ void _print(int level, const char *function, const char *file_in, char *format,
             }
             header_string[length-1] = '\0';
         } else {
-            snprintf(header_string, 15, "%s%-7s: ", color, prefix);
+            snprintf(header_string, sizeof(header_string), "%s%-7s: ", color, prefix);
         }
 
         if ( level == INFO && messagelevel == INFO ) {
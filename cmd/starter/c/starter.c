@@ -68,6 +68,9 @@ int master_socket[2] = {-1, -1};
 /* set Go execution call after init function returns */
 enum goexec goexecute;
 
+/* stage 1 exit status when its failure is handled by Go */
+int stage1_status = 0;
+
 typedef struct fdlist {
     int *fds;
     unsigned int num;
@@ -273,6 +276,7 @@ static void apply_privileges(struct privileges *privileges, struct capabilities
 
     for ( caps_index = 0; caps_index <= last_cap; caps_index++ ) {
         if ( !(privileges->capabilities.bounding & capflag(caps_index)) ) {
+            tracef("prctl(PR_CAPBSET_DROP, %d)\n", caps_index);
             if ( prctl(PR_CAPBSET_DROP, caps_index) < 0 ) {
                 fatalf("Failed to drop cap %d bounding capabilities set: %s\n", caps_index, strerror(errno));
             }
@@ -285,6 +289,7 @@ static void apply_privileges(struct privileges *privileges, struct capabilities
      * and to set ambient capabilities. We can't use capset before changing uid/gid
      * because CAP_SETUID/CAP_SETGID could be already dropped
      */
+    tracef("prctl(PR_SET_SECUREBITS, SECBIT_KEEP_CAPS)\n");
     if ( prctl(PR_SET_SECUREBITS, SECBIT_KEEP_CAPS) < 0 ) {
         fatalf("Failed to set securebits: %s\n", strerror(errno));
     }
@@ -327,6 +332,12 @@ static void apply_privileges(struct privileges *privileges, struct capabilities
     header.version = LINUX_CAPABILITY_VERSION;
     header.pid = 0;
 
+    tracef(
+        "capset(effective=0x%08x%08x, permitted=0x%08x%08x, inheritable=0x%08x%08x)\n",
+        data[1].effective, data[0].effective,
+        data[1].permitted, data[0].permitted,
+        data[1].inheritable, data[0].inheritable
+    );
     if ( capset(&header, data) < 0 ) {
         fatalf("Failed to set process capabilities\n");
     }
@@ -335,6 +346,7 @@ static void apply_privileges(struct privileges *privileges, struct capabilities
     // set ambient capabilities if supported
     for ( caps_index = 0; caps_index <= last_cap; caps_index++ ) {
         if ( (privileges->capabilities.ambient & capflag(caps_index)) ) {
+            tracef("prctl(PR_CAP_AMBIENT, PR_CAP_AMBIENT_RAISE, %d)\n", caps_index);
             if ( prctl(PR_CAP_AMBIENT, PR_CAP_AMBIENT_RAISE, caps_index, 0, 0) < 0 ) {
                 fatalf("Failed to set ambient capability: %s\n", strerror(errno));
             }
@@ -552,6 +564,7 @@ static int enter_namespace(char *nspath, int nstype) {
         return(-1);
     }
 
+    tracef("setns(%s, 0x%x)\n", nspath, nstype);
     if ( xsetns(ns_fd, nstype) < 0 ) {
         int err = errno;
         close(ns_fd);
@@ -789,6 +802,7 @@ static int mount_namespace_init(struct namespace *nsconfig, bool masterPropagate
             if ( create_namespace(CLONE_NEWNS) < 0 ) {
                 fatalf("Failed to create mount namespace: %s\n", nserror(errno, CLONE_NEWNS));
             }
+            tracef("mount(NULL, \"/\", NULL, 0x%lx, NULL)\n", propagation);
             if ( propagation && mount(NULL, "/", NULL, propagation, NULL) < 0 ) {
                 fatalf("Failed to set mount propagation: %s\n", strerror(errno));
             }
@@ -799,6 +813,7 @@ static int mount_namespace_init(struct namespace *nsconfig, bool masterPropagate
             }
 
             /* set shared propagation to propagate few mount points to master */
+            tracef("mount(NULL, \"/\", NULL, MS_SHARED|MS_REC, NULL)\n");
             if ( mount(NULL, "/", NULL, MS_SHARED|MS_REC, NULL) < 0 ) {
                 fatalf("Failed to propagate as SHARED: %s\n", strerror(errno));
             }
@@ -820,10 +835,12 @@ static int shared_mount_namespace_init(struct namespace *nsconfig) {
     if ( create_namespace(CLONE_NEWNS) < 0 ) {
         fatalf("Failed to create mount namespace: %s\n", nserror(errno, CLONE_NEWNS));
     }
+    tracef("mount(NULL, \"/\", NULL, 0x%lx, NULL)\n", propagation);
     if ( mount(NULL, "/", NULL, propagation, NULL) < 0 ) {
         fatalf("Failed to set mount propagation: %s\n", strerror(errno));
     }
     /* set shared mount propagation to share mount points between master and container process */
+    tracef("mount(NULL, \"/\", NULL, MS_SHARED|MS_REC, NULL)\n");
     if ( mount(NULL, "/", NULL, MS_SHARED|MS_REC, NULL) < 0 ) {
         fatalf("Failed to propagate as SHARED: %s\n", strerror(errno));
     }
@@ -1117,6 +1134,29 @@ void do_exit(int sig) {
  * runtime using the real pointer, so we need to work
  * directly with environment stack with cleanenv function.
  */
+static int is_logger_env(const char *env) {
+    static const char *keep[] = {
+        MSGLVL_ENV "=",
+        LOGFORWARD_ENV "=",
+        LOGFILE_ENV "=",
+        LOGFILE_ROTATE_ENV "=",
+        LOGTIMESTAMPS_ENV "=",
+        LOGRELAY_ENV "=",
+        COLORS_ENV "=",
+        LOGFORMAT_ENV "=",
+        LOGDESTINATION_ENV "=",
+        NULL
+    };
+    int i;
+
+    for ( i = 0; keep[i] != NULL; i++ ) {
+        if ( strncmp(keep[i], env, strlen(keep[i])) == 0 ) {
+            return 1;
+        }
+    }
+    return 0;
+}
+
 static void cleanenv(void) {
     extern char **environ;
     char **e;
@@ -1126,11 +1166,12 @@ static void cleanenv(void) {
     }
 
     /*
-     * keep only SINGULARITY_MESSAGELEVEL for GO runtime, set others to empty
-     * string and not NULL (see issue #3703 for why)
+     * keep only logger variables (SINGULARITY_MESSAGELEVEL ...) for GO
+     * runtime, set others to empty string and not NULL (see issue #3703
+     * for why)
      */
     for (e = environ; *e != NULL; e++) {
-        if ( strncmp(MSGLVL_ENV "=", *e, sizeof(MSGLVL_ENV)) != 0 ) {
+        if ( !is_logger_env(*e) ) {
             *e = "";
         }
     }
@@ -1293,6 +1334,30 @@ __attribute__((constructor)) static void init(void) {
     }
 
     debugf("Wait completion of stage1\n");
+    if ( sconfig->starter.isSuid ) {
+        siginfo_t info;
+
+        /* wait for stage 1 exit without reaping it to check its failure first */
+        memset(&info, 0, sizeof(info));
+        if ( waitid(P_PID, process, &info, WEXITED|WNOWAIT) < 0 ) {
+            fatalf("Failed to wait stage 1: %s\n", strerror(errno));
+        }
+        /*
+         * stage 1 runs without privileges, its failure is handled by the
+         * Go runtime with the privileges of the setuid workflow (e.g. to
+         * write the audit records of stage 1) before exiting with the
+         * stage 1 exit status
+         */
+        if ( info.si_code == CLD_EXITED && info.si_status != 0 && sconfig->starter.stage1Failure ) {
+            if ( waitpid(process, NULL, 0) < 0 ) {
+                fatalf("Failed to wait stage 1: %s\n", strerror(errno));
+            }
+            verbosef("stage 1 exited with status %d\n", info.si_status);
+            stage1_status = info.si_status;
+            goexecute = STAGE1_FAILURE;
+            return;
+        }
+    }
     wait_child("stage 1", process, false);
 
     /* change current working directory if requested by stage 1 */
@@ -54,6 +54,13 @@ func startup() {
 	case C.STAGE1:
 		sylog.Verbosef("Execute stage 1\n")
 		starter.StageOne(sconfig, e)
+	case C.STAGE1_FAILURE:
+		sylog.Verbosef("Handle stage 1 failure\n")
+		if err := sconfig.Release(); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+
+		starter.StageOneFailure(int(C.stage1_status), e)
 	case C.STAGE2:
 		sylog.Verbosef("Execute stage 2\n")
 		if err := sconfig.Release(); err != nil {
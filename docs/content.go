@@ -40,6 +40,16 @@ Enterprise Performance Computing (EPC)`
 
       default:    The compressed Singularity read only image format (default)
       sandbox:    This is a read-write container within a directory structure
+      oci:        An OCI image layout directory, written for oci://<dir>[:tag]
+                  targets or with --output-format oci
+      docker-archive:
+                  A tar archive loaded by docker load, written for
+                  docker-archive:<file>[:name:tag] targets or with
+                  --output-format docker-archive
+
+  OCI images hold the root filesystem as a single layer, the labels, the
+  exported %environment variables and the runscript as the entrypoint.
+  They can't be signed, encrypted or updated.
 
   note: It is a common workflow to use the "sandbox" mode for development of the
   container, and then build it as a default Singularity image for production 
@@ -55,13 +65,38 @@ Enterprise Performance Computing (EPC)`
       directory:  A directory structure containing a (ch)root file system
       image:      A local image on your machine (will convert to sif if
                   it is legacy format)
+      Dockerfile: A file named Dockerfile, Dockerfile.<name> or <name>.Dockerfile,
+                  its instructions are translated to a definition and COPY
+                  sources are relative to its directory
+      YAML/JSON:  A .yaml, .yml or .json structured definition file, see
+                  "singularity help def convert" for its schema
 
   Targets can also be remote and defined by a URI of the following formats:
 
       library://  an image library (default https://cloud.sylabs.io/library)
       docker://   a Docker registry (default Docker Hub)
       shub://     a Singularity registry (default Singularity Hub)
-      oras://     a supporting OCI registry`
+      oras://     a supporting OCI registry
+      podman://   the local podman containers storage (alias of containers-storage:)
+
+  MULTI-STAGE BUILDS:
+
+  The stages of a definition are built in order. With --parallel <n>, up to n
+  stages are built concurrently once the stages they copy files from with
+  "%files from <stage>" are built, so that independent stages like a builder
+  and a tester stage don't wait for each other. The messages and sections
+  output of each stage are printed at once when the stage completes, in the
+  stage order. The first failing stage aborts the others.
+
+  UNPRIVILEGED BUILDS:
+
+  When a user with subordinate IDs in /etc/subuid and /etc/subgid builds from
+  an OCI source (eg: docker://), the layers are extracted in a user namespace
+  mapping these IDs with newuidmap/newgidmap, so that the container files keep
+  their owners instead of all being owned by the user. A sandbox built this
+  way holds files owned by the subordinate IDs, they are removed as root of a
+  user namespace with the same mappings. Without subordinate IDs, or with
+  --fix-perms, all the files are owned by the user.`
 
 	BuildExample string = `
 
@@ -85,19 +120,100 @@ Enterprise Performance Computing (EPC)`
           OSVersion: 7
           MirrorURL: http://mirror.centos.org/centos-%{OSVERSION}/%{OSVERSION}/os/x86_64/
           Include: yum
+          # MirrorURL: mirror list tried in order, separated by spaces or commas
+          # GPGKey: https://repo.almalinux.org/almalinux/RPM-GPG-KEY-AlmaLinux-9 # https URLs or paths, verified before install
+          # ModuleStreams: nodejs:18, postgresql:15 # enabled with dnf before install
+          # BootstrapImage: docker://almalinux:9 # run dnf from this image instead of the host
+
+      SUSE/openSUSE:
+          Bootstrap: zypper
+          OSVersion: 15.2
+          MirrorURL: http://download.opensuse.org/distribution/leap/%{OSVERSION}/repo/oss/
+          Include: zypper
+          # Repo0: update http://download.opensuse.org/update/leap/15.2/oss/ priority=90 # <alias> <url> [priority=N refresh=yes|no gpgcheck=yes|no]
+          # Service0: rmt https://rmt.example.com/services/1 # <alias> <url>
+          # GPGKey: https://example.com/repo.key # only these keys are trusted, https URLs or paths
+          # Patterns: base, enhanced_base
+          # RegisterURL: https://rmt.example.com # with Product, User and Regcode are optional
 
       Debian/Ubuntu:
           Bootstrap: debootstrap
           OSVersion: trusty
           MirrorURL: http://us.archive.ubuntu.com/ubuntu/
+          # Keyring: https://example.com/archive-keyring.asc # path or URL, armored or binary
+          # Variant: buildd # minbase (default), buildd or fakechroot
+          # Components: main, universe
+          # Architecture: arm64 # Debian name, defaults to the --arch/--platform one
+
+      Arch Linux:
+          Bootstrap: arch
+          # PacmanConf: /etc/pacman.conf # path or URL, defaults to the pacman package one
+          # MirrorURL: https://mirrors.kernel.org/archlinux/$repo/os/$arch # mirrorlist servers
+          # Include: vim, base-devel # packages and groups installed with base
+          # AURHelper: yay-bin # optional, installs the AUR packages
+          # AUR: google-chrome # AUR packages built with makepkg
+          # Include: vim, curl
 
       Local Image:
           Bootstrap: localimage
           From: /home/dave/starter.img
 
+      Podman:
+          Bootstrap: podman # or containers-storage
+          From: localhost/image:dev
+
+      Nix:
+          Bootstrap: nix
+          From: github:NixOS/nixpkgs/nixos-20.09#hello # or a /nix/store path
+          # NixFile: default.nix # build a nix expression instead of a flake
+          # NixAttr: hello
+
+      Conda:
+          Bootstrap: conda
+          From: debian:buster-slim # docker base image
+          CondaFile: environment.yml # or a conda.lock of a previous build
+          # Channels: conda-forge, bioconda # override the file channels
+
+      Spack:
+          Bootstrap: spack
+          From: spack/ubuntu-bionic # docker base image providing spack
+          Spec: hdf5+mpi, py-numpy # or SpackFile: spack.yaml
+          # Mirror: https://mirror.example.com/spack # binary mirrors
+
       Scratch:
           Bootstrap: scratch # Populate the container with a minimal rootfs in %setup
 
+  DEF FILE SIF COMPRESSION:
+
+      Bootstrap: docker
+      From: tensorflow/tensorflow:latest
+      Compression: zstd # gzip (default), zstd, lz4 or xz
+      CompressionLevel: 19 # 1-9 for gzip, 1-22 for zstd
+
+  DEF FILE VARIABLES:
+
+      Bootstrap: docker
+      From: ubuntu:{{ UBUNTU_VERSION }}
+
+      %arguments
+          UBUNTU_VERSION=20.04
+
+      Variables are set with --build-arg KEY=VALUE, --build-arg-file or
+      SINGULARITY_BUILD_ARG_KEY environment variables, and default to the
+      values of the %arguments section.
+
+  DEF FILE INCLUDES:
+
+      Bootstrap: docker
+      From: ubuntu:20.04
+
+      %include common/environment.def
+      %include https://example.com/recipes/labels.def
+
+      Fragments are inserted verbatim, relative paths are resolved from the
+      directory of the including file and included fragments are recorded
+      in the image labels.
+
   DEFFILE SECTIONS:
 
       %pre
@@ -108,16 +224,19 @@ Enterprise Performance Computing (EPC)`
           echo "This is a scriptlet that will be executed on the host, as root, after"
           echo "the container has been bootstrapped. To install things into the container"
           echo "reference the file system location with $SINGULARITY_ROOTFS."
+          echo "The %files URL sources downloaded before the build are stored under"
+          echo "$SINGULARITY_DOWNLOADS/<host>/<path>."
 
       %post
           echo "This scriptlet section will be executed from within the container after"
           echo "the bootstrap/base has been created and setup."
 
-      %test
+      %test --timeout 10m --retries 1
           echo "Define any test commands that should be executed after container has been"
           echo "built. This scriptlet will be executed from within the running container"
           echo "as the root user. Pay attention to the exit/return value of this scriptlet"
-          echo "as any non-zero exit code will be assumed as failure."
+          echo "as any non-zero exit code will be assumed as failure, --exit-code sets"
+          echo "another expected exit code."
           exit 0
 
       %runscript
@@ -134,6 +253,9 @@ Enterprise Performance Computing (EPC)`
       %files
           /path/on/host/file.txt /path/on/container/file.txt
           relative_file.txt /path/on/container/relative_file.txt
+          https://example.com/data.tar.gz /path/on/container/data.tar.gz
+          /path/on/host/src/*.py /opt/app/ --exclude test_*.py --chown app:app --chmod 0644
+          /path/on/host/data /opt/data --exclude cache/ --exclude *.tmp
 
       %environment
           LUKE=goodguy
@@ -144,18 +266,197 @@ Enterprise Performance Computing (EPC)`
       %help
           This is a text file to be displayed with the run-help command.
 
+  The %files sources are expanded like shell globs and copied in byte order.
+  The --exclude patterns without slash match file names at any depth, those
+  with a slash match paths relative to the copied source and a trailing slash
+  only matches directories. The --chown user[:group] names are looked up in the
+  container, --chmod sets the octal mode of the copied files and directories.
+
+  SCIF apps may depend on other apps of the definition file, they are then
+  installed after them and their bin, lib and %appenv environment are set
+  when the app is installed, run or tested:
+
+      %appdepends samtools
+          htslib zlib
+
+  The %environment section is checked before the build starts, syntax errors
+  abort the build and variables set without export are reported as they
+  aren't visible by the container processes. It's sourced by a POSIX shell
+  accepting bash constructs unless --shell sh or --shell bash restricts its
+  syntax, a fish section with set and export commands is translated:
+
+      %environment --shell fish
+          set -gx PATH /opt/app/bin $PATH
+          set -x APP_HOME /opt/app
+
   COMMANDS:
 
       Build a sif file from a Singularity recipe file:
           $ singularity build /tmp/debian0.sif /path/to/debian.def
 
+      Check the %post and %runscript scripts for syntax errors, unquoted
+      variables, a missing set -e and host paths before building:
+          $ singularity build --check /tmp/debian0.sif /path/to/debian.def
+
       Build a sif image from the Library:
           $ singularity build /tmp/debian1.sif library://debian:latest
 
       Build a base sandbox from DockerHub, make changes to it, then build sif
           $ singularity build --sandbox /tmp/debian docker://debian:latest
           $ singularity exec --writable /tmp/debian apt-get install python
-          $ singularity build /tmp/debian2.sif /tmp/debian`
+          $ singularity build /tmp/debian2.sif /tmp/debian
+
+      Build the same definition file as a sif image, an OCI layout and a docker
+      archive loaded by docker:
+          $ singularity build /tmp/app.sif app.def
+          $ singularity build oci:///tmp/app-oci:v1 app.def
+          $ singularity build --output-format docker-archive /tmp/app.tar:example/app:v1 app.def
+          $ docker load -i /tmp/app.tar
+
+      Build an arm64 sif image from a multi-platform OCI archive:
+          $ singularity build --platform linux/arm64/v8 /tmp/debian3.sif oci-archive:/tmp/debian.tar
+
+      Build an arm64 sif image from a definition file on a x86_64 host, %post
+      runs with the static qemu-aarch64 binary registered with binfmt_misc:
+          $ sudo singularity build --arch arm64 /tmp/debian-arm64.sif debian.def
+
+      Build a sif image embedding a SPDX software bill of materials, then show it:
+          $ singularity build --sbom spdx /tmp/debian4.sif docker://debian:latest
+          $ singularity inspect --sbom /tmp/debian4.sif
+
+      The build output and provenance are stored in the image, the definition
+      file is stored once the includes and the build arguments are resolved:
+          $ singularity inspect --build-log /tmp/debian4.sif
+          $ singularity inspect --provenance /tmp/debian4.sif
+          $ singularity inspect --deffile /tmp/debian4.sif
+
+      Build a sif image with a host package mirror available in %post only:
+          $ sudo singularity build --bind /srv/mirror:/mnt/mirror:ro /tmp/debian5.sif /path/to/debian.def
+          $ sudo singularity build --mount type=bind,source=/srv/mirror,destination=/mnt/mirror,readonly /tmp/debian5.sif /path/to/debian.def
+
+      Build a sif image without network access in %post and %test, the network
+      is recorded in the org.label-schema.usage.singularity.build-network label:
+          $ sudo singularity build --network none --bind /srv/mirror:/mnt/mirror:ro /tmp/debian6.sif /path/to/debian.def
+
+      Build a sif image with %post and %test confined to 4GiB of memory and 2 CPUs,
+      the build is aborted after 2 hours:
+          $ sudo singularity build --memory 4G --cpus 2 --build-timeout 2h /tmp/debian7.sif /path/to/debian.def
+
+      Build and sign a sif image, it is only moved to its destination once signed:
+          $ singularity build --fakeroot --sign --keyidx 1 /tmp/debian8.sif /path/to/debian.def
+
+      Sign from a CI secret with a key file and the passphrase in the environment:
+          $ export SINGULARITY_SIGN_PASSPHRASE="$CI_KEY_PASSPHRASE"
+          $ sudo -E singularity build --sign --key /run/secrets/signing.asc /tmp/debian9.sif /path/to/debian.def
+
+      Follow the build progress as JSON lines, the build output goes to stderr:
+          $ sudo singularity build --json /tmp/debian10.sif /path/to/debian.def 2>build.log | jq -c 'select(.type == "build-completed")'
+          $ sudo singularity build --json-fd 3 /tmp/debian10.sif /path/to/debian.def 3>events.json
+
+      Build a sif image and write a JUnit report of the %test and %apptest sections:
+          $ sudo singularity build --test-report /tmp/debian11.xml /tmp/debian11.sif /path/to/debian.def
+
+      Install one more package in an existing sif image without bootstrapping it
+      again, the %files, %post and following sections of the definition file run
+      over the image root filesystem and the image is replaced once rebuilt:
+          $ sudo singularity build --update /tmp/debian.sif /path/to/extra-package.def
+
+      Rebuild after changing labels without running %post again:
+          $ sudo singularity build --cache-sections /tmp/debian6.sif /path/to/debian.def
+
+      Build an encrypted sif image any of the recipients decrypts, the team RSA
+      key, the CI passphrase in the environment and a key management service
+      handled by a plugin:
+          $ sudo -E singularity build --pem-path team.pem --encryption-key env:CI_PASSPHRASE,kms:vault:transit/images /tmp/debian12.sif /path/to/debian.def
+
+      Encrypt with a passphrase stored in the kernel keyring of root:
+          $ sudo keyctl add user build-passphrase "$PASSPHRASE" @u
+          $ sudo singularity build --encryption-key keyring:build-passphrase /tmp/debian13.sif /path/to/debian.def
+
+      Build and sign a sif image with a dm-verity hash tree, a privileged runtime
+      checks the root filesystem blocks against the signed root hash when read:
+          $ sudo singularity build --verity --sign /tmp/debian14.sif /path/to/debian.def`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Def
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	DefUse   string = `def`
+	DefShort string = `Manage definition files`
+	DefLong  string = `
+  The def command allows you to check definition files without building them
+  and to convert them to and from the YAML and JSON structured formats.`
+	DefExample string = `
+  All def commands have their own help output:
+
+  $ singularity help def lint
+  $ singularity def lint --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Def lint
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	DefLintUse   string = `lint [lint options...] <definition file>`
+	DefLintShort string = `Check a definition file without building it`
+	DefLintLong  string = `
+  The def lint command parses a definition file, resolves its %include
+  directives and reports invalid headers and bootstrap agents, headers missing
+  or ignored by the bootstrap agent, unknown sections, undefined variables and
+  %files sources which don't exist. Sources are relative to the current
+  directory like during a build. The command exits with a non zero status if
+  errors are found.`
+	DefLintExample string = `
+  $ singularity def lint ubuntu.def
+  $ singularity def lint --build-arg TAG=20.04 ubuntu.def
+  $ singularity def lint --json ubuntu.def`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Def convert
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	DefConvertUse   string = `convert [convert options...] <definition file>`
+	DefConvertShort string = `Convert a definition file to or from YAML and JSON`
+	DefConvertLong  string = `
+  The def convert command prints the definition file converted to the classic
+  format (def), YAML (yaml) or JSON (json). Files ending with .yaml, .yml or
+  .json are structured definitions and are converted to the classic format by
+  default, other files are converted to YAML. Structured definitions are also
+  accepted by build and def lint, unknown fields are rejected.
+
+  The structured definition schema (version 1) is:
+
+      version: 1               # required, the schema version
+      stages:                  # the build stages in order
+        - header:              # lower case header keywords, bootstrap is
+            bootstrap: docker  # required by all stages but the first one
+            from: alpine:3.12
+          sections:            # the sections in order
+            - name: post       # the section name without %
+              app: ""          # the app name of SCIF app sections
+              args: ""         # the arguments after the section name
+              script: |        # the content of script sections
+                apk add curl
+            - name: files      # files and appfiles sections
+              files:
+                - source: app.conf
+                  destination: /etc/app.conf
+                  exclude: []  # --exclude patterns
+                  owner: ""    # --chown user[:group]
+                  mode: ""     # --chmod mode
+            - name: labels     # labels and applabels sections
+              labels:
+                maintainer: me
+            - name: arguments  # the %arguments section
+              arguments:
+                TAG: latest
+            - name: include    # an %include directive
+              path: common.def
+
+  Scripts are kept verbatim, so converting a definition file and back gives
+  the same definition. The comments of the header and of the files, labels and
+  arguments sections are not kept.`
+	DefConvertExample string = `
+  $ singularity def convert ubuntu.def > ubuntu.yaml
+  $ singularity def convert --to json ubuntu.def
+  $ singularity def convert ubuntu.yaml > ubuntu.def
+  $ sudo singularity build ubuntu.sif ubuntu.yaml`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// Cache
@@ -179,7 +480,10 @@ Enterprise Performance Computing (EPC)`
 	CacheCleanLong  string = `
   This will clean your local cache (stored at $HOME/.singularity/cache if
   SINGULARITY_CACHEDIR is not set). By default the entire cache is cleaned, use
-  --days and --type flags to override this behavior. Note: if you use Singularity
+  --older-than, --max-size and --type flags to override this behavior. OCI
+  blobs are stored once by digest and shared by the images built from docker,
+  oci and docker-archive sources, they are removed once all the images
+  referencing them are removed. Note: if you use Singularity
   as root, cache will be stored in '/root/.singularity/.cache', to clean that
   cache, you will need to run 'cache clean' as root, or with 'sudo'.`
 	CacheCleanExample string = `
@@ -187,7 +491,10 @@ Enterprise Performance Computing (EPC)`
 
   $ singularity help cache clean --days 30
   $ singularity help cache clean --type=library,oci
-  $ singularity cache clean --help`
+  $ singularity cache clean --help
+
+  Remove the entries not used for 30 days and keep each cache type under 10GiB:
+  $ singularity cache clean --force --older-than 30d --max-size 10G`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// Cache List
@@ -204,6 +511,187 @@ Enterprise Performance Computing (EPC)`
   $ singularity help cache list --type=library,oci
   $ singularity cache list --help`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Store
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	StoreUse   string = `store`
+	StoreShort string = `Manage the local chunk store of SIF images`
+	StoreLong  string = `
+  The chunk store holds SIF images split in content defined chunks, the chunks
+  are identified by their SHA256 digest and stored once for all the images of
+  the store. The partitions are chunked apart from the rest of the image, so
+  images sharing most of their root filesystem, like images built from the same
+  base layers, only use the space of the chunks they don't share.
+
+  Images are reconstructed from their chunks with 'singularity store extract'
+  to be run, they can't be mounted from the store directly. The store is located
+  at $HOME/.singularity/store if SINGULARITY_STOREDIR is not set.`
+	StoreExample string = `
+  All group commands have their own help output:
+
+  $ singularity help store add
+  $ singularity store list --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Store add
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	StoreAddUse   string = `add [add options...] <SIF image>`
+	StoreAddShort string = `Add a SIF image to the chunk store`
+	StoreAddLong  string = `
+  The store add command chunks the SIF image and stores the chunks not in the
+  store yet. The image replaces the image with the same name, its name is the
+  file name without the .sif extension unless set with --name.`
+	StoreAddExample string = `
+  $ singularity store add pytorch_1.6.sif
+  $ singularity store add --name tensorflow tf.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Store list
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	StoreListUse   string = `list`
+	StoreListShort string = `List the images of the chunk store`
+	StoreListLong  string = `
+  The store list command lists the images of the store with their size, and
+  the space used by the chunks compared to the size of the images.`
+	StoreListExample string = `
+  $ singularity store list`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Store extract
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	StoreExtractUse   string = `extract <name> <SIF image>`
+	StoreExtractShort string = `Reconstruct a SIF image of the chunk store`
+	StoreExtractLong  string = `
+  The store extract command reconstructs the image from its chunks, it's
+  identical to the image added and its digest is checked.`
+	StoreExtractExample string = `
+  $ singularity store extract pytorch_1.6 /scratch/pytorch_1.6.sif
+  $ singularity run /scratch/pytorch_1.6.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Store remove
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	StoreRemoveUse   string = `remove <name>`
+	StoreRemoveShort string = `Remove an image from the chunk store`
+	StoreRemoveLong  string = `
+  The store remove command removes the image from the store, the chunks no
+  image references anymore are removed by 'singularity store prune'.`
+	StoreRemoveExample string = `
+  $ singularity store remove pytorch_1.6
+  $ singularity store prune`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Store prune
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	StorePruneUse   string = `prune [prune options...]`
+	StorePruneShort string = `Remove the unused chunks of the chunk store`
+	StorePruneLong  string = `
+  The store prune command removes the chunks which aren't referenced by an
+  image of the store.`
+	StorePruneExample string = `
+  $ singularity store prune --dry-run
+  $ singularity store prune`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Export
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	ExportUse   string = `export [export options...] <image> <destination>`
+	ExportShort string = `Export the root filesystem of an image`
+	ExportLong  string = `
+  The export command writes the root filesystem of a SIF, squashfs or sandbox
+  image to the destination, without root privileges, in one of the formats
+  selected with --format:
+
+    tar:          a tar archive, written to the standard output if the
+                  destination is -
+    oci-layout:   an OCI image layout directory holding a single layer image,
+                  configured like the image built with --output-format oci
+    squashfs:     a squashfs image, the partition is copied as is
+
+  The squashfs partitions are extracted with unsquashfs in a user namespace
+  mapping the subordinate IDs of the user from /etc/subuid and /etc/subgid, so
+  that the files keep their owner, hard links and extended attributes. Without
+  subordinate IDs, the files are owned by root in the export.
+
+  Another squashfs partition of a SIF image is exported with --partition and
+  its ID or name, as listed by 'singularity sif list'. With --app, only the
+  /scif/apps and /scif/data directories of the app are exported.`
+	ExportExample string = `
+  $ singularity export container.sif rootfs.tar
+  $ singularity export --format oci-layout container.sif oci-dir
+  $ singularity export --format squashfs --app foo container.sif foo.sqfs
+  $ singularity export container.sif - | tar -tv`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Overlay
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	OverlayUse   string = `overlay`
+	OverlayShort string = `Manage the writable overlay images`
+	OverlayLong  string = `
+  The overlay commands create and resize the ext3 overlay images used with
+  --overlay, either as standalone images or embedded in a SIF image as an
+  overlay partition. The e2fsprogs programs (mkfs.ext3, debugfs, e2fsck and
+  resize2fs) are required.`
+	OverlayExample string = `
+  All group commands have their own help output:
+
+  $ singularity help overlay create
+  $ singularity overlay resize --help`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Overlay create
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	OverlayCreateUse   string = `create [create options...] <image>`
+	OverlayCreateShort string = `Create an overlay image or embed one in a SIF image`
+	OverlayCreateLong  string = `
+  The overlay create command creates an ext3 overlay image holding the upper
+  and work directories. If the image is an existing SIF image, the overlay is
+  embedded in it as an overlay partition of the primary system partition group.
+
+  The directories are owned by the user so the overlay is writable without
+  privileges, or by root with --fakeroot to use it with 'singularity --fakeroot'.
+  Directories can be created in the overlay with --create-dir, and --sparse
+  doesn't allocate the unused blocks of the image.
+
+  With --encryption-key, the ext3 filesystem is stored in a LUKS volume unlocked
+  by the given passphrases, the image is then 16 MiB larger for the LUKS header.
+  Encrypted overlays are created by root with cryptsetup, and the passphrase
+  is requested with --overlay-key when the container is run.`
+	OverlayCreateExample string = `
+  $ singularity overlay create --size 1024 overlay.img
+  $ singularity overlay create --size 512 --sparse --create-dir /data container.sif
+  $ sudo singularity overlay create --size 1024 --encryption-key passphrase container.sif
+  $ singularity run --overlay-key env:OVERLAY_PASSPHRASE container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Overlay resize
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	OverlayResizeUse   string = `resize [resize options...] <image>`
+	OverlayResizeShort string = `Resize an overlay image or the overlay of a SIF image`
+	OverlayResizeLong  string = `
+  The overlay resize command checks the filesystem of the overlay image, or of
+  the overlay partition of the SIF image, and resizes it to --size MiB. The
+  files of the overlay must fit in the new size when shrinking it. Encrypted
+  overlays can't be resized.`
+	OverlayResizeExample string = `
+  $ singularity overlay resize --size 2048 overlay.img
+  $ singularity overlay resize --size 1024 --sparse container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Overlay sync
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	OverlaySyncUse   string = `sync [sync options...] <SIF image> <overlay image>`
+	OverlaySyncShort string = `Copy an overlay image to or from a SIF image`
+	OverlaySyncLong  string = `
+  The overlay sync command checks the filesystem of the overlay image and
+  replaces the overlay partition of the SIF image with it, or adds it if the
+  SIF image has no overlay partition. With --export, the overlay image is
+  written from the overlay partition instead. Encrypted overlays are copied
+  as they are, without checking their filesystem.`
+	OverlaySyncExample string = `
+  $ singularity overlay sync --export container.sif overlay.img
+  $ singularity overlay sync container.sif overlay.img`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// key
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -458,11 +946,23 @@ Enterprise Performance Computing (EPC)`
 
   shub://*            A container hosted on Singularity Hub
 
-  oras://*            A container hosted on a supporting OCI registry`
+  oras://*            A container hosted on a supporting OCI registry
+
+  http(s)://*         A container downloaded from a web server, SIF images
+                      are mounted with --lazy to fetch only the blocks read`
+	environment string = `
+
+  The container environment is defined by, in order of precedence, the --env
+  variables, the --env-file variables, the host SINGULARITYENV_ prefixed
+  variables, the image %environment and the host environment. With
+  --env-precedence host the host environment takes precedence over the image
+  %environment. With --cleanenv only the proxy variables, TERM and the
+  variables matching the --preserve-env shell patterns are forwarded from the
+  host environment.`
 	ExecUse   string = `exec [exec options...] <container> <command>`
 	ExecShort string = `Run a command within a container`
 	ExecLong  string = `
-  singularity exec supports the following formats:` + formats
+  singularity exec supports the following formats:` + formats + environment
 	ExecExamples string = `
   $ singularity exec /tmp/debian.sif cat /etc/debian_version
   $ singularity exec /tmp/debian.sif python ./hello_world.py
@@ -485,6 +985,34 @@ Enterprise Performance Computing (EPC)`
   $ singularity help instance start
   $ singularity instance start --help`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// instance checkpoint
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	InstanceCheckpointUse   string = `checkpoint [checkpoint options...] <instance name>`
+	InstanceCheckpointShort string = `Checkpoint a named instance with CRIU`
+	InstanceCheckpointLong  string = `
+  The instance checkpoint command saves the state of the processes of a named
+  instance with CRIU, so it can be restored later with the instance restore
+  command, on the same host or another one with access to the instance image.
+  The instance is stopped by the checkpoint unless --leave-running is set.
+
+  The checkpoint is stored in the instance state directory, or in a data object
+  of the instance SIF image with --sif, replacing any previous checkpoint. The
+  container root filesystem isn't part of the checkpoint, it's mounted again
+  from the image when restoring, and directories bound from the host must be
+  available at the same location. Changes written to a writable overlay or to
+  a temporary filesystem over the root filesystem aren't preserved.
+
+  The criu program must be installed, users require a CRIU version supporting
+  the --unprivileged option with the CAP_CHECKPOINT_RESTORE capability.`
+	InstanceCheckpointExample string = `
+  $ singularity instance start my-job.sif job
+  $ singularity instance checkpoint job
+  $ singularity instance restore job
+
+  Store the checkpoint in the image and keep the instance running
+  $ singularity instance checkpoint --sif --leave-running job`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// instance list
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -510,6 +1038,43 @@ Enterprise Performance Computing (EPC)`
   test               11963     /home/mibauer/singularity/sinstance/test.sif
   test2              16219     /home/mibauer/singularity/sinstance/test.sif`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// instance logs
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	InstanceLogsUse   string = `logs [logs options...] <instance name>`
+	InstanceLogsShort string = `Display the output and error logs of a named instance`
+	InstanceLogsLong  string = `
+  The instance logs command displays the standard output and standard error
+  streams, including Singularity messages, recorded for a named instance. The
+  output of each instance started with the same name is appended to the log
+  files, they are rotated when an instance starts once they exceed 10MB and
+  the last 3 rotated files are kept with a .1, .2 or .3 suffix.`
+	InstanceLogsExample string = `
+  $ singularity instance start my-sql.sif mysql
+  $ singularity instance logs mysql
+
+  Keep displaying new output until the instance exits
+  $ singularity instance logs --follow mysql`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// instance restore
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	InstanceRestoreUse   string = `restore <instance name|SIF image>`
+	InstanceRestoreShort string = `Restore a named instance from a CRIU checkpoint`
+	InstanceRestoreLong  string = `
+  The instance restore command restores a named instance from the checkpoint
+  created by the instance checkpoint command. The checkpoint is read from the
+  instance state directory, or from the SIF image holding a checkpoint stored
+  with the --sif option, this image providing the container root filesystem.
+
+  Root filesystems of SIF and filesystem images are mounted by root only, users
+  can restore instances of sandbox images.`
+	InstanceRestoreExample string = `
+  $ singularity instance restore job
+
+  Restore an instance from the checkpoint stored in its image
+  $ singularity instance restore my-job.sif`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// instance start
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -539,6 +1104,23 @@ Enterprise Performance Computing (EPC)`
   $ singularity instance stop /tmp/my-sql.sif mysql
   Stopping /tmp/my-sql.sif mysql`
 
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// instance stats
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	InstanceStatsUse   string = `stats [stats options...] <instance name>`
+	InstanceStatsShort string = `Display the resources usage of a named instance`
+	InstanceStatsLong  string = `
+  The instance stats command displays the CPU time, memory and process count
+  of the cgroup of a named instance. The instance must be started with the
+  --apply-cgroups or resource limit flags, such as --memory or --cpus, which
+  requires root privileges or, for unprivileged users, a systemd user session
+  delegating cgroups with the cgroups v2 unified hierarchy.`
+	InstanceStatsExample string = `
+  $ singularity instance start --memory 1G my-sql.sif mysql
+  $ singularity instance stats mysql
+  INSTANCE NAME    PID      CPU TIME    MEMORY USAGE / LIMIT    PIDS
+  mysql            11963    1.25s       212.3MiB / 1GiB         28`
+
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// instance stop
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
@@ -585,12 +1167,28 @@ Enterprise Performance Computing (EPC)`
   oras: Pull a SIF image from a supporting OCI registry
       oras://registry/namespace/image:tag
 
+  podman, containers-storage: Convert an image of the local containers storage
+      podman://localhost/image:tag
+      containers-storage:[driver@graphroot+runroot]image:tag
+
   http, https: Pull an image using the http(s?) protocol
-      https://library.sylabs.io/v1/imagefile/library/default/alpine:latest`
+      https://library.sylabs.io/v1/imagefile/library/default/alpine:latest
+
+  With --arch all, the library images of all the architectures available are
+  pulled and assembled in a multi-architecture SIF image: the image of the host
+  architecture is kept with its signatures and the root filesystems of the
+  other architectures are added as system partitions tagged with their
+  architecture. The commands running the image select the root filesystem of
+  the host architecture, so a single image runs on heterogeneous clusters. The
+  added partitions are not signed, they can be signed afterwards with
+  'singularity sign'.`
 	PullExample string = `
   From Sylabs cloud library
   $ singularity pull alpine.sif library://alpine:latest
 
+  A multi-architecture image from Sylabs cloud library
+  $ singularity pull --arch all alpine.sif library://alpine:latest
+
   From Docker
   $ singularity pull tensorflow.sif docker://tensorflow/tensorflow:latest
 
@@ -598,7 +1196,10 @@ Enterprise Performance Computing (EPC)`
   $ singularity pull singularity-images.sif shub://vsoch/singularity-images
 
   From supporting OCI registry (e.g. Azure Container Registry)
-  $ singularity pull image.sif oras://<username>.azurecr.io/namespace/image:tag`
+  $ singularity pull image.sif oras://<username>.azurecr.io/namespace/image:tag
+
+  From the podman containers storage (rootless storage requires podman unshare)
+  $ podman unshare singularity pull image.sif podman://localhost/image:dev`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// push
@@ -615,6 +1216,13 @@ Enterprise Performance Computing (EPC)`
   oras:
       oras://registry/namespace/repo:tag
 
+  docker:
+      docker://registry/namespace/repo:tag
+
+  With oras and docker URIs, the SIF image is uploaded unmodified as the
+  single layer of an OCI artifact, so its signatures, overlay partitions
+  and metadata are kept. Pulling it back from the docker:// URI downloads
+  the original SIF image instead of converting OCI layers.
 
   NOTE: It's always good practice to sign your containers before
   pushing them to the library. An auth token is required to push to the library,
@@ -624,7 +1232,9 @@ Enterprise Performance Computing (EPC)`
   $ singularity push /home/user/my.sif library://user/collection/my.sif:latest
 
   To supported OCI registry
-  $ singularity push /home/user/my.sif oras://registry/namespace/image:tag`
+  $ singularity push /home/user/my.sif oras://registry/namespace/image:tag
+  $ singularity push /home/user/my.sif docker://registry/namespace/image:tag
+  $ singularity pull docker://registry/namespace/image:tag`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// search
@@ -651,7 +1261,7 @@ Enterprise Performance Computing (EPC)`
   automatically. All arguments following the container name will be passed
   directly to the runscript.
 
-  singularity run accepts the following container formats:` + formats
+  singularity run accepts the following container formats:` + formats + environment
 	RunExamples string = `
   # Here we see that the runscript prints "Hello world: "
   $ singularity exec /tmp/debian.sif cat /singularity
@@ -671,7 +1281,7 @@ Enterprise Performance Computing (EPC)`
 	ShellUse   string = `shell [shell options...] <container>`
 	ShellShort string = `Run a shell within a container`
 	ShellLong  string = `
-  singularity shell supports the following formats:` + formats
+  singularity shell supports the following formats:` + formats + environment
 	ShellExamples string = `
   $ singularity shell /tmp/Debian.sif
   Singularity/Debian.sif> pwd
@@ -707,10 +1317,22 @@ Enterprise Performance Computing (EPC)`
   The sign command allows a user to add one or more digital signatures to a SIF
   image. By default, one digital signature is added for each object group in
   the file.
+
+  With --verity, a dm-verity hash tree of the squashfs root filesystem is added
+  to its object group before signing it. A privileged runtime then checks every
+  block of the root filesystem against the signed root hash when it's read, so
+  a modification of the image file is detected while the container is running.
+
+  Objects added to a signed image, like an overlay partition or an artifact, are
+  put in a new object group so that the existing signatures stay valid. With
+  --unsigned-groups, only the object groups without signature are signed,
+  appending a signature for the new objects to the ones of the publisher.
   
   To generate a keypair, see 'singularity help key newpair'`
 	SignExample string = `
-  $ singularity sign container.sif`
+  $ singularity sign container.sif
+  $ singularity sign --verity container.sif
+  $ singularity sign --unsigned-groups container.sif`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// verify
@@ -723,9 +1345,15 @@ Enterprise Performance Computing (EPC)`
   multiple data objects signed. By default the command searches for the primary 
   partition signature. If found, a list of all verification blocks applied on 
   the primary partition is gathered so that data integrity (hashing) and 
-  signature verification is done for all those blocks.`
+  signature verification is done for all those blocks.
+
+  The object groups are verified independently and the result of each of them
+  is reported, so that the signature of the publisher can be checked when an
+  object group added later, like a writable overlay, isn't signed or was
+  modified.`
 	VerifyExample string = `
-  $ singularity verify container.sif`
+  $ singularity verify container.sif
+  $ singularity verify --group-id 1 container.sif`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// Run-help
@@ -768,9 +1396,40 @@ Enterprise Performance Computing (EPC)`
   Inspect will show you labels, environment variables, apps and scripts associated 
   with the image determined by the flags you pass. By default, they will be shown in 
   plain text. If you would like to list them in json format, you should use the --json flag.
+
+  Without other flags, --json shows all the metadata of the image in a
+  single document versioned by its "version" field: labels, environment,
+  scripts, apps, the SIF partitions, signatures and data objects with
+  their size and sha256 digest, the SBOM reference, the base images of
+  the build and the build provenance.
   `
 	InspectExample string = `
   $ singularity inspect ubuntu.sif
+
+  If the image was built with --sbom, its software bill of materials is
+  shown with:
+
+  $ singularity inspect --sbom ubuntu.sif
+
+  The output of the build is shown with --build-log unless the image was
+  built with --no-build-log, the builder version, the digest of the
+  rendered definition file and the digests of the base images are shown
+  in JSON format with:
+
+  $ singularity inspect --provenance ubuntu.sif
+
+  The variables set by the %environment section, whether they are exported
+  and the line setting them are part of the JSON output of:
+
+  $ singularity inspect --environment --json ubuntu.sif
+
+  The artifacts added with 'singularity sif add --artifact' are listed with:
+
+  $ singularity inspect --artifacts ubuntu.sif
+
+  The complete metadata of the image is shown with:
+
+  $ singularity inspect --json ubuntu.sif
   
   If you want to list the applications (apps) installed in a container (located at
   /scif/apps) you should run inspect command with --list-apps <container-image> flag.
@@ -817,7 +1476,131 @@ Enterprise Performance Computing (EPC)`
 
   To verify you own a single application on your container image, use the --app <appname> flag:
 
-  $ singularity inspect --app <appname> ubuntu.sif`
+  $ singularity inspect --app <appname> ubuntu.sif
+
+  The JSON output of an app also holds the apps it depends on, declared by
+  its %appdepends section, and the variables set by its %appenv section:
+
+  $ singularity inspect --json --app <appname> ubuntu.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Sif diff
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	SifDiffUse   string = `diff [diff options...] <old SIF image> <new SIF image>`
+	SifDiffShort string = `Compare two SIF images`
+	SifDiffLong  string = `
+  The sif diff command compares the data objects of two SIF images and prints
+  the objects added (+), removed (-) or modified (~), with the descriptor
+  fields and the content digests which changed. Partitions are matched by
+  partition type, signatures by the object they sign and the other objects by
+  type and name. Creation and modification times are not compared.
+
+  With --files the primary system partitions are extracted with unsquashfs and
+  the files added, removed or modified are printed too, their type, mode, size,
+  content and symlink target are compared.
+
+  The command exits with status 1 if the images differ.`
+	SifDiffExample string = `
+  $ singularity sif diff old.sif new.sif
+  $ singularity sif diff --files old.sif new.sif
+  $ singularity sif diff --json old.sif new.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Sif check
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	SifCheckUse   string = `check [check options...] <SIF image>...`
+	SifCheckShort string = `Check the integrity of SIF images without keys`
+	SifCheckLong  string = `
+  The sif check command checks the digests stored by the signatures of SIF
+  images against the content of the images: the global header, the object
+  descriptors and the data objects. The signatures themselves are not verified,
+  so no key and no network access are needed, which makes it suitable to
+  detect the corruption of images on storage (eg: periodically over an image
+  repository). Use the verify command to detect the tampering of images.
+
+  The objects without stored digest, those of unsigned images, are reported as
+  unchecked. The command exits with status 1 if a digest does not match.`
+	SifCheckExample string = `
+  $ singularity sif check image.sif
+  $ singularity sif check --json /shared/images/*.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Sif delta
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	SifDeltaUse   string = `delta <old SIF image> <new SIF image>`
+	SifDeltaShort string = `Write the binary delta between two SIF images`
+	SifDeltaLong  string = `
+  The sif delta command writes to the standard output a binary delta turning
+  the old SIF image into the new one, it's applied with 'singularity sif
+  patch'. The data objects are split in blocks of 64KiB, the blocks also found
+  in a data object of the old image are copied from it when patching and only
+  the other blocks, the header and the descriptors are held by the delta.
+
+  The patched image is identical to the new image, so the unchanged partitions
+  and their signatures are kept and the image verifies like the new one.`
+	SifDeltaExample string = `
+  $ singularity sif delta app_1.0.sif app_1.1.sif > app_1.1.delta`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Sif patch
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	SifPatchUse   string = `patch <old SIF image> <delta> <new SIF image>`
+	SifPatchShort string = `Apply a binary delta to a SIF image`
+	SifPatchLong  string = `
+  The sif patch command applies a delta written by 'singularity sif delta' to
+  the old SIF image and writes the new image. The old image must be the one
+  the delta was created from, and the new image is checked against the digest
+  of the image the delta was created from before being written to its path.`
+	SifPatchExample string = `
+  $ singularity sif patch app_1.0.sif app_1.1.delta app_1.1.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Sif compact
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	SifCompactUse   string = `compact [compact options...] <SIF image>`
+	SifCompactShort string = `Rewrite a SIF image without its unused space`
+	SifCompactLong  string = `
+  The sif compact command rewrites the SIF image with its data objects packed
+  one after the other, dropping the deleted descriptors and the space left by
+  objects removed or replaced, like overlay partitions resized or synchronized.
+  The image is replaced unless --output is set.
+
+  The objects keep their ID so their signatures are still valid, unless they're
+  modified: --strip removes the build log and the test report, and
+  --compression recompresses the squashfs partitions, which requires unsquashfs
+  and mksquashfs. The signatures of the modified object groups are removed and
+  the dm-verity hash trees of the recompressed partitions are dropped, they're
+  added back by signing the image again with 'singularity sign --verity'.
+
+  The compression is set per partition with a comma separated list of
+  [<partition>=]<algorithm>[:<level>], the partition being a partition ID or
+  type (rootfs, system, data or overlay). The compression without partition
+  applies to the partitions not listed, the other ones are copied as is. The
+  none algorithm leaves a partition uncompressed, eg: for data read with mmap.`
+	SifCompactExample string = `
+  $ singularity sif compact container.sif
+  $ singularity sif compact --strip --compression zstd -o small.sif container.sif
+  $ singularity sif compact --compression rootfs=zstd:19,data=none container.sif`
+
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	// Sif extract
+	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
+	SifExtractUse   string = `extract [extract options...] <SIF image> <artifact>`
+	SifExtractShort string = `Extract an artifact from a SIF image`
+	SifExtractLong  string = `
+  Artifacts are files of any media type added to a SIF image with
+  'singularity sif add --artifact <media type> <file> <SIF image>', like
+  SBOMs, test reports, model cards or license files. Each artifact is added to
+  a new object group unless --groupid is set, so it can be signed on its own
+  with 'singularity sign --group-id', and the signature covers its media type.
+
+  The sif extract command writes the artifact selected by its ID, its name or
+  its media type to the standard output or to the file set with --output. The
+  artifacts of an image are listed with 'singularity inspect --artifacts'.`
+	SifExtractExample string = `
+  $ singularity sif add --artifact application/spdx+json sbom.spdx.json container.sif
+  $ singularity inspect --artifacts container.sif
+  $ singularity sif extract -o sbom.spdx.json container.sif application/spdx+json`
 
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// Test
@@ -828,6 +1611,14 @@ Enterprise Performance Computing (EPC)`
   The 'test' command allows you to execute a testscript (if available) inside of
   a given container 
 
+  The %test section and the %apptest sections of all apps are run, or only the
+  %apptest section of the app selected with --app, each %apptest section runs
+  in the environment of its app and of the apps it depends on. A section header may set
+  a timeout, a number of retries and the expected exit code of the test:
+  '%test --timeout 5m --retries 2 --exit-code 0'. The exit code of the command
+  is the number of failed tests and --report writes the test results to a JSON
+  or JUnit file inside the container.
+
   NOTE:
       For instances if there is a daemon process running inside the container,
       then subsequent container commands will all run within the same 
@@ -843,6 +1634,9 @@ Enterprise Performance Computing (EPC)`
   $ singularity test /tmp/debian.sif command
       hello from test command
 
+  Write a JUnit report of the tests in the current directory:
+  $ singularity test --report results.xml /tmp/debian.sif
+
   For additional help, please visit our public documentation pages which are
   found at:
 
@@ -987,11 +1781,20 @@ Enterprise Performance Computing (EPC)`
   $ singularity help config fakeroot
   $ singularity config fakeroot --help`
 
-	ConfigFakerootUse   string = `fakeroot <option> <user>`
+	ConfigFakerootUse   string = `fakeroot <option> [user]`
 	ConfigFakerootShort string = `Manage fakeroot user mappings entries (root user only)`
 	ConfigFakerootLong  string = `
-  The config fakeroot command allow a root user to add/remove/enable/disable fakeroot
-  user mappings.`
+  The config fakeroot command allow a root user to add/remove/enable/disable/list
+  fakeroot user mappings.
+
+  The mappings of a user are a range of 65536 subordinate IDs in /etc/subuid and
+  /etc/subgid, they are allocated from the top of the ID space by default, or in
+  the SUB_UID_MIN-SUB_UID_MAX and SUB_GID_MIN-SUB_GID_MAX ranges of
+  /etc/login.defs with --auto, eg: when directory services use the high UIDs.
+  A user whose UID is a subordinate ID of another user can't be added, and the
+  ranges sharing IDs with other entries are reported. The previous files are
+  kept in /etc/subuid- and /etc/subgid-. Adding or enabling a user fails if
+  the kernel doesn't allow user namespaces.`
 	ConfigFakerootExample string = `
   To add a fakeroot user mapping for vagrant user:
   $ singularity config fakeroot --add vagrant
@@ -1003,7 +1806,13 @@ Enterprise Performance Computing (EPC)`
   $ singularity config fakeroot --disable vagrant
 
   To enable a fakeroot user mapping for vagrant user:
-  $ singularity config fakeroot --enable vagrant`
+  $ singularity config fakeroot --enable vagrant
+
+  To add a fakeroot user mapping in the /etc/login.defs ranges for a directory user:
+  $ singularity config fakeroot --add --auto jdoe
+
+  To list the fakeroot user mappings and report the conflicting entries:
+  $ singularity config fakeroot --list`
 
 	ConfigGlobalUse   string = `global <option> <directive> [value,...]`
 	ConfigGlobalShort string = `Edit singularity.conf from command line (root user only or unprivileged installation)`
@@ -31,7 +31,10 @@ const (
 	be used for singularity remote services. Authentication with a newly created
 	endpoint will occur automatically.`
 	RemoteAddExample string = `
-  $ singularity remote add SylabsCloud cloud.sylabs.io`
+  $ singularity remote add SylabsCloud cloud.sylabs.io
+
+  Remote builds of this endpoint are submitted to a site-local build service:
+  $ singularity remote add --builder-type http --builder-uri https://builder.example.com SiteCloud cloud.sylabs.io`
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
 	// remote remove command
 	// ~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~
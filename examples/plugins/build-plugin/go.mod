@@ -0,0 +1,7 @@
+module github.com/sylabs/singularity/build-plugin
+
+go 1.13
+
+require github.com/sylabs/singularity v0.0.0
+
+replace github.com/sylabs/singularity => ./singularity_source
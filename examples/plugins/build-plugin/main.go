@@ -0,0 +1,45 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	pluginapi "github.com/sylabs/singularity/pkg/plugin"
+	buildcallback "github.com/sylabs/singularity/pkg/plugin/callback/build"
+)
+
+// Plugin is the only variable which a plugin MUST export.
+// This symbol is accessed by the plugin framework to initialize the plugin
+var Plugin = pluginapi.Plugin{
+	Manifest: pluginapi.Manifest{
+		Name:        "github.com/sylabs/singularity/build-plugin",
+		Author:      "Sylabs Team",
+		Version:     "0.1.0",
+		Description: "Stamp built images with their bootstrap source",
+	},
+	Callbacks: []pluginapi.Callback{
+		(buildcallback.PreBootstrap)(checkBootstrap),
+		(buildcallback.PreSquashfs)(stampImage),
+	},
+}
+
+// checkBootstrap fails the builds bootstrapped from a local image.
+func checkBootstrap(ctx context.Context, stage buildcallback.Stage) error {
+	if stage.Definition.Header["bootstrap"] == "localimage" {
+		return fmt.Errorf("localimage bootstrap is not allowed on this site")
+	}
+	return nil
+}
+
+// stampImage records the bootstrap source in the final root filesystem.
+func stampImage(ctx context.Context, stage buildcallback.Stage) error {
+	stamp := fmt.Sprintf("bootstrap=%s\nfrom=%s\n", stage.Definition.Header["bootstrap"], stage.Definition.Header["from"])
+	return ioutil.WriteFile(filepath.Join(stage.RootfsPath, ".singularity.d", "build-stamp"), []byte(stamp), 0644)
+}
@@ -17,22 +17,22 @@ var (
 	errInvalidCacheHandle = errors.New("invalid cache handle")
 )
 
-// cleanCache cleans the given type of cache cacheType. It will return a
-// error if one occurs.
-func cleanCache(imgCache *cache.Handle, cacheType string, dryRun bool, days int) error {
+// cleanCache cleans the entries of the given type of cache cacheType
+// selected by policy. It will return a error if one occurs.
+func cleanCache(imgCache *cache.Handle, cacheType string, dryRun bool, policy cache.CleanPolicy) error {
 	if imgCache == nil {
 		return fmt.Errorf("invalid image cache handle")
 	}
-	return imgCache.CleanCache(cacheType, dryRun, days)
+	return imgCache.CleanCache(cacheType, dryRun, policy)
 }
 
 // CleanSingularityCache is the main function that drives all these
 // other functions. If force is true, remove the entries, otherwise only
 // provide a summary of what would have been done. If cacheCleanTypes
 // contains something, only clean that type. The special value "all" is
-// interpreted as "all types of entries". If cacheName contains
-// something, clean only cache entries matching that name.
-func CleanSingularityCache(imgCache *cache.Handle, dryRun bool, cacheCleanTypes []string, days int) error {
+// interpreted as "all types of entries". The policy selects the entries
+// removed by their age and the size of each type of cache.
+func CleanSingularityCache(imgCache *cache.Handle, dryRun bool, cacheCleanTypes []string, policy cache.CleanPolicy) error {
 	if imgCache == nil {
 		return errInvalidCacheHandle
 	}
@@ -48,7 +48,7 @@ func CleanSingularityCache(imgCache *cache.Handle, dryRun bool, cacheCleanTypes
 
 	for _, cacheType := range cachesToClean {
 		sylog.Debugf("Cleaning %s cache...", cacheType)
-		if err := cleanCache(imgCache, cacheType, dryRun, days); err != nil {
+		if err := cleanCache(imgCache, cacheType, dryRun, policy); err != nil {
 			return err
 		}
 	}
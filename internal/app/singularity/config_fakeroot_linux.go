@@ -1,4 +1,4 @@
-// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// Copyright (c) 2019-2020, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -7,10 +7,16 @@ package singularity
 
 import (
 	"fmt"
+	"os"
+	"text/tabwriter"
 
 	"github.com/sylabs/singularity/internal/pkg/fakeroot"
+	"github.com/sylabs/singularity/internal/pkg/util/user"
+	"github.com/sylabs/singularity/pkg/sylog"
 )
 
+const fakerootListLine = "%s\t%s\t%s\t%s\t%s\n"
+
 // FakerootConfigOp defines a type for a fakeroot
 // configuration operation.
 type FakerootConfigOp uint8
@@ -24,11 +30,22 @@ const (
 	FakerootEnableUser
 	// FakerootDisableUser is the operation to disable a user fakeroot mapping.
 	FakerootDisableUser
+	// FakerootAutoAddUser is the operation to add a user fakeroot mapping
+	// in the automatic subordinate ID ranges of /etc/login.defs.
+	FakerootAutoAddUser
 )
 
 // FakerootConfig allows to add/remove/enable/disable a user fakeroot
 // mapping entry in /etc/subuid and /etc/subgid files.
 func FakerootConfig(username string, op FakerootConfigOp) error {
+	// mappings are useless without user namespaces
+	switch op {
+	case FakerootAddUser, FakerootAutoAddUser, FakerootEnableUser:
+		if err := fakeroot.CheckUserNamespace(); err != nil {
+			return err
+		}
+	}
+
 	subUIDConfig, err := fakeroot.GetConfig(fakeroot.SubUIDFile, true, nil)
 	if err != nil {
 		return fmt.Errorf("while opening %s: %s", fakeroot.SubUIDFile, err)
@@ -39,12 +56,27 @@ func FakerootConfig(username string, op FakerootConfigOp) error {
 	}
 
 	switch op {
+	case FakerootAutoAddUser:
+		uidRange, err := fakeroot.GetAutoRange(fakeroot.LoginDefsFile, "UID")
+		if err != nil {
+			return err
+		}
+		gidRange, err := fakeroot.GetAutoRange(fakeroot.LoginDefsFile, "GID")
+		if err != nil {
+			return err
+		}
+		if err := subUIDConfig.AddUserInRange(username, *uidRange); err != nil {
+			return fmt.Errorf("while adding %s to %s: %s", username, fakeroot.SubUIDFile, err)
+		}
+		if err := subGIDConfig.AddUserInRange(username, *gidRange); err != nil {
+			return fmt.Errorf("while adding %s to %s: %s", username, fakeroot.SubGIDFile, err)
+		}
 	case FakerootAddUser:
 		if err := subUIDConfig.AddUser(username); err != nil {
-			return fmt.Errorf("while adding %s: %s", username, err)
+			return fmt.Errorf("while adding %s to %s: %s", username, fakeroot.SubUIDFile, err)
 		}
 		if err := subGIDConfig.AddUser(username); err != nil {
-			return fmt.Errorf("while adding %s: %s", username, err)
+			return fmt.Errorf("while adding %s to %s: %s", username, fakeroot.SubGIDFile, err)
 		}
 	case FakerootRemoveUser:
 		if err := subUIDConfig.RemoveUser(username); err != nil {
@@ -71,6 +103,10 @@ func FakerootConfig(username string, op FakerootConfigOp) error {
 		return fmt.Errorf("unknown configuration operation")
 	}
 
+	if op == FakerootAddUser || op == FakerootAutoAddUser {
+		warnFakerootConflicts(subUIDConfig, subGIDConfig)
+	}
+
 	if err := subUIDConfig.Close(); err != nil {
 		return fmt.Errorf("while writing configuration: %s", err)
 	}
@@ -80,3 +116,77 @@ func FakerootConfig(username string, op FakerootConfigOp) error {
 
 	return nil
 }
+
+// FakerootList prints the fakeroot mapping entries of username, or of
+// all users if username is empty, and reports the conflicting entries of
+// /etc/subuid and /etc/subgid.
+func FakerootList(username string) error {
+	subUIDConfig, err := fakeroot.GetConfig(fakeroot.SubUIDFile, false, nil)
+	if err != nil {
+		return fmt.Errorf("while opening %s: %s", fakeroot.SubUIDFile, err)
+	}
+	defer subUIDConfig.Close()
+	subGIDConfig, err := fakeroot.GetConfig(fakeroot.SubGIDFile, false, nil)
+	if err != nil {
+		return fmt.Errorf("while opening %s: %s", fakeroot.SubGIDFile, err)
+	}
+	defer subGIDConfig.Close()
+
+	var uid uint32
+	if username != "" {
+		u, err := user.GetPwNam(username)
+		if err != nil {
+			return fmt.Errorf("could not retrieve user information for %s: %s", username, err)
+		}
+		uid = u.UID
+	}
+
+	// the subgid entries are listed with the subuid entries of the
+	// same user
+	subGIDs := make(map[uint32][]*fakeroot.Entry)
+	for _, e := range subGIDConfig.Entries() {
+		subGIDs[e.UID] = append(subGIDs[e.UID], e)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, fakerootListLine, "USER", "UID", "SUBUID", "SUBGID", "STATUS")
+	listed := make(map[uint32]bool)
+	for _, e := range subUIDConfig.Entries() {
+		if (username != "" && e.UID != uid) || listed[e.UID] {
+			continue
+		}
+		listed[e.UID] = true
+		name := "-"
+		if u, err := user.GetPwUID(e.UID); err == nil {
+			name = u.Name
+		}
+		subGID := "-"
+		if gids := subGIDs[e.UID]; len(gids) > 0 {
+			subGID = fmt.Sprintf("%d-%d", gids[0].Start, gids[0].End())
+		}
+		status := "enabled"
+		if e.Disabled() {
+			status = "disabled"
+		}
+		fmt.Fprintf(tw, fakerootListLine, name, fmt.Sprint(e.UID), fmt.Sprintf("%d-%d", e.Start, e.End()), subGID, status)
+	}
+	tw.Flush()
+
+	if username != "" && !listed[uid] {
+		return fmt.Errorf("no mapping entry found in %s for %s", fakeroot.SubUIDFile, username)
+	}
+
+	warnFakerootConflicts(subUIDConfig, subGIDConfig)
+	return nil
+}
+
+// warnFakerootConflicts reports the entries of /etc/subuid and /etc/subgid
+// sharing IDs with other entries.
+func warnFakerootConflicts(subUIDConfig, subGIDConfig *fakeroot.Config) {
+	for _, c := range subUIDConfig.Conflicts() {
+		sylog.Warningf("%s: %s", fakeroot.SubUIDFile, c)
+	}
+	for _, c := range subGIDConfig.Conflicts() {
+		sylog.Warningf("%s: %s", fakeroot.SubGIDFile, c)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/sylabs/singularity/pkg/build/types/parser"
+)
+
+// DefFormatClassic is the classic definition file format.
+const DefFormatClassic = "def"
+
+// DefConvert converts the definition file at path to format, either
+// DefFormatClassic, parser.FormatYAML or parser.FormatJSON. The
+// structured definitions are checked against the schema.
+func DefConvert(path, format string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("while reading definition file: %s", err)
+	}
+
+	var sd *parser.StructuredDefinition
+	if from := parser.StructuredFormat(path); from != "" {
+		sd, err = parser.ParseStructured(raw, from)
+	} else {
+		sd, err = parser.ToStructured(raw)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case DefFormatClassic:
+		return sd.Classic()
+	case parser.FormatYAML, parser.FormatJSON:
+		return sd.Marshal(format)
+	}
+	return nil, fmt.Errorf("unknown definition format %q, must be one of %s, %s or %s", format, DefFormatClassic, parser.FormatYAML, parser.FormatJSON)
+}
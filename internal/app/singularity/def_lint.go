@@ -0,0 +1,45 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/sylabs/singularity/pkg/build/types/parser"
+)
+
+// DefLint checks the definition file at path, converted from YAML or
+// JSON if needed, once its %include directives are resolved, args are
+// the values of the definition file variables.
+func DefLint(path string, args map[string]string) ([]parser.Diagnostic, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("while reading definition file: %s", err)
+	}
+
+	if format := parser.StructuredFormat(path); format != "" {
+		raw, err = parser.ClassicDefinition(raw, format)
+		if err != nil {
+			diagnostic := parser.Diagnostic{
+				Severity: parser.SeverityError,
+				Message:  err.Error(),
+			}
+			return []parser.Diagnostic{diagnostic}, nil
+		}
+	}
+
+	raw, _, err = parser.ResolveIncludes(raw, path)
+	if err != nil {
+		diagnostic := parser.Diagnostic{
+			Severity: parser.SeverityError,
+			Message:  err.Error(),
+		}
+		return []parser.Diagnostic{diagnostic}, nil
+	}
+
+	return parser.Lint(raw, args), nil
+}
@@ -0,0 +1,340 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/containers/storage/pkg/archive"
+	"github.com/containers/storage/pkg/idtools"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/build/assemblers"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
+	"github.com/sylabs/singularity/internal/pkg/util/userns"
+	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/image"
+	"github.com/sylabs/singularity/pkg/image/packer"
+	"github.com/sylabs/singularity/pkg/image/unpacker"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// Export formats.
+const (
+	// ExportTar is a tar archive of the root filesystem.
+	ExportTar = "tar"
+	// ExportOCILayout is an OCI image layout directory holding a single
+	// layer image of the root filesystem.
+	ExportOCILayout = "oci-layout"
+	// ExportSquashfs is a squashfs image of the root filesystem.
+	ExportSquashfs = "squashfs"
+)
+
+// ExportOptions are the options of Export.
+type ExportOptions struct {
+	// Format is ExportTar, ExportOCILayout or ExportSquashfs.
+	Format string
+	// Partition is the ID or the name of the squashfs partition
+	// exported, the root filesystem partition if empty.
+	Partition string
+	// App restricts the export to the files of a SCIF app.
+	App string
+	// TmpDir is the directory the partitions are extracted in.
+	TmpDir string
+	// Force overwrites the destination if it exists.
+	Force bool
+}
+
+// exportRootfs is a root filesystem directory to export.
+type exportRootfs struct {
+	path string
+	// files are the paths of the root filesystem exported, all
+	// of them if empty.
+	files []string
+	// idMap is set when the root filesystem was extracted in a user
+	// namespace, its files are owned by the subordinate IDs of the user.
+	idMap *userns.IDMap
+	// jsonObjects are the JSON objects of the image used for the
+	// configuration of OCI images.
+	jsonObjects map[string][]byte
+}
+
+// Export writes the root filesystem of the image path, or the files of an
+// app, to dest in the format of opts. The squashfs partitions of SIF and
+// squashfs images are extracted without privileges: in a user namespace
+// mapping the subordinate IDs of the user so that the files keep their
+// owner, hard links and extended attributes, or as the user if it has no
+// subordinate IDs, the files are then owned by root in the export.
+func Export(path, dest string, opts ExportOptions) error {
+	switch opts.Format {
+	case ExportTar, ExportOCILayout, ExportSquashfs:
+	default:
+		return fmt.Errorf("unrecognized export format %s, it must be tar, oci-layout or squashfs", opts.Format)
+	}
+	if opts.App != "" && opts.Format == ExportOCILayout {
+		return fmt.Errorf("an app can't be exported as an OCI image")
+	}
+	if dest == "-" {
+		if opts.Format != ExportTar {
+			return fmt.Errorf("only tar archives can be written to the standard output")
+		}
+	} else if _, err := os.Lstat(dest); err == nil && !opts.Force {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", dest)
+	}
+
+	img, err := image.Init(path, false)
+	if err != nil {
+		return fmt.Errorf("while opening image %s: %v", path, err)
+	}
+	defer img.File.Close()
+
+	if img.Type == image.SANDBOX {
+		if opts.Partition != "" {
+			return fmt.Errorf("sandbox images have no partitions")
+		}
+		r := &exportRootfs{path: img.Path}
+		if opts.App != "" {
+			if opts.Format != ExportTar {
+				return fmt.Errorf("apps of sandbox images are only exported as tar archives")
+			}
+			if r.files, err = appFiles(img.Path, opts.App); err != nil {
+				return err
+			}
+		}
+		return r.export(dest, opts)
+	}
+
+	part, err := exportPartition(img, opts.Partition)
+	if err != nil {
+		return err
+	}
+	if part.Type != image.SQUASHFS {
+		return fmt.Errorf("only squashfs partitions can be exported without privileges")
+	}
+
+	// the partition is copied as is, along with all of its metadata
+	if opts.Format == ExportSquashfs && opts.App == "" {
+		return copyPartition(img, part, dest)
+	}
+
+	tmpDir, err := ioutil.TempDir(opts.TmpDir, "export-")
+	if err != nil {
+		return err
+	}
+	r := &exportRootfs{path: filepath.Join(tmpDir, "rootfs")}
+	defer func() {
+		if r.idMap != nil {
+			if err := r.idMap.RemoveAll(tmpDir); err != nil {
+				sylog.Warningf("While removing %s: %v", tmpDir, err)
+			}
+			return
+		}
+		os.RemoveAll(tmpDir)
+	}()
+
+	if err := r.extract(img, part, opts.App); err != nil {
+		return err
+	}
+	if opts.App != "" {
+		if r.files, err = appFiles(r.path, opts.App); err != nil {
+			return err
+		}
+	}
+	if opts.Format == ExportOCILayout {
+		if r.jsonObjects, err = imageJSONObjects(img); err != nil {
+			return err
+		}
+	}
+	return r.export(dest, opts)
+}
+
+// exportPartition returns the partition of img whose ID or name is ref,
+// or its root filesystem partition if ref is empty.
+func exportPartition(img *image.Image, ref string) (*image.Section, error) {
+	if ref == "" {
+		return img.GetRootFsPartition()
+	}
+	id, _ := strconv.ParseUint(ref, 10, 32)
+	for i, p := range img.Partitions {
+		if uint64(p.ID) == id || p.Name == ref {
+			return &img.Partitions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no partition %s in %s", ref, img.Path)
+}
+
+// copyPartition writes the data of the partition part of img to dest.
+func copyPartition(img *image.Image, part *image.Section, dest string) error {
+	f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := io.NewSectionReader(img.File, int64(part.Offset), int64(part.Size))
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("while copying partition: %v", err)
+	}
+	return f.Close()
+}
+
+// appFiles returns the paths of the app directories relative to rootfs.
+func appFiles(rootfs, app string) ([]string, error) {
+	files := []string{filepath.Join("scif", "apps", app)}
+	if _, err := os.Stat(filepath.Join(rootfs, files[0])); err != nil {
+		return nil, fmt.Errorf("no app %s in the image", app)
+	}
+	data := filepath.Join("scif", "data", app)
+	if _, err := os.Stat(filepath.Join(rootfs, data)); err == nil {
+		files = append(files, data)
+	}
+	return files, nil
+}
+
+// imageJSONObjects returns the OCI configuration and the environment of
+// the image, stored in the bundle JSON objects by the build.
+func imageJSONObjects(img *image.Image) (map[string][]byte, error) {
+	objects := make(map[string][]byte)
+	for _, name := range []string{types.OCIConfigJSON, types.EnvironmentJSON} {
+		r, err := image.NewSectionReader(img, name+".json", -1)
+		if err == image.ErrNoSection {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("while reading %s: %v", name, err)
+		}
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("while reading %s: %v", name, err)
+		}
+		objects[name] = data
+	}
+	return objects, nil
+}
+
+// extract extracts the files of app, or all files, of the squashfs
+// partition part of img in a user namespace if possible.
+func (r *exportRootfs) extract(img *image.Image, part *image.Section, app string) error {
+	u := unpacker.NewSquashfs()
+	if !u.HasUnsquashfs() {
+		return fmt.Errorf("unsquashfs is required to export the partition")
+	}
+
+	if os.Geteuid() != 0 {
+		idMap, err := userns.CurrentUser()
+		if err == nil {
+			u.Run = idMap.Run
+			r.idMap = idMap
+		} else if errors.Is(err, userns.ErrUnavailable) {
+			sylog.Warningf("Extracting partition without user namespace, files will be owned by root: %v", err)
+		} else {
+			return err
+		}
+	}
+
+	reader := io.NewSectionReader(img.File, int64(part.Offset), int64(part.Size))
+	if app == "" {
+		return u.ExtractAll(reader, r.path)
+	}
+	files := []string{"/scif/apps/" + app, "/scif/data/" + app}
+	return u.ExtractFiles(files, reader, r.path)
+}
+
+// export writes the root filesystem to dest in the format of opts.
+func (r *exportRootfs) export(dest string, opts ExportOptions) error {
+	switch opts.Format {
+	case ExportTar:
+		return r.writeTar(dest)
+	case ExportOCILayout:
+		b := &types.Bundle{
+			RootfsPath:    r.path,
+			TmpDir:        opts.TmpDir,
+			JSONObjects:   r.jsonObjects,
+			UserNamespace: r.idMap,
+		}
+		if b.TmpDir == "" {
+			b.TmpDir = os.TempDir()
+		}
+		if b.JSONObjects == nil {
+			b.JSONObjects = make(map[string][]byte)
+		}
+		a := &assemblers.OCIAssembler{Format: assemblers.FormatOCI}
+		return a.Assemble(b, dest)
+	default:
+		return r.writeSquashfs(dest)
+	}
+}
+
+// writeTar writes the tar archive of the root filesystem to dest, or to
+// the standard output if dest is -. The files extracted in a user
+// namespace get back their IDs in the image, the files of an image
+// extracted by a user without subordinate IDs are owned by root.
+func (r *exportRootfs) writeTar(dest string) error {
+	opts := &archive.TarOptions{
+		Compression:  archive.Uncompressed,
+		IncludeFiles: r.files,
+	}
+	if r.idMap != nil {
+		for _, m := range r.idMap.UIDMappings {
+			opts.UIDMaps = append(opts.UIDMaps, exportIDMap(m))
+		}
+		for _, m := range r.idMap.GIDMappings {
+			opts.GIDMaps = append(opts.GIDMaps, exportIDMap(m))
+		}
+	} else if os.Geteuid() != 0 {
+		opts.ChownOpts = &idtools.IDPair{UID: 0, GID: 0}
+	}
+
+	rc, err := archive.TarWithOptions(r.path, opts)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	w := os.Stdout
+	if dest != "-" {
+		f, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	if _, err := io.Copy(w, rc); err != nil {
+		return fmt.Errorf("while writing tar archive: %v", err)
+	}
+	return w.Close()
+}
+
+// writeSquashfs creates the squashfs image dest of the root filesystem,
+// only the files of the app are extracted when exporting one.
+func (r *exportRootfs) writeSquashfs(dest string) error {
+	mksquashfs, err := squashfs.GetPath()
+	if err != nil {
+		return fmt.Errorf("mksquashfs is required to create the squashfs image: %v", err)
+	}
+	p := packer.Squashfs{MksquashfsPath: mksquashfs}
+
+	flags := []string{"-noappend"}
+	if r.idMap != nil {
+		p.Run = r.idMap.Run
+	} else if os.Geteuid() != 0 {
+		flags = append(flags, "-all-root")
+	}
+	return p.Create([]string{r.path}, dest, flags)
+}
+
+func exportIDMap(m specs.LinuxIDMapping) idtools.IDMap {
+	return idtools.IDMap{
+		ContainerID: int(m.ContainerID),
+		HostID:      int(m.HostID),
+		Size:        int(m.Size),
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// fakeSquashfs returns the data of a partition with a squashfs super block.
+func fakeSquashfs() []byte {
+	b := make([]byte, 8192)
+	copy(b, "hsqs")
+	// zlib compression
+	binary.LittleEndian.PutUint16(b[20:], 1)
+	for i := 96; i < len(b); i++ {
+		b[i] = byte(i)
+	}
+	return b
+}
+
+func TestExportSquashfs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	part := fakeSquashfs()
+	input := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Size:     int64(len(part)),
+		Fname:    "rootfs",
+		Fp:       bytes.NewReader(part),
+	}
+	if err := input.SetPartExtra(sif.FsSquash, sif.PartPrimSys, sif.GetSIFArch("amd64")); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "image.sif")
+	fimg, err := sif.CreateContainer(sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: []sif.DescriptorInput{input},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fimg.UnloadContainer()
+
+	dest := filepath.Join(dir, "rootfs.sqfs")
+	tests := []struct {
+		name    string
+		opts    ExportOptions
+		wantErr bool
+	}{
+		{"Squashfs", ExportOptions{Format: ExportSquashfs}, false},
+		{"Exists", ExportOptions{Format: ExportSquashfs}, true},
+		{"Force", ExportOptions{Format: ExportSquashfs, Force: true}, false},
+		{"PartitionID", ExportOptions{Format: ExportSquashfs, Partition: "1", Force: true}, false},
+		{"NoPartition", ExportOptions{Format: ExportSquashfs, Partition: "2", Force: true}, true},
+		{"BadFormat", ExportOptions{Format: "zip", Force: true}, true},
+		{"AppOCILayout", ExportOptions{Format: ExportOCILayout, App: "foo", Force: true}, true},
+		{"StdoutSquashfs", ExportOptions{Format: ExportSquashfs, Force: true}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := dest
+			if tt.name == "StdoutSquashfs" {
+				d = "-"
+			}
+			err := Export(path, d, tt.opts)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			b, err := ioutil.ReadFile(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b, part) {
+				t.Errorf("exported partition differs from the image partition")
+			}
+		})
+	}
+}
+
+func TestExportSandboxTar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	for _, d := range []string{"etc", "scif/apps/foo/bin", "scif/data/foo"} {
+		if err := os.MkdirAll(filepath.Join(rootfs, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "etc", "hosts"), []byte("hosts"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(filepath.Join(rootfs, "etc", "hosts"), filepath.Join(rootfs, "etc", "hosts.link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "scif", "apps", "foo", "bin", "foo"), []byte("foo"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		app     string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "Rootfs",
+			want: []string{
+				"etc/", "etc/hosts", "etc/hosts.link", "scif/", "scif/apps/",
+				"scif/apps/foo/", "scif/apps/foo/bin/", "scif/apps/foo/bin/foo",
+				"scif/data/", "scif/data/foo/",
+			},
+		},
+		{
+			name: "App",
+			app:  "foo",
+			want: []string{
+				"scif/apps/foo/", "scif/apps/foo/bin/", "scif/apps/foo/bin/foo",
+				"scif/data/foo/",
+			},
+		},
+		{
+			name:    "NoApp",
+			app:     "bar",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dest := filepath.Join(dir, tt.name+".tar")
+			err := Export(rootfs, dest, ExportOptions{Format: ExportTar, App: tt.app})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			f, err := os.Open(dest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			var names []string
+			tr := tar.NewReader(f)
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if hdr.Name == "etc/hosts.link" && (hdr.Typeflag != tar.TypeLink || hdr.Linkname != "etc/hosts") {
+					t.Errorf("hard link not preserved: %+v", hdr)
+				}
+				if hdr.Name == "./" || hdr.Name == "/" {
+					continue
+				}
+				names = append(names, hdr.Name)
+			}
+			sort.Strings(names)
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("unexpected entries %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
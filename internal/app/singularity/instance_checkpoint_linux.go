@@ -0,0 +1,577 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/instance"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/internal/pkg/util/user"
+	"github.com/sylabs/singularity/pkg/image"
+	"github.com/sylabs/singularity/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/util/loop"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// CheckpointObject is the name of the SIF data object holding an
+	// instance checkpoint.
+	CheckpointObject = "criu-checkpoint.tar"
+	// checkpointInstanceFile is the copy of the instance file stored
+	// along with the CRIU images.
+	checkpointInstanceFile = "instance.json"
+	// checkpointRootfs is the CRIU name of the container root mount, it's
+	// an external mount provided by the restoring process.
+	checkpointRootfs = "rootfs"
+	// restoreEnv holds the arguments of the process restoring an instance.
+	restoreEnv = "SINGULARITY_RESTORE_INSTANCE"
+	// restoreReadyFd is the descriptor where the restoring process writes
+	// a null byte followed by the restored PID, or an error message.
+	restoreReadyFd = 3
+)
+
+// restoreArgs are the arguments of the process restoring an instance.
+type restoreArgs struct {
+	Dir   string `json:"dir"`
+	Image string `json:"image"`
+}
+
+// CheckpointInstance checkpoints the instance name with CRIU. The CRIU
+// images are stored in the checkpoint directory of the instance, or in a
+// data object of the instance SIF image if toSIF is true. The instance is
+// stopped by the checkpoint unless leaveRunning is true.
+func CheckpointInstance(name string, leaveRunning, toSIF bool) error {
+	i, err := instance.Get(name, instance.SingSubDir)
+	if err != nil {
+		return err
+	}
+	criu, err := exec.LookPath("criu")
+	if err != nil {
+		return fmt.Errorf("criu is required to checkpoint instances: %v", err)
+	}
+
+	dir, err := instance.GetDir(name, instance.CheckpointSubDir)
+	if err != nil {
+		return err
+	}
+	// a checkpoint replaces the previous one of the instance
+	if err := os.RemoveAll(dir); err != nil {
+		return fmt.Errorf("while removing previous checkpoint: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("while creating checkpoint directory: %v", err)
+	}
+	b, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, checkpointInstanceFile), b, 0600); err != nil {
+		return fmt.Errorf("while writing checkpoint instance file: %v", err)
+	}
+
+	sylog.Infof("Checkpointing %s instance of %s (PID=%d)", i.Name, i.Image, i.Pid)
+	cmd := exec.Command(criu, criuDumpArgs(i.Pid, dir, leaveRunning, os.Geteuid() != 0)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("criu dump failed: %v: %s, see %s", err, out, filepath.Join(dir, "dump.log"))
+	}
+
+	if !toSIF {
+		return nil
+	}
+	if err := addCheckpointObject(i.Image, dir); err != nil {
+		return fmt.Errorf("while storing checkpoint in %s: %v", i.Image, err)
+	}
+	return os.RemoveAll(dir)
+}
+
+// RestoreInstance restores an instance checkpointed by CheckpointInstance
+// from the checkpoint directory of the instance nameOrImage, or from the
+// checkpoint object of the SIF image nameOrImage. The container root
+// filesystem is mounted from the instance image, the SIF image holding
+// the checkpoint in the latter case.
+func RestoreInstance(nameOrImage string) error {
+	var dir, img string
+
+	if fs.IsFile(nameOrImage) {
+		var err error
+		img, err = filepath.Abs(nameOrImage)
+		if err != nil {
+			return err
+		}
+		dir, err = extractCheckpointObject(img)
+		if err != nil {
+			return fmt.Errorf("while extracting checkpoint from %s: %v", img, err)
+		}
+	} else {
+		var err error
+		dir, err = instance.GetDir(nameOrImage, instance.CheckpointSubDir)
+		if err != nil {
+			return err
+		}
+		if !fs.IsDir(dir) {
+			return fmt.Errorf("no checkpoint found for instance %s", nameOrImage)
+		}
+	}
+
+	saved, err := readCheckpointInstance(dir)
+	if err != nil {
+		return err
+	}
+	if img == "" {
+		img = saved.Image
+	}
+	file, err := instance.Add(saved.Name, instance.SingSubDir)
+	if err != nil {
+		return err
+	}
+	if _, err := exec.LookPath("criu"); err != nil {
+		return fmt.Errorf("criu is required to restore instances: %v", err)
+	}
+
+	u, err := user.Current()
+	if err != nil {
+		return err
+	}
+	procname, err := instance.ProcName(saved.Name, u.Name)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(restoreArgs{Dir: dir, Image: img})
+	if err != nil {
+		return err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// the restoring process is the parent of the restored container, its
+	// process name identifies it as an instance process
+	c := &exec.Cmd{
+		Path:        "/proc/self/exe",
+		Args:        []string{procname},
+		Env:         append(append(os.Environ(), sylog.GetEnvVars()...), restoreEnv+"="+string(b)),
+		ExtraFiles:  []*os.File{w},
+		SysProcAttr: &syscall.SysProcAttr{Setsid: true},
+	}
+	err = c.Start()
+	w.Close()
+	if err != nil {
+		return fmt.Errorf("while starting restore process: %v", err)
+	}
+
+	msg, _ := ioutil.ReadAll(r)
+	if len(msg) == 0 || msg[0] != 0 {
+		c.Wait()
+		if len(msg) == 0 {
+			return errors.New("restore process exited unexpectedly")
+		}
+		return errors.New(string(msg))
+	}
+	pid, err := strconv.Atoi(string(msg[1:]))
+	if err != nil {
+		return fmt.Errorf("unexpected restored PID %q", msg[1:])
+	}
+
+	path := file.Path
+	*file = *saved
+	file.Path = path
+	file.Pid = pid
+	file.PPid = c.Process.Pid
+	file.Image = img
+	c.Process.Release()
+
+	sylog.Infof("Restored %s instance of %s (PID=%d)", file.Name, file.Image, file.Pid)
+	return file.Update()
+}
+
+// RestoreChild restores the instance of a process started by
+// RestoreInstance and exits once the restored container exits, it
+// returns immediately otherwise. It must be called at the beginning of
+// main.
+func RestoreChild() {
+	v, ok := os.LookupEnv(restoreEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(restoreEnv)
+
+	ready := os.NewFile(restoreReadyFd, "ready")
+	if err := restoreCheckpoint(v, ready); err != nil {
+		// the error is reported by RestoreInstance until the
+		// container is restored
+		if _, werr := ready.Write([]byte(err.Error())); werr != nil {
+			sylog.Errorf("While restoring instance: %v", err)
+		}
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// restoreCheckpoint restores the checkpoint described by the JSON
+// arguments v and waits for the restored container, the restored PID is
+// written to ready once the container is restored.
+func restoreCheckpoint(v string, ready *os.File) error {
+	var args restoreArgs
+	if err := json.Unmarshal([]byte(v), &args); err != nil {
+		return fmt.Errorf("while reading restore arguments: %v", err)
+	}
+	criu, err := exec.LookPath("criu")
+	if err != nil {
+		return err
+	}
+
+	// the root filesystem may be mounted in a new mount namespace of
+	// this thread, criu must be started from it
+	runtime.LockOSThread()
+	rootfs, cleanup, err := mountCheckpointRootfs(args.Image)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	// the restored container is re-parented to this process once
+	// detached from criu
+	if err := unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("while setting child subreaper: %v", err)
+	}
+	pidFile := filepath.Join(args.Dir, "restore.pid")
+	cmd := exec.Command(criu, criuRestoreArgs(args.Dir, rootfs, pidFile, os.Geteuid() != 0)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("criu restore failed: %v: %s, see %s", err, out, filepath.Join(args.Dir, "restore.log"))
+	}
+	b, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		return fmt.Errorf("while reading restored PID: %v", err)
+	}
+	os.Remove(pidFile)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("unexpected restored PID %q", b)
+	}
+
+	ready.Write(append([]byte{0}, strconv.Itoa(pid)...))
+	ready.Close()
+
+	for {
+		var status unix.WaitStatus
+		wpid, err := unix.Wait4(-1, &status, 0, nil)
+		if err == unix.EINTR {
+			continue
+		} else if err != nil || wpid == pid {
+			return nil
+		}
+	}
+}
+
+// mountCheckpointRootfs returns the root filesystem of the image path
+// used as external root mount of a restored container and a function
+// releasing it. The root filesystem of a SIF or a filesystem image is
+// mounted in a new mount namespace, which requires root privileges.
+func mountCheckpointRootfs(path string) (string, func(), error) {
+	img, err := image.Init(path, false)
+	if err != nil {
+		return "", nil, err
+	}
+	defer img.File.Close()
+
+	part, err := img.GetRootFsPartition()
+	if err != nil {
+		return "", nil, fmt.Errorf("while getting root filesystem: %v", err)
+	}
+
+	var fstype string
+	switch part.Type {
+	case image.SANDBOX:
+		return img.Path, func() {}, nil
+	case image.SQUASHFS:
+		fstype = "squashfs"
+	case image.EXT3:
+		fstype = "ext3"
+	case image.EROFS:
+		fstype = "erofs"
+	default:
+		return "", nil, fmt.Errorf("root filesystem of %s can't be restored", path)
+	}
+	if os.Geteuid() != 0 {
+		return "", nil, fmt.Errorf("restoring an instance from %s requires root privileges, only sandbox images can be restored by users", path)
+	}
+
+	if err := unix.Unshare(unix.CLONE_NEWNS); err != nil {
+		return "", nil, fmt.Errorf("while creating mount namespace: %v", err)
+	}
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return "", nil, fmt.Errorf("while setting mount propagation: %v", err)
+	}
+
+	var number int
+	loopdev := &loop.Device{
+		MaxLoopDevices: 256,
+		Info: &loop.Info64{
+			Offset:    part.Offset,
+			SizeLimit: part.Size,
+			Flags:     loop.FlagsAutoClear,
+		},
+	}
+	if err := loopdev.AttachFromFile(img.File, os.O_RDONLY, &number); err != nil {
+		return "", nil, fmt.Errorf("while attaching image to loop device: %v", err)
+	}
+
+	mountpoint, err := ioutil.TempDir("", "checkpoint-rootfs-")
+	if err != nil {
+		return "", nil, err
+	}
+	dev := fmt.Sprintf("/dev/loop%d", number)
+	if err := unix.Mount(dev, mountpoint, fstype, unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+		os.Remove(mountpoint)
+		return "", nil, fmt.Errorf("while mounting %s: %v", dev, err)
+	}
+	return mountpoint, func() {
+		unix.Unmount(mountpoint, unix.MNT_DETACH)
+		os.Remove(mountpoint)
+	}, nil
+}
+
+// criuDumpArgs returns the criu arguments checkpointing the container
+// process tree pid in dir. The mounts bound from the host are recorded
+// as external mounts and the container root filesystem, mounted from the
+// image, is provided back at restore time.
+func criuDumpArgs(pid int, dir string, leaveRunning, unprivileged bool) []string {
+	args := []string{
+		"dump",
+		"--tree", strconv.Itoa(pid),
+		"--images-dir", dir,
+		"--log-file", "dump.log",
+		// the session of the instance belongs to its parent process
+		"--shell-job",
+		"--file-locks",
+		"--link-remap",
+		"--ext-mount-map", "auto",
+		"--enable-external-sharing",
+		"--enable-external-masters",
+		"--external", "mnt[/]:" + checkpointRootfs,
+	}
+	if leaveRunning {
+		args = append(args, "--leave-running")
+	}
+	if unprivileged {
+		args = append(args, "--unprivileged")
+	}
+	return args
+}
+
+// criuRestoreArgs returns the criu arguments restoring the checkpoint of
+// dir with the root filesystem rootfs, the restored PID is written to
+// pidFile.
+func criuRestoreArgs(dir, rootfs, pidFile string, unprivileged bool) []string {
+	args := []string{
+		"restore",
+		"--images-dir", dir,
+		"--log-file", "restore.log",
+		"--pidfile", pidFile,
+		"--restore-detached",
+		"--shell-job",
+		"--file-locks",
+		"--link-remap",
+		"--ext-mount-map", "auto",
+		"--enable-external-sharing",
+		"--enable-external-masters",
+		"--external", "mnt[" + checkpointRootfs + "]:" + rootfs,
+	}
+	if unprivileged {
+		args = append(args, "--unprivileged")
+	}
+	return args
+}
+
+// readCheckpointInstance returns the instance file stored in the
+// checkpoint directory dir.
+func readCheckpointInstance(dir string) (*instance.File, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, checkpointInstanceFile))
+	if err != nil {
+		return nil, fmt.Errorf("while reading checkpoint instance file: %v", err)
+	}
+	f := &instance.File{}
+	if err := json.Unmarshal(b, f); err != nil {
+		return nil, fmt.Errorf("while decoding checkpoint instance file: %v", err)
+	}
+	if err := instance.CheckName(f.Name); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// addCheckpointObject stores the checkpoint directory dir in a data
+// object of the SIF image path, replacing a previous checkpoint. The
+// object is added outside of the object groups, the signatures of the
+// image still match.
+func addCheckpointObject(path, dir string) error {
+	f, err := ioutil.TempFile("", "checkpoint-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := archiveCheckpoint(f, dir); err != nil {
+		return err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return err
+	}
+	defer fimg.UnloadContainer()
+
+	for _, d := range fimg.DescrArr {
+		if d.Used && d.Datatype == sif.DataGeneric && d.GetName() == CheckpointObject {
+			if err := removeObject(&fimg, d.ID); err != nil {
+				return fmt.Errorf("while removing previous checkpoint: %v", err)
+			}
+		}
+	}
+
+	input := sif.DescriptorInput{
+		Datatype: sif.DataGeneric,
+		Groupid:  sif.DescrUnusedGroup,
+		Link:     sif.DescrUnusedLink,
+		Size:     size,
+		Fname:    CheckpointObject,
+		Fp:       f,
+	}
+	return fimg.AddObject(input)
+}
+
+// extractCheckpointObject extracts the checkpoint object of the SIF image
+// path in the checkpoint directory of the checkpointed instance and
+// returns the directory.
+func extractCheckpointObject(path string) (string, error) {
+	img, err := image.Init(path, false)
+	if err != nil {
+		return "", err
+	}
+	defer img.File.Close()
+
+	// the instance name comes from the archive itself
+	r, err := image.NewSectionReader(img, CheckpointObject, -1)
+	if err == image.ErrNoSection {
+		return "", errors.New("no checkpoint found")
+	} else if err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempDir("", "checkpoint-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := extractCheckpoint(r, tmp, checkpointInstanceFile); err != nil {
+		return "", err
+	}
+	saved, err := readCheckpointInstance(tmp)
+	if err != nil {
+		return "", err
+	}
+
+	dir, err := instance.GetDir(saved.Name, instance.CheckpointSubDir)
+	if err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	r, err = image.NewSectionReader(img, CheckpointObject, -1)
+	if err != nil {
+		return "", err
+	}
+	return dir, extractCheckpoint(r, dir, "")
+}
+
+// archiveCheckpoint writes the files of the checkpoint directory dir to
+// w as a tar archive.
+func archiveCheckpoint(w io.Writer, dir string) error {
+	tw := tar.NewWriter(w)
+
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		hdr, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		f, err := os.Open(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// extractCheckpoint extracts the files of the tar archive r written by
+// archiveCheckpoint in dir, only the file name is extracted if not empty.
+func extractCheckpoint(r io.Reader, dir, name string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		// checkpoint archives are flat
+		base := filepath.Base(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || base != hdr.Name || (name != "" && base != name) {
+			continue
+		}
+		f, err := os.OpenFile(filepath.Join(dir, base), os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCriuArgs(t *testing.T) {
+	dump := criuDumpArgs(42, "/ckpt", true, false)
+	want := []string{
+		"dump",
+		"--tree", "42",
+		"--images-dir", "/ckpt",
+		"--log-file", "dump.log",
+		"--shell-job",
+		"--file-locks",
+		"--link-remap",
+		"--ext-mount-map", "auto",
+		"--enable-external-sharing",
+		"--enable-external-masters",
+		"--external", "mnt[/]:rootfs",
+		"--leave-running",
+	}
+	if !reflect.DeepEqual(dump, want) {
+		t.Errorf("unexpected dump arguments %v", dump)
+	}
+
+	restore := criuRestoreArgs("/ckpt", "/mnt", "/ckpt/restore.pid", true)
+	if restore[0] != "restore" || restore[len(restore)-1] != "--unprivileged" {
+		t.Errorf("unexpected restore arguments %v", restore)
+	}
+	// the root mount of the dump is provided by the restore
+	if got := restore[len(restore)-2]; got != "mnt[rootfs]:/mnt" {
+		t.Errorf("unexpected external root mount %q", got)
+	}
+}
+
+func TestCheckpointArchive(t *testing.T) {
+	src, err := ioutil.TempDir("", "checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "checkpoint-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dst)
+
+	files := map[string]string{
+		checkpointInstanceFile: `{"name":"job","image":"/job.sif"}`,
+		"pages-1.img":          "pages",
+		"inventory.img":        "inventory",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(src, "dir"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	if err := archiveCheckpoint(&b, src); err != nil {
+		t.Fatalf("unexpected archive error: %v", err)
+	}
+
+	// only the instance file
+	if err := extractCheckpoint(bytes.NewReader(b.Bytes()), dst, checkpointInstanceFile); err != nil {
+		t.Fatalf("unexpected extract error: %v", err)
+	}
+	f, err := readCheckpointInstance(dst)
+	if err != nil {
+		t.Fatalf("unexpected instance file error: %v", err)
+	}
+	if f.Name != "job" || f.Image != "/job.sif" {
+		t.Errorf("unexpected instance file %+v", f)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "pages-1.img")); !os.IsNotExist(err) {
+		t.Errorf("unexpected extracted file pages-1.img")
+	}
+
+	if err := extractCheckpoint(bytes.NewReader(b.Bytes()), dst, ""); err != nil {
+		t.Fatalf("unexpected extract error: %v", err)
+	}
+	for name, content := range files {
+		got, err := ioutil.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", name, err)
+		} else if string(got) != content {
+			t.Errorf("unexpected content of %s: %q", name, got)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dst, "dir")); !os.IsNotExist(err) {
+		t.Errorf("unexpected extracted directory")
+	}
+}
@@ -10,11 +10,16 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"syscall"
 	"text/tabwriter"
 	"time"
 
+	units "github.com/docker/go-units"
+	"github.com/sylabs/singularity/internal/pkg/cgroups"
 	"github.com/sylabs/singularity/internal/pkg/instance"
+	"github.com/sylabs/singularity/pkg/runtime/engine/config"
+	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engine/singularity/config"
 	"github.com/sylabs/singularity/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/util/fs/proc"
 )
@@ -184,3 +189,120 @@ func killInstance(i *instance.File, sig syscall.Signal, stoppedPID chan<- int) {
 		time.Sleep(10 * time.Millisecond)
 	}
 }
+
+// PrintInstanceLogs copies the content of the output and error log files
+// of the instance identified by name to stdout and stderr respectively.
+// If follow is true, it keeps copying newly written content until the
+// instance exits.
+func PrintInstanceLogs(stdout, stderr io.Writer, name, user string, follow bool) error {
+	ii, err := instance.List(user, name, instance.SingSubDir)
+	if err != nil {
+		return fmt.Errorf("could not retrieve instance list: %v", err)
+	}
+	if len(ii) != 1 {
+		return fmt.Errorf("no instance found with name %s", name)
+	}
+	i := ii[0]
+
+	outFile, err := os.Open(i.LogOutPath)
+	if err != nil {
+		return fmt.Errorf("could not open instance output log: %v", err)
+	}
+	defer outFile.Close()
+
+	errFile, err := os.Open(i.LogErrPath)
+	if err != nil {
+		return fmt.Errorf("could not open instance error log: %v", err)
+	}
+	defer errFile.Close()
+
+	for {
+		if _, err := io.Copy(stdout, outFile); err != nil {
+			return fmt.Errorf("could not read instance output log: %v", err)
+		}
+		if _, err := io.Copy(stderr, errFile); err != nil {
+			return fmt.Errorf("could not read instance error log: %v", err)
+		}
+		if !follow {
+			return nil
+		}
+		if err := syscall.Kill(i.PPid, 0); err == syscall.ESRCH {
+			follow = false
+			continue
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// instanceStats are the resources usage of an instance in JSON format.
+type instanceStats struct {
+	Instance string `json:"instance"`
+	Pid      int    `json:"pid"`
+	*cgroups.Stats
+}
+
+// PrintInstanceStats prints the resources usage of the cgroup of the
+// instance identified by name in a table, or in JSON format if formatJSON
+// is true. The instance must have been started with cgroups.
+func PrintInstanceStats(w io.Writer, name, user string, formatJSON bool) error {
+	ii, err := instance.List(user, name, instance.SingSubDir)
+	if err != nil {
+		return fmt.Errorf("could not retrieve instance list: %v", err)
+	}
+	if len(ii) != 1 {
+		return fmt.Errorf("no instance found with name %s", name)
+	}
+	i := ii[0]
+
+	engineConfig := singularityConfig.NewConfig()
+	if err := json.Unmarshal(i.Config, &config.Common{EngineConfig: engineConfig}); err != nil {
+		return fmt.Errorf("could not read instance configuration: %v", err)
+	}
+	// without cgroups the instance process is in the cgroup of its
+	// parent, the usage of other processes would be reported
+	if engineConfig.GetCgroupsPath() == "" && engineConfig.GetCgroupsResources() == nil {
+		return fmt.Errorf("instance %s has no cgroup, it must be started with --apply-cgroups or resource limit flags", name)
+	}
+
+	manager := &cgroups.Manager{Pid: i.Pid}
+	stats, err := manager.Stats()
+	if err != nil {
+		return fmt.Errorf("could not read cgroup of instance %s: %v", name, err)
+	}
+
+	if formatJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(instanceStats{Instance: i.Name, Pid: i.Pid, Stats: stats}); err != nil {
+			return fmt.Errorf("could not encode instance stats: %v", err)
+		}
+		return nil
+	}
+
+	limit := func(v uint64, format func(uint64) string) string {
+		if v == 0 {
+			return "-"
+		}
+		return format(v)
+	}
+	bytes := func(v uint64) string { return units.BytesSize(float64(v)) }
+	count := func(v uint64) string { return strconv.FormatUint(v, 10) }
+
+	tabWriter := tabwriter.NewWriter(w, 0, 8, 4, ' ', 0)
+	defer tabWriter.Flush()
+
+	_, err = fmt.Fprintln(tabWriter, "INSTANCE NAME\tPID\tCPU TIME\tMEMORY USAGE / LIMIT\tPIDS / LIMIT")
+	if err != nil {
+		return fmt.Errorf("could not write stats header: %v", err)
+	}
+	_, err = fmt.Fprintf(tabWriter, "%s\t%d\t%s\t%s / %s\t%d / %s\n",
+		i.Name, i.Pid,
+		time.Duration(stats.CPUUsage).Round(10*time.Millisecond),
+		bytes(stats.MemoryUsage), limit(stats.MemoryLimit, bytes),
+		stats.Pids, limit(stats.PidsLimit, count),
+	)
+	if err != nil {
+		return fmt.Errorf("could not write instance stats: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,676 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/pkg/image"
+	"github.com/sylabs/singularity/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/util/crypt"
+	"golang.org/x/sys/unix"
+)
+
+// OverlayMinSize is the minimum size in MiB of an overlay image.
+const OverlayMinSize = 64
+
+// overlayPartName is the name of the overlay partitions embedded in a SIF
+// image.
+const overlayPartName = "overlay"
+
+// OverlayOptions are the options of the overlay images created, resized
+// or synchronized.
+type OverlayOptions struct {
+	// Sparse doesn't allocate the unused blocks of the image.
+	Sparse bool
+	// Fakeroot makes the upper and work directories and the created
+	// directories owned by root to use the overlay with --fakeroot,
+	// they're owned by the user otherwise.
+	Fakeroot bool
+	// Dirs are the directories created in the upper directory.
+	Dirs []string
+	// EncryptionKeys are the passphrases of the LUKS key slots of an
+	// encrypted overlay, the overlay isn't encrypted if empty.
+	EncryptionKeys []crypt.KeyProvider
+}
+
+// overlayTool returns the path of an e2fsprogs program.
+func overlayTool(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	for _, dir := range []string{"/sbin", "/usr/sbin"} {
+		path := filepath.Join(dir, name)
+		if fs.IsExec(path) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found, e2fsprogs is required", name)
+}
+
+// runOverlayTool runs an e2fsprogs program, ok are the exit codes which
+// aren't errors besides 0.
+func runOverlayTool(name string, ok []int, args ...string) error {
+	path, err := overlayTool(name)
+	if err != nil {
+		return err
+	}
+
+	var out bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	sylog.Debugf("Running %s %s", path, strings.Join(args, " "))
+
+	err = cmd.Run()
+	if exitErr, isExit := err.(*exec.ExitError); isExit {
+		for _, code := range ok {
+			if exitErr.ExitCode() == code {
+				return nil
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", name, err, strings.TrimSpace(out.String()))
+	}
+	return nil
+}
+
+// OverlayCreate creates an ext3 overlay image of size MiB at path. If
+// path is a SIF image, the overlay is embedded in it as an overlay
+// partition of the group of the primary system partition. The overlay
+// is a LUKS volume holding the ext3 filesystem if encryption keys are
+// given.
+func OverlayCreate(path string, size int, opts OverlayOptions) error {
+	if size < OverlayMinSize {
+		return fmt.Errorf("overlay size must be at least %d MiB", OverlayMinSize)
+	}
+	if len(opts.EncryptionKeys) > 0 {
+		if os.Geteuid() != 0 {
+			return errors.New("encrypted overlays can only be created by root")
+		}
+		for _, k := range opts.EncryptionKeys {
+			if k.Passphrase() == nil {
+				return fmt.Errorf("%s: encrypted overlays are only unlocked by passphrases", k)
+			}
+		}
+	}
+
+	embed := false
+	if _, err := os.Stat(path); err == nil {
+		if !isSIF(path) {
+			return fmt.Errorf("%s already exists and is not a SIF image", path)
+		}
+		embed = true
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	if !embed {
+		if len(opts.EncryptionKeys) == 0 {
+			if err := createExt3(path, size, opts); err != nil {
+				os.Remove(path)
+				return err
+			}
+			return nil
+		}
+		tmp, err := tempOverlay(path)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp)
+
+		if err := createEncryptedExt3(tmp, size, opts); err != nil {
+			return err
+		}
+		return os.Rename(tmp, path)
+	}
+
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return fmt.Errorf("while loading SIF image %s: %v", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	prim, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return fmt.Errorf("while looking for the primary partition: %v", err)
+	}
+	if d := overlayPartition(&fimg); d != nil {
+		return fmt.Errorf("%s already holds an overlay partition", path)
+	}
+
+	tmp, err := tempOverlay(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if len(opts.EncryptionKeys) > 0 {
+		err = createEncryptedExt3(tmp, size, opts)
+	} else {
+		err = createExt3(tmp, size, opts)
+	}
+	if err != nil {
+		return err
+	}
+	return addOverlay(&fimg, prim, tmp, opts.Sparse)
+}
+
+// OverlayResize resizes the ext3 overlay image at path, or the overlay
+// partition of the SIF image at path, to size MiB. The filesystem must
+// fit in the new size when shrinking it.
+func OverlayResize(path string, size int, opts OverlayOptions) error {
+	if size < OverlayMinSize {
+		return fmt.Errorf("overlay size must be at least %d MiB", OverlayMinSize)
+	}
+
+	if !isSIF(path) {
+		if encrypted, err := isEncryptedOverlay(path); err != nil {
+			return err
+		} else if encrypted {
+			return errEncryptedResize
+		}
+		return resizeExt3(path, size, opts.Sparse)
+	}
+
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return fmt.Errorf("while loading SIF image %s: %v", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	d := overlayPartition(&fimg)
+	if d == nil {
+		return fmt.Errorf("%s has no overlay partition", path)
+	}
+	if fstype, _ := d.GetFsType(); fstype == sif.FsRaw {
+		return errEncryptedResize
+	}
+
+	tmp, err := tempOverlay(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := exportOverlay(&fimg, d, tmp); err != nil {
+		return err
+	}
+	if err := resizeExt3(tmp, size, opts.Sparse); err != nil {
+		return err
+	}
+	return replaceOverlay(&fimg, d, tmp, opts.Sparse)
+}
+
+// OverlaySync synchronizes the overlay partition of the SIF image with
+// the ext3 overlay image overlay. The overlay image replaces the overlay
+// partition, or is written from it if export is true. The filesystem is
+// checked first unless it's encrypted.
+func OverlaySync(path, overlay string, export bool, opts OverlayOptions) error {
+	fimg, err := sif.LoadContainer(path, export)
+	if err != nil {
+		return fmt.Errorf("while loading SIF image %s: %v", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	d := overlayPartition(&fimg)
+
+	if export {
+		if d == nil {
+			return fmt.Errorf("%s has no overlay partition", path)
+		}
+		tmp, err := tempOverlay(overlay)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp)
+
+		if err := exportOverlay(&fimg, d, tmp); err != nil {
+			return err
+		}
+		return os.Rename(tmp, overlay)
+	}
+
+	encrypted, err := isEncryptedOverlay(overlay)
+	if err != nil {
+		return err
+	}
+	if !encrypted {
+		if err := checkExt3(overlay); err != nil {
+			return err
+		}
+	}
+	if d == nil {
+		prim, _, err := fimg.GetPartPrimSys()
+		if err != nil {
+			return fmt.Errorf("while looking for the primary partition: %v", err)
+		}
+		return addOverlay(&fimg, prim, overlay, opts.Sparse)
+	}
+	return replaceOverlay(&fimg, d, overlay, opts.Sparse)
+}
+
+func isSIF(path string) bool {
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return false
+	}
+	fimg.UnloadContainer()
+	return true
+}
+
+// tempOverlay returns a temporary file next to path.
+func tempOverlay(path string) (string, error) {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-overlay-")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+	return f.Name(), nil
+}
+
+// overlayPartition returns the ext3 overlay partition of the image, or
+// its raw partition holding an encrypted overlay.
+func overlayPartition(fimg *sif.FileImage) *sif.Descriptor {
+	for i, d := range fimg.DescrArr {
+		if !d.Used || d.Datatype != sif.DataPartition {
+			continue
+		}
+		ptype, err := d.GetPartType()
+		if err != nil || ptype != sif.PartOverlay {
+			continue
+		}
+		fstype, err := d.GetFsType()
+		if err != nil {
+			continue
+		}
+		if fstype == sif.FsExt3 {
+			return &fimg.DescrArr[i]
+		}
+		r := io.NewSectionReader(fimg.Fp, d.Fileoff, d.Filelen)
+		if fstype == sif.FsRaw && hasLUKSHeader(r) {
+			return &fimg.DescrArr[i]
+		}
+	}
+	return nil
+}
+
+// createExt3 creates the ext3 overlay image path of size MiB with the
+// upper and work directories.
+func createExt3(path string, size int, opts OverlayOptions) error {
+	skel, err := ioutil.TempDir("", "overlay-skel-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(skel)
+
+	dirs := []string{"/upper", "/work"}
+	for _, d := range opts.Dirs {
+		if !filepath.IsAbs(d) {
+			return fmt.Errorf("directory %s must be an absolute path", d)
+		}
+		d = filepath.Clean(d)
+		// the parent directories are created with the same owner
+		for p := filepath.Join("/upper", d); p != "/upper"; p = filepath.Dir(p) {
+			dirs = append(dirs, p)
+		}
+	}
+	for _, d := range dirs {
+		if err := os.MkdirAll(filepath.Join(skel, d), 0755); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	length := int64(size) * 1024 * 1024
+	if opts.Sparse {
+		err = f.Truncate(length)
+	} else {
+		err = unix.Fallocate(int(f.Fd()), 0, 0, length)
+	}
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("while allocating overlay image: %v", err)
+	}
+
+	if err := runOverlayTool("mkfs.ext3", nil, "-q", "-F", "-d", skel, path); err != nil {
+		return err
+	}
+
+	uid, gid := os.Getuid(), os.Getgid()
+	if opts.Fakeroot {
+		uid, gid = 0, 0
+	}
+	var script bytes.Buffer
+	for _, d := range dirs {
+		fmt.Fprintf(&script, "set_inode_field %s uid %d\nset_inode_field %s gid %d\n", d, uid, d, gid)
+	}
+	return debugfs(path, script.String())
+}
+
+// createEncryptedExt3 creates the ext3 overlay image path of size MiB
+// and encrypts it for the keys of opts.
+func createEncryptedExt3(path string, size int, opts OverlayOptions) error {
+	key, slots, err := crypt.VolumeKey(opts.EncryptionKeys)
+	if err != nil {
+		return err
+	}
+	if err := createExt3(path, size, opts); err != nil {
+		return err
+	}
+
+	cryptPath, err := (&crypt.Device{}).EncryptFilesystem(path, key, slots...)
+	if err != nil {
+		return fmt.Errorf("while encrypting overlay: %v", err)
+	}
+	defer os.Remove(cryptPath)
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return fs.CopyFile(cryptPath, path, 0644)
+}
+
+// errEncryptedResize is returned when resizing an encrypted overlay.
+var errEncryptedResize = errors.New("encrypted overlays can't be resized")
+
+// isEncryptedOverlay returns true if the overlay image at path is
+// encrypted.
+func isEncryptedOverlay(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	return hasLUKSHeader(f), nil
+}
+
+// hasLUKSHeader returns true if r starts with a LUKS header.
+func hasLUKSHeader(r io.ReaderAt) bool {
+	b := make([]byte, 512)
+	n, _ := r.ReadAt(b, 0)
+	return image.CheckLUKSHeader(b[:n]) == nil
+}
+
+// debugfs runs the debugfs commands of script on the image.
+func debugfs(path, script string) error {
+	f, err := ioutil.TempFile("", "debugfs-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(script)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	return runOverlayTool("debugfs", nil, "-w", "-f", f.Name(), path)
+}
+
+// checkExt3 checks and repairs the ext3 image, e2fsck exits with 1 when
+// errors were corrected.
+func checkExt3(path string) error {
+	return runOverlayTool("e2fsck", []int{1}, "-f", "-p", path)
+}
+
+// resizeExt3 resizes the ext3 image path to size MiB.
+func resizeExt3(path string, size int, sparse bool) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	length := int64(size) * 1024 * 1024
+
+	if err := checkExt3(path); err != nil {
+		return err
+	}
+
+	grow := length > fi.Size()
+	if grow {
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		if sparse {
+			err = f.Truncate(length)
+		} else {
+			err = unix.Fallocate(int(f.Fd()), 0, 0, length)
+		}
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("while allocating overlay image: %v", err)
+		}
+	}
+
+	if err := runOverlayTool("resize2fs", nil, path, fmt.Sprintf("%dM", size)); err != nil {
+		return err
+	}
+
+	if !grow {
+		return os.Truncate(path, length)
+	}
+	return nil
+}
+
+// exportOverlay writes the overlay partition d to path, the zero blocks
+// are left as holes.
+func exportOverlay(fimg *sif.FileImage, d *sif.Descriptor, path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := io.NewSectionReader(fimg.Fp, d.Fileoff, d.Filelen)
+	block := make([]byte, 64*1024)
+	zero := make([]byte, len(block))
+	for off := int64(0); off < d.Filelen; {
+		n, err := io.ReadFull(r, block)
+		if err == io.ErrUnexpectedEOF {
+			err = nil
+		}
+		if err != nil {
+			return fmt.Errorf("while reading overlay partition: %v", err)
+		}
+		if !bytes.Equal(block[:n], zero[:n]) {
+			if _, err := f.WriteAt(block[:n], off); err != nil {
+				return err
+			}
+		}
+		off += int64(n)
+	}
+	if err := f.Truncate(d.Filelen); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// addOverlay adds the ext3 image path, or the encrypted overlay image
+// path, to fimg as an overlay partition of the group of the system
+// partition prim. If this group is signed, the overlay is added to a new
+// group linked to it instead so that its signatures stay valid.
+func addOverlay(fimg *sif.FileImage, prim *sif.Descriptor, path string, sparse bool) error {
+	group, link := prim.Groupid, uint32(sif.DescrUnusedLink)
+	if primGroup := prim.Groupid &^ sif.DescrGroupMask; isGroupSigned(fimg, primGroup) {
+		newGroup := newGroupID(fimg)
+		sylog.Infof("Object group %d is signed, adding the overlay to the object group %d", primGroup, newGroup)
+		sylog.Infof("Sign it with 'singularity sign --group-id %d'", newGroup)
+		group, link = sif.DescrGroupMask|newGroup, prim.Groupid
+	}
+	return addOverlayPartition(fimg, group, link, partArch(prim), path, sparse)
+}
+
+// replaceOverlay replaces the overlay partition d of fimg with the ext3
+// image path. The signatures of the overlay group are removed if the
+// overlay has its own group, they don't match its new content.
+func replaceOverlay(fimg *sif.FileImage, d *sif.Descriptor, path string, sparse bool) error {
+	id, group, link, arch := d.ID, d.Groupid, d.Link, partArch(d)
+
+	if g := group &^ sif.DescrGroupMask; isGroupSigned(fimg, g) {
+		if link == sif.DescrUnusedLink {
+			sylog.Warningf("The signatures of the object group %d don't match the new overlay", g)
+		} else {
+			sylog.Warningf("Removing the signatures of the object group %d not matching the new overlay", g)
+			for i := range fimg.DescrArr {
+				sig := &fimg.DescrArr[i]
+				if sig.Used && sig.Datatype == sif.DataSignature && sig.Link == group {
+					if err := removeObject(fimg, sig.ID); err != nil {
+						return fmt.Errorf("while removing overlay signature: %v", err)
+					}
+				}
+			}
+		}
+	}
+
+	if err := removeObject(fimg, id); err != nil {
+		return fmt.Errorf("while removing overlay partition: %v", err)
+	}
+	return addOverlayPartition(fimg, group, link, arch, path, sparse)
+}
+
+// removeObject removes the object id of fimg. The data of an object
+// which isn't the last one can't be removed, it's zeroed and its blocks
+// freed.
+func removeObject(fimg *sif.FileImage, id uint32) error {
+	d, index, err := fimg.GetFromDescrID(id)
+	if err != nil {
+		return err
+	}
+	off, size := d.Fileoff, d.Filelen
+	last := fimg.Filesize == off+size
+	flags := 0
+	if !last {
+		flags = sif.DelZero
+	}
+	if err := fimg.DeleteObject(id, flags); err != nil {
+		return err
+	}
+	// DeleteObject only frees the descriptor on disk, AddObject would
+	// write it back
+	fimg.DescrArr[index] = sif.Descriptor{}
+	if !last {
+		return punchZeroBlocks(fimg, off, size)
+	}
+	// the file is truncated, the previous object may be the last one now
+	fi, err := fimg.Fp.Stat()
+	if err != nil {
+		return err
+	}
+	fimg.Filesize = fi.Size()
+	return nil
+}
+
+func addOverlayPartition(fimg *sif.FileImage, group, link uint32, arch, path string, sparse bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	// encrypted overlays are raw partitions detected by their header
+	fstype := sif.FsExt3
+	if hasLUKSHeader(f) {
+		fstype = sif.FsRaw
+	}
+
+	// partitions of an unknown architecture are run on the host
+	if arch == sif.HdrArchUnknown {
+		arch = sif.GetSIFArch(runtime.GOARCH)
+	}
+	input := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  group,
+		Link:     link,
+		Size:     fi.Size(),
+		Fname:    overlayPartName,
+		Fp:       f,
+	}
+	if err := input.SetPartExtra(fstype, sif.PartOverlay, arch); err != nil {
+		return err
+	}
+	if err := fimg.AddObject(input); err != nil {
+		return fmt.Errorf("while adding overlay partition: %v", err)
+	}
+
+	if !sparse {
+		return nil
+	}
+	d := overlayPartition(fimg)
+	if d == nil {
+		return fmt.Errorf("overlay partition not found after adding it")
+	}
+	return punchZeroBlocks(fimg, d.Fileoff, d.Filelen)
+}
+
+// punchZeroBlocks frees the blocks of the image region which hold
+// zeros only. It's a no-op on filesystems not supporting holes.
+func punchZeroBlocks(fimg *sif.FileImage, off, size int64) error {
+	const blockSize = 4096
+
+	// only whole filesystem blocks can be freed
+	start := (off + blockSize - 1) / blockSize * blockSize
+	end := (off + size) / blockSize * blockSize
+
+	block := make([]byte, blockSize)
+	zero := make([]byte, blockSize)
+	holeStart := int64(-1)
+
+	punch := func(from, to int64) error {
+		err := unix.Fallocate(int(fimg.Fp.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, from, to-from)
+		if err == unix.EOPNOTSUPP {
+			sylog.Debugf("Holes are not supported, the overlay partition is not sparse")
+			return io.EOF
+		}
+		return err
+	}
+
+	for o := start; o < end; o += blockSize {
+		if _, err := fimg.Fp.ReadAt(block, o); err != nil {
+			return fmt.Errorf("while reading overlay partition: %v", err)
+		}
+		if bytes.Equal(block, zero) {
+			if holeStart < 0 {
+				holeStart = o
+			}
+			continue
+		}
+		if holeStart >= 0 {
+			if err := punch(holeStart, o); err == io.EOF {
+				return nil
+			} else if err != nil {
+				return fmt.Errorf("while freeing overlay blocks: %v", err)
+			}
+			holeStart = -1
+		}
+	}
+	if holeStart >= 0 {
+		if err := punch(holeStart, end); err != nil && err != io.EOF {
+			return fmt.Errorf("while freeing overlay blocks: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/scs-key-client/client"
+	"github.com/sylabs/sif/pkg/integrity"
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+func requireE2fsprogs(t *testing.T) {
+	for _, tool := range []string{"mkfs.ext3", "debugfs", "e2fsck", "resize2fs"} {
+		if _, err := overlayTool(tool); err != nil {
+			t.Skip(err)
+		}
+	}
+}
+
+// debugfsStat returns the output of the debugfs stat command of the
+// file of the image.
+func debugfsStat(t *testing.T, img, file string) string {
+	t.Helper()
+
+	path, err := overlayTool("debugfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command(path, "-R", "stat "+file, img).CombinedOutput()
+	if err != nil {
+		t.Fatalf("debugfs failed: %v: %s", err, out)
+	}
+	return string(out)
+}
+
+func TestOverlayCreate(t *testing.T) {
+	requireE2fsprogs(t)
+
+	dir, err := ioutil.TempDir("", "overlay-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	img := filepath.Join(dir, "overlay.img")
+	if err := OverlayCreate(img, 32, OverlayOptions{}); err == nil {
+		t.Errorf("unexpected success for a too small overlay")
+	}
+
+	opts := OverlayOptions{Sparse: true, Fakeroot: true, Dirs: []string{"/data/in"}}
+	if err := OverlayCreate(img, OverlayMinSize, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, d := range []string{"/upper", "/work", "/upper/data", "/upper/data/in"} {
+		if out := debugfsStat(t, img, d); !strings.Contains(out, "User:     0   Group:     0") {
+			t.Errorf("%s is not owned by root:\n%s", d, out)
+		}
+	}
+
+	if err := OverlayCreate(img, OverlayMinSize, OverlayOptions{}); err == nil {
+		t.Errorf("unexpected success for an existing overlay image")
+	}
+
+	if err := OverlayResize(img, 2*OverlayMinSize, opts); err != nil {
+		t.Fatalf("unexpected error while growing: %v", err)
+	}
+	if fi, err := os.Stat(img); err != nil || fi.Size() != 2*OverlayMinSize*1024*1024 {
+		t.Errorf("unexpected size of the grown overlay image: %v %v", fi, err)
+	}
+}
+
+func TestOverlaySIF(t *testing.T) {
+	requireE2fsprogs(t)
+
+	dir, err := ioutil.TempDir("", "overlay-sif-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "image.sif")
+	createArchSIF(t, path, runtime.GOARCH, "rootfs")
+
+	if err := OverlayCreate(path, OverlayMinSize, OverlayOptions{Sparse: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := OverlayCreate(path, OverlayMinSize, OverlayOptions{}); err == nil {
+		t.Errorf("unexpected success for a SIF image with an overlay")
+	}
+
+	checkOverlay := func(size int64) {
+		t.Helper()
+
+		fimg, err := sif.LoadContainer(path, true)
+		if err != nil {
+			t.Fatalf("unable to load %s: %v", path, err)
+		}
+		defer fimg.UnloadContainer()
+
+		prim, _, err := fimg.GetPartPrimSys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := overlayPartition(&fimg)
+		if d == nil {
+			t.Fatalf("no overlay partition")
+		}
+		if d.Groupid != prim.Groupid {
+			t.Errorf("unexpected overlay group %x instead of %x", d.Groupid, prim.Groupid)
+		}
+		if d.Filelen != size {
+			t.Errorf("unexpected overlay size %d instead of %d", d.Filelen, size)
+		}
+	}
+	checkOverlay(OverlayMinSize * 1024 * 1024)
+
+	if err := OverlayResize(path, 2*OverlayMinSize, OverlayOptions{Sparse: true}); err != nil {
+		t.Fatalf("unexpected error while resizing: %v", err)
+	}
+	checkOverlay(2 * OverlayMinSize * 1024 * 1024)
+
+	img := filepath.Join(dir, "overlay.img")
+	if err := OverlaySync(path, img, true, OverlayOptions{}); err != nil {
+		t.Fatalf("unexpected error while exporting: %v", err)
+	}
+	if err := OverlayResize(img, OverlayMinSize, OverlayOptions{}); err != nil {
+		t.Fatalf("unexpected error while shrinking: %v", err)
+	}
+	if err := OverlaySync(path, img, false, OverlayOptions{Sparse: true}); err != nil {
+		t.Fatalf("unexpected error while importing: %v", err)
+	}
+	checkOverlay(OverlayMinSize * 1024 * 1024)
+}
+
+func TestOverlayEncryptedSIF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "overlay-luks-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "image.sif")
+	createArchSIF(t, path, runtime.GOARCH, "rootfs")
+
+	// a LUKS volume is synchronized as it is without being decrypted
+	luks := make([]byte, 1024*1024)
+	copy(luks, "LUKS\xba\xbe\x00\x02")
+	copy(luks[len(luks)/2:], "encrypted")
+	img := filepath.Join(dir, "overlay.img")
+	if err := ioutil.WriteFile(img, luks, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := OverlaySync(path, img, false, OverlayOptions{}); err != nil {
+		t.Fatalf("unexpected error while importing: %v", err)
+	}
+
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		t.Fatalf("unable to load %s: %v", path, err)
+	}
+	d := overlayPartition(&fimg)
+	if d == nil {
+		t.Fatalf("no overlay partition")
+	}
+	if fstype, err := d.GetFsType(); err != nil || fstype != sif.FsRaw {
+		t.Errorf("unexpected overlay filesystem %v: %v", fstype, err)
+	}
+	fimg.UnloadContainer()
+
+	if err := OverlayResize(path, 2*OverlayMinSize, OverlayOptions{}); err != errEncryptedResize {
+		t.Errorf("unexpected resize error: %v", err)
+	}
+
+	exported := filepath.Join(dir, "exported.img")
+	if err := OverlaySync(path, exported, true, OverlayOptions{}); err != nil {
+		t.Fatalf("unexpected error while exporting: %v", err)
+	}
+	b, err := ioutil.ReadFile(exported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, luks) {
+		t.Errorf("exported overlay differs from the imported one")
+	}
+	if err := OverlayResize(exported, 2*OverlayMinSize, OverlayOptions{}); err != errEncryptedResize {
+		t.Errorf("unexpected resize error: %v", err)
+	}
+}
+
+func TestOverlaySignedSIF(t *testing.T) {
+	e := getTestEntity(t)
+	s := httptest.NewServer(mockHKP{e: e})
+	defer s.Close()
+
+	dir, err := ioutil.TempDir("", "overlay-signed-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "image.sif")
+	createArchSIF(t, path, runtime.GOARCH, "rootfs")
+	if err := Sign(path, OptSignEntitySelector(mockEntitySelector(t))); err != nil {
+		t.Fatal(err)
+	}
+
+	luks := make([]byte, 1024*1024)
+	copy(luks, "LUKS\xba\xbe\x00\x02")
+	img := filepath.Join(dir, "overlay.img")
+	if err := ioutil.WriteFile(img, luks, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the overlay is added to a new group linked to the signed one
+	if err := OverlaySync(path, img, false, OverlayOptions{}); err != nil {
+		t.Fatalf("unexpected error while importing: %v", err)
+	}
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prim, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := overlayPartition(&fimg)
+	if d == nil || d.Groupid == prim.Groupid || d.Link != prim.Groupid {
+		t.Fatalf("unexpected overlay partition %+v", d)
+	}
+	group := d.Groupid &^ sif.DescrGroupMask
+	fimg.UnloadContainer()
+
+	if err := Sign(path, OptSignEntitySelector(mockEntitySelector(t)), OptSignUnsignedGroups()); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func() map[uint32]error {
+		t.Helper()
+
+		results := make(map[uint32]error)
+		cb := func(groupID uint32, err error) {
+			results[groupID] = err
+		}
+		Verify(context.Background(), path, OptVerifyUseKeyServer(&client.Config{BaseURL: s.URL}), OptVerifyGroupCallback(cb))
+		return results
+	}
+	if results := verify(); len(results) != 2 || results[1] != nil || results[group] != nil {
+		t.Fatalf("unexpected results %v", results)
+	}
+
+	// replacing the overlay removes the signatures of its group only
+	copy(luks[len(luks)/2:], "modified")
+	if err := ioutil.WriteFile(img, luks, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := OverlaySync(path, img, false, OverlayOptions{}); err != nil {
+		t.Fatalf("unexpected error while importing: %v", err)
+	}
+	results := verify()
+	if len(results) != 2 || results[1] != nil || !errors.Is(results[group], &integrity.SignatureNotFoundError{}) {
+		t.Fatalf("unexpected results %v", results)
+	}
+}
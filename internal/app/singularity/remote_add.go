@@ -15,8 +15,9 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/remote"
 )
 
-// RemoteAdd adds remote to configuration
-func RemoteAdd(configFile, name, uri string, global bool) (err error) {
+// RemoteAdd adds remote to configuration, builder selects its remote build
+// service if not nil.
+func RemoteAdd(configFile, name, uri string, global bool, builder *remote.Builder) (err error) {
 	// Explicit handling of corner cases: name and uri must be valid strings
 	if strings.TrimSpace(name) == "" {
 		return fmt.Errorf("invalid name: cannot have empty name")
@@ -50,7 +51,7 @@ func RemoteAdd(configFile, name, uri string, global bool) (err error) {
 	if err != nil {
 		return err
 	}
-	e := remote.EndPoint{URI: path.Join(u.Host + u.Path), System: global}
+	e := remote.EndPoint{URI: path.Join(u.Host + u.Path), System: global, Builder: builder}
 
 	if err := c.Add(name, &e); err != nil {
 		return err
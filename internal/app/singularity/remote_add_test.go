@@ -347,7 +347,7 @@ func TestRemoteAdd(t *testing.T) {
 			test.DropPrivilege(t)
 			defer test.ResetPrivilege(t)
 
-			err := RemoteAdd(tt.cfgfile, tt.remoteName, tt.uri, tt.global)
+			err := RemoteAdd(tt.cfgfile, tt.remoteName, tt.uri, tt.global, nil)
 			if tt.shallPass == true && err != nil {
 				t.Fatalf("valid case failed: %s\n", err)
 			}
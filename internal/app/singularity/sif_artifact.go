@@ -0,0 +1,131 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/util/artifact"
+)
+
+// SifArtifact describes an artifact of a SIF image, Group is the group
+// number without the SIF group mask.
+type SifArtifact struct {
+	ID        uint32 `json:"id"`
+	Group     uint32 `json:"group,omitempty"`
+	Name      string `json:"name"`
+	MediaType string `json:"mediaType"`
+	Size      int64  `json:"size"`
+	Digest    string `json:"digest"`
+}
+
+// SifAddArtifact adds file to the SIF image path as an artifact of type
+// mediaType named name, or after the file if name is empty. The artifact
+// is added to group, or to a new group if group is 0, so it's signed
+// independently from the other objects of the image.
+func SifAddArtifact(path, file, mediaType, name string, group uint32) (*SifArtifact, error) {
+	if err := artifact.ValidateMediaType(mediaType); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a regular file", file)
+	}
+	if name == "" {
+		name = file
+	}
+
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("while loading SIF image %s: %v", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	if group == 0 {
+		group = newGroupID(&fimg)
+	}
+
+	d, err := artifact.Add(&fimg, name, mediaType, f, fi.Size(), sif.DescrGroupMask|group)
+	if err != nil {
+		return nil, err
+	}
+	return newSifArtifact(&fimg, d)
+}
+
+// SifArtifacts returns the artifacts of the SIF image path.
+func SifArtifacts(path string) ([]SifArtifact, error) {
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return nil, fmt.Errorf("while loading SIF image %s: %v", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	artifacts := []SifArtifact{}
+	for i := range fimg.DescrArr {
+		if _, ok := artifact.MediaType(&fimg.DescrArr[i]); !ok {
+			continue
+		}
+		a, err := newSifArtifact(&fimg, &fimg.DescrArr[i])
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, *a)
+	}
+	return artifacts, nil
+}
+
+// SifExtractArtifact writes the artifact of the SIF image path whose ID,
+// name or media type is ref to w.
+func SifExtractArtifact(path, ref string, w io.Writer) (*SifArtifact, error) {
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return nil, fmt.Errorf("while loading SIF image %s: %v", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	d, err := artifact.Find(&fimg, ref)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(w, io.NewSectionReader(fimg.Fp, d.Fileoff, d.Filelen)); err != nil {
+		return nil, fmt.Errorf("while extracting artifact %d: %v", d.ID, err)
+	}
+	return newSifArtifact(&fimg, d)
+}
+
+func newSifArtifact(fimg *sif.FileImage, d *sif.Descriptor) (*SifArtifact, error) {
+	mediaType, _ := artifact.MediaType(d)
+	dgst, err := digest.FromReader(io.NewSectionReader(fimg.Fp, d.Fileoff, d.Filelen))
+	if err != nil {
+		return nil, fmt.Errorf("while computing digest of artifact %d: %v", d.ID, err)
+	}
+
+	a := &SifArtifact{
+		ID:        d.ID,
+		Name:      d.GetName(),
+		MediaType: mediaType,
+		Size:      d.Filelen,
+		Digest:    dgst.String(),
+	}
+	if d.Groupid != sif.DescrUnusedGroup {
+		a.Group = d.Groupid &^ sif.DescrGroupMask
+	}
+	return a, nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestSifArtifact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sif-artifact-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "image.sif")
+	createCompactSIF(t, path)
+
+	sbom := filepath.Join(dir, "sbom.spdx.json")
+	if err := ioutil.WriteFile(sbom, []byte(`{"spdxVersion":"SPDX-2.2"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	license := filepath.Join(dir, "LICENSE")
+	if err := ioutil.WriteFile(license, []byte("BSD-3-Clause"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := SifAddArtifact(path, sbom, "spdx", "", 0); err == nil {
+		t.Errorf("unexpected success with an invalid media type")
+	}
+
+	a, err := SifAddArtifact(path, sbom, "application/spdx+json", "", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// the deleted object slot 2 is reused
+	if a.ID != 2 || a.Group != 2 || a.Name != "sbom.spdx.json" {
+		t.Errorf("unexpected artifact %+v", a)
+	}
+	if _, err := SifAddArtifact(path, license, "text/plain", "license.txt", 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := SifAddArtifact(path, license, "text/plain", "", 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	list, err := SifArtifacts(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("unexpected artifacts %+v", list)
+	}
+	if list[1].Name != "license.txt" || list[1].Group != 1 || list[2].Group != 3 {
+		t.Errorf("unexpected artifacts %+v", list[1:])
+	}
+
+	tests := []struct {
+		name    string
+		ref     string
+		data    string
+		wantErr bool
+	}{
+		{"ID", "2", `{"spdxVersion":"SPDX-2.2"}`, false},
+		{"Name", "license.txt", "BSD-3-Clause", false},
+		{"MediaType", "application/spdx+json", `{"spdxVersion":"SPDX-2.2"}`, false},
+		{"Ambiguous", "text/plain", "", true},
+		{"NotArtifact", "1", "", true},
+		{"NotFound", "model-card.md", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			a, err := SifExtractArtifact(path, tt.ref, &buf)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buf.String() != tt.data || a.Digest != digest.FromString(tt.data).String() {
+				t.Errorf("unexpected artifact %+v with data %q", a, buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"crypto"
+	_ "crypto/sha1" // registers the hashes of the signed digests
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// Status of the integrity checks of SifCheck.
+const (
+	SifCheckOK        = "ok"
+	SifCheckCorrupted = "corrupted"
+)
+
+// SifCheckResult is the integrity check of a SIF image.
+type SifCheckResult struct {
+	// Checks are the digests stored by the signatures, checked against
+	// the image content.
+	Checks []SifDigestCheck `json:"checks"`
+	// Unchecked are the IDs of the data objects without stored digest.
+	Unchecked []uint32 `json:"unchecked,omitempty"`
+}
+
+// OK returns true if all the digests match the image content.
+func (r *SifCheckResult) OK() bool {
+	for _, c := range r.Checks {
+		if c.Status != SifCheckOK {
+			return false
+		}
+	}
+	return true
+}
+
+// SifDigestCheck is the check of a digest stored by a signature.
+type SifDigestCheck struct {
+	Signature uint32 `json:"signature"`
+	Object    string `json:"object"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// signedMetadata is the signed message of the non-legacy signatures,
+// the image metadata of the sif integrity package.
+type signedMetadata struct {
+	Header struct {
+		Digest string `json:"digest"`
+	} `json:"header"`
+	Objects []struct {
+		RelativeID       uint32 `json:"relativeId"`
+		DescriptorDigest string `json:"descriptorDigest"`
+		ObjectDigest     string `json:"objectDigest"`
+	} `json:"objects"`
+}
+
+// digestHashes are the hash algorithms of the signed digests.
+var digestHashes = map[string]crypto.Hash{
+	"sha1":   crypto.SHA1,
+	"sha224": crypto.SHA224,
+	"sha256": crypto.SHA256,
+	"sha384": crypto.SHA384,
+	"sha512": crypto.SHA512,
+}
+
+// SifCheck checks the digests stored by the signatures of the SIF image
+// path against the content of the image, the signatures themselves
+// aren't verified so no key is needed. It detects the corruption of an
+// image, not its tampering which requires Verify.
+func SifCheck(path string) (*SifCheckResult, error) {
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return nil, fmt.Errorf("while loading SIF image %s: %v", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	res := &SifCheckResult{}
+	checked := make(map[uint32]bool)
+
+	for i := range fimg.DescrArr {
+		sig := &fimg.DescrArr[i]
+		if !sig.Used || sig.Datatype != sif.DataSignature {
+			continue
+		}
+
+		ods, err := signedObjects(&fimg, sig)
+		if err != nil {
+			return nil, err
+		}
+		for _, od := range ods {
+			checked[od.ID] = true
+		}
+
+		block, _ := clearsign.Decode(sig.GetData(&fimg))
+		if block == nil {
+			res.Checks = append(res.Checks, SifDigestCheck{
+				Signature: sig.ID,
+				Object:    fmt.Sprintf("signature %d", sig.ID),
+				Status:    SifCheckCorrupted,
+				Error:     "no signed message found",
+			})
+			continue
+		}
+
+		if bytes.HasPrefix(block.Plaintext, []byte("SIFHASH:\n")) {
+			res.Checks = append(res.Checks, checkLegacyDigest(&fimg, sig, ods, block.Plaintext))
+		} else {
+			res.Checks = append(res.Checks, checkSignedMetadata(&fimg, sig, ods, block.Plaintext)...)
+		}
+	}
+
+	for _, od := range fimg.DescrArr {
+		if od.Used && od.Datatype != sif.DataSignature && !checked[od.ID] {
+			res.Unchecked = append(res.Unchecked, od.ID)
+		}
+	}
+	return res, nil
+}
+
+// signedObjects returns the data objects linked to the signature sig,
+// an object or the objects of a group.
+func signedObjects(fimg *sif.FileImage, sig *sif.Descriptor) ([]*sif.Descriptor, error) {
+	if sig.Link&sif.DescrGroupMask == sif.DescrGroupMask {
+		ods, _, err := fimg.GetFromDescr(sif.Descriptor{Groupid: sig.Link})
+		if err != nil && !errors.Is(err, sif.ErrNotFound) {
+			return nil, fmt.Errorf("while getting objects of group %d: %v", sig.Link&^sif.DescrGroupMask, err)
+		}
+		return ods, nil
+	}
+	od, _, err := fimg.GetFromDescrID(sig.Link)
+	if err != nil {
+		if errors.Is(err, sif.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("while getting object %d: %v", sig.Link, err)
+	}
+	return []*sif.Descriptor{od}, nil
+}
+
+// checkLegacyDigest checks the digest of the legacy signature sig with
+// the message msg, computed over the content of the objects ods.
+func checkLegacyDigest(fimg *sif.FileImage, sig *sif.Descriptor, ods []*sif.Descriptor, msg []byte) SifDigestCheck {
+	ids := make([]string, 0, len(ods))
+	rs := make([]io.Reader, 0, len(ods))
+	for _, od := range ods {
+		ids = append(ids, fmt.Sprint(od.ID))
+		rs = append(rs, od.GetReadSeeker(fimg))
+	}
+	object := "object "
+	if len(ids) > 1 {
+		object = "objects "
+	}
+	c := SifDigestCheck{
+		Signature: sig.ID,
+		Object:    object + strings.Join(ids, ","),
+		Status:    SifCheckOK,
+	}
+
+	var h crypto.Hash
+	ht, err := sig.GetHashType()
+	switch {
+	case err != nil:
+	case ht == sif.HashSHA256:
+		h = crypto.SHA256
+	case ht == sif.HashSHA384:
+		h = crypto.SHA384
+	case ht == sif.HashSHA512:
+		h = crypto.SHA512
+	}
+	value, err := hex.DecodeString(strings.TrimSpace(strings.TrimPrefix(string(msg), "SIFHASH:\n")))
+	if h == 0 || err != nil {
+		c.Status = SifCheckCorrupted
+		c.Error = "invalid legacy digest"
+		return c
+	}
+
+	if err := checkDigest(h, value, io.MultiReader(rs...)); err != nil {
+		c.Status = SifCheckCorrupted
+		c.Error = err.Error()
+	}
+	return c
+}
+
+// checkSignedMetadata checks the header, descriptor and object digests
+// of the non-legacy signature sig with the message msg. The objects ods
+// are the objects of the signed group.
+func checkSignedMetadata(fimg *sif.FileImage, sig *sif.Descriptor, ods []*sif.Descriptor, msg []byte) []SifDigestCheck {
+	var md signedMetadata
+	if err := json.Unmarshal(msg, &md); err != nil {
+		return []SifDigestCheck{{
+			Signature: sig.ID,
+			Object:    fmt.Sprintf("signature %d", sig.ID),
+			Status:    SifCheckCorrupted,
+			Error:     fmt.Sprintf("invalid signed metadata: %v", err),
+		}}
+	}
+
+	check := func(object, digest string, r io.Reader) SifDigestCheck {
+		c := SifDigestCheck{Signature: sig.ID, Object: object, Status: SifCheckOK}
+		h, value, err := parseDigest(digest)
+		if err == nil {
+			err = checkDigest(h, value, r)
+		}
+		if err != nil {
+			c.Status = SifCheckCorrupted
+			c.Error = err.Error()
+		}
+		return c
+	}
+
+	// the object IDs are relative to the lowest ID of the group
+	minID := ^uint32(0)
+	byID := make(map[uint32]*sif.Descriptor)
+	for _, od := range ods {
+		byID[od.ID] = od
+		if od.ID < minID {
+			minID = od.ID
+		}
+	}
+
+	// the integrity protected fields are those of the sif integrity package
+	var b bytes.Buffer
+	for _, f := range []interface{}{
+		fimg.Header.Launch,
+		fimg.Header.Magic,
+		fimg.Header.Version,
+		fimg.Header.ID,
+	} {
+		binary.Write(&b, binary.LittleEndian, f)
+	}
+	checks := []SifDigestCheck{check("header", md.Header.Digest, &b)}
+
+	for _, om := range md.Objects {
+		id := minID + om.RelativeID
+		od, ok := byID[id]
+		if !ok {
+			checks = append(checks, SifDigestCheck{
+				Signature: sig.ID,
+				Object:    fmt.Sprintf("object %d", id),
+				Status:    SifCheckCorrupted,
+				Error:     "signed object not found",
+			})
+			continue
+		}
+
+		var b bytes.Buffer
+		for _, f := range []interface{}{
+			od.Datatype,
+			od.Used,
+			om.RelativeID,
+			od.Link,
+			od.Filelen,
+			od.Ctime,
+			od.UID,
+			od.Gid,
+			od.Name,
+			od.Extra,
+		} {
+			binary.Write(&b, binary.LittleEndian, f)
+		}
+		checks = append(checks,
+			check(fmt.Sprintf("descriptor %d", id), om.DescriptorDigest, &b),
+			check(fmt.Sprintf("object %d", id), om.ObjectDigest, od.GetReadSeeker(fimg)),
+		)
+	}
+	return checks
+}
+
+// parseDigest parses a digest of the form <algorithm>:<hex value>.
+func parseDigest(digest string) (crypto.Hash, []byte, error) {
+	i := strings.Index(digest, ":")
+	if i < 0 {
+		return 0, nil, fmt.Errorf("malformed digest %q", digest)
+	}
+	h, ok := digestHashes[digest[:i]]
+	if !ok {
+		return 0, nil, fmt.Errorf("unsupported digest algorithm %s", digest[:i])
+	}
+	value, err := hex.DecodeString(digest[i+1:])
+	if err != nil {
+		return 0, nil, fmt.Errorf("malformed digest %q", digest)
+	}
+	return h, value, nil
+}
+
+// checkDigest returns an error if the digest of r with the hash h isn't
+// value.
+func checkDigest(h crypto.Hash, value []byte, r io.Reader) error {
+	w := h.New()
+	if _, err := io.Copy(w, r); err != nil {
+		return fmt.Errorf("while reading content: %v", err)
+	}
+	if !bytes.Equal(w.Sum(nil), value) {
+		return errors.New("digest mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+func TestSifCheck(t *testing.T) {
+	tests := []struct {
+		name      string
+		image     string
+		checks    int
+		unchecked int
+	}{
+		{name: "Unsigned", image: "one-group.sif", unchecked: 2},
+		{name: "Signed", image: "one-group-signed.sif", checks: 5},
+		{name: "Legacy", image: "one-group-signed-legacy.sif", checks: 1, unchecked: 1},
+		{name: "LegacyGroup", image: "one-group-signed-legacy-group.sif", checks: 1},
+		{name: "LegacyAll", image: "one-group-signed-legacy-all.sif", checks: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path, err := tempFileFrom(filepath.Join("testdata", "images", tt.image))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(path)
+
+			res, err := SifCheck(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !res.OK() {
+				t.Errorf("unexpected corruption: %+v", res.Checks)
+			}
+			if len(res.Checks) != tt.checks || len(res.Unchecked) != tt.unchecked {
+				t.Errorf("got %d checks and %d unchecked objects, want %d and %d", len(res.Checks), len(res.Unchecked), tt.checks, tt.unchecked)
+			}
+			if tt.checks == 0 {
+				return
+			}
+
+			// flip a byte of the primary partition
+			fimg, err := sif.LoadContainer(path, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			od, _, err := fimg.GetPartPrimSys()
+			if err != nil {
+				t.Fatal(err)
+			}
+			off := od.Fileoff
+			fimg.UnloadContainer()
+
+			f, err := os.OpenFile(path, os.O_RDWR, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := make([]byte, 1)
+			f.ReadAt(b, off)
+			b[0] ^= 0xff
+			f.WriteAt(b, off)
+			f.Close()
+
+			res, err = SifCheck(path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.OK() {
+				t.Errorf("corruption not detected")
+			}
+		})
+	}
+}
@@ -0,0 +1,359 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
+	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/image/packer"
+	"github.com/sylabs/singularity/pkg/image/unpacker"
+	"github.com/sylabs/singularity/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/util/verity"
+)
+
+// SifCompactOptions are the options of SifCompact.
+type SifCompactOptions struct {
+	// Compression is the list of compressions per partition the
+	// squashfs partitions are recompressed with (see
+	// squashfs.ParseCompressions), the partitions not selected are
+	// copied as is.
+	Compression string
+	// Strip removes the objects only useful during the build, the
+	// build log and the test report.
+	Strip bool
+}
+
+// SifCompactResult describes a compacted image.
+type SifCompactResult struct {
+	OldSize int64
+	NewSize int64
+	// Removed are the names of the objects removed.
+	Removed []string
+	// Unsigned are the groups whose signatures were removed as their
+	// objects were modified.
+	Unsigned []uint32
+}
+
+// compactObject is an object of the compacted image, its data is read
+// from path if set or from the source image otherwise.
+type compactObject struct {
+	index int
+	path  string
+}
+
+// SifCompact rewrites the SIF image path to dest, or in place if dest is
+// empty, with its objects packed one after the other. The unused
+// descriptors and the space left by deleted objects are dropped. The
+// descriptors keep their ID, so the signatures of the objects copied as
+// is are still valid, while the signatures of the groups with an object
+// removed or recompressed are removed.
+func SifCompact(path, dest string, opts SifCompactOptions) (*SifCompactResult, error) {
+	src, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return nil, fmt.Errorf("while loading SIF image %s: %v", path, err)
+	}
+	defer src.UnloadContainer()
+
+	res := &SifCompactResult{OldSize: src.Filesize}
+
+	tmpDir, err := ioutil.TempDir("", "sif-compact-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	descrs := make([]sif.Descriptor, len(src.DescrArr))
+	copy(descrs, src.DescrArr)
+
+	modified := make(map[uint32]bool)
+	remove := func(i int) {
+		d := &descrs[i]
+		res.Removed = append(res.Removed, objectName(d))
+		modified[d.Groupid] = true
+		*d = sif.Descriptor{}
+	}
+
+	if opts.Strip {
+		for i, d := range descrs {
+			if d.Used && isBuildOnly(&d) {
+				remove(i)
+			}
+		}
+	}
+
+	comps, err := squashfs.ParseCompressions(opts.Compression)
+	if err != nil {
+		return nil, err
+	}
+
+	replaced := make(map[int]string)
+	if len(comps) > 0 {
+		for i, d := range descrs {
+			if !d.Used || d.Datatype != sif.DataPartition {
+				continue
+			}
+			if fs, err := d.GetFsType(); err != nil || fs != sif.FsSquash {
+				continue
+			}
+			comp, ok := comps.Select(d.ID, partitionSelector(&d))
+			if !ok {
+				continue
+			}
+			part := filepath.Join(tmpDir, fmt.Sprintf("partition-%d", d.ID))
+			if err := recompress(&src, &descrs[i], part, comp); err != nil {
+				return nil, fmt.Errorf("while recompressing partition %d: %v", d.ID, err)
+			}
+			fi, err := os.Stat(part)
+			if err != nil {
+				return nil, err
+			}
+			replaced[i] = part
+			descrs[i].Filelen = fi.Size()
+			descrs[i].Mtime = time.Now().Unix()
+			modified[d.Groupid] = true
+
+			// the hash tree doesn't match the new partition data,
+			// it's added back with 'singularity sign --verity'
+			for j, l := range descrs {
+				if l.Used && l.Link == d.ID && (l.GetName() == verity.HashTreeName || l.GetName() == verity.ParamsName) {
+					remove(j)
+				}
+			}
+		}
+	}
+
+	// the signatures of the modified groups and the signatures of
+	// objects which don't exist anymore are removed
+	for i, d := range descrs {
+		if !d.Used || d.Datatype != sif.DataSignature {
+			continue
+		}
+		if d.Link&sif.DescrGroupMask == sif.DescrGroupMask {
+			if !modified[d.Link] && groupUsed(descrs, d.Link) {
+				continue
+			}
+		} else if l := linkedDescr(descrs, d.Link); l != nil && !modified[l.Groupid] {
+			continue
+		}
+		group := d.Link
+		if group&sif.DescrGroupMask != sif.DescrGroupMask {
+			group = d.Groupid
+		}
+		res.Unsigned = appendGroup(res.Unsigned, group)
+		descrs[i] = sif.Descriptor{}
+	}
+
+	var objects []compactObject
+	for i, d := range descrs {
+		if d.Used {
+			objects = append(objects, compactObject{index: i, path: replaced[i]})
+		} else {
+			// deleted descriptors may keep stale fields
+			descrs[i] = sif.Descriptor{}
+		}
+	}
+	// the objects keep their order in the image
+	sort.Slice(objects, func(i, j int) bool {
+		return descrs[objects[i].index].Fileoff < descrs[objects[j].index].Fileoff
+	})
+
+	if dest == "" {
+		dest = path
+	}
+	f, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest)+"-compact-")
+	if err != nil {
+		return nil, err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	header := src.Header
+	header.Mtime = time.Now().Unix()
+	header.Dfree = int64(len(descrs))
+	header.Datalen = 0
+
+	off := header.Dataoff
+	for _, o := range objects {
+		d := &descrs[o.index]
+		end := off
+		off = alignOffset(off, os.Getpagesize())
+		if err := copyObject(f, &src, d, o.path, off); err != nil {
+			return nil, fmt.Errorf("while copying object %d: %v", d.ID, err)
+		}
+		d.Fileoff = off
+		d.Storelen = off + d.Filelen - end
+		off += d.Filelen
+
+		header.Dfree--
+		header.Datalen += d.Storelen
+	}
+	if err := f.Truncate(off); err != nil {
+		return nil, err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(f, binary.LittleEndian, header); err != nil {
+		return nil, fmt.Errorf("while writing header: %v", err)
+	}
+	if _, err := f.Seek(header.Descroff, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(f, binary.LittleEndian, descrs); err != nil {
+		return nil, fmt.Errorf("while writing descriptors: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	if fi, err := os.Stat(path); err == nil {
+		os.Chmod(tmp, fi.Mode())
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return nil, err
+	}
+	res.NewSize = off
+	return res, nil
+}
+
+// isBuildOnly returns true for the objects only useful during the build.
+func isBuildOnly(d *sif.Descriptor) bool {
+	switch d.Datatype {
+	case sif.DataGeneric:
+		return d.GetName() == types.BuildLogObject
+	case sif.DataGenericJSON:
+		return d.GetName() == types.TestReportJSON+".json"
+	}
+	return false
+}
+
+// objectName returns a name identifying the object for the user.
+func objectName(d *sif.Descriptor) string {
+	if name := d.GetName(); name != "" {
+		return fmt.Sprintf("%s (id %d)", name, d.ID)
+	}
+	return fmt.Sprintf("id %d", d.ID)
+}
+
+func groupUsed(descrs []sif.Descriptor, group uint32) bool {
+	for _, d := range descrs {
+		if d.Used && d.Datatype != sif.DataSignature && d.Groupid == group {
+			return true
+		}
+	}
+	return false
+}
+
+func linkedDescr(descrs []sif.Descriptor, id uint32) *sif.Descriptor {
+	for i, d := range descrs {
+		if d.Used && d.ID == id {
+			return &descrs[i]
+		}
+	}
+	return nil
+}
+
+func appendGroup(groups []uint32, group uint32) []uint32 {
+	for _, g := range groups {
+		if g == group {
+			return groups
+		}
+	}
+	return append(groups, group)
+}
+
+func alignOffset(off int64, align int) int64 {
+	a := int64(align)
+	return (off + a - 1) / a * a
+}
+
+// copyObject writes the data of the object d at off, it's read from path
+// if set or from the source image otherwise.
+func copyObject(w *os.File, src *sif.FileImage, d *sif.Descriptor, path string, off int64) error {
+	var r io.Reader = io.NewSectionReader(src.Fp, d.Fileoff, d.Filelen)
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if _, err := w.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return err
+	}
+	if n != d.Filelen {
+		return fmt.Errorf("short copy of %d bytes instead of %d", n, d.Filelen)
+	}
+	return nil
+}
+
+// partitionSelector returns the type of the partition d selecting its
+// compression.
+func partitionSelector(d *sif.Descriptor) string {
+	ptype, _ := d.GetPartType()
+	switch ptype {
+	case sif.PartPrimSys:
+		return squashfs.RootfsPartition
+	case sif.PartSystem:
+		return squashfs.SystemPartitions
+	case sif.PartOverlay:
+		return squashfs.OverlayPartitions
+	}
+	return squashfs.DataPartitions
+}
+
+// recompress writes the squashfs partition d recompressed with comp to
+// path.
+func recompress(src *sif.FileImage, d *sif.Descriptor, path string, comp squashfs.Compression) error {
+	flags, err := comp.Flags()
+	if err != nil {
+		return err
+	}
+
+	u := unpacker.NewSquashfs()
+	if !u.HasUnsquashfs() {
+		return fmt.Errorf("unsquashfs is required to recompress partitions")
+	}
+	mksquashfs, err := squashfs.GetPath()
+	if err != nil {
+		return fmt.Errorf("mksquashfs is required to recompress partitions: %v", err)
+	}
+
+	rootfs, err := ioutil.TempDir(filepath.Dir(path), "rootfs-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(rootfs)
+
+	sylog.Infof("Recompressing partition %d with %s", d.ID, comp)
+	if err := u.ExtractAll(io.NewSectionReader(src.Fp, d.Fileoff, d.Filelen), rootfs); err != nil {
+		return err
+	}
+
+	flags = append([]string{"-noappend"}, flags...)
+	if os.Getuid() != 0 {
+		flags = append(flags, "-all-root")
+	}
+	p := packer.Squashfs{MksquashfsPath: mksquashfs}
+	return p.Create([]string{rootfs}, path, flags)
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+// createCompactSIF creates an image holding a partition, a build log,
+// a large object deleted from the middle of the image and a signature
+// of the default group.
+func createCompactSIF(t *testing.T, path string) {
+	t.Helper()
+
+	part := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Size:     int64(len("rootfs")),
+		Fname:    "rootfs",
+		Fp:       strings.NewReader("rootfs"),
+	}
+	if err := part.SetPartExtra(sif.FsRaw, sif.PartPrimSys, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+		t.Fatal(err)
+	}
+	deleted := sif.DescriptorInput{
+		Datatype: sif.DataGeneric,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Size:     1 << 20,
+		Fname:    "deleted",
+		Fp:       bytes.NewReader(make([]byte, 1<<20)),
+	}
+	log := sif.DescriptorInput{
+		Datatype: sif.DataGeneric,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Size:     int64(len("build log")),
+		Fname:    types.BuildLogObject,
+		Fp:       strings.NewReader("build log"),
+	}
+	sig := sif.DescriptorInput{
+		Datatype: sif.DataSignature,
+		Groupid:  0,
+		Link:     sif.DescrDefaultGroup,
+		Size:     int64(len("signature")),
+		Fp:       strings.NewReader("signature"),
+	}
+	if err := sig.SetSignExtra(sif.HashSHA256, "0000000000000000000000000000000000000000"); err != nil {
+		t.Fatal(err)
+	}
+
+	fimg, err := sif.CreateContainer(sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: []sif.DescriptorInput{part, deleted, log, sig},
+	})
+	if err != nil {
+		t.Fatalf("while creating SIF image: %v", err)
+	}
+	fimg.UnloadContainer()
+
+	img, err := sif.LoadContainer(path, false)
+	if err != nil {
+		t.Fatalf("while loading SIF image: %v", err)
+	}
+	if err := img.DeleteObject(2, sif.DelZero); err != nil {
+		t.Fatalf("while deleting object: %v", err)
+	}
+	img.UnloadContainer()
+}
+
+func TestSifCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sif-compact-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name        string
+		opts        SifCompactOptions
+		objects     map[uint32]string
+		removed     int
+		unsignedGrp int
+	}{
+		{
+			name:    "Compact",
+			objects: map[uint32]string{1: "rootfs", 3: "build log", 4: "signature"},
+		},
+		{
+			name:        "Strip",
+			opts:        SifCompactOptions{Strip: true},
+			objects:     map[uint32]string{1: "rootfs"},
+			removed:     1,
+			unsignedGrp: 1,
+		},
+		{
+			// the raw root filesystem isn't recompressed
+			name:    "Compression",
+			opts:    SifCompactOptions{Compression: "rootfs=zstd:19,data=none"},
+			objects: map[uint32]string{1: "rootfs", 3: "build log", 4: "signature"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".sif")
+			createCompactSIF(t, path)
+			dest := filepath.Join(dir, tt.name+"-compact.sif")
+
+			res, err := SifCompact(path, dest, tt.opts)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.NewSize >= res.OldSize || res.OldSize < 1<<20 {
+				t.Errorf("unexpected sizes %d -> %d", res.OldSize, res.NewSize)
+			}
+			if len(res.Removed) != tt.removed || len(res.Unsigned) != tt.unsignedGrp {
+				t.Errorf("unexpected removed objects %v and unsigned groups %v", res.Removed, res.Unsigned)
+			}
+
+			fimg, err := sif.LoadContainer(dest, true)
+			if err != nil {
+				t.Fatalf("unable to load compacted image: %v", err)
+			}
+			defer fimg.UnloadContainer()
+
+			if fi, err := os.Stat(dest); err != nil || fi.Size() != res.NewSize {
+				t.Errorf("unexpected compacted image size: %v %v", fi, err)
+			}
+			if n := int(fimg.Header.Dtotal - fimg.Header.Dfree); n != len(tt.objects) {
+				t.Errorf("unexpected %d objects instead of %d", n, len(tt.objects))
+			}
+			for id, data := range tt.objects {
+				d, _, err := fimg.GetFromDescrID(id)
+				if err != nil {
+					t.Errorf("object %d not found: %v", id, err)
+					continue
+				}
+				if got := string(d.GetData(&fimg)); got != data {
+					t.Errorf("unexpected data %q of object %d instead of %q", got, id, data)
+				}
+			}
+		})
+	}
+}
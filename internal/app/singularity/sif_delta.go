@@ -0,0 +1,364 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// SifDeltaBlockSize is the size of the blocks of the data objects
+// matched between images by SifDelta.
+const SifDeltaBlockSize = 64 * 1024
+
+// sifDeltaMagic starts a delta written by SifDelta.
+const sifDeltaMagic = "SIFDELTA"
+
+const sifDeltaVersion uint32 = 1
+
+// sifDeltaMaxData is the size of the data held before writing a data
+// operation.
+const sifDeltaMaxData = 4 * 1024 * 1024
+
+// delta operations, a copy operation holds the offset and the length
+// of data of the old image, a data operation holds the length of the
+// data which follows.
+const (
+	deltaOpEnd byte = iota
+	deltaOpCopy
+	deltaOpData
+)
+
+// sifDeltaHeader starts a delta after the magic.
+type sifDeltaHeader struct {
+	Version   uint32
+	BlockSize uint32
+	OldSize   int64
+	OldDigest [sha256.Size]byte
+	NewSize   int64
+	NewDigest [sha256.Size]byte
+}
+
+// deltaWriter writes the delta operations, merging the copies of
+// contiguous data and the data following each other.
+type deltaWriter struct {
+	w       *bufio.Writer
+	copyOff int64
+	copyLen int64
+	data    bytes.Buffer
+}
+
+func (d *deltaWriter) copy(off, n int64) error {
+	if d.copyLen > 0 && d.copyOff+d.copyLen == off {
+		d.copyLen += n
+		return nil
+	}
+	if err := d.flush(); err != nil {
+		return err
+	}
+	d.copyOff, d.copyLen = off, n
+	return nil
+}
+
+func (d *deltaWriter) literal(b []byte) error {
+	if d.copyLen > 0 {
+		if err := d.flush(); err != nil {
+			return err
+		}
+	}
+	d.data.Write(b)
+	if d.data.Len() >= sifDeltaMaxData {
+		return d.flush()
+	}
+	return nil
+}
+
+// flush writes the pending operation.
+func (d *deltaWriter) flush() error {
+	switch {
+	case d.copyLen > 0:
+		if err := d.w.WriteByte(deltaOpCopy); err != nil {
+			return err
+		}
+		if err := binary.Write(d.w, binary.LittleEndian, [2]int64{d.copyOff, d.copyLen}); err != nil {
+			return err
+		}
+		d.copyLen = 0
+	case d.data.Len() > 0:
+		if err := d.w.WriteByte(deltaOpData); err != nil {
+			return err
+		}
+		if err := binary.Write(d.w, binary.LittleEndian, int64(d.data.Len())); err != nil {
+			return err
+		}
+		if _, err := d.data.WriteTo(d.w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SifDelta writes to w the delta turning the SIF image oldPath into the
+// SIF image newPath. The data objects are split in blocks, the blocks
+// found in the data objects of the old image are copied from it and the
+// others are held by the delta. The image built by SifPatch is identical
+// to the new image, the signatures of the unchanged partitions are
+// copied along with them.
+func SifDelta(oldPath, newPath string, w io.Writer) error {
+	oldImg, err := sif.LoadContainer(oldPath, true)
+	if err != nil {
+		return fmt.Errorf("while loading SIF image %s: %v", oldPath, err)
+	}
+	defer oldImg.UnloadContainer()
+
+	newImg, err := sif.LoadContainer(newPath, true)
+	if err != nil {
+		return fmt.Errorf("while loading SIF image %s: %v", newPath, err)
+	}
+	defer newImg.UnloadContainer()
+
+	hdr := sifDeltaHeader{
+		Version:   sifDeltaVersion,
+		BlockSize: SifDeltaBlockSize,
+	}
+
+	blocks := make(map[[sha256.Size]byte]int64)
+	hdr.OldSize, hdr.OldDigest, err = indexBlocks(&oldImg, blocks)
+	if err != nil {
+		return fmt.Errorf("while reading %s: %v", oldPath, err)
+	}
+	if hdr.NewSize, hdr.NewDigest, err = fileSHA256(newImg.Fp); err != nil {
+		return fmt.Errorf("while reading %s: %v", newPath, err)
+	}
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(sifDeltaMagic); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+
+	d := &deltaWriter{w: bw}
+	block := make([]byte, SifDeltaBlockSize)
+	var off int64
+
+	// the header, the descriptors and the padding between data
+	// objects are held by the delta
+	for _, o := range sortedObjects(&newImg) {
+		if err := deltaData(d, newImg.Fp, off, o.Fileoff-off); err != nil {
+			return err
+		}
+		for off = o.Fileoff; off < o.Fileoff+o.Filelen; {
+			n := o.Fileoff + o.Filelen - off
+			if n > SifDeltaBlockSize {
+				n = SifDeltaBlockSize
+			}
+			if _, err := newImg.Fp.ReadAt(block[:n], off); err != nil {
+				return fmt.Errorf("while reading %s: %v", newPath, err)
+			}
+			if oldOff, ok := blocks[blockKey(block[:n])]; ok {
+				err = d.copy(oldOff, n)
+			} else {
+				err = d.literal(block[:n])
+			}
+			if err != nil {
+				return err
+			}
+			off += n
+		}
+	}
+	if err := deltaData(d, newImg.Fp, off, hdr.NewSize-off); err != nil {
+		return err
+	}
+
+	if err := d.flush(); err != nil {
+		return err
+	}
+	if err := bw.WriteByte(deltaOpEnd); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// deltaData adds n bytes of r at off to the delta.
+func deltaData(d *deltaWriter, r io.ReaderAt, off, n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return err
+	}
+	return d.literal(b)
+}
+
+// sortedObjects returns the descriptors of the data objects sorted by
+// offset.
+func sortedObjects(fimg *sif.FileImage) []*sif.Descriptor {
+	var descrs []*sif.Descriptor
+	for i := range fimg.DescrArr {
+		if d := &fimg.DescrArr[i]; d.Used && d.Filelen > 0 {
+			descrs = append(descrs, d)
+		}
+	}
+	sort.Slice(descrs, func(i, j int) bool {
+		return descrs[i].Fileoff < descrs[j].Fileoff
+	})
+	return descrs
+}
+
+// blockKey returns the key of a block, the last block of an object may
+// be shorter.
+func blockKey(b []byte) [sha256.Size]byte {
+	return sha256.Sum256(b)
+}
+
+// indexBlocks adds the offsets of the blocks of the data objects of the
+// image to blocks and returns the size and the digest of the image.
+func indexBlocks(fimg *sif.FileImage, blocks map[[sha256.Size]byte]int64) (int64, [sha256.Size]byte, error) {
+	block := make([]byte, SifDeltaBlockSize)
+	for _, o := range sortedObjects(fimg) {
+		for off := o.Fileoff; off < o.Fileoff+o.Filelen; off += SifDeltaBlockSize {
+			n := o.Fileoff + o.Filelen - off
+			if n > SifDeltaBlockSize {
+				n = SifDeltaBlockSize
+			}
+			if _, err := fimg.Fp.ReadAt(block[:n], off); err != nil {
+				return 0, [sha256.Size]byte{}, err
+			}
+			key := blockKey(block[:n])
+			if _, ok := blocks[key]; !ok {
+				blocks[key] = off
+			}
+		}
+	}
+	return fileSHA256(fimg.Fp)
+}
+
+// fileSHA256 returns the size and the SHA256 digest of the content of r.
+func fileSHA256(r io.ReaderAt) (int64, [sha256.Size]byte, error) {
+	var digest [sha256.Size]byte
+
+	h := sha256.New()
+	n, err := io.Copy(h, io.NewSectionReader(r, 0, 1<<62))
+	if err != nil {
+		return 0, digest, err
+	}
+	copy(digest[:], h.Sum(nil))
+	return n, digest, nil
+}
+
+// SifPatch applies the delta read from the file patchPath to the SIF
+// image oldPath and writes the new image to newPath. The old image must
+// be the one the delta was created from. The new image is checked
+// against the digest held by the delta before replacing newPath.
+func SifPatch(oldPath, patchPath, newPath string) error {
+	oldFile, err := os.Open(oldPath)
+	if err != nil {
+		return err
+	}
+	defer oldFile.Close()
+
+	patch, err := os.Open(patchPath)
+	if err != nil {
+		return err
+	}
+	defer patch.Close()
+
+	r := bufio.NewReader(patch)
+
+	magic := make([]byte, len(sifDeltaMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != sifDeltaMagic {
+		return fmt.Errorf("%s is not a SIF delta", patchPath)
+	}
+	var hdr sifDeltaHeader
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return fmt.Errorf("while reading delta header: %v", err)
+	}
+	if hdr.Version != sifDeltaVersion {
+		return fmt.Errorf("unsupported delta version %d", hdr.Version)
+	}
+
+	size, digest, err := fileSHA256(oldFile)
+	if err != nil {
+		return fmt.Errorf("while reading %s: %v", oldPath, err)
+	}
+	if size != hdr.OldSize || digest != hdr.OldDigest {
+		return fmt.Errorf("%s is not the image the delta was created from", oldPath)
+	}
+
+	out, err := ioutil.TempFile(filepath.Dir(newPath), "."+filepath.Base(newPath)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	h := sha256.New()
+	w := io.MultiWriter(out, h)
+
+	var written int64
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return fmt.Errorf("while reading delta: %v", err)
+		}
+		if op == deltaOpEnd {
+			break
+		}
+
+		var n int64
+		switch op {
+		case deltaOpCopy:
+			var args [2]int64
+			if err := binary.Read(r, binary.LittleEndian, &args); err != nil {
+				return fmt.Errorf("while reading delta: %v", err)
+			}
+			if args[0] < 0 || args[1] < 0 || args[0]+args[1] > size {
+				return fmt.Errorf("invalid delta copy of %d bytes at offset %d", args[1], args[0])
+			}
+			n, err = io.Copy(w, io.NewSectionReader(oldFile, args[0], args[1]))
+		case deltaOpData:
+			if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+				return fmt.Errorf("while reading delta: %v", err)
+			}
+			n, err = io.CopyN(w, r, n)
+		default:
+			return fmt.Errorf("invalid delta operation %d", op)
+		}
+		if err != nil {
+			return fmt.Errorf("while writing %s: %v", newPath, err)
+		}
+		if written += n; written > hdr.NewSize {
+			return fmt.Errorf("delta produces more than %d bytes", hdr.NewSize)
+		}
+	}
+
+	var newDigest [sha256.Size]byte
+	copy(newDigest[:], h.Sum(nil))
+	if written != hdr.NewSize || newDigest != hdr.NewDigest {
+		return fmt.Errorf("patched image doesn't match the image the delta was created from")
+	}
+
+	if err := out.Chmod(0755); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), newPath)
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSifDelta(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sif-delta-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the new partition changes the third block and appends data
+	part := strings.Repeat("a", SifDeltaBlockSize) + strings.Repeat("b", SifDeltaBlockSize) + strings.Repeat("c", SifDeltaBlockSize)
+	newPart := part[:2*SifDeltaBlockSize] + strings.Repeat("d", SifDeltaBlockSize) + "tail"
+
+	oldPath := filepath.Join(dir, "old.sif")
+	createTestSIF(t, oldPath, "bootstrap: scratch\n", part, map[string]string{"data": "old"})
+	newPath := filepath.Join(dir, "new.sif")
+	createTestSIF(t, newPath, "bootstrap: scratch\n", newPart, map[string]string{"data": "new"})
+
+	var delta bytes.Buffer
+	if err := SifDelta(oldPath, newPath, &delta); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if delta.Len() >= len(newPart) {
+		t.Errorf("delta of %d bytes isn't smaller than the partition", delta.Len())
+	}
+
+	deltaPath := filepath.Join(dir, "delta")
+	if err := ioutil.WriteFile(deltaPath, delta.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	patched := filepath.Join(dir, "patched.sif")
+	if err := SifPatch(oldPath, deltaPath, patched); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected, err := ioutil.ReadFile(newPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(patched)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("patched image differs from the new image")
+	}
+
+	// the delta only applies to the image it was created from
+	err = SifPatch(newPath, deltaPath, filepath.Join(dir, "invalid.sif"))
+	if err == nil || !strings.Contains(err.Error(), "is not the image the delta was created from") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if err := SifPatch(oldPath, oldPath, filepath.Join(dir, "invalid.sif")); err == nil {
+		t.Errorf("unexpected success applying an image as delta")
+	}
+}
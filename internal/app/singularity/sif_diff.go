@@ -0,0 +1,398 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/pkg/image/unpacker"
+	"github.com/sylabs/singularity/pkg/inspect"
+)
+
+// Status of the objects and files compared by SifDiff.
+const (
+	SifDiffAdded    = "added"
+	SifDiffRemoved  = "removed"
+	SifDiffModified = "modified"
+)
+
+// SifDiffResult is the comparison of two SIF images.
+type SifDiffResult struct {
+	// Objects are the data objects added, removed or modified.
+	Objects []SifObjectDiff `json:"objects"`
+	// Files are the files of the primary system partitions added,
+	// removed or modified, they are only compared on request.
+	Files []SifFileDiff `json:"files,omitempty"`
+}
+
+// Identical returns true if no difference was found.
+func (r *SifDiffResult) Identical() bool {
+	return len(r.Objects) == 0 && len(r.Files) == 0
+}
+
+// SifObjectDiff is a data object difference. Objects are matched by
+// type, partition type and name, signatures by the object they sign.
+type SifObjectDiff struct {
+	Object  string           `json:"object"`
+	Status  string           `json:"status"`
+	Changes []SifFieldChange `json:"changes,omitempty"`
+}
+
+// SifFieldChange is a descriptor field or a file attribute changed
+// between the images.
+type SifFieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// SifFileDiff is a file difference of the root filesystems.
+type SifFileDiff struct {
+	Path    string           `json:"path"`
+	Status  string           `json:"status"`
+	Changes []SifFieldChange `json:"changes,omitempty"`
+}
+
+// sifObject holds the compared fields of a data object.
+type sifObject struct {
+	key    string
+	fields []SifFieldChange
+}
+
+// SifDiff compares the descriptors and the content digests of the data
+// objects of the SIF images a and b, and the files of their primary
+// system partitions if files is true. Creation and modification times
+// aren't compared.
+func SifDiff(a, b string, files bool) (*SifDiffResult, error) {
+	fa, err := sif.LoadContainer(a, true)
+	if err != nil {
+		return nil, fmt.Errorf("while loading SIF image %s: %v", a, err)
+	}
+	defer fa.UnloadContainer()
+
+	fb, err := sif.LoadContainer(b, true)
+	if err != nil {
+		return nil, fmt.Errorf("while loading SIF image %s: %v", b, err)
+	}
+	defer fb.UnloadContainer()
+
+	oa, err := sifObjects(&fa)
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %v", a, err)
+	}
+	ob, err := sifObjects(&fb)
+	if err != nil {
+		return nil, fmt.Errorf("while reading %s: %v", b, err)
+	}
+
+	res := &SifDiffResult{Objects: diffObjects(oa, ob)}
+
+	if files {
+		dir, err := ioutil.TempDir("", "sif-diff-")
+		if err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(dir)
+
+		ra := filepath.Join(dir, "a")
+		if err := extractPrimSys(&fa, ra); err != nil {
+			return nil, fmt.Errorf("while extracting root filesystem of %s: %v", a, err)
+		}
+		rb := filepath.Join(dir, "b")
+		if err := extractPrimSys(&fb, rb); err != nil {
+			return nil, fmt.Errorf("while extracting root filesystem of %s: %v", b, err)
+		}
+		if res.Files, err = diffTrees(ra, rb); err != nil {
+			return nil, err
+		}
+	}
+
+	return res, nil
+}
+
+// sifObjects returns the data objects of the image in descriptor order.
+func sifObjects(fimg *sif.FileImage) ([]sifObject, error) {
+	keys := make(map[uint32]string)
+	count := make(map[string]int)
+
+	var descrs []*sif.Descriptor
+	for i := range fimg.DescrArr {
+		if d := &fimg.DescrArr[i]; d.Used {
+			descrs = append(descrs, d)
+		}
+	}
+
+	// linked objects are keyed by the key of their target, which
+	// is set first
+	sort.SliceStable(descrs, func(i, j int) bool {
+		return !isLinkedObject(descrs[i]) && isLinkedObject(descrs[j])
+	})
+
+	objects := make([]sifObject, 0, len(descrs))
+	for _, d := range descrs {
+		key := objectKey(d, keys)
+		if count[key]++; count[key] > 1 {
+			key += " #" + strconv.Itoa(count[key])
+		}
+		keys[d.ID] = key
+
+		digest, err := objectDigest(fimg, d)
+		if err != nil {
+			return nil, fmt.Errorf("while hashing object %d: %v", d.ID, err)
+		}
+
+		o := sifObject{key: key}
+		add := func(field, value string) {
+			o.fields = append(o.fields, SifFieldChange{Field: field, New: value})
+		}
+		add("id", strconv.FormatUint(uint64(d.ID), 10))
+		add("group", objectGroup(d.Groupid))
+		add("link", objectLink(d.Link))
+		add("size", strconv.FormatInt(d.Filelen, 10))
+		add("sha256", digest)
+
+		switch d.Datatype {
+		case sif.DataPartition:
+			// partition names are the temporary files of the build
+			if fs, err := d.GetFsType(); err == nil {
+				add("fstype", inspect.FsTypeName(fs))
+			}
+			if arch, err := d.GetArch(); err == nil {
+				add("arch", sif.GetGoArch(string(bytes.TrimRight(arch[:], "\x00"))))
+			}
+		case sif.DataSignature:
+			add("name", d.GetName())
+			if h, err := d.GetHashType(); err == nil {
+				add("hashtype", strconv.Itoa(int(h)))
+			}
+			if e, err := d.GetEntityString(); err == nil {
+				add("entity", e)
+			}
+		default:
+			add("name", d.GetName())
+		}
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+// isLinkedObject returns true for signatures and cryptographic messages,
+// they are identified by the object they are linked to.
+func isLinkedObject(d *sif.Descriptor) bool {
+	return d.Datatype == sif.DataSignature || d.Datatype == sif.DataCryptoMessage
+}
+
+// objectKey returns the key matching the object of the other image.
+func objectKey(d *sif.Descriptor, keys map[uint32]string) string {
+	switch {
+	case d.Datatype == sif.DataPartition:
+		pt, _ := d.GetPartType()
+		return fmt.Sprintf("%s (%s)", d.Datatype, inspect.PartTypeName(pt))
+	case isLinkedObject(d):
+		target := objectLink(d.Link)
+		if d.Link&sif.DescrGroupMask != sif.DescrGroupMask {
+			if k, ok := keys[d.Link]; ok {
+				target = k
+			}
+		}
+		return fmt.Sprintf("%s of %s", d.Datatype, target)
+	}
+	return fmt.Sprintf("%s %s", d.Datatype, d.GetName())
+}
+
+// objectDigest returns the SHA256 digest of the object content.
+func objectDigest(fimg *sif.FileImage, d *sif.Descriptor) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(fimg.Fp, d.Fileoff, d.Filelen)); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+func objectGroup(id uint32) string {
+	if id == sif.DescrUnusedGroup {
+		return "none"
+	}
+	return strconv.FormatUint(uint64(id&^sif.DescrGroupMask), 10)
+}
+
+func objectLink(id uint32) string {
+	if id == sif.DescrUnusedLink {
+		return "none"
+	}
+	if id&sif.DescrGroupMask == sif.DescrGroupMask {
+		return "group " + strconv.FormatUint(uint64(id&^sif.DescrGroupMask), 10)
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// diffObjects returns the objects of a removed, added or modified in b.
+func diffObjects(a, b []sifObject) []SifObjectDiff {
+	diffs := []SifObjectDiff{}
+
+	others := make(map[string]sifObject)
+	for _, o := range b {
+		others[o.key] = o
+	}
+	for _, o := range a {
+		other, ok := others[o.key]
+		if !ok {
+			diffs = append(diffs, SifObjectDiff{Object: o.key, Status: SifDiffRemoved})
+			continue
+		}
+		delete(others, o.key)
+		if changes := diffFields(o.fields, other.fields); len(changes) > 0 {
+			diffs = append(diffs, SifObjectDiff{Object: o.key, Status: SifDiffModified, Changes: changes})
+		}
+	}
+	for _, o := range b {
+		if _, ok := others[o.key]; ok {
+			diffs = append(diffs, SifObjectDiff{Object: o.key, Status: SifDiffAdded})
+		}
+	}
+	return diffs
+}
+
+// diffFields returns the fields of a whose value changed in b.
+func diffFields(a, b []SifFieldChange) []SifFieldChange {
+	values := make(map[string]string)
+	for _, f := range b {
+		values[f.Field] = f.New
+	}
+	var changes []SifFieldChange
+	for _, f := range a {
+		if v := values[f.Field]; v != f.New {
+			changes = append(changes, SifFieldChange{Field: f.Field, Old: f.New, New: v})
+		}
+	}
+	return changes
+}
+
+// extractPrimSys extracts the squashfs primary system partition of the
+// image to dir.
+func extractPrimSys(fimg *sif.FileImage, dir string) error {
+	d, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return err
+	}
+	if fs, err := d.GetFsType(); err != nil {
+		return err
+	} else if fs != sif.FsSquash {
+		return fmt.Errorf("only squashfs partitions can be compared, found %s", inspect.FsTypeName(fs))
+	}
+
+	s := unpacker.NewSquashfs()
+	if !s.HasUnsquashfs() {
+		return fmt.Errorf("unsquashfs is required to compare files")
+	}
+	return s.ExtractAll(io.NewSectionReader(fimg.Fp, d.Fileoff, d.Filelen), dir)
+}
+
+// treeFile holds the compared attributes of a file.
+type treeFile struct {
+	attrs []SifFieldChange
+}
+
+// readTree returns the attributes of the files below root by path.
+func readTree(root string) (map[string]treeFile, error) {
+	tree := make(map[string]treeFile)
+
+	err := filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		var f treeFile
+		add := func(field, value string) {
+			f.attrs = append(f.attrs, SifFieldChange{Field: field, New: value})
+		}
+		add("type", fileType(fi.Mode()))
+		add("mode", fmt.Sprintf("%04o", fi.Mode().Perm()|fi.Mode()&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky)))
+
+		switch {
+		case fi.Mode().IsRegular():
+			add("size", strconv.FormatInt(fi.Size(), 10))
+			digest, err := fs.FileDigest(path)
+			if err != nil {
+				return err
+			}
+			add("content", digest.Encoded())
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			add("target", target)
+		}
+
+		tree[filepath.Join("/", rel)] = f
+		return nil
+	})
+	return tree, err
+}
+
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	}
+	return "file"
+}
+
+// diffTrees returns the files below a removed, added or modified below
+// b sorted by path.
+func diffTrees(a, b string) ([]SifFileDiff, error) {
+	ta, err := readTree(a)
+	if err != nil {
+		return nil, err
+	}
+	tb, err := readTree(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []SifFileDiff
+	for path, f := range ta {
+		other, ok := tb[path]
+		if !ok {
+			diffs = append(diffs, SifFileDiff{Path: path, Status: SifDiffRemoved})
+		} else if changes := diffFields(f.attrs, other.attrs); len(changes) > 0 {
+			diffs = append(diffs, SifFileDiff{Path: path, Status: SifDiffModified, Changes: changes})
+		}
+	}
+	for path := range tb {
+		if _, ok := ta[path]; !ok {
+			diffs = append(diffs, SifFileDiff{Path: path, Status: SifDiffAdded})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool {
+		return diffs[i].Path < diffs[j].Path
+	})
+	return diffs, nil
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// createTestSIF creates a SIF image holding a definition file with the
+// content def, a raw primary partition with the content part and the
+// generic objects named by the keys of generic.
+func createTestSIF(t *testing.T, path, def, part string, generic map[string]string) {
+	t.Helper()
+
+	cinfo := sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+	}
+
+	input := func(datatype sif.Datatype, name, content string) sif.DescriptorInput {
+		return sif.DescriptorInput{
+			Datatype: datatype,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     sif.DescrUnusedLink,
+			Size:     int64(len(content)),
+			Fname:    name,
+			Fp:       bytes.NewReader([]byte(content)),
+		}
+	}
+
+	cinfo.InputDescr = append(cinfo.InputDescr, input(sif.DataDeffile, "def", def))
+
+	p := input(sif.DataPartition, "rootfs", part)
+	if err := p.SetPartExtra(sif.FsRaw, sif.PartPrimSys, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+		t.Fatalf("while setting partition extra data: %v", err)
+	}
+	cinfo.InputDescr = append(cinfo.InputDescr, p)
+
+	for name, content := range generic {
+		cinfo.InputDescr = append(cinfo.InputDescr, input(sif.DataGeneric, name, content))
+	}
+
+	fimg, err := sif.CreateContainer(cinfo)
+	if err != nil {
+		t.Fatalf("while creating SIF image: %v", err)
+	}
+	fimg.UnloadContainer()
+}
+
+func TestSifDiff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sif-diff-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a.sif")
+	createTestSIF(t, a, "bootstrap: scratch\n", "rootfs", map[string]string{"old": "data"})
+	same := filepath.Join(dir, "same.sif")
+	createTestSIF(t, same, "bootstrap: scratch\n", "rootfs", map[string]string{"old": "data"})
+	b := filepath.Join(dir, "b.sif")
+	createTestSIF(t, b, "bootstrap: scratch\n", "new rootfs", map[string]string{"new": "data"})
+
+	res, err := SifDiff(a, same, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !res.Identical() {
+		t.Errorf("unexpected differences between identical images: %+v", res.Objects)
+	}
+
+	res, err = SifDiff(a, b, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Identical() {
+		t.Fatalf("no difference found")
+	}
+
+	status := make(map[string]string)
+	for _, o := range res.Objects {
+		status[o.Object] = o.Status
+	}
+	expected := map[string]string{
+		"FS (primary system)": SifDiffModified,
+		"Generic/Raw old":     SifDiffRemoved,
+		"Generic/Raw new":     SifDiffAdded,
+	}
+	if !reflect.DeepEqual(status, expected) {
+		t.Errorf("unexpected differences %v instead of %v", status, expected)
+	}
+
+	for _, o := range res.Objects {
+		if o.Status != SifDiffModified {
+			continue
+		}
+		fields := make(map[string]bool)
+		for _, c := range o.Changes {
+			fields[c.Field] = true
+		}
+		if !fields["size"] || !fields["sha256"] || fields["name"] {
+			t.Errorf("unexpected changed fields %+v", o.Changes)
+		}
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sif-diff-tree-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	for _, root := range []string{a, b} {
+		if err := os.MkdirAll(filepath.Join(root, "etc"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(root, "etc", "same"), []byte("same"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writes := []struct {
+		path    string
+		content string
+		mode    os.FileMode
+	}{
+		{filepath.Join(a, "etc", "content"), "old", 0644},
+		{filepath.Join(b, "etc", "content"), "new", 0644},
+		{filepath.Join(a, "etc", "mode"), "mode", 0644},
+		{filepath.Join(b, "etc", "mode"), "mode", 0755},
+		{filepath.Join(a, "removed"), "removed", 0644},
+	}
+	for _, w := range writes {
+		if err := ioutil.WriteFile(w.path, []byte(w.content), w.mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(w.path, w.mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/etc/same", filepath.Join(b, "added")); err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := diffTrees(a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []string
+	for _, d := range diffs {
+		got = append(got, d.Status+" "+d.Path)
+	}
+	expected := []string{
+		SifDiffAdded + " /added",
+		SifDiffModified + " /etc/content",
+		SifDiffModified + " /etc/mode",
+		SifDiffRemoved + " /removed",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected differences %v instead of %v", got, expected)
+	}
+}
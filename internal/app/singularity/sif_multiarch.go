@@ -0,0 +1,103 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
+)
+
+// SifAssembleArch creates the multi-architecture SIF image dest from the
+// SIF images of different architectures. The first image is copied with
+// its signatures, its primary system partition stays the default one,
+// and the primary system partitions of the other images are added as
+// system partitions tagged with their architecture, each in a new
+// group. The runtime selects the partition of the host architecture.
+// The added partitions aren't signed, their signatures can't be kept
+// since they cover the header of their image.
+func SifAssembleArch(dest string, images []string) error {
+	if len(images) == 0 {
+		return fmt.Errorf("no image to assemble")
+	}
+
+	if err := fs.CopyFileAtomic(images[0], dest, 0777); err != nil {
+		return fmt.Errorf("while copying %s: %v", images[0], err)
+	}
+
+	fimg, err := sif.LoadContainer(dest, false)
+	if err != nil {
+		return fmt.Errorf("while loading SIF image %s: %v", dest, err)
+	}
+	defer fimg.UnloadContainer()
+
+	prim, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return fmt.Errorf("while looking for the primary partition of %s: %v", images[0], err)
+	}
+	archs := map[string]bool{partArch(prim): true}
+
+	for _, path := range images[1:] {
+		group := newGroupID(&fimg)
+		if err := addArchPartition(&fimg, path, sif.DescrGroupMask|group, archs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addArchPartition adds the primary system partition of the image path
+// to fimg as a system partition of the group.
+func addArchPartition(fimg *sif.FileImage, path string, group uint32, archs map[string]bool) error {
+	src, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return fmt.Errorf("while loading SIF image %s: %v", path, err)
+	}
+	defer src.UnloadContainer()
+
+	d, _, err := src.GetPartPrimSys()
+	if err != nil {
+		return fmt.Errorf("while looking for the primary partition of %s: %v", path, err)
+	}
+	arch := partArch(d)
+	if archs[arch] {
+		return fmt.Errorf("%s holds a partition of architecture %s already added", path, sif.GetGoArch(arch))
+	}
+	archs[arch] = true
+
+	fstype, err := d.GetFsType()
+	if err != nil {
+		return err
+	}
+
+	input := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  group,
+		Link:     sif.DescrUnusedLink,
+		Size:     d.Filelen,
+		Fname:    d.GetName(),
+		Fp:       io.NewSectionReader(src.Fp, d.Fileoff, d.Filelen),
+	}
+	if err := input.SetPartExtra(fstype, sif.PartSystem, arch); err != nil {
+		return err
+	}
+	if err := fimg.AddObject(input); err != nil {
+		return fmt.Errorf("while adding partition of %s: %v", path, err)
+	}
+	return nil
+}
+
+// partArch returns the SIF architecture of a partition.
+func partArch(d *sif.Descriptor) string {
+	arch, err := d.GetArch()
+	if err != nil {
+		return sif.HdrArchUnknown
+	}
+	return string(bytes.TrimRight(arch[:], "\x00"))
+}
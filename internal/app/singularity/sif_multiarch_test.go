@@ -0,0 +1,117 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// createArchSIF creates a SIF image with a raw primary partition of the
+// architecture arch.
+func createArchSIF(t *testing.T, path, arch, part string) {
+	t.Helper()
+
+	input := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Size:     int64(len(part)),
+		Fname:    "rootfs",
+		Fp:       strings.NewReader(part),
+	}
+	if err := input.SetPartExtra(sif.FsRaw, sif.PartPrimSys, sif.GetSIFArch(arch)); err != nil {
+		t.Fatalf("while setting partition extra data: %v", err)
+	}
+
+	fimg, err := sif.CreateContainer(sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: []sif.DescriptorInput{input},
+	})
+	if err != nil {
+		t.Fatalf("while creating SIF image: %v", err)
+	}
+	fimg.UnloadContainer()
+}
+
+func TestSifAssembleArch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sif-multiarch-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	foreignArch := "s390x"
+	if runtime.GOARCH == foreignArch {
+		foreignArch = "amd64"
+	}
+
+	host := filepath.Join(dir, "host.sif")
+	createArchSIF(t, host, runtime.GOARCH, "host rootfs")
+	foreign := filepath.Join(dir, "foreign.sif")
+	createArchSIF(t, foreign, foreignArch, "foreign rootfs")
+
+	dest := filepath.Join(dir, "fat.sif")
+	if err := SifAssembleArch(dest, []string{host, foreign}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	fimg, err := sif.LoadContainer(dest, true)
+	if err != nil {
+		t.Fatalf("unable to load %s: %v", dest, err)
+	}
+	defer fimg.UnloadContainer()
+
+	partitions := make(map[string]*sif.Descriptor)
+	for i, d := range fimg.DescrArr {
+		if d.Used && d.Datatype == sif.DataPartition {
+			partitions[sif.GetGoArch(partArch(&d))] = &fimg.DescrArr[i]
+		}
+	}
+	if len(partitions) != 2 {
+		t.Fatalf("unexpected %d partitions instead of 2", len(partitions))
+	}
+
+	for arch, expected := range map[string]struct {
+		ptype sif.Parttype
+		group uint32
+		data  string
+	}{
+		runtime.GOARCH: {sif.PartPrimSys, 1, "host rootfs"},
+		foreignArch:    {sif.PartSystem, 2, "foreign rootfs"},
+	} {
+		d, ok := partitions[arch]
+		if !ok {
+			t.Errorf("no %s partition", arch)
+			continue
+		}
+		if ptype, _ := d.GetPartType(); ptype != expected.ptype {
+			t.Errorf("unexpected %s partition type %d instead of %d", arch, ptype, expected.ptype)
+		}
+		if group := d.Groupid &^ sif.DescrGroupMask; group != expected.group {
+			t.Errorf("unexpected %s partition group %d instead of %d", arch, group, expected.group)
+		}
+		if data := d.GetData(&fimg); !bytes.Equal(data, []byte(expected.data)) {
+			t.Errorf("unexpected %s partition data %q", arch, data)
+		}
+	}
+
+	err = SifAssembleArch(filepath.Join(dir, "dup.sif"), []string{host, host})
+	if err == nil || !strings.Contains(err.Error(), "already added") {
+		t.Errorf("unexpected error %v for duplicate architectures", err)
+	}
+}
@@ -6,13 +6,19 @@
 package singularity
 
 import (
+	"errors"
+	"sort"
+
 	"github.com/sylabs/sif/pkg/integrity"
 	"github.com/sylabs/sif/pkg/sif"
 	"github.com/sylabs/singularity/pkg/sypgp"
+	"github.com/sylabs/singularity/pkg/util/verity"
 )
 
 type signer struct {
-	opts []integrity.SignerOpt
+	opts     []integrity.SignerOpt
+	verity   bool
+	unsigned bool
 }
 
 // SignOpt are used to configure s.
@@ -52,6 +58,24 @@ func OptSignObjects(ids ...uint32) SignOpt {
 	}
 }
 
+// OptSignVerity specifies that a dm-verity hash tree be added to the squashfs system partitions
+// before signing them, so that the signatures cover the root hashes.
+func OptSignVerity() SignOpt {
+	return func(s *signer) error {
+		s.verity = true
+		return nil
+	}
+}
+
+// OptSignUnsignedGroups specifies that only the object groups without signature be signed, to
+// sign the objects added to a signed image while keeping the signatures of the other groups.
+func OptSignUnsignedGroups() SignOpt {
+	return func(s *signer) error {
+		s.unsigned = true
+		return nil
+	}
+}
+
 // Sign adds one or more digital signatures to the SIF image found at path, according to opts. Key
 // material must be provided via OptSignEntitySelector.
 //
@@ -73,6 +97,24 @@ func Sign(path string, opts ...SignOpt) error {
 	}
 	defer f.UnloadContainer()
 
+	// Add dm-verity hash tree(s), if applicable.
+	if s.verity {
+		if err := verity.AddToSIF(&f); err != nil {
+			return err
+		}
+	}
+
+	// Select the groups without signature, if applicable.
+	if s.unsigned {
+		groups := unsignedGroups(&f)
+		if len(groups) == 0 {
+			return errors.New("all object groups are already signed")
+		}
+		for _, g := range groups {
+			s.opts = append(s.opts, integrity.OptSignGroup(g))
+		}
+	}
+
 	// Apply signature(s).
 	is, err := integrity.NewSigner(&f, s.opts...)
 	if err != nil {
@@ -80,3 +122,53 @@ func Sign(path string, opts ...SignOpt) error {
 	}
 	return is.Sign()
 }
+
+// objectGroups returns the sorted IDs of the object groups of the image, the groups of the
+// signatures excepted.
+func objectGroups(f *sif.FileImage) []uint32 {
+	var groups []uint32
+	seen := make(map[uint32]bool)
+	for _, d := range f.DescrArr {
+		if !d.Used || d.Datatype == sif.DataSignature || d.Groupid == sif.DescrUnusedGroup {
+			continue
+		}
+		if g := d.Groupid &^ sif.DescrGroupMask; !seen[g] {
+			seen[g] = true
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i] < groups[j] })
+	return groups
+}
+
+// isGroupSigned returns true if a signature covers the object group.
+func isGroupSigned(f *sif.FileImage, groupID uint32) bool {
+	for _, d := range f.DescrArr {
+		if d.Used && d.Datatype == sif.DataSignature && d.Link == sif.DescrGroupMask|groupID {
+			return true
+		}
+	}
+	return false
+}
+
+// unsignedGroups returns the IDs of the object groups of the image without signature.
+func unsignedGroups(f *sif.FileImage) []uint32 {
+	var groups []uint32
+	for _, g := range objectGroups(f) {
+		if !isGroupSigned(f, g) {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
+
+// newGroupID returns the ID of a new object group of the image.
+func newGroupID(f *sif.FileImage) uint32 {
+	group := uint32(0)
+	for _, d := range f.DescrArr {
+		if d.Used && d.Groupid != sif.DescrUnusedGroup && d.Groupid&^sif.DescrGroupMask > group {
+			group = d.Groupid &^ sif.DescrGroupMask
+		}
+	}
+	return group + 1
+}
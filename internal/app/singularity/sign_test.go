@@ -6,14 +6,17 @@
 package singularity
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/sylabs/scs-key-client/client"
 	"github.com/sylabs/sif/pkg/integrity"
 	"github.com/sylabs/singularity/pkg/sypgp"
 	"golang.org/x/crypto/openpgp"
@@ -100,3 +103,53 @@ func TestSign(t *testing.T) {
 		})
 	}
 }
+
+func TestSignUnsignedGroups(t *testing.T) {
+	e := getTestEntity(t)
+	s := httptest.NewServer(mockHKP{e: e})
+	defer s.Close()
+
+	mockEntityOpt := OptSignEntitySelector(mockEntitySelector(t))
+	keyServerOpt := OptVerifyUseKeyServer(&client.Config{BaseURL: s.URL})
+
+	path, err := tempFileFrom(filepath.Join("testdata", "images", "one-group-signed.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(path)
+
+	if err := Sign(path, mockEntityOpt, OptSignUnsignedGroups()); err == nil {
+		t.Errorf("unexpected success signing a signed image")
+	}
+
+	// the artifact is added to a new group, which is the only one
+	// without signature
+	if _, err := SifAddArtifact(path, filepath.Join("testdata", "keys", "private.asc"), "text/plain", "artifact", 0); err != nil {
+		t.Fatal(err)
+	}
+
+	verify := func() map[uint32]error {
+		t.Helper()
+
+		results := make(map[uint32]error)
+		cb := func(groupID uint32, err error) {
+			results[groupID] = err
+		}
+		Verify(context.Background(), path, keyServerOpt, OptVerifyGroupCallback(cb))
+		return results
+	}
+
+	results := verify()
+	if len(results) != 2 || results[1] != nil || !errors.Is(results[2], &integrity.SignatureNotFoundError{}) {
+		t.Fatalf("unexpected results before signing %v", results)
+	}
+
+	if err := Sign(path, mockEntityOpt, OptSignUnsignedGroups()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	results = verify()
+	if len(results) != 2 || results[1] != nil || results[2] != nil {
+		t.Fatalf("unexpected results after signing %v", results)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+
+	"github.com/sylabs/singularity/internal/pkg/chunkstore"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// StoreAdd adds the SIF image path to the chunk store as name.
+func StoreAdd(s *chunkstore.Store, name, path string) error {
+	before, err := s.Usage()
+	if err != nil {
+		return err
+	}
+	img, err := s.Add(name, path)
+	if err != nil {
+		return err
+	}
+	after, err := s.Usage()
+	if err != nil {
+		return err
+	}
+
+	sylog.Infof("Image %s added to the store, %d chunks, %s of new chunks for %s",
+		img.Name, len(img.Chunks), findSize(after.StoredSize-before.StoredSize), findSize(img.Size))
+	return nil
+}
+
+// StoreList lists the images of the chunk store and the space saved by
+// the deduplication of their chunks.
+func StoreList(s *chunkstore.Store) error {
+	images, err := s.Images()
+	if err != nil {
+		return err
+	}
+	u, err := s.Usage()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-32s %-12s %s\n", "NAME", "SIZE", "CHUNKS")
+	for _, img := range images {
+		fmt.Printf("%-32s %-12s %d\n", img.Name, findSize(img.Size), len(img.Chunks))
+	}
+
+	saved := u.ImagesSize - u.StoredSize
+	if saved < 0 {
+		saved = 0
+	}
+	fmt.Printf("\nThere are %d images using %s for %s of images (%s saved), %d chunks stored\n",
+		u.Images, findSize(u.StoredSize), findSize(u.ImagesSize), findSize(saved), u.Chunks)
+	return nil
+}
+
+// StorePrune removes the chunks of the chunk store no longer referenced
+// by an image.
+func StorePrune(s *chunkstore.Store, dryRun bool) error {
+	count, size, err := s.Prune(dryRun)
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		fmt.Printf("Would remove %d chunks (%s)\n", count, findSize(size))
+	} else {
+		fmt.Printf("Removed %d chunks (%s)\n", count, findSize(size))
+	}
+	return nil
+}
@@ -7,16 +7,24 @@ package singularity
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/sylabs/scs-key-client/client"
 	"github.com/sylabs/sif/pkg/integrity"
 	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/audit"
+	"github.com/sylabs/singularity/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/sypgp"
 	"golang.org/x/crypto/openpgp"
 )
 
 type VerifyCallback func(*sif.FileImage, integrity.VerifyResult) bool
 
+// VerifyGroupCallback is called with the result of the verification of an object group, err is
+// nil if the group is verified.
+type VerifyGroupCallback func(groupID uint32, err error)
+
 type verifier struct {
 	c         *client.Config
 	groupIDs  []uint32
@@ -24,6 +32,7 @@ type verifier struct {
 	all       bool
 	legacy    bool
 	cb        VerifyCallback
+	groupCb   VerifyGroupCallback
 }
 
 // VerifyOpt are used to configure v.
@@ -82,6 +91,17 @@ func OptVerifyCallback(cb VerifyCallback) VerifyOpt {
 	}
 }
 
+// OptVerifyGroupCallback registers cb as the object group verification callback. The object
+// groups are then verified independently, the verification goes on after a group failed so that
+// the result of each group is reported. It has no effect on the verification of objects and
+// legacy signatures.
+func OptVerifyGroupCallback(cb VerifyGroupCallback) VerifyOpt {
+	return func(v *verifier) error {
+		v.groupCb = cb
+		return nil
+	}
+}
+
 // newVerifier constructs a new verifier based on opts.
 func newVerifier(opts []VerifyOpt) (verifier, error) {
 	v := verifier{}
@@ -161,6 +181,10 @@ func (v verifier) getOpts(ctx context.Context, f *sif.FileImage) ([]integrity.Ve
 // By default, non-legacy signatures for all object groups are verified. To override the default
 // behavior, consider using OptVerifyGroup, OptVerifyObject, OptVerifyAll, and/or OptVerifyLegacy.
 func Verify(ctx context.Context, path string, opts ...VerifyOpt) error {
+	span := sylog.StartSpan("verify")
+	span.SetAttribute("image", path)
+	defer span.End()
+
 	v, err := newVerifier(opts)
 	if err != nil {
 		return err
@@ -173,16 +197,67 @@ func Verify(ctx context.Context, path string, opts ...VerifyOpt) error {
 	}
 	defer f.UnloadContainer()
 
-	// Get options to validate f.
-	vopts, err := v.getOpts(ctx, &f)
+	// Verify signature(s).
+	if v.groupCb != nil && !v.legacy && len(v.objectIDs) == 0 {
+		err = v.verifyGroups(ctx, &f)
+	} else {
+		var vopts []integrity.VerifierOpt
+		if vopts, err = v.getOpts(ctx, &f); err == nil {
+			err = verifyImage(&f, vopts)
+		}
+	}
+	if err != nil {
+		audit.Record(audit.Verify, audit.Failure, "image", path, "error", err.Error())
+		return signatureError(err)
+	}
+	audit.Record(audit.Verify, audit.Success, "image", path)
+	return nil
+}
+
+// verifyGroups verifies the selected object groups of f, or all of them, one by one and reports
+// their result to the group callback. It returns the error of the first group which failed.
+func (v verifier) verifyGroups(ctx context.Context, f *sif.FileImage) error {
+	groups := v.groupIDs
+	if len(groups) == 0 {
+		groups = objectGroups(f)
+	}
+
+	// Get options to validate f, groups excepted.
+	gv := v
+	gv.groupIDs = nil
+	vopts, err := gv.getOpts(ctx, f)
 	if err != nil {
 		return err
 	}
+	if len(groups) == 0 {
+		return verifyImage(f, vopts)
+	}
 
-	// Verify signature(s).
-	iv, err := integrity.NewVerifier(&f, vopts...)
+	var groupErr error
+	for _, g := range groups {
+		err := verifyImage(f, append(vopts, integrity.OptVerifyGroup(g)))
+		v.groupCb(g, err)
+		if err != nil && groupErr == nil {
+			groupErr = fmt.Errorf("object group %d: %w", g, err)
+		}
+	}
+	return groupErr
+}
+
+// verifyImage verifies the signatures of f according to vopts.
+func verifyImage(f *sif.FileImage, vopts []integrity.VerifierOpt) error {
+	iv, err := integrity.NewVerifier(f, vopts...)
 	if err != nil {
 		return err
 	}
 	return iv.Verify()
 }
+
+// signatureError attaches the signature error code matching err.
+func signatureError(err error) error {
+	var snf *integrity.SignatureNotFoundError
+	if errors.As(err, &snf) {
+		return sylog.WithCode(sylog.SignatureNotFound, err)
+	}
+	return sylog.WithCode(sylog.SignatureInvalid, err)
+}
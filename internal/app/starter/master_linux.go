@@ -43,7 +43,7 @@ func createContainer(ctx context.Context, rpcSocket int, containerPid int, e *en
 			err = errors.New("failed to decrypt, ensure you have supplied appropriate key material")
 		}
 
-		fatalChan <- fmt.Errorf("container creation failed: %s", err)
+		fatalChan <- fmt.Errorf("container creation failed: %w", err)
 		return
 	}
 
@@ -106,7 +106,7 @@ func startContainer(ctx context.Context, masterSocket int, containerPid int, e *
 
 	err = e.PostStartProcess(ctx, containerPid)
 	if err != nil {
-		fatalChan <- fmt.Errorf("post start process failed: %s", err)
+		fatalChan <- fmt.Errorf("post start process failed: %w", err)
 		return
 	}
 }
@@ -170,5 +170,6 @@ func Master(rpcSocket, masterSocket int, containerPid int, e *engine.Engine) {
 	}
 
 	// if previous signal didn't interrupt process
+	sylog.Flush()
 	os.Exit(exitCode)
 }
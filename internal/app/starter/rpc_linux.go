@@ -30,5 +30,6 @@ func RPCServer(socket int, e *engine.Engine) {
 	comm.Close()
 	engine.ServeRPCRequests(e, conn)
 
+	sylog.Flush()
 	os.Exit(0)
 }
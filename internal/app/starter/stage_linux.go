@@ -25,6 +25,13 @@ func StageOne(sconfig *starterConfig.Config, e *engine.Engine) {
 	sylog.Debugf("Entering stage 1\n")
 
 	if err := e.PrepareConfig(sconfig); err != nil {
+		// write back the engine configuration to let starter
+		// handle the failure with the suid flow privileges
+		if _, ok := e.Operations.(engine.FailureOperations); ok && sconfig.GetIsSUID() {
+			if err := sconfig.Write(e.Common); err == nil {
+				sconfig.SetStageOneFailure(true)
+			}
+		}
 		sylog.Fatalf("%s\n", err)
 	}
 
@@ -32,9 +39,27 @@ func StageOne(sconfig *starterConfig.Config, e *engine.Engine) {
 		sylog.Fatalf("%s", err)
 	}
 
+	sylog.Flush()
 	os.Exit(0)
 }
 
+// StageOneFailure handles the failure of stage 1 with the engine
+// configuration written back by stage 1 and exits with the stage 1
+// exit status.
+//
+// Privileges can be escalated with the SUID flow.
+func StageOneFailure(status int, e *engine.Engine) {
+	sylog.Debugf("Handle stage 1 failure\n")
+
+	if op, ok := e.Operations.(engine.FailureOperations); ok {
+		if err := op.PrepareConfigFailure(); err != nil {
+			sylog.Errorf("%s", err)
+		}
+	}
+
+	os.Exit(status)
+}
+
 // StageTwo performs container execution.
 func StageTwo(masterSocket int, e *engine.Engine) {
 	sylog.Debugf("Entering stage 2\n")
@@ -0,0 +1,250 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package audit records security relevant events (setuid invocations,
+// fakeroot escalations, ECL decisions, signature verifications, encrypted
+// image unlocks) to a root owned append only file or to the kernel audit
+// subsystem, independently of the messages displayed to the user.
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/sylabs/singularity/internal/pkg/util/priv"
+	"github.com/sylabs/singularity/pkg/sylog"
+	"golang.org/x/sys/unix"
+)
+
+// Auditd is the destination sending records to the kernel audit
+// subsystem which is then forwarded to auditd.
+const Auditd = "auditd"
+
+// auditTrustedApp is the AUDIT_TRUSTED_APP message type
+// used for records sent to the kernel audit subsystem.
+const auditTrustedApp = 1121
+
+// Events recorded.
+const (
+	// Starter is recorded when the setuid starter is invoked.
+	Starter = "starter"
+	// Fakeroot is recorded when a fakeroot user namespace is requested.
+	Fakeroot = "fakeroot"
+	// ECL is recorded when the execution control list allows or denies an image.
+	ECL = "ecl"
+	// Signature is recorded when the signature policy allows or denies an image.
+	Signature = "signature"
+	// Verify is recorded when image signatures are verified.
+	Verify = "verify"
+	// Decrypt is recorded when an encrypted image is unlocked.
+	Decrypt = "decrypt"
+	// Verity is recorded when a dm-verity device is set up for an image.
+	Verity = "verity"
+)
+
+// Results recorded along with events.
+const (
+	Success = "success"
+	Failure = "failure"
+	Allowed = "allowed"
+	Denied  = "denied"
+)
+
+var (
+	mutex       sync.Mutex
+	destination string
+	pending     []string
+)
+
+// SetDestination sets where the records are written, dest is either
+// Auditd, the absolute path of a file or an empty string to disable
+// auditing.
+func SetDestination(dest string) error {
+	if dest != "" && dest != Auditd && !filepath.IsAbs(dest) {
+		return fmt.Errorf("audit destination %q is neither %s nor an absolute path", dest, Auditd)
+	}
+
+	mutex.Lock()
+	destination = dest
+	mutex.Unlock()
+
+	return nil
+}
+
+// Enabled returns true if a destination is set.
+func Enabled() bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+	return destination != ""
+}
+
+// Record records the event with its result and the alternated keys and
+// values describing it (eg: Record(ECL, Denied, "image", path)). Records
+// of processes without privileges, like the stage 1 of the setuid
+// workflow, are kept until retrieved with Pending.
+func Record(event, result string, keysAndValues ...string) {
+	if !Enabled() {
+		return
+	}
+
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "time=%s event=%s result=%s", time.Now().Format(time.RFC3339Nano), event, result)
+	fmt.Fprintf(&b, " uid=%d euid=%d pid=%d", os.Getuid(), os.Geteuid(), os.Getpid())
+	for i := 0; i < len(keysAndValues); i += 2 {
+		v := ""
+		if i+1 < len(keysAndValues) {
+			v = keysAndValues[i+1]
+		}
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", keysAndValues[i], v)
+	}
+
+	Write([]string{b.String()})
+}
+
+// Pending returns and discards the records which couldn't be written
+// by the current process.
+func Pending() []string {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	records := pending
+	pending = nil
+	return records
+}
+
+// Write writes the records to the destination, it's used by the master
+// process to write the records of the stage 1 passed along with the engine
+// configuration. Records are kept pending if the process can't gain the
+// privileges required to write them.
+func Write(records []string) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if destination == "" || len(records) == 0 {
+		return
+	}
+
+	if !privileged() {
+		pending = append(pending, records...)
+		return
+	}
+
+	var err error
+	if destination == Auditd {
+		err = sendAuditd(records)
+	} else {
+		err = appendFile(destination, records)
+	}
+	if err != nil {
+		sylog.Warningf("Could not write audit records: %s", err)
+	}
+}
+
+// privileged returns true if the process is running as root or
+// is able to escalate its privileges.
+func privileged() bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+	var ruid, euid, suid uint32
+	unix.RawSyscall(
+		unix.SYS_GETRESUID,
+		uintptr(unsafe.Pointer(&ruid)),
+		uintptr(unsafe.Pointer(&euid)),
+		uintptr(unsafe.Pointer(&suid)),
+	)
+	return suid == 0
+}
+
+// escalate escalates privileges if the process isn't running as root,
+// the returned function drops them.
+func escalate() (func(), error) {
+	if os.Geteuid() == 0 {
+		return func() {}, nil
+	}
+	if err := priv.Escalate(); err != nil {
+		return nil, fmt.Errorf("while escalating privileges: %s", err)
+	}
+	return func() {
+		if err := priv.Drop(); err != nil {
+			sylog.Fatalf("Could not drop privileges: %s", err)
+		}
+	}, nil
+}
+
+// appendFile appends the records to the file at path, the file is created
+// if it doesn't exist and must be a regular file owned by root and only
+// writable by its owner.
+func appendFile(path string, records []string) error {
+	drop, err := escalate()
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE|syscall.O_NOFOLLOW, 0600)
+	drop()
+	if err != nil {
+		return fmt.Errorf("while opening audit log: %s", err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("while getting audit log information: %s", err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	if !fi.Mode().IsRegular() || st.Uid != 0 || fi.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s must be a regular file owned by root and not writable by group or others", path)
+	}
+
+	if _, err := f.WriteString(strings.Join(records, "\n") + "\n"); err != nil {
+		return fmt.Errorf("while writing audit log: %s", err)
+	}
+	return nil
+}
+
+// sendAuditd sends the records to the kernel audit subsystem,
+// it requires the CAP_AUDIT_WRITE capability.
+func sendAuditd(records []string) error {
+	drop, err := escalate()
+	if err != nil {
+		return err
+	}
+	defer drop()
+
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_AUDIT)
+	if err != nil {
+		return fmt.Errorf("while creating audit socket: %s", err)
+	}
+	defer unix.Close(fd)
+
+	sa := &unix.SockaddrNetlink{Family: unix.AF_NETLINK}
+
+	for i, r := range records {
+		msg := make([]byte, unix.SizeofNlMsghdr+len(r)+1)
+		// netlink headers are in host byte order
+		hdr := (*unix.NlMsghdr)(unsafe.Pointer(&msg[0]))
+		hdr.Len = uint32(len(msg))
+		hdr.Type = auditTrustedApp
+		hdr.Flags = unix.NLM_F_REQUEST
+		hdr.Seq = uint32(i + 1)
+		copy(msg[unix.SizeofNlMsghdr:], r)
+
+		if err := unix.Sendto(fd, msg, 0, sa); err != nil {
+			return fmt.Errorf("while sending audit record: %s", err)
+		}
+	}
+	return nil
+}
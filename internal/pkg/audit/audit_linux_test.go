@@ -0,0 +1,107 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+)
+
+func TestSetDestination(t *testing.T) {
+	defer SetDestination("")
+
+	tests := []struct {
+		dest       string
+		shouldFail bool
+		enabled    bool
+	}{
+		{"", false, false},
+		{Auditd, false, true},
+		{"/var/log/singularity/audit.log", false, true},
+		{"audit.log", true, false},
+	}
+
+	for _, tt := range tests {
+		SetDestination("")
+
+		err := SetDestination(tt.dest)
+		if err != nil && !tt.shouldFail {
+			t.Errorf("unexpected error for %q: %s", tt.dest, err)
+		} else if err == nil && tt.shouldFail {
+			t.Errorf("unexpected success for %q", tt.dest)
+		}
+		if Enabled() != tt.enabled {
+			t.Errorf("unexpected enabled state for %q", tt.dest)
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "audit-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+
+	if err := SetDestination(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer SetDestination("")
+
+	Record(ECL, Denied, "image", "/tmp/my image.sif")
+
+	t.Run("unprivileged", func(t *testing.T) {
+		test.DropPrivilege(t)
+		defer test.ResetPrivilege(t)
+
+		Record(Decrypt, Success, "image", "/tmp/encrypted.sif")
+	})
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("unexpected audit log permissions %o", fi.Mode().Perm())
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("unexpected number of records %d: %q", len(lines), b)
+	}
+	if !strings.Contains(lines[0], " event=ecl result=denied uid=0 ") || !strings.HasSuffix(lines[0], ` image="/tmp/my image.sif"`) {
+		t.Errorf("unexpected record %q", lines[0])
+	}
+	if !strings.Contains(lines[1], " event=decrypt result=success ") || !strings.HasSuffix(lines[1], " image=/tmp/encrypted.sif") {
+		t.Errorf("unexpected record %q", lines[1])
+	}
+	if p := Pending(); len(p) != 0 {
+		t.Errorf("unexpected pending records %v", p)
+	}
+
+	// group writable audit log are ignored
+	if err := os.Chmod(path, 0660); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	Record(Verify, Failure, "image", "/tmp/unsigned.sif")
+
+	if nb, _ := ioutil.ReadFile(path); len(nb) != len(b) {
+		t.Errorf("unexpected record written to a group writable audit log")
+	}
+}
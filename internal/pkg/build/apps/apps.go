@@ -34,6 +34,7 @@ const (
 	sectionHelp    = "apphelp"
 	sectionRun     = "apprun"
 	sectionLabels  = "applabels"
+	sectionDepends = "appdepends"
 )
 
 var (
@@ -45,6 +46,7 @@ var (
 		sectionHelp:    true,
 		sectionRun:     true,
 		sectionLabels:  true,
+		sectionDepends: true,
 	}
 )
 
@@ -79,6 +81,24 @@ SCIF_APPDATA="/scif/data/%[1]s"
 SCIF_APPINPUT="/scif/data/%[1]s/input"
 SCIF_APPOUTPUT="/scif/data/%[1]s/output"
 export SCIF_APPDATA SCIF_APPNAME SCIF_APPROOT SCIF_APPMETA SCIF_APPINPUT SCIF_APPOUTPUT SCIF_DATA
+`
+
+	scifEnv02Dependencies = `#!/bin/sh
+
+## Dependencies Of: %[1]s
+`
+
+	scifEnv02Dependency = `
+if test -d "/scif/apps/%[1]s/bin"; then
+    PATH="/scif/apps/%[1]s/bin:$PATH"
+fi
+if test -d "/scif/apps/%[1]s/lib"; then
+    LD_LIBRARY_PATH="/scif/apps/%[1]s/lib:$LD_LIBRARY_PATH"
+fi
+if test -f "/scif/apps/%[1]s/scif/env/90-environment.sh"; then
+    . "/scif/apps/%[1]s/scif/env/90-environment.sh"
+fi
+export PATH LD_LIBRARY_PATH
 `
 
 	scifRunscriptBase = `#!/bin/sh
@@ -93,7 +113,7 @@ export SCIF_APPDATA SCIF_APPNAME SCIF_APPROOT SCIF_APPMETA SCIF_APPINPUT SCIF_AP
 	scifInstallBase = `
 cd /
 . %[1]s/scif/env/01-base.sh
-
+%[3]s
 cd %[1]s
 %[2]s
 
@@ -111,6 +131,7 @@ type App struct {
 	Help    string
 	Run     string
 	Labels  string
+	Depends string
 }
 
 // BuildApp is the type which the build system can use to build an app in a bundle
@@ -157,6 +178,8 @@ func (pl *BuildApp) HandleSection(ident, section string) {
 		app.Run = section
 	case sectionLabels:
 		app.Labels = section
+	case sectionDepends:
+		app.Depends = section
 	default:
 		return
 	}
@@ -206,7 +229,12 @@ func (pl *BuildApp) HandleBundle(b *types.Bundle) {
 func (pl *BuildApp) createAllApps(b *types.Bundle) error {
 	globalEnv94 := ""
 
-	for _, name := range b.Recipe.AppOrder {
+	order, err := types.AppInstallOrder(b.Recipe)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range order {
 		app, ok := pl.Apps[name]
 		if !ok {
 			return fmt.Errorf("No BuildApp record for app %s", name)
@@ -217,7 +245,16 @@ func (pl *BuildApp) createAllApps(b *types.Bundle) error {
 			return err
 		}
 
-		if err := writeEnvFile(b, app); err != nil {
+		deps, err := types.AppRequirements(b.Recipe, name)
+		if err != nil {
+			return err
+		}
+		if err := writeDependenciesFile(b, app, deps); err != nil {
+			return err
+		}
+
+		env, err := writeEnvFile(b, app)
+		if err != nil {
 			return err
 		}
 
@@ -241,6 +278,10 @@ func (pl *BuildApp) createAllApps(b *types.Bundle) error {
 			return err
 		}
 
+		if err := writeMetadata(b, app, deps, env); err != nil {
+			return err
+		}
+
 		globalEnv94 += globalAppEnv(b, app)
 	}
 
@@ -280,17 +321,42 @@ func createAppRoot(b *types.Bundle, a *App) error {
 }
 
 // %appenv and 01-base.sh
-func writeEnvFile(b *types.Bundle, a *App) error {
+func writeEnvFile(b *types.Bundle, a *App) (*types.Environment, error) {
 	content := fmt.Sprintf(scifEnv01Base, a.Name)
 	if err := ioutil.WriteFile(filepath.Join(appMeta(b, a), "/env/01-base.sh"), []byte(content), 0755); err != nil {
-		return err
+		return nil, err
 	}
 
 	if a.Env == "" {
+		return nil, nil
+	}
+
+	env, err := types.ParseEnvironment(types.Script{
+		Args:   b.Recipe.CustomArgs[sectionEnv+" "+a.Name],
+		Script: a.Env,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%%%s %s: %v", sectionEnv, a.Name, err)
+	}
+	for _, v := range env.Unexported() {
+		sylog.Warningf("%%%s %s line %d: variable %s is not exported, it won't be set for the app processes", sectionEnv, a.Name, v.Line, v.Name)
+	}
+
+	return env, ioutil.WriteFile(filepath.Join(appMeta(b, a), "/env/90-environment.sh"), []byte(env.Script), 0755)
+}
+
+// %appdepends and 02-dependencies.sh, the environment of the apps
+// required by the app is set before its own environment
+func writeDependenciesFile(b *types.Bundle, a *App, deps []string) error {
+	if len(deps) == 0 {
 		return nil
 	}
 
-	return ioutil.WriteFile(filepath.Join(appMeta(b, a), "/env/90-environment.sh"), []byte(a.Env), 0755)
+	content := fmt.Sprintf(scifEnv02Dependencies, a.Name)
+	for _, dep := range deps {
+		content += fmt.Sprintf(scifEnv02Dependency, dep)
+	}
+	return ioutil.WriteFile(filepath.Join(appMeta(b, a), "/env/02-dependencies.sh"), []byte(content), 0755)
 }
 
 func globalAppEnv(b *types.Bundle, a *App) string {
@@ -329,8 +395,17 @@ func writeTestFile(b *types.Bundle, a *App) error {
 		return nil
 	}
 
+	opts, err := types.ParseTestOptions(b.Recipe.CustomArgs[sectionTest+" "+a.Name])
+	if err != nil {
+		return fmt.Errorf("%%%s %s: %v", sectionTest, a.Name, err)
+	}
+
+	path := filepath.Join(appMeta(b, a), "/test")
 	content := fmt.Sprintf(scifTestBase, a.Test)
-	return ioutil.WriteFile(filepath.Join(appMeta(b, a), "/test"), []byte(content), 0755)
+	if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+		return err
+	}
+	return types.WriteTestOptions(path, opts)
 }
 
 // %apphelp
@@ -380,6 +455,33 @@ func copyFiles(b *types.Bundle, a *App) error {
 
 // %applabels
 func writeLabels(b *types.Bundle, a *App) error {
+	// make new map into json
+	text, err := json.MarshalIndent(appLabels(a), "", "\t")
+	if err != nil {
+		return err
+	}
+
+	appBase := filepath.Join(b.RootfsPath, "/scif/apps/", a.Name)
+	err = ioutil.WriteFile(filepath.Join(appBase, "scif/labels.json"), text, 0644)
+	return err
+}
+
+// app.json
+func writeMetadata(b *types.Bundle, a *App, deps []string, env *types.Environment) error {
+	text, err := json.MarshalIndent(types.AppMetadata{
+		Name:         a.Name,
+		Dependencies: deps,
+		Labels:       appLabels(a),
+		Environment:  env,
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(appMeta(b, a), types.AppMetadataFile), text, 0644)
+}
+
+func appLabels(a *App) map[string]string {
 	lines := strings.Split(strings.TrimSpace(a.Labels), "\n")
 	labels := make(map[string]string)
 
@@ -405,15 +507,7 @@ func writeLabels(b *types.Bundle, a *App) error {
 		labels[key] = val
 	}
 
-	// make new map into json
-	text, err := json.MarshalIndent(labels, "", "\t")
-	if err != nil {
-		return err
-	}
-
-	appBase := filepath.Join(b.RootfsPath, "/scif/apps/", a.Name)
-	err = ioutil.WriteFile(filepath.Join(appBase, "scif/labels.json"), text, 0644)
-	return err
+	return labels
 }
 
 //util funcs
@@ -445,7 +539,12 @@ func copy(src, dst string) error {
 // HandlePost returns a script that should run after %post
 func (pl *BuildApp) HandlePost(b *types.Bundle) (string, error) {
 	post := ""
-	for _, name := range b.Recipe.AppOrder {
+	order, err := types.AppInstallOrder(b.Recipe)
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range order {
 		sylog.Debugf("Fetching app[%s] post script section", name)
 		app, ok := pl.Apps[name]
 		if !ok {
@@ -454,12 +553,24 @@ func (pl *BuildApp) HandlePost(b *types.Bundle) (string, error) {
 
 		sylog.Debugf("Building app[%s] post script section", name)
 
-		post += buildPost(app)
+		deps, err := types.AppRequirements(b.Recipe, name)
+		if err != nil {
+			return "", err
+		}
+
+		post += buildPost(app, deps)
 	}
 
 	return post, nil
 }
 
-func buildPost(a *App) string {
-	return fmt.Sprintf(scifInstallBase, filepath.Join("/scif/apps/", a.Name), a.Install)
+// buildPost returns the %appinstall script, the apps it depends on are
+// installed first and their environment is set.
+func buildPost(a *App, deps []string) string {
+	appRoot := filepath.Join("/scif/apps/", a.Name)
+	depsEnv := ""
+	if len(deps) > 0 {
+		depsEnv = fmt.Sprintf(". %s/scif/env/02-dependencies.sh\n", appRoot)
+	}
+	return fmt.Sprintf(scifInstallBase, appRoot, a.Install, depsEnv)
 }
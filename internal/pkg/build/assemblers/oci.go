@@ -0,0 +1,384 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/containers/image/v5/copy"
+	dockerarchive "github.com/containers/image/v5/docker/archive"
+	ocilayout "github.com/containers/image/v5/oci/layout"
+	"github.com/containers/image/v5/signature"
+	imagetypes "github.com/containers/image/v5/types"
+	"github.com/containers/storage/pkg/archive"
+	"github.com/containers/storage/pkg/idtools"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	runtimespecs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/util/env"
+	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/sylog"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Output formats of the OCIAssembler.
+const (
+	// FormatOCI is an OCI image layout directory.
+	FormatOCI = "oci"
+	// FormatDockerArchive is a docker archive loaded by docker load.
+	FormatDockerArchive = "docker-archive"
+)
+
+// runscriptPath is the entrypoint of the OCI image when the container
+// has a runscript.
+const runscriptPath = "/.singularity.d/runscript"
+
+// OCIAssembler assembles a single layer OCI image, written as an OCI
+// image layout or a docker archive.
+type OCIAssembler struct {
+	// Format is FormatOCI or FormatDockerArchive.
+	Format string
+	// Reference is the optional tag of an OCI layout image, or the
+	// name:tag of a docker archive image.
+	Reference string
+}
+
+// Assemble creates an OCI image from a Bundle.
+func (a *OCIAssembler) Assemble(b *types.Bundle, path string) error {
+	sylog.Infof("Creating %s image...", a.Format)
+
+	ref := path
+	if a.Reference != "" {
+		ref += ":" + a.Reference
+	}
+	var destRef imagetypes.ImageReference
+	var err error
+	switch a.Format {
+	case FormatOCI:
+		destRef, err = ocilayout.ParseReference(ref)
+	case FormatDockerArchive:
+		destRef, err = dockerarchive.ParseReference(ref)
+	default:
+		return fmt.Errorf("unrecognized OCI output format %s", a.Format)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid %s destination %s: %v", a.Format, ref, err)
+	}
+
+	layout, err := ioutil.TempDir(b.TmpDir, "oci-layout-")
+	if err != nil {
+		return fmt.Errorf("while creating temporary OCI layout: %v", err)
+	}
+	defer os.RemoveAll(layout)
+
+	if err := writeOCILayout(b, layout); err != nil {
+		return fmt.Errorf("while creating OCI image: %v", err)
+	}
+	srcRef, err := ocilayout.ParseReference(layout)
+	if err != nil {
+		return err
+	}
+
+	// the image replaces the build target
+	if _, err := os.Stat(path); err == nil {
+		os.RemoveAll(path)
+	}
+
+	policy := &signature.Policy{Default: []signature.PolicyRequirement{signature.NewPRInsecureAcceptAnything()}}
+	policyCtx, err := signature.NewPolicyContext(policy)
+	if err != nil {
+		return err
+	}
+	defer policyCtx.Destroy()
+
+	_, err = copy.Image(context.Background(), policyCtx, destRef, srcRef, &copy.Options{
+		ReportWriter: ioutil.Discard,
+	})
+	if err != nil {
+		return fmt.Errorf("while writing %s image: %v", a.Format, err)
+	}
+	return nil
+}
+
+// writeOCILayout writes the root filesystem of the bundle as the single
+// layer of an image in the OCI layout directory dir.
+func writeOCILayout(b *types.Bundle, dir string) error {
+	blobs := filepath.Join(dir, "blobs", string(digest.Canonical))
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		return err
+	}
+
+	layer, diffID, err := writeLayer(b, blobs)
+	if err != nil {
+		return fmt.Errorf("while creating image layer: %v", err)
+	}
+
+	img, err := imageConfig(b)
+	if err != nil {
+		return err
+	}
+	img.RootFS = imgspecv1.RootFS{
+		Type:    "layers",
+		DiffIDs: []digest.Digest{diffID},
+	}
+	config, err := writeBlob(blobs, imgspecv1.MediaTypeImageConfig, img)
+	if err != nil {
+		return err
+	}
+
+	m := imgspecv1.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Config:    config,
+		Layers:    []imgspecv1.Descriptor{layer},
+	}
+	manifest, err := writeBlob(blobs, imgspecv1.MediaTypeImageManifest, m)
+	if err != nil {
+		return err
+	}
+
+	index := imgspecv1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []imgspecv1.Descriptor{manifest},
+	}
+	if err := writeJSON(filepath.Join(dir, "index.json"), index); err != nil {
+		return err
+	}
+	return writeJSON(filepath.Join(dir, imgspecv1.ImageLayoutFile), imgspecv1.ImageLayout{Version: imgspecv1.ImageLayoutVersion})
+}
+
+// writeLayer writes the gzip compressed tar archive of the root
+// filesystem in the blobs directory, it returns the descriptor of the
+// layer and the digest of the uncompressed archive.
+func writeLayer(b *types.Bundle, blobs string) (imgspecv1.Descriptor, digest.Digest, error) {
+	opts := &archive.TarOptions{Compression: archive.Uncompressed}
+	if b.UserNamespace != nil {
+		// the subordinate IDs owning the files are the container IDs
+		for _, m := range b.UserNamespace.UIDMappings {
+			opts.UIDMaps = append(opts.UIDMaps, idMap(m))
+		}
+		for _, m := range b.UserNamespace.GIDMappings {
+			opts.GIDMaps = append(opts.GIDMaps, idMap(m))
+		}
+	} else if syscall.Getuid() != 0 {
+		// files are owned by root when building as a user
+		opts.ChownOpts = &idtools.IDPair{UID: 0, GID: 0}
+	}
+
+	rc, err := archive.TarWithOptions(b.RootfsPath, opts)
+	if err != nil {
+		return imgspecv1.Descriptor{}, "", err
+	}
+	defer rc.Close()
+
+	f, err := ioutil.TempFile(blobs, "layer-")
+	if err != nil {
+		return imgspecv1.Descriptor{}, "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	diffIDs := digest.Canonical.Digester()
+	digests := digest.Canonical.Digester()
+	counter := &countWriter{w: io.MultiWriter(f, digests.Hash())}
+	gz := gzip.NewWriter(counter)
+	if _, err := io.Copy(io.MultiWriter(gz, diffIDs.Hash()), rc); err != nil {
+		return imgspecv1.Descriptor{}, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return imgspecv1.Descriptor{}, "", err
+	}
+	if err := f.Close(); err != nil {
+		return imgspecv1.Descriptor{}, "", err
+	}
+
+	d := digests.Digest()
+	if err := os.Rename(f.Name(), filepath.Join(blobs, d.Encoded())); err != nil {
+		return imgspecv1.Descriptor{}, "", err
+	}
+	return imgspecv1.Descriptor{
+		MediaType: imgspecv1.MediaTypeImageLayerGzip,
+		Digest:    d,
+		Size:      counter.n,
+	}, diffIDs.Digest(), nil
+}
+
+// imageConfig returns the configuration of the image, it's the one of
+// the base image with the labels, the exported variables of the
+// %environment section and the runscript of the container.
+func imageConfig(b *types.Bundle) (imgspecv1.Image, error) {
+	created := time.Now().UTC()
+	img := imgspecv1.Image{
+		Created:      &created,
+		Architecture: containerArch(b),
+		OS:           "linux",
+	}
+
+	if data, ok := b.JSONObjects[types.OCIConfigJSON]; ok {
+		if err := json.Unmarshal(data, &img.Config); err != nil {
+			return img, fmt.Errorf("while reading base image configuration: %v", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(b.RootfsPath, "/.singularity.d/labels.json"))
+	if err == nil {
+		labels := make(map[string]string)
+		if err := json.Unmarshal(data, &labels); err != nil {
+			return img, fmt.Errorf("while reading labels: %v", err)
+		}
+		if img.Config.Labels == nil {
+			img.Config.Labels = make(map[string]string)
+		}
+		for k, v := range labels {
+			img.Config.Labels[k] = v
+		}
+	} else if !os.IsNotExist(err) {
+		return img, fmt.Errorf("while reading labels: %v", err)
+	}
+
+	if !hasEnv(img.Config.Env, "PATH") {
+		img.Config.Env = append(img.Config.Env, "PATH="+env.DefaultPath)
+	}
+	if data, ok := b.JSONObjects[types.EnvironmentJSON]; ok {
+		var e types.Environment
+		if err := json.Unmarshal(data, &e); err != nil {
+			return img, fmt.Errorf("while reading environment: %v", err)
+		}
+		img.Config.Env = environment(img.Config.Env, e.Variables)
+	}
+
+	hasEntrypoint := len(img.Config.Entrypoint) > 0 || len(img.Config.Cmd) > 0
+	if b.Recipe.ImageData.Runscript.Script != "" || !hasEntrypoint {
+		if _, err := os.Stat(filepath.Join(b.RootfsPath, runscriptPath)); err == nil {
+			img.Config.Entrypoint = []string{runscriptPath}
+			img.Config.Cmd = nil
+		}
+	}
+
+	img.History = []imgspecv1.History{{
+		Created:   &created,
+		CreatedBy: "singularity build",
+	}}
+	return img, nil
+}
+
+// environment returns the environment list envs updated with the
+// variables of the %environment section, the values are expanded like
+// the container shell would, values which can't be expanded statically
+// are skipped.
+func environment(envs []string, variables []types.EnvironmentVariable) []string {
+	for _, v := range variables {
+		if v.Unset {
+			envs = unsetEnv(envs, v.Name)
+			continue
+		}
+		if !v.Exported {
+			continue
+		}
+		value, err := expandValue(envs, v.Value)
+		if err != nil {
+			sylog.Warningf("%%environment line %d: variable %s is not set in the image configuration: %v", v.Line, v.Name, err)
+			continue
+		}
+		envs = append(unsetEnv(envs, v.Name), v.Name+"="+value)
+	}
+	return envs
+}
+
+// expandValue expands the variable value in the POSIX shell syntax
+// with the environment envs.
+func expandValue(envs []string, value string) (string, error) {
+	f, err := syntax.NewParser().Parse(strings.NewReader("v="+value), "")
+	if err != nil {
+		return "", err
+	}
+	if len(f.Stmts) != 1 {
+		return "", fmt.Errorf("unexpected value %s", value)
+	}
+	call, ok := f.Stmts[0].Cmd.(*syntax.CallExpr)
+	if !ok || len(call.Assigns) != 1 || len(call.Args) > 0 {
+		return "", fmt.Errorf("unexpected value %s", value)
+	}
+	if call.Assigns[0].Value == nil {
+		return "", nil
+	}
+	return expand.Literal(&expand.Config{Env: expand.ListEnviron(envs...)}, call.Assigns[0].Value)
+}
+
+func hasEnv(envs []string, name string) bool {
+	for _, e := range envs {
+		if strings.HasPrefix(e, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+func unsetEnv(envs []string, name string) []string {
+	var kept []string
+	for _, e := range envs {
+		if !strings.HasPrefix(e, name+"=") {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+func idMap(m runtimespecs.LinuxIDMapping) idtools.IDMap {
+	return idtools.IDMap{
+		ContainerID: int(m.ContainerID),
+		HostID:      int(m.HostID),
+		Size:        int(m.Size),
+	}
+}
+
+// writeBlob writes the JSON encoding of v in the blobs directory.
+func writeBlob(blobs, mediaType string, v interface{}) (imgspecv1.Descriptor, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	d := digest.Canonical.FromBytes(data)
+	if err := ioutil.WriteFile(filepath.Join(blobs, d.Encoded()), data, 0644); err != nil {
+		return imgspecv1.Descriptor{}, err
+	}
+	return imgspecv1.Descriptor{
+		MediaType: mediaType,
+		Digest:    d,
+		Size:      int64(len(data)),
+	}, nil
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
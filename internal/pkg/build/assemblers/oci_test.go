@@ -0,0 +1,137 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package assemblers_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	dockerarchive "github.com/containers/image/v5/docker/archive"
+	ocilayout "github.com/containers/image/v5/oci/layout"
+	imagetypes "github.com/containers/image/v5/types"
+	"github.com/sylabs/singularity/internal/pkg/build/assemblers"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+// TestOCIAssembler checks the configuration of the OCI images assembled
+// from a local root filesystem.
+func TestOCIAssembler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-assembler-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := types.NewBundle(filepath.Join(dir, "rootfs"), dir)
+	if err != nil {
+		t.Fatalf("unable to make bundle: %v", err)
+	}
+	defer b.Remove()
+
+	files := map[string]string{
+		"/.singularity.d/labels.json": `{"org.label-schema.schema-version":"1.0","Maintainer":"me"}`,
+		"/.singularity.d/runscript":   "#!/bin/sh\necho hello\n",
+	}
+	for path, content := range files {
+		path = filepath.Join(b.RootfsPath, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b.Recipe.ImageData.Runscript.Script = "echo hello"
+	b.JSONObjects[types.OCIConfigJSON] = []byte(`{"Env":["PATH=/usr/bin:/bin","OLD=1"],"Cmd":["/bin/sh"],"Labels":{"base":"alpine"}}`)
+	env := types.Environment{
+		Shell: types.ShellSh,
+		Variables: []types.EnvironmentVariable{
+			{Name: "APP", Value: `"/opt/app"`, Exported: true, Line: 1},
+			{Name: "PATH", Value: `"$APP/bin:$PATH"`, Exported: true, Line: 2},
+			{Name: "LOCAL", Value: "1", Line: 3},
+			{Name: "OLD", Unset: true, Line: 4},
+			{Name: "DATE", Value: "$(date)", Exported: true, Line: 5},
+		},
+	}
+	if b.JSONObjects[types.EnvironmentJSON], err = json.Marshal(env); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedEnv := []string{"APP=/opt/app", "PATH=/opt/app/bin:/usr/bin:/bin"}
+	expectedLabels := map[string]string{
+		"base":                            "alpine",
+		"org.label-schema.schema-version": "1.0",
+		"Maintainer":                      "me",
+	}
+
+	tests := []struct {
+		name   string
+		format string
+		path   string
+		ref    string
+		src    string
+		parse  func(string) (imagetypes.ImageReference, error)
+	}{
+		{
+			name:   "oci",
+			format: assemblers.FormatOCI,
+			path:   filepath.Join(dir, "oci"),
+			ref:    "v1",
+			src:    filepath.Join(dir, "oci") + ":v1",
+			parse:  ocilayout.ParseReference,
+		},
+		{
+			name:   "docker-archive",
+			format: assemblers.FormatDockerArchive,
+			path:   filepath.Join(dir, "image.tar"),
+			ref:    "example/image:v1",
+			src:    filepath.Join(dir, "image.tar"),
+			parse:  dockerarchive.ParseReference,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &assemblers.OCIAssembler{Format: tt.format, Reference: tt.ref}
+			if err := a.Assemble(b, tt.path); err != nil {
+				t.Fatalf("failed to assemble %s image: %v", tt.format, err)
+			}
+
+			ref, err := tt.parse(tt.src)
+			if err != nil {
+				t.Fatalf("unable to parse reference: %v", err)
+			}
+			img, err := ref.NewImage(context.Background(), nil)
+			if err != nil {
+				t.Fatalf("unable to open image: %v", err)
+			}
+			defer img.Close()
+
+			config, err := img.OCIConfig(context.Background())
+			if err != nil {
+				t.Fatalf("unable to read image configuration: %v", err)
+			}
+			if !reflect.DeepEqual(config.Config.Env, expectedEnv) {
+				t.Errorf("unexpected environment %q instead of %q", config.Config.Env, expectedEnv)
+			}
+			if !reflect.DeepEqual(config.Config.Labels, expectedLabels) {
+				t.Errorf("unexpected labels %q instead of %q", config.Config.Labels, expectedLabels)
+			}
+			if !reflect.DeepEqual(config.Config.Entrypoint, []string{"/.singularity.d/runscript"}) || config.Config.Cmd != nil {
+				t.Errorf("unexpected entrypoint %q and command %q", config.Config.Entrypoint, config.Config.Cmd)
+			}
+			if config.OS != "linux" || len(config.RootFS.DiffIDs) != 1 {
+				t.Errorf("unexpected image configuration %+v", config)
+			}
+		})
+	}
+}
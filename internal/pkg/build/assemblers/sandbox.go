@@ -33,7 +33,13 @@ func (a *SandboxAssembler) Assemble(b *types.Bundle, path string) (err error) {
 		var stderr bytes.Buffer
 		cmd := exec.Command("cp", "-r", b.RootfsPath+`/.`, path)
 		cmd.Stderr = &stderr
-		if err := cmd.Run(); err != nil {
+		run := cmd.Run
+		if b.UserNamespace != nil {
+			// preserve the subordinate IDs owning the files
+			cmd.Args = []string{"cp", "-a", b.RootfsPath + `/.`, path}
+			run = func() error { return b.UserNamespace.Run(cmd) }
+		}
+		if err := run(); err != nil {
 			return fmt.Errorf("cp Failed: %v: %v", err, stderr.String())
 		}
 	} else {
@@ -6,38 +6,63 @@
 package assemblers
 
 import (
+	"archive/tar"
+	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
+	"strings"
 	"syscall"
 
 	uuid "github.com/satori/go.uuid"
 	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/build/events"
+	"github.com/sylabs/singularity/internal/pkg/build/oci"
 	"github.com/sylabs/singularity/internal/pkg/util/machine"
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/image/packer"
 	"github.com/sylabs/singularity/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/util/crypt"
+	"github.com/sylabs/singularity/pkg/util/verity"
+)
+
+// Root filesystem formats of the SIFAssembler.
+const (
+	// RootfsSquashfs is a squashfs root filesystem, the default.
+	RootfsSquashfs = "squashfs"
+	// RootfsErofs is an erofs root filesystem, stored as a raw
+	// partition as SIF has no erofs filesystem type.
+	RootfsErofs = "erofs"
 )
 
 // SIFAssembler doesn't store anything.
 type SIFAssembler struct {
-	GzipFlag        bool
-	MksquashfsProcs uint
-	MksquashfsMem   string
-	MksquashfsPath  string
+	// RootfsFormat is the root filesystem format, RootfsSquashfs
+	// if empty.
+	RootfsFormat string
+	// CompressionFlags are the mksquashfs or mkfs.erofs flags
+	// selecting the compression algorithm and level if any.
+	CompressionFlags []string
+	MksquashfsProcs  uint
+	MksquashfsMem    string
+	MksquashfsPath   string
+	MkfsErofsPath    string
 }
 
 type encryptionOptions struct {
-	keyInfo   crypt.KeyInfo
+	providers []crypt.KeyProvider
 	plaintext []byte
 }
 
-func createSIF(path string, definition, ociConf []byte, squashfile string, encOpts *encryptionOptions, arch string) (err error) {
+func createSIF(path string, definition []byte, jsonObjects, genericObjects map[string][]byte, squashfile string, sifType sif.Fstype, encOpts *encryptionOptions, arch string) (err error) {
 	// general info for the new SIF file creation
 	cinfo := sif.CreateInfo{
 		Pathname:   path,
@@ -58,19 +83,52 @@ func createSIF(path string, definition, ociConf []byte, squashfile string, encOp
 	// add this descriptor input element to creation descriptor slice
 	cinfo.InputDescr = append(cinfo.InputDescr, definput)
 
-	if len(ociConf) > 0 {
-		// data we need to create a definition file descriptor
-		ociInput := sif.DescriptorInput{
+	// JSON objects are added in a stable order (eg: oci-config.json)
+	names := make([]string, 0, len(jsonObjects))
+	for name := range jsonObjects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if len(jsonObjects[name]) == 0 {
+			continue
+		}
+		// data we need to create a generic JSON descriptor
+		jsonInput := sif.DescriptorInput{
 			Datatype: sif.DataGenericJSON,
 			Groupid:  sif.DescrDefaultGroup,
 			Link:     sif.DescrUnusedLink,
-			Data:     ociConf,
-			Fname:    "oci-config.json",
+			Data:     jsonObjects[name],
+			Fname:    name + ".json",
 		}
-		ociInput.Size = int64(binary.Size(ociInput.Data))
+		jsonInput.Size = int64(binary.Size(jsonInput.Data))
 
 		// add this descriptor input element to creation descriptor slice
-		cinfo.InputDescr = append(cinfo.InputDescr, ociInput)
+		cinfo.InputDescr = append(cinfo.InputDescr, jsonInput)
+	}
+
+	// generic objects are added in a stable order too (eg: build.log)
+	names = names[:0]
+	for name := range genericObjects {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if len(genericObjects[name]) == 0 {
+			continue
+		}
+		genericInput := sif.DescriptorInput{
+			Datatype: sif.DataGeneric,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     sif.DescrUnusedLink,
+			Data:     genericObjects[name],
+			Fname:    name,
+		}
+		genericInput.Size = int64(binary.Size(genericInput.Data))
+
+		cinfo.InputDescr = append(cinfo.InputDescr, genericInput)
 	}
 
 	// data we need to create a system partition descriptor
@@ -96,12 +154,6 @@ func createSIF(path string, definition, ociConf []byte, squashfile string, encOp
 	parinput.Fp = fp
 	parinput.Size = fi.Size()
 
-	sifType := sif.FsSquash
-
-	if encOpts != nil {
-		sifType = sif.FsEncryptedSquashfs
-	}
-
 	err = parinput.SetPartExtra(sifType, sif.PartPrimSys, sif.GetSIFArch(arch))
 	if err != nil {
 		return
@@ -111,13 +163,16 @@ func createSIF(path string, definition, ociConf []byte, squashfile string, encOp
 	cinfo.InputDescr = append(cinfo.InputDescr, parinput)
 
 	if encOpts != nil {
-		data, err := crypt.EncryptKey(encOpts.keyInfo, encOpts.plaintext)
-		if err != nil {
-			return fmt.Errorf("while encrypting filesystem key: %s", err)
-		}
+		syspartID := uint32(len(cinfo.InputDescr))
+		for _, p := range encOpts.providers {
+			data, message, err := p.WrapKey(encOpts.plaintext)
+			if err != nil {
+				return fmt.Errorf("while encrypting filesystem key for %s: %s", p, err)
+			}
+			if data == nil {
+				continue
+			}
 
-		if data != nil {
-			syspartID := uint32(len(cinfo.InputDescr))
 			part := sif.DescriptorInput{
 				Datatype: sif.DataCryptoMessage,
 				Groupid:  sif.DescrDefaultGroup,
@@ -127,7 +182,7 @@ func createSIF(path string, definition, ociConf []byte, squashfile string, encOp
 			}
 
 			// extra data needed for the creation of a signature descriptor
-			err := part.SetCryptoMsgExtra(sif.FormatPEM, sif.MessageRSAOAEP)
+			err = part.SetCryptoMsgExtra(sif.FormatPEM, message)
 			if err != nil {
 				return err
 			}
@@ -154,12 +209,43 @@ func createSIF(path string, definition, ociConf []byte, squashfile string, encOp
 	return nil
 }
 
+// containerArch returns the architecture of the container binaries.
+func containerArch(b *types.Bundle) string {
+	var arch string
+	if b.RootfsLayers != nil {
+		arch = b.RootfsLayers.Arch()
+	} else {
+		arch = machine.ArchFromContainer(b.RootfsPath)
+	}
+	if b.Opts.Platform != "" {
+		// the requested platform prevails, the container may also
+		// hold the binaries of the emulator
+		if p, err := machine.ParsePlatform(b.Opts.Platform); err == nil {
+			arch = p.Arch
+		}
+	}
+	if arch == "" {
+		sylog.Infof("Architecture not recognized, use native")
+		arch = runtime.GOARCH
+	}
+	return arch
+}
+
 // Assemble creates a SIF image from a Bundle.
 func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 	sylog.Infof("Creating SIF file...")
 
+	if a.RootfsFormat == RootfsErofs {
+		return a.assembleErofs(b, path)
+	}
+
 	s := packer.NewSquashfs()
 	s.MksquashfsPath = a.MksquashfsPath
+	if b.Opts.Events != nil {
+		s.Progress = func(percent int) {
+			b.Opts.Events.Emit(events.Event{Type: events.SquashfsProgress, Percent: percent})
+		}
+	}
 
 	f, err := ioutil.TempFile(b.TmpDir, "squashfs-")
 	if err != nil {
@@ -171,35 +257,49 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 	defer os.Remove(fsPath)
 
 	flags := []string{"-noappend"}
-	// build squashfs with all-root flag when building as a user
-	if syscall.Getuid() != 0 {
+	// the ownership of a root filesystem unpacked in a user namespace
+	// is preserved by running mksquashfs in the namespace, the layers
+	// streamed as tar archives keep their ownership
+	if b.UserNamespace != nil {
+		s.Run = b.UserNamespace.Run
+	} else if syscall.Getuid() != 0 && b.RootfsLayers == nil {
+		// build squashfs with all-root flag when building as a user
 		flags = append(flags, "-all-root")
 	}
 	// specify compression if needed
-	if a.GzipFlag {
-		flags = append(flags, "-comp", "gzip")
-	}
+	flags = append(flags, a.CompressionFlags...)
 	if a.MksquashfsMem != "" {
 		flags = append(flags, "-mem", a.MksquashfsMem)
 	}
 	if a.MksquashfsProcs != 0 {
 		flags = append(flags, "-processors", fmt.Sprint(a.MksquashfsProcs))
 	}
-	arch := machine.ArchFromContainer(b.RootfsPath)
-	if arch == "" {
-		sylog.Infof("Architecture not recognized, use native")
-		arch = runtime.GOARCH
-	}
+	arch := containerArch(b)
 	sylog.Verbosef("Set SIF container architecture to %s", arch)
 
-	if err := s.Create([]string{b.RootfsPath}, fsPath, flags); err != nil {
+	if b.RootfsLayers != nil {
+		err = createFromLayers(s, b, fsPath, flags)
+	} else {
+		err = s.Create([]string{b.RootfsPath}, fsPath, flags)
+	}
+	if err != nil {
 		return fmt.Errorf("while creating squashfs: %v", err)
 	}
 
+	sifType := sif.FsSquash
 	var encOpts *encryptionOptions
 
-	if b.Opts.EncryptionKeyInfo != nil {
-		plaintext, err := crypt.NewPlaintextKey(*b.Opts.EncryptionKeyInfo)
+	if b.Opts.Encrypted() {
+		providers := b.Opts.EncryptionKeys
+		if b.Opts.EncryptionKeyInfo != nil {
+			p, err := crypt.KeyInfoProvider(*b.Opts.EncryptionKeyInfo)
+			if err != nil {
+				return fmt.Errorf("unable to obtain encryption key: %+v", err)
+			}
+			providers = append([]crypt.KeyProvider{p}, providers...)
+		}
+
+		plaintext, slots, err := crypt.VolumeKey(providers)
 		if err != nil {
 			return fmt.Errorf("unable to obtain encryption key: %+v", err)
 		}
@@ -211,7 +311,7 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 		// Detach the following code from the squashfs creation. SIF can be
 		// created first and encrypted after. This gives the flexibility to
 		// encrypt an existing SIF
-		loopPath, err := cryptDev.EncryptFilesystem(fsPath, plaintext)
+		loopPath, err := cryptDev.EncryptFilesystem(fsPath, plaintext, slots...)
 		if err != nil {
 			return fmt.Errorf("unable to encrypt filesystem at %s: %+v", fsPath, err)
 		}
@@ -219,21 +319,179 @@ func (a *SIFAssembler) Assemble(b *types.Bundle, path string) error {
 
 		fsPath = loopPath
 
+		sifType = sif.FsEncryptedSquashfs
 		encOpts = &encryptionOptions{
-			keyInfo:   *b.Opts.EncryptionKeyInfo,
+			providers: providers,
 			plaintext: plaintext,
 		}
-
 	}
 
-	err = createSIF(path, b.Recipe.Raw, b.JSONObjects[types.OCIConfigJSON], fsPath, encOpts, arch)
+	err = createSIF(path, b.Recipe.Raw, b.JSONObjects, b.GenericObjects, fsPath, sifType, encOpts, arch)
 	if err != nil {
 		return fmt.Errorf("while creating SIF: %v", err)
 	}
 
+	if b.Opts.Verity {
+		if err := addVerity(path); err != nil {
+			return fmt.Errorf("while adding dm-verity hash tree: %v", err)
+		}
+	}
+
 	return nil
 }
 
+// assembleErofs creates a SIF image with an erofs root filesystem from
+// a Bundle, the root filesystem layers must be unpacked in the bundle.
+func (a *SIFAssembler) assembleErofs(b *types.Bundle, path string) error {
+	if b.RootfsLayers != nil {
+		return fmt.Errorf("erofs root filesystems can't be created from streamed layers")
+	}
+
+	e := packer.NewErofs()
+	e.MkfsErofsPath = a.MkfsErofsPath
+
+	f, err := ioutil.TempFile(b.TmpDir, "erofs-")
+	if err != nil {
+		return fmt.Errorf("while creating temporary file for erofs: %v", err)
+	}
+
+	fsPath := f.Name()
+	f.Close()
+	defer os.Remove(fsPath)
+
+	flags := append([]string{}, a.CompressionFlags...)
+	// as with mksquashfs the ownership is preserved by running
+	// mkfs.erofs in the user namespace, or reset to root
+	if b.UserNamespace != nil {
+		e.Run = b.UserNamespace.Run
+	} else if syscall.Getuid() != 0 {
+		flags = append(flags, "--all-root")
+	}
+	arch := containerArch(b)
+	sylog.Verbosef("Set SIF container architecture to %s", arch)
+
+	if err := e.Create(b.RootfsPath, fsPath, flags); err != nil {
+		return fmt.Errorf("while creating erofs: %v", err)
+	}
+
+	err = createSIF(path, b.Recipe.Raw, b.JSONObjects, b.GenericObjects, fsPath, sif.FsRaw, nil, arch)
+	if err != nil {
+		return fmt.Errorf("while creating SIF: %v", err)
+	}
+	return nil
+}
+
+// createFromLayers creates the squashfs filesystem fsPath from the image
+// layers of the bundle merged on the fly. The metadata of the bundle root
+// filesystem overrides the layers, its other files are only added if
+// missing (eg: /proc, /sys, /etc/resolv.conf).
+func createFromLayers(s *packer.Squashfs, b *types.Bundle, fsPath string, flags []string) error {
+	metadata, base, err := rootfsArchives(b.RootfsPath)
+	if err != nil {
+		return fmt.Errorf("while archiving %s: %v", b.RootfsPath, err)
+	}
+
+	n := b.RootfsLayers.Len()
+	open := func(ctx context.Context, i int) (io.ReadCloser, error) {
+		switch i {
+		case 0:
+			return ioutil.NopCloser(bytes.NewReader(base)), nil
+		case n + 1:
+			return ioutil.NopCloser(bytes.NewReader(metadata)), nil
+		}
+		return b.RootfsLayers.Open(ctx, i-1)
+	}
+
+	pr, pw := io.Pipe()
+	merged := make(chan error, 1)
+	go func() {
+		err := oci.MergeLayers(context.TODO(), pw, n+2, open)
+		pw.CloseWithError(err)
+		merged <- err
+	}()
+
+	err = s.CreateFromTar(pr, fsPath, flags)
+	// unblocks the merge if mksquashfs exited early
+	pr.CloseWithError(io.ErrClosedPipe)
+	if mergeErr := <-merged; mergeErr != nil && mergeErr != io.ErrClosedPipe {
+		return fmt.Errorf("while merging image layers: %v", mergeErr)
+	}
+	return err
+}
+
+// rootfsArchives returns the tar archives of the metadata of the root
+// filesystem rootfs (ie: the .singularity.d files and the links to them)
+// and of its other files, owned by root.
+func rootfsArchives(rootfs string) ([]byte, []byte, error) {
+	var metadata, base bytes.Buffer
+	mw := tar.NewWriter(&metadata)
+	bw := tar.NewWriter(&base)
+
+	err := filepath.Walk(rootfs, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || path == rootfs {
+			return err
+		}
+		name, err := filepath.Rel(rootfs, path)
+		if err != nil {
+			return err
+		}
+
+		var link string
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		hdr.Uid, hdr.Gid = 0, 0
+		hdr.Uname, hdr.Gname = "", ""
+
+		w := bw
+		if strings.HasPrefix(name, ".singularity.d/") || (!fi.IsDir() && !strings.Contains(name, "/")) {
+			w = mw
+		}
+		if err := w.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(w, f)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, nil, err
+	}
+	if err := bw.Close(); err != nil {
+		return nil, nil, err
+	}
+	return metadata.Bytes(), base.Bytes(), nil
+}
+
+// addVerity adds the dm-verity hash tree of the root filesystem partition
+// to the SIF image at path.
+func addVerity(path string) error {
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return err
+	}
+	defer fimg.UnloadContainer()
+
+	return verity.AddToSIF(&fimg)
+}
+
 // changeOwner check the command being called with sudo with the environment
 // variable SUDO_COMMAND. Pattern match that for the singularity bin.
 func changeOwner() (int, int, bool) {
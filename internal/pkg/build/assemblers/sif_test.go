@@ -17,6 +17,7 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/cache"
 	testCache "github.com/sylabs/singularity/internal/pkg/test/tool/cache"
 	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/image/packer"
 	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
 )
 
@@ -40,6 +41,24 @@ func TestSIFAssemblerDocker(t *testing.T) {
 		t.Fatalf("could not find mksquashfs: %v", err)
 	}
 
+	assembleDocker(t, mksquashfsPath, false)
+}
+
+// TestSIFAssemblerDockerStream sees if we can build a SIF image from the
+// layers of an image from a Docker registry streamed to mksquashfs
+func TestSIFAssemblerDockerStream(t *testing.T) {
+	s := packer.NewSquashfs()
+	if !s.HasMksquashfs() {
+		t.Fatalf("could not find mksquashfs")
+	}
+	if !s.HasTarSupport() {
+		t.Skipf("%s doesn't read tar archives", s.MksquashfsPath)
+	}
+
+	assembleDocker(t, s.MksquashfsPath, true)
+}
+
+func assembleDocker(t *testing.T, mksquashfsPath string, stream bool) {
 	b, err := types.NewBundle(filepath.Join(os.TempDir(), "sbuild-SIFAssembler"), os.TempDir())
 	if err != nil {
 		t.Fatalf("unable to make bundle: %v", err)
@@ -59,6 +78,7 @@ func TestSIFAssemblerDocker(t *testing.T) {
 		t.Fatalf("failed to create an image cache handle: %s", err)
 	}
 	b.Opts.ImgCache = imgCache
+	b.Opts.StreamLayers = stream
 
 	ocp := &sources.OCIConveyorPacker{}
 
@@ -70,6 +90,9 @@ func TestSIFAssemblerDocker(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to Pack from %s: %v\n", assemblerDockerURI, err)
 	}
+	if stream && b.RootfsLayers == nil {
+		t.Fatalf("layers of %s not streamed", assemblerDockerURI)
+	}
 
 	a := &assemblers.SIFAssembler{
 		MksquashfsPath: mksquashfsPath,
@@ -0,0 +1,161 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ParseMounts converts the --mount specifications of the form
+// type=bind,source=<src>[,destination=<dst>][,readonly] to the
+// src:dst[:ro] bind path specifications used by --bind. The command
+// line splits values on commas, each type field starts a new mount.
+func ParseMounts(values []string) ([]string, error) {
+	var specs [][]string
+
+	for _, field := range values {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if strings.HasPrefix(strings.ToLower(field), "type=") {
+			specs = append(specs, nil)
+		} else if len(specs) == 0 {
+			return nil, fmt.Errorf("mount specification %q must start with the type field", field)
+		}
+		specs[len(specs)-1] = append(specs[len(specs)-1], field)
+	}
+
+	binds := make([]string, 0, len(specs))
+	for _, fields := range specs {
+		bind, err := parseMount(fields)
+		if err != nil {
+			return nil, err
+		}
+		binds = append(binds, bind)
+	}
+	return binds, nil
+}
+
+// parseMount returns the bind path specification of the fields
+// of a mount specification.
+func parseMount(fields []string) (string, error) {
+	var src, dst string
+	ro := false
+
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		key := strings.ToLower(kv[0])
+		value := ""
+		if len(kv) == 2 {
+			value = kv[1]
+		}
+
+		switch key {
+		case "type":
+			if value != "bind" {
+				return "", fmt.Errorf("unsupported mount type %q, only bind is supported", value)
+			}
+		case "source", "src":
+			src = value
+		case "destination", "dst", "target":
+			dst = value
+		case "readonly", "ro":
+			if value != "" && value != "true" && value != "false" {
+				return "", fmt.Errorf("invalid readonly value %q", value)
+			}
+			ro = value != "false"
+		default:
+			return "", fmt.Errorf("unknown mount option %q", key)
+		}
+	}
+
+	spec := strings.Join(fields, ",")
+	if src == "" {
+		return "", fmt.Errorf("mount specification %q has no source", spec)
+	}
+	if strings.Contains(src+dst, ":") {
+		return "", fmt.Errorf("mount specification %q source and destination can't contain ':'", spec)
+	}
+	if dst == "" {
+		dst = src
+	}
+
+	bind := src + ":" + dst
+	if ro {
+		bind += ":ro"
+	}
+	return bind, nil
+}
+
+// createBindPoints creates the destinations of binds missing in the
+// container root filesystem, it returns a function removing them so
+// that bind points aren't left in the image.
+func createBindPoints(rootfs string, binds []string) (func(), error) {
+	var created []string
+
+	cleanup := func() {
+		// remove the created paths in reverse order, non empty
+		// directories are kept
+		for i := len(created) - 1; i >= 0; i-- {
+			os.Remove(created[i])
+		}
+	}
+
+	for _, bind := range binds {
+		split := strings.SplitN(bind, ":", 3)
+		src := split[0]
+		dst := src
+		if len(split) > 1 {
+			dst = split[1]
+		}
+
+		fi, err := os.Stat(src)
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("while getting bind source %s: %v", src, err)
+		}
+
+		// record the missing directories to remove, destinations through
+		// symbolic links are left to the engine as they could point to
+		// the host filesystem here
+		path := filepath.Join(rootfs, dst)
+		var missing []string
+		p := rootfs
+		for _, elem := range strings.Split(strings.Trim(filepath.Clean(dst), "/"), "/") {
+			p = filepath.Join(p, elem)
+			if len(missing) > 0 {
+				missing = append(missing, p)
+			} else if fi, err := os.Lstat(p); os.IsNotExist(err) {
+				missing = append(missing, p)
+			} else if err != nil || fi.Mode()&os.ModeSymlink != 0 {
+				break
+			}
+		}
+		if len(missing) == 0 || missing[len(missing)-1] != path {
+			continue
+		}
+
+		if fi.IsDir() {
+			err = os.MkdirAll(path, 0755)
+		} else if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			var f *os.File
+			if f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+				f.Close()
+			}
+		}
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("while creating bind point %s: %v", dst, err)
+		}
+		created = append(created, missing...)
+	}
+
+	return cleanup, nil
+}
@@ -0,0 +1,98 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMounts(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []string
+		fail     bool
+	}{
+		{"Empty", "", []string{}, false},
+		{"Source", "type=bind,source=/data", []string{"/data:/data"}, false},
+		{"Destination", "type=bind,src=/data,dst=/mnt", []string{"/data:/mnt"}, false},
+		{"Readonly", "type=bind,source=/data,target=/mnt,readonly", []string{"/data:/mnt:ro"}, false},
+		{"ReadonlyFalse", "type=bind,source=/data,readonly=false", []string{"/data:/data"}, false},
+		{"Multiple", "type=bind,source=/a,type=bind,source=/b,ro", []string{"/a:/a", "/b:/b:ro"}, false},
+		{"NoType", "source=/data", nil, true},
+		{"NoSource", "type=bind,destination=/mnt", nil, true},
+		{"Volume", "type=volume,source=data", nil, true},
+		{"UnknownOption", "type=bind,source=/data,propagation=shared", nil, true},
+		{"Colon", "type=bind,source=/a:b", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binds, err := ParseMounts(strings.Split(tt.value, ","))
+			if tt.fail {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !reflect.DeepEqual(binds, tt.expected) {
+				t.Errorf("unexpected binds %v, expected %v", binds, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCreateBindPoints(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "build-binds-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	rootfs := filepath.Join(tmp, "rootfs")
+	src := filepath.Join(tmp, "src")
+	file := filepath.Join(tmp, "file")
+
+	for _, d := range []string{filepath.Join(rootfs, "opt"), src} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup, err := createBindPoints(rootfs, []string{src + ":/opt/data/mirror", file + ":/etc/license:ro", src + ":/opt"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if fi, err := os.Stat(filepath.Join(rootfs, "/opt/data/mirror")); err != nil || !fi.IsDir() {
+		t.Errorf("directory bind point not created: %v", err)
+	}
+	if fi, err := os.Stat(filepath.Join(rootfs, "/etc/license")); err != nil || !fi.Mode().IsRegular() {
+		t.Errorf("file bind point not created: %v", err)
+	}
+
+	cleanup()
+
+	for _, p := range []string{"/opt/data", "/etc"} {
+		if _, err := os.Stat(filepath.Join(rootfs, p)); !os.IsNotExist(err) {
+			t.Errorf("bind point %s not removed", p)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(rootfs, "/opt")); err != nil {
+		t.Errorf("existing directory /opt removed")
+	}
+}
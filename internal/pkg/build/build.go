@@ -13,7 +13,9 @@ import (
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
 	"syscall"
+	"time"
 
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
 	"github.com/sylabs/singularity/pkg/util/fs/proc"
@@ -22,7 +24,9 @@ import (
 	uuid "github.com/satori/go.uuid"
 	"github.com/sylabs/singularity/internal/pkg/build/apps"
 	"github.com/sylabs/singularity/internal/pkg/build/assemblers"
+	"github.com/sylabs/singularity/internal/pkg/build/events"
 	"github.com/sylabs/singularity/internal/pkg/build/sources"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/erofs"
 	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
 	"github.com/sylabs/singularity/internal/pkg/util/uri"
 	"github.com/sylabs/singularity/pkg/build/types"
@@ -32,6 +36,9 @@ import (
 	"github.com/sylabs/singularity/pkg/sylog"
 )
 
+// buildLog is the sub logger used by the build process.
+var buildLog = sylog.NewSubLogger("build")
+
 // Build is an abstracted way to look at the entire build process.
 // For example calling NewBuild() will return this object.
 // From there we can call Full() on this build object, which will:
@@ -51,6 +58,9 @@ type Config struct {
 	Dest string
 	// Format is the format of built container, e.g. SIF, sandbox.
 	Format string
+	// Reference is the tag of an OCI layout image or the name:tag
+	// of a docker archive image, if any.
+	Reference string
 	// NoCleanUp allows a user to prevent a bundle from being cleaned
 	// up after a failed build, useful for debugging.
 	NoCleanUp bool
@@ -84,10 +94,51 @@ func newBuild(defs []types.Definition, conf Config) (*Build, error) {
 	}
 	conf.Dest = dest
 
-	// always build a sandbox if updating an existing sandbox
-	if conf.Opts.Update {
+	if conf.Format == assemblers.FormatOCI || conf.Format == assemblers.FormatDockerArchive {
+		if conf.Opts.Update {
+			return nil, fmt.Errorf("%s images can't be updated", conf.Format)
+		}
+		if conf.Opts.Encrypted() {
+			return nil, fmt.Errorf("only SIF images can be encrypted")
+		}
+	}
+	// always build a sandbox if updating an existing sandbox, an
+	// updated SIF image replaces the existing one
+	if conf.Opts.Update && fs.IsDir(conf.Dest) {
 		conf.Format = "sandbox"
 	}
+	if conf.Opts.SignEntity != nil && conf.Format != "sif" {
+		return nil, fmt.Errorf("only SIF images can be signed")
+	}
+	if conf.Opts.Verity {
+		if conf.Format != "sif" {
+			return nil, fmt.Errorf("only SIF images can be protected with dm-verity")
+		}
+		if conf.Opts.Encrypted() {
+			return nil, fmt.Errorf("encrypted images can't be protected with dm-verity")
+		}
+	}
+	switch conf.Opts.RootfsFormat {
+	case "", assemblers.RootfsSquashfs:
+	case assemblers.RootfsErofs:
+		if conf.Format != "sif" {
+			return nil, fmt.Errorf("only SIF images can have an erofs root filesystem")
+		}
+		if conf.Opts.Encrypted() {
+			return nil, fmt.Errorf("images with an erofs root filesystem can't be encrypted")
+		}
+		if conf.Opts.Verity {
+			return nil, fmt.Errorf("images with an erofs root filesystem can't be protected with dm-verity")
+		}
+		// mkfs.erofs reads the unpacked root filesystem only
+		conf.Opts.StreamLayers = false
+	default:
+		return nil, fmt.Errorf("unsupported root filesystem format %q, supported values are squashfs and erofs", conf.Opts.RootfsFormat)
+	}
+
+	if conf.Opts.Downloads <= 0 {
+		conf.Opts.Downloads = types.DefaultDownloads
+	}
 
 	b := &Build{
 		Conf: conf,
@@ -109,6 +160,14 @@ func newBuild(defs []types.Definition, conf Config) (*Build, error) {
 			return nil, fmt.Errorf("multiple stages detected, all must have headers")
 		}
 
+		// report %environment errors before building anything
+		if _, err := types.ParseEnvironment(d.ImageData.Environment); err != nil {
+			return nil, fmt.Errorf("invalid %%environment section: %v", err)
+		}
+		if _, err := types.AppInstallOrder(d); err != nil {
+			return nil, fmt.Errorf("invalid %%appdepends section: %v", err)
+		}
+
 		rootfsParent := conf.Opts.TmpDir
 		if conf.Format == "sandbox" {
 			rootfsParent = filepath.Dir(conf.Dest)
@@ -117,7 +176,7 @@ func newBuild(defs []types.Definition, conf Config) (*Build, error) {
 
 		var s stage
 		var err error
-		if conf.Opts.EncryptionKeyInfo != nil {
+		if conf.Opts.Encrypted() {
 			s.b, err = types.NewEncryptedBundle(rootfs, conf.Opts.TmpDir, conf.Opts.EncryptionKeyInfo)
 		} else {
 			s.b, err = types.NewBundle(rootfs, conf.Opts.TmpDir)
@@ -136,7 +195,7 @@ func newBuild(defs []types.Definition, conf Config) (*Build, error) {
 			// provided
 			if s.b.RootfsPath != rootfs {
 				sandboxCopy = true
-				sylog.Warningf("The underlying filesystem on which resides %q won't allow to set ownership, "+
+				buildLog.Warningf("The underlying filesystem on which resides %q won't allow to set ownership, "+
 					"as a consequence the sandbox could not preserve image's files/directories ownerships", conf.Dest)
 			} else {
 				// check if the final sandbox directory doesn't have noexec set
@@ -160,7 +219,7 @@ func newBuild(defs []types.Definition, conf Config) (*Build, error) {
 			for _, opt := range tmpdirEntry.Options {
 				switch opt {
 				case "nodev":
-					sylog.Warningf("'nodev' mount option set on %s, it could be a source of failure during build process", tmpdirEntry.Point)
+					buildLog.Warningf("'nodev' mount option set on %s, it could be a source of failure during build process", tmpdirEntry.Point)
 				case "noexec":
 					return nil, fmt.Errorf("'noexec' mount option set on %s, temporary root filesystem won't be usable at this location", tmpdirEntry.Point)
 				}
@@ -185,12 +244,42 @@ func newBuild(defs []types.Definition, conf Config) (*Build, error) {
 	case "sandbox":
 		b.stages[lastStageIndex].a = &assemblers.SandboxAssembler{Copy: sandboxCopy}
 	case "sif":
+		if conf.Opts.RootfsFormat == assemblers.RootfsErofs {
+			mkfsErofsPath, err := erofs.GetPath()
+			if err != nil {
+				return nil, err
+			}
+			comp, level, err := squashfsCompression(conf.Opts, b.stages[lastStageIndex].b.Recipe.Header)
+			if err != nil {
+				return nil, err
+			}
+			compFlags, err := erofs.CompressionFlags(comp, level)
+			if err != nil {
+				return nil, err
+			}
+			b.stages[lastStageIndex].a = &assemblers.SIFAssembler{
+				RootfsFormat:     assemblers.RootfsErofs,
+				CompressionFlags: compFlags,
+				MkfsErofsPath:    mkfsErofsPath,
+			}
+			break
+		}
 		mksquashfsPath, err := squashfs.GetPath()
 		if err != nil {
 			return nil, fmt.Errorf("while searching for mksquashfs: %v", err)
 		}
 
-		flag, err := ensureGzipComp(b.stages[lastStageIndex].b.TmpDir, mksquashfsPath)
+		lastStage := b.stages[lastStageIndex]
+		comp, level, err := squashfsCompression(conf.Opts, lastStage.b.Recipe.Header)
+		if err != nil {
+			return nil, err
+		}
+		var compFlags []string
+		if comp == "" {
+			compFlags, err = ensureGzipComp(lastStage.b.TmpDir, mksquashfsPath)
+		} else {
+			compFlags, err = ensureComp(lastStage.b.TmpDir, mksquashfsPath, comp, level)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("while ensuring correct compression algorithm: %v", err)
 		}
@@ -203,10 +292,15 @@ func newBuild(defs []types.Definition, conf Config) (*Build, error) {
 			return nil, fmt.Errorf("while searching for mksquashfs mem limits: %v", err)
 		}
 		b.stages[lastStageIndex].a = &assemblers.SIFAssembler{
-			GzipFlag:        flag,
-			MksquashfsProcs: mksquashfsProcs,
-			MksquashfsMem:   mksquashfsMem,
-			MksquashfsPath:  mksquashfsPath,
+			CompressionFlags: compFlags,
+			MksquashfsProcs:  mksquashfsProcs,
+			MksquashfsMem:    mksquashfsMem,
+			MksquashfsPath:   mksquashfsPath,
+		}
+	case assemblers.FormatOCI, assemblers.FormatDockerArchive:
+		b.stages[lastStageIndex].a = &assemblers.OCIAssembler{
+			Format:    conf.Format,
+			Reference: conf.Reference,
 		}
 	default:
 		return nil, fmt.Errorf("unrecognized output format %s", conf.Format)
@@ -215,40 +309,79 @@ func newBuild(defs []types.Definition, conf Config) (*Build, error) {
 	return b, nil
 }
 
-// ensureGzipComp builds dummy squashfs images and checks the type of compression used
-// to deduce if we can successfully build with gzip compression. It returns an error
-// if we cannot and a boolean to indicate if the `-comp` flag is needed to specify
-// gzip compression when the final squashfs is built
-func ensureGzipComp(tmpdir, mksquashfsPath string) (bool, error) {
-	sylog.Debugf("Ensuring gzip compression for mksquashfs")
+// squashfsCompression returns the squashfs compression algorithm and level
+// of the root filesystem requested by the build options or by the definition
+// file header, options take precedence. Both are a list of compressions per
+// partition parsed by squashfs.ParseCompressions, builds only create the
+// root filesystem partition. An empty algorithm selects the default gzip
+// compression.
+func squashfsCompression(opts types.Options, header map[string]string) (string, int, error) {
+	spec := opts.Compression
+	if spec == "" {
+		spec = header["compression"]
+	}
+	comps, err := squashfs.ParseCompressions(spec)
+	if err != nil {
+		return "", 0, err
+	}
+	for part := range comps {
+		if part != squashfs.AllPartitions && part != squashfs.RootfsPartition {
+			return "", 0, fmt.Errorf("builds only create the rootfs partition, partition %s is recompressed with 'singularity sif compact --compression'", part)
+		}
+	}
+	c, ok := comps[squashfs.RootfsPartition]
+	if !ok {
+		c = comps[squashfs.AllPartitions]
+	}
 
-	var err error
+	comp, level := c.Algorithm, c.Level
+	if level == 0 {
+		level = opts.CompressionLevel
+	}
+	if level == 0 && header["compressionlevel"] != "" {
+		l, err := strconv.Atoi(header["compressionlevel"])
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid CompressionLevel header %q: %v", header["compressionlevel"], err)
+		}
+		level = l
+	}
+	if comp == "" && level != 0 {
+		comp = "gzip"
+	}
+	return comp, level, nil
+}
+
+// testSquashfsComp builds a dummy squashfs image with the provided
+// mksquashfs flags and returns the compression algorithm used.
+func testSquashfsComp(tmpdir, mksquashfsPath string, compFlags []string) (string, error) {
 	s := packer.NewSquashfs()
 	s.MksquashfsPath = mksquashfsPath
 
-	srcf, err := ioutil.TempFile(tmpdir, "squashfs-gzip-comp-test-src")
+	srcf, err := ioutil.TempFile(tmpdir, "squashfs-comp-test-src")
 	if err != nil {
-		return false, fmt.Errorf("while creating temporary file for squashfs source: %v", err)
+		return "", fmt.Errorf("while creating temporary file for squashfs source: %v", err)
 	}
+	defer os.Remove(srcf.Name())
 
 	srcf.Write([]byte("Test File Content"))
 	srcf.Close()
 
-	f, err := ioutil.TempFile(tmpdir, "squashfs-gzip-comp-test-")
+	f, err := ioutil.TempFile(tmpdir, "squashfs-comp-test-")
 	if err != nil {
-		return false, fmt.Errorf("while creating temporary file for squashfs: %v", err)
+		return "", fmt.Errorf("while creating temporary file for squashfs: %v", err)
 	}
+	defer os.Remove(f.Name())
 	f.Close()
 
 	flags := []string{"-noappend"}
 
 	mksquashfsProcs, err := squashfs.GetProcs()
 	if err != nil {
-		return false, fmt.Errorf("while searching for mksquashfs processor limits: %v", err)
+		return "", fmt.Errorf("while searching for mksquashfs processor limits: %v", err)
 	}
 	mksquashfsMem, err := squashfs.GetMem()
 	if err != nil {
-		return false, fmt.Errorf("while searching for mksquashfs mem limits: %v", err)
+		return "", fmt.Errorf("while searching for mksquashfs mem limits: %v", err)
 	}
 	if mksquashfsMem != "" {
 		flags = append(flags, "-mem", mksquashfsMem)
@@ -256,49 +389,75 @@ func ensureGzipComp(tmpdir, mksquashfsPath string) (bool, error) {
 	if mksquashfsProcs != 0 {
 		flags = append(flags, "-processors", fmt.Sprint(mksquashfsProcs))
 	}
+	flags = append(flags, compFlags...)
 
 	if err := s.Create([]string{srcf.Name()}, f.Name(), flags); err != nil {
-		return false, fmt.Errorf("while creating squashfs: %v", err)
+		return "", fmt.Errorf("while creating squashfs: %v", err)
 	}
 
 	content, err := ioutil.ReadFile(f.Name())
 	if err != nil {
-		return false, fmt.Errorf("while reading test squashfs: %v", err)
+		return "", fmt.Errorf("while reading test squashfs: %v", err)
 	}
 
 	comp, err := image.GetSquashfsComp(content)
 	if err != nil {
-		return false, fmt.Errorf("could not verify squashfs compression type: %v", err)
+		return "", fmt.Errorf("could not verify squashfs compression type: %v", err)
+	}
+	return comp, nil
+}
+
+// ensureGzipComp builds dummy squashfs images and checks the type of compression used
+// to deduce if we can successfully build with gzip compression. It returns an error
+// if we cannot and the `-comp` flags needed to specify gzip compression when the
+// final squashfs is built
+func ensureGzipComp(tmpdir, mksquashfsPath string) ([]string, error) {
+	buildLog.Debugf("Ensuring gzip compression for mksquashfs")
+
+	comp, err := testSquashfsComp(tmpdir, mksquashfsPath, nil)
+	if err != nil {
+		return nil, err
 	}
 
 	if comp == "gzip" {
-		sylog.Debugf("Gzip compression by default ensured")
-		return false, nil
+		buildLog.Debugf("Gzip compression by default ensured")
+		return nil, nil
 	}
 
 	// Now force add `-comp gzip` in addition to -noappend -mem -processors
-	flags = append(flags, "-comp", "gzip")
+	flags := []string{"-comp", "gzip"}
 
-	if err := s.Create([]string{srcf.Name()}, f.Name(), flags); err != nil {
-		return false, fmt.Errorf("could not build squashfs with required gzip compression")
+	comp, err = testSquashfsComp(tmpdir, mksquashfsPath, flags)
+	if err != nil {
+		return nil, fmt.Errorf("could not build squashfs with required gzip compression")
 	}
 
-	content, err = ioutil.ReadFile(f.Name())
-	if err != nil {
-		return false, fmt.Errorf("while reading test squashfs: %v", err)
+	if comp == "gzip" {
+		buildLog.Debugf("Gzip compression with -comp flag ensured")
+		return flags, nil
 	}
 
-	comp, err = image.GetSquashfsComp(content)
+	return nil, fmt.Errorf("could not build squashfs with required gzip compression")
+}
+
+// ensureComp builds a dummy squashfs image to check that mksquashfs supports
+// the requested compression algorithm and level, it returns the mksquashfs
+// flags required to build the final squashfs with this compression.
+func ensureComp(tmpdir, mksquashfsPath, comp string, level int) ([]string, error) {
+	buildLog.Debugf("Ensuring %s compression for mksquashfs", comp)
+
+	flags, err := squashfs.CompressionFlags(comp, level)
 	if err != nil {
-		return false, fmt.Errorf("could not verify squashfs compression type: %v", err)
+		return nil, err
 	}
 
-	if comp == "gzip" {
-		sylog.Debugf("Gzip compression with -comp flag ensured")
-		return true, nil
+	c, err := testSquashfsComp(tmpdir, mksquashfsPath, flags)
+	if err != nil {
+		return nil, fmt.Errorf("could not build squashfs with %s compression, mksquashfs may not support it: %v", comp, err)
+	} else if comp != squashfs.NoCompression && c != comp {
+		return nil, fmt.Errorf("could not build squashfs with %s compression, mksquashfs used %s instead", comp, c)
 	}
-
-	return false, fmt.Errorf("could not build squashfs with required gzip compression")
+	return flags, nil
 }
 
 // cleanUp removes remnants of build from file system unless NoCleanUp is specified.
@@ -308,22 +467,51 @@ func (b Build) cleanUp() {
 		for _, s := range b.stages {
 			bundlePaths = append(bundlePaths, s.b.RootfsPath, s.b.TmpDir)
 		}
-		sylog.Infof("Build performed with no clean up option, build bundle(s) located at: %v", bundlePaths)
+		buildLog.Infof("Build performed with no clean up option, build bundle(s) located at: %v", bundlePaths)
 		return
 	}
 
 	for _, s := range b.stages {
-		sylog.Debugf("Cleaning up %q and %q", s.b.RootfsPath, s.b.TmpDir)
+		buildLog.Debugf("Cleaning up %q and %q", s.b.RootfsPath, s.b.TmpDir)
 		err := s.b.Remove()
 		if err != nil {
-			sylog.Errorf("Could not remove bundle: %v", err)
+			buildLog.Errorf("Could not remove bundle: %v", err)
 		}
 	}
 }
 
 // Full runs a standard build from start to finish.
-func (b *Build) Full(ctx context.Context) error {
-	sylog.Infof("Starting build...")
+func (b *Build) Full(ctx context.Context) (err error) {
+	span := sylog.StartSpan("build")
+	span.SetAttribute("destination", b.Conf.Dest)
+	defer span.End()
+
+	started := time.Now()
+	// capture the build output stored in the image
+	var output *logCapture
+	if !b.Conf.Opts.NoBuildLog {
+		if output, err = captureLog(b.Conf.Opts.TmpDir); err != nil {
+			return err
+		}
+		defer output.cleanUp()
+		defer sylog.RegisterExitHook(output.cleanUp)()
+	}
+
+	buildLog.Infof("Starting build...")
+	emitter := b.Conf.Opts.Events
+	emitter.Emit(events.Event{Type: events.BuildStarted, Path: b.Conf.Dest})
+	defer func() {
+		if err != nil {
+			emitter.Emit(events.Event{Type: events.BuildFailed, Error: err.Error()})
+		}
+	}()
+
+	// abort the build once the timeout expires
+	if b.Conf.Opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.Conf.Opts.Timeout)
+		defer cancel()
+	}
 
 	// monitor build for termination signal and clean up
 	c := make(chan os.Signal, 1)
@@ -331,10 +519,15 @@ func (b *Build) Full(ctx context.Context) error {
 	go func() {
 		<-c
 		b.cleanUp()
+		if output != nil {
+			output.cleanUp()
+		}
 		os.Exit(1)
 	}()
 	// clean up build normally
 	defer b.cleanUp()
+	// or when a fatal error terminates the process
+	defer sylog.RegisterExitHook(b.cleanUp)()
 
 	oldumask := syscall.Umask(0002)
 
@@ -355,41 +548,178 @@ func (b *Build) Full(ctx context.Context) error {
 	}
 	configData := buffer.Bytes()
 
-	// build each stage one after the other
-	for i, stage := range b.stages {
-		if err := stage.runSectionScript("pre", stage.b.Recipe.BuildData.Pre); err != nil {
-			return err
-		}
+	if err := b.setupEmulation(); err != nil {
+		return err
+	}
 
-		// only update last stage if specified
-		update := stage.b.Opts.Update && !stage.b.Opts.Force && i == len(b.stages)-1
-		if update {
-			// updating, extract dest container to bundle
-			sylog.Infof("Building into existing container: %s", b.Conf.Dest)
-			p, err := sources.GetLocalPacker(b.Conf.Dest, stage.b)
-			if err != nil {
-				return err
-			}
+	removeLimits, err := b.setupLimits()
+	if err != nil {
+		return err
+	}
+	defer removeLimits()
+
+	if err := b.fetchURLSources(ctx); err != nil {
+		return fmt.Errorf("while downloading %%files URL sources: %v", err)
+	}
+
+	deps, err := stageDependencies(b.stages)
+	if err != nil {
+		return err
+	}
 
-			_, err = p.Pack(ctx)
+	sections := newSectionCache(b.Conf.Opts)
+	// the keys of the previous stages are part of the snapshot keys,
+	// they are computed before building the stages
+	var keys []stageKeys
+	if sections != nil {
+		for _, stage := range b.stages {
+			k, err := sectionKeys(stage.b.Recipe, stage.b.Opts, keys)
 			if err != nil {
 				return err
 			}
-		} else {
-			// regular build or force, start build from scratch
-			if b.Conf.Opts.ImgCache == nil {
-				return fmt.Errorf("undefined image cache")
-			}
-			if err := stage.c.Get(ctx, stage.b); err != nil {
-				return fmt.Errorf("conveyor failed to get: %v", err)
-			}
+			keys = append(keys, k)
+		}
+	}
 
-			_, err := stage.c.Pack(ctx)
-			if err != nil {
-				return fmt.Errorf("packer failed to pack: %v", err)
+	// build the stages once the stages they copy files from are built,
+	// one after the other unless --parallel is set
+	run := func(ctx context.Context, i int) error {
+		return b.buildStage(ctx, i, sections, keys, configData)
+	}
+	if parallel := b.Conf.Opts.Parallel; parallel > 1 && len(b.stages) > 1 {
+		buildLog.Infof("Building up to %d independent stages concurrently", parallel)
+		logs := newStageLogs(b.stages, emitter.Output())
+		err = runStages(ctx, parallel, deps, func(ctx context.Context, i int) error {
+			defer logs.finish(i)
+			b.stages[i].logger().Infof("Building stage %s", stageLabel(b.stages[i], i))
+			return run(ctx, i)
+		})
+		logs.flush()
+	} else {
+		err = runStages(ctx, 1, deps, run)
+	}
+	if err != nil {
+		return err
+	}
+
+	syscall.Umask(oldumask)
+
+	// the SBOM describes the final root filesystem only
+	lastStage := b.stages[len(b.stages)-1]
+	// called before the SBOM generation so that it includes the files
+	// added by the callbacks
+	if b.Conf.Format == "sif" {
+		if err := lastStage.preSquashfs(ctx); err != nil {
+			return err
+		}
+	}
+	if err := insertSBOM(lastStage.b, filepath.Base(b.Conf.Dest)); err != nil {
+		return fmt.Errorf("while generating SBOM: %v", err)
+	}
+
+	// the log ends with the last section, the assembler output isn't part
+	// of it
+	var log []byte
+	if output != nil {
+		if log, err = output.log(); err != nil {
+			return fmt.Errorf("while reading build log: %v", err)
+		}
+	}
+	if err := insertProvenance(lastStage.b, b.provenance(started), log); err != nil {
+		return fmt.Errorf("while inserting build provenance: %v", err)
+	}
+
+	buildLog.Debugf("Calling assembler")
+	if b.Conf.Opts.SignEntity != nil {
+		if err := b.assembleSigned(lastStage, b.Conf.Dest); err != nil {
+			return err
+		}
+	} else if b.updatingSIF() {
+		if err := b.assembleUpdate(lastStage, b.Conf.Dest); err != nil {
+			return err
+		}
+	} else if err := lastStage.Assemble(b.Conf.Dest); err != nil {
+		return err
+	}
+
+	completed := events.Event{Type: events.BuildCompleted, Path: b.Conf.Dest}
+	if b.Conf.Format == "sif" {
+		d, err := fs.FileDigest(b.Conf.Dest)
+		if err != nil {
+			return fmt.Errorf("while computing image digest: %v", err)
+		}
+		completed.Digest = d.String()
+	}
+	if err := lastStage.postBuild(ctx, b.Conf.Dest); err != nil {
+		return err
+	}
+	emitter.Emit(completed)
+
+	buildLog.Verbosef("Build complete: %s", b.Conf.Dest)
+	return nil
+}
+
+// buildStage builds the stage i, keys are the snapshot keys of the
+// stages if sections are cached.
+func (b *Build) buildStage(ctx context.Context, i int, sections *sectionCache, keys []stageKeys, configData []byte) error {
+	stage := &b.stages[i]
+	emitter := b.Conf.Opts.Events
+
+	emitter.Emit(events.Event{Type: events.StageStarted, Stage: stage.name})
+
+	if err := stage.runSectionScript(ctx, "pre", stage.b.Recipe.BuildData.Pre); err != nil {
+		return err
+	}
+
+	// restore the stage snapshots when its sections didn't change
+	restored := ""
+	if sections != nil {
+		var err error
+		if restored, err = sections.restoreStage(stage.name, keys[i], stage.b); err != nil {
+			return fmt.Errorf("while restoring cached sections: %v", err)
+		}
+	}
+
+	// only update last stage if specified
+	update := stage.b.Opts.Update && !stage.b.Opts.Force && i == len(b.stages)-1
+	if update {
+		// updating, extract dest container to bundle
+		stage.logger().Infof("Building into existing container: %s", b.Conf.Dest)
+		p, err := sources.GetLocalPacker(b.Conf.Dest, stage.b)
+		if err != nil {
+			return err
+		}
+
+		_, err = p.Pack(ctx)
+		if err != nil {
+			return err
+		}
+	} else if restored == "" {
+		// regular build or force, start build from scratch
+		if b.Conf.Opts.ImgCache == nil {
+			return fmt.Errorf("undefined image cache")
+		}
+		if err := stage.preBootstrap(ctx); err != nil {
+			return err
+		}
+		stage.sectionStarted("bootstrap")
+		if err := stage.c.Get(ctx, stage.b); err != nil {
+			return fmt.Errorf("conveyor failed to get: %v", err)
+		}
+
+		_, err := stage.c.Pack(ctx)
+		if err != nil {
+			return fmt.Errorf("packer failed to pack: %v", err)
+		}
+
+		if sections != nil {
+			if err := sections.save(keys[i].bootstrap, stage.b); err != nil {
+				return fmt.Errorf("while caching bootstrap snapshot: %v", err)
 			}
 		}
+	}
 
+	if restored != postSnapshot {
 		// create apps in bundle
 		a := apps.New()
 		for k, v := range stage.b.Recipe.CustomData {
@@ -410,62 +740,69 @@ func (b *Build) Full(ctx context.Context) error {
 			}
 		}
 
-		if err := stage.runSectionScript("setup", stage.b.Recipe.BuildData.Setup); err != nil {
+		if err := stage.runSectionScript(ctx, "setup", stage.b.Recipe.BuildData.Setup); err != nil {
 			return err
 		}
 
 		// copy files from host
 		if stage.b.RunSection("files") {
+			stage.sectionStarted("files")
 			if err := stage.copyFiles(); err != nil {
 				return fmt.Errorf("unable to copy files from host to container fs: %v", err)
 			}
 		}
+	}
 
-		// create stage file for /etc/resolv.conf and /etc/hosts
-		sessionResolv, err := createStageFile("/etc/resolv.conf", stage.b, "Name resolution could fail")
-		if err != nil {
-			return err
-		} else if sessionResolv != "" {
-			defer os.Remove(sessionResolv)
-		}
-		sessionHosts, err := createStageFile("/etc/hosts", stage.b, "Host resolution could fail")
-		if err != nil {
-			return err
-		} else if sessionHosts != "" {
-			defer os.Remove(sessionHosts)
-		}
+	// create stage file for /etc/resolv.conf and /etc/hosts
+	sessionResolv, err := createStageFile("/etc/resolv.conf", stage.b, "Name resolution could fail")
+	if err != nil {
+		return err
+	} else if sessionResolv != "" {
+		defer os.Remove(sessionResolv)
+	}
+	sessionHosts, err := createStageFile("/etc/hosts", stage.b, "Host resolution could fail")
+	if err != nil {
+		return err
+	} else if sessionHosts != "" {
+		defer os.Remove(sessionHosts)
+	}
 
-		// write the build configuration used for %post and %test sections
-		configFile := filepath.Join(stage.b.TmpDir, "singularity.conf")
-		if err := ioutil.WriteFile(configFile, configData, 0644); err != nil {
-			return fmt.Errorf("while creating %s: %s", configFile, err)
-		}
-		defer os.Remove(configFile)
+	// write the build configuration used for %post and %test sections
+	configFile := filepath.Join(stage.b.TmpDir, "singularity.conf")
+	if err := ioutil.WriteFile(configFile, configData, 0644); err != nil {
+		return fmt.Errorf("while creating %s: %s", configFile, err)
+	}
+	defer os.Remove(configFile)
 
+	if restored != postSnapshot {
 		if stage.b.Recipe.BuildData.Post.Script != "" {
-			if err := stage.runPostScript(configFile, sessionResolv, sessionHosts); err != nil {
+			if err := stage.runPostScript(ctx, configFile, sessionResolv, sessionHosts); err != nil {
 				return fmt.Errorf("while running engine: %v", err)
 			}
 		}
 
-		sylog.Debugf("Inserting Metadata")
-		if err := stage.insertMetadata(); err != nil {
-			return fmt.Errorf("while inserting metadata to bundle: %v", err)
+		if sections != nil {
+			if err := sections.save(keys[i].post, stage.b); err != nil {
+				return fmt.Errorf("while caching %%post snapshot: %v", err)
+			}
 		}
+	}
 
-		if err := stage.runTestScript(configFile, sessionResolv, sessionHosts); err != nil {
-			return fmt.Errorf("failed to execute %%test script: %v", err)
-		}
+	// the snapshot doesn't include the changes of the callbacks,
+	// they are called for restored stages too
+	if err := stage.postPost(ctx); err != nil {
+		return err
 	}
 
-	syscall.Umask(oldumask)
+	stage.logger().Debugf("Inserting Metadata")
+	if err := stage.insertMetadata(); err != nil {
+		return fmt.Errorf("while inserting metadata to bundle: %v", err)
+	}
 
-	sylog.Debugf("Calling assembler")
-	if err := b.stages[len(b.stages)-1].Assemble(b.Conf.Dest); err != nil {
-		return err
+	if err := stage.runTestScript(ctx, configFile, sessionResolv, sessionHosts); err != nil {
+		return fmt.Errorf("failed to execute %%test script: %v", err)
 	}
 
-	sylog.Verbosef("Build complete: %s", b.Conf.Dest)
 	return nil
 }
 
@@ -496,8 +833,9 @@ func makeDef(spec string) (types.Definition, error) {
 	return d, nil
 }
 
-// MakeAllDefs gets a definition object from a spec
-func MakeAllDefs(spec string) ([]types.Definition, error) {
+// MakeAllDefs gets a definition object from a spec, the {{ variable }}
+// references of a definition file are replaced by the values of args.
+func MakeAllDefs(spec string, args map[string]string) ([]types.Definition, error) {
 	if ok, err := uri.IsValid(spec); ok && err == nil {
 		// URI passed as spec
 		d, err := types.NewDefinitionFromURI(spec)
@@ -511,18 +849,58 @@ func MakeAllDefs(spec string) ([]types.Definition, error) {
 		return []types.Definition{d}, err
 	}
 
+	// translate Dockerfile instructions
+	if parser.IsDockerfile(spec) {
+		return dockerfileDefs(spec, args)
+	}
+
 	// default to reading file as definition
-	defFile, err := os.Open(spec)
+	raw, err := parser.ReadDefinitionFile(spec)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open file %s: %v", spec, err)
 	}
-	defer defFile.Close()
 
-	d, err := parser.All(defFile)
+	raw, includes, err := parser.ResolveIncludes(raw, spec)
 	if err != nil {
 		return nil, fmt.Errorf("while parsing definition: %s: %v", spec, err)
 	}
 
+	raw, err = parser.ApplyBuildArgs(raw, args)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing definition: %s: %v", spec, err)
+	}
+
+	d, err := parser.All(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("while parsing definition: %s: %v", spec, err)
+	}
+
+	for i := range d {
+		d[i].Includes = includes
+	}
+
+	return d, nil
+}
+
+// dockerfileDefs returns the definitions translated from the Dockerfile,
+// the directory of the Dockerfile is the build context.
+func dockerfileDefs(spec string, args map[string]string) ([]types.Definition, error) {
+	context, err := filepath.Abs(filepath.Dir(spec))
+	if err != nil {
+		return nil, fmt.Errorf("while getting Dockerfile context directory: %v", err)
+	}
+
+	f, err := os.Open(spec)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open file %s: %v", spec, err)
+	}
+	defer f.Close()
+
+	d, err := parser.ParseDockerfile(f, context, args)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing Dockerfile: %s: %v", spec, err)
+	}
+
 	return d, nil
 }
 
@@ -0,0 +1,151 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// logDrainTimeout is the delay the output left in the pipes is
+// copied within once the capture stops.
+const logDrainTimeout = 5 * time.Second
+
+// ansiRegexp matches the terminal escape sequences coloring the output.
+var ansiRegexp = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+
+// logCapture tees the standard output and error of the build process and
+// of its children to a log file. The file descriptors are redirected so
+// that the output of the sections and of the bootstrap tools is captured
+// along with the build messages.
+type logCapture struct {
+	file  *os.File
+	mutex sync.Mutex
+	wg    sync.WaitGroup
+	// saved are the original standard output and error
+	saved map[int]*os.File
+	// pipes are the read ends of the redirected descriptors
+	pipes []*os.File
+	once  sync.Once
+}
+
+// captureLog starts the capture of the build output to a temporary file
+// in dir.
+func captureLog(dir string) (*logCapture, error) {
+	f, err := ioutil.TempFile(dir, "build-log-")
+	if err != nil {
+		return nil, fmt.Errorf("while creating build log: %v", err)
+	}
+	c := &logCapture{file: f, saved: make(map[int]*os.File)}
+
+	for _, fd := range []int{unix.Stdout, unix.Stderr} {
+		if err := c.redirect(fd); err != nil {
+			c.cleanUp()
+			return nil, fmt.Errorf("while capturing build log: %v", err)
+		}
+	}
+	return c, nil
+}
+
+// redirect replaces the descriptor fd by a pipe copied to the log file
+// and to the original descriptor.
+func (c *logCapture) redirect(fd int) error {
+	saved, err := unix.Dup(fd)
+	if err != nil {
+		return err
+	}
+	out := os.NewFile(uintptr(saved), "")
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		out.Close()
+		return err
+	}
+	defer w.Close()
+
+	if err := unix.Dup2(int(w.Fd()), fd); err != nil {
+		out.Close()
+		r.Close()
+		return err
+	}
+	c.saved[fd] = out
+	c.pipes = append(c.pipes, r)
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		io.Copy(io.MultiWriter(out, c), r)
+	}()
+	return nil
+}
+
+// Write writes the output of both descriptors to the log file.
+func (c *logCapture) Write(p []byte) (int, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	// the log is best effort, the output is never blocked
+	c.file.Write(p)
+	return len(p), nil
+}
+
+// stop restores the original descriptors once the output is copied,
+// the copy is interrupted after a delay if a process left running in the
+// background (eg: a daemon started by %post) still holds a descriptor.
+func (c *logCapture) stop() {
+	c.once.Do(func() {
+		for fd, out := range c.saved {
+			// the pipe is closed once the last writer is replaced
+			unix.Dup2(int(out.Fd()), fd)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			c.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(logDrainTimeout):
+		}
+
+		for _, r := range c.pipes {
+			r.Close()
+		}
+		<-done
+		for _, out := range c.saved {
+			out.Close()
+		}
+	})
+}
+
+// log stops the capture and returns the build log without the terminal
+// escape sequences.
+func (c *logCapture) log() ([]byte, error) {
+	c.stop()
+
+	if _, err := c.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(c.file)
+	if err != nil {
+		return nil, err
+	}
+	return ansiRegexp.ReplaceAll(data, nil), nil
+}
+
+// cleanUp stops the capture and removes the log file.
+func (c *logCapture) cleanUp() {
+	c.stop()
+	c.file.Close()
+	os.Remove(c.file.Name())
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sylabs/singularity/internal/pkg/util/uri"
+	"github.com/sylabs/singularity/pkg/build/types/parser"
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+// CheckScripts runs the static analysis of the %post and %runscript
+// sections of the definition file spec once its includes and variables
+// are resolved. The home directory of the user, the current working
+// directory and the directory of the definition file are the host paths
+// reported unless they are bound by binds (src:dst[:ro]). Other specs
+// have no script to check.
+func CheckScripts(spec string, args map[string]string, binds []string) ([]parser.Diagnostic, error) {
+	if ok, err := uri.IsValid(spec); ok && err == nil {
+		return nil, nil
+	}
+	if i, err := image.Init(spec, false); err == nil {
+		_ = i.File.Close()
+		return nil, nil
+	}
+	if parser.IsDockerfile(spec) {
+		return nil, nil
+	}
+
+	raw, err := parser.ReadDefinitionFile(spec)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open file %s: %v", spec, err)
+	}
+	raw, _, err = parser.ResolveIncludes(raw, spec)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing definition: %s: %v", spec, err)
+	}
+	raw, err = parser.ApplyBuildArgs(raw, args)
+	if err != nil {
+		return nil, fmt.Errorf("while parsing definition: %s: %v", spec, err)
+	}
+
+	var opts parser.CheckOptions
+	var dirs []string
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, home)
+	}
+	if cwd, err := os.Getwd(); err == nil {
+		dirs = append(dirs, cwd)
+	}
+	if path, err := filepath.Abs(spec); err == nil {
+		dirs = append(dirs, filepath.Dir(path))
+	}
+	for _, dir := range dirs {
+		// the home directory of root is also a container directory
+		if dir != "/root" {
+			opts.HostPaths = append(opts.HostPaths, dir)
+		}
+	}
+	for _, b := range binds {
+		if split := strings.Split(b, ":"); len(split) > 1 {
+			opts.Binds = append(opts.Binds, split[1])
+		}
+	}
+
+	return parser.CheckScripts(raw, opts), nil
+}
@@ -39,7 +39,7 @@ func conveyorPacker(def types.Definition) (ConveyorPacker, error) {
 		return &sources.OrasConveyorPacker{}, nil
 	case "shub":
 		return &sources.ShubConveyorPacker{}, nil
-	case "docker", "docker-archive", "docker-daemon", "oci", "oci-archive":
+	case "docker", "docker-archive", "docker-daemon", "oci", "oci-archive", "containers-storage", "podman":
 		return &sources.OCIConveyorPacker{}, nil
 	case "busybox":
 		return &sources.BusyBoxConveyorPacker{}, nil
@@ -55,6 +55,12 @@ func conveyorPacker(def types.Definition) (ConveyorPacker, error) {
 		return &sources.ZypperConveyorPacker{}, nil
 	case "scratch":
 		return &sources.ScratchConveyorPacker{}, nil
+	case "nix":
+		return &sources.NixConveyorPacker{}, nil
+	case "conda":
+		return &sources.CondaConveyorPacker{}, nil
+	case "spack":
+		return &sources.SpackConveyorPacker{}, nil
 	case "":
 		return nil, fmt.Errorf("no bootstrap specification found")
 	default:
@@ -0,0 +1,50 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"fmt"
+
+	"github.com/sylabs/singularity/internal/pkg/util/machine"
+)
+
+// setupEmulation prepares the qemu-user emulation running %post and %test
+// when the build platform architecture can't run natively on the host.
+func (b *Build) setupEmulation() error {
+	if b.Conf.Opts.Platform == "" {
+		return nil
+	}
+	p, err := machine.ParsePlatform(b.Conf.Opts.Platform)
+	if err != nil {
+		return err
+	}
+
+	// emulation is only required to run commands in the container
+	needed := false
+	for _, s := range b.stages {
+		post := s.b.RunSection("post") && s.b.Recipe.BuildData.Post.Script != ""
+		test := !s.b.Opts.NoTest && s.b.Recipe.BuildData.Test.Script != ""
+		needed = needed || post || test
+	}
+	if !needed {
+		return nil
+	}
+
+	e, err := machine.SetupEmulation(p.Arch)
+	if err != nil {
+		return fmt.Errorf("while setting up %s emulation: %v", p.Arch, err)
+	} else if e == nil {
+		return nil
+	}
+
+	buildLog.Infof("Running %s sections with %s", p.Arch, e.Interpreter)
+	if !e.FixBinary {
+		for i := range b.stages {
+			b.stages[i].emulator = e.Interpreter
+		}
+	}
+	return nil
+}
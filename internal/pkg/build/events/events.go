@@ -0,0 +1,131 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package events emits the machine-readable build progress requested
+// with build --json, one JSON object per line.
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/types"
+)
+
+// Types of the build events.
+const (
+	// BuildStarted is emitted once the build starts.
+	BuildStarted = "build-started"
+	// StageStarted is emitted when a stage starts.
+	StageStarted = "stage-started"
+	// SectionStarted is emitted before running a section of a stage,
+	// bootstrap designates the root filesystem creation.
+	SectionStarted = "section-started"
+	// FetchProgress reports the bytes fetched of an OCI blob.
+	FetchProgress = "fetch-progress"
+	// SquashfsProgress reports the mksquashfs progress in percent.
+	SquashfsProgress = "squashfs-progress"
+	// Signature is emitted once the image is signed.
+	Signature = "signature"
+	// BuildCompleted is emitted with the image digest once built.
+	BuildCompleted = "build-completed"
+	// BuildFailed is emitted with the error of a failed build.
+	BuildFailed = "build-failed"
+)
+
+// ProgressInterval is the interval of the FetchProgress events of a blob.
+const ProgressInterval = 500 * time.Millisecond
+
+// Event is a build progress event.
+type Event struct {
+	Time        time.Time `json:"time"`
+	Type        string    `json:"type"`
+	Stage       string    `json:"stage,omitempty"`
+	Section     string    `json:"section,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	Digest      string    `json:"digest,omitempty"`
+	Bytes       uint64    `json:"bytes,omitempty"`
+	Total       int64     `json:"total,omitempty"`
+	Percent     int       `json:"percent,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Emitter writes the build events, a nil Emitter discards them so
+// that callers don't have to check whether --json was given.
+type Emitter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	stdout bool
+}
+
+// New returns an Emitter writing the events to w.
+func New(w io.Writer) *Emitter {
+	return &Emitter{w: w, stdout: w == os.Stdout}
+}
+
+// Emit writes the event ev, its time is set if unset.
+func (e *Emitter) Emit(ev Event) {
+	if e == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.w.Write(append(data, '\n'))
+}
+
+// Output returns the writer of the section scripts output, it is
+// stderr when the events are written to stdout to keep them parsable.
+func (e *Emitter) Output() io.Writer {
+	if e != nil && e.stdout {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// CopyProgress returns the channel reporting the progress of an image
+// copy as FetchProgress events and the function to call once the copy
+// returned, the channel is nil for a nil Emitter.
+func (e *Emitter) CopyProgress() (chan types.ProgressProperties, func()) {
+	if e == nil {
+		return nil, func() {}
+	}
+
+	c := make(chan types.ProgressProperties)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		for p := range c {
+			if p.Event == types.ProgressEventNewArtifact {
+				continue
+			}
+			e.Emit(Event{
+				Type:   FetchProgress,
+				Digest: p.Artifact.Digest.String(),
+				Bytes:  p.Offset,
+				Total:  p.Artifact.Size,
+			})
+		}
+	}()
+
+	return c, func() {
+		close(c)
+		<-done
+	}
+}
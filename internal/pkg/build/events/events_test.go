@@ -0,0 +1,70 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package events
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+func TestEmitter(t *testing.T) {
+	var buf bytes.Buffer
+
+	e := New(&buf)
+	e.Emit(Event{Type: StageStarted, Stage: "devel"})
+
+	c, done := e.CopyProgress()
+	blob := types.BlobInfo{Digest: digest.FromString("layer"), Size: 10}
+	c <- types.ProgressProperties{Event: types.ProgressEventNewArtifact, Artifact: blob}
+	c <- types.ProgressProperties{Event: types.ProgressEventRead, Artifact: blob, Offset: 5}
+	done()
+
+	var got []Event
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		var ev Event
+		if err := json.Unmarshal(s.Bytes(), &ev); err != nil {
+			t.Fatalf("invalid event %q: %s", s.Text(), err)
+		}
+		got = append(got, ev)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("got %d events, want 2", len(got))
+	}
+	if got[0].Type != StageStarted || got[0].Stage != "devel" || got[0].Time.IsZero() {
+		t.Errorf("unexpected stage event %+v", got[0])
+	}
+	if got[1].Type != FetchProgress || got[1].Bytes != 5 || got[1].Total != 10 || got[1].Digest != blob.Digest.String() {
+		t.Errorf("unexpected fetch event %+v", got[1])
+	}
+	if e.Output() != os.Stdout {
+		t.Errorf("unexpected scripts output")
+	}
+}
+
+func TestNilEmitter(t *testing.T) {
+	var e *Emitter
+
+	e.Emit(Event{Type: BuildStarted})
+	if c, done := e.CopyProgress(); c != nil {
+		t.Errorf("unexpected progress channel")
+	} else {
+		done()
+	}
+	if e.Output() != os.Stdout {
+		t.Errorf("unexpected scripts output")
+	}
+	if New(os.Stdout).Output() != os.Stderr {
+		t.Errorf("scripts output not redirected to stderr")
+	}
+}
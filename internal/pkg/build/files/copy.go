@@ -8,10 +8,15 @@ package files
 import (
 	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+
+	"golang.org/x/sys/unix"
 )
 
 // makeParentDir ensures existence of the expected destination directory for the cp command
@@ -39,6 +44,25 @@ func makeParentDir(path string, numSrcPaths int) error {
 	return nil
 }
 
+// Options are the options of a copy into the container.
+type Options struct {
+	// FollowLinks follows all the symlinks, rather than only those of the
+	// source paths.
+	FollowLinks bool
+	// Exclude are the patterns of the files not copied, a pattern without
+	// slash matches the file names at any depth, a pattern with a slash
+	// matches the path relative to the copied source and a trailing slash
+	// matches directories only.
+	Exclude []string
+	// Chown sets the owner of the copied files to UID and GID.
+	Chown    bool
+	UID, GID int
+	// Chmod sets the permission mode of the copied files and directories
+	// to Mode.
+	Chmod bool
+	Mode  uint32
+}
+
 // Copy calls cp with src and dst as its arguments
 // Checks dst and creates parent directories if they do not exist
 // before calling cp.
@@ -47,16 +71,63 @@ func makeParentDir(path string, numSrcPaths int) error {
 // files or files that resolve directly from a glob pattern. It will not follow
 // links found during directory traversal.
 func Copy(src, dst string, followLinks bool) error {
+	return CopyWithOptions(src, dst, Options{FollowLinks: followLinks})
+}
+
+// CopyWithOptions copies src to dst like Copy, the files matching the
+// exclude patterns are skipped and the owner and mode of the copied files
+// are set if requested.
+func CopyWithOptions(src, dst string, opts Options) error {
 	// resolve any bash globbing in filepath
 	paths, err := expandPath(src)
 	if err != nil {
 		return fmt.Errorf("while expanding source path with bash: %s: %s", src, err)
 	}
+	var sources []string
+	for _, p := range paths {
+		if fi, err := os.Stat(p); err == nil && excluded(opts.Exclude, filepath.Base(p), fi.IsDir()) {
+			continue
+		}
+		sources = append(sources, p)
+	}
+	if len(paths) > 0 && len(sources) == 0 {
+		return nil
+	}
 
-	if err := makeParentDir(dst, len(paths)); err != nil {
+	if err := makeParentDir(dst, len(sources)); err != nil {
 		return fmt.Errorf("while creating parent dir: %v", err)
 	}
+	// the targets are resolved before the copy creates dst
+	targets := make([]string, len(sources))
+	for i, p := range sources {
+		targets[i] = target(p, dst)
+	}
+
+	if len(opts.Exclude) == 0 {
+		if err := cp(sources, dst, opts.FollowLinks); err != nil {
+			return err
+		}
+	} else {
+		for i, p := range sources {
+			if err := copyTree(p, targets[i], opts); err != nil {
+				return fmt.Errorf("while copying %s to %s: %s", p, dst, err)
+			}
+		}
+	}
+
+	if !opts.Chown && !opts.Chmod {
+		return nil
+	}
+	for i, p := range sources {
+		if err := setAttributes(p, targets[i], opts); err != nil {
+			return fmt.Errorf("while setting owner and mode of %s: %s", dst, err)
+		}
+	}
+	return nil
+}
 
+// cp copies paths to dst with the cp command.
+func cp(paths []string, dst string, followLinks bool) error {
 	// set flags for cp
 	args := []string{"-fHr"}
 	if followLinks {
@@ -77,3 +148,194 @@ func Copy(src, dst string, followLinks bool) error {
 	}
 	return nil
 }
+
+// target returns the path the source path is copied to by cp -r in dst.
+func target(path, dst string) string {
+	if fi, err := os.Stat(dst); err == nil && fi.IsDir() {
+		return filepath.Join(dst, filepath.Base(path))
+	}
+	return dst
+}
+
+// excluded returns whether the file at the relative path rel matches
+// one of the exclude patterns.
+func excluded(patterns []string, rel string, dir bool) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "/") {
+			if !dir {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = filepath.Base(rel)
+		}
+		if ok, _ := filepath.Match(strings.TrimPrefix(pattern, "/"), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// copyTree copies the source path to dst without the excluded files, the
+// directories are walked in lexical order. The source path is followed if
+// it's a symlink, other symlinks are followed with opts.FollowLinks.
+func copyTree(src, dst string, opts Options) error {
+	root, err := filepath.EvalSymlinks(src)
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && excluded(opts.Exclude, rel, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		to := filepath.Join(dst, rel)
+
+		switch mode := fi.Mode(); {
+		case mode.IsDir():
+			if err := os.Mkdir(to, fi.Mode().Perm()); err != nil && !os.IsExist(err) {
+				return err
+			}
+			return os.Chmod(to, fi.Mode().Perm())
+		case mode.IsRegular():
+			return copyFile(path, to, fi.Mode().Perm())
+		case mode&os.ModeSymlink != 0 && !opts.FollowLinks:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err := os.Remove(to); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return os.Symlink(link, to)
+		default:
+			// followed symlinks and special files
+			return cp([]string{path}, to, opts.FollowLinks)
+		}
+	})
+}
+
+// copyFile copies the regular file src to dst with the permission perm.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	// cp -f removes the destination it can't open
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		os.Remove(dst)
+		if out, err = os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm); err != nil {
+			return err
+		}
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
+
+// setAttributes sets the owner and the mode of the files copied from the
+// source path to dst, the files of dst not copied from the source path
+// are left untouched.
+func setAttributes(src, dst string, opts Options) error {
+	return filepath.Walk(dst, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." && filepath.Base(src) == "." {
+			// the content of the source directory is copied in dst
+			return nil
+		} else if rel != "." {
+			if _, err := os.Lstat(filepath.Join(src, rel)); err != nil || excluded(opts.Exclude, rel, fi.IsDir()) {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+		}
+
+		if opts.Chown {
+			if err := os.Lchown(path, opts.UID, opts.GID); err != nil {
+				return err
+			}
+		}
+		if opts.Chmod && fi.Mode()&os.ModeSymlink == 0 {
+			if err := unix.Chmod(path, opts.Mode); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// LookupOwner returns the IDs of the user[:group] owner, the names are
+// looked up in the passwd and group files of the container rootfs. The
+// group defaults to the primary group of the user.
+func LookupOwner(rootfs, owner string) (uid, gid int, err error) {
+	kv := strings.SplitN(owner, ":", 2)
+
+	uid, gid, err = lookupID(filepath.Join(rootfs, "etc/passwd"), kv[0], true)
+	if err != nil {
+		return 0, 0, fmt.Errorf("user %s: %v", kv[0], err)
+	}
+	if len(kv) == 2 {
+		if gid, _, err = lookupID(filepath.Join(rootfs, "etc/group"), kv[1], false); err != nil {
+			return 0, 0, fmt.Errorf("group %s: %v", kv[1], err)
+		}
+	}
+	return uid, gid, nil
+}
+
+// lookupID returns the ID of the entry named name in the passwd or group
+// file at path, and the primary group ID of passwd entries. Numeric names
+// don't require an entry, the primary group is the same ID then.
+func lookupID(path, name string, passwd bool) (id, gid int, err error) {
+	if id, err := strconv.Atoi(name); err == nil {
+		return id, id, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || fields[0] != name {
+			continue
+		}
+		if id, err = strconv.Atoi(fields[2]); err != nil {
+			return 0, 0, fmt.Errorf("invalid entry in %s: %s", path, line)
+		}
+		gid = id
+		if passwd && len(fields) > 3 {
+			if gid, err = strconv.Atoi(fields[3]); err != nil {
+				return 0, 0, fmt.Errorf("invalid entry in %s: %s", path, line)
+			}
+		}
+		return id, gid, nil
+	}
+	return 0, 0, fmt.Errorf("not found in %s", path)
+}
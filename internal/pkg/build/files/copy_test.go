@@ -338,3 +338,91 @@ func TestCopyFail(t *testing.T) {
 		})
 	}
 }
+
+func TestCopyWithOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "copy-test-options-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcDir := filepath.Join(dir, "sourceDir")
+	for _, p := range []string{"keep", "drop.bak", "sub/keep", "sub/drop.bak", "build/output"} {
+		path := filepath.Join(srcDir, p)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(sourceFileContent), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := filepath.Join(dir, "destDir")
+	opts := Options{
+		Exclude: []string{"*.bak", "build/"},
+		Chown:   true,
+		UID:     os.Getuid(),
+		GID:     os.Getgid(),
+		Chmod:   true,
+		Mode:    0640,
+	}
+	if err := CopyWithOptions(srcDir, dst, opts); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, p := range []string{"keep", "sub/keep"} {
+		fi, err := os.Stat(filepath.Join(dst, p))
+		if err != nil {
+			t.Errorf("%s not copied: %v", p, err)
+		} else if fi.Mode().Perm() != 0640 {
+			t.Errorf("unexpected mode %s of %s", fi.Mode(), p)
+		}
+	}
+	for _, p := range []string{"drop.bak", "sub/drop.bak", "build"} {
+		if _, err := os.Lstat(filepath.Join(dst, p)); !os.IsNotExist(err) {
+			t.Errorf("excluded %s copied: %v", p, err)
+		}
+	}
+}
+
+func TestLookupOwner(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "copy-test-rootfs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	if err := os.Mkdir(filepath.Join(rootfs, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	passwd := "root:x:0:0:root:/root:/bin/sh\napp:x:1000:1001::/home/app:/bin/sh\n"
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "etc/passwd"), []byte(passwd), 0644); err != nil {
+		t.Fatal(err)
+	}
+	group := "root:x:0:\nstaff:x:50:app\n"
+	if err := ioutil.WriteFile(filepath.Join(rootfs, "etc/group"), []byte(group), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		owner   string
+		uid     int
+		gid     int
+		wantErr bool
+	}{
+		{owner: "app", uid: 1000, gid: 1001},
+		{owner: "app:staff", uid: 1000, gid: 50},
+		{owner: "2000:50", uid: 2000, gid: 50},
+		{owner: "2000", uid: 2000, gid: 2000},
+		{owner: "missing", wantErr: true},
+		{owner: "app:missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		uid, gid, err := LookupOwner(rootfs, tt.owner)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("unexpected error for %s: %v", tt.owner, err)
+		} else if uid != tt.uid || gid != tt.gid {
+			t.Errorf("unexpected IDs %d:%d for %s", uid, gid, tt.owner)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package files
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sylabs/singularity/pkg/sylog"
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
+)
+
+// IsURL returns whether the %files source src is a http or https URL
+// downloaded by the build.
+func IsURL(src string) bool {
+	return strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")
+}
+
+// URLPath returns the path of the URL source src, it's the default
+// destination of the downloaded file in the container.
+func URLPath(src string) (string, error) {
+	u, err := url.Parse(src)
+	if err != nil {
+		return "", err
+	}
+	p := path.Clean("/" + u.Path)
+	if p == "/" || strings.HasSuffix(u.Path, "/") {
+		return "", fmt.Errorf("URL %s has no file name", src)
+	}
+	return p, nil
+}
+
+// Downloads holds the URL sources downloaded by Fetch, they are stored at
+// <dir>/<host>/<URL path>.
+type Downloads struct {
+	Dir   string
+	paths map[string]string
+}
+
+// Path returns the path of the downloaded URL source src.
+func (d *Downloads) Path(src string) (string, error) {
+	if d != nil {
+		if p, ok := d.paths[src]; ok {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%s was not downloaded", src)
+}
+
+// Fetch downloads the URL sources to dir with at most workers concurrent
+// downloads, the identical URLs are downloaded once.
+func Fetch(ctx context.Context, dir string, srcs []string, workers int) (*Downloads, error) {
+	d := &Downloads{Dir: dir, paths: make(map[string]string)}
+
+	// the URLs stored at the same path would overwrite each other
+	urls := make(map[string]string)
+	var queue []string
+	for _, src := range srcs {
+		if _, ok := d.paths[src]; ok {
+			continue
+		}
+		u, err := url.Parse(src)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %s: %v", src, err)
+		}
+		p, err := URLPath(src)
+		if err != nil {
+			return nil, err
+		}
+		p = filepath.Join(dir, u.Host, p)
+		if other, ok := urls[p]; ok {
+			return nil, fmt.Errorf("%s and %s would be downloaded to the same path", other, src)
+		}
+		urls[p] = src
+		d.paths[src] = p
+		queue = append(queue, src)
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(queue) {
+		workers = len(queue)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan string)
+	errs := make(chan error, len(queue))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for src := range jobs {
+				if err := download(ctx, src, d.paths[src]); err != nil {
+					errs <- fmt.Errorf("while downloading %s: %v", src, err)
+					// abort the other downloads
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for _, src := range queue {
+		select {
+		case jobs <- src:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+	return d, ctx.Err()
+}
+
+// download writes the content of the URL src to the file dst.
+func download(ctx context.Context, src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodGet, src, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("User-Agent", useragent.Value())
+
+	sylog.Infof("Downloading %s", src)
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status %d", res.StatusCode)
+	}
+
+	// the file is written at its path once complete
+	f, err := ioutil.TempFile(filepath.Dir(dst), ".download-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, res.Body); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package files
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
+)
+
+func TestMain(m *testing.M) {
+	useragent.InitValue("singularity", "3.0.0-alpha.1-303-gaed8d30-dirty")
+
+	os.Exit(m.Run())
+}
+
+func TestURLPath(t *testing.T) {
+	tests := []struct {
+		src     string
+		path    string
+		wantErr bool
+	}{
+		{src: "https://example.com/pkg/file.tar.gz", path: "/pkg/file.tar.gz"},
+		{src: "https://example.com/../../file?version=1", path: "/file"},
+		{src: "https://example.com", wantErr: true},
+		{src: "https://example.com/pkg/", wantErr: true},
+	}
+	for _, tt := range tests {
+		p, err := URLPath(tt.src)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("unexpected error for %s: %v", tt.src, err)
+		}
+		if p != tt.path {
+			t.Errorf("unexpected path for %s: %s instead of %s", tt.src, p, tt.path)
+		}
+	}
+}
+
+func TestFetch(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "files-fetch-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	srcs := []string{srv.URL + "/a", srv.URL + "/dir/b", srv.URL + "/a", srv.URL + "/c"}
+	d, err := Fetch(context.Background(), dir, srcs, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, src := range srcs {
+		p, err := d.Path(src)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !filepath.HasPrefix(p, dir) {
+			t.Errorf("%s downloaded outside of %s", p, dir)
+		}
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if want := src[len(srv.URL):]; string(b) != want {
+			t.Errorf("unexpected content of %s: %q instead of %q", p, b, want)
+		}
+	}
+	if requests["/a"] != 1 {
+		t.Errorf("%s downloaded %d times", srv.URL+"/a", requests["/a"])
+	}
+	if _, err := d.Path(srv.URL + "/d"); err == nil {
+		t.Errorf("unexpected success for a URL not downloaded")
+	}
+
+	if _, err := Fetch(context.Background(), dir, []string{srv.URL + "/a", srv.URL + "/missing"}, 2); err == nil {
+		t.Errorf("unexpected success for a missing URL")
+	}
+	if _, err := Fetch(context.Background(), dir, []string{srv.URL + "/a", srv.URL + "/a?v=2"}, 2); err == nil {
+		t.Errorf("unexpected success for URLs downloaded to the same path")
+	}
+}
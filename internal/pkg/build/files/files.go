@@ -8,6 +8,7 @@ package files
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -21,6 +22,8 @@ done
 func expandPath(path string) ([]string, error) {
 	var output, stderr bytes.Buffer
 	cmd := exec.Command("/bin/sh", "-c", fmt.Sprintf(filenameExpansionScript, path))
+	// sort the matches in byte order whatever the locale of the user
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
 	cmd.Stdout = &output
 	cmd.Stderr = &stderr
 	if err := cmd.Run(); err != nil {
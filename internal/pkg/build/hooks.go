@@ -0,0 +1,64 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sylabs/singularity/internal/pkg/plugin"
+	pluginapi "github.com/sylabs/singularity/pkg/plugin"
+	buildcallback "github.com/sylabs/singularity/pkg/plugin/callback/build"
+)
+
+// runCallbacks calls the plugin callbacks of callbackType with call, the
+// build fails with the first callback error.
+func runCallbacks(callbackType pluginapi.Callback, call func(pluginapi.Callback) error) error {
+	callbacks, err := plugin.LoadCallbacks(callbackType)
+	if err != nil {
+		return fmt.Errorf("while loading plugins callbacks '%T': %s", callbackType, err)
+	}
+	for _, cb := range callbacks {
+		if err := call(cb); err != nil {
+			return fmt.Errorf("plugin callback '%T' failed: %v", callbackType, err)
+		}
+	}
+	return nil
+}
+
+// callbackStage returns the description of the stage passed to the
+// build callbacks.
+func (s *stage) callbackStage() buildcallback.Stage {
+	return buildcallback.Stage{
+		Name:       s.name,
+		RootfsPath: s.b.RootfsPath,
+		Definition: s.b.Recipe,
+	}
+}
+
+func (s *stage) preBootstrap(ctx context.Context) error {
+	return runCallbacks((buildcallback.PreBootstrap)(nil), func(cb pluginapi.Callback) error {
+		return cb.(buildcallback.PreBootstrap)(ctx, s.callbackStage())
+	})
+}
+
+func (s *stage) postPost(ctx context.Context) error {
+	return runCallbacks((buildcallback.PostPost)(nil), func(cb pluginapi.Callback) error {
+		return cb.(buildcallback.PostPost)(ctx, s.callbackStage())
+	})
+}
+
+func (s *stage) preSquashfs(ctx context.Context) error {
+	return runCallbacks((buildcallback.PreSquashfs)(nil), func(cb pluginapi.Callback) error {
+		return cb.(buildcallback.PreSquashfs)(ctx, s.callbackStage())
+	})
+}
+
+func (s *stage) postBuild(ctx context.Context, dest string) error {
+	return runCallbacks((buildcallback.PostBuild)(nil), func(cb pluginapi.Callback) error {
+		return cb.(buildcallback.PostBuild)(ctx, s.callbackStage(), dest)
+	})
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/plugin/callback"
+	"github.com/sylabs/singularity/pkg/build/types"
+	buildcallback "github.com/sylabs/singularity/pkg/plugin/callback/build"
+)
+
+func TestCallbacks(t *testing.T) {
+	s := &stage{
+		name: "one",
+		b: &types.Bundle{
+			RootfsPath: "/tmp/rootfs",
+			Recipe:     types.Definition{Header: map[string]string{"bootstrap": "scratch"}},
+		},
+	}
+
+	var got []string
+	callback.Load((buildcallback.PreBootstrap)(func(ctx context.Context, stage buildcallback.Stage) error {
+		got = append(got, "pre-bootstrap "+stage.Name+" "+stage.RootfsPath+" "+stage.Definition.Header["bootstrap"])
+		return nil
+	}))
+	callback.Load((buildcallback.PostBuild)(func(ctx context.Context, stage buildcallback.Stage, dest string) error {
+		got = append(got, "post-build "+dest)
+		return fmt.Errorf("image rejected")
+	}))
+
+	if err := s.preBootstrap(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := s.postPost(context.Background()); err != nil {
+		t.Errorf("unexpected error without callback: %v", err)
+	}
+	if err := s.postBuild(context.Background(), "image.sif"); err == nil {
+		t.Errorf("unexpected success of a failing callback")
+	}
+
+	expected := []string{"pre-bootstrap one /tmp/rootfs scratch", "post-build image.sif"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("unexpected callbacks calls %q instead of %q", got, expected)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+
+	units "github.com/docker/go-units"
+	"github.com/sylabs/singularity/internal/pkg/cgroups"
+	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/util/namespaces"
+)
+
+// cpuPeriod is the CFS period in microseconds of the --cpus limit.
+const cpuPeriod = 100000
+
+// Limits returns the cgroups configuration restricting the memory in
+// bytes and the number of CPUs of the build, it returns nil if there
+// is no limit.
+func Limits(memory int64, cpus float64) *cgroups.Config {
+	if memory <= 0 && cpus <= 0 {
+		return nil
+	}
+
+	config := &cgroups.Config{}
+	if memory > 0 {
+		config.Memory = &cgroups.LinuxMemory{Limit: &memory}
+	}
+	if cpus > 0 {
+		period := uint64(cpuPeriod)
+		quota := int64(cpus * cpuPeriod)
+		config.CPU = &cgroups.LinuxCPU{Quota: &quota, Period: &period}
+	}
+	return config
+}
+
+// setupLimits writes the cgroups configuration applied to the %post and
+// %test sections, it returns a function removing it. Builds run with
+// --fakeroot are confined as a whole by the fakeroot engine as cgroups
+// can't be managed from the user namespace.
+func (b *Build) setupLimits() (func(), error) {
+	config := Limits(b.Conf.Opts.Memory, b.Conf.Opts.CPUs)
+	if config == nil {
+		return func() {}, nil
+	}
+	if userns, _ := namespaces.IsInsideUserNamespace(os.Getpid()); userns {
+		buildLog.Debugf("Build resource limits applied by the fakeroot engine")
+		return func() {}, nil
+	}
+
+	f, err := ioutil.TempFile(b.Conf.Opts.TmpDir, "build-cgroups-")
+	if err != nil {
+		return nil, fmt.Errorf("while creating cgroups configuration: %v", err)
+	}
+	f.Close()
+
+	if err := cgroups.PutConfig(*config, f.Name()); err != nil {
+		os.Remove(f.Name())
+		return nil, fmt.Errorf("while writing cgroups configuration: %v", err)
+	}
+
+	for i := range b.stages {
+		b.stages[i].cgroupsConfig = f.Name()
+	}
+	return func() { os.Remove(f.Name()) }, nil
+}
+
+// limitError returns the reason of the termination of a section script
+// when it was killed by a build resource limit, other errors are returned
+// unchanged.
+func limitError(ctx context.Context, opts types.Options, err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("killed after the build timeout of %s", opts.Timeout)
+	}
+
+	var exitErr *exec.ExitError
+	if opts.Memory > 0 && errors.As(err, &exitErr) {
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() && ws.Signal() == syscall.SIGKILL {
+			return fmt.Errorf("killed, the memory limit of %s was probably exceeded", units.BytesSize(float64(opts.Memory)))
+		}
+	}
+	return err
+}
@@ -0,0 +1,60 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+func TestLimits(t *testing.T) {
+	if Limits(0, 0) != nil {
+		t.Errorf("unexpected configuration without limits")
+	}
+
+	c := Limits(1<<30, 1.5)
+	if c == nil || c.Memory == nil || c.CPU == nil {
+		t.Fatalf("missing limits in configuration")
+	}
+	if *c.Memory.Limit != 1<<30 {
+		t.Errorf("unexpected memory limit %d", *c.Memory.Limit)
+	}
+	if *c.CPU.Quota != 150000 || *c.CPU.Period != cpuPeriod {
+		t.Errorf("unexpected CPU quota %d/%d", *c.CPU.Quota, *c.CPU.Period)
+	}
+
+	if c := Limits(0, 2); c.Memory != nil {
+		t.Errorf("unexpected memory limit")
+	}
+}
+
+func TestLimitError(t *testing.T) {
+	opts := types.Options{Memory: 1 << 30, Timeout: time.Second}
+
+	if limitError(context.Background(), opts, nil) != nil {
+		t.Errorf("unexpected error without script error")
+	}
+
+	err := exec.Command("sh", "-c", "kill -9 $$").Run()
+	if e := limitError(context.Background(), opts, err); e == nil || !strings.Contains(e.Error(), "memory limit of 1GiB") {
+		t.Errorf("unexpected error for killed script: %v", e)
+	}
+	if e := limitError(context.Background(), types.Options{}, err); e != err {
+		t.Errorf("unexpected error without memory limit: %v", e)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	err = exec.CommandContext(ctx, "sleep", "10").Run()
+	if e := limitError(ctx, opts, err); e == nil || !strings.Contains(e.Error(), "timeout of 1s") {
+		t.Errorf("unexpected error after timeout: %v", e)
+	}
+}
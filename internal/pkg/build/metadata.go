@@ -15,9 +15,9 @@ import (
 	"strings"
 	"time"
 
+	"github.com/sylabs/singularity/internal/pkg/build/sbom"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	"github.com/sylabs/singularity/pkg/build/types"
-	"github.com/sylabs/singularity/pkg/sylog"
 )
 
 func (s *stage) insertMetadata() error {
@@ -56,16 +56,69 @@ func (s *stage) insertMetadata() error {
 		return fmt.Errorf("while inserting test script: %v", err)
 	}
 
+	// insert JSON objects written by %post
+	if err := insertJSONObjects(s.b); err != nil {
+		return fmt.Errorf("while inserting JSON objects: %v", err)
+	}
+
+	return nil
+}
+
+func insertJSONObjects(b *types.Bundle) error {
+	for name, path := range types.JSONObjectFiles {
+		data, err := ioutil.ReadFile(filepath.Join(b.RootfsPath, path))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if !json.Valid(data) {
+			return fmt.Errorf("%s is not a valid JSON file", path)
+		}
+		buildLog.Infof("Adding %s to container metadata", path)
+		b.JSONObjects[name] = data
+	}
+	return nil
+}
+
+// insertSBOM generates the software bill of materials of the root
+// filesystem in the format of the --sbom option, it is added to the
+// container metadata and to the JSON objects of the bundle.
+func insertSBOM(b *types.Bundle, name string) error {
+	if b.Opts.SBOM == "" {
+		return nil
+	}
+
+	buildLog.Infof("Generating %s SBOM", b.Opts.SBOM)
+	data, err := sbom.Generate(b.RootfsPath, b.Opts.SBOM, name)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(b.RootfsPath, types.SBOMFile)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	b.JSONObjects[types.SBOMJSON] = data
+
 	return nil
 }
 
 func insertEnvScript(b *types.Bundle) error {
 	if b.RunSection("environment") && b.Recipe.ImageData.Environment.Script != "" {
-		sylog.Infof("Adding environment to container")
+		env, err := types.ParseEnvironment(b.Recipe.ImageData.Environment)
+		if err != nil {
+			return fmt.Errorf("invalid %%environment section: %v", err)
+		}
+		for _, v := range env.Unexported() {
+			buildLog.Warningf("%%environment line %d: variable %s is not exported, it won't be set for the container processes", v.Line, v.Name)
+		}
+
+		buildLog.Infof("Adding environment to container")
 		envScriptPath := filepath.Join(b.RootfsPath, "/.singularity.d/env/90-environment.sh")
-		_, err := os.Stat(envScriptPath)
+		_, err = os.Stat(envScriptPath)
 		if os.IsNotExist(err) {
-			err := ioutil.WriteFile(envScriptPath, []byte("#!/bin/sh\n\n"+b.Recipe.ImageData.Environment.Script+"\n"), 0755)
+			err := ioutil.WriteFile(envScriptPath, []byte("#!/bin/sh\n\n"+env.Script+"\n"), 0755)
 			if err != nil {
 				return err
 			}
@@ -77,12 +130,38 @@ func insertEnvScript(b *types.Bundle) error {
 			}
 			defer f.Close()
 
-			_, err = f.WriteString("\n" + b.Recipe.ImageData.Environment.Script + "\n")
+			_, err = f.WriteString("\n" + env.Script + "\n")
 			if err != nil {
 				return err
 			}
 		}
+
+		return insertEnvironmentJSON(b, env)
+	}
+	return nil
+}
+
+// insertEnvironmentJSON stores the variables of the %environment section
+// in the container metadata, the variables of the base image environment
+// come first when the environment script is appended to.
+func insertEnvironmentJSON(b *types.Bundle, env *types.Environment) error {
+	path := filepath.Join(b.RootfsPath, types.EnvironmentFile)
+	if data, err := ioutil.ReadFile(path); err == nil {
+		var base types.Environment
+		if err := json.Unmarshal(data, &base); err != nil {
+			return fmt.Errorf("while reading %s: %v", types.EnvironmentFile, err)
+		}
+		env.Variables = append(base.Variables, env.Variables...)
+	}
+
+	data, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return err
 	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	b.JSONObjects[types.EnvironmentJSON] = data
 	return nil
 }
 
@@ -110,7 +189,7 @@ func handleShebangScript(s types.Script) (string, string) {
 
 func insertRunScript(b *types.Bundle) error {
 	if b.RunSection("runscript") && b.Recipe.ImageData.Runscript.Script != "" {
-		sylog.Infof("Adding runscript")
+		buildLog.Infof("Adding runscript")
 		shebang, script := handleShebangScript(b.Recipe.ImageData.Runscript)
 		err := ioutil.WriteFile(filepath.Join(b.RootfsPath, "/.singularity.d/runscript"), []byte(shebang+"\n\n"+script+"\n"), 0755)
 		if err != nil {
@@ -122,7 +201,7 @@ func insertRunScript(b *types.Bundle) error {
 
 func insertStartScript(b *types.Bundle) error {
 	if b.RunSection("startscript") && b.Recipe.ImageData.Startscript.Script != "" {
-		sylog.Infof("Adding startscript")
+		buildLog.Infof("Adding startscript")
 		shebang, script := handleShebangScript(b.Recipe.ImageData.Startscript)
 		err := ioutil.WriteFile(filepath.Join(b.RootfsPath, "/.singularity.d/startscript"), []byte(shebang+"\n\n"+script+"\n"), 0755)
 		if err != nil {
@@ -134,11 +213,17 @@ func insertStartScript(b *types.Bundle) error {
 
 func insertTestScript(b *types.Bundle) error {
 	if b.RunSection("test") && b.Recipe.ImageData.Test.Script != "" {
-		sylog.Infof("Adding testscript")
-		err := ioutil.WriteFile(filepath.Join(b.RootfsPath, "/.singularity.d/test"), []byte("#!/bin/sh\n\n"+b.Recipe.ImageData.Test.Script+"\n"), 0755)
+		opts, err := types.ParseTestOptions(b.Recipe.ImageData.Test.Args)
 		if err != nil {
 			return err
 		}
+		buildLog.Infof("Adding testscript")
+		path := filepath.Join(b.RootfsPath, "/.singularity.d/test")
+		err = ioutil.WriteFile(path, []byte("#!/bin/sh\n\n"+b.Recipe.ImageData.Test.Script+"\n"), 0755)
+		if err != nil {
+			return err
+		}
+		return types.WriteTestOptions(path, opts)
 	}
 	return nil
 }
@@ -147,13 +232,13 @@ func insertHelpScript(b *types.Bundle) error {
 	if b.RunSection("help") && b.Recipe.ImageData.Help.Script != "" {
 		_, err := os.Stat(filepath.Join(b.RootfsPath, "/.singularity.d/runscript.help"))
 		if err != nil || b.Opts.Force {
-			sylog.Infof("Adding help info")
+			buildLog.Infof("Adding help info")
 			err := ioutil.WriteFile(filepath.Join(b.RootfsPath, "/.singularity.d/runscript.help"), []byte(b.Recipe.ImageData.Help.Script+"\n"), 0644)
 			if err != nil {
 				return err
 			}
 		} else {
-			sylog.Warningf("Help message already exists and force option is false, not overwriting")
+			buildLog.Warningf("Help message already exists and force option is false, not overwriting")
 		}
 	}
 	return nil
@@ -210,7 +295,7 @@ func insertLabelsJSON(b *types.Bundle) (err error) {
 	}
 
 	if b.RunSection("labels") && len(b.Recipe.ImageData.Labels) > 0 {
-		sylog.Infof("Adding labels")
+		buildLog.Infof("Adding labels")
 
 		// add new labels to new map and check for collisions
 		for key, value := range b.Recipe.ImageData.Labels {
@@ -220,7 +305,7 @@ func insertLabelsJSON(b *types.Bundle) (err error) {
 				if b.Opts.Force {
 					labels[key] = value
 				} else {
-					sylog.Warningf("Label: %s already exists and force option is false, not overwriting", key)
+					buildLog.Warningf("Label: %s already exists and force option is false, not overwriting", key)
 				}
 			} else {
 				// set if it doesnt
@@ -278,6 +363,13 @@ func addBuildLabels(labels map[string]string, b *types.Bundle) error {
 	// singularity version
 	labels["org.label-schema.usage.singularity.version"] = buildcfg.PACKAGE_VERSION
 
+	// network of the %post and %test sections, none for hermetic builds
+	network := b.Opts.Network
+	if network == "" {
+		network = types.HostNetwork
+	}
+	labels["org.label-schema.usage.singularity.build-network"] = network
+
 	// help info if help exists in the definition and is run in the build
 	if b.RunSection("help") && b.Recipe.ImageData.Help.Script != "" {
 		labels["org.label-schema.usage"] = "/.singularity.d/runscript.help"
@@ -289,6 +381,10 @@ func addBuildLabels(labels map[string]string, b *types.Bundle) error {
 		for key, value := range b.Recipe.Header {
 			labels["org.label-schema.usage.singularity.deffile."+key] = value
 		}
+		// provenance of the fragments included in the definition
+		for i, inc := range b.Recipe.Includes {
+			labels["org.label-schema.usage.singularity.deffile.include."+strconv.Itoa(i+1)] = inc.Source + " " + inc.Digest
+		}
 	}
 
 	return nil
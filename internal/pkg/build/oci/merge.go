@@ -0,0 +1,172 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+const (
+	// whiteoutPrefix marks the files removed from the lower layers.
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaque marks the directories whose lower layers content
+	// is hidden.
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// LayerOpener returns the uncompressed tar stream of the layer i.
+type LayerOpener func(ctx context.Context, i int) (io.ReadCloser, error)
+
+// layerMerge tracks the paths of the layers already written.
+type layerMerge struct {
+	// seen maps the written paths to true for directories.
+	seen map[string]bool
+	// removed are the paths removed by whiteouts.
+	removed map[string]bool
+	// opaque are the directories whose lower content is hidden.
+	opaque map[string]bool
+}
+
+// MergeLayers writes to w the tar stream of the root filesystem made of n
+// layers, the layer 0 being the bottom one, without unpacking them. The
+// layers are read from the top one so that each path is written once with
+// its topmost content, and the whiteouts are resolved along the way: a
+// directory may then be written after its content, mksquashfs creates
+// the missing parent directories and sets their attributes once found.
+func MergeLayers(ctx context.Context, w io.Writer, n int, open LayerOpener) error {
+	m := &layerMerge{
+		seen:    make(map[string]bool),
+		removed: make(map[string]bool),
+		opaque:  make(map[string]bool),
+	}
+	tw := tar.NewWriter(w)
+
+	for i := n - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r, err := open(ctx, i)
+		if err != nil {
+			return fmt.Errorf("while opening layer %d: %v", i, err)
+		}
+		err = m.merge(tw, r)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("while merging layer %d: %v", i, err)
+		}
+	}
+	return tw.Close()
+}
+
+// merge writes the visible entries of the layer read from r to tw.
+func (m *layerMerge) merge(tw *tar.Writer, r io.Reader) error {
+	// the whiteouts of a layer only apply to the lower layers
+	removed := make(map[string]bool)
+	opaque := make(map[string]bool)
+	// files written from this layer, hard links are only valid if
+	// their target is
+	written := make(map[string]bool)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		name := cleanName(hdr.Name)
+		if name == "" {
+			continue
+		}
+
+		dir, base := path.Split(name)
+		dir = strings.TrimSuffix(dir, "/")
+		if base == whiteoutOpaque {
+			opaque[dir] = true
+			continue
+		} else if strings.HasPrefix(base, whiteoutPrefix) {
+			removed[path.Join(dir, strings.TrimPrefix(base, whiteoutPrefix))] = true
+			continue
+		}
+
+		if _, ok := m.seen[name]; ok || m.hidden(name) {
+			continue
+		}
+
+		if hdr.Typeflag == tar.TypeLink {
+			target := cleanName(hdr.Linkname)
+			if !written[target] {
+				sylog.Warningf("Skipping hard link /%s, its target /%s is overridden by an upper layer", name, target)
+				continue
+			}
+			hdr.Linkname = target
+		}
+		// sparse files are read back with their holes
+		if hdr.Typeflag == tar.TypeGNUSparse {
+			hdr.Typeflag = tar.TypeReg
+		}
+		for k := range hdr.PAXRecords {
+			if strings.HasPrefix(k, "GNU.sparse.") {
+				delete(hdr.PAXRecords, k)
+			}
+		}
+
+		hdr.Name = name
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA {
+			if _, err := io.Copy(tw, tr); err != nil {
+				return err
+			}
+		}
+		written[name] = true
+		m.seen[name] = hdr.Typeflag == tar.TypeDir
+	}
+
+	for p := range removed {
+		m.removed[p] = true
+	}
+	for p := range opaque {
+		m.opaque[p] = true
+	}
+	return nil
+}
+
+// hidden returns true if name is hidden by the upper layers: it's removed,
+// it's in an opaque directory, or one of its parents isn't a directory.
+func (m *layerMerge) hidden(name string) bool {
+	if m.removed[name] || m.opaque[""] {
+		return true
+	}
+	for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+		if m.removed[dir] || m.opaque[dir] {
+			return true
+		}
+		if isDir, ok := m.seen[dir]; ok && !isDir {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanName returns the path of a tar entry relative to the root
+// filesystem, empty for the root directory.
+func cleanName(name string) string {
+	return strings.TrimPrefix(path.Clean("/"+name), "/")
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	content  string
+}
+
+func makeLayer(t *testing.T, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Mode: 0644}
+		switch e.typeflag {
+		case tar.TypeDir:
+			hdr.Mode = 0755
+		case tar.TypeSymlink, tar.TypeLink:
+			hdr.Linkname = e.content
+		case tar.TypeReg:
+			hdr.Size = int64(len(e.content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if e.typeflag == tar.TypeReg {
+			if _, err := tw.Write([]byte(e.content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMergeLayers(t *testing.T) {
+	layers := [][]tarEntry{
+		{
+			{"./", tar.TypeDir, ""},
+			{"./etc/", tar.TypeDir, ""},
+			{"./etc/os-release", tar.TypeReg, "ID=alpine"},
+			{"./etc/passwd", tar.TypeReg, "root:x:0:0"},
+			{"./opt/", tar.TypeDir, ""},
+			{"./opt/app/", tar.TypeDir, ""},
+			{"./opt/app/v1", tar.TypeReg, "v1"},
+			{"./usr/", tar.TypeDir, ""},
+			{"./usr/lib/", tar.TypeDir, ""},
+			{"./usr/lib/libc.so", tar.TypeReg, "libc"},
+			{"./usr/lib/libc.so.1", tar.TypeLink, "usr/lib/libc.so"},
+			{"./var/", tar.TypeDir, ""},
+			{"./var/cache/", tar.TypeDir, ""},
+			{"./var/cache/apk", tar.TypeReg, "index"},
+			{"./lib", tar.TypeDir, ""},
+			{"./lib/ld.so", tar.TypeReg, "ld"},
+		},
+		{
+			{"etc/", tar.TypeDir, ""},
+			{"etc/os-release", tar.TypeReg, "ID=custom"},
+			{"etc/.wh.passwd", tar.TypeReg, ""},
+			{"opt/app/", tar.TypeDir, ""},
+			{"opt/app/.wh..wh..opq", tar.TypeReg, ""},
+			{"opt/app/v2", tar.TypeReg, "v2"},
+			{"usr/lib/libc.so", tar.TypeReg, "libc2"},
+			{".wh.var", tar.TypeReg, ""},
+			{"lib", tar.TypeSymlink, "usr/lib"},
+		},
+	}
+
+	open := func(ctx context.Context, i int) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(makeLayer(t, layers[i]))), nil
+	}
+
+	var buf bytes.Buffer
+	if err := MergeLayers(context.Background(), &buf, len(layers), open); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var got []tarEntry
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		e := tarEntry{name: hdr.Name, typeflag: hdr.Typeflag, content: hdr.Linkname}
+		if hdr.Typeflag == tar.TypeReg {
+			b, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.content = string(b)
+		}
+		got = append(got, e)
+	}
+
+	// the upper layer entries come first, the hard link of the
+	// overridden libc.so is dropped
+	want := []tarEntry{
+		{"etc/", tar.TypeDir, ""},
+		{"etc/os-release", tar.TypeReg, "ID=custom"},
+		{"opt/app/", tar.TypeDir, ""},
+		{"opt/app/v2", tar.TypeReg, "v2"},
+		{"usr/lib/libc.so", tar.TypeReg, "libc2"},
+		{"lib", tar.TypeSymlink, "usr/lib"},
+		{"opt/", tar.TypeDir, ""},
+		{"usr/", tar.TypeDir, ""},
+		{"usr/lib/", tar.TypeDir, ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected merged layers:\ngot  %v\nwant %v", got, want)
+	}
+}
+
+func TestMergeLayersOpaqueRoot(t *testing.T) {
+	layers := [][]tarEntry{
+		{{"bin/", tar.TypeDir, ""}, {"bin/sh", tar.TypeReg, "sh"}},
+		{{".wh..wh..opq", tar.TypeReg, ""}, {"app", tar.TypeReg, "app"}},
+	}
+	open := func(ctx context.Context, i int) (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(makeLayer(t, layers[i]))), nil
+	}
+
+	var buf bytes.Buffer
+	if err := MergeLayers(context.Background(), &buf, len(layers), open); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tr := tar.NewReader(&buf)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"app"}) {
+		t.Errorf("unexpected entries %v", names)
+	}
+}
@@ -14,19 +14,34 @@ import (
 	"strings"
 
 	"github.com/containers/image/v5/copy"
+	"github.com/containers/image/v5/manifest"
 	"github.com/containers/image/v5/oci/layout"
 	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/storage"
 	"github.com/containers/image/v5/transports"
 	"github.com/containers/image/v5/types"
 	"github.com/pkg/errors"
+	"github.com/sylabs/singularity/internal/pkg/build/events"
 	"github.com/sylabs/singularity/internal/pkg/cache"
 	"github.com/sylabs/singularity/pkg/sylog"
 )
 
+// PodmanTransport is an alias of the containers-storage transport, the
+// podman://alpine URI references the containers-storage:alpine image.
+const PodmanTransport = "podman"
+
 // ImageReference wraps containers/image ImageReference type
 type ImageReference struct {
 	source types.ImageReference
 	types.ImageReference
+	// Progress reports the progress of the blobs fetched into the
+	// cache if set.
+	Progress chan types.ProgressProperties
+	// Downloads is the number of layers fetched concurrently into the
+	// cache, the image copy fetches them if less than 2.
+	Downloads int
+	// dir is the cache layout directory.
+	dir string
 }
 
 // ConvertReference converts a source reference into a cache.ImageReference to cache its blobs
@@ -55,6 +70,7 @@ func ConvertReference(ctx context.Context, imgCache *cache.Handle, src types.Ima
 	return &ImageReference{
 		source:         src,
 		ImageReference: c,
+		dir:            cacheDir,
 	}, nil
 
 }
@@ -71,10 +87,18 @@ func (t *ImageReference) newImageSource(ctx context.Context, sys *types.SystemCo
 		return nil, err
 	}
 
+	if t.Downloads > 1 {
+		if err := t.prefetchLayers(ctx, sys); err != nil {
+			return nil, err
+		}
+	}
+
 	// First we are fetching into the cache
 	_, err = copy.Image(ctx, policyCtx, t.ImageReference, t.source, &copy.Options{
-		ReportWriter: w,
-		SourceCtx:    sys,
+		ReportWriter:     w,
+		SourceCtx:        sys,
+		Progress:         t.Progress,
+		ProgressInterval: events.ProgressInterval,
 	})
 	if err != nil {
 		return nil, err
@@ -101,6 +125,11 @@ func parseURI(uri string) (types.ImageReference, error) {
 		return nil, fmt.Errorf("%s not in transport:reference pair", uri)
 	}
 
+	if split[0] == PodmanTransport {
+		split[0] = storage.Transport.Name()
+		split[1] = strings.TrimPrefix(split[1], "//")
+	}
+
 	transport := transports.Get(split[0])
 	if transport == nil {
 		return nil, fmt.Errorf("%s not a registered transport", split[0])
@@ -130,11 +159,27 @@ func calculateRefHash(ctx context.Context, ref types.ImageReference, sys *types.
 		}
 	}()
 
-	man, _, err := source.GetManifest(ctx, nil)
+	man, mimeType, err := source.GetManifest(ctx, nil)
 	if err != nil {
 		return "", err
 	}
 
+	// the hash of manifest lists depends on the selected image
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		list, err := manifest.ListFromBlob(man, mimeType)
+		if err != nil {
+			return "", err
+		}
+		instance, err := list.ChooseInstance(sys)
+		if err != nil {
+			return "", fmt.Errorf("while selecting image platform: %v", err)
+		}
+		man, _, err = source.GetManifest(ctx, &instance)
+		if err != nil {
+			return "", err
+		}
+	}
+
 	hash = fmt.Sprintf("%x", sha256.Sum256(man))
 	return hash, nil
 }
@@ -0,0 +1,173 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/types"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sylabs/singularity/internal/pkg/build/events"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// prefetchLayers downloads the layers of the source image missing from the
+// cache with at most t.Downloads concurrent downloads, the image copy into
+// the cache then reuses them.
+func (t *ImageReference) prefetchLayers(ctx context.Context, sys *types.SystemContext) error {
+	src, err := t.source.NewImageSource(ctx, sys)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// sources like archives are read sequentially
+	if !src.HasThreadSafeGetBlob() {
+		return nil
+	}
+
+	img, err := image.FromUnparsedImage(ctx, sys, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return err
+	}
+
+	var layers []types.BlobInfo
+	seen := make(map[digest.Digest]bool)
+	for _, l := range img.LayerInfos() {
+		if seen[l.Digest] {
+			continue
+		}
+		seen[l.Digest] = true
+		if _, err := os.Stat(t.blobPath(l.Digest)); err == nil {
+			continue
+		}
+		layers = append(layers, l)
+	}
+	if len(layers) < 2 {
+		return nil
+	}
+
+	workers := t.Downloads
+	if workers > len(layers) {
+		workers = len(layers)
+	}
+	sylog.Debugf("Fetching %d layers with %d concurrent downloads", len(layers), workers)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan types.BlobInfo)
+	errs := make(chan error, len(layers))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for l := range jobs {
+				if err := t.fetchBlob(ctx, src, l); err != nil {
+					errs <- fmt.Errorf("while fetching layer %s: %v", l.Digest, err)
+					// abort the other downloads
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for _, l := range layers {
+		select {
+		case jobs <- l:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// blobPath returns the path of the blob d in the cache layout.
+func (t *ImageReference) blobPath(d digest.Digest) string {
+	return filepath.Join(t.dir, "blobs", d.Algorithm().String(), d.Hex())
+}
+
+// fetchBlob writes the blob l of src in the cache layout once its digest
+// is verified.
+func (t *ImageReference) fetchBlob(ctx context.Context, src types.ImageSource, l types.BlobInfo) error {
+	if err := l.Digest.Validate(); err != nil {
+		return err
+	}
+
+	r, _, err := src.GetBlob(ctx, l, none.NoCache)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	path := t.blobPath(l.Digest)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "prefetch-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	p := t.progressReader(r, l)
+	verifier := l.Digest.Verifier()
+	if _, err := io.Copy(io.MultiWriter(f, verifier), p); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("digest mismatch")
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// progressReader returns a reader reporting its progress to t.Progress
+// if set, as the image copy reports the blobs it fetches.
+func (t *ImageReference) progressReader(r io.Reader, l types.BlobInfo) io.Reader {
+	if t.Progress == nil {
+		return r
+	}
+	t.Progress <- types.ProgressProperties{Event: types.ProgressEventNewArtifact, Artifact: l}
+	return &progressReader{r: r, blob: l, c: t.Progress}
+}
+
+type progressReader struct {
+	r      io.Reader
+	blob   types.BlobInfo
+	c      chan types.ProgressProperties
+	offset uint64
+	last   time.Time
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.offset += uint64(n)
+	if n > 0 && time.Since(p.last) >= events.ProgressInterval {
+		p.last = time.Now()
+		p.c <- types.ProgressProperties{Event: types.ProgressEventRead, Artifact: p.blob, Offset: p.offset}
+	}
+	return n, err
+}
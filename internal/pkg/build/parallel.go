@@ -0,0 +1,201 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// stageDependencies returns the indexes of the stages each stage copies
+// files from with %files from <stage>, they must be defined before it.
+func stageDependencies(stages []stage) ([][]int, error) {
+	index := make(map[string]int)
+	deps := make([][]int, len(stages))
+
+	for i, s := range stages {
+		seen := make(map[int]bool)
+		for _, f := range s.b.Recipe.BuildData.Files {
+			args := strings.Fields(f.Args)
+			if len(args) != 2 {
+				continue
+			}
+			j, ok := index[args[1]]
+			if !ok {
+				for _, other := range stages[i:] {
+					if other.name == args[1] {
+						return nil, fmt.Errorf("stage %s copies files from stage %s which isn't defined before it", stageLabel(s, i), args[1])
+					}
+				}
+				return nil, fmt.Errorf("stage %s was not found", args[1])
+			}
+			if !seen[j] {
+				seen[j] = true
+				deps[i] = append(deps[i], j)
+			}
+		}
+		if s.name != "" {
+			index[s.name] = i
+		}
+	}
+	return deps, nil
+}
+
+// stageLabel returns the name of the stage or its number if unnamed.
+func stageLabel(s stage, i int) string {
+	if s.name != "" {
+		return s.name
+	}
+	return "#" + strconv.Itoa(i+1)
+}
+
+// runStages calls run for each stage once the stages it depends on
+// succeeded, with at most parallel stages running concurrently. The
+// stages run one after the other in order if parallel is lower than 2.
+// The first error cancels the running stages and is returned.
+func runStages(ctx context.Context, parallel int, deps [][]int, run func(context.Context, int) error) error {
+	if parallel < 2 {
+		for i := range deps {
+			if err := run(ctx, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make([]chan struct{}, len(deps))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+	slots := make(chan struct{}, parallel)
+
+	var once sync.Once
+	var firstErr error
+
+	var wg sync.WaitGroup
+	for i := range deps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			for _, d := range deps[i] {
+				select {
+				case <-done[d]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-slots }()
+			if ctx.Err() != nil {
+				return
+			}
+
+			if err := run(ctx, i); err != nil {
+				once.Do(func() {
+					firstErr = err
+					// abort the other stages
+					cancel()
+				})
+				return
+			}
+			close(done[i])
+		}(i)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
+	return ctx.Err()
+}
+
+// stageOutput buffers the messages and the sections output of a stage
+// built concurrently with other stages.
+type stageOutput struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	log *sylog.SubLogger
+}
+
+// Write appends p to the stage output.
+func (o *stageOutput) Write(p []byte) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.buf.Write(p)
+}
+
+// stageLogs writes the output of the stages in the stage order, the
+// output of a stage is written once it completed and the output of the
+// previous stages is written.
+type stageLogs struct {
+	mu      sync.Mutex
+	w       io.Writer
+	outputs []*stageOutput
+	done    []bool
+	next    int
+}
+
+// newStageLogs sets the buffered output of the stages, it's written
+// to w.
+func newStageLogs(stages []stage, w io.Writer) *stageLogs {
+	l := &stageLogs{
+		w:       w,
+		outputs: make([]*stageOutput, len(stages)),
+		done:    make([]bool, len(stages)),
+	}
+	for i := range stages {
+		o := &stageOutput{}
+		o.log = sylog.NewLogger(o, sylog.GetLevel(), false).NewSubLogger("build")
+		stages[i].output = o
+		l.outputs[i] = o
+	}
+	return l
+}
+
+// finish marks the stage i as completed and writes the output of the
+// completed stages which are next in order.
+func (l *stageLogs) finish(i int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.done[i] = true
+	for l.next < len(l.done) && l.done[l.next] {
+		l.write(l.next)
+		l.next++
+	}
+}
+
+// flush writes the output left, stages which didn't run have none.
+func (l *stageLogs) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for ; l.next < len(l.outputs); l.next++ {
+		l.write(l.next)
+	}
+}
+
+func (l *stageLogs) write(i int) {
+	o := l.outputs[i]
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.buf.WriteTo(l.w)
+}
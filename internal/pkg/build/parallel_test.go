@@ -0,0 +1,180 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+// testStage returns a stage copying files from the stages from.
+func testStage(name string, from ...string) stage {
+	d := types.Definition{Header: map[string]string{"bootstrap": "scratch"}}
+	for _, f := range from {
+		d.BuildData.Files = append(d.BuildData.Files, types.Files{Args: "from " + f})
+	}
+	return stage{name: name, b: &types.Bundle{Recipe: d}}
+}
+
+func TestStageDependencies(t *testing.T) {
+	tests := []struct {
+		name   string
+		stages []stage
+		deps   [][]int
+		err    string
+	}{
+		{
+			name:   "builder tester",
+			stages: []stage{testStage("builder"), testStage("tester"), testStage("", "builder", "tester", "builder")},
+			deps:   [][]int{nil, nil, {0, 1}},
+		},
+		{
+			name:   "chain",
+			stages: []stage{testStage("one"), testStage("two", "one"), testStage("three", "two")},
+			deps:   [][]int{nil, {0}, {1}},
+		},
+		{
+			name:   "later stage",
+			stages: []stage{testStage("one", "two"), testStage("two")},
+			err:    "stage one copies files from stage two which isn't defined before it",
+		},
+		{
+			name:   "unknown stage",
+			stages: []stage{testStage("one"), testStage("two", "three")},
+			err:    "stage three was not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps, err := stageDependencies(tt.stages)
+			if tt.err != "" {
+				if err == nil || err.Error() != tt.err {
+					t.Fatalf("unexpected error %v instead of %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(deps, tt.deps) {
+				t.Errorf("unexpected dependencies %v instead of %v", deps, tt.deps)
+			}
+		})
+	}
+}
+
+func TestRunStages(t *testing.T) {
+	// 0 and 1 are independent, 2 depends on both and 3 on 2
+	deps := [][]int{nil, nil, {0, 1}, {2}}
+
+	for _, parallel := range []int{1, 2, 4} {
+		t.Run(fmt.Sprintf("parallel %d", parallel), func(t *testing.T) {
+			var mu sync.Mutex
+			var order []int
+			running, max := 0, 0
+			// the independent stages wait for each other when
+			// built concurrently
+			var started sync.WaitGroup
+			started.Add(2)
+
+			err := runStages(context.Background(), parallel, deps, func(ctx context.Context, i int) error {
+				mu.Lock()
+				running++
+				if running > max {
+					max = running
+				}
+				mu.Unlock()
+
+				if parallel > 1 && i < 2 {
+					started.Done()
+					started.Wait()
+				}
+
+				mu.Lock()
+				running--
+				order = append(order, i)
+				mu.Unlock()
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if len(order) != 4 || order[2] != 2 || order[3] != 3 {
+				t.Errorf("unexpected stage order %v", order)
+			}
+			expected := 2
+			if parallel == 1 {
+				expected = 1
+				if !reflect.DeepEqual(order, []int{0, 1, 2, 3}) {
+					t.Errorf("unexpected sequential stage order %v", order)
+				}
+			}
+			if max != expected {
+				t.Errorf("unexpected %d stages running concurrently instead of %d", max, expected)
+			}
+		})
+	}
+
+	t.Run("failure", func(t *testing.T) {
+		var mu sync.Mutex
+		var ran []int
+
+		err := runStages(context.Background(), 2, deps, func(ctx context.Context, i int) error {
+			mu.Lock()
+			ran = append(ran, i)
+			mu.Unlock()
+
+			if i == 0 {
+				return fmt.Errorf("stage failed")
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		})
+		if err == nil || err.Error() != "stage failed" {
+			t.Errorf("unexpected error %v", err)
+		}
+		for _, i := range ran {
+			if i > 1 {
+				t.Errorf("stage %d ran after the failure of the stage it depends on", i)
+			}
+		}
+	})
+}
+
+func TestStageLogs(t *testing.T) {
+	stages := []stage{testStage("one"), testStage("two"), testStage("three")}
+
+	var b bytes.Buffer
+	logs := newStageLogs(stages, &b)
+	for i := range stages {
+		if stages[i].output == nil {
+			t.Fatalf("stage %d has no buffered output", i)
+		}
+		fmt.Fprintf(stages[i].stdout(), "output of %s\n", stages[i].name)
+	}
+
+	logs.finish(1)
+	if b.Len() != 0 {
+		t.Errorf("output of stage two written before stage one completed: %q", b.String())
+	}
+	logs.finish(0)
+	if b.String() != "output of one\noutput of two\n" {
+		t.Errorf("unexpected output %q", b.String())
+	}
+	logs.flush()
+	if !strings.HasSuffix(b.String(), "output of three\n") {
+		t.Errorf("unexpected output %q", b.String())
+	}
+}
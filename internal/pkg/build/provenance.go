@@ -0,0 +1,107 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+// provenance describes how an image was built, it is stored in the image
+// metadata along with the build log.
+type provenance struct {
+	Builder    provenanceBuilder    `json:"builder"`
+	Started    time.Time            `json:"started"`
+	Finished   time.Time            `json:"finished"`
+	Definition provenanceDefinition `json:"definition"`
+	Platform   string               `json:"platform,omitempty"`
+	Stages     []provenanceStage    `json:"stages"`
+}
+
+type provenanceBuilder struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Go      string `json:"go"`
+}
+
+// provenanceDefinition is the definition rendered once the includes
+// and the build arguments are resolved, as stored in the image.
+type provenanceDefinition struct {
+	Digest   string          `json:"digest"`
+	Includes []types.Include `json:"includes,omitempty"`
+}
+
+type provenanceStage struct {
+	Name      string           `json:"name,omitempty"`
+	Bootstrap string           `json:"bootstrap"`
+	From      string           `json:"from,omitempty"`
+	BaseImage *types.BaseImage `json:"baseImage,omitempty"`
+}
+
+// provenance returns the provenance of the build started at started.
+func (b *Build) provenance(started time.Time) provenance {
+	last := b.stages[len(b.stages)-1].b
+
+	p := provenance{
+		Builder: provenanceBuilder{
+			Name:    "singularity",
+			Version: buildcfg.PACKAGE_VERSION,
+			Go:      runtime.Version(),
+		},
+		Started:  started.UTC(),
+		Finished: time.Now().UTC(),
+		Definition: provenanceDefinition{
+			Digest:   digest.FromBytes(last.Recipe.Raw).String(),
+			Includes: last.Recipe.Includes,
+		},
+		Platform: last.Opts.Platform,
+	}
+	for _, s := range b.stages {
+		p.Stages = append(p.Stages, provenanceStage{
+			Name:      s.name,
+			Bootstrap: s.b.Recipe.Header["bootstrap"],
+			From:      s.b.Recipe.Header["from"],
+			BaseImage: s.b.BaseImage,
+		})
+	}
+	return p
+}
+
+// insertProvenance adds the build provenance and the build log to the
+// container metadata and to the objects of the bundle b, the log is
+// stored in a generic SIF descriptor.
+func insertProvenance(b *types.Bundle, p provenance, log []byte) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(b.RootfsPath, types.ProvenanceFile)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	b.JSONObjects[types.ProvenanceJSON] = data
+
+	if log == nil {
+		return nil
+	}
+	path = filepath.Join(b.RootfsPath, types.BuildLogFile)
+	if err := ioutil.WriteFile(path, log, 0644); err != nil {
+		return err
+	}
+	if b.GenericObjects == nil {
+		b.GenericObjects = make(map[string][]byte)
+	}
+	b.GenericObjects[types.BuildLogObject] = log
+
+	return nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+func TestCaptureLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build-log-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c, err := captureLog(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.cleanUp()
+
+	os.Stderr.WriteString("\x1b[34mINFO:   \x1b[0m Starting build...\n")
+	cmd := exec.Command("/bin/sh", "-c", "echo %post output")
+	cmd.Stdout = os.Stdout
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	log, err := c.log()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"INFO:    Starting build...\n", "%post output\n"} {
+		if !strings.Contains(string(log), want) {
+			t.Errorf("build log doesn't contain %q: %q", want, log)
+		}
+	}
+
+	c.cleanUp()
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Errorf("build log file not removed")
+	}
+}
+
+func TestInsertProvenance(t *testing.T) {
+	dir, err := ioutil.TempDir("", "provenance-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.MkdirAll(filepath.Join(dir, ".singularity.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	raw := []byte("Bootstrap: docker\nFrom: alpine\n")
+	base := &types.BaseImage{Source: "docker://alpine", Digest: "sha256:21a3deaa0d32a8057914f36584b5288d2e5ecc984380bc0118285c70fa8c9300"}
+	b := &Build{
+		stages: []stage{{
+			name: "final",
+			b: &types.Bundle{
+				RootfsPath:  dir,
+				JSONObjects: make(map[string][]byte),
+				Recipe: types.Definition{
+					Header: map[string]string{"bootstrap": "docker", "from": "alpine"},
+					Raw:    raw,
+				},
+				BaseImage: base,
+			},
+		}},
+	}
+
+	last := b.stages[0].b
+	if err := insertProvenance(last, b.provenance(time.Now()), []byte("build output\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var p provenance
+	if err := json.Unmarshal(last.JSONObjects[types.ProvenanceJSON], &p); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if p.Builder.Name != "singularity" || p.Builder.Version == "" {
+		t.Errorf("unexpected builder %+v", p.Builder)
+	}
+	if p.Definition.Digest != digest.FromBytes(raw).String() {
+		t.Errorf("unexpected definition digest %s", p.Definition.Digest)
+	}
+	if len(p.Stages) != 1 || p.Stages[0].Name != "final" || *p.Stages[0].BaseImage != *base {
+		t.Errorf("unexpected stages %+v", p.Stages)
+	}
+
+	if string(last.GenericObjects[types.BuildLogObject]) != "build output\n" {
+		t.Errorf("unexpected build log %q", last.GenericObjects[types.BuildLogObject])
+	}
+	for _, path := range []string{types.ProvenanceFile, types.BuildLogFile} {
+		if _, err := os.Stat(filepath.Join(dir, path)); err != nil {
+			t.Errorf("%s not written to the container: %v", path, err)
+		}
+	}
+}
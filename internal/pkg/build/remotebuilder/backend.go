@@ -0,0 +1,142 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package remotebuilder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultBackend is the type of the backend used when an endpoint
+// doesn't configure one.
+const DefaultBackend = "scs"
+
+// Request holds the build request submitted to a backend.
+type Request struct {
+	// Definition is the raw definition file to build.
+	Definition []byte
+	// LibraryRef is the library reference the image is pushed to, builds
+	// without library reference are downloaded once complete.
+	LibraryRef string
+	// LibraryURL is the library service the image is pushed to.
+	LibraryURL string
+	// Requirements are the builder requirements, eg: arch.
+	Requirements map[string]string
+}
+
+// Job identifies a build submitted to a backend.
+type Job struct {
+	ID         string
+	LibraryRef string
+	LibraryURL string
+	// Requirements are the builder requirements of the build request.
+	Requirements map[string]string
+}
+
+// Status is the status of a build.
+type Status struct {
+	Complete  bool
+	ImageSize int64
+	// ImageDigest is the digest of the built image (eg: sha256:<hex>)
+	// verified once downloaded, it is empty when not provided.
+	ImageDigest string
+	LibraryRef  string
+	LibraryURL  string
+}
+
+// Backend is a remote build service.
+type Backend interface {
+	// Submit submits the build request.
+	Submit(ctx context.Context, r Request) (Job, error)
+	// Logs writes the build output from offset bytes to w, it returns
+	// once the build output ended.
+	Logs(ctx context.Context, j Job, offset int64, w io.Writer) error
+	// Status returns the status of the build.
+	Status(ctx context.Context, j Job) (Status, error)
+	// Download writes the built image to w.
+	Download(ctx context.Context, j Job, w io.Writer) error
+	// Cancel cancels the build.
+	Cancel(ctx context.Context, j Job) error
+}
+
+// BackendConfig is the configuration of a backend.
+type BackendConfig struct {
+	// URL is the base URL of the build service.
+	URL       string
+	AuthToken string
+}
+
+// NewBackendFunc returns a backend for the configuration.
+type NewBackendFunc func(BackendConfig) (Backend, error)
+
+var (
+	backendsMu sync.Mutex
+	backends   = map[string]NewBackendFunc{
+		DefaultBackend: newSCSBackend,
+		"http":         newHTTPBackend,
+	}
+)
+
+// RegisterBackend registers the backend type name, it returns an error if
+// the type is already registered.
+func RegisterBackend(name string, fn NewBackendFunc) error {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	if _, ok := backends[name]; ok {
+		return fmt.Errorf("remote build backend %s is already registered", name)
+	}
+	backends[name] = fn
+	return nil
+}
+
+// NewBackend returns a backend of the registered type name, the default
+// backend is returned for an empty name.
+func NewBackend(name string, cfg BackendConfig) (Backend, error) {
+	if name == "" {
+		name = DefaultBackend
+	}
+
+	backendsMu.Lock()
+	fn, ok := backends[name]
+	backendsMu.Unlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown remote build backend %s, available backends are: %s", name, strings.Join(Backends(), ", "))
+	}
+	return fn(cfg)
+}
+
+// IsBackend returns whether name is a registered backend type, an empty
+// name designates the default backend.
+func IsBackend(name string) bool {
+	if name == "" {
+		return true
+	}
+
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	_, ok := backends[name]
+	return ok
+}
+
+// Backends returns the sorted names of the registered backend types.
+func Backends() []string {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+
+	names := make([]string, 0, len(backends))
+	for name := range backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package remotebuilder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	buildclient "github.com/sylabs/scs-build-client/client"
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
+)
+
+// requestTimeout is the timeout of the HTTP backend requests, except for
+// the log streaming and image download bounded by the build context.
+const requestTimeout = 30 * time.Second
+
+// httpBackend is a plain HTTP build service for site-local builders, it
+// reuses the JSON objects of the Sylabs Cloud build service API:
+//
+//	POST /v1/build                 submits a BuildRequest, returns 201 and a BuildInfo
+//	GET  /v1/build/<id>            returns the BuildInfo of the build
+//	GET  /v1/build/<id>/log?offset streams the build output from offset bytes
+//	GET  /v1/build/<id>/image      downloads the built image
+//	PUT  /v1/build/<id>/_cancel    cancels the build, returns 204
+//
+// The built image is served by the builder, the BuildInfo imageChecksum
+// is its digest.
+type httpBackend struct {
+	baseURL   *url.URL
+	authToken string
+	client    *http.Client
+}
+
+func newHTTPBackend(cfg BackendConfig) (Backend, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("unsupported protocol scheme %q", u.Scheme)
+	}
+	// API paths are relative to the base URL path
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return &httpBackend{baseURL: u, authToken: cfg.AuthToken, client: &http.Client{}}, nil
+}
+
+// do sends the request and returns the response if its status is the
+// expected status.
+func (b *httpBackend) do(ctx context.Context, method, path string, query url.Values, body io.Reader, status int) (*http.Response, error) {
+	u := b.baseURL.ResolveReference(&url.URL{Path: path, RawQuery: query.Encode()})
+
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if b.authToken != "" {
+		req.Header.Set("Authorization", "Bearer "+b.authToken)
+	}
+	req.Header.Set("User-Agent", useragent.Value())
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	res, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != status {
+		defer res.Body.Close()
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 4096))
+		return nil, fmt.Errorf("%s %s: http status %d: %s", method, u.Path, res.StatusCode, bytes.TrimSpace(msg))
+	}
+	return res, nil
+}
+
+// buildInfo sends the request and decodes the BuildInfo response.
+func (b *httpBackend) buildInfo(ctx context.Context, method, path string, body io.Reader, status int) (buildclient.BuildInfo, error) {
+	var bi buildclient.BuildInfo
+
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	res, err := b.do(ctx, method, path, nil, body, status)
+	if err != nil {
+		return bi, err
+	}
+	defer res.Body.Close()
+
+	if err := json.NewDecoder(res.Body).Decode(&bi); err != nil {
+		return bi, fmt.Errorf("while decoding build information: %v", err)
+	}
+	return bi, nil
+}
+
+func (b *httpBackend) Submit(ctx context.Context, r Request) (Job, error) {
+	data, err := json.Marshal(buildclient.BuildRequest{
+		LibraryRef:          r.LibraryRef,
+		LibraryURL:          r.LibraryURL,
+		DefinitionRaw:       r.Definition,
+		BuilderRequirements: r.Requirements,
+	})
+	if err != nil {
+		return Job{}, err
+	}
+
+	bi, err := b.buildInfo(ctx, http.MethodPost, "v1/build", bytes.NewReader(data), http.StatusCreated)
+	if err != nil {
+		return Job{}, err
+	}
+	return Job{
+		ID:           bi.ID,
+		LibraryRef:   bi.LibraryRef,
+		LibraryURL:   bi.LibraryURL,
+		Requirements: r.Requirements,
+	}, nil
+}
+
+func (b *httpBackend) Logs(ctx context.Context, j Job, offset int64, w io.Writer) error {
+	query := url.Values{"offset": []string{strconv.FormatInt(offset, 10)}}
+	res, err := b.do(ctx, http.MethodGet, "v1/build/"+j.ID+"/log", query, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+func (b *httpBackend) Status(ctx context.Context, j Job) (Status, error) {
+	bi, err := b.buildInfo(ctx, http.MethodGet, "v1/build/"+j.ID, nil, http.StatusOK)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{
+		Complete:    bi.IsComplete,
+		ImageSize:   bi.ImageSize,
+		ImageDigest: bi.ImageChecksum,
+		LibraryRef:  bi.LibraryRef,
+		LibraryURL:  bi.LibraryURL,
+	}, nil
+}
+
+func (b *httpBackend) Download(ctx context.Context, j Job, w io.Writer) error {
+	res, err := b.do(ctx, http.MethodGet, "v1/build/"+j.ID+"/image", nil, nil, http.StatusOK)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(w, res.Body)
+	return err
+}
+
+func (b *httpBackend) Cancel(ctx context.Context, j Job) error {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+
+	res, err := b.do(ctx, http.MethodPut, "v1/build/"+j.ID+"/_cancel", nil, nil, http.StatusNoContent)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
@@ -14,61 +14,57 @@ package remotebuilder
 import (
 	"context"
 	"fmt"
-	"net/http"
-	"net/url"
+	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
-	golog "github.com/go-log/log"
-	"github.com/gorilla/websocket"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
-	buildclient "github.com/sylabs/scs-build-client/client"
 	client "github.com/sylabs/scs-library-client/client"
-	"github.com/sylabs/singularity/internal/pkg/client/library"
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/sylog"
-	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
 )
 
 // CloudURI holds the URI of the Library web front-end.
 const CloudURI = "https://cloud.sylabs.io"
 
+// logRetries is the number of attempts to resume the build output
+// streaming without progress before giving up.
+const logRetries = 5
+
+// logRetryDelay is multiplied by the attempt number to wait before
+// resuming the build output streaming.
+var logRetryDelay = time.Second
+
 // RemoteBuilder contains the build request and response
 type RemoteBuilder struct {
-	BuildClient         *buildclient.Client
+	Backend             Backend
 	ImagePath           string
 	LibraryURL          string
 	Definition          types.Definition
-	BuilderURL          *url.URL
-	AuthToken           string
 	Force               bool
 	IsDetached          bool
 	BuilderRequirements map[string]string
 }
 
-// New creates a RemoteBuilder with the specified details.
-func New(imagePath, libraryURL string, d types.Definition, isDetached, force bool, builderAddr, authToken, buildArch string) (rb *RemoteBuilder, err error) {
-	bc, err := buildclient.New(&buildclient.Config{
-		BaseURL:   builderAddr,
-		AuthToken: authToken,
-		UserAgent: useragent.Value(),
-		HTTPClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
-	})
+// New creates a RemoteBuilder with the specified details, builderType
+// selects the backend of the build service at builderAddr.
+func New(imagePath, libraryURL string, d types.Definition, isDetached, force bool, builderType, builderAddr, authToken, buildArch string) (rb *RemoteBuilder, err error) {
+	backend, err := NewBackend(builderType, BackendConfig{URL: builderAddr, AuthToken: authToken})
 	if err != nil {
 		return nil, err
 	}
 
 	return &RemoteBuilder{
-		BuildClient: bc,
-		ImagePath:   imagePath,
-		Force:       force,
-		LibraryURL:  libraryURL,
-		Definition:  d,
-		IsDetached:  isDetached,
-		AuthToken:   authToken,
+		Backend:    backend,
+		ImagePath:  imagePath,
+		Force:      force,
+		LibraryURL: libraryURL,
+		Definition: d,
+		IsDetached: isDetached,
 		// TODO - set RAM requirements, singularity version, etc.
 		BuilderRequirements: map[string]string{
 			"arch": buildArch,
@@ -76,7 +72,7 @@ func New(imagePath, libraryURL string, d types.Definition, isDetached, force boo
 	}, nil
 }
 
-// Build is responsible for making the request via scs-build-client to the builder
+// Build is responsible for making the request via the backend to the builder
 func (rb *RemoteBuilder) Build(ctx context.Context) (err error) {
 	var libraryRef string
 
@@ -89,89 +85,156 @@ func (rb *RemoteBuilder) Build(ctx context.Context) (err error) {
 		return fmt.Errorf("invalid library reference: %s", rb.ImagePath)
 	}
 
-	br := buildclient.BuildRequest{
-		LibraryRef:          libraryRef,
-		LibraryURL:          rb.LibraryURL,
-		DefinitionRaw:       rb.Definition.Raw,
-		BuilderRequirements: rb.BuilderRequirements,
-	}
-
-	bi, err := rb.BuildClient.Submit(ctx, br)
+	job, err := rb.Backend.Submit(ctx, Request{
+		Definition:   rb.Definition.Raw,
+		LibraryRef:   libraryRef,
+		LibraryURL:   rb.LibraryURL,
+		Requirements: rb.BuilderRequirements,
+	})
 	if err != nil {
 		return errors.Wrap(err, "failed to post request to remote build service")
 	}
-	sylog.Debugf("Build response - id: %s, libref: %s", bi.ID, bi.LibraryRef)
+	sylog.Debugf("Build response - id: %s, libref: %s", job.ID, job.LibraryRef)
 
 	// If we're doing an detached build, print help on how to download the image
-	libraryRefRaw := strings.TrimPrefix(bi.LibraryRef, "library://")
 	if rb.IsDetached {
+		if job.LibraryRef == "" {
+			fmt.Printf("Build %s submitted!\n", job.ID)
+			return nil
+		}
+		libraryRefRaw := strings.TrimPrefix(job.LibraryRef, "library://")
 		fmt.Printf("Build submitted! Once it is complete, the image can be retrieved by running:\n")
-		fmt.Printf("\tsingularity pull --library %s library://%s\n\n", bi.LibraryURL, libraryRefRaw)
+		fmt.Printf("\tsingularity pull --library %s library://%s\n\n", job.LibraryURL, libraryRefRaw)
 		fmt.Printf("Alternatively, you can access it from a browser at:\n\t%s/library/%s\n", CloudURI, libraryRefRaw)
 		return nil
 	}
 
+	// cancel the build when interrupted, the build context is done
+	defer func() {
+		if ctx.Err() != nil {
+			rb.cancel(job)
+		}
+	}()
+
 	// We're doing an attached build, stream output and then download the resulting file
-	var outputLogger stdoutLogger
-	err = rb.BuildClient.GetOutput(ctx, bi.ID, outputLogger)
-	if err != nil {
+	if err := rb.streamLogs(ctx, job); err != nil {
 		return errors.Wrap(err, "failed to stream output from remote build service")
 	}
 
 	// Get build status
-	bi, err = rb.BuildClient.GetStatus(ctx, bi.ID)
+	st, err := rb.Backend.Status(ctx, job)
 	if err != nil {
 		return errors.Wrap(err, "failed to get status from remote build service")
 	}
 
 	// Do not try to download image if not complete or image size is 0
-	if !bi.IsComplete {
+	if !st.Complete {
 		return errors.New("build has not completed")
 	}
-	if bi.ImageSize <= 0 {
+	if st.ImageSize <= 0 {
 		return errors.New("build image size <= 0")
 	}
 
 	// If image destination is local file, pull image.
-	if !strings.HasPrefix(rb.ImagePath, "library://") {
-		f, err := os.OpenFile(rb.ImagePath, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0777)
-		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("unable to open file %s for writing", rb.ImagePath))
+	if libraryRef == "" {
+		if err := rb.download(ctx, job, st); err != nil {
+			return errors.Wrap(err, "failed to pull image file")
 		}
-		defer f.Close()
+	}
 
-		c, err := client.NewClient(&client.Config{
-			BaseURL:   bi.LibraryURL,
-			AuthToken: rb.AuthToken,
-			Logger:    (golog.Logger)(sylog.DebugLogger{}),
-		})
-		if err != nil {
-			return errors.Wrap(err, fmt.Sprintf("error initializing library client: %v", err))
+	return nil
+}
+
+// streamLogs writes the build output to stdout, the streaming is resumed
+// from the last byte received when the connection is interrupted.
+func (rb *RemoteBuilder) streamLogs(ctx context.Context, job Job) error {
+	w := &countWriter{w: os.Stdout}
+
+	attempts := 0
+	for {
+		offset := w.n
+		err := rb.Backend.Logs(ctx, job, offset, w)
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if w.n > offset {
+			attempts = 0
+		}
+		if attempts++; attempts == logRetries {
+			return err
 		}
 
-		imageRef := library.NormalizeLibraryRef(bi.LibraryRef)
+		delay := time.Duration(attempts) * logRetryDelay
+		sylog.Warningf("Build output interrupted: %v, resuming in %s", err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
 
-		if err = library.DownloadImageNoProgress(ctx, c, rb.ImagePath, rb.BuilderRequirements["arch"], imageRef); err != nil {
-			return errors.Wrap(err, "failed to pull image file")
+// download writes the built image to a temporary file next to the image
+// path, it is renamed to the image path once its checksum verified.
+func (rb *RemoteBuilder) download(ctx context.Context, job Job, st Status) error {
+	f, err := ioutil.TempFile(filepath.Dir(rb.ImagePath), "."+filepath.Base(rb.ImagePath)+".")
+	if err != nil {
+		return fmt.Errorf("unable to create temporary image: %v", err)
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	var w io.Writer = f
+	var verifier digest.Verifier
+	if st.ImageDigest != "" {
+		d, err := digest.Parse(st.ImageDigest)
+		if err != nil {
+			f.Close()
+			return fmt.Errorf("invalid image checksum %q: %v", st.ImageDigest, err)
 		}
+		verifier = d.Verifier()
+		w = io.MultiWriter(f, verifier)
+	} else {
+		sylog.Warningf("Remote build service provided no image checksum, the image is not verified")
 	}
 
-	return nil
+	err = rb.Backend.Download(ctx, job, w)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if verifier != nil && !verifier.Verified() {
+		return fmt.Errorf("image checksum mismatch, expected %s", st.ImageDigest)
+	}
+
+	if err := os.Chmod(tmp, 0755); err != nil {
+		return err
+	}
+	return os.Rename(tmp, rb.ImagePath)
+}
+
+// cancel cancels the remote build, the build context being done.
+func (rb *RemoteBuilder) cancel(job Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	if err := rb.Backend.Cancel(ctx, job); err != nil {
+		sylog.Warningf("Failed to cancel remote build %s: %v", job.ID, err)
+		return
+	}
+	sylog.Infof("Remote build %s canceled", job.ID)
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
 }
 
-// stdoutLogger implements the buildclient.OutputReader interface and writes
-// messages to stdout
-type stdoutLogger struct{}
-
-// Read implements the buildclient.OutputReader Read interface, writing messages
-// to the console/terminal
-func (c stdoutLogger) Read(messageType int, msg []byte) (int, error) {
-	// Print to terminal
-	switch messageType {
-	case websocket.TextMessage:
-		fmt.Printf("%s", msg)
-	case websocket.BinaryMessage:
-		fmt.Print("Ignoring binary message")
-	}
-	return len(msg), nil
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
@@ -6,10 +6,20 @@
 package remotebuilder
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strconv"
+	"sync"
 	"testing"
 
+	digest "github.com/opencontainers/go-digest"
+
 	"github.com/sylabs/singularity/internal/pkg/test"
 	"github.com/sylabs/singularity/pkg/build/types"
 	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
@@ -39,7 +49,7 @@ func TestBuild(t *testing.T) {
 	// Loop over test cases
 	for _, tt := range tests {
 		t.Run(tt.description, test.WithoutPrivilege(func(t *testing.T) {
-			_, err := New("", "", types.Definition{}, false, false, tt.builderAddr, "", runtime.GOARCH)
+			_, err := New("", "", types.Definition{}, false, false, "", tt.builderAddr, "", runtime.GOARCH)
 			if tt.expectSuccess {
 				// Ensure the handler returned no error, and the response is as expected
 				if err != nil {
@@ -54,3 +64,107 @@ func TestBuild(t *testing.T) {
 		}))
 	}
 }
+
+func TestNewBackend(t *testing.T) {
+	if _, err := NewBackend("unknown", BackendConfig{URL: "https://build.sylabs.io"}); err == nil {
+		t.Errorf("unexpected success with unknown backend")
+	}
+	if _, err := NewBackend("http", BackendConfig{URL: "ftp://build.example.com"}); err == nil {
+		t.Errorf("unexpected success with bad scheme")
+	}
+	if err := RegisterBackend(DefaultBackend, newSCSBackend); err == nil {
+		t.Errorf("unexpected success registering existing backend")
+	}
+}
+
+// mockBuildService implements the HTTP backend API, the build output
+// connection is interrupted once to check it is resumed.
+type mockBuildService struct {
+	sync.Mutex
+	output      string
+	image       []byte
+	checksum    string
+	interrupted bool
+	canceled    bool
+}
+
+func (m *mockBuildService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.Lock()
+	defer m.Unlock()
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/v1/build":
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"id":"1"}`)
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/build/1":
+		fmt.Fprintf(w, `{"id":"1","isComplete":true,"imageSize":%d,"imageChecksum":%q}`, len(m.image), m.checksum)
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/build/1/log":
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		output := m.output[offset:]
+		if !m.interrupted {
+			// announce the whole output and write half of it
+			m.interrupted = true
+			w.Header().Set("Content-Length", strconv.Itoa(len(output)))
+			output = output[:len(output)/2]
+		}
+		fmt.Fprint(w, output)
+	case r.Method == http.MethodGet && r.URL.Path == "/v1/build/1/image":
+		w.Write(m.image)
+	case r.Method == http.MethodPut && r.URL.Path == "/v1/build/1/_cancel":
+		m.canceled = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestBuildHTTPBackend(t *testing.T) {
+	logRetryDelay = 0
+
+	tmp, err := ioutil.TempDir("", "remote-build-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	image := []byte("image content")
+	m := &mockBuildService{
+		output:   "building image\nbuild complete\n",
+		image:    image,
+		checksum: digest.FromBytes(image).String(),
+	}
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+
+	path := filepath.Join(tmp, "image.sif")
+	rb, err := New(path, "", types.Definition{Raw: []byte("bootstrap: docker")}, false, false, "http", srv.URL, "", runtime.GOARCH)
+	if err != nil {
+		t.Fatalf("unexpected failure: %v", err)
+	}
+
+	if err := rb.Build(context.Background()); err != nil {
+		t.Fatalf("unexpected build failure: %v", err)
+	}
+	if b, err := ioutil.ReadFile(path); err != nil || string(b) != string(image) {
+		t.Errorf("unexpected image content %q: %v", b, err)
+	}
+
+	// a checksum mismatch leaves neither the image nor temporary files
+	m.checksum = digest.FromString("other content").String()
+	m.interrupted = false
+	os.Remove(path)
+	if err := rb.Build(context.Background()); err == nil {
+		t.Errorf("unexpected success with checksum mismatch")
+	}
+	if entries, err := ioutil.ReadDir(tmp); err != nil || len(entries) != 0 {
+		t.Errorf("unexpected files left in destination directory: %v", entries)
+	}
+
+	// an interrupted build is canceled
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.interrupted = false
+	if err := rb.Build(ctx); err == nil {
+		t.Errorf("unexpected success with canceled context")
+	}
+}
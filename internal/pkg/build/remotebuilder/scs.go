@@ -0,0 +1,141 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package remotebuilder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	golog "github.com/go-log/log"
+	"github.com/gorilla/websocket"
+	buildclient "github.com/sylabs/scs-build-client/client"
+	client "github.com/sylabs/scs-library-client/client"
+	"github.com/sylabs/singularity/internal/pkg/client/library"
+	"github.com/sylabs/singularity/pkg/sylog"
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
+)
+
+// scsBackend is the Sylabs Cloud build service backend, images are
+// pushed to the library by the builder and downloaded from it.
+type scsBackend struct {
+	client    *buildclient.Client
+	authToken string
+}
+
+func newSCSBackend(cfg BackendConfig) (Backend, error) {
+	bc, err := buildclient.New(&buildclient.Config{
+		BaseURL:   cfg.URL,
+		AuthToken: cfg.AuthToken,
+		UserAgent: useragent.Value(),
+		HTTPClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &scsBackend{client: bc, authToken: cfg.AuthToken}, nil
+}
+
+func (b *scsBackend) Submit(ctx context.Context, r Request) (Job, error) {
+	bi, err := b.client.Submit(ctx, buildclient.BuildRequest{
+		LibraryRef:          r.LibraryRef,
+		LibraryURL:          r.LibraryURL,
+		DefinitionRaw:       r.Definition,
+		BuilderRequirements: r.Requirements,
+	})
+	if err != nil {
+		return Job{}, err
+	}
+	return Job{
+		ID:           bi.ID,
+		LibraryRef:   bi.LibraryRef,
+		LibraryURL:   bi.LibraryURL,
+		Requirements: r.Requirements,
+	}, nil
+}
+
+// Logs replays the build output from its start on each connection, the
+// output already written is skipped.
+func (b *scsBackend) Logs(ctx context.Context, j Job, offset int64, w io.Writer) error {
+	return b.client.GetOutput(ctx, j.ID, &outputReader{w: w, skip: offset})
+}
+
+func (b *scsBackend) Status(ctx context.Context, j Job) (Status, error) {
+	bi, err := b.client.GetStatus(ctx, j.ID)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{
+		Complete:    bi.IsComplete,
+		ImageSize:   bi.ImageSize,
+		ImageDigest: libraryDigest(bi.ImageChecksum),
+		LibraryRef:  bi.LibraryRef,
+		LibraryURL:  bi.LibraryURL,
+	}, nil
+}
+
+func (b *scsBackend) Download(ctx context.Context, j Job, w io.Writer) error {
+	c, err := client.NewClient(&client.Config{
+		BaseURL:   j.LibraryURL,
+		AuthToken: b.authToken,
+		Logger:    (golog.Logger)(sylog.DebugLogger{}),
+	})
+	if err != nil {
+		return fmt.Errorf("error initializing library client: %v", err)
+	}
+
+	r, err := client.Parse("library:///" + library.NormalizeLibraryRef(j.LibraryRef))
+	if err != nil {
+		return fmt.Errorf("error parsing library ref: %v", err)
+	}
+	var tag string
+	if len(r.Tags) > 0 {
+		tag = r.Tags[0]
+	}
+	return c.DownloadImage(ctx, w, j.Requirements["arch"], r.Path, tag, nil)
+}
+
+func (b *scsBackend) Cancel(ctx context.Context, j Job) error {
+	return b.client.Cancel(ctx, j.ID)
+}
+
+// libraryDigest converts a library checksum (eg: sha256.<hex>) to a digest.
+func libraryDigest(checksum string) string {
+	if i := strings.Index(checksum, "."); i > 0 {
+		return checksum[:i] + ":" + checksum[i+1:]
+	}
+	return checksum
+}
+
+// outputReader implements the buildclient.OutputReader interface and writes
+// the text messages to w once skip bytes were discarded.
+type outputReader struct {
+	w    io.Writer
+	skip int64
+}
+
+// Read implements the buildclient.OutputReader Read interface.
+func (r *outputReader) Read(messageType int, msg []byte) (int, error) {
+	if messageType != websocket.TextMessage {
+		sylog.Debugf("Ignoring binary message")
+		return len(msg), nil
+	}
+	n := len(msg)
+	if r.skip >= int64(n) {
+		r.skip -= int64(n)
+		return n, nil
+	}
+	if _, err := r.w.Write(msg[r.skip:]); err != nil {
+		return 0, err
+	}
+	r.skip = 0
+	return n, nil
+}
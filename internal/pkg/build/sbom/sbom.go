@@ -0,0 +1,213 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package sbom generates the software bill of materials of a container
+// root filesystem from its package databases.
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+)
+
+// Supported SBOM formats.
+const (
+	FormatSPDX      = "spdx"
+	FormatCycloneDX = "cyclonedx"
+)
+
+// toolName identifies singularity as the SBOM creator.
+const toolName = "singularity"
+
+// spdxIDRegexp matches the characters not allowed in SPDX identifiers
+var spdxIDRegexp = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// IsSupported returns an error if format isn't a supported SBOM format.
+func IsSupported(format string) error {
+	switch format {
+	case FormatSPDX, FormatCycloneDX:
+		return nil
+	}
+	return fmt.Errorf("unsupported SBOM format %q, must be %s or %s", format, FormatSPDX, FormatCycloneDX)
+}
+
+// Generate scans the root filesystem and returns its SBOM in the
+// JSON encoding of format, name is the name of the described image.
+func Generate(rootfs, format, name string) ([]byte, error) {
+	if err := IsSupported(format); err != nil {
+		return nil, err
+	}
+
+	pkgs := Scan(rootfs)
+
+	now := time.Now().UTC()
+
+	if format == FormatCycloneDX {
+		return json.MarshalIndent(cycloneDX(pkgs, name, now), "", "  ")
+	}
+	return json.MarshalIndent(spdx(pkgs, name, now), "", "  ")
+}
+
+type spdxDocument struct {
+	SPDXVersion       string         `json:"spdxVersion"`
+	DataLicense       string         `json:"dataLicense"`
+	SPDXID            string         `json:"SPDXID"`
+	Name              string         `json:"name"`
+	DocumentNamespace string         `json:"documentNamespace"`
+	CreationInfo      spdxCreation   `json:"creationInfo"`
+	Packages          []spdxPackage  `json:"packages"`
+	Relationships     []spdxRelation `json:"relationships,omitempty"`
+}
+
+type spdxCreation struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	SPDXID           string        `json:"SPDXID"`
+	Name             string        `json:"name"`
+	VersionInfo      string        `json:"versionInfo"`
+	DownloadLocation string        `json:"downloadLocation"`
+	FilesAnalyzed    bool          `json:"filesAnalyzed"`
+	LicenseConcluded string        `json:"licenseConcluded"`
+	LicenseDeclared  string        `json:"licenseDeclared"`
+	LicenseComments  string        `json:"licenseComments,omitempty"`
+	CopyrightText    string        `json:"copyrightText"`
+	ExternalRefs     []spdxExtRefs `json:"externalRefs"`
+}
+
+type spdxExtRefs struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelation struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// spdx returns the SPDX 2.2 document of the packages. Package databases
+// don't record SPDX license expressions, declared licenses are kept as
+// license comments.
+func spdx(pkgs []Package, name string, now time.Time) spdxDocument {
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.2",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              name,
+		DocumentNamespace: fmt.Sprintf("https://sylabs.io/spdxdocs/%s-%s", spdxIDRegexp.ReplaceAllString(name, "-"), uuid.NewV4()),
+		CreationInfo: spdxCreation{
+			Created:  now.Format(time.RFC3339),
+			Creators: []string{"Tool: " + toolName + "-" + buildcfg.PACKAGE_VERSION},
+		},
+		Packages: []spdxPackage{},
+	}
+
+	for i, p := range pkgs {
+		id := fmt.Sprintf("SPDXRef-Package-%s-%s-%d", p.Type, spdxIDRegexp.ReplaceAllString(p.Name, "-"), i)
+		doc.Packages = append(doc.Packages, spdxPackage{
+			SPDXID:           id,
+			Name:             p.Name,
+			VersionInfo:      p.Version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			LicenseComments:  p.License,
+			CopyrightText:    "NOASSERTION",
+			ExternalRefs: []spdxExtRefs{
+				{
+					ReferenceCategory: "PACKAGE_MANAGER",
+					ReferenceType:     "purl",
+					ReferenceLocator:  p.PURL(),
+				},
+			},
+		})
+		doc.Relationships = append(doc.Relationships, spdxRelation{
+			SPDXElementID:      "SPDXRef-DOCUMENT",
+			RelationshipType:   "DESCRIBES",
+			RelatedSPDXElement: id,
+		})
+	}
+
+	return doc
+}
+
+type cdxDocument struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     []cdxTool    `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTool struct {
+	Vendor  string `json:"vendor"`
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type cdxComponent struct {
+	Type     string       `json:"type"`
+	Name     string       `json:"name"`
+	Version  string       `json:"version,omitempty"`
+	PURL     string       `json:"purl,omitempty"`
+	Licenses []cdxLicense `json:"licenses,omitempty"`
+}
+
+type cdxLicense struct {
+	License struct {
+		Name string `json:"name"`
+	} `json:"license"`
+}
+
+// cycloneDX returns the CycloneDX 1.2 document of the packages.
+func cycloneDX(pkgs []Package, name string, now time.Time) cdxDocument {
+	doc := cdxDocument{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.2",
+		SerialNumber: "urn:uuid:" + uuid.NewV4().String(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: now.Format(time.RFC3339),
+			Tools: []cdxTool{
+				{Vendor: "Sylabs", Name: toolName, Version: buildcfg.PACKAGE_VERSION},
+			},
+			Component: cdxComponent{Type: "container", Name: name},
+		},
+		Components: []cdxComponent{},
+	}
+
+	for _, p := range pkgs {
+		c := cdxComponent{
+			Type:    "library",
+			Name:    p.Name,
+			Version: p.Version,
+			PURL:    p.PURL(),
+		}
+		if p.License != "" {
+			var l cdxLicense
+			l.License.Name = p.License
+			c.Licenses = []cdxLicense{l}
+		}
+		doc.Components = append(doc.Components, c)
+	}
+
+	return doc
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sbom
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const dpkgStatus = `Package: bash
+Status: install ok installed
+Architecture: amd64
+Version: 5.0-4
+Description: GNU Bourne Again SHell
+ Bash is an sh-compatible command language interpreter.
+
+Package: removed
+Status: deinstall ok config-files
+Architecture: amd64
+Version: 1.0
+`
+
+const apkInstalled = `C:Q1abc=
+P:musl
+V:1.1.24-r9
+A:x86_64
+L:MIT
+
+C:Q1def=
+P:busybox
+V:1.31.1-r19
+A:x86_64
+L:GPL-2.0-only
+`
+
+func writeFile(t *testing.T, rootfs, path, content string) {
+	path = filepath.Join(rootfs, path)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	rootfs, err := ioutil.TempDir("", "sbom-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rootfs)
+
+	writeFile(t, rootfs, "/etc/os-release", "NAME=\"Debian GNU/Linux\"\nID=debian\n")
+	writeFile(t, rootfs, "/var/lib/dpkg/status", dpkgStatus)
+	writeFile(t, rootfs, "/lib/apk/db/installed", apkInstalled)
+	writeFile(t, rootfs, "/usr/lib/python3/dist-packages/six-1.14.0.dist-info/METADATA", "Metadata-Version: 2.1\nName: six\nVersion: 1.14.0\nLicense: MIT\n\nSix is a compatibility library.\n")
+	writeFile(t, rootfs, "/opt/conda/conda-meta/zlib-1.2.11-0.json", `{"name": "zlib", "version": "1.2.11", "license": "Zlib", "subdir": "linux-64"}`)
+
+	expected := []Package{
+		{Type: TypeApk, Name: "busybox", Version: "1.31.1-r19", Arch: "x86_64", License: "GPL-2.0-only", Distro: "debian"},
+		{Type: TypeApk, Name: "musl", Version: "1.1.24-r9", Arch: "x86_64", License: "MIT", Distro: "debian"},
+		{Type: TypeConda, Name: "zlib", Version: "1.2.11", Arch: "linux-64", License: "Zlib"},
+		{Type: TypeDeb, Name: "bash", Version: "5.0-4", Arch: "amd64", Distro: "debian"},
+		{Type: TypePython, Name: "six", Version: "1.14.0", License: "MIT"},
+	}
+
+	if pkgs := Scan(rootfs); !reflect.DeepEqual(pkgs, expected) {
+		t.Errorf("unexpected packages:\n%+v\nexpected:\n%+v", pkgs, expected)
+	}
+
+	for _, format := range []string{FormatSPDX, FormatCycloneDX} {
+		b, err := Generate(rootfs, format, "test.sif")
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %s", format, err)
+		}
+		if !json.Valid(b) {
+			t.Errorf("invalid %s document", format)
+		}
+	}
+
+	if _, err := Generate(rootfs, "swid", "test.sif"); err == nil {
+		t.Errorf("unexpected success with unsupported format")
+	}
+}
+
+func TestParseRpmList(t *testing.T) {
+	out := []byte("bash\t5.0.11-2.fc32\tx86_64\tGPLv3+\ngpg-pubkey\t12c944d0-5d5156ab\t(none)\tpubkey\nfedora-release\t32-1\tnoarch\tMIT\n")
+
+	expected := []Package{
+		{Type: TypeRpm, Name: "bash", Version: "5.0.11-2.fc32", Arch: "x86_64", License: "GPLv3+"},
+		{Type: TypeRpm, Name: "fedora-release", Version: "32-1", Arch: "noarch", License: "MIT"},
+	}
+
+	if pkgs := parseRpmList(out); !reflect.DeepEqual(pkgs, expected) {
+		t.Errorf("unexpected packages:\n%+v\nexpected:\n%+v", pkgs, expected)
+	}
+}
+
+func TestPURL(t *testing.T) {
+	p := Package{Type: TypeDeb, Name: "bash", Version: "5.0-4", Arch: "amd64", Distro: "debian"}
+	if purl := p.PURL(); purl != "pkg:deb/debian/bash@5.0-4?arch=amd64" {
+		t.Errorf("unexpected purl %s", purl)
+	}
+}
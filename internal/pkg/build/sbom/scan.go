@@ -0,0 +1,348 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// Package types.
+const (
+	TypeDeb    = "deb"
+	TypeApk    = "apk"
+	TypeRpm    = "rpm"
+	TypePython = "pypi"
+	TypeConda  = "conda"
+)
+
+// Package describes a package found in a container root filesystem.
+type Package struct {
+	Type    string
+	Name    string
+	Version string
+	Arch    string
+	License string
+	// Distro is the ID of the distribution for OS packages
+	Distro string
+}
+
+// PURL returns the package URL of the package.
+func (p Package) PURL() string {
+	purl := "pkg:" + p.Type + "/"
+	if p.Distro != "" {
+		purl += p.Distro + "/"
+	}
+	purl += p.Name + "@" + p.Version
+	if p.Arch != "" {
+		purl += "?arch=" + p.Arch
+	}
+	return purl
+}
+
+// scanner returns the packages of a package database of rootfs.
+type scanner func(rootfs string) ([]Package, error)
+
+var scanners = map[string]scanner{
+	TypeDeb:    scanDpkg,
+	TypeApk:    scanApk,
+	TypeRpm:    scanRpm,
+	TypePython: scanPython,
+	TypeConda:  scanConda,
+}
+
+// condaPrefixes are the usual locations of conda environments.
+var condaPrefixes = []string{"/opt/conda", "/usr/local", "/opt/miniconda3"}
+
+// Scan returns the OS and language packages installed in the root
+// filesystem, sorted by type and name. A package database which can't
+// be read is reported as a warning.
+func Scan(rootfs string) []Package {
+	distro := osReleaseID(rootfs)
+
+	var pkgs []Package
+
+	types := make([]string, 0, len(scanners))
+	for t := range scanners {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+
+	for _, t := range types {
+		found, err := scanners[t](rootfs)
+		if err != nil {
+			sylog.Warningf("Could not list %s packages: %v", t, err)
+			continue
+		}
+		for i := range found {
+			if t == TypeDeb || t == TypeApk || t == TypeRpm {
+				found[i].Distro = distro
+			}
+		}
+		pkgs = append(pkgs, found...)
+	}
+
+	sort.SliceStable(pkgs, func(i, j int) bool {
+		if pkgs[i].Type != pkgs[j].Type {
+			return pkgs[i].Type < pkgs[j].Type
+		}
+		if pkgs[i].Name != pkgs[j].Name {
+			return pkgs[i].Name < pkgs[j].Name
+		}
+		return pkgs[i].Version < pkgs[j].Version
+	})
+
+	return pkgs
+}
+
+// osReleaseID returns the distribution ID of /etc/os-release.
+func osReleaseID(rootfs string) string {
+	for _, path := range []string{"/etc/os-release", "/usr/lib/os-release"} {
+		fields, err := readFields(filepath.Join(rootfs, path), "=")
+		if err != nil || len(fields) == 0 {
+			continue
+		}
+		return strings.Trim(fields[0]["ID"], `"'`)
+	}
+	return ""
+}
+
+// readFields reads the paragraphs of "Key<sep> value" lines separated by
+// empty lines of path, continuation lines are ignored.
+func readFields(path, sep string) ([]map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return parseFields(f, sep)
+}
+
+// parseFields parses paragraphs of "Key<sep> value" lines separated
+// by empty lines.
+func parseFields(r io.Reader, sep string) ([]map[string]string, error) {
+	var paragraphs []map[string]string
+
+	current := make(map[string]string)
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for s.Scan() {
+		line := s.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				paragraphs = append(paragraphs, current)
+				current = make(map[string]string)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		kv := strings.SplitN(line, sep, 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		if _, ok := current[key]; !ok {
+			current[key] = strings.TrimSpace(kv[1])
+		}
+	}
+	if len(current) > 0 {
+		paragraphs = append(paragraphs, current)
+	}
+
+	return paragraphs, s.Err()
+}
+
+// scanDpkg lists the packages installed in the dpkg database.
+func scanDpkg(rootfs string) ([]Package, error) {
+	paragraphs, err := readFields(filepath.Join(rootfs, "/var/lib/dpkg/status"), ":")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var pkgs []Package
+	for _, p := range paragraphs {
+		if !strings.HasSuffix(p["Status"], " installed") {
+			continue
+		}
+		pkgs = append(pkgs, Package{
+			Type:    TypeDeb,
+			Name:    p["Package"],
+			Version: p["Version"],
+			Arch:    p["Architecture"],
+		})
+	}
+	return pkgs, nil
+}
+
+// scanApk lists the packages installed in the apk database.
+func scanApk(rootfs string) ([]Package, error) {
+	paragraphs, err := readFields(filepath.Join(rootfs, "/lib/apk/db/installed"), ":")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var pkgs []Package
+	for _, p := range paragraphs {
+		if p["P"] == "" {
+			continue
+		}
+		pkgs = append(pkgs, Package{
+			Type:    TypeApk,
+			Name:    p["P"],
+			Version: p["V"],
+			Arch:    p["A"],
+			License: p["L"],
+		})
+	}
+	return pkgs, nil
+}
+
+// scanRpm lists the packages installed in the rpm database with
+// the rpm command of the host.
+func scanRpm(rootfs string) ([]Package, error) {
+	found := false
+	for _, db := range []string{"/var/lib/rpm", "/usr/lib/sysimage/rpm"} {
+		if _, err := os.Stat(filepath.Join(rootfs, db)); err == nil {
+			found = true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	rpm, err := exec.LookPath("rpm")
+	if err != nil {
+		return nil, fmt.Errorf("rpm is not in PATH")
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.Command(rpm, "--root", rootfs, "-qa", "--queryformat", `%{NAME}\t%{VERSION}-%{RELEASE}\t%{ARCH}\t%{LICENSE}\n`)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v: %s", err, stderr.String())
+	}
+
+	return parseRpmList(stdout.Bytes()), nil
+}
+
+// parseRpmList parses the tab separated name, version, arch and
+// license lines printed by rpm.
+func parseRpmList(out []byte) []Package {
+	var pkgs []Package
+	for _, l := range strings.Split(string(out), "\n") {
+		fields := strings.Split(l, "\t")
+		if len(fields) != 4 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+		p := Package{Type: TypeRpm, Name: fields[0], Version: fields[1], Arch: fields[2], License: fields[3]}
+		if p.Arch == "(none)" {
+			p.Arch = ""
+		}
+		pkgs = append(pkgs, p)
+	}
+	return pkgs
+}
+
+// scanPython lists the python distributions installed in the
+// site-packages directories.
+func scanPython(rootfs string) ([]Package, error) {
+	var patterns []string
+	for _, prefix := range append([]string{"/usr"}, condaPrefixes...) {
+		for _, dir := range []string{"site-packages", "dist-packages"} {
+			for _, meta := range []string{"*.dist-info/METADATA", "*.egg-info/PKG-INFO", "*.egg-info"} {
+				patterns = append(patterns, filepath.Join(rootfs, prefix, "lib", "python*", dir, meta))
+			}
+		}
+	}
+
+	var pkgs []Package
+	seen := make(map[string]bool)
+
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			if fi, err := os.Stat(path); err != nil || fi.IsDir() {
+				continue
+			}
+			paragraphs, err := readFields(path, ":")
+			if err != nil {
+				return nil, err
+			}
+			if len(paragraphs) == 0 || paragraphs[0]["Name"] == "" {
+				continue
+			}
+			p := Package{
+				Type:    TypePython,
+				Name:    paragraphs[0]["Name"],
+				Version: paragraphs[0]["Version"],
+				License: paragraphs[0]["License"],
+			}
+			if p.License == "UNKNOWN" {
+				p.License = ""
+			}
+			// the same distribution may be seen through symlinks
+			key := p.Name + "@" + p.Version
+			if !seen[key] {
+				seen[key] = true
+				pkgs = append(pkgs, p)
+			}
+		}
+	}
+	return pkgs, nil
+}
+
+// scanConda lists the packages of the conda environments.
+func scanConda(rootfs string) ([]Package, error) {
+	var pkgs []Package
+
+	for _, prefix := range condaPrefixes {
+		matches, _ := filepath.Glob(filepath.Join(rootfs, prefix, "conda-meta", "*.json"))
+		for _, path := range matches {
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			var meta struct {
+				Name    string `json:"name"`
+				Version string `json:"version"`
+				License string `json:"license"`
+				Subdir  string `json:"subdir"`
+			}
+			if err := json.Unmarshal(b, &meta); err != nil || meta.Name == "" {
+				continue
+			}
+			pkgs = append(pkgs, Package{
+				Type:    TypeConda,
+				Name:    meta.Name,
+				Version: meta.Version,
+				Arch:    meta.Subdir,
+				License: meta.License,
+			})
+		}
+	}
+	return pkgs, nil
+}
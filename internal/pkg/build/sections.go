@@ -0,0 +1,276 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/cache"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+// sectionCacheVersion is bumped when the snapshot content changes.
+const sectionCacheVersion = "2"
+
+// Snapshots of a stage.
+const (
+	// bootstrapSnapshot is the stage root filesystem once bootstrapped
+	bootstrapSnapshot = "bootstrap"
+	// postSnapshot is the stage root filesystem once %post ran
+	postSnapshot = "post"
+)
+
+// sectionState is the bundle state saved along with a root filesystem
+// snapshot, it is set by the conveyorPacker and isn't part of the rootfs.
+type sectionState struct {
+	JSONObjects map[string][]byte `json:"jsonObjects"`
+	Post        types.Script      `json:"post"`
+	PostBinds   []string          `json:"postBinds,omitempty"`
+	BaseImage   *types.BaseImage  `json:"baseImage,omitempty"`
+}
+
+// sectionCache stores the root filesystem snapshots of a stage once
+// bootstrapped and once %setup, %files and %post ran, they are
+// restored when the corresponding sections didn't change.
+type sectionCache struct {
+	handle *cache.Handle
+}
+
+// stageKeys are the snapshot keys of a stage.
+type stageKeys struct {
+	bootstrap string
+	post      string
+}
+
+// newSectionCache returns the section cache if enabled with
+// --cache-sections or nil.
+func newSectionCache(opts types.Options) *sectionCache {
+	if !opts.CacheSections {
+		return nil
+	}
+	if opts.NoCache || opts.ImgCache == nil || opts.ImgCache.IsDisabled() {
+		buildLog.Warningf("Cache disabled, ignoring --cache-sections")
+		return nil
+	}
+	if opts.Update && !opts.Force {
+		buildLog.Warningf("Building into existing container, ignoring --cache-sections")
+		return nil
+	}
+	return &sectionCache{handle: opts.ImgCache}
+}
+
+// sectionKeys returns the snapshot keys of a stage, the keys of the
+// previous stages are part of the %post key as %files can copy files
+// from them.
+func sectionKeys(d types.Definition, opts types.Options, previous []stageKeys) (stageKeys, error) {
+	h := sha256.New()
+
+	fmt.Fprintf(h, "version %s %s\n", sectionCacheVersion, buildcfg.PACKAGE_VERSION)
+	fmt.Fprintf(h, "platform %s\n", opts.Platform)
+
+	headers := make([]string, 0, len(d.Header))
+	for k := range d.Header {
+		headers = append(headers, k)
+	}
+	sort.Strings(headers)
+	for _, k := range headers {
+		fmt.Fprintf(h, "header %q %q\n", k, d.Header[k])
+		// headers may reference local images or files read
+		// by the bootstrap agent
+		if fi, err := os.Stat(d.Header[k]); err == nil {
+			fmt.Fprintf(h, "file %q %d %d\n", d.Header[k], fi.Size(), fi.ModTime().UnixNano())
+		}
+	}
+
+	keys := stageKeys{bootstrap: fmt.Sprintf("%x", h.Sum(nil))}
+
+	for _, p := range previous {
+		fmt.Fprintf(h, "stage %s\n", p.post)
+	}
+	fmt.Fprintf(h, "sections %q\n", opts.Sections)
+	fmt.Fprintf(h, "network %q\n", opts.Network)
+	fmt.Fprintf(h, "setup %q %q\n", d.BuildData.Setup.Args, d.BuildData.Setup.Script)
+	fmt.Fprintf(h, "post %q %q\n", d.BuildData.Post.Args, d.BuildData.Post.Script)
+
+	for _, f := range d.BuildData.Files {
+		fmt.Fprintf(h, "files %q\n", f.Args)
+		for _, t := range f.Files {
+			fmt.Fprintf(h, "file %q %q %q\n", t.Src, t.Dst, t.Options())
+			if f.Args != "" {
+				continue
+			}
+			if err := hashFiles(h, t.Src); err != nil {
+				return keys, fmt.Errorf("while hashing %%files source %s: %v", t.Src, err)
+			}
+		}
+	}
+
+	// app sections are installed before %post
+	apps := make([]string, 0, len(d.CustomData))
+	for k := range d.CustomData {
+		apps = append(apps, k)
+	}
+	sort.Strings(apps)
+	for _, k := range apps {
+		fmt.Fprintf(h, "app %q %q\n", k, d.CustomData[k])
+	}
+
+	keys.post = fmt.Sprintf("%x", h.Sum(nil))
+
+	return keys, nil
+}
+
+// hashFiles hashes the path, mode, size and modification time of the
+// files matching the %files source pattern, the content isn't read.
+func hashFiles(h hash.Hash, pattern string) error {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		err := filepath.Walk(m, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(h, "%q %s %d %d\n", path, fi.Mode(), fi.Size(), fi.ModTime().UnixNano())
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreStage restores the most recent snapshot of the stage and
+// returns which one or an empty string if there is none.
+func (c *sectionCache) restoreStage(name string, keys stageKeys, b *types.Bundle) (string, error) {
+	if name != "" {
+		name = " of stage " + name
+	}
+
+	if ok, err := c.restore(keys.post, b); err != nil {
+		return "", err
+	} else if ok {
+		buildLog.Infof("Using cached snapshot%s, skipping bootstrap, %%setup, %%files and %%post", name)
+		return postSnapshot, nil
+	}
+
+	if ok, err := c.restore(keys.bootstrap, b); err != nil {
+		return "", err
+	} else if ok {
+		buildLog.Infof("Using cached bootstrap snapshot%s", name)
+		return bootstrapSnapshot, nil
+	}
+
+	return "", nil
+}
+
+// restore extracts the snapshot of key in the bundle root filesystem and
+// restores the bundle state, it returns false if there is no snapshot.
+func (c *sectionCache) restore(key string, b *types.Bundle) (bool, error) {
+	state, err := c.handle.GetEntry(cache.SectionsCacheType, key+".json")
+	if err != nil {
+		return false, err
+	}
+	defer state.CleanTmp()
+
+	rootfs, err := c.handle.GetEntry(cache.SectionsCacheType, key+".tar")
+	if err != nil {
+		return false, err
+	}
+	defer rootfs.CleanTmp()
+
+	if !state.Exists || !rootfs.Exists {
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(state.Path)
+	if err != nil {
+		return false, err
+	}
+	var s sectionState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return false, fmt.Errorf("while decoding %s: %v", state.Path, err)
+	}
+
+	if err := runTar("--numeric-owner", "--xattrs", "-xpf", rootfs.Path, "-C", b.RootfsPath); err != nil {
+		return false, fmt.Errorf("while extracting %s: %v", rootfs.Path, err)
+	}
+
+	for k, v := range s.JSONObjects {
+		b.JSONObjects[k] = v
+	}
+	b.Recipe.BuildData.Post = s.Post
+	b.PostBinds = s.PostBinds
+	b.BaseImage = s.BaseImage
+
+	return true, nil
+}
+
+// save stores the bundle root filesystem and state as the snapshot of key,
+// the state is written last as the snapshot is looked up with it.
+func (c *sectionCache) save(key string, b *types.Bundle) error {
+	rootfs, err := c.handle.GetEntry(cache.SectionsCacheType, key+".tar")
+	if err != nil {
+		return err
+	}
+	defer rootfs.CleanTmp()
+
+	if err := runTar("--numeric-owner", "--xattrs", "-cpf", rootfs.TmpPath, "-C", b.RootfsPath, "."); err != nil {
+		return fmt.Errorf("while archiving %s: %v", b.RootfsPath, err)
+	}
+	if err := rootfs.Finalize(); err != nil {
+		return err
+	}
+
+	state, err := c.handle.GetEntry(cache.SectionsCacheType, key+".json")
+	if err != nil {
+		return err
+	}
+	defer state.CleanTmp()
+
+	data, err := json.Marshal(sectionState{
+		JSONObjects: b.JSONObjects,
+		Post:        b.Recipe.BuildData.Post,
+		PostBinds:   b.PostBinds,
+		BaseImage:   b.BaseImage,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(state.TmpPath, data, 0600); err != nil {
+		return err
+	}
+	return state.Finalize()
+}
+
+// runTar runs tar found in PATH.
+func runTar(args ...string) error {
+	path, err := exec.LookPath("tar")
+	if err != nil {
+		return fmt.Errorf("tar is not in PATH: %v", err)
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(path, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%v: %s", err, stderr.String())
+	}
+	return nil
+}
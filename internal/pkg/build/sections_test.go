@@ -0,0 +1,191 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity/internal/pkg/cache"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+func testDefinition(src string) types.Definition {
+	d := types.Definition{
+		Header: map[string]string{"bootstrap": "docker", "from": "alpine:3.12"},
+		CustomData: map[string]string{
+			"appinstall foo": "touch /foo",
+		},
+	}
+	d.BuildData.Post.Script = "apk add curl"
+	d.BuildData.Files = []types.Files{{Files: []types.FileTransport{{Src: src, Dst: "/opt/file"}}}}
+	d.ImageData.Labels = map[string]string{"version": "1"}
+	return d
+}
+
+func TestSectionKeys(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "build-sections-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	src := filepath.Join(tmp, "file")
+	if err := ioutil.WriteFile(src, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := sectionKeys(testDefinition(src), types.Options{}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tests := []struct {
+		name          string
+		modify        func(d *types.Definition, opts *types.Options)
+		bootstrapSame bool
+		postSame      bool
+	}{
+		{
+			name:          "Label",
+			modify:        func(d *types.Definition, opts *types.Options) { d.ImageData.Labels["version"] = "2" },
+			bootstrapSame: true,
+			postSame:      true,
+		},
+		{
+			name:          "Runscript",
+			modify:        func(d *types.Definition, opts *types.Options) { d.ImageData.Runscript.Script = "exec curl" },
+			bootstrapSame: true,
+			postSame:      true,
+		},
+		{
+			name:          "Post",
+			modify:        func(d *types.Definition, opts *types.Options) { d.BuildData.Post.Script = "apk add wget" },
+			bootstrapSame: true,
+		},
+		{
+			name:          "App",
+			modify:        func(d *types.Definition, opts *types.Options) { d.CustomData["appinstall foo"] = "touch /bar" },
+			bootstrapSame: true,
+		},
+		{
+			name: "From",
+			modify: func(d *types.Definition, opts *types.Options) {
+				d.Header["from"] = "alpine:3.13"
+			},
+		},
+		{
+			name: "Platform",
+			modify: func(d *types.Definition, opts *types.Options) {
+				opts.Platform = "linux/arm64"
+			},
+		},
+		{
+			name: "Network",
+			modify: func(d *types.Definition, opts *types.Options) {
+				opts.Network = types.NoneNetwork
+			},
+			bootstrapSame: true,
+		},
+		{
+			name: "FilesSource",
+			modify: func(d *types.Definition, opts *types.Options) {
+				future := time.Now().Add(time.Hour)
+				if err := os.Chtimes(src, future, future); err != nil {
+					t.Fatal(err)
+				}
+			},
+			bootstrapSame: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := testDefinition(src)
+			opts := types.Options{}
+			tt.modify(&d, &opts)
+
+			k, err := sectionKeys(d, opts, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if (k.bootstrap == keys.bootstrap) != tt.bootstrapSame {
+				t.Errorf("unexpected bootstrap key change: %v", !tt.bootstrapSame)
+			}
+			if (k.post == keys.post) != tt.postSame {
+				t.Errorf("unexpected post key change: %v", !tt.postSame)
+			}
+		})
+	}
+
+	// the previous stages are part of the post key only
+	k, err := sectionKeys(testDefinition(src), types.Options{}, []stageKeys{{"a", "b"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if k.bootstrap != keys.bootstrap || k.post == keys.post {
+		t.Errorf("unexpected keys with previous stages")
+	}
+}
+
+func TestSectionCache(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "build-sections-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	h, err := cache.New(cache.Config{ParentDir: tmp})
+	if err != nil {
+		t.Fatalf("while creating cache: %s", err)
+	}
+	c := newSectionCache(types.Options{CacheSections: true, ImgCache: h})
+	if c == nil {
+		t.Fatalf("section cache unexpectedly disabled")
+	}
+
+	b, err := types.NewBundle(filepath.Join(tmp, "rootfs"), tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Remove()
+
+	if err := ioutil.WriteFile(filepath.Join(b.RootfsPath, "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	b.JSONObjects[types.OCIConfigJSON] = []byte("{}")
+	b.Recipe.BuildData.Post.Script = "spack install"
+
+	if ok, err := c.restore("key", b); err != nil || ok {
+		t.Fatalf("unexpected restore of missing snapshot: %v", err)
+	}
+	if err := c.save("key", b); err != nil {
+		t.Fatalf("while saving snapshot: %s", err)
+	}
+
+	r, err := types.NewBundle(filepath.Join(tmp, "restored"), tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Remove()
+
+	if ok, err := c.restore("key", r); err != nil || !ok {
+		t.Fatalf("snapshot not restored: %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(r.RootfsPath, "file")); err != nil || string(data) != "data" {
+		t.Errorf("unexpected restored file: %v", err)
+	}
+	if string(r.JSONObjects[types.OCIConfigJSON]) != "{}" || r.Recipe.BuildData.Post.Script != "spack install" {
+		t.Errorf("bundle state not restored")
+	}
+
+	if newSectionCache(types.Options{CacheSections: true, ImgCache: h, NoCache: true}) != nil {
+		t.Errorf("section cache unexpectedly enabled with --disable-cache")
+	}
+}
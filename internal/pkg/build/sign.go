@@ -0,0 +1,61 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/sylabs/sif/pkg/integrity"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/build/events"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+// assembleSigned assembles the stage to a temporary image next to dest, signs
+// it and renames it to dest so that the image is never visible unsigned.
+func (b *Build) assembleSigned(s stage, dest string) error {
+	f, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest)+".")
+	if err != nil {
+		return fmt.Errorf("while creating temporary image: %v", err)
+	}
+	tmp := f.Name()
+	f.Close()
+	defer os.Remove(tmp)
+
+	if err := s.Assemble(tmp); err != nil {
+		return err
+	}
+
+	fingerprint := fmt.Sprintf("%X", b.Conf.Opts.SignEntity.PrimaryKey.Fingerprint)
+	buildLog.Infof("Signing image with key %s", fingerprint)
+	if err := signImage(tmp, b.Conf.Opts); err != nil {
+		return fmt.Errorf("while signing image: %v", err)
+	}
+	b.Conf.Opts.Events.Emit(events.Event{Type: events.Signature, Path: dest, Fingerprint: fingerprint})
+
+	if err := os.Rename(tmp, dest); err != nil {
+		return fmt.Errorf("while moving signed image to %s: %v", dest, err)
+	}
+	return nil
+}
+
+// signImage adds one signature per object group to the SIF image at path.
+func signImage(path string, opts types.Options) error {
+	f, err := sif.LoadContainer(path, false)
+	if err != nil {
+		return err
+	}
+	defer f.UnloadContainer()
+
+	is, err := integrity.NewSigner(&f, integrity.OptSignWithEntity(opts.SignEntity))
+	if err != nil {
+		return err
+	}
+	return is.Sign()
+}
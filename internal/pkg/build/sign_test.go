@@ -0,0 +1,90 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/build/types"
+	"golang.org/x/crypto/openpgp"
+)
+
+// mockAssembler creates a SIF image holding a definition file only.
+type mockAssembler struct {
+	err error
+}
+
+func (a *mockAssembler) Assemble(b *types.Bundle, path string) error {
+	if a.err != nil {
+		return a.err
+	}
+
+	def := []byte("bootstrap: docker\nfrom: alpine\n")
+	cinfo := sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: []sif.DescriptorInput{{
+			Datatype: sif.DataDeffile,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     sif.DescrUnusedLink,
+			Data:     def,
+			Size:     int64(len(def)),
+		}},
+	}
+	_, err := sif.CreateContainer(cinfo)
+	return err
+}
+
+func TestAssembleSigned(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "build-sign-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	e, err := openpgp.NewEntity("Test", "", "test@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Build{Conf: Config{Opts: types.Options{SignEntity: e}}}
+
+	dest := filepath.Join(tmp, "image.sif")
+	if err := b.assembleSigned(stage{a: &mockAssembler{}}, dest); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	f, err := sif.LoadContainer(dest, true)
+	if err != nil {
+		t.Fatalf("while loading signed image: %s", err)
+	}
+	signed := false
+	for _, d := range f.DescrArr {
+		if d.Used && d.Datatype == sif.DataSignature {
+			signed = true
+		}
+	}
+	f.UnloadContainer()
+	if !signed {
+		t.Errorf("image not signed")
+	}
+
+	// a failed assembly leaves neither the image nor temporary files
+	failed := filepath.Join(tmp, "failed.sif")
+	if err := b.assembleSigned(stage{a: &mockAssembler{err: errors.New("failure")}}, failed); err == nil {
+		t.Errorf("unexpected success")
+	}
+	if entries, err := ioutil.ReadDir(tmp); err != nil || len(entries) != 1 {
+		t.Errorf("unexpected files left in destination directory: %v", entries)
+	}
+}
@@ -106,21 +106,186 @@ for script in /.singularity.d/env/*.sh; do
     fi
 done
 
+# now prints the current time in seconds
+now() {
+    date +%s 2>/dev/null || echo 0
+}
+
+# run_once runs the test command, the test is killed after $timeout
+# seconds if set and $timed_out is then set to 1.
+run_once() {
+    timed_out=0
+    if test "$timeout" -eq 0; then
+        "$@"
+        return $?
+    fi
+
+    "$@" &
+    test_pid=$!
+    (
+        sleep "$timeout" &
+        sleep_pid=$!
+        trap 'kill $sleep_pid 2>/dev/null; exit 0' TERM
+        wait $sleep_pid
+        trap '' TERM
+        kill -KILL $test_pid 2>/dev/null
+        exit 124
+    ) &
+    watchdog_pid=$!
+
+    wait $test_pid
+    test_code=$?
+    kill $watchdog_pid 2>/dev/null
+    wait $watchdog_pid
+    if test $? -eq 124; then
+        timed_out=1
+    fi
+    return $test_code
+}
+
+# run_test runs the test script $2 named $1 with the options written
+# next to it at build time and records its result, the test of the app
+# $test_app runs in the environment of the app.
+run_test() {
+    test_name="$1"
+    test_script="$2"
+    shift 2
+
+    timeout=0
+    retries=0
+    exit_code=0
+    if test -f "$test_script.opts"; then
+        while IFS='=' read -r key value; do
+            case "$key" in
+            timeout) timeout="$value" ;;
+            retries) retries="$value" ;;
+            exit_code) exit_code="$value" ;;
+            esac
+        done < "$test_script.opts"
+    fi
+
+    attempts=0
+    start=$(now)
+    while true; do
+        attempts=$((attempts + 1))
+        if test -n "${test_app:-}"; then
+            run_once env SINGULARITY_APPNAME="$test_app" /bin/sh -c '. /.singularity.d/env/95-apps.sh && exec "$@"' apptest "$test_script" "$@"
+        else
+            run_once "$test_script" "$@"
+        fi
+        code=$?
+        if test $timed_out -eq 1; then
+            status=timeout
+            echo "Test $test_name timed out after ${timeout}s" 1>&2
+        elif test $code -ne $exit_code; then
+            status=failed
+            echo "Test $test_name failed with exit code $code, expected $exit_code" 1>&2
+        else
+            status=passed
+            break
+        fi
+        if test $attempts -gt $retries; then
+            break
+        fi
+        echo "Retrying test $test_name" 1>&2
+    done
+    duration=$(($(now) - start))
+
+    if test $status = passed; then
+        passed=$((passed + 1))
+    else
+        failed=$((failed + 1))
+    fi
+    results="$results$test_name|$status|$code|$attempts|$duration
+"
+}
+
+# write_report writes the test results to $SINGULARITY_TEST_REPORT in
+# the JSON or JUnit format.
+write_report() {
+    format="${SINGULARITY_TEST_REPORT_FORMAT:-}"
+    if test -z "$format"; then
+        case "$SINGULARITY_TEST_REPORT" in
+        *.xml) format=junit ;;
+        *) format=json ;;
+        esac
+    fi
+
+    case "$format" in
+    json)
+        printf '{"passed":%d,"failed":%d,"tests":[' $passed $failed
+        sep=""
+        printf '%s' "$results" | while IFS='|' read -r test_name status code attempts duration; do
+            printf '%s{"name":"%s","status":"%s","exitCode":%d,"attempts":%d,"duration":%d}' "$sep" "$test_name" $status $code $attempts $duration
+            sep=","
+        done
+        printf ']}\n'
+        ;;
+    junit)
+        echo '<?xml version="1.0" encoding="UTF-8"?>'
+        echo "<testsuite name=\"singularity\" tests=\"$((passed + failed))\" failures=\"$failed\">"
+        printf '%s' "$results" | while IFS='|' read -r test_name status code attempts duration; do
+            if test $status = passed; then
+                echo "  <testcase name=\"$test_name\" time=\"$duration\"/>"
+            else
+                echo "  <testcase name=\"$test_name\" time=\"$duration\">"
+                echo "    <failure message=\"$status with exit code $code after $attempts attempts\"/>"
+                echo "  </testcase>"
+            fi
+        done
+        echo "</testsuite>"
+        ;;
+    *)
+        echo "Unknown test report format: $format" 1>&2
+        return 1
+        ;;
+    esac > "$SINGULARITY_TEST_REPORT"
+}
+
+passed=0
+failed=0
+results=""
 
 if test -n "${SINGULARITY_APPNAME:-}"; then
 
     if test -x "/scif/apps/${SINGULARITY_APPNAME:-}/scif/test"; then
-        exec "/scif/apps/${SINGULARITY_APPNAME:-}/scif/test" "$@"
+        run_test "apptest ${SINGULARITY_APPNAME:-}" "/scif/apps/${SINGULARITY_APPNAME:-}/scif/test" "$@"
     else
         echo "No tests for contained app: ${SINGULARITY_APPNAME:-}"
         exit 1
     fi
-elif test -x "/.singularity.d/test"; then
-    exec "/.singularity.d/test" "$@"
 else
-    echo "No test found in container, executing /bin/sh -c true"
-    exec /bin/sh -c true
+    if test -x "/.singularity.d/test"; then
+        run_test test "/.singularity.d/test" "$@"
+    fi
+    for app_test in /scif/apps/*/scif/test; do
+        if test -x "$app_test"; then
+            test_app="${app_test#/scif/apps/}"
+            test_app="${test_app%/scif/test}"
+            run_test "apptest $test_app" "$app_test" "$@"
+            test_app=""
+        fi
+    done
+    if test -z "$results"; then
+        echo "No test found in container, executing /bin/sh -c true"
+        exec /bin/sh -c true
+    fi
+fi
+
+if test -n "${SINGULARITY_TEST_REPORT:-}"; then
+    if ! write_report; then
+        exit 255
+    fi
+fi
+
+# the exit code is the number of failed tests
+if test $failed -gt 0; then
+    echo "$failed of $((passed + failed)) tests failed" 1>&2
 fi
+if test $failed -gt 255; then
+    exit 255
+fi
+exit $failed
 `
 	// Contents of /.singularity.d/env/01-base.sh
 	baseShFileContent = `#!/bin/sh
@@ -176,6 +341,12 @@ if test -n "${SINGULARITY_APPNAME:-}"; then
         SCIF_APPS="/scif/apps"
         SCIF_APPROOT="/scif/apps/${SINGULARITY_APPNAME:-}"
         export SCIF_APPROOT SCIF_APPS
+
+        # Set the environment of the apps required by the application first
+        if [ -f "/scif/apps/${SINGULARITY_APPNAME:-}/scif/env/02-dependencies.sh" ]; then
+            . "/scif/apps/${SINGULARITY_APPNAME:-}/scif/env/02-dependencies.sh"
+        fi
+
         PATH="/scif/apps/${SINGULARITY_APPNAME:-}:$PATH"
 
         # Automatically add application bin to path
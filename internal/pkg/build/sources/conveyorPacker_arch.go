@@ -6,6 +6,7 @@
 package sources
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -14,8 +15,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strings"
 
+	"github.com/sylabs/singularity/internal/pkg/build/files"
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/util/namespaces"
@@ -23,6 +27,8 @@ import (
 
 const (
 	pacmanConfURL = "https://git.archlinux.org/svntogit/packages.git/plain/trunk/pacman.conf?h=packages/pacman"
+	// aurURL is the git repositories base URL of the AUR packages
+	aurURL = "https://aur.archlinux.org"
 )
 
 var (
@@ -30,11 +36,58 @@ var (
 	// As of 2019-10-06 there is a base metapackage instead of a base group
 	// https://www.archlinux.org/news/base-group-replaced-by-mandatory-base-package-manual-intervention-required/
 	instList = []string{"base"}
+	// aurInstList are the packages required to build the AUR packages
+	aurInstList = []string{"base-devel", "git"}
+
+	// mirrorlistRegexp matches the pacman.conf lines including the mirrorlist
+	mirrorlistRegexp = regexp.MustCompile(`(?m)^\s*Include\s*=\s*/etc/pacman\.d/mirrorlist\s*$`)
+	// aurPackageRegexp matches the valid AUR package names
+	aurPackageRegexp = regexp.MustCompile(`^[a-z0-9@._+-]+$`)
 )
 
+// aurScript builds the AUR helper or packages with makepkg as a
+// temporary unprivileged user, makepkg packages the builds with
+// fakeroot. The first argument is the AUR helper, the others are the
+// AUR packages.
+const aurScript = `set -e
+user=singularity-aur
+useradd -m "$user"
+echo "$user ALL=(ALL) NOPASSWD: /usr/bin/pacman" > "/etc/sudoers.d/$user"
+trap 'userdel -r "$user"; rm -f "/etc/sudoers.d/$user"' EXIT
+
+makepkg_install() {
+	runuser -u "$user" -- sh -c 'cd "$HOME" && git clone "$0/$1.git" && cd "$1" && makepkg -si --noconfirm' "` + aurURL + `" "$1"
+}
+
+helper="$1"
+shift
+if [ -n "$helper" ]; then
+	makepkg_install "$helper"
+	binary="${helper%-bin}"
+	binary="${binary%-git}"
+	runuser -u "$user" -- "$binary" -S --noconfirm "$@"
+else
+	for pkg in "$@"; do
+		makepkg_install "$pkg"
+	done
+fi
+`
+
 // ArchConveyorPacker only needs to hold the conveyor to have the needed data to pack
 type ArchConveyorPacker struct {
 	b *types.Bundle
+	// pacmanConf is the custom pacman.conf path or URL
+	pacmanConf string
+	// customConf is the custom pacman.conf content
+	customConf []byte
+	// mirrors are the mirrorlist servers
+	mirrors []string
+	// include are the packages and groups installed along with base
+	include []string
+	// aurHelper is the AUR helper package installing the AUR packages
+	aurHelper string
+	// aur are the AUR packages
+	aur []string
 }
 
 // Get just stores the source
@@ -52,7 +105,11 @@ func (cp *ArchConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err err
 		return fmt.Errorf("%v architecture is not supported", arch)
 	}
 
-	pacConf, err := cp.getPacConf(pacmanConfURL)
+	if err = cp.getRecipeHeaderInfo(); err != nil {
+		return err
+	}
+
+	pacConf, err := cp.preparePacConf(ctx)
 	if err != nil {
 		return fmt.Errorf("while getting pacman config: %v", err)
 	}
@@ -68,13 +125,18 @@ func (cp *ArchConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err err
 		}
 	}
 
+	installList := append(append([]string{}, instList...), cp.include...)
+	if len(cp.aur) > 0 {
+		installList = append(installList, aurInstList...)
+	}
+
 	args := []string{"-C", pacConf, "-c", "-d", "-G", "-M", cp.b.RootfsPath, "haveged"}
-	args = append(args, instList...)
+	args = append(args, installList...)
 
 	pacCmd := exec.Command(pacstrapPath, args...)
 	pacCmd.Stdout = os.Stdout
 	pacCmd.Stderr = os.Stderr
-	sylog.Debugf("\n\tPacstrap Path: %s\n\tPac Conf: %s\n\tRootfs: %s\n\tInstall List: %s\n", pacstrapPath, pacConf, cp.b.RootfsPath, instList)
+	sylog.Debugf("\n\tPacstrap Path: %s\n\tPac Conf: %s\n\tRootfs: %s\n\tInstall List: %s\n\tAUR Helper: %s\n\tAUR: %s\n", pacstrapPath, pacConf, cp.b.RootfsPath, installList, cp.aurHelper, cp.aur)
 
 	if err = pacCmd.Run(); err != nil {
 		return fmt.Errorf("while pacstrapping: %v", err)
@@ -96,9 +158,110 @@ func (cp *ArchConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err err
 		return fmt.Errorf("while cleaning up packages: %v", err)
 	}
 
+	if err = cp.insertPacConf(); err != nil {
+		return fmt.Errorf("while inserting pacman config: %v", err)
+	}
+
+	if len(cp.aur) > 0 {
+		args := []string{cp.b.RootfsPath, "/bin/sh", "-c", aurScript, "sh", cp.aurHelper}
+		cmd = exec.Command("arch-chroot", append(args, cp.aur...)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err = cmd.Run(); err != nil {
+			return fmt.Errorf("while installing AUR packages: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// getRecipeHeaderInfo parses the pacman configuration, mirrors and
+// packages headers.
+func (cp *ArchConveyorPacker) getRecipeHeaderInfo() error {
+	cp.pacmanConf = strings.TrimSpace(cp.b.Recipe.Header["pacmanconf"])
+	cp.mirrors = splitList(cp.b.Recipe.Header["mirrorurl"])
+	cp.include = splitList(cp.b.Recipe.Header["include"])
+	cp.aurHelper = strings.TrimSpace(cp.b.Recipe.Header["aurhelper"])
+	cp.aur = splitList(cp.b.Recipe.Header["aur"])
+
+	if cp.aurHelper != "" && len(cp.aur) == 0 {
+		return fmt.Errorf("invalid arch header, AURHelper specified without AUR packages")
+	}
+	for _, pkg := range append([]string{cp.aurHelper}, cp.aur...) {
+		if pkg != "" && !aurPackageRegexp.MatchString(pkg) {
+			return fmt.Errorf("invalid arch header, %q is not a valid AUR package name", pkg)
+		}
+	}
+	return nil
+}
+
+// preparePacConf returns the pacman.conf used by pacstrap, it's the
+// PacmanConf header one or the pacman package default one. The included
+// mirrorlist is replaced by the MirrorURL servers when specified.
+func (cp *ArchConveyorPacker) preparePacConf(ctx context.Context) (string, error) {
+	var conf []byte
+	if cp.pacmanConf == "" {
+		pacConf, err := cp.getPacConf(pacmanConfURL)
+		if err != nil || len(cp.mirrors) == 0 {
+			return pacConf, err
+		}
+		if conf, err = ioutil.ReadFile(pacConf); err != nil {
+			return "", err
+		}
+	} else {
+		path := cp.pacmanConf
+		if files.IsURL(path) {
+			d, err := files.Fetch(ctx, filepath.Join(cp.b.TmpDir, "pacman-conf"), []string{path}, 1)
+			if err != nil {
+				return "", err
+			}
+			if path, err = d.Path(cp.pacmanConf); err != nil {
+				return "", err
+			}
+		}
+		c, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		conf = c
+		cp.customConf = c
+	}
+
+	if len(cp.mirrors) > 0 {
+		mirrorlist := filepath.Join(cp.b.TmpDir, "mirrorlist")
+		if err := ioutil.WriteFile(mirrorlist, archMirrorlist(cp.mirrors), 0644); err != nil {
+			return "", err
+		}
+		conf = mirrorlistRegexp.ReplaceAll(conf, []byte("Include = "+mirrorlist))
+	}
+
+	pacConf := filepath.Join(cp.b.TmpDir, "pacman.conf")
+	return pacConf, ioutil.WriteFile(pacConf, conf, 0644)
+}
+
+// insertPacConf installs the PacmanConf header pacman.conf and the
+// MirrorURL servers mirrorlist in the container.
+func (cp *ArchConveyorPacker) insertPacConf() error {
+	if cp.customConf != nil {
+		if err := ioutil.WriteFile(filepath.Join(cp.b.RootfsPath, "/etc/pacman.conf"), cp.customConf, 0644); err != nil {
+			return err
+		}
+	}
+	if len(cp.mirrors) > 0 {
+		return ioutil.WriteFile(filepath.Join(cp.b.RootfsPath, "/etc/pacman.d/mirrorlist"), archMirrorlist(cp.mirrors), 0644)
+	}
 	return nil
 }
 
+// archMirrorlist returns the mirrorlist of the servers.
+func archMirrorlist(servers []string) []byte {
+	var buf bytes.Buffer
+	for _, s := range servers {
+		fmt.Fprintf(&buf, "Server = %s\n", s)
+	}
+	return buf.Bytes()
+}
+
 // Pack puts relevant objects in a Bundle!
 func (cp *ArchConveyorPacker) Pack(context.Context) (b *types.Bundle, err error) {
 	err = cp.insertBaseEnv()
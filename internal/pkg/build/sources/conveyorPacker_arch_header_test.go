@@ -0,0 +1,115 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+func TestArchHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  map[string]string
+		include []string
+		aur     []string
+		wantErr bool
+	}{
+		{
+			name: "packages",
+			header: map[string]string{
+				"include":   "vim, base-devel",
+				"aurhelper": "yay-bin",
+				"aur":       "google-chrome",
+			},
+			include: []string{"vim", "base-devel"},
+			aur:     []string{"google-chrome"},
+		},
+		{
+			name:    "helper without packages",
+			header:  map[string]string{"aurhelper": "yay-bin"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid package",
+			header:  map[string]string{"aur": "yay;reboot"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := &ArchConveyorPacker{b: &types.Bundle{Recipe: types.Definition{Header: tt.header}}}
+			err := cp.getRecipeHeaderInfo()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(cp.include, tt.include) {
+				t.Errorf("unexpected packages %q instead of %q", cp.include, tt.include)
+			}
+			if !reflect.DeepEqual(cp.aur, tt.aur) {
+				t.Errorf("unexpected AUR packages %q instead of %q", cp.aur, tt.aur)
+			}
+		})
+	}
+}
+
+func TestArchPacConf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "arch-pacman-conf-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := "[options]\nArchitecture = auto\n\n[core]\nInclude = /etc/pacman.d/mirrorlist\n\n[extra]\nInclude = /etc/pacman.d/mirrorlist\n"
+	path := filepath.Join(dir, "custom.conf")
+	if err := ioutil.WriteFile(path, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rootfs := filepath.Join(dir, "rootfs")
+	if err := os.MkdirAll(filepath.Join(rootfs, "etc/pacman.d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cp := &ArchConveyorPacker{
+		b:          &types.Bundle{TmpDir: dir, RootfsPath: rootfs},
+		pacmanConf: path,
+		mirrors:    []string{"https://one.example.com/$repo/os/$arch", "https://two.example.com/$repo/os/$arch"},
+	}
+	pacConf, err := cp.preparePacConf(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, err := ioutil.ReadFile(pacConf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mirrorlist := "Include = " + filepath.Join(dir, "mirrorlist")
+	if strings.Count(string(data), mirrorlist) != 2 || strings.Contains(string(data), "/etc/pacman.d") {
+		t.Errorf("mirrorlist not replaced in pacman.conf:\n%s", data)
+	}
+
+	if err := cp.insertPacConf(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := ioutil.ReadFile(filepath.Join(rootfs, "etc/pacman.conf")); err != nil || string(data) != conf {
+		t.Errorf("unexpected container pacman.conf %q: %v", data, err)
+	}
+	want := "Server = https://one.example.com/$repo/os/$arch\nServer = https://two.example.com/$repo/os/$arch\n"
+	if data, err := ioutil.ReadFile(filepath.Join(rootfs, "etc/pacman.d/mirrorlist")); err != nil || string(data) != want {
+		t.Errorf("unexpected container mirrorlist %q: %v", data, err)
+	}
+}
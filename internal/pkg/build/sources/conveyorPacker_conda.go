@@ -0,0 +1,255 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sylabs/singularity/internal/pkg/cache"
+	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+const (
+	// condaPrefix is the location of the conda environment in the container
+	condaPrefix = "/opt/conda"
+	// condaLockFile is the location of the explicit lockfile in the container
+	condaLockFile = "/.singularity.d/conda.lock"
+)
+
+// Labels recording the conda build inputs.
+const (
+	condaFileLabel     = "org.label-schema.usage.singularity.conda.file"
+	condaChannelsLabel = "org.label-schema.usage.singularity.conda.channels"
+	condaLockLabel     = "org.label-schema.usage.singularity.conda.lock"
+)
+
+// CondaConveyorPacker pulls the docker base image specified by the From
+// header and creates a conda environment from the CondaFile header in
+// /opt/conda of the container root with micromamba running on the host.
+type CondaConveyorPacker struct {
+	OCIConveyorPacker
+	// file is the environment file or explicit lockfile
+	file string
+	// channels override the channels of the environment file
+	channels []string
+	// pkgsDir is the micromamba package cache
+	pkgsDir string
+	// labels record the build inputs
+	labels map[string]string
+}
+
+// Get downloads the base image and checks the conda environment file.
+func (cp *CondaConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err error) {
+	cp.b = b
+
+	cp.file = b.Recipe.Header["condafile"]
+	if cp.file == "" {
+		return fmt.Errorf("invalid conda header, no CondaFile specified")
+	}
+	if b.Recipe.Header["from"] == "" {
+		return fmt.Errorf("invalid conda header, no From base image specified")
+	}
+	cp.channels = condaChannels(b.Recipe.Header["channels"])
+
+	content, err := ioutil.ReadFile(cp.file)
+	if err != nil {
+		return fmt.Errorf("while reading conda environment file: %v", err)
+	}
+	cp.labels = map[string]string{
+		condaFileLabel: fmt.Sprintf("%s sha256:%x", cp.file, sha256.Sum256(content)),
+	}
+	if len(cp.channels) > 0 {
+		cp.labels[condaChannelsLabel] = strings.Join(cp.channels, " ")
+	}
+
+	return cp.getDockerBase(ctx, b)
+}
+
+// Pack puts relevant objects in a Bundle.
+func (cp *CondaConveyorPacker) Pack(ctx context.Context) (*types.Bundle, error) {
+	b, err := cp.OCIConveyorPacker.Pack(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cp.pkgsDir, err = cp.packageCache()
+	if err != nil {
+		return nil, fmt.Errorf("while getting conda package cache: %v", err)
+	}
+
+	if err := cp.create(ctx); err != nil {
+		return nil, fmt.Errorf("while creating conda environment: %v", err)
+	}
+
+	if err := cp.insertLockFile(ctx); err != nil {
+		return nil, fmt.Errorf("while inserting conda lockfile: %v", err)
+	}
+
+	if err := cp.insertEnv(); err != nil {
+		return nil, fmt.Errorf("while inserting conda environment: %v", err)
+	}
+
+	if err := cp.insertLabels(); err != nil {
+		return nil, fmt.Errorf("while inserting conda labels: %v", err)
+	}
+
+	return b, nil
+}
+
+// micromamba runs a micromamba command and returns its standard output.
+func (cp *CondaConveyorPacker) micromamba(ctx context.Context, args ...string) ([]byte, error) {
+	path, err := exec.LookPath("micromamba")
+	if err != nil {
+		return nil, fmt.Errorf("micromamba is not in PATH: %v", err)
+	}
+
+	var stdout bytes.Buffer
+
+	sylog.Debugf("Running %s %s", path, strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	// ignore the host configuration, channels are set by the
+	// environment file and the Channels header only
+	cmd.Env = append(os.Environ(), "CONDARC=/dev/null", "MAMBARC=/dev/null", "CONDA_PKGS_DIRS="+cp.pkgsDir)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("while running micromamba %s: %v", strings.Join(args, " "), err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// packageCache returns the package cache used by micromamba, packages
+// are kept in the singularity cache unless caching is disabled.
+func (cp *CondaConveyorPacker) packageCache() (string, error) {
+	if !cp.b.Opts.NoCache && cp.b.Opts.ImgCache != nil && !cp.b.Opts.ImgCache.IsDisabled() {
+		return cp.b.Opts.ImgCache.GetFileCacheDir(cache.CondaCacheType)
+	}
+	return ioutil.TempDir(cp.b.TmpDir, "conda-pkgs-")
+}
+
+// create creates the conda environment in the container root.
+func (cp *CondaConveyorPacker) create(ctx context.Context) error {
+	rootPrefix, err := ioutil.TempDir(cp.b.TmpDir, "conda-root-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(rootPrefix)
+
+	prefix := filepath.Join(cp.b.RootfsPath, condaPrefix)
+
+	sylog.Infof("Creating conda environment from %s", cp.file)
+
+	// packages are copied rather than linked so that changes of the
+	// container root can't alter the package cache
+	args := []string{
+		"create", "--yes", "--always-copy",
+		"--root-prefix", rootPrefix,
+		"--prefix", prefix,
+		"--relocate-prefix", condaPrefix,
+		"--file", cp.file,
+	}
+	if len(cp.channels) > 0 {
+		args = append(args, "--override-channels")
+		for _, c := range cp.channels {
+			args = append(args, "--channel", c)
+		}
+	}
+	if _, err := cp.micromamba(ctx, args...); err != nil {
+		return err
+	}
+
+	// the container doesn't need the package tarballs nor the index cache
+	if err := os.RemoveAll(filepath.Join(prefix, "pkgs")); err != nil {
+		return fmt.Errorf("while removing package cache: %v", err)
+	}
+
+	return cp.insertCondarc(prefix)
+}
+
+// insertCondarc records the Channels header in the environment .condarc
+// so that packages installed in %post use the same channels.
+func (cp *CondaConveyorPacker) insertCondarc(prefix string) error {
+	if len(cp.channels) == 0 {
+		return nil
+	}
+
+	var rc strings.Builder
+
+	rc.WriteString("channels:\n")
+	for _, c := range cp.channels {
+		fmt.Fprintf(&rc, "  - %s\n", c)
+	}
+
+	return ioutil.WriteFile(filepath.Join(prefix, ".condarc"), []byte(rc.String()), 0644)
+}
+
+// insertLockFile writes the explicit list of the installed packages, it can
+// be used as CondaFile to rebuild the same environment.
+func (cp *CondaConveyorPacker) insertLockFile(ctx context.Context) error {
+	prefix := filepath.Join(cp.b.RootfsPath, condaPrefix)
+
+	out, err := cp.micromamba(ctx, "env", "export", "--explicit", "--md5", "--prefix", prefix)
+	if err != nil {
+		return err
+	}
+	lock := condaExplicitLock(out)
+	cp.labels[condaLockLabel] = fmt.Sprintf("%s sha256:%x", condaLockFile, sha256.Sum256(lock))
+
+	return ioutil.WriteFile(filepath.Join(cp.b.RootfsPath, condaLockFile), lock, 0644)
+}
+
+// insertEnv activates the conda environment in the container.
+func (cp *CondaConveyorPacker) insertEnv() error {
+	env := fmt.Sprintf("#!/bin/sh\nPATH=\"%[1]s/bin:$PATH\"\nCONDA_PREFIX=%[1]s\nexport PATH CONDA_PREFIX\n", condaPrefix)
+
+	return ioutil.WriteFile(filepath.Join(cp.b.RootfsPath, "/.singularity.d/env/20-conda.sh"), []byte(env), 0755)
+}
+
+// insertLabels records the conda build inputs in the labels merged
+// with the definition file labels during the build.
+func (cp *CondaConveyorPacker) insertLabels() error {
+	return mergeLabels(cp.b.RootfsPath, cp.labels)
+}
+
+// condaChannels returns the channels of the Channels header
+// separated by commas or spaces.
+func condaChannels(header string) []string {
+	return strings.FieldsFunc(header, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// condaExplicitLock returns the explicit lockfile printed by micromamba
+// without the comments preceding the @EXPLICIT marker, they contain the
+// path of the environment on the host.
+func condaExplicitLock(out []byte) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString("# This file may be used to create an environment using:\n")
+	buf.WriteString("# $ micromamba create --file <file>\n")
+
+	explicit := false
+	for _, l := range strings.Split(string(out), "\n") {
+		l = strings.TrimSpace(l)
+		if l == "@EXPLICIT" {
+			explicit = true
+		}
+		if explicit && l != "" {
+			buf.WriteString(l + "\n")
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,47 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCondaChannels(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []string
+	}{
+		{"", []string{}},
+		{"conda-forge", []string{"conda-forge"}},
+		{"conda-forge, bioconda", []string{"conda-forge", "bioconda"}},
+		{"conda-forge bioconda\tdefaults", []string{"conda-forge", "bioconda", "defaults"}},
+	}
+
+	for _, tt := range tests {
+		if channels := condaChannels(tt.header); !reflect.DeepEqual(channels, tt.expected) {
+			t.Errorf("unexpected channels %v for %q", channels, tt.header)
+		}
+	}
+}
+
+func TestCondaExplicitLock(t *testing.T) {
+	out := []byte("# This file may be used to create an environment using:\n" +
+		"# $ conda create --name <env> --file <this file>\n" +
+		"# platform: linux-64\n" +
+		"@EXPLICIT\n" +
+		"https://conda.anaconda.org/conda-forge/linux-64/python-3.8.6.tar.bz2#abc\n" +
+		"\n")
+
+	lock := string(condaExplicitLock(out))
+	if strings.Contains(lock, "<env>") || strings.Contains(lock, "platform") {
+		t.Errorf("unexpected comments in lockfile:\n%s", lock)
+	}
+	if !strings.HasSuffix(lock, "@EXPLICIT\nhttps://conda.anaconda.org/conda-forge/linux-64/python-3.8.6.tar.bz2#abc\n") {
+		t.Errorf("unexpected lockfile:\n%s", lock)
+	}
+}
@@ -17,17 +17,49 @@ import (
 	"runtime"
 	"strings"
 
+	"github.com/sylabs/singularity/internal/pkg/build/files"
+	utilmachine "github.com/sylabs/singularity/internal/pkg/util/machine"
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/util/namespaces"
+	"golang.org/x/crypto/openpgp/armor"
 )
 
+// debootstrapVariants are the debootstrap variants accepted
+// by the variant header.
+var debootstrapVariants = map[string]bool{
+	"minbase":    true,
+	"buildd":     true,
+	"fakechroot": true,
+}
+
+// debianArchs maps the Go architectures to the Debian ones.
+var debianArchs = map[string]string{
+	"386":      "i386",
+	"amd64":    "amd64",
+	"arm":      "armhf",
+	"arm64":    "arm64",
+	"mips":     "mips",
+	"mipsle":   "mipsel",
+	"mips64le": "mips64el",
+	"ppc64le":  "ppc64el",
+	"riscv64":  "riscv64",
+	"s390x":    "s390x",
+}
+
+// armoredKey starts an ASCII armored public key block.
+var armoredKey = []byte("-----BEGIN PGP PUBLIC KEY BLOCK-----")
+
 // DebootstrapConveyorPacker holds stuff that needs to be packed into the bundle
 type DebootstrapConveyorPacker struct {
-	b         *types.Bundle
-	mirrorurl string
-	osversion string
-	include   string
+	b          *types.Bundle
+	mirrorurl  string
+	osversion  string
+	include    []string
+	variant    string
+	components []string
+	arch       string
+	keyring    string
 }
 
 // Get downloads container information from the specified source
@@ -44,6 +76,10 @@ func (cp *DebootstrapConveyorPacker) Get(ctx context.Context, b *types.Bundle) (
 		return err
 	}
 
+	if err = cp.prepareKeyring(ctx); err != nil {
+		return fmt.Errorf("while preparing debootstrap keyring: %v", err)
+	}
+
 	if os.Getuid() != 0 {
 		return fmt.Errorf("you must be root to build with debootstrap")
 	}
@@ -60,9 +96,9 @@ func (cp *DebootstrapConveyorPacker) Get(ctx context.Context, b *types.Bundle) (
 	}
 
 	// run debootstrap command
-	cmd := exec.Command(debootstrapPath, `--variant=minbase`, `--exclude=openssl,udev,debconf-i18n,e2fsprogs`, `--include=apt,`+cp.include, `--arch=`+runtime.GOARCH, cp.osversion, cp.b.RootfsPath, cp.mirrorurl)
+	cmd := exec.Command(debootstrapPath, cp.debootstrapArgs()...)
 
-	sylog.Debugf("\n\tDebootstrap Path: %s\n\tIncludes: apt(default),%s\n\tDetected Arch: %s\n\tOSVersion: %s\n\tMirrorURL: %s\n", debootstrapPath, cp.include, runtime.GOARCH, cp.osversion, cp.mirrorurl)
+	sylog.Debugf("\n\tDebootstrap Path: %s\n\tIncludes: apt(default),%s\n\tArch: %s\n\tVariant: %s\n\tComponents: %s\n\tKeyring: %s\n\tOSVersion: %s\n\tMirrorURL: %s\n", debootstrapPath, strings.Join(cp.include, ","), cp.arch, cp.variant, strings.Join(cp.components, ","), cp.keyring, cp.osversion, cp.mirrorurl)
 
 	// run debootstrap
 	out, err := cmd.CombinedOutput()
@@ -139,15 +175,128 @@ func (cp *DebootstrapConveyorPacker) getRecipeHeaderInfo() (err error) {
 	//check for include environment variable and add it to requires string
 	include += ` ` + os.Getenv("INCLUDE")
 
-	//trim leading and trailing whitespace
-	include = strings.TrimSpace(include)
+	//packages are separated by spaces or commas
+	cp.include = splitList(include)
+	cp.components = splitList(cp.b.Recipe.Header["components"])
+	cp.keyring = strings.TrimSpace(cp.b.Recipe.Header["keyring"])
+
+	cp.variant = "minbase"
+	if variant, ok := cp.b.Recipe.Header["variant"]; ok {
+		if !debootstrapVariants[variant] {
+			return fmt.Errorf("invalid debootstrap header, unknown variant %s", variant)
+		}
+		cp.variant = variant
+	}
+
+	return cp.getArch()
+}
 
-	//convert Requires string to comma separated list
-	cp.include = strings.Replace(include, ` `, `,`, -1)
+// getArch sets the target Debian architecture from the architecture
+// header or the requested platform, the host architecture is used
+// if none is set.
+func (cp *DebootstrapConveyorPacker) getArch() error {
+	arch := cp.b.Recipe.Header["architecture"]
 
+	if cp.b.Opts.Platform != "" {
+		p, err := utilmachine.ParsePlatform(cp.b.Opts.Platform)
+		if err != nil {
+			return err
+		}
+		platformArch := debianArch(p.Arch, p.Variant)
+		if arch != "" && arch != platformArch {
+			return fmt.Errorf("invalid debootstrap header, architecture %s doesn't match the requested platform %s", arch, cp.b.Opts.Platform)
+		}
+		arch = platformArch
+	}
+	if arch == "" {
+		arch = debianArch(runtime.GOARCH, "")
+	}
+	cp.arch = arch
 	return nil
 }
 
+// debianArch returns the Debian architecture of the Go architecture
+// arch and its variant.
+func debianArch(arch, variant string) string {
+	if arch == "arm" && (variant == "v5" || variant == "v6") {
+		return "armel"
+	}
+	if a, ok := debianArchs[arch]; ok {
+		return a
+	}
+	return arch
+}
+
+// splitList splits a list of values separated by spaces or commas.
+func splitList(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ' ' || r == ',' || r == '\t'
+	})
+}
+
+// debootstrapArgs returns the debootstrap command arguments.
+func (cp *DebootstrapConveyorPacker) debootstrapArgs() []string {
+	args := []string{
+		`--variant=` + cp.variant,
+		`--exclude=openssl,udev,debconf-i18n,e2fsprogs`,
+		`--include=` + strings.Join(append([]string{"apt"}, cp.include...), ","),
+		`--arch=` + cp.arch,
+	}
+	if len(cp.components) > 0 {
+		args = append(args, `--components=`+strings.Join(cp.components, ","))
+	}
+	if cp.keyring != "" {
+		args = append(args, `--keyring=`+cp.keyring)
+	}
+	return append(args, cp.osversion, cp.b.RootfsPath, cp.mirrorurl)
+}
+
+// prepareKeyring downloads the keyring header URL and converts ASCII
+// armored keyrings to the binary format read by gpgv, so that the
+// release files are verified without installing the keyring on the
+// host.
+func (cp *DebootstrapConveyorPacker) prepareKeyring(ctx context.Context) error {
+	if cp.keyring == "" {
+		return nil
+	}
+
+	path := cp.keyring
+	if files.IsURL(path) {
+		d, err := files.Fetch(ctx, filepath.Join(cp.b.TmpDir, "debootstrap-keyring"), []string{path}, 1)
+		if err != nil {
+			return err
+		}
+		if path, err = d.Path(cp.keyring); err != nil {
+			return err
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(data, armoredKey) {
+		cp.keyring, err = filepath.Abs(path)
+		return err
+	}
+
+	// each armored block is decoded on its own
+	var keys bytes.Buffer
+	blocks := bytes.Split(data, armoredKey)
+	for _, b := range blocks[1:] {
+		block, err := armor.Decode(bytes.NewReader(append(armoredKey, b...)))
+		if err != nil {
+			return fmt.Errorf("while decoding %s: %v", cp.keyring, err)
+		}
+		if _, err := io.Copy(&keys, block.Body); err != nil {
+			return fmt.Errorf("while decoding %s: %v", cp.keyring, err)
+		}
+	}
+
+	cp.keyring = filepath.Join(cp.b.TmpDir, "debootstrap-keyring.gpg")
+	return ioutil.WriteFile(cp.keyring, keys.Bytes(), 0644)
+}
+
 func (cp *DebootstrapConveyorPacker) insertBaseEnv(b *types.Bundle) (err error) {
 	if err = makeBaseEnv(b.RootfsPath); err != nil {
 		return
@@ -0,0 +1,173 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+func TestDebootstrapHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   map[string]string
+		platform string
+		args     []string
+		wantErr  bool
+	}{
+		{
+			name: "defaults",
+			header: map[string]string{
+				"osversion": "bullseye",
+				"mirrorurl": "http://deb.debian.org/debian",
+				"include":   "vim, curl  less",
+			},
+			platform: "linux/arm/v7",
+			args: []string{
+				"--variant=minbase",
+				"--exclude=openssl,udev,debconf-i18n,e2fsprogs",
+				"--include=apt,vim,curl,less",
+				"--arch=armhf",
+				"bullseye", "/rootfs", "http://deb.debian.org/debian",
+			},
+		},
+		{
+			name: "all headers",
+			header: map[string]string{
+				"osversion":    "jammy",
+				"mirrorurl":    "http://archive.ubuntu.com/ubuntu",
+				"variant":      "buildd",
+				"components":   "main universe",
+				"architecture": "ppc64el",
+				"keyring":      "/usr/share/keyrings/ubuntu-archive-keyring.gpg",
+			},
+			platform: "linux/ppc64le",
+			args: []string{
+				"--variant=buildd",
+				"--exclude=openssl,udev,debconf-i18n,e2fsprogs",
+				"--include=apt",
+				"--arch=ppc64el",
+				"--components=main,universe",
+				"--keyring=/usr/share/keyrings/ubuntu-archive-keyring.gpg",
+				"jammy", "/rootfs", "http://archive.ubuntu.com/ubuntu",
+			},
+		},
+		{
+			name:    "unknown variant",
+			header:  map[string]string{"osversion": "jammy", "mirrorurl": "http://archive.ubuntu.com/ubuntu", "variant": "tiny"},
+			wantErr: true,
+		},
+		{
+			name:     "architecture mismatch",
+			header:   map[string]string{"osversion": "jammy", "mirrorurl": "http://archive.ubuntu.com/ubuntu", "architecture": "arm64"},
+			platform: "linux/amd64",
+			wantErr:  true,
+		},
+		{
+			name:    "missing osversion",
+			header:  map[string]string{"mirrorurl": "http://archive.ubuntu.com/ubuntu"},
+			wantErr: true,
+		},
+	}
+
+	os.Unsetenv("INCLUDE")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := &DebootstrapConveyorPacker{
+				b: &types.Bundle{
+					RootfsPath: "/rootfs",
+					Recipe:     types.Definition{Header: tt.header},
+					Opts:       types.Options{Platform: tt.platform},
+				},
+			}
+			err := cp.getRecipeHeaderInfo()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if args := cp.debootstrapArgs(); !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("unexpected arguments %q instead of %q", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestDebootstrapKeyring(t *testing.T) {
+	dir, err := ioutil.TempDir("", "debootstrap-keyring-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// an armored keyring holding two keys in two blocks
+	var binary, armored bytes.Buffer
+	for _, name := range []string{"one", "two"} {
+		e, err := openpgp.NewEntity(name, "", name+"@example.com", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Serialize(&binary); err != nil {
+			t.Fatal(err)
+		}
+		w, err := armor.Encode(&armored, openpgp.PublicKeyType, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Serialize(w); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+		armored.WriteString("\n")
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(armored.Bytes())
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(dir, "keyring.gpg")
+	if err := ioutil.WriteFile(path, binary.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keyring := range []string{path, srv.URL + "/keyring.asc"} {
+		cp := &DebootstrapConveyorPacker{
+			b:       &types.Bundle{TmpDir: dir},
+			keyring: keyring,
+		}
+		if err := cp.prepareKeyring(context.Background()); err != nil {
+			t.Fatalf("unexpected error for %s: %v", keyring, err)
+		}
+		data, err := ioutil.ReadFile(cp.keyring)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", keyring, err)
+		}
+		if !bytes.Equal(data, binary.Bytes()) {
+			t.Errorf("unexpected keyring content for %s", keyring)
+		}
+	}
+
+	cp := &DebootstrapConveyorPacker{
+		b:       &types.Bundle{TmpDir: dir},
+		keyring: filepath.Join(dir, "missing.gpg"),
+	}
+	if err := cp.prepareKeyring(context.Background()); err == nil {
+		t.Errorf("unexpected success for a missing keyring")
+	}
+}
@@ -69,6 +69,10 @@ func (cp *LibraryConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err
 		return fmt.Errorf("while inserting base environment: %v", err)
 	}
 
+	if err = setBaseImage(cp.b, imagePath); err != nil {
+		return err
+	}
+
 	cp.LocalPacker, err = GetLocalPacker(imagePath, cp.b)
 
 	return err
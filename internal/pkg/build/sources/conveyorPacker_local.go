@@ -8,8 +8,10 @@ package sources
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
 
+	"github.com/opencontainers/go-digest"
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/sylog"
@@ -86,6 +88,37 @@ func (cp *LocalConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err er
 
 	cp.src = filepath.Clean(b.Recipe.Header["from"])
 
+	if err = setBaseImage(b, cp.src); err != nil {
+		return err
+	}
+
 	cp.LocalPacker, err = GetLocalPacker(cp.src, b)
 	return err
 }
+
+// setBaseImage records the image file at path the bundle b is bootstrapped
+// from for provenance, sandbox directories have no digest.
+func setBaseImage(b *types.Bundle, path string) error {
+	b.BaseImage = &types.BaseImage{
+		Source: b.Recipe.Header["bootstrap"] + "://" + b.Recipe.Header["from"],
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("while opening base image: %v", err)
+	}
+	defer f.Close()
+
+	if fi, err := f.Stat(); err != nil {
+		return fmt.Errorf("while opening base image: %v", err)
+	} else if fi.IsDir() {
+		return nil
+	}
+
+	d, err := digest.FromReader(f)
+	if err != nil {
+		return fmt.Errorf("while computing base image digest: %v", err)
+	}
+	b.BaseImage.Digest = d.String()
+	return nil
+}
@@ -0,0 +1,317 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+const (
+	// nixStore is the location of the nix store in the host and the container
+	nixStore = "/nix/store"
+	// nixFeatures enables the nix command and flakes with nix < 2.4
+	nixFeatures = "nix-command flakes"
+)
+
+// Labels recording the nix build inputs and outputs.
+const (
+	nixOutputsLabel = "org.label-schema.usage.singularity.nix.outputs"
+	nixFlakeLabel   = "org.label-schema.usage.singularity.nix.flake"
+	nixFileLabel    = "org.label-schema.usage.singularity.nix.file"
+)
+
+// NixConveyorPacker builds a nix store path, flake or expression on the
+// host and copies its closure into the container root.
+type NixConveyorPacker struct {
+	b *types.Bundle
+	// outputs are the store paths built
+	outputs []string
+	// labels record the build inputs and outputs
+	labels map[string]string
+}
+
+// Get builds the nix derivation and copies its closure into the bundle rootfs.
+func (cp *NixConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err error) {
+	cp.b = b
+	cp.labels = make(map[string]string)
+
+	from := b.Recipe.Header["from"]
+	file := b.Recipe.Header["nixfile"]
+
+	switch {
+	case from != "" && file != "":
+		return fmt.Errorf("invalid nix header, From and NixFile are mutually exclusive")
+	case from == "" && file == "":
+		return fmt.Errorf("invalid nix header, no From flake reference or NixFile specified")
+	case strings.HasPrefix(from, nixStore+"/"):
+		// store paths are already pinned
+		cp.outputs, err = cp.realise(ctx, from)
+	case from != "":
+		cp.outputs, err = cp.buildFlake(ctx, from)
+	default:
+		cp.outputs, err = cp.buildFile(ctx, file, b.Recipe.Header["nixattr"])
+	}
+	if err != nil {
+		return err
+	}
+	if len(cp.outputs) == 0 {
+		return fmt.Errorf("nix build didn't return any store path")
+	}
+	cp.labels[nixOutputsLabel] = strings.Join(cp.outputs, " ")
+
+	closure, err := cp.closure(ctx)
+	if err != nil {
+		return err
+	}
+
+	return cp.copyClosure(closure)
+}
+
+// Pack puts relevant objects in a Bundle.
+func (cp *NixConveyorPacker) Pack(context.Context) (*types.Bundle, error) {
+	if err := makeBaseEnv(cp.b.RootfsPath); err != nil {
+		return nil, fmt.Errorf("while inserting base environment: %v", err)
+	}
+
+	if err := cp.insertShell(); err != nil {
+		return nil, fmt.Errorf("while inserting shell: %v", err)
+	}
+
+	if err := cp.insertEnv(); err != nil {
+		return nil, fmt.Errorf("while inserting nix environment: %v", err)
+	}
+
+	if err := cp.insertLabels(); err != nil {
+		return nil, fmt.Errorf("while inserting nix labels: %v", err)
+	}
+
+	err := ioutil.WriteFile(filepath.Join(cp.b.RootfsPath, "/.singularity.d/runscript"), []byte("#!/bin/sh\n"), 0755)
+	if err != nil {
+		return nil, fmt.Errorf("while inserting runscript: %v", err)
+	}
+
+	return cp.b, nil
+}
+
+// nix runs a nix command and returns its standard output.
+func (cp *NixConveyorPacker) nix(ctx context.Context, name string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not in PATH: %v", name, err)
+	}
+
+	var stdout bytes.Buffer
+
+	sylog.Debugf("Running %s %s", path, strings.Join(args, " "))
+
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("while running %s %s: %v", name, strings.Join(args, " "), err)
+	}
+	return stdout.Bytes(), nil
+}
+
+// realise ensures the store path is available in the host store.
+func (cp *NixConveyorPacker) realise(ctx context.Context, path string) ([]string, error) {
+	out, err := cp.nix(ctx, "nix-store", "--realise", path)
+	if err != nil {
+		return nil, err
+	}
+	return nixStorePaths(out), nil
+}
+
+// buildFlake builds the flake installable and records its locked reference.
+func (cp *NixConveyorPacker) buildFlake(ctx context.Context, installable string) ([]string, error) {
+	flake := strings.SplitN(installable, "#", 2)[0]
+
+	out, err := cp.nix(ctx, "nix", "--extra-experimental-features", nixFeatures, "flake", "metadata", "--json", flake)
+	if err != nil {
+		return nil, err
+	}
+	locked, err := nixLockedFlake(out)
+	if err != nil {
+		return nil, err
+	}
+	cp.labels[nixFlakeLabel] = locked
+	sylog.Infof("Using locked flake %s", locked)
+
+	out, err = cp.nix(ctx, "nix", "--extra-experimental-features", nixFeatures, "build", "--no-link", "--json", installable)
+	if err != nil {
+		return nil, err
+	}
+	return nixBuildOutputs(out)
+}
+
+// buildFile builds the attribute of the nix expression file.
+func (cp *NixConveyorPacker) buildFile(ctx context.Context, file, attr string) ([]string, error) {
+	args := []string{"--no-out-link", file}
+	if attr != "" {
+		args = append(args, "-A", attr)
+	}
+
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("while reading nix expression: %v", err)
+	}
+	cp.labels[nixFileLabel] = fmt.Sprintf("%s sha256:%x", file, sha256.Sum256(content))
+
+	out, err := cp.nix(ctx, "nix-build", args...)
+	if err != nil {
+		return nil, err
+	}
+	return nixStorePaths(out), nil
+}
+
+// closure returns the store paths required by the outputs.
+func (cp *NixConveyorPacker) closure(ctx context.Context) ([]string, error) {
+	args := append([]string{"--query", "--requisites"}, cp.outputs...)
+
+	out, err := cp.nix(ctx, "nix-store", args...)
+	if err != nil {
+		return nil, err
+	}
+	return nixStorePaths(out), nil
+}
+
+// copyClosure copies the store paths into the container store.
+func (cp *NixConveyorPacker) copyClosure(closure []string) error {
+	store := filepath.Join(cp.b.RootfsPath, nixStore)
+	if err := os.MkdirAll(store, 0755); err != nil {
+		return fmt.Errorf("while creating nix store: %v", err)
+	}
+
+	sylog.Infof("Copying %d store paths into the container", len(closure))
+
+	args := append([]string{"-a"}, closure...)
+	args = append(args, store)
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("/bin/cp", args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("while copying nix closure: %v: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// insertShell links /bin/sh to a shell of the closure if the container
+// doesn't provide one, action scripts require /bin/sh.
+func (cp *NixConveyorPacker) insertShell() error {
+	sh := filepath.Join(cp.b.RootfsPath, "/bin/sh")
+	if _, err := os.Lstat(sh); err == nil {
+		return nil
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(cp.b.RootfsPath, nixStore, "*", "bin", "sh"))
+	if len(matches) == 0 {
+		sylog.Warningf("No shell found in the nix closure, add a shell like bashInteractive or busybox to run the container")
+		return nil
+	}
+	sort.Strings(matches)
+
+	if err := os.MkdirAll(filepath.Dir(sh), 0755); err != nil {
+		return err
+	}
+	target := strings.TrimPrefix(matches[0], cp.b.RootfsPath)
+	return os.Symlink(target, sh)
+}
+
+// insertEnv adds the outputs bin directories to the container PATH.
+func (cp *NixConveyorPacker) insertEnv() error {
+	var env strings.Builder
+
+	for _, o := range cp.outputs {
+		fmt.Fprintf(&env, "PATH=\"%s/bin:$PATH\"\n", o)
+	}
+	env.WriteString("export PATH\n")
+
+	return ioutil.WriteFile(filepath.Join(cp.b.RootfsPath, "/.singularity.d/env/10-nix.sh"), []byte(env.String()), 0755)
+}
+
+// insertLabels records the nix build inputs and outputs in the labels
+// merged with the definition file labels during the build.
+func (cp *NixConveyorPacker) insertLabels() error {
+	b, err := json.MarshalIndent(cp.labels, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(cp.b.RootfsPath, "/.singularity.d/labels.json"), b, 0644)
+}
+
+// CleanUp removes any tmpfs owned by the conveyorPacker on the filesystem
+func (cp *NixConveyorPacker) CleanUp() {
+	cp.b.Remove()
+}
+
+// nixStorePaths returns the store paths printed one per line by nix commands.
+func nixStorePaths(out []byte) []string {
+	var paths []string
+	for _, l := range strings.Split(string(out), "\n") {
+		if l = strings.TrimSpace(l); strings.HasPrefix(l, nixStore+"/") {
+			paths = append(paths, l)
+		}
+	}
+	return paths
+}
+
+// nixBuildOutputs returns the output store paths of nix build --json.
+func nixBuildOutputs(out []byte) ([]string, error) {
+	var results []struct {
+		Outputs map[string]string `json:"outputs"`
+	}
+	if err := json.Unmarshal(out, &results); err != nil {
+		return nil, fmt.Errorf("while decoding nix build output: %v", err)
+	}
+
+	var paths []string
+	for _, r := range results {
+		names := make([]string, 0, len(r.Outputs))
+		for name := range r.Outputs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			paths = append(paths, r.Outputs[name])
+		}
+	}
+	return paths, nil
+}
+
+// nixLockedFlake returns the locked URL of nix flake metadata --json.
+func nixLockedFlake(out []byte) (string, error) {
+	var metadata struct {
+		URL    string `json:"url"`
+		Locked struct {
+			NarHash string `json:"narHash"`
+		} `json:"locked"`
+	}
+	if err := json.Unmarshal(out, &metadata); err != nil {
+		return "", fmt.Errorf("while decoding nix flake metadata: %v", err)
+	}
+	if metadata.URL == "" {
+		return "", fmt.Errorf("nix flake metadata didn't return a locked URL")
+	}
+	if metadata.Locked.NarHash != "" {
+		return metadata.URL + " " + metadata.Locked.NarHash, nil
+	}
+	return metadata.URL, nil
+}
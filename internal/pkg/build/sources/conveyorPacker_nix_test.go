@@ -0,0 +1,76 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNixStorePaths(t *testing.T) {
+	out := []byte("warning: something\n/nix/store/abc-hello-2.10\n\n/nix/store/def-glibc-2.32\n")
+
+	expected := []string{"/nix/store/abc-hello-2.10", "/nix/store/def-glibc-2.32"}
+	if paths := nixStorePaths(out); !reflect.DeepEqual(paths, expected) {
+		t.Errorf("unexpected store paths %v", paths)
+	}
+}
+
+func TestNixBuildOutputs(t *testing.T) {
+	out := []byte(`[{"drvPath":"/nix/store/x.drv","outputs":{"out":"/nix/store/abc-hello","man":"/nix/store/abc-hello-man"}}]`)
+
+	paths, err := nixBuildOutputs(out)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{"/nix/store/abc-hello-man", "/nix/store/abc-hello"}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Errorf("unexpected outputs %v", paths)
+	}
+
+	if _, err := nixBuildOutputs([]byte("not json")); err == nil {
+		t.Errorf("unexpected success with invalid output")
+	}
+}
+
+func TestNixLockedFlake(t *testing.T) {
+	tests := []struct {
+		name       string
+		out        string
+		expected   string
+		shouldFail bool
+	}{
+		{
+			name:     "locked",
+			out:      `{"url":"github:NixOS/nixpkgs/0123abc","locked":{"narHash":"sha256-xyz"}}`,
+			expected: "github:NixOS/nixpkgs/0123abc sha256-xyz",
+		},
+		{
+			name:     "no hash",
+			out:      `{"url":"path:/src/flake"}`,
+			expected: "path:/src/flake",
+		},
+		{
+			name:       "no url",
+			out:        `{}`,
+			shouldFail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			locked, err := nixLockedFlake([]byte(tt.out))
+			if err != nil && !tt.shouldFail {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.shouldFail {
+				t.Fatalf("unexpected success")
+			}
+			if locked != tt.expected {
+				t.Errorf("unexpected locked flake %q", locked)
+			}
+		})
+	}
+}
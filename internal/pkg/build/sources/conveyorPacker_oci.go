@@ -23,12 +23,16 @@ import (
 	"github.com/containers/image/v5/docker"
 	dockerarchive "github.com/containers/image/v5/docker/archive"
 	dockerdaemon "github.com/containers/image/v5/docker/daemon"
+	"github.com/containers/image/v5/manifest"
 	ociarchive "github.com/containers/image/v5/oci/archive"
 	ocilayout "github.com/containers/image/v5/oci/layout"
 	"github.com/containers/image/v5/signature"
+	"github.com/containers/image/v5/storage"
 	"github.com/containers/image/v5/types"
 	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sylabs/singularity/internal/pkg/build/events"
 	"github.com/sylabs/singularity/internal/pkg/build/oci"
+	utilmachine "github.com/sylabs/singularity/internal/pkg/util/machine"
 	"github.com/sylabs/singularity/internal/pkg/util/shell"
 	buildTypes "github.com/sylabs/singularity/pkg/build/types"
 	sytypes "github.com/sylabs/singularity/pkg/build/types"
@@ -69,6 +73,16 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 	if cp.b.Opts.NoHTTPS {
 		cp.sysCtx.DockerInsecureSkipTLSVerify = types.NewOptionalBool(true)
 	}
+	// select the requested image of manifest lists and image indexes
+	if cp.b.Opts.Platform != "" {
+		p, err := utilmachine.ParsePlatform(cp.b.Opts.Platform)
+		if err != nil {
+			return err
+		}
+		cp.sysCtx.OSChoice = p.OS
+		cp.sysCtx.ArchitectureChoice = p.Arch
+		cp.sysCtx.VariantChoice = p.Variant
+	}
 
 	// add registry and namespace to reference if specified
 	ref := b.Recipe.Header["from"]
@@ -80,6 +94,8 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 	}
 	sylog.Debugf("Reference: %v", ref)
 
+	stream := b.Opts.StreamLayers
+
 	switch b.Recipe.Header["bootstrap"] {
 	case "docker":
 		ref = "//" + ref
@@ -90,6 +106,8 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 		cp.srcRef, err = dockerdaemon.ParseReference(ref)
 	case "oci":
 		cp.srcRef, err = ocilayout.ParseReference(ref)
+	case "containers-storage", oci.PodmanTransport:
+		cp.srcRef, err = storage.Transport.ParseReference(ref)
 	case "oci-archive":
 		if os.Geteuid() == 0 {
 			// As root, the direct oci-archive handling will work
@@ -101,6 +119,8 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 				return fmt.Errorf("could not create temporary oci directory: %v", err)
 			}
 			defer os.RemoveAll(tmpDir)
+			// the layers must be read before the directory is removed
+			stream = false
 
 			refParts := strings.SplitN(b.Recipe.Header["from"], ":", 2)
 			err = cp.extractArchive(refParts[0], tmpDir)
@@ -127,7 +147,9 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 		return fmt.Errorf("invalid image source: %v", err)
 	}
 
-	if !cp.b.Opts.NoCache {
+	// images of the local containers storage are read directly, caching
+	// their blobs would only duplicate them
+	if !cp.b.Opts.NoCache && cp.srcRef.Transport() != storage.Transport {
 		// Grab the modified source ref from the cache
 		cp.srcRef, err = oci.ConvertReference(ctx, b.Opts.ImgCache, cp.srcRef, cp.sysCtx)
 		if err != nil {
@@ -135,6 +157,10 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 		}
 	}
 
+	if stream {
+		return cp.stream(ctx)
+	}
+
 	// To to do the RootFS extraction we also have to have a location that
 	// contains *only* this image
 	cp.tmpfsRef, err = ocilayout.ParseReference(cp.b.TmpDir + ":" + "tmp")
@@ -152,14 +178,52 @@ func (cp *OCIConveyorPacker) Get(ctx context.Context, b *sytypes.Bundle) (err er
 	return nil
 }
 
+// getDockerBase downloads the base image specified by the From header from
+// a docker registry, it's used by the bootstrap agents installing software
+// on top of a base image.
+func (cp *OCIConveyorPacker) getDockerBase(ctx context.Context, b *sytypes.Bundle) error {
+	agent := b.Recipe.Header["bootstrap"]
+	b.Recipe.Header["bootstrap"] = "docker"
+	defer func() {
+		b.Recipe.Header["bootstrap"] = agent
+	}()
+
+	return cp.Get(ctx, b)
+}
+
+// mergeLabels adds labels to the labels of the base image in rootfs, they
+// are merged with the definition file labels during the build.
+func mergeLabels(rootfs string, labels map[string]string) error {
+	path := filepath.Join(rootfs, "/.singularity.d/labels.json")
+
+	merged := make(map[string]string)
+	if b, err := ioutil.ReadFile(path); err == nil {
+		if err := json.Unmarshal(b, &merged); err != nil {
+			sylog.Warningf("Ignoring invalid labels of the base image: %v", err)
+		}
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+
+	b, err := json.MarshalIndent(merged, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
+
 // Pack puts relevant objects in a Bundle.
 func (cp *OCIConveyorPacker) Pack(ctx context.Context) (*sytypes.Bundle, error) {
-	err := cp.unpackTmpfs(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("while unpacking tmpfs: %v", err)
+	// streamed layers are merged by the assembler, only the metadata
+	// is added to the root filesystem directory
+	if cp.b.RootfsLayers == nil {
+		if err := cp.unpackTmpfs(ctx); err != nil {
+			return nil, fmt.Errorf("while unpacking tmpfs: %v", err)
+		}
 	}
 
-	err = cp.insertBaseEnv()
+	err := cp.insertBaseEnv()
 	if err != nil {
 		return nil, fmt.Errorf("while inserting base environment: %v", err)
 	}
@@ -183,12 +247,35 @@ func (cp *OCIConveyorPacker) Pack(ctx context.Context) (*sytypes.Bundle, error)
 }
 
 func (cp *OCIConveyorPacker) fetch(ctx context.Context) error {
+	// report the blobs fetched into the cache and the temporary layout
+	progress, done := cp.b.Opts.Events.CopyProgress()
+	defer done()
+	if ref, ok := cp.srcRef.(*oci.ImageReference); ok {
+		ref.Progress = progress
+		ref.Downloads = cp.b.Opts.Downloads
+	}
+
 	// cp.srcRef contains the cache source reference
-	_, err := copy.Image(ctx, cp.policyCtx, cp.tmpfsRef, cp.srcRef, &copy.Options{
-		ReportWriter: ioutil.Discard,
-		SourceCtx:    cp.sysCtx,
+	m, err := copy.Image(ctx, cp.policyCtx, cp.tmpfsRef, cp.srcRef, &copy.Options{
+		ReportWriter:     ioutil.Discard,
+		SourceCtx:        cp.sysCtx,
+		Progress:         progress,
+		ProgressInterval: events.ProgressInterval,
 	})
-	return err
+	if err != nil {
+		return err
+	}
+
+	// record the digest of the selected image manifest for provenance
+	d, err := manifest.Digest(m)
+	if err != nil {
+		return fmt.Errorf("while computing manifest digest: %v", err)
+	}
+	cp.b.BaseImage = &sytypes.BaseImage{
+		Source: cp.b.Recipe.Header["bootstrap"] + "://" + cp.b.Recipe.Header["from"],
+		Digest: d.String(),
+	}
+	return nil
 }
 
 func (cp *OCIConveyorPacker) getConfig(ctx context.Context) (imgspecv1.ImageConfig, error) {
@@ -198,14 +285,31 @@ func (cp *OCIConveyorPacker) getConfig(ctx context.Context) (imgspecv1.ImageConf
 	}
 	defer img.Close()
 
-	imgSpec, err := img.OCIConfig(ctx)
+	imgSpec, err := cp.imageSpec(ctx, img)
 	if err != nil {
 		return imgspecv1.ImageConfig{}, err
 	}
-
 	return imgSpec.Config, nil
 }
 
+// imageSpec returns the OCI configuration of img.
+func (cp *OCIConveyorPacker) imageSpec(ctx context.Context, img types.Image) (*imgspecv1.Image, error) {
+	imgSpec, err := img.OCIConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// single platform sources are not selected by the platform,
+	// make sure they match
+	if cp.sysCtx.ArchitectureChoice != "" {
+		if imgSpec.Architecture != cp.sysCtx.ArchitectureChoice || imgSpec.OS != cp.sysCtx.OSChoice {
+			return nil, fmt.Errorf("image platform %s/%s doesn't match the requested platform %s", imgSpec.OS, imgSpec.Architecture, cp.b.Opts.Platform)
+		}
+	}
+
+	return imgSpec, nil
+}
+
 func (cp *OCIConveyorPacker) insertOCIConfig() error {
 	conf, err := json.Marshal(cp.imgConfig)
 	if err != nil {
@@ -39,6 +39,10 @@ func (cp *OrasConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err err
 		return fmt.Errorf("while inserting base environment: %v", err)
 	}
 
+	if err = setBaseImage(b, imagePath); err != nil {
+		return err
+	}
+
 	cp.LocalPacker, err = GetLocalPacker(imagePath, b)
 	return err
 }
@@ -39,6 +39,10 @@ func (cp *ShubConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err err
 		return fmt.Errorf("while inserting base environment: %v", err)
 	}
 
+	if err = setBaseImage(cp.b, imagePath); err != nil {
+		return err
+	}
+
 	cp.LocalPacker, err = GetLocalPacker(imagePath, cp.b)
 
 	return err
@@ -0,0 +1,225 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sylabs/singularity/internal/pkg/cache"
+	"github.com/sylabs/singularity/internal/pkg/util/shell"
+	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+const (
+	// spackEnvironment is the location of the spack environment in the container
+	spackEnvironment = "/opt/spack-environment"
+	// spackBuildCache is the location of the build cache bound in the container
+	spackBuildCache = spackEnvironment + "/build-cache"
+	// spackCacheMirror is the name of the build cache mirror
+	spackCacheMirror = "singularity-build-cache"
+)
+
+// Labels recording the spack build inputs.
+const (
+	spackSpecsLabel = "org.label-schema.usage.singularity.spack.specs"
+	spackFileLabel  = "org.label-schema.usage.singularity.spack.file"
+)
+
+// SpackConveyorPacker pulls the docker base image specified by the From
+// header, which must provide spack, and installs the specs of the Spec
+// header or the environment of the SpackFile header in the container. The
+// installation runs in the container before the %post section, built
+// packages are pushed to a build cache kept in the singularity cache.
+type SpackConveyorPacker struct {
+	OCIConveyorPacker
+	// specs are the specs to install
+	specs []string
+	// file is the spack.yaml environment file
+	file string
+	// mirrors are the additional binary mirrors
+	mirrors []string
+	// labels record the build inputs
+	labels map[string]string
+}
+
+// Get downloads the base image and checks the spack environment file.
+func (cp *SpackConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err error) {
+	cp.b = b
+	cp.labels = make(map[string]string)
+
+	cp.specs = spackSpecs(b.Recipe.Header["spec"])
+	cp.file = b.Recipe.Header["spackfile"]
+	cp.mirrors = strings.Fields(b.Recipe.Header["mirror"])
+
+	switch {
+	case len(cp.specs) > 0 && cp.file != "":
+		return fmt.Errorf("invalid spack header, Spec and SpackFile are mutually exclusive")
+	case len(cp.specs) == 0 && cp.file == "":
+		return fmt.Errorf("invalid spack header, no Spec or SpackFile specified")
+	case b.Recipe.Header["from"] == "":
+		return fmt.Errorf("invalid spack header, no From base image specified")
+	case cp.file != "":
+		content, err := ioutil.ReadFile(cp.file)
+		if err != nil {
+			return fmt.Errorf("while reading spack environment file: %v", err)
+		}
+		cp.labels[spackFileLabel] = fmt.Sprintf("%s sha256:%x", cp.file, sha256.Sum256(content))
+	default:
+		cp.labels[spackSpecsLabel] = strings.Join(cp.specs, ", ")
+	}
+
+	return cp.getDockerBase(ctx, b)
+}
+
+// Pack puts relevant objects in a Bundle.
+func (cp *SpackConveyorPacker) Pack(ctx context.Context) (*types.Bundle, error) {
+	b, err := cp.OCIConveyorPacker.Pack(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cp.insertEnvironment(); err != nil {
+		return nil, fmt.Errorf("while inserting spack environment: %v", err)
+	}
+
+	cached, err := cp.bindBuildCache()
+	if err != nil {
+		return nil, fmt.Errorf("while binding spack build cache: %v", err)
+	}
+
+	// the installation runs in the container before the %post section
+	post := &b.Recipe.BuildData.Post
+	post.Script = spackInstallScript(cp.mirrors, cached) + post.Script
+
+	if err := mergeLabels(b.RootfsPath, cp.labels); err != nil {
+		return nil, fmt.Errorf("while inserting spack labels: %v", err)
+	}
+
+	return b, nil
+}
+
+// insertEnvironment writes the spack.yaml environment file in the container.
+func (cp *SpackConveyorPacker) insertEnvironment() error {
+	env := filepath.Join(cp.b.RootfsPath, spackEnvironment)
+	if err := os.MkdirAll(env, 0755); err != nil {
+		return err
+	}
+
+	var content []byte
+
+	if cp.file != "" {
+		b, err := ioutil.ReadFile(cp.file)
+		if err != nil {
+			return err
+		}
+		content = b
+	} else {
+		content = spackYAML(cp.specs)
+	}
+
+	return ioutil.WriteFile(filepath.Join(env, "spack.yaml"), content, 0644)
+}
+
+// bindBuildCache binds the build cache of the singularity cache in the
+// container, it returns false if caching is disabled.
+func (cp *SpackConveyorPacker) bindBuildCache() (bool, error) {
+	if cp.b.Opts.NoCache || cp.b.Opts.ImgCache == nil || cp.b.Opts.ImgCache.IsDisabled() {
+		return false, nil
+	}
+
+	dir, err := cp.b.Opts.ImgCache.GetFileCacheDir(cache.SpackCacheType)
+	if err != nil {
+		return false, err
+	}
+	if err := os.MkdirAll(filepath.Join(cp.b.RootfsPath, spackBuildCache), 0755); err != nil {
+		return false, err
+	}
+
+	sylog.Debugf("Using spack build cache %s", dir)
+	cp.b.PostBinds = append(cp.b.PostBinds, dir+":"+spackBuildCache)
+
+	return true, nil
+}
+
+// spackSpecs returns the specs of the Spec header separated by commas.
+func spackSpecs(header string) []string {
+	var specs []string
+	for _, s := range strings.Split(header, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			specs = append(specs, s)
+		}
+	}
+	return specs
+}
+
+// spackYAML returns the environment file installing the specs with a
+// view in /opt/view.
+func spackYAML(specs []string) []byte {
+	var y strings.Builder
+
+	y.WriteString("spack:\n  specs:\n")
+	for _, s := range specs {
+		fmt.Fprintf(&y, "  - %s\n", strconv.Quote(s))
+	}
+	y.WriteString("  concretization: together\n")
+	y.WriteString("  config:\n    install_tree: /opt/software\n")
+	y.WriteString("  view: /opt/view\n")
+
+	return []byte(y.String())
+}
+
+// spackInstallScript returns the script installing the environment in the
+// container, the concretized environment is copied in the container
+// metadata and the packages are pushed to the build cache if cached is true.
+func spackInstallScript(mirrors []string, cached bool) string {
+	var s strings.Builder
+
+	s.WriteString("# spack bootstrap agent\n")
+	s.WriteString("if command -v spack >/dev/null 2>&1; then SPACK=spack; else SPACK=\"${SPACK_ROOT:-/opt/spack}/bin/spack\"; fi\n")
+	fmt.Fprintf(&s, "cd %s\n", spackEnvironment)
+
+	for i, m := range mirrors {
+		fmt.Fprintf(&s, "$SPACK mirror add --scope site mirror%d %s\n", i, shell.ArgsQuoted([]string{m}))
+	}
+	if cached {
+		fmt.Fprintf(&s, "$SPACK mirror add --scope site %s file://%s\n", spackCacheMirror, spackBuildCache)
+	}
+
+	s.WriteString("$SPACK -e . concretize -f\n")
+	if len(mirrors) > 0 || cached {
+		s.WriteString("$SPACK -e . install --fail-fast --no-check-signature\n")
+	} else {
+		s.WriteString("$SPACK -e . install --fail-fast\n")
+	}
+
+	if cached {
+		// packages already in the build cache are skipped
+		s.WriteString("for h in $($SPACK -e . find --format '{hash}'); do\n")
+		fmt.Fprintf(&s, "    $SPACK buildcache create --allow-root --unsigned --only package -d %s /$h || :\n", spackBuildCache)
+		s.WriteString("done\n")
+		fmt.Fprintf(&s, "$SPACK buildcache update-index -d %s\n", spackBuildCache)
+		fmt.Fprintf(&s, "$SPACK mirror remove --scope site %s\n", spackCacheMirror)
+	}
+	for i := range mirrors {
+		fmt.Fprintf(&s, "$SPACK mirror remove --scope site mirror%d\n", i)
+	}
+
+	s.WriteString("$SPACK -e . gc -y\n")
+	s.WriteString("$SPACK clean -a\n")
+	fmt.Fprintf(&s, "$SPACK env activate --sh -d %s > /.singularity.d/env/20-spack.sh\n", spackEnvironment)
+	fmt.Fprintf(&s, "cp %s/spack.lock %s\n", spackEnvironment, types.JSONObjectFiles[types.SpackLockJSON])
+	s.WriteString("cd /\n\n")
+
+	return s.String()
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSpackSpecs(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected []string
+	}{
+		{"", nil},
+		{"zlib", []string{"zlib"}},
+		{"hdf5+mpi ^openmpi@4.0.5, py-numpy ,", []string{"hdf5+mpi ^openmpi@4.0.5", "py-numpy"}},
+	}
+
+	for _, tt := range tests {
+		if specs := spackSpecs(tt.header); !reflect.DeepEqual(specs, tt.expected) {
+			t.Errorf("unexpected specs %v for %q", specs, tt.header)
+		}
+	}
+}
+
+func TestSpackYAML(t *testing.T) {
+	y := string(spackYAML([]string{"hdf5+mpi", `zlib cflags="-O3"`}))
+
+	for _, s := range []string{"  - \"hdf5+mpi\"\n", `  - "zlib cflags=\"-O3\""` + "\n", "  view: /opt/view\n"} {
+		if !strings.Contains(y, s) {
+			t.Errorf("%q not found in environment file:\n%s", s, y)
+		}
+	}
+}
+
+func TestSpackInstallScript(t *testing.T) {
+	tests := []struct {
+		name        string
+		mirrors     []string
+		cached      bool
+		contains    []string
+		notContains []string
+	}{
+		{
+			name:        "no cache",
+			contains:    []string{"install --fail-fast\n", "cp /opt/spack-environment/spack.lock /.singularity.d/spack.lock"},
+			notContains: []string{"mirror add", "buildcache", "--no-check-signature"},
+		},
+		{
+			name:     "cache",
+			cached:   true,
+			contains: []string{"mirror add --scope site singularity-build-cache file:///opt/spack-environment/build-cache", "buildcache create", "--no-check-signature"},
+		},
+		{
+			name:        "mirror",
+			mirrors:     []string{"https://mirror.example.com/spack"},
+			contains:    []string{"mirror add --scope site mirror0 \"https://mirror.example.com/spack\"", "mirror remove --scope site mirror0"},
+			notContains: []string{"buildcache create"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := spackInstallScript(tt.mirrors, tt.cached)
+			for _, s := range tt.contains {
+				if !strings.Contains(script, s) {
+					t.Errorf("%q not found in script:\n%s", s, script)
+				}
+			}
+			for _, s := range tt.notContains {
+				if strings.Contains(script, s) {
+					t.Errorf("unexpected %q in script:\n%s", s, script)
+				}
+			}
+		})
+	}
+}
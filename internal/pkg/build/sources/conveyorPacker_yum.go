@@ -19,6 +19,7 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/sylog"
 )
@@ -29,13 +30,19 @@ const (
 
 // YumConveyor holds stuff that needs to be packed into the bundle
 type YumConveyor struct {
-	b         *types.Bundle
-	rpmPath   string
-	mirrorurl string
-	updateurl string
-	osversion string
-	include   string
-	gpg       string
+	b              *types.Bundle
+	rpmPath        string
+	installPath    string
+	mirrorurls     []string
+	updateurls     []string
+	osversion      string
+	include        string
+	gpgkeys        []string
+	modulestreams  []string
+	bootstrapImage string
+	// keyDir holds the verified GPG keys imported in the rpm database
+	keyDir   string
+	keyFiles []string
 }
 
 // YumConveyorPacker only needs to hold the conveyor to have the needed data to pack
@@ -47,25 +54,40 @@ type YumConveyorPacker struct {
 func (c *YumConveyor) Get(ctx context.Context, b *types.Bundle) (err error) {
 	c.b = b
 
-	// check for dnf or yum on system
-	var installCommandPath string
-	if installCommandPath, err = exec.LookPath("dnf"); err == nil {
-		sylog.Debugf("Found dnf at: %v", installCommandPath)
-	} else if installCommandPath, err = exec.LookPath("yum"); err == nil {
-		sylog.Debugf("Found yum at: %v", installCommandPath)
+	err = c.getBootstrapOptions()
+	if err != nil {
+		return fmt.Errorf("while getting bootstrap options: %v", err)
+	}
+
+	if c.bootstrapImage != "" {
+		// dnf and rpm are run from the bootstrap image
+		sylog.Infof("Running dnf from bootstrap image %s", c.bootstrapImage)
+		c.installPath = "dnf"
+		c.rpmPath = "rpm"
 	} else {
-		return fmt.Errorf("neither yum nor dnf in path")
+		// check for dnf or yum on system
+		if c.installPath, err = exec.LookPath("dnf"); err == nil {
+			sylog.Debugf("Found dnf at: %v", c.installPath)
+		} else if c.installPath, err = exec.LookPath("yum"); err == nil {
+			sylog.Debugf("Found yum at: %v", c.installPath)
+		} else {
+			return fmt.Errorf("neither yum nor dnf in path")
+		}
+
+		// check for rpm on system
+		err = c.getRPMPath()
+		if err != nil {
+			return fmt.Errorf("while checking rpm path: %v", err)
+		}
 	}
 
-	// check for rpm on system
-	err = c.getRPMPath()
-	if err != nil {
-		return fmt.Errorf("while checking rpm path: %v", err)
+	if len(c.modulestreams) > 0 && !c.isDNF() {
+		return fmt.Errorf("module streams require dnf, found %s", c.installPath)
 	}
 
-	err = c.getBootstrapOptions()
+	err = c.prepareGPGKeys(ctx)
 	if err != nil {
-		return fmt.Errorf("while getting bootstrap options: %v", err)
+		return fmt.Errorf("while verifying gpg keys: %v", err)
 	}
 
 	err = c.genYumConfig()
@@ -78,12 +100,24 @@ func (c *YumConveyor) Get(ctx context.Context, b *types.Bundle) (err error) {
 		return fmt.Errorf("while copying pseudo devices: %v", err)
 	}
 
-	args := []string{`--noplugins`, `-c`, filepath.Join(c.b.RootfsPath, yumConf), `--installroot`, c.b.RootfsPath, `--releasever=` + c.osversion, `-y`, `install`}
-	args = append(args, strings.Fields(c.include)...)
+	sylog.Debugf("\n\tInstall Command Path: %s\n\tBootstrap Image: %s\n\tDetected Arch: %s\n\tOSVersion: %s\n\tMirrorURL: %s\n\tUpdateURL: %s\n\tModuleStreams: %s\n\tIncludes: %s\n", c.installPath, c.bootstrapImage, runtime.GOARCH, c.osversion, strings.Join(c.mirrorurls, " "), strings.Join(c.updateurls, " "), strings.Join(c.modulestreams, " "), c.include)
+
+	// enable the module streams before the install so that the
+	// packages are picked from them
+	if len(c.modulestreams) > 0 {
+		args := append(c.installArgs(), `module`, `enable`)
+		args = append(args, c.modulestreams...)
+		cmd := c.command(ctx, c.installPath, args...)
+		cmd.Stderr = os.Stderr
+		if err = cmd.Run(); err != nil {
+			return fmt.Errorf("while enabling module streams: %v", err)
+		}
+	}
 
 	// Do the install
-	sylog.Debugf("\n\tInstall Command Path: %s\n\tDetected Arch: %s\n\tOSVersion: %s\n\tMirrorURL: %s\n\tUpdateURL: %s\n\tIncludes: %s\n", installCommandPath, runtime.GOARCH, c.osversion, c.mirrorurl, c.updateurl, c.include)
-	cmd := exec.Command(installCommandPath, args...)
+	args := append(c.installArgs(), `install`)
+	args = append(args, strings.Fields(c.include)...)
+	cmd := c.command(ctx, c.installPath, args...)
 	// cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err = cmd.Run(); err != nil {
@@ -160,28 +194,47 @@ func (c *YumConveyor) getRPMPath() (err error) {
 func (c *YumConveyor) getBootstrapOptions() (err error) {
 	var ok bool
 
-	// look for http_proxy and gpg environment vars
-	c.gpg = os.Getenv("GPG")
-
 	// get mirrorURL, updateURL, OSVerison, and Includes components to definition
-	c.mirrorurl, ok = c.b.Recipe.Header["mirrorurl"]
+	mirrorurl, ok := c.b.Recipe.Header["mirrorurl"]
 	if !ok {
 		return fmt.Errorf("invalid yum header, no mirrorurl specified")
 	}
 
-	c.updateurl = c.b.Recipe.Header["updateurl"]
+	updateurl := c.b.Recipe.Header["updateurl"]
 
 	// look for an OS version if a mirror specifies it
 	regex := regexp.MustCompile(`(?i)%{OSVERSION}`)
-	if regex.MatchString(c.mirrorurl) || regex.MatchString(c.updateurl) {
+	if regex.MatchString(mirrorurl) || regex.MatchString(updateurl) {
 		c.osversion, ok = c.b.Recipe.Header["osversion"]
 		if !ok {
 			return fmt.Errorf("invalid yum header, osversion referenced in mirror but no osversion specified")
 		}
-		c.mirrorurl = regex.ReplaceAllString(c.mirrorurl, c.osversion)
-		c.updateurl = regex.ReplaceAllString(c.updateurl, c.osversion)
+		mirrorurl = regex.ReplaceAllString(mirrorurl, c.osversion)
+		updateurl = regex.ReplaceAllString(updateurl, c.osversion)
+	}
+
+	// the mirrors are tried in order
+	c.mirrorurls = splitList(mirrorurl)
+	if len(c.mirrorurls) == 0 {
+		return fmt.Errorf("invalid yum header, no mirrorurl specified")
+	}
+	c.updateurls = splitList(updateurl)
+
+	// the GPG environment variable is used without gpgkey header
+	c.gpgkeys = splitList(c.b.Recipe.Header["gpgkey"])
+	if len(c.gpgkeys) == 0 {
+		c.gpgkeys = splitList(os.Getenv("GPG"))
+	}
+
+	c.modulestreams = splitList(c.b.Recipe.Header["modulestreams"])
+	for _, stream := range c.modulestreams {
+		if !strings.Contains(stream, ":") {
+			return fmt.Errorf("invalid yum header, module stream %s is not in the name:stream format", stream)
+		}
 	}
 
+	c.bootstrapImage = strings.TrimSpace(c.b.Recipe.Header["bootstrapimage"])
+
 	include := c.b.Recipe.Header["include"]
 
 	// check for include environment variable and add it to requires string
@@ -198,6 +251,74 @@ func (c *YumConveyor) getBootstrapOptions() (err error) {
 	return nil
 }
 
+// isDNF returns true if the packages are installed with dnf.
+func (c *YumConveyor) isDNF() bool {
+	return filepath.Base(c.installPath) == "dnf"
+}
+
+// installArgs returns the dnf or yum arguments common to all commands.
+func (c *YumConveyor) installArgs() []string {
+	return []string{`--noplugins`, `-c`, filepath.Join(c.b.RootfsPath, yumConf), `--installroot`, c.b.RootfsPath, `--releasever=` + c.osversion, `-y`}
+}
+
+// command returns the command running name on the host, or in the
+// bootstrap image with the container root filesystem and the GPG keys
+// bound at the same paths. Bind mounts are nodev, the pseudo devices
+// of the container root filesystem are mounted over from the bootstrap
+// image /dev for the package scriptlets.
+func (c *YumConveyor) command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	if c.bootstrapImage == "" {
+		return exec.CommandContext(ctx, name, args...)
+	}
+
+	binds := []string{"-B", c.b.RootfsPath}
+	if c.keyDir != "" {
+		binds = append(binds, "-B", c.keyDir)
+	}
+
+	script := `for d in null random urandom zero; do mount --bind /dev/$d "$0/dev/$d" || exit 1; done; exec "$@"`
+
+	exe := filepath.Join(buildcfg.BINDIR, "singularity")
+	cmdArgs := append([]string{"exec"}, binds...)
+	cmdArgs = append(cmdArgs, c.bootstrapImage, "/bin/sh", "-c", script, c.b.RootfsPath, name)
+	cmdArgs = append(cmdArgs, args...)
+
+	return exec.CommandContext(ctx, exe, cmdArgs...)
+}
+
+// prepareGPGKeys downloads and verifies the GPG keys to keyDir.
+func (c *YumConveyor) prepareGPGKeys(ctx context.Context) (err error) {
+	if len(c.gpgkeys) == 0 {
+		return nil
+	}
+	c.keyDir = filepath.Join(c.b.TmpDir, "yum-gpgkeys")
+	c.keyFiles, err = fetchGPGKeys(ctx, c.keyDir, c.gpgkeys)
+	return err
+}
+
+// repoConfig returns the configuration of the repository using the
+// mirrors urls in order.
+func (c *YumConveyor) repoConfig(id, name string, urls []string) string {
+	fileContent := "[" + id + "]\n"
+	fileContent += "name=" + name + "\n"
+	// mirror, the following ones are continuation lines
+	fileContent += "baseurl=" + strings.Join(urls, "\n        ") + "\n"
+	if len(urls) > 1 && !c.isDNF() {
+		// dnf always tries the mirrors in order
+		fileContent += "failovermethod=priority\n"
+	}
+	fileContent += "enabled=1\n"
+	// gpg
+	if len(c.keyFiles) > 0 {
+		fileContent += "gpgcheck=1\n"
+		fileContent += "gpgkey=file://" + strings.Join(c.keyFiles, "\n       file://") + "\n"
+	} else {
+		fileContent += "gpgcheck=0\n"
+	}
+	fileContent += "\n"
+	return fileContent
+}
+
 func (c *YumConveyor) genYumConfig() (err error) {
 	fileContent := "[main]\n"
 	fileContent += "cachedir=/var/cache/yum-bootstrap\n"
@@ -208,7 +329,7 @@ func (c *YumConveyor) genYumConfig() (err error) {
 	fileContent += "exactarch=1\n"
 	fileContent += "obsoletes=1\n"
 	// gpg
-	if c.gpg != "" {
+	if len(c.keyFiles) > 0 {
 		fileContent += "gpgcheck=1\n"
 	} else {
 		fileContent += "gpgcheck=0\n"
@@ -217,33 +338,11 @@ func (c *YumConveyor) genYumConfig() (err error) {
 	fileContent += "reposdir=0\n"
 	fileContent += "deltarpm=0\n"
 	fileContent += "\n"
-	fileContent += "[base]\n"
-	fileContent += "name=Linux $releasever - $basearch\n"
-	// mirror
-	if c.mirrorurl != "" {
-		fileContent += "baseurl=" + c.mirrorurl + "\n"
-	}
-	fileContent += "enabled=1\n"
-	// gpg
-	if c.gpg != "" {
-		fileContent += "gpgcheck=1\n"
-	} else {
-		fileContent += "gpgcheck=0\n"
-	}
+	fileContent += c.repoConfig("base", "Linux $releasever - $basearch", c.mirrorurls)
 
 	// add update section if updateurl is specified
-	if c.updateurl != "" {
-		fileContent += "[updates]\n"
-		fileContent += "name=Linux $releasever - $basearch updates\n"
-		fileContent += "baseurl=" + c.updateurl + "\n"
-		fileContent += "enabled=1\n"
-		// gpg
-		if c.gpg != "" {
-			fileContent += "gpgcheck=1\n"
-		} else {
-			fileContent += "gpgcheck=0\n"
-		}
-		fileContent += "\n"
+	if len(c.updateurls) > 0 {
+		fileContent += c.repoConfig("updates", "Linux $releasever - $basearch updates", c.updateurls)
 	}
 
 	err = os.Mkdir(filepath.Join(c.b.RootfsPath, "/etc"), 0775)
@@ -256,14 +355,14 @@ func (c *YumConveyor) genYumConfig() (err error) {
 		return fmt.Errorf("while creating %v: %v", filepath.Join(c.b.RootfsPath, yumConf), err)
 	}
 
-	// if gpg key is specified, import it
-	if c.gpg != "" {
+	// if gpg keys are specified, import them
+	if len(c.keyFiles) > 0 {
 		err = c.importGPGKey()
 		if err != nil {
 			return fmt.Errorf("while importing gpg key: %v", err)
 		}
 	} else {
-		sylog.Infof("Skipping GPG Key Import")
+		sylog.Warningf("No GPG key specified, packages signatures won't be checked")
 	}
 
 	return nil
@@ -272,24 +371,17 @@ func (c *YumConveyor) genYumConfig() (err error) {
 func (c *YumConveyor) importGPGKey() (err error) {
 	sylog.Infof("We have a GPG key!  Preparing RPM database.")
 
-	// make sure gpg is being imported over https
-	if !strings.HasPrefix(c.gpg, "https://") {
-		return fmt.Errorf("gpg key must be fetched with https")
-	}
-
-	// make sure curl is installed so rpm can import gpg key
-	if _, err = exec.LookPath("curl"); err != nil {
-		return fmt.Errorf("neither yum nor dnf in path")
-	}
+	ctx := context.Background()
 
-	cmd := exec.Command(c.rpmPath, "--root", c.b.RootfsPath, "--initdb")
+	cmd := c.command(ctx, c.rpmPath, "--root", c.b.RootfsPath, "--initdb")
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err = cmd.Run(); err != nil {
 		return fmt.Errorf("while initializing new rpm db: %v", err)
 	}
 
-	cmd = exec.Command(c.rpmPath, "--root", c.b.RootfsPath, "--import", c.gpg)
+	args := append([]string{"--root", c.b.RootfsPath, "--import"}, c.keyFiles...)
+	cmd = c.command(ctx, c.rpmPath, args...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	if err = cmd.Run(); err != nil {
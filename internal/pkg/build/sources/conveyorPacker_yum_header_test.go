@@ -0,0 +1,146 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+	"golang.org/x/crypto/openpgp"
+)
+
+func TestYumHeader(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  map[string]string
+		mirrors []string
+		updates []string
+		streams []string
+		image   string
+		wantErr bool
+	}{
+		{
+			name: "mirror list",
+			header: map[string]string{
+				"osversion":      "9",
+				"mirrorurl":      "https://repo.almalinux.org/almalinux/%{OSVERSION}/BaseOS/x86_64/os/, https://mirror.example.com/%{OSVERSION}/BaseOS/x86_64/os/",
+				"updateurl":      "https://repo.almalinux.org/almalinux/%{OSVERSION}/AppStream/x86_64/os/",
+				"modulestreams":  "nodejs:18 postgresql:15",
+				"bootstrapimage": "docker://almalinux:9",
+			},
+			mirrors: []string{"https://repo.almalinux.org/almalinux/9/BaseOS/x86_64/os/", "https://mirror.example.com/9/BaseOS/x86_64/os/"},
+			updates: []string{"https://repo.almalinux.org/almalinux/9/AppStream/x86_64/os/"},
+			streams: []string{"nodejs:18", "postgresql:15"},
+			image:   "docker://almalinux:9",
+		},
+		{
+			name:    "missing osversion",
+			header:  map[string]string{"mirrorurl": "https://repo.almalinux.org/almalinux/%{OSVERSION}/BaseOS/x86_64/os/"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid module stream",
+			header:  map[string]string{"mirrorurl": "https://repo.almalinux.org/almalinux/9/BaseOS/x86_64/os/", "modulestreams": "nodejs"},
+			wantErr: true,
+		},
+	}
+
+	os.Unsetenv("GPG")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &YumConveyor{b: &types.Bundle{Recipe: types.Definition{Header: tt.header}}}
+			err := c.getBootstrapOptions()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(c.mirrorurls, tt.mirrors) {
+				t.Errorf("unexpected mirrors %q instead of %q", c.mirrorurls, tt.mirrors)
+			}
+			if !reflect.DeepEqual(c.updateurls, tt.updates) {
+				t.Errorf("unexpected updates %q instead of %q", c.updateurls, tt.updates)
+			}
+			if !reflect.DeepEqual(c.modulestreams, tt.streams) {
+				t.Errorf("unexpected module streams %q instead of %q", c.modulestreams, tt.streams)
+			}
+			if c.bootstrapImage != tt.image {
+				t.Errorf("unexpected bootstrap image %q instead of %q", c.bootstrapImage, tt.image)
+			}
+		})
+	}
+}
+
+func TestYumGPGKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yum-gpgkeys-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var binary bytes.Buffer
+	e, err := openpgp.NewEntity("yum", "", "yum@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Serialize(&binary); err != nil {
+		t.Fatal(err)
+	}
+	key := filepath.Join(dir, "RPM-GPG-KEY")
+	if err := ioutil.WriteFile(key, binary.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid")
+	if err := ioutil.WriteFile(invalid, []byte("not a key"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, keys := range [][]string{{invalid}, {"http://example.com/RPM-GPG-KEY"}} {
+		c := &YumConveyor{b: &types.Bundle{TmpDir: dir}, gpgkeys: keys}
+		if err := c.prepareGPGKeys(context.Background()); err == nil {
+			t.Errorf("unexpected success for %v", keys)
+		}
+	}
+
+	c := &YumConveyor{
+		b:          &types.Bundle{TmpDir: dir},
+		gpgkeys:    []string{key},
+		mirrorurls: []string{"https://one.example.com/os/", "https://two.example.com/os/"},
+	}
+	if err := c.prepareGPGKeys(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.keyFiles) != 1 {
+		t.Fatalf("unexpected key files %v", c.keyFiles)
+	}
+	keyring, err := readKeyRing(c.keyFiles[0])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if keyring[0].PrimaryKey.Fingerprint != e.PrimaryKey.Fingerprint {
+		t.Errorf("unexpected key %X", keyring[0].PrimaryKey.Fingerprint)
+	}
+
+	c.installPath = "/usr/bin/yum"
+	conf := c.repoConfig("base", "Linux", c.mirrorurls)
+	for _, want := range []string{
+		"baseurl=https://one.example.com/os/\n        https://two.example.com/os/\n",
+		"failovermethod=priority\n",
+		"gpgcheck=1\ngpgkey=file://" + c.keyFiles[0] + "\n",
+	} {
+		if !strings.Contains(conf, want) {
+			t.Errorf("repository configuration doesn't contain %q:\n%s", want, conf)
+		}
+	}
+}
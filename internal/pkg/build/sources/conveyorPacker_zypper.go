@@ -16,12 +16,15 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
 
 	"github.com/docker/docker/pkg/system"
+	"github.com/sylabs/singularity/internal/pkg/plugin"
 	"github.com/sylabs/singularity/pkg/build/types"
+	buildcallback "github.com/sylabs/singularity/pkg/plugin/callback/build"
 	"github.com/sylabs/singularity/pkg/sylog"
 )
 
@@ -81,6 +84,20 @@ func (cp *ZypperConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err e
 	// add aaa_base to start of include list by default
 	include = `aaa_base ` + include
 
+	// patterns are installed along with the packages
+	for _, pattern := range splitList(cp.b.Recipe.Header["patterns"]) {
+		include += ` pattern:` + pattern
+	}
+
+	repos, err := zypperRepos(cp.b.Recipe.Header, "repo")
+	if err != nil {
+		return fmt.Errorf("invalid zypper header: %v", err)
+	}
+	services, err := zypperRepos(cp.b.Recipe.Header, "service")
+	if err != nil {
+		return fmt.Errorf("invalid zypper header: %v", err)
+	}
+
 	// get mirrorURL, OSVerison, and Includes components to definition
 	osversion, osversionOk := cp.b.Recipe.Header["osversion"]
 	mirrorurl, mirrorurlOk := cp.b.Recipe.Header["mirrorurl"]
@@ -113,8 +130,9 @@ func (cp *ZypperConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err e
 	regex := regexp.MustCompile(`(?i)%{OSVERSION}`)
 
 	if sleproductOk || sleuserOk || sleregcodeOk {
-		if !sleproductOk || !sleuserOk || !sleregcodeOk {
-			return fmt.Errorf("for installation of SLE 'Product', 'User' and 'Regcode' need to be set")
+		// a RMT server registers the products without credentials
+		if !sleproductOk || (!sleurlOk && (!sleuserOk || !sleregcodeOk)) {
+			return fmt.Errorf("for installation of SLE 'Product', 'User' and 'Regcode' need to be set, 'User' and 'Regcode' are optional with a RMT 'RegisterURL'")
 		}
 		if !osversionOk {
 			return fmt.Errorf("invalid zypper header, OSVersion always required for SLE")
@@ -210,34 +228,17 @@ func (cp *ZypperConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err e
 		return fmt.Errorf("while copying pseudo devices: %v", err)
 	}
 
-	// Add mirrorURL/installURL as repo
-	if mirrorurl != "" {
-		cmd := exec.Command(zypperPath, `--root`, cp.b.RootfsPath, `ar`, mirrorurl, `repo`)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err = cmd.Run(); err != nil {
-			return fmt.Errorf("while adding zypper mirror: %v", err)
-		}
-		// Refreshing gpg keys
-		cmd = exec.Command(zypperPath, `--root`, cp.b.RootfsPath, `--gpg-auto-import-keys`, `refresh`)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		if err = cmd.Run(); err != nil {
-			return fmt.Errorf("while refreshing gpg keys: %v", err)
-		}
-		if updateurl != "" {
-			cmd := exec.Command(zypperPath, `--root`, cp.b.RootfsPath, `ar`, `-f`, updateurl, `update`)
-			cmd.Stdout = os.Stdout
-			cmd.Stderr = os.Stderr
-			if err = cmd.Run(); err != nil {
-				return fmt.Errorf("while adding zypper update: %v", err)
-			}
-			cmd = exec.Command(zypperPath, `--root`, cp.b.RootfsPath, `--gpg-auto-import-keys`, `refresh`, `-r`, `update`)
-			if err = cmd.Run(); err != nil {
-				return fmt.Errorf("while refreshing update %v", err)
-			}
+	// the keys of the GPGKey header are imported before the repositories
+	// are refreshed, the repositories keys are not trusted automatically
+	var keys []string
+	if gpgkeys := splitList(cp.b.Recipe.Header["gpgkey"]); len(gpgkeys) > 0 {
+		keys, err = fetchGPGKeys(ctx, filepath.Join(cp.b.TmpDir, "zypper-gpgkeys"), gpgkeys)
+		if err != nil {
+			return fmt.Errorf("while verifying gpg keys: %v", err)
 		}
 	}
+	autoImport := len(keys) == 0
+
 	if pgpfile != "" {
 		rpmbase := "/usr/lib/sysimage"
 		rpmsys := "/var/lib"
@@ -259,22 +260,60 @@ func (cp *ZypperConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err e
 		if err = os.Symlink(rpmrel+rpmbase+`/rpm`, cp.b.RootfsPath+rpmsys+`/rpm`); err != nil {
 			return fmt.Errorf("cannot create rpm symlink")
 		}
-		cmd := exec.Command("rpmkeys", `--root`, cp.b.RootfsPath, `--import`, pgpfile)
+		keys = append(keys, pgpfile)
+	}
+	if len(keys) > 0 {
+		args := append([]string{`--root`, cp.b.RootfsPath, `--import`}, keys...)
+		cmd := exec.Command("rpmkeys", args...)
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		if err = cmd.Run(); err != nil {
 			return fmt.Errorf("while importing pgp keys: %v", err)
 		}
+	}
+	if pgpfile != "" {
 		if err = os.Remove(pgpfile); err != nil {
 			return fmt.Errorf("cannot remove pgpfile")
 		}
 	}
 
+	// Add mirrorURL/installURL as repo
+	if mirrorurl != "" {
+		cmd := exec.Command(zypperPath, `--root`, cp.b.RootfsPath, `ar`, mirrorurl, `repo`)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err = cmd.Run(); err != nil {
+			return fmt.Errorf("while adding zypper mirror: %v", err)
+		}
+		// Refreshing gpg keys
+		cmd = exec.Command(zypperPath, cp.zypperArgs(autoImport, `refresh`)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err = cmd.Run(); err != nil {
+			return fmt.Errorf("while refreshing gpg keys: %v", err)
+		}
+		if updateurl != "" {
+			cmd := exec.Command(zypperPath, `--root`, cp.b.RootfsPath, `ar`, `-f`, updateurl, `update`)
+			cmd.Stdout = os.Stdout
+			cmd.Stderr = os.Stderr
+			if err = cmd.Run(); err != nil {
+				return fmt.Errorf("while adding zypper update: %v", err)
+			}
+			cmd = exec.Command(zypperPath, cp.zypperArgs(autoImport, `refresh`, `-r`, `update`)...)
+			if err = cmd.Run(); err != nil {
+				return fmt.Errorf("while refreshing update %v", err)
+			}
+		}
+	}
 	if suseconnectPath != "" {
 		args := []string{`--root`, cp.b.RootfsPath,
-			`--product`, suseconnectProduct,
-			`--email`, sleuser,
-			`--regcode`, sleregcode}
+			`--product`, suseconnectProduct}
+		if sleuserOk {
+			args = append(args, `--email`, sleuser)
+		}
+		if sleregcodeOk {
+			args = append(args, `--regcode`, sleregcode)
+		}
 		if sleurlOk {
 			args = append(args, `--url`, sleurl)
 		}
@@ -306,11 +345,46 @@ func (cp *ZypperConveyorPacker) Get(ctx context.Context, b *types.Bundle) (err e
 		if err = cmd.Run(); err != nil {
 			return fmt.Errorf("while adding zypper url: %s %v", otherurl[i], err)
 		}
-		cmd = exec.Command(zypperPath, `--root`, cp.b.RootfsPath, `--gpg-auto-import-keys`, `refresh`, `-r`, `repo-`+sID)
+		cmd = exec.Command(zypperPath, cp.zypperArgs(autoImport, `refresh`, `-r`, `repo-`+sID)...)
 		if err = cmd.Run(); err != nil {
 			return fmt.Errorf("while refreshing: %s %v", `repo-`+sID, err)
 		}
 	}
+	for _, r := range repos {
+		cmd := exec.Command(zypperPath, append([]string{`--root`, cp.b.RootfsPath}, r.addArgs()...)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err = cmd.Run(); err != nil {
+			return fmt.Errorf("while adding zypper repository %s: %v", r.alias, err)
+		}
+		cmd = exec.Command(zypperPath, cp.zypperArgs(autoImport, `refresh`, `-r`, r.alias)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err = cmd.Run(); err != nil {
+			return fmt.Errorf("while refreshing zypper repository %s: %v", r.alias, err)
+		}
+	}
+	for _, s := range services {
+		cmd := exec.Command(zypperPath, `--root`, cp.b.RootfsPath, `addservice`, s.url, s.alias)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err = cmd.Run(); err != nil {
+			return fmt.Errorf("while adding zypper service %s: %v", s.alias, err)
+		}
+	}
+	if len(services) > 0 {
+		cmd := exec.Command(zypperPath, cp.zypperArgs(autoImport, `refresh-services`, `--with-repos`)...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err = cmd.Run(); err != nil {
+			return fmt.Errorf("while refreshing zypper services: %v", err)
+		}
+	}
+
+	// registration hooks of the plugins
+	if err = cp.register(ctx); err != nil {
+		return err
+	}
 
 	args := []string{`--non-interactive`, `-c`, filepath.Join(cp.b.RootfsPath, zypperConf), `--root`, cp.b.RootfsPath, `--releasever=` + osversion, `-n`, `install`, `--auto-agree-with-licenses`, `--download-in-advance`}
 	args = append(args, strings.Fields(include)...)
@@ -465,3 +539,123 @@ func rpmPathCheck() (err error) {
 
 	return nil
 }
+
+// zypperRepo is a repository or a service of the Repo&n and Service&n
+// headers in the "<alias> <url> [option=value...]" format.
+type zypperRepo struct {
+	alias    string
+	url      string
+	priority int
+	refresh  bool
+	gpgcheck bool
+}
+
+// zypperRepos returns the repositories of the prefix&n headers in
+// their number order.
+func zypperRepos(header map[string]string, prefix string) ([]zypperRepo, error) {
+	regex := regexp.MustCompile(`^` + prefix + `(\d+)$`)
+
+	var numbers []int
+	values := make(map[int]string)
+	for k, v := range header {
+		m := regex.FindStringSubmatch(k)
+		if m == nil {
+			continue
+		}
+		n, _ := strconv.Atoi(m[1])
+		numbers = append(numbers, n)
+		values[n] = v
+	}
+	sort.Ints(numbers)
+
+	repos := make([]zypperRepo, 0, len(numbers))
+	for _, n := range numbers {
+		fields := strings.Fields(values[n])
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s%d must be in the '<alias> <url> [option=value...]' format", prefix, n)
+		}
+		r := zypperRepo{alias: fields[0], url: fields[1], refresh: true, gpgcheck: true}
+		for _, opt := range fields[2:] {
+			kv := strings.SplitN(opt, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("%s%d option %s is not in the option=value format", prefix, n, opt)
+			}
+			var err error
+			switch kv[0] {
+			case "priority":
+				r.priority, err = strconv.Atoi(kv[1])
+			case "refresh":
+				r.refresh, err = parseYesNo(kv[1])
+			case "gpgcheck":
+				r.gpgcheck, err = parseYesNo(kv[1])
+			default:
+				err = fmt.Errorf("unknown option")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("%s%d option %s: %v", prefix, n, opt, err)
+			}
+		}
+		repos = append(repos, r)
+	}
+	return repos, nil
+}
+
+// parseYesNo parses the yes and no option values along with the
+// strconv.ParseBool ones.
+func parseYesNo(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// addArgs returns the zypper arguments adding the repository.
+func (r zypperRepo) addArgs() []string {
+	args := []string{`ar`}
+	if r.refresh {
+		args = append(args, `-f`)
+	}
+	if r.priority > 0 {
+		args = append(args, `-p`, strconv.Itoa(r.priority))
+	}
+	if !r.gpgcheck {
+		args = append(args, `-G`)
+	}
+	return append(args, r.url, r.alias)
+}
+
+// zypperArgs returns the zypper arguments running the command args in
+// the container root, the repositories keys are imported automatically
+// when autoImport is true, otherwise only the imported keys are trusted.
+func (cp *ZypperConveyorPacker) zypperArgs(autoImport bool, args ...string) []string {
+	opts := []string{`--root`, cp.b.RootfsPath}
+	if autoImport {
+		opts = append(opts, `--gpg-auto-import-keys`)
+	} else {
+		opts = append(opts, `--non-interactive`)
+	}
+	return append(opts, args...)
+}
+
+// register calls the ZypperRegister plugin callbacks.
+func (cp *ZypperConveyorPacker) register(ctx context.Context) error {
+	callbackType := (buildcallback.ZypperRegister)(nil)
+	callbacks, err := plugin.LoadCallbacks(callbackType)
+	if err != nil {
+		return fmt.Errorf("while loading plugins callbacks '%T': %s", callbackType, err)
+	}
+	stage := buildcallback.Stage{
+		Name:       cp.b.Recipe.Header["stage"],
+		RootfsPath: cp.b.RootfsPath,
+		Definition: cp.b.Recipe,
+	}
+	for _, cb := range callbacks {
+		if err := cb.(buildcallback.ZypperRegister)(ctx, stage); err != nil {
+			return fmt.Errorf("plugin callback '%T' failed: %v", callbackType, err)
+		}
+	}
+	return nil
+}
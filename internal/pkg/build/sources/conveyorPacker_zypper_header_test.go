@@ -0,0 +1,79 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+func TestZypperRepos(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  map[string]string
+		args    [][]string
+		wantErr bool
+	}{
+		{
+			name: "repositories",
+			header: map[string]string{
+				"repo10":   "hpc https://rmt.example.com/repo/SLE-Module-HPC/ priority=90",
+				"repo2":    "oss http://download.opensuse.org/distribution/leap/15.2/repo/oss/ refresh=no gpgcheck=no",
+				"otherurl": "ignored",
+			},
+			args: [][]string{
+				{"ar", "-G", "http://download.opensuse.org/distribution/leap/15.2/repo/oss/", "oss"},
+				{"ar", "-f", "-p", "90", "https://rmt.example.com/repo/SLE-Module-HPC/", "hpc"},
+			},
+		},
+		{
+			name:    "missing url",
+			header:  map[string]string{"repo0": "oss"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown option",
+			header:  map[string]string{"repo0": "oss http://download.opensuse.org/ keep=yes"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid priority",
+			header:  map[string]string{"repo0": "oss http://download.opensuse.org/ priority=high"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos, err := zypperRepos(tt.header, "repo")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var args [][]string
+			for _, r := range repos {
+				args = append(args, r.addArgs())
+			}
+			if !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("unexpected arguments %q instead of %q", args, tt.args)
+			}
+		})
+	}
+}
+
+func TestZypperArgs(t *testing.T) {
+	cp := &ZypperConveyorPacker{b: &types.Bundle{RootfsPath: "/rootfs"}}
+
+	want := []string{"--root", "/rootfs", "--gpg-auto-import-keys", "refresh"}
+	if args := cp.zypperArgs(true, "refresh"); !reflect.DeepEqual(args, want) {
+		t.Errorf("unexpected arguments %q instead of %q", args, want)
+	}
+	want = []string{"--root", "/rootfs", "--non-interactive", "refresh", "-r", "repo"}
+	if args := cp.zypperArgs(false, "refresh", "-r", "repo"); !reflect.DeepEqual(args, want) {
+		t.Errorf("unexpected arguments %q instead of %q", args, want)
+	}
+}
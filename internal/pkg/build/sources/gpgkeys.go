@@ -0,0 +1,114 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sylabs/singularity/internal/pkg/build/files"
+	"github.com/sylabs/singularity/pkg/sylog"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/armor"
+)
+
+// fetchGPGKeys downloads the GPG keys from their https URLs or reads
+// them from their paths and checks they are valid PGP public keys
+// before they are trusted by rpm. The keys are stored ASCII armored in
+// dir, their paths are returned in the keys order.
+func fetchGPGKeys(ctx context.Context, dir string, keys []string) ([]string, error) {
+	var urls []string
+	for _, key := range keys {
+		if strings.HasPrefix(key, "https://") {
+			urls = append(urls, key)
+		} else if files.IsURL(key) {
+			// make sure gpg is being imported over https
+			return nil, fmt.Errorf("gpg key %s must be fetched with https", key)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	d, err := files.Fetch(ctx, filepath.Join(dir, "download"), urls, len(urls))
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	for i, key := range keys {
+		path := key
+		if files.IsURL(key) {
+			if path, err = d.Path(key); err != nil {
+				return nil, err
+			}
+		}
+
+		keyring, err := readKeyRing(path)
+		if err != nil {
+			return nil, fmt.Errorf("while reading %s: %v", key, err)
+		}
+		for _, e := range keyring {
+			sylog.Infof("Using GPG key %X from %s", e.PrimaryKey.Fingerprint, key)
+		}
+
+		dst := filepath.Join(dir, fmt.Sprintf("key-%d.asc", i))
+		if err := writeArmoredKeyRing(dst, keyring); err != nil {
+			return nil, fmt.Errorf("while writing %s: %v", dst, err)
+		}
+		paths = append(paths, dst)
+	}
+
+	return paths, nil
+}
+
+// readKeyRing reads the armored or binary PGP public keys at path.
+func readKeyRing(path string) (openpgp.EntityList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyring openpgp.EntityList
+	if bytes.Contains(data, armoredKey) {
+		keyring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		keyring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid PGP public key: %v", err)
+	} else if len(keyring) == 0 {
+		return nil, fmt.Errorf("no PGP public key found")
+	}
+	return keyring, nil
+}
+
+// writeArmoredKeyRing writes the public keys of keyring ASCII armored
+// to path, the format imported by rpm.
+func writeArmoredKeyRing(path string, keyring openpgp.EntityList) error {
+	var buf bytes.Buffer
+
+	w, err := armor.Encode(&buf, openpgp.PublicKeyType, nil)
+	if err != nil {
+		return err
+	}
+	for _, e := range keyring {
+		if err := e.Serialize(w); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	buf.WriteString("\n")
+
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/containers/image/v5/image"
+	"github.com/containers/image/v5/manifest"
+	"github.com/containers/image/v5/pkg/blobinfocache/none"
+	"github.com/containers/image/v5/pkg/compression"
+	"github.com/containers/image/v5/types"
+	"github.com/opencontainers/go-digest"
+	"github.com/sylabs/singularity/internal/pkg/build/oci"
+	sytypes "github.com/sylabs/singularity/pkg/build/types"
+)
+
+// ociLayers are the layers of an OCI image read from its source when
+// they're requested by the assembler.
+type ociLayers struct {
+	src   types.ImageSource
+	infos []types.BlobInfo
+	arch  string
+}
+
+// Len returns the number of layers.
+func (l *ociLayers) Len() int {
+	return len(l.infos)
+}
+
+// Open returns the uncompressed tar stream of the layer i.
+func (l *ociLayers) Open(ctx context.Context, i int) (io.ReadCloser, error) {
+	blob, _, err := l.src.GetBlob(ctx, l.infos[i], none.NoCache)
+	if err != nil {
+		return nil, fmt.Errorf("while fetching layer %s: %v", l.infos[i].Digest, err)
+	}
+	r, _, err := compression.AutoDecompress(blob)
+	if err != nil {
+		blob.Close()
+		return nil, fmt.Errorf("while decompressing layer %s: %v", l.infos[i].Digest, err)
+	}
+	return &layerReader{ReadCloser: r, blob: blob}, nil
+}
+
+// Arch returns the architecture of the image.
+func (l *ociLayers) Arch() string {
+	return l.arch
+}
+
+// Close closes the image source.
+func (l *ociLayers) Close() error {
+	return l.src.Close()
+}
+
+// layerReader closes both the decompressed stream and the layer blob.
+type layerReader struct {
+	io.ReadCloser
+	blob io.Closer
+}
+
+func (r *layerReader) Close() error {
+	r.ReadCloser.Close()
+	return r.blob.Close()
+}
+
+// stream opens the image source so that the assembler streams its layers
+// to mksquashfs, instead of fetching the image to unpack it.
+func (cp *OCIConveyorPacker) stream(ctx context.Context) (err error) {
+	// report the blobs fetched into the cache
+	progress, done := cp.b.Opts.Events.CopyProgress()
+	defer done()
+	if ref, ok := cp.srcRef.(*oci.ImageReference); ok {
+		ref.Progress = progress
+		ref.Downloads = cp.b.Opts.Downloads
+	}
+
+	src, err := cp.srcRef.NewImageSource(ctx, cp.sysCtx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			src.Close()
+		}
+	}()
+
+	// select the requested image of manifest lists
+	man, mimeType, err := src.GetManifest(ctx, nil)
+	if err != nil {
+		return err
+	}
+	var instance *digest.Digest
+	if manifest.MIMETypeIsMultiImage(mimeType) {
+		list, err := manifest.ListFromBlob(man, mimeType)
+		if err != nil {
+			return err
+		}
+		d, err := list.ChooseInstance(cp.sysCtx)
+		if err != nil {
+			return fmt.Errorf("while selecting image platform: %v", err)
+		}
+		if man, _, err = src.GetManifest(ctx, &d); err != nil {
+			return err
+		}
+		instance = &d
+	}
+
+	img, err := image.FromUnparsedImage(ctx, cp.sysCtx, image.UnparsedInstance(src, instance))
+	if err != nil {
+		return err
+	}
+	imgSpec, err := cp.imageSpec(ctx, img)
+	if err != nil {
+		return err
+	}
+	cp.imgConfig = imgSpec.Config
+
+	// record the digest of the selected image manifest for provenance
+	d, err := manifest.Digest(man)
+	if err != nil {
+		return fmt.Errorf("while computing manifest digest: %v", err)
+	}
+	cp.b.BaseImage = &sytypes.BaseImage{
+		Source: cp.b.Recipe.Header["bootstrap"] + "://" + cp.b.Recipe.Header["from"],
+		Digest: d.String(),
+	}
+
+	cp.b.RootfsLayers = &ociLayers{
+		src:   src,
+		infos: img.LayerInfos(),
+		arch:  imgSpec.Architecture,
+	}
+	return nil
+}
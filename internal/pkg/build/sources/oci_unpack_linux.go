@@ -23,14 +23,18 @@ import (
 	umocilayer "github.com/opencontainers/umoci/oci/layer"
 	"github.com/opencontainers/umoci/pkg/idtools"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/internal/pkg/util/userns"
 	sytypes "github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/sylog"
 )
 
-// unpackRootfs extracts all of the layers of the given image reference into the rootfs of the provided bundle
-func unpackRootfs(ctx context.Context, b *sytypes.Bundle, tmpfsRef types.ImageReference, sysCtx *types.SystemContext) (err error) {
-	var mapOptions umocilayer.MapOptions
+func init() {
+	userns.Register("oci-unpack", unpackChild)
+}
 
+// setUmociLogLevel sets the apex log level used by umoci from the
+// singularity log level.
+func setUmociLogLevel() {
 	loggerLevel := sylog.GetLevel()
 
 	// set the apex log level, for umoci
@@ -47,28 +51,57 @@ func unpackRootfs(ctx context.Context, b *sytypes.Bundle, tmpfsRef types.ImageRe
 		// debug option
 		apexlog.SetLevel(apexlog.DebugLevel)
 	}
+}
 
-	// Allow unpacking as non-root
-	if os.Geteuid() != 0 {
-		mapOptions.Rootless = true
+// unpackChild extracts the layers of the manifest args[2] of the OCI
+// layout args[0] into the rootfs args[1] as root of a user namespace,
+// the ownership of the files is restored with the subordinate IDs of
+// the user.
+func unpackChild(args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("unexpected arguments %q", args)
+	}
+	setUmociLogLevel()
 
-		uidMap, err := idtools.ParseMapping(fmt.Sprintf("0:%d:1", os.Geteuid()))
-		if err != nil {
-			return fmt.Errorf("error parsing uidmap: %s", err)
-		}
-		mapOptions.UIDMappings = append(mapOptions.UIDMappings, uidMap)
+	var manifest imgspecv1.Manifest
+	if err := json.Unmarshal([]byte(args[2]), &manifest); err != nil {
+		return fmt.Errorf("error decoding manifest: %s", err)
+	}
+	engineExt, err := umoci.OpenLayout(args[0])
+	if err != nil {
+		return fmt.Errorf("error opening layout: %s", err)
+	}
+	defer engineExt.Close()
 
-		gidMap, err := idtools.ParseMapping(fmt.Sprintf("0:%d:1", os.Getegid()))
-		if err != nil {
-			return fmt.Errorf("error parsing gidmap: %s", err)
-		}
-		mapOptions.GIDMappings = append(mapOptions.GIDMappings, gidMap)
+	err = umocilayer.UnpackRootfs(context.Background(), engineExt, args[1], manifest, &umocilayer.MapOptions{}, nil, imgspecv1.Descriptor{})
+	if err != nil {
+		return fmt.Errorf("error unpacking rootfs: %s", err)
 	}
+	return nil
+}
 
-	engineExt, err := umoci.OpenLayout(b.TmpDir)
+// unpackInUserNamespace extracts the layers of the manifest in a user
+// namespace mapping the subordinate IDs of the user, the ID map is
+// recorded in the bundle on success.
+func unpackInUserNamespace(b *sytypes.Bundle, manifestData []byte) error {
+	idMap, err := userns.CurrentUser()
 	if err != nil {
-		return fmt.Errorf("error opening layout: %s", err)
+		return err
+	}
+	sylog.Debugf("Unpacking rootfs in a user namespace with mappings %+v", *idMap)
+
+	if err := idMap.Call("oci-unpack", b.TmpDir, b.RootfsPath, string(manifestData)); err != nil {
+		return err
 	}
+	b.UserNamespace = idMap
+	return nil
+}
+
+// unpackRootfs extracts all of the layers of the given image reference into the rootfs of the provided bundle
+func unpackRootfs(ctx context.Context, b *sytypes.Bundle, tmpfsRef types.ImageReference, sysCtx *types.SystemContext) (err error) {
+	var mapOptions umocilayer.MapOptions
+
+	setUmociLogLevel()
 
 	// Obtain the manifest
 	imageSource, err := tmpfsRef.NewImageSource(ctx, sysCtx)
@@ -88,10 +121,61 @@ func unpackRootfs(ctx context.Context, b *sytypes.Bundle, tmpfsRef types.ImageRe
 	// UnpackRootfs from umoci v0.4.2 expects a path to a non-existing directory
 	os.RemoveAll(b.RootfsPath)
 
-	// Unpack root filesystem
-	err = umocilayer.UnpackRootfs(ctx, engineExt, b.RootfsPath, manifest, &mapOptions, nil, imgspecv1.Descriptor{})
-	if err != nil {
-		return fmt.Errorf("error unpacking rootfs: %s", err)
+	// As non-root, unpack in a user namespace first so that the files keep
+	// their owners, and fall back to files all owned by the user when the
+	// user has no subordinate IDs. The files must be owned by the user
+	// for the `--fix-perms` flag.
+	unpacked := false
+	if os.Geteuid() != 0 && !b.Opts.FixPerms {
+		err := unpackInUserNamespace(b, manifestData)
+		if err == nil {
+			unpacked = true
+		} else if errors.Is(err, userns.ErrUnavailable) {
+			sylog.Verbosef("Unpacking rootfs without user namespace, files will be owned by the current user: %v", err)
+			os.RemoveAll(b.RootfsPath)
+		} else {
+			return fmt.Errorf("error unpacking rootfs in user namespace: %s", err)
+		}
+	}
+
+	if !unpacked {
+		// Allow unpacking as non-root
+		if os.Geteuid() != 0 {
+			mapOptions.Rootless = true
+
+			uidMap, err := idtools.ParseMapping(fmt.Sprintf("0:%d:1", os.Geteuid()))
+			if err != nil {
+				return fmt.Errorf("error parsing uidmap: %s", err)
+			}
+			mapOptions.UIDMappings = append(mapOptions.UIDMappings, uidMap)
+
+			gidMap, err := idtools.ParseMapping(fmt.Sprintf("0:%d:1", os.Getegid()))
+			if err != nil {
+				return fmt.Errorf("error parsing gidmap: %s", err)
+			}
+			mapOptions.GIDMappings = append(mapOptions.GIDMappings, gidMap)
+		}
+
+		engineExt, err := umoci.OpenLayout(b.TmpDir)
+		if err != nil {
+			return fmt.Errorf("error opening layout: %s", err)
+		}
+
+		// Unpack root filesystem
+		err = umocilayer.UnpackRootfs(ctx, engineExt, b.RootfsPath, manifest, &mapOptions, nil, imgspecv1.Descriptor{})
+		if err != nil {
+			return fmt.Errorf("error unpacking rootfs: %s", err)
+		}
+	}
+
+	// The sandbox files owned by subordinate IDs can't be checked nor
+	// removed by the user outside of a user namespace
+	if b.UserNamespace != nil {
+		if b.Opts.SandboxTarget {
+			sylog.Warningf("The sandbox files keep their owners, mapped to your subordinate user and group IDs")
+			sylog.Warningf("Remove the sandbox as root of a user namespace with the same mappings (eg: 'unshare --map-auto --map-root-user rm -rf <sandbox>')")
+		}
+		return nil
 	}
 
 	// If the `--fix-perms` flag was used, then modify the permissions so that
@@ -6,15 +6,17 @@
 package sources
 
 import (
-	"bytes"
-	"context"
-	"fmt"
-	"os/exec"
+	"sort"
+	"strings"
 
 	"github.com/sylabs/singularity/pkg/build/types"
 	"github.com/sylabs/singularity/pkg/sylog"
 )
 
+// maxLossExamples is the number of files reported for each kind of
+// attribute not preserved by the sandbox copy.
+const maxLossExamples = 5
+
 // SandboxPacker holds the locations of where to pack from and to
 // Ext3Packer holds the locations of where to back from and to, aswell as image offset info
 type SandboxPacker struct {
@@ -22,18 +24,26 @@ type SandboxPacker struct {
 	b      *types.Bundle
 }
 
-// Pack puts relevant objects in a Bundle!
-func (p *SandboxPacker) Pack(context.Context) (*types.Bundle, error) {
-	rootfs := p.srcdir
-
-	// copy filesystem into bundle rootfs
-	sylog.Debugf("Copying file system from %s to %s in Bundle\n", rootfs, p.b.RootfsPath)
-	var stderr bytes.Buffer
-	cmd := exec.Command("cp", "-a", rootfs+`/.`, p.b.RootfsPath)
-	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("cp Failed: %v: %v", err, stderr.String())
+// reportLosses warns about the attributes of the files not preserved by
+// the sandbox copy, the losses map the attributes to the files.
+func reportLosses(losses map[string][]string) {
+	if len(losses) == 0 {
+		return
+	}
+
+	attrs := make([]string, 0, len(losses))
+	for attr := range losses {
+		attrs = append(attrs, attr)
 	}
+	sort.Strings(attrs)
 
-	return p.b, nil
+	sylog.Warningf("Some file attributes of the sandbox could not be preserved, build as root or with --fakeroot to preserve them")
+	for _, attr := range attrs {
+		files := losses[attr]
+		examples := files
+		if len(examples) > maxLossExamples {
+			examples = examples[:maxLossExamples]
+		}
+		sylog.Warningf("%s not preserved for %d file(s): %s", attr, len(files), strings.Join(examples, ", "))
+	}
 }
@@ -0,0 +1,144 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/sylabs/singularity/internal/pkg/util/userns"
+	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/sylog"
+	"golang.org/x/sys/unix"
+)
+
+// Pack puts relevant objects in a Bundle!
+func (p *SandboxPacker) Pack(context.Context) (*types.Bundle, error) {
+	rootfs := p.srcdir
+
+	// an unprivileged copy preserves the ownership and the file
+	// capabilities in a user namespace mapping the subordinate IDs
+	// of the user, the bundle is then assembled in this namespace
+	if os.Geteuid() != 0 && p.b.UserNamespace == nil {
+		if idMap, err := userns.CurrentUser(); err == nil {
+			sylog.Debugf("Copying sandbox in a user namespace with mappings %+v", *idMap)
+			p.b.UserNamespace = idMap
+		} else {
+			sylog.Debugf("Copying sandbox without user namespace: %v", err)
+		}
+	}
+
+	// copy filesystem into bundle rootfs, with its ownership, extended
+	// attributes, hard links and sparse files
+	sylog.Debugf("Copying file system from %s to %s in Bundle\n", rootfs, p.b.RootfsPath)
+	var stderr bytes.Buffer
+	cmd := exec.Command("cp", "-a", "--sparse=auto", rootfs+`/.`, p.b.RootfsPath)
+	cmd.Stderr = &stderr
+	run := cmd.Run
+	if p.b.UserNamespace != nil {
+		run = func() error { return p.b.UserNamespace.Run(cmd) }
+	}
+	if err := run(); err != nil {
+		return nil, fmt.Errorf("cp Failed: %v: %v", err, stderr.String())
+	}
+
+	losses, err := copyLosses(rootfs, p.b.RootfsPath)
+	if err != nil {
+		sylog.Warningf("Could not check the copy of %s: %v", rootfs, err)
+	}
+	reportLosses(losses)
+
+	return p.b, nil
+}
+
+// copyLosses compares the sandbox src with its copy dst and returns the
+// file attributes not preserved by the copy mapped to the files missing
+// them: their ownership, extended attributes, hard links and sparse
+// regions. The files of the copy which can't be examined are ignored.
+func copyLosses(src, dst string) (map[string][]string, error) {
+	losses := make(map[string][]string)
+	lost := func(attr, path string) {
+		losses[attr] = append(losses[attr], path)
+	}
+	// the copies of the source inodes with several links
+	links := make(map[[2]uint64]uint64)
+
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil || path == src {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		name := "/" + rel
+
+		var st, cst syscall.Stat_t
+		if err := syscall.Lstat(path, &st); err != nil {
+			return err
+		}
+		if err := syscall.Lstat(filepath.Join(dst, rel), &cst); err != nil {
+			if os.IsNotExist(err) {
+				lost("file", name)
+			}
+			return nil
+		}
+
+		if st.Uid != cst.Uid || st.Gid != cst.Gid {
+			lost("ownership", name)
+		}
+		for _, attr := range xattrs(path) {
+			if _, err := unix.Lgetxattr(filepath.Join(dst, rel), attr, nil); err == unix.ENODATA || err == unix.ENOTSUP {
+				lost("extended attribute "+attr, name)
+			}
+		}
+
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		if st.Nlink > 1 {
+			key := [2]uint64{st.Dev, st.Ino}
+			if ino, ok := links[key]; !ok {
+				links[key] = cst.Ino
+			} else if ino != cst.Ino {
+				lost("hard link", name)
+			}
+		}
+		if st.Size > 0 && st.Blocks*512 < st.Size && cst.Blocks*512 >= cst.Size {
+			lost("sparse regions", name)
+		}
+		return nil
+	})
+	return losses, err
+}
+
+// xattrs returns the names of the extended attributes of path, the
+// SELinux labels are ignored as they depend on the host policy.
+func xattrs(path string) []string {
+	size, err := unix.Llistxattr(path, nil)
+	if err != nil || size == 0 {
+		return nil
+	}
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name != "" && name != "security.selinux" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sources
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCopyLosses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sandbox-copy-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(src, "bin", "file")
+	if err := ioutil.WriteFile(file, []byte("file"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Link(file, filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "sparse"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(filepath.Join(src, "sparse"), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	xattr := unix.Lsetxattr(file, "user.test", []byte("test"), 0) == nil
+
+	// a lossless copy
+	dst := filepath.Join(dir, "dst")
+	if out, err := exec.Command("cp", "-a", "--sparse=auto", src, dst).CombinedOutput(); err != nil {
+		t.Fatalf("unexpected copy error: %v: %s", err, out)
+	}
+	losses, err := copyLosses(src, dst)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(losses) != 0 {
+		t.Errorf("unexpected losses %v", losses)
+	}
+
+	// a copy following hard links, without sparse files and extended attributes
+	lossy := filepath.Join(dir, "lossy")
+	if out, err := exec.Command("cp", "-r", "--sparse=never", src, lossy).CombinedOutput(); err != nil {
+		t.Fatalf("unexpected copy error: %v: %s", err, out)
+	}
+	losses, err = copyLosses(src, lossy)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string][]string{
+		"hard link":      {"/link"},
+		"sparse regions": {"/sparse"},
+	}
+	if xattr {
+		want["extended attribute user.test"] = []string{"/bin/file", "/link"}
+	}
+	if !reflect.DeepEqual(losses, want) {
+		t.Errorf("got losses %v, want %v", losses, want)
+	}
+}
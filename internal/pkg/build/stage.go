@@ -6,13 +6,17 @@
 package build
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 
+	"github.com/sylabs/singularity/internal/pkg/build/events"
 	"github.com/sylabs/singularity/internal/pkg/build/files"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	"github.com/sylabs/singularity/pkg/build/types"
@@ -29,6 +33,18 @@ type stage struct {
 	a Assembler
 	// b is an intermediate structure that encapsulates all information for the container, e.g., metadata, filesystems.
 	b *types.Bundle
+	// emulator is the binfmt_misc interpreter bound in the container
+	// to run %post and %test for a foreign architecture.
+	emulator string
+	// cgroupsConfig is the cgroups configuration applied to %post and
+	// %test to enforce the build resource limits.
+	cgroupsConfig string
+	// downloads are the %files URL sources downloaded before the
+	// stages are built.
+	downloads *files.Downloads
+	// output buffers the stage messages and sections output when the
+	// stages are built concurrently, they're written directly if nil.
+	output *stageOutput
 }
 
 const sEnvironment = "SINGULARITY_ENVIRONMENT=/.singularity.d/env/91-environment.sh"
@@ -38,8 +54,37 @@ func (s *stage) Assemble(path string) error {
 	return s.a.Assemble(s.b, path)
 }
 
+// logger returns the logger of the stage messages.
+func (s *stage) logger() *sylog.SubLogger {
+	if s.output != nil {
+		return s.output.log
+	}
+	return buildLog
+}
+
+// stdout returns the writer of the sections standard output.
+func (s *stage) stdout() io.Writer {
+	if s.output != nil {
+		return s.output
+	}
+	return s.b.Opts.Events.Output()
+}
+
+// stderr returns the writer of the sections error output.
+func (s *stage) stderr() io.Writer {
+	if s.output != nil {
+		return s.output
+	}
+	return os.Stderr
+}
+
+// sectionStarted emits the SectionStarted event of the section.
+func (s *stage) sectionStarted(section string) {
+	s.b.Opts.Events.Emit(events.Event{Type: events.SectionStarted, Stage: s.name, Section: section})
+}
+
 // runSetupScript executes the stage's pre script on host.
-func (s *stage) runSectionScript(name string, script types.Script) error {
+func (s *stage) runSectionScript(ctx context.Context, name string, script types.Script) error {
 	if s.b.RunSection(name) && script.Script != "" {
 		if syscall.Getuid() != 0 {
 			return fmt.Errorf("attempted to build with scripts as non-root user or without --fakeroot")
@@ -59,24 +104,30 @@ func (s *stage) runSectionScript(name string, script types.Script) error {
 		}
 
 		// Run script section here
-		cmd := exec.Command(args[0], args[1:]...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdout = s.stdout()
+		cmd.Stderr = s.stderr()
 		cmd.Env = os.Environ()
 		cmd.Env = append(cmd.Env, sEnvironment, sRootfs)
+		if name == "setup" && s.downloads != nil {
+			cmd.Env = append(cmd.Env, "SINGULARITY_DOWNLOADS="+s.downloads.Dir)
+		}
 
-		sylog.Infof("Running %s scriptlet", name)
+		s.logger().Infof("Running %s scriptlet", name)
+		s.sectionStarted(name)
 		if err := cmd.Run(); err != nil {
-			return fmt.Errorf("failed to run %%%s script: %v", name, err)
+			return fmt.Errorf("failed to run %%%s script: %v", name, limitError(ctx, s.b.Opts, err))
 		}
 	}
 	return nil
 }
 
-func (s *stage) runPostScript(configFile, sessionResolv, sessionHosts string) error {
+func (s *stage) runPostScript(ctx context.Context, configFile, sessionResolv, sessionHosts string) error {
 	if s.b.Recipe.BuildData.Post.Script != "" {
 		cmdArgs := []string{"-s", "-c", configFile, "exec", "--pwd", "/", "--writable"}
 		cmdArgs = append(cmdArgs, "--cleanenv", "--env", sEnvironment)
+		cmdArgs = append(cmdArgs, s.networkArgs()...)
+		cmdArgs = append(cmdArgs, s.cgroupsArgs()...)
 
 		if sessionResolv != "" {
 			cmdArgs = append(cmdArgs, "-B", sessionResolv+":/etc/resolv.conf")
@@ -84,6 +135,20 @@ func (s *stage) runPostScript(configFile, sessionResolv, sessionHosts string) er
 		if sessionHosts != "" {
 			cmdArgs = append(cmdArgs, "-B", sessionHosts+":/etc/hosts")
 		}
+		for _, bind := range s.b.PostBinds {
+			cmdArgs = append(cmdArgs, "-B", bind)
+		}
+
+		// bind paths requested with --bind and --mount
+		binds := append(s.emulatorBinds(), s.b.Opts.Binds...)
+		removeBindPoints, err := createBindPoints(s.b.RootfsPath, binds)
+		if err != nil {
+			return err
+		}
+		defer removeBindPoints()
+		for _, bind := range binds {
+			cmdArgs = append(cmdArgs, "-B", bind)
+		}
 
 		script := s.b.Recipe.BuildData.Post
 		scriptPath := filepath.Join(s.b.RootfsPath, ".post.script")
@@ -101,42 +166,116 @@ func (s *stage) runPostScript(configFile, sessionResolv, sessionHosts string) er
 
 		cmdArgs = append(cmdArgs, s.b.RootfsPath)
 		cmdArgs = append(cmdArgs, args...)
-		cmd := exec.Command(exe, cmdArgs...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
+		cmd := exec.CommandContext(ctx, exe, cmdArgs...)
+		cmd.Stdout = s.stdout()
+		cmd.Stderr = s.stderr()
 		cmd.Dir = "/"
 		cmd.Env = currentEnvNoSingularity()
 
-		sylog.Infof("Running post scriptlet")
-		return cmd.Run()
+		s.logger().Infof("Running post scriptlet")
+		s.sectionStarted("post")
+		return limitError(ctx, s.b.Opts, cmd.Run())
 	}
 	return nil
 }
 
-func (s *stage) runTestScript(configFile, sessionResolv, sessionHosts string) error {
-	if !s.b.Opts.NoTest && s.b.Recipe.BuildData.Test.Script != "" {
-		cmdArgs := []string{"-s", "-c", configFile, "test", "--pwd", "/"}
+func (s *stage) runTestScript(ctx context.Context, configFile, sessionResolv, sessionHosts string) error {
+	if s.b.Opts.NoTest || !s.hasTests() {
+		return nil
+	}
 
-		if sessionResolv != "" {
-			cmdArgs = append(cmdArgs, "-B", sessionResolv+":/etc/resolv.conf")
+	// the test report is written in a host directory as the
+	// container is read-only
+	reportDir := filepath.Join(s.b.TmpDir, "test-report")
+	if err := os.MkdirAll(reportDir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(reportDir)
+
+	cmdArgs := []string{"-s", "-c", configFile, "test", "--pwd", "/"}
+	cmdArgs = append(cmdArgs, "--report", filepath.Join(testReportDir, "report.json"), "--report-format", "json")
+	cmdArgs = append(cmdArgs, s.networkArgs()...)
+	cmdArgs = append(cmdArgs, s.cgroupsArgs()...)
+
+	if sessionResolv != "" {
+		cmdArgs = append(cmdArgs, "-B", sessionResolv+":/etc/resolv.conf")
+	}
+	if sessionHosts != "" {
+		cmdArgs = append(cmdArgs, "-B", sessionHosts+":/etc/hosts")
+	}
+
+	binds := append(s.emulatorBinds(), reportDir+":"+testReportDir)
+	removeBindPoints, err := createBindPoints(s.b.RootfsPath, binds)
+	if err != nil {
+		return err
+	}
+	defer removeBindPoints()
+	for _, bind := range binds {
+		cmdArgs = append(cmdArgs, "-B", bind)
+	}
+
+	exe := filepath.Join(buildcfg.BINDIR, "singularity")
+
+	cmdArgs = append(cmdArgs, s.b.RootfsPath)
+	cmd := exec.CommandContext(ctx, exe, cmdArgs...)
+	cmd.Stdout = s.stdout()
+	cmd.Stderr = s.stderr()
+	cmd.Dir = "/"
+	cmd.Env = currentEnvNoSingularity()
+
+	s.logger().Infof("Running testscript")
+	s.sectionStarted("test")
+	err = limitError(ctx, s.b.Opts, cmd.Run())
+
+	// the report is kept for failed tests too
+	if rerr := s.saveTestReport(filepath.Join(reportDir, "report.json")); rerr != nil {
+		if err != nil {
+			s.logger().Warningf("While saving test report: %v", rerr)
+			return err
 		}
-		if sessionHosts != "" {
-			cmdArgs = append(cmdArgs, "-B", sessionHosts+":/etc/hosts")
+		return fmt.Errorf("while saving test report: %v", rerr)
+	}
+	return err
+}
+
+// hasTests returns true if the definition has a %test or
+// %apptest section.
+func (s *stage) hasTests() bool {
+	if s.b.Recipe.BuildData.Test.Script != "" {
+		return true
+	}
+	for k := range s.b.Recipe.CustomData {
+		if strings.HasPrefix(k, "apptest ") {
+			return true
 		}
+	}
+	return false
+}
 
-		exe := filepath.Join(buildcfg.BINDIR, "singularity")
+// networkArgs returns the arguments joining the container to the network
+// requested with --network, the host network is shared by default.
+func (s *stage) networkArgs() []string {
+	if s.b.Opts.Network == "" || s.b.Opts.Network == types.HostNetwork {
+		return nil
+	}
+	return []string{"--net", "--network", s.b.Opts.Network}
+}
 
-		cmdArgs = append(cmdArgs, s.b.RootfsPath)
-		cmd := exec.Command(exe, cmdArgs...)
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-		cmd.Dir = "/"
-		cmd.Env = currentEnvNoSingularity()
+// cgroupsArgs returns the arguments applying the build resource limits.
+func (s *stage) cgroupsArgs() []string {
+	if s.cgroupsConfig == "" {
+		return nil
+	}
+	return []string{"--apply-cgroups", s.cgroupsConfig}
+}
 
-		sylog.Infof("Running testscript")
-		return cmd.Run()
+// emulatorBinds returns the bind path of the emulator, the kernel looks up
+// the interpreter in the container unless its handler has the fix binary flag.
+func (s *stage) emulatorBinds() []string {
+	if s.emulator == "" {
+		return nil
 	}
-	return nil
+	return []string{s.emulator + ":" + s.emulator + ":ro"}
 }
 
 func (s *stage) copyFilesFrom(b *Build) error {
@@ -155,13 +294,13 @@ func (s *stage) copyFilesFrom(b *Build) error {
 			return err
 		}
 
-		sylog.Debugf("Copying files from stage: %s", args[1])
+		s.logger().Debugf("Copying files from stage: %s", args[1])
 
 		// iterate through filetransfers
 		for _, transfer := range f.Files {
 			// sanity
 			if transfer.Src == "" {
-				sylog.Warningf("Attempt to copy file with no name, skipping.")
+				s.logger().Warningf("Attempt to copy file with no name, skipping.")
 				continue
 			}
 			// dest = source if not specified
@@ -172,10 +311,14 @@ func (s *stage) copyFilesFrom(b *Build) error {
 			// copy each file into bundle rootfs
 			// prepend appropriate bundle path to supplied paths
 			// copying between stages should not follow symlinks
+			opts, err := s.copyOptions(transfer, false)
+			if err != nil {
+				return err
+			}
 			transfer.Src = files.AddPrefix(b.stages[stageIndex].b.RootfsPath, transfer.Src)
 			transfer.Dst = files.AddPrefix(s.b.RootfsPath, transfer.Dst)
-			sylog.Infof("Copying %v to %v", transfer.Src, transfer.Dst)
-			if err := files.Copy(transfer.Src, transfer.Dst, false); err != nil {
+			s.logger().Infof("Copying %v to %v", transfer.Src, transfer.Dst)
+			if err := files.CopyWithOptions(transfer.Src, transfer.Dst, opts); err != nil {
 				return err
 			}
 		}
@@ -196,21 +339,107 @@ func (s *stage) copyFiles() error {
 	for _, transfer := range filesSection.Files {
 		// sanity
 		if transfer.Src == "" {
-			sylog.Warningf("Attempt to copy file with no name, skipping.")
+			s.logger().Warningf("Attempt to copy file with no name, skipping.")
 			continue
 		}
 		// dest = source if not specified
 		if transfer.Dst == "" {
 			transfer.Dst = transfer.Src
 		}
+		// URL sources are copied from their download, the default
+		// destination is the URL path
+		if files.IsURL(transfer.Src) {
+			if transfer.Dst == transfer.Src {
+				p, err := files.URLPath(transfer.Src)
+				if err != nil {
+					return err
+				}
+				transfer.Dst = p
+			}
+			p, err := s.downloads.Path(transfer.Src)
+			if err != nil {
+				return err
+			}
+			transfer.Src = p
+		}
 		// copy each file into bundle rootfs
 		// copying from host to container should follow symlinks
+		opts, err := s.copyOptions(transfer, true)
+		if err != nil {
+			return err
+		}
 		transfer.Dst = files.AddPrefix(s.b.RootfsPath, transfer.Dst)
-		sylog.Infof("Copying %v to %v", transfer.Src, transfer.Dst)
-		if err := files.Copy(transfer.Src, transfer.Dst, true); err != nil {
+		s.logger().Infof("Copying %v to %v", transfer.Src, transfer.Dst)
+		if err := files.CopyWithOptions(transfer.Src, transfer.Dst, opts); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// copyOptions returns the options of the %files transfer copied into the
+// stage, the owner is looked up in the stage root filesystem.
+func (s *stage) copyOptions(transfer types.FileTransport, followLinks bool) (files.Options, error) {
+	opts := files.Options{
+		FollowLinks: followLinks,
+		Exclude:     transfer.Exclude,
+	}
+	if transfer.Owner != "" {
+		uid, gid, err := files.LookupOwner(s.b.RootfsPath, transfer.Owner)
+		if err != nil {
+			return opts, fmt.Errorf("while looking up owner of %s: %v", transfer.Src, err)
+		}
+		opts.Chown, opts.UID, opts.GID = true, uid, gid
+	}
+	if transfer.Mode != "" {
+		mode, err := strconv.ParseUint(transfer.Mode, 8, 32)
+		if err != nil {
+			return opts, fmt.Errorf("invalid mode %s of %s: %v", transfer.Mode, transfer.Src, err)
+		}
+		opts.Chmod, opts.Mode = true, uint32(mode)
+	}
+	return opts, nil
+}
+
+// urlSources returns the URL sources of the stage %files section.
+func (s *stage) urlSources() []string {
+	if !s.b.RunSection("files") {
+		return nil
+	}
+	var srcs []string
+	for _, f := range s.b.Recipe.BuildData.Files {
+		if f.Args != "" {
+			continue
+		}
+		for _, transfer := range f.Files {
+			if files.IsURL(transfer.Src) {
+				srcs = append(srcs, transfer.Src)
+			}
+		}
+	}
+	return srcs
+}
+
+// fetchURLSources downloads the %files URL sources of all the stages
+// concurrently, the URLs used by several stages are downloaded once.
+func (b *Build) fetchURLSources(ctx context.Context) error {
+	var srcs []string
+	for _, s := range b.stages {
+		srcs = append(srcs, s.urlSources()...)
+	}
+	if len(srcs) == 0 {
+		return nil
+	}
+
+	// the downloads are removed with the first stage bundle
+	dir := filepath.Join(b.stages[0].b.TmpDir, "downloads")
+	d, err := files.Fetch(ctx, dir, srcs, b.Conf.Opts.Downloads)
+	if err != nil {
+		return err
+	}
+	for i := range b.stages {
+		b.stages[i].downloads = d
+	}
+	return nil
+}
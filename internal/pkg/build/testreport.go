@@ -0,0 +1,107 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+// testReportDir is the directory of the test report in the container
+// while running the %test and %apptest sections.
+const testReportDir = "/.singularity.d/test-report"
+
+// testReport is the JSON report written by the test action script.
+type testReport struct {
+	Passed int          `json:"passed"`
+	Failed int          `json:"failed"`
+	Tests  []testResult `json:"tests"`
+}
+
+type testResult struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	ExitCode int    `json:"exitCode"`
+	Attempts int    `json:"attempts"`
+	Duration int    `json:"duration"`
+}
+
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name    string        `xml:"name,attr"`
+	Time    int           `xml:"time,attr"`
+	Failure *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// saveTestReport adds the report written by the test action script at
+// path to the image metadata and writes it to the --test-report path,
+// there is no report if the tests didn't run.
+func (s *stage) saveTestReport(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var r testReport
+	if err := json.Unmarshal(data, &r); err != nil {
+		return fmt.Errorf("invalid test report: %v", err)
+	}
+	s.b.JSONObjects[types.TestReportJSON] = data
+
+	if s.b.Opts.TestReport == "" {
+		return nil
+	}
+	if filepath.Ext(s.b.Opts.TestReport) == ".xml" {
+		if data, err = r.junit(); err != nil {
+			return err
+		}
+	}
+	buildLog.Infof("Writing test report to %s", s.b.Opts.TestReport)
+	return ioutil.WriteFile(s.b.Opts.TestReport, data, 0644)
+}
+
+// junit returns the report in the JUnit XML format.
+func (r testReport) junit() ([]byte, error) {
+	suite := junitTestSuite{
+		Name:     "singularity",
+		Tests:    len(r.Tests),
+		Failures: r.Failed,
+	}
+	for _, t := range r.Tests {
+		c := junitTestCase{Name: t.Name, Time: t.Duration}
+		if t.Status != "passed" {
+			c.Failure = &junitFailure{
+				Message: fmt.Sprintf("%s with exit code %d after %d attempts", t.Status, t.ExitCode, t.Attempts),
+			}
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+
+	data, err := xml.MarshalIndent(suite, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(data, '\n')...), nil
+}
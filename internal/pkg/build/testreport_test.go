@@ -0,0 +1,67 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+func TestSaveTestReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-report-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	report := `{"passed":1,"failed":1,"tests":[{"name":"test","status":"passed","exitCode":0,"attempts":1,"duration":2},{"name":"apptest foo","status":"timeout","exitCode":137,"attempts":3,"duration":30}]}`
+	path := filepath.Join(dir, "report.json")
+	if err := ioutil.WriteFile(path, []byte(report), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &stage{
+		b: &types.Bundle{
+			JSONObjects: make(map[string][]byte),
+			Opts:        types.Options{TestReport: filepath.Join(dir, "report.xml")},
+		},
+	}
+	if err := s.saveTestReport(filepath.Join(dir, "missing.json")); err != nil {
+		t.Errorf("unexpected error without report: %v", err)
+	}
+	if err := s.saveTestReport(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(s.b.JSONObjects[types.TestReportJSON]) != report {
+		t.Errorf("unexpected report in metadata: %s", s.b.JSONObjects[types.TestReportJSON])
+	}
+
+	b, err := ioutil.ReadFile(s.b.Opts.TestReport)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{
+		`<testsuite name="singularity" tests="2" failures="1">`,
+		`<testcase name="test" time="2"></testcase>`,
+		`<failure message="timeout with exit code 137 after 3 attempts"></failure>`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("JUnit report doesn't contain %s:\n%s", want, b)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.saveTestReport(path); err == nil {
+		t.Errorf("unexpected success for an invalid report")
+	}
+}
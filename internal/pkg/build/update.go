@@ -0,0 +1,50 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// updatingSIF returns true if the build runs the definition over the
+// existing SIF image at its destination.
+func (b *Build) updatingSIF() bool {
+	return b.Conf.Opts.Update && !b.Conf.Opts.Force && b.Conf.Format == "sif"
+}
+
+// assembleUpdate assembles the updated root filesystem next to the SIF
+// image dest, the image is only replaced once the updated image is
+// written so that a failed update leaves it untouched.
+func (b *Build) assembleUpdate(s stage, dest string) error {
+	fi, err := os.Stat(dest)
+	if err != nil {
+		return fmt.Errorf("while getting image %s information: %v", dest, err)
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest)+".")
+	if err != nil {
+		return fmt.Errorf("while creating temporary image: %v", err)
+	}
+	tmp := f.Name()
+	f.Close()
+	defer os.Remove(tmp)
+
+	if err := s.Assemble(tmp); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, fi.Mode().Perm()); err != nil {
+		return fmt.Errorf("while setting image permissions: %v", err)
+	}
+
+	buildLog.Infof("Replacing %s with the updated image", dest)
+	if err := os.Rename(tmp, dest); err != nil {
+		return fmt.Errorf("while moving updated image to %s: %v", dest, err)
+	}
+	return nil
+}
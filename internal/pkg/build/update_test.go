@@ -0,0 +1,58 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+func TestAssembleUpdate(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "build-update-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	b := &Build{Conf: Config{Format: "sif", Opts: types.Options{Update: true}}}
+	if !b.updatingSIF() {
+		t.Errorf("SIF update not detected")
+	}
+
+	dest := filepath.Join(tmp, "image.sif")
+	if err := ioutil.WriteFile(dest, []byte("old image"), 0750); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed update leaves the image untouched
+	if err := b.assembleUpdate(stage{a: &mockAssembler{err: errors.New("failure")}}, dest); err == nil {
+		t.Errorf("unexpected success")
+	}
+	if data, err := ioutil.ReadFile(dest); err != nil || string(data) != "old image" {
+		t.Errorf("image modified by a failed update: %q, %v", data, err)
+	}
+	if entries, err := ioutil.ReadDir(tmp); err != nil || len(entries) != 1 {
+		t.Errorf("unexpected files left in destination directory: %v", entries)
+	}
+
+	if err := b.assembleUpdate(stage{a: &mockAssembler{}}, dest); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f, err := sif.LoadContainer(dest, true)
+	if err != nil {
+		t.Fatalf("while loading updated image: %s", err)
+	}
+	f.UnloadContainer()
+	if fi, err := os.Stat(dest); err != nil || fi.Mode().Perm() != 0750 {
+		t.Errorf("image permissions not preserved: %v, %v", fi.Mode(), err)
+	}
+}
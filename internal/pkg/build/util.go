@@ -12,12 +12,15 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	ocitypes "github.com/containers/image/v5/types"
 	"github.com/sylabs/singularity/internal/pkg/cache"
 	"github.com/sylabs/singularity/internal/pkg/util/env"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
 	"github.com/sylabs/singularity/pkg/build/types"
 	buildtypes "github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/image/packer"
 	"github.com/sylabs/singularity/pkg/sylog"
 	"golang.org/x/sys/unix"
 )
@@ -28,6 +31,10 @@ func ConvertOciToSIF(ctx context.Context, imgCache *cache.Handle, image, cachedI
 		return fmt.Errorf("image cache is undefined")
 	}
 
+	span := sylog.StartSpan("convert")
+	span.SetAttribute("image", image)
+	defer span.End()
+
 	b, err := NewBuild(
 		image,
 		Config{
@@ -41,6 +48,7 @@ func ConvertOciToSIF(ctx context.Context, imgCache *cache.Handle, image, cachedI
 				NoHTTPS:          noHTTPS,
 				DockerAuthConfig: authConf,
 				ImgCache:         imgCache,
+				StreamLayers:     canStreamLayers(),
 			},
 		},
 	)
@@ -48,13 +56,35 @@ func ConvertOciToSIF(ctx context.Context, imgCache *cache.Handle, image, cachedI
 		return fmt.Errorf("unable to create new build: %v", err)
 	}
 
-	return b.Full(ctx)
+	start := time.Now()
+	if err := b.Full(ctx); err != nil {
+		return err
+	}
+	sylog.Debugw("Converted OCI image to SIF", "image", image, "dest", cachedImgPath, "duration", time.Since(start))
+
+	return nil
+}
+
+// canStreamLayers returns true if mksquashfs reads tar archives, the
+// image layers are then streamed to mksquashfs instead of being unpacked
+// in a temporary sandbox.
+func canStreamLayers() bool {
+	path, err := squashfs.GetPath()
+	if err != nil {
+		return false
+	}
+	s := packer.Squashfs{MksquashfsPath: path}
+	if !s.HasTarSupport() {
+		sylog.Debugf("%s doesn't read tar archives, unpacking image layers", path)
+		return false
+	}
+	return true
 }
 
 func createStageFile(source string, b *types.Bundle, warnMsg string) (string, error) {
 	dest := filepath.Join(b.RootfsPath, source)
 	if err := unix.Access(dest, unix.R_OK); err != nil {
-		sylog.Warningf("%s: while accessing to %s: %s", warnMsg, dest, err)
+		buildLog.Warningf("%s: while accessing to %s: %s", warnMsg, dest, err)
 		return "", nil
 	}
 
@@ -9,12 +9,10 @@ package cache
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"strconv"
-	"time"
 
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
 	"github.com/sylabs/singularity/pkg/syfs"
@@ -50,6 +48,14 @@ const (
 	OrasCacheType = "oras"
 	// The Net cache holds images pulled from http(s) internet sources
 	NetCacheType = "net"
+	// The Conda cache holds packages downloaded to build conda environments
+	CondaCacheType = "conda"
+	// The Spack cache holds the build cache of packages built by spack
+	SpackCacheType = "spack"
+	// The Sections cache holds root filesystem snapshots of build stages
+	SectionsCacheType = "sections"
+	// The Blocks cache holds the blocks of http(s) images read lazily
+	BlocksCacheType = "blocks"
 )
 
 var (
@@ -59,6 +65,10 @@ var (
 		ShubCacheType,
 		OrasCacheType,
 		NetCacheType,
+		CondaCacheType,
+		SpackCacheType,
+		SectionsCacheType,
+		BlocksCacheType,
 	}
 	OciCacheTypes = []string{
 		OciBlobCacheType,
@@ -156,44 +166,18 @@ func (h *Handle) GetEntry(cacheType string, hash string) (e *Entry, err error) {
 
 	// It exists in the cache and it's a file. Caller can use the Path directly
 	e.Exists = true
+	touch(e.Path)
 	return e, nil
 }
 
-func (h *Handle) CleanCache(cacheType string, dryRun bool, days int) (err error) {
-	dir := h.getCacheTypeDir(cacheType)
-
-	files, err := ioutil.ReadDir(dir)
-	if (err != nil && os.IsNotExist(err)) || len(files) == 0 {
-		sylog.Infof("No cached files to remove at %s", dir)
-		return nil
-	}
-
-	errCount := 0
-	for _, f := range files {
-
-		if days >= 0 {
-			if time.Since(f.ModTime()) < time.Duration(days*24)*time.Hour {
-				sylog.Debugf("Skipping %s: less that %d days old", f.Name(), days)
-				continue
-			}
-		}
-
-		sylog.Infof("Removing %s cache entry: %s", cacheType, f.Name())
-		if !dryRun {
-			// We RemoveAll in case the entry is a directory from Singularity <3.6
-			err := os.RemoveAll(path.Join(dir, f.Name()))
-			if err != nil {
-				sylog.Errorf("Could not remove cache entry '%s': %v", f.Name(), err)
-				errCount = errCount + 1
-			}
-		}
+// CleanCache removes the entries of a cache type selected by the policy,
+// unused blobs are removed from the OCI blob cache once all the images
+// referencing them are removed.
+func (h *Handle) CleanCache(cacheType string, dryRun bool, policy CleanPolicy) (err error) {
+	if cacheType == OciBlobCacheType && !policy.All() {
+		return h.cleanBlobs(dryRun, policy)
 	}
-
-	if errCount > 0 {
-		return fmt.Errorf("failed to remove %d cache entries", errCount)
-	}
-
-	return err
+	return h.cleanFiles(cacheType, dryRun, policy)
 }
 
 // cleanAllCaches is an utility function that wipes all files in the
@@ -0,0 +1,295 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// blobGracePeriod protects the blobs not yet referenced by an image of
+// the blob cache from the garbage collection, they may be fetched by a
+// concurrent pull.
+const blobGracePeriod = time.Hour
+
+// CleanPolicy selects the cache entries removed by a clean, the zero
+// value removes all the entries.
+type CleanPolicy struct {
+	// OlderThan removes the entries not used for this duration.
+	OlderThan time.Duration
+	// MaxSize removes the least recently used entries until the size of
+	// each cache type is at most MaxSize bytes.
+	MaxSize int64
+}
+
+// All returns whether the policy removes all the cache entries.
+func (p CleanPolicy) All() bool {
+	return p.OlderThan <= 0 && p.MaxSize <= 0
+}
+
+// cleanEntry is a cache entry considered for removal.
+type cleanEntry struct {
+	name    string
+	id      int
+	lastUse time.Time
+	size    int64
+}
+
+// selectEntries returns the entries removed by the policy, sizeOf returns
+// the size freed by the removal of an entry, it is called with the entries
+// in least recently used order.
+func (p CleanPolicy) selectEntries(entries []cleanEntry, total int64, sizeOf func(cleanEntry) int64) []cleanEntry {
+	if p.All() {
+		return entries
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].lastUse.Before(entries[j].lastUse)
+	})
+
+	var removed []cleanEntry
+	for _, e := range entries {
+		expired := p.OlderThan > 0 && time.Since(e.lastUse) >= p.OlderThan
+		oversized := p.MaxSize > 0 && total > p.MaxSize
+		if !expired && !oversized {
+			continue
+		}
+		total -= sizeOf(e)
+		removed = append(removed, e)
+	}
+	return removed
+}
+
+// touch records the use of the cache entry at path, the least recently
+// used entries are removed first by a clean with a size limit.
+func touch(path string) {
+	now := time.Now()
+	if err := os.Chtimes(path, now, now); err != nil {
+		sylog.Debugf("Could not update the last use of cache entry %s: %v", path, err)
+	}
+}
+
+// cleanFiles removes the entries of a file cache type selected by the policy.
+func (h *Handle) cleanFiles(cacheType string, dryRun bool, policy CleanPolicy) error {
+	dir := h.getCacheTypeDir(cacheType)
+
+	files, err := ioutil.ReadDir(dir)
+	if (err != nil && os.IsNotExist(err)) || len(files) == 0 {
+		sylog.Infof("No cached files to remove at %s", dir)
+		return nil
+	}
+
+	var total int64
+	entries := make([]cleanEntry, 0, len(files))
+	for _, f := range files {
+		entries = append(entries, cleanEntry{name: f.Name(), lastUse: f.ModTime(), size: f.Size()})
+		total += f.Size()
+	}
+
+	errCount := 0
+	for _, e := range policy.selectEntries(entries, total, func(e cleanEntry) int64 { return e.size }) {
+		sylog.Infof("Removing %s cache entry: %s", cacheType, e.name)
+		if !dryRun {
+			// We RemoveAll in case the entry is a directory from Singularity <3.6
+			err := os.RemoveAll(filepath.Join(dir, e.name))
+			if err != nil {
+				sylog.Errorf("Could not remove cache entry '%s': %v", e.name, err)
+				errCount = errCount + 1
+			}
+		}
+	}
+
+	if errCount > 0 {
+		return fmt.Errorf("failed to remove %d cache entries", errCount)
+	}
+	return nil
+}
+
+// blobPath returns the path of the blob d in the OCI layout dir.
+func blobPath(dir string, d digest.Digest) string {
+	return filepath.Join(dir, "blobs", d.Algorithm().String(), d.Hex())
+}
+
+// blobImage is an image of the OCI blob cache.
+type blobImage struct {
+	desc  imgspecv1.Descriptor
+	blobs []digest.Digest
+}
+
+// readBlobImage returns the image of the index descriptor desc, the blobs
+// of a manifest which can't be read are the manifest blob only.
+func readBlobImage(dir string, desc imgspecv1.Descriptor) blobImage {
+	img := blobImage{desc: desc, blobs: []digest.Digest{desc.Digest}}
+
+	b, err := ioutil.ReadFile(blobPath(dir, desc.Digest))
+	if err != nil {
+		return img
+	}
+	var m imgspecv1.Manifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		sylog.Debugf("Ignoring blobs of invalid manifest %s: %v", desc.Digest, err)
+		return img
+	}
+	if m.Config.Digest != "" {
+		img.blobs = append(img.blobs, m.Config.Digest)
+	}
+	for _, l := range m.Layers {
+		img.blobs = append(img.blobs, l.Digest)
+	}
+	return img
+}
+
+// cleanBlobs removes the images of the OCI blob cache selected by the policy
+// and the blobs no longer referenced, the blobs shared by several images
+// are kept until all of them are removed.
+func (h *Handle) cleanBlobs(dryRun bool, policy CleanPolicy) error {
+	dir := h.getCacheTypeDir(OciBlobCacheType)
+
+	indexPath := filepath.Join(dir, "index.json")
+	b, err := ioutil.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		sylog.Infof("No cached files to remove at %s", dir)
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("while reading blob cache index: %v", err)
+	}
+	var index imgspecv1.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		return fmt.Errorf("while decoding blob cache index: %v", err)
+	}
+
+	// collect the blobs with their size and last modification
+	blobs := make(map[digest.Digest]os.FileInfo)
+	err = filepath.Walk(filepath.Join(dir, "blobs"), func(path string, fi os.FileInfo, err error) error {
+		if err != nil || fi.IsDir() {
+			return err
+		}
+		blobs[digest.NewDigestFromHex(filepath.Base(filepath.Dir(path)), fi.Name())] = fi
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("while listing cached blobs: %v", err)
+	}
+
+	images := make([]blobImage, len(index.Manifests))
+	refs := make(map[digest.Digest]int)
+	entries := make([]cleanEntry, len(index.Manifests))
+	for i, desc := range index.Manifests {
+		images[i] = readBlobImage(dir, desc)
+		for _, d := range images[i].blobs {
+			refs[d]++
+		}
+		// the manifest is written on each fetch of the image through the
+		// cache, it records its last use
+		entries[i] = cleanEntry{name: desc.Annotations[imgspecv1.AnnotationRefName], id: i}
+		if fi, ok := blobs[desc.Digest]; ok {
+			entries[i].lastUse = fi.ModTime()
+		}
+	}
+
+	// the blobs without reference are removed unless recent, they may
+	// belong to an image being fetched
+	orphans := make(map[digest.Digest]bool)
+	var total int64
+	for d, fi := range blobs {
+		if refs[d] == 0 && time.Since(fi.ModTime()) >= blobGracePeriod {
+			orphans[d] = true
+			continue
+		}
+		total += fi.Size()
+	}
+
+	// the size freed by the removal of an image is the size of the blobs
+	// it doesn't share with the images kept
+	removed := policy.selectEntries(entries, total, func(e cleanEntry) int64 {
+		var size int64
+		for _, d := range images[e.id].blobs {
+			if refs[d]--; refs[d] == 0 {
+				orphans[d] = true
+				if fi, ok := blobs[d]; ok {
+					size += fi.Size()
+				}
+			}
+		}
+		return size
+	})
+
+	if len(removed) == 0 && len(orphans) == 0 {
+		sylog.Infof("No cached files to remove at %s", dir)
+		return nil
+	}
+
+	removedImages := make(map[int]bool)
+	for _, e := range removed {
+		removedImages[e.id] = true
+		sylog.Infof("Removing %s cache image: %s", OciBlobCacheType, e.name)
+	}
+	if !dryRun && len(removed) > 0 {
+		kept := make([]imgspecv1.Descriptor, 0, len(index.Manifests))
+		for i, desc := range index.Manifests {
+			if !removedImages[i] {
+				kept = append(kept, desc)
+			}
+		}
+		index.Manifests = kept
+		if err := writeIndex(indexPath, index); err != nil {
+			return err
+		}
+	}
+
+	var size int64
+	for d := range orphans {
+		fi, ok := blobs[d]
+		if !ok {
+			continue
+		}
+		sylog.Debugf("Removing %s cache entry: %s", OciBlobCacheType, d)
+		size += fi.Size()
+		if !dryRun {
+			if err := os.Remove(blobPath(dir, d)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("could not remove cache entry '%s': %v", d, err)
+			}
+		}
+	}
+	sylog.Infof("Removing %d %s cache entries (%d bytes)", len(orphans), OciBlobCacheType, size)
+	return nil
+}
+
+// writeIndex atomically replaces the OCI layout index at path.
+func writeIndex(path string, index imgspecv1.Index) error {
+	b, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("while encoding blob cache index: %v", err)
+	}
+
+	f, err := fs.MakeTmpFile(filepath.Dir(path), "tmp_", 0600)
+	if err != nil {
+		return fmt.Errorf("while writing blob cache index: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("while writing blob cache index: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("while writing blob cache index: %v", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("while writing blob cache index: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	imgspecv1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// writeBlob writes a blob of the OCI layout dir modified at mtime.
+func writeBlob(t *testing.T, dir string, data []byte, mtime time.Time) digest.Digest {
+	d := digest.FromBytes(data)
+	path := blobPath(dir, d)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// writeImage writes the manifest of an image with the layers and returns
+// its index descriptor.
+func writeImage(t *testing.T, dir, name string, mtime time.Time, layers ...digest.Digest) imgspecv1.Descriptor {
+	m := imgspecv1.Manifest{
+		Config: imgspecv1.Descriptor{Digest: writeBlob(t, dir, []byte("config "+name), mtime)},
+	}
+	for _, l := range layers {
+		m.Layers = append(m.Layers, imgspecv1.Descriptor{Digest: l})
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return imgspecv1.Descriptor{
+		Digest:      writeBlob(t, dir, b, mtime),
+		Annotations: map[string]string{imgspecv1.AnnotationRefName: name},
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestCleanBlobs(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "cache-clean-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	h := &Handle{rootDir: tmp}
+	dir := h.getCacheTypeDir(OciBlobCacheType)
+
+	old := time.Now().Add(-40 * 24 * time.Hour)
+	now := time.Now()
+
+	shared := writeBlob(t, dir, []byte("shared base layer"), old)
+	oldLayer := writeBlob(t, dir, []byte("old image layer"), old)
+	newLayer := writeBlob(t, dir, []byte("new image layer"), now)
+	oldOrphan := writeBlob(t, dir, []byte("old orphan"), old)
+	newOrphan := writeBlob(t, dir, []byte("blob being fetched"), now)
+
+	oldImage := writeImage(t, dir, "old", old, shared, oldLayer)
+	newImage := writeImage(t, dir, "new", now, shared, newLayer)
+	if err := writeIndex(filepath.Join(dir, "index.json"), imgspecv1.Index{
+		Manifests: []imgspecv1.Descriptor{oldImage, newImage},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the old image is removed, the base layer is still used
+	if err := h.CleanCache(OciBlobCacheType, false, CleanPolicy{OlderThan: 30 * 24 * time.Hour}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for d, want := range map[digest.Digest]bool{
+		shared:          true,
+		newLayer:        true,
+		newImage.Digest: true,
+		newOrphan:       true,
+		oldLayer:        false,
+		oldImage.Digest: false,
+		oldOrphan:       false,
+	} {
+		if exists(blobPath(dir, d)) != want {
+			t.Errorf("blob %s exists: %v, expected %v", d, !want, want)
+		}
+	}
+
+	// the new image is removed to keep the cache under the size limit
+	if err := h.CleanCache(OciBlobCacheType, true, CleanPolicy{MaxSize: 10}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !exists(blobPath(dir, shared)) {
+		t.Errorf("blob removed in dry run mode")
+	}
+	if err := h.CleanCache(OciBlobCacheType, false, CleanPolicy{MaxSize: 10}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if exists(blobPath(dir, shared)) || exists(blobPath(dir, newImage.Digest)) {
+		t.Errorf("blobs of removed image left in cache")
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var index imgspecv1.Index
+	if err := json.Unmarshal(b, &index); err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Manifests) != 0 {
+		t.Errorf("unexpected images left in index: %v", index.Manifests)
+	}
+}
+
+func TestCleanPolicy(t *testing.T) {
+	now := time.Now()
+	entries := []cleanEntry{
+		{name: "recent", lastUse: now, size: 20},
+		{name: "oldest", lastUse: now.Add(-48 * time.Hour), size: 10},
+		{name: "old", lastUse: now.Add(-24 * time.Hour), size: 10},
+	}
+	size := func(e cleanEntry) int64 { return e.size }
+
+	if removed := (CleanPolicy{}).selectEntries(entries, 40, size); len(removed) != 3 {
+		t.Errorf("unexpected entries removed by empty policy: %v", removed)
+	}
+	if removed := (CleanPolicy{OlderThan: 36 * time.Hour}).selectEntries(entries, 40, size); len(removed) != 1 || removed[0].name != "oldest" {
+		t.Errorf("unexpected entries removed by age: %v", removed)
+	}
+	if removed := (CleanPolicy{MaxSize: 25}).selectEntries(entries, 40, size); len(removed) != 2 || removed[1].name != "old" {
+		t.Errorf("unexpected entries removed by size: %v", removed)
+	}
+}
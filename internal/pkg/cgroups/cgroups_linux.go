@@ -8,6 +8,7 @@ package cgroups
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"path/filepath"
 	"strings"
 
@@ -15,11 +16,18 @@ import (
 	specs "github.com/opencontainers/runtime-spec/specs-go"
 )
 
-// Manager manage container cgroup resources restriction
+// Manager manage container cgroup resources restriction, with the cgroups
+// v1 hierarchies or the cgroups v2 unified hierarchy
 type Manager struct {
-	Path   string
-	Pid    int
-	cgroup cgroups.Cgroup
+	Path string
+	Pid  int
+	// Rootless creates the cgroup of an unprivileged user in a scope
+	// delegated by the systemd user instance, Path is ignored. It
+	// requires the cgroups v2 unified hierarchy.
+	Rootless bool
+	cgroup   cgroups.Cgroup
+	// unified is the cgroup directory with the cgroups v2 unified hierarchy
+	unified string
 }
 
 func readSpecFromFile(path string) (spec specs.LinuxResources, err error) {
@@ -27,7 +35,11 @@ func readSpecFromFile(path string) (spec specs.LinuxResources, err error) {
 	if err != nil {
 		return
 	}
+	return conf.Spec()
+}
 
+// Spec returns the OCI specification of the cgroups resources restriction.
+func (conf Config) Spec() (spec specs.LinuxResources, err error) {
 	// convert TOML structures to OCI JSON structures
 	data, err := json.Marshal(conf)
 	if err != nil {
@@ -41,8 +53,69 @@ func readSpecFromFile(path string) (spec specs.LinuxResources, err error) {
 	return
 }
 
+// OverrideSpec sets the memory, CPU, pids and block IO restrictions of spec
+// defined by override. The device rules of override are appended to those
+// of spec, they take precedence as the last matching rule applies.
+func OverrideSpec(spec, override *specs.LinuxResources) {
+	if override == nil {
+		return
+	}
+
+	if mem := override.Memory; mem != nil {
+		if spec.Memory == nil {
+			spec.Memory = &specs.LinuxMemory{}
+		}
+		if mem.Limit != nil {
+			spec.Memory.Limit = mem.Limit
+		}
+		if mem.Reservation != nil {
+			spec.Memory.Reservation = mem.Reservation
+		}
+		if mem.Swap != nil {
+			spec.Memory.Swap = mem.Swap
+		}
+	}
+
+	if cpu := override.CPU; cpu != nil {
+		if spec.CPU == nil {
+			spec.CPU = &specs.LinuxCPU{}
+		}
+		if cpu.Shares != nil {
+			spec.CPU.Shares = cpu.Shares
+		}
+		if cpu.Quota != nil {
+			spec.CPU.Quota = cpu.Quota
+		}
+		if cpu.Period != nil {
+			spec.CPU.Period = cpu.Period
+		}
+		if cpu.Cpus != "" {
+			spec.CPU.Cpus = cpu.Cpus
+		}
+		if cpu.Mems != "" {
+			spec.CPU.Mems = cpu.Mems
+		}
+	}
+
+	if override.Pids != nil {
+		spec.Pids = override.Pids
+	}
+
+	if blkio := override.BlockIO; blkio != nil && blkio.Weight != nil {
+		if spec.BlockIO == nil {
+			spec.BlockIO = &specs.LinuxBlockIO{}
+		}
+		spec.BlockIO.Weight = blkio.Weight
+	}
+
+	spec.Devices = append(spec.Devices, override.Devices...)
+}
+
 // GetCgroupRootPath returns cgroup root path
 func (m *Manager) GetCgroupRootPath() string {
+	if m.unified != "" {
+		return unifiedMountpoint
+	}
 	if m.cgroup == nil {
 		return ""
 	}
@@ -60,21 +133,33 @@ func (m *Manager) GetCgroupRootPath() string {
 	return ""
 }
 
+// GetUnifiedPath returns the cgroup directory with the cgroups v2 unified
+// hierarchy, or an empty string with cgroups v1
+func (m *Manager) GetUnifiedPath() string {
+	return m.unified
+}
+
 // ApplyFromSpec applies cgroups resources restriction from OCI specification
 func (m *Manager) ApplyFromSpec(spec *specs.LinuxResources) (err error) {
 	var path cgroups.Path
 
+	s := spec
+	if s == nil {
+		s = &specs.LinuxResources{}
+	}
+
+	if IsUnified() {
+		return m.applyUnified(s)
+	} else if m.Rootless {
+		return fmt.Errorf("rootless cgroups require the cgroups v2 unified hierarchy")
+	}
+
 	if !filepath.IsAbs(m.Path) {
 		return fmt.Errorf("cgroup path must be an absolute path")
 	}
 
 	path = cgroups.StaticPath(m.Path)
 
-	s := spec
-	if s == nil {
-		s = &specs.LinuxResources{}
-	}
-
 	// creates cgroup
 	m.cgroup, err = cgroups.New(cgroups.V1, path, s)
 	if err != nil {
@@ -109,6 +194,16 @@ func (m *Manager) loadFromPid() (err error) {
 
 // UpdateFromSpec updates cgroups resources restriction from OCI specification
 func (m *Manager) UpdateFromSpec(spec *specs.LinuxResources) (err error) {
+	if IsUnified() {
+		values, err := unifiedValues(spec)
+		if err != nil {
+			return err
+		}
+		if err := m.loadUnified(); err != nil {
+			return err
+		}
+		return writeUnifiedValues(m.unified, values)
+	}
 	if m.cgroup == nil {
 		if err = m.loadFromPid(); err != nil {
 			return
@@ -129,12 +224,18 @@ func (m *Manager) UpdateFromFile(path string) error {
 
 // Remove removes resources restriction for current managed process
 func (m *Manager) Remove() error {
+	if IsUnified() {
+		return m.removeUnified()
+	}
 	// deletes subgroup
 	return m.cgroup.Delete()
 }
 
 // Pause suspends all processes inside the container
 func (m *Manager) Pause() error {
+	if IsUnified() {
+		return m.freezeUnified(true)
+	}
 	if m.cgroup == nil {
 		if err := m.loadFromPid(); err != nil {
 			return err
@@ -145,6 +246,9 @@ func (m *Manager) Pause() error {
 
 // Resume resumes all processes that have been previously paused
 func (m *Manager) Resume() error {
+	if IsUnified() {
+		return m.freezeUnified(false)
+	}
 	if m.cgroup == nil {
 		if err := m.loadFromPid(); err != nil {
 			return err
@@ -152,3 +256,51 @@ func (m *Manager) Resume() error {
 	}
 	return m.cgroup.Thaw()
 }
+
+// Stats are the resources usage of the processes of a cgroup, the limits
+// are zero when unlimited or not available.
+type Stats struct {
+	// CPUUsage is the CPU time consumed in nanoseconds.
+	CPUUsage    uint64 `json:"cpuUsage"`
+	MemoryUsage uint64 `json:"memoryUsage"`
+	MemoryLimit uint64 `json:"memoryLimit"`
+	Pids        uint64 `json:"pids"`
+	PidsLimit   uint64 `json:"pidsLimit"`
+}
+
+// Stats returns the resources usage of the cgroup of the process m.Pid.
+func (m *Manager) Stats() (*Stats, error) {
+	if IsUnified() {
+		if err := m.loadUnified(); err != nil {
+			return nil, err
+		}
+		return unifiedStats(m.unified)
+	}
+	if m.cgroup == nil {
+		if err := m.loadFromPid(); err != nil {
+			return nil, err
+		}
+	}
+	metrics, err := m.cgroup.Stat(cgroups.IgnoreNotExist)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{}
+	if metrics.CPU != nil && metrics.CPU.Usage != nil {
+		stats.CPUUsage = metrics.CPU.Usage.Total
+	}
+	if metrics.Memory != nil && metrics.Memory.Usage != nil {
+		stats.MemoryUsage = metrics.Memory.Usage.Usage
+		// the cgroups v1 memory limit is the maximum page counter
+		// value when unlimited
+		if metrics.Memory.Usage.Limit < math.MaxInt64/2 {
+			stats.MemoryLimit = metrics.Memory.Usage.Limit
+		}
+	}
+	if metrics.Pids != nil {
+		stats.Pids = metrics.Pids.Current
+		stats.PidsLimit = metrics.Pids.Limit
+	}
+	return stats, nil
+}
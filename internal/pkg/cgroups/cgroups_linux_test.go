@@ -16,6 +16,7 @@ import (
 	"strings"
 	"testing"
 
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sylabs/singularity/internal/pkg/test"
 )
 
@@ -36,6 +37,9 @@ func readIntFromFile(path string) (int64, error) {
 
 func TestCgroups(t *testing.T) {
 	test.EnsurePrivilege(t)
+	if IsUnified() {
+		t.Skip("cgroups v1 hierarchies not available")
+	}
 
 	cmd := exec.Command("/bin/cat")
 	pipe, err := cmd.StdinPipe()
@@ -108,6 +112,9 @@ func TestCgroups(t *testing.T) {
 
 func TestPauseResume(t *testing.T) {
 	test.EnsurePrivilege(t)
+	if IsUnified() {
+		t.Skip("cgroups v1 hierarchies not available")
+	}
 
 	manager := &Manager{}
 	if err := manager.Pause(); err == nil {
@@ -186,3 +193,40 @@ func TestPauseResume(t *testing.T) {
 
 	cmd.Wait()
 }
+
+func TestOverrideSpec(t *testing.T) {
+	limit := int64(1 << 30)
+	swap := int64(1 << 31)
+	quota := int64(50000)
+	weight := uint16(500)
+
+	spec := specs.LinuxResources{
+		Memory:  &specs.LinuxMemory{Limit: &swap, Swap: &swap},
+		Pids:    &specs.LinuxPids{Limit: 10},
+		Devices: []specs.LinuxDeviceCgroup{{Allow: true, Access: "rwm"}},
+	}
+	OverrideSpec(&spec, &specs.LinuxResources{
+		Memory:  &specs.LinuxMemory{Limit: &limit},
+		CPU:     &specs.LinuxCPU{Quota: &quota},
+		BlockIO: &specs.LinuxBlockIO{Weight: &weight},
+		Devices: []specs.LinuxDeviceCgroup{{Allow: false, Access: "rwm"}},
+	})
+
+	if *spec.Memory.Limit != limit || *spec.Memory.Swap != swap {
+		t.Errorf("unexpected memory limits %d and %d", *spec.Memory.Limit, *spec.Memory.Swap)
+	}
+	if spec.CPU == nil || *spec.CPU.Quota != quota {
+		t.Errorf("CPU quota not overridden")
+	}
+	if spec.Pids.Limit != 10 {
+		t.Errorf("unexpected pids limit %d", spec.Pids.Limit)
+	}
+	if spec.BlockIO == nil || *spec.BlockIO.Weight != weight {
+		t.Errorf("block IO weight not overridden")
+	}
+	if len(spec.Devices) != 2 || spec.Devices[1].Allow {
+		t.Errorf("unexpected device rules %+v", spec.Devices)
+	}
+
+	OverrideSpec(&spec, nil)
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cgroups
+
+import (
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// The device types and accesses of the context of a device program,
+// see the bpf_cgroup_dev_ctx structure of the kernel.
+const (
+	bpfDevBlock = 1
+	bpfDevChar  = 2
+
+	bpfAccessMknod = 1
+	bpfAccessRead  = 2
+	bpfAccessWrite = 4
+)
+
+// bpfInsn is an eBPF instruction.
+type bpfInsn struct {
+	code uint8
+	dst  uint8
+	src  uint8
+	off  int16
+	imm  int32
+}
+
+// bpfProgLoadAttr are the attributes of the BPF_PROG_LOAD command.
+type bpfProgLoadAttr struct {
+	progType    uint32
+	insnCnt     uint32
+	insns       uint64
+	license     uint64
+	logLevel    uint32
+	logSize     uint32
+	logBuf      uint64
+	kernVersion uint32
+	progFlags   uint32
+}
+
+// bpfProgAttachAttr are the attributes of the BPF_PROG_ATTACH command.
+type bpfProgAttachAttr struct {
+	targetFd    uint32
+	attachBpfFd uint32
+	attachType  uint32
+	attachFlags uint32
+}
+
+// deviceFilter returns the instructions of the cgroups v2 device program
+// enforcing the cgroups v1 device rules. As with cgroups v1, the last
+// matching rule applies and device accesses are denied by default.
+func deviceFilter(rules []specs.LinuxDeviceCgroup) ([]bpfInsn, error) {
+	const (
+		ldxW   = unix.BPF_LDX | unix.BPF_MEM | unix.BPF_W
+		andK   = unix.BPF_ALU | unix.BPF_AND | unix.BPF_K
+		rshK   = unix.BPF_ALU | unix.BPF_RSH | unix.BPF_K
+		movK   = unix.BPF_ALU | unix.BPF_MOV | unix.BPF_K
+		movX   = unix.BPF_ALU | unix.BPF_MOV | unix.BPF_X
+		jneK   = unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K
+		jneX   = unix.BPF_JMP | unix.BPF_JNE | unix.BPF_X
+		exit   = unix.BPF_JMP | unix.BPF_EXIT
+		allAcc = bpfAccessMknod | bpfAccessRead | bpfAccessWrite
+	)
+
+	// r2 is the device type, r3 the access, r4 the major and r5 the
+	// minor number of the device
+	prog := []bpfInsn{
+		{code: ldxW, dst: 2, src: 1, off: 0},
+		{code: andK, dst: 2, imm: 0xffff},
+		{code: ldxW, dst: 3, src: 1, off: 0},
+		{code: rshK, dst: 3, imm: 16},
+		{code: ldxW, dst: 4, src: 1, off: 4},
+		{code: ldxW, dst: 5, src: 1, off: 8},
+	}
+
+	for i := len(rules) - 1; i >= 0; i-- {
+		r := rules[i]
+
+		var block []bpfInsn
+		switch r.Type {
+		case "", "a":
+		case "b":
+			block = append(block, bpfInsn{code: jneK, dst: 2, imm: bpfDevBlock})
+		case "c":
+			block = append(block, bpfInsn{code: jneK, dst: 2, imm: bpfDevChar})
+		default:
+			return nil, fmt.Errorf("invalid device type %q", r.Type)
+		}
+
+		access := 0
+		for _, c := range r.Access {
+			switch c {
+			case 'm':
+				access |= bpfAccessMknod
+			case 'r':
+				access |= bpfAccessRead
+			case 'w':
+				access |= bpfAccessWrite
+			default:
+				return nil, fmt.Errorf("invalid device access %q", r.Access)
+			}
+		}
+		if access != 0 && access != allAcc {
+			// the rule matches if the requested access is a subset
+			block = append(block,
+				bpfInsn{code: movX, dst: 1, src: 3},
+				bpfInsn{code: andK, dst: 1, imm: int32(access)},
+				bpfInsn{code: jneX, dst: 1, src: 3},
+			)
+		}
+		if r.Major != nil && *r.Major >= 0 {
+			block = append(block, bpfInsn{code: jneK, dst: 4, imm: int32(*r.Major)})
+		}
+		if r.Minor != nil && *r.Minor >= 0 {
+			block = append(block, bpfInsn{code: jneK, dst: 5, imm: int32(*r.Minor)})
+		}
+
+		allow := int32(0)
+		if r.Allow {
+			allow = 1
+		}
+		block = append(block, bpfInsn{code: movK, dst: 0, imm: allow}, bpfInsn{code: exit})
+
+		// a mismatch jumps to the next rule
+		for j := range block {
+			if block[j].code == jneK || block[j].code == jneX {
+				block[j].off = int16(len(block) - j - 1)
+			}
+		}
+		prog = append(prog, block...)
+
+		// the verifier rejects the unreachable rules following a rule
+		// matching all devices
+		if len(block) == 2 {
+			return prog, nil
+		}
+	}
+
+	return append(prog, bpfInsn{code: movK, dst: 0, imm: 0}, bpfInsn{code: exit}), nil
+}
+
+// encodeInsns returns the encoding of the instructions prog.
+func encodeInsns(prog []bpfInsn) []byte {
+	b := make([]byte, 0, len(prog)*8)
+	for _, insn := range prog {
+		var buf [8]byte
+		buf[0] = insn.code
+		buf[1] = insn.dst&0xf | insn.src<<4
+		binary.LittleEndian.PutUint16(buf[2:], uint16(insn.off))
+		binary.LittleEndian.PutUint32(buf[4:], uint32(insn.imm))
+		b = append(b, buf[:]...)
+	}
+	return b
+}
+
+// attachDeviceFilter attaches to the cgroup dir the device program
+// enforcing rules, which requires the CAP_SYS_ADMIN capability.
+func attachDeviceFilter(dir string, rules []specs.LinuxDeviceCgroup) error {
+	prog, err := deviceFilter(rules)
+	if err != nil {
+		return err
+	}
+	insns := encodeInsns(prog)
+	license := []byte("Apache\x00")
+	log := make([]byte, 4096)
+
+	load := bpfProgLoadAttr{
+		progType: unix.BPF_PROG_TYPE_CGROUP_DEVICE,
+		insnCnt:  uint32(len(prog)),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(log)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&log[0]))),
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_LOAD, uintptr(unsafe.Pointer(&load)), unsafe.Sizeof(load))
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if errno != 0 {
+		msg := strings.TrimRight(string(log), "\x00")
+		return fmt.Errorf("while loading device program: %s: %s", errno, strings.TrimSpace(msg))
+	}
+	defer unix.Close(int(fd))
+
+	cfd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("while opening cgroup %s: %s", dir, err)
+	}
+	defer unix.Close(cfd)
+
+	attach := bpfProgAttachAttr{
+		targetFd:    uint32(cfd),
+		attachBpfFd: uint32(fd),
+		attachType:  unix.BPF_CGROUP_DEVICE,
+		attachFlags: unix.BPF_F_ALLOW_MULTI,
+	}
+	_, _, errno = unix.Syscall(unix.SYS_BPF, unix.BPF_PROG_ATTACH, uintptr(unsafe.Pointer(&attach)), unsafe.Sizeof(attach))
+	if errno != 0 {
+		return fmt.Errorf("while attaching device program to cgroup %s: %s", dir, errno)
+	}
+	return nil
+}
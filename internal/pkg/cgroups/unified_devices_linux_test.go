@@ -0,0 +1,98 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cgroups
+
+import (
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"golang.org/x/sys/unix"
+)
+
+// runDeviceFilter interprets the instructions generated by deviceFilter
+// for the device access of the context ctx.
+func runDeviceFilter(t *testing.T, prog []bpfInsn, ctx [3]uint32) int64 {
+	var regs [11]int64
+	for pc := 0; pc < len(prog); pc++ {
+		insn := prog[pc]
+		switch insn.code {
+		case unix.BPF_LDX | unix.BPF_MEM | unix.BPF_W:
+			regs[insn.dst] = int64(ctx[insn.off/4])
+		case unix.BPF_ALU | unix.BPF_AND | unix.BPF_K:
+			regs[insn.dst] &= int64(insn.imm)
+		case unix.BPF_ALU | unix.BPF_RSH | unix.BPF_K:
+			regs[insn.dst] >>= uint(insn.imm)
+		case unix.BPF_ALU | unix.BPF_MOV | unix.BPF_K:
+			regs[insn.dst] = int64(insn.imm)
+		case unix.BPF_ALU | unix.BPF_MOV | unix.BPF_X:
+			regs[insn.dst] = regs[insn.src]
+		case unix.BPF_JMP | unix.BPF_JNE | unix.BPF_K:
+			if regs[insn.dst] != int64(insn.imm) {
+				pc += int(insn.off)
+			}
+		case unix.BPF_JMP | unix.BPF_JNE | unix.BPF_X:
+			if regs[insn.dst] != regs[insn.src] {
+				pc += int(insn.off)
+			}
+		case unix.BPF_JMP | unix.BPF_EXIT:
+			return regs[0]
+		default:
+			t.Fatalf("unexpected instruction %#x", insn.code)
+		}
+	}
+	t.Fatalf("program without exit")
+	return 0
+}
+
+func TestDeviceFilter(t *testing.T) {
+	i64 := func(v int64) *int64 { return &v }
+
+	rules := []specs.LinuxDeviceCgroup{
+		{Allow: false, Access: "rwm"},
+		{Allow: true, Type: "c", Major: i64(1), Minor: i64(3), Access: "rwm"},
+		{Allow: true, Type: "c", Major: i64(136), Access: "rw"},
+		{Allow: true, Type: "b", Major: i64(8), Minor: i64(0), Access: "r"},
+		{Allow: false, Type: "c", Major: i64(1), Minor: i64(3), Access: "w"},
+	}
+	prog, err := deviceFilter(rules)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		typ    uint32
+		access uint32
+		major  uint32
+		minor  uint32
+		allow  int64
+	}{
+		{"NullRead", bpfDevChar, bpfAccessRead, 1, 3, 1},
+		{"NullWrite", bpfDevChar, bpfAccessWrite, 1, 3, 0},
+		{"NullMknod", bpfDevChar, bpfAccessMknod, 1, 3, 1},
+		{"PtsReadWrite", bpfDevChar, bpfAccessRead | bpfAccessWrite, 136, 5, 1},
+		{"PtsMknod", bpfDevChar, bpfAccessMknod, 136, 5, 0},
+		{"DiskRead", bpfDevBlock, bpfAccessRead, 8, 0, 1},
+		{"DiskWrite", bpfDevBlock, bpfAccessWrite, 8, 0, 0},
+		{"DiskAsChar", bpfDevChar, bpfAccessRead, 8, 0, 0},
+		{"Other", bpfDevChar, bpfAccessRead, 10, 200, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := [3]uint32{tt.access<<16 | tt.typ, tt.major, tt.minor}
+			if got := runDeviceFilter(t, prog, ctx); got != tt.allow {
+				t.Errorf("got %d, want %d", got, tt.allow)
+			}
+		})
+	}
+
+	if _, err := deviceFilter([]specs.LinuxDeviceCgroup{{Type: "x"}}); err == nil {
+		t.Errorf("unexpected success with invalid device type")
+	}
+	if _, err := deviceFilter([]specs.LinuxDeviceCgroup{{Access: "rx"}}); err == nil {
+		t.Errorf("unexpected success with invalid device access")
+	}
+}
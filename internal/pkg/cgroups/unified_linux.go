@@ -0,0 +1,492 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cgroups
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	systemdDbus "github.com/coreos/go-systemd/v22/dbus"
+	"github.com/godbus/dbus/v5"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/pkg/sylog"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// unifiedMountpoint is the mount point of the cgroups v2 unified hierarchy.
+	unifiedMountpoint = "/sys/fs/cgroup"
+	// scopeTimeout is the time waited for the systemd user instance to
+	// create the scope of a rootless container.
+	scopeTimeout = 30 * time.Second
+	// rootlessCgroup is the cgroup of a rootless container in its scope,
+	// the controllers of a delegated scope are enabled for its children.
+	rootlessCgroup = "container"
+)
+
+// unifiedValue is a value written to a cgroup interface file.
+type unifiedValue struct {
+	file    string
+	content string
+}
+
+// IsUnified returns true if the host uses the cgroups v2 unified hierarchy
+// only, the cgroups v1 controllers aren't available then.
+func IsUnified() bool {
+	var st unix.Statfs_t
+	if err := unix.Statfs(unifiedMountpoint, &st); err != nil {
+		return false
+	}
+	return st.Type == unix.CGROUP2_SUPER_MAGIC
+}
+
+// CanDelegate returns true if the cgroups of the current unprivileged user
+// can be delegated by the systemd user instance, with the cgroups v2 unified
+// hierarchy and a session bus.
+func CanDelegate() bool {
+	if !IsUnified() {
+		return false
+	}
+	if os.Getenv("DBUS_SESSION_BUS_ADDRESS") != "" {
+		return true
+	}
+	_, err := os.Stat(fmt.Sprintf("/run/user/%d/bus", os.Getuid()))
+	return err == nil
+}
+
+// unifiedValues converts the OCI resources restriction spec to the values
+// of the cgroups v2 interface files. The device access rules require eBPF
+// programs and aren't enforced, as the settings without cgroups v2
+// equivalent.
+func unifiedValues(spec *specs.LinuxResources) ([]unifiedValue, error) {
+	var values []unifiedValue
+	add := func(file, content string) {
+		values = append(values, unifiedValue{file, content})
+	}
+	max := func(v int64) string {
+		if v < 0 {
+			return "max"
+		}
+		return strconv.FormatInt(v, 10)
+	}
+
+	if mem := spec.Memory; mem != nil {
+		if mem.Limit != nil {
+			add("memory.max", max(*mem.Limit))
+		}
+		if mem.Reservation != nil {
+			add("memory.low", max(*mem.Reservation))
+		}
+		// the cgroups v1 swap limit includes the memory limit
+		if mem.Swap != nil {
+			switch {
+			case *mem.Swap < 0:
+				add("memory.swap.max", "max")
+			case mem.Limit == nil || *mem.Limit < 0:
+				return nil, fmt.Errorf("a memory swap limit requires a memory limit")
+			case *mem.Swap < *mem.Limit:
+				return nil, fmt.Errorf("memory swap limit %d lower than memory limit %d", *mem.Swap, *mem.Limit)
+			default:
+				add("memory.swap.max", strconv.FormatInt(*mem.Swap-*mem.Limit, 10))
+			}
+		}
+		if mem.Kernel != nil || mem.KernelTCP != nil || mem.Swappiness != nil || mem.DisableOOMKiller != nil {
+			sylog.Warningf("Kernel memory, swappiness and OOM killer settings are not supported with cgroups v2")
+		}
+	}
+
+	if cpu := spec.CPU; cpu != nil {
+		// the shares range [2-262144] is mapped to the weights [1-10000]
+		if cpu.Shares != nil && *cpu.Shares != 0 {
+			shares := *cpu.Shares
+			if shares < 2 {
+				shares = 2
+			}
+			add("cpu.weight", strconv.FormatUint(1+((shares-2)*9999)/262142, 10))
+		}
+		if (cpu.Quota != nil && *cpu.Quota != 0) || (cpu.Period != nil && *cpu.Period != 0) {
+			quota := "max"
+			if cpu.Quota != nil && *cpu.Quota > 0 {
+				quota = strconv.FormatInt(*cpu.Quota, 10)
+			}
+			period := uint64(100000)
+			if cpu.Period != nil && *cpu.Period != 0 {
+				period = *cpu.Period
+			}
+			add("cpu.max", fmt.Sprintf("%s %d", quota, period))
+		}
+		if cpu.Cpus != "" {
+			add("cpuset.cpus", cpu.Cpus)
+		}
+		if cpu.Mems != "" {
+			add("cpuset.mems", cpu.Mems)
+		}
+		if (cpu.RealtimeRuntime != nil && *cpu.RealtimeRuntime != 0) || (cpu.RealtimePeriod != nil && *cpu.RealtimePeriod != 0) {
+			sylog.Warningf("CPU realtime settings are not supported with cgroups v2")
+		}
+	}
+
+	if spec.Pids != nil {
+		limit := spec.Pids.Limit
+		if limit == 0 {
+			limit = -1
+		}
+		add("pids.max", max(limit))
+	}
+
+	if blkio := spec.BlockIO; blkio != nil {
+		// the weights range [10-1000] is mapped to [1-10000]
+		weight := func(w uint16) uint64 {
+			if w < 10 {
+				w = 10
+			}
+			return 1 + (uint64(w)-10)*9999/990
+		}
+		if blkio.Weight != nil && *blkio.Weight != 0 {
+			add("io.weight", fmt.Sprintf("default %d", weight(*blkio.Weight)))
+		}
+		for _, d := range blkio.WeightDevice {
+			if d.Weight != nil {
+				add("io.weight", fmt.Sprintf("%d:%d %d", d.Major, d.Minor, weight(*d.Weight)))
+			}
+		}
+		for _, t := range []struct {
+			key     string
+			devices []specs.LinuxThrottleDevice
+		}{
+			{"rbps", blkio.ThrottleReadBpsDevice},
+			{"wbps", blkio.ThrottleWriteBpsDevice},
+			{"riops", blkio.ThrottleReadIOPSDevice},
+			{"wiops", blkio.ThrottleWriteIOPSDevice},
+		} {
+			for _, d := range t.devices {
+				add("io.max", fmt.Sprintf("%d:%d %s=%d", d.Major, d.Minor, t.key, d.Rate))
+			}
+		}
+		if blkio.LeafWeight != nil {
+			sylog.Warningf("Block IO leaf weight is not supported with cgroups v2")
+		}
+	}
+
+	for _, h := range spec.HugepageLimits {
+		add(fmt.Sprintf("hugetlb.%s.max", h.Pagesize), strconv.FormatUint(h.Limit, 10))
+	}
+
+	if spec.Network != nil {
+		sylog.Warningf("Network class and priorities are not supported with cgroups v2")
+	}
+
+	return values, nil
+}
+
+// controllers returns the controllers of the interface files of values.
+func controllers(values []unifiedValue) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, v := range values {
+		name := strings.SplitN(v.file, ".", 2)[0]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// enableControllers enables the controllers names for the children of the
+// cgroups from base down to the parent of dir, the controllers of base
+// are the available ones.
+func enableControllers(base, dir string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(base, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("while reading available controllers: %s", err)
+	}
+	available := strings.Fields(string(b))
+	for _, name := range names {
+		found := false
+		for _, a := range available {
+			found = found || a == name
+		}
+		if !found {
+			return fmt.Errorf("cgroup controller %s not available in %s", name, base)
+		}
+	}
+
+	rel, err := filepath.Rel(base, filepath.Dir(dir))
+	if err != nil {
+		return err
+	}
+	paths := []string{base}
+	if rel != "." {
+		for _, elem := range strings.Split(rel, "/") {
+			paths = append(paths, filepath.Join(paths[len(paths)-1], elem))
+		}
+	}
+	for _, path := range paths {
+		for _, name := range names {
+			if err := ioutil.WriteFile(filepath.Join(path, "cgroup.subtree_control"), []byte("+"+name), 0); err != nil {
+				return fmt.Errorf("while enabling controller %s in %s: %s", name, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeUnifiedValues writes values to the interface files of the cgroup
+// directory dir.
+func writeUnifiedValues(dir string, values []unifiedValue) error {
+	for _, v := range values {
+		if err := ioutil.WriteFile(filepath.Join(dir, v.file), []byte(v.content), 0); err != nil {
+			return fmt.Errorf("while writing %q to %s: %s", v.content, v.file, err)
+		}
+	}
+	return nil
+}
+
+// unifiedPath returns the cgroup of the cgroups v2 unified hierarchy read
+// from r, in the format of /proc/<pid>/cgroup.
+func unifiedPath(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := scanner.Text(); strings.HasPrefix(line, "0::") {
+			return line[3:], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("no cgroups v2 hierarchy found")
+}
+
+// pidUnifiedDir returns the cgroup directory of the process pid.
+func pidUnifiedDir(pid int) (string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	path, err := unifiedPath(f)
+	if err != nil {
+		return "", fmt.Errorf("while reading cgroup of process %d: %s", pid, err)
+	}
+	return filepath.Join(unifiedMountpoint, path), nil
+}
+
+// delegatedScope moves the process pid to a new scope delegated to the
+// current user by the systemd user instance and returns the directory
+// of the scope.
+func delegatedScope(pid int) (string, error) {
+	conn, err := systemdDbus.NewUserConnection()
+	if err != nil {
+		return "", fmt.Errorf("while connecting to the systemd user instance: %s", err)
+	}
+	defer conn.Close()
+
+	name := fmt.Sprintf("singularity-%d.scope", pid)
+	props := []systemdDbus.Property{
+		systemdDbus.PropDescription(fmt.Sprintf("Singularity container process %d", pid)),
+		systemdDbus.PropPids(uint32(pid)),
+		{Name: "Delegate", Value: dbus.MakeVariant(true)},
+		{Name: "DefaultDependencies", Value: dbus.MakeVariant(false)},
+	}
+	done := make(chan string, 1)
+	if _, err := conn.StartTransientUnit(name, "replace", props, done); err != nil {
+		return "", fmt.Errorf("while creating systemd scope %s: %s", name, err)
+	}
+	select {
+	case res := <-done:
+		if res != "done" {
+			return "", fmt.Errorf("systemd scope %s creation %s", name, res)
+		}
+	case <-time.After(scopeTimeout):
+		return "", fmt.Errorf("timeout while creating systemd scope %s", name)
+	}
+
+	return pidUnifiedDir(pid)
+}
+
+// applyUnified creates the cgroup of the process m.Pid in the cgroups v2
+// unified hierarchy and applies the resources restriction spec, rootless
+// cgroups are created in a scope delegated by the systemd user instance.
+func (m *Manager) applyUnified(spec *specs.LinuxResources) error {
+	values, err := unifiedValues(spec)
+	if err != nil {
+		return err
+	}
+
+	base := unifiedMountpoint
+	var dir string
+	if m.Rootless {
+		if base, err = delegatedScope(m.Pid); err != nil {
+			return err
+		}
+		dir = filepath.Join(base, rootlessCgroup)
+	} else {
+		if !filepath.IsAbs(m.Path) {
+			return fmt.Errorf("cgroup path must be an absolute path")
+		}
+		dir = filepath.Join(base, m.Path)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("while creating cgroup %s: %s", dir, err)
+	}
+	m.unified = dir
+
+	// the process is moved first, the parent cgroups can't hold processes
+	// once their controllers are enabled for their children
+	if err := ioutil.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(m.Pid)), 0); err != nil {
+		return fmt.Errorf("while adding process %d to cgroup %s: %s", m.Pid, dir, err)
+	}
+	if err := enableControllers(base, dir, controllers(values)); err != nil {
+		return err
+	}
+	if err := writeUnifiedValues(dir, values); err != nil {
+		return err
+	}
+
+	// device access is controlled by an eBPF program attached to the cgroup
+	if len(spec.Devices) == 0 {
+		return nil
+	}
+	if m.Rootless {
+		sylog.Warningf("Device access rules are not enforced in rootless cgroups v2")
+		return nil
+	}
+	return attachDeviceFilter(dir, spec.Devices)
+}
+
+// loadUnified finds the cgroup of the process m.Pid in the cgroups v2
+// unified hierarchy.
+func (m *Manager) loadUnified() error {
+	if m.unified != "" {
+		return nil
+	}
+	if m.Pid == 0 {
+		return fmt.Errorf("no process ID specified")
+	}
+	dir, err := pidUnifiedDir(m.Pid)
+	if err != nil {
+		return err
+	}
+	m.unified = dir
+	return nil
+}
+
+// freezeUnified freezes or thaws the processes of the cgroup and waits
+// until their state changed.
+func (m *Manager) freezeUnified(freeze bool) error {
+	if err := m.loadUnified(); err != nil {
+		return err
+	}
+
+	state := "0"
+	if freeze {
+		state = "1"
+	}
+	if err := ioutil.WriteFile(filepath.Join(m.unified, "cgroup.freeze"), []byte(state), 0); err != nil {
+		return fmt.Errorf("while writing cgroup freezer state: %s", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		b, err := ioutil.ReadFile(filepath.Join(m.unified, "cgroup.events"))
+		if err != nil {
+			return fmt.Errorf("while reading cgroup events: %s", err)
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if line == "frozen "+state {
+				return nil
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return fmt.Errorf("timeout while waiting for cgroup freezer state %s", state)
+}
+
+// removeUnified removes the cgroup once its processes exited, the scope of
+// a rootless cgroup is removed by systemd once empty.
+func (m *Manager) removeUnified() error {
+	if m.unified == "" {
+		return nil
+	}
+	var err error
+	for i := 0; i < 50; i++ {
+		if err = unix.Rmdir(m.unified); err == nil || err == unix.ENOENT {
+			return nil
+		} else if err != unix.EBUSY {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("while removing cgroup %s: %s", m.unified, err)
+}
+
+// unifiedStats returns the resources usage of the cgroup dir, the interface
+// files of the controllers not enabled for the cgroup are ignored.
+func unifiedStats(dir string) (*Stats, error) {
+	readValue := func(file string) (uint64, error) {
+		b, err := ioutil.ReadFile(filepath.Join(dir, file))
+		if os.IsNotExist(err) {
+			return 0, nil
+		} else if err != nil {
+			return 0, fmt.Errorf("while reading cgroup %s: %s", file, err)
+		}
+		s := strings.TrimSpace(string(b))
+		if s == "max" {
+			return 0, nil
+		}
+		v, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid cgroup %s value %q", file, s)
+		}
+		return v, nil
+	}
+
+	stats := &Stats{}
+	var err error
+	for _, v := range []struct {
+		file  string
+		value *uint64
+	}{
+		{"memory.current", &stats.MemoryUsage},
+		{"memory.max", &stats.MemoryLimit},
+		{"pids.current", &stats.Pids},
+		{"pids.max", &stats.PidsLimit},
+	} {
+		if *v.value, err = readValue(v.file); err != nil {
+			return nil, err
+		}
+	}
+
+	// the CPU usage is always available in cpu.stat, in microseconds
+	b, err := ioutil.ReadFile(filepath.Join(dir, "cpu.stat"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("while reading cgroup cpu.stat: %s", err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			usec, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cgroup cpu.stat usage %q", fields[1])
+			}
+			stats.CPUUsage = usec * 1000
+		}
+	}
+	return stats, nil
+}
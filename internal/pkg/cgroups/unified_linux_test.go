@@ -0,0 +1,173 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cgroups
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func TestUnifiedValues(t *testing.T) {
+	i64 := func(v int64) *int64 { return &v }
+	u64 := func(v uint64) *uint64 { return &v }
+	u16 := func(v uint16) *uint16 { return &v }
+
+	throttle := specs.LinuxThrottleDevice{Rate: 1024}
+	throttle.Major, throttle.Minor = 8, 0
+
+	tests := []struct {
+		name    string
+		spec    specs.LinuxResources
+		want    []unifiedValue
+		wantErr bool
+	}{
+		{
+			name: "Empty",
+		},
+		{
+			name: "Memory",
+			spec: specs.LinuxResources{
+				Memory: &specs.LinuxMemory{Limit: i64(1 << 30), Reservation: i64(1 << 29), Swap: i64(3 << 29)},
+			},
+			want: []unifiedValue{
+				{"memory.max", "1073741824"},
+				{"memory.low", "536870912"},
+				{"memory.swap.max", "536870912"},
+			},
+		},
+		{
+			name: "UnlimitedMemory",
+			spec: specs.LinuxResources{
+				Memory: &specs.LinuxMemory{Limit: i64(-1), Swap: i64(-1)},
+			},
+			want: []unifiedValue{
+				{"memory.max", "max"},
+				{"memory.swap.max", "max"},
+			},
+		},
+		{
+			name: "SwapWithoutLimit",
+			spec: specs.LinuxResources{
+				Memory: &specs.LinuxMemory{Swap: i64(1 << 30)},
+			},
+			wantErr: true,
+		},
+		{
+			name: "CPU",
+			spec: specs.LinuxResources{
+				CPU: &specs.LinuxCPU{Shares: u64(1024), Quota: i64(50000), Cpus: "0-1"},
+			},
+			want: []unifiedValue{
+				{"cpu.weight", "39"},
+				{"cpu.max", "50000 100000"},
+				{"cpuset.cpus", "0-1"},
+			},
+		},
+		{
+			name: "Pids",
+			spec: specs.LinuxResources{
+				Pids: &specs.LinuxPids{Limit: 100},
+			},
+			want: []unifiedValue{{"pids.max", "100"}},
+		},
+		{
+			name: "BlockIO",
+			spec: specs.LinuxResources{
+				BlockIO: &specs.LinuxBlockIO{
+					Weight:                u16(1000),
+					ThrottleReadBpsDevice: []specs.LinuxThrottleDevice{throttle},
+				},
+			},
+			want: []unifiedValue{
+				{"io.weight", "default 10000"},
+				{"io.max", "8:0 rbps=1024"},
+			},
+		},
+		{
+			name: "Hugepages",
+			spec: specs.LinuxResources{
+				HugepageLimits: []specs.LinuxHugepageLimit{{Pagesize: "2MB", Limit: 1 << 21}},
+			},
+			want: []unifiedValue{{"hugetlb.2MB.max", "2097152"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unifiedValues(&tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got values %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestControllers(t *testing.T) {
+	values := []unifiedValue{
+		{"memory.max", "max"},
+		{"cpu.max", "max 100000"},
+		{"memory.swap.max", "max"},
+		{"hugetlb.2MB.max", "0"},
+	}
+	if got, want := controllers(values), []string{"memory", "cpu", "hugetlb"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got controllers %v, want %v", got, want)
+	}
+}
+
+func TestUnifiedPath(t *testing.T) {
+	hybrid := "4:memory:/user.slice\n1:name=systemd:/user.slice\n0::/user.slice/user-1000.slice/session-1.scope\n"
+	if got, err := unifiedPath(strings.NewReader(hybrid)); err != nil || got != "/user.slice/user-1000.slice/session-1.scope" {
+		t.Errorf("got path %q and error %v", got, err)
+	}
+	if _, err := unifiedPath(strings.NewReader("4:memory:/\n")); err == nil {
+		t.Errorf("unexpected success without cgroups v2 hierarchy")
+	}
+}
+
+func TestUnifiedStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cgroup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"memory.current": "1048576\n",
+		"memory.max":     "max\n",
+		"pids.current":   "3\n",
+		"cpu.stat":       "usage_usec 2500\nuser_usec 2000\nsystem_usec 500\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := unifiedStats(dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := &Stats{CPUUsage: 2500000, MemoryUsage: 1 << 20, Pids: 3}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "pids.max"), []byte("many\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unifiedStats(dir); err == nil {
+		t.Errorf("unexpected success with invalid pids.max")
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package chunkstore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	// MinChunkSize is the minimum size of a chunk, except the last one.
+	MinChunkSize = 16 * 1024
+	// MaxChunkSize is the maximum size of a chunk.
+	MaxChunkSize = 256 * 1024
+	// chunkMask cuts a chunk every 64KiB on average past MinChunkSize,
+	// the high bits of the gear hash depend on the last 64 bytes.
+	chunkMask = uint64(0xffff) << 48
+)
+
+// gear maps the bytes to the random values of the gear hash, it's
+// derived from SHA256 so chunk boundaries never change.
+var gear [256]uint64
+
+func init() {
+	for i := range gear {
+		sum := sha256.Sum256([]byte{byte(i)})
+		gear[i] = binary.LittleEndian.Uint64(sum[:8])
+	}
+}
+
+// chunker splits a stream in content defined chunks, the boundaries
+// are found with a gear rolling hash so that the chunks of identical
+// data are identical wherever the data is in the stream.
+type chunker struct {
+	r     io.Reader
+	buf   []byte
+	start int
+	end   int
+	eof   bool
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{
+		r:   r,
+		buf: make([]byte, 2*MaxChunkSize),
+	}
+}
+
+// next returns the next chunk, it's valid until the next call, or
+// io.EOF once the stream is consumed.
+func (c *chunker) next() ([]byte, error) {
+	if c.end-c.start < MaxChunkSize && !c.eof {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+
+		n, err := io.ReadFull(c.r, c.buf[c.end:])
+		c.end += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	data := c.buf[c.start:c.end]
+	if len(data) == 0 {
+		return nil, io.EOF
+	}
+	n := cutPoint(data)
+	c.start += n
+	return data[:n], nil
+}
+
+// cutPoint returns the size of the chunk starting data.
+func cutPoint(data []byte) int {
+	if len(data) <= MinChunkSize {
+		return len(data)
+	}
+	if len(data) > MaxChunkSize {
+		data = data[:MaxChunkSize]
+	}
+
+	var h uint64
+	for i := MinChunkSize; i < len(data); i++ {
+		h = h<<1 + gear[data[i]]
+		if h&chunkMask == 0 {
+			return i + 1
+		}
+	}
+	return len(data)
+}
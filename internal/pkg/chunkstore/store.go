@@ -0,0 +1,396 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package chunkstore provides a local content addressable store of SIF
+// images, the images are split in content defined chunks deduplicated
+// across the images of the store.
+package chunkstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/pkg/syfs"
+	"github.com/sylabs/singularity/pkg/util/fs/lock"
+)
+
+// DirEnv specifies the environment variable which can set the directory
+// of the store.
+const DirEnv = "SINGULARITY_STOREDIR"
+
+const (
+	chunksDir = "chunks"
+	imagesDir = "images"
+)
+
+// ErrNoImage is returned when an image isn't in the store.
+var ErrNoImage = errors.New("no such image in the store")
+
+var nameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// Chunk is a chunk of an image identified by its SHA256 digest.
+type Chunk struct {
+	Digest string `json:"digest"`
+	Size   int64  `json:"size"`
+}
+
+// Image is an image of the store, it's the concatenation of its chunks.
+type Image struct {
+	Name   string  `json:"name"`
+	Digest string  `json:"digest"`
+	Size   int64   `json:"size"`
+	Chunks []Chunk `json:"chunks"`
+}
+
+// Usage is the space used by the store.
+type Usage struct {
+	// Images is the number of images.
+	Images int
+	// ImagesSize is the size of the images once reconstructed.
+	ImagesSize int64
+	// Chunks is the number of chunks stored.
+	Chunks int
+	// StoredSize is the size of the chunks stored.
+	StoredSize int64
+}
+
+// Store is a local chunk store.
+type Store struct {
+	dir string
+}
+
+// DefaultDir returns the directory of the store set by DirEnv, or the
+// store directory of the Singularity configuration directory.
+func DefaultDir() string {
+	if dir := os.Getenv(DirEnv); dir != "" {
+		return dir
+	}
+	return filepath.Join(syfs.ConfigDir(), "store")
+}
+
+// Open opens the store in dir, creating it if needed.
+func Open(dir string) (*Store, error) {
+	for _, d := range []string{chunksDir, imagesDir} {
+		if err := fs.MkdirAll(filepath.Join(dir, d), 0700); err != nil {
+			return nil, fmt.Errorf("while creating store directory: %v", err)
+		}
+	}
+	return &Store{dir: dir}, nil
+}
+
+// lock takes the lock of the store, the returned function releases it.
+func (s *Store) lock() (func(), error) {
+	fd, err := lock.Exclusive(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("while locking store: %v", err)
+	}
+	return func() { lock.Release(fd) }, nil
+}
+
+func (s *Store) chunkPath(digest string) string {
+	return filepath.Join(s.dir, chunksDir, digest[:2], digest)
+}
+
+func (s *Store) imagePath(name string) string {
+	return filepath.Join(s.dir, imagesDir, name+".json")
+}
+
+// Add adds the SIF image path to the store as name, replacing the image
+// with the same name. The partitions are chunked apart from the rest of
+// the image so that identical partitions give identical chunks wherever
+// they are in the images.
+func (s *Store) Add(name, path string) (*Image, error) {
+	if !nameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid image name %q: only letters, digits, '.', '_' and '-' are allowed", name)
+	}
+
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return nil, fmt.Errorf("while loading SIF image %s: %v", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	fi, err := fimg.Fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	img := &Image{Name: name, Size: fi.Size()}
+	h := sha256.New()
+	var off int64
+	for _, b := range append(partitionBounds(&fimg), img.Size) {
+		if b <= off {
+			continue
+		}
+		r := io.TeeReader(io.NewSectionReader(fimg.Fp, off, b-off), h)
+		if err := s.addChunks(img, r); err != nil {
+			return nil, err
+		}
+		off = b
+	}
+	img.Digest = hex.EncodeToString(h.Sum(nil))
+
+	data, err := json.MarshalIndent(img, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(s.imagePath(name), data); err != nil {
+		return nil, fmt.Errorf("while writing image manifest: %v", err)
+	}
+	return img, nil
+}
+
+// partitionBounds returns the offsets where the partitions of the image
+// start and end.
+func partitionBounds(fimg *sif.FileImage) []int64 {
+	var bounds []int64
+	for _, d := range fimg.DescrArr {
+		if d.Used && d.Datatype == sif.DataPartition {
+			bounds = append(bounds, d.Fileoff, d.Fileoff+d.Filelen)
+		}
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	return bounds
+}
+
+// addChunks chunks r and stores the chunks which aren't stored yet.
+func (s *Store) addChunks(img *Image, r io.Reader) error {
+	c := newChunker(r)
+	for {
+		data, err := c.next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("while reading image: %v", err)
+		}
+
+		sum := sha256.Sum256(data)
+		digest := hex.EncodeToString(sum[:])
+		img.Chunks = append(img.Chunks, Chunk{Digest: digest, Size: int64(len(data))})
+
+		path := s.chunkPath(digest)
+		if fs.IsFile(path) {
+			continue
+		}
+		if err := fs.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return err
+		}
+		if err := writeFile(path, data); err != nil {
+			return fmt.Errorf("while writing chunk: %v", err)
+		}
+	}
+}
+
+// writeFile writes data to a temporary file renamed to path, so that
+// path is either missing or complete.
+func writeFile(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Image returns the image name.
+func (s *Store) Image(name string) (*Image, error) {
+	data, err := ioutil.ReadFile(s.imagePath(name))
+	if os.IsNotExist(err) || !nameRegexp.MatchString(name) {
+		return nil, ErrNoImage
+	} else if err != nil {
+		return nil, err
+	}
+
+	img := new(Image)
+	if err := json.Unmarshal(data, img); err != nil {
+		return nil, fmt.Errorf("while decoding manifest of image %s: %v", name, err)
+	}
+	return img, nil
+}
+
+// Images returns the images of the store sorted by name.
+func (s *Store) Images() ([]*Image, error) {
+	files, err := ioutil.ReadDir(filepath.Join(s.dir, imagesDir))
+	if err != nil {
+		return nil, err
+	}
+
+	var images []*Image
+	for _, f := range files {
+		name := strings.TrimSuffix(f.Name(), ".json")
+		if name == f.Name() || !nameRegexp.MatchString(name) {
+			continue
+		}
+		img, err := s.Image(name)
+		if err != nil {
+			return nil, err
+		}
+		images = append(images, img)
+	}
+	return images, nil
+}
+
+// Extract reconstructs the image name at path. The content is checked
+// against the digest of the image added to the store.
+func (s *Store) Extract(name, path string) error {
+	img, err := s.Image(name)
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+"-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	h := sha256.New()
+	w := io.MultiWriter(f, h)
+	for _, c := range img.Chunks {
+		if err := s.copyChunk(w, c); err != nil {
+			return err
+		}
+	}
+	if hex.EncodeToString(h.Sum(nil)) != img.Digest {
+		return fmt.Errorf("reconstructed image %s doesn't match its digest", name)
+	}
+
+	if err := f.Chmod(0755); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (s *Store) copyChunk(w io.Writer, c Chunk) error {
+	f, err := os.Open(s.chunkPath(c.Digest))
+	if err != nil {
+		return fmt.Errorf("while opening chunk: %v", err)
+	}
+	defer f.Close()
+
+	if n, err := io.Copy(w, f); err != nil {
+		return fmt.Errorf("while copying chunk %s: %v", c.Digest, err)
+	} else if n != c.Size {
+		return fmt.Errorf("chunk %s has %d bytes instead of %d", c.Digest, n, c.Size)
+	}
+	return nil
+}
+
+// Remove removes the image name, its chunks are removed by Prune once
+// no image references them.
+func (s *Store) Remove(name string) error {
+	if _, err := s.Image(name); err != nil {
+		return err
+	}
+
+	unlock, err := s.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return os.Remove(s.imagePath(name))
+}
+
+// Prune removes the chunks which aren't referenced by an image, and
+// returns the number and the size of the chunks removed. Nothing is
+// removed if dryRun is true.
+func (s *Store) Prune(dryRun bool) (int, int64, error) {
+	unlock, err := s.lock()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer unlock()
+
+	images, err := s.Images()
+	if err != nil {
+		return 0, 0, err
+	}
+	used := make(map[string]bool)
+	for _, img := range images {
+		for _, c := range img.Chunks {
+			used[c.Digest] = true
+		}
+	}
+
+	count, size := 0, int64(0)
+	err = s.walkChunks(func(path string, fi os.FileInfo) error {
+		if used[fi.Name()] {
+			return nil
+		}
+		count++
+		size += fi.Size()
+		if dryRun {
+			return nil
+		}
+		return os.Remove(path)
+	})
+	return count, size, err
+}
+
+// Usage returns the space used by the store.
+func (s *Store) Usage() (Usage, error) {
+	var u Usage
+
+	images, err := s.Images()
+	if err != nil {
+		return u, err
+	}
+	u.Images = len(images)
+	for _, img := range images {
+		u.ImagesSize += img.Size
+	}
+
+	err = s.walkChunks(func(path string, fi os.FileInfo) error {
+		u.Chunks++
+		u.StoredSize += fi.Size()
+		return nil
+	})
+	return u, err
+}
+
+// walkChunks calls fn for each chunk file of the store.
+func (s *Store) walkChunks(fn func(string, os.FileInfo) error) error {
+	root := filepath.Join(s.dir, chunksDir)
+	return filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() || strings.HasPrefix(fi.Name(), ".") {
+			return nil
+		}
+		return fn(path, fi)
+	})
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package chunkstore
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+func randomData(seed int64, size int) []byte {
+	b := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(b)
+	return b
+}
+
+// createTestSIF creates a SIF image with a definition file and a
+// partition.
+func createTestSIF(t *testing.T, path string, def, part []byte) {
+	t.Helper()
+
+	cinfo := sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+	}
+	for _, d := range []struct {
+		datatype sif.Datatype
+		data     []byte
+	}{
+		{sif.DataDeffile, def},
+		{sif.DataPartition, part},
+	} {
+		input := sif.DescriptorInput{
+			Datatype: d.datatype,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     sif.DescrUnusedLink,
+			Size:     int64(len(d.data)),
+			Fname:    "data",
+			Fp:       bytes.NewReader(d.data),
+		}
+		if d.datatype == sif.DataPartition {
+			if err := input.SetPartExtra(sif.FsSquash, sif.PartPrimSys, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cinfo.InputDescr = append(cinfo.InputDescr, input)
+	}
+
+	fimg, err := sif.CreateContainer(cinfo)
+	if err != nil {
+		t.Fatalf("while creating SIF image: %v", err)
+	}
+	fimg.UnloadContainer()
+}
+
+func chunks(t *testing.T, data []byte) [][]byte {
+	var res [][]byte
+	c := newChunker(bytes.NewReader(data))
+	for {
+		b, err := c.next()
+		if err == io.EOF {
+			return res
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if len(b) > MaxChunkSize {
+			t.Fatalf("chunk of %d bytes", len(b))
+		}
+		res = append(res, append([]byte(nil), b...))
+	}
+}
+
+func TestChunker(t *testing.T) {
+	data := randomData(1, 4*1024*1024)
+	a := chunks(t, data)
+	if !bytes.Equal(bytes.Join(a, nil), data) {
+		t.Fatalf("chunks don't match the data")
+	}
+	if len(a) < 8 {
+		t.Errorf("unexpected %d chunks for 4MiB", len(a))
+	}
+
+	// inserting data only changes the chunks around it
+	shifted := append(randomData(2, 1000), data...)
+	b := chunks(t, shifted)
+	same := make(map[string]bool)
+	for _, c := range b {
+		same[string(c)] = true
+	}
+	shared := 0
+	for _, c := range a {
+		if same[string(c)] {
+			shared++
+		}
+	}
+	if shared < len(a)-2 {
+		t.Errorf("only %d of %d chunks shared after inserting data", shared, len(a))
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chunkstore-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := Open(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the images share their partition at different offsets
+	part := randomData(3, 2*1024*1024)
+	a := filepath.Join(dir, "a.sif")
+	createTestSIF(t, a, []byte("bootstrap: scratch\n"), part)
+	b := filepath.Join(dir, "b.sif")
+	createTestSIF(t, b, randomData(4, 10000), part)
+
+	if _, err := s.Add("a", a); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.Add("b", b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.Add("../c", b); err == nil {
+		t.Errorf("unexpected success adding an invalid name")
+	}
+
+	u, err := s.Usage()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if u.Images != 2 {
+		t.Errorf("unexpected %d images", u.Images)
+	}
+	if u.StoredSize > u.ImagesSize/2+100*1024 {
+		t.Errorf("partition not deduplicated: %d bytes stored for %d bytes of images", u.StoredSize, u.ImagesSize)
+	}
+
+	out := filepath.Join(dir, "out.sif")
+	for name, path := range map[string]string{"a": a, "b": b} {
+		if err := s.Extract(name, out); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		expected, _ := ioutil.ReadFile(path)
+		got, _ := ioutil.ReadFile(out)
+		if !bytes.Equal(got, expected) {
+			t.Errorf("reconstructed image %s differs", name)
+		}
+	}
+
+	if err := s.Remove("b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := s.Extract("b", out); err != ErrNoImage {
+		t.Errorf("unexpected error %v instead of %v", err, ErrNoImage)
+	}
+	count, _, err := s.Prune(false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count == 0 {
+		t.Errorf("no chunk removed after removing image b")
+	}
+	if err := s.Extract("a", out); err != nil {
+		t.Errorf("unable to reconstruct image a after pruning: %v", err)
+	}
+}
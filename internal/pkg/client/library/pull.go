@@ -10,6 +10,8 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"runtime"
 
 	keyclient "github.com/sylabs/scs-key-client/client"
 	scs "github.com/sylabs/scs-library-client/client"
@@ -28,6 +30,10 @@ var (
 
 // pull will pull a library image into the cache if directTo="", or a specific file if directTo is set.
 func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom string, arch string, scsConfig *scs.Config, keystoreURI string) (imagePath string, err error) {
+	span := sylog.StartSpan("pull")
+	span.SetAttribute("image", pullFrom)
+	defer span.End()
+
 	imageRef := NormalizeLibraryRef(pullFrom)
 
 	sylog.GetLevel()
@@ -135,3 +141,72 @@ func PullToFile(ctx context.Context, imgCache *cache.Handle, pullTo, pullFrom, a
 
 	return pullTo, nil
 }
+
+// AllArchs are the architectures looked up by PullAllArchToFile.
+var AllArchs = []string{"amd64", "arm64", "ppc64le", "s390x", "386", "arm"}
+
+// PullAllArchToFile pulls the images of all the architectures available
+// for a library image, through the cache, and assembles them in the
+// multi-architecture image pullTo. The image of the host architecture
+// comes first and keeps its signatures, each image is verified before
+// being assembled.
+func PullAllArchToFile(ctx context.Context, imgCache *cache.Handle, pullTo, pullFrom string, tmpDir string, scsConfig *scs.Config, keystoreURI string) (imagePath string, err error) {
+	imageRef := NormalizeLibraryRef(pullFrom)
+
+	c, err := scs.NewClient(scsConfig)
+	if err != nil {
+		return "", fmt.Errorf("unable to initialize client library: %v", err)
+	}
+
+	archs := []string{runtime.GOARCH}
+	for _, arch := range AllArchs {
+		if arch != runtime.GOARCH {
+			archs = append(archs, arch)
+		}
+	}
+
+	kc := keyclient.Config{
+		BaseURL:   keystoreURI,
+		AuthToken: scsConfig.AuthToken,
+		UserAgent: useragent.Value(),
+	}
+
+	var images []string
+	unsigned := false
+	for _, arch := range archs {
+		if _, err := c.GetImage(ctx, arch, imageRef); err == scs.ErrNotFound {
+			sylog.Debugf("No %s image for %s", arch, imageRef)
+			continue
+		} else if err != nil {
+			return "", err
+		}
+
+		sylog.Infof("Pulling %s image", arch)
+		path, err := Pull(ctx, imgCache, pullFrom, arch, tmpDir, scsConfig, keystoreURI)
+		if err != nil {
+			return "", fmt.Errorf("error fetching %s image: %v", arch, err)
+		}
+		if imgCache.IsDisabled() {
+			defer os.Remove(path)
+		}
+
+		if err := singularity.Verify(ctx, path, singularity.OptVerifyUseKeyServer(&kc)); err != nil {
+			sylog.Warningf("%s image: %v", arch, err)
+			unsigned = true
+		}
+		images = append(images, path)
+	}
+	if len(images) == 0 {
+		return "", fmt.Errorf("image does not exist in the library: %s", imageRef)
+	}
+
+	sylog.Infof("Assembling %d architectures", len(images))
+	if err := singularity.SifAssembleArch(pullTo, images); err != nil {
+		return "", fmt.Errorf("while assembling multi-architecture image: %v", err)
+	}
+
+	if unsigned {
+		return pullTo, ErrLibraryPullUnsigned
+	}
+	return pullTo, nil
+}
@@ -0,0 +1,194 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package net
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/cache"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/fuse"
+	"github.com/sylabs/singularity/pkg/sylog"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// lazyEnv holds the arguments of the process serving a lazy image.
+	lazyEnv = "SINGULARITY_LAZY_IMAGE"
+	// lazyReadyFd is the descriptor where the serving process writes a
+	// null byte once the image is mounted, or an error message.
+	lazyReadyFd = 3
+)
+
+// errNotSIF is returned for lazy images which aren't SIF images.
+var errNotSIF = errors.New("not a SIF image")
+
+// lazyArgs are the arguments of the process serving a lazy image.
+type lazyArgs struct {
+	URL          string `json:"url"`
+	Mountpoint   string `json:"mountpoint"`
+	Name         string `json:"name"`
+	TmpDir       string `json:"tmpDir"`
+	DisableCache bool   `json:"disableCache"`
+	AllowRoot    bool   `json:"allowRoot"`
+}
+
+// MountLazy mounts the http(s) SIF image pullFrom with FUSE in a directory
+// of tmpDir and returns the path of the image, its blocks are fetched
+// with range requests when they're read. The image is served by a
+// separate process until the calling process exits and the image is no
+// longer used, allowRoot lets root access the image (eg: the setuid
+// starter).
+func MountLazy(imgCache *cache.Handle, pullFrom, tmpDir string, allowRoot bool) (string, error) {
+	if !IsNetPullRef(pullFrom) {
+		return "", fmt.Errorf("not a valid url reference: %s", pullFrom)
+	}
+
+	name := "image.sif"
+	if u, err := url.Parse(pullFrom); err == nil && strings.HasSuffix(u.Path, ".sif") {
+		name = path.Base(u.Path)
+	}
+	mountpoint, err := ioutil.TempDir(tmpDir, "lazy-image-")
+	if err != nil {
+		return "", fmt.Errorf("while creating mount point: %v", err)
+	}
+
+	b, err := json.Marshal(lazyArgs{
+		URL:          pullFrom,
+		Mountpoint:   mountpoint,
+		Name:         name,
+		TmpDir:       tmpDir,
+		DisableCache: imgCache == nil || imgCache.IsDisabled(),
+		AllowRoot:    allowRoot,
+	})
+	if err != nil {
+		os.Remove(mountpoint)
+		return "", err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		os.Remove(mountpoint)
+		return "", err
+	}
+	defer r.Close()
+
+	c := &exec.Cmd{
+		Path:       "/proc/self/exe",
+		Args:       []string{os.Args[0]},
+		Env:        append(append(os.Environ(), sylog.GetEnvVars()...), lazyEnv+"="+string(b)),
+		Stderr:     os.Stderr,
+		ExtraFiles: []*os.File{w},
+		// the image is still served when the container is interrupted
+		SysProcAttr: &syscall.SysProcAttr{Setsid: true},
+	}
+	err = c.Start()
+	w.Close()
+	if err != nil {
+		os.Remove(mountpoint)
+		return "", fmt.Errorf("while starting lazy image process: %v", err)
+	}
+
+	msg, _ := ioutil.ReadAll(r)
+	if len(msg) != 1 || msg[0] != 0 {
+		c.Wait()
+		os.Remove(mountpoint)
+		if len(msg) == 0 {
+			return "", errors.New("lazy image process exited unexpectedly")
+		}
+		return "", errors.New(string(msg))
+	}
+	c.Process.Release()
+
+	return filepath.Join(mountpoint, name), nil
+}
+
+// LazyChild serves the lazy image of a process started by MountLazy and
+// exits, it returns immediately otherwise. It must be called at the
+// beginning of main.
+func LazyChild() {
+	v, ok := os.LookupEnv(lazyEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(lazyEnv)
+
+	ready := os.NewFile(lazyReadyFd, "ready")
+	if err := serveLazy(v, ready); err != nil {
+		// the error is reported by MountLazy until the image is mounted
+		if _, werr := ready.Write([]byte(err.Error())); werr != nil {
+			sylog.Errorf("While serving lazy image: %v", err)
+		}
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// serveLazy mounts and serves the lazy image described by the JSON
+// arguments v, a null byte is written to ready once the image is mounted.
+func serveLazy(v string, ready *os.File) error {
+	var args lazyArgs
+	if err := json.Unmarshal([]byte(v), &args); err != nil {
+		return fmt.Errorf("while reading lazy image arguments: %v", err)
+	}
+	defer os.Remove(args.Mountpoint)
+
+	imgCache, err := cache.New(cache.Config{
+		ParentDir: os.Getenv(cache.DirEnv),
+		Disable:   args.DisableCache,
+	})
+	if err != nil {
+		return err
+	}
+	r, err := NewRangeReader(context.Background(), imgCache, args.URL, args.TmpDir)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	hdr := make([]byte, sif.HdrLaunchLen+len(sif.HdrMagic))
+	if _, err := r.ReadAt(hdr, 0); err != nil || !bytes.Equal(hdr[sif.HdrLaunchLen:], []byte(sif.HdrMagic)) {
+		return errNotSIF
+	}
+
+	fd, err := fuse.Mount(args.Mountpoint, fuse.MountOptions{
+		FSName:    "singularity-lazy",
+		AllowRoot: args.AllowRoot,
+	})
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ready.Write([]byte{0})
+	ready.Close()
+
+	// unmount once the container process started by the parent exited,
+	// the file system is released when the image is no longer used
+	ppid := os.Getppid()
+	go func() {
+		for os.Getppid() == ppid {
+			time.Sleep(time.Second)
+		}
+		if err := fuse.Unmount(args.Mountpoint); err != nil {
+			sylog.Debugf("While unmounting lazy image: %v", err)
+		}
+	}()
+
+	return fuse.Serve(fd, fuse.File{Name: args.Name, Size: r.Size(), Reader: r})
+}
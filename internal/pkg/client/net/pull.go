@@ -112,6 +112,10 @@ func DownloadImage(ctx context.Context, filePath string, netURL string) error {
 
 // pull will pull a http(s) image into the cache if directTo="", or a specific file if directTo is set.
 func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom string) (imagePath string, err error) {
+	span := sylog.StartSpan("pull")
+	span.SetAttribute("image", pullFrom)
+	defer span.End()
+
 	// We will cache using a sha256 over the URL and the date of the file that
 	// is to be fetched, as returned by an HTTP HEAD call and the Last-Modified
 	// header. If no date is available, use the current date-time, which will
@@ -0,0 +1,320 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package net
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sylabs/singularity/internal/pkg/cache"
+	"github.com/sylabs/singularity/pkg/sylog"
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
+)
+
+// BlockSize is the size of the blocks fetched by a RangeReader.
+const BlockSize = 1 << 20
+
+// fetchAttempts is the number of times a block is requested before
+// reporting an error.
+const fetchAttempts = 3
+
+// RangeReader reads an http(s) image with range requests, only the blocks
+// actually read are fetched. The blocks are written to a sparse cache file
+// holding the image data followed by one byte per block set once the
+// block is fetched, so that the blocks are shared by the runs of the same
+// image version.
+type RangeReader struct {
+	url       string
+	size      int64
+	validator string
+	client    *http.Client
+	file      *os.File
+	temp      bool
+
+	mu      sync.Mutex
+	fetched []bool
+	pending map[int64]*blockFetch
+}
+
+// blockFetch is a block being fetched, done is closed once err is set.
+type blockFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewRangeReader returns a RangeReader of the http(s) image pullFrom. The
+// blocks are cached in the blocks cache of imgCache, or in a temporary file
+// of tmpDir removed by Close if the cache is disabled or the server doesn't
+// return an ETag or Last-Modified header to identify the image version.
+// An error is returned if the server doesn't support range requests.
+func NewRangeReader(ctx context.Context, imgCache *cache.Handle, pullFrom, tmpDir string) (*RangeReader, error) {
+	if !IsNetPullRef(pullFrom) {
+		return nil, fmt.Errorf("not a valid url reference: %s", pullFrom)
+	}
+
+	r := &RangeReader{
+		url:     pullFrom,
+		client:  &http.Client{},
+		pending: make(map[int64]*blockFetch),
+	}
+
+	// the first block tells the image size and version
+	res, err := r.get(ctx, 0, BlockSize-1)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusPartialContent {
+		return nil, fmt.Errorf("server doesn't support range requests for %s", pullFrom)
+	}
+	i := strings.LastIndex(res.Header.Get("Content-Range"), "/")
+	if i < 0 {
+		return nil, fmt.Errorf("no image size in Content-Range header %q", res.Header.Get("Content-Range"))
+	}
+	r.size, err = strconv.ParseInt(res.Header.Get("Content-Range")[i+1:], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("no image size in Content-Range header %q", res.Header.Get("Content-Range"))
+	} else if r.size == 0 {
+		return nil, fmt.Errorf("image %s is empty", pullFrom)
+	}
+	r.validator = res.Header.Get("ETag")
+	if r.validator == "" {
+		r.validator = res.Header.Get("Last-Modified")
+	}
+	r.fetched = make([]bool, r.blocks())
+
+	if err := r.openCache(imgCache, tmpDir); err != nil {
+		return nil, err
+	}
+
+	if !r.fetched[0] {
+		b := make([]byte, r.blockLen(0))
+		if _, err := io.ReadFull(res.Body, b); err != nil {
+			r.Close()
+			return nil, fmt.Errorf("while reading first block of %s: %v", pullFrom, err)
+		}
+		if err := r.store(0, b); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// openCache opens the cache file of the image and reads the fetched blocks.
+func (r *RangeReader) openCache(imgCache *cache.Handle, tmpDir string) error {
+	var err error
+
+	if imgCache == nil || imgCache.IsDisabled() || r.validator == "" {
+		sylog.Debugf("Caching blocks of %s in a temporary file", r.url)
+		r.temp = true
+		r.file, err = ioutil.TempFile(tmpDir, "lazy-image-")
+		if err != nil {
+			return fmt.Errorf("unable to create tmp file: %v", err)
+		}
+		return r.file.Truncate(r.size + r.blocks())
+	}
+
+	dir, err := imgCache.GetFileCacheDir(cache.BlocksCacheType)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("while creating blocks cache directory: %v", err)
+	}
+	h := sha256.New()
+	h.Write([]byte(r.url + r.validator))
+	path := filepath.Join(dir, hex.EncodeToString(h.Sum(nil)))
+	sylog.Debugf("Caching blocks of %s in %s", r.url, path)
+
+	r.file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("while opening blocks cache file: %v", err)
+	}
+	fi, err := r.file.Stat()
+	if err != nil {
+		r.file.Close()
+		return err
+	}
+	if fi.Size() != r.size+r.blocks() {
+		if err := r.file.Truncate(r.size + r.blocks()); err != nil {
+			r.file.Close()
+			return fmt.Errorf("while sizing blocks cache file: %v", err)
+		}
+	}
+
+	m := make([]byte, r.blocks())
+	if _, err := r.file.ReadAt(m, r.size); err != nil {
+		r.file.Close()
+		return fmt.Errorf("while reading blocks cache file: %v", err)
+	}
+	for i, b := range m {
+		r.fetched[i] = b != 0
+	}
+	return nil
+}
+
+// Size returns the size of the image.
+func (r *RangeReader) Size() int64 {
+	return r.size
+}
+
+// ReadAt implements io.ReaderAt, the blocks of the range are fetched
+// if they aren't cached yet.
+func (r *RangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > r.size {
+		end = r.size
+	}
+	for b := off / BlockSize; b*BlockSize < end; b++ {
+		if err := r.fetch(b); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.ReadAt(p[:end-off], off)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// Close closes the cache file, it's removed if it's a temporary file.
+func (r *RangeReader) Close() error {
+	err := r.file.Close()
+	if r.temp {
+		os.Remove(r.file.Name())
+	}
+	return err
+}
+
+// fetch fetches the block b if it's not cached, concurrent reads of the
+// same block wait for a single request.
+func (r *RangeReader) fetch(b int64) error {
+	r.mu.Lock()
+	if r.fetched[b] {
+		r.mu.Unlock()
+		return nil
+	}
+	if f, ok := r.pending[b]; ok {
+		r.mu.Unlock()
+		<-f.done
+		return f.err
+	}
+	f := &blockFetch{done: make(chan struct{})}
+	r.pending[b] = f
+	r.mu.Unlock()
+
+	// another process may have fetched the block since the cache was opened
+	m := make([]byte, 1)
+	if _, err := r.file.ReadAt(m, r.size+b); err != nil || m[0] == 0 {
+		for i := 0; i < fetchAttempts; i++ {
+			if f.err = r.fetchBlock(b); f.err == nil {
+				break
+			}
+			sylog.Debugf("Failed to fetch block %d of %s: %v", b, r.url, f.err)
+		}
+	}
+
+	r.mu.Lock()
+	delete(r.pending, b)
+	r.fetched[b] = f.err == nil
+	r.mu.Unlock()
+	close(f.done)
+	return f.err
+}
+
+// fetchBlock requests the block b and stores it in the cache file.
+func (r *RangeReader) fetchBlock(b int64) error {
+	start := b * BlockSize
+	res, err := r.get(context.Background(), start, start+r.blockLen(b)-1)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusPartialContent {
+		// If-Range returns the whole image if the version changed
+		if res.StatusCode == http.StatusOK {
+			return fmt.Errorf("image %s changed on the server", r.url)
+		}
+		return fmt.Errorf("unexpected status %d for block %d of %s", res.StatusCode, b, r.url)
+	}
+
+	data := make([]byte, r.blockLen(b))
+	if _, err := io.ReadFull(res.Body, data); err != nil {
+		return fmt.Errorf("while reading block %d of %s: %v", b, r.url, err)
+	}
+	return r.store(b, data)
+}
+
+// store writes the block b to the cache file before marking it fetched.
+func (r *RangeReader) store(b int64, data []byte) error {
+	if _, err := r.file.WriteAt(data, b*BlockSize); err != nil {
+		return fmt.Errorf("while caching block %d: %v", b, err)
+	}
+	if _, err := r.file.WriteAt([]byte{1}, r.size+b); err != nil {
+		return fmt.Errorf("while caching block %d: %v", b, err)
+	}
+	r.mu.Lock()
+	r.fetched[b] = true
+	r.mu.Unlock()
+	return nil
+}
+
+// get requests the bytes start to end of the image.
+func (r *RangeReader) get(ctx context.Context, start, end int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", useragent.Value())
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if r.validator != "" {
+		req.Header.Set("If-Range", r.validator)
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, fmt.Errorf("the requested image was not found")
+	}
+	return res, nil
+}
+
+// blocks returns the number of blocks of the image.
+func (r *RangeReader) blocks() int64 {
+	return (r.size + BlockSize - 1) / BlockSize
+}
+
+// blockLen returns the length of the block b, the last block may be
+// shorter than BlockSize.
+func (r *RangeReader) blockLen(b int64) int64 {
+	if (b+1)*BlockSize > r.size {
+		return r.size - b*BlockSize
+	}
+	return BlockSize
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package net
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity/internal/pkg/cache"
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
+)
+
+func TestMain(m *testing.M) {
+	useragent.InitValue("singularity", "3.0.0-alpha.1-303-gaed8d30-dirty")
+
+	os.Exit(m.Run())
+}
+
+// imageServer serves data with range requests and counts the requests.
+type imageServer struct {
+	data     []byte
+	etag     string
+	requests int32
+}
+
+func (s *imageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&s.requests, 1)
+	if s.etag != "" {
+		w.Header().Set("ETag", s.etag)
+	}
+	http.ServeContent(w, r, "image.sif", time.Time{}, bytes.NewReader(s.data))
+}
+
+func TestRangeReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rangereader-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	imgCache, err := cache.New(cache.Config{ParentDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 3*BlockSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	s := &imageServer{data: data, etag: `"v1"`}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+
+	r, err := NewRangeReader(context.Background(), imgCache, srv.URL+"/image.sif", dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if r.Size() != int64(len(data)) {
+		t.Errorf("got size %d, want %d", r.Size(), len(data))
+	}
+
+	// a read across the last two blocks fetches them only
+	b := make([]byte, 200)
+	off := int64(3*BlockSize - 100)
+	if n, err := r.ReadAt(b, off); err != nil || n != len(b) {
+		t.Fatalf("unexpected read of %d bytes: %v", n, err)
+	}
+	if !bytes.Equal(b, data[off:off+200]) {
+		t.Errorf("unexpected data at offset %d", off)
+	}
+	if got := atomic.LoadInt32(&s.requests); got != 3 {
+		t.Errorf("got %d requests, want 3", got)
+	}
+
+	// reading past the end returns io.EOF
+	if n, err := r.ReadAt(b, int64(len(data)-10)); err != io.EOF || n != 10 {
+		t.Errorf("got %d bytes and error %v at end of image", n, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the blocks are cached for the same image version
+	r, err = NewRangeReader(context.Background(), imgCache, srv.URL+"/image.sif", dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.ReadAt(b, off); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := atomic.LoadInt32(&s.requests); got != 4 {
+		t.Errorf("got %d requests, want 4", got)
+	}
+
+	// a new version of the image is detected
+	s.etag = `"v2"`
+	if _, err := r.ReadAt(b, BlockSize); err == nil {
+		t.Errorf("unexpected success reading a changed image")
+	}
+	r.Close()
+}
+
+func TestRangeReaderNoRange(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("image"))
+	}))
+	defer srv.Close()
+
+	if _, err := NewRangeReader(context.Background(), nil, srv.URL+"/image.sif", ""); err == nil {
+		t.Errorf("unexpected success with a server not supporting range requests")
+	}
+}
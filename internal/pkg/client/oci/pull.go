@@ -9,17 +9,24 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"strings"
 
 	ocitypes "github.com/containers/image/v5/types"
 	"github.com/sylabs/singularity/internal/pkg/build"
 	"github.com/sylabs/singularity/internal/pkg/build/oci"
 	"github.com/sylabs/singularity/internal/pkg/cache"
+	"github.com/sylabs/singularity/internal/pkg/client/oras"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/internal/pkg/util/uri"
 	"github.com/sylabs/singularity/pkg/sylog"
 )
 
 // pull will build a SIF image into the cache if directTo="", or a specific file if directTo is set.
 func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom, tmpDir string, ociAuth *ocitypes.DockerAuthConfig, noHTTPS, noCleanUp bool) (imagePath string, err error) {
+	span := sylog.StartSpan("pull")
+	span.SetAttribute("image", pullFrom)
+	defer span.End()
+
 	// DockerInsecureSkipTLSVerify is set only if --nohttps is specified to honor
 	// configuration from /etc/containers/registries.conf because DockerInsecureSkipTLSVerify
 	// can have three possible values true/false and undefined, so we left it as undefined instead
@@ -72,8 +79,42 @@ func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom, tmpDi
 	return imagePath, nil
 }
 
+// sifRef returns the oras reference of pullFrom if it's a docker reference
+// to a SIF image uploaded to a registry by push, SIF images are pulled as
+// is instead of being converted. Docker Hub references without registry
+// host aren't looked up.
+func sifRef(ctx context.Context, pullFrom string, ociAuth *ocitypes.DockerAuthConfig) (string, bool) {
+	transport, ref := uri.Split(pullFrom)
+	if transport != "docker" {
+		return "", false
+	}
+	ref = strings.TrimPrefix(ref, "//")
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) < 2 || (!strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost") {
+		return "", false
+	}
+
+	ref = "oras://" + ref
+	ok, err := oras.IsSIF(ctx, ref, ociAuth)
+	if err != nil {
+		sylog.Debugf("Unable to check for a SIF image at %s: %v", pullFrom, err)
+		return "", false
+	}
+	return ref, ok
+}
+
 // Pull will build a SIF image to the cache or direct to a temporary file if cache is disabled
 func Pull(ctx context.Context, imgCache *cache.Handle, pullFrom, tmpDir string, ociAuth *ocitypes.DockerAuthConfig, noHTTPS, noCleanUp bool) (imagePath string, err error) {
+	if ref, ok := sifRef(ctx, pullFrom, ociAuth); ok {
+		sylog.Infof("%s is a SIF image, pulling it as is", pullFrom)
+		return oras.Pull(ctx, imgCache, ref, tmpDir, ociAuth)
+	}
+	return convert(ctx, imgCache, pullFrom, tmpDir, ociAuth, noHTTPS, noCleanUp)
+}
+
+// convert builds a SIF image from the OCI image pullFrom to the cache or
+// to a temporary file if cache is disabled.
+func convert(ctx context.Context, imgCache *cache.Handle, pullFrom, tmpDir string, ociAuth *ocitypes.DockerAuthConfig, noHTTPS, noCleanUp bool) (imagePath string, err error) {
 
 	directTo := ""
 
@@ -91,6 +132,10 @@ func Pull(ctx context.Context, imgCache *cache.Handle, pullFrom, tmpDir string,
 
 // PullToFile will build a SIF image from the specified oci URI and place it at the specified dest
 func PullToFile(ctx context.Context, imgCache *cache.Handle, pullTo, pullFrom, tmpDir string, ociAuth *ocitypes.DockerAuthConfig, noHTTPS, noCleanUp bool) (imagePath string, err error) {
+	if ref, ok := sifRef(ctx, pullFrom, ociAuth); ok {
+		sylog.Infof("%s is a SIF image, pulling it as is", pullFrom)
+		return oras.PullToFile(ctx, imgCache, pullTo, ref, tmpDir, ociAuth)
+	}
 
 	directTo := ""
 	if imgCache.IsDisabled() {
@@ -98,7 +143,7 @@ func PullToFile(ctx context.Context, imgCache *cache.Handle, pullTo, pullFrom, t
 		sylog.Debugf("Cache disabled, pulling directly to: %s", directTo)
 	}
 
-	src, err := Pull(ctx, imgCache, pullFrom, tmpDir, ociAuth, noHTTPS, noCleanUp)
+	src, err := convert(ctx, imgCache, pullFrom, tmpDir, ociAuth, noHTTPS, noCleanUp)
 	if err != nil {
 		return "", fmt.Errorf("error fetching image to cache: %v", err)
 	}
@@ -0,0 +1,34 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSifRef(t *testing.T) {
+	// none of these references are looked up as SIF images, the last
+	// one fails to resolve and falls back to the OCI conversion
+	tests := []struct {
+		name string
+		ref  string
+	}{
+		{"DockerHub", "docker://alpine:3.12"},
+		{"DockerHubNamespace", "docker://library/alpine:3.12"},
+		{"OCIArchive", "oci-archive:alpine.tar"},
+		{"DockerDaemon", "docker-daemon:alpine:3.12"},
+		{"Unreachable", "docker://127.0.0.1:1/namespace/image:tag"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if ref, ok := sifRef(context.Background(), tt.ref, nil); ok {
+				t.Errorf("unexpected SIF image reference %s", ref)
+			}
+		})
+	}
+}
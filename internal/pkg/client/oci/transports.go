@@ -7,11 +7,15 @@ package oci
 
 import (
 	"github.com/containers/image/v5/transports"
+	ocibuild "github.com/sylabs/singularity/internal/pkg/build/oci"
 )
 
 // IsSupported returns whether or not the transport given is supported. To fit within a switch/case
 // statement, this function will return transport if it is supported
 func IsSupported(transport string) string {
+	if transport == ocibuild.PodmanTransport {
+		return transport
+	}
 	for _, t := range transports.ListNames() {
 		if transport == t {
 			return transport
@@ -6,6 +6,7 @@
 package oras
 
 import (
+	"bytes"
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
@@ -15,7 +16,9 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/reference"
@@ -26,6 +29,7 @@ import (
 	"github.com/deislabs/oras/pkg/oras"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/sylabs/sif/pkg/sif"
 	"github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/sylog"
 )
@@ -39,6 +43,15 @@ const (
 
 	// SifLayerMediaType is the mediaType for the "layer" which contains the actual SIF file
 	SifLayerMediaType = "appliciation/vnd.sylabs.sif.layer.tar"
+
+	// SifIDAnnotation is the manifest annotation holding the SIF image ID.
+	SifIDAnnotation = "org.sylabs.sif.id"
+	// SifArchAnnotation is the manifest annotation holding the
+	// architecture of the SIF primary partition.
+	SifArchAnnotation = "org.sylabs.sif.arch"
+	// SifSignaturesAnnotation is the manifest annotation holding the
+	// number of signatures of the SIF image.
+	SifSignaturesAnnotation = "org.sylabs.sif.signatures"
 )
 
 // DownloadImage downloads a SIF image specified by an oci reference to a file using the included credentials
@@ -158,13 +171,47 @@ func UploadImage(path, ref string, ociAuth *ocitypes.DockerAuthConfig) error {
 
 	descriptors := []ocispec.Descriptor{desc}
 
-	if _, err := oras.Push(orasctx.Background(), resolver, spec.String(), store, descriptors, oras.WithConfig(conf)); err != nil {
+	annotations, err := sifAnnotations(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := oras.Push(orasctx.Background(), resolver, spec.String(), store, descriptors, oras.WithConfig(conf), oras.WithManifestAnnotations(annotations)); err != nil {
 		return fmt.Errorf("unable to push: %s", err)
 	}
 
 	return nil
 }
 
+// sifAnnotations returns the manifest annotations describing the SIF
+// image at path, the image itself is uploaded unmodified.
+func sifAnnotations(path string) (map[string]string, error) {
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		return nil, fmt.Errorf("while loading SIF image %s: %v", path, err)
+	}
+	defer fimg.UnloadContainer()
+
+	signatures := 0
+	for _, d := range fimg.DescrArr {
+		if d.Used && d.Datatype == sif.DataSignature {
+			signatures++
+		}
+	}
+
+	annotations := map[string]string{
+		SifIDAnnotation:           fimg.Header.ID.String(),
+		SifSignaturesAnnotation:   strconv.Itoa(signatures),
+		ocispec.AnnotationCreated: time.Unix(fimg.Header.Ctime, 0).UTC().Format(time.RFC3339),
+	}
+	if d, _, err := fimg.GetPartPrimSys(); err == nil {
+		if arch, err := d.GetArch(); err == nil {
+			annotations[SifArchAnnotation] = sif.GetGoArch(string(bytes.TrimRight(arch[:], "\x00")))
+		}
+	}
+	return annotations, nil
+}
+
 // ensureSIF checks for a SIF image at filepath and returns an error if it is not, or an error is encountered
 func ensureSIF(filepath string) error {
 	img, err := image.Init(filepath, false)
@@ -186,6 +233,42 @@ func ensureSIF(filepath string) error {
 // encountering such digests.
 // https://github.com/opencontainers/image-spec/blob/master/descriptor.md#registered-algorithms
 func ImageSHA(ctx context.Context, uri string, ociAuth *ocitypes.DockerAuthConfig) (string, error) {
+	man, err := fetchManifest(ctx, uri, ociAuth)
+	if err != nil {
+		return "", err
+	}
+
+	// search image layers for sif image and return sha
+	for _, l := range man.Layers {
+		if l.MediaType == SifLayerMediaType {
+			// only allow sha256 digests
+			if l.Digest.Algorithm() != digest.SHA256 {
+				return "", fmt.Errorf("SIF layer found with incorrect digest algorithm: %s", l.Digest.Algorithm())
+			}
+			return l.Digest.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("no layer found corresponding to SIF image")
+}
+
+// IsSIF returns true if the image manifest of the oci reference uri holds
+// a SIF layer, as uploaded by UploadImage.
+func IsSIF(ctx context.Context, uri string, ociAuth *ocitypes.DockerAuthConfig) (bool, error) {
+	man, err := fetchManifest(ctx, uri, ociAuth)
+	if err != nil {
+		return false, err
+	}
+	for _, l := range man.Layers {
+		if l.MediaType == SifLayerMediaType {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// fetchManifest returns the image manifest of the oci reference uri.
+func fetchManifest(ctx context.Context, uri string, ociAuth *ocitypes.DockerAuthConfig) (*ocispec.Manifest, error) {
 	ref := strings.TrimPrefix(uri, "oras://")
 	ref = strings.TrimPrefix(ref, "//")
 
@@ -193,51 +276,40 @@ func ImageSHA(ctx context.Context, uri string, ociAuth *ocitypes.DockerAuthConfi
 
 	_, desc, err := resolver.Resolve(ctx, ref)
 	if err != nil {
-		return "", fmt.Errorf("while resolving reference: %v", err)
+		return nil, fmt.Errorf("while resolving reference: %v", err)
 	}
 
 	// ensure that we received an image manifest descriptor
 	if desc.MediaType != ocispec.MediaTypeImageManifest {
-		return "", fmt.Errorf("could not get image manifest, received mediaType: %s", desc.MediaType)
+		return nil, fmt.Errorf("could not get image manifest, received mediaType: %s", desc.MediaType)
 	}
 
 	fetcher, err := resolver.Fetcher(ctx, ref)
 	if err != nil {
-		return "", fmt.Errorf("while creating fetcher for reference: %v", err)
+		return nil, fmt.Errorf("while creating fetcher for reference: %v", err)
 	}
 
 	rc, err := fetcher.Fetch(ctx, desc)
 	if err != nil {
-		return "", fmt.Errorf("while fetching manifest: %v", err)
+		return nil, fmt.Errorf("while fetching manifest: %v", err)
 	}
 	defer rc.Close()
 
 	b, err := ioutil.ReadAll(rc)
 	if err != nil {
-		return "", fmt.Errorf("while reading manifest: %v", err)
+		return nil, fmt.Errorf("while reading manifest: %v", err)
 	}
 
 	var man ocispec.Manifest
 	if err := json.Unmarshal(b, &man); err != nil {
-		return "", fmt.Errorf("while unmarshalling manifest: %v", err)
+		return nil, fmt.Errorf("while unmarshalling manifest: %v", err)
 	}
-
-	// search image layers for sif image and return sha
-	for _, l := range man.Layers {
-		if l.MediaType == SifLayerMediaType {
-			// only allow sha256 digests
-			if l.Digest.Algorithm() != digest.SHA256 {
-				return "", fmt.Errorf("SIF layer found with incorrect digest algorithm: %s", l.Digest.Algorithm())
-			}
-			return l.Digest.String(), nil
-		}
-	}
-
-	return "", fmt.Errorf("no layer found corresponding to SIF image")
+	return &man, nil
 }
 
 // ImageHash returns the appropriate hash for a provided image file
-//   e.g. sha256:<sha256>
+//
+//	e.g. sha256:<sha256>
 func ImageHash(filePath string) (result string, err error) {
 	file, err := os.Open(filePath)
 	if err != nil {
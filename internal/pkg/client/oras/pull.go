@@ -18,6 +18,10 @@ import (
 
 // pull will pull an oras image into the cache if directTo="", or a specific file if directTo is set.
 func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom string, ociAuth *ocitypes.DockerAuthConfig) (imagePath string, err error) {
+	span := sylog.StartSpan("pull")
+	span.SetAttribute("image", pullFrom)
+	defer span.End()
+
 	hash, err := ImageSHA(ctx, pullFrom, ociAuth)
 	if err != nil {
 		return "", fmt.Errorf("failed to get checksum for %s: %s", pullFrom, err)
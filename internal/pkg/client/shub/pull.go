@@ -130,6 +130,10 @@ func DownloadImage(ctx context.Context, manifest APIResponse, filePath, shubRef
 
 // pull will pull an oras image into the cache if directTo="", or a specific file if directTo is set.
 func pull(ctx context.Context, imgCache *cache.Handle, directTo, pullFrom string, noHTTPS bool) (imagePath string, err error) {
+	span := sylog.StartSpan("pull")
+	span.SetAttribute("image", pullFrom)
+	defer span.End()
+
 	shubURI, err := ParseReference(pullFrom)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse shub uri: %s", err)
@@ -1,4 +1,4 @@
-// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// Copyright (c) 2019-2020, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -9,7 +9,10 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -24,6 +27,9 @@ const (
 	SubUIDFile = "/etc/subuid"
 	// SubGIDFile is the default path to the subgid file.
 	SubGIDFile = "/etc/subgid"
+	// LoginDefsFile is the default path to the shadow-utils configuration
+	// holding the automatic subordinate ID ranges.
+	LoginDefsFile = "/etc/login.defs"
 	// validRangeCount is the valid fakeroot range count.
 	validRangeCount = uint32(65536)
 	// StartMax is the maximum possible range start.
@@ -50,6 +56,104 @@ type Entry struct {
 	invalid  bool
 }
 
+// Disabled returns true if the entry is disabled.
+func (e *Entry) Disabled() bool {
+	return e.disabled
+}
+
+// End returns the last ID of the entry range.
+func (e *Entry) End() uint32 {
+	return e.Start + e.Count - 1
+}
+
+// overlaps returns true if the entry range overlaps the range of count
+// IDs from start.
+func (e *Entry) overlaps(start, count uint32) bool {
+	return uint64(start) <= uint64(e.Start)+uint64(e.Count)-1 &&
+		uint64(e.Start) <= uint64(start)+uint64(count)-1
+}
+
+// contains returns true if id is in the entry range.
+func (e *Entry) contains(id uint32) bool {
+	return e.overlaps(id, 1)
+}
+
+// Conflict is a mapping entry sharing IDs with another entry: their
+// ranges overlap, or the range of the entry holds the UID of the user
+// of the other entry.
+type Conflict struct {
+	Entry *Entry
+	Other *Entry
+	// UID is true if the range of Entry holds the UID of the user
+	// of Other.
+	UID bool
+}
+
+func (c Conflict) Error() string {
+	if c.UID {
+		return fmt.Sprintf("range %d-%d of UID %d holds the UID %d of a mapped user", c.Entry.Start, c.Entry.End(), c.Entry.UID, c.Other.UID)
+	}
+	return fmt.Sprintf("range %d-%d of UID %d overlaps range %d-%d of UID %d", c.Entry.Start, c.Entry.End(), c.Entry.UID, c.Other.Start, c.Other.End(), c.Other.UID)
+}
+
+// AutoRange is the range of the subordinate IDs allocated automatically
+// by useradd, it's configured in /etc/login.defs.
+type AutoRange struct {
+	Min   uint32
+	Max   uint32
+	Count uint32
+}
+
+// GetAutoRange returns the automatic range of subordinate IDs of the
+// login.defs file at path, kind is UID or GID and selects the
+// SUB_<kind>_MIN, SUB_<kind>_MAX and SUB_<kind>_COUNT directives. The
+// shadow-utils defaults apply to missing directives or a missing file.
+func GetAutoRange(path, kind string) (*AutoRange, error) {
+	r := &AutoRange{Min: 100000, Max: 600100000, Count: validRangeCount}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	values := map[string]*uint32{
+		"SUB_" + kind + "_MIN":   &r.Min,
+		"SUB_" + kind + "_MAX":   &r.Max,
+		"SUB_" + kind + "_COUNT": &r.Count,
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || values[fields[0]] == nil {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value in %s: %s", fields[0], path, fields[1])
+		}
+		*values[fields[0]] = uint32(v)
+	}
+
+	if r.Count < validRangeCount {
+		return nil, fmt.Errorf("SUB_%s_COUNT of %s is lower than the %d IDs required by fakeroot", kind, path, validRangeCount)
+	}
+	if r.Min > r.Max || r.Max-r.Min+1 < r.Count {
+		return nil, fmt.Errorf("SUB_%s_MIN and SUB_%s_MAX of %s don't hold a range of %d IDs", kind, kind, path, r.Count)
+	}
+	return r, nil
+}
+
+// CheckUserNamespace returns an error if the kernel doesn't allow to
+// create the user namespaces required by fakeroot.
+func CheckUserNamespace() error {
+	if _, err := os.Stat("/proc/self/ns/user"); os.IsNotExist(err) {
+		return fmt.Errorf("the kernel doesn't support user namespaces (CONFIG_USER_NS)")
+	}
+	data, err := ioutil.ReadFile("/proc/sys/user/max_user_namespaces")
+	if err == nil && strings.TrimSpace(string(data)) == "0" {
+		return fmt.Errorf("user namespaces are disabled, enable them with: sysctl -w user.max_user_namespaces=15000")
+	}
+	return nil
+}
+
 // Config holds all entries found in the corresponding configuration
 // file and manages its configuration.
 type Config struct {
@@ -180,15 +284,39 @@ func (c *Config) Close() error {
 	}
 	defer lock.Release(fd)
 
-	if err := c.file.Truncate(0); err != nil {
-		return fmt.Errorf("error while truncating %s to 0: %s", filename, err)
+	// keep a backup of the previous configuration like shadow-utils
+	// tools do, the new one replaces it atomically
+	fi, err := c.file.Stat()
+	if err != nil {
+		return fmt.Errorf("error while getting %s information: %s", filename, err)
+	}
+	previous, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("error while reading %s: %s", filename, err)
+	}
+	if err := ioutil.WriteFile(filename+"-", previous, fi.Mode().Perm()); err != nil {
+		return fmt.Errorf("error while writing backup of %s: %s", filename, err)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+"-")
+	if err != nil {
+		return fmt.Errorf("error while creating temporary configuration file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("error while writing configuration file %s: %s", filename, err)
 	}
-	if _, err := c.file.Seek(0, os.SEEK_SET); err != nil {
-		return fmt.Errorf("error while resetting file offset: %s", err)
+	if err := tmp.Chmod(fi.Mode().Perm()); err != nil {
+		return fmt.Errorf("error while setting %s permissions: %s", filename, err)
 	}
-	if _, err := c.file.Write(buf.Bytes()); err != nil {
+	if err := tmp.Sync(); err != nil {
 		return fmt.Errorf("error while writing configuration file %s: %s", filename, err)
 	}
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return fmt.Errorf("error while replacing configuration file %s: %s", filename, err)
+	}
 
 	return nil
 }
@@ -197,6 +325,47 @@ func (c *Config) Close() error {
 // find the first available range. It doesn't return any error
 // if the user is already present and ignores the operation.
 func (c *Config) AddUser(username string) error {
+	return c.addUser(username, validRangeCount, func(available func(uint32) bool) (uint32, bool) {
+		for i := startMax; i >= startMin; i -= validRangeCount {
+			if available(i) {
+				return i, true
+			}
+		}
+		return 0, false
+	})
+}
+
+// AddUserInRange adds a user mapping entry with the first available
+// range of r.Count IDs between r.Min and r.Max, like useradd does. It
+// doesn't return any error if the user is already present and ignores
+// the operation.
+func (c *Config) AddUserInRange(username string, r AutoRange) error {
+	return c.addUser(username, r.Count, func(available func(uint32) bool) (uint32, bool) {
+		// the first available range starts at the minimum or
+		// right after an existing range
+		starts := []uint64{uint64(r.Min)}
+		for _, entry := range c.entries {
+			if !entry.invalid {
+				starts = append(starts, uint64(entry.Start)+uint64(entry.Count))
+			}
+		}
+		sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+		for _, start := range starts {
+			if start < uint64(r.Min) || start+uint64(r.Count)-1 > uint64(r.Max) {
+				continue
+			}
+			if available(uint32(start)) {
+				return uint32(start), true
+			}
+		}
+		return 0, false
+	})
+}
+
+// addUser adds a user mapping entry of count IDs starting at the ID
+// returned by next, which is called with a function reporting if a
+// range start is available.
+func (c *Config) addUser(username string, count uint32, next func(func(uint32) bool) (uint32, bool)) error {
 	_, err := c.GetUserEntry(username)
 	if err == nil {
 		return nil
@@ -206,36 +375,44 @@ func (c *Config) AddUser(username string) error {
 	if err != nil {
 		return fmt.Errorf("could not retrieve user information for %s: %s", username, err)
 	}
-	for i := startMax; i >= startMin; i -= validRangeCount {
-		current := i
-		available := true
+	for _, entry := range c.entries {
+		if !entry.invalid && entry.contains(u.UID) {
+			return fmt.Errorf("UID %d of %s is in the range %d-%d of UID %d, the user files would be owned by fakeroot processes of UID %d", u.UID, username, entry.Start, entry.End(), entry.UID, entry.UID)
+		}
+	}
+
+	available := func(start uint32) bool {
+		candidate := &Entry{Start: start, Count: count}
+		if candidate.contains(u.UID) {
+			return false
+		}
 		for _, entry := range c.entries {
 			if entry.invalid {
 				continue
 			}
-			start := entry.Start
-			end := entry.Start + entry.Count - 1
-			if current >= start && current <= end {
-				available = false
-				break
+			if entry.overlaps(start, count) || candidate.contains(entry.UID) {
+				return false
 			}
 		}
-		if available {
-			c.requireUpdate = true
-			line := fmt.Sprintf("%d:%d:%d", u.UID, current, validRangeCount)
-			c.entries = append(
-				c.entries,
-				&Entry{
-					UID:      u.UID,
-					Start:    current,
-					Count:    validRangeCount,
-					disabled: false,
-					line:     line,
-				})
-			return nil
-		}
-	}
-	return fmt.Errorf("no range available")
+		return true
+	}
+
+	current, ok := next(available)
+	if !ok {
+		return fmt.Errorf("no range available")
+	}
+	c.requireUpdate = true
+	line := fmt.Sprintf("%d:%d:%d", u.UID, current, count)
+	c.entries = append(
+		c.entries,
+		&Entry{
+			UID:      u.UID,
+			Start:    current,
+			Count:    count,
+			disabled: false,
+			line:     line,
+		})
+	return nil
 }
 
 // RemoveUser removes a user mapping entry. It returns an error
@@ -335,6 +512,35 @@ func (c *Config) GetUserEntry(username string) (*Entry, error) {
 	return nil, fmt.Errorf("no mapping entry found in %s for %s", c.file.Name(), username)
 }
 
+// Entries returns the valid mapping entries in the order of the
+// configuration file.
+func (c *Config) Entries() []*Entry {
+	var entries []*Entry
+	for _, entry := range c.entries {
+		if !entry.invalid {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Conflicts returns the mapping entries sharing IDs with other entries.
+func (c *Config) Conflicts() []Conflict {
+	var conflicts []Conflict
+	entries := c.Entries()
+	for i, entry := range entries {
+		for j, other := range entries {
+			if i < j && entry.overlaps(other.Start, other.Count) {
+				conflicts = append(conflicts, Conflict{Entry: entry, Other: other})
+			}
+			if entry.UID != other.UID && other.UID != maxUID && entry.contains(other.UID) {
+				conflicts = append(conflicts, Conflict{Entry: entry, Other: other, UID: true})
+			}
+		}
+	}
+	return conflicts
+}
+
 // getPwUID is also used for mocking purpose
 var getPwUID = user.GetPwUID
 var getPwNam = user.GetPwNam
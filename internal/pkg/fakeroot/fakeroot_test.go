@@ -1,4 +1,4 @@
-// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// Copyright (c) 2019-2020, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -502,6 +502,7 @@ func TestConfig(t *testing.T) {
 
 	file := createConfig(t)
 	defer os.Remove(file)
+	defer os.Remove(file + "-")
 
 	// test with empty path
 	_, err := GetConfig("", true, nil)
@@ -517,3 +518,84 @@ func TestConfig(t *testing.T) {
 	testGetUserEntry(t, config)
 	testEditEntry(t, config)
 }
+
+func TestGetAutoRange(t *testing.T) {
+	f, err := fs.MakeTmpFile("", "login.defs-", 0644)
+	if err != nil {
+		t.Fatalf("failed to create temporary login.defs: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# subordinate IDs\nSUB_UID_MIN 2000000000\nSUB_UID_MAX 2100000000\nSUB_UID_COUNT 131072\nSUB_GID_COUNT 1000\n")
+	f.Close()
+
+	r, err := GetAutoRange(f.Name(), "UID")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := AutoRange{Min: 2000000000, Max: 2100000000, Count: 131072}
+	if *r != expected {
+		t.Errorf("unexpected range %+v instead of %+v", *r, expected)
+	}
+	// the count is too low
+	if _, err := GetAutoRange(f.Name(), "GID"); err == nil {
+		t.Errorf("unexpected success with a GID count of 1000")
+	}
+	// defaults
+	r, err = GetAutoRange(f.Name()+"-missing", "UID")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = AutoRange{Min: 100000, Max: 600100000, Count: validRangeCount}
+	if *r != expected {
+		t.Errorf("unexpected default range %+v instead of %+v", *r, expected)
+	}
+}
+
+func TestAddUserInRange(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	f, err := fs.MakeTmpFile("", "subid-", 0644)
+	if err != nil {
+		t.Fatalf("failed to create temporary config: %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer os.Remove(f.Name() + "-")
+	// valid_150000 is a subordinate ID of valid_10, valid_11 and
+	// valid_12 overlap
+	f.WriteString("valid_10:100000:65536\nvalid_11:240000:65536\nvalid_12:270000:65536\n")
+	f.Close()
+
+	config, err := GetConfig(f.Name(), true, getUserFn)
+	if err != nil {
+		t.Fatalf("unexpected error while getting config %s: %s", f.Name(), err)
+	}
+	defer config.Close()
+
+	conflicts := config.Conflicts()
+	if len(conflicts) != 1 || conflicts[0].Entry.UID != 11 || conflicts[0].Other.UID != 12 {
+		t.Errorf("unexpected conflicts %v", conflicts)
+	}
+
+	r := AutoRange{Min: 100000, Max: 600100000, Count: validRangeCount}
+	if err := config.AddUserInRange("valid_13", r); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	e, err := config.GetUserEntry("valid_13")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// the first available range is between valid_10 and valid_11
+	if e.Start != 165536 {
+		t.Errorf("unexpected range start %d instead of 165536", e.Start)
+	}
+	if err := config.AddUserInRange("valid_20", AutoRange{Min: 100000, Max: 300000, Count: validRangeCount}); err == nil {
+		t.Errorf("unexpected success without available range")
+	}
+	if err := config.AddUserInRange("valid_150000", r); err == nil {
+		t.Errorf("unexpected success for a user with a subordinate UID")
+	}
+	if err := config.AddUser("valid_150000"); err == nil {
+		t.Errorf("unexpected success for a user with a subordinate UID")
+	}
+}
@@ -26,6 +26,16 @@ const (
 	SingSubDir = "sing"
 	// LogSubDir represents directory where Singularity instance log files are stored
 	LogSubDir = "logs"
+	// CheckpointSubDir represents directory where Singularity instance checkpoints are stored
+	CheckpointSubDir = "checkpoints"
+)
+
+const (
+	// LogMaxSize is the size in bytes above which an instance log file
+	// is rotated when the instance starts
+	LogMaxSize = 10 * 1024 * 1024
+	// LogMaxBackups is the number of rotated instance log files to keep
+	LogMaxBackups = 3
 )
 
 const (
@@ -288,6 +298,12 @@ func SetLogFile(name string, uid int, subDir string) (*os.File, *os.File, error)
 		return nil, nil, err
 	}
 
+	for _, p := range []string{stderrPath, stdoutPath} {
+		if err := rotateLogFile(p, LogMaxSize, LogMaxBackups); err != nil {
+			return nil, nil, fmt.Errorf("while rotating log file %s: %s", p, err)
+		}
+	}
+
 	stderr, err := os.OpenFile(stderrPath, os.O_RDWR|os.O_CREATE|os.O_APPEND|syscall.O_NOFOLLOW, 0644)
 	if err != nil {
 		return nil, nil, err
@@ -309,3 +325,31 @@ func SetLogFile(name string, uid int, subDir string) (*os.File, *os.File, error)
 
 	return stdout, stderr, nil
 }
+
+// rotateLogFile renames the log file at path to path.1, shifting
+// existing backups up to maxBackups, if its size exceeds maxSize.
+func rotateLogFile(path string, maxSize int64, maxBackups int) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if fi.Size() <= maxSize {
+		return nil
+	}
+	if maxBackups < 1 {
+		return os.Remove(path)
+	}
+
+	for i := maxBackups - 1; i > 0; i-- {
+		src := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Lstat(src); err != nil {
+			continue
+		}
+		if err := os.Rename(src, fmt.Sprintf("%s.%d", path, i+1)); err != nil {
+			return err
+		}
+	}
+	return os.Rename(path, path+".1")
+}
@@ -6,6 +6,7 @@
 package instance
 
 import (
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -251,6 +252,54 @@ func TestGet(t *testing.T) {
 	}
 }
 
+func TestRotateLogFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "instance-log-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "test.err")
+
+	if err := rotateLogFile(path, 4, 2); err != nil {
+		t.Errorf("unexpected error with non existent log file: %s", err)
+	}
+
+	for _, content := range []string{"first", "second", "third"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("could not write log file: %s", err)
+		}
+		if err := rotateLogFile(path, 4, 2); err != nil {
+			t.Fatalf("unexpected error while rotating log file: %s", err)
+		}
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("log file %s was not rotated", path)
+	}
+	for suffix, expected := range map[string]string{".1": "third", ".2": "second"} {
+		b, err := ioutil.ReadFile(path + suffix)
+		if err != nil {
+			t.Errorf("could not read rotated log file: %s", err)
+		} else if string(b) != expected {
+			t.Errorf("unexpected content for %s%s: got %q instead of %q", path, suffix, b, expected)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("unexpected backup %s.3", path)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("1234"), 0644); err != nil {
+		t.Fatalf("could not write log file: %s", err)
+	}
+	if err := rotateLogFile(path, 4, 2); err != nil {
+		t.Fatalf("unexpected error while rotating log file: %s", err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("log file below maximum size was rotated")
+	}
+}
+
 func TestMain(m *testing.M) {
 	// spawn a fake instance process
 	cmd := exec.Command("cat")
@@ -37,9 +37,20 @@ type Config struct {
 
 // EndPoint descriptes a single remote service
 type EndPoint struct {
-	URI    string `yaml:"URI,omitempty"`
-	Token  string `yaml:"Token,omitempty"`
-	System bool   `yaml:"System"` // Was this EndPoint set from system config file
+	URI     string   `yaml:"URI,omitempty"`
+	Token   string   `yaml:"Token,omitempty"`
+	System  bool     `yaml:"System"` // Was this EndPoint set from system config file
+	Builder *Builder `yaml:"Builder,omitempty"`
+}
+
+// Builder selects the remote build service of an endpoint when it isn't
+// the build service of the endpoint.
+type Builder struct {
+	// Type is the remote build backend type, eg: scs, http.
+	Type string `yaml:"Type,omitempty"`
+	// URI is the build service URI, the endpoint build service URI is
+	// used if empty.
+	URI string `yaml:"URI,omitempty"`
 }
 
 // ReadFrom reads remote configuration from io.Reader
@@ -93,12 +104,14 @@ func (c *Config) SyncFrom(sys *Config) error {
 			return fmt.Errorf("name collision while syncing: %s", name)
 		} else if err == nil {
 			eUsr.URI = eSys.URI // update URI just in case
+			eUsr.Builder = eSys.Builder
 			continue
 		}
 
 		e := &EndPoint{
-			URI:    eSys.URI,
-			System: true,
+			URI:     eSys.URI,
+			System:  true,
+			Builder: eSys.Builder,
 		}
 
 		if err := c.Add(name, e); err != nil {
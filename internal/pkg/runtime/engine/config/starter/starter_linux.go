@@ -157,6 +157,17 @@ func (c *Config) KeepFileDescriptor(fd int) error {
 	return nil
 }
 
+// SetStageOneFailure sets the flag to tell starter that stage 1 failed
+// after writing back the engine configuration, starter then lets the
+// engine handle the failure with the privileges of the setuid workflow.
+func (c *Config) SetStageOneFailure(failure bool) {
+	if failure {
+		c.config.starter.stage1Failure = C.true
+	} else {
+		c.config.starter.stage1Failure = C.false
+	}
+}
+
 // SetHybridWorkflow sets the flag to tell starter container setup
 // will require an hybrid workflow. Typically used for fakeroot.
 // In hybrid workflow master process lives in host user namespace
@@ -1,4 +1,4 @@
-// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// Copyright (c) 2019-2020, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -90,6 +90,18 @@ type Operations interface {
 	CleanupContainer(context.Context, error, syscall.WaitStatus) error
 }
 
+// FailureOperations is an optional interface implemented by operations
+// handling a PrepareConfig failure.
+type FailureOperations interface {
+	// PrepareConfigFailure is called from starter once stage1 exited
+	// after a PrepareConfig failure, the engine configuration is the
+	// one written back by stage1.
+	//
+	// Additional privileges may be gained when running in suid flow,
+	// e.g. to record the failure.
+	PrepareConfigFailure() error
+}
+
 // getName returns the engine name set in JSON []byte configuration.
 func getName(b []byte) string {
 	engineName := struct {
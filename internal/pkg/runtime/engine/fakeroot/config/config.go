@@ -5,14 +5,20 @@
 
 package fakeroot
 
+import specs "github.com/opencontainers/runtime-spec/specs-go"
+
 // Name of the engine
 const Name = "fakeroot"
 
 // EngineConfig is the config for the fakeroot engine used to execute
 // a command in a fakeroot context
 type EngineConfig struct {
-	Args     []string `json:"args"`
-	Envs     []string `json:"envs"`
-	Home     string   `json:"home"`
-	BuildEnv bool     `json:"buildEnv"`
+	Args         []string `json:"args"`
+	Envs         []string `json:"envs"`
+	AuditRecords []string `json:"auditRecords,omitempty"`
+	Home         string   `json:"home"`
+	AuditLog     string   `json:"auditLog,omitempty"`
+	BuildEnv     bool     `json:"buildEnv"`
+	// Resources are the cgroups resource limits of the build.
+	Resources *specs.LinuxResources `json:"resources,omitempty"`
 }
@@ -10,10 +10,14 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"strconv"
 	"syscall"
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/audit"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/cgroups"
 	fakerootutil "github.com/sylabs/singularity/internal/pkg/fakeroot"
 	"github.com/sylabs/singularity/internal/pkg/plugin"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engine"
@@ -22,6 +26,7 @@ import (
 	fakerootConfig "github.com/sylabs/singularity/internal/pkg/runtime/engine/fakeroot/config"
 	"github.com/sylabs/singularity/internal/pkg/security/seccomp"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/internal/pkg/util/priv"
 	fakerootcallback "github.com/sylabs/singularity/pkg/plugin/callback/runtime/fakeroot"
 	"github.com/sylabs/singularity/pkg/runtime/engine/config"
 	"github.com/sylabs/singularity/pkg/sylog"
@@ -30,6 +35,9 @@ import (
 	"github.com/sylabs/singularity/pkg/util/singularityconf"
 )
 
+// cgroupManager confines the fakeroot process to the build resource limits.
+var cgroupManager *cgroups.Manager
+
 // EngineOperations is a Singularity fakeroot runtime engine that implements engine.Operations.
 type EngineOperations struct {
 	CommonConfig *config.Common               `json:"-"`
@@ -67,6 +75,15 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 
 	configurationFile := buildcfg.SINGULARITY_CONF_FILE
 
+	// records are always overwritten to not let users forge
+	// them through the engine configuration, and written back
+	// on failure too to record denied requests
+	auditLog := ""
+	defer func() {
+		e.EngineConfig.AuditLog = auditLog
+		e.EngineConfig.AuditRecords = audit.Pending()
+	}()
+
 	// check for ownership of singularity.conf
 	if starterConfig.GetIsSUID() && !fs.IsOwner(configurationFile, 0) {
 		return fmt.Errorf("%s must be owned by root", configurationFile)
@@ -77,11 +94,21 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 		return fmt.Errorf("unable to parse singularity.conf file: %s", err)
 	}
 
+	if err := audit.SetDestination(fileConfig.AuditLog); err != nil {
+		return fmt.Errorf("while setting audit log: %s", err)
+	}
+	auditLog = fileConfig.AuditLog
+
 	if starterConfig.GetIsSUID() {
 		if !fileConfig.AllowSetuid {
+			audit.Record(audit.Starter, audit.Denied, "engine", e.CommonConfig.EngineName)
 			return fmt.Errorf("fakeroot requires to set 'allow setuid = yes' in %s", configurationFile)
 		}
+		audit.Record(audit.Starter, audit.Allowed, "engine", e.CommonConfig.EngineName)
 	} else {
+		if e.EngineConfig.Resources != nil {
+			return fmt.Errorf("build resource limits with fakeroot require a setuid installation")
+		}
 		sylog.Verbosef("Fakeroot requested with unprivileged workflow, fallback to newuidmap/newgidmap")
 		sylog.Debugf("Search for newuidmap binary")
 		if err := starterConfig.SetNewUIDMapPath(); err != nil {
@@ -121,6 +148,8 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 	g.AddLinuxUIDMapping(idRange.HostID, idRange.ContainerID, idRange.Size)
 	starterConfig.AddUIDMappings(g.Config.Linux.UIDMappings)
 
+	audit.Record(audit.Fakeroot, audit.Allowed, "engine", e.CommonConfig.EngineName, "subuid", fmt.Sprint(idRange.HostID))
+
 	g.AddLinuxGIDMapping(gid, 0, 1)
 	idRange, err = getIDRange(fakerootutil.SubGIDFile, uid)
 	if err != nil {
@@ -150,8 +179,42 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 	return nil
 }
 
-// CreateContainer does nothing for the fakeroot engine.
-func (e *EngineOperations) CreateContainer(context.Context, int, net.Conn) error {
+// PrepareConfigFailure is called from starter when PrepareConfig
+// failed to write the audit records of stage 1.
+//
+// Additional privileges may be gained when running in suid flow.
+func (e *EngineOperations) PrepareConfigFailure() error {
+	if err := audit.SetDestination(e.EngineConfig.AuditLog); err != nil {
+		return fmt.Errorf("while setting audit log: %s", err)
+	}
+	audit.Write(e.EngineConfig.AuditRecords)
+	return nil
+}
+
+// CreateContainer writes the audit records of stage 1 and applies
+// the build resource limits for the fakeroot engine.
+//
+// Additional privileges are gained to create the cgroup of the
+// container process with the setuid workflow.
+func (e *EngineOperations) CreateContainer(ctx context.Context, pid int, rpcConn net.Conn) error {
+	if err := audit.SetDestination(e.EngineConfig.AuditLog); err != nil {
+		return fmt.Errorf("while setting audit log: %s", err)
+	}
+	audit.Write(e.EngineConfig.AuditRecords)
+
+	if e.EngineConfig.Resources != nil {
+		if err := priv.Escalate(); err != nil {
+			return fmt.Errorf("while escalating privileges: %s", err)
+		}
+		defer priv.Drop()
+
+		cgroupPath := filepath.Join("/singularity", strconv.Itoa(pid))
+		cgroupManager = &cgroups.Manager{Pid: pid, Path: cgroupPath}
+		if err := cgroupManager.ApplyFromSpec(e.EngineConfig.Resources); err != nil {
+			cgroupManager = nil
+			return fmt.Errorf("failed to apply cgroups resources restriction: %s", err)
+		}
+	}
 	return nil
 }
 
@@ -274,8 +337,17 @@ func (e *EngineOperations) MonitorContainer(pid int, signals chan os.Signal) (sy
 	}
 }
 
-// CleanupContainer does nothing for the fakeroot engine.
+// CleanupContainer removes the cgroup of the build resource limits
+// for the fakeroot engine.
 func (e *EngineOperations) CleanupContainer(context.Context, error, syscall.WaitStatus) error {
+	if cgroupManager != nil {
+		priv.Escalate()
+		defer priv.Drop()
+
+		if err := cgroupManager.Remove(); err != nil {
+			sylog.Errorf("could not remove cgroups: %v", err)
+		}
+	}
 	return nil
 }
 
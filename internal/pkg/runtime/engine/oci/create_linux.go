@@ -511,6 +511,23 @@ func (c *container) addCgroups(pid int, system *mount.System) error {
 			c.engine.EngineConfig.OciConfig.Config.Mounts[c.cgroupIndex+1:]...,
 		)
 
+		// with cgroups v2 the container cgroup is bound as the root of
+		// the unified hierarchy
+		if unified := manager.GetUnifiedPath(); unified != "" {
+			flags, _ := mount.ConvertOptions(m.Options)
+			flags |= uintptr(syscall.MS_BIND)
+			if err := system.Points.AddBind(mount.OtherTag, unified, m.Destination, flags); err != nil {
+				return err
+			}
+			if flags&syscall.MS_RDONLY != 0 {
+				if err := system.Points.AddRemount(mount.OtherTag, m.Destination, flags); err != nil {
+					return err
+				}
+			}
+			c.engine.EngineConfig.Cgroups = manager
+			return nil
+		}
+
 		cgroupRootPath := manager.GetCgroupRootPath()
 		if cgroupRootPath == "" {
 			return fmt.Errorf("failed to determine cgroup root path")
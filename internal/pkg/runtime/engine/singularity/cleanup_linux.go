@@ -16,12 +16,14 @@ import (
 
 	"github.com/sylabs/singularity/internal/pkg/instance"
 	fakerootConfig "github.com/sylabs/singularity/internal/pkg/runtime/engine/fakeroot/config"
+	"github.com/sylabs/singularity/internal/pkg/util/fs/squashfs"
 	"github.com/sylabs/singularity/internal/pkg/util/priv"
 	"github.com/sylabs/singularity/internal/pkg/util/starter"
+	"github.com/sylabs/singularity/pkg/image/packer"
 	"github.com/sylabs/singularity/pkg/runtime/engine/config"
-	"github.com/sylabs/singularity/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/util/capabilities"
 	"github.com/sylabs/singularity/pkg/util/crypt"
+	"github.com/sylabs/singularity/pkg/util/verity"
 )
 
 // CleanupContainer is called from master after the MonitorContainer returns.
@@ -41,19 +43,25 @@ func (e *EngineOperations) CleanupContainer(ctx context.Context, fatal error, st
 	// fakeroot workflow
 	e.stopFuseDrivers()
 
+	if e.EngineConfig.GetCommitDir() != "" {
+		if err := e.commitWritableTmpfs(); err != nil {
+			runtimeLog.Errorf("could not save writable tmpfs: %s", err)
+		}
+	}
+
 	if imageDriver != nil {
 		if err := umount(); err != nil {
-			sylog.Errorf("%s", err)
+			runtimeLog.Errorf("%s", err)
 		}
 		if err := imageDriver.Stop(); err != nil {
-			sylog.Errorf("could not stop driver: %s", err)
+			runtimeLog.Errorf("could not stop driver: %s", err)
 		}
 	}
 
 	if e.EngineConfig.GetDeleteImage() {
 		image := e.EngineConfig.GetImage()
-		sylog.Verbosef("Removing image %s", image)
-		sylog.Infof("Cleaning up image...")
+		runtimeLog.Verbosef("Removing image %s", image)
+		runtimeLog.Infof("Cleaning up image...")
 
 		var err error
 
@@ -68,7 +76,7 @@ func (e *EngineOperations) CleanupContainer(ctx context.Context, fatal error, st
 			err = os.RemoveAll(image)
 		}
 		if err != nil {
-			sylog.Errorf("failed to delete container image %s: %s", image, err)
+			runtimeLog.Errorf("failed to delete container image %s: %s", image, err)
 		}
 	}
 
@@ -77,7 +85,7 @@ func (e *EngineOperations) CleanupContainer(ctx context.Context, fatal error, st
 			priv.Escalate()
 		}
 		if err := networkSetup.DelNetworks(ctx); err != nil {
-			sylog.Errorf("could not delete networks: %v", err)
+			networkLog.Errorf("could not delete networks: %v", err)
 		}
 		if e.EngineConfig.GetFakeroot() {
 			priv.Drop()
@@ -86,13 +94,21 @@ func (e *EngineOperations) CleanupContainer(ctx context.Context, fatal error, st
 
 	if cgroupManager != nil {
 		if err := cgroupManager.Remove(); err != nil {
-			sylog.Errorf("could not remove cgroups: %v", err)
+			runtimeLog.Errorf("could not remove cgroups: %v", err)
 		}
 	}
 
-	if cryptDev != "" && imageDriver == nil {
-		if err := cleanupCrypt(cryptDev); err != nil {
-			sylog.Errorf("could not cleanup crypt: %v", err)
+	if imageDriver == nil {
+		for _, cryptDev := range cryptDevs {
+			if err := cleanupCrypt(cryptDev); err != nil {
+				runtimeLog.Errorf("could not cleanup crypt: %v", err)
+			}
+		}
+	}
+
+	if verityDev != "" && imageDriver == nil {
+		if err := cleanupVerity(verityDev); err != nil {
+			runtimeLog.Errorf("could not cleanup verity: %v", err)
 		}
 	}
 
@@ -107,6 +123,47 @@ func (e *EngineOperations) CleanupContainer(ctx context.Context, fatal error, st
 	return nil
 }
 
+// commitWritableTmpfs saves the upper layer of the writable tmpfs to the
+// squashfs overlay image requested with --commit and removes the temporary
+// directory holding the layers.
+func (e *EngineOperations) commitWritableTmpfs() error {
+	dir := e.EngineConfig.GetCommitDir()
+	image := e.EngineConfig.GetCommitImage()
+
+	defer func() {
+		var err error
+
+		if e.EngineConfig.GetFakeroot() && os.Getuid() != 0 {
+			// the files created by the other users mapped in
+			// the fakeroot user namespace are removed via the
+			// fakeroot engine as for the image removal
+			err = fakerootCleanup(dir)
+		} else {
+			// the directory is given by the user, it's never
+			// removed with escalated privileges
+			err = os.RemoveAll(dir)
+		}
+		if err != nil {
+			runtimeLog.Errorf("failed to delete writable tmpfs directory %s: %s", dir, err)
+		}
+	}()
+
+	mksquashfs, err := squashfs.GetPath()
+	if err != nil {
+		return fmt.Errorf("mksquashfs is required to save the writable tmpfs: %s", err)
+	}
+	p := packer.Squashfs{MksquashfsPath: mksquashfs}
+
+	flags := []string{"-noappend"}
+	if e.EngineConfig.GetFakeroot() && os.Getuid() != 0 {
+		// the files of the container root user are owned by the user
+		flags = append(flags, "-all-root")
+	}
+
+	runtimeLog.Infof("Saving writable tmpfs to %s", image)
+	return p.Create([]string{filepath.Join(dir, "upper")}, image, flags)
+}
+
 func umount() (err error) {
 	var oldEffective uint64
 
@@ -129,7 +186,7 @@ func umount() (err error) {
 
 	for i := len(umountPoints) - 1; i >= 0; i-- {
 		p := umountPoints[i]
-		sylog.Debugf("Umount %s", p)
+		runtimeLog.Debugf("Umount %s", p)
 		retries := 0
 	retry:
 		err = syscall.Unmount(p, 0)
@@ -165,10 +222,24 @@ func cleanupCrypt(path string) error {
 	return nil
 }
 
+func cleanupVerity(path string) error {
+	if err := umount(); err != nil {
+		return err
+	}
+
+	devName := filepath.Base(path)
+
+	if err := verity.Close(devName); err != nil {
+		return fmt.Errorf("unable to delete verity device: %s", devName)
+	}
+
+	return nil
+}
+
 func fakerootCleanup(path string) error {
 	command := []string{"/bin/rm", "-rf", path}
 
-	sylog.Debugf("Calling fakeroot engine to execute %q", strings.Join(command, " "))
+	runtimeLog.Debugf("Calling fakeroot engine to execute %q", strings.Join(command, " "))
 
 	cfg := &config.Common{
 		EngineName:   fakerootConfig.Name,
@@ -7,6 +7,7 @@ package singularity
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -14,12 +15,15 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/audit"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	"github.com/sylabs/singularity/internal/pkg/cgroups"
 	"github.com/sylabs/singularity/internal/pkg/plugin"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engine/singularity/rpc/client"
+	"github.com/sylabs/singularity/internal/pkg/util/bin"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
 	"github.com/sylabs/singularity/internal/pkg/util/fs/files"
 	"github.com/sylabs/singularity/internal/pkg/util/fs/layout"
@@ -40,6 +44,7 @@ import (
 	"github.com/sylabs/singularity/pkg/util/loop"
 	"github.com/sylabs/singularity/pkg/util/namespaces"
 	"github.com/sylabs/singularity/pkg/util/singularityconf"
+	"github.com/sylabs/singularity/pkg/util/verity"
 	"golang.org/x/crypto/ssh/terminal"
 	"golang.org/x/sys/unix"
 )
@@ -48,7 +53,8 @@ import (
 // - setup
 // - cleanup
 // - post start process
-var cryptDev string
+var cryptDevs []string
+var verityDev string
 var networkSetup *network.Setup
 var cgroupManager *cgroups.Manager
 var imageDriver image.Driver
@@ -75,12 +81,14 @@ type container struct {
 	pidNS         bool
 	utsNS         bool
 	netNS         bool
+	netNSJoined   bool
 	ipcNS         bool
 	mountInfoPath string
 	lastMount     lastMount
 	skippedMount  []string
 	suidFlag      uintptr
 	devSourcePath string
+	sessionHosts  bool
 }
 
 func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, pid int) error {
@@ -100,7 +108,7 @@ func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, p
 
 	engine.EngineConfig.File, err = singularityconf.Parse(configurationFile)
 	if err != nil {
-		return fmt.Errorf("unable to parse singularity.conf file: %s", err)
+		return sylog.WithCode(sylog.ConfigInvalid, fmt.Errorf("unable to parse singularity.conf file: %s", err))
 	}
 
 	c := &container{
@@ -128,6 +136,9 @@ func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, p
 				c.utsNS = true
 			case specs.NetworkNamespace:
 				c.netNS = true
+				// the network namespace of an instance or a
+				// process is joined with its networks
+				c.netNSJoined = namespace.Path != ""
 			case specs.IPCNamespace:
 				c.ipcNS = true
 			}
@@ -238,6 +249,9 @@ func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, p
 	if err := c.addResolvConfMount(system); err != nil {
 		return err
 	}
+	if err := c.addHostsMount(system); err != nil {
+		return err
+	}
 	if err := c.addHostnameMount(system); err != nil {
 		return err
 	}
@@ -251,17 +265,23 @@ func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, p
 		return err
 	}
 
-	sylog.Debugf("Mount all")
+	runtimeLog.Debugf("Mount all")
 	if err := system.MountAll(); err != nil {
 		return err
 	}
 
+	if engine.EngineConfig.GetNvCCLI() {
+		if err := c.setupNvCCLI(pid); err != nil {
+			return err
+		}
+	}
+
 	// chroot from RPC server current working directory since
 	// it's already in final directory after chdirFinal call
-	sylog.Debugf("Chroot into %s\n", c.session.FinalPath())
+	runtimeLog.Debugf("Chroot into %s\n", c.session.FinalPath())
 	_, err = c.rpcOps.Chroot(".", "pivot")
 	if err != nil {
-		sylog.Debugf("Fallback to move/chroot")
+		runtimeLog.Debugf("Fallback to move/chroot")
 		_, err = c.rpcOps.Chroot(".", "move")
 		if err != nil {
 			return fmt.Errorf("chroot failed: %s", err)
@@ -274,24 +294,50 @@ func create(ctx context.Context, engine *EngineOperations, rpcOps *client.RPC, p
 		}
 	}
 
-	if os.Geteuid() == 0 && !c.userNS {
-		path := engine.EngineConfig.GetCgroupsPath()
-		if path != "" {
+	path := engine.EngineConfig.GetCgroupsPath()
+	resources := engine.EngineConfig.GetCgroupsResources()
+	if path != "" || resources != nil {
+		if os.Geteuid() == 0 && !c.userNS {
 			cgroupPath := filepath.Join("/singularity", strconv.Itoa(pid))
 			cgroupManager = &cgroups.Manager{Pid: pid, Path: cgroupPath}
-			if err := cgroupManager.ApplyFromFile(path); err != nil {
+		} else if os.Geteuid() != 0 {
+			// the cgroup of an unprivileged user is delegated by the
+			// systemd user instance with cgroups v2
+			cgroupManager = &cgroups.Manager{Pid: pid, Rootless: true}
+		}
+		if cgroupManager != nil {
+			spec := specs.LinuxResources{}
+			if path != "" {
+				conf, err := cgroups.LoadConfig(path)
+				if err == nil {
+					spec, err = conf.Spec()
+				}
+				if err != nil {
+					return fmt.Errorf("failed to read cgroups profile %s: %s", path, err)
+				}
+			}
+			// the resource limit flags override the profile
+			cgroups.OverrideSpec(&spec, resources)
+			if err := cgroupManager.ApplyFromSpec(&spec); err != nil && !cgroupManager.Rootless {
 				return fmt.Errorf("failed to apply cgroups resources restriction: %s", err)
+			} else if err != nil {
+				// the systemd user instance may not delegate the
+				// controllers, the container runs without restriction
+				runtimeLog.Warningf("Could not apply rootless cgroups resources restriction, running without: %s", err)
 			}
 		}
 	}
 
-	sylog.Debugf("Chdir into / to avoid errors\n")
+	runtimeLog.Debugf("Chdir into / to avoid errors\n")
 	err = syscall.Chdir("/")
 	if err != nil {
 		return fmt.Errorf("change directory failed: %s", err)
 	}
 
-	if err := engine.runFuseDrivers(false, usernsFd); err != nil {
+	// the FUSE drivers run from host see the container root
+	// filesystem through the container process
+	root := filepath.Join("/proc", strconv.Itoa(pid), "root")
+	if err := engine.runFuseDrivers(false, usernsFd, root); err != nil {
 		return fmt.Errorf("while running FUSE drivers: %s", err)
 	}
 
@@ -313,7 +359,7 @@ func (c *container) setupSessionLayout(system *mount.System) error {
 
 	sessionLayer := c.engine.EngineConfig.GetSessionLayer()
 
-	sylog.Debugf("Using Layer system: %s\n", sessionLayer)
+	runtimeLog.Debugf("Using Layer system: %s\n", sessionLayer)
 
 	switch sessionLayer {
 	case singularity.DefaultLayer:
@@ -335,7 +381,7 @@ func (c *container) setupSessionLayout(system *mount.System) error {
 
 // setupOverlayLayout sets up the session with overlay filesystem
 func (c *container) setupOverlayLayout(system *mount.System, sessionPath string) (err error) {
-	sylog.Debugf("Creating overlay SESSIONDIR layout\n")
+	runtimeLog.Debugf("Creating overlay SESSIONDIR layout\n")
 	if c.session, err = layout.NewSession(sessionPath, c.sessionFsType, c.sessionSize, system, overlay.New()); err != nil {
 		return err
 	}
@@ -344,14 +390,14 @@ func (c *container) setupOverlayLayout(system *mount.System, sessionPath string)
 
 // setupUnderlayLayout sets up the session with underlay "filesystem"
 func (c *container) setupUnderlayLayout(system *mount.System, sessionPath string) (err error) {
-	sylog.Debugf("Creating underlay SESSIONDIR layout\n")
+	runtimeLog.Debugf("Creating underlay SESSIONDIR layout\n")
 	c.session, err = layout.NewSession(sessionPath, c.sessionFsType, c.sessionSize, system, underlay.New())
 	return err
 }
 
 // setupDefaultLayout sets up the session without overlay or underlay
 func (c *container) setupDefaultLayout(system *mount.System, sessionPath string) (err error) {
-	sylog.Debugf("Creating default SESSIONDIR layout\n")
+	runtimeLog.Debugf("Creating default SESSIONDIR layout\n")
 	c.session, err = layout.NewSession(sessionPath, c.sessionFsType, c.sessionSize, system, nil)
 	return err
 }
@@ -459,7 +505,7 @@ func (c *container) setupImageDriver(system *mount.System) error {
 				allowOther,
 			)
 
-			sylog.Debugf("Add FUSE mount for image driver with options %s", opts)
+			runtimeLog.Debugf("Add FUSE mount for image driver with options %s", opts)
 			err := c.rpcOps.Mount("fuse", sp, "fuse", syscall.MS_NOSUID|syscall.MS_NODEV, opts)
 			if err != nil {
 				return fmt.Errorf("while mounting fuse image driver: %s", err)
@@ -467,7 +513,7 @@ func (c *container) setupImageDriver(system *mount.System) error {
 
 			umountPoints = append(umountPoints, sp)
 
-			sylog.Debugf("Starting image driver %s", c.engine.EngineConfig.File.ImageDriver)
+			runtimeLog.Debugf("Starting image driver %s", c.engine.EngineConfig.File.ImageDriver)
 			if err := imageDriver.Start(params); err != nil {
 				return fmt.Errorf("failed to start driver: %s", err)
 			}
@@ -481,7 +527,7 @@ func (c *container) setupImageDriver(system *mount.System) error {
 		if params.UsernsFd != -1 {
 			defer unix.Close(params.UsernsFd)
 		}
-		sylog.Debugf("Starting image driver %s", c.engine.EngineConfig.File.ImageDriver)
+		runtimeLog.Debugf("Starting image driver %s", c.engine.EngineConfig.File.ImageDriver)
 		if err := imageDriver.Start(params); err != nil {
 			return fmt.Errorf("failed to start driver: %s", err)
 		}
@@ -499,10 +545,10 @@ func (c *container) setPropagationMount(system *mount.System) error {
 	pflags := uintptr(syscall.MS_REC)
 
 	if c.engine.EngineConfig.File.MountSlave {
-		sylog.Debugf("Set RPC mount propagation flag to SLAVE")
+		runtimeLog.Debugf("Set RPC mount propagation flag to SLAVE")
 		pflags |= syscall.MS_SLAVE
 	} else {
-		sylog.Debugf("Set RPC mount propagation flag to PRIVATE")
+		runtimeLog.Debugf("Set RPC mount propagation flag to PRIVATE")
 		pflags |= syscall.MS_PRIVATE
 	}
 
@@ -592,9 +638,9 @@ func (c *container) mountGeneric(mnt *mount.Point, tag mount.AuthorizedTag) (err
 				return nil
 			}
 		}
-		sylog.Debugf("Remounting %s\n", dest)
+		runtimeLog.Debugw("Remounting", "dest", dest)
 	} else {
-		sylog.Debugf("Mounting %s to %s\n", source, dest)
+		runtimeLog.Debugw("Mounting", "source", source, "dest", dest)
 
 		// in stage 1 we changed current working directory to
 		// sandbox image directory, just pass "." as source argument to
@@ -632,16 +678,16 @@ mount:
 			mount.FilesTag,
 			mount.TmpTag:
 			c.skippedMount = append(c.skippedMount, mnt.Destination)
-			sylog.Warningf("Skipping mount %s [%s]: %s doesn't exist in container", source, tag, mnt.Destination)
+			runtimeLog.Warningf("Skipping mount %s [%s]: %s doesn't exist in container", source, tag, mnt.Destination)
 			return nil
 		default:
 			if c.engine.EngineConfig.GetWritableImage() {
-				sylog.Warningf(
+				runtimeLog.Warningf(
 					"By using --writable, Singularity can't create %s destination automatically without overlay or underlay",
 					mnt.Destination,
 				)
 			} else if !c.isLayerEnabled() {
-				sylog.Warningf("No layer in use (overlay or underlay), check your configuration, "+
+				runtimeLog.Warningf("No layer in use (overlay or underlay), check your configuration, "+
 					"Singularity can't create %s destination automatically without overlay or underlay", mnt.Destination)
 			}
 			return fmt.Errorf("destination %s doesn't exist in container", mnt.Destination)
@@ -649,13 +695,13 @@ mount:
 	} else if err != nil {
 		if !bindMount && !remount {
 			if mnt.Type == "devpts" {
-				sylog.Verbosef("Couldn't mount devpts filesystem, continuing with PTY allocation functionality disabled")
+				runtimeLog.Verbosef("Couldn't mount devpts filesystem, continuing with PTY allocation functionality disabled")
 				return nil
 			} else if mnt.Type == "overlay" && err == syscall.ESTALE {
 				// overlay mount can return this error when a previous mount was
 				// done with an upper layer and overlay inodes index is enabled
 				// by default, see https://github.com/sylabs/singularity/issues/4539
-				sylog.Verbosef("Overlay mount failed with %s, mounting with index=off", err)
+				runtimeLog.Verbosef("Overlay mount failed with %s, mounting with index=off", err)
 				optsString = fmt.Sprintf("%s,index=off", optsString)
 				goto mount
 			}
@@ -669,9 +715,9 @@ mount:
 				// execution by ignoring the error and warn user if the bind mount
 				// need to be mounted read-only
 				if flags&syscall.MS_RDONLY != 0 {
-					sylog.Warningf("Could not remount %s read-only: %s", mnt.Destination, err)
+					runtimeLog.Warningf("Could not remount %s read-only: %s", mnt.Destination, err)
 				} else {
-					sylog.Verbosef("Could not remount %s: %s", mnt.Destination, err)
+					runtimeLog.Verbosef("Could not remount %s: %s", mnt.Destination, err)
 				}
 				return nil
 			}
@@ -703,7 +749,8 @@ func (c *container) mountImage(mnt *mount.Point) error {
 
 	mountType := mnt.Type
 
-	if mountType == "encryptfs" {
+	if mountType == "encryptfs" || mountType == "encryptext3" || mountType == "verityfs" {
+		// the key of a verityfs mount holds the dm-verity parameters
 		key, err = mount.GetKey(mnt.InternalOptions)
 		if err != nil {
 			return err
@@ -746,9 +793,9 @@ func (c *container) mountImage(mnt *mount.Point) error {
 
 	path := fmt.Sprintf("/dev/loop%d", number)
 
-	sylog.Debugf("Mounting loop device %s to %s of type %s\n", path, mnt.Destination, mnt.Type)
+	runtimeLog.Debugw("Mounting loop device", "device", path, "dest", mnt.Destination, "type", mnt.Type)
 
-	if mountType == "encryptfs" {
+	if mountType == "encryptfs" || mountType == "encryptext3" {
 		// pass the master processus ID only if a container IPC
 		// namespace was requested because cryptsetup requires
 		// to run in the host IPC namespace
@@ -757,15 +804,33 @@ func (c *container) mountImage(mnt *mount.Point) error {
 			masterPid = os.Getpid()
 		}
 
-		cryptDev, err = c.rpcOps.Decrypt(offset, path, key, masterPid)
+		cryptDev, err := c.rpcOps.Decrypt(offset, path, key, masterPid)
 
 		if err != nil {
+			audit.Record(audit.Decrypt, audit.Failure, "image", mnt.Source, "error", err.Error())
 			return fmt.Errorf("unable to decrypt the file system: %s", err)
 		}
+		audit.Record(audit.Decrypt, audit.Success, "image", mnt.Source, "device", cryptDev)
 
+		cryptDevs = append(cryptDevs, cryptDev)
 		path = cryptDev
 
-		// Currently we only support encrypted squashfs file system
+		// the root filesystem is an encrypted squashfs, the
+		// overlays an encrypted ext3
+		if mountType == "encryptext3" {
+			mountType = "ext3"
+		} else {
+			mountType = "squashfs"
+		}
+	} else if mountType == "verityfs" {
+		verityDev, err = c.verityDevice(mnt.Source, path, key, maxDevices, shared)
+		if err != nil {
+			audit.Record(audit.Verity, audit.Failure, "image", mnt.Source, "error", err.Error())
+			return fmt.Errorf("unable to set up dm-verity: %s", err)
+		}
+		audit.Record(audit.Verity, audit.Success, "image", mnt.Source, "device", verityDev)
+
+		path = verityDev
 		mountType = "squashfs"
 	}
 
@@ -791,6 +856,34 @@ func (c *container) mountImage(mnt *mount.Point) error {
 	return nil
 }
 
+// verityDevice creates the verity device checking the data loop device
+// against the hash tree of the image source described by params.
+func (c *container) verityDevice(source, dataDev string, params []byte, maxDevices int, shared bool) (string, error) {
+	p := new(verity.Params)
+	if err := json.Unmarshal(params, p); err != nil {
+		return "", fmt.Errorf("while decoding dm-verity parameters: %s", err)
+	}
+
+	info := loop.Info64{
+		Offset:    p.HashOffset,
+		SizeLimit: p.HashSize,
+		Flags:     loop.FlagsAutoClear | loop.FlagsReadOnly,
+	}
+	number, err := c.rpcOps.LoopDevice(source, os.O_RDONLY, info, maxDevices, shared)
+	if err != nil {
+		return "", fmt.Errorf("failed to find loop device for hash tree: %s", err)
+	}
+	hashDev := fmt.Sprintf("/dev/loop%d", number)
+
+	// veritysetup requires to run in the host IPC namespace
+	// like cryptsetup
+	masterPid := 0
+	if c.ipcNS {
+		masterPid = os.Getpid()
+	}
+	return c.rpcOps.Verity(dataDev, hashDev, params, masterPid)
+}
+
 func (c *container) addRootfsMount(system *mount.System) error {
 	flags := uintptr(c.suidFlag | syscall.MS_NODEV)
 	rootfs := c.engine.EngineConfig.GetImage()
@@ -802,27 +895,39 @@ func (c *container) addRootfsMount(system *mount.System) error {
 	}
 
 	if !imageObject.Writable {
-		sylog.Debugf("Mount rootfs in read-only mode")
+		runtimeLog.Debugf("Mount rootfs in read-only mode")
 		flags |= syscall.MS_RDONLY
 	} else {
-		sylog.Debugf("Mount rootfs in read-write mode")
+		runtimeLog.Debugf("Mount rootfs in read-write mode")
 	}
 
 	mountType := ""
 	var key []byte
 
-	sylog.Debugf("Image type is %v", part.Type)
+	runtimeLog.Debugf("Image type is %v", part.Type)
 
 	switch part.Type {
 	case image.SQUASHFS:
 		mountType = "squashfs"
+		if part.Verity != nil {
+			if c.userNS || imageDriver != nil {
+				runtimeLog.Warningf("dm-verity requires a privileged runtime, the root filesystem is not checked at read time")
+				break
+			}
+			mountType = "verityfs"
+			if key, err = json.Marshal(part.Verity); err != nil {
+				return err
+			}
+		}
 	case image.EXT3:
 		mountType = "ext3"
+	case image.EROFS:
+		mountType = "erofs"
 	case image.ENCRYPTSQUASHFS:
 		mountType = "encryptfs"
 		key = c.engine.EngineConfig.GetEncryptionKey()
 	case image.SANDBOX:
-		sylog.Debugf("Mounting directory rootfs: %v\n", rootfs)
+		runtimeLog.Debugf("Mounting directory rootfs: %v\n", rootfs)
 		flags |= syscall.MS_BIND
 		if err := system.Points.AddBind(mount.RootfsTag, rootfs, c.session.RootFsPath(), flags); err != nil {
 			return err
@@ -839,7 +944,7 @@ func (c *container) addRootfsMount(system *mount.System) error {
 		return system.Points.AddPropagation(mount.RootfsTag, c.session.RootFsPath(), flags)
 	}
 
-	sylog.Debugf("Mounting block [%v] image: %v\n", mountType, rootfs)
+	runtimeLog.Debugf("Mounting block [%v] image: %v\n", mountType, rootfs)
 	if err := system.Points.AddImage(
 		mount.RootfsTag,
 		imageObject.Source,
@@ -878,11 +983,11 @@ func (c *container) overlayUpperWork(system *mount.System) error {
 	}
 
 	if err := createUpperWork(ov.GetUpperDir(), "upper"); err != nil {
-		sylog.Errorf("Could not create overlay upper dir. If using an overlay image ensure it contains 'upper' and 'work' directories")
+		runtimeLog.Errorf("Could not create overlay upper dir. If using an overlay image ensure it contains 'upper' and 'work' directories")
 		return err
 	}
 	if err := createUpperWork(ov.GetWorkDir(), "workdir"); err != nil {
-		sylog.Errorf("Could not create overlay work dir. If using an overlay image ensure it contains 'upper' and 'work' directories")
+		runtimeLog.Errorf("Could not create overlay work dir. If using an overlay image ensure it contains 'upper' and 'work' directories")
 		return err
 	}
 
@@ -894,39 +999,64 @@ func (c *container) addOverlayMount(system *mount.System) error {
 	ov := c.session.Layer.(*overlay.Overlay)
 	hasUpper := false
 
-	if c.engine.EngineConfig.GetWritableTmpfs() {
-		sylog.Debugf("Setup writable tmpfs overlay")
+	// the read-only overlays are stacked in the order given, the
+	// last one on top, below the writable overlay if any
+	var lowers []string
+	upperLayer := ""
 
-		if err := c.session.AddDir("/tmpfs/upper"); err != nil {
-			return err
-		}
-		if err := c.session.AddDir("/tmpfs/work"); err != nil {
-			return err
-		}
+	if c.engine.EngineConfig.GetWritableTmpfs() {
+		runtimeLog.Debugf("Setup writable tmpfs overlay")
+
+		if c.engine.EngineConfig.GetCommitDir() != "" {
+			// the layers are kept on the host to be saved by the
+			// master process once the container exits, the upper
+			// and work directories opened during stage 1 are used
+			// to not follow a path changed by the user since
+			fds := c.engine.EngineConfig.GetCommitFds()
+			upper := fmt.Sprintf("/proc/self/fd/%d", fds[0])
+			work := fmt.Sprintf("/proc/self/fd/%d", fds[1])
+			if err := fsoverlay.CheckUpper(upper); err != nil {
+				return err
+			}
+			if err := ov.SetUpperDir(upper); err != nil {
+				return fmt.Errorf("failed to add overlay upper: %s", err)
+			}
+			if err := ov.SetWorkDir(work); err != nil {
+				return fmt.Errorf("failed to add overlay upper: %s", err)
+			}
+		} else {
+			if err := c.session.AddDir("/tmpfs/upper"); err != nil {
+				return err
+			}
+			if err := c.session.AddDir("/tmpfs/work"); err != nil {
+				return err
+			}
 
-		upper, _ := c.session.GetPath("/tmpfs/upper")
-		work, _ := c.session.GetPath("/tmpfs/work")
+			upper, _ := c.session.GetPath("/tmpfs/upper")
+			work, _ := c.session.GetPath("/tmpfs/work")
 
-		if err := ov.SetUpperDir(upper); err != nil {
-			return fmt.Errorf("failed to add overlay upper: %s", err)
-		}
-		if err := ov.SetWorkDir(work); err != nil {
-			return fmt.Errorf("failed to add overlay upper: %s", err)
-		}
+			if err := ov.SetUpperDir(upper); err != nil {
+				return fmt.Errorf("failed to add overlay upper: %s", err)
+			}
+			if err := ov.SetWorkDir(work); err != nil {
+				return fmt.Errorf("failed to add overlay upper: %s", err)
+			}
 
-		tmpfsPath := filepath.Dir(upper)
+			tmpfsPath := filepath.Dir(upper)
 
-		flags := uintptr(c.suidFlag | syscall.MS_NODEV)
+			flags := uintptr(c.suidFlag | syscall.MS_NODEV)
 
-		if err := system.Points.AddBind(mount.PreLayerTag, tmpfsPath, tmpfsPath, flags); err != nil {
-			return fmt.Errorf("failed to add %s temporary filesystem: %s", tmpfsPath, err)
-		}
+			if err := system.Points.AddBind(mount.PreLayerTag, tmpfsPath, tmpfsPath, flags); err != nil {
+				return fmt.Errorf("failed to add %s temporary filesystem: %s", tmpfsPath, err)
+			}
 
-		if err := system.Points.AddRemount(mount.PreLayerTag, tmpfsPath, flags); err != nil {
-			return fmt.Errorf("failed to add %s temporary filesystem: %s", tmpfsPath, err)
+			if err := system.Points.AddRemount(mount.PreLayerTag, tmpfsPath, flags); err != nil {
+				return fmt.Errorf("failed to add %s temporary filesystem: %s", tmpfsPath, err)
+			}
 		}
 
 		hasUpper = true
+		upperLayer = "writable tmpfs"
 	}
 
 	for _, img := range c.engine.EngineConfig.GetImageList() {
@@ -935,7 +1065,7 @@ func (c *container) addOverlayMount(system *mount.System) error {
 			return fmt.Errorf("while opening overlay image %s: %s", img.Path, err)
 		}
 		for _, overlay := range overlays {
-			sylog.Debugf("Using overlay partition in image %s", img.Path)
+			runtimeLog.Debugf("Using overlay partition in image %s", img.Path)
 
 			sessionDest := fmt.Sprintf("/overlay-images/%d", nb)
 			if err := c.session.AddDir(sessionDest); err != nil {
@@ -955,19 +1085,39 @@ func (c *container) addOverlayMount(system *mount.System) error {
 				if !img.Writable {
 					flags |= syscall.MS_RDONLY
 					ov.AddLowerDir(filepath.Join(dst, "upper"))
+					lowers = append(lowers, img.Path)
 				}
 
 				err = system.Points.AddImage(mount.PreLayerTag, src, dst, "ext3", flags, offset, size, nil)
 				if err != nil {
 					return fmt.Errorf("while adding ext3 image: %s", err)
 				}
-			case image.SQUASHFS:
+			case image.ENCRYPTEXT3:
+				flags := uintptr(c.suidFlag | syscall.MS_NODEV)
+
+				if !img.Writable {
+					flags |= syscall.MS_RDONLY
+					ov.AddLowerDir(filepath.Join(dst, "upper"))
+					lowers = append(lowers, img.Path)
+				}
+
+				key := c.engine.EngineConfig.GetOverlayEncryptionKey()
+				err = system.Points.AddImage(mount.PreLayerTag, src, dst, "encryptext3", flags, offset, size, key)
+				if err != nil {
+					return fmt.Errorf("while adding encrypted ext3 image: %s", err)
+				}
+			case image.SQUASHFS, image.EROFS:
+				fstype := "squashfs"
+				if overlay.Type == image.EROFS {
+					fstype = "erofs"
+				}
 				flags := uintptr(c.suidFlag | syscall.MS_NODEV | syscall.MS_RDONLY)
-				err = system.Points.AddImage(mount.PreLayerTag, src, dst, "squashfs", flags, offset, size, nil)
+				err = system.Points.AddImage(mount.PreLayerTag, src, dst, fstype, flags, offset, size, nil)
 				if err != nil {
 					return err
 				}
 				ov.AddLowerDir(dst)
+				lowers = append(lowers, img.Path)
 			case image.SANDBOX:
 				allowed := os.Geteuid() == 0
 
@@ -999,6 +1149,7 @@ func (c *container) addOverlayMount(system *mount.System) error {
 					} else {
 						ov.AddLowerDir(dst)
 					}
+					lowers = append(lowers, img.Path)
 				} else {
 					// check if the sandbox directory is located on a compatible
 					// filesystem usable with overlay upper directory
@@ -1027,10 +1178,22 @@ func (c *container) addOverlayMount(system *mount.System) error {
 				}
 
 				hasUpper = true
+				upperLayer = img.Path
 			}
 		}
 	}
 
+	if upperLayer != "" || len(lowers) > 0 {
+		layers := []string{}
+		if upperLayer != "" {
+			layers = append(layers, upperLayer+" (writable)")
+		}
+		for i := len(lowers) - 1; i >= 0; i-- {
+			layers = append(layers, lowers[i])
+		}
+		runtimeLog.Verbosef("Overlay layers from top to bottom: %s, container image", strings.Join(layers, ", "))
+	}
+
 	if hasUpper {
 		if err := system.RunAfterTag(mount.PreLayerTag, c.overlayUpperWork); err != nil {
 			return err
@@ -1040,6 +1203,45 @@ func (c *container) addOverlayMount(system *mount.System) error {
 	return system.Points.AddPropagation(mount.SharedTag, c.session.FinalPath(), syscall.MS_UNBINDABLE)
 }
 
+// imageBindPartition returns the partition of the image img bound by an
+// image bind, the partition id of a SIF image if id is greater than 0 or
+// the first data partition. The root filesystem of a SIF image without
+// data partition is bound instead.
+func imageBindPartition(img *image.Image, id int) (*image.Section, error) {
+	// id is only meaningful for SIF images
+	if img.Type == image.SIF && id > 0 {
+		partitions, err := img.GetAllPartitions()
+		if err != nil {
+			return nil, fmt.Errorf("while getting partitions for %s: %s", img.Path, err)
+		}
+		for _, part := range partitions {
+			if part.ID == uint32(id) {
+				return &part, nil
+			}
+		}
+		return nil, fmt.Errorf("no partition with ID %d found in %s", id, img.Path)
+	}
+
+	// take the first data partition found
+	partitions, err := img.GetDataPartitions()
+	if err != nil {
+		return nil, fmt.Errorf("while getting data partition for %s: %s", img.Path, err)
+	}
+	if len(partitions) > 0 {
+		return &partitions[0], nil
+	}
+	// bind images are loaded with the data usage only, the root
+	// filesystem partition is looked up in all partitions
+	if img.Type == image.SIF {
+		for _, part := range img.Partitions {
+			if part.AllowedUsage&image.RootFsUsage != 0 {
+				return &part, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no data partition found in %s", img.Path)
+}
+
 func (c *container) addImageBindMount(system *mount.System) error {
 	nb := 0
 	imageList := c.engine.EngineConfig.GetImageList()
@@ -1048,7 +1250,7 @@ func (c *container) addImageBindMount(system *mount.System) error {
 		if bind.ImageSrc() == "" && bind.ID() == "" {
 			continue
 		} else if !c.engine.EngineConfig.File.UserBindControl {
-			sylog.Warningf("Ignoring image bind mount request: user bind control disabled by system administrator")
+			runtimeLog.Warningf("Ignoring image bind mount request: user bind control disabled by system administrator")
 			return nil
 		}
 
@@ -1080,34 +1282,9 @@ func (c *container) addImageBindMount(system *mount.System) error {
 				continue
 			}
 
-			data := (*image.Section)(nil)
-
-			// id is only meaningful for SIF images
-			if img.Type == image.SIF && id > 0 {
-				partitions, err := img.GetAllPartitions()
-				if err != nil {
-					return fmt.Errorf("while getting partitions for %s: %s", img.Path, err)
-				}
-				for _, part := range partitions {
-					if part.ID == uint32(id) {
-						data = &part
-						break
-					}
-				}
-			} else {
-				// take the first data partition found
-				partitions, err := img.GetDataPartitions()
-				if err != nil {
-					return fmt.Errorf("while getting data partition for %s: %s", img.Path, err)
-				}
-				for _, part := range partitions {
-					data = &part
-					break
-				}
-			}
-
-			if data == nil {
-				return fmt.Errorf("no data partition found in %s", img.Path)
+			data, err := imageBindPartition(&img, id)
+			if err != nil {
+				return err
 			}
 
 			sessionDest := fmt.Sprintf("/data-images/%d", nb)
@@ -1129,11 +1306,14 @@ func (c *container) addImageBindMount(system *mount.System) error {
 			case image.SQUASHFS:
 				flags |= syscall.MS_RDONLY
 				fstype = "squashfs"
+			case image.EROFS:
+				flags |= syscall.MS_RDONLY
+				fstype = "erofs"
 			default:
 				return fmt.Errorf("could not use %s for image binding: not supported image format", img.Path)
 			}
 
-			err := system.Points.AddImage(
+			err = system.Points.AddImage(
 				mount.PreLayerTag,
 				img.Source,
 				imgDest,
@@ -1169,9 +1349,9 @@ func (c *container) addKernelMount(system *mount.System) error {
 	var err error
 	bindFlags := uintptr(syscall.MS_BIND | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_REC)
 
-	sylog.Debugf("Checking configuration file for 'mount proc'")
+	runtimeLog.Debugf("Checking configuration file for 'mount proc'")
 	if c.engine.EngineConfig.File.MountProc {
-		sylog.Debugf("Adding proc to mount list\n")
+		runtimeLog.Debugf("Adding proc to mount list\n")
 		if c.pidNS {
 			err = system.Points.AddFS(mount.KernelTag, "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV, "")
 		} else {
@@ -1185,14 +1365,14 @@ func (c *container) addKernelMount(system *mount.System) error {
 		if err != nil {
 			return fmt.Errorf("unable to add proc to mount list: %s", err)
 		}
-		sylog.Verbosef("Default mount: /proc:/proc")
+		runtimeLog.Verbosef("Default mount: /proc:/proc")
 	} else {
-		sylog.Verbosef("Skipping /proc mount")
+		runtimeLog.Verbosef("Skipping /proc mount")
 	}
 
-	sylog.Debugf("Checking configuration file for 'mount sys'")
+	runtimeLog.Debugf("Checking configuration file for 'mount sys'")
 	if c.engine.EngineConfig.File.MountSys {
-		sylog.Debugf("Adding sysfs to mount list\n")
+		runtimeLog.Debugf("Adding sysfs to mount list\n")
 		if !c.userNS {
 			err = system.Points.AddFS(mount.KernelTag, "/sys", "sysfs", syscall.MS_NOSUID|syscall.MS_NODEV, "")
 		} else {
@@ -1206,9 +1386,9 @@ func (c *container) addKernelMount(system *mount.System) error {
 		if err != nil {
 			return fmt.Errorf("unable to add sys to mount list: %s", err)
 		}
-		sylog.Verbosef("Default mount: /sys:/sys")
+		runtimeLog.Verbosef("Default mount: /sys:/sys")
 	} else {
-		sylog.Verbosef("Skipping /sys mount")
+		runtimeLog.Verbosef("Skipping /sys mount")
 	}
 	return nil
 }
@@ -1231,7 +1411,7 @@ func (c *container) addSessionDevAt(srcpath string, atpath string, system *mount
 
 		dst, _ := c.session.GetPath(atpath)
 
-		sylog.Debugf("Adding symlink device %s to %s at %s", srcpath, target, dst)
+		runtimeLog.Debugf("Adding symlink device %s to %s at %s", srcpath, target, dst)
 
 		return nil
 	case mode.IsDir():
@@ -1246,7 +1426,7 @@ func (c *container) addSessionDevAt(srcpath string, atpath string, system *mount
 
 	dst, _ := c.session.GetPath(atpath)
 
-	sylog.Debugf("Mounting device %s at %s", srcpath, dst)
+	runtimeLog.Debugf("Mounting device %s at %s", srcpath, dst)
 
 	if err := system.Points.AddBind(mount.DevTag, srcpath, dst, syscall.MS_BIND); err != nil {
 		return fmt.Errorf("failed to add %s mount: %s", srcpath, err)
@@ -1270,14 +1450,21 @@ func (c *container) addSessionDevMount(system *mount.System) error {
 }
 
 func (c *container) addDevMount(system *mount.System) error {
-	sylog.Debugf("Checking configuration file for 'mount dev'")
+	devices := c.engine.EngineConfig.GetDevices()
+	for _, dev := range devices {
+		if err := checkDevice(dev); err != nil {
+			return err
+		}
+	}
+
+	runtimeLog.Debugf("Checking configuration file for 'mount dev'")
 
 	if c.engine.EngineConfig.File.MountDev == "minimal" || c.engine.EngineConfig.GetContain() {
-		sylog.Debugf("Creating temporary staged /dev")
+		runtimeLog.Debugf("Creating temporary staged /dev")
 		if err := c.session.AddDir("/dev"); err != nil {
 			return fmt.Errorf("failed to add /dev session directory: %s", err)
 		}
-		sylog.Debugf("Creating temporary staged /dev/shm")
+		runtimeLog.Debugf("Creating temporary staged /dev/shm")
 		if err := c.session.AddDir("/dev/shm"); err != nil {
 			return fmt.Errorf("failed to add /dev/shm session directory: %s", err)
 		}
@@ -1289,7 +1476,7 @@ func (c *container) addDevMount(system *mount.System) error {
 		}
 
 		if c.ipcNS {
-			sylog.Debugf("Creating temporary staged /dev/mqueue")
+			runtimeLog.Debugf("Creating temporary staged /dev/mqueue")
 			if err := c.session.AddDir("/dev/mqueue"); err != nil {
 				return fmt.Errorf("failed to add /dev/mqueue session directory: %s", err)
 			}
@@ -1306,7 +1493,7 @@ func (c *container) addDevMount(system *mount.System) error {
 				return fmt.Errorf("multiple devpts instances unsupported and /dev/pts configured")
 			}
 
-			sylog.Debugf("Creating temporary staged /dev/pts")
+			runtimeLog.Debugf("Creating temporary staged /dev/pts")
 			if err := c.session.AddDir("/dev/pts"); err != nil {
 				return fmt.Errorf("failed to add /dev/pts session directory: %s", err)
 			}
@@ -1320,9 +1507,9 @@ func (c *container) addDevMount(system *mount.System) error {
 				options = fmt.Sprintf("%s,gid=%d", options, group.GID)
 
 			} else {
-				sylog.Debugf("Not setting /dev/pts filesystem gid: user namespace enabled")
+				runtimeLog.Debugf("Not setting /dev/pts filesystem gid: user namespace enabled")
 			}
-			sylog.Debugf("Mounting devpts for staged /dev/pts")
+			runtimeLog.Debugf("Mounting devpts for staged /dev/pts")
 			devptsPath, _ := c.session.GetPath("/dev/pts")
 			err = system.Points.AddFS(mount.DevTag, devptsPath, "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC, options)
 			if err != nil {
@@ -1363,15 +1550,15 @@ func (c *container) addDevMount(system *mount.System) error {
 					fderr == nil &&
 					consinfo.Ino == fdinfo.Ino &&
 					consinfo.Rdev == fdinfo.Rdev {
-					sylog.Debugf("Fd %d is tty pointing to nonexistent %s but /dev/console is good", fd, ttylink)
+					runtimeLog.Debugf("Fd %d is tty pointing to nonexistent %s but /dev/console is good", fd, ttylink)
 					ttylink = "/dev/console"
 
 				} else {
-					sylog.Debugf("Fd %d is tty but %s doesn't exist, skipping", fd, ttylink)
+					runtimeLog.Debugf("Fd %d is tty but %s doesn't exist, skipping", fd, ttylink)
 					continue
 				}
 			}
-			sylog.Debugf("Fd %d is tty %s, binding to /dev/console", fd, ttylink)
+			runtimeLog.Debugf("Fd %d is tty %s, binding to /dev/console", fd, ttylink)
 			if err := c.addSessionDevAt(ttylink, "/dev/console", system); err != nil {
 				return err
 			}
@@ -1392,8 +1579,9 @@ func (c *container) addDevMount(system *mount.System) error {
 		if err := c.addSessionDev("/dev/urandom", system); err != nil {
 			return err
 		}
-		if c.engine.EngineConfig.GetNv() {
-			devs, err := gpu.NvidiaDevices(true)
+		// nvidia-container-cli creates the devices of the selected GPUs
+		if c.engine.EngineConfig.GetNv() && !c.engine.EngineConfig.GetNvCCLI() {
+			devs, err := gpu.NvidiaVisibleDevices(c.engine.EngineConfig.GetNvEnv())
 			if err != nil {
 				return fmt.Errorf("failed to get nvidia devices: %v", err)
 			}
@@ -1405,7 +1593,7 @@ func (c *container) addDevMount(system *mount.System) error {
 		}
 
 		if c.engine.EngineConfig.GetRocm() {
-			devs, err := gpu.RocmDevices(true)
+			devs, err := gpu.RocmVisibleDevices(c.engine.EngineConfig.GetRocmEnv())
 			if err != nil {
 				return fmt.Errorf("failed to get rocm devices: %v", err)
 			}
@@ -1416,6 +1604,12 @@ func (c *container) addDevMount(system *mount.System) error {
 			}
 		}
 
+		for _, dev := range devices {
+			if err := c.addSessionDev(dev, system); err != nil {
+				return err
+			}
+		}
+
 		if err := c.addSessionDev("/dev/fd", system); err != nil {
 			return err
 		}
@@ -1435,21 +1629,40 @@ func (c *container) addDevMount(system *mount.System) error {
 			return err
 		}
 	} else if c.engine.EngineConfig.File.MountDev == "yes" {
-		sylog.Debugf("Adding dev to mount list\n")
+		runtimeLog.Debugf("Adding dev to mount list\n")
 		err := system.Points.AddBind(mount.DevTag, "/dev", "/dev", syscall.MS_BIND|syscall.MS_REC)
 		if err != nil {
 			return fmt.Errorf("unable to add dev to mount list: %s", err)
 		}
-		sylog.Verbosef("Default mount: /dev:/dev")
+		runtimeLog.Verbosef("Default mount: /dev:/dev")
 	} else if c.engine.EngineConfig.File.MountDev == "no" {
-		sylog.Verbosef("Not mounting /dev inside the container, disallowed by configuration")
+		runtimeLog.Verbosef("Not mounting /dev inside the container, disallowed by configuration")
+		if len(devices) > 0 {
+			runtimeLog.Warningf("Not binding devices %s: /dev mount disallowed by configuration", strings.Join(devices, ", "))
+		}
+	}
+	return nil
+}
+
+// checkDevice returns an error if path isn't a character or block device
+// of the host /dev directory.
+func checkDevice(path string) error {
+	if filepath.Clean(path) != path || !strings.HasPrefix(path, "/dev/") {
+		return fmt.Errorf("device %s is not in /dev", path)
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return fmt.Errorf("while checking device %s: %s", path, err)
+	}
+	if fi.Mode()&os.ModeDevice == 0 {
+		return fmt.Errorf("%s is not a device", path)
 	}
 	return nil
 }
 
 func (c *container) addHostMount(system *mount.System) error {
 	if !c.engine.EngineConfig.File.MountHostfs {
-		sylog.Debugf("Not mounting host file systems per configuration")
+		runtimeLog.Debugf("Not mounting host file systems per configuration")
 		return nil
 	}
 
@@ -1460,25 +1673,25 @@ func (c *container) addHostMount(system *mount.System) error {
 	flags := uintptr(syscall.MS_BIND | c.suidFlag | syscall.MS_NODEV | syscall.MS_REC)
 	for _, child := range info["/"] {
 		if strings.HasPrefix(child, "/proc") {
-			sylog.Debugf("Skipping /proc based file system")
+			runtimeLog.Debugf("Skipping /proc based file system")
 			continue
 		} else if strings.HasPrefix(child, "/sys") {
-			sylog.Debugf("Skipping /sys based file system")
+			runtimeLog.Debugf("Skipping /sys based file system")
 			continue
 		} else if strings.HasPrefix(child, "/dev") {
-			sylog.Debugf("Skipping /dev based file system")
+			runtimeLog.Debugf("Skipping /dev based file system")
 			continue
 		} else if strings.HasPrefix(child, "/run") {
-			sylog.Debugf("Skipping /run based file system")
+			runtimeLog.Debugf("Skipping /run based file system")
 			continue
 		} else if strings.HasPrefix(child, "/boot") {
-			sylog.Debugf("Skipping /boot based file system")
+			runtimeLog.Debugf("Skipping /boot based file system")
 			continue
 		} else if strings.HasPrefix(child, "/var") {
-			sylog.Debugf("Skipping /var based file system")
+			runtimeLog.Debugf("Skipping /var based file system")
 			continue
 		}
-		sylog.Debugf("Adding %s to mount list\n", child)
+		runtimeLog.Debugf("Adding %s to mount list\n", child)
 		if err := system.Points.AddBind(mount.HostfsTag, child, child, flags); err != nil {
 			return fmt.Errorf("unable to add %s to mount list: %s", child, err)
 		}
@@ -1502,15 +1715,20 @@ func (c *container) addBindsMount(system *mount.System) error {
 		// /etc/hosts from host, if network namespace is requested
 		// we create a minimal default hosts for localhost resolution
 		if !c.netNS {
-			sylog.Debugf("Binding /etc/hosts and /etc/localtime only with contain")
+			runtimeLog.Debugf("Binding /etc/hosts and /etc/localtime only with contain")
 		} else {
-			sylog.Debugf("Skipping bind mounts as contain was requested")
+			runtimeLog.Debugf("Skipping bind mounts as contain was requested")
 
-			sylog.Verbosef("Binding staging /etc/hosts as contain is set")
-			if err := c.session.AddFile(hostsPath, files.DefaultHosts()); err != nil {
+			runtimeLog.Verbosef("Binding staging /etc/hosts as contain is set")
+			content, err := files.Hosts(files.DefaultHosts(), c.engine.EngineConfig.GetAddHosts())
+			if err != nil {
+				return fmt.Errorf("while adding /etc/hosts entries: %s", err)
+			}
+			if err := c.session.AddFile(hostsPath, content); err != nil {
 				return fmt.Errorf("while adding /etc/hosts staging file: %s", err)
 			}
 			hosts, _ = c.session.GetPath(hostsPath)
+			c.sessionHosts = true
 		}
 
 		if err := system.Points.AddBind(mount.BindsTag, hosts, hostsPath, flags); err != nil {
@@ -1538,7 +1756,7 @@ func (c *container) addBindsMount(system *mount.System) error {
 			dst = src
 		}
 
-		sylog.Verbosef("Found 'bind path' = %s, %s", src, dst)
+		runtimeLog.Verbosef("Found 'bind path' = %s, %s", src, dst)
 		err := system.Points.AddBind(mount.BindsTag, src, dst, flags)
 		if err != nil {
 			return fmt.Errorf("unable to add %s to mount list: %s", src, err)
@@ -1590,7 +1808,7 @@ func (c *container) addHomeStagingDir(system *mount.System, source string, dest
 	}
 
 	if bindSource {
-		sylog.Debugf("Staging home directory (%v) at %v\n", source, homeStage)
+		runtimeLog.Debugf("Staging home directory (%v) at %v\n", source, homeStage)
 
 		if err := system.Points.AddBind(mount.HomeTag, source, homeStage, flags); err != nil {
 			return "", fmt.Errorf("unable to add %s to mount list: %s", source, err)
@@ -1598,7 +1816,7 @@ func (c *container) addHomeStagingDir(system *mount.System, source string, dest
 		system.Points.AddRemount(mount.HomeTag, homeStage, flags)
 		c.session.OverrideDir(dest, source)
 	} else {
-		sylog.Debugf("Using session directory for home directory")
+		runtimeLog.Debugf("Using session directory for home directory")
 		c.session.OverrideDir(dest, homeStage)
 	}
 
@@ -1636,7 +1854,7 @@ func (c *container) addHomeNoLayer(system *mount.System, source, dest string) er
 
 	homeStageBase, _ := c.session.GetPath(homeBase)
 
-	sylog.Verbosef("Mounting staged home directory base (%v) into container at %v\n", homeStageBase, filepath.Join(c.session.FinalPath(), homeBase))
+	runtimeLog.Verbosef("Mounting staged home directory base (%v) into container at %v\n", homeStageBase, filepath.Join(c.session.FinalPath(), homeBase))
 	if err := system.Points.AddBind(mount.HomeTag, homeStageBase, homeBase, flags); err != nil {
 		return fmt.Errorf("unable to add %s to mount list: %s", homeStageBase, err)
 	}
@@ -1647,12 +1865,12 @@ func (c *container) addHomeNoLayer(system *mount.System, source, dest string) er
 // addHomeMount is responsible for adding the home directory mount using the proper method
 func (c *container) addHomeMount(system *mount.System) error {
 	if c.engine.EngineConfig.GetNoHome() {
-		sylog.Debugf("Skipping home directory mount by user request.")
+		runtimeLog.Debugf("Skipping home directory mount by user request.")
 		return nil
 	}
 
 	if !c.engine.EngineConfig.GetCustomHome() && !c.engine.EngineConfig.File.MountHome {
-		sylog.Debugf("Skipping home dir mounting (per config)")
+		runtimeLog.Debugf("Skipping home dir mounting (per config)")
 		return nil
 	}
 
@@ -1668,7 +1886,7 @@ func (c *container) addHomeMount(system *mount.System) error {
 
 	// issue #5228 - don't attempt to mount a '/' home dir like 'nobody' has
 	if dest == "/" {
-		sylog.Warningf("Skipping impossible home directory mount to '/'")
+		runtimeLog.Warningf("Skipping impossible home directory mount to '/'")
 		return nil
 	}
 
@@ -1678,7 +1896,7 @@ func (c *container) addHomeMount(system *mount.System) error {
 	}
 
 	sessionLayer := c.engine.EngineConfig.GetSessionLayer()
-	sylog.Debugf("Adding home directory mount [%v:%v] to list using layer: %s\n", stagingDir, dest, sessionLayer)
+	runtimeLog.Debugf("Adding home directory mount [%v:%v] to list using layer: %s\n", stagingDir, dest, sessionLayer)
 	if !c.isLayerEnabled() {
 		return c.addHomeNoLayer(system, stagingDir, dest)
 	}
@@ -1701,7 +1919,7 @@ func (c *container) addUserbindsMount(system *mount.System) error {
 
 		src, err := filepath.Abs(source)
 		if err != nil {
-			sylog.Warningf("Can't determine absolute path of %s bind point", source)
+			runtimeLog.Warningf("Can't determine absolute path of %s bind point", source)
 			continue
 		}
 		if b.Readonly() {
@@ -1712,7 +1930,7 @@ func (c *container) addUserbindsMount(system *mount.System) error {
 		// with --contain option or 'mount dev = minimal'
 		if strings.HasPrefix(dst, devPrefix) && strings.HasPrefix(src, devPrefix) {
 			if dst != src {
-				sylog.Warningf("Skipping %s bind mount: source and destination must be identical when binding to %s", src, devPrefix)
+				runtimeLog.Warningf("Skipping %s bind mount: source and destination must be identical when binding to %s", src, devPrefix)
 				continue
 			}
 			if c.engine.EngineConfig.File.MountDev == "minimal" || c.engine.EngineConfig.GetContain() {
@@ -1720,35 +1938,35 @@ func (c *container) addUserbindsMount(system *mount.System) error {
 				if src == devPrefix {
 					system.Points.RemoveByTag(mount.DevTag)
 					c.devSourcePath = devPrefix
-					sylog.Debugf("Adding %[1]s host bind mount, resetting container mount list for %[1]s\n", devPrefix)
+					runtimeLog.Debugf("Adding %[1]s host bind mount, resetting container mount list for %[1]s\n", devPrefix)
 					continue
 				}
 				_, err := c.session.GetPath(src)
 				if err == nil {
-					sylog.Warningf("Skipping %s bind mount: already mounted", src)
+					runtimeLog.Warningf("Skipping %s bind mount: already mounted", src)
 					continue
 				}
 				if err := c.addSessionDev(src, system); err != nil {
-					sylog.Warningf("Skipping %s bind mount: %s", src, err)
+					runtimeLog.Warningf("Skipping %s bind mount: %s", src, err)
 				}
-				sylog.Debugf("Adding device %s to mount list\n", src)
+				runtimeLog.Debugf("Adding device %s to mount list\n", src)
 				continue
 			} else if c.engine.EngineConfig.File.MountDev == "no" {
-				sylog.Warningf("Skipping %s bind mount: disallowed by configuration", src)
+				runtimeLog.Warningf("Skipping %s bind mount: disallowed by configuration", src)
 				continue
 			}
 			// proceed with normal binds below if 'mount dev = yes'
 			// or '--contain' wasn't requested
 		}
 		if !c.engine.EngineConfig.File.UserBindControl {
-			sylog.Warningf("Ignoring %s bind mount: user bind control disabled by system administrator", src)
+			runtimeLog.Warningf("Ignoring %s bind mount: user bind control disabled by system administrator", src)
 			continue
 		}
 
-		sylog.Debugf("Adding %s to mount list\n", src)
+		runtimeLog.Debugw("Adding bind to mount list", "bind", src, "dest", dst)
 
 		if err := system.Points.AddBind(mount.UserbindsTag, src, dst, flags); err == mount.ErrMountExists {
-			sylog.Warningf("While bind mounting '%s:%s': %s", src, dst, err)
+			runtimeLog.Warningf("While bind mounting '%s:%s': %s", src, dst, err)
 		} else if err != nil {
 			return fmt.Errorf("unable to add %s to mount list: %s", src, err)
 		} else {
@@ -1769,9 +1987,9 @@ func (c *container) addTmpMount(system *mount.System) error {
 		varTmpPath = "/var/tmp"
 	)
 
-	sylog.Debugf("Checking for 'mount tmp' in configuration file")
+	runtimeLog.Debugf("Checking for 'mount tmp' in configuration file")
 	if !c.engine.EngineConfig.File.MountTmp {
-		sylog.Verbosef("Skipping tmp dir mounting (per config)")
+		runtimeLog.Verbosef("Skipping tmp dir mounting (per config)")
 		return nil
 	}
 
@@ -1782,7 +2000,7 @@ func (c *container) addTmpMount(system *mount.System) error {
 		workdir := c.engine.EngineConfig.GetWorkdir()
 		if workdir != "" {
 			if !c.engine.EngineConfig.File.UserBindControl {
-				sylog.Warningf("User bind control is disabled by system administrator")
+				runtimeLog.Warningf("User bind control is disabled by system administrator")
 				return nil
 			}
 
@@ -1790,7 +2008,7 @@ func (c *container) addTmpMount(system *mount.System) error {
 
 			workdir, err := filepath.Abs(filepath.Clean(workdir))
 			if err != nil {
-				sylog.Warningf("Can't determine absolute path of workdir %s", workdir)
+				runtimeLog.Warningf("Can't determine absolute path of workdir %s", workdir)
 			}
 
 			tmpSource = filepath.Join(workdir, tmpSource)
@@ -1831,14 +2049,14 @@ func (c *container) addTmpMount(system *mount.System) error {
 
 	if err := system.Points.AddBind(mount.TmpTag, tmpSource, tmpPath, flags); err == nil {
 		system.Points.AddRemount(mount.TmpTag, tmpPath, flags)
-		sylog.Verbosef("Default mount: %s:%s", tmpPath, tmpPath)
+		runtimeLog.Verbosef("Default mount: %s:%s", tmpPath, tmpPath)
 	} else {
 		return fmt.Errorf("could not mount container's %s directory: %s", tmpPath, err)
 	}
 
 	if err := system.Points.AddBind(mount.TmpTag, vartmpSource, varTmpPath, flags); err == nil {
 		system.Points.AddRemount(mount.TmpTag, varTmpPath, flags)
-		sylog.Verbosef("Default mount: %s:%s", varTmpPath, varTmpPath)
+		runtimeLog.Verbosef("Default mount: %s:%s", varTmpPath, varTmpPath)
 	} else {
 		return fmt.Errorf("could not mount container's %s directory: %s", varTmpPath, err)
 	}
@@ -1850,13 +2068,13 @@ func (c *container) addScratchMount(system *mount.System) error {
 
 	scratchDir := c.engine.EngineConfig.GetScratchDir()
 	if len(scratchDir) == 0 {
-		sylog.Debugf("Not mounting scratch directory: Not requested")
+		runtimeLog.Debugf("Not mounting scratch directory: Not requested")
 		return nil
 	} else if len(scratchDir) == 1 {
 		scratchDir = strings.Split(filepath.Clean(scratchDir[0]), ",")
 	}
 	if !c.engine.EngineConfig.File.UserBindControl {
-		sylog.Verbosef("Not mounting scratch: user bind control disabled by system administrator")
+		runtimeLog.Verbosef("Not mounting scratch: user bind control disabled by system administrator")
 		return nil
 	}
 
@@ -1895,16 +2113,16 @@ func (c *container) addScratchMount(system *mount.System) error {
 }
 
 func (c *container) isMounted(dest string) bool {
-	sylog.Debugf("Checking if %s is already mounted", dest)
+	runtimeLog.Debugf("Checking if %s is already mounted", dest)
 
 	if !filepath.IsAbs(dest) {
-		sylog.Debugf("%s is not an absolute path", dest)
+		runtimeLog.Debugf("%s is not an absolute path", dest)
 		return false
 	}
 
 	entries, err := proc.GetMountInfoEntry(c.mountInfoPath)
 	if err != nil {
-		sylog.Debugf("Could not get %s entries: %s", c.mountInfoPath, err)
+		runtimeLog.Debugf("Could not get %s entries: %s", c.mountInfoPath, err)
 		return false
 	}
 
@@ -1919,31 +2137,31 @@ func (c *container) isMounted(dest string) bool {
 
 func (c *container) addCwdMount(system *mount.System) error {
 	if c.engine.EngineConfig.GetContain() {
-		sylog.Verbosef("Not mounting current directory: container was requested")
+		runtimeLog.Verbosef("Not mounting current directory: container was requested")
 		return nil
 	}
 	if !c.engine.EngineConfig.File.UserBindControl {
-		sylog.Warningf("Not mounting current directory: user bind control is disabled by system administrator")
+		runtimeLog.Warningf("Not mounting current directory: user bind control is disabled by system administrator")
 		return nil
 	}
 	cwd := c.engine.EngineConfig.GetCwd()
 	if cwd == "" {
-		sylog.Warningf("Not current working directory set: skipping mount")
+		runtimeLog.Warningf("Not current working directory set: skipping mount")
 	}
 
 	current, err := filepath.EvalSymlinks(cwd)
 	if err != nil {
 		return fmt.Errorf("could not obtain current directory path: %s", err)
 	}
-	sylog.Debugf("Using %s as current working directory", cwd)
+	runtimeLog.Debugf("Using %s as current working directory", cwd)
 
 	switch current {
 	case "/", "/etc", "/bin", "/mnt", "/usr", "/var", "/opt", "/sbin", "/lib", "/lib64":
-		sylog.Verbosef("Not mounting CWD within operating system directory: %s", current)
+		runtimeLog.Verbosef("Not mounting CWD within operating system directory: %s", current)
 		return nil
 	}
 	if strings.HasPrefix(current, "/sys") || strings.HasPrefix(current, "/proc") || strings.HasPrefix(current, "/dev") {
-		sylog.Verbosef("Not mounting CWD within virtual directory: %s", current)
+		runtimeLog.Verbosef("Not mounting CWD within virtual directory: %s", current)
 		return nil
 	}
 
@@ -1953,9 +2171,9 @@ func (c *container) addCwdMount(system *mount.System) error {
 	fi, err := c.rpcOps.Stat(dest)
 	if err != nil {
 		if os.IsNotExist(err) {
-			sylog.Verbosef("Not mounting CWD, %s doesn't exist within container", cwd)
+			runtimeLog.Verbosef("Not mounting CWD, %s doesn't exist within container", cwd)
 		}
-		sylog.Verbosef("Not mounting CWD, while getting %s information: %s", cwd, err)
+		runtimeLog.Verbosef("Not mounting CWD, while getting %s information: %s", cwd, err)
 		return nil
 	}
 	cst := fi.Sys().(*syscall.Stat_t)
@@ -1966,10 +2184,10 @@ func (c *container) addCwdMount(system *mount.System) error {
 	}
 	// same ino/dev, the current working directory is available within the container
 	if hst.Dev == cst.Dev && hst.Ino == cst.Ino {
-		sylog.Verbosef("%s found within container", cwd)
+		runtimeLog.Verbosef("%s found within container", cwd)
 		return nil
 	} else if c.isMounted(dest) {
-		sylog.Verbosef("Not mounting CWD (already mounted in container): %s", cwd)
+		runtimeLog.Verbosef("Not mounting CWD (already mounted in container): %s", cwd)
 		return nil
 	}
 
@@ -1983,13 +2201,13 @@ func (c *container) addCwdMount(system *mount.System) error {
 func (c *container) addLibsMount(system *mount.System) error {
 	libraries := c.engine.EngineConfig.GetLibrariesPath()
 
-	sylog.Debugf("Checking for 'user bind control' in configuration file")
+	runtimeLog.Debugf("Checking for 'user bind control' in configuration file")
 	if !c.engine.EngineConfig.File.UserBindControl {
 		msg := "Ignoring libraries bind request: user bind control disabled by system administrator"
 		if len(libraries) > 0 {
-			sylog.Warningf(msg)
+			runtimeLog.Warningf(msg)
 		} else {
-			sylog.Verbosef(msg)
+			runtimeLog.Verbosef(msg)
 		}
 		return nil
 	}
@@ -2004,7 +2222,7 @@ func (c *container) addLibsMount(system *mount.System) error {
 	}
 
 	for _, lib := range libraries {
-		sylog.Debugf("Add library %s to mount list", lib)
+		runtimeLog.Debugf("Add library %s to mount list", lib)
 
 		file := filepath.Base(lib)
 		sessionFile := filepath.Join(sessionDir, file)
@@ -2039,13 +2257,13 @@ func (c *container) addLibsMount(system *mount.System) error {
 func (c *container) addFilesMount(system *mount.System) error {
 	files := c.engine.EngineConfig.GetFilesPath()
 
-	sylog.Debugf("Checking for 'user bind control' in configuration file")
+	runtimeLog.Debugf("Checking for 'user bind control' in configuration file")
 	if !c.engine.EngineConfig.File.UserBindControl {
 		msg := "Ignoring binaries bind request: user bind control disabled by system administrator"
 		if len(files) > 0 {
-			sylog.Warningf(msg)
+			runtimeLog.Warningf(msg)
 		} else {
-			sylog.Verbosef(msg)
+			runtimeLog.Verbosef(msg)
 		}
 		return nil
 	}
@@ -2053,7 +2271,7 @@ func (c *container) addFilesMount(system *mount.System) error {
 	flags := uintptr(syscall.MS_BIND | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_RDONLY | syscall.MS_REC)
 
 	for _, file := range files {
-		sylog.Debugf("Adding file %s to mount list", file)
+		runtimeLog.Debugf("Adding file %s to mount list", file)
 
 		splitted := strings.Split(file, ":")
 		src := splitted[0]
@@ -2075,7 +2293,11 @@ func (c *container) addFilesMount(system *mount.System) error {
 func (c *container) addIdentityMount(system *mount.System) error {
 	if (os.Geteuid() == 0 && c.engine.EngineConfig.GetTargetUID() == 0) ||
 		c.engine.EngineConfig.GetFakeroot() {
-		sylog.Verbosef("Not updating passwd/group files, running as root!")
+		runtimeLog.Verbosef("Not updating passwd/group files, running as root!")
+		return nil
+	}
+	if c.engine.EngineConfig.GetContainerUser() {
+		runtimeLog.Verbosef("Not updating passwd/group files, running as a user of the container")
 		return nil
 	}
 
@@ -2091,49 +2313,49 @@ func (c *container) addIdentityMount(system *mount.System) error {
 		passwd := filepath.Join(rootfs, "/etc/passwd")
 		_, home, err := c.getHomePaths()
 		if err != nil {
-			sylog.Warningf("%s", err)
+			runtimeLog.Warningf("%s", err)
 		} else {
 			content, err := files.Passwd(passwd, home, uid)
 			if err != nil {
-				sylog.Warningf("%s", err)
+				runtimeLog.Warningf("%s", err)
 			} else {
 				if err := c.session.AddFile("/etc/passwd", content); err != nil {
-					sylog.Warningf("failed to add passwd session file: %s", err)
+					runtimeLog.Warningf("failed to add passwd session file: %s", err)
 				}
 				passwd, _ = c.session.GetPath("/etc/passwd")
 
-				sylog.Debugf("Adding /etc/passwd to mount list\n")
+				runtimeLog.Debugf("Adding /etc/passwd to mount list\n")
 				err = system.Points.AddBind(mount.FilesTag, passwd, "/etc/passwd", syscall.MS_BIND)
 				if err != nil {
 					return fmt.Errorf("unable to add /etc/passwd to mount list: %s", err)
 				}
-				sylog.Verbosef("Default mount: /etc/passwd:/etc/passwd")
+				runtimeLog.Verbosef("Default mount: /etc/passwd:/etc/passwd")
 			}
 		}
 	} else {
-		sylog.Verbosef("Skipping bind of the host's /etc/passwd")
+		runtimeLog.Verbosef("Skipping bind of the host's /etc/passwd")
 	}
 
 	if c.engine.EngineConfig.File.ConfigGroup {
 		group := filepath.Join(rootfs, "/etc/group")
 		content, err := files.Group(group, uid, c.engine.EngineConfig.GetTargetGID())
 		if err != nil {
-			sylog.Warningf("%s", err)
+			runtimeLog.Warningf("%s", err)
 		} else {
 			if err := c.session.AddFile("/etc/group", content); err != nil {
-				sylog.Warningf("failed to add group session file: %s", err)
+				runtimeLog.Warningf("failed to add group session file: %s", err)
 			}
 			group, _ = c.session.GetPath("/etc/group")
 
-			sylog.Debugf("Adding /etc/group to mount list\n")
+			runtimeLog.Debugf("Adding /etc/group to mount list\n")
 			err = system.Points.AddBind(mount.FilesTag, group, "/etc/group", syscall.MS_BIND)
 			if err != nil {
 				return fmt.Errorf("unable to add /etc/group to mount list: %s", err)
 			}
-			sylog.Verbosef("Default mount: /etc/group:/etc/group")
+			runtimeLog.Verbosef("Default mount: /etc/group:/etc/group")
 		}
 	} else {
-		sylog.Verbosef("Skipping bind of the host's /etc/group")
+		runtimeLog.Verbosef("Skipping bind of the host's /etc/group")
 	}
 
 	return nil
@@ -2165,30 +2387,103 @@ func (c *container) addResolvConfMount(system *mount.System) error {
 				return err
 			}
 		}
+		if search := c.engine.EngineConfig.GetDNSSearch(); search != "" {
+			search = strings.Replace(search, " ", "", -1)
+			content, err = files.ResolvConfSearch(content, strings.Split(search, ","))
+			if err != nil {
+				return err
+			}
+		}
 		if err := c.session.AddFile(resolvConf, content); err != nil {
-			sylog.Warningf("failed to add resolv.conf session file: %s", err)
+			runtimeLog.Warningf("failed to add resolv.conf session file: %s", err)
 		}
 		sessionFile, _ := c.session.GetPath(resolvConf)
 
-		sylog.Debugf("Adding %s to mount list\n", resolvConf)
+		runtimeLog.Debugf("Adding %s to mount list\n", resolvConf)
 		err = system.Points.AddBind(mount.FilesTag, sessionFile, resolvConf, syscall.MS_BIND)
 		if err != nil {
 			return fmt.Errorf("unable to add %s to mount list: %s", resolvConf, err)
 		}
-		sylog.Verbosef("Default mount: /etc/resolv.conf:/etc/resolv.conf")
+		runtimeLog.Verbosef("Default mount: /etc/resolv.conf:/etc/resolv.conf")
 	} else {
-		sylog.Verbosef("Skipping bind of the host's %s", resolvConf)
+		runtimeLog.Verbosef("Skipping bind of the host's %s", resolvConf)
+	}
+	return nil
+}
+
+// resolveHostname returns true if the container hostname must resolve to
+// the addresses assigned by the CNI plugins.
+func (c *container) resolveHostname() bool {
+	net := c.engine.EngineConfig.GetNetwork()
+	if net == "none" || c.netNSJoined {
+		return false
+	}
+	return c.netNS && c.utsNS && c.engine.EngineConfig.GetHostname() != ""
+}
+
+// addHostsMount binds a staging /etc/hosts with the --add-host entries,
+// and where the container hostname is added once the networks are set up,
+// if the staging /etc/hosts of --contain isn't used.
+func (c *container) addHostsMount(system *mount.System) error {
+	hostsPath := "/etc/hosts"
+	addHosts := c.engine.EngineConfig.GetAddHosts()
+
+	if c.sessionHosts || (len(addHosts) == 0 && !c.resolveHostname()) {
+		return nil
+	}
+
+	content, err := ioutil.ReadFile(hostsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	content, err = files.Hosts(content, addHosts)
+	if err != nil {
+		return fmt.Errorf("while adding /etc/hosts entries: %s", err)
+	}
+	if err := c.session.AddFile(hostsPath, content); err != nil {
+		return fmt.Errorf("while adding /etc/hosts staging file: %s", err)
 	}
+	sessionFile, _ := c.session.GetPath(hostsPath)
+
+	runtimeLog.Debugf("Adding %s to mount list\n", hostsPath)
+	if err := system.Points.AddBind(mount.FilesTag, sessionFile, hostsPath, syscall.MS_BIND); err != nil {
+		return fmt.Errorf("unable to add %s to mount list: %s", hostsPath, err)
+	}
+	runtimeLog.Verbosef("Default mount: /etc/hosts:/etc/hosts")
+	c.sessionHosts = true
 	return nil
 }
 
+// addHostnameEntry adds to the staging /etc/hosts the container hostname
+// resolving to the addresses assigned by the CNI plugins.
+func (c *container) addHostnameEntry() error {
+	if !c.sessionHosts || !c.resolveHostname() {
+		return nil
+	}
+	hostname := c.engine.EngineConfig.GetHostname()
+
+	var entries []byte
+	for _, version := range []string{"4", "6"} {
+		ip, err := networkSetup.GetNetworkIP("", version)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, fmt.Sprintf("%s\t%s\n", ip, hostname)...)
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	networkLog.Debugf("Adding hostname %s to /etc/hosts", hostname)
+	return c.rpcOps.AppendFile("/etc/hosts", entries)
+}
+
 func (c *container) addHostnameMount(system *mount.System) error {
 	hostnameFile := "/etc/hostname"
 
 	if c.utsNS {
 		hostname := c.engine.EngineConfig.GetHostname()
 		if hostname != "" {
-			sylog.Debugf("Set container hostname %s", hostname)
+			runtimeLog.Debugf("Set container hostname %s", hostname)
 
 			content, err := files.Hostname(hostname)
 			if err != nil {
@@ -2199,18 +2494,44 @@ func (c *container) addHostnameMount(system *mount.System) error {
 			}
 			sessionFile, _ := c.session.GetPath(hostnameFile)
 
-			sylog.Debugf("Adding %s to mount list\n", hostnameFile)
+			runtimeLog.Debugf("Adding %s to mount list\n", hostnameFile)
 			err = system.Points.AddBind(mount.FilesTag, sessionFile, hostnameFile, syscall.MS_BIND)
 			if err != nil {
 				return fmt.Errorf("unable to add %s to mount list: %s", hostnameFile, err)
 			}
-			sylog.Verbosef("Default mount: /etc/hostname:/etc/hostname")
+			runtimeLog.Verbosef("Default mount: /etc/hostname:/etc/hostname")
 			if _, err := c.rpcOps.SetHostname(hostname); err != nil {
 				return fmt.Errorf("failed to set container hostname: %s", err)
 			}
 		}
 	} else {
-		sylog.Debugf("Skipping hostname mount, not virtualizing UTS namespace on user request")
+		runtimeLog.Debugf("Skipping hostname mount, not virtualizing UTS namespace on user request")
+	}
+	return nil
+}
+
+// setupNvCCLI sets up the GPUs in the container root filesystem with
+// nvidia-container-cli, the GPUs and driver capabilities are selected by
+// the NVIDIA_* environment variables captured by the CLI.
+func (c *container) setupNvCCLI(pid int) error {
+	flags, err := gpu.NvCCLIFlags(c.engine.EngineConfig.GetNvCCLIEnv())
+	if err != nil {
+		return fmt.Errorf("while getting nvidia-container-cli flags: %s", err)
+	}
+	path, err := bin.NvidiaContainerCli(c.engine.EngineConfig.File)
+	if err != nil {
+		return fmt.Errorf("while looking for nvidia-container-cli: %s", err)
+	}
+
+	// rejected for users of the setuid workflow by PrepareConfig
+	if os.Geteuid() != 0 && !c.userNS {
+		return fmt.Errorf("nvidia-container-cli requires root or a user namespace")
+	}
+
+	runtimeLog.Debugf("Setting up GPUs with %s", path)
+	ldconfig := c.engine.EngineConfig.File.LdconfigPath
+	if err := gpu.NvCCLIConfigure(path, ldconfig, flags, pid, c.session.FinalPath(), c.userNS); err != nil {
+		return fmt.Errorf("while setting up GPUs with nvidia-container-cli: %s", err)
 	}
 	return nil
 }
@@ -2227,7 +2548,7 @@ func (c *container) prepareNetworkSetup(system *mount.System, pid int) (func(con
 	net := c.engine.EngineConfig.GetNetwork()
 	euid := os.Geteuid()
 
-	if !c.netNS || net == noneNet {
+	if !c.netNS || net == noneNet || c.netNSJoined {
 		return nil, nil
 	} else if (c.userNS || euid != 0) && !fakeroot {
 		return nil, fmt.Errorf("network requires root or --fakeroot, users need to specify --network=%s with --net", noneNet)
@@ -2247,9 +2568,10 @@ func (c *container) prepareNetworkSetup(system *mount.System, pid int) (func(con
 
 	if fakeroot && euid != 0 && net != fakerootNet {
 		// set as debug message to avoid annoying warning
-		sylog.Debugf("only '%s' network is allowed for regular user, you requested '%s'", fakerootNet, net)
+		networkLog.Debugf("only '%s' network is allowed for regular user, you requested '%s'", fakerootNet, net)
 		networks = []string{fakerootNet}
 	}
+	networkLog.Debugw("Requested networks", "networks", strings.Join(networks, ","))
 
 	cniPath := &network.CNIPath{}
 
@@ -2261,6 +2583,7 @@ func (c *container) prepareNetworkSetup(system *mount.System, pid int) (func(con
 	if cniPath.Plugin == "" {
 		cniPath.Plugin = defaultCNIPluginPath
 	}
+	networkLog.Debugw("Using CNI configuration", "conf", cniPath.Conf, "plugin", cniPath.Plugin)
 
 	setup, err := network.NewSetup(networks, strconv.Itoa(pid), nspath, cniPath)
 	if err != nil {
@@ -2269,6 +2592,7 @@ func (c *container) prepareNetworkSetup(system *mount.System, pid int) (func(con
 	networkSetup = setup
 
 	netargs := c.engine.EngineConfig.GetNetworkArgs()
+	networkLog.Debugw("Network arguments", "args", netargs)
 	if err := networkSetup.SetArgs(netargs); err != nil {
 		return nil, fmt.Errorf("error while setting network arguments: %s", err)
 	}
@@ -2287,10 +2611,14 @@ func (c *container) prepareNetworkSetup(system *mount.System, pid int) (func(con
 
 		networkSetup.SetEnvPath("/bin:/sbin:/usr/bin:/usr/sbin")
 
+		networkLog.Verbosef("Setting up container networks")
+		networkLog.Tracef("Adding networks %s in network namespace %s", strings.Join(networks, ","), nspath)
+		start := time.Now()
 		if err := networkSetup.AddNetworks(ctx); err != nil {
-			return fmt.Errorf("%s", err)
+			return sylog.WithCode(sylog.NetworkFailed, err)
 		}
-		return nil
+		networkLog.Debugw("Container networks set up", "networks", strings.Join(networks, ","), "duration", time.Since(start))
+		return c.addHostnameEntry()
 	}, nil
 }
 
@@ -2457,7 +2785,7 @@ func (c *container) addFuseMount(system *mount.System) (int, error) {
 		}
 		fuseDir, _ = c.session.GetPath(fuseDir)
 
-		sylog.Debugf("Add FUSE mount for %s with options %s", fuseMounts[i].MountPoint, opts)
+		runtimeLog.Debugf("Add FUSE mount for %s with options %s", fuseMounts[i].MountPoint, opts)
 		err := system.Points.AddFS(
 			mount.BindsTag,
 			fuseDir,
@@ -2466,7 +2794,7 @@ func (c *container) addFuseMount(system *mount.System) (int, error) {
 			opts,
 		)
 		if err != nil {
-			sylog.Debugf("Calling AddFS: %+v\n", err)
+			runtimeLog.Debugf("Calling AddFS: %+v\n", err)
 			return usernsFd, err
 		}
 
@@ -2526,7 +2854,7 @@ func (c *container) getBindFlags(source string, defaultFlags uintptr) (uintptr,
 
 	if addFlags&syscall.MS_RDONLY != 0 && defaultFlags&syscall.MS_RDONLY == 0 {
 		if !strings.HasPrefix(source, buildcfg.SESSIONDIR) {
-			sylog.Verbosef("Could not mount %s as read-write: mounted read-only", source)
+			runtimeLog.Verbosef("Could not mount %s as read-write: mounted read-only", source)
 		}
 	}
 
@@ -0,0 +1,127 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+const testSquash = "../../../../../pkg/image/testdata/squashfs.v4"
+
+// createTestSIF creates the SIF image path with the test squashfs image
+// as partitions of type parts, their IDs start at 1.
+func createTestSIF(t *testing.T, path string, parts ...sif.Parttype) {
+	var inputs []sif.DescriptorInput
+	for _, part := range parts {
+		fp, err := os.Open(testSquash)
+		if err != nil {
+			t.Fatalf("failed to open %s: %s", testSquash, err)
+		}
+		defer fp.Close()
+		fi, err := fp.Stat()
+		if err != nil {
+			t.Fatalf("failed to stat %s: %s", testSquash, err)
+		}
+
+		input := sif.DescriptorInput{
+			Datatype: sif.DataPartition,
+			Groupid:  sif.DescrDefaultGroup,
+			Link:     sif.DescrUnusedLink,
+			Size:     fi.Size(),
+			Fname:    "partition",
+			Fp:       fp,
+		}
+		if err := input.SetPartExtra(sif.FsSquash, part, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+			t.Fatal(err)
+		}
+		inputs = append(inputs, input)
+	}
+
+	if _, err := sif.CreateContainer(sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: inputs,
+	}); err != nil {
+		t.Fatalf("while creating SIF image: %s", err)
+	}
+}
+
+func TestImageBindPartition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-bind-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	dataSIF := filepath.Join(dir, "data.sif")
+	createTestSIF(t, dataSIF, sif.PartPrimSys, sif.PartData)
+	rootfsSIF := filepath.Join(dir, "rootfs.sif")
+	createTestSIF(t, rootfsSIF, sif.PartPrimSys)
+	overlaySIF := filepath.Join(dir, "overlay.sif")
+	createTestSIF(t, overlaySIF, sif.PartOverlay)
+	squash := filepath.Join(dir, "image.sqfs")
+	b, err := ioutil.ReadFile(testSquash)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", testSquash, err)
+	}
+	if err := ioutil.WriteFile(squash, b, 0644); err != nil {
+		t.Fatalf("failed to create %s: %s", squash, err)
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		id      int
+		partID  uint32
+		wantErr bool
+	}{
+		{name: "DataPartition", path: dataSIF, partID: 2},
+		{name: "RootFsFallback", path: rootfsSIF, partID: 1},
+		{name: "ID", path: dataSIF, id: 1, partID: 1},
+		{name: "UnknownID", path: dataSIF, id: 3, wantErr: true},
+		{name: "NoPartition", path: overlaySIF, wantErr: true},
+		{name: "Squashfs", path: squash, partID: 1},
+		{name: "SquashfsID", path: squash, id: 2, partID: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := image.Init(tt.path, false)
+			if err != nil {
+				t.Fatalf("failed to load image %s: %s", tt.path, err)
+			}
+			defer img.File.Close()
+			// as loaded by loadBindImages
+			img.Usage = image.DataUsage
+
+			part, err := imageBindPartition(img, tt.id)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if part.ID != tt.partID {
+				t.Errorf("got partition %d instead of %d", part.ID, tt.partID)
+			}
+			if part.Type != image.SQUASHFS {
+				t.Errorf("unexpected partition type %d", part.Type)
+			}
+		})
+	}
+}
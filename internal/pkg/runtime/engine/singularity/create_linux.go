@@ -11,6 +11,7 @@ import (
 	"net"
 	"net/rpc"
 
+	"github.com/sylabs/singularity/internal/pkg/audit"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engine/singularity/rpc/client"
 	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engine/singularity/config"
 )
@@ -32,6 +33,13 @@ func (e *EngineOperations) CreateContainer(ctx context.Context, pid int, rpcConn
 		return fmt.Errorf("engineName configuration doesn't match runtime name")
 	}
 
+	// write the audit records of stage 1 which can't
+	// write them in the setuid workflow
+	if err := audit.SetDestination(e.EngineConfig.GetAuditLog()); err != nil {
+		return fmt.Errorf("while setting audit log: %s", err)
+	}
+	audit.Write(e.EngineConfig.GetAuditRecords())
+
 	if e.EngineConfig.GetInstanceJoin() {
 		return nil
 	}
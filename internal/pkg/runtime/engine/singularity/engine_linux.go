@@ -10,6 +10,15 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/runtime/engine/singularity/rpc/server"
 	"github.com/sylabs/singularity/pkg/runtime/engine/config"
 	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engine/singularity/config"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+var (
+	// runtimeLog is the sub logger used by the engine.
+	runtimeLog = sylog.NewSubLogger("runtime")
+	// networkLog is the sub logger used for the container
+	// network setup.
+	networkLog = sylog.NewSubLogger("network")
 )
 
 // EngineOperations is a Singularity runtime engine that implements engine.Operations.
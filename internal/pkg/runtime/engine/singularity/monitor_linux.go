@@ -9,11 +9,18 @@ import (
 	"fmt"
 	"os"
 	"syscall"
+	"time"
 
 	"github.com/sylabs/singularity/internal/pkg/plugin"
 	singularitycallback "github.com/sylabs/singularity/pkg/plugin/callback/runtime/engine/singularity"
+	"golang.org/x/crypto/ssh/terminal"
+	"golang.org/x/sys/unix"
 )
 
+// TimeoutExitCode is the exit code of the container process killed by the
+// engine after the --timeout deadline, as with the timeout command.
+const TimeoutExitCode = 124
+
 // MonitorContainer is called from master once the container has
 // been spawned. It will block until the container exists.
 //
@@ -38,15 +45,54 @@ func (e *EngineOperations) MonitorContainer(pid int, signals chan os.Signal) (sy
 		return callbacks[0].(singularitycallback.MonitorContainer)(e.CommonConfig, pid, signals)
 	}
 
+	// timeout fires once the container process runs past the deadline
+	// set by --timeout, and again at the end of the grace period
+	var timeout <-chan time.Time
+	var ttyState *terminal.State
+	timedOut := false
+	if d := e.EngineConfig.GetTimeout(); d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+		// the terminal isn't restored by the processes killed
+		ttyState, _ = terminal.GetState(int(os.Stdin.Fd()))
+	}
+
 	for {
-		s := <-signals
+		var s os.Signal
+
+		select {
+		case s = <-signals:
+		case <-timeout:
+			// SIGTERM is sent at the deadline, then SIGKILL at the
+			// end of the grace period
+			sig := syscall.SIGKILL
+			timeout = nil
+			if grace := e.EngineConfig.GetTimeoutGrace(); !timedOut && grace > 0 {
+				sig = syscall.SIGTERM
+				timeout = time.After(grace)
+			}
+			timedOut = true
+			runtimeLog.Warningf("Container process timed out after %s, sending %s", e.EngineConfig.GetTimeout(), unix.SignalName(sig))
+			syscall.Kill(pid, sig)
+			continue
+		}
+
 		switch s {
 		case syscall.SIGCHLD:
+			// FUSE drivers run from host are children too
+			e.reapFuseDrivers()
 			if wpid, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err != nil {
 				return status, fmt.Errorf("error while waiting child: %s", err)
 			} else if wpid != pid {
 				continue
 			}
+			if timedOut {
+				if ttyState != nil {
+					terminal.Restore(int(os.Stdin.Fd()), ttyState)
+				}
+				return syscall.WaitStatus(TimeoutExitCode << 8), nil
+			}
 			return status, nil
 		case syscall.SIGURG:
 			// Ignore SIGURG, which is used for non-cooperative goroutine
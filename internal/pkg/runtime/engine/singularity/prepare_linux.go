@@ -19,6 +19,7 @@ import (
 
 	"github.com/containerd/cgroups"
 	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/audit"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	fakerootutil "github.com/sylabs/singularity/internal/pkg/fakeroot"
 	"github.com/sylabs/singularity/internal/pkg/instance"
@@ -26,6 +27,7 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/runtime/engine/config/starter"
 	"github.com/sylabs/singularity/internal/pkg/security"
 	"github.com/sylabs/singularity/internal/pkg/security/seccomp"
+	"github.com/sylabs/singularity/internal/pkg/sigpolicy"
 	"github.com/sylabs/singularity/internal/pkg/syecl"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
 	"github.com/sylabs/singularity/internal/pkg/util/fs/overlay"
@@ -41,6 +43,7 @@ import (
 	"github.com/sylabs/singularity/pkg/util/fs/proc"
 	"github.com/sylabs/singularity/pkg/util/namespaces"
 	"github.com/sylabs/singularity/pkg/util/singularityconf"
+	"golang.org/x/crypto/openpgp"
 	"golang.org/x/sys/unix"
 )
 
@@ -64,6 +67,9 @@ var nsProcName = map[specs.LinuxNamespaceType]string{
 func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 	var err error
 
+	// written back on failure too to record denied requests
+	defer e.setAuditRecords()
+
 	if e.CommonConfig.EngineName != singularityConfig.Name {
 		return fmt.Errorf("incorrect engine")
 	}
@@ -85,11 +91,18 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 		return fmt.Errorf("unable to parse singularity.conf file: %s", err)
 	}
 
+	if err := audit.SetDestination(e.EngineConfig.File.AuditLog); err != nil {
+		return fmt.Errorf("while setting audit log: %s", err)
+	}
+
 	if !e.EngineConfig.File.AllowSetuid && starterConfig.GetIsSUID() {
+		audit.Record(audit.Starter, audit.Denied, "engine", e.CommonConfig.EngineName, "image", e.EngineConfig.GetImage())
 		return fmt.Errorf("suid workflow disabled by administrator")
 	}
 
 	if starterConfig.GetIsSUID() {
+		audit.Record(audit.Starter, audit.Allowed, "engine", e.CommonConfig.EngineName, "image", e.EngineConfig.GetImage())
+
 		// check for ownership of singularity.conf
 		if !fs.IsOwner(configurationFile, 0) {
 			return fmt.Errorf("%s must be owned by root", configurationFile)
@@ -109,7 +122,7 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 		if pwd, err := os.Getwd(); err == nil {
 			e.EngineConfig.SetCwd(pwd)
 		} else {
-			sylog.Warningf("can't determine current working directory")
+			runtimeLog.Warningf("can't determine current working directory")
 			e.EngineConfig.SetCwd("/")
 		}
 	}
@@ -133,6 +146,10 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 		e.EngineConfig.OciConfig.SetProcessNoNewPrivileges(true)
 	}
 
+	if err := e.prepareGroups(starterConfig); err != nil {
+		return err
+	}
+
 	if e.EngineConfig.GetInstanceJoin() {
 		if err := e.prepareInstanceJoinConfig(starterConfig); err != nil {
 			return err
@@ -141,6 +158,16 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 		if err := e.prepareContainerConfig(starterConfig); err != nil {
 			return err
 		}
+		if err := e.checkCommit(starterConfig.GetIsSUID()); err != nil {
+			return err
+		}
+		if e.EngineConfig.GetCommitDir() != "" {
+			for _, fd := range e.EngineConfig.GetCommitFds() {
+				if err := starterConfig.KeepFileDescriptor(fd); err != nil {
+					return err
+				}
+			}
+		}
 		if err := e.loadImages(starterConfig); err != nil {
 			return err
 		}
@@ -190,6 +217,125 @@ func (e *EngineOperations) PrepareConfig(starterConfig *starter.Config) error {
 	return nil
 }
 
+// setAuditRecords sets the audit log and the records of stage 1 which
+// are written by the master process or by starter on failure.
+func (e *EngineOperations) setAuditRecords() {
+	auditLog := ""
+	if e.EngineConfig.File != nil {
+		auditLog = e.EngineConfig.File.AuditLog
+	}
+	// records are always overwritten to not let users forge
+	// them through the engine configuration
+	e.EngineConfig.SetAuditLog(auditLog)
+	e.EngineConfig.SetAuditRecords(audit.Pending())
+}
+
+// PrepareConfigFailure is called from starter when PrepareConfig
+// failed to write the audit records of stage 1, like the denied
+// images.
+//
+// Additional privileges may be gained when running in suid flow.
+func (e *EngineOperations) PrepareConfigFailure() error {
+	if err := audit.SetDestination(e.EngineConfig.GetAuditLog()); err != nil {
+		return fmt.Errorf("while setting audit log: %s", err)
+	}
+	audit.Write(e.EngineConfig.GetAuditRecords())
+	return nil
+}
+
+// checkCommit checks the squashfs overlay image path of --commit and opens
+// the temporary directory holding the writable tmpfs layers. As overlay
+// copy up runs with the credentials of the mounter and preserves the file
+// owners and modes, users can't commit with the setuid workflow. The
+// directory is given by the user, its upper and work directories are
+// opened once checked and mounted by file descriptor.
+func (e *EngineOperations) checkCommit(suid bool) error {
+	image := e.EngineConfig.GetCommitImage()
+	dir := e.EngineConfig.GetCommitDir()
+	if image == "" && dir == "" {
+		return nil
+	} else if image == "" || dir == "" {
+		return fmt.Errorf("commit image and directory must be both set")
+	}
+
+	if suid && os.Getuid() != 0 && !e.EngineConfig.GetFakeroot() {
+		return fmt.Errorf("only root user can commit the writable tmpfs with the setuid workflow, use --fakeroot or --userns")
+	}
+
+	if !filepath.IsAbs(image) || filepath.Clean(image) != image {
+		return fmt.Errorf("commit image %s must be an absolute path", image)
+	}
+	if _, err := os.Lstat(image); err == nil {
+		return fmt.Errorf("commit image %s already exists", image)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("while checking commit image %s: %s", image, err)
+	}
+	if !fs.IsDir(filepath.Dir(image)) {
+		return fmt.Errorf("directory %s doesn't exist", filepath.Dir(image))
+	}
+
+	if !filepath.IsAbs(dir) || filepath.Clean(dir) != dir || !strings.HasPrefix(filepath.Base(dir), singularityConfig.CommitDirPrefix) {
+		return fmt.Errorf("%s is not a commit temporary directory", dir)
+	}
+	dirFd, err := openCommitDir(unix.AT_FDCWD, dir, 2)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dirFd)
+
+	var fds [2]int
+	for i, name := range []string{"upper", "work"} {
+		fd, err := openCommitDir(dirFd, name, 0)
+		if err != nil {
+			if i > 0 {
+				unix.Close(fds[0])
+			}
+			return err
+		}
+		fds[i] = fd
+	}
+	e.EngineConfig.SetCommitFds(fds)
+	return nil
+}
+
+// openCommitDir opens the directory path of the commit directory relative
+// to the directory dirFd without following symbolic links, and checks that
+// it's owned by the user and has the expected number of entries.
+func openCommitDir(dirFd int, path string, entries int) (int, error) {
+	fd, err := unix.Openat(dirFd, path, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("while opening commit directory %s: %s", path, err)
+	}
+
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("while checking commit directory %s: %s", path, err)
+	} else if int(st.Uid) != os.Getuid() {
+		unix.Close(fd)
+		return -1, fmt.Errorf("%s must be owned by user", path)
+	}
+
+	// the directory entries are read from a duplicate, the
+	// file would close the descriptor
+	dup, err := unix.Dup(fd)
+	if err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("while reading commit directory %s: %s", path, err)
+	}
+	f := os.NewFile(uintptr(dup), path)
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		unix.Close(fd)
+		return -1, fmt.Errorf("while reading commit directory %s: %s", path, err)
+	} else if len(names) != entries {
+		unix.Close(fd)
+		return -1, fmt.Errorf("commit directory must only contain empty upper and work directories")
+	}
+	return fd, nil
+}
+
 // prepareUserCaps is responsible for checking that user's requested
 // capabilities are authorized.
 func (e *EngineOperations) prepareUserCaps(enforced bool) error {
@@ -216,14 +362,14 @@ func (e *EngineOperations) prepareUserCaps(enforced bool) error {
 
 	caps, ignoredCaps := capabilities.Split(e.EngineConfig.GetAddCaps())
 	if len(ignoredCaps) > 0 {
-		sylog.Warningf("won't add unknown capability: %s", strings.Join(ignoredCaps, ","))
+		runtimeLog.Warningf("won't add unknown capability: %s", strings.Join(ignoredCaps, ","))
 	}
 	caps = append(caps, e.EngineConfig.OciConfig.Process.Capabilities.Permitted...)
 
 	if enforced {
 		authorizedCaps, unauthorizedCaps := capConfig.CheckUserCaps(pw.Name, caps)
 		if len(authorizedCaps) > 0 {
-			sylog.Debugf("User capabilities %s added", strings.Join(authorizedCaps, ","))
+			runtimeLog.Debugf("User capabilities %s added", strings.Join(authorizedCaps, ","))
 			commonCaps = authorizedCaps
 		}
 		if len(unauthorizedCaps) > 0 {
@@ -238,12 +384,12 @@ func (e *EngineOperations) prepareUserCaps(enforced bool) error {
 		for _, g := range groups {
 			gr, err := user.GetGrGID(uint32(g))
 			if err != nil {
-				sylog.Debugf("Ignoring group %d: %s", g, err)
+				runtimeLog.Debugf("Ignoring group %d: %s", g, err)
 				continue
 			}
 			authorizedCaps, unauthorizedCaps := capConfig.CheckGroupCaps(gr.Name, caps)
 			if len(authorizedCaps) > 0 {
-				sylog.Debugf("%s group capabilities %s added", gr.Name, strings.Join(authorizedCaps, ","))
+				runtimeLog.Debugf("%s group capabilities %s added", gr.Name, strings.Join(authorizedCaps, ","))
 				commonCaps = append(commonCaps, authorizedCaps...)
 			}
 			if len(unauthorizedCaps) > 0 {
@@ -268,17 +414,17 @@ func (e *EngineOperations) prepareUserCaps(enforced bool) error {
 		}
 	}
 	if len(commonUnauthorizedCaps) > 0 {
-		sylog.Warningf("not authorized to add capability: %s", strings.Join(commonUnauthorizedCaps, ","))
+		runtimeLog.Warningf("not authorized to add capability: %s", strings.Join(commonUnauthorizedCaps, ","))
 	}
 
 	caps, ignoredCaps = capabilities.Split(e.EngineConfig.GetDropCaps())
 	if len(ignoredCaps) > 0 {
-		sylog.Warningf("won't drop unknown capability: %s", strings.Join(ignoredCaps, ","))
+		runtimeLog.Warningf("won't drop unknown capability: %s", strings.Join(ignoredCaps, ","))
 	}
 	for _, cap := range caps {
 		for i, c := range commonCaps {
 			if c == cap {
-				sylog.Debugf("Capability %s dropped", cap)
+				runtimeLog.Debugf("Capability %s dropped", cap)
 				commonCaps = append(commonCaps[:i], commonCaps[i+1:]...)
 				break
 			}
@@ -294,6 +440,76 @@ func (e *EngineOperations) prepareUserCaps(enforced bool) error {
 	return nil
 }
 
+// prepareGroups sets the supplementary groups of the container process
+// with the groups added by --groups, the current groups or the target
+// groups are dropped with --no-supplementary-groups. Users can only add
+// their own groups, which requires the setuid workflow, and can't drop
+// any of them as groups may also deny access to files.
+func (e *EngineOperations) prepareGroups(starterConfig *starter.Config) error {
+	add := e.EngineConfig.GetAddGroups()
+	if len(add) == 0 && !e.EngineConfig.GetNoSupplementary() {
+		return nil
+	}
+	if e.EngineConfig.GetFakeroot() {
+		return fmt.Errorf("supplementary groups can't be set with fakeroot")
+	}
+	if e.EngineConfig.GetNoSupplementary() && os.Getuid() != 0 {
+		return fmt.Errorf("supplementary groups can only be dropped by root")
+	}
+
+	current, err := os.Getgroups()
+	if err != nil {
+		return fmt.Errorf("while getting groups: %s", err)
+	}
+	gids := e.EngineConfig.GetTargetGID()
+	if len(gids) == 0 {
+		gids = append([]int{os.Getgid()}, current...)
+	}
+	if e.EngineConfig.GetNoSupplementary() {
+		gids = gids[:1]
+	}
+
+	seen := make(map[int]bool)
+	groups := make([]int, 0, len(gids)+len(add))
+	for _, gid := range append(gids, add...) {
+		if !seen[gid] {
+			seen[gid] = true
+			groups = append(groups, gid)
+		}
+	}
+
+	if os.Getuid() != 0 {
+		if !starterConfig.GetIsSUID() {
+			return fmt.Errorf("supplementary groups can only be set by users with the setuid workflow")
+		}
+		if err := checkGroupsMember(groups, os.Getgid(), current); err != nil {
+			return err
+		}
+		starterConfig.SetAllowSetgroups(true)
+	} else {
+		starterConfig.SetTargetUID(e.EngineConfig.GetTargetUID())
+	}
+
+	runtimeLog.Debugf("Container process groups %v", groups)
+	starterConfig.SetTargetGID(groups)
+	return nil
+}
+
+// checkGroupsMember checks that a user with the primary group gid and
+// the supplementary groups current is a member of all the groups.
+func checkGroupsMember(groups []int, gid int, current []int) error {
+	member := map[int]bool{gid: true}
+	for _, g := range current {
+		member[g] = true
+	}
+	for _, g := range groups {
+		if !member[g] {
+			return fmt.Errorf("user is not a member of group %d", g)
+		}
+	}
+	return nil
+}
+
 // prepareRootCaps is responsible for setting root capabilities
 // based on capability/configuration files and requested capabilities.
 func (e *EngineOperations) prepareRootCaps() error {
@@ -303,20 +519,21 @@ func (e *EngineOperations) prepareRootCaps() error {
 	uid := e.EngineConfig.GetTargetUID()
 	gids := e.EngineConfig.GetTargetGID()
 
-	if uid != 0 || len(gids) > 0 {
+	// the root user of the container keeps the default capabilities
+	if uid != 0 || (len(gids) > 0 && !e.EngineConfig.GetContainerUser()) {
 		defaultCapabilities = "no"
 	}
 
 	// is no-privs/keep-privs set on command line
 	if e.EngineConfig.GetNoPrivs() {
-		sylog.Debugf("--no-privs requested, no new privileges enabled")
+		runtimeLog.Debugf("--no-privs requested, no new privileges enabled")
 		defaultCapabilities = "no"
 	} else if e.EngineConfig.GetKeepPrivs() {
-		sylog.Debugf("--keep-privs requested")
+		runtimeLog.Debugf("--keep-privs requested")
 		defaultCapabilities = "full"
 	}
 
-	sylog.Debugf("Root %s capabilities", defaultCapabilities)
+	runtimeLog.Debugf("Root %s capabilities", defaultCapabilities)
 
 	// set default capabilities based on configuration file directive
 	switch defaultCapabilities {
@@ -345,12 +562,12 @@ func (e *EngineOperations) prepareRootCaps() error {
 		for _, g := range groups {
 			gr, err := user.GetGrGID(uint32(g))
 			if err != nil {
-				sylog.Debugf("Ignoring group %d: %s", g, err)
+				runtimeLog.Debugf("Ignoring group %d: %s", g, err)
 				continue
 			}
 			caps := capConfig.ListGroupCaps(gr.Name)
 			commonCaps = append(commonCaps, caps...)
-			sylog.Debugf("%s group capabilities %s added", gr.Name, strings.Join(caps, ","))
+			runtimeLog.Debugf("%s group capabilities %s added", gr.Name, strings.Join(caps, ","))
 		}
 	default:
 		e.EngineConfig.OciConfig.SetProcessNoNewPrivileges(true)
@@ -358,7 +575,7 @@ func (e *EngineOperations) prepareRootCaps() error {
 
 	caps, ignoredCaps := capabilities.Split(e.EngineConfig.GetAddCaps())
 	if len(ignoredCaps) > 0 {
-		sylog.Warningf("won't add unknown capability: %s", strings.Join(ignoredCaps, ","))
+		runtimeLog.Warningf("won't add unknown capability: %s", strings.Join(ignoredCaps, ","))
 	}
 	for _, cap := range caps {
 		found := false
@@ -369,7 +586,7 @@ func (e *EngineOperations) prepareRootCaps() error {
 			}
 		}
 		if !found {
-			sylog.Debugf("Root capability %s added", cap)
+			runtimeLog.Debugf("Root capability %s added", cap)
 			commonCaps = append(commonCaps, cap)
 		}
 	}
@@ -378,12 +595,12 @@ func (e *EngineOperations) prepareRootCaps() error {
 
 	caps, ignoredCaps = capabilities.Split(e.EngineConfig.GetDropCaps())
 	if len(ignoredCaps) > 0 {
-		sylog.Warningf("won't add unknown capability: %s", strings.Join(ignoredCaps, ","))
+		runtimeLog.Warningf("won't add unknown capability: %s", strings.Join(ignoredCaps, ","))
 	}
 	for _, cap := range caps {
 		for i, c := range commonCaps {
 			if c == cap {
-				sylog.Debugf("Root capability %s dropped", cap)
+				runtimeLog.Debugf("Root capability %s dropped", cap)
 				commonCaps = append(commonCaps[:i], commonCaps[i+1:]...)
 				break
 			}
@@ -407,7 +624,7 @@ func keepAutofsMount(source string, autoFsPoints []string) (int, error) {
 
 	for _, p := range autoFsPoints {
 		if strings.HasPrefix(resolved, p) {
-			sylog.Debugf("Open file descriptor for %s", resolved)
+			runtimeLog.Debugf("Open file descriptor for %s", resolved)
 			f, err := os.Open(resolved)
 			if err != nil {
 				return -1, err
@@ -429,12 +646,12 @@ func (e *EngineOperations) prepareAutofs(starterConfig *starter.Config) error {
 	autoFsPoints := make([]string, 0)
 	for _, e := range entries {
 		if e.FSType == "autofs" {
-			sylog.Debugf("Found %q as autofs mount point", e.Point)
+			runtimeLog.Debugf("Found %q as autofs mount point", e.Point)
 			autoFsPoints = append(autoFsPoints, e.Point)
 		}
 	}
 	if len(autoFsPoints) == 0 {
-		sylog.Debugf("No autofs mount point found")
+		runtimeLog.Debugf("No autofs mount point found")
 		return nil
 	}
 
@@ -444,7 +661,7 @@ func (e *EngineOperations) prepareAutofs(starterConfig *starter.Config) error {
 		for _, b := range e.EngineConfig.GetBindPath() {
 			fd, err := keepAutofsMount(b.Source, autoFsPoints)
 			if err != nil {
-				sylog.Debugf("Could not keep file descriptor for user bind path %s: %s", b.Source, err)
+				runtimeLog.Debugf("Could not keep file descriptor for user bind path %s: %s", b.Source, err)
 				continue
 			}
 			fds = append(fds, fd)
@@ -457,7 +674,7 @@ func (e *EngineOperations) prepareAutofs(starterConfig *starter.Config) error {
 
 			fd, err := keepAutofsMount(splitted[0], autoFsPoints)
 			if err != nil {
-				sylog.Debugf("Could not keep file descriptor for bind path %s: %s", splitted[0], err)
+				runtimeLog.Debugf("Could not keep file descriptor for bind path %s: %s", splitted[0], err)
 				continue
 			}
 			fds = append(fds, fd)
@@ -467,7 +684,7 @@ func (e *EngineOperations) prepareAutofs(starterConfig *starter.Config) error {
 		dir := e.EngineConfig.GetHomeSource()
 		fd, err := keepAutofsMount(dir, autoFsPoints)
 		if err != nil {
-			sylog.Debugf("Could not keep file descriptor for home directory %s: %s", dir, err)
+			runtimeLog.Debugf("Could not keep file descriptor for home directory %s: %s", dir, err)
 		} else {
 			fds = append(fds, fd)
 		}
@@ -476,7 +693,7 @@ func (e *EngineOperations) prepareAutofs(starterConfig *starter.Config) error {
 		dir = e.EngineConfig.GetCwd()
 		fd, err = keepAutofsMount(dir, autoFsPoints)
 		if err != nil {
-			sylog.Debugf("Could not keep file descriptor for current working directory %s: %s", dir, err)
+			runtimeLog.Debugf("Could not keep file descriptor for current working directory %s: %s", dir, err)
 		} else {
 			fds = append(fds, fd)
 		}
@@ -485,7 +702,7 @@ func (e *EngineOperations) prepareAutofs(starterConfig *starter.Config) error {
 		dir := e.EngineConfig.GetWorkdir()
 		fd, err := keepAutofsMount(dir, autoFsPoints)
 		if err != nil {
-			sylog.Debugf("Could not keep file descriptor for workdir %s: %s", dir, err)
+			runtimeLog.Debugf("Could not keep file descriptor for workdir %s: %s", dir, err)
 		} else {
 			fds = append(fds, fd)
 		}
@@ -513,7 +730,7 @@ func (e *EngineOperations) prepareContainerConfig(starterConfig *starter.Config)
 		namespaces := e.EngineConfig.OciConfig.Linux.Namespaces
 		for i, ns := range namespaces {
 			if ns.Type == specs.PIDNamespace {
-				sylog.Debugf("Not virtualizing PID namespace by configuration")
+				runtimeLog.Debugf("Not virtualizing PID namespace by configuration")
 				e.EngineConfig.OciConfig.Linux.Namespaces = append(namespaces[:i], namespaces[i+1:]...)
 				break
 			}
@@ -531,6 +748,13 @@ func (e *EngineOperations) prepareContainerConfig(starterConfig *starter.Config)
 		}
 	}
 
+	// nvidia-container-cli writes to the container root filesystem and
+	// runs ldconfig in it, it's never run with escalated privileges for
+	// a root filesystem controlled by the user
+	if e.EngineConfig.GetNvCCLI() && starterConfig.GetIsSUID() && os.Getuid() != 0 && !e.EngineConfig.GetFakeroot() {
+		return fmt.Errorf("--nvccli is not supported with the setuid workflow, use --fakeroot or --userns")
+	}
+
 	if e.EngineConfig.File.MountSlave {
 		starterConfig.SetMountPropagation("rslave")
 	} else {
@@ -540,12 +764,12 @@ func (e *EngineOperations) prepareContainerConfig(starterConfig *starter.Config)
 	if e.EngineConfig.GetFakeroot() {
 		if !starterConfig.GetIsSUID() {
 			// no SUID workflow, check if newuidmap/newgidmap are present
-			sylog.Verbosef("Fakeroot requested with unprivileged workflow, fallback to newuidmap/newgidmap")
-			sylog.Debugf("Search for newuidmap binary")
+			runtimeLog.Verbosef("Fakeroot requested with unprivileged workflow, fallback to newuidmap/newgidmap")
+			runtimeLog.Debugf("Search for newuidmap binary")
 			if err := starterConfig.SetNewUIDMapPath(); err != nil {
 				return err
 			}
-			sylog.Debugf("Search for newgidmap binary")
+			runtimeLog.Debugf("Search for newgidmap binary")
 			if err := starterConfig.SetNewGIDMapPath(); err != nil {
 				return err
 			}
@@ -574,6 +798,9 @@ func (e *EngineOperations) prepareContainerConfig(starterConfig *starter.Config)
 		}
 		e.EngineConfig.OciConfig.AddLinuxUIDMapping(idRange.HostID, idRange.ContainerID, idRange.Size)
 		starterConfig.AddUIDMappings(e.EngineConfig.OciConfig.Linux.UIDMappings)
+		maxUID := idRange.ContainerID + idRange.Size
+
+		audit.Record(audit.Fakeroot, audit.Allowed, "engine", e.CommonConfig.EngineName, "subuid", fmt.Sprint(idRange.HostID), "image", e.EngineConfig.GetImage())
 
 		e.EngineConfig.OciConfig.AddLinuxGIDMapping(gid, 0, 1)
 		idRange, err = getIDRange(fakerootutil.SubGIDFile, uid)
@@ -582,22 +809,49 @@ func (e *EngineOperations) prepareContainerConfig(starterConfig *starter.Config)
 		}
 		e.EngineConfig.OciConfig.AddLinuxGIDMapping(idRange.HostID, idRange.ContainerID, idRange.Size)
 		starterConfig.AddGIDMappings(e.EngineConfig.OciConfig.Linux.GIDMappings)
+		maxGID := idRange.ContainerID + idRange.Size
+
+		// the user of the container runs with the IDs of the
+		// subordinate ranges, without capabilities
+		targetUID := 0
+		targetGIDs := []int{0}
+		if e.EngineConfig.GetContainerUser() {
+			targetUID = e.EngineConfig.GetTargetUID()
+			targetGIDs = e.EngineConfig.GetTargetGID()
+			if uint32(targetUID) >= maxUID {
+				return fmt.Errorf("user ID %d is not mapped in the fakeroot user namespace", targetUID)
+			}
+			for _, g := range targetGIDs {
+				if uint32(g) >= maxGID {
+					return fmt.Errorf("group ID %d is not mapped in the fakeroot user namespace", g)
+				}
+			}
+		}
 
-		e.EngineConfig.OciConfig.SetupPrivileged(true)
+		if targetUID == 0 {
+			e.EngineConfig.OciConfig.SetupPrivileged(true)
+		}
 
 		e.EngineConfig.OciConfig.AddOrReplaceLinuxNamespace(specs.UserNamespace, "")
 
 		starterConfig.SetHybridWorkflow(true)
 		starterConfig.SetAllowSetgroups(true)
 
-		starterConfig.SetTargetUID(0)
-		starterConfig.SetTargetGID([]int{0})
+		starterConfig.SetTargetUID(targetUID)
+		starterConfig.SetTargetGID(targetGIDs)
 	}
 
 	starterConfig.SetBringLoopbackInterface(true)
 
 	starterConfig.SetInstance(e.EngineConfig.GetInstance())
 
+	if err := e.prepareNetworkContainer(starterConfig); err != nil {
+		return err
+	}
+	if err := e.prepareJoinNamespaces(starterConfig); err != nil {
+		return err
+	}
+
 	starterConfig.SetNsFlagsFromSpec(e.EngineConfig.OciConfig.Linux.Namespaces)
 
 	// user namespace ID mappings
@@ -612,17 +866,17 @@ func (e *EngineOperations) prepareContainerConfig(starterConfig *starter.Config)
 
 	param := security.GetParam(e.EngineConfig.GetSecurity(), "selinux")
 	if param != "" {
-		sylog.Debugf("Applying SELinux context %s", param)
+		runtimeLog.Debugf("Applying SELinux context %s", param)
 		e.EngineConfig.OciConfig.SetProcessSelinuxLabel(param)
 	}
 	param = security.GetParam(e.EngineConfig.GetSecurity(), "apparmor")
 	if param != "" {
-		sylog.Debugf("Applying Apparmor profile %s", param)
+		runtimeLog.Debugf("Applying Apparmor profile %s", param)
 		e.EngineConfig.OciConfig.SetProcessApparmorProfile(param)
 	}
 	param = security.GetParam(e.EngineConfig.GetSecurity(), "seccomp")
 	if param != "" {
-		sylog.Debugf("Applying seccomp rule from %s", param)
+		runtimeLog.Debugf("Applying seccomp rule from %s", param)
 		generator := &e.EngineConfig.OciConfig.Generator
 		if err := seccomp.LoadProfileFromFile(param, generator); err != nil {
 			return err
@@ -633,6 +887,331 @@ func (e *EngineOperations) prepareContainerConfig(starterConfig *starter.Config)
 	return e.prepareAutofs(starterConfig)
 }
 
+// prepareNetworkContainer sets the network namespace of the instance
+// selected with the 'container:<name>' network to be joined by starter
+// in place of a new network namespace, the instance networks are shared
+// and no CNI network is set up for the container.
+func (e *EngineOperations) prepareNetworkContainer(starterConfig *starter.Config) error {
+	name := e.EngineConfig.GetNetworkContainer()
+	if name == "" {
+		return nil
+	}
+
+	file, err := instance.Get(name, instance.SingSubDir)
+	if err != nil {
+		return fmt.Errorf("could not find instance %s: %s", name, err)
+	}
+
+	uid := os.Getuid()
+	gid := os.Getgid()
+
+	// a network namespace is owned by the user namespace where it was
+	// created, joining it from another user namespace is not permitted
+	userNS := false
+	for _, ns := range e.EngineConfig.OciConfig.Linux.Namespaces {
+		if ns.Type == specs.UserNamespace {
+			userNS = true
+		}
+	}
+	if file.UserNs || userNS {
+		return fmt.Errorf("sharing the network of instance %s is not supported with user namespace", name)
+	} else if e.EngineConfig.GetJoinPid() != 0 && joinsNamespace(e.EngineConfig.GetJoinNamespaces(), specs.NetworkNamespace) {
+		return fmt.Errorf("sharing the network of instance %s is not supported when joining a network namespace with --join", name)
+	} else if uid != 0 && !starterConfig.GetIsSUID() {
+		return fmt.Errorf("a setuid installation is required to share the network of instance %s", name)
+	}
+
+	if file.Pid <= 1 || file.PPid <= 1 {
+		return fmt.Errorf("bad instance process ID found")
+	}
+
+	instanceEngineConfig := singularityConfig.NewConfig()
+	instanceConfig := &config.Common{
+		EngineConfig: instanceEngineConfig,
+	}
+	if err := json.Unmarshal(file.Config, instanceConfig); err != nil {
+		return err
+	}
+	hasNetNS := false
+	if instanceEngineConfig.OciConfig.Linux != nil {
+		for _, ns := range instanceEngineConfig.OciConfig.Linux.Namespaces {
+			if ns.Type == specs.NetworkNamespace {
+				hasNetNS = true
+			}
+		}
+	}
+	if !hasNetNS {
+		return fmt.Errorf("instance %s has no network namespace, it must be started with --net", name)
+	}
+
+	// the instance file content can't be trusted with the setuid
+	// workflow where starter joins the namespace with privileges,
+	// the namespace is opened relative to the /proc directory of the
+	// checked process
+	fd, dir, err := openProc(file.Pid, func(dir string) error {
+		if uid == 0 {
+			return nil
+		}
+		return checkInstanceProcess(dir, file, uid, gid)
+	})
+	if err != nil {
+		return err
+	}
+	if err := starterConfig.KeepFileDescriptor(fd); err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, "ns", nsProcName[specs.NetworkNamespace])
+	runtimeLog.Debugf("Sharing network namespace of instance %s", name)
+	e.EngineConfig.OciConfig.AddOrReplaceLinuxNamespace(specs.NetworkNamespace, path)
+
+	return starterConfig.SetNsPath(specs.NetworkNamespace, path)
+}
+
+// joinableNamespaces are the namespaces of a process which can be joined
+// with --join, the container keeps its own mount namespace and root
+// filesystem.
+var joinableNamespaces = []specs.LinuxNamespaceType{
+	specs.NetworkNamespace,
+	specs.IPCNamespace,
+	specs.UTSNamespace,
+	specs.PIDNamespace,
+}
+
+// prepareJoinNamespaces sets the namespaces of the process selected with
+// --join to be joined by starter in place of new namespaces.
+func (e *EngineOperations) prepareJoinNamespaces(starterConfig *starter.Config) error {
+	pid := e.EngineConfig.GetJoinPid()
+	if pid == 0 {
+		return nil
+	} else if pid <= 1 {
+		return fmt.Errorf("joining the namespaces of process %d is not allowed", pid)
+	}
+
+	uid := os.Getuid()
+	gid := os.Getgid()
+
+	for _, ns := range e.EngineConfig.OciConfig.Linux.Namespaces {
+		if ns.Type == specs.UserNamespace {
+			return fmt.Errorf("joining the namespaces of process %d is not supported with user namespace", pid)
+		}
+	}
+
+	if uid != 0 && !starterConfig.GetIsSUID() {
+		return fmt.Errorf("a setuid installation is required to join the namespaces of process %d", pid)
+	}
+
+	// the process is checked and its namespaces are opened relative
+	// to its /proc directory, a process reusing the same ID can't be
+	// joined in place of the checked one
+	fd, dir, err := openProc(pid, func(dir string) error {
+		if uid == 0 {
+			return nil
+		}
+		// starter joins the namespaces with privileges, users
+		// can only join those of their own processes
+		return checkProcessOwner(dir, uid, gid)
+	})
+	if err != nil {
+		return err
+	}
+	if err := starterConfig.KeepFileDescriptor(fd); err != nil {
+		return err
+	}
+
+	for _, name := range e.EngineConfig.GetJoinNamespaces() {
+		var nstype specs.LinuxNamespaceType
+		for _, t := range joinableNamespaces {
+			if nsProcName[t] == name {
+				nstype = t
+			}
+		}
+		if nstype == "" {
+			return fmt.Errorf("joining the %s namespace of process %d is not supported", name, pid)
+		}
+		if nstype == specs.PIDNamespace && !e.EngineConfig.File.AllowPidNs {
+			return fmt.Errorf("joining the pid namespace of process %d is not allowed by configuration", pid)
+		}
+
+		path := filepath.Join(dir, "ns", name)
+		runtimeLog.Debugf("Joining %s namespace of process %d", name, pid)
+		e.EngineConfig.OciConfig.AddOrReplaceLinuxNamespace(nstype, path)
+		if err := starterConfig.SetNsPath(nstype, path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// joinsNamespace returns true if the namespace nstype is
+// part of the namespaces joined with --join.
+func joinsNamespace(names []string, nstype specs.LinuxNamespaceType) bool {
+	for _, name := range names {
+		if name == nsProcName[nstype] {
+			return true
+		}
+	}
+	return false
+}
+
+// openProc opens the /proc directory of the process pid and calls check
+// with the path of this directory. It returns the directory file descriptor
+// and the path starter uses to open the namespaces of the process, which
+// stays valid as long as the file descriptor is kept open, a process
+// reusing the same ID can't be joined in place of the checked one.
+func openProc(pid int, check func(dir string) error) (int, string, error) {
+	path := filepath.Join("/proc", strconv.Itoa(pid))
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, "", fmt.Errorf("could not open proc directory %s: %s", path, err)
+	}
+
+	dir := fmt.Sprintf("/proc/self/fd/%d", fd)
+	if err := check(dir); err != nil {
+		syscall.Close(fd)
+		return -1, "", err
+	}
+	return fd, dir, nil
+}
+
+// checkProcessOwner checks that the process with the /proc directory dir
+// runs with the user uid:gid IDs outside of a user namespace.
+func checkProcessOwner(dir string, uid, gid int) error {
+	_, hid, err := proc.ReadIDMap(filepath.Join(dir, "uid_map"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read user namespace mapping: %s", err)
+	} else if err == nil && hid > 0 {
+		return fmt.Errorf("joining a process running with user namespace enabled is not supported")
+	}
+
+	f, err := os.Open(filepath.Join(dir, "status"))
+	if err != nil {
+		return fmt.Errorf("could not open status: %s", err)
+	}
+	defer f.Close()
+
+	// the real, effective, saved set and filesystem IDs
+	// must be those of the user
+	var uids, gids [4]int
+	found := 0
+	for s := bufio.NewScanner(f); s.Scan(); {
+		if n, _ := fmt.Sscanf(s.Text(), "Uid:\t%d\t%d\t%d\t%d", &uids[0], &uids[1], &uids[2], &uids[3]); n == 4 {
+			found++
+		} else if n, _ := fmt.Sscanf(s.Text(), "Gid:\t%d\t%d\t%d\t%d", &gids[0], &gids[1], &gids[2], &gids[3]); n == 4 {
+			found++
+		}
+	}
+	if found != 2 {
+		return fmt.Errorf("could not read process IDs from %s", f.Name())
+	}
+	for i := range uids {
+		if uids[i] != uid || gids[i] != gid {
+			return fmt.Errorf("process owned by %d:%d instead of %d:%d", uids[i], gids[i], uid, gid)
+		}
+	}
+	return nil
+}
+
+// checkInstanceProcess checks that the instance process file.Pid, with
+// its /proc directory dir, is the sinit process of an instance of the
+// user uid:gid started without user namespace, as the content of the
+// instance file can't be trusted with the setuid workflow.
+func checkInstanceProcess(dir string, file *instance.File, uid, gid int) error {
+	// check if instance is running with user namespace enabled
+	// by reading /proc/pid/uid_map
+	_, hid, err := proc.ReadIDMap(filepath.Join(dir, "uid_map"))
+
+	// if the error returned is "no such file or directory" it means
+	// that user namespaces are not supported, just skip this check
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read user namespace mapping: %s", err)
+	} else if err == nil && hid > 0 {
+		// a host uid greater than 0 means user namespace is in use for this process
+		return fmt.Errorf("trying to join an instance running with user namespace enabled")
+	}
+
+	// read "/proc/pid/root" link of instance process must return
+	// a permission denied error.
+	// This is the "sinit" process (PID 1 in container) and it inherited
+	// setuid bit, so most of "/proc/pid" entries are owned by root:root
+	// like "/proc/pid/root" link even if the process has dropped all
+	// privileges and run with user UID/GID. So we expect a "permission denied"
+	// error when reading link.
+	if _, err := mainthread.Readlink(filepath.Join(dir, "root")); !os.IsPermission(err) {
+		return fmt.Errorf("trying to join a wrong instance process")
+	}
+	// Since we could be tricked to join namespaces of a root owned process,
+	// we will get UID/GID information of task directory to be sure it belongs
+	// to the user currently joining the instance. Also ensure that a user won't
+	// be able to join other user's instances.
+	fi, err := os.Stat(filepath.Join(dir, "task"))
+	if err != nil {
+		return fmt.Errorf("error while getting information for instance task directory: %s", err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	if st.Uid != uint32(uid) || st.Gid != uint32(gid) {
+		return fmt.Errorf("instance process owned by %d:%d instead of %d:%d", st.Uid, st.Gid, uid, gid)
+	}
+
+	ppid := -1
+
+	// read "/proc/pid/status" to check if instance process
+	// is neither orphaned or faked
+	f, err := os.Open(filepath.Join(dir, "status"))
+	if err != nil {
+		return fmt.Errorf("could not open status: %s", err)
+	}
+
+	for s := bufio.NewScanner(f); s.Scan(); {
+		if n, _ := fmt.Sscanf(s.Text(), "PPid:\t%d", &ppid); n == 1 {
+			break
+		}
+	}
+	f.Close()
+
+	// check that Ppid/Pid read from instance file are "somewhat" valid
+	// processes
+	if ppid <= 1 || ppid != file.PPid {
+		return fmt.Errorf("orphaned (or faked) instance process")
+	}
+
+	// read "/proc/ppid/root" link of parent instance process must return
+	// a permission denied error (same logic than "sinit" process).
+	// Also we don't use absolute path while joining an instance because
+	// we want to return an error if current working directory is deleted
+	// meaning that instance process exited.
+	path := filepath.Join(dir, "..", strconv.Itoa(file.PPid), "root")
+	if _, err := mainthread.Readlink(path); !os.IsPermission(err) {
+		return fmt.Errorf("trying to join a wrong instance process")
+	}
+	// "/proc/ppid/task" directory must be owned by user UID/GID
+	path = filepath.Join(dir, "..", strconv.Itoa(file.PPid), "task")
+	fi, err = os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("error while getting information for parent task directory: %s", err)
+	}
+	st = fi.Sys().(*syscall.Stat_t)
+	if st.Uid != uint32(uid) || st.Gid != uint32(gid) {
+		return fmt.Errorf("parent instance process owned by %d:%d instead of %d:%d", st.Uid, st.Gid, uid, gid)
+	}
+
+	path, err = filepath.Abs(filepath.Join(dir, "comm"))
+	if err != nil {
+		return fmt.Errorf("failed to determine absolute path for comm: %s", err)
+	}
+
+	// we must read "sinit\n"
+	b, err := ioutil.ReadFile(filepath.Join(dir, "comm"))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", path, err)
+	}
+	// check that we are currently joining sinit process
+	if "sinit" != strings.Trim(string(b), "\n") {
+		return fmt.Errorf("sinit not found in %s, wrong instance process", path)
+	}
+	return nil
+}
+
 // prepareInstanceJoinConfig is responsible for getting and
 // applying configuration to join a running instance.
 func (e *EngineOperations) prepareInstanceJoinConfig(starterConfig *starter.Config) error {
@@ -709,97 +1288,8 @@ func (e *EngineOperations) prepareInstanceJoinConfig(starterConfig *starter.Conf
 	// since instance file is stored in user home directory, we can't trust
 	// its content when using SUID workflow
 	if suidRequired {
-		// check if instance is running with user namespace enabled
-		// by reading /proc/pid/uid_map
-		_, hid, err := proc.ReadIDMap("uid_map")
-
-		// if the error returned is "no such file or directory" it means
-		// that user namespaces are not supported, just skip this check
-		if err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("failed to read user namespace mapping: %s", err)
-		} else if err == nil && hid > 0 {
-			// a host uid greater than 0 means user namespace is in use for this process
-			return fmt.Errorf("trying to join an instance running with user namespace enabled")
-		}
-
-		// read "/proc/pid/root" link of instance process must return
-		// a permission denied error.
-		// This is the "sinit" process (PID 1 in container) and it inherited
-		// setuid bit, so most of "/proc/pid" entries are owned by root:root
-		// like "/proc/pid/root" link even if the process has dropped all
-		// privileges and run with user UID/GID. So we expect a "permission denied"
-		// error when reading link.
-		if _, err := mainthread.Readlink("root"); !os.IsPermission(err) {
-			return fmt.Errorf("trying to join a wrong instance process")
-		}
-		// Since we could be tricked to join namespaces of a root owned process,
-		// we will get UID/GID information of task directory to be sure it belongs
-		// to the user currently joining the instance. Also ensure that a user won't
-		// be able to join other user's instances.
-		fi, err := os.Stat("task")
-		if err != nil {
-			return fmt.Errorf("error while getting information for instance task directory: %s", err)
-		}
-		st := fi.Sys().(*syscall.Stat_t)
-		if st.Uid != uint32(uid) || st.Gid != uint32(gid) {
-			return fmt.Errorf("instance process owned by %d:%d instead of %d:%d", st.Uid, st.Gid, uid, gid)
-		}
-
-		ppid := -1
-
-		// read "/proc/pid/status" to check if instance process
-		// is neither orphaned or faked
-		f, err := os.Open("status")
-		if err != nil {
-			return fmt.Errorf("could not open status: %s", err)
-		}
-
-		for s := bufio.NewScanner(f); s.Scan(); {
-			if n, _ := fmt.Sscanf(s.Text(), "PPid:\t%d", &ppid); n == 1 {
-				break
-			}
-		}
-		f.Close()
-
-		// check that Ppid/Pid read from instance file are "somewhat" valid
-		// processes
-		if ppid <= 1 || ppid != file.PPid {
-			return fmt.Errorf("orphaned (or faked) instance process")
-		}
-
-		// read "/proc/ppid/root" link of parent instance process must return
-		// a permission denied error (same logic than "sinit" process).
-		// Also we don't use absolute path because we want to return an error
-		// if current working directory is deleted meaning that instance process
-		// exited.
-		path := filepath.Join("..", strconv.Itoa(file.PPid), "root")
-		if _, err := mainthread.Readlink(path); !os.IsPermission(err) {
-			return fmt.Errorf("trying to join a wrong instance process")
-		}
-		// "/proc/ppid/task" directory must be owned by user UID/GID
-		path = filepath.Join("..", strconv.Itoa(file.PPid), "task")
-		fi, err = os.Stat(path)
-		if err != nil {
-			return fmt.Errorf("error while getting information for parent task directory: %s", err)
-		}
-		st = fi.Sys().(*syscall.Stat_t)
-		if st.Uid != uint32(uid) || st.Gid != uint32(gid) {
-			return fmt.Errorf("parent instance process owned by %d:%d instead of %d:%d", st.Uid, st.Gid, uid, gid)
-		}
-
-		path, err = filepath.Abs("comm")
-		if err != nil {
-			return fmt.Errorf("failed to determine absolute path for comm: %s", err)
-		}
-
-		// we must read "sinit\n"
-		b, err := ioutil.ReadFile("comm")
-		if err != nil {
-			return fmt.Errorf("failed to read %s: %s", path, err)
-		}
-		// check that we are currently joining sinit process
-		if "sinit" != strings.Trim(string(b), "\n") {
-			return fmt.Errorf("sinit not found in %s, wrong instance process", path)
+		if err := checkInstanceProcess(".", file, uid, gid); err != nil {
+			return err
 		}
 	}
 
@@ -860,7 +1350,7 @@ func (e *EngineOperations) prepareInstanceJoinConfig(starterConfig *starter.Conf
 	// restore apparmor profile or apply a new one if provided
 	param := security.GetParam(e.EngineConfig.GetSecurity(), "apparmor")
 	if param != "" {
-		sylog.Debugf("Applying Apparmor profile %s", param)
+		runtimeLog.Debugf("Applying Apparmor profile %s", param)
 		e.EngineConfig.OciConfig.SetProcessApparmorProfile(param)
 	} else {
 		e.EngineConfig.OciConfig.SetProcessApparmorProfile(instanceEngineConfig.OciConfig.Process.ApparmorProfile)
@@ -869,7 +1359,7 @@ func (e *EngineOperations) prepareInstanceJoinConfig(starterConfig *starter.Conf
 	// restore selinux context or apply a new one if provided
 	param = security.GetParam(e.EngineConfig.GetSecurity(), "selinux")
 	if param != "" {
-		sylog.Debugf("Applying SELinux context %s", param)
+		runtimeLog.Debugf("Applying SELinux context %s", param)
 		e.EngineConfig.OciConfig.SetProcessSelinuxLabel(param)
 	} else {
 		e.EngineConfig.OciConfig.SetProcessSelinuxLabel(instanceEngineConfig.OciConfig.Process.SelinuxLabel)
@@ -878,7 +1368,7 @@ func (e *EngineOperations) prepareInstanceJoinConfig(starterConfig *starter.Conf
 	// restore seccomp filter or apply a new one if provided
 	param = security.GetParam(e.EngineConfig.GetSecurity(), "seccomp")
 	if param != "" {
-		sylog.Debugf("Applying seccomp rule from %s", param)
+		runtimeLog.Debugf("Applying seccomp rule from %s", param)
 		generator := &e.EngineConfig.OciConfig.Generator
 		if err := seccomp.LoadProfileFromFile(param, generator); err != nil {
 			return err
@@ -927,7 +1417,7 @@ func openDevFuse(e *EngineOperations, starterConfig *starter.Config) (bool, erro
 	mounts := e.EngineConfig.GetFuseMount()
 
 	for i := range mounts {
-		sylog.Debugf("Opening /dev/fuse for FUSE mount point %s\n", mounts[i].MountPoint)
+		runtimeLog.Debugf("Opening /dev/fuse for FUSE mount point %s\n", mounts[i].MountPoint)
 		fd, err := syscall.Open("/dev/fuse", syscall.O_RDWR, 0)
 		if err != nil {
 			return false, err
@@ -992,7 +1482,7 @@ func (e *EngineOperations) setSessionLayer(img *image.Image) error {
 			return fmt.Errorf("while getting overlay partition in SIF image %s: %s", img.Path, err)
 		}
 		for _, o := range overlays {
-			if o.Type == image.EXT3 {
+			if o.Type == image.EXT3 || o.Type == image.ENCRYPTEXT3 {
 				hasSIFOverlay = true
 				break
 			}
@@ -1008,7 +1498,7 @@ func (e *EngineOperations) setSessionLayer(img *image.Image) error {
 			e.EngineConfig.SetSessionLayer(singularityConfig.OverlayLayer)
 			return nil
 		}
-		sylog.Debugf("Not attempting to use overlay or underlay: writable flag requested")
+		runtimeLog.Debugf("Not attempting to use overlay or underlay: writable flag requested")
 		return nil
 	}
 
@@ -1029,32 +1519,32 @@ func (e *EngineOperations) setSessionLayer(img *image.Image) error {
 
 	if userNS {
 		if !e.EngineConfig.File.EnableUnderlay {
-			sylog.Debugf("Not attempting to use underlay with user namespace: disabled by configuration ('enable underlay = no')")
+			runtimeLog.Debugf("Not attempting to use underlay with user namespace: disabled by configuration ('enable underlay = no')")
 			return nil
 		}
 		if !writableImage {
-			sylog.Debugf("Using underlay layer: user namespace requested")
+			runtimeLog.Debugf("Using underlay layer: user namespace requested")
 			e.EngineConfig.SetSessionLayer(singularityConfig.UnderlayLayer)
 			return nil
 		}
-		sylog.Debugf("Not attempting to use overlay or underlay: writable flag requested")
+		runtimeLog.Debugf("Not attempting to use overlay or underlay: writable flag requested")
 		return nil
 	}
 
 	// starter was forced to load overlay module, now check if there
 	// is an overlay entry in /proc/filesystems
 	if has, _ := proc.HasFilesystem("overlay"); has {
-		sylog.Debugf("Overlay seems supported and allowed by kernel")
+		runtimeLog.Debugf("Overlay seems supported and allowed by kernel")
 		switch e.EngineConfig.File.EnableOverlay {
 		case "yes", "try":
 			e.EngineConfig.SetSessionLayer(singularityConfig.OverlayLayer)
 
 			if !writableImage || hasSIFOverlay {
-				sylog.Debugf("Attempting to use overlayfs (enable overlay = %v)\n", e.EngineConfig.File.EnableOverlay)
+				runtimeLog.Debugf("Attempting to use overlayfs (enable overlay = %v)\n", e.EngineConfig.File.EnableOverlay)
 				return nil
 			}
 
-			sylog.Debugf("Not attempting to use overlay or underlay: writable flag requested")
+			runtimeLog.Debugf("Not attempting to use overlay or underlay: writable flag requested")
 			e.EngineConfig.SetSessionLayer(singularityConfig.DefaultLayer)
 			return nil
 		default:
@@ -1064,7 +1554,7 @@ func (e *EngineOperations) setSessionLayer(img *image.Image) error {
 			if writableTmpfs {
 				return fmt.Errorf("--writable-tmpfs requires 'enable overlay = yes': set to 'no' by administrator")
 			}
-			sylog.Debugf("Could not use overlay, disabled by configuration ('enable overlay = no')")
+			runtimeLog.Debugf("Could not use overlay, disabled by configuration ('enable overlay = no')")
 		}
 	} else {
 		if writableTmpfs {
@@ -1077,15 +1567,88 @@ func (e *EngineOperations) setSessionLayer(img *image.Image) error {
 
 	// if --writable wasn't set, use underlay if possible
 	if !writableImage && e.EngineConfig.File.EnableUnderlay {
-		sylog.Debugf("Attempting to use underlay (enable underlay = yes)\n")
+		runtimeLog.Debugf("Attempting to use underlay (enable underlay = yes)\n")
 		e.EngineConfig.SetSessionLayer(singularityConfig.UnderlayLayer)
 		return nil
 	} else if writableImage {
-		sylog.Debugf("Not attempting to use overlay or underlay: writable flag requested")
+		runtimeLog.Debugf("Not attempting to use overlay or underlay: writable flag requested")
+		return nil
+	}
+
+	runtimeLog.Debugf("Not attempting to use overlay or underlay: both disabled by administrator")
+	return nil
+}
+
+// checkSignature applies the signature policy of singularity.conf to the
+// container, overlay and data images, and the --verify option to the
+// container image, before anything is mounted from them.
+func (e *EngineOperations) checkSignature(img *image.Image, suid bool) error {
+	policy, err := sigpolicy.FromConfig(e.EngineConfig.File, suid)
+	if err != nil {
+		return fmt.Errorf("while loading signature policy: %s", err)
+	}
+	rootFs := img.Usage&image.RootFsUsage != 0
+	verify := rootFs && e.EngineConfig.GetVerifyImage()
+	if policy == nil && !verify {
+		return nil
+	}
+
+	var kr openpgp.EntityList
+	enforce := verify
+
+	if policy != nil {
+		// the URI is only trusted for root as images pulled
+		// by users are stored in their own cache directory
+		uri := ""
+		if rootFs && os.Getuid() == 0 {
+			uri = e.EngineConfig.GetImageURI()
+		}
+		exemption, err := policy.Exemption(img, uri)
+		if err != nil {
+			return fmt.Errorf("while checking signature policy exemptions: %s", err)
+		}
+		if exemption != "" {
+			audit.Record(audit.Signature, audit.Allowed, "image", img.Path, "exemption", exemption)
+			if !verify {
+				return nil
+			}
+		} else {
+			kr = append(kr, policy.KeyRing...)
+			enforce = enforce || policy.Mode == sigpolicy.Enforce
+		}
+	}
+
+	if verify {
+		pubkr, err := sypgp.NewHandle("").LoadPubKeyring()
+		if err != nil {
+			return fmt.Errorf("while obtaining public keyring: %s", err)
+		}
+		kr = append(kr, pubkr...)
+	}
+
+	var signers [][20]byte
+	if img.Type != image.SIF {
+		err = errors.New("only SIF images can be signed")
+	} else if rootFs {
+		signers, err = sigpolicy.Verify(img.File, kr)
+	} else {
+		signers, err = sigpolicy.VerifyAll(img.File, kr)
+	}
+	if err != nil {
+		if enforce {
+			audit.Record(audit.Signature, audit.Denied, "image", img.Path, "error", err.Error())
+			return sylog.WithCode(sylog.ImageDenied, fmt.Errorf("image signature verification failed: %s", err))
+		}
+		runtimeLog.Warningf("Image signature verification failed, running anyway as 'signature policy = warn': %s", err)
+		audit.Record(audit.Signature, audit.Allowed, "image", img.Path, "error", err.Error())
 		return nil
 	}
 
-	sylog.Debugf("Not attempting to use overlay or underlay: both disabled by administrator")
+	fps := make([]string, 0, len(signers))
+	for _, fp := range signers {
+		fps = append(fps, fmt.Sprintf("%X", fp))
+	}
+	audit.Record(audit.Signature, audit.Allowed, "image", img.Path, "signers", strings.Join(fps, ","))
 	return nil
 }
 
@@ -1108,6 +1671,10 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 		return fmt.Errorf("could not use %s for writing, you don't have write permissions", img.Path)
 	}
 
+	if err := e.checkSignature(img, starterConfig.GetIsSUID()); err != nil {
+		return err
+	}
+
 	if err := e.setSessionLayer(img); err != nil {
 		return err
 	}
@@ -1133,7 +1700,7 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 			if err := overlay.CheckLower(img.Path); overlay.IsIncompatible(err) {
 				layer := singularityConfig.UnderlayLayer
 				if !e.EngineConfig.File.EnableUnderlay {
-					sylog.Warningf("Could not fallback to underlay, disabled by configuration ('enable underlay = no')")
+					runtimeLog.Warningf("Could not fallback to underlay, disabled by configuration ('enable underlay = no')")
 					layer = singularityConfig.DefaultLayer
 				}
 				e.EngineConfig.SetSessionLayer(layer)
@@ -1141,18 +1708,18 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 				// show a warning message if --writable-tmpfs or overlay images
 				// are requested otherwise make it verbose to not annoy users
 				if e.EngineConfig.GetWritableTmpfs() || len(e.EngineConfig.GetOverlayImage()) > 0 {
-					sylog.Warningf("Fallback to %s layer: %s", layer, err)
+					runtimeLog.Warningf("Fallback to %s layer: %s", layer, err)
 
 					if e.EngineConfig.GetWritableTmpfs() {
 						e.EngineConfig.SetWritableTmpfs(false)
-						sylog.Warningf("--writable-tmpfs disabled due to sandbox filesystem incompatibility with overlay")
+						runtimeLog.Warningf("--writable-tmpfs disabled due to sandbox filesystem incompatibility with overlay")
 					}
 					if len(e.EngineConfig.GetOverlayImage()) > 0 {
 						e.EngineConfig.SetOverlayImage(nil)
-						sylog.Warningf("overlay image(s) not loaded due to sandbox filesystem incompatibility with overlay")
+						runtimeLog.Warningf("overlay image(s) not loaded due to sandbox filesystem incompatibility with overlay")
 					}
 				} else {
-					sylog.Verbosef("Fallback to %s layer: %s", layer, err)
+					runtimeLog.Verbosef("Fallback to %s layer: %s", layer, err)
 				}
 			} else if err != nil {
 				return fmt.Errorf("while checking image compatibility with overlay: %s", err)
@@ -1172,10 +1739,13 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 			}
 
 			if ok, err := ecl.ShouldRunFp(img.File, kr); err != nil {
+				audit.Record(audit.ECL, audit.Denied, "image", img.Path, "error", err.Error())
 				return fmt.Errorf("while checking container image with ECL: %s", err)
 			} else if !ok {
-				return errors.New("image prohibited by ECL")
+				audit.Record(audit.ECL, audit.Denied, "image", img.Path)
+				return sylog.WithCode(sylog.ImageDenied, errors.New("image prohibited by ECL"))
 			}
+			audit.Record(audit.ECL, audit.Allowed, "image", img.Path)
 		}
 
 		// look for potential overlay partition in SIF image
@@ -1185,7 +1755,7 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 				return fmt.Errorf("while getting overlay partitions in %s: %s", img.Path, err)
 			}
 			for _, p := range overlays {
-				if img.Writable && p.Type == image.EXT3 {
+				if img.Writable && (p.Type == image.EXT3 || p.Type == image.ENCRYPTEXT3) {
 					writableOverlayPath = img.Path
 				}
 			}
@@ -1203,19 +1773,19 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 	case singularityConfig.OverlayLayer:
 		overlayImages, err := e.loadOverlayImages(starterConfig, writableOverlayPath)
 		if err != nil {
-			return fmt.Errorf("while loading overlay images: %s", err)
+			return fmt.Errorf("while loading overlay images: %w", err)
 		}
 		images = append(images, overlayImages...)
 	case singularityConfig.UnderlayLayer:
 		if e.EngineConfig.GetWritableTmpfs() {
-			sylog.Warningf("Disabling --writable-tmpfs as it can't be used in conjunction with underlay")
+			runtimeLog.Warningf("Disabling --writable-tmpfs as it can't be used in conjunction with underlay")
 			e.EngineConfig.SetWritableTmpfs(false)
 		}
 	}
 
 	bindImages, err := e.loadBindImages(starterConfig)
 	if err != nil {
-		return fmt.Errorf("while loading data bind images: %s", err)
+		return fmt.Errorf("while loading data bind images: %w", err)
 	}
 	images = append(images, bindImages...)
 
@@ -1227,6 +1797,7 @@ func (e *EngineOperations) loadImages(starterConfig *starter.Config) error {
 // loadOverlayImages loads overlay images.
 func (e *EngineOperations) loadOverlayImages(starterConfig *starter.Config, writableOverlayPath string) ([]image.Image, error) {
 	images := make([]image.Image, 0)
+	loaded := make(map[string]bool)
 
 	for _, overlayImg := range e.EngineConfig.GetOverlayImage() {
 		writableOverlay := true
@@ -1249,6 +1820,17 @@ func (e *EngineOperations) loadOverlayImages(starterConfig *starter.Config, writ
 		}
 		img.Usage = image.OverlayUsage
 
+		if err := e.checkSignature(img, starterConfig.GetIsSUID()); err != nil {
+			return nil, err
+		}
+
+		// an overlay can't be stacked twice, its position would be
+		// ambiguous
+		if loaded[img.Path] {
+			return nil, fmt.Errorf("overlay image %s is given more than once", img.Path)
+		}
+		loaded[img.Path] = true
+
 		if writableOverlay && img.Writable {
 			if writableOverlayPath != "" {
 				return nil, fmt.Errorf(
@@ -1286,7 +1868,7 @@ func (e *EngineOperations) loadBindImages(starterConfig *starter.Config) ([]imag
 
 		imagePath := binds[i].Source
 
-		sylog.Debugf("Loading data image %s", imagePath)
+		runtimeLog.Debugf("Loading data image %s", imagePath)
 
 		img, err := e.loadImage(imagePath, !binds[i].Readonly())
 		if err != nil && !image.IsReadOnlyFilesytem(err) {
@@ -1294,6 +1876,10 @@ func (e *EngineOperations) loadBindImages(starterConfig *starter.Config) ([]imag
 		}
 		img.Usage = image.DataUsage
 
+		if err := e.checkSignature(img, starterConfig.GetIsSUID()); err != nil {
+			return nil, err
+		}
+
 		if err := starterConfig.KeepFileDescriptor(int(img.Fd)); err != nil {
 			return nil, err
 		}
@@ -1327,7 +1913,7 @@ func (e *EngineOperations) loadImage(path string, writable bool) (*image.Image,
 		// may return a path with the suffix " (deleted)" even if not deleted, we just
 		// remove it because it won't impact ACL path check
 		finalTarget := strings.TrimSuffix(imgTarget, delSuffix)
-		sylog.Debugf("Replacing image resolved path %s by %s", imgObject.Path, finalTarget)
+		runtimeLog.Debugf("Replacing image resolved path %s by %s", imgObject.Path, finalTarget)
 		imgObject.Path = finalTarget
 	}
 
@@ -1367,7 +1953,11 @@ func (e *EngineOperations) loadImage(path string, writable bool) (*image.Image,
 			if !e.EngineConfig.File.AllowContainerSquashfs {
 				return nil, fmt.Errorf("configuration disallows users from running squashFS based containers")
 			}
-		case image.ENCRYPTSQUASHFS:
+		case image.EROFS:
+			if !e.EngineConfig.File.AllowContainerErofs {
+				return nil, fmt.Errorf("configuration disallows users from running erofs based containers")
+			}
+		case image.ENCRYPTSQUASHFS, image.ENCRYPTEXT3:
 			if !e.EngineConfig.File.AllowContainerEncrypted {
 				return nil, fmt.Errorf("configuration disallows users from running encrypted containers")
 			}
@@ -0,0 +1,352 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/sylabs/singularity/internal/pkg/audit"
+	"github.com/sylabs/singularity/internal/pkg/sigpolicy"
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"github.com/sylabs/singularity/pkg/image"
+	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engine/singularity/config"
+	"golang.org/x/sys/unix"
+)
+
+const sigpolicyTestData = "../../../sigpolicy/testdata"
+
+func TestCheckCommit(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "check-commit-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	commitDir := func(name string, extra ...string) string {
+		dir := filepath.Join(tmpDir, name)
+		for _, d := range append([]string{"upper", "work"}, extra...) {
+			if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+				t.Fatalf("failed to create directory: %s", err)
+			}
+		}
+		return dir
+	}
+	goodDir := commitDir(singularityConfig.CommitDirPrefix + "good")
+	otherDir := commitDir("other")
+	extraDir := commitDir(singularityConfig.CommitDirPrefix+"extra", "upper/file")
+	linkDir := filepath.Join(tmpDir, singularityConfig.CommitDirPrefix+"link")
+	if err := os.Symlink(goodDir, linkDir); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+	existing := filepath.Join(tmpDir, "existing.sqfs")
+	if err := ioutil.WriteFile(existing, nil, 0644); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+	image := filepath.Join(tmpDir, "overlay.sqfs")
+
+	tests := []struct {
+		name    string
+		image   string
+		dir     string
+		suid    bool
+		wantErr bool
+	}{
+		{name: "NoCommit"},
+		{name: "Valid", image: image, dir: goodDir},
+		{name: "Setuid", image: image, dir: goodDir, suid: true, wantErr: os.Getuid() != 0},
+		{name: "MissingDir", image: image, wantErr: true},
+		{name: "MissingImage", dir: goodDir, wantErr: true},
+		{name: "RelativeImage", image: "overlay.sqfs", dir: goodDir, wantErr: true},
+		{name: "ExistingImage", image: existing, dir: goodDir, wantErr: true},
+		{name: "NoImageDirectory", image: filepath.Join(tmpDir, "none", "overlay.sqfs"), dir: goodDir, wantErr: true},
+		{name: "SystemDirectory", image: image, dir: "/etc", wantErr: true},
+		{name: "BadPrefix", image: image, dir: otherDir, wantErr: true},
+		{name: "UncleanDirectory", image: image, dir: goodDir + "/../" + filepath.Base(goodDir), wantErr: true},
+		{name: "Symlink", image: image, dir: linkDir, wantErr: true},
+		{name: "NotEmpty", image: image, dir: extraDir, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &EngineOperations{EngineConfig: singularityConfig.NewConfig()}
+			e.EngineConfig.SetCommitImage(tt.image, tt.dir)
+
+			err := e.checkCommit(tt.suid)
+			if tt.wantErr && err == nil {
+				t.Errorf("unexpected success")
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+			if err != nil || tt.dir == "" {
+				return
+			}
+			for i, fd := range e.EngineConfig.GetCommitFds() {
+				path, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				} else if want := filepath.Join(tt.dir, []string{"upper", "work"}[i]); path != want {
+					t.Errorf("got %s instead of %s", path, want)
+				}
+				syscall.Close(fd)
+			}
+		})
+	}
+}
+
+func TestOpenProc(t *testing.T) {
+	errCheck := fmt.Errorf("check failed")
+
+	tests := []struct {
+		name    string
+		pid     int
+		check   error
+		wantErr bool
+	}{
+		{name: "Self", pid: os.Getpid()},
+		{name: "CheckFailure", pid: os.Getpid(), check: errCheck, wantErr: true},
+		{name: "NoProcess", pid: 0, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checked := ""
+			fd, dir, err := openProc(tt.pid, func(dir string) error {
+				checked = dir
+				return tt.check
+			})
+			if tt.wantErr {
+				if err == nil {
+					syscall.Close(fd)
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer syscall.Close(fd)
+
+			if checked != dir {
+				t.Errorf("checked %s instead of %s", checked, dir)
+			}
+			// the namespaces are those of the opened process
+			got, err := os.Readlink(filepath.Join(dir, "ns", "net"))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			want, err := os.Readlink("/proc/self/ns/net")
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got != want {
+				t.Errorf("got namespace %s instead of %s", got, want)
+			}
+		})
+	}
+}
+
+func TestCheckProcessOwner(t *testing.T) {
+	uid := os.Getuid()
+	gid := os.Getgid()
+
+	tests := []struct {
+		name    string
+		dir     string
+		uid     int
+		gid     int
+		wantErr bool
+	}{
+		{name: "Self", dir: "/proc/self", uid: uid, gid: gid},
+		{name: "OtherUser", dir: "/proc/self", uid: uid + 1, gid: gid, wantErr: true},
+		{name: "OtherGroup", dir: "/proc/self", uid: uid, gid: gid + 1, wantErr: true},
+		{name: "NoStatus", dir: "/proc/self/ns", uid: uid, gid: gid, wantErr: true},
+		{name: "NoProcess", dir: "/proc/0", uid: uid, gid: gid, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkProcessOwner(tt.dir, tt.uid, tt.gid)
+			if tt.wantErr && err == nil {
+				t.Errorf("unexpected success")
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestCheckSignature(t *testing.T) {
+	dir, err := ioutil.TempDir("", "check-signature-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// copy the test keyring and images readable by unprivileged users
+	copyTestData := func(path string) string {
+		b, err := ioutil.ReadFile(filepath.Join(sigpolicyTestData, path))
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", path, err)
+		}
+		dst := filepath.Join(dir, filepath.Base(path))
+		if err := ioutil.WriteFile(dst, b, 0644); err != nil {
+			t.Fatalf("failed to create %s: %s", dst, err)
+		}
+		return dst
+	}
+	keyring := copyTestData("keys/private.asc")
+	signed := copyTestData("images/one-group-signed.sif")
+	unsigned := copyTestData("images/one-group.sif")
+
+	// URI exemptions only apply to the images run by root
+	rootOnly := os.Getuid() != 0
+
+	tests := []struct {
+		name    string
+		path    string
+		usage   image.Usage
+		uri     string
+		exempt  string
+		wantErr bool
+	}{
+		{name: "Signed", path: signed, usage: image.RootFsUsage},
+		{name: "Unsigned", path: unsigned, usage: image.RootFsUsage, wantErr: true},
+		{name: "ExemptURI", path: unsigned, usage: image.RootFsUsage, uri: "library://sylabs/*", wantErr: rootOnly},
+		{name: "OverlaySigned", path: signed, usage: image.OverlayUsage},
+		{name: "OverlayUnsigned", path: unsigned, usage: image.OverlayUsage, wantErr: true},
+		{name: "OverlayExemptURI", path: unsigned, usage: image.OverlayUsage, uri: "library://sylabs/*", wantErr: true},
+		{name: "DataUnsigned", path: unsigned, usage: image.DataUsage, wantErr: true},
+		{name: "DataExemptPath", path: unsigned, usage: image.DataUsage, exempt: dir + "/*"},
+		{name: "DataSandbox", path: dir, usage: image.DataUsage, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &EngineOperations{EngineConfig: singularityConfig.NewConfig()}
+			e.EngineConfig.File.SignaturePolicy = sigpolicy.Enforce
+			e.EngineConfig.File.SignatureKeyring = keyring
+			e.EngineConfig.SetImageURI("library://sylabs/examples/lolcow")
+			if tt.uri != "" {
+				e.EngineConfig.File.SignatureExemptURI = []string{tt.uri}
+			}
+			if tt.exempt != "" {
+				e.EngineConfig.File.SignatureExemptPath = []string{tt.exempt}
+			}
+
+			f, err := os.Open(tt.path)
+			if err != nil {
+				t.Fatalf("failed to open %s: %s", tt.path, err)
+			}
+			defer f.Close()
+
+			img := &image.Image{Path: tt.path, File: f, Type: image.SIF, Usage: tt.usage}
+			if tt.path == dir {
+				img.Type = image.SANDBOX
+			}
+
+			err = e.checkSignature(img, false)
+			if tt.wantErr && err == nil {
+				t.Errorf("unexpected success")
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestCheckGroupsMember(t *testing.T) {
+	tests := []struct {
+		name    string
+		groups  []int
+		gid     int
+		current []int
+		wantErr bool
+	}{
+		{name: "NoGroups", gid: 100},
+		{name: "PrimaryGroup", groups: []int{100}, gid: 100},
+		{name: "SupplementaryGroups", groups: []int{100, 20, 30}, gid: 100, current: []int{20, 30}},
+		{name: "NoSupplementaryGroups", groups: []int{100, 20}, gid: 100, wantErr: true},
+		{name: "NotMember", groups: []int{100, 20, 0}, gid: 100, current: []int{20, 30}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkGroupsMember(tt.groups, tt.gid, tt.current)
+			if tt.wantErr && err == nil {
+				t.Errorf("unexpected success")
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func TestPrepareConfigFailure(t *testing.T) {
+	test.EnsurePrivilege(t)
+
+	dir, err := ioutil.TempDir("", "prepare-failure-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "audit.log")
+	if err := audit.SetDestination(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer audit.SetDestination("")
+
+	e := &EngineOperations{EngineConfig: singularityConfig.NewConfig()}
+	e.EngineConfig.File.AuditLog = path
+	e.EngineConfig.SetAuditLog(filepath.Join(dir, "forged.log"))
+	e.EngineConfig.SetAuditRecords([]string{"event=ecl result=allowed"})
+
+	// stage 1 denies the image with privileges permanently dropped,
+	// the thread is terminated when the goroutine exits while locked
+	done := make(chan error)
+	go func() {
+		runtime.LockOSThread()
+		if err := unix.Setresuid(65534, 65534, 65534); err != nil {
+			done <- err
+			return
+		}
+		audit.Record(audit.ECL, audit.Denied, "image", "/tmp/denied.sif")
+		e.setAuditRecords()
+		done <- nil
+	}()
+	if err := <-done; err != nil {
+		t.Fatalf("failed to drop privileges: %s", err)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("unexpected record written by stage 1")
+	}
+	if e.EngineConfig.GetAuditLog() != path {
+		t.Errorf("got audit log %s instead of %s", e.EngineConfig.GetAuditLog(), path)
+	}
+
+	if err := e.PrepareConfigFailure(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read audit log: %s", err)
+	}
+	records := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(records) != 1 {
+		t.Fatalf("unexpected records %q", b)
+	}
+	if !strings.Contains(records[0], " event=ecl result=denied uid=65534 ") || !strings.HasSuffix(records[0], " image=/tmp/denied.sif") {
+		t.Errorf("unexpected record %q", records[0])
+	}
+}
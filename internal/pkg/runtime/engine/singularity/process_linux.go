@@ -62,7 +62,11 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 	signals := make(chan os.Signal, 2)
 	signal.Notify(signals)
 
-	if err := e.runFuseDrivers(true, -1); err != nil {
+	if mask := e.EngineConfig.GetUmask(); mask >= 0 {
+		syscall.Umask(mask)
+	}
+
+	if err := e.runFuseDrivers(true, -1, "/"); err != nil {
 		return err
 	}
 
@@ -91,10 +95,10 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 			}
 			consfile, err := os.OpenFile("/dev/console", os.O_RDWR, 0600)
 			if err != nil {
-				sylog.Debugf("Could not open minimal /dev/console, skipping replacing tty descriptors")
+				runtimeLog.Debugf("Could not open minimal /dev/console, skipping replacing tty descriptors")
 				break
 			}
-			sylog.Debugf("Replacing tty descriptors with /dev/console")
+			runtimeLog.Debugf("Replacing tty descriptors with /dev/console")
 			consfd := int(consfile.Fd())
 			for ; fd <= 2; fd++ {
 				if !terminal.IsTerminal(fd) {
@@ -153,7 +157,11 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 		return fmt.Errorf("failed to apply security configuration: %s", err)
 	}
 
-	if (!isInstance && !shimProcess) || bootInstance || e.EngineConfig.GetInstanceJoin() {
+	// with --pty and --no-pty this process relays the standard streams
+	// of the container process
+	relayStdio := !isInstance && (e.EngineConfig.GetPty() || e.EngineConfig.GetNoPty())
+
+	if bootInstance || (!relayStdio && ((!isInstance && !shimProcess) || e.EngineConfig.GetInstanceJoin())) {
 		args := e.EngineConfig.OciConfig.Process.Args
 		env := e.EngineConfig.OciConfig.Process.Env
 
@@ -176,10 +184,17 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 	statusChan := make(chan syscall.WaitStatus, 1)
 	cmdPid := -2
 
+	var relay *stdioRelay
+
 	args, env, err := runActionScript(e.EngineConfig)
 	if err != nil {
 		return err
 	} else if len(args) > 0 {
+		if relayStdio {
+			if relay, err = e.newStdioRelay(); err != nil {
+				return err
+			}
+		}
 	cmdexec:
 		// Spawn and wait container process, signal handler
 		cmd := exec.Command(args[0], args[1:]...)
@@ -190,6 +205,12 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 		cmd.SysProcAttr = &syscall.SysProcAttr{
 			Setpgid: isInstance,
 		}
+		if e.EngineConfig.GetTimeout() > 0 {
+			// the container process doesn't outlive the engine killed
+			// by the --timeout deadline
+			cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+		}
+		relay.attach(cmd)
 		if err := cmd.Start(); err != nil {
 			if e, ok := err.(*os.PathError); ok {
 				if e.Err.(syscall.Errno) == syscall.ENOEXEC && args[0] != defaultShell {
@@ -201,6 +222,10 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 		}
 		cmdPid = cmd.Process.Pid
 
+		if err := relay.start(); err != nil {
+			return err
+		}
+
 		go func() {
 			errChan <- cmd.Wait()
 		}()
@@ -230,7 +255,7 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 	for {
 		select {
 		case s := <-signals:
-			sylog.Debugf("Received signal %s", s.String())
+			runtimeLog.Debugf("Received signal %s", s.String())
 			switch s {
 			case syscall.SIGCHLD:
 				for {
@@ -245,6 +270,8 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 					if wpid == cmdPid {
 						e.stopFuseDrivers()
 						statusChan <- status
+					} else {
+						e.fuseDriverExited(wpid, status)
 					}
 				}
 			case syscall.SIGURG:
@@ -254,6 +281,11 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 				break
 			default:
 				signal := s.(syscall.Signal)
+				// the kernel notifies the container process once the
+				// pseudo terminal is resized
+				if signal == syscall.SIGWINCH && relay.resize() {
+					break
+				}
 				// EPERM and EINVAL are deliberately ignored because they can't be
 				// returned in this context, this process is PID 1, so it has the
 				// permissions to send signals to its childs and EINVAL would
@@ -261,12 +293,16 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 				// stable :)
 				if isInstance && cmdPid > 0 {
 					if err := syscall.Kill(-cmdPid, signal); err == syscall.ESRCH {
-						sylog.Debugf("No child process, exiting ...")
+						runtimeLog.Debugf("No child process, exiting ...")
 						os.Exit(128 + int(signal))
 					}
-				} else if e.EngineConfig.GetSignalPropagation() && cmdPid > 0 {
+				} else if (e.EngineConfig.GetSignalPropagation() || relay != nil || timeoutSignal(e.EngineConfig, signal)) && cmdPid > 0 {
+					// the container process relayed doesn't receive the
+					// signals of the host terminal, and the engine sends
+					// SIGTERM once the --timeout deadline expired
 					if err := syscall.Kill(cmdPid, signal); err == syscall.ESRCH {
-						sylog.Debugf("No child process, exiting ...")
+						runtimeLog.Debugf("No child process, exiting ...")
+						relay.wait()
 						os.Exit(128 + int(signal))
 					}
 				}
@@ -282,10 +318,11 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 				// handle possible race with Wait4 call above by ignoring ECHILD
 				// error because child process was already catched
 				if e.Err.(syscall.Errno) != syscall.ECHILD {
-					sylog.Fatalf("error while waiting container process: %s", e.Error())
+					runtimeLog.Fatalf("error while waiting container process: %s", e.Error())
 				}
 			}
 			if !isInstance {
+				relay.wait()
 				if len(statusChan) > 0 {
 					status := <-statusChan
 					if status.Signaled() {
@@ -295,12 +332,18 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 				} else if err == nil {
 					os.Exit(0)
 				}
-				sylog.Fatalf("command exited with unknown error: %s", err)
+				runtimeLog.Fatalf("command exited with unknown error: %s", err)
 			}
 		}
 	}
 }
 
+// timeoutSignal returns if signal is the SIGTERM sent by the engine
+// once the --timeout deadline expired.
+func timeoutSignal(engineConfig *singularityConfig.EngineConfig, signal syscall.Signal) bool {
+	return signal == syscall.SIGTERM && engineConfig.GetTimeout() > 0
+}
+
 // PostStartProcess is called from master after successful
 // execution of the container process. It will write instance
 // state/config files (if any).
@@ -312,7 +355,7 @@ func (e *EngineOperations) StartProcess(masterConn net.Conn) error {
 //
 // Here, however, singularity engine does not escalate privileges.
 func (e *EngineOperations) PostStartProcess(ctx context.Context, pid int) error {
-	sylog.Debugf("Post start process")
+	runtimeLog.Debugf("Post start process")
 
 	callbackType := (singularitycallback.PostStartProcess)(nil)
 	callbacks, err := plugin.LoadCallbacks(callbackType)
@@ -356,7 +399,7 @@ func (e *EngineOperations) PostStartProcess(ctx context.Context, pid int) error
 
 		ip, err := e.getIP()
 		if err != nil {
-			sylog.Warningf("Could not get ip for %s: %s", pw.Name, err)
+			runtimeLog.Warningf("Could not get ip for %s: %s", pw.Name, err)
 		}
 		file.IP = ip
 
@@ -421,7 +464,8 @@ func (e *EngineOperations) setPathEnv() {
 }
 
 // runFuseDrivers execute FUSE drivers and returns the list of FUSE process ID.
-func (e *EngineOperations) runFuseDrivers(fromContainer bool, usernsFd int) error {
+// The root directory of the container is root, as seen by the FUSE drivers.
+func (e *EngineOperations) runFuseDrivers(fromContainer bool, usernsFd int, root string) error {
 	// set PATH for the command
 	oldpath := os.Getenv("PATH")
 	defer func() {
@@ -462,7 +506,7 @@ func (e *EngineOperations) runFuseDrivers(fromContainer bool, usernsFd int) erro
 		program := fuseMounts[i].Program
 		fd := fuseMounts[i].Fd
 
-		sylog.Debugf("Running FUSE driver for %s as %v, fd %d", mnt, program, fd)
+		runtimeLog.Debugf("Running FUSE driver for %s as %v, fd %d", mnt, program, fd)
 
 		fh := os.NewFile(uintptr(fd), "/dev/fuse")
 		if fh == nil {
@@ -478,8 +522,9 @@ func (e *EngineOperations) runFuseDrivers(fromContainer bool, usernsFd int) erro
 		// the fuse file descriptor becomes 3 for the FUSE program
 		args := append(program, "/dev/fd/3")
 
-		// add -f to run FUSE in foreground mode
-		if !fuseMounts[i].Daemon {
+		// add -f to run FUSE in foreground mode, the built-in
+		// drivers already have their foreground options
+		if !fuseMounts[i].Daemon && fuseMounts[i].Driver == "" {
 			args = append(args, "-f")
 		}
 
@@ -512,33 +557,133 @@ func (e *EngineOperations) runFuseDrivers(fromContainer bool, usernsFd int) erro
 				return fmt.Errorf("could not start program %s: %s", cmdline, err)
 			}
 			fuseMounts[i].Cmd = cmd
+
+			if err := waitFuseDriver(&fuseMounts[i], root); err != nil {
+				return err
+			}
 		}
 	}
 
 	return nil
 }
 
-// stopFuseDrivers notifies FUSE drivers running in foreground mode
-// with a SIGTERM signal.
-func (e *EngineOperations) stopFuseDrivers() {
-	for _, fuseMount := range e.EngineConfig.GetFuseMount() {
-		if fuseMount.Cmd != nil {
-			cmd := fuseMount.Cmd
-			if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
-				sylog.Warningf("Can not send SIGTERM to FUSE process: %s", err)
-				continue
+// fuseStartTimeout is the time given to the FUSE drivers running in
+// foreground to answer to the file system requests.
+const fuseStartTimeout = 30 * time.Second
+
+// waitFuseDriver waits until the FUSE driver running in foreground for the
+// fuse mount m answers to the file system requests, or returns an error
+// if the driver exits before or doesn't answer after fuseStartTimeout.
+// The mount point is looked up in the root directory of the container root.
+func waitFuseDriver(m *singularityConfig.FuseMount, root string) error {
+	mnt := filepath.Join(root, m.MountPoint)
+	pid := m.Cmd.Process.Pid
+
+	// requests are queued by the kernel until the driver answers to the
+	// FUSE initialization request, or fail once the driver exited
+	ready := make(chan error, 1)
+	go func() {
+		_, err := os.Stat(mnt)
+		ready <- err
+	}()
+
+	runtimeLog.Debugf("Waiting for FUSE driver for %s", m.MountPoint)
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.NewTimer(fuseStartTimeout)
+	defer deadline.Stop()
+
+	for {
+		select {
+		case <-deadline.C:
+			// the pending request fails once the driver is killed
+			var status syscall.WaitStatus
+			syscall.Kill(pid, syscall.SIGKILL)
+			syscall.Wait4(pid, &status, 0, nil)
+			m.Cmd = nil
+			return fmt.Errorf("FUSE driver for %s not ready after %s", m.MountPoint, fuseStartTimeout)
+		case err := <-ready:
+			if os.IsPermission(err) {
+				// the FUSE file system is only accessible by the driver user
+				runtimeLog.Debugf("Could not check FUSE mount point %s: %s", m.MountPoint, err)
+			} else if err != nil {
+				return fmt.Errorf("FUSE mount point %s not ready: %s", m.MountPoint, err)
 			}
-			mnt := fuseMount.MountPoint
-			_, err := cmd.Process.Wait()
-			if err != nil {
-				sylog.Warningf("FUSE process for mount point %s terminated with error: %s", mnt, err)
-			} else {
-				sylog.Debugf("FUSE process for mount point %s terminated", mnt)
+			return nil
+		case <-ticker.C:
+			var status syscall.WaitStatus
+			if wpid, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err == nil && wpid == pid {
+				m.Cmd = nil
+				return fmt.Errorf("FUSE driver for %s exited with status %d", m.MountPoint, status.ExitStatus())
 			}
 		}
 	}
 }
 
+// fuseDriverExited warns if pid is the process of a FUSE driver running
+// in foreground, the driver is not stopped anymore by stopFuseDrivers.
+func (e *EngineOperations) fuseDriverExited(pid int, status syscall.WaitStatus) {
+	fuseMounts := e.EngineConfig.GetFuseMount()
+	for i := range fuseMounts {
+		if fuseMounts[i].Cmd == nil || fuseMounts[i].Cmd.Process.Pid != pid {
+			continue
+		}
+		runtimeLog.Warningf("FUSE driver for %s exited with status %d, the mount point is not accessible anymore", fuseMounts[i].MountPoint, status.ExitStatus())
+		fuseMounts[i].Cmd = nil
+		return
+	}
+}
+
+// reapFuseDrivers reaps the FUSE drivers running in foreground which exited.
+func (e *EngineOperations) reapFuseDrivers() {
+	for _, fuseMount := range e.EngineConfig.GetFuseMount() {
+		if fuseMount.Cmd == nil {
+			continue
+		}
+		var status syscall.WaitStatus
+		pid := fuseMount.Cmd.Process.Pid
+		if wpid, err := syscall.Wait4(pid, &status, syscall.WNOHANG, nil); err == nil && wpid == pid {
+			e.fuseDriverExited(pid, status)
+		}
+	}
+}
+
+// fuseStopTimeout is the time given to the FUSE drivers to terminate
+// after SIGTERM before being killed.
+const fuseStopTimeout = 5 * time.Second
+
+// stopFuseDrivers notifies FUSE drivers running in foreground mode
+// with a SIGTERM signal, and kills those still running after
+// fuseStopTimeout.
+func (e *EngineOperations) stopFuseDrivers() {
+	fuseMounts := e.EngineConfig.GetFuseMount()
+	for i := range fuseMounts {
+		cmd := fuseMounts[i].Cmd
+		if cmd == nil {
+			continue
+		}
+		fuseMounts[i].Cmd = nil
+
+		if err := cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			runtimeLog.Warningf("Can not send SIGTERM to FUSE process: %s", err)
+			continue
+		}
+
+		mnt := fuseMounts[i].MountPoint
+		timer := time.AfterFunc(fuseStopTimeout, func() {
+			runtimeLog.Warningf("FUSE process for mount point %s still running after %s, killing it", mnt, fuseStopTimeout)
+			cmd.Process.Kill()
+		})
+		_, err := cmd.Process.Wait()
+		timer.Stop()
+		if err != nil {
+			runtimeLog.Warningf("FUSE process for mount point %s terminated with error: %s", mnt, err)
+		} else {
+			runtimeLog.Debugf("FUSE process for mount point %s terminated", mnt)
+		}
+	}
+}
+
 func (e *EngineOperations) getIP() (string, error) {
 	if networkSetup == nil {
 		return "", nil
@@ -550,13 +695,13 @@ func (e *EngineOperations) getIP() (string, error) {
 	if err == nil {
 		return ip.String(), nil
 	}
-	sylog.Warningf("Could not get ipv4 %s", err)
+	runtimeLog.Warningf("Could not get ipv4 %s", err)
 
 	ip, err = networkSetup.GetNetworkIP(net[0], "6")
 	if err == nil {
 		return ip.String(), nil
 	}
-	sylog.Warningf("Could not get ipv6 %s", err)
+	runtimeLog.Warningf("Could not get ipv6 %s", err)
 
 	return "", errors.New("could not get ip")
 }
@@ -584,6 +729,7 @@ func getExecError(err error, args []string, shell string) error {
 }
 
 func (e *EngineOperations) execProcess(args, env []string) error {
+	sylog.Flush()
 	err := syscall.Exec(args[0], args, env)
 	if err == nil {
 		return nil
@@ -664,15 +810,15 @@ func sylogBuiltin(ctx context.Context, argv []string) error {
 	}
 	switch argv[0] {
 	case "info":
-		sylog.Infof(argv[1])
+		runtimeLog.Infof(argv[1])
 	case "error":
-		sylog.Errorf(argv[1])
+		runtimeLog.Errorf(argv[1])
 	case "verbose":
-		sylog.Verbosef(argv[1])
+		runtimeLog.Verbosef(argv[1])
 	case "debug":
-		sylog.Debugf(argv[1])
+		runtimeLog.Debugf(argv[1])
 	case "warning":
-		sylog.Warningf(argv[1])
+		runtimeLog.Warningf(argv[1])
 	}
 	return nil
 }
@@ -711,7 +857,7 @@ func getAllEnvBuiltin(shell *interpreter.Shell) interpreter.ShellBuiltin {
 			// like "BASH_FUNC_module%%"
 			key := strings.SplitN(env, "=", 2)[0]
 			if !keyRe.MatchString(key) {
-				sylog.Debugf("Not exporting %q to container environment: invalid key", key)
+				runtimeLog.Debugf("Not exporting %q to container environment: invalid key", key)
 				continue
 			}
 
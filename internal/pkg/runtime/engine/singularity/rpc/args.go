@@ -46,6 +46,14 @@ type CryptArgs struct {
 	MasterPid int
 }
 
+// VerityArgs defines the arguments to verity.
+type VerityArgs struct {
+	DataDev   string
+	HashDev   string
+	Params    []byte
+	MasterPid int
+}
+
 // ChrootArgs defines the arguments to chroot.
 type ChrootArgs struct {
 	Root   string
@@ -130,6 +138,12 @@ type WriteFileArgs struct {
 	Perm     os.FileMode
 }
 
+// AppendFileArgs defines the arguments to appendfile.
+type AppendFileArgs struct {
+	Filename string
+	Data     []byte
+}
+
 // FileInfo returns FileInfo interface to be passed as RPC argument.
 func FileInfo(fi os.FileInfo) os.FileInfo {
 	return &fileInfo{
@@ -55,6 +55,21 @@ func (t *RPC) Decrypt(offset uint64, path string, key []byte, masterPid int) (st
 	return reply, err
 }
 
+// Verity calls the Verity RPC using the supplied arguments.
+func (t *RPC) Verity(dataDev, hashDev string, params []byte, masterPid int) (string, error) {
+	arguments := &args.VerityArgs{
+		DataDev:   dataDev,
+		HashDev:   hashDev,
+		Params:    params,
+		MasterPid: masterPid,
+	}
+
+	var reply string
+	err := t.Client.Call(t.Name+".Verity", arguments, &reply)
+
+	return reply, err
+}
+
 // Mkdir calls the mkdir RPC using the supplied arguments.
 func (t *RPC) Mkdir(path string, perm os.FileMode) error {
 	arguments := &args.MkdirArgs{
@@ -235,3 +250,12 @@ func (t *RPC) WriteFile(filename string, data []byte, perm os.FileMode) error {
 	}
 	return t.Client.Call(t.Name+".WriteFile", arguments, nil)
 }
+
+// AppendFile calls the appendfile RPC using the supplied arguments.
+func (t *RPC) AppendFile(filename string, data []byte) error {
+	arguments := &args.AppendFileArgs{
+		Filename: filename,
+		Data:     data,
+	}
+	return t.Client.Call(t.Name+".AppendFile", arguments, nil)
+}
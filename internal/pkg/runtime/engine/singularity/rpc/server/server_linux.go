@@ -6,6 +6,7 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -23,6 +24,7 @@ import (
 	"github.com/sylabs/singularity/pkg/util/crypt"
 	"github.com/sylabs/singularity/pkg/util/loop"
 	"github.com/sylabs/singularity/pkg/util/namespaces"
+	"github.com/sylabs/singularity/pkg/util/verity"
 	"golang.org/x/sys/unix"
 )
 
@@ -62,6 +64,7 @@ func (t *Methods) Mount(arguments *args.MountArgs, mountErr *error) (err error)
 				_, err = capabilities.SetProcessEffective(oldEffective)
 			}()
 		}
+		sylog.Tracef("mount(%q, %q, %q, %#x, %q)", arguments.Source, arguments.Target, arguments.Filesystem, arguments.Mountflags, arguments.Data)
 		*mountErr = syscall.Mount(arguments.Source, arguments.Target, arguments.Filesystem, arguments.Mountflags, arguments.Data)
 	})
 	return
@@ -69,9 +72,34 @@ func (t *Methods) Mount(arguments *args.MountArgs, mountErr *error) (err error)
 
 // Decrypt decrypts the loop device.
 func (t *Methods) Decrypt(arguments *args.CryptArgs, reply *string) (err error) {
-	cryptName := ""
 	cryptDev := &crypt.Device{}
-	hasIPC := arguments.MasterPid > 0
+
+	return withHostIPC(arguments.MasterPid, func() error {
+		cryptName, err := cryptDev.Open(arguments.Key, arguments.Loopdev)
+		*reply = "/dev/mapper/" + cryptName
+		return err
+	})
+}
+
+// Verity creates the verity device checking the data loop device.
+func (t *Methods) Verity(arguments *args.VerityArgs, reply *string) (err error) {
+	p := new(verity.Params)
+	if err := json.Unmarshal(arguments.Params, p); err != nil {
+		return fmt.Errorf("while decoding dm-verity parameters: %s", err)
+	}
+
+	return withHostIPC(arguments.MasterPid, func() error {
+		name, err := verity.Open(arguments.DataDev, arguments.HashDev, p)
+		*reply = "/dev/mapper/" + name
+		return err
+	})
+}
+
+// withHostIPC calls f with the capabilities required by the device
+// mapper tools, in the host IPC namespace if masterPid is greater than
+// zero.
+func withHostIPC(masterPid int, f func() error) (err error) {
+	hasIPC := masterPid > 0
 
 	runtime.LockOSThread()
 	defer runtime.UnlockOSThread()
@@ -103,11 +131,11 @@ func (t *Methods) Decrypt(arguments *args.CryptArgs, reply *string) (err error)
 			return err
 		}
 
-		// cryptsetup requires to run in the host IPC namespace
+		// cryptsetup and veritysetup require to run in the host IPC namespace
 		// so we enter temporarily in the host IPC namespace
 		// via the master processus ID if its greater than zero
 		// which means that a container IPC namespace was requested
-		if err := namespaces.Enter(arguments.MasterPid, "ipc"); err != nil {
+		if err := namespaces.Enter(masterPid, "ipc"); err != nil {
 			return fmt.Errorf("while joining host IPC namespace: %s", err)
 		}
 	}
@@ -126,11 +154,7 @@ func (t *Methods) Decrypt(arguments *args.CryptArgs, reply *string) (err error)
 		}
 	}()
 
-	cryptName, err = cryptDev.Open(arguments.Key, arguments.Loopdev)
-
-	*reply = "/dev/mapper/" + cryptName
-
-	return err
+	return f()
 }
 
 // Mkdir performs a mkdir with the specified arguments.
@@ -203,16 +227,19 @@ func (t *Methods) Chroot(arguments *args.ChrootArgs, reply *int) (err error) {
 		}
 
 		sylog.Debugf("Apply slave mount propagation for host / directory")
+		sylog.Tracef(`mount("", ".", "", MS_SLAVE|MS_REC, "")`)
 		if err := syscall.Mount("", ".", "", syscall.MS_SLAVE|syscall.MS_REC, ""); err != nil {
 			return fmt.Errorf("failed to apply slave mount propagation for host / directory: %s", err)
 		}
 
 		sylog.Debugf("Called unmount(/, syscall.MNT_DETACH)\n")
+		sylog.Tracef(`umount2(".", MNT_DETACH)`)
 		if err := syscall.Unmount(".", syscall.MNT_DETACH); err != nil {
 			return fmt.Errorf("unmount pivot_root dir %s", err)
 		}
 	case "move":
 		sylog.Debugf("Move %s as / directory", root)
+		sylog.Tracef(`mount(".", "/", "", MS_MOVE, "")`)
 		if err := syscall.Mount(".", "/", "", syscall.MS_MOVE, ""); err != nil {
 			return fmt.Errorf("failed to move %s as / directory: %s", root, err)
 		}
@@ -412,3 +439,16 @@ func (t *Methods) WriteFile(arguments *args.WriteFileArgs, reply *int) error {
 	}
 	return err
 }
+
+// AppendFile appends the provided data to an existing file.
+func (t *Methods) AppendFile(arguments *args.AppendFileArgs, reply *int) error {
+	f, err := os.OpenFile(arguments.Filename, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %s", arguments.Filename, err)
+	}
+	_, err = f.Write(arguments.Data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/kr/pty"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// stdioDrainTimeout is the time given to relay the output left once the
+// container process exited, processes left in background and keeping the
+// output streams open don't delay the exit any longer.
+const stdioDrainTimeout = time.Second
+
+// streamCopy is a copy between a host stream and a stream of the
+// container process.
+type streamCopy struct {
+	dst io.Writer
+	src io.Reader
+	// output is set for the copies of the container process output,
+	// the container process input is closed at the end of the copy
+	// unless it's the pseudo terminal.
+	output bool
+}
+
+// stdioRelay relays the host standard streams to the container process
+// running in a pseudo terminal with --pty, or without terminal with
+// --no-pty. The container process runs in its own session in both cases,
+// the signals and the terminal size changes are forwarded by the engine.
+type stdioRelay struct {
+	// master is the pseudo terminal master, nil with --no-pty.
+	master *os.File
+	// raw is set if the host terminal is switched to raw mode while the
+	// container process runs in the pseudo terminal.
+	raw   bool
+	state *terminal.State
+	// stdio are the standard streams of the container process.
+	stdio [3]*os.File
+	// childFiles are the streams of the container process closed by the
+	// engine once the process started.
+	childFiles []*os.File
+	copies     []streamCopy
+	outputs    sync.WaitGroup
+}
+
+// hostTerminal returns the first of the host standard streams which
+// is a terminal, or nil if none of them is.
+func hostTerminal() *os.File {
+	for _, f := range []*os.File{os.Stdin, os.Stdout, os.Stderr} {
+		if terminal.IsTerminal(int(f.Fd())) {
+			return f
+		}
+	}
+	return nil
+}
+
+// newStdioRelay returns the relay of the standard streams of the container
+// process for --pty and --no-pty, or nil if none of them is set.
+func (e *EngineOperations) newStdioRelay() (*stdioRelay, error) {
+	if !e.EngineConfig.GetPty() && !e.EngineConfig.GetNoPty() {
+		return nil, nil
+	}
+	interactive := e.EngineConfig.GetInteractive()
+	r := new(stdioRelay)
+
+	if e.EngineConfig.GetPty() {
+		master, slave, err := pty.Open()
+		if err != nil {
+			return nil, fmt.Errorf("while allocating pseudo terminal: %s", err)
+		}
+		r.master = master
+		r.resize()
+
+		r.stdio = [3]*os.File{slave, slave, slave}
+		r.childFiles = append(r.childFiles, slave)
+		r.copies = append(r.copies, streamCopy{dst: os.Stdout, src: master, output: true})
+		if interactive {
+			r.raw = terminal.IsTerminal(int(os.Stdin.Fd()))
+			r.copies = append(r.copies, streamCopy{dst: master, src: os.Stdin})
+		}
+		return r, nil
+	}
+
+	// without terminal, the host streams which are terminals
+	// are replaced by pipes
+	r.stdio = [3]*os.File{os.Stdin, os.Stdout, os.Stderr}
+	if !interactive {
+		devNull, err := os.Open(os.DevNull)
+		if err != nil {
+			return nil, fmt.Errorf("while opening %s: %s", os.DevNull, err)
+		}
+		r.stdio[0] = devNull
+		r.childFiles = append(r.childFiles, devNull)
+	}
+	for i, f := range r.stdio {
+		if !terminal.IsTerminal(int(f.Fd())) {
+			continue
+		}
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return nil, fmt.Errorf("while creating pipe: %s", err)
+		}
+		if i == 0 {
+			r.stdio[i] = pr
+			r.childFiles = append(r.childFiles, pr)
+			r.copies = append(r.copies, streamCopy{dst: pw, src: f})
+		} else {
+			r.stdio[i] = pw
+			r.childFiles = append(r.childFiles, pw)
+			r.copies = append(r.copies, streamCopy{dst: f, src: pr, output: true})
+		}
+	}
+	return r, nil
+}
+
+// attach sets the standard streams of the container process cmd.
+func (r *stdioRelay) attach(cmd *exec.Cmd) {
+	if r == nil {
+		return
+	}
+	cmd.Stdin = r.stdio[0]
+	cmd.Stdout = r.stdio[1]
+	cmd.Stderr = r.stdio[2]
+
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	if r.master != nil {
+		cmd.SysProcAttr.Setctty = true
+		cmd.SysProcAttr.Ctty = 0
+	}
+}
+
+// start starts the relay once the container process started.
+func (r *stdioRelay) start() error {
+	if r == nil {
+		return nil
+	}
+	for _, f := range r.childFiles {
+		f.Close()
+	}
+
+	if r.raw {
+		state, err := terminal.MakeRaw(int(os.Stdin.Fd()))
+		if err != nil {
+			return fmt.Errorf("while setting terminal in raw mode: %s", err)
+		}
+		r.state = state
+	}
+
+	for _, c := range r.copies {
+		if c.output {
+			r.outputs.Add(1)
+		}
+		go func(c streamCopy) {
+			// reading the pseudo terminal master returns EIO once
+			// the container processes closed the terminal
+			io.Copy(c.dst, c.src)
+			if c.output {
+				r.outputs.Done()
+			} else if f, ok := c.dst.(*os.File); ok && f != r.master {
+				f.Close()
+			}
+		}(c)
+	}
+	return nil
+}
+
+// wait waits until the output of the container processes is relayed,
+// for stdioDrainTimeout at most once the container process exited, and
+// restores the host terminal.
+func (r *stdioRelay) wait() {
+	if r == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		r.outputs.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(stdioDrainTimeout):
+		runtimeLog.Debugf("Output streams still open after %s, closing them", stdioDrainTimeout)
+		// the pending reads of the pseudo terminal master can't be
+		// interrupted, the copies are left behind as the engine
+		// exits once the relay returns
+		for _, c := range r.copies {
+			if f, ok := c.src.(io.Closer); ok && c.output {
+				f.Close()
+			}
+		}
+	}
+
+	if r.state != nil {
+		terminal.Restore(int(os.Stdin.Fd()), r.state)
+	}
+}
+
+// resize applies the size of the host terminal to the pseudo terminal,
+// it returns false if the container process doesn't run in a pseudo
+// terminal.
+func (r *stdioRelay) resize() bool {
+	if r == nil || r.master == nil {
+		return false
+	}
+	if tty := hostTerminal(); tty != nil {
+		if err := pty.InheritSize(tty, r.master); err != nil {
+			runtimeLog.Debugf("Could not resize pseudo terminal: %s", err)
+		}
+	}
+	return true
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/kr/pty"
+	singularityConfig "github.com/sylabs/singularity/pkg/runtime/engine/singularity/config"
+)
+
+func TestStdioRelay(t *testing.T) {
+	// container process output relayed to the host
+	outR, outW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %s", err)
+	}
+	defer outR.Close()
+	// host input relayed to the container process
+	inR, inW, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("failed to create pipe: %s", err)
+	}
+	defer inR.Close()
+	// stream of the container process closed once started
+	child, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", os.DevNull, err)
+	}
+
+	var output bytes.Buffer
+	r := &stdioRelay{
+		childFiles: []*os.File{child},
+		copies: []streamCopy{
+			{dst: &output, src: outR, output: true},
+			{dst: inW, src: strings.NewReader("input data")},
+		},
+	}
+	if err := r.start(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := child.Close(); err == nil {
+		t.Errorf("container process stream not closed")
+	}
+
+	// the input pipe is closed at the end of the input
+	input, err := ioutil.ReadAll(inR)
+	if err != nil {
+		t.Fatalf("failed to read input: %s", err)
+	}
+	if string(input) != "input data" {
+		t.Errorf("got input %q instead of %q", input, "input data")
+	}
+
+	// wait returns once the output is relayed
+	if _, err := outW.Write([]byte("output data")); err != nil {
+		t.Fatalf("failed to write output: %s", err)
+	}
+	outW.Close()
+	r.wait()
+	if output.String() != "output data" {
+		t.Errorf("got output %q instead of %q", output.String(), "output data")
+	}
+}
+
+func TestStdioRelayBackground(t *testing.T) {
+	tests := []struct {
+		name string
+		pty  bool
+	}{
+		{name: "Pipe"},
+		{name: "Pty", pty: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var src, child *os.File
+			var err error
+			if tt.pty {
+				src, child, err = pty.Open()
+			} else {
+				src, child, err = os.Pipe()
+			}
+			if err != nil {
+				t.Fatalf("failed to create streams: %s", err)
+			}
+
+			// the background process keeps the output open
+			// once the container process exited
+			cmd := exec.Command("/bin/sh", "-c", "echo output; sleep 60 &")
+			cmd.Stdout = child
+			cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+			var output bytes.Buffer
+			r := &stdioRelay{
+				childFiles: []*os.File{child},
+				copies:     []streamCopy{{dst: &output, src: src, output: true}},
+			}
+			if err := cmd.Start(); err != nil {
+				t.Fatalf("failed to start process: %s", err)
+			}
+			defer syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+
+			if err := r.start(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if err := cmd.Wait(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			start := time.Now()
+			r.wait()
+			if d := time.Since(start); d > 2*stdioDrainTimeout {
+				t.Errorf("relay waited %s for the background process", d)
+			}
+			if !strings.Contains(output.String(), "output") {
+				t.Errorf("got output %q", output.String())
+			}
+		})
+	}
+}
+
+func TestNewStdioRelay(t *testing.T) {
+	tests := []struct {
+		name        string
+		noPty       bool
+		interactive bool
+		relay       bool
+		devNull     bool
+	}{
+		{name: "Default"},
+		{name: "NoPty", noPty: true, relay: true, devNull: true},
+		{name: "NoPtyInteractive", noPty: true, interactive: true, relay: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &EngineOperations{EngineConfig: singularityConfig.NewConfig()}
+			e.EngineConfig.SetNoPty(tt.noPty)
+			e.EngineConfig.SetInteractive(tt.interactive)
+
+			r, err := e.newStdioRelay()
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if (r != nil) != tt.relay {
+				t.Fatalf("unexpected relay %v", r)
+			} else if r == nil {
+				return
+			}
+			defer func() {
+				for _, f := range r.childFiles {
+					f.Close()
+				}
+			}()
+
+			if r.master != nil {
+				t.Errorf("unexpected pseudo terminal without terminal")
+			}
+			if devNull := r.stdio[0].Name() == os.DevNull; devNull != tt.devNull {
+				t.Errorf("unexpected standard input %s", r.stdio[0].Name())
+			}
+		})
+	}
+}
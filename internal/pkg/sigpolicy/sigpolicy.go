@@ -0,0 +1,225 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package sigpolicy implements the signature policy of singularity.conf,
+// which admits the images signed by the keys of an administrator controlled
+// keyring before they're mounted, unless they're exempted by their path,
+// URI or digest.
+package sigpolicy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sylabs/sif/pkg/integrity"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/pkg/image"
+	"github.com/sylabs/singularity/pkg/sypgp"
+	"github.com/sylabs/singularity/pkg/util/singularityconf"
+	"golang.org/x/crypto/openpgp"
+)
+
+// Policy modes.
+const (
+	// None disables the signature policy.
+	None = "none"
+	// Warn runs the images not signed by a trusted key with a warning.
+	Warn = "warn"
+	// Enforce refuses the images not signed by a trusted key.
+	Enforce = "enforce"
+)
+
+// Policy is the signature policy of singularity.conf.
+type Policy struct {
+	// Mode is Warn or Enforce.
+	Mode string
+	// KeyRing holds the trusted public keys.
+	KeyRing openpgp.EntityList
+	// ExemptPaths are the patterns of the exempted image paths.
+	ExemptPaths []string
+	// ExemptURIs are the patterns of the exempted image URIs.
+	ExemptURIs []string
+	// ExemptDigests are the sha256 digests of the exempted images.
+	ExemptDigests []string
+}
+
+// FromConfig returns the signature policy of the configuration c, or nil
+// if it's disabled. The keyring must be owned by root if rootOwned is true
+// (eg: for the setuid workflow).
+func FromConfig(c *singularityconf.File, rootOwned bool) (*Policy, error) {
+	switch c.SignaturePolicy {
+	case None, "":
+		return nil, nil
+	case Warn, Enforce:
+	default:
+		return nil, fmt.Errorf("unknown signature policy %q", c.SignaturePolicy)
+	}
+
+	if c.SignatureKeyring == "" {
+		return nil, fmt.Errorf("'signature keyring' must be set with 'signature policy = %s'", c.SignaturePolicy)
+	}
+	if rootOwned && !fs.IsOwner(c.SignatureKeyring, 0) {
+		return nil, fmt.Errorf("%s must be owned by root", c.SignatureKeyring)
+	}
+	kr, err := sypgp.LoadKeysFromFile(c.SignatureKeyring)
+	if err != nil {
+		return nil, fmt.Errorf("while reading signature keyring %s: %v", c.SignatureKeyring, err)
+	}
+
+	for _, d := range c.SignatureExemptDigest {
+		if !strings.HasPrefix(d, "sha256:") {
+			return nil, fmt.Errorf("signature exempt digest %s is not a sha256 digest", d)
+		}
+	}
+
+	return &Policy{
+		Mode:          c.SignaturePolicy,
+		KeyRing:       kr,
+		ExemptPaths:   c.SignatureExemptPath,
+		ExemptURIs:    c.SignatureExemptURI,
+		ExemptDigests: c.SignatureExemptDigest,
+	}, nil
+}
+
+// Exemption returns the reason the image img run from uri is exempted from
+// the policy (eg: "path /opt/images/*"), or an empty string if it isn't.
+// The digest of the image is only computed if the policy exempts digests.
+// As the URI is provided by the user and the image pulled from it lies in
+// a user owned cache, uri must only be set for the images run by root.
+func (p *Policy) Exemption(img *image.Image, uri string) (string, error) {
+	for _, pattern := range p.ExemptPaths {
+		if match(pattern, img.Path) {
+			return "path " + pattern, nil
+		}
+	}
+	if uri != "" {
+		for _, pattern := range p.ExemptURIs {
+			if match(pattern, uri) {
+				return "uri " + pattern, nil
+			}
+		}
+	}
+	if len(p.ExemptDigests) == 0 || img.Type == image.SANDBOX {
+		return "", nil
+	}
+
+	digest, err := Digest(img.File)
+	if err != nil {
+		return "", fmt.Errorf("while computing image digest: %v", err)
+	}
+	for _, d := range p.ExemptDigests {
+		if d == digest {
+			return "digest " + d, nil
+		}
+	}
+	return "", nil
+}
+
+// match returns true if s matches the shell pattern, a trailing * also
+// matches the following path components.
+func match(pattern, s string) bool {
+	if ok, _ := filepath.Match(pattern, s); ok {
+		return true
+	}
+	if !strings.HasSuffix(pattern, "*") {
+		return false
+	}
+	for i := range s {
+		if s[i] != '/' {
+			continue
+		}
+		if ok, _ := filepath.Match(pattern, s[:i]); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Digest returns the sha256 digest of the content of f.
+func Digest(f *os.File) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, 1<<63-1)); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Verify verifies the signature of the object group of the primary system
+// partition of the SIF image f with the keys of kr, it returns the
+// fingerprints of the signing keys. Legacy signatures of the partition are
+// accepted if the group isn't signed.
+func Verify(f *os.File, kr openpgp.KeyRing) ([][20]byte, error) {
+	fimg, err := sif.LoadContainerFp(f, true)
+	if err != nil {
+		return nil, err
+	}
+
+	od, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		return nil, fmt.Errorf("while getting primary system partition: %v", err)
+	}
+
+	return verify(&fimg, []integrity.VerifierOpt{
+		integrity.OptVerifyWithKeyRing(kr),
+		integrity.OptVerifyGroup(od.Groupid &^ sif.DescrGroupMask),
+	}, []integrity.VerifierOpt{
+		integrity.OptVerifyWithKeyRing(kr),
+		integrity.OptVerifyLegacy(),
+		integrity.OptVerifyObject(od.ID),
+	})
+}
+
+// VerifyAll verifies the signatures of all the object groups of the SIF
+// image f with the keys of kr, for the images which aren't mounted from
+// their primary system partition (eg: overlay or data images). It returns
+// the fingerprints of the keys signing all the groups. Legacy signatures
+// of the partitions are accepted if the groups aren't signed.
+func VerifyAll(f *os.File, kr openpgp.KeyRing) ([][20]byte, error) {
+	fimg, err := sif.LoadContainerFp(f, true)
+	if err != nil {
+		return nil, err
+	}
+
+	legacyOpts := []integrity.VerifierOpt{
+		integrity.OptVerifyWithKeyRing(kr),
+		integrity.OptVerifyLegacy(),
+	}
+	for _, od := range fimg.DescrArr {
+		if od.Used && od.Datatype == sif.DataPartition && od.Groupid != sif.DescrUnusedGroup {
+			legacyOpts = append(legacyOpts, integrity.OptVerifyObject(od.ID))
+		}
+	}
+
+	return verify(&fimg, []integrity.VerifierOpt{
+		integrity.OptVerifyWithKeyRing(kr),
+	}, legacyOpts)
+}
+
+// verify verifies fimg with opts, or with legacyOpts if the
+// image doesn't hold any signature.
+func verify(fimg *sif.FileImage, opts, legacyOpts []integrity.VerifierOpt) ([][20]byte, error) {
+	v, err := integrity.NewVerifier(fimg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	err = v.Verify()
+	if errors.Is(err, &integrity.SignatureNotFoundError{}) {
+		if v, err = integrity.NewVerifier(fimg, legacyOpts...); err != nil {
+			return nil, err
+		}
+		err = v.Verify()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v.AllSignedBy()
+}
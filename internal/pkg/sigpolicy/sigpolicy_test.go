@@ -0,0 +1,155 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sigpolicy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/singularity/pkg/image"
+	"github.com/sylabs/singularity/pkg/util/singularityconf"
+	"golang.org/x/crypto/openpgp"
+)
+
+var keyringPath = filepath.Join("testdata", "keys", "private.asc")
+
+func TestFromConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		conf     singularityconf.File
+		wantNil  bool
+		wantErr  bool
+		wantKeys int
+	}{
+		{"None", singularityconf.File{SignaturePolicy: None}, true, false, 0},
+		{"NoKeyring", singularityconf.File{SignaturePolicy: Enforce}, false, true, 0},
+		{"BadKeyring", singularityconf.File{SignaturePolicy: Warn, SignatureKeyring: "/non/existent"}, false, true, 0},
+		{"BadMode", singularityconf.File{SignaturePolicy: "deny", SignatureKeyring: keyringPath}, false, true, 0},
+		{
+			"BadDigest",
+			singularityconf.File{SignaturePolicy: Enforce, SignatureKeyring: keyringPath, SignatureExemptDigest: []string{"md5:00"}},
+			false, true, 0,
+		},
+		{"Enforce", singularityconf.File{SignaturePolicy: Enforce, SignatureKeyring: keyringPath}, false, false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := FromConfig(&tt.conf, false)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if (p == nil) != tt.wantNil {
+				t.Fatalf("got policy %v", p)
+			}
+			if p != nil && len(p.KeyRing) != tt.wantKeys {
+				t.Errorf("got %d keys, want %d", len(p.KeyRing), tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestExemption(t *testing.T) {
+	path, err := filepath.Abs(filepath.Join("testdata", "images", "one-group.sif"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	digest, err := Digest(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := &image.Image{Path: path, File: f, Type: image.SIF}
+
+	tests := []struct {
+		name string
+		p    Policy
+		uri  string
+		want string
+	}{
+		{"None", Policy{}, "", ""},
+		{"Path", Policy{ExemptPaths: []string{filepath.Dir(path) + "/*"}}, "", "path " + filepath.Dir(path) + "/*"},
+		{"PathComponents", Policy{ExemptPaths: []string{"/*"}}, "", "path /*"},
+		{"PathMismatch", Policy{ExemptPaths: []string{"/opt/images/*.sif"}}, "", ""},
+		{"URI", Policy{ExemptURIs: []string{"library://sylabs/*"}}, "library://sylabs/examples/lolcow", "uri library://sylabs/*"},
+		{"URIMismatch", Policy{ExemptURIs: []string{"library://sylabs/*"}}, "library://alpine", ""},
+		{"NoURI", Policy{ExemptURIs: []string{"*"}}, "", ""},
+		{"Digest", Policy{ExemptDigests: []string{digest}}, "", "digest " + digest},
+		{"DigestMismatch", Policy{ExemptDigests: []string{"sha256:00"}}, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.p.Exemption(img, tt.uri)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("got exemption %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	p, err := FromConfig(&singularityconf.File{SignaturePolicy: Enforce, SignatureKeyring: keyringPath}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp := p.KeyRing[0].PrimaryKey.Fingerprint
+
+	tests := []struct {
+		name    string
+		image   string
+		kr      openpgp.EntityList
+		all     bool
+		wantErr bool
+	}{
+		{"Signed", "one-group-signed.sif", p.KeyRing, false, false},
+		{"LegacySigned", "one-group-legacy-signed.sif", p.KeyRing, false, false},
+		{"Unsigned", "one-group.sif", p.KeyRing, false, true},
+		{"UntrustedKey", "one-group-signed.sif", openpgp.EntityList{}, false, true},
+		{"AllSigned", "one-group-signed.sif", p.KeyRing, true, false},
+		// only the primary system partition has a legacy signature
+		{"AllLegacyPartlySigned", "one-group-legacy-signed.sif", p.KeyRing, true, true},
+		{"AllUnsigned", "one-group.sif", p.KeyRing, true, true},
+		{"AllUntrustedKey", "one-group-signed.sif", openpgp.EntityList{}, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.Open(filepath.Join("testdata", "images", tt.image))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			verify := Verify
+			if tt.all {
+				verify = VerifyAll
+			}
+			fps, err := verify(f, tt.kr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("got error %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(fps) != 1 || fps[0] != fp {
+				t.Errorf("unexpected fingerprints %x", fps)
+			}
+		})
+	}
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/util/env"
 	"github.com/sylabs/singularity/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/util/singularityconf"
 )
@@ -88,3 +89,30 @@ func cryptsetup(cfgpath string) (string, error) {
 	// use exec.LookPath to verify it's an executable.
 	return exec.LookPath(path)
 }
+
+// Veritysetup returns the absolute path to the "veritysetup" program,
+// it's installed along with cryptsetup which is looked up first.
+func Veritysetup() (string, error) {
+	cryptsetup, err := Cryptsetup()
+	if err != nil {
+		return "", errors.Wrap(err, "veritysetup is installed with cryptsetup")
+	}
+	return exec.LookPath(filepath.Join(filepath.Dir(cryptsetup), "veritysetup"))
+}
+
+// NvidiaContainerCli returns the absolute path to the nvidia-container-cli
+// program set by the 'nvidia-container-cli path' directive of cfg, or
+// found in the default path if the directive is unset. The program is
+// run with privileges, the user PATH is never searched.
+func NvidiaContainerCli(cfg *singularityconf.File) (string, error) {
+	if cfg.NvidiaContainerCliPath != "" {
+		return exec.LookPath(cfg.NvidiaContainerCliPath)
+	}
+	for _, dir := range filepath.SplitList(env.DefaultPath) {
+		path := filepath.Join(dir, "nvidia-container-cli")
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+			return path, nil
+		}
+	}
+	return "", errors.Errorf("nvidia-container-cli not found in %s", env.DefaultPath)
+}
@@ -6,6 +6,9 @@
 package env
 
 import (
+	"fmt"
+	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/sylabs/singularity/internal/pkg/runtime/engine/config/oci/generate"
@@ -45,12 +48,34 @@ var alwaysOmitKeys = map[string]bool{
 	"LD_LIBRARY_PATH":     true,
 }
 
+// validName matches the variable names which could be exported by
+// the container shell.
+var validName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// HostEnv defines the forwarding of the host environment variables.
+type HostEnv struct {
+	// Clean forwards only the variables always passed and those
+	// matching Preserve.
+	Clean bool
+	// Preserve are the shell patterns of the variable names forwarded
+	// with a clean environment.
+	Preserve []string
+	// OverrideImage gives precedence to the forwarded variables over those
+	// defined by the image environment, the SINGULARITYENV_ variables still
+	// take precedence over both.
+	OverrideImage bool
+}
+
 // SetContainerEnv cleans environment variables before running the container.
-func SetContainerEnv(g *generate.Generator, hostEnvs []string, cleanEnv bool, homeDest string) map[string]string {
+// The returned variables are set after the image environment, by order of
+// precedence they are the SINGULARITYENV_ variables and, if hostEnv gives
+// them precedence, the forwarded host variables.
+func SetContainerEnv(g *generate.Generator, hostEnvs []string, hostEnv HostEnv, homeDest string) map[string]string {
 	singEnvKeys := make(map[string]string)
+	forwarded := make(map[string]string)
 
 	// allow override with SINGULARITYENV_LANG
-	if cleanEnv {
+	if hostEnv.Clean {
 		g.AddProcessEnv("LANG", "C")
 	}
 
@@ -89,11 +114,21 @@ func SetContainerEnv(g *generate.Generator, hostEnvs []string, cleanEnv bool, ho
 			// precedence over the non prefixed variables
 			if _, ok := singEnvKeys[e[0]]; ok {
 				sylog.Verbosef("Skipping %[1]s environment variable, overridden by %[2]s%[1]s", e[0], SingularityEnvPrefix)
-			} else if addHostEnv(e[0], cleanEnv) {
+			} else if addHostEnv(e[0], hostEnv) {
 				// transpose host env variables into config
 				sylog.Debugf("Forwarding %s environment variable", e[0])
 				g.AddProcessEnv(e[0], e[1])
+				forwarded[e[0]] = e[1]
+			}
+		}
+	}
+
+	if hostEnv.OverrideImage {
+		for key, value := range forwarded {
+			if _, ok := singEnvKeys[key]; ok || !validName.MatchString(key) {
+				continue
 			}
+			singEnvKeys[key] = value
 		}
 	}
 
@@ -107,12 +142,31 @@ func SetContainerEnv(g *generate.Generator, hostEnvs []string, cleanEnv bool, ho
 
 // addHostEnv processes given key and returns if the environment
 // variable should be added to the container or not.
-func addHostEnv(key string, cleanEnv bool) bool {
+func addHostEnv(key string, hostEnv HostEnv) bool {
 	if _, ok := alwaysPassKeys[key]; ok {
 		return true
 	}
-	if _, ok := alwaysOmitKeys[key]; ok || cleanEnv {
+	if _, ok := alwaysOmitKeys[key]; ok {
 		return false
 	}
-	return true
+	if !hostEnv.Clean {
+		return true
+	}
+	for _, pattern := range hostEnv.Preserve {
+		if ok, _ := filepath.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckPatterns returns an error if one of the shell patterns of variable
+// names is malformed.
+func CheckPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("bad pattern %q: %s", pattern, err)
+		}
+	}
+	return nil
 }
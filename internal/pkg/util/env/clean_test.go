@@ -22,6 +22,8 @@ func TestSetContainerEnv(t *testing.T) {
 	tt := []struct {
 		name           string
 		cleanEnv       bool
+		preserve       []string
+		overrideImage  bool
 		homeDest       string
 		env            []string
 		resultEnv      []string
@@ -300,13 +302,59 @@ func TestSetContainerEnv(t *testing.T) {
 				"HOST": "myhostenv",
 			},
 		},
+		{
+			name:     "preserve envs with cleanenv",
+			cleanEnv: true,
+			preserve: []string{"SLURM_*", "FOO"},
+			homeDest: "/home/tester",
+			env: []string{
+				"SLURM_JOB_ID=42",
+				"FOO=foo",
+				"FOOBAR=foobar",
+				"LD_LIBRARY_PATH=/my/libs",
+			},
+			resultEnv: []string{
+				"LANG=C",
+				"SLURM_JOB_ID=42",
+				"FOO=foo",
+				"HOME=/home/tester",
+				"PATH=" + DefaultPath,
+			},
+			singularityEnv: map[string]string{},
+		},
+		{
+			name:          "host precedence over image",
+			overrideImage: true,
+			homeDest:      "/home/tester",
+			env: []string{
+				"FOO=foo",
+				"HOST=myhost",
+				"SINGULARITYENV_HOST=myhostenv",
+				"BASH_FUNC_f%%=() { :; }",
+				"PATH=/usr/bin",
+			},
+			resultEnv: []string{
+				"FOO=foo",
+				"BASH_FUNC_f%%=() { :; }",
+				"HOME=/home/tester",
+				"PATH=" + DefaultPath,
+			},
+			singularityEnv: map[string]string{
+				"FOO":  "foo",
+				"HOST": "myhostenv",
+			},
+		},
 	}
 	for _, tc := range tt {
 		t.Run(tc.name, func(t *testing.T) {
 			ociConfig := &oci.Config{}
 			generator := generate.New(&ociConfig.Spec)
 
-			senv := SetContainerEnv(generator, tc.env, tc.cleanEnv, tc.homeDest)
+			senv := SetContainerEnv(generator, tc.env, HostEnv{
+				Clean:         tc.cleanEnv,
+				Preserve:      tc.preserve,
+				OverrideImage: tc.overrideImage,
+			}, tc.homeDest)
 			if !equal(t, ociConfig.Process.Env, tc.resultEnv) {
 				t.Fatalf("unexpected envs:\n want: %v\ngot: %v", tc.resultEnv, ociConfig.Process.Env)
 			}
@@ -0,0 +1,65 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package erofs provides the tools creating and mounting erofs root
+// filesystems, an alternative to squashfs with better random read
+// performance for images holding many small files.
+package erofs
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// GetPath returns the path of the mkfs.erofs program found in PATH.
+func GetPath() (string, error) {
+	path, err := exec.LookPath("mkfs.erofs")
+	if err != nil {
+		return "", fmt.Errorf("mkfs.erofs not found, erofs-utils must be installed: %s", err)
+	}
+	return path, nil
+}
+
+// compressions maps the squashfs compression algorithms supported for
+// builds to the erofs ones and their compression level range, algorithms
+// without levels have a zero range.
+var compressions = map[string]struct {
+	name   string
+	levels [2]int
+}{
+	"gzip": {"deflate", [2]int{1, 9}},
+	"zstd": {"zstd", [2]int{1, 22}},
+	"lz4":  {"lz4hc", [2]int{0, 0}},
+	"xz":   {"lzma", [2]int{0, 0}},
+	"none": {"", [2]int{0, 0}},
+}
+
+// CompressionFlags returns the mkfs.erofs flags to compress an image with
+// the erofs algorithm matching the squashfs algorithm comp (gzip, zstd, lz4,
+// xz or none) at the compression level, a zero level selects the algorithm
+// default level. An empty algorithm selects lz4, fast to decompress and
+// supported by all the kernels with erofs.
+func CompressionFlags(comp string, level int) ([]string, error) {
+	if comp == "" {
+		comp = "lz4"
+	}
+	c, ok := compressions[comp]
+	if !ok {
+		return nil, fmt.Errorf("unsupported erofs compression %q, supported values are gzip, zstd, lz4, xz and none", comp)
+	}
+	if level == 0 {
+		if c.name == "" {
+			return nil, nil
+		}
+		return []string{"-z" + c.name}, nil
+	}
+
+	if c.levels[1] == 0 {
+		return nil, fmt.Errorf("%s compression doesn't support compression levels", comp)
+	} else if level < c.levels[0] || level > c.levels[1] {
+		return nil, fmt.Errorf("%s compression level must be between %d and %d", comp, c.levels[0], c.levels[1])
+	}
+	return []string{fmt.Sprintf("-z%s,%d", c.name, level)}, nil
+}
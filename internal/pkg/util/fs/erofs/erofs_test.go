@@ -0,0 +1,72 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package erofs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompressionFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		comp       string
+		level      int
+		shouldFail bool
+		expected   []string
+	}{
+		{
+			name:     "default compression",
+			expected: []string{"-zlz4hc"},
+		},
+		{
+			name:     "zstd level",
+			comp:     "zstd",
+			level:    19,
+			expected: []string{"-zzstd,19"},
+		},
+		{
+			name:     "gzip",
+			comp:     "gzip",
+			expected: []string{"-zdeflate"},
+		},
+		{
+			name: "no compression",
+			comp: "none",
+		},
+		{
+			name:       "gzip bad level",
+			comp:       "gzip",
+			level:      19,
+			shouldFail: true,
+		},
+		{
+			name:       "lz4 level",
+			comp:       "lz4",
+			level:      6,
+			shouldFail: true,
+		},
+		{
+			name:       "unsupported compression",
+			comp:       "lzo",
+			shouldFail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, err := CompressionFlags(tt.comp, tt.level)
+			if err != nil && !tt.shouldFail {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.shouldFail {
+				t.Fatalf("unexpected success")
+			}
+			if !reflect.DeepEqual(flags, tt.expected) {
+				t.Errorf("unexpected flags %v instead of %v", flags, tt.expected)
+			}
+		})
+	}
+}
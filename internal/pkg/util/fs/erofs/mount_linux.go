@@ -0,0 +1,204 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package erofs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sylabs/singularity/internal/pkg/util/fs/fuse"
+	"github.com/sylabs/singularity/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/util/fs/proc"
+)
+
+const (
+	// fuseEnv holds the arguments of the process running erofsfuse.
+	fuseEnv = "SINGULARITY_EROFSFUSE"
+	// fuseReadyFd is the descriptor where the process running erofsfuse
+	// writes a null byte once the image is mounted, or an error message.
+	fuseReadyFd = 3
+	// fuseMountTimeout is the time erofsfuse has to mount the image.
+	fuseMountTimeout = 30 * time.Second
+)
+
+// fuseArgs are the arguments of the process running erofsfuse.
+type fuseArgs struct {
+	Image      string `json:"image"`
+	Offset     uint64 `json:"offset"`
+	Mountpoint string `json:"mountpoint"`
+	AllowRoot  bool   `json:"allowRoot"`
+}
+
+// KernelSupport returns true if the kernel mounts erofs filesystems.
+func KernelSupport() bool {
+	has, err := proc.HasFilesystem("erofs")
+	return err == nil && has
+}
+
+// MountFuse mounts the erofs filesystem at offset in image with erofsfuse
+// in a directory of tmpDir and returns it. The filesystem is mounted until
+// the calling process exits and the directory is no longer used, allowRoot
+// lets root access the directory (eg: the setuid starter).
+func MountFuse(image string, offset uint64, tmpDir string, allowRoot bool) (string, error) {
+	if _, err := exec.LookPath("erofsfuse"); err != nil {
+		return "", fmt.Errorf("erofsfuse not found, erofs-utils must be installed: %s", err)
+	}
+
+	mountpoint, err := ioutil.TempDir(tmpDir, "erofs-rootfs-")
+	if err != nil {
+		return "", fmt.Errorf("while creating mount point: %v", err)
+	}
+
+	b, err := json.Marshal(fuseArgs{
+		Image:      image,
+		Offset:     offset,
+		Mountpoint: mountpoint,
+		AllowRoot:  allowRoot,
+	})
+	if err != nil {
+		os.Remove(mountpoint)
+		return "", err
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		os.Remove(mountpoint)
+		return "", err
+	}
+	defer r.Close()
+
+	c := &exec.Cmd{
+		Path:       "/proc/self/exe",
+		Args:       []string{os.Args[0]},
+		Env:        append(append(os.Environ(), sylog.GetEnvVars()...), fuseEnv+"="+string(b)),
+		Stderr:     os.Stderr,
+		ExtraFiles: []*os.File{w},
+		// the image is still mounted when the container is interrupted
+		SysProcAttr: &syscall.SysProcAttr{Setsid: true},
+	}
+	err = c.Start()
+	w.Close()
+	if err != nil {
+		os.Remove(mountpoint)
+		return "", fmt.Errorf("while starting erofsfuse process: %v", err)
+	}
+
+	msg, _ := ioutil.ReadAll(r)
+	if len(msg) != 1 || msg[0] != 0 {
+		c.Wait()
+		os.Remove(mountpoint)
+		if len(msg) == 0 {
+			return "", errors.New("erofsfuse process exited unexpectedly")
+		}
+		return "", errors.New(string(msg))
+	}
+	c.Process.Release()
+
+	return mountpoint, nil
+}
+
+// FuseChild runs erofsfuse for a process started by MountFuse and exits,
+// it returns immediately otherwise. It must be called at the beginning
+// of main.
+func FuseChild() {
+	v, ok := os.LookupEnv(fuseEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(fuseEnv)
+
+	ready := os.NewFile(fuseReadyFd, "ready")
+	if err := runFuse(v, ready); err != nil {
+		// the error is reported by MountFuse until the image is mounted
+		if _, werr := ready.Write([]byte(err.Error())); werr != nil {
+			sylog.Errorf("While running erofsfuse: %v", err)
+		}
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// runFuse mounts the image described by the JSON arguments v with
+// erofsfuse, a null byte is written to ready once the image is mounted.
+func runFuse(v string, ready *os.File) error {
+	var args fuseArgs
+	if err := json.Unmarshal([]byte(v), &args); err != nil {
+		return fmt.Errorf("while reading erofsfuse arguments: %v", err)
+	}
+	defer os.Remove(args.Mountpoint)
+
+	path, err := exec.LookPath("erofsfuse")
+	if err != nil {
+		return err
+	}
+	opts := "ro,nosuid,nodev"
+	if args.AllowRoot {
+		opts += ",allow_root"
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, "-f", fmt.Sprintf("--offset=%d", args.Offset), "-o", opts, args.Image, args.Mountpoint)
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("while starting %s: %v", path, err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	// the mount point device changes once the image is mounted
+	var parent syscall.Stat_t
+	if err := syscall.Stat(filepath.Dir(args.Mountpoint), &parent); err != nil {
+		cmd.Process.Kill()
+		return err
+	}
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		select {
+		case err := <-exited:
+			return fmt.Errorf("%s failed: %v: %s", path, err, strings.TrimSpace(stderr.String()))
+		default:
+		}
+		var st syscall.Stat_t
+		if err := syscall.Stat(args.Mountpoint, &st); err == nil && st.Dev != parent.Dev {
+			break
+		}
+		if time.Since(start) > fuseMountTimeout {
+			cmd.Process.Kill()
+			return fmt.Errorf("%s didn't mount the image after %s", path, fuseMountTimeout)
+		}
+	}
+	ready.Write([]byte{0})
+	ready.Close()
+
+	// unmount once the container process started by the parent exited,
+	// erofsfuse exits when the image is no longer used
+	ppid := os.Getppid()
+	for os.Getppid() == ppid {
+		select {
+		case err := <-exited:
+			if err != nil {
+				return fmt.Errorf("%s failed: %v: %s", path, err, strings.TrimSpace(stderr.String()))
+			}
+			return nil
+		case <-time.After(time.Second):
+		}
+	}
+	if err := fuse.Unmount(args.Mountpoint); err != nil {
+		sylog.Debugf("While unmounting erofs image: %v", err)
+	}
+	<-exited
+	return nil
+}
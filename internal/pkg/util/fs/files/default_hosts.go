@@ -5,6 +5,13 @@
 
 package files
 
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
 var defaultContent = `127.0.0.1   localhost
 ::1         localhost ip6-localhost ip6-loopback
 ff02::1     ip6-allnodes
@@ -15,3 +22,29 @@ ff02::2     ip6-allrouters
 func DefaultHosts() []byte {
 	return []byte(defaultContent)
 }
+
+// Hosts appends to the hosts file content the entries hosts of the form
+// <name>:<IP> and returns it, an IPv6 address may be enclosed in brackets.
+func Hosts(content []byte, hosts []string) ([]byte, error) {
+	r := regexp.MustCompile(hostRegex)
+
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		content = append(content, '\n')
+	}
+	for _, h := range hosts {
+		splitted := strings.SplitN(h, ":", 2)
+		if len(splitted) != 2 {
+			return nil, fmt.Errorf("host entry %s must be of the form <name>:<IP>", h)
+		}
+		name := splitted[0]
+		ip := strings.TrimSuffix(strings.TrimPrefix(splitted[1], "["), "]")
+		if !r.MatchString(name) {
+			return nil, fmt.Errorf("%s is not a valid hostname", name)
+		}
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("host %s IP %s is not a valid IP address", name, ip)
+		}
+		content = append(content, fmt.Sprintf("%s\t%s\n", ip, name)...)
+	}
+	return content, nil
+}
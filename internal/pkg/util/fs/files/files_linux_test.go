@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/sylabs/singularity/internal/pkg/test"
@@ -114,3 +115,85 @@ func TestResolvConf(t *testing.T) {
 		t.Errorf("ResolvConf returns a bad content")
 	}
 }
+
+func TestResolvConfSearch(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	host := []byte("domain example.org\nnameserver 8.8.8.8\nsearch example.org\n")
+
+	_, err := ResolvConfSearch(host, []string{})
+	if err == nil {
+		t.Errorf("should have failed with empty search domains")
+	}
+	_, err = ResolvConfSearch(host, []string{"bad|domain"})
+	if err == nil {
+		t.Errorf("should have failed with bad search domain")
+	}
+	content, err := ResolvConfSearch(host, []string{"a.example.com", "example.com"})
+	if err != nil {
+		t.Errorf("should have passed with valid search domains: %s", err)
+	}
+	if !bytes.Equal(content, []byte("nameserver 8.8.8.8\nsearch a.example.com example.com\n")) {
+		t.Errorf("ResolvConfSearch returns a bad content: %q", content)
+	}
+}
+
+func TestHosts(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	_, err := Hosts(nil, []string{"db"})
+	if err == nil {
+		t.Errorf("should have failed with missing IP")
+	}
+	_, err = Hosts(nil, []string{"bad|host:10.0.0.1"})
+	if err == nil {
+		t.Errorf("should have failed with bad hostname")
+	}
+	_, err = Hosts(nil, []string{"db:10.0.0"})
+	if err == nil {
+		t.Errorf("should have failed with bad IP")
+	}
+	content, err := Hosts([]byte("127.0.0.1 localhost"), []string{"db:10.0.0.1", "web:[fd00::1]", "api:fd00::2"})
+	if err != nil {
+		t.Errorf("should have passed with valid host entries: %s", err)
+	}
+	if !bytes.Equal(content, []byte("127.0.0.1 localhost\n10.0.0.1\tdb\nfd00::1\tweb\nfd00::2\tapi\n")) {
+		t.Errorf("Hosts returns a bad content: %q", content)
+	}
+}
+
+func TestLookupUser(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	passwd := []byte("root:x:0:0:root:/root:/bin/sh\n# comment\npostgres:x:70:70:PostgreSQL:/var/lib/postgresql:/bin/sh\n")
+	group := []byte("root:x:0:\npostgres:x:70:\nssl-cert:x:101:postgres,www\nwww:x:33:\n")
+
+	tests := []struct {
+		spec    string
+		want    ContainerUser
+		wantErr bool
+	}{
+		{spec: "postgres", want: ContainerUser{Name: "postgres", UID: 70, GIDs: []int{70, 101}, Home: "/var/lib/postgresql"}},
+		{spec: "70", want: ContainerUser{Name: "postgres", UID: 70, GIDs: []int{70, 101}, Home: "/var/lib/postgresql"}},
+		{spec: "postgres:www", want: ContainerUser{Name: "postgres", UID: 70, GIDs: []int{33, 101}, Home: "/var/lib/postgresql"}},
+		{spec: "1000", want: ContainerUser{UID: 1000, GIDs: []int{1000}}},
+		{spec: "1000:33", want: ContainerUser{UID: 1000, GIDs: []int{33}}},
+		{spec: "nobody", wantErr: true},
+		{spec: "postgres:nogroup", wantErr: true},
+		{spec: "postgres:", wantErr: true},
+		{spec: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := LookupUser(passwd, group, tt.spec)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("spec %q: got error %v, want error %v", tt.spec, err, tt.wantErr)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("spec %q: got user %+v, want %+v", tt.spec, *got, tt.want)
+		}
+	}
+}
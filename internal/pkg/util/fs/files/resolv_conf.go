@@ -6,8 +6,12 @@
 package files
 
 import (
+	"bufio"
+	"bytes"
 	"fmt"
 	"net"
+	"regexp"
+	"strings"
 
 	"github.com/sylabs/singularity/pkg/sylog"
 )
@@ -27,3 +31,33 @@ func ResolvConf(dns []string) (content []byte, err error) {
 	}
 	return content, nil
 }
+
+// ResolvConfSearch replaces the search domains of the resolv.conf content
+// with domains and returns it.
+func ResolvConfSearch(content []byte, domains []string) ([]byte, error) {
+	if len(domains) == 0 {
+		return content, fmt.Errorf("no search domain provided")
+	}
+	r := regexp.MustCompile(hostRegex)
+	for _, d := range domains {
+		if !r.MatchString(d) {
+			return content, fmt.Errorf("search domain %s is not a valid domain name", d)
+		}
+	}
+
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		// the last search or domain keyword prevails
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 && (fields[0] == "search" || fields[0] == "domain") {
+			continue
+		}
+		buf.WriteString(scanner.Text() + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return content, err
+	}
+	buf.WriteString("search " + strings.Join(domains, " ") + "\n")
+	return buf.Bytes(), nil
+}
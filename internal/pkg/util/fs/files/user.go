@@ -0,0 +1,116 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package files
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ContainerUser is an account of the passwd and group files of a container.
+type ContainerUser struct {
+	// Name is empty for a user ID without passwd entry.
+	Name string
+	UID  int
+	// GIDs are the primary group ID followed by the supplementary
+	// group IDs.
+	GIDs []int
+	// Home is empty for a user ID without passwd entry.
+	Home string
+}
+
+// entries returns the colon separated fields of the lines of content
+// having at least n fields, comments and empty lines are ignored.
+func entries(content []byte, n int) [][]string {
+	var fields [][]string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if f := strings.Split(line, ":"); len(f) >= n {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// LookupUser returns the account of the specification spec of the form
+// 'name|uid[:group|gid]' found in the passwd and group file contents.
+// Numeric IDs don't require an entry, the group ID of a user ID without
+// passwd entry defaults to the user ID. The supplementary groups are the
+// groups of the group file listing the user name as a member.
+func LookupUser(passwd, group []byte, spec string) (*ContainerUser, error) {
+	splitted := strings.SplitN(spec, ":", 2)
+	if splitted[0] == "" || (len(splitted) == 2 && splitted[1] == "") {
+		return nil, fmt.Errorf("user spec %q must be of the form name|uid[:group|gid]", spec)
+	}
+
+	u := &ContainerUser{UID: -1}
+	gid := -1
+	id, idErr := strconv.Atoi(splitted[0])
+	for _, f := range entries(passwd, 7) {
+		uid, err := strconv.Atoi(f[2])
+		if err != nil {
+			continue
+		}
+		if f[0] == splitted[0] || (idErr == nil && uid == id) {
+			u.Name, u.UID, u.Home = f[0], uid, f[5]
+			if gid, err = strconv.Atoi(f[3]); err != nil {
+				return nil, fmt.Errorf("invalid group ID of user %s in passwd file", f[0])
+			}
+			break
+		}
+	}
+	if u.UID < 0 {
+		if idErr != nil || id < 0 {
+			return nil, fmt.Errorf("user %s not found in container passwd file", splitted[0])
+		}
+		u.UID, gid = id, id
+	}
+
+	groups := entries(group, 4)
+	if len(splitted) == 2 {
+		gid = -1
+		id, idErr := strconv.Atoi(splitted[1])
+		if idErr == nil && id >= 0 {
+			gid = id
+		}
+		for _, f := range groups {
+			if f[0] == splitted[1] {
+				if gid, idErr = strconv.Atoi(f[2]); idErr != nil {
+					return nil, fmt.Errorf("invalid group ID of group %s in group file", f[0])
+				}
+				break
+			}
+		}
+		if gid < 0 {
+			return nil, fmt.Errorf("group %s not found in container group file", splitted[1])
+		}
+	}
+	u.GIDs = []int{gid}
+
+	if u.Name == "" {
+		return u, nil
+	}
+	for _, f := range groups {
+		id, err := strconv.Atoi(f[2])
+		if err != nil || id == gid {
+			continue
+		}
+		for _, m := range strings.Split(f[3], ",") {
+			if strings.TrimSpace(m) == u.Name {
+				u.GIDs = append(u.GIDs, id)
+				break
+			}
+		}
+	}
+	return u, nil
+}
@@ -0,0 +1,293 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package fuse implements a minimal read-only FUSE file system holding a
+// single file whose content is read from an io.ReaderAt, it's used to
+// access images without copying them first (eg: lazily fetched http(s)
+// images).
+package fuse
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// FUSE kernel protocol version implemented, the kernel protocol is
+// backward compatible and 7.12 is supported by all the kernels
+// running singularity.
+const (
+	kernelVersion    = 7
+	minKernelMinor   = 12
+	kernelMinor      = 26
+	compatInitOutLen = 24
+	initOutLen       = 64
+	maxWrite         = 4096
+	bufferSize       = 1 << 17
+)
+
+// FUSE kernel operations handled by Serve, the other ones are answered
+// with ENOSYS.
+const (
+	opLookup      = 1
+	opForget      = 2
+	opGetattr     = 3
+	opOpen        = 14
+	opRead        = 15
+	opStatfs      = 17
+	opRelease     = 18
+	opFlush       = 25
+	opInit        = 26
+	opOpendir     = 27
+	opReaddir     = 28
+	opReleasedir  = 29
+	opAccess      = 34
+	opInterrupt   = 36
+	opDestroy     = 38
+	opBatchForget = 42
+)
+
+const (
+	rootID     = 1
+	fileID     = 2
+	inHeader   = 40
+	outHeader  = 16
+	openKeep   = 1 << 1 // FOPEN_KEEP_CACHE
+	attrValid  = 3600
+	statfsSize = 80
+)
+
+// File is the file served by a FUSE file system.
+type File struct {
+	// Name is the name of the file in the mount point.
+	Name string
+	// Size is the size of the file.
+	Size int64
+	// Reader reads the file content.
+	Reader io.ReaderAt
+}
+
+// server answers the requests of the kernel for a File.
+type server struct {
+	fd   int
+	file File
+	uid  uint32
+	gid  uint32
+}
+
+// Serve serves file on the FUSE device fd returned by Mount until the
+// file system is unmounted and no longer used. The file is owned by the
+// current user and can't be modified.
+func Serve(fd int, file File) error {
+	s := &server{
+		fd:   fd,
+		file: file,
+		uid:  uint32(os.Getuid()),
+		gid:  uint32(os.Getgid()),
+	}
+
+	buf := make([]byte, bufferSize)
+	for {
+		n, err := unix.Read(fd, buf)
+		switch err {
+		case nil:
+		case unix.EINTR, unix.EAGAIN, unix.ENOENT:
+			// interrupted or aborted request
+			continue
+		case unix.ENODEV:
+			// unmounted
+			return nil
+		default:
+			return fmt.Errorf("while reading FUSE request: %s", err)
+		}
+		if n < inHeader {
+			return fmt.Errorf("short FUSE request of %d bytes", n)
+		}
+
+		opcode := binary.LittleEndian.Uint32(buf[4:])
+		switch opcode {
+		case opDestroy:
+			s.reply(buf, 0, nil)
+			return nil
+		case opRead:
+			// reads may fetch remote data, they don't block the others
+			go s.handle(opcode, append([]byte(nil), buf[:n]...))
+		default:
+			s.handle(opcode, buf[:n])
+		}
+	}
+}
+
+// handle answers the request req.
+func (s *server) handle(opcode uint32, req []byte) {
+	nodeid := binary.LittleEndian.Uint64(req[16:])
+	in := req[inHeader:]
+
+	switch opcode {
+	case opForget, opBatchForget, opInterrupt:
+		// no reply expected
+	case opInit:
+		s.init(req, in)
+	case opLookup:
+		name := in
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		if nodeid != rootID || string(name) != s.file.Name {
+			s.reply(req, syscall.ENOENT, nil)
+			return
+		}
+		out := make([]byte, 40+88)
+		binary.LittleEndian.PutUint64(out[0:], fileID)
+		binary.LittleEndian.PutUint64(out[16:], attrValid)
+		binary.LittleEndian.PutUint64(out[24:], attrValid)
+		s.attr(out[40:], fileID)
+		s.reply(req, 0, out)
+	case opGetattr:
+		if nodeid != rootID && nodeid != fileID {
+			s.reply(req, syscall.ENOENT, nil)
+			return
+		}
+		out := make([]byte, 16+88)
+		binary.LittleEndian.PutUint64(out[0:], attrValid)
+		s.attr(out[16:], nodeid)
+		s.reply(req, 0, out)
+	case opOpen, opOpendir:
+		flags := binary.LittleEndian.Uint32(in)
+		if flags&syscall.O_ACCMODE != syscall.O_RDONLY {
+			s.reply(req, syscall.EROFS, nil)
+			return
+		}
+		out := make([]byte, 16)
+		if opcode == opOpen {
+			binary.LittleEndian.PutUint32(out[8:], openKeep)
+		}
+		s.reply(req, 0, out)
+	case opRead:
+		off := int64(binary.LittleEndian.Uint64(in[8:]))
+		size := binary.LittleEndian.Uint32(in[16:])
+		if off >= s.file.Size {
+			s.reply(req, 0, nil)
+			return
+		}
+		if rest := s.file.Size - off; int64(size) > rest {
+			size = uint32(rest)
+		}
+		out := make([]byte, size)
+		n, err := s.file.Reader.ReadAt(out, off)
+		if err != nil && err != io.EOF {
+			s.reply(req, syscall.EIO, nil)
+			return
+		}
+		s.reply(req, 0, out[:n])
+	case opReaddir:
+		s.readdir(req, in)
+	case opStatfs:
+		out := make([]byte, statfsSize)
+		binary.LittleEndian.PutUint64(out[0:], uint64((s.file.Size+4095)/4096))
+		binary.LittleEndian.PutUint64(out[24:], 2)
+		binary.LittleEndian.PutUint32(out[40:], 4096)
+		binary.LittleEndian.PutUint32(out[44:], 255)
+		binary.LittleEndian.PutUint32(out[48:], 4096)
+		s.reply(req, 0, out)
+	case opRelease, opReleasedir, opFlush, opAccess:
+		s.reply(req, 0, nil)
+	default:
+		s.reply(req, syscall.ENOSYS, nil)
+	}
+}
+
+// init negotiates the protocol version with the kernel.
+func (s *server) init(req, in []byte) {
+	major := binary.LittleEndian.Uint32(in[0:])
+	minor := binary.LittleEndian.Uint32(in[4:])
+
+	if major < kernelVersion || (major == kernelVersion && minor < minKernelMinor) {
+		s.reply(req, syscall.EPROTO, nil)
+		return
+	}
+	// a newer major version is negotiated again by the kernel
+	if minor > kernelMinor || major > kernelVersion {
+		minor = kernelMinor
+	}
+
+	size := initOutLen
+	if major == kernelVersion && minor < 23 {
+		size = compatInitOutLen
+	}
+	out := make([]byte, size)
+	binary.LittleEndian.PutUint32(out[0:], kernelVersion)
+	binary.LittleEndian.PutUint32(out[4:], minor)
+	// max_readahead of the kernel
+	copy(out[8:12], in[8:12])
+	binary.LittleEndian.PutUint32(out[20:], maxWrite)
+	s.reply(req, 0, out)
+}
+
+// attr writes the fuse_attr structure of the node nodeid to out.
+func (s *server) attr(out []byte, nodeid uint64) {
+	mode, nlink, size := uint32(syscall.S_IFDIR|0555), uint32(2), int64(0)
+	if nodeid == fileID {
+		mode, nlink, size = syscall.S_IFREG|0444, 1, s.file.Size
+	}
+	binary.LittleEndian.PutUint64(out[0:], nodeid)
+	binary.LittleEndian.PutUint64(out[8:], uint64(size))
+	binary.LittleEndian.PutUint64(out[16:], uint64((size+511)/512))
+	binary.LittleEndian.PutUint32(out[60:], mode)
+	binary.LittleEndian.PutUint32(out[64:], nlink)
+	binary.LittleEndian.PutUint32(out[68:], s.uid)
+	binary.LittleEndian.PutUint32(out[72:], s.gid)
+	binary.LittleEndian.PutUint32(out[80:], 4096)
+}
+
+// readdir lists the root directory from the offset of the request.
+func (s *server) readdir(req, in []byte) {
+	off := binary.LittleEndian.Uint64(in[8:])
+	size := int(binary.LittleEndian.Uint32(in[16:]))
+
+	entries := []struct {
+		ino   uint64
+		name  string
+		dtype uint32
+	}{
+		{rootID, ".", syscall.DT_DIR},
+		{rootID, "..", syscall.DT_DIR},
+		{fileID, s.file.Name, syscall.DT_REG},
+	}
+
+	var out []byte
+	for i := off; i < uint64(len(entries)); i++ {
+		e := entries[i]
+		l := (24 + len(e.name) + 7) &^ 7
+		if len(out)+l > size {
+			break
+		}
+		dirent := make([]byte, l)
+		binary.LittleEndian.PutUint64(dirent[0:], e.ino)
+		binary.LittleEndian.PutUint64(dirent[8:], i+1)
+		binary.LittleEndian.PutUint32(dirent[16:], uint32(len(e.name)))
+		binary.LittleEndian.PutUint32(dirent[20:], e.dtype)
+		copy(dirent[24:], e.name)
+		out = append(out, dirent...)
+	}
+	s.reply(req, 0, out)
+}
+
+// reply writes the answer to the request req with a single write, the
+// error is a positive errno value or 0.
+func (s *server) reply(req []byte, errno syscall.Errno, data []byte) {
+	out := make([]byte, outHeader+len(data))
+	binary.LittleEndian.PutUint32(out[0:], uint32(len(out)))
+	binary.LittleEndian.PutUint32(out[4:], uint32(-int32(errno)))
+	copy(out[8:16], req[8:16])
+	copy(out[outHeader:], data)
+	// ENOENT is returned for interrupted requests
+	unix.Write(s.fd, out)
+}
@@ -0,0 +1,115 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package fuse
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"github.com/sylabs/singularity/internal/pkg/test/tool/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestServe(t *testing.T) {
+	test.EnsurePrivilege(t)
+	require.Filesystem(t, "fuse")
+
+	dir, err := ioutil.TempDir("", "fuse-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data := make([]byte, 300000)
+	for i := range data {
+		data[i] = byte(i % 253)
+	}
+
+	fd, err := Mount(dir, MountOptions{FSName: "fuse-test"})
+	if err != nil {
+		t.Fatalf("unexpected mount error: %v", err)
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- Serve(fd, File{Name: "image.sif", Size: int64(len(data)), Reader: bytes.NewReader(data)})
+	}()
+
+	path := filepath.Join(dir, "image.sif")
+	fi, err := os.Stat(path)
+	if err != nil {
+		Unmount(dir)
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fi.Size() != int64(len(data)) || fi.Mode() != 0444 {
+		t.Errorf("unexpected file size %d and mode %s", fi.Size(), fi.Mode())
+	}
+
+	// the file system is accessed with raw system calls, registering its
+	// files with the runtime poller would wait for the serving goroutine
+	// without releasing the processor
+	b := make([]byte, len(data)+1)
+	f, err := unix.Open(path, unix.O_RDONLY, 0)
+	if err != nil {
+		t.Errorf("unexpected open error: %v", err)
+	} else {
+		n := 0
+		for n < len(b) {
+			r, err := unix.Pread(f, b[n:], int64(n))
+			if err != nil {
+				t.Errorf("unexpected read error: %v", err)
+			}
+			if r <= 0 {
+				break
+			}
+			n += r
+		}
+		unix.Close(f)
+		if !bytes.Equal(b[:n], data) {
+			t.Errorf("unexpected file content")
+		}
+	}
+
+	d, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY, 0)
+	if err != nil {
+		t.Errorf("unexpected opendir error: %v", err)
+	} else {
+		buf := make([]byte, 4096)
+		n, err := unix.ReadDirent(d, buf)
+		unix.Close(d)
+		if err != nil {
+			t.Errorf("unexpected readdir error: %v", err)
+		}
+		_, _, names := unix.ParseDirent(buf[:n], -1, nil)
+		if len(names) != 1 || names[0] != "image.sif" {
+			t.Errorf("unexpected directory entries %v", names)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "other")); !os.IsNotExist(err) {
+		t.Errorf("unexpected error for missing file: %v", err)
+	}
+	if f, err := unix.Open(path, unix.O_WRONLY, 0); err == nil {
+		unix.Close(f)
+		t.Errorf("unexpected open for writing success")
+	}
+
+	if err := Unmount(dir); err != nil {
+		t.Fatalf("unexpected unmount error: %v", err)
+	}
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("unexpected serve error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("file system not released after unmount")
+	}
+}
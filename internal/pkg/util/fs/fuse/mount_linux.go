@@ -0,0 +1,152 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package fuse
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/sylabs/singularity/internal/pkg/util/env"
+	"golang.org/x/sys/unix"
+)
+
+// fuseConf is the configuration file of the fusermount program.
+const fuseConf = "/etc/fuse.conf"
+
+// MountOptions are the options of a FUSE mount.
+type MountOptions struct {
+	// FSName is the name of the file system shown in the mount table.
+	FSName string
+	// AllowRoot lets root access the file system of an unprivileged
+	// user (eg: the setuid starter), see UserAllowOther.
+	AllowRoot bool
+}
+
+// Mount mounts a FUSE file system on mountpoint and returns the FUSE device
+// descriptor to pass to Serve. Root mounts the file system directly, users
+// mount it with the setuid fusermount program.
+func Mount(mountpoint string, opts MountOptions) (int, error) {
+	if os.Geteuid() == 0 {
+		fd, err := unix.Open("/dev/fuse", unix.O_RDWR|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return -1, fmt.Errorf("while opening /dev/fuse: %s", err)
+		}
+		source := opts.FSName
+		if source == "" {
+			source = "fuse"
+		}
+		data := fmt.Sprintf("fd=%d,rootmode=40000,user_id=%d,group_id=%d,allow_other,default_permissions", fd, os.Getuid(), os.Getgid())
+		if err := unix.Mount(source, mountpoint, "fuse", unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, data); err != nil {
+			unix.Close(fd)
+			return -1, fmt.Errorf("while mounting FUSE file system on %s: %s", mountpoint, err)
+		}
+		return fd, nil
+	}
+
+	options := []string{"ro", "nosuid", "nodev", "default_permissions"}
+	if opts.FSName != "" {
+		options = append(options, "fsname="+opts.FSName)
+	}
+	if opts.AllowRoot {
+		options = append(options, "allow_root")
+	}
+	return fusermount(mountpoint, options)
+}
+
+// fusermount mounts the file system with fusermount, the FUSE device
+// descriptor is sent back on the socket _FUSE_COMMFD.
+func fusermount(mountpoint string, options []string) (int, error) {
+	path, err := fusermountPath()
+	if err != nil {
+		return -1, err
+	}
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return -1, fmt.Errorf("while creating socket pair: %s", err)
+	}
+	local := os.NewFile(uintptr(fds[0]), "fusermount")
+	remote := os.NewFile(uintptr(fds[1]), "fusermount")
+	defer local.Close()
+
+	cmd := exec.Command(path, "-o", strings.Join(options, ","), "--", mountpoint)
+	cmd.Env = append(os.Environ(), "_FUSE_COMMFD=3")
+	cmd.ExtraFiles = []*os.File{remote}
+	out, err := cmd.CombinedOutput()
+	remote.Close()
+	if err != nil {
+		return -1, fmt.Errorf("%s failed: %s: %s", path, err, strings.TrimSpace(string(out)))
+	}
+
+	buf := make([]byte, 1)
+	oob := make([]byte, unix.CmsgSpace(4))
+	_, oobn, _, _, err := unix.Recvmsg(fds[0], buf, oob, 0)
+	if err != nil {
+		return -1, fmt.Errorf("while receiving FUSE device from %s: %s", path, err)
+	}
+	msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return -1, fmt.Errorf("no FUSE device received from %s", path)
+	}
+	rights, err := unix.ParseUnixRights(&msgs[0])
+	if err != nil || len(rights) != 1 {
+		return -1, fmt.Errorf("no FUSE device received from %s", path)
+	}
+	unix.CloseOnExec(rights[0])
+	return rights[0], nil
+}
+
+// Unmount lazily unmounts the file system mounted on mountpoint, it's
+// released once no longer used (eg: by a loop device).
+func Unmount(mountpoint string) error {
+	if os.Geteuid() == 0 {
+		return unix.Unmount(mountpoint, unix.MNT_DETACH)
+	}
+	path, err := fusermountPath()
+	if err != nil {
+		return err
+	}
+	if out, err := exec.Command(path, "-u", "-z", "--", mountpoint).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s failed: %s: %s", path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// UserAllowOther returns true if fusermount lets users mount file systems
+// accessible by root, with 'user_allow_other' in /etc/fuse.conf.
+func UserAllowOther() bool {
+	f, err := os.Open(fuseConf)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "user_allow_other" {
+			return true
+		}
+	}
+	return false
+}
+
+// fusermountPath returns the path of fusermount3 or fusermount found in
+// the default path, the setuid program is never searched in PATH.
+func fusermountPath() (string, error) {
+	for _, command := range []string{"fusermount3", "fusermount"} {
+		for _, dir := range filepath.SplitList(env.DefaultPath) {
+			path := filepath.Join(dir, command)
+			if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() {
+				return path, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("fusermount not found in %s", env.DefaultPath)
+}
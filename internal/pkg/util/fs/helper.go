@@ -15,6 +15,7 @@ import (
 	"strings"
 	"syscall"
 
+	digest "github.com/opencontainers/go-digest"
 	"github.com/sylabs/singularity/pkg/sylog"
 	"golang.org/x/sys/unix"
 )
@@ -574,3 +575,14 @@ func readDirNames(dirname string) ([]string, error) {
 	sort.Strings(names)
 	return names, nil
 }
+
+// FileDigest returns the sha256 digest of the content of the file at path.
+func FileDigest(path string) (digest.Digest, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	return digest.FromReader(f)
+}
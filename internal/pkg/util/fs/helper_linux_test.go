@@ -788,3 +788,27 @@ func TestForceRemoveAll(t *testing.T) {
 		t.Errorf("ForceRemoveAll failed to remove %s", testDir)
 	}
 }
+
+func TestFileDigest(t *testing.T) {
+	f, err := ioutil.TempFile("", "file-digest-")
+	if err != nil {
+		t.Fatalf("failed to create temporary file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+
+	// sha256 of an empty content
+	const empty = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	d, err := FileDigest(f.Name())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if d.String() != empty {
+		t.Errorf("got digest %s instead of %s", d, empty)
+	}
+
+	if _, err := FileDigest(filepath.Join(f.Name(), "none")); err == nil {
+		t.Errorf("unexpected success")
+	}
+}
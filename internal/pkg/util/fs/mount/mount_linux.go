@@ -8,6 +8,7 @@ package mount
 import (
 	"encoding/base64"
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
 	"syscall"
@@ -142,9 +143,12 @@ var authorizedTags = map[AuthorizedTag]struct {
 }
 
 var authorizedImage = map[string]fsContext{
-	"encryptfs": {true},
-	"ext3":      {true},
-	"squashfs":  {true},
+	"encryptfs":   {true},
+	"encryptext3": {true},
+	"erofs":       {true},
+	"ext3":        {true},
+	"squashfs":    {true},
+	"verityfs":    {true},
 }
 
 var authorizedFS = map[string]fsContext{
@@ -611,7 +615,7 @@ func (p *Points) AddImage(tag AuthorizedTag, source string, dest string, fstype
 	}
 	keyB64 := base64.StdEncoding.EncodeToString(key)
 	options = fmt.Sprintf("loop,offset=%d,sizelimit=%d,key=%s", offset, sizelimit, keyB64)
-	if fstype == "ext3" {
+	if fstype == "ext3" || fstype == "encryptext3" {
 		options += ",errors=remount-ro"
 	}
 	return p.add(tag, source, dest, fstype, flags, options)
@@ -688,6 +692,11 @@ func (p *Points) AddOverlay(tag AuthorizedTag, dest string, flags uintptr, lower
 	} else {
 		options = fmt.Sprintf("lowerdir=%s", lowerdir)
 	}
+	// the kernel truncates mount options to a page
+	if len(options) >= os.Getpagesize() {
+		n := strings.Count(lowerdir, ":") + 1
+		return fmt.Errorf("overlay mount point %s has too many layers (%d): options exceed %d bytes", dest, n, os.Getpagesize()-1)
+	}
 	return p.add(tag, "overlay", dest, "overlay", flags, options)
 }
 
@@ -7,6 +7,7 @@ package mount
 
 import (
 	"fmt"
+	"strings"
 	"syscall"
 	"testing"
 
@@ -136,6 +137,10 @@ func TestOverlay(t *testing.T) {
 	if err := points.AddOverlay(LayerTag, "/fake", syscall.MS_REC, "/lower", "", ""); err == nil {
 		t.Errorf("should have failed with bad recursive flag")
 	}
+	lowers := strings.Repeat("/var/lib/singularity/mnt/session/overlay-images/0:", 100)
+	if err := points.AddOverlay(LayerTag, "/fake", 0, strings.TrimSuffix(lowers, ":"), "", ""); err == nil {
+		t.Errorf("should have failed with too many lower directories")
+	}
 	points.RemoveAll()
 
 	if err := points.AddOverlay(LayerTag, "/fake", 0, "/lower", "", ""); err != nil {
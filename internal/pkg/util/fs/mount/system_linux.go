@@ -7,6 +7,8 @@ package mount
 
 import (
 	"fmt"
+
+	"github.com/sylabs/singularity/pkg/sylog"
 )
 
 // hookFn describes function prototype for function
@@ -77,7 +79,7 @@ func (b *System) MountAll() error {
 		for _, point := range b.Points.GetByTag(tag) {
 			if b.Mount != nil {
 				if err := b.Mount(&point, b); err != nil {
-					return fmt.Errorf("mount %s->%s error: %s", point.Source, point.Destination, err)
+					return sylog.WithCode(sylog.MountFailed, fmt.Errorf("mount %s->%s error: %s", point.Source, point.Destination, err))
 				}
 			}
 		}
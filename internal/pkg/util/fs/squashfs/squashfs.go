@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
@@ -73,3 +74,147 @@ func GetMem() (string, error) {
 
 	return mem, err
 }
+
+// NoCompression stores the squashfs data, inodes, fragments and extended
+// attributes uncompressed (eg: for a partition read with mmap).
+const NoCompression = "none"
+
+// compressionLevels holds the compression level range of the
+// squashfs compression algorithms supported for builds, algorithms
+// without levels have a zero range.
+var compressionLevels = map[string][2]int{
+	"gzip":        {1, 9},
+	"zstd":        {1, 22},
+	"lz4":         {0, 0},
+	"xz":          {0, 0},
+	NoCompression: {0, 0},
+}
+
+// CompressionFlags returns the mksquashfs flags to compress an image
+// with the algorithm comp (gzip, zstd, lz4, xz or none) at the compression
+// level, a zero level selects the algorithm default level.
+func CompressionFlags(comp string, level int) ([]string, error) {
+	levels, ok := compressionLevels[comp]
+	if !ok {
+		return nil, fmt.Errorf("unsupported squashfs compression %q, supported values are gzip, zstd, lz4, xz and none", comp)
+	}
+
+	flags := []string{"-comp", comp}
+	if comp == NoCompression {
+		flags = []string{"-noI", "-noD", "-noF", "-noX"}
+	}
+	if level == 0 {
+		return flags, nil
+	}
+
+	if levels[1] == 0 {
+		return nil, fmt.Errorf("%s compression doesn't support compression levels", comp)
+	} else if level < levels[0] || level > levels[1] {
+		return nil, fmt.Errorf("%s compression level must be between %d and %d", comp, levels[0], levels[1])
+	}
+	return append(flags, "-Xcompression-level", fmt.Sprint(level)), nil
+}
+
+// Partition selectors of the compressions parsed by ParseCompressions.
+const (
+	// AllPartitions selects the partitions not selected otherwise.
+	AllPartitions = ""
+	// RootfsPartition selects the primary system partition.
+	RootfsPartition = "rootfs"
+	// SystemPartitions selects the other system partitions (eg: the
+	// partitions of the other architectures).
+	SystemPartitions = "system"
+	// DataPartitions selects the data partitions.
+	DataPartitions = "data"
+	// OverlayPartitions selects the overlay partitions.
+	OverlayPartitions = "overlay"
+)
+
+// Compression is a squashfs compression algorithm and level, a zero
+// level selects the algorithm default level.
+type Compression struct {
+	Algorithm string
+	Level     int
+}
+
+// Flags returns the mksquashfs flags of the compression.
+func (c Compression) Flags() ([]string, error) {
+	return CompressionFlags(c.Algorithm, c.Level)
+}
+
+// String returns the compression as parsed by ParseCompressions.
+func (c Compression) String() string {
+	if c.Level == 0 {
+		return c.Algorithm
+	}
+	return fmt.Sprintf("%s:%d", c.Algorithm, c.Level)
+}
+
+// Compressions maps partition selectors, a partition type or a partition
+// ID, to the compression of the partitions they select.
+type Compressions map[string]Compression
+
+// ParseCompressions parses a comma separated list of compressions of the
+// form [<partition>=]<algorithm>[:<level>], the partition is a partition
+// ID or type (rootfs, system, data or overlay), the compression without
+// partition applies to the partitions not selected otherwise. For example
+// "rootfs=zstd:19,data=none" compresses the root filesystem with zstd at
+// level 19 and leaves the data partitions uncompressed.
+func ParseCompressions(s string) (Compressions, error) {
+	comps := make(Compressions)
+	if strings.TrimSpace(s) == "" {
+		return comps, nil
+	}
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		part, comp := AllPartitions, entry
+		if i := strings.Index(entry, "="); i >= 0 {
+			part, comp = strings.TrimSpace(entry[:i]), strings.TrimSpace(entry[i+1:])
+			if !isPartitionSelector(part) {
+				return nil, fmt.Errorf("invalid partition %q in compression %q, a partition ID or type (rootfs, system, data, overlay) is expected", part, entry)
+			}
+		}
+		if _, ok := comps[part]; ok {
+			return nil, fmt.Errorf("compression %q selects a partition already selected", entry)
+		}
+
+		c := Compression{Algorithm: comp}
+		if i := strings.Index(comp, ":"); i >= 0 {
+			level, err := strconv.Atoi(comp[i+1:])
+			if err != nil || level <= 0 {
+				return nil, fmt.Errorf("invalid level in compression %q", entry)
+			}
+			c = Compression{Algorithm: comp[:i], Level: level}
+		}
+		if _, err := c.Flags(); err != nil {
+			return nil, err
+		}
+		comps[part] = c
+	}
+	return comps, nil
+}
+
+// isPartitionSelector returns true if part is a partition type or ID.
+func isPartitionSelector(part string) bool {
+	switch part {
+	case RootfsPartition, SystemPartitions, DataPartitions, OverlayPartitions:
+		return true
+	}
+	id, err := strconv.ParseUint(part, 10, 32)
+	return err == nil && id > 0
+}
+
+// Select returns the compression of the partition id of type ptype (see
+// ParseCompressions), the partition ID prevails over its type. It returns
+// false if the partition isn't selected.
+func (c Compressions) Select(id uint32, ptype string) (Compression, bool) {
+	if comp, ok := c[strconv.FormatUint(uint64(id), 10)]; ok {
+		return comp, true
+	}
+	if comp, ok := c[ptype]; ok {
+		return comp, true
+	}
+	comp, ok := c[AllPartitions]
+	return comp, ok
+}
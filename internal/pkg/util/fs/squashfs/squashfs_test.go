@@ -0,0 +1,158 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package squashfs
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompressionFlags(t *testing.T) {
+	tests := []struct {
+		name       string
+		comp       string
+		level      int
+		shouldFail bool
+		expected   []string
+	}{
+		{
+			name:     "zstd default level",
+			comp:     "zstd",
+			expected: []string{"-comp", "zstd"},
+		},
+		{
+			name:     "zstd level",
+			comp:     "zstd",
+			level:    19,
+			expected: []string{"-comp", "zstd", "-Xcompression-level", "19"},
+		},
+		{
+			name:     "gzip level",
+			comp:     "gzip",
+			level:    1,
+			expected: []string{"-comp", "gzip", "-Xcompression-level", "1"},
+		},
+		{
+			name:       "gzip bad level",
+			comp:       "gzip",
+			level:      19,
+			shouldFail: true,
+		},
+		{
+			name:       "xz level",
+			comp:       "xz",
+			level:      6,
+			shouldFail: true,
+		},
+		{
+			name:     "no compression",
+			comp:     "none",
+			expected: []string{"-noI", "-noD", "-noF", "-noX"},
+		},
+		{
+			name:       "unsupported compression",
+			comp:       "lzma",
+			shouldFail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, err := CompressionFlags(tt.comp, tt.level)
+			if err != nil && !tt.shouldFail {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.shouldFail {
+				t.Fatalf("unexpected success")
+			}
+			if !reflect.DeepEqual(flags, tt.expected) {
+				t.Errorf("unexpected flags %v instead of %v", flags, tt.expected)
+			}
+		})
+	}
+}
+
+func TestParseCompressions(t *testing.T) {
+	tests := []struct {
+		name       string
+		s          string
+		shouldFail bool
+		expected   Compressions
+	}{
+		{
+			name:     "empty",
+			expected: Compressions{},
+		},
+		{
+			name:     "all partitions",
+			s:        "zstd",
+			expected: Compressions{AllPartitions: {Algorithm: "zstd"}},
+		},
+		{
+			name: "per partition",
+			s:    "rootfs=zstd:19, data=none,3=gzip,lz4",
+			expected: Compressions{
+				RootfsPartition: {Algorithm: "zstd", Level: 19},
+				DataPartitions:  {Algorithm: "none"},
+				"3":             {Algorithm: "gzip"},
+				AllPartitions:   {Algorithm: "lz4"},
+			},
+		},
+		{
+			name:       "unknown partition",
+			s:          "home=zstd",
+			shouldFail: true,
+		},
+		{
+			name:       "duplicate partition",
+			s:          "data=zstd,data=xz",
+			shouldFail: true,
+		},
+		{
+			name:       "bad level",
+			s:          "rootfs=gzip:19",
+			shouldFail: true,
+		},
+		{
+			name:       "invalid level",
+			s:          "rootfs=zstd:high",
+			shouldFail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comps, err := ParseCompressions(tt.s)
+			if err != nil && !tt.shouldFail {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.shouldFail {
+				t.Fatalf("unexpected success")
+			}
+			if !tt.shouldFail && !reflect.DeepEqual(comps, tt.expected) {
+				t.Errorf("unexpected compressions %v instead of %v", comps, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCompressionsSelect(t *testing.T) {
+	comps, err := ParseCompressions("rootfs=zstd:19,data=none,3=gzip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c, ok := comps.Select(1, RootfsPartition); !ok || c.String() != "zstd:19" {
+		t.Errorf("unexpected rootfs compression %v", c)
+	}
+	if c, ok := comps.Select(3, DataPartitions); !ok || c.String() != "gzip" {
+		t.Errorf("unexpected compression %v of partition 3", c)
+	}
+	if c, ok := comps.Select(4, DataPartitions); !ok || c.String() != "none" {
+		t.Errorf("unexpected data compression %v", c)
+	}
+	if _, ok := comps.Select(5, OverlayPartitions); ok {
+		t.Errorf("unexpected overlay partition selected")
+	}
+}
@@ -147,6 +147,8 @@ func AskQuestionNoEcho(format string, a ...interface{}) (string, error) {
 		}
 	}
 	fmt.Println("")
+	// input not echoed is sensitive, ensure it never shows up in logs
+	sylog.AddSecret(response)
 	return string(response), nil
 }
 
@@ -15,9 +15,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
 
 	"github.com/sylabs/sif/pkg/sif"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
@@ -29,6 +31,7 @@ var ErrUnknownArch = errors.New("architecture not recognized")
 
 type format struct {
 	Arch       string
+	Qemu       string
 	Sif        string
 	Compatible string
 	Machine    elf.Machine
@@ -40,6 +43,7 @@ type format struct {
 var formats = []format{
 	{
 		Arch:       "386",
+		Qemu:       "i386",
 		Sif:        sif.HdrArch386,
 		Compatible: "amd64",
 		Machine:    elf.EM_386,
@@ -49,6 +53,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "386",
+		Qemu:       "i386",
 		Sif:        sif.HdrArch386,
 		Compatible: "amd64",
 		Machine:    elf.EM_486,
@@ -58,6 +63,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "amd64",
+		Qemu:       "x86_64",
 		Sif:        sif.HdrArchAMD64,
 		Machine:    elf.EM_X86_64,
 		Class:      elf.ELFCLASS64,
@@ -66,6 +72,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "arm",
+		Qemu:       "arm",
 		Sif:        sif.HdrArchARM,
 		Compatible: "arm64",
 		Machine:    elf.EM_ARM,
@@ -75,6 +82,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "armbe",
+		Qemu:       "armeb",
 		Sif:        sif.HdrArchARM, // FIXME: add HdrArchARMbe to sif package
 		Compatible: "arm64be",
 		Machine:    elf.EM_ARM,
@@ -84,6 +92,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "arm64",
+		Qemu:       "aarch64",
 		Sif:        sif.HdrArchARM64,
 		Machine:    elf.EM_AARCH64,
 		Class:      elf.ELFCLASS64,
@@ -92,6 +101,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "arm64be",
+		Qemu:       "aarch64_be",
 		Sif:        sif.HdrArchARM64, // FIXME: add HdrArchARM64be to sif package
 		Machine:    elf.EM_AARCH64,
 		Class:      elf.ELFCLASS64,
@@ -100,6 +110,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "s390x",
+		Qemu:       "s390x",
 		Sif:        sif.HdrArchS390x,
 		Machine:    elf.EM_S390,
 		Class:      elf.ELFCLASS64,
@@ -108,6 +119,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "ppc64",
+		Qemu:       "ppc64",
 		Sif:        sif.HdrArchPPC64,
 		Machine:    elf.EM_PPC64,
 		Class:      elf.ELFCLASS32,
@@ -116,6 +128,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "ppc64le",
+		Qemu:       "ppc64le",
 		Sif:        sif.HdrArchPPC64le,
 		Machine:    elf.EM_PPC64,
 		Class:      elf.ELFCLASS64,
@@ -124,6 +137,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "mips",
+		Qemu:       "mips",
 		Sif:        sif.HdrArchMIPS,
 		Compatible: "mips64",
 		Machine:    elf.EM_MIPS,
@@ -133,6 +147,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "mipsle",
+		Qemu:       "mipsel",
 		Sif:        sif.HdrArchMIPSle,
 		Compatible: "mips64le",
 		Machine:    elf.EM_MIPS,
@@ -142,6 +157,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "mips64",
+		Qemu:       "mips64",
 		Sif:        sif.HdrArchMIPS64,
 		Machine:    elf.EM_MIPS,
 		Class:      elf.ELFCLASS64,
@@ -150,6 +166,7 @@ var formats = []format{
 	},
 	{
 		Arch:       "mips64le",
+		Qemu:       "mips64el",
 		Sif:        sif.HdrArchMIPS64le,
 		Machine:    elf.EM_MIPS,
 		Class:      elf.ELFCLASS64,
@@ -227,35 +244,34 @@ func ArchFromContainer(container string) string {
 const binfmtMisc = "/proc/sys/fs/binfmt_misc"
 
 type binfmtEntry struct {
-	magic      string
-	enabled    bool
-	persistent bool
+	magic       string
+	interpreter string
+	enabled     bool
+	persistent  bool
 }
 
-func canEmulate(arch string) bool {
-	var format format
-
+// getFormat returns the format of the architecture.
+func getFormat(arch string) (format, bool) {
 	for _, f := range formats {
 		if arch == f.Arch {
-			format = f
-			break
+			return f, true
 		}
 	}
+	return format{}, false
+}
 
-	// no architecture format found
-	if format.Arch == "" {
-		return false
-	}
-
+// binfmtHandler returns the enabled binfmt_misc handler matching
+// the ELF magic of the format or nil if there is none.
+func binfmtHandler(format format) *binfmtEntry {
 	// look at /proc/sys/fs/binfmt_misc
 	content, _ := ioutil.ReadFile(filepath.Join(binfmtMisc, "status"))
 	if string(content) != "enabled\n" {
-		return false
+		return nil
 	}
 
 	infos, err := ioutil.ReadDir(binfmtMisc)
 	if err != nil {
-		return false
+		return nil
 	}
 
 	archMagic := hex.EncodeToString(format.ElfMagic)
@@ -280,6 +296,11 @@ func canEmulate(arch string) bool {
 				if len(splitted) > 1 {
 					entry.magic = splitted[1]
 				}
+			} else if strings.HasPrefix(t, "interpreter") {
+				splitted := strings.Split(t, " ")
+				if len(splitted) > 1 {
+					entry.interpreter = splitted[1]
+				}
 			} else if strings.HasPrefix(t, "flags") {
 				splitted := strings.Split(t, " ")
 				if len(splitted) > 1 {
@@ -288,12 +309,146 @@ func canEmulate(arch string) bool {
 			}
 		}
 
-		if entry.enabled && entry.persistent && entry.magic == archMagic {
-			return true
+		if entry.enabled && entry.magic == archMagic {
+			return entry
+		}
+	}
+
+	return nil
+}
+
+func canEmulate(arch string) bool {
+	format, ok := getFormat(arch)
+	// no architecture format found
+	if !ok {
+		return false
+	}
+
+	entry := binfmtHandler(format)
+	return entry != nil && entry.persistent
+}
+
+// Emulation describes the binfmt_misc handler running the
+// binaries of a foreign architecture.
+type Emulation struct {
+	// Interpreter is the path of the emulator.
+	Interpreter string
+	// FixBinary is true if the interpreter is opened by the kernel
+	// when the handler is registered, it doesn't have to be present
+	// in the container then.
+	FixBinary bool
+}
+
+// SetupEmulation returns the binfmt_misc handler running the binaries of
+// arch on the host, a qemu-user handler is registered with the static qemu
+// binary of the host if there is none. It returns nil if the architecture
+// runs natively.
+func SetupEmulation(arch string) (*Emulation, error) {
+	format, ok := getFormat(arch)
+	if !ok {
+		return nil, ErrUnknownArch
+	}
+	if arch == runtime.GOARCH || format.Compatible == runtime.GOARCH {
+		return nil, nil
+	}
+
+	if err := mountBinfmtMisc(); err != nil {
+		return nil, err
+	}
+
+	if entry := binfmtHandler(format); entry != nil {
+		sylog.Debugf("Found binfmt_misc handler %s for %s", entry.interpreter, arch)
+		return &Emulation{Interpreter: entry.interpreter, FixBinary: entry.persistent}, nil
+	}
+
+	qemu, err := findQemu(format)
+	if err != nil {
+		return nil, err
+	}
+
+	sylog.Infof("Registering binfmt_misc handler %s for %s", qemu, arch)
+	if err := registerBinfmt(format, qemu); err != nil {
+		return nil, fmt.Errorf("while registering binfmt_misc handler for %s: %s", arch, err)
+	}
+	return &Emulation{Interpreter: qemu, FixBinary: true}, nil
+}
+
+// mountBinfmtMisc mounts the binfmt_misc filesystem if it isn't.
+func mountBinfmtMisc() error {
+	if _, err := os.Stat(filepath.Join(binfmtMisc, "status")); err == nil {
+		return nil
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("binfmt_misc is not mounted on %s", binfmtMisc)
+	}
+	if err := syscall.Mount("binfmt_misc", binfmtMisc, "binfmt_misc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+		return fmt.Errorf("while mounting binfmt_misc on %s: %s", binfmtMisc, err)
+	}
+	return nil
+}
+
+// findQemu returns the path of the qemu-user binary emulating the format,
+// it must be statically linked to run in the container.
+func findQemu(format format) (string, error) {
+	for _, name := range []string{"qemu-" + format.Qemu + "-static", "qemu-" + format.Qemu} {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			continue
+		}
+		if path, err = filepath.EvalSymlinks(path); err != nil {
+			continue
+		}
+		if isStatic(path) {
+			return path, nil
+		}
+		sylog.Debugf("Ignoring %s, it is not statically linked", path)
+	}
+	return "", fmt.Errorf("no binfmt_misc handler for %s and no static qemu-%s found in PATH, install qemu-user-static", format.Arch, format.Qemu)
+}
+
+// isStatic returns true if the ELF binary has no program interpreter.
+func isStatic(path string) bool {
+	e, err := elf.Open(path)
+	if err != nil {
+		return false
+	}
+	defer e.Close()
+
+	for _, p := range e.Progs {
+		if p.Type == elf.PT_INTERP {
+			return false
+		}
+	}
+	return true
+}
+
+// binfmtRule returns the binfmt_misc registration rule of the format
+// handled by the interpreter, the mask ignores the OS ABI and matches
+// executables and shared objects.
+func binfmtRule(format format, interpreter string) string {
+	mask := bytes.Repeat([]byte{0xff}, len(format.ElfMagic))
+	mask[7] = 0x00
+	if format.Endianness == binary.LittleEndian {
+		mask[16] = 0xfe
+	} else {
+		mask[17] = 0xfe
+	}
+
+	escape := func(b []byte) string {
+		var s strings.Builder
+		for _, c := range b {
+			fmt.Fprintf(&s, "\\x%02x", c)
 		}
+		return s.String()
 	}
 
-	return false
+	return fmt.Sprintf(":singularity-qemu-%s:M::%s:%s:%s:F", format.Qemu, escape(format.ElfMagic), escape(mask), interpreter)
+}
+
+// registerBinfmt registers the interpreter as binfmt_misc handler of the
+// format, the fix binary flag makes it available in any mount namespace.
+func registerBinfmt(format format, interpreter string) error {
+	return ioutil.WriteFile(filepath.Join(binfmtMisc, "register"), []byte(binfmtRule(format, interpreter)), 0200)
 }
 
 // CompatibleWith returns if the current machine architecture is
@@ -314,3 +469,39 @@ func CompatibleWith(arch string) bool {
 
 	return canEmulate(arch)
 }
+
+// Platform describes the os/arch[/variant] platform of an OCI image.
+type Platform struct {
+	OS      string
+	Arch    string
+	Variant string
+}
+
+// String returns the os/arch[/variant] representation of the platform.
+func (p Platform) String() string {
+	if p.Variant != "" {
+		return p.OS + "/" + p.Arch + "/" + p.Variant
+	}
+	return p.OS + "/" + p.Arch
+}
+
+// ParsePlatform parses an os/arch[/variant] platform (eg: linux/arm64/v8),
+// the architecture must be a known architecture.
+func ParsePlatform(platform string) (Platform, error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", platform)
+	}
+
+	p := Platform{OS: parts[0], Arch: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+
+	for _, f := range formats {
+		if f.Arch == p.Arch {
+			return p, nil
+		}
+	}
+	return Platform{}, fmt.Errorf("invalid platform %q: %w", platform, ErrUnknownArch)
+}
@@ -0,0 +1,68 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package machine
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	tests := []struct {
+		platform   string
+		expected   Platform
+		shouldFail bool
+	}{
+		{platform: "linux/amd64", expected: Platform{OS: "linux", Arch: "amd64"}},
+		{platform: "linux/arm64/v8", expected: Platform{OS: "linux", Arch: "arm64", Variant: "v8"}},
+		{platform: "arm64", shouldFail: true},
+		{platform: "linux/", shouldFail: true},
+		{platform: "linux/arm/v7/extra", shouldFail: true},
+		{platform: "linux/riscv128", shouldFail: true},
+	}
+
+	for _, tt := range tests {
+		p, err := ParsePlatform(tt.platform)
+		if tt.shouldFail {
+			if err == nil {
+				t.Errorf("unexpected success for %q", tt.platform)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", tt.platform, err)
+		} else if p != tt.expected {
+			t.Errorf("unexpected platform %+v for %q", p, tt.platform)
+		} else if p.String() != tt.platform {
+			t.Errorf("unexpected string %s for %q", p, tt.platform)
+		}
+	}
+}
+
+func TestBinfmtRule(t *testing.T) {
+	f, ok := getFormat("arm64")
+	if !ok {
+		t.Fatalf("arm64 format not found")
+	}
+
+	expected := `:singularity-qemu-aarch64:M::` +
+		`\x7f\x45\x4c\x46\x02\x01\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02\x00\xb7\x00:` +
+		`\xff\xff\xff\xff\xff\xff\xff\x00\xff\xff\xff\xff\xff\xff\xff\xff\xfe\xff\xff\xff:` +
+		`/usr/bin/qemu-aarch64-static:F`
+
+	if rule := binfmtRule(f, "/usr/bin/qemu-aarch64-static"); rule != expected {
+		t.Errorf("unexpected rule:\n%s\nexpected:\n%s", rule, expected)
+	}
+}
+
+func TestSetupEmulation(t *testing.T) {
+	if _, err := SetupEmulation("riscv128"); err != ErrUnknownArch {
+		t.Errorf("unexpected error for unknown architecture: %v", err)
+	}
+	if e, err := SetupEmulation(runtime.GOARCH); err != nil || e != nil {
+		t.Errorf("unexpected emulation of the host architecture: %+v, %v", e, err)
+	}
+}
@@ -89,6 +89,7 @@ func Exec(name string, config *config.Common, ops ...CommandOp) error {
 	if err := c.init(config, ops...); err != nil {
 		return fmt.Errorf("while initializing starter command: %s", err)
 	}
+	sylog.Flush()
 	err := unix.Exec(c.path, []string{name}, c.env)
 	return fmt.Errorf("while executing %s: %s", c.path, err)
 }
@@ -108,6 +109,17 @@ func Run(name string, config *config.Common, ops ...CommandOp) error {
 	cmd.Stdout = c.stdout
 	cmd.Stderr = c.stderr
 
+	// relay starter and engine messages through our logger
+	relay, err := sylog.NewRelay()
+	if err != nil {
+		sylog.Debugf("Messages won't be relayed: %s", err)
+	} else {
+		defer relay.Close()
+		cmd.ExtraFiles = []*os.File{relay.File()}
+		// extra files start at file descriptor 3
+		cmd.Env = append(cmd.Env, relay.EnvVar(3))
+	}
+
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("while running %s: %s", c.path, err)
 	}
@@ -137,7 +149,7 @@ func (c *Command) init(config *config.Common, ops ...CommandOp) error {
 		return fmt.Errorf("while copying engine configuration: %s", err)
 	}
 
-	c.env = append(c.env, sylog.GetEnvVar())
+	c.env = append(c.env, sylog.GetEnvVars()...)
 	c.env = append(c.env, envConfig...)
 
 	return nil
@@ -25,16 +25,18 @@ const (
 
 // validURIs contains a list of known uris
 var validURIs = map[string]bool{
-	"library":        true,
-	"shub":           true,
-	"docker":         true,
-	"docker-archive": true,
-	"docker-daemon":  true,
-	"oci":            true,
-	"oci-archive":    true,
-	"http":           true,
-	"https":          true,
-	"oras":           true,
+	"library":            true,
+	"shub":               true,
+	"docker":             true,
+	"docker-archive":     true,
+	"docker-daemon":      true,
+	"oci":                true,
+	"oci-archive":        true,
+	"http":               true,
+	"https":              true,
+	"oras":               true,
+	"containers-storage": true,
+	"podman":             true,
 }
 
 // IsValid returns whether or not the given source is valid
@@ -63,6 +65,11 @@ func GetName(uri string) string {
 		return ""
 	}
 
+	// Trim the containers-storage [driver@graphroot+runroot] store specification
+	if i := strings.Index(ref, "]"); strings.HasPrefix(ref, "[") && i > 0 {
+		ref = ref[i+1:]
+	}
+
 	ref = strings.TrimLeft(ref, "/")    // Trim leading "/" characters
 	refSplit := strings.Split(ref, "/") // Split ref into parts
 
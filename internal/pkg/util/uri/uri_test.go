@@ -17,6 +17,9 @@ func Test_GetName(t *testing.T) {
 		{"docker scoped", "docker://user/image", "image_latest.sif"},
 		{"dave's magical lolcow", "docker://godlovedc/lolcow", "lolcow_latest.sif"},
 		{"docker w/ tags", "docker://godlovedc/lolcow:3.7", "lolcow_3.7.sif"},
+		{"podman", "podman://localhost/image:dev", "image_dev.sif"},
+		{"containers-storage", "containers-storage:localhost/image", "image_latest.sif"},
+		{"containers-storage w/ store", "containers-storage:[overlay@/var/lib/containers/storage]localhost/image:dev", "image_dev.sif"},
 	}
 
 	for _, tt := range tests {
@@ -41,6 +44,7 @@ func Test_Split(t *testing.T) {
 		{"docker with tags", "docker://godlovedc/lolcow:latest", "docker", "//godlovedc/lolcow:latest"},
 		{"library basic", "library://image", "library", "//image"},
 		{"library scoped", "library://collection/image", "library", "//collection/image"},
+		{"containers-storage", "containers-storage:localhost/image", "containers-storage", "localhost/image"},
 		{"without transport", "ubuntu", "", "ubuntu"},
 		{"without transport with colon", "ubuntu:18.04.img", "", "ubuntu:18.04.img"},
 	}
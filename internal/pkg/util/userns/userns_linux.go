@@ -0,0 +1,293 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package userns runs commands and functions of an unprivileged user in a
+// user namespace where the user is root and its subordinate IDs are the
+// other users, so that the ownership of container files is preserved
+// without setuid, fakeroot or root privileges.
+package userns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/fakeroot"
+	"github.com/sylabs/singularity/internal/pkg/util/env"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+const (
+	// childEnv is the environment variable holding the name of the function
+	// run by the process re-executed in the user namespace.
+	childEnv = "SINGULARITY_USERNS_CHILD"
+	// syncEnv is set until the process waited for its ID mappings and
+	// re-executed itself with the capabilities of the namespace root.
+	syncEnv = "SINGULARITY_USERNS_SYNC"
+	// syncFd is the descriptor closed by the parent once the ID
+	// mappings of the child are written.
+	syncFd = 3
+)
+
+// ErrUnavailable is returned when user namespaces can't be used by the
+// current user (eg: no subordinate IDs, newuidmap not installed or user
+// namespaces disabled).
+var ErrUnavailable = errors.New("user namespace not available")
+
+// Func is a function run in a user namespace with the arguments passed by
+// IDMap.Call.
+type Func func(args []string) error
+
+var funcs = map[string]Func{
+	"exec":       execFunc,
+	"remove-all": removeAllFunc,
+}
+
+// Register registers the function fn run in a user namespace by
+// IDMap.Call(name), it must be called from an init function so that the
+// function is known by the re-executed process.
+func Register(name string, fn Func) {
+	if _, ok := funcs[name]; ok {
+		panic(fmt.Sprintf("user namespace function %s already registered", name))
+	}
+	funcs[name] = fn
+}
+
+// IDMap holds the ID mappings of a user namespace.
+type IDMap struct {
+	UIDMappings []specs.LinuxIDMapping `json:"uidMappings"`
+	GIDMappings []specs.LinuxIDMapping `json:"gidMappings"`
+}
+
+// CurrentUser returns the ID mappings of a user namespace where the current
+// user is root and its subordinate IDs from /etc/subuid and /etc/subgid are
+// the other users.
+func CurrentUser() (*IDMap, error) {
+	uid, gid := os.Geteuid(), os.Getegid()
+
+	uidRange, err := fakeroot.GetIDRange(fakeroot.SubUIDFile, uint32(uid))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	gidRange, err := fakeroot.GetIDRange(fakeroot.SubGIDFile, uint32(uid))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+	for _, command := range []string{"newuidmap", "newgidmap"} {
+		if _, err := lookPath(command); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+	}
+
+	return &IDMap{
+		UIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: uint32(uid), Size: 1}, *uidRange},
+		GIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: uint32(gid), Size: 1}, *gidRange},
+	}, nil
+}
+
+// Run runs the command cmd as root of the user namespace.
+func (m *IDMap) Run(cmd *exec.Cmd) error {
+	environ := cmd.Env
+	if environ == nil {
+		environ = os.Environ()
+	}
+
+	c := &exec.Cmd{
+		Path:   "/proc/self/exe",
+		Args:   append([]string{os.Args[0], cmd.Path}, cmd.Args[1:]...),
+		Env:    append(environ, childEnv+"=exec"),
+		Dir:    cmd.Dir,
+		Stdin:  cmd.Stdin,
+		Stdout: cmd.Stdout,
+		Stderr: cmd.Stderr,
+	}
+	return m.run(c)
+}
+
+// Call runs the function registered with name in the user namespace, the
+// standard output and error of the calling process are inherited.
+func (m *IDMap) Call(name string, args ...string) error {
+	if _, ok := funcs[name]; !ok {
+		return fmt.Errorf("no user namespace function %s", name)
+	}
+
+	c := &exec.Cmd{
+		Path:   "/proc/self/exe",
+		Args:   append([]string{os.Args[0]}, args...),
+		Env:    append(append(os.Environ(), sylog.GetEnvVars()...), childEnv+"="+name),
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+	}
+	return m.run(c)
+}
+
+// RemoveAll removes path and its content as root of the user namespace,
+// the files owned by the subordinate IDs of a user can't be removed
+// by the user outside of the namespace.
+func (m *IDMap) RemoveAll(path string) error {
+	return m.Call("remove-all", path)
+}
+
+// run starts c in a new user namespace, writes its ID mappings and
+// waits for its completion.
+func (m *IDMap) run(c *exec.Cmd) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	c.Env = append(c.Env, syncEnv+"=1")
+	c.ExtraFiles = []*os.File{r}
+	c.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: syscall.CLONE_NEWUSER,
+		Pdeathsig:  syscall.SIGKILL,
+	}
+	// a single mapping of the current user doesn't require newuidmap
+	// and is written before the child starts
+	if m.single() {
+		c.SysProcAttr.UidMappings = toSysProcIDMap(m.UIDMappings)
+		c.SysProcAttr.GidMappings = toSysProcIDMap(m.GIDMappings)
+		c.SysProcAttr.GidMappingsEnableSetgroups = false
+	}
+
+	err = c.Start()
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnavailable, err)
+	}
+
+	if !m.single() {
+		if err := m.writeMappings(c.Process.Pid); err != nil {
+			c.Process.Kill()
+			c.Wait()
+			return fmt.Errorf("%w: %v", ErrUnavailable, err)
+		}
+	}
+	w.Close()
+
+	return c.Wait()
+}
+
+func (m *IDMap) single() bool {
+	return len(m.UIDMappings) == 1 && len(m.GIDMappings) == 1 &&
+		m.UIDMappings[0].HostID == uint32(os.Geteuid()) && m.UIDMappings[0].Size == 1 &&
+		m.GIDMappings[0].HostID == uint32(os.Getegid()) && m.GIDMappings[0].Size == 1
+}
+
+// writeMappings writes the ID mappings of the process pid with the
+// setuid newuidmap and newgidmap commands.
+func (m *IDMap) writeMappings(pid int) error {
+	for command, mappings := range map[string][]specs.LinuxIDMapping{
+		"newuidmap": m.UIDMappings,
+		"newgidmap": m.GIDMappings,
+	} {
+		path, err := lookPath(command)
+		if err != nil {
+			return err
+		}
+		args := []string{strconv.Itoa(pid)}
+		for _, id := range mappings {
+			args = append(args,
+				strconv.FormatUint(uint64(id.ContainerID), 10),
+				strconv.FormatUint(uint64(id.HostID), 10),
+				strconv.FormatUint(uint64(id.Size), 10),
+			)
+		}
+		if out, err := exec.Command(path, args...).CombinedOutput(); err != nil {
+			return fmt.Errorf("%s failed: %v: %s", command, err, out)
+		}
+	}
+	return nil
+}
+
+// Child runs the function of a process re-executed in a user namespace
+// and exits, it returns immediately otherwise. It must be called at the
+// beginning of main.
+func Child() {
+	name, ok := os.LookupEnv(childEnv)
+	if !ok {
+		return
+	}
+
+	// wait for the ID mappings and execute again to get the capabilities
+	// of the namespace root, they are dropped by the first execution of a
+	// process with an unmapped user
+	if _, ok := os.LookupEnv(syncEnv); ok {
+		sync := os.NewFile(syncFd, "sync")
+		buf := make([]byte, 1)
+		sync.Read(buf)
+		sync.Close()
+		os.Unsetenv(syncEnv)
+
+		if err := syscall.Exec("/proc/self/exe", os.Args, os.Environ()); err != nil {
+			fmt.Fprintf(os.Stderr, "while executing in user namespace: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	os.Unsetenv(childEnv)
+
+	fn, ok := funcs[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "no user namespace function %s\n", name)
+		os.Exit(1)
+	}
+	if err := fn(os.Args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// execFunc executes the command args as root of the user namespace.
+func execFunc(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command to execute")
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, args, os.Environ())
+}
+
+// removeAllFunc removes the paths args as root of the user namespace.
+func removeAllFunc(args []string) error {
+	for _, path := range args {
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func toSysProcIDMap(mappings []specs.LinuxIDMapping) []syscall.SysProcIDMap {
+	var ids []syscall.SysProcIDMap
+	for _, id := range mappings {
+		ids = append(ids, syscall.SysProcIDMap{
+			ContainerID: int(id.ContainerID),
+			HostID:      int(id.HostID),
+			Size:        int(id.Size),
+		})
+	}
+	return ids
+}
+
+// lookPath looks for the setuid ID mapping commands in the default PATH
+// rather than in the PATH of the user.
+func lookPath(command string) (string, error) {
+	for _, dir := range filepath.SplitList(env.DefaultPath) {
+		path := filepath.Join(dir, command)
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0 {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s not found in %s", command, env.DefaultPath)
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package userns
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+func init() {
+	Register("test-write", func(args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("unexpected arguments %q", args)
+		}
+		return ioutil.WriteFile(args[0], []byte(fmt.Sprint(os.Getuid())), 0644)
+	})
+}
+
+func TestMain(m *testing.M) {
+	Child()
+	os.Exit(m.Run())
+}
+
+func currentUser() *IDMap {
+	return &IDMap{
+		UIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: uint32(os.Geteuid()), Size: 1}},
+		GIDMappings: []specs.LinuxIDMapping{{ContainerID: 0, HostID: uint32(os.Getegid()), Size: 1}},
+	}
+}
+
+func TestRun(t *testing.T) {
+	var out bytes.Buffer
+	cmd := exec.Command("id", "-u")
+	cmd.Stdout = &out
+
+	err := currentUser().Run(cmd)
+	if errors.Is(err, ErrUnavailable) {
+		t.Skipf("user namespaces not available: %v", err)
+	} else if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uid := strings.TrimSpace(out.String()); uid != "0" {
+		t.Errorf("unexpected user %s in user namespace", uid)
+	}
+
+	if err := currentUser().Run(exec.Command("false")); err == nil {
+		t.Errorf("unexpected success of a failing command")
+	}
+}
+
+func TestCall(t *testing.T) {
+	dir, err := ioutil.TempDir("", "userns-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	m := currentUser()
+	path := filepath.Join(dir, "uid")
+	err = m.Call("test-write", path)
+	if errors.Is(err, ErrUnavailable) {
+		t.Skipf("user namespaces not available: %v", err)
+	} else if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || string(data) != "0" {
+		t.Errorf("unexpected user %q in user namespace: %v", data, err)
+	}
+
+	if err := m.RemoveAll(dir); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("%s not removed: %v", dir, err)
+	}
+
+	if err := m.Call("missing"); err == nil {
+		t.Errorf("unexpected success with an unregistered function")
+	}
+}
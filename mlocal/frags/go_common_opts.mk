@@ -1,6 +1,9 @@
 # go tool default build options
 GO111MODULE := on
 GO_TAGS := containers_image_openpgp sylog oci_engine singularity_engine fakeroot_engine
+# the containers-storage images are only read, don't require the btrfs
+# and devicemapper libraries
+GO_TAGS += exclude_graphdriver_btrfs exclude_graphdriver_devicemapper
 GO_TAGS_SUID := containers_image_openpgp sylog singularity_engine fakeroot_engine
 GO_LDFLAGS :=
 GO_BUILDMODE := -buildmode=pie
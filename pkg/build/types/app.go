@@ -0,0 +1,137 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AppMetadataFile is the name of the metadata of a SCIF app in its
+// /scif/apps/<app>/scif directory.
+const AppMetadataFile = "app.json"
+
+// AppMetadata describes a SCIF app for inspection.
+type AppMetadata struct {
+	Name string `json:"name"`
+	// Dependencies are the apps required by the app, direct and
+	// indirect, in install order.
+	Dependencies []string          `json:"dependencies,omitempty"`
+	Labels       map[string]string `json:"labels"`
+	// Environment holds the variables of the %appenv section.
+	Environment *Environment `json:"environment,omitempty"`
+}
+
+// ParseAppDependencies returns the app names listed in an %appdepends
+// section, they are separated by spaces, commas or new lines:
+//
+//	%appdepends samtools
+//	    htslib zlib
+func ParseAppDependencies(section string) []string {
+	var names []string
+	for _, line := range strings.Split(section, "\n") {
+		line = strings.Split(line, "#")[0]
+		names = append(names, strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})...)
+	}
+	return names
+}
+
+// AppDependencies returns the direct dependencies of the apps of the
+// definition d declared by their %appdepends section.
+func AppDependencies(d Definition) (map[string][]string, error) {
+	known := make(map[string]bool)
+	for _, app := range d.AppOrder {
+		known[app] = true
+	}
+
+	deps := make(map[string][]string)
+	for _, app := range d.AppOrder {
+		for _, dep := range ParseAppDependencies(d.CustomData["appdepends "+app]) {
+			if dep == app {
+				return nil, fmt.Errorf("app %s depends on itself", app)
+			}
+			if !known[dep] {
+				return nil, fmt.Errorf("app %s depends on unknown app %s", app, dep)
+			}
+			deps[app] = append(deps[app], dep)
+		}
+	}
+	return deps, nil
+}
+
+// AppInstallOrder returns the apps of the definition d ordered so that
+// each app comes after its dependencies, the apps keep the order of the
+// definition file otherwise.
+func AppInstallOrder(d Definition) ([]string, error) {
+	deps, err := AppDependencies(d)
+	if err != nil {
+		return nil, err
+	}
+
+	var order []string
+	state := make(map[string]int)
+	var visit func(app string, path []string) error
+	visit = func(app string, path []string) error {
+		path = append(path, app)
+		switch state[app] {
+		case 1:
+			return fmt.Errorf("app dependency cycle %s", strings.Join(path, " -> "))
+		case 2:
+			return nil
+		}
+		state[app] = 1
+		for _, dep := range deps[app] {
+			if err := visit(dep, path); err != nil {
+				return err
+			}
+		}
+		state[app] = 2
+		order = append(order, app)
+		return nil
+	}
+
+	for _, app := range d.AppOrder {
+		if err := visit(app, nil); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// AppRequirements returns the dependencies of app, direct and indirect,
+// in install order.
+func AppRequirements(d Definition, app string) ([]string, error) {
+	deps, err := AppDependencies(d)
+	if err != nil {
+		return nil, err
+	}
+	order, err := AppInstallOrder(d)
+	if err != nil {
+		return nil, err
+	}
+
+	required := make(map[string]bool)
+	var visit func(app string)
+	visit = func(app string) {
+		for _, dep := range deps[app] {
+			if !required[dep] {
+				required[dep] = true
+				visit(dep)
+			}
+		}
+	}
+	visit(app)
+
+	var reqs []string
+	for _, a := range order {
+		if required[a] {
+			reqs = append(reqs, a)
+		}
+	}
+	return reqs, nil
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAppInstallOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		order    []string
+		depends  map[string]string
+		expected []string
+		requires map[string][]string
+		wantErr  bool
+	}{
+		{
+			name:     "no dependencies",
+			order:    []string{"b", "a"},
+			expected: []string{"b", "a"},
+		},
+		{
+			name:  "dependencies",
+			order: []string{"samtools", "bwa", "htslib", "zlib"},
+			depends: map[string]string{
+				"samtools": "htslib\n# compression\nzlib",
+				"htslib":   "zlib",
+				"bwa":      "zlib, htslib",
+			},
+			expected: []string{"zlib", "htslib", "samtools", "bwa"},
+			requires: map[string][]string{
+				"samtools": {"zlib", "htslib"},
+				"bwa":      {"zlib", "htslib"},
+				"zlib":     nil,
+			},
+		},
+		{
+			name:    "cycle",
+			order:   []string{"a", "b"},
+			depends: map[string]string{"a": "b", "b": "a"},
+			wantErr: true,
+		},
+		{
+			name:    "unknown app",
+			order:   []string{"a"},
+			depends: map[string]string{"a": "b"},
+			wantErr: true,
+		},
+		{
+			name:    "self",
+			order:   []string{"a"},
+			depends: map[string]string{"a": "a"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := Definition{AppOrder: tt.order, CustomData: make(map[string]string)}
+			for app, deps := range tt.depends {
+				d.CustomData["appdepends "+app] = deps
+			}
+
+			order, err := AppInstallOrder(d)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(order, tt.expected) {
+				t.Errorf("unexpected install order %q instead of %q", order, tt.expected)
+			}
+
+			for app, expected := range tt.requires {
+				reqs, err := AppRequirements(d, app)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if !reflect.DeepEqual(reqs, expected) {
+					t.Errorf("unexpected requirements %q of %s instead of %q", reqs, app, expected)
+				}
+			}
+		})
+	}
+}
@@ -6,21 +6,61 @@
 package types
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	ocitypes "github.com/containers/image/v5/types"
+	"github.com/sylabs/singularity/internal/pkg/build/events"
 	"github.com/sylabs/singularity/internal/pkg/cache"
 	"github.com/sylabs/singularity/internal/pkg/util/fs"
+	"github.com/sylabs/singularity/internal/pkg/util/userns"
 	"github.com/sylabs/singularity/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/util/crypt"
+	"golang.org/x/crypto/openpgp"
 	"golang.org/x/sys/unix"
 )
 
-const OCIConfigJSON = "oci-config"
+const (
+	OCIConfigJSON = "oci-config"
+	// SpackLockJSON is the concretized environment of the spack agent.
+	SpackLockJSON = "spack-lock"
+	// SBOMJSON is the software bill of materials generated with --sbom.
+	SBOMJSON = "sbom"
+	// SBOMFile is the location of the SBOM in the container.
+	SBOMFile = "/.singularity.d/sbom.json"
+	// ProvenanceJSON describes the builder, the rendered definition and
+	// the base images of the build.
+	ProvenanceJSON = "provenance"
+	// ProvenanceFile is the location of the provenance in sandboxes.
+	ProvenanceFile = "/.singularity.d/provenance.json"
+	// BuildLogObject is the build log stored in a generic SIF descriptor.
+	BuildLogObject = "build.log"
+	// BuildLogFile is the location of the build log in sandboxes.
+	BuildLogFile = "/.singularity.d/build.log"
+	// EnvironmentJSON holds the variables set by the %environment section.
+	EnvironmentJSON = "environment"
+	// EnvironmentFile is the location of the environment variables in
+	// the container.
+	EnvironmentFile = "/.singularity.d/environment.json"
+	// NoneNetwork runs the build sections with loopback networking only.
+	NoneNetwork = "none"
+	// HostNetwork runs the build sections in the host network.
+	HostNetwork = "host"
+	// DefaultDownloads is the default number of concurrent downloads.
+	DefaultDownloads = 4
+)
+
+// JSONObjectFiles maps the JSON objects written by %post in the container
+// to their path, they are added to the bundle JSON objects once %post ran.
+var JSONObjectFiles = map[string]string{
+	SpackLockJSON: "/.singularity.d/spack.lock",
+}
 
 // Bundle is the temporary environment used during the image building process.
 type Bundle struct {
@@ -30,6 +70,44 @@ type Bundle struct {
 
 	RootfsPath string `json:"rootfsPath"` // where actual fs to chroot will appear
 	TmpDir     string `json:"tmpPath"`    // where temp files required during build will appear
+
+	// PostBinds are the src:dest bind paths mounted in the container
+	// while running the %post section, set by the conveyorPacker.
+	PostBinds []string `json:"postBinds,omitempty"`
+
+	// GenericObjects are added to SIF images in generic descriptors
+	// named after their key (eg: build.log).
+	GenericObjects map[string][]byte `json:"genericObjects,omitempty"`
+	// BaseImage is the base image resolved by the conveyorPacker,
+	// recorded in the build provenance.
+	BaseImage *BaseImage `json:"baseImage,omitempty"`
+	// UserNamespace is set when the root filesystem was unpacked by an
+	// unprivileged user in a user namespace, its files are owned by the
+	// subordinate IDs of the user and are removed in the namespace.
+	UserNamespace *userns.IDMap `json:"userNamespace,omitempty"`
+	// RootfsLayers are the layers of the root filesystem streamed to
+	// the assembler when set, the root filesystem directory then only
+	// holds the files added on top of them (eg: .singularity.d).
+	RootfsLayers Layers `json:"-"`
+}
+
+// Layers are the layers of an image root filesystem, they're closed when
+// the bundle is removed.
+type Layers interface {
+	io.Closer
+	// Len returns the number of layers.
+	Len() int
+	// Open returns the uncompressed tar stream of the layer i, the
+	// layer 0 is the bottom one.
+	Open(ctx context.Context, i int) (io.ReadCloser, error)
+	// Arch returns the architecture of the image.
+	Arch() string
+}
+
+// BaseImage describes the image a stage is bootstrapped from.
+type BaseImage struct {
+	Source string `json:"source"`
+	Digest string `json:"digest"`
 }
 
 // Options defines build time behavior to be executed on the bundle.
@@ -48,6 +126,9 @@ type Options struct {
 	// encryption if applicable.
 	// A nil value indicates encryption should not occur.
 	EncryptionKeyInfo *crypt.KeyInfo
+	// EncryptionKeys are the recipients the filesystem is encrypted
+	// for along with EncryptionKeyInfo, any of them decrypts it.
+	EncryptionKeys []crypt.KeyProvider `json:"-"`
 	// ImgCache stores a pointer to the image cache to use.
 	ImgCache *cache.Handle
 	// NoTest indicates if build should skip running the test script.
@@ -70,6 +151,63 @@ type Options struct {
 	// To warn when the above is needed, we need to know if the target of this
 	// bundle will be a sandbox
 	SandboxTarget bool
+	// Compression is the squashfs compression algorithm of SIF images, it
+	// takes precedence over the definition file Compression header.
+	Compression string `json:"compression,omitempty"`
+	// CompressionLevel is the squashfs compression level, it takes precedence
+	// over the definition file CompressionLevel header.
+	CompressionLevel int `json:"compressionLevel,omitempty"`
+	// RootfsFormat is the filesystem of the root filesystem partition of
+	// SIF images, squashfs or erofs, squashfs if empty.
+	RootfsFormat string `json:"rootfsFormat,omitempty"`
+	// Platform is the os/arch[/variant] image selected from multi-platform
+	// OCI sources, the host platform is selected if empty.
+	Platform string `json:"platform,omitempty"`
+	// SBOM is the format of the software bill of materials generated
+	// from the final root filesystem, none is generated if empty.
+	SBOM string `json:"sbom,omitempty"`
+	// TestReport is the path the report of the %test and %apptest sections
+	// is written to, in the JUnit format for a .xml path and JSON otherwise.
+	TestReport string `json:"testReport,omitempty"`
+	// Binds are the src:dest[:ro] bind paths mounted in the container
+	// while running the %post section, they aren't part of the image.
+	Binds []string `json:"binds,omitempty"`
+	// CacheSections enables the cache of the stages root filesystem once
+	// bootstrapped and once %post ran, they are reused when the definition
+	// file sections they depend on didn't change.
+	CacheSections bool `json:"cacheSections,omitempty"`
+	// Network is the network of the %post and %test sections, either none,
+	// host or a comma separated list of CNI profiles, host if empty.
+	Network string `json:"network,omitempty"`
+	// Memory is the memory limit in bytes of the %post and %test sections.
+	Memory int64 `json:"memory,omitempty"`
+	// CPUs is the number of CPUs available to the %post and %test sections.
+	CPUs float64 `json:"cpus,omitempty"`
+	// Timeout is the duration after which the build is aborted.
+	Timeout time.Duration `json:"timeout,omitempty"`
+	// Downloads is the maximum number of concurrent downloads of the OCI
+	// layers and %files URL sources, DefaultDownloads if unset.
+	Downloads int `json:"downloads,omitempty"`
+	// Parallel is the maximum number of independent stages built
+	// concurrently, the stages are built one after the other if lower
+	// than 2.
+	Parallel int `json:"parallel,omitempty"`
+	// SignEntity is the decrypted key signing the SIF image before it is
+	// moved to its destination, the image isn't signed if nil.
+	SignEntity *openpgp.Entity `json:"-"`
+	// Events receives the machine-readable build progress, the events
+	// are discarded if nil.
+	Events *events.Emitter `json:"-"`
+	// NoBuildLog disables the capture of the build output stored in the
+	// image along with the build provenance.
+	NoBuildLog bool `json:"noBuildLog,omitempty"`
+	// Verity adds a dm-verity hash tree of the squashfs root filesystem
+	// partition to SIF images.
+	Verity bool `json:"verity,omitempty"`
+	// StreamLayers lets the OCI sources stream their layers to the SIF
+	// assembler instead of unpacking them, it's only set when nothing
+	// modifies the root filesystem during the build (eg: pull).
+	StreamLayers bool `json:"streamLayers,omitempty"`
 }
 
 // NewEncryptedBundle creates an Encrypted Bundle environment.
@@ -77,6 +215,11 @@ func NewEncryptedBundle(rootfs, tempDir string, keyInfo *crypt.KeyInfo) (b *Bund
 	return newBundle(rootfs, tempDir, keyInfo)
 }
 
+// Encrypted returns true if the image filesystem is encrypted.
+func (o Options) Encrypted() bool {
+	return o.EncryptionKeyInfo != nil || len(o.EncryptionKeys) > 0
+}
+
 // NewBundle creates a Bundle environment.
 func NewBundle(rootfs, tempDir string) (b *Bundle, err error) {
 	return newBundle(rootfs, tempDir, nil)
@@ -100,7 +243,19 @@ func (b *Bundle) RunSection(s string) bool {
 // Remove cleans up any bundle files.
 func (b *Bundle) Remove() error {
 	var errors []string
+	if b.RootfsLayers != nil {
+		if err := b.RootfsLayers.Close(); err != nil {
+			errors = append(errors, fmt.Sprintf("could not close image layers: %v", err))
+		}
+		b.RootfsLayers = nil
+	}
 	for _, dir := range []string{b.TmpDir, b.RootfsPath} {
+		if b.UserNamespace != nil {
+			if err := b.UserNamespace.RemoveAll(dir); err != nil {
+				errors = append(errors, fmt.Sprintf("could not remove %q: %v", dir, err))
+			}
+			continue
+		}
 		if err := fs.ForceRemoveAll(dir); err != nil {
 			errors = append(errors, fmt.Sprintf("could not remove %q: %v", dir, err))
 		}
@@ -19,11 +19,24 @@ type Definition struct {
 	ImageData  `json:"imageData"`
 	BuildData  Data              `json:"buildData"`
 	CustomData map[string]string `json:"customData"`
+	// CustomArgs holds the arguments following the app name of the
+	// app sections in CustomData.
+	CustomArgs map[string]string `json:"customArgs,omitempty"`
 	Raw        []byte            `json:"raw"`
 	// SCIF app sections must be processed in order from the definition file,
 	// so we need to record the order of the items as they are parsed from the
 	// file into unordered maps.
 	AppOrder []string `json:"appOrder"`
+	// Includes records the fragments pulled in by %include directives
+	// for provenance.
+	Includes []Include `json:"includes,omitempty"`
+}
+
+// Include describes a definition file fragment included
+// with an %include directive.
+type Include struct {
+	Source string `json:"source"`
+	Digest string `json:"digest"`
 }
 
 // ImageData contains any scripts, metadata, etc... that needs to be
@@ -68,6 +81,30 @@ type Files struct {
 type FileTransport struct {
 	Src string `json:"source"`
 	Dst string `json:"destination"`
+
+	// Exclude are the patterns of the source files not copied.
+	Exclude []string `json:"exclude,omitempty"`
+	// Owner is the user[:group] owning the copied files, the names are
+	// looked up in the container.
+	Owner string `json:"owner,omitempty"`
+	// Mode is the octal permission mode of the copied files.
+	Mode string `json:"mode,omitempty"`
+}
+
+// Options returns the --exclude, --chown and --chmod options of the
+// %files line of the transport.
+func (ft FileTransport) Options() string {
+	var opts []string
+	for _, e := range ft.Exclude {
+		opts = append(opts, "--exclude "+e)
+	}
+	if ft.Owner != "" {
+		opts = append(opts, "--chown "+ft.Owner)
+	}
+	if ft.Mode != "" {
+		opts = append(opts, "--chmod "+ft.Mode)
+	}
+	return strings.Join(opts, " ")
 }
 
 // Script describes any script section of a definition.
@@ -143,6 +180,10 @@ func writeFilesIfExists(w io.Writer, f []Files) {
 			fmt.Fprintln(w)
 
 			for _, ft := range f.Files {
+				if opts := ft.Options(); opts != "" {
+					fmt.Fprintf(w, "\t%s\t%s %s\n", ft.Src, ft.Dst, opts)
+					continue
+				}
 				fmt.Fprintf(w, "\t%s\t%s\n", ft.Src, ft.Dst)
 			}
 			fmt.Fprintln(w)
@@ -0,0 +1,424 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package types
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// Runtime shells of the %environment section.
+const (
+	// ShellSh is a POSIX shell environment.
+	ShellSh = "sh"
+	// ShellBash is a bash environment.
+	ShellBash = "bash"
+	// ShellFish is a fish environment, it is translated to a POSIX
+	// script as the environment is sourced by a POSIX compatible shell.
+	ShellFish = "fish"
+)
+
+// EnvironmentVariable is a variable set by the %environment section.
+type EnvironmentVariable struct {
+	Name string `json:"name"`
+	// Value is the value before expansion in the POSIX shell syntax.
+	Value    string `json:"value"`
+	Exported bool   `json:"exported"`
+	// Unset is true if the variable is removed from the environment.
+	Unset bool `json:"unset,omitempty"`
+	// Line is the line of the section setting the variable.
+	Line int `json:"line"`
+}
+
+// Environment is the validated %environment section, the variables are
+// stored in the image for inspection.
+type Environment struct {
+	Shell     string                `json:"shell"`
+	Variables []EnvironmentVariable `json:"variables"`
+	// Script is the environment script sourced at runtime.
+	Script string `json:"-"`
+}
+
+// EnvironmentError is a syntax error of the %environment section.
+type EnvironmentError struct {
+	// Line is the line of the section, 0 if unknown.
+	Line int
+	Msg  string
+}
+
+func (e *EnvironmentError) Error() string {
+	if e.Line == 0 {
+		return e.Msg
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ParseEnvironmentShell parses the arguments of an %environment section:
+//
+//	%environment --shell fish
+//
+// The default shell syntax is the one of the runtime interpreter, which
+// accepts bash constructs, it's reported as sh.
+func ParseEnvironmentShell(args string) (shell string, strict bool, err error) {
+	fs := pflag.NewFlagSet("environment", pflag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&shell, "shell", "", "")
+
+	if err := fs.Parse(strings.Fields(args)); err != nil {
+		return "", false, fmt.Errorf("invalid environment options %q: %v", args, err)
+	}
+	if fs.NArg() > 0 {
+		return "", false, fmt.Errorf("invalid environment options %q: unexpected argument %s", args, fs.Arg(0))
+	}
+	switch shell {
+	case "":
+		return ShellSh, false, nil
+	case ShellSh, ShellBash, ShellFish:
+		return shell, true, nil
+	default:
+		return "", false, fmt.Errorf("invalid environment options %q: unsupported shell %s, must be sh, bash or fish", args, shell)
+	}
+}
+
+// ParseEnvironment validates the %environment script s and returns the
+// variables it sets, syntax errors are returned as *EnvironmentError.
+func ParseEnvironment(s Script) (*Environment, error) {
+	shell, strict, err := ParseEnvironmentShell(s.Args)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &Environment{Shell: shell, Script: s.Script}
+	switch {
+	case shell == ShellFish:
+		err = e.parseFish(s.Script)
+	case shell == ShellSh && strict:
+		err = e.parseShell(s.Script, syntax.LangPOSIX)
+	default:
+		err = e.parseShell(s.Script, syntax.LangBash)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// Unexported returns the variables set and never exported, they are not
+// visible by the container processes.
+func (e *Environment) Unexported() []EnvironmentVariable {
+	var vars []EnvironmentVariable
+	seen := make(map[string]bool)
+	for _, v := range e.Variables {
+		if v.Exported || v.Unset || seen[v.Name] {
+			continue
+		}
+		seen[v.Name] = true
+		vars = append(vars, v)
+	}
+	return vars
+}
+
+// parseShell collects the assignments and the exported variables of a
+// POSIX or bash script.
+func (e *Environment) parseShell(script string, lang syntax.LangVariant) error {
+	f, err := syntax.NewParser(syntax.Variant(lang)).Parse(strings.NewReader(script), "")
+	if err != nil {
+		if perr, ok := err.(syntax.ParseError); ok {
+			return &EnvironmentError{Line: int(perr.Pos.Line()), Msg: perr.Text}
+		}
+		return &EnvironmentError{Msg: err.Error()}
+	}
+
+	exported := make(map[string]bool)
+	allExport := false
+	printer := syntax.NewPrinter()
+	value := func(a *syntax.Assign) string {
+		var buf bytes.Buffer
+		if a.Value != nil {
+			printer.Print(&buf, a.Value)
+		} else if a.Array != nil {
+			printer.Print(&buf, a.Array)
+		}
+		return buf.String()
+	}
+	add := func(a *syntax.Assign, export bool) {
+		if a.Name == nil {
+			return
+		}
+		if export {
+			exported[a.Name.Value] = true
+		}
+		if a.Naked {
+			return
+		}
+		e.Variables = append(e.Variables, EnvironmentVariable{
+			Name:  a.Name.Value,
+			Value: value(a),
+			Line:  int(a.Pos().Line()),
+		})
+	}
+
+	syntax.Walk(f, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.CallExpr:
+			if len(n.Args) == 0 {
+				for _, a := range n.Assigns {
+					add(a, false)
+				}
+				return true
+			}
+			switch n.Args[0].Lit() {
+			case "set":
+				for _, arg := range n.Args[1:] {
+					if arg.Lit() == "-a" || arg.Lit() == "allexport" {
+						allExport = true
+					}
+				}
+			case "unset":
+				for _, arg := range n.Args[1:] {
+					if name := arg.Lit(); name != "" && !strings.HasPrefix(name, "-") {
+						e.Variables = append(e.Variables, EnvironmentVariable{
+							Name:  name,
+							Unset: true,
+							Line:  int(arg.Pos().Line()),
+						})
+					}
+				}
+			}
+		case *syntax.DeclClause:
+			export := n.Variant.Value == "export"
+			if n.Variant.Value == "local" || n.Variant.Value == "nameref" {
+				return true
+			}
+			for _, a := range n.Args {
+				if a.Name == nil && a.Value != nil && strings.HasPrefix(a.Value.Lit(), "-") {
+					export = export || strings.Contains(a.Value.Lit(), "x")
+				}
+			}
+			for _, a := range n.Args {
+				add(a, export)
+			}
+		}
+		return true
+	})
+
+	for i, v := range e.Variables {
+		e.Variables[i].Exported = !v.Unset && (allExport || exported[v.Name])
+	}
+	return nil
+}
+
+// parseFish translates the fish script to a POSIX script, only the set
+// and export commands are supported as the environment is sourced by a
+// POSIX compatible shell.
+func (e *Environment) parseFish(script string) error {
+	var posix strings.Builder
+	posix.WriteString("# translated from the fish %environment section\n")
+
+	for i, line := range strings.Split(script, "\n") {
+		lineNum := i + 1
+		words, err := fishWords(line)
+		if err != nil {
+			return &EnvironmentError{Line: lineNum, Msg: err.Error()}
+		}
+		if len(words) == 0 {
+			continue
+		}
+
+		var v EnvironmentVariable
+		switch words[0] {
+		case "set":
+			v, err = fishSet(words[1:])
+		case "export":
+			if len(words) != 2 || !strings.Contains(words[1], "=") {
+				err = fmt.Errorf("export requires a NAME=value argument")
+				break
+			}
+			kv := strings.SplitN(words[1], "=", 2)
+			v = EnvironmentVariable{Name: kv[0], Value: `"` + fishToPOSIX(kv[1]) + `"`, Exported: true}
+		default:
+			err = fmt.Errorf("unsupported fish command %s, only set and export are supported", fishUnquote(words[0]))
+		}
+		if err != nil {
+			return &EnvironmentError{Line: lineNum, Msg: err.Error()}
+		}
+		if !syntax.ValidName(v.Name) {
+			return &EnvironmentError{Line: lineNum, Msg: fmt.Sprintf("invalid variable name %s", v.Name)}
+		}
+
+		v.Line = lineNum
+		switch {
+		case v.Unset:
+			fmt.Fprintf(&posix, "unset %s\n", v.Name)
+		case v.Exported:
+			fmt.Fprintf(&posix, "export %s=%s\n", v.Name, v.Value)
+		default:
+			fmt.Fprintf(&posix, "%s=%s\n", v.Name, v.Value)
+		}
+		e.Variables = append(e.Variables, v)
+	}
+
+	// fish keeps the export flag of a variable assigned again
+	exported := make(map[string]bool)
+	for _, v := range e.Variables {
+		exported[v.Name] = exported[v.Name] || v.Exported
+	}
+	for i, v := range e.Variables {
+		e.Variables[i].Exported = !v.Unset && exported[v.Name]
+	}
+
+	e.Script = posix.String()
+	return nil
+}
+
+// fishSet translates the arguments of a fish set command.
+func fishSet(args []string) (EnvironmentVariable, error) {
+	var v EnvironmentVariable
+	var appendValue, prependValue bool
+
+	for len(args) > 0 && strings.HasPrefix(args[0], "-") {
+		flag := args[0]
+		args = args[1:]
+		if strings.HasPrefix(flag, "--") {
+			switch flag {
+			case "--global", "--local":
+			case "--export":
+				v.Exported = true
+			case "--unexport":
+				v.Exported = false
+			case "--erase":
+				v.Unset = true
+			case "--append":
+				appendValue = true
+			case "--prepend":
+				prependValue = true
+			default:
+				return v, fmt.Errorf("unsupported set option %s", flag)
+			}
+			continue
+		}
+		for _, c := range flag[1:] {
+			switch c {
+			case 'g', 'l':
+			case 'x':
+				v.Exported = true
+			case 'u':
+				v.Exported = false
+			case 'e':
+				v.Unset = true
+			case 'a':
+				appendValue = true
+			case 'p':
+				prependValue = true
+			default:
+				return v, fmt.Errorf("unsupported set option -%c", c)
+			}
+		}
+	}
+	if len(args) == 0 {
+		return v, fmt.Errorf("set requires a variable name")
+	}
+	v.Name = args[0]
+	if v.Unset {
+		if len(args) > 1 {
+			return v, fmt.Errorf("set --erase takes a single variable name")
+		}
+		return v, nil
+	}
+
+	// fish lists are joined with colons in path variables like
+	// when they are exported by fish
+	sep := " "
+	if strings.HasSuffix(v.Name, "PATH") {
+		sep = ":"
+	}
+	values := make([]string, 0, len(args))
+	for _, a := range args[1:] {
+		values = append(values, fishToPOSIX(a))
+	}
+	if appendValue {
+		values = append([]string{"${" + v.Name + "}"}, values...)
+	}
+	if prependValue {
+		values = append(values, "${"+v.Name+"}")
+	}
+	v.Value = `"` + strings.Join(values, sep) + `"`
+	return v, nil
+}
+
+// fishWords splits a line of a fish script into words keeping their
+// quotes, comments are removed.
+func fishWords(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	var quote rune
+	inWord := false
+
+	for _, c := range line {
+		switch {
+		case quote != 0:
+			word.WriteRune(c)
+			if c == quote {
+				quote = 0
+			}
+			continue
+		case c == '\'' || c == '"':
+			quote = c
+		case c == ' ' || c == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case c == '#' && !inWord:
+			return words, nil
+		case c == '(' || c == ')' || c == ';' || c == '|' || c == '&' || c == '\\':
+			return nil, fmt.Errorf("unsupported fish syntax %q", c)
+		}
+		word.WriteRune(c)
+		inWord = true
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quoted string")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// fishUnquote removes the quotes of a fish word.
+func fishUnquote(word string) string {
+	return strings.NewReplacer(`'`, ``, `"`, ``).Replace(word)
+}
+
+// fishToPOSIX returns the content of a double quoted POSIX string with
+// the value of the fish word, variables are expanded except in single
+// quoted parts.
+func fishToPOSIX(word string) string {
+	var b strings.Builder
+	var quote rune
+	for _, c := range word {
+		switch {
+		case quote == 0 && (c == '\'' || c == '"'):
+			quote = c
+		case c == quote:
+			quote = 0
+		case quote == '\'' && c == '$', c == '"', c == '`', c == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(c)
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,119 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package types
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnvironment(t *testing.T) {
+	tests := []struct {
+		name       string
+		script     Script
+		shell      string
+		variables  []EnvironmentVariable
+		unexported []string
+		posix      string
+		errLine    int
+		wantErr    bool
+	}{
+		{
+			name:   "default",
+			script: Script{Script: "export A=1\nB=\"$A:2\"\nC=3\nexport C\nunset D\n[[ -n $A ]] && E=4"},
+			shell:  ShellSh,
+			variables: []EnvironmentVariable{
+				{Name: "A", Value: "1", Exported: true, Line: 1},
+				{Name: "B", Value: `"$A:2"`, Line: 2},
+				{Name: "C", Value: "3", Exported: true, Line: 3},
+				{Name: "D", Unset: true, Line: 5},
+				{Name: "E", Value: "4", Line: 6},
+			},
+			unexported: []string{"B", "E"},
+		},
+		{
+			name:   "allexport",
+			script: Script{Args: "--shell bash", Script: "set -a\nA=1\ndeclare -x B=2"},
+			shell:  ShellBash,
+			variables: []EnvironmentVariable{
+				{Name: "A", Value: "1", Exported: true, Line: 2},
+				{Name: "B", Value: "2", Exported: true, Line: 3},
+			},
+		},
+		{
+			name:    "posix",
+			script:  Script{Args: "--shell sh", Script: "export A=1\nexport B=(1 2)"},
+			errLine: 2,
+			wantErr: true,
+		},
+		{
+			name:    "syntax error",
+			script:  Script{Script: "export A=1\nif true; then\nexport B=2"},
+			errLine: 2,
+			wantErr: true,
+		},
+		{
+			name:   "fish",
+			script: Script{Args: "--shell fish", Script: "# comment\nset -gx PATH /opt/bin $PATH\nset -x GREETING 'hello $USER'\nset LOCAL value\nset -e OLD\nexport A=\"$HOME/a\""},
+			shell:  ShellFish,
+			variables: []EnvironmentVariable{
+				{Name: "PATH", Value: `"/opt/bin:$PATH"`, Exported: true, Line: 2},
+				{Name: "GREETING", Value: `"hello \$USER"`, Exported: true, Line: 3},
+				{Name: "LOCAL", Value: `"value"`, Line: 4},
+				{Name: "OLD", Unset: true, Line: 5},
+				{Name: "A", Value: `"$HOME/a"`, Exported: true, Line: 6},
+			},
+			unexported: []string{"LOCAL"},
+			posix:      "export PATH=\"/opt/bin:$PATH\"\nexport GREETING=\"hello \\$USER\"\nLOCAL=\"value\"\nunset OLD\nexport A=\"$HOME/a\"\n",
+		},
+		{
+			name:    "fish command",
+			script:  Script{Args: "--shell fish", Script: "set -x A 1\nif test -d /opt\nend"},
+			errLine: 2,
+			wantErr: true,
+		},
+		{
+			name:    "invalid shell",
+			script:  Script{Args: "--shell zsh", Script: "export A=1"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, err := ParseEnvironment(tt.script)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				if envErr, ok := err.(*EnvironmentError); ok && envErr.Line != tt.errLine {
+					t.Errorf("unexpected error line %d instead of %d: %v", envErr.Line, tt.errLine, err)
+				} else if !ok && tt.errLine != 0 {
+					t.Errorf("unexpected error type %T: %v", err, err)
+				}
+				return
+			}
+
+			if e.Shell != tt.shell {
+				t.Errorf("unexpected shell %s instead of %s", e.Shell, tt.shell)
+			}
+			if !reflect.DeepEqual(e.Variables, tt.variables) {
+				t.Errorf("unexpected variables %+v instead of %+v", e.Variables, tt.variables)
+			}
+			var unexported []string
+			for _, v := range e.Unexported() {
+				unexported = append(unexported, v.Name)
+			}
+			if !reflect.DeepEqual(unexported, tt.unexported) {
+				t.Errorf("unexpected unexported variables %q instead of %q", unexported, tt.unexported)
+			}
+			if tt.posix != "" && !strings.HasSuffix(e.Script, tt.posix) {
+				t.Errorf("unexpected POSIX script:\n%s", e.Script)
+			}
+		})
+	}
+}
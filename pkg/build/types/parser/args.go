@@ -0,0 +1,179 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// BuildArgEnvPrefix is the prefix of the environment variables
+// providing build argument values (eg: SINGULARITY_BUILD_ARG_TAG).
+const BuildArgEnvPrefix = "SINGULARITY_BUILD_ARG_"
+
+var (
+	// buildArgRegexp matches the {{ variable }} references of a definition file
+	buildArgRegexp = regexp.MustCompile(`{{\s*([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+	// buildArgNameRegexp matches valid build argument names
+	buildArgNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+// ParseBuildArgs parses the build arguments provided as KEY=VALUE lines,
+// empty lines and lines starting with # are ignored.
+func ParseBuildArgs(lines []string) (map[string]string, error) {
+	args := make(map[string]string)
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("build argument %q is not in the KEY=VALUE form", line)
+		}
+		key := strings.TrimSpace(kv[0])
+		if !buildArgNameRegexp.MatchString(key) {
+			return nil, fmt.Errorf("invalid build argument name %q", key)
+		}
+		args[key] = kv[1]
+	}
+
+	return args, nil
+}
+
+// ReadBuildArgsFile reads build arguments from a file
+// containing KEY=VALUE lines.
+func ReadBuildArgsFile(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("while opening build arguments file: %v", err)
+	}
+	defer f.Close()
+
+	var lines []string
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		lines = append(lines, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("while reading build arguments file: %v", err)
+	}
+
+	return ParseBuildArgs(lines)
+}
+
+// argumentsDefaults returns the default values declared as KEY=VALUE
+// lines in the %arguments sections of the definition.
+func argumentsDefaults(raw []byte) (map[string]string, error) {
+	var lines []string
+
+	inArguments := false
+
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "%") {
+			inArguments = getSectionName(line) == "arguments"
+			continue
+		} else if strings.HasPrefix(strings.ToLower(line), "bootstrap:") {
+			// a new stage begins
+			inArguments = false
+		}
+		if inArguments {
+			lines = append(lines, line)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	defaults, err := ParseBuildArgs(lines)
+	if err != nil {
+		return nil, fmt.Errorf("in %%arguments section: %v", err)
+	}
+	return defaults, nil
+}
+
+// ApplyBuildArgs replaces the {{ variable }} references of the raw
+// definition file by their values. Values are taken in order from args,
+// from the BuildArgEnvPrefix environment variables and from the defaults
+// declared in the %arguments section. An error listing the variables
+// without value is returned if any.
+func ApplyBuildArgs(raw []byte, args map[string]string) ([]byte, error) {
+	defaults, err := argumentsDefaults(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[string]bool)
+	missing := make([]string, 0)
+
+	value := func(name string) (string, bool) {
+		if v, ok := args[name]; ok {
+			return v, true
+		}
+		if v, ok := os.LookupEnv(BuildArgEnvPrefix + name); ok {
+			return v, true
+		}
+		v, ok := defaults[name]
+		return v, ok
+	}
+
+	result := buildArgRegexp.ReplaceAllFunc(raw, func(ref []byte) []byte {
+		name := string(buildArgRegexp.FindSubmatch(ref)[1])
+		used[name] = true
+		v, ok := value(name)
+		if !ok {
+			if !contains(missing, name) {
+				missing = append(missing, name)
+			}
+			return ref
+		}
+		return []byte(v)
+	})
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("required build arguments not provided: %s", strings.Join(missing, ", "))
+	}
+
+	for _, name := range sortedKeys(args) {
+		if !used[name] {
+			sylog.Warningf("Build argument %s is not used by the definition file", name)
+		}
+	}
+
+	return result, nil
+}
+
+// sortedKeys returns the sorted keys of the map m.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// contains returns true if s is an element of list.
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseBuildArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		lines      []string
+		expected   map[string]string
+		shouldFail bool
+	}{
+		{
+			name:     "comments and empty lines",
+			lines:    []string{"# comment", "", "TAG=20.04", "OPTS=a=b c"},
+			expected: map[string]string{"TAG": "20.04", "OPTS": "a=b c"},
+		},
+		{
+			name:       "missing value",
+			lines:      []string{"TAG"},
+			shouldFail: true,
+		},
+		{
+			name:       "invalid name",
+			lines:      []string{"1TAG=20.04"},
+			shouldFail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := ParseBuildArgs(tt.lines)
+			if err != nil && !tt.shouldFail {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.shouldFail {
+				t.Fatalf("unexpected success")
+			}
+			if !tt.shouldFail && !reflect.DeepEqual(args, tt.expected) {
+				t.Errorf("unexpected arguments %v instead of %v", args, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyBuildArgs(t *testing.T) {
+	const def = `Bootstrap: docker
+From: ubuntu:{{ TAG }}
+
+%arguments
+    TAG=18.04
+
+%post
+    echo {{PACKAGE}} {{ TAG }}
+`
+
+	os.Setenv(BuildArgEnvPrefix+"PACKAGE", "fortune")
+	defer os.Unsetenv(BuildArgEnvPrefix + "PACKAGE")
+
+	tests := []struct {
+		name       string
+		def        string
+		args       map[string]string
+		expected   string
+		shouldFail bool
+	}{
+		{
+			name: "defaults and environment",
+			def:  def,
+			expected: `Bootstrap: docker
+From: ubuntu:18.04
+
+%arguments
+    TAG=18.04
+
+%post
+    echo fortune 18.04
+`,
+		},
+		{
+			name: "arguments",
+			def:  def,
+			args: map[string]string{"TAG": "20.04", "PACKAGE": "cowsay"},
+			expected: `Bootstrap: docker
+From: ubuntu:20.04
+
+%arguments
+    TAG=18.04
+
+%post
+    echo cowsay 20.04
+`,
+		},
+		{
+			name:       "missing",
+			def:        "Bootstrap: docker\nFrom: {{ IMAGE }}\n",
+			shouldFail: true,
+		},
+		{
+			name:       "invalid default",
+			def:        "Bootstrap: docker\n%arguments\n    TAG\n",
+			shouldFail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := ApplyBuildArgs([]byte(tt.def), tt.args)
+			if err != nil && !tt.shouldFail {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.shouldFail {
+				t.Fatalf("unexpected success")
+			}
+			if !tt.shouldFail && string(b) != tt.expected {
+				t.Errorf("unexpected definition:\n%s", b)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// CheckOptions holds the build context of the %post and %runscript
+// static analysis.
+type CheckOptions struct {
+	// HostPaths are the host directories which are not available in the
+	// container, eg: the home directory of the user running the build.
+	HostPaths []string
+	// Binds are the container paths bound while running %post, the
+	// paths below them are available.
+	Binds []string
+}
+
+// checkedSections are the sections analyzed by CheckScripts, set -e is
+// only checked in %post as %appinstall sections are appended to it.
+var checkedSections = map[string]bool{
+	"post":       true,
+	"runscript":  true,
+	"appinstall": true,
+	"apprun":     true,
+}
+
+// specialParams are the shell parameters which expand to a single word.
+var specialParams = map[string]bool{
+	"?": true,
+	"#": true,
+	"$": true,
+	"!": true,
+	"-": true,
+}
+
+// checkKey identifies a reported name on a line.
+type checkKey struct {
+	line int
+	name string
+}
+
+// scriptSection holds the lines of a section checked by CheckScripts.
+type scriptSection struct {
+	name  string
+	args  string
+	line  int
+	lines []string
+}
+
+// CheckScripts analyzes the %post, %runscript, %appinstall and %apprun
+// sections of the raw definition file like shellcheck would. It reports
+// syntax errors, variables expanded without quotes, a %post section run
+// by another shell with -c without set -e and references to the host
+// paths of opts. Diagnostics are sorted by line number.
+func CheckScripts(raw []byte, opts CheckOptions) []Diagnostic {
+	l := &linter{}
+
+	var section *scriptSection
+	flush := func() {
+		if section != nil {
+			l.checkScript(section, opts)
+		}
+		section = nil
+	}
+
+	lineNum := 0
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	for s.Scan() {
+		lineNum++
+		line := strings.TrimSpace(s.Text())
+
+		if stageRegexp.MatchString(s.Text()) {
+			flush()
+			continue
+		}
+		if strings.HasPrefix(line, "%") {
+			flush()
+			name := getSectionName(line)
+			if checkedSections[name] {
+				section = &scriptSection{name: name, line: lineNum}
+				if split := strings.SplitN(line, " ", 2); len(split) == 2 {
+					section.args = strings.TrimSpace(split[1])
+				}
+			}
+			continue
+		}
+		if section != nil {
+			section.lines = append(section.lines, s.Text())
+		}
+	}
+	flush()
+	if err := s.Err(); err != nil {
+		l.errorf(0, "while reading definition file: %s", err)
+	}
+
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		return l.diagnostics[i].Line < l.diagnostics[j].Line
+	})
+
+	return l.diagnostics
+}
+
+// checkScript reports the issues of a section, lines of the script
+// are shifted by the line of the section header.
+func (l *linter) checkScript(s *scriptSection, opts CheckOptions) {
+	f, err := syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(strings.Join(s.lines, "\n")), "")
+	if err != nil {
+		if perr, ok := err.(syntax.ParseError); ok {
+			l.errorf(s.line+int(perr.Pos.Line()), "%%%s: %s", s.name, perr.Text)
+		} else {
+			l.errorf(s.line, "%%%s: %s", s.name, err)
+		}
+		return
+	}
+
+	errexit := false
+	unquoted := make(map[checkKey]bool)
+	hostPaths := make(map[checkKey]bool)
+
+	syntax.Walk(f, func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.CallExpr:
+			if len(n.Args) > 0 && n.Args[0].Lit() == "set" {
+				errexit = errexit || setsErrexit(n.Args[1:])
+			}
+			for _, arg := range n.Args {
+				for _, part := range arg.Parts {
+					pe, ok := part.(*syntax.ParamExp)
+					if !ok || pe.Length || specialParams[pe.Param.Value] {
+						continue
+					}
+					line := s.line + int(pe.Pos().Line())
+					key := checkKey{line, pe.Param.Value}
+					if !unquoted[key] {
+						unquoted[key] = true
+						l.warningf(line, "%%%s: variable $%s is not quoted, it's subject to word splitting and globbing", s.name, pe.Param.Value)
+					}
+				}
+			}
+		case *syntax.Word:
+			path := literalPrefix(n)
+			if !filepath.IsAbs(path) {
+				return true
+			}
+			for _, dir := range opts.HostPaths {
+				if !hasPathPrefix(path, dir) || bound(path, opts.Binds) {
+					continue
+				}
+				line := s.line + int(n.Pos().Line())
+				key := checkKey{line, dir}
+				if !hostPaths[key] {
+					hostPaths[key] = true
+					l.warningf(line, "%%%s: host path %s is not available in the container, copy it with %%files or bind it with --bind", s.name, path)
+				}
+				break
+			}
+		}
+		return true
+	})
+
+	if s.name == "post" && !errexit && usesShellCommand(s.args) {
+		l.warningf(s.line, "%%post: the script runs with %q without set -e, failing commands don't stop the build", s.args)
+	}
+}
+
+// setsErrexit returns true if the arguments of the set command
+// enable the errexit option.
+func setsErrexit(args []*syntax.Word) bool {
+	for i, arg := range args {
+		lit := arg.Lit()
+		if lit == "-o" && i+1 < len(args) && args[i+1].Lit() == "errexit" {
+			return true
+		}
+		if strings.HasPrefix(lit, "-") && !strings.HasPrefix(lit, "--") && strings.Contains(lit, "e") {
+			return true
+		}
+	}
+	return false
+}
+
+// usesShellCommand returns true if the section arguments run the script
+// with another shell, the -e option of the default shell doesn't apply.
+func usesShellCommand(args string) bool {
+	for _, arg := range strings.Fields(strings.Split(args, "#")[0]) {
+		if arg == "-c" {
+			return true
+		}
+	}
+	return false
+}
+
+// literalPrefix returns the literal part of the word before the
+// first expansion.
+func literalPrefix(w *syntax.Word) string {
+	var b strings.Builder
+	for _, part := range w.Parts {
+		switch p := part.(type) {
+		case *syntax.Lit:
+			b.WriteString(p.Value)
+		case *syntax.SglQuoted:
+			b.WriteString(p.Value)
+		case *syntax.DblQuoted:
+			for _, dp := range p.Parts {
+				lit, ok := dp.(*syntax.Lit)
+				if !ok {
+					return b.String()
+				}
+				b.WriteString(lit.Value)
+			}
+		default:
+			return b.String()
+		}
+	}
+	return b.String()
+}
+
+// hasPathPrefix returns true if path is dir or below dir.
+func hasPathPrefix(path, dir string) bool {
+	dir = filepath.Clean(dir)
+	if dir == "/" || dir == "." {
+		return false
+	}
+	path = filepath.Clean(path)
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// bound returns true if path is below one of the bind destinations.
+func bound(path string, binds []string) bool {
+	for _, b := range binds {
+		if hasPathPrefix(path, b) {
+			return true
+		}
+	}
+	return false
+}
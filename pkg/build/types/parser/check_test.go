@@ -0,0 +1,69 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckScripts(t *testing.T) {
+	opts := CheckOptions{
+		HostPaths: []string{"/home/user", "/"},
+		Binds:     []string{"/home/user/data"},
+	}
+
+	tests := []struct {
+		name     string
+		def      string
+		expected []Diagnostic
+	}{
+		{
+			name: "valid",
+			def:  "Bootstrap: scratch\n%post\n    echo \"$HOME\" ${#ARGS} $?\n    cp /home/user/data/file /opt\n%runscript\n    exec /opt/app \"$@\"\n",
+		},
+		{
+			name: "unquoted",
+			def:  "Bootstrap: scratch\n%post\n    DIR=$HOME\n    rm -rf $DIR/tmp $DIR\n%apprun foo\n    exec foo $@\n",
+			expected: []Diagnostic{
+				{SeverityWarning, 4, "%post: variable $DIR is not quoted, it's subject to word splitting and globbing"},
+				{SeverityWarning, 6, "%apprun: variable $@ is not quoted, it's subject to word splitting and globbing"},
+			},
+		},
+		{
+			name: "host paths",
+			def:  "Bootstrap: scratch\n%setup\n    cp /home/user/file $SINGULARITY_ROOTFS\n%post\n    tar xf '/home/user/src.tar' -C /opt\n%runscript\n    \"/home/user/bin/$APP\"\n",
+			expected: []Diagnostic{
+				{SeverityWarning, 5, "%post: host path /home/user/src.tar is not available in the container, copy it with %files or bind it with --bind"},
+				{SeverityWarning, 7, "%runscript: host path /home/user/bin/ is not available in the container, copy it with %files or bind it with --bind"},
+			},
+		},
+		{
+			name: "errexit",
+			def:  "Bootstrap: scratch\n%post -c /bin/bash\n    false\nBootstrap: scratch\n%post -c /bin/bash\n    set -euo pipefail\n    false\n",
+			expected: []Diagnostic{
+				{SeverityWarning, 2, `%post: the script runs with "-c /bin/bash" without set -e, failing commands don't stop the build`},
+			},
+		},
+		{
+			name: "syntax error",
+			def:  "Bootstrap: scratch\n%post\n    echo ok\n    if true; then\n%runscript\n    echo \"unterminated\n",
+			expected: []Diagnostic{
+				{SeverityError, 4, `%post: "then" must be followed by a statement list`},
+				{SeverityError, 6, `%runscript: reached EOF without closing quote "`},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics := CheckScripts([]byte(tt.def), opts)
+			if !reflect.DeepEqual(diagnostics, tt.expected) {
+				t.Errorf("unexpected diagnostics %v instead of %v", diagnostics, tt.expected)
+			}
+		})
+	}
+}
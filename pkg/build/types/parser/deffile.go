@@ -14,8 +14,10 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/sylabs/singularity/pkg/build/types"
@@ -133,6 +135,65 @@ func getSectionName(line string) string {
 }
 
 // parseTokenSection into appropriate components to be placed into a types.Script struct
+// parseFileTransport parses a %files line made of a source, an optional
+// destination and the --exclude, --chown and --chmod options.
+func parseFileTransport(line string) (types.FileTransport, error) {
+	var ft types.FileTransport
+
+	if !strings.Contains(line, " --") {
+		lineSubs := strings.SplitN(line, " ", 2)
+		ft.Src = strings.TrimSpace(lineSubs[0])
+		if len(lineSubs) == 2 {
+			ft.Dst = strings.TrimSpace(lineSubs[1])
+		}
+		return ft, nil
+	}
+
+	var paths []string
+	fields := strings.Fields(line)
+	for i := 0; i < len(fields); i++ {
+		if !strings.HasPrefix(fields[i], "--") {
+			paths = append(paths, fields[i])
+			continue
+		}
+		name, value := strings.TrimPrefix(fields[i], "--"), ""
+		if kv := strings.SplitN(name, "=", 2); len(kv) == 2 {
+			name, value = kv[0], kv[1]
+		} else if i+1 < len(fields) {
+			i++
+			value = fields[i]
+		}
+		if value == "" {
+			return ft, fmt.Errorf("option --%s requires a value", name)
+		}
+
+		switch name {
+		case "exclude":
+			if _, err := filepath.Match(value, ""); err != nil {
+				return ft, fmt.Errorf("invalid exclude pattern %s: %v", value, err)
+			}
+			ft.Exclude = append(ft.Exclude, value)
+		case "chown":
+			ft.Owner = value
+		case "chmod":
+			if mode, err := strconv.ParseUint(value, 8, 32); err != nil || mode > 07777 {
+				return ft, fmt.Errorf("invalid mode %s", value)
+			}
+			ft.Mode = value
+		default:
+			return ft, fmt.Errorf("unknown option --%s", name)
+		}
+	}
+	if len(paths) == 0 || len(paths) > 2 {
+		return ft, fmt.Errorf("a source and an optional destination are required")
+	}
+	ft.Src = paths[0]
+	if len(paths) == 2 {
+		ft.Dst = paths[1]
+	}
+	return ft, nil
+}
+
 func parseTokenSection(tok string, sections map[string]*types.Script, files *[]types.Files, appOrder *[]string) error {
 	split := strings.SplitN(tok, "\n", 2)
 	if len(split) != 2 {
@@ -156,16 +217,11 @@ func parseTokenSection(tok string, sections map[string]*types.Script, files *[]t
 			if line = strings.TrimSpace(line); line == "" || strings.Index(line, "#") == 0 {
 				continue
 			}
-			var src, dst string
-			lineSubs := strings.SplitN(line, " ", 2)
-			if len(lineSubs) < 2 {
-				src = strings.TrimSpace(lineSubs[0])
-				dst = ""
-			} else {
-				src = strings.TrimSpace(lineSubs[0])
-				dst = strings.TrimSpace(lineSubs[1])
+			ft, err := parseFileTransport(line)
+			if err != nil {
+				return fmt.Errorf("section %v: line %q: %v", split[0], line, err)
 			}
-			f.Files = append(f.Files, types.FileTransport{Src: src, Dst: dst})
+			f.Files = append(f.Files, ft)
 		}
 
 		// look through existing files and append to them if they already exist
@@ -193,6 +249,9 @@ func parseTokenSection(tok string, sections map[string]*types.Script, files *[]t
 		if _, ok := sections[key]; !ok {
 			sections[key] = &types.Script{}
 		}
+		if len(sectionSplit) == 3 {
+			sections[key].Args = sectionSplit[2]
+		}
 		// Record the order in which we came across each app... since we have
 		// to process their appinstall sections in that order.
 		appName := sectionSplit[1]
@@ -324,6 +383,12 @@ func populateDefinition(sections map[string]*types.Script, files *[]types.Files,
 		d.CustomData = make(map[string]string)
 		for k := range sections {
 			d.CustomData[k] = sections[k].Script
+			if sections[k].Args != "" {
+				if d.CustomArgs == nil {
+					d.CustomArgs = make(map[string]string)
+				}
+				d.CustomArgs[k] = sections[k].Args
+			}
 		}
 		var keys []string
 		for k := range sections {
@@ -507,7 +572,19 @@ func IsValidDefinition(source string) (valid bool, err error) {
 		return false, nil
 	}
 
-	_, err = ParseDefinitionFile(defFile)
+	var r io.Reader = defFile
+	if format := StructuredFormat(source); format != "" {
+		raw, err := ioutil.ReadAll(defFile)
+		if err != nil {
+			return false, err
+		}
+		if raw, err = ClassicDefinition(raw, format); err != nil {
+			return false, err
+		}
+		r = bytes.NewReader(raw)
+	}
+
+	_, err = ParseDefinitionFile(r)
 	if err != nil {
 		return false, err
 	}
@@ -544,6 +621,8 @@ var validSections = map[string]bool{
 	"runscript":   true,
 	"test":        true,
 	"startscript": true,
+	"arguments":   true,
+	"include":     true,
 }
 
 var appSections = map[string]bool{
@@ -554,6 +633,7 @@ var appSections = map[string]bool{
 	"apptest":    true,
 	"apphelp":    true,
 	"apprun":     true,
+	"appdepends": true,
 }
 
 // validHeaders just contains a list of all the valid headers a definition file
@@ -577,4 +657,31 @@ var validHeaders = map[string]bool{
 	"registerurl": true,
 	"modules":     true,
 	"otherurl&n":  true,
+	"nixfile":     true,
+	"nixattr":     true,
+	"condafile":   true,
+	"channels":    true,
+	"spec":        true,
+	"spackfile":   true,
+	"mirror":      true,
+	// debootstrap agent
+	"keyring":      true,
+	"variant":      true,
+	"components":   true,
+	"architecture": true,
+	// yum agent
+	"gpgkey":         true,
+	"modulestreams":  true,
+	"bootstrapimage": true,
+	// zypper agent
+	"repo&n":    true,
+	"service&n": true,
+	"patterns":  true,
+	// arch agent
+	"pacmanconf": true,
+	"aurhelper":  true,
+	"aur":        true,
+	// squashfs compression of SIF images
+	"compression":      true,
+	"compressionlevel": true,
 }
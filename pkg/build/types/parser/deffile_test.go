@@ -122,6 +122,77 @@ func TestParseTokenSection(t *testing.T) {
 	if testMap["appenv apptest"].Script != "test" {
 		t.Fatal("returned map is invalid", testMap["appenv"].Script)
 	}
+	if testMap["appenv apptest"].Args != "apptest2" {
+		t.Fatal("unexpected app section arguments", testMap["appenv apptest"].Args)
+	}
+}
+
+func TestParseFileTransport(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected types.FileTransport
+		wantErr  bool
+	}{
+		{
+			name:     "source only",
+			line:     "/opt/app",
+			expected: types.FileTransport{Src: "/opt/app"},
+		},
+		{
+			name:     "destination",
+			line:     "/opt/app /usr/local/app",
+			expected: types.FileTransport{Src: "/opt/app", Dst: "/usr/local/app"},
+		},
+		{
+			name: "options",
+			line: "/opt/app/* /usr/local/app/ --exclude *.o --exclude=build/ --chown app:app --chmod=0755",
+			expected: types.FileTransport{
+				Src:     "/opt/app/*",
+				Dst:     "/usr/local/app/",
+				Exclude: []string{"*.o", "build/"},
+				Owner:   "app:app",
+				Mode:    "0755",
+			},
+		},
+		{
+			name:     "options without destination",
+			line:     "/opt/app --chown 1000",
+			expected: types.FileTransport{Src: "/opt/app", Owner: "1000"},
+		},
+		{
+			name:    "invalid mode",
+			line:    "/opt/app /opt/app --chmod 0999",
+			wantErr: true,
+		},
+		{
+			name:    "missing value",
+			line:    "/opt/app /opt/app --chown",
+			wantErr: true,
+		},
+		{
+			name:    "unknown option",
+			line:    "/opt/app /opt/app --owner root",
+			wantErr: true,
+		},
+		{
+			name:    "extra path",
+			line:    "/opt/app /opt/app /opt --chmod 0755",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ft, err := parseFileTransport(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(ft, tt.expected) {
+				t.Errorf("unexpected transport %+v instead of %+v", ft, tt.expected)
+			}
+		})
+	}
 }
 
 // Specific tests to cover some corner cases of doSections()
@@ -0,0 +1,561 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/sylabs/singularity/internal/pkg/util/shell"
+	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// IsDockerfile returns true if the file at path is named like a
+// Dockerfile (Dockerfile, Dockerfile.<name> or <name>.Dockerfile).
+func IsDockerfile(path string) bool {
+	base := strings.ToLower(filepath.Base(path))
+	if base != "dockerfile" && !strings.HasPrefix(base, "dockerfile.") && !strings.HasSuffix(base, ".dockerfile") {
+		return false
+	}
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// dockerInstruction is a Dockerfile instruction once
+// continuation lines are joined.
+type dockerInstruction struct {
+	line    int
+	command string
+	flags   map[string]string
+	args    string
+}
+
+// dockerStage holds the state of the Dockerfile stage being translated.
+type dockerStage struct {
+	def        types.Definition
+	name       string
+	vars       map[string]string
+	workdir    string
+	entrypoint string
+	cmd        string
+	hasRun     bool
+	post       strings.Builder
+	env        strings.Builder
+}
+
+// dockerfileTranslator translates a Dockerfile into definitions.
+type dockerfileTranslator struct {
+	context    string
+	args       map[string]string
+	globalArgs map[string]string
+	stages     []*dockerStage
+}
+
+// ParseDockerfile translates the Dockerfile read from r into one definition
+// per stage, sources of COPY and ADD instructions are relative to the
+// context directory. FROM, RUN, COPY, ADD, ENV, ARG, WORKDIR, LABEL,
+// ENTRYPOINT and CMD are translated to their definition file equivalent,
+// ARG values are looked up like ApplyBuildArgs does. Instructions without
+// equivalent are ignored with a warning.
+func ParseDockerfile(r io.Reader, context string, args map[string]string) ([]types.Definition, error) {
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("while attempting to read in Dockerfile: %v", err)
+	}
+
+	instructions, err := scanDockerfile(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &dockerfileTranslator{
+		context:    context,
+		args:       args,
+		globalArgs: make(map[string]string),
+	}
+
+	for _, inst := range instructions {
+		if err := t.translate(inst); err != nil {
+			return nil, fmt.Errorf("Dockerfile line %d: %s: %v", inst.line, inst.command, err)
+		}
+	}
+
+	if len(t.stages) == 0 {
+		return nil, fmt.Errorf("no FROM instruction found in Dockerfile")
+	}
+
+	defs := make([]types.Definition, 0, len(t.stages))
+	for _, st := range t.stages {
+		defs = append(defs, st.definition())
+	}
+	// set raw of last stage to be entire Dockerfile
+	defs[len(defs)-1].Raw = raw
+
+	return defs, nil
+}
+
+// scanDockerfile splits the Dockerfile into instructions, comments
+// are discarded and continuation lines are joined.
+func scanDockerfile(raw []byte) ([]dockerInstruction, error) {
+	var instructions []dockerInstruction
+	var current strings.Builder
+
+	start := 0
+	lineNum := 0
+
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	for s.Scan() {
+		lineNum++
+		line := strings.TrimSpace(s.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if current.Len() == 0 {
+			if line == "" {
+				continue
+			}
+			start = lineNum
+		}
+		if strings.HasSuffix(line, "\\") {
+			current.WriteString(strings.TrimSuffix(line, "\\") + " ")
+			continue
+		}
+		current.WriteString(line)
+
+		inst, err := parseDockerInstruction(start, current.String())
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, inst)
+		current.Reset()
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("while reading Dockerfile: %v", err)
+	}
+	if current.Len() > 0 {
+		inst, err := parseDockerInstruction(start, current.String())
+		if err != nil {
+			return nil, err
+		}
+		instructions = append(instructions, inst)
+	}
+
+	return instructions, nil
+}
+
+// parseDockerInstruction splits an instruction line into its command,
+// its --flag=value options and its arguments.
+func parseDockerInstruction(lineNum int, line string) (dockerInstruction, error) {
+	inst := dockerInstruction{
+		line:  lineNum,
+		flags: make(map[string]string),
+	}
+
+	split := strings.SplitN(line, " ", 2)
+	inst.command = strings.ToUpper(split[0])
+	if len(split) == 1 {
+		return inst, fmt.Errorf("Dockerfile line %d: %s requires arguments", lineNum, inst.command)
+	}
+	inst.args = strings.TrimSpace(split[1])
+
+	for strings.HasPrefix(inst.args, "--") {
+		split = strings.SplitN(inst.args, " ", 2)
+		kv := strings.SplitN(strings.TrimPrefix(split[0], "--"), "=", 2)
+		if len(kv) == 2 {
+			inst.flags[kv[0]] = kv[1]
+		} else {
+			inst.flags[kv[0]] = ""
+		}
+		inst.args = ""
+		if len(split) == 2 {
+			inst.args = strings.TrimSpace(split[1])
+		}
+	}
+
+	return inst, nil
+}
+
+// translate applies the instruction to the current stage.
+func (t *dockerfileTranslator) translate(inst dockerInstruction) error {
+	if inst.command == "FROM" {
+		return t.from(inst)
+	}
+	if len(t.stages) == 0 {
+		if inst.command != "ARG" {
+			return fmt.Errorf("instruction found before the first FROM")
+		}
+		return t.arg(inst, t.globalArgs)
+	}
+
+	st := t.stages[len(t.stages)-1]
+
+	switch inst.command {
+	case "RUN":
+		for f := range inst.flags {
+			sylog.Warningf("Dockerfile line %d: ignoring RUN --%s", inst.line, f)
+		}
+		st.hasRun = true
+		if cmd, ok := execForm(inst.args); ok {
+			fmt.Fprintf(&st.post, "%s\n", shell.ArgsQuoted(cmd))
+		} else {
+			fmt.Fprintf(&st.post, "%s\n", inst.args)
+		}
+	case "COPY", "ADD":
+		return t.copy(st, inst)
+	case "ENV":
+		pairs, err := keyValues(inst.args)
+		if err != nil {
+			return err
+		}
+		for _, kv := range pairs {
+			st.vars[kv[0]] = os.Expand(kv[1], st.lookup)
+			fmt.Fprintf(&st.env, "export %s=%s\n", kv[0], envQuote(kv[1]))
+			fmt.Fprintf(&st.post, "export %s=%s\n", kv[0], envQuote(kv[1]))
+		}
+	case "ARG":
+		return t.arg(inst, st.vars)
+	case "WORKDIR":
+		dir := os.Expand(inst.args, st.lookup)
+		if !path.IsAbs(dir) {
+			dir = path.Join("/", st.workdir, dir)
+		}
+		st.workdir = dir
+		fmt.Fprintf(&st.post, "mkdir -p %s && cd %s\n", envQuote(dir), envQuote(dir))
+	case "LABEL":
+		pairs, err := keyValues(inst.args)
+		if err != nil {
+			return err
+		}
+		for _, kv := range pairs {
+			st.def.Labels[kv[0]] = kv[1]
+		}
+	case "MAINTAINER":
+		st.def.Labels["maintainer"] = inst.args
+	case "ENTRYPOINT":
+		st.entrypoint = dockerCommand(inst.args)
+		// ENTRYPOINT resets the CMD of previous instructions
+		st.cmd = ""
+	case "CMD":
+		st.cmd = dockerCommand(inst.args)
+	case "USER", "VOLUME", "SHELL":
+		sylog.Warningf("Dockerfile line %d: %s instruction is not supported and is ignored", inst.line, inst.command)
+	case "EXPOSE", "STOPSIGNAL", "HEALTHCHECK", "ONBUILD":
+		sylog.Debugf("Dockerfile line %d: ignoring %s instruction", inst.line, inst.command)
+	default:
+		return fmt.Errorf("unknown instruction")
+	}
+
+	return nil
+}
+
+// from starts a new stage.
+func (t *dockerfileTranslator) from(inst dockerInstruction) error {
+	if p, ok := inst.flags["platform"]; ok {
+		sylog.Warningf("Dockerfile line %d: ignoring FROM --platform=%s", inst.line, p)
+	}
+
+	fields := strings.Fields(inst.args)
+	name := strconv.Itoa(len(t.stages))
+	if len(fields) == 3 && strings.ToLower(fields[1]) == "as" {
+		name = fields[2]
+	} else if len(fields) != 1 {
+		return fmt.Errorf("expected 'FROM <image> [AS <name>]'")
+	}
+
+	image := os.Expand(fields[0], func(k string) string {
+		return t.globalArgs[k]
+	})
+	if t.stage(image) != nil {
+		return fmt.Errorf("building from the previous stage %s is not supported", image)
+	}
+
+	st := &dockerStage{
+		name: name,
+		vars: make(map[string]string),
+	}
+	st.def.Header = map[string]string{
+		"bootstrap": "docker",
+		"from":      image,
+		"stage":     name,
+	}
+	if image == "scratch" {
+		st.def.Header["bootstrap"] = "scratch"
+		delete(st.def.Header, "from")
+	}
+	st.def.Labels = make(map[string]string)
+
+	t.stages = append(t.stages, st)
+	return nil
+}
+
+// arg declares a build argument in vars.
+func (t *dockerfileTranslator) arg(inst dockerInstruction, vars map[string]string) error {
+	kv := strings.SplitN(inst.args, "=", 2)
+	name := strings.TrimSpace(kv[0])
+	if !buildArgNameRegexp.MatchString(name) {
+		return fmt.Errorf("invalid argument name %q", name)
+	}
+
+	value, ok := t.args[name]
+	if !ok {
+		value, ok = os.LookupEnv(BuildArgEnvPrefix + name)
+	}
+	if !ok && len(kv) == 2 {
+		value, ok = strings.Trim(strings.TrimSpace(kv[1]), `"'`), true
+	}
+	if !ok {
+		// a stage argument without default inherits the global value
+		value = t.globalArgs[name]
+	}
+	vars[name] = value
+
+	if len(t.stages) > 0 {
+		st := t.stages[len(t.stages)-1]
+		fmt.Fprintf(&st.post, "export %s=%s\n", name, envQuote(value))
+	}
+	return nil
+}
+
+// copy translates COPY and ADD instructions into %files entries.
+func (t *dockerfileTranslator) copy(st *dockerStage, inst dockerInstruction) error {
+	var paths []string
+	if cmd, ok := execForm(inst.args); ok {
+		paths = cmd
+	} else {
+		paths = strings.Fields(inst.args)
+	}
+	if len(paths) < 2 {
+		return fmt.Errorf("requires at least a source and a destination")
+	}
+	if mode, ok := inst.flags["chmod"]; ok {
+		if _, err := strconv.ParseUint(mode, 8, 32); err != nil {
+			return fmt.Errorf("invalid --chmod mode %s", mode)
+		}
+	}
+	if st.hasRun {
+		sylog.Warningf("Dockerfile line %d: %s is applied before the RUN instructions of the stage", inst.line, inst.command)
+	}
+
+	files := types.Files{}
+
+	from, fromStage := inst.flags["from"]
+	if fromStage {
+		src := t.stage(from)
+		if src == nil {
+			return fmt.Errorf("copying from the image %s is not supported, only from previous stages", from)
+		}
+		files.Args = "from " + src.name
+	}
+
+	dst := os.Expand(paths[len(paths)-1], st.lookup)
+	if !path.IsAbs(dst) {
+		dst = path.Join("/", st.workdir, dst)
+		if strings.HasSuffix(paths[len(paths)-1], "/") {
+			dst += "/"
+		}
+	}
+
+	for _, src := range paths[:len(paths)-1] {
+		src = os.Expand(src, st.lookup)
+		if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+			// URL sources are downloaded by the build
+			if inst.command != "ADD" || fromStage {
+				return fmt.Errorf("remote sources are only supported by ADD")
+			}
+		} else if !fromStage {
+			src = filepath.Join(t.context, src)
+			if fi, err := os.Stat(src); err == nil && fi.IsDir() {
+				// copy the directory content like docker does
+				src += "/."
+			} else if inst.command == "ADD" && isArchive(src) {
+				sylog.Warningf("Dockerfile line %d: archive %s is copied without being extracted", inst.line, src)
+			}
+		}
+		files.Files = append(files.Files, types.FileTransport{
+			Src:   src,
+			Dst:   dst,
+			Owner: inst.flags["chown"],
+			Mode:  inst.flags["chmod"],
+		})
+	}
+
+	// look through existing files and append to them if they already exist
+	for i, ef := range st.def.BuildData.Files {
+		if ef.Args == files.Args {
+			st.def.BuildData.Files[i].Files = append(ef.Files, files.Files...)
+			return nil
+		}
+	}
+	st.def.BuildData.Files = append(st.def.BuildData.Files, files)
+	return nil
+}
+
+// stage returns the previous stage named or indexed by name.
+func (t *dockerfileTranslator) stage(name string) *dockerStage {
+	for _, st := range t.stages {
+		if strings.EqualFold(st.name, name) {
+			return st
+		}
+	}
+	return nil
+}
+
+// lookup returns the value of the ENV or ARG variable of the stage.
+func (st *dockerStage) lookup(name string) string {
+	return st.vars[name]
+}
+
+// definition returns the definition of the stage.
+func (st *dockerStage) definition() types.Definition {
+	d := st.def
+	d.BuildData.Post.Script = st.post.String()
+	d.ImageData.Environment.Script = st.env.String()
+
+	if st.entrypoint == "" && st.cmd == "" {
+		return d
+	}
+
+	var rs strings.Builder
+	if st.workdir != "" {
+		fmt.Fprintf(&rs, "cd %s\n", envQuote(st.workdir))
+	}
+	switch {
+	case st.cmd == "":
+		fmt.Fprintf(&rs, "exec %s \"$@\"\n", st.entrypoint)
+	case st.entrypoint == "":
+		fmt.Fprintf(&rs, "if [ $# -gt 0 ]; then\n    exec \"$@\"\nfi\nexec %s\n", st.cmd)
+	default:
+		fmt.Fprintf(&rs, "if [ $# -gt 0 ]; then\n    exec %s \"$@\"\nfi\nexec %s %s\n", st.entrypoint, st.entrypoint, st.cmd)
+	}
+	d.ImageData.Runscript.Script = rs.String()
+
+	return d
+}
+
+// execForm parses the JSON array form of an instruction.
+func execForm(args string) ([]string, bool) {
+	if !strings.HasPrefix(args, "[") {
+		return nil, false
+	}
+	var cmd []string
+	if err := json.Unmarshal([]byte(args), &cmd); err != nil {
+		return nil, false
+	}
+	return cmd, true
+}
+
+// dockerCommand returns the quoted command of ENTRYPOINT and CMD
+// instructions, the shell form is run with /bin/sh -c.
+func dockerCommand(args string) string {
+	if cmd, ok := execForm(args); ok {
+		return shell.ArgsQuoted(cmd)
+	}
+	return shell.ArgsQuoted([]string{"/bin/sh", "-c", args})
+}
+
+// keyValues parses the key=value pairs of ENV and LABEL instructions,
+// the legacy 'ENV key value' form is also accepted.
+func keyValues(args string) ([][2]string, error) {
+	tokens, err := splitQuoted(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("requires at least one key=value pair")
+	}
+
+	if !strings.Contains(tokens[0], "=") {
+		split := strings.SplitN(args, " ", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("%s has no value", split[0])
+		}
+		return [][2]string{{split[0], strings.TrimSpace(split[1])}}, nil
+	}
+
+	pairs := make([][2]string, 0, len(tokens))
+	for _, tok := range tokens {
+		kv := strings.SplitN(tok, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("%s is not in the key=value form", tok)
+		}
+		pairs = append(pairs, [2]string{kv[0], kv[1]})
+	}
+	return pairs, nil
+}
+
+// splitQuoted splits s on spaces not enclosed in quotes,
+// quotes are removed.
+func splitQuoted(s string) ([]string, error) {
+	var tokens []string
+	var tok strings.Builder
+	var quote rune
+
+	inToken := false
+	escaped := false
+
+	for _, c := range s {
+		switch {
+		case escaped:
+			tok.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				tok.WriteRune(c)
+			}
+		case c == '"' || c == '\'':
+			quote = c
+			inToken = true
+		case c == ' ' || c == '\t':
+			if inToken {
+				tokens = append(tokens, tok.String())
+				tok.Reset()
+				inToken = false
+			}
+		default:
+			tok.WriteRune(c)
+			inToken = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if inToken {
+		tokens = append(tokens, tok.String())
+	}
+	return tokens, nil
+}
+
+// envQuote double quotes v for the shell, variables are still expanded.
+func envQuote(v string) string {
+	v = strings.Replace(v, `"`, `\"`, -1)
+	v = strings.Replace(v, "`", "\\`", -1)
+	return `"` + v + `"`
+}
+
+// isArchive returns true if path looks like an archive
+// extracted by ADD.
+func isArchive(path string) bool {
+	for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.xz", ".txz"} {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
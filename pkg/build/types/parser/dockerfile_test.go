@@ -0,0 +1,149 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+func TestIsDockerfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dockerfile-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name     string
+		expected bool
+	}{
+		{"Dockerfile", true},
+		{"Dockerfile.gpu", true},
+		{"app.dockerfile", true},
+		{"app.def", false},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name)
+		if err := ioutil.WriteFile(path, []byte("FROM alpine\n"), 0644); err != nil {
+			t.Fatalf("could not write %s: %s", path, err)
+		}
+		if IsDockerfile(path) != tt.expected {
+			t.Errorf("unexpected result for %s", tt.name)
+		}
+	}
+	if IsDockerfile(filepath.Join(dir, "missing", "Dockerfile")) {
+		t.Errorf("unexpected result for missing Dockerfile")
+	}
+}
+
+func TestParseDockerfile(t *testing.T) {
+	context, err := ioutil.TempDir("", "dockerfile-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(context)
+
+	if err := os.Mkdir(filepath.Join(context, "src"), 0755); err != nil {
+		t.Fatalf("could not create directory: %s", err)
+	}
+
+	const dockerfile = `# syntax comment
+ARG VERSION=18.04
+FROM ubuntu:${VERSION} AS build
+ARG PKG
+ENV PREFIX=/opt/app \
+    PATH="/opt/app/bin:$PATH"
+WORKDIR /src
+COPY src .
+RUN apt-get update && \
+    apt-get install -y $PKG
+
+FROM alpine
+LABEL maintainer="Jane Doe" version=1.0
+COPY --from=build --chown=app:app --chmod=0755 /opt/app /opt/app
+ADD https://example.com/app.conf /etc/
+ENV PREFIX /usr/local
+ENTRYPOINT ["/opt/app/bin/app"]
+CMD ["--help"]
+`
+
+	defs, err := ParseDockerfile(strings.NewReader(dockerfile), context, map[string]string{"VERSION": "20.04", "PKG": "make"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(defs) != 2 {
+		t.Fatalf("unexpected number of stages %d", len(defs))
+	}
+
+	build := defs[0]
+	if !reflect.DeepEqual(build.Header, map[string]string{"bootstrap": "docker", "from": "ubuntu:20.04", "stage": "build"}) {
+		t.Errorf("unexpected header %v", build.Header)
+	}
+	expectedFiles := []types.Files{
+		{Files: []types.FileTransport{{Src: filepath.Join(context, "src") + "/.", Dst: "/src"}}},
+	}
+	if !reflect.DeepEqual(build.BuildData.Files, expectedFiles) {
+		t.Errorf("unexpected files %v", build.BuildData.Files)
+	}
+	expectedPost := `export PKG="make"
+export PREFIX="/opt/app"
+export PATH="/opt/app/bin:$PATH"
+mkdir -p "/src" && cd "/src"
+apt-get update &&  apt-get install -y $PKG
+`
+	if build.BuildData.Post.Script != expectedPost {
+		t.Errorf("unexpected post script:\n%s", build.BuildData.Post.Script)
+	}
+	if build.ImageData.Environment.Script != "export PREFIX=\"/opt/app\"\nexport PATH=\"/opt/app/bin:$PATH\"\n" {
+		t.Errorf("unexpected environment:\n%s", build.ImageData.Environment.Script)
+	}
+
+	final := defs[1]
+	if !reflect.DeepEqual(final.Header, map[string]string{"bootstrap": "docker", "from": "alpine", "stage": "1"}) {
+		t.Errorf("unexpected header %v", final.Header)
+	}
+	if !reflect.DeepEqual(final.Labels, map[string]string{"maintainer": "Jane Doe", "version": "1.0"}) {
+		t.Errorf("unexpected labels %v", final.Labels)
+	}
+	expectedFiles = []types.Files{
+		{Args: "from build", Files: []types.FileTransport{{Src: "/opt/app", Dst: "/opt/app", Owner: "app:app", Mode: "0755"}}},
+		{Files: []types.FileTransport{{Src: "https://example.com/app.conf", Dst: "/etc/"}}},
+	}
+	if !reflect.DeepEqual(final.BuildData.Files, expectedFiles) {
+		t.Errorf("unexpected files %v", final.BuildData.Files)
+	}
+	expectedRunscript := `if [ $# -gt 0 ]; then
+    exec "/opt/app/bin/app" "$@"
+fi
+exec "/opt/app/bin/app" "--help"
+`
+	if final.ImageData.Runscript.Script != expectedRunscript {
+		t.Errorf("unexpected runscript:\n%s", final.ImageData.Runscript.Script)
+	}
+	if string(final.Raw) != dockerfile {
+		t.Errorf("unexpected raw content")
+	}
+
+	for _, bad := range []string{
+		"RUN echo\n",
+		"FROM alpine AS a\nFROM a\n",
+		"FROM alpine\nCOPY --from=busybox /bin/sh /bin/sh\n",
+		"FROM alpine\nCOPY https://example.com/file /file\n",
+		"FROM alpine\nBOGUS value\n",
+	} {
+		if _, err := ParseDockerfile(strings.NewReader(bad), context, nil); err == nil {
+			t.Errorf("unexpected success for %q", bad)
+		}
+	}
+}
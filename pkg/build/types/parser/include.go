@@ -0,0 +1,179 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
+)
+
+// includeTimeout is the timeout in seconds to fetch a definition
+// file fragment from a URL.
+const includeTimeout = 30
+
+// includeResolver keeps track of the fragments being included
+// to detect include cycles.
+type includeResolver struct {
+	stack    []string
+	includes []types.Include
+}
+
+// ResolveIncludes replaces the %include directives of the raw definition
+// file read from source by the content of the referenced fragments. A
+// fragment is either a local path, relative to the directory of the file
+// including it, or an http(s) URL and may itself contain %include
+// directives. It returns the resulting definition along with the list of
+// included fragments and their digests.
+func ResolveIncludes(raw []byte, source string) ([]byte, []types.Include, error) {
+	r := &includeResolver{}
+
+	if !isURL(source) {
+		abs, err := filepath.Abs(source)
+		if err != nil {
+			return nil, nil, fmt.Errorf("while getting absolute path of %s: %v", source, err)
+		}
+		source = abs
+	}
+
+	b, err := r.resolve(raw, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, r.includes, nil
+}
+
+// resolve replaces the %include directives of the fragment raw read
+// from source.
+func (r *includeResolver) resolve(raw []byte, source string) ([]byte, error) {
+	for _, s := range r.stack {
+		if s == source {
+			return nil, fmt.Errorf("include cycle detected: %s", strings.Join(append(r.stack, source), " -> "))
+		}
+	}
+	r.stack = append(r.stack, source)
+	defer func() {
+		r.stack = r.stack[:len(r.stack)-1]
+	}()
+
+	var buf bytes.Buffer
+
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if !strings.HasPrefix(line, "%") || getSectionName(line) != "include" {
+			buf.WriteString(s.Text() + "\n")
+			continue
+		}
+
+		ref := strings.TrimSpace(line[len("%include"):])
+		if ref == "" {
+			return nil, fmt.Errorf("%s: %%include directive requires a path or URL", source)
+		}
+		ref, err := includeSource(source, ref)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", source, err)
+		}
+
+		fragment, err := readInclude(ref)
+		if err != nil {
+			return nil, fmt.Errorf("%s: while including %s: %v", source, ref, err)
+		}
+		r.record(ref, fragment)
+
+		b, err := r.resolve(fragment, ref)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(b)
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("while reading %s: %v", source, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// record adds the fragment to the list of included fragments
+// if it isn't already part of it.
+func (r *includeResolver) record(source string, fragment []byte) {
+	for _, inc := range r.includes {
+		if inc.Source == source {
+			return
+		}
+	}
+	r.includes = append(r.includes, types.Include{
+		Source: source,
+		Digest: fmt.Sprintf("sha256:%x", sha256.Sum256(fragment)),
+	})
+}
+
+// isURL returns true if source is an http(s) URL.
+func isURL(source string) bool {
+	return strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://")
+}
+
+// includeSource returns the location of the fragment ref included
+// by source.
+func includeSource(source, ref string) (string, error) {
+	if isURL(ref) {
+		return ref, nil
+	}
+	if isURL(source) {
+		base, err := url.Parse(source)
+		if err != nil {
+			return "", fmt.Errorf("while parsing URL %s: %v", source, err)
+		}
+		u, err := url.Parse(ref)
+		if err != nil {
+			return "", fmt.Errorf("while parsing include reference %s: %v", ref, err)
+		}
+		return base.ResolveReference(u).String(), nil
+	}
+	if filepath.IsAbs(ref) {
+		return filepath.Clean(ref), nil
+	}
+	return filepath.Join(filepath.Dir(source), ref), nil
+}
+
+// readInclude returns the content of the fragment.
+func readInclude(source string) ([]byte, error) {
+	if !isURL(source) {
+		return ioutil.ReadFile(source)
+	}
+
+	client := &http.Client{
+		Timeout: includeTimeout * time.Second,
+	}
+
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", useragent.Value())
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response from server: %s", res.Status)
+	}
+
+	return ioutil.ReadAll(res.Body)
+}
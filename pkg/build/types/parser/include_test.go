@@ -0,0 +1,122 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	useragent "github.com/sylabs/singularity/pkg/util/user-agent"
+)
+
+func writeFragments(t *testing.T, dir string, fragments map[string]string) {
+	for name, content := range fragments {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("could not create directory: %s", err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("could not write %s: %s", path, err)
+		}
+	}
+}
+
+func TestResolveIncludes(t *testing.T) {
+	useragent.InitValue("singularity", "3.0.0")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/recipes/labels.def":
+			fmt.Fprintln(w, "%labels")
+			fmt.Fprintln(w, "%include maintainer.def")
+		case "/recipes/maintainer.def":
+			fmt.Fprintln(w, "    Maintainer site")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "include-")
+	if err != nil {
+		t.Fatalf("could not create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeFragments(t, dir, map[string]string{
+		"common/post.def": "%post\n%include apt.def\n",
+		"common/apt.def":  "    apt-get update\n",
+		"cycle/a.def":     "%include b.def\n",
+		"cycle/b.def":     "%include a.def\n",
+	})
+
+	tests := []struct {
+		name       string
+		def        string
+		expected   string
+		includes   []string
+		shouldFail bool
+	}{
+		{
+			name:     "local",
+			def:      "Bootstrap: docker\n%include common/post.def\n    echo done\n",
+			expected: "Bootstrap: docker\n%post\n    apt-get update\n    echo done\n",
+			includes: []string{"common/post.def", "common/apt.def"},
+		},
+		{
+			name:     "url",
+			def:      "Bootstrap: docker\n%include " + srv.URL + "/recipes/labels.def\n",
+			expected: "Bootstrap: docker\n%labels\n    Maintainer site\n",
+			includes: []string{srv.URL + "/recipes/labels.def", srv.URL + "/recipes/maintainer.def"},
+		},
+		{
+			name:       "cycle",
+			def:        "Bootstrap: docker\n%include cycle/a.def\n",
+			shouldFail: true,
+		},
+		{
+			name:       "missing",
+			def:        "Bootstrap: docker\n%include common/missing.def\n",
+			shouldFail: true,
+		},
+		{
+			name:       "no reference",
+			def:        "Bootstrap: docker\n%include\n",
+			shouldFail: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, includes, err := ResolveIncludes([]byte(tt.def), filepath.Join(dir, "test.def"))
+			if err != nil && !tt.shouldFail {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.shouldFail {
+				t.Fatalf("unexpected success")
+			}
+			if tt.shouldFail {
+				return
+			}
+			if string(b) != tt.expected {
+				t.Errorf("unexpected definition:\n%s", b)
+			}
+			if len(includes) != len(tt.includes) {
+				t.Fatalf("unexpected includes %v", includes)
+			}
+			for i, inc := range includes {
+				if !strings.HasSuffix(inc.Source, tt.includes[i]) || !strings.HasPrefix(inc.Digest, "sha256:") {
+					t.Errorf("unexpected include %v", inc)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,493 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+)
+
+// Diagnostic severities.
+const (
+	// SeverityError reports an issue which makes the build fail.
+	SeverityError = "error"
+	// SeverityWarning reports a suspicious but valid construct.
+	SeverityWarning = "warning"
+)
+
+// Diagnostic describes an issue found in a definition file, Line is
+// the line number in the definition file once %include directives are
+// resolved or 0 if the issue isn't related to a particular line.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	Line     int    `json:"line,omitempty"`
+	Message  string `json:"message"`
+}
+
+// bootstrapAgents maps the supported bootstrap agents to the headers
+// they require.
+var bootstrapAgents = map[string][]string{
+	"arch":               nil,
+	"busybox":            {"mirrorurl"},
+	"conda":              {"from", "condafile"},
+	"containers-storage": {"from"},
+	"debootstrap":        {"mirrorurl", "osversion"},
+	"docker":             {"from"},
+	"docker-archive":     {"from"},
+	"docker-daemon":      {"from"},
+	"library":            {"from"},
+	"localimage":         {"from"},
+	"nix":                nil,
+	"oci":                {"from"},
+	"oci-archive":        {"from"},
+	"oras":               {"from"},
+	"podman":             {"from"},
+	"scratch":            nil,
+	"shub":               {"from"},
+	"spack":              {"from"},
+	"yum":                {"mirrorurl"},
+	"zypper":             nil,
+}
+
+// agentHeaders maps the headers specific to some bootstrap agents
+// to these agents, they are ignored by the other ones.
+var agentHeaders = map[string][]string{
+	"from":        {"conda", "containers-storage", "docker", "docker-archive", "docker-daemon", "library", "localimage", "nix", "oci", "oci-archive", "oras", "podman", "shub", "spack"},
+	"library":     {"library"},
+	"registry":    {"conda", "docker", "spack"},
+	"namespace":   {"conda", "docker", "spack"},
+	"mirrorurl":   {"arch", "busybox", "debootstrap", "yum", "zypper"},
+	"updateurl":   {"yum", "zypper"},
+	"osversion":   {"debootstrap", "yum", "zypper"},
+	"include":     {"arch", "debootstrap", "yum", "zypper"},
+	"product":     {"zypper"},
+	"user":        {"zypper"},
+	"regcode":     {"zypper"},
+	"productpgp":  {"zypper"},
+	"registerurl": {"zypper"},
+	"modules":     {"zypper"},
+	"otherurl&n":  {"zypper"},
+	"nixfile":     {"nix"},
+	"nixattr":     {"nix"},
+	"condafile":   {"conda"},
+	"channels":    {"conda"},
+	"spec":        {"spack"},
+	"spackfile":   {"spack"},
+	"mirror":      {"spack"},
+	// debootstrap keyring and target selection
+	"keyring":      {"debootstrap"},
+	"variant":      {"debootstrap"},
+	"components":   {"debootstrap"},
+	"architecture": {"debootstrap"},
+	// yum and zypper keys, yum module streams and bootstrap image
+	"gpgkey":         {"yum", "zypper"},
+	"modulestreams":  {"yum"},
+	"bootstrapimage": {"yum"},
+	// zypper repositories, services and patterns
+	"repo&n":    {"zypper"},
+	"service&n": {"zypper"},
+	"patterns":  {"zypper"},
+	// arch pacman configuration and AUR packages
+	"pacmanconf": {"arch"},
+	"aurhelper":  {"arch"},
+	"aur":        {"arch"},
+}
+
+var (
+	// stageRegexp matches the header starting a new stage
+	stageRegexp = regexp.MustCompile(`(?i)^bootstrap:`)
+	// headerNumberRegexp matches the number suffix of numbered headers
+	headerNumberRegexp = regexp.MustCompile(`\d+$`)
+)
+
+// lintStage holds the headers of a definition file stage
+// along with their line numbers.
+type lintStage struct {
+	line         int
+	empty        bool
+	continuation bool
+	headers      map[string]int
+	values       map[string]string
+	// apps are the SCIF apps of the stage in definition order, depends
+	// holds their %appdepends sections and dependsLines their lines.
+	apps         []string
+	depends      map[string]string
+	dependsLines map[string]int
+}
+
+func newLintStage(line int) *lintStage {
+	return &lintStage{
+		line:         line,
+		empty:        true,
+		headers:      make(map[string]int),
+		values:       make(map[string]string),
+		depends:      make(map[string]string),
+		dependsLines: make(map[string]int),
+	}
+}
+
+// linter accumulates the diagnostics of a definition file.
+type linter struct {
+	diagnostics []Diagnostic
+	stages      []*lintStage
+	body        *lintBody
+}
+
+// lintBody holds the lines of an %environment, %appenv or %appdepends
+// section which is checked once complete.
+type lintBody struct {
+	section string
+	app     string
+	line    int
+	args    string
+	lines   []string
+}
+
+func (l *linter) errorf(line int, format string, a ...interface{}) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{SeverityError, line, fmt.Sprintf(format, a...)})
+}
+
+func (l *linter) warningf(line int, format string, a ...interface{}) {
+	l.diagnostics = append(l.diagnostics, Diagnostic{SeverityWarning, line, fmt.Sprintf(format, a...)})
+}
+
+// Lint checks the raw definition file without building it. It reports
+// invalid headers and bootstrap agents, headers missing or ignored by the
+// bootstrap agent, unknown sections, {{ variable }} references without
+// value, %files sources which don't exist, %environment and %appenv syntax
+// errors, variables not exported and invalid app dependencies. Variable
+// values are looked up like ApplyBuildArgs does and %files sources are
+// relative to the current working directory. Diagnostics are sorted by
+// line number.
+func Lint(raw []byte, args map[string]string) []Diagnostic {
+	l := &linter{}
+
+	defaults, err := argumentsDefaults(raw)
+	if err != nil {
+		l.errorf(0, "%s", err)
+	}
+
+	inHeader := true
+	section := ""
+	sectionArgs := ""
+	lineNum := 0
+
+	l.stages = append(l.stages, newLintStage(1))
+
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	for s.Scan() {
+		lineNum++
+		line := strings.TrimSpace(s.Text())
+
+		l.checkVariables(lineNum, line, args, defaults)
+
+		// stages are split like All does
+		if stageRegexp.MatchString(s.Text()) {
+			l.checkBody()
+			l.stages = append(l.stages, newLintStage(lineNum))
+			inHeader = true
+			section = ""
+		}
+		st := l.stages[len(l.stages)-1]
+
+		if strings.HasPrefix(line, "%") {
+			st.empty = false
+			inHeader = false
+			section = getSectionName(line)
+			sectionArgs = ""
+			if split := strings.SplitN(line, " ", 2); len(split) == 2 {
+				sectionArgs = strings.TrimSpace(split[1])
+			}
+			l.checkSection(lineNum, section, sectionArgs)
+			l.checkBody()
+			l.startBody(lineNum, section, sectionArgs)
+			continue
+		}
+
+		if l.body != nil {
+			l.body.lines = append(l.body.lines, s.Text())
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			st.continuation = false
+			continue
+		}
+
+		if inHeader {
+			st.empty = false
+			l.checkHeader(lineNum, line)
+		} else if section == "files" {
+			l.checkFiles(lineNum, line, sectionArgs)
+		}
+	}
+	if err := s.Err(); err != nil {
+		l.errorf(0, "while reading definition file: %s", err)
+	}
+	l.checkBody()
+
+	for _, st := range l.stages {
+		if !st.empty {
+			l.checkAgent(st)
+			l.checkApps(st)
+		}
+	}
+
+	// report parser errors not covered by the checks above
+	if !l.hasErrors() {
+		if b, err := ApplyBuildArgs(raw, args); err != nil {
+			l.errorf(0, "%s", err)
+		} else if _, err := All(bytes.NewReader(b)); err != nil {
+			l.errorf(0, "%s", err)
+		}
+	}
+
+	sort.SliceStable(l.diagnostics, func(i, j int) bool {
+		return l.diagnostics[i].Line < l.diagnostics[j].Line
+	})
+
+	return l.diagnostics
+}
+
+// HasErrors returns true if one of the diagnostics is an error.
+func HasErrors(diagnostics []Diagnostic) bool {
+	for _, d := range diagnostics {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *linter) hasErrors() bool {
+	return HasErrors(l.diagnostics)
+}
+
+// checkVariables reports the {{ variable }} references of the line
+// without value.
+func (l *linter) checkVariables(lineNum int, line string, args, defaults map[string]string) {
+	for _, m := range buildArgRegexp.FindAllStringSubmatch(line, -1) {
+		name := m[1]
+		if _, ok := args[name]; ok {
+			continue
+		}
+		if _, ok := os.LookupEnv(BuildArgEnvPrefix + name); ok {
+			continue
+		}
+		if _, ok := defaults[name]; ok {
+			continue
+		}
+		l.errorf(lineNum, "undefined variable %s, set it with --build-arg or in the %%arguments section", name)
+	}
+}
+
+// checkSection reports unknown sections and %files sections
+// referencing unknown stages.
+func (l *linter) checkSection(lineNum int, section, sectionArgs string) {
+	if !validSections[section] && !appSections[section] {
+		l.errorf(lineNum, "unknown section %%%s", section)
+		return
+	}
+	if appSections[section] && sectionArgs == "" {
+		l.errorf(lineNum, "section %%%s requires an app name", section)
+	}
+	if section == "test" || section == "apptest" {
+		args := sectionArgs
+		if section == "apptest" {
+			args = ""
+			if split := strings.SplitN(sectionArgs, " ", 2); len(split) == 2 {
+				args = split[1]
+			}
+		}
+		if _, err := types.ParseTestOptions(args); err != nil {
+			l.errorf(lineNum, "section %%%s: %s", section, err)
+		}
+	}
+	if section != "files" || sectionArgs == "" {
+		return
+	}
+
+	fields := strings.Fields(sectionArgs)
+	if len(fields) != 2 || strings.ToLower(fields[0]) != "from" {
+		l.errorf(lineNum, "invalid %%files arguments %q, expected 'from <stage>'", sectionArgs)
+		return
+	}
+	// a stage can only copy files from previous stages
+	for _, st := range l.stages[:len(l.stages)-1] {
+		if st.values["stage"] == fields[1] {
+			return
+		}
+	}
+	l.errorf(lineNum, "%%files references unknown stage %s", fields[1])
+}
+
+// startBody records the apps of the stage and starts collecting the
+// lines of the sections checked once complete.
+func (l *linter) startBody(lineNum int, section, sectionArgs string) {
+	b := &lintBody{section: section, line: lineNum, args: sectionArgs}
+	if appSections[section] {
+		split := strings.SplitN(sectionArgs, " ", 2)
+		b.app = split[0]
+		b.args = ""
+		if len(split) == 2 {
+			b.args = strings.TrimSpace(split[1])
+		}
+
+		st := l.stages[len(l.stages)-1]
+		if b.app != "" && !contains(st.apps, b.app) {
+			st.apps = append(st.apps, b.app)
+		}
+	}
+
+	switch section {
+	case "environment", "appenv", "appdepends":
+		l.body = b
+	}
+}
+
+// checkBody checks the complete section collected by startBody.
+func (l *linter) checkBody() {
+	b := l.body
+	if b == nil {
+		return
+	}
+	l.body = nil
+
+	script := strings.Join(b.lines, "\n")
+	if b.section == "appdepends" {
+		st := l.stages[len(l.stages)-1]
+		st.depends[b.app] += script + "\n"
+		if _, ok := st.dependsLines[b.app]; !ok {
+			st.dependsLines[b.app] = b.line
+		}
+		return
+	}
+
+	env, err := types.ParseEnvironment(types.Script{Args: b.args, Script: script})
+	if envErr, ok := err.(*types.EnvironmentError); ok {
+		l.errorf(b.line+envErr.Line, "invalid %%%s section: %s", b.section, envErr.Msg)
+		return
+	} else if err != nil {
+		l.errorf(b.line, "invalid %%%s section: %s", b.section, err)
+		return
+	}
+	for _, v := range env.Unexported() {
+		l.warningf(b.line+v.Line, "%%%s variable %s is not exported", b.section, v.Name)
+	}
+}
+
+// checkApps reports the %appdepends sections referencing unknown apps
+// and the dependency cycles of the stage.
+func (l *linter) checkApps(st *lintStage) {
+	d := types.Definition{AppOrder: st.apps, CustomData: make(map[string]string)}
+	line := 0
+	for _, app := range st.apps {
+		if deps, ok := st.depends[app]; ok {
+			d.CustomData["appdepends "+app] = deps
+			if line == 0 {
+				line = st.dependsLines[app]
+			}
+		}
+	}
+	if _, err := types.AppInstallOrder(d); err != nil {
+		l.errorf(line, "%s", err)
+	}
+}
+
+// checkHeader reports invalid header lines and keywords.
+func (l *linter) checkHeader(lineNum int, line string) {
+	st := l.stages[len(l.stages)-1]
+
+	line = strings.TrimSpace(strings.Split(line, "#")[0])
+	if st.continuation {
+		st.continuation = strings.HasSuffix(line, "\\")
+		return
+	}
+	st.continuation = strings.HasSuffix(line, "\\")
+
+	kv := strings.SplitN(line, ":", 2)
+	if len(kv) != 2 {
+		l.errorf(lineNum, "header line %q is not in the 'Key: value' form", line)
+		return
+	}
+
+	key := strings.ToLower(strings.TrimSpace(kv[0]))
+	if !validHeaders[key] && !validHeaders[headerPattern(key)] {
+		l.errorf(lineNum, "unknown header %s", strings.TrimSpace(kv[0]))
+		return
+	}
+	if _, ok := st.headers[key]; ok {
+		l.warningf(lineNum, "header %s is set multiple times, only the last value is used", strings.TrimSpace(kv[0]))
+	}
+	st.headers[key] = lineNum
+	st.values[key] = strings.TrimSpace(kv[1])
+}
+
+// checkAgent reports invalid bootstrap agents and headers missing
+// or ignored by the bootstrap agent of the stage.
+func (l *linter) checkAgent(st *lintStage) {
+	agent, ok := st.values["bootstrap"]
+	if !ok {
+		l.errorf(st.line, "missing Bootstrap header")
+		return
+	}
+	agent = strings.ToLower(agent)
+
+	required, ok := bootstrapAgents[agent]
+	if !ok {
+		l.errorf(st.headers["bootstrap"], "invalid bootstrap agent %s", agent)
+		return
+	}
+	for _, h := range required {
+		if st.values[h] == "" {
+			l.errorf(st.headers["bootstrap"], "bootstrap agent %s requires the %s header", agent, h)
+		}
+	}
+
+	for h, line := range st.headers {
+		agents, ok := agentHeaders[headerPattern(h)]
+		if !ok {
+			agents, ok = agentHeaders[h]
+		}
+		if ok && !contains(agents, agent) {
+			l.warningf(line, "header %s is ignored by the %s bootstrap agent", h, agent)
+		}
+	}
+}
+
+// checkFiles reports %files sources which don't exist on the host,
+// sources copied from another stage, downloaded or using variables
+// aren't checked.
+func (l *linter) checkFiles(lineNum int, line, sectionArgs string) {
+	if sectionArgs != "" {
+		return
+	}
+	src := strings.Fields(line)[0]
+	if strings.ContainsAny(src, "$`") || buildArgRegexp.MatchString(src) {
+		return
+	}
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return
+	}
+	if matches, err := filepath.Glob(src); err != nil {
+		l.errorf(lineNum, "invalid %%files source %s: %s", src, err)
+	} else if len(matches) == 0 {
+		l.errorf(lineNum, "%%files source %s doesn't exist", src)
+	}
+}
+
+// headerPattern returns the validHeaders pattern of numbered
+// headers (eg: otherurl1 -> otherurl&n).
+func headerPattern(key string) string {
+	return headerNumberRegexp.ReplaceAllString(key, "&n")
+}
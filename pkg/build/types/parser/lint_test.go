@@ -0,0 +1,102 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name     string
+		def      string
+		args     map[string]string
+		expected []Diagnostic
+	}{
+		{
+			name: "valid",
+			def:  "Bootstrap: docker\nFrom: ubuntu:{{ TAG }}\n\n%arguments\n    TAG=20.04\n\n%files\n    lint.go /opt\n",
+		},
+		{
+			name: "headers",
+			def:  "Bootstrap: docker\nFrom: alpine\nMirrorURL: http://localhost\nFoo: bar\nFrom alpine\n",
+			expected: []Diagnostic{
+				{SeverityWarning, 3, "header mirrorurl is ignored by the docker bootstrap agent"},
+				{SeverityError, 4, "unknown header Foo"},
+				{SeverityError, 5, `header line "From alpine" is not in the 'Key: value' form`},
+			},
+		},
+		{
+			name: "bootstrap agent",
+			def:  "Bootstrap: debootstrap\nOSVersion: focal\n\nBootstrap: unknown\n",
+			expected: []Diagnostic{
+				{SeverityError, 1, "bootstrap agent debootstrap requires the mirrorurl header"},
+				{SeverityError, 4, "invalid bootstrap agent unknown"},
+			},
+		},
+		{
+			name: "sections",
+			def:  "Bootstrap: scratch\n%bogus\n%apprun\n",
+			expected: []Diagnostic{
+				{SeverityError, 2, "unknown section %bogus"},
+				{SeverityError, 3, "section %apprun requires an app name"},
+			},
+		},
+		{
+			name: "test options",
+			def:  "Bootstrap: scratch\n%test --timeout 5m --retries 2\n%apptest foo --retries=-1\n%apptest bar --exit-code 3\n",
+			expected: []Diagnostic{
+				{SeverityError, 3, `section %apptest: invalid test options "--retries=-1": negative retries`},
+			},
+		},
+		{
+			name: "variables",
+			def:  "Bootstrap: docker\nFrom: {{ IMAGE }}\n%post\n    echo {{ MSG }}\n",
+			args: map[string]string{"MSG": "hello"},
+			expected: []Diagnostic{
+				{SeverityError, 2, "undefined variable IMAGE, set it with --build-arg or in the %arguments section"},
+			},
+		},
+		{
+			name: "files",
+			def:  "Bootstrap: scratch\nStage: one\n%files\n    missing.go\n    $HOME/file\n    https://example.com/file\nBootstrap: scratch\n%files from one\n    /missing\n%files from two\n",
+			expected: []Diagnostic{
+				{SeverityError, 4, "%files source missing.go doesn't exist"},
+				{SeverityError, 10, "%files references unknown stage two"},
+			},
+		},
+		{
+			name: "environment",
+			def:  "Bootstrap: scratch\n%environment\n    export A=1\n    B=2\n%runscript\n    echo\nBootstrap: scratch\n%environment --shell fish\n    set -x A 1\n    if true\n%environment --shell zsh\n",
+			expected: []Diagnostic{
+				{SeverityWarning, 4, "%environment variable B is not exported"},
+				{SeverityError, 10, "invalid %environment section: unsupported fish command if, only set and export are supported"},
+				{SeverityError, 11, `invalid %environment section: invalid environment options "--shell zsh": unsupported shell zsh, must be sh, bash or fish`},
+			},
+		},
+		{
+			name: "apps",
+			def:  "Bootstrap: scratch\n%appinstall samtools\n    make\n%appdepends samtools\n    htslib\n%appenv htslib\n    HTSLIB=1\n%appinstall htslib\n    make\nBootstrap: scratch\n%appdepends foo\n    bar\n",
+			expected: []Diagnostic{
+				{SeverityWarning, 7, "%appenv variable HTSLIB is not exported"},
+				{SeverityError, 11, "app foo depends on unknown app bar"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics := Lint([]byte(tt.def), tt.args)
+			if !reflect.DeepEqual(diagnostics, tt.expected) {
+				t.Errorf("unexpected diagnostics %v instead of %v", diagnostics, tt.expected)
+			}
+			if HasErrors(diagnostics) != (len(tt.expected) > 0) {
+				t.Errorf("unexpected errors state")
+			}
+		})
+	}
+}
@@ -0,0 +1,523 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Formats of the structured definition files.
+const (
+	// FormatYAML is a YAML structured definition file.
+	FormatYAML = "yaml"
+	// FormatJSON is a JSON structured definition file.
+	FormatJSON = "json"
+)
+
+// StructuredVersion is the version of the structured definition schema.
+const StructuredVersion = 1
+
+// StructuredDefinition is the YAML or JSON equivalent of a definition
+// file:
+//
+//	version: 1
+//	stages:
+//	  - header:
+//	      bootstrap: docker
+//	      from: ubuntu:20.04
+//	    sections:
+//	      - name: post
+//	        script: |
+//	          apt-get update
+//	      - name: apprun
+//	        app: hello
+//	        script: |
+//	          echo hello
+type StructuredDefinition struct {
+	Version int               `json:"version" yaml:"version"`
+	Stages  []StructuredStage `json:"stages" yaml:"stages"`
+}
+
+// StructuredStage is a build stage, header keywords are in lower case.
+// Only the first stage may have no bootstrap keyword.
+type StructuredStage struct {
+	Header   map[string]string   `json:"header,omitempty" yaml:"header,omitempty"`
+	Sections []StructuredSection `json:"sections,omitempty" yaml:"sections,omitempty"`
+}
+
+// StructuredSection is a section of a stage, sections keep the order of
+// the definition file. Script sections (eg: post, runscript, appinstall)
+// have a script, files and appfiles sections have files, labels and
+// applabels sections have labels, the arguments section has arguments
+// and an include directive has a path.
+type StructuredSection struct {
+	Name string `json:"name" yaml:"name"`
+	// App is the app name of the SCIF app sections.
+	App string `json:"app,omitempty" yaml:"app,omitempty"`
+	// Args are the arguments following the section name, and the app
+	// name of app sections, eg: -c /bin/bash or from <stage>.
+	Args      string            `json:"args,omitempty" yaml:"args,omitempty"`
+	Script    string            `json:"script,omitempty" yaml:"script,omitempty"`
+	Files     []StructuredFile  `json:"files,omitempty" yaml:"files,omitempty"`
+	Labels    map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Arguments map[string]string `json:"arguments,omitempty" yaml:"arguments,omitempty"`
+	Path      string            `json:"path,omitempty" yaml:"path,omitempty"`
+}
+
+// StructuredFile is a line of a files section.
+type StructuredFile struct {
+	Source      string   `json:"source" yaml:"source"`
+	Destination string   `json:"destination,omitempty" yaml:"destination,omitempty"`
+	Exclude     []string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	Owner       string   `json:"owner,omitempty" yaml:"owner,omitempty"`
+	Mode        string   `json:"mode,omitempty" yaml:"mode,omitempty"`
+}
+
+// stageHeaderRegexp matches the header line starting a build stage.
+var stageHeaderRegexp = regexp.MustCompile(`(?mi)^bootstrap:`)
+
+// StructuredFormat returns the structured format of the definition file
+// path selected by its extension (.yaml, .yml or .json), or an empty
+// string for a classic definition file.
+func StructuredFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".json":
+		return FormatJSON
+	}
+	return ""
+}
+
+// ParseStructured decodes the structured definition raw in format,
+// unknown fields are rejected.
+func ParseStructured(raw []byte, format string) (*StructuredDefinition, error) {
+	sd := new(StructuredDefinition)
+
+	switch format {
+	case FormatYAML:
+		if err := yaml.UnmarshalStrict(raw, sd); err != nil {
+			return nil, fmt.Errorf("while decoding YAML definition: %v", err)
+		}
+	case FormatJSON:
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(sd); err != nil {
+			return nil, fmt.Errorf("while decoding JSON definition: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown definition format %s", format)
+	}
+
+	return sd, sd.validate()
+}
+
+// ClassicDefinition returns the definition file equivalent of the
+// structured definition raw in format.
+func ClassicDefinition(raw []byte, format string) ([]byte, error) {
+	sd, err := ParseStructured(raw, format)
+	if err != nil {
+		return nil, err
+	}
+	return sd.Classic()
+}
+
+// ReadDefinitionFile reads the definition file at path, structured
+// definition files are returned as their definition file equivalent.
+func ReadDefinitionFile(path string) ([]byte, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if format := StructuredFormat(path); format != "" {
+		return ClassicDefinition(raw, format)
+	}
+	return raw, nil
+}
+
+// Marshal encodes the structured definition in format.
+func (sd *StructuredDefinition) Marshal(format string) ([]byte, error) {
+	switch format {
+	case FormatYAML:
+		return yaml.Marshal(sd)
+	case FormatJSON:
+		data, err := json.MarshalIndent(sd, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	return nil, fmt.Errorf("unknown definition format %s", format)
+}
+
+// validate checks that the structured definition can be written as a
+// definition file which parses to the same stages.
+func (sd *StructuredDefinition) validate() error {
+	if sd.Version != StructuredVersion {
+		return fmt.Errorf("unsupported definition version %d, the version must be %d", sd.Version, StructuredVersion)
+	}
+	if len(sd.Stages) == 0 {
+		return fmt.Errorf("no stage found in definition")
+	}
+
+	for i, st := range sd.Stages {
+		if _, ok := st.Header["bootstrap"]; !ok && i > 0 {
+			return fmt.Errorf("stage %d: the header requires a bootstrap keyword", i+1)
+		}
+		for key, value := range st.Header {
+			if key != strings.ToLower(key) || (!validHeaders[key] && !validHeaders[headerPattern(key)]) {
+				return fmt.Errorf("stage %d: invalid header keyword found: %s", i+1, key)
+			}
+			if strings.Contains(value, "#") {
+				return fmt.Errorf("stage %d: header %s: values can't contain #", i+1, key)
+			}
+		}
+		for j, s := range st.Sections {
+			if err := s.validate(); err != nil {
+				return fmt.Errorf("stage %d: section %d: %v", i+1, j+1, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validate checks the name and the fields of a section.
+func (s StructuredSection) validate() error {
+	if !validSections[s.Name] && !appSections[s.Name] {
+		return fmt.Errorf("invalid section %q", s.Name)
+	}
+	if appSections[s.Name] {
+		if s.App == "" || strings.ContainsAny(s.App, " \t\n") {
+			return fmt.Errorf("%%%s: a single word app name is required", s.Name)
+		}
+	} else if s.App != "" {
+		return fmt.Errorf("%%%s: only app sections have an app name", s.Name)
+	}
+	if strings.Contains(s.Args, "\n") {
+		return fmt.Errorf("%%%s: args must hold on a single line", s.Name)
+	}
+
+	var field string
+	switch s.Name {
+	case "files", "appfiles":
+		field = "files"
+		for _, f := range s.Files {
+			if err := f.validate(); err != nil {
+				return fmt.Errorf("%%%s: %v", s.Name, err)
+			}
+		}
+	case "labels", "applabels":
+		field = "labels"
+		for k, v := range s.Labels {
+			if k == "" || strings.ContainsAny(k, " \t\n") || strings.HasPrefix(k, "#") {
+				return fmt.Errorf("%%%s: invalid label name %q", s.Name, k)
+			}
+			if strings.Contains(v, "\n") {
+				return fmt.Errorf("%%%s: label %s value must hold on a single line", s.Name, k)
+			}
+		}
+	case "arguments":
+		field = "arguments"
+		for k, v := range s.Arguments {
+			if !buildArgNameRegexp.MatchString(k) {
+				return fmt.Errorf("%%arguments: invalid build argument name %q", k)
+			}
+			if strings.Contains(v, "\n") {
+				return fmt.Errorf("%%arguments: %s value must hold on a single line", k)
+			}
+		}
+	case "include":
+		field = "path"
+		if s.Path == "" {
+			return fmt.Errorf("%%include: a path or URL is required")
+		}
+	default:
+		field = "script"
+		sc := bufio.NewScanner(strings.NewReader(s.Script))
+		for line := 1; sc.Scan(); line++ {
+			if strings.HasPrefix(strings.TrimSpace(sc.Text()), "%") {
+				return fmt.Errorf("%%%s: script line %d starts with %%, it would start a new section", s.Name, line)
+			}
+		}
+	}
+
+	set := map[string]bool{
+		"script":    s.Script != "",
+		"files":     len(s.Files) > 0,
+		"labels":    len(s.Labels) > 0,
+		"arguments": len(s.Arguments) > 0,
+		"path":      s.Path != "",
+	}
+	for name, ok := range set {
+		if ok && name != field {
+			return fmt.Errorf("%%%s: %s are not allowed, the section has %s", s.Name, name, field)
+		}
+	}
+	return nil
+}
+
+// validate checks that the file can be written as a files line.
+func (f StructuredFile) validate() error {
+	if f.Source == "" || strings.ContainsAny(f.Source, " \t\n") {
+		return fmt.Errorf("invalid source %q", f.Source)
+	}
+	if strings.Contains(f.Destination, "\n") || (f.options() != "" && strings.ContainsAny(f.Destination, " \t")) {
+		return fmt.Errorf("invalid destination %q", f.Destination)
+	}
+	if f.Destination == "" && f.options() != "" {
+		return fmt.Errorf("source %s: a destination is required with exclude, owner or mode", f.Source)
+	}
+	_, err := parseFileTransport(f.line())
+	return err
+}
+
+func (f StructuredFile) options() string {
+	ft := types.FileTransport{Exclude: f.Exclude, Owner: f.Owner, Mode: f.Mode}
+	return ft.Options()
+}
+
+// line returns the files section line of the file.
+func (f StructuredFile) line() string {
+	line := f.Source
+	if f.Destination != "" {
+		line += " " + f.Destination
+	}
+	if opts := f.options(); opts != "" {
+		line += " " + opts
+	}
+	return line
+}
+
+// Classic returns the definition file equivalent of the structured
+// definition.
+func (sd *StructuredDefinition) Classic() ([]byte, error) {
+	if err := sd.validate(); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	for _, st := range sd.Stages {
+		writeStructuredHeader(&b, st.Header)
+		for _, s := range st.Sections {
+			writeStructuredSection(&b, s)
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// writeStructuredHeader writes the header keywords with bootstrap first,
+// multi-line values are continued with \n\.
+func writeStructuredHeader(b *bytes.Buffer, header map[string]string) {
+	if len(header) == 0 {
+		return
+	}
+	keys := sortedKeys(header)
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i] == "bootstrap" && keys[j] != "bootstrap"
+	})
+	separateSection(b)
+	for _, k := range keys {
+		fmt.Fprintf(b, "%s: %s\n", k, strings.Replace(header[k], "\n", "\\n\\\n", -1))
+	}
+}
+
+// separateSection adds an empty line before the next header or section
+// unless the previous script already ends with one.
+func separateSection(b *bytes.Buffer) {
+	if b.Len() > 0 && !bytes.HasSuffix(b.Bytes(), []byte("\n\n")) {
+		b.WriteString("\n")
+	}
+}
+
+func writeStructuredSection(b *bytes.Buffer, s StructuredSection) {
+	separateSection(b)
+	b.WriteString("%" + s.Name)
+	if s.App != "" {
+		b.WriteString(" " + s.App)
+	}
+	if s.Path != "" {
+		b.WriteString(" " + s.Path)
+	}
+	if s.Args != "" {
+		b.WriteString(" " + s.Args)
+	}
+	b.WriteString("\n")
+
+	switch {
+	case len(s.Files) > 0:
+		for _, f := range s.Files {
+			b.WriteString("    " + f.line() + "\n")
+		}
+	case len(s.Labels) > 0:
+		for _, k := range sortedKeys(s.Labels) {
+			b.WriteString("    " + strings.TrimSpace(k+" "+s.Labels[k]) + "\n")
+		}
+	case len(s.Arguments) > 0:
+		for _, k := range sortedKeys(s.Arguments) {
+			b.WriteString("    " + k + "=" + s.Arguments[k] + "\n")
+		}
+	case s.Script != "":
+		b.WriteString(s.Script)
+		if !strings.HasSuffix(s.Script, "\n") {
+			b.WriteString("\n")
+		}
+	}
+}
+
+// ToStructured returns the structured equivalent of the definition file
+// raw. Scripts are kept verbatim, comments of the header and of the
+// files, labels and arguments sections are dropped.
+func ToStructured(raw []byte) (*StructuredDefinition, error) {
+	sd := &StructuredDefinition{Version: StructuredVersion}
+
+	for _, chunk := range splitStages(raw) {
+		st, err := structuredStage(chunk)
+		if err != nil {
+			return nil, err
+		}
+		if len(st.Header) == 0 && len(st.Sections) == 0 {
+			continue
+		}
+		sd.Stages = append(sd.Stages, st)
+	}
+	if len(sd.Stages) == 0 {
+		return nil, errEmptyDefinition
+	}
+
+	return sd, sd.validate()
+}
+
+// splitStages splits the raw definition file at each bootstrap header
+// keyword like All does.
+func splitStages(raw []byte) [][]byte {
+	var chunks [][]byte
+	prev := 0
+	for _, loc := range stageHeaderRegexp.FindAllIndex(raw, -1) {
+		chunks = append(chunks, raw[prev:loc[0]])
+		prev = loc[0]
+	}
+	return append(chunks, raw[prev:])
+}
+
+// structuredStage converts a stage of a definition file.
+func structuredStage(raw []byte) (StructuredStage, error) {
+	var st StructuredStage
+
+	s := bufio.NewScanner(bytes.NewReader(raw))
+	s.Split(scanDefinitionFile)
+	for s.Scan() {
+		tok := s.Text()
+		if strings.TrimSpace(tok) == "" {
+			continue
+		}
+		if !strings.HasPrefix(strings.TrimSpace(tok), "%") {
+			d := types.Definition{Header: make(map[string]string)}
+			if err := doHeader(tok, &d); err != nil {
+				return st, fmt.Errorf("failed to parse deffile header: %v", err)
+			}
+			st.Header = d.Header
+			continue
+		}
+
+		section, err := structuredSection(strings.TrimLeft(tok, " \t\n"))
+		if err != nil {
+			return st, err
+		}
+		st.Sections = append(st.Sections, section)
+	}
+	return st, s.Err()
+}
+
+// structuredSection converts a section token, its first line is the
+// section name followed by its arguments.
+func structuredSection(tok string) (StructuredSection, error) {
+	split := strings.SplitN(tok, "\n", 2)
+	decl := strings.TrimSpace(strings.TrimPrefix(split[0], "%"))
+	body := ""
+	if len(split) == 2 {
+		body = split[1]
+	}
+
+	s := StructuredSection{Name: getSectionName(decl)}
+	args := ""
+	if fields := strings.SplitN(decl, " ", 2); len(fields) == 2 {
+		args = strings.TrimSpace(fields[1])
+	}
+	if appSections[s.Name] {
+		fields := strings.SplitN(args, " ", 2)
+		s.App = fields[0]
+		args = ""
+		if len(fields) == 2 {
+			args = strings.TrimSpace(fields[1])
+		}
+	}
+	if s.Name == "include" {
+		s.Path = args
+		return s, nil
+	}
+	s.Args = args
+
+	lines := func() []string {
+		var lines []string
+		for _, line := range strings.Split(body, "\n") {
+			if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "#") {
+				lines = append(lines, line)
+			}
+		}
+		return lines
+	}
+
+	switch s.Name {
+	case "files", "appfiles":
+		for _, line := range lines() {
+			ft, err := parseFileTransport(line)
+			if err != nil {
+				return s, fmt.Errorf("section %%%s: line %q: %v", s.Name, line, err)
+			}
+			s.Files = append(s.Files, StructuredFile{
+				Source:      ft.Src,
+				Destination: ft.Dst,
+				Exclude:     ft.Exclude,
+				Owner:       ft.Owner,
+				Mode:        ft.Mode,
+			})
+		}
+	case "labels", "applabels":
+		for _, line := range lines() {
+			if s.Labels == nil {
+				s.Labels = make(map[string]string)
+			}
+			kv := strings.SplitN(line, " ", 2)
+			if len(kv) == 2 {
+				s.Labels[kv[0]] = strings.TrimSpace(kv[1])
+			} else {
+				s.Labels[kv[0]] = ""
+			}
+		}
+	case "arguments":
+		args, err := ParseBuildArgs(lines())
+		if err != nil {
+			return s, fmt.Errorf("in %%arguments section: %v", err)
+		}
+		if len(args) > 0 {
+			s.Arguments = args
+		}
+	default:
+		s.Script = body
+	}
+	return s, nil
+}
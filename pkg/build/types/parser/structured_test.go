@@ -0,0 +1,204 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package parser
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const structuredDef = `# build stage
+Bootstrap: docker
+From: golang:1.14
+Stage: build
+Modules: main \n\
+    extra
+
+%arguments
+    VERSION=1.0
+
+%files
+    app.go /src/app.go
+    conf /etc/app --exclude *.bak --chown 1000:1000 --chmod 0644
+
+%post -c /bin/bash
+    set -e
+    # comments of scripts are kept
+    go build -o /app /src/app.go
+
+Bootstrap: docker
+From: alpine:3.12
+
+%files from build
+    /app /usr/bin/app
+
+%labels
+    Maintainer me
+    Version {{ VERSION }}
+
+%environment
+    export APP=/usr/bin/app
+
+%runscript
+    exec /usr/bin/app "$@"
+
+%appinstall hello
+    touch /hello
+
+%applabels hello
+    Hello world
+
+%apprun hello -c /bin/sh
+    echo hello
+`
+
+func TestStructuredRoundTrip(t *testing.T) {
+	expected, err := All(strings.NewReader(structuredDef))
+	if err != nil {
+		t.Fatalf("unable to parse definition: %v", err)
+	}
+
+	sd, err := ToStructured([]byte(structuredDef))
+	if err != nil {
+		t.Fatalf("unable to convert definition: %v", err)
+	}
+
+	for _, format := range []string{FormatYAML, FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			data, err := sd.Marshal(format)
+			if err != nil {
+				t.Fatalf("unable to encode definition: %v", err)
+			}
+			raw, err := ClassicDefinition(data, format)
+			if err != nil {
+				t.Fatalf("unable to decode definition: %v", err)
+			}
+			defs, err := All(bytes.NewReader(raw))
+			if err != nil {
+				t.Fatalf("unable to parse converted definition: %v\n%s", err, raw)
+			}
+			if len(defs) != len(expected) {
+				t.Fatalf("unexpected %d stages instead of %d", len(defs), len(expected))
+			}
+			for i := range defs {
+				defs[i].Raw, expected[i].Raw = nil, nil
+				if !reflect.DeepEqual(defs[i], expected[i]) {
+					t.Errorf("stage %d: unexpected definition\n%+v\ninstead of\n%+v", i+1, defs[i], expected[i])
+				}
+			}
+
+			again, err := ToStructured(raw)
+			if err != nil {
+				t.Fatalf("unable to convert definition: %v", err)
+			}
+			if !reflect.DeepEqual(again, sd) {
+				t.Errorf("unexpected structured definition %+v instead of %+v", again, sd)
+			}
+		})
+	}
+}
+
+func TestParseStructured(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		data   string
+		err    string
+	}{
+		{
+			name:   "valid",
+			format: FormatYAML,
+			data:   "version: 1\nstages:\n- header:\n    bootstrap: library\n    from: alpine\n  sections:\n  - name: post\n    script: echo\n",
+		},
+		{
+			name:   "unknown field",
+			format: FormatJSON,
+			data:   `{"version": 1, "stages": [{"header": {"bootstrap": "library"}, "scripts": []}]}`,
+			err:    "unknown field",
+		},
+		{
+			name:   "version",
+			format: FormatYAML,
+			data:   "version: 2\nstages:\n- header:\n    bootstrap: library\n",
+			err:    "unsupported definition version 2",
+		},
+		{
+			name:   "no bootstrap",
+			format: FormatYAML,
+			data:   "version: 1\nstages:\n- header:\n    bootstrap: library\n- header:\n    from: alpine\n",
+			err:    "requires a bootstrap keyword",
+		},
+		{
+			name:   "invalid header",
+			format: FormatYAML,
+			data:   "version: 1\nstages:\n- header:\n    bootstrap: library\n    image: alpine\n",
+			err:    "invalid header keyword found: image",
+		},
+		{
+			name:   "invalid section",
+			format: FormatYAML,
+			data:   "version: 1\nstages:\n- sections:\n  - name: install\n",
+			err:    `invalid section "install"`,
+		},
+		{
+			name:   "app name",
+			format: FormatYAML,
+			data:   "version: 1\nstages:\n- sections:\n  - name: apprun\n    script: echo\n",
+			err:    "a single word app name is required",
+		},
+		{
+			name:   "section field",
+			format: FormatYAML,
+			data:   "version: 1\nstages:\n- sections:\n  - name: post\n    labels:\n      a: b\n",
+			err:    "labels are not allowed",
+		},
+		{
+			name:   "script section",
+			format: FormatYAML,
+			data:   "version: 1\nstages:\n- sections:\n  - name: post\n    script: \"echo\\n%test\\n\"\n",
+			err:    "script line 2 starts with %",
+		},
+		{
+			name:   "file options",
+			format: FormatYAML,
+			data:   "version: 1\nstages:\n- sections:\n  - name: files\n    files:\n    - source: a\n      mode: \"0644\"\n",
+			err:    "a destination is required",
+		},
+		{
+			name:   "build argument",
+			format: FormatYAML,
+			data:   "version: 1\nstages:\n- sections:\n  - name: arguments\n    arguments:\n      1A: b\n",
+			err:    "invalid build argument name",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseStructured([]byte(tt.data), tt.format)
+			if tt.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("unexpected error %v instead of %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestStructuredFormat(t *testing.T) {
+	for path, format := range map[string]string{
+		"app.def":   "",
+		"app.yaml":  FormatYAML,
+		"app.YML":   FormatYAML,
+		"app.json":  FormatJSON,
+		"Singulari": "",
+	} {
+		if f := StructuredFormat(path); f != format {
+			t.Errorf("unexpected format %q for %s instead of %q", f, path, format)
+		}
+	}
+}
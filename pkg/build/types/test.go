@@ -0,0 +1,93 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package types
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	// TestReportJSON is the report of the %test and %apptest sections
+	// run during the build.
+	TestReportJSON = "test-report"
+	// TestOptionsExt is the extension of the file holding the options
+	// of a test script, next to the test script.
+	TestOptionsExt = ".opts"
+)
+
+// TestOptions holds the options of a %test or %apptest section, set
+// in the section header:
+//
+//	%test --timeout 5m --retries 2 --exit-code 0
+type TestOptions struct {
+	// Timeout is the duration after which a test run is killed and
+	// fails, there is no timeout if zero.
+	Timeout time.Duration
+	// Retries is the number of times a failed test is run again.
+	Retries int
+	// ExitCode is the exit code of a successful test run.
+	ExitCode int
+}
+
+// ParseTestOptions parses the arguments of a %test section, or the
+// arguments following the app name of an %apptest section.
+func ParseTestOptions(args string) (TestOptions, error) {
+	var o TestOptions
+
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.DurationVar(&o.Timeout, "timeout", 0, "")
+	fs.IntVar(&o.Retries, "retries", 0, "")
+	fs.IntVar(&o.ExitCode, "exit-code", 0, "")
+
+	if err := fs.Parse(strings.Fields(args)); err != nil {
+		return o, fmt.Errorf("invalid test options %q: %v", args, err)
+	}
+	if fs.NArg() > 0 {
+		return o, fmt.Errorf("invalid test options %q: unexpected argument %s", args, fs.Arg(0))
+	}
+	if o.Timeout < 0 {
+		return o, fmt.Errorf("invalid test options %q: negative timeout", args)
+	}
+	if o.Retries < 0 {
+		return o, fmt.Errorf("invalid test options %q: negative retries", args)
+	}
+	if o.ExitCode < 0 || o.ExitCode > 255 {
+		return o, fmt.Errorf("invalid test options %q: exit code must be between 0 and 255", args)
+	}
+	return o, nil
+}
+
+// IsZero returns true if o holds the default options.
+func (o TestOptions) IsZero() bool {
+	return o == TestOptions{}
+}
+
+// String returns the options in the format read by the test action
+// script, the timeout is rounded up to the second.
+func (o TestOptions) String() string {
+	timeout := (o.Timeout + time.Second - 1) / time.Second
+	return fmt.Sprintf("timeout=%d\nretries=%d\nexit_code=%d\n", timeout, o.Retries, o.ExitCode)
+}
+
+// WriteTestOptions writes the options o of the test script at path
+// next to it, a stale options file is removed for default options.
+func WriteTestOptions(path string, o TestOptions) error {
+	path += TestOptionsExt
+	if o.IsZero() {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(path, []byte(o.String()), 0644)
+}
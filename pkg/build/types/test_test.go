@@ -0,0 +1,50 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTestOptions(t *testing.T) {
+	tests := []struct {
+		args    string
+		want    TestOptions
+		opts    string
+		wantErr bool
+	}{
+		{args: "", want: TestOptions{}, opts: "timeout=0\nretries=0\nexit_code=0\n"},
+		{
+			args: "--timeout 1m30s --retries 2 --exit-code 3",
+			want: TestOptions{Timeout: 90 * time.Second, Retries: 2, ExitCode: 3},
+			opts: "timeout=90\nretries=2\nexit_code=3\n",
+		},
+		{args: "--timeout=500ms", want: TestOptions{Timeout: 500 * time.Millisecond}, opts: "timeout=1\nretries=0\nexit_code=0\n"},
+		{args: "--timeout 5", wantErr: true},
+		{args: "--retries -1", wantErr: true},
+		{args: "--exit-code 256", wantErr: true},
+		{args: "--bogus", wantErr: true},
+		{args: "-c /bin/bash", wantErr: true},
+		{args: "extra", wantErr: true},
+	}
+	for _, tt := range tests {
+		o, err := ParseTestOptions(tt.args)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("unexpected error for %q: %v", tt.args, err)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if o != tt.want {
+			t.Errorf("unexpected options for %q: %+v instead of %+v", tt.args, o, tt.want)
+		}
+		if o.String() != tt.opts {
+			t.Errorf("unexpected options file for %q: %q instead of %q", tt.args, o.String(), tt.opts)
+		}
+	}
+}
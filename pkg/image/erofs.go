@@ -0,0 +1,75 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"bytes"
+	"os"
+)
+
+const (
+	// erofsMagic is the little endian 0xE0F5E1E2 magic number
+	// of the erofs super block.
+	erofsMagic = "\xe2\xe1\xf5\xe0"
+	// erofsSuperOffset is the offset of the erofs super block.
+	erofsSuperOffset = 1024
+)
+
+type erofsFormat struct{}
+
+// CheckErofsHeader checks if byte content starts with an erofs filesystem.
+func CheckErofsHeader(b []byte) error {
+	if len(b) < erofsSuperOffset+len(erofsMagic) {
+		return debugError("can't find erofs super block")
+	}
+	if !bytes.Equal(b[erofsSuperOffset:erofsSuperOffset+len(erofsMagic)], []byte(erofsMagic)) {
+		return debugError("erofs magic not found")
+	}
+	return nil
+}
+
+func (f *erofsFormat) initializer(img *Image, fileinfo os.FileInfo) error {
+	if fileinfo.IsDir() {
+		return debugError("not an erofs image")
+	}
+	b := make([]byte, bufferSize)
+	if n, err := img.File.Read(b); err != nil || n != bufferSize {
+		return debugErrorf("can't read first %d bytes: %v", bufferSize, err)
+	}
+	if err := CheckErofsHeader(b); err != nil {
+		return err
+	}
+	img.Type = EROFS
+	img.Partitions = []Section{
+		{
+			Offset:       0,
+			Size:         uint64(fileinfo.Size()),
+			ID:           1,
+			Type:         EROFS,
+			Name:         RootFs,
+			AllowedUsage: RootFsUsage | OverlayUsage | DataUsage,
+		},
+	}
+
+	if img.Writable {
+		// see squashfs initializer
+		img.Writable = false
+
+		return &readOnlyFilesystemError{
+			"could not set " + img.Path + " image writable: erofs is a read-only filesystem",
+		}
+	}
+
+	return nil
+}
+
+func (f *erofsFormat) openMode(writable bool) int {
+	return os.O_RDONLY
+}
+
+func (f *erofsFormat) lock(img *Image) error {
+	return nil
+}
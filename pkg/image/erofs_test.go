@@ -0,0 +1,94 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+func TestErofsInitializer(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		writable bool
+		wantErr  bool
+	}{
+		{"Erofs", erofsMagic, false, false},
+		{"ErofsWritable", erofsMagic, true, true},
+		{"NotErofs", "\x68\x73\x71\x73", false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "erofs-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			b := make([]byte, 4*bufferSize)
+			copy(b[erofsSuperOffset:], tt.header)
+			if _, err := f.Write(b); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Seek(0, 0); err != nil {
+				t.Fatal(err)
+			}
+			fi, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img := &Image{File: f, Writable: tt.writable}
+			ef := &erofsFormat{}
+			err = ef.initializer(img, fi)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if img.Type != EROFS || len(img.Partitions) != 1 {
+				t.Fatalf("unexpected image %+v", img)
+			}
+			if p := img.Partitions[0]; p.Type != EROFS || p.Size != uint64(len(b)) {
+				t.Errorf("unexpected partition %+v", p)
+			}
+		})
+	}
+}
+
+func TestErofsPartitionType(t *testing.T) {
+	f, err := ioutil.TempFile("", "erofs-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	// a raw SIF partition at offset bufferSize holds an erofs filesystem
+	b := make([]byte, 4*bufferSize)
+	copy(b[bufferSize+erofsSuperOffset:], erofsMagic)
+	if _, err := f.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	img := &Image{File: f}
+
+	if typ, err := checkPartitionType(img, sif.FsRaw, bufferSize); err != nil || typ != EROFS {
+		t.Errorf("got type %#x and error %v for erofs partition", typ, err)
+	}
+	if typ, err := checkPartitionType(img, sif.FsRaw, 0); err != nil || typ != RAW {
+		t.Errorf("got type %#x and error %v for raw partition", typ, err)
+	}
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/sylabs/singularity/internal/pkg/util/user"
 	"github.com/sylabs/singularity/pkg/sylog"
 	"github.com/sylabs/singularity/pkg/util/fs/lock"
+	"github.com/sylabs/singularity/pkg/util/verity"
 )
 
 const (
@@ -32,6 +33,10 @@ const (
 	ENCRYPTSQUASHFS
 	// RAW constant for raw format
 	RAW
+	// ENCRYPTEXT3 constant for ext3 format encrypted with LUKS
+	ENCRYPTEXT3
+	// EROFS constant for erofs format
+	EROFS
 )
 
 type Usage uint8
@@ -101,6 +106,8 @@ var registeredFormats = []struct {
 	{"sif", &sifFormat{}},
 	{"squashfs", &squashfsFormat{}},
 	{"ext3", &ext3Format{}},
+	{"luks", &luksFormat{}},
+	{"erofs", &erofsFormat{}},
 }
 
 // format describes the interface that an image format type must implement.
@@ -118,6 +125,9 @@ type Section struct {
 	ID           uint32 `json:"id"`
 	Type         uint32 `json:"type"`
 	AllowedUsage Usage  `json:"allowed_usage"`
+	// Verity holds the dm-verity parameters of a SIF root filesystem
+	// partition protected by a hash tree.
+	Verity *verity.Params `json:"verity,omitempty"`
 }
 
 // Image describes an image object, an image is composed of one
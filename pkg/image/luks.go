@@ -0,0 +1,67 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+)
+
+// luksMagic starts the header of LUKS volumes.
+const luksMagic = "LUKS\xba\xbe"
+
+type luksFormat struct{}
+
+// CheckLUKSHeader checks if byte content starts with a LUKS header.
+func CheckLUKSHeader(b []byte) error {
+	if !bytes.HasPrefix(b, []byte(luksMagic)) {
+		return debugError("LUKS magic not found")
+	}
+	return nil
+}
+
+// initializer recognizes the encrypted overlay images, LUKS volumes
+// holding an ext3 filesystem only usable as overlay.
+func (f *luksFormat) initializer(img *Image, fileinfo os.FileInfo) error {
+	if fileinfo.IsDir() {
+		return debugError("not a LUKS image")
+	}
+	b := make([]byte, bufferSize)
+	if n, err := img.File.Read(b); err != nil || n != bufferSize {
+		return debugErrorf("can't read first %d bytes: %v", bufferSize, err)
+	}
+	if err := CheckLUKSHeader(b); err != nil {
+		return err
+	}
+	img.Type = ENCRYPTEXT3
+	img.Partitions = []Section{
+		{
+			Offset:       0,
+			Size:         uint64(fileinfo.Size()),
+			ID:           1,
+			Type:         ENCRYPTEXT3,
+			Name:         RootFs,
+			AllowedUsage: OverlayUsage,
+		},
+	}
+
+	return nil
+}
+
+func (f *luksFormat) openMode(writable bool) int {
+	if writable {
+		return os.O_RDWR
+	}
+	return os.O_RDONLY
+}
+
+func (f *luksFormat) lock(img *Image) error {
+	if err := lockSection(img, img.Partitions[0]); err != nil {
+		return fmt.Errorf("while locking encrypted partition from %s: %s", img.Path, err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package image
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLUKSInitializer(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{"LUKS", luksMagic + "\x00\x02", false},
+		{"NotLUKS", "\x53\xEF", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ioutil.TempFile("", "luks-")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(f.Name())
+			defer f.Close()
+
+			b := make([]byte, 4*bufferSize)
+			copy(b, tt.header)
+			if _, err := f.Write(b); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := f.Seek(0, 0); err != nil {
+				t.Fatal(err)
+			}
+			fi, err := f.Stat()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			img := &Image{File: f}
+			lf := &luksFormat{}
+			err = lf.initializer(img, fi)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if img.Type != ENCRYPTEXT3 || len(img.Partitions) != 1 {
+				t.Fatalf("unexpected image %+v", img)
+			}
+			p := img.Partitions[0]
+			if p.Size != uint64(len(b)) || p.AllowedUsage != OverlayUsage {
+				t.Errorf("unexpected partition %+v", p)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package packer
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+)
+
+// Erofs represents an erofs packer
+type Erofs struct {
+	MkfsErofsPath string
+	// Run runs the mkfs.erofs command if set (eg: in a user namespace).
+	Run func(cmd *exec.Cmd) error
+}
+
+// NewErofs initializes and returns an Erofs packer instance
+func NewErofs() *Erofs {
+	e := &Erofs{}
+	e.MkfsErofsPath, _ = exec.LookPath("mkfs.erofs")
+	return e
+}
+
+// HasMkfsErofs returns if mkfs.erofs binary has set or not
+func (e Erofs) HasMkfsErofs() bool {
+	return e.MkfsErofsPath != ""
+}
+
+// Create makes an erofs filesystem from the source directory src to a
+// destination file.
+func (e Erofs) Create(src string, dest string, opts []string) error {
+	var stderr bytes.Buffer
+
+	if !e.HasMkfsErofs() {
+		return fmt.Errorf("could not create erofs, mkfs.erofs not found")
+	}
+
+	// mkfs.erofs takes args of the form: [options] destination source
+	args := append(append([]string{}, opts...), dest, src)
+
+	cmd := exec.Command(e.MkfsErofsPath, args...)
+	cmd.Stderr = &stderr
+	run := cmd.Run
+	if e.Run != nil {
+		run = func() error { return e.Run(cmd) }
+	}
+	if err := run(); err != nil {
+		return fmt.Errorf("create command failed: %v: %s", err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package packer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+func TestErofsCreate(t *testing.T) {
+	e := NewErofs()
+	if !e.HasMkfsErofs() {
+		t.Skip("mkfs.erofs not found")
+	}
+
+	dir, err := ioutil.TempDir("", "erofs-packer-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(filepath.Join(src, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(src, "etc", "hostname"), []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dest := filepath.Join(dir, "image.erofs")
+	if err := e.Create(src, dest, []string{"-zlz4hc"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, err := ioutil.ReadFile(dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := image.CheckErofsHeader(b); err != nil {
+		t.Errorf("unexpected erofs image: %s", err)
+	}
+
+	e.MkfsErofsPath = ""
+	if err := e.Create(src, dest, nil); err == nil {
+		t.Errorf("unexpected success without mkfs.erofs")
+	}
+}
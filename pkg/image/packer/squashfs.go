@@ -8,12 +8,20 @@ package packer
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os/exec"
+	"regexp"
+	"strconv"
 )
 
 // Squashfs represents a squashfs packer
 type Squashfs struct {
 	MksquashfsPath string
+	// Progress is called with the percentage of the mksquashfs
+	// progress bar when it changes if set.
+	Progress func(percent int)
+	// Run runs the mksquashfs command if set (eg: in a user namespace).
+	Run func(cmd *exec.Cmd) error
 }
 
 // NewSquashfs initializes and returns a Squashfs packer instance
@@ -28,7 +36,20 @@ func (s Squashfs) HasMksquashfs() bool {
 	return s.MksquashfsPath != ""
 }
 
-func (s Squashfs) create(files []string, dest string, opts []string) error {
+// tarOptionRegexp matches the -tar option in the mksquashfs help.
+var tarOptionRegexp = regexp.MustCompile(`(?m)^\s*-tar\b`)
+
+// HasTarSupport returns if mksquashfs reads tar archives (mksquashfs >= 4.6).
+func (s Squashfs) HasTarSupport() bool {
+	if !s.HasMksquashfs() {
+		return false
+	}
+	// the help is printed along with an error exit status
+	out, _ := exec.Command(s.MksquashfsPath, "-help").CombinedOutput()
+	return tarOptionRegexp.Match(out)
+}
+
+func (s Squashfs) create(files []string, dest string, opts []string, stdin io.Reader) error {
 	var stderr bytes.Buffer
 
 	if !s.HasMksquashfs() {
@@ -41,8 +62,16 @@ func (s Squashfs) create(files []string, dest string, opts []string) error {
 	args = append(args, opts...)
 
 	cmd := exec.Command(s.MksquashfsPath, args...)
+	cmd.Stdin = stdin
 	cmd.Stderr = &stderr
-	if err := cmd.Run(); err != nil {
+	if s.Progress != nil {
+		cmd.Stdout = &progressWriter{report: s.Progress, last: -1}
+	}
+	run := cmd.Run
+	if s.Run != nil {
+		run = func() error { return s.Run(cmd) }
+	}
+	if err := run(); err != nil {
 		return fmt.Errorf("create command failed: %v: %s", err, stderr.String())
 	}
 	return nil
@@ -51,5 +80,38 @@ func (s Squashfs) create(files []string, dest string, opts []string) error {
 // Create makes a squashfs filesystem from a list of source files/directories to a
 // destination file
 func (s Squashfs) Create(src []string, dest string, opts []string) error {
-	return s.create(src, dest, opts)
+	return s.create(src, dest, opts, nil)
+}
+
+// CreateFromTar makes a squashfs filesystem from the tar archive read from
+// r to a destination file, the files keep the ownership of the archive.
+func (s Squashfs) CreateFromTar(r io.Reader, dest string, opts []string) error {
+	return s.create([]string{"-"}, dest, append([]string{"-tar"}, opts...), r)
+}
+
+var percentRegexp = regexp.MustCompile(`(\d+)%`)
+
+// progressWriter parses the percentage of the mksquashfs progress bar
+// redrawn after a carriage return.
+type progressWriter struct {
+	report func(int)
+	line   []byte
+	last   int
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		if c != '\r' && c != '\n' {
+			w.line = append(w.line, c)
+			continue
+		}
+		if m := percentRegexp.FindSubmatch(w.line); m != nil {
+			if n, err := strconv.Atoi(string(m[1])); err == nil && n != w.last {
+				w.last = n
+				w.report(n)
+			}
+		}
+		w.line = w.line[:0]
+	}
+	return len(p), nil
 }
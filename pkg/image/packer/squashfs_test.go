@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -118,3 +119,17 @@ func TestSquashfs(t *testing.T) {
 	t.Run("non-zero exit code", testNonZeroExitCode)
 	t.Run("happy path", testHappyPath)
 }
+
+func TestProgressWriter(t *testing.T) {
+	var got []int
+
+	w := &progressWriter{report: func(n int) { got = append(got, n) }, last: -1}
+	output := "Parallel mksquashfs: Using 4 processors\n" +
+		"[====        ] 10/40  25%\r[=====       ] 12/40  3"
+	w.Write([]byte(output))
+	w.Write([]byte("0%\r[=====       ] 12/40  30%\r[============] 40/40 100%\n\nExportable Squashfs 4.0 filesystem\n"))
+
+	if want := []int{25, 30, 100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got progress %v, want %v", got, want)
+	}
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/sylabs/sif/pkg/sif"
 	"github.com/sylabs/singularity/internal/pkg/util/machine"
+	"github.com/sylabs/singularity/pkg/util/verity"
 )
 
 type sifFormat struct{}
@@ -39,12 +40,67 @@ func checkPartitionType(img *Image, fstype sif.Fstype, offset int64) (uint32, er
 	case sif.FsEncryptedSquashfs:
 		return ENCRYPTSQUASHFS, nil
 	case sif.FsRaw:
+		// encrypted overlay partitions are raw LUKS volumes, SIF has
+		// no erofs filesystem type and erofs partitions are raw too
+		if CheckLUKSHeader(header) == nil {
+			return ENCRYPTEXT3, nil
+		}
+		if CheckErofsHeader(header) == nil {
+			return EROFS, nil
+		}
 		return RAW, nil
 	}
 
 	return 0, fmt.Errorf("unknown filesystem type %v", fstype)
 }
 
+// partitionArch returns the SIF architecture of a system partition.
+func partitionArch(desc *sif.Descriptor) string {
+	arch, err := desc.GetArch()
+	if err != nil {
+		return ""
+	}
+	return string(arch[:sif.HdrArchLen-1])
+}
+
+// systemPartition returns the system partition of a multi-architecture
+// image matching arch, a system partition tagged with arch is selected
+// if the primary system partition doesn't match it. It returns the
+// primary system partition otherwise, or nil if there is none.
+func systemPartition(descrs []sif.Descriptor, arch string) *sif.Descriptor {
+	var primary, match *sif.Descriptor
+
+	for i := range descrs {
+		desc := &descrs[i]
+		if !desc.Used {
+			continue
+		}
+		ptype, err := desc.GetPartType()
+		if err != nil {
+			continue
+		}
+		if _, err := desc.GetFsType(); err != nil {
+			continue
+		}
+
+		switch ptype {
+		case sif.PartPrimSys:
+			if primary == nil {
+				primary = desc
+			}
+		case sif.PartSystem:
+			if match == nil && partitionArch(desc) == arch {
+				match = desc
+			}
+		}
+	}
+
+	if primary != nil && (match == nil || partitionArch(primary) == arch) {
+		return primary
+	}
+	return match
+}
+
 func (f *sifFormat) initializer(img *Image, fi os.FileInfo) error {
 	if fi.IsDir() {
 		return debugError("not a sif file image")
@@ -65,21 +121,12 @@ func (f *sifFormat) initializer(img *Image, fi os.FileInfo) error {
 
 	groupID := -1
 
-	// Get the default system partition image
-	for _, desc := range fimg.DescrArr {
-		if !desc.Used {
-			continue
-		}
-		ptype, err := desc.GetPartType()
-		if err != nil {
-			continue
-		}
-		if ptype != sif.PartPrimSys {
-			continue
-		}
+	// Get the system partition of the host architecture, or the
+	// default system partition
+	if desc := systemPartition(fimg.DescrArr, sif.GetSIFArch(runtime.GOARCH)); desc != nil {
 		fstype, err := desc.GetFsType()
 		if err != nil {
-			continue
+			return err
 		}
 
 		// checks if the partition length is greater that the file
@@ -97,7 +144,7 @@ func (f *sifFormat) initializer(img *Image, fi os.FileInfo) error {
 		// CompatibleWith call will also check that the current machine
 		// has persistent emulation enabled in /proc/sys/fs/binfmt_misc to
 		// be able to execute container process correctly
-		sifArch := string(fimg.Header.Arch[:sif.HdrArchLen-1])
+		sifArch := partitionArch(desc)
 		goArch := sif.GetGoArch(sifArch)
 		if sifArch != sif.HdrArchUnknown && !machine.CompatibleWith(goArch) {
 			return fmt.Errorf("the image's architecture (%s) could not run on the host's (%s)", goArch, runtime.GOARCH)
@@ -114,8 +161,13 @@ func (f *sifFormat) initializer(img *Image, fi os.FileInfo) error {
 			},
 		}
 
+		if htype == SQUASHFS {
+			if p, _, err := verity.FromSIF(fimg.DescrArr, img.File, desc.ID); err == nil {
+				img.Partitions[0].Verity = p
+			}
+		}
+
 		groupID = int(desc.Groupid)
-		break
 	}
 
 	for _, desc := range fimg.DescrArr {
@@ -127,8 +179,9 @@ func (f *sifFormat) initializer(img *Image, fi os.FileInfo) error {
 			if ptype != sif.PartData && ptype != sif.PartOverlay {
 				continue
 			}
-			// ignore overlay partitions not associated to root filesystem group ID if any
-			if ptype == sif.PartOverlay && groupID > 0 && groupID != int(desc.Groupid) {
+			// ignore overlay partitions not associated to root filesystem group ID if any,
+			// an overlay added to a signed image has its own group linked to it
+			if ptype == sif.PartOverlay && groupID > 0 && groupID != int(desc.Groupid) && groupID != int(desc.Link) {
 				continue
 			}
 			fstype, err := desc.GetFsType()
@@ -198,7 +251,7 @@ func (f *sifFormat) openMode(writable bool) int {
 
 func (f *sifFormat) lock(img *Image) error {
 	for _, part := range img.Partitions {
-		if part.Type != EXT3 {
+		if part.Type != EXT3 && part.Type != ENCRYPTEXT3 {
 			continue
 		}
 		if err := lockSection(img, part); err != nil {
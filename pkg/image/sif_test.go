@@ -256,3 +256,98 @@ func TestSIFOpenMode(t *testing.T) {
 		t.Fatal("openMode(false) returned the wrong value")
 	}
 }
+
+func TestSIFMultiArch(t *testing.T) {
+	foreignArch := "s390x"
+	if runtime.GOARCH == foreignArch {
+		foreignArch = "amd64"
+	}
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	partition := func(arch string, ptype sif.Parttype, group uint32) sif.DescriptorInput {
+		fp, err := os.Open(testSquash)
+		if err != nil {
+			t.Fatalf("failed to open %s: %s", testSquash, err)
+		}
+		files = append(files, fp)
+
+		fi, err := fp.Stat()
+		if err != nil {
+			t.Fatalf("failed to stat %s: %s", testSquash, err)
+		}
+		input := sif.DescriptorInput{
+			Datatype: sif.DataPartition,
+			Groupid:  sif.DescrGroupMask | group,
+			Link:     sif.DescrUnusedLink,
+			Size:     fi.Size(),
+			Fname:    arch,
+			Fp:       fp,
+		}
+		if err := input.SetPartExtra(sif.FsSquash, ptype, sif.GetSIFArch(arch)); err != nil {
+			t.Fatalf("failed to set partition extra data: %s", err)
+		}
+		return input
+	}
+
+	tests := []struct {
+		name     string
+		inputs   []sif.DescriptorInput
+		expected string
+	}{
+		{
+			name:     "HostPrimary",
+			inputs:   []sif.DescriptorInput{partition(runtime.GOARCH, sif.PartPrimSys, 1), partition(foreignArch, sif.PartSystem, 2)},
+			expected: runtime.GOARCH,
+		},
+		{
+			name:     "HostSystem",
+			inputs:   []sif.DescriptorInput{partition(foreignArch, sif.PartPrimSys, 1), partition(runtime.GOARCH, sif.PartSystem, 2)},
+			expected: runtime.GOARCH,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := createSIF(t, tt.inputs, false)
+			defer os.Remove(path)
+
+			fimg, err := sif.LoadContainer(path, true)
+			if err != nil {
+				t.Fatalf("failed to load %s: %s", path, err)
+			}
+			defer fimg.UnloadContainer()
+
+			desc := systemPartition(fimg.DescrArr, sif.GetSIFArch(runtime.GOARCH))
+			if desc == nil {
+				t.Fatalf("no system partition selected")
+			}
+			if name := desc.GetName(); name != tt.expected {
+				t.Errorf("unexpected partition %s selected instead of %s", name, tt.expected)
+			}
+
+			img := &Image{Path: path, Name: path}
+			img.File, err = os.Open(path)
+			if err != nil {
+				t.Fatalf("cannot open image's file: %s", err)
+			}
+			defer img.File.Close()
+
+			fi, err := img.File.Stat()
+			if err != nil {
+				t.Fatalf("cannot stat the image file: %s", err)
+			}
+			if err := new(sifFormat).initializer(img, fi); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(img.Partitions) != 1 || img.Partitions[0].ID != desc.ID {
+				t.Errorf("unexpected root filesystem partitions %+v", img.Partitions)
+			}
+		})
+	}
+}
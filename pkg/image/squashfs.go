@@ -22,8 +22,20 @@ const (
 	squashfsLzoComp  = 3
 	squashfsXzComp   = 4
 	squashfsLz4Comp  = 5
+	squashfsZstdComp = 6
 )
 
+// squashfsCompressions maps the squashfs compression
+// identifiers to the compression algorithm names.
+var squashfsCompressions = map[uint16]string{
+	squashfsZlib:     "gzip",
+	squashfsLzmaComp: "lzma",
+	squashfsLzoComp:  "lzo",
+	squashfsXzComp:   "xz",
+	squashfsLz4Comp:  "lz4",
+	squashfsZstdComp: "zstd",
+}
+
 // this represents the superblock of a v4 squashfs image
 // previous versions of the superblock contain the major and minor versions
 // at the same location so we can use this struct to deduce the version
@@ -80,20 +92,11 @@ func CheckSquashfsHeader(b []byte) (uint64, error) {
 	}
 
 	if sinfo.Compression != squashfsZlib {
-		compressionType := ""
-		switch sinfo.Compression {
-		case squashfsLzmaComp:
-			compressionType = "lzma"
-		case squashfsLz4Comp:
-			compressionType = "lz4"
-		case squashfsLzoComp:
-			compressionType = "lzo"
-		case squashfsXzComp:
-			compressionType = "xz"
-		default:
+		compressionType, ok := squashfsCompressions[sinfo.Compression]
+		if !ok {
 			return 0, fmt.Errorf("corrupted image: unknown compression algorithm value %d", sinfo.Compression)
 		}
-		sylog.Infof("squashfs image was compressed with %s, if it failed to run, please contact image's author", compressionType)
+		sylog.Debugf("squashfs image was compressed with %s, mounting it requires a kernel supporting %s", compressionType, compressionType)
 	}
 	return offset, nil
 }
@@ -108,20 +111,7 @@ func GetSquashfsComp(b []byte) (string, error) {
 
 	// tighten up this check to at least look a the major version
 	if sb.Major == 4 {
-		var compType string
-		switch sb.Compression {
-		case squashfsZlib:
-			compType = "gzip"
-		case squashfsLzmaComp:
-			compType = "lzma"
-		case squashfsLz4Comp:
-			compType = "lz4"
-		case squashfsLzoComp:
-			compType = "lzo"
-		case squashfsXzComp:
-			compType = "xz"
-		}
-		return compType, nil
+		return squashfsCompressions[sb.Compression], nil
 	} else if sb.Major < 4 {
 		// v3 and eariler super blocks always use gzip comp
 		// different compressors were introduced after the change
@@ -20,6 +20,9 @@ import (
 // Squashfs represents a squashfs unpacker.
 type Squashfs struct {
 	UnsquashfsPath string
+	// Run runs the unsquashfs command if set (eg: in a user namespace
+	// where the files keep their ownership).
+	Run func(cmd *exec.Cmd) error
 }
 
 // NewSquashfs initializes and returns a Squahfs unpacker instance
@@ -69,7 +72,7 @@ func (s *Squashfs) extract(files []string, reader io.Reader, dest string) error
 	// If we are running as non-root, first try with `-user-xattrs` so we won't fail trying
 	// to set system xatts. This isn't supported on unsquashfs 4.0 in RHEL6 so we
 	//  have to fall back to not using that option on failure.
-	if os.Geteuid() != 0 {
+	if os.Geteuid() != 0 && s.Run == nil {
 		sylog.Debugf("Rootless extraction. Trying -user-xattrs for unsquashfs")
 		args := []string{"-user-xattrs", "-f", "-d", dest, filename}
 		args = append(args, files...)
@@ -99,6 +102,15 @@ func (s *Squashfs) extract(files []string, reader io.Reader, dest string) error
 	if stdin {
 		cmd.Stdin = reader
 	}
+	if s.Run != nil {
+		var out bytes.Buffer
+		cmd.Stdout = &out
+		cmd.Stderr = &out
+		if err := s.Run(cmd); err != nil {
+			return fmt.Errorf("extract command failed: %s: %s", out.String(), err)
+		}
+		return nil
+	}
 	if o, err := cmd.CombinedOutput(); err != nil {
 		return fmt.Errorf("extract command failed: %s: %s", string(o), err)
 	}
@@ -5,9 +5,15 @@
 
 package inspect
 
+import "encoding/json"
+
 // ContainerType defines the container type (used by default).
 const ContainerType = "container"
 
+// Version is the version of the JSON format, it changes when fields are
+// removed or change meaning.
+const Version = "2"
+
 // AppAttributes describes app metadata attributes.
 type AppAttributes struct {
 	Environment map[string]string `json:"environment,omitempty"`
@@ -15,6 +21,12 @@ type AppAttributes struct {
 	Runscript   string            `json:"runscript,omitempty"`
 	Test        string            `json:"test,omitempty"`
 	Helpfile    string            `json:"helpfile,omitempty"`
+
+	// Dependencies are the apps required by the app in install order.
+	Dependencies []string `json:"dependencies,omitempty"`
+	// EnvironmentVariables holds the variables of the %appenv section
+	// validated at build time.
+	EnvironmentVariables json.RawMessage `json:"environmentVariables,omitempty"`
 }
 
 // Attributes describes metadata attributes of Singularity containers.
@@ -27,6 +39,29 @@ type Attributes struct {
 	Helpfile    string                    `json:"helpfile,omitempty"`
 	Deffile     string                    `json:"deffile,omitempty"`
 	Startscript string                    `json:"startscript,omitempty"`
+
+	// EnvironmentVariables holds the variables of the %environment
+	// section validated at build time.
+	EnvironmentVariables json.RawMessage `json:"environmentVariables,omitempty"`
+
+	// SIF describes the data objects of SIF images.
+	SIF *SIF `json:"sif,omitempty"`
+	// SBOM references the software bill of materials generated with
+	// build --sbom, the document itself is shown by inspect --sbom.
+	SBOM *Object `json:"sbom,omitempty"`
+	// Sources are the base images of the build stages.
+	Sources []Source `json:"sources,omitempty"`
+	// Provenance describes how the image was built.
+	Provenance json.RawMessage `json:"provenance,omitempty"`
+}
+
+// Source is the base image of a build stage, Digest is the manifest
+// digest of OCI sources.
+type Source struct {
+	Stage     string `json:"stage,omitempty"`
+	Bootstrap string `json:"bootstrap"`
+	Source    string `json:"source,omitempty"`
+	Digest    string `json:"digest,omitempty"`
 }
 
 // Data holds the container metadata attributes.
@@ -36,8 +71,9 @@ type Data struct {
 
 // Metadata describes the JSON format of Singularity container metadata.
 type Metadata struct {
-	Data `json:"data"`
-	Type string `json:"type"`
+	Data    `json:"data"`
+	Type    string `json:"type"`
+	Version string `json:"version"`
 }
 
 func (m *Metadata) AddApp(name string) {
@@ -53,6 +89,7 @@ func (m *Metadata) AddApp(name string) {
 func NewMetadata() *Metadata {
 	format := new(Metadata)
 	format.Type = ContainerType
+	format.Version = Version
 	format.Attributes.Labels = make(map[string]string)
 	format.Attributes.Environment = make(map[string]string)
 	format.Attributes.Apps = make(map[string]*AppAttributes)
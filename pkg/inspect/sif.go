@@ -0,0 +1,203 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package inspect
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/util/artifact"
+)
+
+// SIF describes the header and the data objects of a SIF image.
+type SIF struct {
+	ID       string    `json:"id"`
+	Arch     string    `json:"arch"`
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+	Size     int64     `json:"size"`
+
+	Partitions []Partition `json:"partitions"`
+	Signatures []Signature `json:"signatures,omitempty"`
+	// Objects are the data objects other than partitions and
+	// signatures (eg: definition file, JSON metadata, build log).
+	Objects []Object `json:"objects,omitempty"`
+}
+
+// Object describes a data object, Group and LinkedGroup are the group
+// numbers without the SIF group mask. MediaType is only set for
+// artifacts.
+type Object struct {
+	ID           uint32 `json:"id,omitempty"`
+	Type         string `json:"type,omitempty"`
+	Name         string `json:"name,omitempty"`
+	MediaType    string `json:"mediaType,omitempty"`
+	Group        uint32 `json:"group,omitempty"`
+	LinkedObject uint32 `json:"linkedObject,omitempty"`
+	LinkedGroup  uint32 `json:"linkedGroup,omitempty"`
+	Size         int64  `json:"size"`
+	Digest       string `json:"digest"`
+}
+
+// Partition describes a filesystem partition.
+type Partition struct {
+	Object
+	Filesystem string `json:"filesystem"`
+	Usage      string `json:"usage"`
+	Arch       string `json:"arch"`
+}
+
+// Signature describes a signature object, Entity is the fingerprint of
+// the signing key.
+type Signature struct {
+	Object
+	Hash   string `json:"hash"`
+	Entity string `json:"entity"`
+}
+
+// NewSIF returns the description of the SIF image fimg, the digests of
+// the data objects are computed from their content.
+func NewSIF(fimg *sif.FileImage) (*SIF, error) {
+	s := &SIF{
+		ID:       fimg.Header.ID.String(),
+		Arch:     sif.GetGoArch(string(bytes.TrimRight(fimg.Header.Arch[:], "\x00"))),
+		Created:  time.Unix(fimg.Header.Ctime, 0).UTC(),
+		Modified: time.Unix(fimg.Header.Mtime, 0).UTC(),
+		Size:     fimg.Filesize,
+
+		Partitions: []Partition{},
+	}
+
+	for i := range fimg.DescrArr {
+		d := &fimg.DescrArr[i]
+		if !d.Used {
+			continue
+		}
+
+		o, err := newObject(fimg, d)
+		if err != nil {
+			return nil, fmt.Errorf("while computing digest of object %d: %v", d.ID, err)
+		}
+
+		switch d.Datatype {
+		case sif.DataPartition:
+			p := Partition{Object: o}
+			if fs, err := d.GetFsType(); err == nil {
+				p.Filesystem = FsTypeName(fs)
+			}
+			if pt, err := d.GetPartType(); err == nil {
+				p.Usage = PartTypeName(pt)
+			}
+			if arch, err := d.GetArch(); err == nil {
+				p.Arch = sif.GetGoArch(string(bytes.TrimRight(arch[:], "\x00")))
+			}
+			s.Partitions = append(s.Partitions, p)
+		case sif.DataSignature:
+			sig := Signature{Object: o}
+			if h, err := d.GetHashType(); err == nil {
+				sig.Hash = hashTypeName(h)
+			}
+			if e, err := d.GetEntityString(); err == nil {
+				sig.Entity = e
+			}
+			s.Signatures = append(s.Signatures, sig)
+		default:
+			s.Objects = append(s.Objects, o)
+		}
+	}
+	return s, nil
+}
+
+// Find returns the object of type typ named name, nil if none.
+func (s *SIF) Find(typ sif.Datatype, name string) *Object {
+	for i, o := range s.Objects {
+		if o.Type == typ.String() && o.Name == name {
+			return &s.Objects[i]
+		}
+	}
+	return nil
+}
+
+func newObject(fimg *sif.FileImage, d *sif.Descriptor) (Object, error) {
+	o := Object{
+		ID:   d.ID,
+		Type: d.Datatype.String(),
+		Name: d.GetName(),
+		Size: d.Filelen,
+	}
+	if mediaType, ok := artifact.MediaType(d); ok {
+		o.MediaType = mediaType
+	}
+	if d.Groupid != sif.DescrUnusedGroup {
+		o.Group = d.Groupid &^ sif.DescrGroupMask
+	}
+	if d.Link != sif.DescrUnusedLink {
+		if d.Link&sif.DescrGroupMask == sif.DescrGroupMask {
+			o.LinkedGroup = d.Link &^ sif.DescrGroupMask
+		} else {
+			o.LinkedObject = d.Link
+		}
+	}
+
+	dgst, err := digest.FromReader(io.NewSectionReader(fimg.Fp, d.Fileoff, d.Filelen))
+	if err != nil {
+		return o, err
+	}
+	o.Digest = dgst.String()
+	return o, nil
+}
+
+// FsTypeName returns the name of the SIF partition filesystem type fs.
+func FsTypeName(fs sif.Fstype) string {
+	switch fs {
+	case sif.FsSquash:
+		return "squashfs"
+	case sif.FsExt3:
+		return "ext3"
+	case sif.FsImmuObj:
+		return "archive"
+	case sif.FsRaw:
+		return "raw"
+	case sif.FsEncryptedSquashfs:
+		return "encrypted squashfs"
+	}
+	return "unknown"
+}
+
+// PartTypeName returns the name of the SIF partition type pt.
+func PartTypeName(pt sif.Parttype) string {
+	switch pt {
+	case sif.PartSystem:
+		return "system"
+	case sif.PartPrimSys:
+		return "primary system"
+	case sif.PartData:
+		return "data"
+	case sif.PartOverlay:
+		return "overlay"
+	}
+	return "unknown"
+}
+
+func hashTypeName(h sif.Hashtype) string {
+	switch h {
+	case sif.HashSHA256:
+		return "sha256"
+	case sif.HashSHA384:
+		return "sha384"
+	case sif.HashSHA512:
+		return "sha512"
+	case sif.HashBLAKE2S:
+		return "blake2s"
+	case sif.HashBLAKE2B:
+		return "blake2b"
+	}
+	return "unknown"
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package inspect
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+func TestNewSIF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspect-sif-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	part := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Size:     int64(len("rootfs")),
+		Fname:    "rootfs",
+		Fp:       strings.NewReader("rootfs"),
+	}
+	if err := part.SetPartExtra(sif.FsSquash, sif.PartPrimSys, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+		t.Fatal(err)
+	}
+	sbom := sif.DescriptorInput{
+		Datatype: sif.DataGenericJSON,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Size:     int64(len("{}")),
+		Fname:    "sbom.json",
+		Fp:       strings.NewReader("{}"),
+	}
+	sig := sif.DescriptorInput{
+		Datatype: sif.DataSignature,
+		Groupid:  sif.DescrUnusedGroup,
+		Link:     sif.DescrDefaultGroup,
+		Size:     int64(len("signature")),
+		Fp:       strings.NewReader("signature"),
+	}
+	fingerprint := "0123456789abcdef0123456789abcdef01234567"
+	if err := sig.SetSignExtra(sif.HashSHA256, fingerprint); err != nil {
+		t.Fatal(err)
+	}
+
+	id := uuid.NewV4()
+	path := filepath.Join(dir, "image.sif")
+	created, err := sif.CreateContainer(sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         id,
+		InputDescr: []sif.DescriptorInput{part, sbom, sig},
+	})
+	if err != nil {
+		t.Fatalf("while creating SIF image: %v", err)
+	}
+	created.UnloadContainer()
+
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		t.Fatalf("while loading SIF image: %v", err)
+	}
+	defer fimg.UnloadContainer()
+
+	s, err := NewSIF(&fimg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if s.ID != id.String() || s.Arch != runtime.GOARCH {
+		t.Errorf("unexpected image %s of architecture %s", s.ID, s.Arch)
+	}
+	if len(s.Partitions) != 1 || len(s.Signatures) != 1 || len(s.Objects) != 1 {
+		t.Fatalf("unexpected objects: %+v", s)
+	}
+
+	p := s.Partitions[0]
+	if p.ID != 1 || p.Group != 1 || p.Filesystem != "squashfs" || p.Usage != "primary system" || p.Arch != runtime.GOARCH {
+		t.Errorf("unexpected partition: %+v", p)
+	}
+	if p.Size != int64(len("rootfs")) || p.Digest != digest.FromString("rootfs").String() {
+		t.Errorf("unexpected partition size %d and digest %s", p.Size, p.Digest)
+	}
+
+	if sig := s.Signatures[0]; sig.LinkedGroup != 1 || sig.Hash != "sha256" || !strings.EqualFold(sig.Entity, fingerprint) {
+		t.Errorf("unexpected signature: %+v", sig)
+	}
+
+	if o := s.Find(sif.DataGenericJSON, "sbom.json"); o == nil || o.ID != 2 {
+		t.Errorf("unexpected SBOM object: %+v", o)
+	}
+	if o := s.Find(sif.DataGeneric, "sbom.json"); o != nil {
+		t.Errorf("unexpected object found: %+v", o)
+	}
+}
@@ -62,6 +62,76 @@ type PortMapEntry struct {
 	HostIP        string `json:"hostIP,omitempty"`
 }
 
+// String returns the port mapping as parsed by ParsePortMapping.
+func (e PortMapEntry) String() string {
+	s := fmt.Sprintf("%d:%d/%s", e.HostPort, e.ContainerPort, e.Protocol)
+	if e.HostIP == "" {
+		return s
+	}
+	if strings.Contains(e.HostIP, ":") {
+		return fmt.Sprintf("[%s]:%s", e.HostIP, s)
+	}
+	return e.HostIP + ":" + s
+}
+
+// ParsePortMapping parses a port mapping of the form
+// [hostIP:]hostPort[:containerPort][/protocol], an IPv6 host IP is
+// enclosed in brackets, the container port defaults to the host port
+// and the protocol (tcp or udp) to tcp.
+func ParsePortMapping(spec string) (PortMapEntry, error) {
+	pm := PortMapEntry{Protocol: "tcp"}
+
+	ports := spec
+	if i := strings.LastIndex(spec, "/"); i >= 0 {
+		ports, pm.Protocol = spec[:i], spec[i+1:]
+		if pm.Protocol != "tcp" && pm.Protocol != "udp" {
+			return pm, fmt.Errorf("only tcp and udp protocol can be specified")
+		}
+	}
+
+	if strings.HasPrefix(ports, "[") {
+		i := strings.Index(ports, "]:")
+		if i < 0 {
+			return pm, fmt.Errorf("badly formatted port mapping '%s', IPv6 host IP must be of form [IP]:hostPort", spec)
+		}
+		pm.HostIP, ports = ports[1:i], ports[i+2:]
+		if ip := net.ParseIP(pm.HostIP); ip == nil || ip.To4() != nil {
+			return pm, fmt.Errorf("invalid IPv6 host IP '%s'", pm.HostIP)
+		}
+	} else if splitted := strings.Split(ports, ":"); len(splitted) == 3 {
+		pm.HostIP, ports = splitted[0], strings.Join(splitted[1:], ":")
+		if ip := net.ParseIP(pm.HostIP); ip == nil || ip.To4() == nil {
+			return pm, fmt.Errorf("invalid IPv4 host IP '%s'", pm.HostIP)
+		}
+	}
+
+	splitted := strings.Split(ports, ":")
+	if len(splitted) != 1 && len(splitted) != 2 {
+		return pm, fmt.Errorf("portmap port argument is badly formatted")
+	}
+	if n, err := strconv.ParseUint(splitted[0], 0, 16); err == nil {
+		pm.HostPort = int(n)
+		if pm.HostPort <= 0 || pm.HostPort > 65535 {
+			return pm, fmt.Errorf("host port must be greater than 0 and less than 65535")
+		}
+	} else {
+		return pm, fmt.Errorf("can't convert host port '%s': %s", splitted[0], err)
+	}
+	if len(splitted) == 2 {
+		if n, err := strconv.ParseUint(splitted[1], 0, 16); err == nil {
+			pm.ContainerPort = int(n)
+			if pm.ContainerPort <= 0 || pm.ContainerPort > 65535 {
+				return pm, fmt.Errorf("container port must be greater than 0 and less than 65535")
+			}
+		} else {
+			return pm, fmt.Errorf("can't convert container port '%s': %s", splitted[1], err)
+		}
+	} else {
+		pm.ContainerPort = pm.HostPort
+	}
+	return pm, nil
+}
+
 // GetAllNetworkConfigList lists configured networks in configuration path directory
 // provided by cniPath
 func GetAllNetworkConfigList(cniPath *CNIPath) ([]*libcni.NetworkConfigList, error) {
@@ -269,41 +339,14 @@ func (m *Setup) SetArgs(args []string) error {
 			key := kv[0]
 			value := kv[1]
 			if key == "portmap" {
-				pm := &PortMapEntry{}
-
-				splittedPort := strings.SplitN(value, "/", 2)
-				if len(splittedPort) != 2 {
+				if !strings.Contains(value, "/") {
 					return fmt.Errorf("badly formatted portmap argument '%s', must be of form portmap=hostPort:containerPort/protocol", value)
 				}
-				pm.Protocol = splittedPort[1]
-				if pm.Protocol != "tcp" && pm.Protocol != "udp" {
-					return fmt.Errorf("only tcp and udp protocol can be specified")
-				}
-				ports := strings.Split(splittedPort[0], ":")
-				if len(ports) != 1 && len(ports) != 2 {
-					return fmt.Errorf("portmap port argument is badly formatted")
-				}
-				if n, err := strconv.ParseUint(ports[0], 0, 16); err == nil {
-					pm.HostPort = int(n)
-					if pm.HostPort <= 0 || pm.HostPort > 65535 {
-						return fmt.Errorf("host port must be greater than 0 and less than 65535")
-					}
-				} else {
-					return fmt.Errorf("can't convert host port '%s': %s", ports[0], err)
-				}
-				if len(ports) == 2 {
-					if n, err := strconv.ParseUint(ports[1], 0, 16); err == nil {
-						pm.ContainerPort = int(n)
-						if pm.ContainerPort <= 0 || pm.ContainerPort > 65535 {
-							return fmt.Errorf("container port must be greater than 0 and less than 65535")
-						}
-					} else {
-						return fmt.Errorf("can't convert container port '%s': %s", ports[1], err)
-					}
-				} else {
-					pm.ContainerPort = pm.HostPort
+				pm, err := ParsePortMapping(value)
+				if err != nil {
+					return err
 				}
-				if err := m.SetCapability(networkName, "portMappings", *pm); err != nil {
+				if err := m.SetCapability(networkName, "portMappings", pm); err != nil {
 					return err
 				}
 			} else if key == "ipRange" {
@@ -399,7 +442,24 @@ func (m *Setup) SetPortProtection(network string, lowPort int) error {
 			sockProt = unix.IPPROTO_UDP
 			sockType = unix.SOCK_DGRAM
 		}
-		fd, err := unix.Socket(unix.AF_INET, sockType, sockProt)
+		family := unix.AF_INET
+		var sockAddr unix.Sockaddr = &unix.SockaddrInet4{
+			Port: e.HostPort,
+		}
+		// the port is bound on the host IP only if set
+		if ip := net.ParseIP(e.HostIP); ip != nil {
+			if ip4 := ip.To4(); ip4 != nil {
+				addr := &unix.SockaddrInet4{Port: e.HostPort}
+				copy(addr.Addr[:], ip4)
+				sockAddr = addr
+			} else {
+				family = unix.AF_INET6
+				addr := &unix.SockaddrInet6{Port: e.HostPort}
+				copy(addr.Addr[:], ip.To16())
+				sockAddr = addr
+			}
+		}
+		fd, err := unix.Socket(family, sockType, sockProt)
 		if err != nil {
 			return fmt.Errorf("failed to create %s socket on port %d: %s", e.Protocol, e.HostPort, err)
 		}
@@ -407,9 +467,6 @@ func (m *Setup) SetPortProtection(network string, lowPort int) error {
 		if err != nil {
 			return fmt.Errorf("failed to set reuseport for %s socket on port %d: %s", e.Protocol, e.HostPort, err)
 		}
-		sockAddr := &unix.SockaddrInet4{
-			Port: e.HostPort,
-		}
 		err = unix.Bind(fd, sockAddr)
 		if err != nil {
 			return fmt.Errorf("failed to bind %s socket on port %d: %s", e.Protocol, e.HostPort, err)
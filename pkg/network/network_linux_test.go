@@ -254,6 +254,16 @@ func testSetArgs(setup *Setup, t *testing.T) {
 			args:    []string{"test-bridge:portmap=65550/tcp"},
 			success: false,
 		},
+		{
+			desc:    "good portmap host IP",
+			args:    []string{"test-bridge:portmap=127.0.0.1:8080:80/tcp", "portmap=[::1]:8080:80/tcp"},
+			success: true,
+		},
+		{
+			desc:    "portmap without protocol",
+			args:    []string{"test-bridge:portmap=80:80"},
+			success: false,
+		},
 		{
 			desc:    "ipRange not supported arg",
 			args:    []string{"test-bridge:ipRange=10.1.1.0/16"},
@@ -290,6 +300,42 @@ func testSetArgs(setup *Setup, t *testing.T) {
 	}
 }
 
+func TestParsePortMapping(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    PortMapEntry
+		success bool
+	}{
+		{spec: "8080", want: PortMapEntry{HostPort: 8080, ContainerPort: 8080, Protocol: "tcp"}, success: true},
+		{spec: "8080:80/udp", want: PortMapEntry{HostPort: 8080, ContainerPort: 80, Protocol: "udp"}, success: true},
+		{spec: "127.0.0.1:8080:80", want: PortMapEntry{HostPort: 8080, ContainerPort: 80, Protocol: "tcp", HostIP: "127.0.0.1"}, success: true},
+		{spec: "[::1]:8080:80/tcp", want: PortMapEntry{HostPort: 8080, ContainerPort: 80, Protocol: "tcp", HostIP: "::1"}, success: true},
+		{spec: "[::1]:8080", want: PortMapEntry{HostPort: 8080, ContainerPort: 8080, Protocol: "tcp", HostIP: "::1"}, success: true},
+		{spec: "", success: false},
+		{spec: "0:80", success: false},
+		{spec: "8080:70000", success: false},
+		{spec: "8080:80/icmp", success: false},
+		{spec: "localhost:8080:80", success: false},
+		{spec: "[127.0.0.1]:8080:80", success: false},
+		{spec: "[::1:8080:80", success: false},
+		{spec: "1:2:3:4", success: false},
+	}
+	for _, tt := range tests {
+		pm, err := ParsePortMapping(tt.spec)
+		if err != nil && tt.success {
+			t.Errorf("unexpected failure for %q: %s", tt.spec, err)
+		} else if err == nil && !tt.success {
+			t.Errorf("unexpected success for %q", tt.spec)
+		} else if err == nil && pm != tt.want {
+			t.Errorf("unexpected port mapping for %q: got %+v, want %+v", tt.spec, pm, tt.want)
+		} else if err == nil {
+			if back, err := ParsePortMapping(pm.String()); err != nil || back != pm {
+				t.Errorf("port mapping %q doesn't parse back to %+v", pm.String(), pm)
+			}
+		}
+	}
+}
+
 func TestNewSetup(t *testing.T) {
 	test.EnsurePrivilege(t)
 
@@ -0,0 +1,71 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package build
+
+import (
+	"context"
+
+	"github.com/sylabs/singularity/pkg/build/types"
+	"github.com/sylabs/singularity/pkg/util/crypt"
+)
+
+// Stage describes the build stage passed to the build callbacks.
+type Stage struct {
+	// Name is the stage name, it's empty for single stage builds.
+	Name string
+	// RootfsPath is the path of the stage root filesystem.
+	RootfsPath string
+	// Definition is the stage definition.
+	Definition types.Definition
+}
+
+// PreBootstrap callback is called before the root filesystem of
+// each stage is bootstrapped, the root filesystem is empty. An error
+// returned by the callback fails the build.
+// This callback is called in:
+// - internal/pkg/build/build.go
+type PreBootstrap func(ctx context.Context, stage Stage) error
+
+// PostPost callback is called once the %post section of each stage
+// ran, even if the stage sections were restored with --cache-sections.
+// An error returned by the callback fails the build.
+// This callback is called in:
+// - internal/pkg/build/build.go
+type PostPost func(ctx context.Context, stage Stage) error
+
+// PreSquashfs callback is called before the root filesystem of the
+// last stage is compressed into the SIF image, once the %test section
+// ran. It's the place to scan or stamp the final root filesystem, an
+// error returned by the callback fails the build.
+// This callback is called in:
+// - internal/pkg/build/build.go
+type PreSquashfs func(ctx context.Context, stage Stage) error
+
+// PostBuild callback is called once the image of the last stage was
+// written to its destination dest. An error returned by the callback
+// fails the build, the image is left at its destination.
+// This callback is called in:
+// - internal/pkg/build/build.go
+type PostBuild func(ctx context.Context, stage Stage, dest string) error
+
+// ZypperRegister callback is called by the zypper bootstrap agent once
+// the repositories and services of the definition are added and the
+// product registered with SUSEConnect, before the packages install.
+// It's the place to register the root filesystem with a site SCC, RMT
+// or SMT service or to add the repositories requiring credentials, an
+// error returned by the callback fails the build.
+// This callback is called in:
+// - internal/pkg/build/sources/conveyorPacker_zypper.go
+type ZypperRegister func(ctx context.Context, stage Stage) error
+
+// KMSKeyProvider callback returns the key provider of the build
+// --encryption-key kms:<service>:<key> recipients, the volume key of the
+// image is wrapped by the key management service. The callback returns
+// a nil provider for the services it doesn't handle, an error returned by
+// the callback fails the build.
+// This callback is called in:
+// - cmd/internal/cli/build_linux.go
+type KMSKeyProvider func(ctx context.Context, service, key string) (crypt.KeyProvider, error)
@@ -22,3 +22,9 @@ type Command func(*cmdline.CommandManager)
 // allows plugins to modify/alter runtime engine configuration. This
 // is the place to inject custom binds.
 type SingularityEngineConfig func(*config.Common)
+
+// LogHook callback allows to receive messages written by the logger.
+// This callback is called in cmd/internal/cli/singularity.go and
+// allows plugins to mirror log messages into their own sinks, the
+// callback must not call sylog functions.
+type LogHook func(level int, msg string, fields map[string]interface{})
@@ -10,7 +10,9 @@ import (
 	"os/exec"
 	"regexp"
 	"strings"
+	"time"
 
+	specs "github.com/opencontainers/runtime-spec/specs-go"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engine/config/oci"
 	"github.com/sylabs/singularity/pkg/image"
 	"github.com/sylabs/singularity/pkg/util/singularityconf"
@@ -19,6 +21,18 @@ import (
 // Name is the name of the runtime.
 const Name = "singularity"
 
+// ContainerNetworkPrefix is the prefix of the network 'container:<name>'
+// sharing the network namespace of the instance name.
+const ContainerNetworkPrefix = "container:"
+
+// CommitDirPrefix is the name prefix of the temporary directory holding
+// the writable tmpfs layers saved by --commit.
+const CommitDirPrefix = "commit-"
+
+// DefaultTimeoutGrace is the grace period between SIGTERM and SIGKILL of
+// a --timeout deadline without explicit grace period.
+const DefaultTimeoutGrace = 10 * time.Second
+
 const (
 	// DefaultLayer is the string representation for the default layer.
 	DefaultLayer string = "none"
@@ -57,6 +71,7 @@ type FuseMount struct {
 	Fd            int       `json:"fd,omitempty"`            // /dev/fuse file descriptor
 	FromContainer bool      `json:"fromContainer,omitempty"` // is FUSE driver program is run from container or from host
 	Daemon        bool      `json:"daemon,omitempty"`        // is FUSE driver program is run in daemon/background mode
+	Driver        string    `json:"driver,omitempty"`        // the built-in FUSE driver running the FUSE driver program, if any
 	Cmd           *exec.Cmd `json:"-"`                       // holds the process exec command when FUSE driver run in foreground mode
 }
 
@@ -100,6 +115,12 @@ func (b *BindPath) Readonly() bool {
 	return b.Options != nil && b.Options["ro"] != nil
 }
 
+// ImageFile returns the option file was set or not, the
+// image file source is bound instead of its file system.
+func (b *BindPath) ImageFile() bool {
+	return b.Options != nil && b.Options["file"] != nil
+}
+
 // JSONConfig stores engine specific confguration that is allowed to be set by the user.
 type JSONConfig struct {
 	ScratchDir        []string          `json:"scratchdir,omitempty"`
@@ -107,7 +128,14 @@ type JSONConfig struct {
 	NetworkArgs       []string          `json:"networkArgs,omitempty"`
 	Security          []string          `json:"security,omitempty"`
 	FilesPath         []string          `json:"filesPath,omitempty"`
+	Devices           []string          `json:"devices,omitempty"`
+	NvEnv             []string          `json:"nvEnv,omitempty"`
+	NvCCLIEnv         []string          `json:"nvCCLIEnv,omitempty"`
+	RocmEnv           []string          `json:"rocmEnv,omitempty"`
 	LibrariesPath     []string          `json:"librariesPath,omitempty"`
+	AuditRecords      []string          `json:"auditRecords,omitempty"`
+	AddHosts          []string          `json:"addHosts,omitempty"`
+	JoinNamespaces    []string          `json:"joinNamespaces,omitempty"`
 	FuseMount         []FuseMount       `json:"fuseMount,omitempty"`
 	ImageList         []image.Image     `json:"imageList,omitempty"`
 	BindPath          []BindPath        `json:"bindpath,omitempty"`
@@ -115,7 +143,9 @@ type JSONConfig struct {
 	UnixSocketPair    [2]int            `json:"unixSocketPair,omitempty"`
 	OpenFd            []int             `json:"openFd,omitempty"`
 	TargetGID         []int             `json:"targetGID,omitempty"`
+	AddGroups         []int             `json:"addGroups,omitempty"`
 	Image             string            `json:"image"`
+	ImageURI          string            `json:"imageURI,omitempty"`
 	Workdir           string            `json:"workdir,omitempty"`
 	CgroupsPath       string            `json:"cgroupsPath,omitempty"`
 	HomeSource        string            `json:"homedir,omitempty"`
@@ -128,15 +158,23 @@ type JSONConfig struct {
 	Hostname          string            `json:"hostname,omitempty"`
 	Network           string            `json:"network,omitempty"`
 	DNS               string            `json:"dns,omitempty"`
+	DNSSearch         string            `json:"dnsSearch,omitempty"`
 	Cwd               string            `json:"cwd,omitempty"`
 	SessionLayer      string            `json:"sessionLayer,omitempty"`
 	ConfigurationFile string            `json:"configurationFile,omitempty"`
+	AuditLog          string            `json:"auditLog,omitempty"`
+	CommitImage       string            `json:"commitImage,omitempty"`
+	CommitDir         string            `json:"commitDir,omitempty"`
+	CommitFds         [2]int            `json:"commitFds,omitempty"`
 	EncryptionKey     []byte            `json:"encryptionKey,omitempty"`
+	OverlayKey        []byte            `json:"overlayKey,omitempty"`
 	TargetUID         int               `json:"targetUID,omitempty"`
+	JoinPid           int               `json:"joinPid,omitempty"`
 	WritableImage     bool              `json:"writableImage,omitempty"`
 	WritableTmpfs     bool              `json:"writableTmpfs,omitempty"`
 	Contain           bool              `json:"container,omitempty"`
 	Nv                bool              `json:"nv,omitempty"`
+	NvCCLI            bool              `json:"nvCCLI,omitempty"`
 	Rocm              bool              `json:"rocm,omitempty"`
 	CustomHome        bool              `json:"customHome,omitempty"`
 	Instance          bool              `json:"instance,omitempty"`
@@ -151,6 +189,25 @@ type JSONConfig struct {
 	DeleteImage       bool              `json:"deleteImage,omitempty"`
 	Fakeroot          bool              `json:"fakeroot,omitempty"`
 	SignalPropagation bool              `json:"signalPropagation,omitempty"`
+	VerifyImage       bool              `json:"verifyImage,omitempty"`
+	ContainerUser     bool              `json:"containerUser,omitempty"`
+	NoSupplementary   bool              `json:"noSupplementary,omitempty"`
+	Pty               bool              `json:"pty,omitempty"`
+	NoPty             bool              `json:"noPty,omitempty"`
+	Interactive       bool              `json:"interactive,omitempty"`
+
+	// Umask is the umask of the container process, the umask set by
+	// the starter applies if nil.
+	Umask *int `json:"umask,omitempty"`
+
+	// Timeout is the deadline of the container process, it's sent SIGTERM
+	// once expired and SIGKILL after TimeoutGrace.
+	Timeout      time.Duration `json:"timeout,omitempty"`
+	TimeoutGrace time.Duration `json:"timeoutGrace,omitempty"`
+
+	// CgroupsResources are the resources restrictions of the resource
+	// limit flags, they override those of the CgroupsPath profile.
+	CgroupsResources *specs.LinuxResources `json:"cgroupsResources,omitempty"`
 }
 
 // SetImage sets the container image path to be used by EngineConfig.JSON.
@@ -163,6 +220,28 @@ func (e *EngineConfig) GetImage() string {
 	return e.JSON.Image
 }
 
+// SetImageURI sets the URI the container image was pulled from.
+func (e *EngineConfig) SetImageURI(uri string) {
+	e.JSON.ImageURI = uri
+}
+
+// GetImageURI retrieves the URI the container image was pulled from.
+func (e *EngineConfig) GetImageURI() string {
+	return e.JSON.ImageURI
+}
+
+// SetVerifyImage sets if the container image signature must be verified
+// before running it.
+func (e *EngineConfig) SetVerifyImage(verify bool) {
+	e.JSON.VerifyImage = verify
+}
+
+// GetVerifyImage returns if the container image signature must be verified
+// before running it.
+func (e *EngineConfig) GetVerifyImage() bool {
+	return e.JSON.VerifyImage
+}
+
 // SetKey sets the key for the image's system partition.
 func (e *EngineConfig) SetEncryptionKey(key []byte) {
 	e.JSON.EncryptionKey = key
@@ -173,6 +252,16 @@ func (e *EngineConfig) GetEncryptionKey() []byte {
 	return e.JSON.EncryptionKey
 }
 
+// SetOverlayEncryptionKey sets the passphrase of the encrypted overlays.
+func (e *EngineConfig) SetOverlayEncryptionKey(key []byte) {
+	e.JSON.OverlayKey = key
+}
+
+// GetOverlayEncryptionKey retrieves the passphrase of the encrypted overlays.
+func (e *EngineConfig) GetOverlayEncryptionKey() []byte {
+	return e.JSON.OverlayKey
+}
+
 // SetWritableImage defines the container image as writable or not.
 func (e *EngineConfig) SetWritableImage(writable bool) {
 	e.JSON.WritableImage = writable
@@ -213,6 +302,41 @@ func (e *EngineConfig) GetNv() bool {
 	return e.JSON.Nv
 }
 
+// SetNvEnv sets the NVIDIA_VISIBLE_DEVICES environment variable selecting
+// the GPUs and MIG instances bound into container.
+func (e *EngineConfig) SetNvEnv(env []string) {
+	e.JSON.NvEnv = env
+}
+
+// GetNvEnv returns the NVIDIA_VISIBLE_DEVICES environment variable
+// selecting the GPUs and MIG instances bound into container.
+func (e *EngineConfig) GetNvEnv() []string {
+	return e.JSON.NvEnv
+}
+
+// SetNvCCLI sets nvccli flag to set up the GPUs with nvidia-container-cli
+// instead of binding the cuda libraries into container.
+func (e *EngineConfig) SetNvCCLI(nvCCLI bool) {
+	e.JSON.NvCCLI = nvCCLI
+}
+
+// GetNvCCLI returns if nvccli flag is set or not.
+func (e *EngineConfig) GetNvCCLI() bool {
+	return e.JSON.NvCCLI
+}
+
+// SetNvCCLIEnv sets the NVIDIA_* environment variables selecting the GPUs
+// and driver capabilities set up by nvidia-container-cli.
+func (e *EngineConfig) SetNvCCLIEnv(env []string) {
+	e.JSON.NvCCLIEnv = env
+}
+
+// GetNvCCLIEnv returns the NVIDIA_* environment variables selecting the
+// GPUs and driver capabilities set up by nvidia-container-cli.
+func (e *EngineConfig) GetNvCCLIEnv() []string {
+	return e.JSON.NvCCLIEnv
+}
+
 // SetRocm sets rocm flag to bind rocm libraries into containee.JSON.
 func (e *EngineConfig) SetRocm(rocm bool) {
 	e.JSON.Rocm = rocm
@@ -223,6 +347,18 @@ func (e *EngineConfig) GetRocm() bool {
 	return e.JSON.Rocm
 }
 
+// SetRocmEnv sets the ROCR_VISIBLE_DEVICES and HIP_VISIBLE_DEVICES
+// environment variables selecting the AMD GPUs bound into container.
+func (e *EngineConfig) SetRocmEnv(env []string) {
+	e.JSON.RocmEnv = env
+}
+
+// GetRocmEnv returns the ROCR_VISIBLE_DEVICES and HIP_VISIBLE_DEVICES
+// environment variables selecting the AMD GPUs bound into container.
+func (e *EngineConfig) GetRocmEnv() []string {
+	return e.JSON.RocmEnv
+}
+
 // SetWorkdir sets a work directory path.
 func (e *EngineConfig) SetWorkdir(name string) {
 	e.JSON.Workdir = name
@@ -283,6 +419,7 @@ func ParseBindPath(bindpaths string) ([]BindPath, error) {
 	var validOptions = map[string]bool{
 		"ro":        true,
 		"rw":        true,
+		"file":      true,
 		"image-src": false,
 		"id":        false,
 	}
@@ -578,6 +715,34 @@ func (e *EngineConfig) GetNetworkArgs() []string {
 	return e.JSON.NetworkArgs
 }
 
+// GetNetworkContainer returns the name of the instance sharing its network
+// namespace with the 'container:<name>' network, or an empty string.
+func (e *EngineConfig) GetNetworkContainer() string {
+	if !strings.HasPrefix(e.JSON.Network, ContainerNetworkPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(e.JSON.Network, ContainerNetworkPrefix)
+}
+
+// SetJoin sets the process pid whose namespaces (net, ipc, uts or pid)
+// are joined by the container.
+func (e *EngineConfig) SetJoin(pid int, namespaces []string) {
+	e.JSON.JoinPid = pid
+	e.JSON.JoinNamespaces = namespaces
+}
+
+// GetJoinPid returns the process whose namespaces are joined by the
+// container, or zero.
+func (e *EngineConfig) GetJoinPid() int {
+	return e.JSON.JoinPid
+}
+
+// GetJoinNamespaces returns the namespaces of the process GetJoinPid
+// joined by the container.
+func (e *EngineConfig) GetJoinNamespaces() []string {
+	return e.JSON.JoinNamespaces
+}
+
 // SetDNS sets a commas separated list of DNS servers to add in resolv.conf.
 func (e *EngineConfig) SetDNS(dns string) {
 	e.JSON.DNS = dns
@@ -588,6 +753,27 @@ func (e *EngineConfig) GetDNS() string {
 	return e.JSON.DNS
 }
 
+// SetDNSSearch sets a commas separated list of search domains replacing
+// those of resolv.conf.
+func (e *EngineConfig) SetDNSSearch(search string) {
+	e.JSON.DNSSearch = search
+}
+
+// GetDNSSearch retrieves list of search domains.
+func (e *EngineConfig) GetDNSSearch() string {
+	return e.JSON.DNSSearch
+}
+
+// SetAddHosts sets the <name>:<IP> entries to add in /etc/hosts.
+func (e *EngineConfig) SetAddHosts(hosts []string) {
+	e.JSON.AddHosts = hosts
+}
+
+// GetAddHosts retrieves the <name>:<IP> entries to add in /etc/hosts.
+func (e *EngineConfig) GetAddHosts() []string {
+	return e.JSON.AddHosts
+}
+
 // SetImageList sets image list containing opened images.
 func (e *EngineConfig) SetImageList(list []image.Image) {
 	e.JSON.ImageList = list
@@ -648,6 +834,18 @@ func (e *EngineConfig) GetCgroupsPath() string {
 	return e.JSON.CgroupsPath
 }
 
+// SetCgroupsResources sets the resources restrictions overriding those of
+// the cgroups profile.
+func (e *EngineConfig) SetCgroupsResources(resources *specs.LinuxResources) {
+	e.JSON.CgroupsResources = resources
+}
+
+// GetCgroupsResources returns the resources restrictions overriding those
+// of the cgroups profile.
+func (e *EngineConfig) GetCgroupsResources() *specs.LinuxResources {
+	return e.JSON.CgroupsResources
+}
+
 // SetTargetUID sets target UID to execute the container process as user ID.
 func (e *EngineConfig) SetTargetUID(uid int) {
 	e.JSON.TargetUID = uid
@@ -668,6 +866,109 @@ func (e *EngineConfig) GetTargetGID() []int {
 	return e.JSON.TargetGID
 }
 
+// SetContainerUser sets if the target UID is an account of the container
+// passwd file, the container passwd and group files are left unchanged.
+func (e *EngineConfig) SetContainerUser(containerUser bool) {
+	e.JSON.ContainerUser = containerUser
+}
+
+// GetContainerUser returns if the target UID is an account of the
+// container passwd file.
+func (e *EngineConfig) GetContainerUser() bool {
+	return e.JSON.ContainerUser
+}
+
+// SetAddGroups sets the group IDs added to the supplementary groups of
+// the container process.
+func (e *EngineConfig) SetAddGroups(gids []int) {
+	e.JSON.AddGroups = gids
+}
+
+// GetAddGroups returns the group IDs added to the supplementary groups of
+// the container process.
+func (e *EngineConfig) GetAddGroups() []int {
+	return e.JSON.AddGroups
+}
+
+// SetNoSupplementary sets if the supplementary groups of the container
+// process are dropped, only the groups added by SetAddGroups are kept.
+func (e *EngineConfig) SetNoSupplementary(noSupplementary bool) {
+	e.JSON.NoSupplementary = noSupplementary
+}
+
+// GetNoSupplementary returns if the supplementary groups of the container
+// process are dropped.
+func (e *EngineConfig) GetNoSupplementary() bool {
+	return e.JSON.NoSupplementary
+}
+
+// SetUmask sets the umask of the container process.
+func (e *EngineConfig) SetUmask(mask int) {
+	e.JSON.Umask = &mask
+}
+
+// GetUmask returns the umask of the container process, or -1 if not set.
+func (e *EngineConfig) GetUmask() int {
+	if e.JSON.Umask == nil {
+		return -1
+	}
+	return *e.JSON.Umask
+}
+
+// SetPty sets if the container process runs in a pseudo terminal
+// allocated by the engine, whether the host streams are terminals
+// or not.
+func (e *EngineConfig) SetPty(pty bool) {
+	e.JSON.Pty = pty
+}
+
+// GetPty returns if the container process runs in a pseudo terminal.
+func (e *EngineConfig) GetPty() bool {
+	return e.JSON.Pty
+}
+
+// SetNoPty sets if the container process runs without controlling
+// terminal, the host streams which are terminals are relayed by pipes.
+func (e *EngineConfig) SetNoPty(noPty bool) {
+	e.JSON.NoPty = noPty
+}
+
+// GetNoPty returns if the container process runs without terminal.
+func (e *EngineConfig) GetNoPty() bool {
+	return e.JSON.NoPty
+}
+
+// SetInteractive sets if the standard input is relayed to the container
+// process run with SetPty or SetNoPty, it reads /dev/null otherwise.
+func (e *EngineConfig) SetInteractive(interactive bool) {
+	e.JSON.Interactive = interactive
+}
+
+// GetInteractive returns if the standard input is relayed to the
+// container process run with SetPty or SetNoPty.
+func (e *EngineConfig) GetInteractive() bool {
+	return e.JSON.Interactive
+}
+
+// SetTimeout sets the deadline of the container process, it's sent
+// SIGTERM once expired and SIGKILL after the grace period, or SIGKILL
+// right away without grace period.
+func (e *EngineConfig) SetTimeout(timeout, grace time.Duration) {
+	e.JSON.Timeout = timeout
+	e.JSON.TimeoutGrace = grace
+}
+
+// GetTimeout returns the deadline of the container process, 0 if none.
+func (e *EngineConfig) GetTimeout() time.Duration {
+	return e.JSON.Timeout
+}
+
+// GetTimeoutGrace returns the grace period between SIGTERM and SIGKILL
+// once the container process deadline expired.
+func (e *EngineConfig) GetTimeoutGrace() time.Duration {
+	return e.JSON.TimeoutGrace
+}
+
 // SetLibrariesPath sets libraries to bind in container
 // /.singularity.d/libs directory.
 func (e *EngineConfig) SetLibrariesPath(libraries []string) {
@@ -701,6 +1002,16 @@ func (e *EngineConfig) GetFilesPath() []string {
 	return e.JSON.FilesPath
 }
 
+// SetDevices sets host devices to bind in container (eg: --device).
+func (e *EngineConfig) SetDevices(devices []string) {
+	e.JSON.Devices = devices
+}
+
+// GetDevices returns host devices to bind in container (eg: --device).
+func (e *EngineConfig) GetDevices() []string {
+	return e.JSON.Devices
+}
+
 // SetFakeroot sets fakeroot flag.
 func (e *EngineConfig) SetFakeroot(fakeroot bool) {
 	e.JSON.Fakeroot = fakeroot
@@ -721,6 +1032,38 @@ func (e *EngineConfig) SetDeleteImage(delete bool) {
 	e.JSON.DeleteImage = delete
 }
 
+// SetCommitImage sets the squashfs overlay image path where the writable
+// tmpfs upper layer is saved when the container exits, and the host
+// directory dir used as writable tmpfs in place of the session tmpfs.
+func (e *EngineConfig) SetCommitImage(path, dir string) {
+	e.JSON.CommitImage = path
+	e.JSON.CommitDir = dir
+}
+
+// GetCommitImage returns the squashfs overlay image path where the
+// writable tmpfs upper layer is saved when the container exits.
+func (e *EngineConfig) GetCommitImage() string {
+	return e.JSON.CommitImage
+}
+
+// GetCommitDir returns the host directory used as writable tmpfs when
+// its upper layer is saved when the container exits.
+func (e *EngineConfig) GetCommitDir() string {
+	return e.JSON.CommitDir
+}
+
+// SetCommitFds sets the file descriptors of the upper and work
+// directories of the commit directory, opened during stage 1.
+func (e *EngineConfig) SetCommitFds(fds [2]int) {
+	e.JSON.CommitFds = fds
+}
+
+// GetCommitFds returns the file descriptors of the upper and work
+// directories of the commit directory.
+func (e *EngineConfig) GetCommitFds() [2]int {
+	return e.JSON.CommitFds
+}
+
 // SetSignalPropagation sets if engine must propagate signals from
 // master process -> container process when PID namespace is disabled
 // or from master process -> sinit process -> container
@@ -753,6 +1096,10 @@ func (e *EngineConfig) SetFuseMount(mount []string) error {
 	e.JSON.FuseMount = make([]FuseMount, len(mount))
 
 	for i, mountspec := range mount {
+		mountspec, err := e.expandFuseProfile(mountspec)
+		if err != nil {
+			return err
+		}
 		words := strings.Fields(mountspec)
 
 		if len(words) == 0 {
@@ -769,6 +1116,14 @@ func (e *EngineConfig) SetFuseMount(mount []string) error {
 		e.JSON.FuseMount[i].MountPoint = words[len(words)-1]
 		e.JSON.FuseMount[i].Program = words[0 : len(words)-1]
 
+		// built-in drivers are run from host in foreground
+		if d, ok := fuseDrivers[prefix]; ok {
+			if err := e.JSON.FuseMount[i].setFuseDriver(prefix, d, words); err != nil {
+				return err
+			}
+			continue
+		}
+
 		switch prefix {
 		case "container":
 			e.JSON.FuseMount[i].FromContainer = true
@@ -829,3 +1184,25 @@ func (e *EngineConfig) SetConfigurationFile(filename string) {
 func (e *EngineConfig) GetConfigurationFile() string {
 	return e.JSON.ConfigurationFile
 }
+
+// SetAuditLog sets the audit log destination used by the master
+// process, it's set by stage 1 from the configuration file.
+func (e *EngineConfig) SetAuditLog(dest string) {
+	e.JSON.AuditLog = dest
+}
+
+// GetAuditLog returns the audit log destination.
+func (e *EngineConfig) GetAuditLog() string {
+	return e.JSON.AuditLog
+}
+
+// SetAuditRecords sets the audit records of stage 1 which runs
+// without privileges in the setuid workflow and can't write them.
+func (e *EngineConfig) SetAuditRecords(records []string) {
+	e.JSON.AuditRecords = records
+}
+
+// GetAuditRecords returns the audit records left by stage 1.
+func (e *EngineConfig) GetAuditRecords() []string {
+	return e.JSON.AuditRecords
+}
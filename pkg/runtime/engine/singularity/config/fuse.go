@@ -0,0 +1,120 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sylabs/singularity/pkg/image"
+)
+
+// FuseProfilePrefix is the --fusemount prefix of the FUSE mount profiles
+// defined by the 'fusemount profile' directives of singularity.conf.
+const FuseProfilePrefix = "profile"
+
+// fuseDriver is a built-in FUSE driver, a FUSE program run from host to
+// mount the source of a --fusemount specification of the form
+// '<driver>:<source> [options] <mountpoint>'.
+type fuseDriver struct {
+	// program is the FUSE driver program.
+	program string
+	// foreground are the options running program in foreground, they
+	// precede the source as some programs stop parsing options there.
+	foreground []string
+	// args returns the program arguments mounting source, the default is
+	// source itself.
+	args func(source string) ([]string, error)
+}
+
+// fuseDrivers are the built-in FUSE drivers by name. The programs must
+// accept a /dev/fd/N mount point, as libfuse 3.3 and later do.
+var fuseDrivers = map[string]fuseDriver{
+	"sshfs": {
+		program:    "sshfs",
+		foreground: []string{"-f"},
+	},
+	"s3fs": {
+		program:    "s3fs",
+		foreground: []string{"-f"},
+	},
+	"goofys": {
+		program:    "goofys",
+		foreground: []string{"-f"},
+	},
+	"squashfuse": {
+		program:    "squashfuse",
+		foreground: []string{"-f"},
+		args:       squashfuseArgs,
+	},
+}
+
+// squashfuseArgs returns the squashfuse arguments mounting the squashfs
+// image path, or the squashfs root filesystem of a SIF image.
+func squashfuseArgs(path string) ([]string, error) {
+	img, err := image.Init(path, false)
+	if err != nil {
+		return nil, fmt.Errorf("while opening image %s: %s", path, err)
+	}
+	defer img.File.Close()
+
+	switch img.Type {
+	case image.SQUASHFS:
+		return []string{path}, nil
+	case image.SIF:
+		for _, p := range img.Partitions {
+			if p.Type == image.SQUASHFS {
+				return []string{"-o", fmt.Sprintf("offset=%d", p.Offset), path}, nil
+			}
+		}
+		return nil, fmt.Errorf("no squashfs partition found in SIF image %s", path)
+	}
+	return nil, fmt.Errorf("image %s is not a squashfs or SIF image", path)
+}
+
+// expandFuseProfile returns the --fusemount specification of the profile
+// specification 'profile:<name> <mountpoint>', other specifications are
+// returned unchanged.
+func (e *EngineConfig) expandFuseProfile(mountspec string) (string, error) {
+	if !strings.HasPrefix(mountspec, FuseProfilePrefix+":") {
+		return mountspec, nil
+	}
+	words := strings.Fields(strings.TrimPrefix(mountspec, FuseProfilePrefix+":"))
+	if len(words) != 2 {
+		return "", fmt.Errorf("fusemount profile spec %q must be of the form '%s:<name> <mountpoint>'", mountspec, FuseProfilePrefix)
+	}
+	name, mountPoint := words[0], words[1]
+
+	for _, p := range e.File.FusemountProfile {
+		fields := strings.Fields(p)
+		if len(fields) < 2 || fields[0] != name {
+			continue
+		}
+		return strings.Join(append(fields[1:], mountPoint), " "), nil
+	}
+	return "", fmt.Errorf("fusemount profile %s not found in singularity.conf", name)
+}
+
+// setFuseDriver sets the program of the fuse mount m with the built-in
+// driver d, words are the source, the options and the mount point.
+func (m *FuseMount) setFuseDriver(name string, d fuseDriver, words []string) error {
+	source := words[0]
+	options := words[1 : len(words)-1]
+
+	args := []string{source}
+	if d.args != nil {
+		var err error
+		if args, err = d.args(source); err != nil {
+			return fmt.Errorf("fusemount %s driver: %s", name, err)
+		}
+	}
+
+	m.Driver = name
+	m.Program = append([]string{d.program}, d.foreground...)
+	m.Program = append(m.Program, options...)
+	m.Program = append(m.Program, args...)
+	return nil
+}
@@ -0,0 +1,270 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package singularity
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+const testSquash = "../../../../image/testdata/squashfs.v4"
+
+// createSquashSIF creates a SIF image in dir with the test squashfs image
+// as root filesystem partition, it returns the image path and the offset
+// of the partition.
+func createSquashSIF(t *testing.T, dir string) (string, int64) {
+	fp, err := os.Open(testSquash)
+	if err != nil {
+		t.Fatalf("failed to open %s: %s", testSquash, err)
+	}
+	defer fp.Close()
+
+	input := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Fname:    "rootfs",
+		Fp:       fp,
+	}
+	if err := input.SetPartExtra(sif.FsSquash, sif.PartPrimSys, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "image.sif")
+	if _, err := sif.CreateContainer(sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: []sif.DescriptorInput{input},
+	}); err != nil {
+		t.Fatalf("while creating SIF image: %s", err)
+	}
+
+	fimg, err := sif.LoadContainer(path, true)
+	if err != nil {
+		t.Fatalf("while loading SIF image: %s", err)
+	}
+	defer fimg.UnloadContainer()
+
+	prim, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		t.Fatalf("while getting root filesystem partition: %s", err)
+	}
+	return path, prim.Fileoff
+}
+
+func TestExpandFuseProfile(t *testing.T) {
+	e := NewConfig()
+	e.File.FusemountProfile = []string{
+		"data sshfs:user@host:/data -o ro",
+		"bad",
+	}
+
+	tests := []struct {
+		name      string
+		mountspec string
+		expected  string
+		wantErr   bool
+	}{
+		{name: "NoProfile", mountspec: "host:fuse-prog /mnt", expected: "host:fuse-prog /mnt"},
+		{name: "Profile", mountspec: "profile:data /mnt", expected: "sshfs:user@host:/data -o ro /mnt"},
+		{name: "Spaces", mountspec: "profile: data   /mnt ", expected: "sshfs:user@host:/data -o ro /mnt"},
+		{name: "NoMountPoint", mountspec: "profile:data", wantErr: true},
+		{name: "Options", mountspec: "profile:data -o rw /mnt", wantErr: true},
+		{name: "UnknownProfile", mountspec: "profile:other /mnt", wantErr: true},
+		{name: "EmptyProfile", mountspec: "profile:bad /mnt", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mountspec, err := e.expandFuseProfile(tt.mountspec)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success for %q", tt.mountspec)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error for %q: %s", tt.mountspec, err)
+			}
+			if mountspec != tt.expected {
+				t.Errorf("got %q instead of %q", mountspec, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSetFuseDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fuse-driver-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sifPath, offset := createSquashSIF(t, dir)
+	squash, err := ioutil.ReadFile(testSquash)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", testSquash, err)
+	}
+	squashPath := filepath.Join(dir, "image.sqfs")
+	if err := ioutil.WriteFile(squashPath, squash, 0644); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+	textPath := filepath.Join(dir, "text")
+	if err := ioutil.WriteFile(textPath, []byte("not an image"), 0644); err != nil {
+		t.Fatalf("failed to create file: %s", err)
+	}
+
+	tests := []struct {
+		name     string
+		driver   string
+		words    []string
+		expected []string
+		wantErr  bool
+	}{
+		{
+			name:     "Source",
+			driver:   "sshfs",
+			words:    []string{"user@host:/data", "/mnt"},
+			expected: []string{"sshfs", "-f", "user@host:/data"},
+		},
+		{
+			name:     "Options",
+			driver:   "s3fs",
+			words:    []string{"bucket", "-o", "ro", "/mnt"},
+			expected: []string{"s3fs", "-f", "-o", "ro", "bucket"},
+		},
+		{
+			name:     "Squashfs",
+			driver:   "squashfuse",
+			words:    []string{squashPath, "/mnt"},
+			expected: []string{"squashfuse", "-f", squashPath},
+		},
+		{
+			name:     "SIF",
+			driver:   "squashfuse",
+			words:    []string{sifPath, "-o", "allow_other", "/mnt"},
+			expected: []string{"squashfuse", "-f", "-o", "allow_other", "-o", fmt.Sprintf("offset=%d", offset), sifPath},
+		},
+		{
+			name:    "NotImage",
+			driver:  "squashfuse",
+			words:   []string{textPath, "/mnt"},
+			wantErr: true,
+		},
+		{
+			name:    "NoImage",
+			driver:  "squashfuse",
+			words:   []string{filepath.Join(dir, "none"), "/mnt"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var m FuseMount
+			err := m.setFuseDriver(tt.driver, fuseDrivers[tt.driver], tt.words)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if m.Driver != tt.driver {
+				t.Errorf("got driver %q instead of %q", m.Driver, tt.driver)
+			}
+			if !reflect.DeepEqual(m.Program, tt.expected) {
+				t.Errorf("got program %v instead of %v", m.Program, tt.expected)
+			}
+		})
+	}
+}
+
+func TestSetFuseMount(t *testing.T) {
+	tests := []struct {
+		name      string
+		mountspec string
+		expected  FuseMount
+		wantErr   bool
+	}{
+		{
+			name:      "Container",
+			mountspec: "container:fuse-prog -o ro /mnt",
+			expected:  FuseMount{Program: []string{"fuse-prog", "-o", "ro"}, MountPoint: "/mnt", FromContainer: true},
+		},
+		{
+			name:      "ContainerDaemon",
+			mountspec: "container-daemon:fuse-prog /mnt",
+			expected:  FuseMount{Program: []string{"fuse-prog"}, MountPoint: "/mnt", FromContainer: true, Daemon: true},
+		},
+		{
+			name:      "Host",
+			mountspec: "host:fuse-prog /mnt",
+			expected:  FuseMount{Program: []string{"fuse-prog"}, MountPoint: "/mnt"},
+		},
+		{
+			name:      "HostDaemon",
+			mountspec: "host-daemon:fuse-prog /mnt",
+			expected:  FuseMount{Program: []string{"fuse-prog"}, MountPoint: "/mnt", Daemon: true},
+		},
+		{
+			name:      "Driver",
+			mountspec: "sshfs:user@host:/data /mnt",
+			expected:  FuseMount{Program: []string{"sshfs", "-f", "user@host:/data"}, MountPoint: "/mnt", Driver: "sshfs"},
+		},
+		{
+			name:      "Profile",
+			mountspec: "profile:data /mnt",
+			expected:  FuseMount{Program: []string{"goofys", "-f", "bucket"}, MountPoint: "/mnt", Driver: "goofys"},
+		},
+		{
+			name:      "UnknownProfile",
+			mountspec: "profile:other /mnt",
+			wantErr:   true,
+		},
+		{
+			name:      "UnknownPrefix",
+			mountspec: "other:fuse-prog /mnt",
+			wantErr:   true,
+		},
+		{
+			name:      "NoMountPoint",
+			mountspec: "host:fuse-prog",
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := NewConfig()
+			e.File.FusemountProfile = []string{"data goofys:bucket"}
+
+			err := e.SetFuseMount([]string{tt.mountspec})
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success for %q", tt.mountspec)
+				}
+				return
+			} else if err != nil {
+				t.Fatalf("unexpected error for %q: %s", tt.mountspec, err)
+			}
+			tt.expected.Fd = -1
+			if m := e.GetFuseMount()[0]; !reflect.DeepEqual(m, tt.expected) {
+				t.Errorf("got %+v instead of %+v", m, tt.expected)
+			}
+		})
+	}
+}
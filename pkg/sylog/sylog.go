@@ -13,37 +13,92 @@ import (
 	"io/ioutil"
 	"os"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 const messageLevelEnv = "SINGULARITY_MESSAGELEVEL"
 
-var messageColors = map[messageLevel]string{
-	FatalLevel: "\x1b[31m",
-	ErrorLevel: "\x1b[31m",
-	WarnLevel:  "\x1b[33m",
-	InfoLevel:  "\x1b[34m",
-}
+const noColorLevel messageLevel = 90
 
 var (
-	noColorLevel messageLevel = 90
-	loggerLevel  messageLevel = InfoLevel
+	subLevelsMutex sync.Mutex
+	// subLevels holds the message level of sub loggers
+	// overriding the logger level
+	subLevels = make(map[string]messageLevel)
 )
 
-var logWriter = (io.Writer)(os.Stderr)
+// defaultLogger is the logger used by the package level functions.
+var defaultLogger = NewLogger(os.Stderr, int(InfoLevel), true)
 
 func init() {
-	level, err := strconv.Atoi(os.Getenv(messageLevelEnv))
-	if err == nil {
-		loggerLevel = messageLevel(level)
+	parseMessageLevel(os.Getenv(messageLevelEnv))
+}
+
+// parseMessageLevel interprets the message level environment variable
+// value which is a comma separated list of a global level and/or sub
+// logger levels, eg: "5", "build=debug,network=verbose3" or "1,build=5".
+func parseMessageLevel(value string) {
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) == 2 {
+			if level, err := ParseLevel(kv[1]); err == nil {
+				subLevels[strings.TrimSpace(kv[0])] = messageLevel(level)
+			}
+			continue
+		}
+		// integer levels may carry the no color offset
+		if level, err := strconv.Atoi(v); err == nil {
+			defaultLogger.level, defaultLogger.color = decodeLevel(messageLevel(level))
+		} else if level, err := ParseLevel(v); err == nil {
+			defaultLogger.level = messageLevel(level)
+		}
+	}
+}
+
+// decodeLevel returns the message level and the color setting
+// of a level carrying the no color offset.
+func decodeLevel(l messageLevel) (messageLevel, bool) {
+	if l <= -noColorLevel {
+		return l + noColorLevel, false
+	} else if l >= noColorLevel {
+		return l - noColorLevel, false
+	}
+	return l, true
+}
+
+// encodeLevel returns the message level with the no color
+// offset applied if color is disabled.
+func encodeLevel(l messageLevel, color bool) messageLevel {
+	if !color {
+		if l >= InfoLevel {
+			return l + noColorLevel
+		} else if l <= LogLevel {
+			return l - noColorLevel
+		}
 	}
+	return l
 }
 
-func prefix(logLevel, msgLevel messageLevel) string {
+func prefix(l *Logger, logLevel, msgLevel messageLevel) string {
+	if logLevel < DebugLevel {
+		return formatPrefix(l, logLevel, msgLevel, 0, 0, "")
+	}
+	return formatPrefix(l, logLevel, msgLevel, os.Geteuid(), os.Getpid(), callerName(4))
+}
+
+// formatPrefix builds the message prefix, the user ID, process ID and
+// function name are only displayed for levels >= debug.
+func formatPrefix(l *Logger, logLevel, msgLevel messageLevel, uid, pid int, funcName string) string {
 	colorReset := "\x1b[0m"
-	messageColor, ok := messageColors[msgLevel]
-	if !ok || logLevel != loggerLevel {
+	messageColor := levelColor(msgLevel)
+	if messageColor == "" || !l.colored() {
 		colorReset = ""
 		messageColor = ""
 	}
@@ -53,111 +108,366 @@ func prefix(logLevel, msgLevel messageLevel) string {
 		return fmt.Sprintf("%s%-8s%s ", messageColor, msgLevel.String()+":", colorReset)
 	}
 
-	pc, _, _, ok := runtime.Caller(3)
+	uidStr := fmt.Sprintf("[U=%d,P=%d]", uid, pid)
+
+	return fmt.Sprintf("%s%-8s%s%-19s%-30s", messageColor, msgLevel, colorReset, uidStr, funcName)
+}
+
+// callerName returns the name of the function at the
+// given depth of the calling goroutine's stack.
+func callerName(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip)
 	details := runtime.FuncForPC(pc)
 
-	var funcName string
 	if ok && details == nil {
-		funcName = "????()"
-	} else {
-		funcNameSplit := strings.Split(details.Name(), ".")
-		funcName = funcNameSplit[len(funcNameSplit)-1] + "()"
+		return "????()"
 	}
-
-	uid := os.Geteuid()
-	pid := os.Getpid()
-	uidStr := fmt.Sprintf("[U=%d,P=%d]", uid, pid)
-
-	return fmt.Sprintf("%s%-8s%s%-19s%-30s", messageColor, msgLevel, colorReset, uidStr, funcName)
+	funcNameSplit := strings.Split(details.Name(), ".")
+	return funcNameSplit[len(funcNameSplit)-1] + "()"
 }
 
-func writef(msgLevel messageLevel, format string, a ...interface{}) {
-	logLevel := getLoggerLevel()
-	if logLevel < msgLevel {
+// writef formats and writes the message through the logger l, name
+// identifies the sub logger and is empty for messages logged directly
+// through the logger. Fatal messages get a code field when an error
+// code is attached to one of the arguments.
+func writef(l *Logger, name string, msgLevel messageLevel, format string, a ...interface{}) {
+	logLevel := l.levelFor(name)
+	if logLevel < msgLevel && !forwarded(msgLevel) {
 		return
 	}
 
 	message := fmt.Sprintf(format, a...)
-	message = strings.TrimRight(message, "\n")
+	message = redact(strings.TrimRight(message, "\n"))
+
+	var fields []field
+	if msgLevel == FatalLevel {
+		if code, ok := argsCode(a); ok {
+			fields = []field{{key: "code", value: code.String()}}
+		}
+	}
 
-	fmt.Fprintf(logWriter, "%s%s\n", prefix(logLevel, msgLevel), message)
+	if throttled(l, name, logLevel, msgLevel, message, fields) {
+		return
+	}
+
+	p := ""
+	if logLevel >= msgLevel {
+		p = timestamp() + prefix(l, logLevel, msgLevel)
+	}
+	output(l, name, logLevel, msgLevel, p, message, fields)
 }
 
-func getLoggerLevel() messageLevel {
-	if loggerLevel <= -noColorLevel {
-		return loggerLevel + noColorLevel
-	} else if loggerLevel >= noColorLevel {
-		return loggerLevel - noColorLevel
+// output writes the message with the provided prefix and its fields to
+// the logger writer and sends it to the forwarding backend and hooks, or
+// to the parent process if messages are relayed.
+func output(l *Logger, name string, logLevel, msgLevel messageLevel, p, message string, fields []field) {
+	if relayed(name, logLevel, msgLevel, message, fields) {
+		return
+	}
+
+	text := message + formatFields(fields)
+
+	forward(msgLevel, text)
+
+	if logLevel < msgLevel {
+		return
+	}
+
+	if !writeBackend(name, msgLevel, message, fields) {
+		if jsonOutput() {
+			line := jsonRecord(name, logLevel, msgLevel, message, fields)
+			io.WriteString(l.getWriter(), line)
+			writeOutputFile(line)
+		} else {
+			fmt.Fprintf(l.getWriter(), "%s%s\n", p, text)
+			writeOutputFile(stripColor(p) + text + "\n")
+		}
 	}
-	return loggerLevel
+
+	callHooks(name, msgLevel, message, fields)
+	addSpanEvent(name, msgLevel, text)
 }
 
-// Fatalf is equivalent to a call to Errorf followed by os.Exit(255). Code that
-// may be imported by other projects should NOT use Fatalf.
+// Fatalf is equivalent to a call to Errorf followed by Exit(255), the exit
+// hooks are called before the process terminates. If an error argument
+// carries an error code (see WithCode), the code is appended to the message
+// and used as exit code instead. Code that may be imported by
+// other projects should NOT use Fatalf.
 func Fatalf(format string, a ...interface{}) {
-	writef(FatalLevel, format, a...)
-	os.Exit(255)
+	writef(defaultLogger, "", FatalLevel, format, a...)
+	Exit(fatalExitCode(a))
 }
 
 // Errorf writes an ERROR level message to the log but does not exit. This
 // should be called when an error is being returned to the calling thread
 func Errorf(format string, a ...interface{}) {
-	writef(ErrorLevel, format, a...)
+	writef(defaultLogger, "", ErrorLevel, format, a...)
 }
 
 // Warningf writes a WARNING level message to the log.
 func Warningf(format string, a ...interface{}) {
-	writef(WarnLevel, format, a...)
+	writef(defaultLogger, "", WarnLevel, format, a...)
 }
 
 // Infof writes an INFO level message to the log. By default, INFO level messages
 // will always be output (unless running in silent)
 func Infof(format string, a ...interface{}) {
-	writef(InfoLevel, format, a...)
+	writef(defaultLogger, "", InfoLevel, format, a...)
 }
 
 // Verbosef writes a VERBOSE level message to the log. This should probably be
 // deprecated since the granularity is often too fine to be useful.
 func Verbosef(format string, a ...interface{}) {
-	writef(VerboseLevel, format, a...)
+	writef(defaultLogger, "", VerboseLevel, format, a...)
 }
 
 // Debugf writes a DEBUG level message to the log.
 func Debugf(format string, a ...interface{}) {
-	writef(DebugLevel, format, a...)
+	writef(defaultLogger, "", DebugLevel, format, a...)
 }
 
-// SetLevel explicitly sets the loggerLevel
+// Tracef writes a TRACE level message to the log. It should be used to
+// report each low level operation, like a mount or a setns call.
+func Tracef(format string, a ...interface{}) {
+	writef(defaultLogger, "", TraceLevel, format, a...)
+}
+
+// SetLevel explicitly sets the level of the default logger
 func SetLevel(l int, color bool) {
-	loggerLevel = messageLevel(l)
-	if !color {
-		if loggerLevel >= InfoLevel {
-			loggerLevel = loggerLevel + noColorLevel
-		} else if loggerLevel <= LogLevel {
-			loggerLevel = loggerLevel - noColorLevel
-		}
-	}
+	defaultLogger.SetLevel(l, color)
 }
 
-// GetLevel returns the current log level as integer
+// GetLevel returns the current log level of the default logger as integer
 func GetLevel() int {
-	return int(getLoggerLevel())
+	return defaultLogger.GetLevel()
+}
+
+// SetSubLevel explicitly sets the level of the sub logger name,
+// overriding the logger level for messages logged through it.
+func SetSubLevel(name string, l int) {
+	subLevelsMutex.Lock()
+	subLevels[name] = messageLevel(l)
+	subLevelsMutex.Unlock()
 }
 
 // GetEnvVar returns a formatted environment variable string which
 // can later be interpreted by init() in a child proc
 func GetEnvVar() string {
-	return fmt.Sprintf("%s=%d", messageLevelEnv, loggerLevel)
+	subLevelsMutex.Lock()
+	defer subLevelsMutex.Unlock()
+
+	names := make([]string, 0, len(subLevels))
+	for name := range subLevels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	defaultLogger.mu.RLock()
+	level := encodeLevel(defaultLogger.level, defaultLogger.color)
+	defaultLogger.mu.RUnlock()
+
+	env := fmt.Sprintf("%s=%d", messageLevelEnv, level)
+	for _, name := range names {
+		env += fmt.Sprintf(",%s=%d", name, subLevels[name])
+	}
+	return env
 }
 
-// Writer returns an io.Writer to pass to an external packages logging utility.
-// i.e when --quiet option is set, this function returns ioutil.Discard writer to ignore output
-func Writer() io.Writer {
-	if loggerLevel <= LogLevel {
+// Logger writes messages with its own message level, color setting
+// and writer, so independent consumers of the same process, like
+// concurrent builds, don't race on the default logger settings. Log
+// forwarding, output file, hooks, backend and sub logger levels are
+// shared by all loggers.
+type Logger struct {
+	mu     sync.RWMutex
+	level  messageLevel
+	color  bool
+	writer io.Writer
+}
+
+// NewLogger returns a logger writing messages up to level to w.
+func NewLogger(w io.Writer, level int, color bool) *Logger {
+	return &Logger{
+		level:  messageLevel(level),
+		color:  color,
+		writer: w,
+	}
+}
+
+// SetLevel explicitly sets the logger level
+func (l *Logger) SetLevel(level int, color bool) {
+	flushThrottled()
+
+	l.mu.Lock()
+	l.level = messageLevel(level)
+	l.color = color
+	l.mu.Unlock()
+}
+
+// GetLevel returns the current logger level as integer
+func (l *Logger) GetLevel() int {
+	return int(l.getLevel())
+}
+
+// SetWriter sets the writer where messages are written.
+func (l *Logger) SetWriter(w io.Writer) {
+	l.mu.Lock()
+	l.writer = w
+	l.mu.Unlock()
+}
+
+// Writer returns an io.Writer to pass to an external packages logging utility,
+// it returns ioutil.Discard writer if the logger is silenced.
+func (l *Logger) Writer() io.Writer {
+	if l.getLevel() <= LogLevel {
 		return ioutil.Discard
 	}
+	return l.getWriter()
+}
+
+// NewSubLogger returns a sub logger identified by name
+// writing through the logger.
+func (l *Logger) NewSubLogger(name string) *SubLogger {
+	return &SubLogger{name: name, logger: l}
+}
+
+func (l *Logger) getLevel() messageLevel {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.level
+}
 
-	return logWriter
+func (l *Logger) colored() bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.color
+}
+
+func (l *Logger) getWriter() io.Writer {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.writer
+}
+
+// levelFor returns the level of the sub logger name if set,
+// the logger level otherwise.
+func (l *Logger) levelFor(name string) messageLevel {
+	if name != "" {
+		subLevelsMutex.Lock()
+		level, ok := subLevels[name]
+		subLevelsMutex.Unlock()
+		if ok {
+			return level
+		}
+	}
+	return l.getLevel()
+}
+
+// Fatalf is equivalent to Fatalf for the logger.
+func (l *Logger) Fatalf(format string, a ...interface{}) {
+	writef(l, "", FatalLevel, format, a...)
+	Exit(fatalExitCode(a))
+}
+
+// Errorf is equivalent to Errorf for the logger.
+func (l *Logger) Errorf(format string, a ...interface{}) {
+	writef(l, "", ErrorLevel, format, a...)
+}
+
+// Warningf is equivalent to Warningf for the logger.
+func (l *Logger) Warningf(format string, a ...interface{}) {
+	writef(l, "", WarnLevel, format, a...)
+}
+
+// Infof is equivalent to Infof for the logger.
+func (l *Logger) Infof(format string, a ...interface{}) {
+	writef(l, "", InfoLevel, format, a...)
+}
+
+// Verbosef is equivalent to Verbosef for the logger.
+func (l *Logger) Verbosef(format string, a ...interface{}) {
+	writef(l, "", VerboseLevel, format, a...)
+}
+
+// Debugf is equivalent to Debugf for the logger.
+func (l *Logger) Debugf(format string, a ...interface{}) {
+	writef(l, "", DebugLevel, format, a...)
+}
+
+// Tracef is equivalent to Tracef for the logger.
+func (l *Logger) Tracef(format string, a ...interface{}) {
+	writef(l, "", TraceLevel, format, a...)
+}
+
+// SubLogger is a named logger whose level can be set independently of
+// the logger level with SetSubLevel or with the SINGULARITY_MESSAGELEVEL
+// environment variable (eg: SINGULARITY_MESSAGELEVEL=network=debug). It
+// defaults to the level of the logger it writes through.
+type SubLogger struct {
+	name   string
+	logger *Logger
+}
+
+// NewSubLogger returns a sub logger identified by name
+// writing through the default logger.
+func NewSubLogger(name string) *SubLogger {
+	return defaultLogger.NewSubLogger(name)
+}
+
+// Fatalf is equivalent to Fatalf for the sub logger.
+func (s *SubLogger) Fatalf(format string, a ...interface{}) {
+	writef(s.logger, s.name, FatalLevel, format, a...)
+	Exit(fatalExitCode(a))
+}
+
+// Errorf is equivalent to Errorf for the sub logger.
+func (s *SubLogger) Errorf(format string, a ...interface{}) {
+	writef(s.logger, s.name, ErrorLevel, format, a...)
+}
+
+// Warningf is equivalent to Warningf for the sub logger.
+func (s *SubLogger) Warningf(format string, a ...interface{}) {
+	writef(s.logger, s.name, WarnLevel, format, a...)
+}
+
+// Infof is equivalent to Infof for the sub logger.
+func (s *SubLogger) Infof(format string, a ...interface{}) {
+	writef(s.logger, s.name, InfoLevel, format, a...)
+}
+
+// Verbosef is equivalent to Verbosef for the sub logger.
+func (s *SubLogger) Verbosef(format string, a ...interface{}) {
+	writef(s.logger, s.name, VerboseLevel, format, a...)
+}
+
+// Debugf is equivalent to Debugf for the sub logger.
+func (s *SubLogger) Debugf(format string, a ...interface{}) {
+	writef(s.logger, s.name, DebugLevel, format, a...)
+}
+
+// Tracef is equivalent to Tracef for the sub logger.
+func (s *SubLogger) Tracef(format string, a ...interface{}) {
+	writef(s.logger, s.name, TraceLevel, format, a...)
+}
+
+// GetEnvVars returns the formatted environment variable strings which
+// can later be interpreted by init() in a child proc to restore the
+// message level, the colors, the message format and destination, the
+// log forwarding, the timestamps and the log file settings.
+func GetEnvVars() []string {
+	env := []string{GetEnvVar()}
+	env = append(env, getColorEnvVars()...)
+	env = append(env, getFormatEnvVars()...)
+	env = append(env, getDestinationEnvVars()...)
+	env = append(env, getForwardEnvVars()...)
+	env = append(env, getTimestampEnvVars()...)
+	return append(env, getOutputFileEnvVars()...)
+}
+
+// Writer returns an io.Writer to pass to an external packages logging utility.
+// i.e when --quiet option is set, this function returns ioutil.Discard writer to ignore output
+func Writer() io.Writer {
+	return defaultLogger.Writer()
 }
 
 // DebugLogger is an implementation of the go-log/log Logger interface that will
@@ -167,10 +477,10 @@ type DebugLogger struct{}
 
 // Output a log message via sylog.Debugf
 func (t DebugLogger) Log(v ...interface{}) {
-	writef(DebugLevel, "%s", fmt.Sprint(v...))
+	writef(defaultLogger, "", DebugLevel, "%s", fmt.Sprint(v...))
 }
 
 // Output a formatted log message via sylog.Debugf
 func (t DebugLogger) Logf(format string, v ...interface{}) {
-	writef(DebugLevel, format, v...)
+	writef(defaultLogger, "", DebugLevel, format, v...)
 }
@@ -0,0 +1,86 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog,go1.21
+
+package sylog
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestSlogBackend(t *testing.T) {
+	var buf bytes.Buffer
+
+	SetLevel(int(InfoLevel), false)
+	SetSlogBackend(slog.New(slog.NewTextHandler(&buf, nil)))
+	defer SetBackend(nil)
+
+	NewSubLogger("build").Warningf("slog message")
+	Verbosef("hidden message")
+
+	out := buf.String()
+	if !strings.Contains(out, `level=WARN msg="slog message" logger=build`) {
+		t.Errorf("unexpected slog output: %s", out)
+	}
+	if strings.Contains(out, "hidden message") {
+		t.Errorf("unexpected verbose message: %s", out)
+	}
+}
+
+func TestSlogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger.writer = &buf
+
+	defer func() {
+		defaultLogger.writer = defaultWriter
+	}()
+
+	SetLevel(int(InfoLevel), false)
+
+	l := slog.New(NewSlogHandler()).With("image", "alpine.sif").WithGroup("pull")
+	l.Info("pulled", "size", 42)
+	l.Debug("hidden")
+
+	if buf.String() != "INFO:    pulled image=alpine.sif pull.size=42\n" {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestLogrus(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger.writer = &buf
+
+	defer func() {
+		defaultLogger.writer = defaultWriter
+	}()
+
+	SetLevel(int(InfoLevel), false)
+
+	l := logrus.New()
+	l.Out = &bytes.Buffer{}
+	l.AddHook(LogrusHook{})
+	l.WithField("layer", "sha256:abc").Warn("logrus message")
+
+	if buf.String() != "WARNING: logrus message layer=sha256:abc\n" {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+
+	var lbuf bytes.Buffer
+	l = logrus.New()
+	l.Out = &lbuf
+	SetLogrusBackend(l)
+	defer SetBackend(nil)
+
+	Errorf("sylog message")
+	if !strings.Contains(lbuf.String(), `level=error msg="sylog message"`) {
+		t.Errorf("unexpected logrus output %q", lbuf.String())
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// colorsEnv holds the user defined colors of message levels.
+const colorsEnv = "SINGULARITY_COLORS"
+
+// noColorEnv disables colors when set to a non empty
+// value, see https://no-color.org.
+const noColorEnv = "NO_COLOR"
+
+var defaultColors = map[messageLevel]string{
+	FatalLevel: "31",
+	ErrorLevel: "31",
+	WarnLevel:  "33",
+	InfoLevel:  "34",
+}
+
+var (
+	colorMutex sync.RWMutex
+	// messageColors holds the escape sequences of
+	// the colored message levels
+	messageColors = colorSequences(defaultColors)
+	// colorSpec holds the colors set by SetColors
+	colorSpec string
+)
+
+var colorRegexp = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func init() {
+	if os.Getenv(noColorEnv) != "" {
+		defaultLogger.color = false
+	}
+	// errors are ignored, there is nothing we could
+	// report them to at this stage
+	SetColors(os.Getenv(colorsEnv))
+}
+
+func colorSequences(colors map[messageLevel]string) map[messageLevel]string {
+	seqs := make(map[messageLevel]string, len(colors))
+	for l, sgr := range colors {
+		if sgr != "" {
+			seqs[l] = "\x1b[" + sgr + "m"
+		}
+	}
+	return seqs
+}
+
+// SetColors overrides the default colors of message levels with spec, a
+// colon separated list of level=SGR pairs where SGR are the ANSI select
+// graphic rendition parameters (eg: "error=1;31:warning=35:info=36").
+// An empty SGR disables the color of the level, levels not listed keep
+// their default color.
+func SetColors(spec string) error {
+	colors := make(map[messageLevel]string, len(defaultColors))
+	for l, sgr := range defaultColors {
+		colors[l] = sgr
+	}
+
+	for _, pair := range strings.Split(spec, ":") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("bad color %q: expected level=SGR", pair)
+		}
+		level, ok := messageLevelNames[strings.ToLower(strings.TrimSpace(kv[0]))]
+		if !ok {
+			return fmt.Errorf("bad color %q: unknown message level %q", pair, kv[0])
+		}
+		if strings.Trim(kv[1], "0123456789;") != "" {
+			return fmt.Errorf("bad color %q: SGR parameters must be numbers separated by ';'", pair)
+		}
+		colors[level] = kv[1]
+	}
+
+	colorMutex.Lock()
+	messageColors = colorSequences(colors)
+	colorSpec = spec
+	colorMutex.Unlock()
+
+	return nil
+}
+
+// levelColor returns the escape sequence of the level color,
+// or an empty string if the level isn't colored.
+func levelColor(l messageLevel) string {
+	colorMutex.RLock()
+	defer colorMutex.RUnlock()
+	return messageColors[l]
+}
+
+// stripColor removes color escape sequences from the message prefix.
+func stripColor(prefix string) string {
+	if !strings.Contains(prefix, "\x1b[") {
+		return prefix
+	}
+	return colorRegexp.ReplaceAllString(prefix, "")
+}
+
+// getColorEnvVars returns the environment variables required
+// by a child process to display the same colors.
+func getColorEnvVars() []string {
+	colorMutex.RLock()
+	defer colorMutex.RUnlock()
+
+	if colorSpec == "" {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s=%s", colorsEnv, colorSpec)}
+}
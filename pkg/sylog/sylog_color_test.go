@@ -0,0 +1,111 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"testing"
+)
+
+func TestSetColors(t *testing.T) {
+	defer SetColors("")
+
+	tests := []struct {
+		name        string
+		spec        string
+		shouldFail  bool
+		level       messageLevel
+		expected    string
+		expectedEnv []string
+	}{
+		{
+			name:     "default colors",
+			spec:     "",
+			level:    WarnLevel,
+			expected: "\x1b[33mWARNING:\x1b[0m ",
+		},
+		{
+			name:        "custom color",
+			spec:        "warning=1;35:info=36",
+			level:       WarnLevel,
+			expected:    "\x1b[1;35mWARNING:\x1b[0m ",
+			expectedEnv: []string{colorsEnv + "=warning=1;35:info=36"},
+		},
+		{
+			name:        "disabled color",
+			spec:        "error=",
+			level:       ErrorLevel,
+			expected:    "ERROR:   ",
+			expectedEnv: []string{colorsEnv + "=error="},
+		},
+		{
+			name:        "uncolored level",
+			spec:        "debug=32",
+			level:       VerboseLevel,
+			expected:    "VERBOSE: ",
+			expectedEnv: []string{colorsEnv + "=debug=32"},
+		},
+		{
+			name:       "unknown level",
+			spec:       "notice=32",
+			shouldFail: true,
+		},
+		{
+			name:       "bad parameters",
+			spec:       "error=red",
+			shouldFail: true,
+		},
+		{
+			name:       "missing parameters",
+			spec:       "error",
+			shouldFail: true,
+		},
+	}
+
+	l := NewLogger(nil, int(InfoLevel), true)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetColors("")
+
+			err := SetColors(tt.spec)
+			if err != nil && !tt.shouldFail {
+				t.Fatalf("unexpected error: %s", err)
+			} else if err == nil && tt.shouldFail {
+				t.Fatalf("unexpected success with %q", tt.spec)
+			} else if tt.shouldFail {
+				return
+			}
+
+			p := formatPrefix(l, InfoLevel, tt.level, 0, 0, "")
+			if p != tt.expected {
+				t.Errorf("unexpected prefix %q instead of %q", p, tt.expected)
+			}
+			env := getColorEnvVars()
+			if len(env) != len(tt.expectedEnv) || len(env) > 0 && env[0] != tt.expectedEnv[0] {
+				t.Errorf("unexpected environment %v instead of %v", env, tt.expectedEnv)
+			}
+		})
+	}
+}
+
+func TestStripColor(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		expected string
+	}{
+		{"\x1b[1;35mWARNING:\x1b[0m ", "WARNING: "},
+		{"\x1b[33mWARNING \x1b[0m[U=0,P=1]", "WARNING [U=0,P=1]"},
+		{"INFO:    ", "INFO:    "},
+	}
+
+	for _, tt := range tests {
+		if s := stripColor(tt.prefix); s != tt.expected {
+			t.Errorf("unexpected stripped prefix %q instead of %q", s, tt.expected)
+		}
+	}
+}
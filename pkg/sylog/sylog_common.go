@@ -5,6 +5,53 @@
 
 package sylog
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// SyslogBackend forwards messages to the local syslog daemon.
+	SyslogBackend = "syslog"
+	// JournaldBackend forwards messages to systemd-journald using
+	// its native protocol.
+	JournaldBackend = "journald"
+	// NoBackend disables message forwarding.
+	NoBackend = "none"
+)
+
+const (
+	// RFC3339Timestamps prepends RFC3339 timestamps to messages.
+	RFC3339Timestamps = "rfc3339"
+	// RelativeTimestamps prepends the time elapsed since the start
+	// of the command to messages.
+	RelativeTimestamps = "relative"
+	// NoTimestamps disables timestamps.
+	NoTimestamps = "no"
+)
+
+const (
+	// TextFormat writes messages as text lines with fields
+	// appended in the key=value form.
+	TextFormat = "text"
+	// JSONFormat writes messages as JSON objects, one per line.
+	JSONFormat = "json"
+)
+
+const (
+	// StderrDestination writes messages to the standard error.
+	StderrDestination = "stderr"
+	// StdoutDestination writes messages to the standard output.
+	StdoutDestination = "stdout"
+)
+
+// Hook is a function receiving messages written by the logger along with
+// their level and associated fields (eg: "uid", "pid", "logger" for sub
+// loggers and "container" when known). Hooks must not call the logger
+// functions.
+type Hook func(level int, msg string, fields map[string]interface{})
+
 type messageLevel int
 
 const (
@@ -18,6 +65,7 @@ const (
 	Verbose2Level                         // Verbose2Level : 3
 	Verbose3Level                         // Verbose3Level : 4
 	DebugLevel                            // DebugLevel    : 5
+	TraceLevel                            // TraceLevel    : 6
 )
 
 func (l messageLevel) String() string {
@@ -40,4 +88,34 @@ var messageLabels = map[messageLevel]string{
 	Verbose2Level: "VERBOSE",
 	Verbose3Level: "VERBOSE",
 	DebugLevel:    "DEBUG",
+	TraceLevel:    "TRACE",
+}
+
+var messageLevelNames = map[string]messageLevel{
+	"fatal":    FatalLevel,
+	"error":    ErrorLevel,
+	"warn":     WarnLevel,
+	"warning":  WarnLevel,
+	"log":      LogLevel,
+	"info":     InfoLevel,
+	"verbose":  VerboseLevel,
+	"verbose1": VerboseLevel,
+	"verbose2": Verbose2Level,
+	"verbose3": Verbose3Level,
+	"debug":    DebugLevel,
+	"verbose4": TraceLevel,
+	"trace":    TraceLevel,
+}
+
+// ParseLevel returns the integer message level corresponding to the
+// provided level name (eg: "warning", "debug") or number.
+func ParseLevel(level string) (int, error) {
+	if l, ok := messageLevelNames[strings.ToLower(strings.TrimSpace(level))]; ok {
+		return int(l), nil
+	}
+	l, err := strconv.Atoi(level)
+	if err != nil || l < int(FatalLevel) || l > int(TraceLevel) {
+		return 0, fmt.Errorf("unknown message level %q", level)
+	}
+	return l, nil
 }
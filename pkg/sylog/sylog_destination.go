@@ -0,0 +1,103 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// destinationEnv holds the destination of the default logger
+// messages, either "stderr", "stdout" or the absolute path of
+// a file.
+const destinationEnv = "SINGULARITY_LOG_DESTINATION"
+
+var (
+	destinationMutex sync.Mutex
+	destination      = StderrDestination
+	destinationFile  *os.File
+)
+
+func init() {
+	dest := os.Getenv(destinationEnv)
+	if dest == "" {
+		return
+	}
+	// don't let a privileged process write to a file
+	// provided by an unprivileged user
+	if os.Geteuid() != os.Getuid() && filepath.IsAbs(dest) {
+		return
+	}
+	// errors are ignored, there is nothing we could
+	// report them to at this stage
+	SetDestination(dest)
+}
+
+// SetDestination sets where the default logger writes messages, dest is
+// either StderrDestination, StdoutDestination or the absolute path of a
+// file where messages are appended instead of being displayed.
+func SetDestination(dest string) error {
+	var w io.Writer
+	var f *os.File
+
+	switch dest {
+	case "", StderrDestination:
+		dest = StderrDestination
+		w = os.Stderr
+	case StdoutDestination:
+		w = os.Stdout
+	default:
+		if !filepath.IsAbs(dest) {
+			return fmt.Errorf("log destination %q is neither %s, %s nor an absolute path", dest, StderrDestination, StdoutDestination)
+		}
+		var err error
+		f, err = os.OpenFile(dest, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return fmt.Errorf("while opening log destination: %s", err)
+		}
+		w = f
+	}
+
+	destinationMutex.Lock()
+	defer destinationMutex.Unlock()
+
+	defaultLogger.SetWriter(w)
+	if destinationFile != nil {
+		destinationFile.Close()
+	}
+	destinationFile = f
+	destination = dest
+
+	return nil
+}
+
+// syncDestination commits the content of the destination
+// file to disk if messages are written to a file.
+func syncDestination() {
+	destinationMutex.Lock()
+	defer destinationMutex.Unlock()
+
+	if destinationFile != nil {
+		destinationFile.Sync()
+	}
+}
+
+// getDestinationEnvVars returns the environment variables required
+// by a child process to write messages to the same destination.
+func getDestinationEnvVars() []string {
+	destinationMutex.Lock()
+	defer destinationMutex.Unlock()
+
+	if destination == StderrDestination {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s=%s", destinationEnv, destination)}
+}
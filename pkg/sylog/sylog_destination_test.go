@@ -0,0 +1,71 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSetDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sylog-destination-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func() {
+		SetDestination(StderrDestination)
+		defaultLogger.writer = defaultWriter
+	}()
+
+	SetLevel(int(InfoLevel), false)
+
+	if err := SetDestination("relative.log"); err == nil {
+		t.Errorf("unexpected success with relative path")
+	}
+	if err := SetDestination(filepath.Join(dir, "missing", "file.log")); err == nil {
+		t.Errorf("unexpected success with path in missing directory")
+	}
+
+	path := filepath.Join(dir, "file.log")
+	if err := SetDestination(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	Infof("written to file")
+
+	env := getDestinationEnvVars()
+	if len(env) != 1 || env[0] != destinationEnv+"="+path {
+		t.Errorf("unexpected environment %v", env)
+	}
+
+	if err := SetDestination(StdoutDestination); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if defaultLogger.getWriter() != os.Stdout {
+		t.Errorf("messages not written to standard output")
+	}
+	if destinationFile != nil {
+		t.Errorf("destination file not closed")
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %s", path, err)
+	}
+	if string(b) != "INFO:    written to file\n" {
+		t.Errorf("unexpected file content %q", b)
+	}
+
+	SetDestination(StderrDestination)
+	if env := getDestinationEnvVars(); len(env) != 0 {
+		t.Errorf("unexpected environment %v for stderr destination", env)
+	}
+}
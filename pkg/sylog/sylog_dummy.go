@@ -8,15 +8,17 @@
 package sylog
 
 import (
+	"errors"
 	"io"
 	"io/ioutil"
 	"os"
 )
 
-// Fatalf is a dummy function exiting with code 255. This
+// Fatalf is a dummy function calling the exit hooks and exiting with
+// code 255 or with the error code attached to its arguments. This
 // function must not be used in public packages.
 func Fatalf(format string, a ...interface{}) {
-	os.Exit(255)
+	Exit(fatalExitCode(a))
 }
 
 // Errorf is a dummy function doing nothing.
@@ -34,9 +36,98 @@ func Verbosef(format string, a ...interface{}) {}
 // Debugf is a dummy function doing nothing
 func Debugf(format string, a ...interface{}) {}
 
+// Tracef is a dummy function doing nothing.
+func Tracef(format string, a ...interface{}) {}
+
 // SetLevel is a dummy function doing nothing.
 func SetLevel(l int, color bool) {}
 
+// SetSubLevel is a dummy function doing nothing.
+func SetSubLevel(name string, l int) {}
+
+// SubLogger is a dummy sub logger doing nothing.
+type SubLogger struct{}
+
+// NewSubLogger returns a dummy sub logger.
+func NewSubLogger(name string) *SubLogger {
+	return &SubLogger{}
+}
+
+// Fatalf is a dummy function exiting like Fatalf.
+func (s *SubLogger) Fatalf(format string, a ...interface{}) {
+	Exit(fatalExitCode(a))
+}
+
+// Errorf is a dummy function doing nothing.
+func (s *SubLogger) Errorf(format string, a ...interface{}) {}
+
+// Warningf is a dummy function doing nothing.
+func (s *SubLogger) Warningf(format string, a ...interface{}) {}
+
+// Infof is a dummy function doing nothing.
+func (s *SubLogger) Infof(format string, a ...interface{}) {}
+
+// Verbosef is a dummy function doing nothing.
+func (s *SubLogger) Verbosef(format string, a ...interface{}) {}
+
+// Debugf is a dummy function doing nothing.
+func (s *SubLogger) Debugf(format string, a ...interface{}) {}
+
+// Tracef is a dummy function doing nothing.
+func (s *SubLogger) Tracef(format string, a ...interface{}) {}
+
+// Logger is a dummy logger doing nothing.
+type Logger struct{}
+
+// NewLogger returns a dummy logger.
+func NewLogger(w io.Writer, level int, color bool) *Logger {
+	return &Logger{}
+}
+
+// SetLevel is a dummy function doing nothing.
+func (l *Logger) SetLevel(level int, color bool) {}
+
+// GetLevel is a dummy function returning lowest message level.
+func (l *Logger) GetLevel() int {
+	return int(-1)
+}
+
+// SetWriter is a dummy function doing nothing.
+func (l *Logger) SetWriter(w io.Writer) {}
+
+// Writer is a dummy function returning ioutil.Discard writer.
+func (l *Logger) Writer() io.Writer {
+	return ioutil.Discard
+}
+
+// NewSubLogger returns a dummy sub logger.
+func (l *Logger) NewSubLogger(name string) *SubLogger {
+	return &SubLogger{}
+}
+
+// Fatalf is a dummy function exiting like Fatalf.
+func (l *Logger) Fatalf(format string, a ...interface{}) {
+	Exit(fatalExitCode(a))
+}
+
+// Errorf is a dummy function doing nothing.
+func (l *Logger) Errorf(format string, a ...interface{}) {}
+
+// Warningf is a dummy function doing nothing.
+func (l *Logger) Warningf(format string, a ...interface{}) {}
+
+// Infof is a dummy function doing nothing.
+func (l *Logger) Infof(format string, a ...interface{}) {}
+
+// Verbosef is a dummy function doing nothing.
+func (l *Logger) Verbosef(format string, a ...interface{}) {}
+
+// Debugf is a dummy function doing nothing.
+func (l *Logger) Debugf(format string, a ...interface{}) {}
+
+// Tracef is a dummy function doing nothing.
+func (l *Logger) Tracef(format string, a ...interface{}) {}
+
 // DisableColor for the logger
 func DisableColor() {}
 
@@ -51,6 +142,175 @@ func GetEnvVar() string {
 	return "SINGULARITY_MESSAGELEVEL=-1"
 }
 
+// SetForwarding is a dummy function doing nothing.
+func SetForwarding(backend string, level int) error {
+	return nil
+}
+
+// SetContainerName is a dummy function doing nothing.
+func SetContainerName(name string) {}
+
+// SetOutputFile is a dummy function doing nothing.
+func SetOutputFile(path string, maxSize int64, maxBackups int) error {
+	return nil
+}
+
+// SetTimestamps is a dummy function doing nothing.
+func SetTimestamps(mode string) error {
+	return nil
+}
+
+// EnableTimestamps is a dummy function doing nothing.
+func EnableTimestamps() {}
+
+// EnableRelativeTimestamps is a dummy function doing nothing.
+func EnableRelativeTimestamps() {}
+
+// DisableTimestamps is a dummy function doing nothing.
+func DisableTimestamps() {}
+
+// SetDestination is a dummy function doing nothing.
+func SetDestination(dest string) error {
+	return nil
+}
+
+// SetColors is a dummy function doing nothing.
+func SetColors(spec string) error {
+	return nil
+}
+
+// SetFormat is a dummy function doing nothing.
+func SetFormat(format string) error {
+	return nil
+}
+
+// Errorw is a dummy function doing nothing.
+func Errorw(msg string, keysAndValues ...interface{}) {}
+
+// Warningw is a dummy function doing nothing.
+func Warningw(msg string, keysAndValues ...interface{}) {}
+
+// Infow is a dummy function doing nothing.
+func Infow(msg string, keysAndValues ...interface{}) {}
+
+// Verbosew is a dummy function doing nothing.
+func Verbosew(msg string, keysAndValues ...interface{}) {}
+
+// Debugw is a dummy function doing nothing.
+func Debugw(msg string, keysAndValues ...interface{}) {}
+
+// Tracew is a dummy function doing nothing.
+func Tracew(msg string, keysAndValues ...interface{}) {}
+
+// Errorw is a dummy function doing nothing.
+func (l *Logger) Errorw(msg string, keysAndValues ...interface{}) {}
+
+// Warningw is a dummy function doing nothing.
+func (l *Logger) Warningw(msg string, keysAndValues ...interface{}) {}
+
+// Infow is a dummy function doing nothing.
+func (l *Logger) Infow(msg string, keysAndValues ...interface{}) {}
+
+// Verbosew is a dummy function doing nothing.
+func (l *Logger) Verbosew(msg string, keysAndValues ...interface{}) {}
+
+// Debugw is a dummy function doing nothing.
+func (l *Logger) Debugw(msg string, keysAndValues ...interface{}) {}
+
+// Tracew is a dummy function doing nothing.
+func (l *Logger) Tracew(msg string, keysAndValues ...interface{}) {}
+
+// Errorw is a dummy function doing nothing.
+func (s *SubLogger) Errorw(msg string, keysAndValues ...interface{}) {}
+
+// Warningw is a dummy function doing nothing.
+func (s *SubLogger) Warningw(msg string, keysAndValues ...interface{}) {}
+
+// Infow is a dummy function doing nothing.
+func (s *SubLogger) Infow(msg string, keysAndValues ...interface{}) {}
+
+// Verbosew is a dummy function doing nothing.
+func (s *SubLogger) Verbosew(msg string, keysAndValues ...interface{}) {}
+
+// Debugw is a dummy function doing nothing.
+func (s *SubLogger) Debugw(msg string, keysAndValues ...interface{}) {}
+
+// Tracew is a dummy function doing nothing.
+func (s *SubLogger) Tracew(msg string, keysAndValues ...interface{}) {}
+
+// AddSecret is a dummy function doing nothing.
+func AddSecret(secret string) {}
+
+// SetRedaction is a dummy function doing nothing.
+func SetRedaction(enabled bool) {}
+
+// GetEnvVars is a dummy function returning environment variable
+// with lowest message level.
+func GetEnvVars() []string {
+	return []string{GetEnvVar()}
+}
+
+// AddHook is a dummy function returning a function doing nothing.
+func AddHook(h Hook) func() {
+	return func() {}
+}
+
+// SetBackend is a dummy function doing nothing.
+func SetBackend(b Hook) {}
+
+// SetDeduplication is a dummy function doing nothing.
+func SetDeduplication(enabled bool) {}
+
+// Flush is a dummy function doing nothing.
+func Flush() {}
+
+// Span is a dummy span.
+type Span struct{}
+
+// StartSpan is a dummy function returning a nil span.
+func StartSpan(name string) *Span {
+	return nil
+}
+
+// SetAttribute is a dummy function doing nothing.
+func (s *Span) SetAttribute(key, value string) {}
+
+// End is a dummy function doing nothing.
+func (s *Span) End() {}
+
+// SetOTLPEndpoint is a dummy function doing nothing.
+func SetOTLPEndpoint(endpoint string) error {
+	return nil
+}
+
+// Relay is a dummy log relay.
+type Relay struct{}
+
+// NewRelay is a dummy function returning an error.
+func NewRelay() (*Relay, error) {
+	return nil, errors.New("log relay not supported")
+}
+
+// NewRelay is a dummy function returning an error.
+func (l *Logger) NewRelay() (*Relay, error) {
+	return nil, errors.New("log relay not supported")
+}
+
+// File is a dummy function returning nil.
+func (r *Relay) File() *os.File {
+	return nil
+}
+
+// EnvVar is a dummy function returning an empty string.
+func (r *Relay) EnvVar(fd int) string {
+	return ""
+}
+
+// Close is a dummy function doing nothing.
+func (r *Relay) Close() error {
+	return nil
+}
+
 // Writer is a dummy function returning ioutil.Discard writer.
 func Writer() io.Writer {
 	return ioutil.Discard
@@ -0,0 +1,118 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sylog
+
+import (
+	"errors"
+)
+
+// FatalExitCode is the exit code of Fatalf when no error code
+// is attached to its arguments.
+const FatalExitCode = 255
+
+// ErrorCode identifies a class of fatal errors, it is displayed along
+// with the fatal message and used as process exit code so batch
+// schedulers can distinguish failures without parsing messages. Error
+// codes use the 240-254 exit code range.
+type ErrorCode struct {
+	name string
+	exit int
+}
+
+// Error codes attached to fatal errors.
+var (
+	// ImageNotFound reports a missing container image.
+	ImageNotFound = ErrorCode{"E_IMAGE_NOTFOUND", 240}
+	// ImageInvalid reports an image in an unknown or corrupted format.
+	ImageInvalid = ErrorCode{"E_IMAGE_INVALID", 241}
+	// SignatureNotFound reports an image without the requested signatures.
+	SignatureNotFound = ErrorCode{"E_SIGNATURE_NOTFOUND", 242}
+	// SignatureInvalid reports a failed signature verification.
+	SignatureInvalid = ErrorCode{"E_SIGNATURE_INVALID", 243}
+	// ImageDenied reports an image prohibited by the execution control list.
+	ImageDenied = ErrorCode{"E_IMAGE_DENIED", 244}
+	// PullFailed reports an image download or conversion failure.
+	PullFailed = ErrorCode{"E_PULL_FAILED", 245}
+	// BuildFailed reports an image build failure.
+	BuildFailed = ErrorCode{"E_BUILD_FAILED", 246}
+	// MountFailed reports a failed mount during the container setup.
+	MountFailed = ErrorCode{"E_MOUNT_FAILED", 247}
+	// NetworkFailed reports a failed container network setup.
+	NetworkFailed = ErrorCode{"E_NETWORK_FAILED", 248}
+	// ConfigInvalid reports an invalid configuration file.
+	ConfigInvalid = ErrorCode{"E_CONFIG_INVALID", 249}
+)
+
+// String returns the error code name (eg: E_IMAGE_NOTFOUND).
+func (c ErrorCode) String() string {
+	return c.name
+}
+
+// ExitCode returns the process exit code of the error code.
+func (c ErrorCode) ExitCode() int {
+	return c.exit
+}
+
+// codedError is an error with an attached error code.
+type codedError struct {
+	code ErrorCode
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Unwrap() error {
+	return e.err
+}
+
+// WithCode attaches the error code to err, it returns nil if err is
+// nil. Error codes are preserved by errors wrapping err with the %w
+// verb of fmt.Errorf.
+func WithCode(code ErrorCode, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &codedError{code: code, err: err}
+}
+
+// CodeOf returns the error code attached to err or to one of the
+// errors it wraps, the innermost code being the more specific one.
+func CodeOf(err error) (ErrorCode, bool) {
+	var code ErrorCode
+	var found bool
+
+	for ; err != nil; err = errors.Unwrap(err) {
+		if e, ok := err.(*codedError); ok {
+			code = e.code
+			found = true
+		}
+	}
+	return code, found
+}
+
+// argsCode returns the error code attached to the first
+// error argument carrying one.
+func argsCode(a []interface{}) (ErrorCode, bool) {
+	for _, v := range a {
+		if err, ok := v.(error); ok {
+			if code, ok := CodeOf(err); ok {
+				return code, true
+			}
+		}
+	}
+	return ErrorCode{}, false
+}
+
+// fatalExitCode returns the exit code of a fatal
+// message formatted with the arguments a.
+func fatalExitCode(a []interface{}) int {
+	if code, ok := argsCode(a); ok {
+		return code.ExitCode()
+	}
+	return FatalExitCode
+}
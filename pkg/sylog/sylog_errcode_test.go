@@ -0,0 +1,80 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sylog
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCode(t *testing.T) {
+	if WithCode(MountFailed, nil) != nil {
+		t.Errorf("unexpected error returned for a nil error")
+	}
+
+	base := errors.New("no such file")
+	err := WithCode(ImageNotFound, base)
+
+	if err.Error() != base.Error() {
+		t.Errorf("unexpected error message %q", err)
+	}
+	if !errors.Is(err, base) {
+		t.Errorf("coded error doesn't wrap the original error")
+	}
+
+	tests := []struct {
+		name     string
+		err      error
+		expected ErrorCode
+		found    bool
+	}{
+		{
+			name:  "no code",
+			err:   base,
+			found: false,
+		},
+		{
+			name:     "coded",
+			err:      err,
+			expected: ImageNotFound,
+			found:    true,
+		},
+		{
+			name:     "wrapped",
+			err:      fmt.Errorf("container creation failed: %w", err),
+			expected: ImageNotFound,
+			found:    true,
+		},
+		{
+			name:     "innermost",
+			err:      WithCode(PullFailed, fmt.Errorf("while converting: %w", WithCode(MountFailed, base))),
+			expected: MountFailed,
+			found:    true,
+		},
+		{
+			name:  "formatted",
+			err:   fmt.Errorf("container creation failed: %s", err),
+			found: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, found := CodeOf(tt.err)
+			if found != tt.found || code != tt.expected {
+				t.Errorf("unexpected code %v (found: %v) instead of %v (found: %v)", code, found, tt.expected, tt.found)
+			}
+
+			exit := fatalExitCode([]interface{}{"image", tt.err})
+			if tt.found && exit != tt.expected.ExitCode() {
+				t.Errorf("unexpected exit code %d instead of %d", exit, tt.expected.ExitCode())
+			} else if !tt.found && exit != FatalExitCode {
+				t.Errorf("unexpected exit code %d instead of %d", exit, FatalExitCode)
+			}
+		})
+	}
+}
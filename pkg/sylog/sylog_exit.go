@@ -0,0 +1,80 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sylog
+
+import (
+	"os"
+	"sync"
+)
+
+type exitHookEntry struct {
+	hook func()
+}
+
+var (
+	exitMutex sync.Mutex
+	exitHooks []*exitHookEntry
+	exiting   bool
+)
+
+// RegisterExitHook registers a cleanup function called by Fatalf and Exit
+// before the process terminates, hooks are called in the reverse order of
+// their registration. It returns a function to remove the hook once the
+// cleanup isn't required anymore.
+func RegisterExitHook(h func()) func() {
+	entry := &exitHookEntry{hook: h}
+
+	exitMutex.Lock()
+	exitHooks = append(exitHooks, entry)
+	exitMutex.Unlock()
+
+	return func() {
+		exitMutex.Lock()
+		defer exitMutex.Unlock()
+
+		for i, e := range exitHooks {
+			if e == entry {
+				exitHooks = append(exitHooks[:i], exitHooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// runExitHooks calls the registered exit hooks once, a hook calling
+// Fatalf terminates the process without calling the remaining hooks.
+func runExitHooks() {
+	exitMutex.Lock()
+	if exiting {
+		exitMutex.Unlock()
+		return
+	}
+	exiting = true
+	entries := exitHooks
+	exitHooks = nil
+	exitMutex.Unlock()
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		runExitHook(entries[i].hook)
+	}
+}
+
+// runExitHook calls the hook, a panicking hook doesn't
+// prevent the others to be called.
+func runExitHook(h func()) {
+	defer func() {
+		recover()
+	}()
+	h()
+}
+
+// Exit calls the registered exit hooks, flushes the pending messages
+// and spans and terminates the process with the exit code.
+func Exit(code int) {
+	runExitHooks()
+	Flush()
+	os.Exit(code)
+}
@@ -0,0 +1,45 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sylog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExitHooks(t *testing.T) {
+	defer func() {
+		exiting = false
+	}()
+
+	var calls []string
+
+	RegisterExitHook(func() {
+		calls = append(calls, "unmount")
+	})
+	RegisterExitHook(func() {
+		panic("cleanup failure")
+	})
+	remove := RegisterExitHook(func() {
+		calls = append(calls, "removed")
+	})
+	RegisterExitHook(func() {
+		calls = append(calls, "remove sandbox")
+	})
+	remove()
+
+	runExitHooks()
+	// hooks are only called once
+	runExitHooks()
+
+	expected := []string{"remove sandbox", "unmount"}
+	if !reflect.DeepEqual(calls, expected) {
+		t.Errorf("unexpected hook calls %v instead of %v", calls, expected)
+	}
+	if len(exitHooks) != 0 {
+		t.Errorf("unexpected remaining exit hooks")
+	}
+}
@@ -0,0 +1,275 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// formatEnv holds the message format, either "text" or "json".
+const formatEnv = "SINGULARITY_LOG_FORMAT"
+
+// missingValue is the value of a trailing key without value.
+const missingValue = "(MISSING)"
+
+// field is a key/value pair attached to a message.
+type field struct {
+	key   string
+	value interface{}
+}
+
+var (
+	formatMutex  sync.Mutex
+	outputFormat = TextFormat
+)
+
+func init() {
+	// errors are ignored, there is nothing we could
+	// report them to at this stage
+	SetFormat(os.Getenv(formatEnv))
+}
+
+// SetFormat sets the format of the messages written by the loggers
+// and to the log file, format is either TextFormat or JSONFormat.
+func SetFormat(format string) error {
+	switch format {
+	case "":
+		format = TextFormat
+	case TextFormat, JSONFormat:
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	formatMutex.Lock()
+	outputFormat = format
+	formatMutex.Unlock()
+
+	return nil
+}
+
+func jsonOutput() bool {
+	formatMutex.Lock()
+	defer formatMutex.Unlock()
+	return outputFormat == JSONFormat
+}
+
+// getFormatEnvVars returns the environment variables required
+// by a child process to write messages in the same format.
+func getFormatEnvVars() []string {
+	formatMutex.Lock()
+	defer formatMutex.Unlock()
+
+	if outputFormat == TextFormat {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s=%s", formatEnv, outputFormat)}
+}
+
+// parseFields converts alternated keys and values to fields, string
+// values are redacted and errors or fmt.Stringer values are converted
+// to strings.
+func parseFields(keysAndValues []interface{}) []field {
+	if len(keysAndValues) == 0 {
+		return nil
+	}
+
+	fields := make([]field, 0, (len(keysAndValues)+1)/2)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		f := field{
+			key:   fmt.Sprint(keysAndValues[i]),
+			value: missingValue,
+		}
+		if i+1 < len(keysAndValues) {
+			f.value = keysAndValues[i+1]
+		}
+		switch v := f.value.(type) {
+		case string:
+			f.value = redact(v)
+		case error:
+			f.value = redact(v.Error())
+		case fmt.Stringer:
+			f.value = redact(v.String())
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// formatFields returns the fields in the key=value form appended
+// to text messages, values containing spaces or quotes are quoted.
+func formatFields(fields []field) string {
+	var b strings.Builder
+
+	for _, f := range fields {
+		v := fmt.Sprint(f.value)
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(&b, " %s=%s", f.key, v)
+	}
+	return b.String()
+}
+
+// jsonRecord returns the JSON line written for a message in the JSON
+// format, the user and process IDs are only included for levels >= debug.
+func jsonRecord(name string, logLevel, msgLevel messageLevel, msg string, fields []field) string {
+	var b bytes.Buffer
+
+	b.WriteString(`{"time":`)
+	writeJSONValue(&b, time.Now().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSONValue(&b, strings.ToLower(msgLevel.String()))
+	if name != "" {
+		b.WriteString(`,"logger":`)
+		writeJSONValue(&b, name)
+	}
+	if logLevel >= DebugLevel {
+		fmt.Fprintf(&b, `,"uid":%d,"pid":%d`, os.Geteuid(), os.Getpid())
+	}
+	b.WriteString(`,"msg":`)
+	writeJSONValue(&b, msg)
+	for _, f := range fields {
+		b.WriteByte(',')
+		writeJSONValue(&b, f.key)
+		b.WriteByte(':')
+		writeJSONValue(&b, f.value)
+	}
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// writeJSONValue writes the JSON encoding of v, values which can't
+// be encoded are written as strings.
+func writeJSONValue(b *bytes.Buffer, v interface{}) {
+	enc, err := json.Marshal(v)
+	if err != nil {
+		enc, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(enc)
+}
+
+// writew writes the message and its fields through the logger l,
+// writew is the structured counterpart of writef.
+func writew(l *Logger, name string, msgLevel messageLevel, msg string, keysAndValues []interface{}) {
+	logLevel := l.levelFor(name)
+	if logLevel < msgLevel && !forwarded(msgLevel) {
+		return
+	}
+
+	message := redact(strings.TrimRight(msg, "\n"))
+	fields := parseFields(keysAndValues)
+
+	if throttled(l, name, logLevel, msgLevel, message, fields) {
+		return
+	}
+
+	p := ""
+	if logLevel >= msgLevel {
+		p = timestamp() + prefix(l, logLevel, msgLevel)
+	}
+	output(l, name, logLevel, msgLevel, p, message, fields)
+}
+
+// Errorw writes an ERROR level message with the alternated keys and
+// values appended as fields (eg: Errorw("mount failed", "bind", path)).
+func Errorw(msg string, keysAndValues ...interface{}) {
+	writew(defaultLogger, "", ErrorLevel, msg, keysAndValues)
+}
+
+// Warningw writes a WARNING level message with fields.
+func Warningw(msg string, keysAndValues ...interface{}) {
+	writew(defaultLogger, "", WarnLevel, msg, keysAndValues)
+}
+
+// Infow writes an INFO level message with fields.
+func Infow(msg string, keysAndValues ...interface{}) {
+	writew(defaultLogger, "", InfoLevel, msg, keysAndValues)
+}
+
+// Verbosew writes a VERBOSE level message with fields.
+func Verbosew(msg string, keysAndValues ...interface{}) {
+	writew(defaultLogger, "", VerboseLevel, msg, keysAndValues)
+}
+
+// Debugw writes a DEBUG level message with fields.
+func Debugw(msg string, keysAndValues ...interface{}) {
+	writew(defaultLogger, "", DebugLevel, msg, keysAndValues)
+}
+
+// Tracew writes a TRACE level message with fields.
+func Tracew(msg string, keysAndValues ...interface{}) {
+	writew(defaultLogger, "", TraceLevel, msg, keysAndValues)
+}
+
+// Errorw is equivalent to Errorw for the logger.
+func (l *Logger) Errorw(msg string, keysAndValues ...interface{}) {
+	writew(l, "", ErrorLevel, msg, keysAndValues)
+}
+
+// Warningw is equivalent to Warningw for the logger.
+func (l *Logger) Warningw(msg string, keysAndValues ...interface{}) {
+	writew(l, "", WarnLevel, msg, keysAndValues)
+}
+
+// Infow is equivalent to Infow for the logger.
+func (l *Logger) Infow(msg string, keysAndValues ...interface{}) {
+	writew(l, "", InfoLevel, msg, keysAndValues)
+}
+
+// Verbosew is equivalent to Verbosew for the logger.
+func (l *Logger) Verbosew(msg string, keysAndValues ...interface{}) {
+	writew(l, "", VerboseLevel, msg, keysAndValues)
+}
+
+// Debugw is equivalent to Debugw for the logger.
+func (l *Logger) Debugw(msg string, keysAndValues ...interface{}) {
+	writew(l, "", DebugLevel, msg, keysAndValues)
+}
+
+// Tracew is equivalent to Tracew for the logger.
+func (l *Logger) Tracew(msg string, keysAndValues ...interface{}) {
+	writew(l, "", TraceLevel, msg, keysAndValues)
+}
+
+// Errorw is equivalent to Errorw for the sub logger.
+func (s *SubLogger) Errorw(msg string, keysAndValues ...interface{}) {
+	writew(s.logger, s.name, ErrorLevel, msg, keysAndValues)
+}
+
+// Warningw is equivalent to Warningw for the sub logger.
+func (s *SubLogger) Warningw(msg string, keysAndValues ...interface{}) {
+	writew(s.logger, s.name, WarnLevel, msg, keysAndValues)
+}
+
+// Infow is equivalent to Infow for the sub logger.
+func (s *SubLogger) Infow(msg string, keysAndValues ...interface{}) {
+	writew(s.logger, s.name, InfoLevel, msg, keysAndValues)
+}
+
+// Verbosew is equivalent to Verbosew for the sub logger.
+func (s *SubLogger) Verbosew(msg string, keysAndValues ...interface{}) {
+	writew(s.logger, s.name, VerboseLevel, msg, keysAndValues)
+}
+
+// Debugw is equivalent to Debugw for the sub logger.
+func (s *SubLogger) Debugw(msg string, keysAndValues ...interface{}) {
+	writew(s.logger, s.name, DebugLevel, msg, keysAndValues)
+}
+
+// Tracew is equivalent to Tracew for the sub logger.
+func (s *SubLogger) Tracew(msg string, keysAndValues ...interface{}) {
+	writew(s.logger, s.name, TraceLevel, msg, keysAndValues)
+}
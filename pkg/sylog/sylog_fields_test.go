@@ -0,0 +1,145 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestFormatFields(t *testing.T) {
+	tests := []struct {
+		name          string
+		keysAndValues []interface{}
+		expected      string
+	}{
+		{
+			name:     "no fields",
+			expected: "",
+		},
+		{
+			name:          "simple values",
+			keysAndValues: []interface{}{"image", "test.sif", "size", 42},
+			expected:      " image=test.sif size=42",
+		},
+		{
+			name:          "quoted values",
+			keysAndValues: []interface{}{"bind", "/my dir", "empty", "", "opts", "a=b"},
+			expected:      ` bind="/my dir" empty="" opts="a=b"`,
+		},
+		{
+			name:          "error and stringer",
+			keysAndValues: []interface{}{"error", errors.New("failed"), "duration", 1500 * time.Millisecond},
+			expected:      " error=failed duration=1.5s",
+		},
+		{
+			name:          "missing value",
+			keysAndValues: []interface{}{"image", "test.sif", "dest"},
+			expected:      " image=test.sif dest=(MISSING)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := formatFields(parseFields(tt.keysAndValues))
+			if s != tt.expected {
+				t.Errorf("unexpected fields %q instead of %q", s, tt.expected)
+			}
+		})
+	}
+}
+
+func TestWritew(t *testing.T) {
+	var buf bytes.Buffer
+
+	defaultLogger.writer = &buf
+	defer func() {
+		defaultLogger.writer = defaultWriter
+	}()
+
+	SetLevel(int(InfoLevel), false)
+
+	var hookFields map[string]interface{}
+	remove := AddHook(func(level int, msg string, fields map[string]interface{}) {
+		hookFields = fields
+	})
+	defer remove()
+
+	Infow("Mounting", "source", "/tmp", "dest", "/mnt")
+	Debugw("hidden", "key", "value")
+
+	expected := "INFO:    Mounting source=/tmp dest=/mnt\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected output %q instead of %q", buf.String(), expected)
+	}
+	if hookFields["source"] != "/tmp" || hookFields["dest"] != "/mnt" {
+		t.Errorf("unexpected hook fields: %v", hookFields)
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+
+	defaultLogger.writer = &buf
+	defer func() {
+		defaultLogger.writer = defaultWriter
+		SetFormat(TextFormat)
+	}()
+
+	SetLevel(int(InfoLevel), false)
+
+	if err := SetFormat("xml"); err == nil {
+		t.Errorf("unexpected success with unknown format")
+	}
+	if err := SetFormat(JSONFormat); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if env := getFormatEnvVars(); len(env) != 1 || env[0] != formatEnv+"=json" {
+		t.Errorf("unexpected environment %v", env)
+	}
+
+	NewSubLogger("network").Warningw("Network setup", "networks", "bridge", "count", 2)
+
+	var rec map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("unexpected error while decoding %q: %s", buf.String(), err)
+	}
+
+	expected := map[string]interface{}{
+		"level":    "warning",
+		"logger":   "network",
+		"msg":      "Network setup",
+		"networks": "bridge",
+		"count":    float64(2),
+	}
+	for k, v := range expected {
+		if rec[k] != v {
+			t.Errorf("unexpected value %v for %s instead of %v", rec[k], k, v)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, rec["time"].(string)); err != nil {
+		t.Errorf("unexpected time %v: %s", rec["time"], err)
+	}
+	if _, ok := rec["pid"]; ok {
+		t.Errorf("unexpected pid field below debug level")
+	}
+
+	buf.Reset()
+	Infof("plain message")
+
+	rec = nil
+	if err := json.Unmarshal(buf.Bytes(), &rec); err != nil {
+		t.Fatalf("unexpected error while decoding %q: %s", buf.String(), err)
+	}
+	if rec["msg"] != "plain message" || rec["level"] != "info" {
+		t.Errorf("unexpected record %v", rec)
+	}
+}
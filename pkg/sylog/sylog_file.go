@@ -0,0 +1,206 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// outputFileEnv holds the path of the log file.
+	outputFileEnv = "SINGULARITY_LOGFILE"
+	// outputFileRotateEnv holds the rotation parameters of the
+	// log file in the form "maxSize,maxBackups".
+	outputFileRotateEnv = "SINGULARITY_LOGFILE_ROTATE"
+
+	// defaultOutputFileMaxSize is the default maximum size in bytes
+	// of the log file before rotation.
+	defaultOutputFileMaxSize = 10 * 1024 * 1024
+	// defaultOutputFileMaxBackups is the default number of rotated
+	// log files kept.
+	defaultOutputFileMaxBackups = 3
+)
+
+// rotateFile is a file writer rotating the file once it
+// reaches a maximum size.
+type rotateFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+var (
+	outputFileMutex sync.Mutex
+	outputFile      *rotateFile
+)
+
+func init() {
+	path := os.Getenv(outputFileEnv)
+	if path == "" {
+		return
+	}
+	// don't let a privileged process write to a file
+	// provided by an unprivileged user
+	if os.Geteuid() != os.Getuid() {
+		return
+	}
+
+	maxSize := int64(defaultOutputFileMaxSize)
+	maxBackups := defaultOutputFileMaxBackups
+
+	if rotate := strings.SplitN(os.Getenv(outputFileRotateEnv), ",", 2); len(rotate) == 2 {
+		if s, err := strconv.ParseInt(rotate[0], 10, 64); err == nil {
+			maxSize = s
+		}
+		if b, err := strconv.Atoi(rotate[1]); err == nil {
+			maxBackups = b
+		}
+	}
+
+	// errors are ignored, there is nothing we could
+	// report them to at this stage
+	SetOutputFile(path, maxSize, maxBackups)
+}
+
+// SetOutputFile duplicates all messages displayed into the file at path.
+// Once the file reaches maxSize bytes, it is rotated and up to maxBackups
+// previous files are kept with a numbered suffix (path.1, path.2 ...).
+// A maxSize of zero disables rotation. An empty path stops writing
+// messages to the current file.
+func SetOutputFile(path string, maxSize int64, maxBackups int) error {
+	outputFileMutex.Lock()
+	defer outputFileMutex.Unlock()
+
+	if outputFile != nil {
+		outputFile.close()
+		outputFile = nil
+	}
+	if path == "" {
+		return nil
+	}
+
+	rf := &rotateFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+	}
+	if err := rf.open(); err != nil {
+		return err
+	}
+	outputFile = rf
+
+	return nil
+}
+
+// writeOutputFile writes the message line to the log file if any.
+func writeOutputFile(line string) {
+	outputFileMutex.Lock()
+	defer outputFileMutex.Unlock()
+
+	if outputFile == nil {
+		return
+	}
+	// a failure to write must not disrupt the execution
+	// and can't be reported through the logger itself
+	outputFile.Write([]byte(line))
+}
+
+// syncOutputFile commits the log file content to disk.
+func syncOutputFile() {
+	outputFileMutex.Lock()
+	defer outputFileMutex.Unlock()
+
+	if outputFile != nil && outputFile.file != nil {
+		outputFile.file.Sync()
+	}
+}
+
+func (rf *rotateFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("while opening log file %s: %s", rf.path, err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("while getting log file %s information: %s", rf.path, err)
+	}
+	rf.file = f
+	rf.size = fi.Size()
+	return nil
+}
+
+func (rf *rotateFile) close() error {
+	if rf.file == nil {
+		return nil
+	}
+	err := rf.file.Close()
+	rf.file = nil
+	return err
+}
+
+// rotate shifts the numbered backups, moves the current file
+// to the first backup and opens a fresh file.
+func (rf *rotateFile) rotate() error {
+	if err := rf.close(); err != nil {
+		return err
+	}
+
+	if rf.maxBackups <= 0 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return rf.open()
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.maxBackups))
+	for i := rf.maxBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return rf.open()
+}
+
+func (rf *rotateFile) Write(b []byte) (int, error) {
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(b)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	if rf.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	n, err := rf.file.Write(b)
+	rf.size += int64(n)
+	return n, err
+}
+
+// getOutputFileEnvVars returns the environment variables required
+// by a child process to write into the same log file.
+func getOutputFileEnvVars() []string {
+	outputFileMutex.Lock()
+	defer outputFileMutex.Unlock()
+
+	if outputFile == nil {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("%s=%s", outputFileEnv, outputFile.path),
+		fmt.Sprintf("%s=%d,%d", outputFileRotateEnv, outputFile.maxSize, outputFile.maxBackups),
+	}
+}
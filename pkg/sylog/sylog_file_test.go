@@ -0,0 +1,68 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestOutputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sylog-file-")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+	defaultLogger.writer = &buf
+
+	path := filepath.Join(dir, "singularity.log")
+	if err := SetOutputFile(path, 64, 2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	defer func() {
+		defaultLogger.writer = defaultWriter
+		SetOutputFile("", 0, 0)
+	}()
+
+	SetLevel(int(InfoLevel), true)
+	SetDeduplication(false)
+	defer SetDeduplication(true)
+
+	for _, msg := range []string{"first message", "second message", "third message", "fourth message", "fifth message"} {
+		Infof("%s", msg)
+	}
+
+	if !strings.Contains(buf.String(), "\x1b[") {
+		t.Errorf("colors are expected in the terminal output")
+	}
+
+	expected := map[string]string{
+		path:        "INFO:    fifth message\n",
+		path + ".1": "INFO:    third message\nINFO:    fourth message\n",
+		path + ".2": "INFO:    first message\nINFO:    second message\n",
+	}
+	for p, content := range expected {
+		b, err := ioutil.ReadFile(p)
+		if err != nil {
+			t.Fatalf("failed to read %s: %s", p, err)
+		}
+		if string(b) != content {
+			t.Errorf("unexpected content for %s: %q", p, string(b))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Errorf("unexpected backup file %s.3", path)
+	}
+}
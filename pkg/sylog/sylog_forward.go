@@ -0,0 +1,242 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log/syslog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// forwardEnv holds the forwarding configuration passed to child
+	// processes in the form "backend,level,container".
+	forwardEnv = "SINGULARITY_LOGFORWARD"
+
+	// journaldSocket is the native protocol socket of systemd-journald.
+	journaldSocket = "/run/systemd/journal/socket"
+
+	// forwardTag is the identifier used for forwarded messages.
+	forwardTag = "singularity"
+)
+
+type forwarder interface {
+	forward(level messageLevel, msg string) error
+	close() error
+}
+
+var (
+	forwardMutex   sync.Mutex
+	forwardBackend forwarder
+	forwardName    string
+	forwardLevel   messageLevel = WarnLevel
+	containerName  string
+)
+
+func init() {
+	env := os.Getenv(forwardEnv)
+	if env == "" {
+		return
+	}
+	fields := strings.SplitN(env, ",", 3)
+	if len(fields) != 3 {
+		return
+	}
+	level, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return
+	}
+	containerName = fields[2]
+	// errors are ignored, there is nothing we could
+	// report them to at this stage
+	SetForwarding(fields[0], level)
+}
+
+// SetForwarding configures an additional backend receiving any messages
+// at or above level. The backend is one of SyslogBackend, JournaldBackend
+// or NoBackend to disable forwarding.
+func SetForwarding(backend string, level int) error {
+	forwardMutex.Lock()
+	defer forwardMutex.Unlock()
+
+	if forwardBackend != nil {
+		forwardBackend.close()
+		forwardBackend = nil
+		forwardName = ""
+	}
+
+	var err error
+
+	switch backend {
+	case "", NoBackend:
+		return nil
+	case SyslogBackend:
+		forwardBackend, err = newSyslogForwarder()
+	case JournaldBackend:
+		forwardBackend, err = newJournaldForwarder()
+	default:
+		return fmt.Errorf("unknown log forwarding backend %q", backend)
+	}
+	if err != nil {
+		return fmt.Errorf("while connecting to %s: %s", backend, err)
+	}
+
+	forwardName = backend
+	forwardLevel = messageLevel(level)
+
+	return nil
+}
+
+// SetContainerName sets the container name reported along with
+// forwarded messages.
+func SetContainerName(name string) {
+	forwardMutex.Lock()
+	containerName = name
+	forwardMutex.Unlock()
+}
+
+// getForwardEnvVars returns the environment variables required
+// by a child process to forward messages to the same backend.
+func getForwardEnvVars() []string {
+	forwardMutex.Lock()
+	defer forwardMutex.Unlock()
+
+	if forwardBackend == nil {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("%s=%s,%d,%s", forwardEnv, forwardName, forwardLevel, containerName),
+	}
+}
+
+// forwarded returns whether a message at msgLevel would be
+// forwarded to the configured backend.
+func forwarded(msgLevel messageLevel) bool {
+	forwardMutex.Lock()
+	defer forwardMutex.Unlock()
+
+	return forwardBackend != nil && forwardLevel >= msgLevel
+}
+
+// forward sends the message to the configured backend if any.
+func forward(msgLevel messageLevel, msg string) {
+	forwardMutex.Lock()
+	defer forwardMutex.Unlock()
+
+	if forwardBackend == nil || forwardLevel < msgLevel {
+		return
+	}
+	// a failure to forward must not disrupt the execution
+	// and can't be reported through the logger itself
+	forwardBackend.forward(msgLevel, msg)
+}
+
+type syslogForwarder struct {
+	w *syslog.Writer
+}
+
+func newSyslogForwarder() (forwarder, error) {
+	w, err := syslog.New(syslog.LOG_USER|syslog.LOG_INFO, forwardTag)
+	if err != nil {
+		return nil, err
+	}
+	return &syslogForwarder{w: w}, nil
+}
+
+func (s *syslogForwarder) forward(level messageLevel, msg string) error {
+	msg = fmt.Sprintf("level=%s uid=%d container=%q %s", level, os.Geteuid(), containerName, msg)
+
+	switch {
+	case level <= FatalLevel:
+		return s.w.Crit(msg)
+	case level == ErrorLevel:
+		return s.w.Err(msg)
+	case level == WarnLevel:
+		return s.w.Warning(msg)
+	case level <= InfoLevel:
+		return s.w.Info(msg)
+	default:
+		return s.w.Debug(msg)
+	}
+}
+
+func (s *syslogForwarder) close() error {
+	return s.w.Close()
+}
+
+type journaldForwarder struct {
+	conn *net.UnixConn
+}
+
+func newJournaldForwarder() (forwarder, error) {
+	addr := &net.UnixAddr{Name: journaldSocket, Net: "unixgram"}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &journaldForwarder{conn: conn}, nil
+}
+
+// journaldPriority maps a message level to a syslog priority value
+// as expected by the journald PRIORITY field.
+func journaldPriority(level messageLevel) int {
+	switch {
+	case level <= FatalLevel:
+		return 2
+	case level == ErrorLevel:
+		return 3
+	case level == WarnLevel:
+		return 4
+	case level <= InfoLevel:
+		return 6
+	default:
+		return 7
+	}
+}
+
+// writeJournaldField appends a field to the buffer using the
+// binary safe serialization when the value contains new lines.
+func writeJournaldField(b *bytes.Buffer, key, value string) {
+	b.WriteString(key)
+	if !strings.Contains(value, "\n") {
+		b.WriteByte('=')
+		b.WriteString(value)
+		b.WriteByte('\n')
+		return
+	}
+	b.WriteByte('\n')
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value)
+	b.WriteByte('\n')
+}
+
+func (j *journaldForwarder) forward(level messageLevel, msg string) error {
+	var b bytes.Buffer
+
+	writeJournaldField(&b, "MESSAGE", msg)
+	writeJournaldField(&b, "PRIORITY", strconv.Itoa(journaldPriority(level)))
+	writeJournaldField(&b, "SYSLOG_IDENTIFIER", forwardTag)
+	writeJournaldField(&b, "SINGULARITY_LEVEL", level.String())
+	writeJournaldField(&b, "SINGULARITY_UID", strconv.Itoa(os.Geteuid()))
+	if containerName != "" {
+		writeJournaldField(&b, "SINGULARITY_CONTAINER", containerName)
+	}
+
+	_, err := j.conn.Write(b.Bytes())
+	return err
+}
+
+func (j *journaldForwarder) close() error {
+	return j.conn.Close()
+}
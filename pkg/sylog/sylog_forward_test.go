@@ -0,0 +1,101 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+type testForwarder struct {
+	messages []string
+}
+
+func (t *testForwarder) forward(level messageLevel, msg string) error {
+	t.messages = append(t.messages, level.String()+":"+msg)
+	return nil
+}
+
+func (t *testForwarder) close() error {
+	return nil
+}
+
+func TestForward(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger.writer = &buf
+
+	tf := new(testForwarder)
+	forwardBackend = tf
+	forwardLevel = WarnLevel
+
+	defer func() {
+		defaultLogger.writer = defaultWriter
+		forwardBackend = nil
+	}()
+
+	// forwarding must happen even if messages are not displayed
+	SetLevel(int(ErrorLevel), false)
+
+	Errorf("error message")
+	Warningf("warning message")
+	Infof("info message")
+
+	expected := []string{"ERROR:error message", "WARNING:warning message"}
+	if strings.Join(tf.messages, "|") != strings.Join(expected, "|") {
+		t.Errorf("unexpected forwarded messages: %v", tf.messages)
+	}
+	if strings.Contains(buf.String(), "warning message") {
+		t.Errorf("warning message shouldn't be displayed with error level")
+	}
+}
+
+func TestWriteJournaldField(t *testing.T) {
+	var b bytes.Buffer
+
+	writeJournaldField(&b, "MESSAGE", "simple")
+	if b.String() != "MESSAGE=simple\n" {
+		t.Errorf("unexpected field serialization: %q", b.String())
+	}
+
+	b.Reset()
+	writeJournaldField(&b, "MESSAGE", "multi\nline")
+	expected := "MESSAGE\n\x0a\x00\x00\x00\x00\x00\x00\x00multi\nline\n"
+	if b.String() != expected {
+		t.Errorf("unexpected field serialization: %q", b.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		level    string
+		expected int
+		err      bool
+	}{
+		{level: "warning", expected: int(WarnLevel)},
+		{level: "DEBUG", expected: int(DebugLevel)},
+		{level: "verbose3", expected: int(Verbose3Level)},
+		{level: "trace", expected: int(TraceLevel)},
+		{level: "verbose4", expected: int(TraceLevel)},
+		{level: "6", expected: int(TraceLevel)},
+		{level: "-3", expected: int(ErrorLevel)},
+		{level: "42", err: true},
+		{level: "loud", err: true},
+	}
+
+	for _, tt := range tests {
+		l, err := ParseLevel(tt.level)
+		if tt.err && err == nil {
+			t.Errorf("unexpected success for level %q", tt.level)
+		} else if !tt.err && err != nil {
+			t.Errorf("unexpected error for level %q: %s", tt.level, err)
+		} else if l != tt.expected {
+			t.Errorf("level %q returned %d instead of %d", tt.level, l, tt.expected)
+		}
+	}
+}
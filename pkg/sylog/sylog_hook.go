@@ -0,0 +1,112 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"os"
+	"sync"
+)
+
+type hookEntry struct {
+	hook Hook
+}
+
+var (
+	hooksMutex sync.RWMutex
+	hooks      []*hookEntry
+	// backend replaces the log output when set
+	backend Hook
+)
+
+// AddHook registers a hook called for each message written to the log
+// output. It returns a function to remove the hook.
+func AddHook(h Hook) func() {
+	entry := &hookEntry{hook: h}
+
+	hooksMutex.Lock()
+	hooks = append(hooks, entry)
+	hooksMutex.Unlock()
+
+	return func() {
+		hooksMutex.Lock()
+		defer hooksMutex.Unlock()
+
+		for i, e := range hooks {
+			if e == entry {
+				hooks = append(hooks[:i], hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// SetBackend replaces the log output (terminal and log file) by the
+// provided backend receiving all messages at or above the current
+// message level. A nil backend restores the default log output.
+func SetBackend(b Hook) {
+	hooksMutex.Lock()
+	backend = b
+	hooksMutex.Unlock()
+}
+
+// writeBackend sends the message to the backend if any and
+// returns true, otherwise it returns false.
+func writeBackend(name string, msgLevel messageLevel, msg string, fields []field) bool {
+	hooksMutex.RLock()
+	b := backend
+	hooksMutex.RUnlock()
+
+	if b == nil {
+		return false
+	}
+	b(int(msgLevel), msg, messageFields(name, fields))
+	return true
+}
+
+// messageFields returns the fields associated to a message
+// logged through the sub logger name along with the message
+// own fields.
+func messageFields(name string, msgFields []field) map[string]interface{} {
+	fields := map[string]interface{}{
+		"uid": os.Geteuid(),
+		"pid": os.Getpid(),
+	}
+	for _, f := range msgFields {
+		fields[f.key] = f.value
+	}
+	if name != "" {
+		fields["logger"] = name
+	}
+
+	forwardMutex.Lock()
+	if containerName != "" {
+		fields["container"] = containerName
+	}
+	forwardMutex.Unlock()
+
+	return fields
+}
+
+// callHooks calls the registered hooks with the message and
+// its associated fields.
+func callHooks(name string, msgLevel messageLevel, msg string, msgFields []field) {
+	hooksMutex.RLock()
+	entries := make([]*hookEntry, len(hooks))
+	copy(entries, hooks)
+	hooksMutex.RUnlock()
+
+	if len(entries) == 0 {
+		return
+	}
+
+	fields := messageFields(name, msgFields)
+
+	for _, e := range entries {
+		e.hook(int(msgLevel), msg, fields)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package sylog
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// logrusLevel converts a message level to a logrus level.
+func logrusLevel(level int) logrus.Level {
+	switch l := messageLevel(level); {
+	case l <= ErrorLevel:
+		return logrus.ErrorLevel
+	case l == WarnLevel:
+		return logrus.WarnLevel
+	case l <= InfoLevel:
+		return logrus.InfoLevel
+	case l >= TraceLevel:
+		return logrus.TraceLevel
+	default:
+		return logrus.DebugLevel
+	}
+}
+
+// LogrusBackend returns a backend writing messages to the provided
+// logrus logger, message fields are passed as logrus fields.
+func LogrusBackend(l *logrus.Logger) Hook {
+	return func(level int, msg string, fields map[string]interface{}) {
+		l.WithFields(logrus.Fields(fields)).Log(logrusLevel(level), msg)
+	}
+}
+
+// SetLogrusBackend sends all messages to the provided logrus logger
+// instead of the default log output.
+func SetLogrusBackend(l *logrus.Logger) {
+	SetBackend(LogrusBackend(l))
+}
+
+// LogrusHook is a logrus hook writing entries through sylog, it allows
+// to display messages of packages using logrus with the same format
+// and verbosity.
+type LogrusHook struct{}
+
+// Levels returns the logrus levels handled by the hook.
+func (LogrusHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire writes the logrus entry through sylog. Fatal and panic entries
+// are written as errors and let logrus terminate the execution.
+func (LogrusHook) Fire(e *logrus.Entry) error {
+	keys := make([]string, 0, len(e.Data))
+	for k := range e.Data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	msg := e.Message
+	for _, k := range keys {
+		msg += fmt.Sprintf(" %s=%v", k, e.Data[k])
+	}
+
+	switch e.Level {
+	case logrus.PanicLevel, logrus.FatalLevel, logrus.ErrorLevel:
+		Errorf("%s", msg)
+	case logrus.WarnLevel:
+		Warningf("%s", msg)
+	case logrus.InfoLevel:
+		Infof("%s", msg)
+	case logrus.TraceLevel:
+		Tracef("%s", msg)
+	default:
+		Debugf("%s", msg)
+	}
+	return nil
+}
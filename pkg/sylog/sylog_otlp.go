@@ -0,0 +1,180 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	otlpTracesPath  = "/v1/traces"
+	otlpServiceName = "singularity"
+	otlpTimeout     = 5 * time.Second
+	// otlpSpanKindInternal is the OTLP internal span kind
+	otlpSpanKindInternal = 1
+)
+
+// The following types are the OTLP/HTTP JSON encoding of
+// the trace export request.
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpEvent struct {
+	TimeUnixNano string          `json:"timeUnixNano"`
+	Name         string          `json:"name"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Events            []otlpEvent     `json:"events,omitempty"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// otlpExporter sends spans to an OpenTelemetry collector.
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+func newOTLPExporter(endpoint string) (*otlpExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP endpoint %s: %s", endpoint, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %s: scheme must be http or https", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpTracesPath
+	}
+	return &otlpExporter{
+		url:    u.String(),
+		client: &http.Client{Timeout: otlpTimeout},
+	}, nil
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]otlpAttribute, 0, len(keys))
+	for _, k := range keys {
+		attrs = append(attrs, otlpAttribute{Key: k, Value: otlpValue{StringValue: attributes[k]}})
+	}
+	return attrs
+}
+
+// encode returns the OTLP/HTTP JSON export request for spans.
+func (e *otlpExporter) encode(spans []*Span) ([]byte, error) {
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "sylog"
+
+	for _, s := range spans {
+		s.mu.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              otlpSpanKindInternal,
+			StartTimeUnixNano: unixNano(s.start),
+			EndTimeUnixNano:   unixNano(s.end),
+			Attributes:        otlpAttributes(s.attributes),
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, ev := range s.events {
+			attrs := map[string]string{
+				"level":   ev.level.String(),
+				"message": ev.msg,
+			}
+			if ev.logger != "" {
+				attrs["logger"] = ev.logger
+			}
+			span.Events = append(span.Events, otlpEvent{
+				TimeUnixNano: unixNano(ev.time),
+				Name:         "log",
+				Attributes:   otlpAttributes(attrs),
+			})
+		}
+		s.mu.Unlock()
+
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	rs := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	rs.Resource.Attributes = otlpAttributes(map[string]string{
+		"service.name": otlpServiceName,
+	})
+
+	return json.Marshal(&otlpRequest{ResourceSpans: []otlpResourceSpans{rs}})
+}
+
+func (e *otlpExporter) export(spans []*Span) error {
+	b, err := e.encode(spans)
+	if err != nil {
+		return fmt.Errorf("while encoding spans: %s", err)
+	}
+
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response from %s: %s", e.url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// relayEnv holds the file descriptor number of the socket
+// used to send messages to the parent process.
+const relayEnv = "SINGULARITY_LOGRELAY"
+
+// relayDrainTimeout is the time given to child processes to send
+// their last messages once the relay is closed.
+const relayDrainTimeout = 100 * time.Millisecond
+
+// relayRecord is a message sent through the relay, one JSON
+// record per line.
+type relayRecord struct {
+	Level  int    `json:"level"`
+	Msg    string `json:"msg"`
+	Logger string `json:"logger,omitempty"`
+	Func   string `json:"func,omitempty"`
+	UID    int    `json:"uid"`
+	PID    int    `json:"pid"`
+	// Fields holds the message alternated keys and values
+	Fields []interface{} `json:"fields,omitempty"`
+}
+
+var (
+	relayMutex sync.Mutex
+	relayFile  *os.File
+)
+
+func init() {
+	fd, err := strconv.Atoi(os.Getenv(relayEnv))
+	if err != nil || fd <= 2 {
+		return
+	}
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil || st.Mode&syscall.S_IFMT != syscall.S_IFSOCK {
+		return
+	}
+	// don't leak the relay socket in the container process
+	syscall.CloseOnExec(fd)
+	relayFile = os.NewFile(uintptr(fd), "sylog-relay")
+}
+
+// relayed sends the message to the parent process and returns true,
+// it returns false if messages are not relayed or if the parent
+// process doesn't read them anymore, in which case the relay is
+// disabled and messages are written locally.
+func relayed(name string, logLevel, msgLevel messageLevel, msg string, fields []field) bool {
+	relayMutex.Lock()
+	defer relayMutex.Unlock()
+
+	if relayFile == nil {
+		return false
+	}
+
+	r := relayRecord{
+		Level:  int(msgLevel),
+		Msg:    msg,
+		Logger: name,
+		UID:    os.Geteuid(),
+		PID:    os.Getpid(),
+	}
+	if logLevel >= DebugLevel {
+		r.Func = callerName(5)
+	}
+	for _, f := range fields {
+		r.Fields = append(r.Fields, f.key, f.value)
+	}
+
+	b, err := json.Marshal(&r)
+	if err == nil {
+		_, err = relayFile.Write(append(b, '\n'))
+	}
+	if err != nil {
+		relayFile.Close()
+		relayFile = nil
+		return false
+	}
+	return true
+}
+
+// Relay receives the messages sent by child processes and writes them
+// through its logger with the logger formatting and level filtering.
+// Child processes inherit the relay socket returned by File and must
+// be started with the environment variable returned by EnvVar.
+type Relay struct {
+	logger *Logger
+	conn   net.Conn
+	child  *os.File
+	done   chan struct{}
+}
+
+// NewRelay returns a relay writing messages through the default logger.
+func NewRelay() (*Relay, error) {
+	return defaultLogger.NewRelay()
+}
+
+// NewRelay returns a relay writing messages through the logger.
+func (l *Logger) NewRelay() (*Relay, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("while creating log relay socket: %s", err)
+	}
+
+	parent := os.NewFile(uintptr(fds[0]), "sylog-relay")
+	conn, err := net.FileConn(parent)
+	parent.Close()
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, fmt.Errorf("while creating log relay connection: %s", err)
+	}
+
+	r := &Relay{
+		logger: l,
+		conn:   conn,
+		child:  os.NewFile(uintptr(fds[1]), "sylog-relay"),
+		done:   make(chan struct{}),
+	}
+	go r.read()
+
+	return r, nil
+}
+
+// File returns the socket end to pass to the child process.
+func (r *Relay) File() *os.File {
+	return r.child
+}
+
+// EnvVar returns the environment variable telling the child process
+// that the relay socket is the file descriptor fd.
+func (r *Relay) EnvVar(fd int) string {
+	return fmt.Sprintf("%s=%d", relayEnv, fd)
+}
+
+// Close stops the relay once the child processes had a chance to send
+// their last messages. Child processes still running afterward, like
+// instances, write their messages locally.
+func (r *Relay) Close() error {
+	r.child.Close()
+	r.conn.SetReadDeadline(time.Now().Add(relayDrainTimeout))
+	<-r.done
+	return r.conn.Close()
+}
+
+func (r *Relay) read() {
+	defer close(r.done)
+
+	scanner := bufio.NewScanner(r.conn)
+	for scanner.Scan() {
+		var rec relayRecord
+
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		rec.output(r.logger)
+	}
+}
+
+// output writes the relayed message as if it was logged by
+// the current process.
+func (rec *relayRecord) output(l *Logger) {
+	msgLevel := messageLevel(rec.Level)
+	logLevel := l.levelFor(rec.Logger)
+	if logLevel < msgLevel && !forwarded(msgLevel) {
+		return
+	}
+
+	message := redact(rec.Msg)
+
+	p := ""
+	if logLevel >= msgLevel {
+		p = timestamp() + formatPrefix(l, logLevel, msgLevel, rec.UID, rec.PID, rec.Func)
+	}
+	output(l, rec.Logger, logLevel, msgLevel, p, message, parseFields(rec.Fields))
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRelay(t *testing.T) {
+	var buf bytes.Buffer
+
+	l := NewLogger(&buf, int(InfoLevel), false)
+
+	r, err := l.NewRelay()
+	if err != nil {
+		t.Fatalf("unexpected error while creating relay: %s", err)
+	}
+
+	records := []string{
+		`{"level":-2,"msg":"relayed warning","uid":0,"pid":1}`,
+		`not a record`,
+		`{"level":5,"msg":"relayed debug","uid":0,"pid":1}`,
+		`{"level":1,"msg":"relayed info","uid":0,"pid":1,"fields":["image","test.sif"]}`,
+	}
+	for _, rec := range records {
+		if _, err := r.File().Write([]byte(rec + "\n")); err != nil {
+			t.Fatalf("unexpected error while writing record: %s", err)
+		}
+	}
+
+	if err := r.Close(); err != nil {
+		t.Errorf("unexpected error while closing relay: %s", err)
+	}
+
+	expected := "WARNING: relayed warning\nINFO:    relayed info image=test.sif\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected relay output %q instead of %q", buf.String(), expected)
+	}
+}
+
+func TestRelayed(t *testing.T) {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("unexpected error while creating pipe: %s", err)
+	}
+	defer pr.Close()
+
+	var buf bytes.Buffer
+	defaultLogger.writer = &buf
+
+	relayFile = pw
+	defer func() {
+		relayFile = nil
+		defaultLogger.writer = defaultWriter
+	}()
+
+	SetLevel(int(InfoLevel), false)
+	Warningf("child warning")
+
+	var rec relayRecord
+	line, err := bufio.NewReader(pr).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("unexpected error while reading record: %s", err)
+	}
+	if err := json.Unmarshal(line, &rec); err != nil {
+		t.Fatalf("unexpected error while decoding record %q: %s", line, err)
+	}
+	if rec.Level != int(WarnLevel) || rec.Msg != "child warning" || rec.PID != os.Getpid() {
+		t.Errorf("unexpected record %+v", rec)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("relayed message written locally: %q", buf.String())
+	}
+
+	// parent doesn't read anymore, messages are written locally
+	pr.Close()
+	Warningf("local warning")
+
+	if relayFile != nil {
+		t.Errorf("relay not disabled after write error")
+	}
+	if !strings.Contains(buf.String(), "local warning") {
+		t.Errorf("message not written locally: %q", buf.String())
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build go1.21
+
+package sylog
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// slogLevel converts a message level to a slog level, verbose
+// levels are mapped between slog.LevelDebug and slog.LevelInfo.
+func slogLevel(level int) slog.Level {
+	switch l := messageLevel(level); {
+	case l <= FatalLevel:
+		return slog.LevelError + 4
+	case l == ErrorLevel:
+		return slog.LevelError
+	case l == WarnLevel:
+		return slog.LevelWarn
+	case l <= InfoLevel:
+		return slog.LevelInfo
+	case l >= TraceLevel:
+		return slog.LevelDebug - 4
+	case l == DebugLevel:
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo - slog.Level(l-InfoLevel)
+	}
+}
+
+// messageLevelFromSlog converts a slog level to a message level.
+func messageLevelFromSlog(level slog.Level) messageLevel {
+	switch {
+	case level >= slog.LevelError:
+		return ErrorLevel
+	case level >= slog.LevelWarn:
+		return WarnLevel
+	case level >= slog.LevelInfo:
+		return InfoLevel
+	case level > slog.LevelDebug:
+		return InfoLevel + messageLevel(slog.LevelInfo-level)
+	case level > slog.LevelDebug-4:
+		return DebugLevel
+	default:
+		return TraceLevel
+	}
+}
+
+// SlogBackend returns a backend writing messages to the provided
+// slog logger, message fields are passed as attributes.
+func SlogBackend(l *slog.Logger) Hook {
+	return func(level int, msg string, fields map[string]interface{}) {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		attrs := make([]slog.Attr, 0, len(keys))
+		for _, k := range keys {
+			attrs = append(attrs, slog.Any(k, fields[k]))
+		}
+		l.LogAttrs(context.Background(), slogLevel(level), msg, attrs...)
+	}
+}
+
+// SetSlogBackend sends all messages to the provided slog logger
+// instead of the default log output.
+func SetSlogBackend(l *slog.Logger) {
+	SetBackend(SlogBackend(l))
+}
+
+// slogHandler is a slog.Handler writing records through sylog.
+type slogHandler struct {
+	attrs  []slog.Attr
+	groups []string
+}
+
+// NewSlogHandler returns a slog.Handler writing records through sylog,
+// record attributes are appended to the message as key=value pairs.
+func NewSlogHandler() slog.Handler {
+	return &slogHandler{}
+}
+
+// Enabled reports whether the sylog message level allows the record.
+func (h *slogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return messageLevel(GetLevel()) >= messageLevelFromSlog(level)
+}
+
+// Handle writes the record through sylog.
+func (h *slogHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+
+	b.WriteString(r.Message)
+
+	prefix := ""
+	if len(h.groups) > 0 {
+		prefix = strings.Join(h.groups, ".") + "."
+	}
+	for _, a := range h.attrs {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s%s=%v", prefix, a.Key, a.Value)
+		return true
+	})
+
+	msg := b.String()
+
+	switch messageLevelFromSlog(r.Level) {
+	case ErrorLevel:
+		Errorf("%s", msg)
+	case WarnLevel:
+		Warningf("%s", msg)
+	case InfoLevel:
+		Infof("%s", msg)
+	case DebugLevel:
+		Debugf("%s", msg)
+	case TraceLevel:
+		Tracef("%s", msg)
+	default:
+		Verbosef("%s", msg)
+	}
+	return nil
+}
+
+// WithAttrs returns a handler adding attrs to all records.
+func (h *slogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	prefix := ""
+	if len(h.groups) > 0 {
+		prefix = strings.Join(h.groups, ".") + "."
+	}
+
+	nh := &slogHandler{
+		attrs:  make([]slog.Attr, 0, len(h.attrs)+len(attrs)),
+		groups: h.groups,
+	}
+	nh.attrs = append(nh.attrs, h.attrs...)
+	for _, a := range attrs {
+		nh.attrs = append(nh.attrs, slog.Attr{Key: prefix + a.Key, Value: a.Value})
+	}
+	return nh
+}
+
+// WithGroup returns a handler qualifying the following attributes
+// with the group name.
+func (h *slogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	groups := make([]string, 0, len(h.groups)+1)
+	groups = append(groups, h.groups...)
+	return &slogHandler{
+		attrs:  h.attrs,
+		groups: append(groups, name),
+	}
+}
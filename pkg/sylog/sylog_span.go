@@ -0,0 +1,216 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// traceParentEnv holds the W3C trace context of the parent span,
+// allowing workflow managers to attach spans to their own traces.
+const traceParentEnv = "TRACEPARENT"
+
+// spanEvent is a message logged while a span is active.
+type spanEvent struct {
+	time   time.Time
+	level  messageLevel
+	msg    string
+	logger string
+}
+
+// Span represents a timed operation like an image pull or a build. Messages
+// displayed while the span is the innermost active span are attached to it
+// as events. Spans are only recorded when a span exporter is configured
+// with SetOTLPEndpoint, a nil span is valid and does nothing.
+type Span struct {
+	mu         sync.Mutex
+	traceID    [16]byte
+	spanID     [8]byte
+	parentID   [8]byte
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]string
+	events     []spanEvent
+}
+
+// spanExporter exports ended spans.
+type spanExporter func(spans []*Span) error
+
+var (
+	spanMutex sync.Mutex
+	exporter  spanExporter
+	// activeSpans holds the started spans, the last
+	// one being the innermost active span
+	activeSpans []*Span
+	endedSpans  []*Span
+	// remoteTraceID and remoteParentID hold the context
+	// of the parent span set by the TRACEPARENT variable
+	remoteTraceID  [16]byte
+	remoteParentID [8]byte
+)
+
+func init() {
+	parseTraceParent(os.Getenv(traceParentEnv))
+}
+
+// parseTraceParent parses a W3C trace context header value
+// (eg: 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01).
+func parseTraceParent(value string) {
+	fields := strings.Split(value, "-")
+	if len(fields) != 4 || len(fields[1]) != 32 || len(fields[2]) != 16 {
+		return
+	}
+
+	var traceID [16]byte
+	var parentID [8]byte
+
+	if _, err := hex.Decode(traceID[:], []byte(fields[1])); err != nil {
+		return
+	}
+	if _, err := hex.Decode(parentID[:], []byte(fields[2])); err != nil {
+		return
+	}
+	remoteTraceID = traceID
+	remoteParentID = parentID
+}
+
+// StartSpan starts a span named name as a child of the innermost active
+// span, it returns nil if no span exporter is configured. The span must
+// be ended with End.
+func StartSpan(name string) *Span {
+	spanMutex.Lock()
+	defer spanMutex.Unlock()
+
+	if exporter == nil {
+		return nil
+	}
+
+	s := &Span{
+		name:       name,
+		start:      time.Now(),
+		attributes: make(map[string]string),
+	}
+	rand.Read(s.spanID[:])
+
+	if n := len(activeSpans); n > 0 {
+		s.traceID = activeSpans[n-1].traceID
+		s.parentID = activeSpans[n-1].spanID
+	} else if remoteTraceID != [16]byte{} {
+		s.traceID = remoteTraceID
+		s.parentID = remoteParentID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+
+	activeSpans = append(activeSpans, s)
+	return s
+}
+
+// SetAttribute sets the attribute key of the span to value.
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attributes[key] = value
+	s.mu.Unlock()
+}
+
+// End ends the span, ended spans are exported by Flush.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	spanMutex.Lock()
+	defer spanMutex.Unlock()
+
+	s.endLocked()
+}
+
+// endLocked ends the span, spanMutex must be held by the caller.
+func (s *Span) endLocked() {
+	for i, a := range activeSpans {
+		if a == s {
+			activeSpans = append(activeSpans[:i], activeSpans[i+1:]...)
+			s.mu.Lock()
+			s.end = time.Now()
+			s.mu.Unlock()
+			endedSpans = append(endedSpans, s)
+			return
+		}
+	}
+}
+
+// addSpanEvent attaches the message to the innermost active span.
+func addSpanEvent(name string, msgLevel messageLevel, msg string) {
+	spanMutex.Lock()
+	defer spanMutex.Unlock()
+
+	n := len(activeSpans)
+	if n == 0 {
+		return
+	}
+
+	s := activeSpans[n-1]
+	s.mu.Lock()
+	s.events = append(s.events, spanEvent{
+		time:   time.Now(),
+		level:  msgLevel,
+		msg:    msg,
+		logger: name,
+	})
+	s.mu.Unlock()
+}
+
+// flushSpans ends the active spans and exports all ended spans.
+func flushSpans() {
+	spanMutex.Lock()
+	for n := len(activeSpans); n > 0; n = len(activeSpans) {
+		activeSpans[n-1].endLocked()
+	}
+	spans := endedSpans
+	endedSpans = nil
+	export := exporter
+	spanMutex.Unlock()
+
+	if export == nil || len(spans) == 0 {
+		return
+	}
+	if err := export(spans); err != nil {
+		Warningf("Failed to export %d spans: %s", len(spans), err)
+	}
+}
+
+// SetOTLPEndpoint configures the export of spans to the OpenTelemetry
+// collector listening at endpoint with the OTLP/HTTP protocol, spans are
+// sent to the /v1/traces path of endpoint unless it already contains a
+// path. An empty endpoint disables span recording.
+func SetOTLPEndpoint(endpoint string) error {
+	var export spanExporter
+
+	if endpoint != "" {
+		e, err := newOTLPExporter(endpoint)
+		if err != nil {
+			return err
+		}
+		export = e.export
+	}
+
+	spanMutex.Lock()
+	exporter = export
+	spanMutex.Unlock()
+
+	return nil
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpans(t *testing.T) {
+	var buf bytes.Buffer
+	var exported []*Span
+
+	defaultLogger.writer = &buf
+	exporter = func(spans []*Span) error {
+		exported = append(exported, spans...)
+		return nil
+	}
+
+	defer func() {
+		defaultLogger.writer = defaultWriter
+		exporter = nil
+	}()
+
+	SetLevel(int(InfoLevel), false)
+
+	root := StartSpan("root")
+	child := StartSpan("child")
+	child.SetAttribute("image", "test.sif")
+	Infof("child message")
+	Verbosef("hidden message")
+	child.End()
+	Warningf("root message")
+	Flush()
+
+	if len(exported) != 2 {
+		t.Fatalf("unexpected number of exported spans: %d", len(exported))
+	}
+	if exported[0] != child || exported[1] != root {
+		t.Fatalf("spans exported in wrong order")
+	}
+	if child.traceID != root.traceID || child.parentID != root.spanID {
+		t.Errorf("child span is not attached to the root span")
+	}
+	if child.attributes["image"] != "test.sif" {
+		t.Errorf("unexpected child attributes: %v", child.attributes)
+	}
+	if len(child.events) != 1 || child.events[0].msg != "child message" {
+		t.Errorf("unexpected child events: %+v", child.events)
+	}
+	if len(root.events) != 1 || root.events[0].level != WarnLevel {
+		t.Errorf("unexpected root events: %+v", root.events)
+	}
+	if root.end.Before(child.end) {
+		t.Errorf("root span ended before child span")
+	}
+	if len(activeSpans) != 0 || len(endedSpans) != 0 {
+		t.Errorf("spans remaining after flush")
+	}
+
+	// nil spans are valid without exporter
+	exporter = nil
+	s := StartSpan("none")
+	if s != nil {
+		t.Errorf("span recorded without exporter")
+	}
+	s.SetAttribute("key", "value")
+	s.End()
+}
+
+func TestTraceParent(t *testing.T) {
+	defer func() {
+		remoteTraceID = [16]byte{}
+		remoteParentID = [8]byte{}
+	}()
+
+	parseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	if hex.EncodeToString(remoteTraceID[:]) != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("unexpected trace ID %x", remoteTraceID)
+	}
+	if hex.EncodeToString(remoteParentID[:]) != "00f067aa0ba902b7" {
+		t.Errorf("unexpected parent span ID %x", remoteParentID)
+	}
+
+	remoteTraceID = [16]byte{}
+	parseTraceParent("invalid")
+	if remoteTraceID != [16]byte{} {
+		t.Errorf("invalid trace parent was parsed")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var req otlpRequest
+	var path string
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		b, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(b, &req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer ts.Close()
+
+	if _, err := newOTLPExporter("localhost:4318"); err == nil {
+		t.Errorf("unexpected success with endpoint without scheme")
+	}
+
+	e, err := newOTLPExporter(ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	s := &Span{
+		name:       "pull",
+		attributes: map[string]string{"image": "library://alpine"},
+		events:     []spanEvent{{level: InfoLevel, msg: "Downloading library image"}},
+	}
+	s.spanID[0] = 1
+
+	if err := e.export([]*Span{s}); err != nil {
+		t.Fatalf("unexpected error while exporting spans: %s", err)
+	}
+	if path != otlpTracesPath {
+		t.Errorf("spans sent to %s instead of %s", path, otlpTracesPath)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("unexpected export request: %+v", req)
+	}
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 || spans[0].Name != "pull" || spans[0].SpanID != "0100000000000000" {
+		t.Fatalf("unexpected exported spans: %+v", spans)
+	}
+	if spans[0].ParentSpanID != "" {
+		t.Errorf("unexpected parent span ID %s", spans[0].ParentSpanID)
+	}
+	if len(spans[0].Events) != 1 || spans[0].Events[0].Attributes[1].Value.StringValue != "Downloading library image" {
+		t.Errorf("unexpected exported events: %+v", spans[0].Events)
+	}
+}
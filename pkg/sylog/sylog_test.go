@@ -9,18 +9,20 @@ package sylog
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 
 	"github.com/sylabs/singularity/internal/pkg/test"
 )
 
-var defaultWriter = logWriter
+var defaultWriter = defaultLogger.writer
 
 func TestPrefix(t *testing.T) {
 	// This is information necessary to deal with the string generated by
@@ -84,7 +86,7 @@ func TestPrefix(t *testing.T) {
 	for _, tt := range tests {
 		t.Run("color_"+tt.name, func(t *testing.T) {
 			SetLevel(int(tt.lvl), true) // This impacts the output format
-			p := prefix(getLoggerLevel(), tt.lvl)
+			p := prefix(defaultLogger, defaultLogger.getLevel(), tt.lvl)
 			colorReset := ""
 			if tt.msgColor != "" {
 				colorReset = "\x1b[0m"
@@ -103,7 +105,7 @@ func TestPrefix(t *testing.T) {
 	for _, tt := range tests {
 		t.Run("nocolor_"+tt.name, func(t *testing.T) {
 			SetLevel(int(tt.lvl), false) // This impacts the output format
-			p := prefix(getLoggerLevel(), tt.lvl)
+			p := prefix(defaultLogger, defaultLogger.getLevel(), tt.lvl)
 			expectedOutput := fmt.Sprintf("%-8s ", tt.levelStr+":")
 			// invalid cases do *not* support disabling color
 			if tt.name == "invalid" {
@@ -167,10 +169,10 @@ func TestWritef(t *testing.T) {
 	const str = "just a test"
 
 	var buf bytes.Buffer
-	logWriter = &buf
+	defaultLogger.writer = &buf
 
 	defer func() {
-		logWriter = defaultWriter
+		defaultLogger.writer = defaultWriter
 	}()
 
 	tests := []struct {
@@ -200,19 +202,40 @@ func TestWritef(t *testing.T) {
 			SetLevel(int(tt.lvl), false)
 			buf.Reset()
 
-			writef(tt.lvl, "%s", str)
-			expectedResult := prefix(getLoggerLevel(), tt.lvl) + str + "\n"
+			writef(defaultLogger, "", tt.lvl, "%s", str)
+			expectedResult := prefix(defaultLogger, defaultLogger.getLevel(), tt.lvl) + str + "\n"
 			if buf.String() != expectedResult {
 				t.Fatalf("test %s returned %s instead of %s", tt.name, buf.String(), expectedResult)
 			}
 		})
 	}
 
+	// trace messages are only displayed at trace level
+	SetLevel(int(DebugLevel), false)
+	buf.Reset()
+	writef(defaultLogger, "", TraceLevel, "%s", str)
+	if buf.String() != "" {
+		t.Fatalf("trace message displayed at debug level: %s", buf.String())
+	}
+	SetLevel(int(TraceLevel), false)
+	writef(defaultLogger, "", TraceLevel, "%s", str)
+	if !strings.HasPrefix(buf.String(), "TRACE") {
+		t.Fatalf("trace message not displayed at trace level: %s", buf.String())
+	}
+
+	// fatal messages display the attached error code
+	SetLevel(int(InfoLevel), false)
+	buf.Reset()
+	writef(defaultLogger, "", FatalLevel, "mount failed: %s", WithCode(MountFailed, errors.New(str)))
+	if buf.String() != "FATAL:   mount failed: just a test code=E_MOUNT_FAILED\n" {
+		t.Fatalf("unexpected fatal message: %s", buf.String())
+	}
+
 	// corner case
 	SetLevel(int(FatalLevel), true)
 	expectedResult := ""
 	buf.Reset()
-	writef(InfoLevel, "%s", str)
+	writef(defaultLogger, "", InfoLevel, "%s", str)
 	if buf.String() != expectedResult {
 		t.Fatalf("test returned %s instead of an empty string", buf.String())
 	}
@@ -300,14 +323,14 @@ func runTestLogFn(t *testing.T, errFd *os.File, fn fnOut) {
 		return
 	}
 
-	SetLevel(int(DebugLevel), false)
+	SetLevel(int(TraceLevel), false)
 
 	var buf bytes.Buffer
-	logWriter = &buf
+	defaultLogger.writer = &buf
 
 	fn("%s\n", testStr)
 
-	logWriter = defaultWriter
+	defaultLogger.writer = defaultWriter
 
 	out := buf.String()
 
@@ -320,7 +343,7 @@ func runTestLogFn(t *testing.T, errFd *os.File, fn fnOut) {
 	}
 	class := classResult[1]
 	class = strings.Trim(class, " \t")
-	if class != "WARNING" && class != "INFO" && class != "DEBUG" && class != "VERBOSE" {
+	if class != "WARNING" && class != "INFO" && class != "DEBUG" && class != "TRACE" && class != "VERBOSE" {
 		t.Fatalf("failed to recognize the type of message: %s.", class)
 	}
 
@@ -363,6 +386,173 @@ func TestStderrOutput(t *testing.T) {
 			runTestLogFn(t, tt.out, Infof)
 			runTestLogFn(t, tt.out, Verbosef)
 			runTestLogFn(t, tt.out, Debugf)
+			runTestLogFn(t, tt.out, Tracef)
 		})
 	}
 }
+
+func TestSubLogger(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger.writer = &buf
+
+	origLevel := defaultLogger.level
+	origColor := defaultLogger.color
+
+	defer func() {
+		defaultLogger.writer = defaultWriter
+		defaultLogger.level = origLevel
+		defaultLogger.color = origColor
+		subLevels = make(map[string]messageLevel)
+	}()
+
+	parseMessageLevel("91,network=verbose3,build=debug")
+
+	if defaultLogger.getLevel() != InfoLevel {
+		t.Errorf("unexpected global level %d", defaultLogger.getLevel())
+	}
+	if subLevels["network"] != Verbose3Level || subLevels["build"] != DebugLevel {
+		t.Errorf("unexpected sub logger levels: %v", subLevels)
+	}
+
+	env := GetEnvVar()
+	if env != "SINGULARITY_MESSAGELEVEL=91,build=5,network=4" {
+		t.Errorf("unexpected environment variable %s", env)
+	}
+
+	networkLog := NewSubLogger("network")
+	runtimeLog := NewSubLogger("runtime")
+
+	networkLog.Verbosef("network message")
+	runtimeLog.Verbosef("runtime message")
+	Verbosef("global message")
+
+	out := buf.String()
+	if !strings.Contains(out, "network message") {
+		t.Errorf("network message not displayed")
+	}
+	if strings.Contains(out, "runtime message") || strings.Contains(out, "global message") {
+		t.Errorf("unexpected verbose messages displayed: %s", out)
+	}
+}
+
+func TestHook(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger.writer = &buf
+
+	defer func() {
+		defaultLogger.writer = defaultWriter
+	}()
+
+	type event struct {
+		level  int
+		msg    string
+		logger interface{}
+	}
+	var events []event
+
+	remove := AddHook(func(level int, msg string, fields map[string]interface{}) {
+		events = append(events, event{level, msg, fields["logger"]})
+	})
+
+	SetLevel(int(InfoLevel), false)
+
+	Infof("info message")
+	Debugf("debug message")
+	NewSubLogger("build").Warningf("warning message")
+
+	remove()
+
+	Infof("not hooked")
+
+	expected := []event{
+		{int(InfoLevel), "info message", nil},
+		{int(WarnLevel), "warning message", "build"},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("unexpected hooked messages: %v", events)
+	}
+	for i, e := range expected {
+		if events[i] != e {
+			t.Errorf("unexpected hooked message %v instead of %v", events[i], e)
+		}
+	}
+}
+
+func TestDeduplication(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger.writer = &buf
+
+	defer func() {
+		defaultLogger.writer = defaultWriter
+	}()
+
+	SetLevel(int(InfoLevel), false)
+
+	// distinct messages sharing the same format are kept
+	var expected string
+	for i := 0; i < 5; i++ {
+		Warningf("skipping mount %d, source does not exist", i)
+		expected += fmt.Sprintf("WARNING: skipping mount %d, source does not exist\n", i)
+	}
+	for i := 0; i < 10; i++ {
+		Warningf("skipping mount 9, source does not exist")
+	}
+	Infof("other message")
+	Flush()
+
+	expected += "WARNING: skipping mount 9, source does not exist\n" +
+		"WARNING: skipping mount 9, source does not exist\n" +
+		"WARNING: skipping mount 9, source does not exist\n" +
+		"INFO:    other message\n" +
+		"WARNING: skipping mount 9, source does not exist (message repeated 7 times)\n"
+	if buf.String() != expected {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+
+	buf.Reset()
+	SetDeduplication(false)
+	defer SetDeduplication(true)
+
+	for i := 0; i < 5; i++ {
+		Warningf("not deduplicated")
+	}
+	if strings.Count(buf.String(), "not deduplicated") != 5 {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var infoBuf, debugBuf bytes.Buffer
+
+	infoLogger := NewLogger(&infoBuf, int(InfoLevel), false)
+	debugLogger := NewLogger(&debugBuf, int(DebugLevel), false)
+
+	var wg sync.WaitGroup
+	for _, l := range []*Logger{infoLogger, debugLogger} {
+		wg.Add(1)
+		go func(l *Logger) {
+			defer wg.Done()
+			l.Infof("info message")
+			l.Verbosef("verbose message")
+		}(l)
+	}
+	wg.Wait()
+
+	if infoBuf.String() != "INFO:    info message\n" {
+		t.Errorf("unexpected info logger output: %q", infoBuf.String())
+	}
+	if !strings.Contains(debugBuf.String(), "verbose message") {
+		t.Errorf("debug logger didn't write verbose message: %q", debugBuf.String())
+	}
+	if strings.Contains(debugBuf.String(), "\x1b[") {
+		t.Errorf("unexpected color in debug logger output: %q", debugBuf.String())
+	}
+
+	infoLogger.SetLevel(int(LogLevel), true)
+	if infoLogger.Writer() != ioutil.Discard {
+		t.Errorf("silenced logger writer is not discarded")
+	}
+	if GetLevel() == infoLogger.GetLevel() {
+		t.Errorf("logger level changed the default logger level")
+	}
+}
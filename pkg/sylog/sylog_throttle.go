@@ -0,0 +1,140 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// throttleLimit is the number of identical messages
+	// displayed before the next ones are suppressed.
+	throttleLimit = 3
+	// throttleWindow is the duration during which identical
+	// messages are counted.
+	throttleWindow = 5 * time.Second
+)
+
+// throttleEntry tracks occurrences of identical messages.
+type throttleEntry struct {
+	logger     *Logger
+	name       string
+	logLevel   messageLevel
+	msgLevel   messageLevel
+	first      time.Time
+	count      int
+	last       string
+	lastFields []field
+	suppressed int
+}
+
+var (
+	throttleMutex   sync.Mutex
+	throttleEnabled = true
+	throttleCache   = make(map[string]*throttleEntry)
+)
+
+// SetDeduplication enables or disables the suppression of repeated
+// messages. When enabled (default), identical warning, info and verbose
+// messages (same text and fields) are displayed up to three times in a
+// row, the next ones are counted and reported once with a "message
+// repeated N times" notice. Debug level disables the suppression.
+func SetDeduplication(enabled bool) {
+	flushThrottled()
+
+	throttleMutex.Lock()
+	throttleEnabled = enabled
+	throttleMutex.Unlock()
+}
+
+// throttled returns true if the message must be suppressed.
+func throttled(l *Logger, name string, logLevel, msgLevel messageLevel, msg string, fields []field) bool {
+	if msgLevel < WarnLevel || msgLevel >= DebugLevel || logLevel >= DebugLevel {
+		return false
+	}
+
+	key := fmt.Sprintf("%p:%d:%s:%s:%v", l, msgLevel, name, msg, fields)
+	now := time.Now()
+
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+
+	if !throttleEnabled {
+		return false
+	}
+
+	e, ok := throttleCache[key]
+	if ok && now.Sub(e.first) > throttleWindow {
+		flushEntry(e)
+		ok = false
+	}
+	if !ok {
+		e = &throttleEntry{
+			logger:   l,
+			name:     name,
+			logLevel: logLevel,
+			msgLevel: msgLevel,
+			first:    now,
+		}
+		throttleCache[key] = e
+	}
+
+	e.count++
+	if e.count <= throttleLimit {
+		return false
+	}
+	e.last = msg
+	e.lastFields = fields
+	e.suppressed++
+	return true
+}
+
+// flushEntry writes the repeated message notice for the entry.
+func flushEntry(e *throttleEntry) {
+	if e.suppressed == 0 {
+		return
+	}
+	msg := fmt.Sprintf("%s (message repeated %d times)", e.last, e.suppressed)
+	p := ""
+	if e.logLevel >= e.msgLevel {
+		p = timestamp() + prefix(e.logger, e.logLevel, e.msgLevel)
+	}
+	output(e.logger, e.name, e.logLevel, e.msgLevel, p, msg, e.lastFields)
+	e.suppressed = 0
+}
+
+// Flush writes the pending notices of repeated messages, exports
+// the spans and commits the log files to disk, it should be called
+// before the process terminates or replaces itself.
+func Flush() {
+	flushThrottled()
+	flushSpans()
+	syncOutputFile()
+	syncDestination()
+}
+
+// flushThrottled writes the pending notices of repeated messages.
+func flushThrottled() {
+	throttleMutex.Lock()
+	defer throttleMutex.Unlock()
+
+	entries := make([]*throttleEntry, 0, len(throttleCache))
+	for _, e := range throttleCache {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].first.Before(entries[j].first)
+	})
+	for _, e := range entries {
+		flushEntry(e)
+	}
+	throttleCache = make(map[string]*throttleEntry)
+}
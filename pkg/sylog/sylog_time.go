@@ -0,0 +1,118 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// timestampEnv holds the timestamp mode, either "rfc3339" or
+// "relative" optionally followed by the reference start time
+// in nanoseconds (eg: "relative,1600000000000000000").
+const timestampEnv = "SINGULARITY_LOGTIMESTAMPS"
+
+// timestampAliasEnv is the user facing alias of timestampEnv,
+// timestampEnv takes precedence as it carries the start time
+// of the parent process.
+const timestampAliasEnv = "SINGULARITY_LOG_TIMESTAMPS"
+
+var (
+	timestampMutex sync.Mutex
+	timestampMode  string
+	// startTime is the reference time for relative timestamps
+	startTime = time.Now()
+)
+
+func init() {
+	env := os.Getenv(timestampEnv)
+	if env == "" {
+		env = os.Getenv(timestampAliasEnv)
+	}
+	if env == "" {
+		return
+	}
+	fields := strings.SplitN(env, ",", 2)
+	if len(fields) == 2 {
+		if ns, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			startTime = time.Unix(0, ns)
+		}
+	}
+	// errors are ignored, there is nothing we could
+	// report them to at this stage
+	SetTimestamps(fields[0])
+}
+
+// SetTimestamps sets the timestamps prepended to messages, mode
+// is one of RFC3339Timestamps, RelativeTimestamps or NoTimestamps.
+func SetTimestamps(mode string) error {
+	switch mode {
+	case "", NoTimestamps:
+		mode = ""
+	case RFC3339Timestamps, RelativeTimestamps:
+	default:
+		return fmt.Errorf("unknown timestamp format %q", mode)
+	}
+
+	timestampMutex.Lock()
+	timestampMode = mode
+	timestampMutex.Unlock()
+
+	return nil
+}
+
+// EnableTimestamps prepends RFC3339 timestamps to messages.
+func EnableTimestamps() {
+	SetTimestamps(RFC3339Timestamps)
+}
+
+// EnableRelativeTimestamps prepends the time elapsed since the
+// process start to messages.
+func EnableRelativeTimestamps() {
+	SetTimestamps(RelativeTimestamps)
+}
+
+// DisableTimestamps removes timestamps from messages.
+func DisableTimestamps() {
+	SetTimestamps(NoTimestamps)
+}
+
+// timestamp returns the timestamp prepended to messages
+// or an empty string if timestamps are disabled.
+func timestamp() string {
+	timestampMutex.Lock()
+	mode := timestampMode
+	timestampMutex.Unlock()
+
+	switch mode {
+	case RFC3339Timestamps:
+		return time.Now().Format(time.RFC3339) + " "
+	case RelativeTimestamps:
+		return fmt.Sprintf("[%10.6f] ", time.Since(startTime).Seconds())
+	}
+	return ""
+}
+
+// getTimestampEnvVars returns the environment variables required
+// by a child process to display the same timestamps.
+func getTimestampEnvVars() []string {
+	timestampMutex.Lock()
+	defer timestampMutex.Unlock()
+
+	switch timestampMode {
+	case RFC3339Timestamps:
+		return []string{fmt.Sprintf("%s=%s", timestampEnv, timestampMode)}
+	case RelativeTimestamps:
+		return []string{fmt.Sprintf("%s=%s,%d", timestampEnv, timestampMode, startTime.UnixNano())}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// +build sylog
+
+package sylog
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestTimestamps(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger.writer = &buf
+
+	defer func() {
+		defaultLogger.writer = defaultWriter
+		DisableTimestamps()
+	}()
+
+	SetLevel(int(InfoLevel), false)
+
+	tests := []struct {
+		name   string
+		enable func()
+		match  string
+	}{
+		{
+			name:   "none",
+			enable: DisableTimestamps,
+			match:  `^INFO:    message\n$`,
+		},
+		{
+			name:   "rfc3339",
+			enable: EnableTimestamps,
+			match:  `^\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\S* INFO:    message\n$`,
+		},
+		{
+			name:   "relative",
+			enable: EnableRelativeTimestamps,
+			match:  `^\[\s*\d+\.\d{6}\] INFO:    message\n$`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			tt.enable()
+			Infof("message")
+			if !regexp.MustCompile(tt.match).MatchString(buf.String()) {
+				t.Errorf("unexpected output %q", buf.String())
+			}
+		})
+	}
+
+	if err := SetTimestamps("unknown"); err == nil {
+		t.Errorf("unexpected success with unknown timestamp format")
+	}
+
+	startTime = time.Unix(0, 42)
+	EnableRelativeTimestamps()
+	env := getTimestampEnvVars()
+	if len(env) != 1 || env[0] != "SINGULARITY_LOGTIMESTAMPS=relative,42" {
+		t.Errorf("unexpected environment variables %v", env)
+	}
+}
@@ -5,7 +5,11 @@
 
 package sypgp
 
-import "golang.org/x/crypto/openpgp"
+import (
+	"errors"
+
+	"golang.org/x/crypto/openpgp"
+)
 
 // EntitySelector selects an Entity given an EntityList.
 type EntitySelector func(el openpgp.EntityList) (*openpgp.Entity, error)
@@ -23,3 +27,23 @@ func (keyring *Handle) getPrivateEntity(f EntitySelector) (*openpgp.Entity, erro
 func GetPrivateEntity(f EntitySelector) (*openpgp.Entity, error) {
 	return NewHandle("").getPrivateEntity(f)
 }
+
+// GetPrivateEntityFromFile retrieves the entity selected by f from the private keys found in the
+// binary or ASCII armored key file at path.
+func GetPrivateEntityFromFile(path string, f EntitySelector) (*openpgp.Entity, error) {
+	el, err := LoadKeysFromFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var private openpgp.EntityList
+	for _, e := range el {
+		if e.PrivateKey != nil {
+			private = append(private, e)
+		}
+	}
+	if len(private) == 0 {
+		return nil, errors.New("no private key found")
+	}
+	return f(private)
+}
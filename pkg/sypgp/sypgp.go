@@ -214,11 +214,11 @@ func (keyring *Handle) LoadPubKeyring() (openpgp.EntityList, error) {
 	return loadKeyring(keyring.PublicPath())
 }
 
-// loadKeysFromFile loads one or more keys from the specified file.
+// LoadKeysFromFile loads one or more keys from the specified file.
 //
 // The key can be either a public or private key, and the file might be
 // in binary or ascii armored format.
-func loadKeysFromFile(fn string) (openpgp.EntityList, error) {
+func LoadKeysFromFile(fn string) (openpgp.EntityList, error) {
 	f, err := os.Open(fn)
 	if err != nil {
 		return nil, err
@@ -1057,7 +1057,7 @@ func (keyring *Handle) importPublicKey(entity *openpgp.Entity) error {
 // binary or ascii-armored format.
 func (keyring *Handle) ImportKey(kpath string, setNewPassword bool) error {
 	// Load the private key as an entitylist
-	pathEntityList, err := loadKeysFromFile(kpath)
+	pathEntityList, err := LoadKeysFromFile(kpath)
 	if err != nil {
 		return fmt.Errorf("unable to get entity from: %s: %v", kpath, err)
 	}
@@ -769,6 +769,60 @@ func TestRemoveKey(t *testing.T) {
 	}
 }
 
+func TestGetPrivateEntityFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("failed to create temporary directory")
+	}
+	defer os.RemoveAll(dir)
+
+	private, err := serializePrivateEntity(testEntity, openpgp.PrivateKeyType)
+	if err != nil {
+		t.Fatalf("failed to serialize private key: %v", err)
+	}
+	public, err := serializeEntity(testEntity, openpgp.PublicKeyType)
+	if err != nil {
+		t.Fatalf("failed to serialize public key: %v", err)
+	}
+
+	first := func(el openpgp.EntityList) (*openpgp.Entity, error) {
+		return el[0], nil
+	}
+
+	cases := []struct {
+		name      string
+		data      string
+		shallPass bool
+	}{
+		{"private key", private, true},
+		{"public key", public, false},
+		{"garbage", "not a key", false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "key.asc")
+			if err := ioutil.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatalf("failed to write key file: %v", err)
+			}
+
+			e, err := GetPrivateEntityFromFile(path, first)
+			if !tt.shallPass {
+				if err == nil {
+					t.Fatalf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if e.PrimaryKey.Fingerprint != testEntity.PrimaryKey.Fingerprint {
+				t.Errorf("unexpected entity %X", e.PrimaryKey.Fingerprint)
+			}
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	// Set TZ to UTC so that the code converting a time.Time value
 	// to a string produces consistent output.
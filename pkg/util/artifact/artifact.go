@@ -0,0 +1,104 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package artifact stores arbitrary files along with their media type in
+// SIF images (eg: SBOMs, test reports, model cards, license files). An
+// artifact is a generic data object whose descriptor holds the media type
+// in its extra field, unused by generic objects.
+package artifact
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+// mediaTypeRegexp matches the media types of RFC 6838.
+var mediaTypeRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}/[A-Za-z0-9][A-Za-z0-9!#$&^_.+-]{0,126}$`)
+
+// ValidateMediaType returns an error if mediaType isn't a valid media type.
+func ValidateMediaType(mediaType string) error {
+	if !mediaTypeRegexp.MatchString(mediaType) {
+		return fmt.Errorf("invalid media type %q, it must be of the form type/subtype", mediaType)
+	}
+	return nil
+}
+
+// MediaType returns the media type of the data object d and true if d is
+// an artifact.
+func MediaType(d *sif.Descriptor) (string, bool) {
+	if !d.Used || d.Datatype != sif.DataGeneric {
+		return "", false
+	}
+	mediaType := string(bytes.TrimRight(d.Extra[:], "\x00"))
+	if ValidateMediaType(mediaType) != nil {
+		return "", false
+	}
+	return mediaType, true
+}
+
+// Add adds the size bytes read from r to fimg as an artifact named name
+// of type mediaType in group, it returns its descriptor.
+func Add(fimg *sif.FileImage, name, mediaType string, r io.Reader, size int64, group uint32) (*sif.Descriptor, error) {
+	if err := ValidateMediaType(mediaType); err != nil {
+		return nil, err
+	}
+
+	input := sif.DescriptorInput{
+		Datatype: sif.DataGeneric,
+		Groupid:  group,
+		Link:     sif.DescrUnusedLink,
+		Size:     size,
+		Fname:    name,
+		Fp:       r,
+	}
+	input.Extra.WriteString(mediaType)
+
+	used := make([]bool, len(fimg.DescrArr))
+	for i, d := range fimg.DescrArr {
+		used[i] = d.Used
+	}
+	if err := fimg.AddObject(input); err != nil {
+		return nil, fmt.Errorf("while adding artifact %s: %v", name, err)
+	}
+	for i, d := range fimg.DescrArr {
+		if d.Used && !used[i] {
+			return &fimg.DescrArr[i], nil
+		}
+	}
+	return nil, fmt.Errorf("artifact %s not found once added", name)
+}
+
+// Find returns the artifact of fimg whose ID, name or media type is ref.
+func Find(fimg *sif.FileImage, ref string) (*sif.Descriptor, error) {
+	var found *sif.Descriptor
+
+	id, _ := strconv.ParseUint(ref, 10, 32)
+	for i := range fimg.DescrArr {
+		d := &fimg.DescrArr[i]
+		mediaType, ok := MediaType(d)
+		if !ok {
+			continue
+		}
+		if uint64(d.ID) == id {
+			return d, nil
+		}
+		if d.GetName() != ref && mediaType != ref {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("several artifacts match %s, use the artifact ID instead", ref)
+		}
+		found = d
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no artifact matching %s found", ref)
+	}
+	return found, nil
+}
@@ -8,6 +8,7 @@ package capabilities
 import (
 	"fmt"
 
+	"github.com/sylabs/singularity/pkg/sylog"
 	"golang.org/x/sys/unix"
 )
 
@@ -94,6 +95,7 @@ func SetProcessEffective(caps uint64) (uint64, error) {
 		}
 	}
 
+	sylog.Tracef("capset(effective=%#016x) (previous=%#016x)", effective, oldEffective)
 	if err := unix.Capset(&header, &data[0]); err != nil {
 		return 0, fmt.Errorf("while setting effective capabilities: %s", err)
 	}
@@ -105,9 +105,10 @@ func checkCryptsetupVersion(cryptsetup string) error {
 // EncryptFilesystem takes the path to a file containing a non-encrypted
 // filesystem, encrypts it using the provided key, and returns a path to
 // a file that can be later used as an encrypted volume with cryptsetup.
+// The slots passphrases are added to additional LUKS key slots.
 // NOTE: it is the callers responsibility to remove the returned file that
 // contains the crypt header.
-func (crypt *Device) EncryptFilesystem(path string, key []byte) (string, error) {
+func (crypt *Device) EncryptFilesystem(path string, key []byte, slots ...[]byte) (string, error) {
 	f, err := os.Stat(path)
 	if err != nil {
 		return "", fmt.Errorf("failed getting size of %s", path)
@@ -187,6 +188,12 @@ func (crypt *Device) EncryptFilesystem(path string, key []byte) (string, error)
 		return "", fmt.Errorf("unable to format crypt device: %s: %s", cryptF.Name(), string(out))
 	}
 
+	for _, slot := range slots {
+		if err := addKeySlot(cryptsetup, loop, key, slot); err != nil {
+			return "", err
+		}
+	}
+
 	nextCrypt, err := crypt.Open(key, loop)
 	if err != nil {
 		sylog.Verbosef("Unable to open encrypted device %s: %s", loop, err)
@@ -205,6 +212,31 @@ func (crypt *Device) EncryptFilesystem(path string, key []byte) (string, error)
 	return cryptF.Name(), err
 }
 
+// addKeySlot adds the passphrase slot to the key slots of the LUKS device
+// at path unlocked with key, the new passphrase is passed on a pipe.
+func addKeySlot(cryptsetup, path string, key, slot []byte) error {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(cryptsetup, "luksAddKey", "--batch-mode", "--key-file", "-", path, "/dev/fd/3")
+	cmd.Stdin = bytes.NewReader(key)
+	cmd.ExtraFiles = []*os.File{r}
+
+	go func() {
+		w.Write(slot)
+		w.Close()
+	}()
+
+	sylog.Debugf("Running %s %s", cmd.Path, strings.Join(cmd.Args, " "))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("unable to add key slot to crypt device: %s: %s", path, string(out))
+	}
+	return nil
+}
+
 // copyDeviceContents copies the contents of source to destination.
 // source and dest can either be a file or a block device
 func copyDeviceContents(source, dest string, size int64) error {
@@ -105,24 +105,28 @@ func PlaintextKey(k KeyInfo, image string) ([]byte, error) {
 			return nil, fmt.Errorf("could not load PEM private key: %v", err)
 		}
 
-		pemKey, err := getEncryptionKeyFromImage(image)
+		pemKeys, err := getEncryptionKeysFromImage(image)
 		if err != nil {
 			return nil, fmt.Errorf("could not get encryption information from SIF: %v", err)
 		}
 
-		pemBuf := bytes.NewReader(pemKey)
-
-		encKey, err := loadPEMMessage(pemBuf)
-		if err != nil {
-			return nil, fmt.Errorf("could not unpack LUKS PEM from SIF: %v", err)
+		// the image may be encrypted for several recipients, the
+		// key is decrypted from the message of the private key
+		for _, pemKey := range pemKeys {
+			encKey, err := loadPEMMessage(bytes.NewReader(pemKey))
+			if err != nil {
+				return nil, fmt.Errorf("could not unpack LUKS PEM from SIF: %v", err)
+			}
+
+			plaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, encKey, nil)
+			if err == nil {
+				return plaintext, nil
+			}
+			if len(pemKeys) == 1 {
+				return nil, fmt.Errorf("could not decrypt LUKS key: %v", err)
+			}
 		}
-
-		plaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, encKey, nil)
-		if err != nil {
-			return nil, fmt.Errorf("could not decrypt LUKS key: %v", err)
-		}
-
-		return plaintext, nil
+		return nil, fmt.Errorf("could not decrypt LUKS key: none of the %d recipients matches the private key", len(pemKeys))
 
 	case Passphrase:
 		return []byte(k.Material), nil
@@ -193,7 +197,9 @@ func savePEMMessage(w io.Writer, msg []byte) error {
 	return pem.Encode(w, b)
 }
 
-func getEncryptionKeyFromImage(fn string) ([]byte, error) {
+// getEncryptionKeysFromImage returns the RSA encrypted LUKS keys of the
+// recipients of the image fn.
+func getEncryptionKeysFromImage(fn string) ([][]byte, error) {
 	img, err := sif.LoadContainer(fn, true)
 	if err != nil {
 		return nil, fmt.Errorf("could not load container: %v", err)
@@ -210,6 +216,7 @@ func getEncryptionKeyFromImage(fn string) ([]byte, error) {
 		return nil, fmt.Errorf("could not retrieve linked descriptors for primary system partition from %s", fn)
 	}
 
+	var keys [][]byte
 	for _, d := range descr {
 		format, err := d.GetFormatType()
 		if err != nil {
@@ -221,14 +228,11 @@ func getEncryptionKeyFromImage(fn string) ([]byte, error) {
 			return nil, fmt.Errorf("could not get descriptor message type: %v", err)
 		}
 
-		// currently only support one type of message
+		// the messages of KMS recipients are left to their plugin
 		if format != sif.FormatPEM || message != sif.MessageRSAOAEP {
 			continue
 		}
 
-		// TODO(ian): For now, assume the first linked message is what we
-		// are looking for. We should consider what we want to do in the
-		// case of multiple linked messages
 		data := d.GetData(&img)
 		if data == nil {
 			return nil, fmt.Errorf("could not retrieve LUKS key data from %s: %v", fn, ErrNoEncryptedKeyData)
@@ -237,8 +241,11 @@ func getEncryptionKeyFromImage(fn string) ([]byte, error) {
 		key := make([]byte, len(data))
 		copy(key, data)
 
-		return key, nil
+		keys = append(keys, key)
 	}
 
-	return nil, fmt.Errorf("could not read LUKS key from %s: %v", fn, ErrEncryptedKeyNotFound)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("could not read LUKS key from %s: %v", fn, ErrEncryptedKeyNotFound)
+	}
+	return keys, nil
 }
@@ -0,0 +1,175 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"golang.org/x/sys/unix"
+)
+
+// MessageKMS is the type of the SIF crypto messages holding a volume key
+// wrapped by an external key management service, the message content is
+// opaque to singularity.
+const MessageKMS sif.Messagetype = 0x300
+
+// ErrNoKeyProvider is returned when an image is encrypted without
+// key provider.
+var ErrNoKeyProvider = errors.New("no encryption key provider")
+
+// KeyProvider is a recipient of an encrypted image. The passphrase
+// providers unlock a LUKS key slot of the encrypted filesystem, the others
+// wrap the volume key stored in a SIF crypto message.
+type KeyProvider interface {
+	// String describes the provider in messages (eg: pem:/path/to/key.pem).
+	String() string
+	// Passphrase returns the passphrase of the LUKS key slot of the
+	// provider, it returns nil if the provider wraps the volume key.
+	Passphrase() []byte
+	// WrapKey returns the volume key encrypted for the recipient and the
+	// type of the SIF crypto message it's stored in.
+	WrapKey(key []byte) ([]byte, sif.Messagetype, error)
+}
+
+// pemProvider encrypts the volume key with a RSA public key.
+type pemProvider struct {
+	path string
+	key  *rsa.PublicKey
+}
+
+// NewPEMProvider returns the provider encrypting the volume key with the
+// PEM formatted RSA public key at path.
+func NewPEMProvider(path string) (KeyProvider, error) {
+	key, err := LoadPEMPublicKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption public key %s: %v", path, err)
+	}
+	return &pemProvider{path: path, key: key}, nil
+}
+
+func (p *pemProvider) String() string {
+	return "pem:" + p.path
+}
+
+func (p *pemProvider) Passphrase() []byte {
+	return nil
+}
+
+func (p *pemProvider) WrapKey(key []byte) ([]byte, sif.Messagetype, error) {
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, p.key, key, nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("encrypting key: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := savePEMMessage(&buf, ciphertext); err != nil {
+		return nil, 0, fmt.Errorf("serializing encrypted key: %v", err)
+	}
+	return buf.Bytes(), sif.MessageRSAOAEP, nil
+}
+
+// passphraseProvider unlocks a LUKS key slot.
+type passphraseProvider struct {
+	source     string
+	passphrase []byte
+}
+
+// NewPassphraseProvider returns the provider of passphrase, source
+// describes where it was read from (eg: prompt).
+func NewPassphraseProvider(source string, passphrase []byte) (KeyProvider, error) {
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("empty passphrase from %s", source)
+	}
+	return &passphraseProvider{source: source, passphrase: passphrase}, nil
+}
+
+// NewEnvProvider returns the provider of the passphrase in the
+// environment variable name.
+func NewEnvProvider(name string) (KeyProvider, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %s is not set", name)
+	}
+	return NewPassphraseProvider("env:"+name, []byte(value))
+}
+
+// NewKeyringProvider returns the provider of the passphrase stored in the
+// user key named description of the kernel keyrings of the session (eg:
+// added with keyctl add user <description> <passphrase> @u).
+func NewKeyringProvider(description string) (KeyProvider, error) {
+	id, err := unix.KeyctlSearch(unix.KEY_SPEC_SESSION_KEYRING, "user", description, 0)
+	if err != nil {
+		id, err = unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, "user", description, 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("key %s not found in the kernel keyrings: %v", description, err)
+	}
+
+	size, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("while reading key %s: %v", description, err)
+	}
+	buf := make([]byte, size)
+	if _, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf, 0); err != nil {
+		return nil, fmt.Errorf("while reading key %s: %v", description, err)
+	}
+	return NewPassphraseProvider("keyring:"+description, buf)
+}
+
+func (p *passphraseProvider) String() string {
+	return p.source
+}
+
+func (p *passphraseProvider) Passphrase() []byte {
+	return p.passphrase
+}
+
+func (p *passphraseProvider) WrapKey(key []byte) ([]byte, sif.Messagetype, error) {
+	return nil, 0, nil
+}
+
+// KeyInfoProvider returns the provider of the key k.
+func KeyInfoProvider(k KeyInfo) (KeyProvider, error) {
+	switch k.Format {
+	case PEM:
+		return NewPEMProvider(k.Path)
+	case Passphrase:
+		return NewPassphraseProvider("passphrase", []byte(k.Material))
+	default:
+		return nil, ErrUnsupportedKeyURI
+	}
+}
+
+// VolumeKey returns the key a filesystem encrypted for providers is
+// formatted with and the passphrases of the additional LUKS key slots.
+// The volume key is random if a provider wraps it, otherwise it's the
+// passphrase of the first provider as for images with a single passphrase.
+func VolumeKey(providers []KeyProvider) (key []byte, slots [][]byte, err error) {
+	if len(providers) == 0 {
+		return nil, nil, ErrNoKeyProvider
+	}
+
+	for _, p := range providers {
+		if passphrase := p.Passphrase(); passphrase != nil {
+			slots = append(slots, passphrase)
+		} else if key == nil {
+			if key, err = getRandomBytes(64); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if key == nil {
+		key, slots = slots[0], slots[1:]
+	}
+	return key, slots, nil
+}
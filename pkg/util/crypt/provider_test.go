@@ -0,0 +1,119 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package crypt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/internal/pkg/test"
+	"golang.org/x/sys/unix"
+)
+
+func TestVolumeKey(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	dir, err := ioutil.TempDir("", "crypt-provider-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := GenerateRSAKey(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "public.pem")
+	if err := SavePublicPEM(path, key); err != nil {
+		t.Fatal(err)
+	}
+	pem, err := NewPEMProvider(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := NewPEMProvider(filepath.Join(dir, "missing.pem")); err == nil {
+		t.Errorf("unexpected success with a missing PEM file")
+	}
+
+	first, _ := NewPassphraseProvider("passphrase", []byte("first"))
+	os.Setenv("TEST_ENCRYPTION_PASSPHRASE", "second")
+	defer os.Unsetenv("TEST_ENCRYPTION_PASSPHRASE")
+	second, err := NewEnvProvider("TEST_ENCRYPTION_PASSPHRASE")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := NewEnvProvider("TEST_ENCRYPTION_UNSET"); err == nil {
+		t.Errorf("unexpected success with an unset variable")
+	}
+
+	// passphrases only, the first one formats the device
+	volume, slots, err := VolumeKey([]KeyProvider{first, second})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(volume) != "first" || len(slots) != 1 || string(slots[0]) != "second" {
+		t.Errorf("unexpected volume key %q and slots %q", volume, slots)
+	}
+
+	// a random key is wrapped for the PEM recipient
+	volume, slots, err = VolumeKey([]KeyProvider{first, pem})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(volume) != 64 || len(slots) != 1 || string(slots[0]) != "first" {
+		t.Errorf("unexpected volume key %q and slots %q", volume, slots)
+	}
+
+	data, message, err := pem.WrapKey(volume)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if message != sif.MessageRSAOAEP {
+		t.Errorf("unexpected message type %x", message)
+	}
+	ciphertext, err := loadPEMMessage(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, key, ciphertext, nil)
+	if err != nil || !bytes.Equal(plaintext, volume) {
+		t.Errorf("unexpected decrypted key %q: %v", plaintext, err)
+	}
+
+	if _, _, err := VolumeKey(nil); err != ErrNoKeyProvider {
+		t.Errorf("unexpected error without provider: %v", err)
+	}
+}
+
+func TestKeyringProvider(t *testing.T) {
+	test.DropPrivilege(t)
+	defer test.ResetPrivilege(t)
+
+	id, err := unix.AddKey("user", "singularity-test", []byte("keyring"), unix.KEY_SPEC_SESSION_KEYRING)
+	if err != nil {
+		t.Skipf("kernel keyrings not available: %v", err)
+	}
+	defer unix.KeyctlInt(unix.KEYCTL_UNLINK, id, unix.KEY_SPEC_SESSION_KEYRING, 0, 0)
+
+	p, err := NewKeyringProvider("singularity-test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(p.Passphrase()) != "keyring" || p.String() != "keyring:singularity-test" {
+		t.Errorf("unexpected provider %s with passphrase %q", p, p.Passphrase())
+	}
+	if _, err := NewKeyringProvider("singularity-missing"); err == nil {
+		t.Errorf("unexpected success with a missing key")
+	}
+}
@@ -1,4 +1,4 @@
-// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// Copyright (c) 2019-2020, Sylabs Inc. All rights reserved.
 // This software is licensed under a 3-clause BSD license. Please consult the
 // LICENSE.md file distributed with the sources of this project regarding your
 // rights to use or distribute this software.
@@ -6,10 +6,36 @@
 package gpu
 
 import (
+	"bufio"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 )
 
+// Environment variables selecting the AMD GPUs, HIP_VISIBLE_DEVICES indexes
+// the GPUs selected by ROCR_VISIBLE_DEVICES.
+const (
+	rocrVisibleDevices = "ROCR_VISIBLE_DEVICES"
+	hipVisibleDevices  = "HIP_VISIBLE_DEVICES"
+)
+
+// rocmTopologyDir is the sysfs directory of the KFD topology nodes, the
+// CPUs and AMD GPUs of the host in the enumeration order of ROCm.
+var rocmTopologyDir = "/sys/class/kfd/kfd/topology/nodes"
+
+// drmClassDir is the sysfs directory of the DRM devices.
+var drmClassDir = "/sys/class/drm"
+
+// rocmGPU is an AMD GPU of the KFD topology.
+type rocmGPU struct {
+	renderMinor int
+	uniqueID    uint64
+}
+
 // NvidiaDevices return list of all non-GPU nvidia devices present on host. If withGPU
 // is true all GPUs are included in the resulting list as well.
 func NvidiaDevices(withGPU bool) ([]string, error) {
@@ -37,3 +63,215 @@ func RocmDevices(withGPU bool) ([]string, error) {
 	}
 	return devs, nil
 }
+
+// RocmEnv returns the environment variables of env selecting the AMD GPUs
+// bound by RocmVisibleDevices.
+func RocmEnv(env []string) []string {
+	var rocm []string
+	for _, e := range env {
+		if strings.HasPrefix(e, rocrVisibleDevices+"=") || strings.HasPrefix(e, hipVisibleDevices+"=") {
+			rocm = append(rocm, e)
+		}
+	}
+	return rocm
+}
+
+// RocmVisibleDevices returns the list of rocm devices present on host for the
+// AMD GPUs selected by the ROCR_VISIBLE_DEVICES and HIP_VISIBLE_DEVICES
+// variables of env: the kfd device, and the render and card nodes of the
+// selected GPUs, all GPUs if no GPU is selected.
+func RocmVisibleDevices(env []string) ([]string, error) {
+	gpus, selected, err := visibleRocmGPUs(env)
+	if err != nil {
+		return nil, err
+	}
+	if !selected {
+		devs, err := filepath.Glob("/dev/dri/renderD*")
+		if err != nil {
+			return nil, fmt.Errorf("could not list rocm devices: %v", err)
+		}
+		cards, err := RocmDevices(true)
+		if err != nil {
+			return nil, err
+		}
+		return append(rocmKfd(), append(cards, devs...)...), nil
+	}
+
+	devs := rocmKfd()
+	for _, g := range gpus {
+		render := fmt.Sprintf("renderD%d", g.renderMinor)
+		devs = append(devs, filepath.Join("/dev/dri", render))
+		// the card node of the GPU shares its PCI device
+		cards, _ := filepath.Glob(filepath.Join(drmClassDir, render, "device", "drm", "card*"))
+		for _, c := range cards {
+			devs = append(devs, filepath.Join("/dev/dri", filepath.Base(c)))
+		}
+	}
+	return devs, nil
+}
+
+// RocmContainerEnv returns the ROCR_VISIBLE_DEVICES and HIP_VISIBLE_DEVICES
+// variables of the container when the GPUs selected by env are bound with
+// RocmVisibleDevices. ROCm enumerates only the accessible GPUs, so the
+// selected GPUs are renumbered from 0 in the container.
+func RocmContainerEnv(env []string) ([]string, error) {
+	gpus, selected, err := visibleRocmGPUs(env)
+	if err != nil || !selected {
+		return nil, err
+	}
+	ids := make([]string, len(gpus))
+	for i := range gpus {
+		ids[i] = strconv.Itoa(i)
+	}
+	visible := strings.Join(ids, ",")
+
+	var cenv []string
+	for _, e := range RocmEnv(env) {
+		key := strings.SplitN(e, "=", 2)[0]
+		cenv = append(cenv, key+"="+visible)
+	}
+	return cenv, nil
+}
+
+// rocmKfd returns the kfd device if present on host.
+func rocmKfd() []string {
+	if _, err := os.Stat("/dev/kfd"); err == nil {
+		return []string{"/dev/kfd"}
+	}
+	return nil
+}
+
+// visibleRocmGPUs returns the AMD GPUs selected by the ROCR_VISIBLE_DEVICES
+// and HIP_VISIBLE_DEVICES variables of env, selected is false if env
+// doesn't select GPUs.
+func visibleRocmGPUs(env []string) (gpus []rocmGPU, selected bool, err error) {
+	var rocr, hip *string
+	for _, e := range RocmEnv(env) {
+		kv := strings.SplitN(e, "=", 2)
+		value := kv[1]
+		if kv[0] == rocrVisibleDevices {
+			rocr = &value
+		} else {
+			hip = &value
+		}
+	}
+	if rocr == nil && hip == nil {
+		return nil, false, nil
+	}
+
+	gpus, err = rocmGPUs()
+	if err != nil {
+		return nil, true, err
+	}
+	if rocr != nil {
+		if gpus, err = selectRocmGPUs(gpus, *rocr, rocrVisibleDevices); err != nil {
+			return nil, true, err
+		}
+	}
+	if hip != nil {
+		if gpus, err = selectRocmGPUs(gpus, *hip, hipVisibleDevices); err != nil {
+			return nil, true, err
+		}
+	}
+	return gpus, true, nil
+}
+
+// selectRocmGPUs returns the GPUs of gpus selected by the comma separated
+// list visible of GPU indexes or UUIDs (GPU-<unique ID>) of the variable
+// name.
+func selectRocmGPUs(gpus []rocmGPU, visible, name string) ([]rocmGPU, error) {
+	var selected []rocmGPU
+	if visible == "" {
+		return selected, nil
+	}
+	for _, v := range strings.Split(visible, ",") {
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, "GPU-") {
+			id, err := strconv.ParseUint(strings.TrimPrefix(v, "GPU-"), 16, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s GPU UUID %q", name, v)
+			}
+			found := false
+			for _, g := range gpus {
+				if g.uniqueID == id {
+					selected = append(selected, g)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("%s GPU %s not found", name, v)
+			}
+			continue
+		}
+		i, err := strconv.Atoi(v)
+		if err != nil || i < 0 {
+			return nil, fmt.Errorf("invalid %s GPU index %q", name, v)
+		}
+		// ROCm ignores the indexes following an invalid index
+		if i >= len(gpus) {
+			break
+		}
+		selected = append(selected, gpus[i])
+	}
+	return selected, nil
+}
+
+// rocmGPUs returns the AMD GPUs of the KFD topology in the enumeration
+// order of ROCm, the nodes without SIMD are CPUs.
+func rocmGPUs() ([]rocmGPU, error) {
+	nodes, err := ioutil.ReadDir(rocmTopologyDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read KFD topology: %v", err)
+	}
+
+	var ids []int
+	for _, n := range nodes {
+		if id, err := strconv.Atoi(n.Name()); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	var gpus []rocmGPU
+	for _, id := range ids {
+		props, err := readTopologyProperties(filepath.Join(rocmTopologyDir, strconv.Itoa(id), "properties"))
+		if err != nil {
+			return nil, err
+		}
+		if props["simd_count"] == 0 {
+			continue
+		}
+		gpus = append(gpus, rocmGPU{
+			renderMinor: int(props["drm_render_minor"]),
+			uniqueID:    props["unique_id"],
+		})
+	}
+	return gpus, nil
+}
+
+// readTopologyProperties returns the properties of a KFD topology node,
+// a property per line with its name followed by its value.
+func readTopologyProperties(path string) (map[string]uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read KFD topology node: %v", err)
+	}
+	defer f.Close()
+
+	props := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			props[fields[0]] = v
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read KFD topology node %s: %v", path, err)
+	}
+	return props, nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package gpu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// writeTopology writes a KFD topology with a CPU node followed by GPU
+// nodes with the render minors of gpus.
+func writeTopology(t *testing.T, dir string, gpus []rocmGPU) {
+	nodes := []string{"cpu_cores_count 8\nsimd_count 0\ndrm_render_minor 0\n"}
+	for _, g := range gpus {
+		nodes = append(nodes, "cpu_cores_count 0\nsimd_count 256\n"+
+			"drm_render_minor "+strconv.Itoa(g.renderMinor)+"\nunique_id "+strconv.FormatUint(g.uniqueID, 10)+"\n")
+	}
+	for i, props := range nodes {
+		node := filepath.Join(dir, strconv.Itoa(i))
+		if err := os.MkdirAll(node, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(node, "properties"), []byte(props), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVisibleRocmGPUs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kfd-topology-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	gpus := []rocmGPU{
+		{renderMinor: 128, uniqueID: 0x1a2b},
+		{renderMinor: 129, uniqueID: 0x3c4d},
+		{renderMinor: 130, uniqueID: 0x5e6f},
+	}
+	writeTopology(t, dir, gpus)
+
+	oldDir := rocmTopologyDir
+	rocmTopologyDir = dir
+	defer func() { rocmTopologyDir = oldDir }()
+
+	tests := []struct {
+		name     string
+		env      []string
+		gpus     []rocmGPU
+		selected bool
+		wantErr  bool
+	}{
+		{
+			name: "NoSelection",
+			env:  []string{"PATH=/bin"},
+		},
+		{
+			name:     "ROCR",
+			env:      []string{"ROCR_VISIBLE_DEVICES=2,0"},
+			gpus:     []rocmGPU{gpus[2], gpus[0]},
+			selected: true,
+		},
+		{
+			name:     "ROCRUUID",
+			env:      []string{"ROCR_VISIBLE_DEVICES=GPU-3c4d"},
+			gpus:     []rocmGPU{gpus[1]},
+			selected: true,
+		},
+		{
+			name:     "HIPIndexesROCR",
+			env:      []string{"HIP_VISIBLE_DEVICES=1", "ROCR_VISIBLE_DEVICES=0,2"},
+			gpus:     []rocmGPU{gpus[2]},
+			selected: true,
+		},
+		{
+			name:     "InvalidIndexStops",
+			env:      []string{"HIP_VISIBLE_DEVICES=1,5,0"},
+			gpus:     []rocmGPU{gpus[1]},
+			selected: true,
+		},
+		{
+			name:     "Empty",
+			env:      []string{"ROCR_VISIBLE_DEVICES="},
+			selected: true,
+		},
+		{
+			name:     "BadIndex",
+			env:      []string{"ROCR_VISIBLE_DEVICES=../card0"},
+			selected: true,
+			wantErr:  true,
+		},
+		{
+			name:     "UnknownUUID",
+			env:      []string{"ROCR_VISIBLE_DEVICES=GPU-ffff"},
+			selected: true,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gpus, selected, err := visibleRocmGPUs(tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if selected != tt.selected {
+				t.Errorf("got selected %v, want %v", selected, tt.selected)
+			}
+			if !reflect.DeepEqual(gpus, tt.gpus) && !tt.wantErr {
+				t.Errorf("got GPUs %v, want %v", gpus, tt.gpus)
+			}
+		})
+	}
+
+	env, err := RocmContainerEnv([]string{"HOME=/root", "ROCR_VISIBLE_DEVICES=2,0", "HIP_VISIBLE_DEVICES=1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"ROCR_VISIBLE_DEVICES=0", "HIP_VISIBLE_DEVICES=0"}; !reflect.DeepEqual(env, want) {
+		t.Errorf("got container environment %v, want %v", env, want)
+	}
+}
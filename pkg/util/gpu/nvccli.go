@@ -0,0 +1,162 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package gpu
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// nvCCLIEnvPrefix is the prefix of the environment variables selecting
+// the GPUs and the driver capabilities of nvidia-container-cli.
+const nvCCLIEnvPrefix = "NVIDIA_"
+
+// nvCCLICaps are the driver capabilities of nvidia-container-cli.
+var nvCCLICaps = []string{
+	"compute",
+	"compat32",
+	"display",
+	"graphics",
+	"ngx",
+	"utility",
+	"video",
+}
+
+// NvCCLIEnv returns the environment variables of env configuring the GPU
+// setup of nvidia-container-cli.
+func NvCCLIEnv(env []string) []string {
+	var nv []string
+	for _, e := range env {
+		if strings.HasPrefix(e, nvCCLIEnvPrefix) {
+			nv = append(nv, e)
+		}
+	}
+	return nv
+}
+
+// NvCCLIFlags returns the nvidia-container-cli configure flags of the
+// environment variables env, as for the NVIDIA container runtime.
+// NVIDIA_VISIBLE_DEVICES selects the GPUs by index or UUID, all GPUs by
+// default, or none with 'none' or 'void'. NVIDIA_DRIVER_CAPABILITIES
+// selects the driver capabilities, 'compute' and 'utility' by default, or
+// all with 'all'. NVIDIA_REQUIRE_* are the constraints checked against the
+// driver and GPUs, such as the CUDA version (NVIDIA_REQUIRE_CUDA), they are
+// ignored if NVIDIA_DISABLE_REQUIRE is true.
+func NvCCLIFlags(env []string) ([]string, error) {
+	devices := "all"
+	caps := "compute,utility"
+	disableRequire := false
+	var requires []string
+
+	for _, e := range env {
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := kv[0], kv[1]
+		switch {
+		case key == "NVIDIA_VISIBLE_DEVICES":
+			devices = value
+		case key == "NVIDIA_DRIVER_CAPABILITIES":
+			caps = value
+		case key == "NVIDIA_DISABLE_REQUIRE":
+			disable, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid NVIDIA_DISABLE_REQUIRE value %q: %v", value, err)
+			}
+			disableRequire = disable
+		case strings.HasPrefix(key, "NVIDIA_REQUIRE_"):
+			requires = append(requires, value)
+		}
+	}
+
+	var flags []string
+	switch devices {
+	case "", "none", "void":
+	default:
+		for _, d := range strings.Split(devices, ",") {
+			if d != "all" && strings.TrimLeft(d, "0123456789") != "" && !strings.HasPrefix(d, "GPU-") && !strings.HasPrefix(d, "MIG-") {
+				return nil, fmt.Errorf("invalid NVIDIA_VISIBLE_DEVICES value %q", devices)
+			}
+		}
+		flags = append(flags, "--device="+devices)
+	}
+
+	if caps == "all" {
+		caps = strings.Join(nvCCLICaps, ",")
+	}
+	for _, c := range strings.Split(caps, ",") {
+		if c == "" {
+			continue
+		}
+		known := false
+		for _, k := range nvCCLICaps {
+			if c == k {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown NVIDIA_DRIVER_CAPABILITIES value %q", c)
+		}
+		flags = append(flags, "--"+c)
+	}
+
+	if !disableRequire {
+		for _, r := range requires {
+			flags = append(flags, "--require="+r)
+		}
+	}
+	return flags, nil
+}
+
+// NvCCLIConfigure runs nvidia-container-cli at path to set up the GPUs in
+// the root filesystem rootfs of the mount namespace of the process pid with
+// flags returned by NvCCLIFlags. The host libraries cache is updated with
+// the ldconfig program, userNS must be true in a user namespace where
+// nvidia-container-cli doesn't have the privileges to manage cgroups.
+// The CUDA compat libraries of the image are used by nvidia-container-cli
+// when the host driver is older than the CUDA version of the image.
+func NvCCLIConfigure(path, ldconfig string, flags []string, pid int, rootfs string, userNS bool) error {
+	var args []string
+	if userNS {
+		args = append(args, "--user")
+	}
+	args = append(args,
+		"configure",
+		// the device access is controlled by singularity
+		"--no-cgroups",
+		"--pid="+strconv.Itoa(pid),
+	)
+	if ldconfig != "" {
+		// the @ prefix refers to a host path
+		args = append(args, "--ldconfig=@"+ldconfig)
+	}
+	args = append(args, flags...)
+	args = append(args, rootfs)
+
+	sylog.Debugf("Running %s %s", path, strings.Join(args, " "))
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(path, args...)
+	cmd.Stderr = &stderr
+	cmd.Env = []string{"PATH=/usr/sbin:/usr/bin:/sbin:/bin"}
+	if !userNS {
+		// run by root only, the real IDs are set for the
+		// programs run by nvidia-container-cli
+		cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: 0, Gid: 0}}
+	}
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %v: %s", path, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package gpu
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNvCCLIFlags(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     []string
+		flags   []string
+		wantErr bool
+	}{
+		{
+			name:  "Defaults",
+			env:   []string{"PATH=/bin"},
+			flags: []string{"--device=all", "--compute", "--utility"},
+		},
+		{
+			name: "Selected",
+			env: []string{
+				"NVIDIA_VISIBLE_DEVICES=0,GPU-fef8089b",
+				"NVIDIA_DRIVER_CAPABILITIES=video,graphics",
+				"NVIDIA_REQUIRE_CUDA=cuda>=10.2",
+			},
+			flags: []string{"--device=0,GPU-fef8089b", "--video", "--graphics", "--require=cuda>=10.2"},
+		},
+		{
+			name: "NoDevice",
+			env:  []string{"NVIDIA_VISIBLE_DEVICES=none", "NVIDIA_DRIVER_CAPABILITIES=all"},
+			flags: []string{
+				"--compute", "--compat32", "--display", "--graphics", "--ngx", "--utility", "--video",
+			},
+		},
+		{
+			name:  "DisableRequire",
+			env:   []string{"NVIDIA_REQUIRE_CUDA=cuda>=10.2", "NVIDIA_DISABLE_REQUIRE=true"},
+			flags: []string{"--device=all", "--compute", "--utility"},
+		},
+		{
+			name:    "BadDevice",
+			env:     []string{"NVIDIA_VISIBLE_DEVICES=0;reboot"},
+			wantErr: true,
+		},
+		{
+			name:    "BadCapability",
+			env:     []string{"NVIDIA_DRIVER_CAPABILITIES=compute,--pid=1"},
+			wantErr: true,
+		},
+		{
+			name:    "BadDisableRequire",
+			env:     []string{"NVIDIA_DISABLE_REQUIRE=maybe"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			flags, err := NvCCLIFlags(tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(flags, tt.flags) {
+				t.Errorf("got flags %v, want %v", flags, tt.flags)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package gpu
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// nvidiaVisibleDevices is the environment variable selecting the NVIDIA
+// GPUs and MIG instances.
+const nvidiaVisibleDevices = "NVIDIA_VISIBLE_DEVICES"
+
+// nvidiaGPUsDir is the directory of the driver information of the NVIDIA
+// GPUs, a directory per GPU named by its PCI address.
+var nvidiaGPUsDir = "/proc/driver/nvidia/gpus"
+
+// nvidiaMigMinors is the file listing the minor numbers of the MIG
+// capability devices, a capability per line with its minor number.
+var nvidiaMigMinors = "/proc/driver/nvidia-caps/mig-minors"
+
+// nvidiaCapsDir is the directory of the capability devices granting
+// access to the MIG instances.
+const nvidiaCapsDir = "/dev/nvidia-caps"
+
+// nvidiaGPU is an NVIDIA GPU of the driver.
+type nvidiaGPU struct {
+	minor int
+	uuid  string
+}
+
+// NvidiaEnv returns the environment variables of env selecting the NVIDIA
+// GPUs bound by NvidiaVisibleDevices.
+func NvidiaEnv(env []string) []string {
+	for _, e := range env {
+		if strings.HasPrefix(e, nvidiaVisibleDevices+"=") {
+			return []string{e}
+		}
+	}
+	return nil
+}
+
+// NvidiaVisibleDevices returns the list of nvidia devices present on host
+// for the GPUs selected by the NVIDIA_VISIBLE_DEVICES variable of env,
+// a comma separated list of GPU indexes, GPU UUIDs and MIG UUIDs of the
+// form MIG-GPU-<GPU UUID>/<GPU instance>/<compute instance>. All the GPUs
+// are selected if the variable is unset or 'all', none with 'none' or
+// 'void'. A MIG instance selects its GPU and the capability devices of
+// its GPU and compute instances, so the container only sees its slice.
+func NvidiaVisibleDevices(env []string) ([]string, error) {
+	visible, ok := nvidiaVisible(env)
+	if !ok || visible == "all" {
+		return NvidiaDevices(true)
+	}
+
+	nonGPU, err := NvidiaDevices(false)
+	if err != nil {
+		return nil, err
+	}
+	// the capability devices of all MIG instances are bound only
+	// if they are selected
+	var devs []string
+	for _, d := range nonGPU {
+		if d != nvidiaCapsDir {
+			devs = append(devs, d)
+		}
+	}
+	if visible == "" || visible == "none" || visible == "void" {
+		return devs, nil
+	}
+
+	gpus, err := nvidiaGPUs()
+	if err != nil {
+		return nil, err
+	}
+	var caps map[string]int
+
+	seen := make(map[string]bool)
+	add := func(dev string) {
+		if !seen[dev] {
+			seen[dev] = true
+			devs = append(devs, dev)
+		}
+	}
+	for _, v := range strings.Split(visible, ",") {
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, "MIG-") {
+			g, gi, ci, err := parseMigUUID(gpus, v)
+			if err != nil {
+				return nil, err
+			}
+			if caps == nil {
+				if caps, err = nvidiaMigCaps(); err != nil {
+					return nil, err
+				}
+			}
+			add(fmt.Sprintf("/dev/nvidia%d", g.minor))
+			for _, c := range []string{
+				fmt.Sprintf("gpu%d/gi%d/access", g.minor, gi),
+				fmt.Sprintf("gpu%d/gi%d/ci%d/access", g.minor, gi, ci),
+			} {
+				minor, ok := caps[c]
+				if !ok {
+					return nil, fmt.Errorf("MIG capability %s of %s not found", c, v)
+				}
+				add(filepath.Join(nvidiaCapsDir, fmt.Sprintf("nvidia-cap%d", minor)))
+			}
+			continue
+		}
+		g, err := findNvidiaGPU(gpus, v)
+		if err != nil {
+			return nil, err
+		}
+		add(fmt.Sprintf("/dev/nvidia%d", g.minor))
+	}
+	return devs, nil
+}
+
+// NvidiaContainerEnv returns the CUDA_VISIBLE_DEVICES variable of the
+// container when MIG instances are selected by the NVIDIA_VISIBLE_DEVICES
+// variable of env, as CUDA only uses a MIG instance selected by its UUID.
+func NvidiaContainerEnv(env []string) ([]string, error) {
+	visible, ok := nvidiaVisible(env)
+	if !ok || !strings.Contains(visible, "MIG-") {
+		return nil, nil
+	}
+
+	gpus, err := nvidiaGPUs()
+	if err != nil {
+		return nil, err
+	}
+	var uuids []string
+	for _, v := range strings.Split(visible, ",") {
+		v = strings.TrimSpace(v)
+		if !strings.HasPrefix(v, "MIG-") {
+			g, err := findNvidiaGPU(gpus, v)
+			if err != nil {
+				return nil, err
+			}
+			v = g.uuid
+		}
+		uuids = append(uuids, v)
+	}
+	return []string{"CUDA_VISIBLE_DEVICES=" + strings.Join(uuids, ",")}, nil
+}
+
+// nvidiaVisible returns the value of the NVIDIA_VISIBLE_DEVICES variable
+// of env, ok is false if the variable is unset.
+func nvidiaVisible(env []string) (visible string, ok bool) {
+	nv := NvidiaEnv(env)
+	if len(nv) == 0 {
+		return "", false
+	}
+	return strings.TrimPrefix(nv[0], nvidiaVisibleDevices+"="), true
+}
+
+// findNvidiaGPU returns the GPU of gpus selected by an index or a UUID.
+func findNvidiaGPU(gpus []nvidiaGPU, v string) (nvidiaGPU, error) {
+	if strings.HasPrefix(v, "GPU-") {
+		for _, g := range gpus {
+			if strings.EqualFold(g.uuid, v) {
+				return g, nil
+			}
+		}
+		return nvidiaGPU{}, fmt.Errorf("%s GPU %s not found", nvidiaVisibleDevices, v)
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return nvidiaGPU{}, fmt.Errorf("invalid %s GPU %q", nvidiaVisibleDevices, v)
+	}
+	if i >= len(gpus) {
+		return nvidiaGPU{}, fmt.Errorf("%s GPU %d not found", nvidiaVisibleDevices, i)
+	}
+	return gpus[i], nil
+}
+
+// parseMigUUID returns the GPU of gpus, the GPU instance and the compute
+// instance of the MIG UUID v.
+func parseMigUUID(gpus []nvidiaGPU, v string) (g nvidiaGPU, gi, ci int, err error) {
+	parts := strings.Split(strings.TrimPrefix(v, "MIG-"), "/")
+	if len(parts) != 3 || !strings.HasPrefix(parts[0], "GPU-") {
+		return g, 0, 0, fmt.Errorf("unsupported MIG UUID %s, expected MIG-GPU-<GPU UUID>/<GPU instance>/<compute instance>", v)
+	}
+	if gi, err = strconv.Atoi(parts[1]); err != nil || gi < 0 {
+		return g, 0, 0, fmt.Errorf("invalid GPU instance of MIG UUID %s", v)
+	}
+	if ci, err = strconv.Atoi(parts[2]); err != nil || ci < 0 {
+		return g, 0, 0, fmt.Errorf("invalid compute instance of MIG UUID %s", v)
+	}
+	g, err = findNvidiaGPU(gpus, parts[0])
+	return g, gi, ci, err
+}
+
+// nvidiaGPUs returns the NVIDIA GPUs of the driver in the enumeration
+// order of NVML, the PCI address order.
+func nvidiaGPUs() ([]nvidiaGPU, error) {
+	dirs, err := ioutil.ReadDir(nvidiaGPUsDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read nvidia GPUs: %v", err)
+	}
+	names := make([]string, 0, len(dirs))
+	for _, d := range dirs {
+		names = append(names, d.Name())
+	}
+	sort.Strings(names)
+
+	gpus := make([]nvidiaGPU, 0, len(names))
+	for _, name := range names {
+		info, err := readKeyValues(filepath.Join(nvidiaGPUsDir, name, "information"), ":")
+		if err != nil {
+			return nil, fmt.Errorf("could not read nvidia GPU %s: %v", name, err)
+		}
+		minor, err := strconv.Atoi(info["Device Minor"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid device minor of nvidia GPU %s", name)
+		}
+		gpus = append(gpus, nvidiaGPU{minor: minor, uuid: info["GPU UUID"]})
+	}
+	return gpus, nil
+}
+
+// nvidiaMigCaps returns the minor numbers of the MIG capability devices.
+func nvidiaMigCaps() (map[string]int, error) {
+	values, err := readKeyValues(nvidiaMigMinors, " ")
+	if err != nil {
+		return nil, fmt.Errorf("could not read MIG capabilities: %v", err)
+	}
+	caps := make(map[string]int, len(values))
+	for k, v := range values {
+		if minor, err := strconv.Atoi(v); err == nil {
+			caps[k] = minor
+		}
+	}
+	return caps, nil
+}
+
+// readKeyValues returns the key and value pairs of the file path, a pair
+// per line separated by sep.
+func readKeyValues(path, sep string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), sep, 2)
+		if len(kv) == 2 {
+			values[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return values, scanner.Err()
+}
@@ -0,0 +1,129 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package gpu
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	testGPU0 = "GPU-5d5ba0d6-d33d-2b2c-524d-9e3d8d2b8a77"
+	testGPU1 = "GPU-0ab1c2d3-e4f5-a6b7-c8d9-e0f1a2b3c4d5"
+)
+
+func TestNvidiaVisibleDevices(t *testing.T) {
+	if devs, _ := NvidiaDevices(true); len(devs) > 0 {
+		t.Skip("nvidia devices present on host")
+	}
+
+	dir, err := ioutil.TempDir("", "nvidia-driver-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// PCI address order differs from the device minor order
+	for _, gpu := range []struct{ pci, info string }{
+		{"0000:07:00.0", "Model: A100\nDevice Minor: 1\nGPU UUID: " + testGPU0 + "\n"},
+		{"0000:0f:00.0", "Model: A100\nDevice Minor: 0\nGPU UUID: " + testGPU1 + "\n"},
+	} {
+		gpuDir := filepath.Join(dir, "gpus", gpu.pci)
+		if err := os.MkdirAll(gpuDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(gpuDir, "information"), []byte(gpu.info), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	minors := "config 1\nmonitor 2\ngpu1/gi1/access 12\ngpu1/gi1/ci0/access 13\ngpu1/gi2/access 21\ngpu1/gi2/ci0/access 22\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "mig-minors"), []byte(minors), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	oldGPUsDir, oldMigMinors := nvidiaGPUsDir, nvidiaMigMinors
+	nvidiaGPUsDir, nvidiaMigMinors = filepath.Join(dir, "gpus"), filepath.Join(dir, "mig-minors")
+	defer func() { nvidiaGPUsDir, nvidiaMigMinors = oldGPUsDir, oldMigMinors }()
+
+	mig := "MIG-" + testGPU0 + "/2/0"
+
+	tests := []struct {
+		name    string
+		visible string
+		devs    []string
+		env     []string
+		wantErr bool
+	}{
+		{
+			name:    "None",
+			visible: "none",
+		},
+		{
+			name:    "Index",
+			visible: "1,0",
+			devs:    []string{"/dev/nvidia0", "/dev/nvidia1"},
+		},
+		{
+			name:    "UUID",
+			visible: testGPU1,
+			devs:    []string{"/dev/nvidia0"},
+		},
+		{
+			name:    "MIG",
+			visible: mig,
+			devs:    []string{"/dev/nvidia1", "/dev/nvidia-caps/nvidia-cap21", "/dev/nvidia-caps/nvidia-cap22"},
+			env:     []string{"CUDA_VISIBLE_DEVICES=" + mig},
+		},
+		{
+			name:    "MIGAndIndex",
+			visible: "1," + mig,
+			devs:    []string{"/dev/nvidia0", "/dev/nvidia1", "/dev/nvidia-caps/nvidia-cap21", "/dev/nvidia-caps/nvidia-cap22"},
+			env:     []string{"CUDA_VISIBLE_DEVICES=" + testGPU1 + "," + mig},
+		},
+		{
+			name:    "UnknownMIGInstance",
+			visible: "MIG-" + testGPU0 + "/3/0",
+			wantErr: true,
+		},
+		{
+			name:    "UnsupportedMIGUUID",
+			visible: "MIG-bb3c1e52-9d3c-5bd1-b1d5-1b7e2a1e9c6d",
+			wantErr: true,
+		},
+		{
+			name:    "BadIndex",
+			visible: "2",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := []string{"HOME=/root", nvidiaVisibleDevices + "=" + tt.visible}
+			devs, err := NvidiaVisibleDevices(env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(devs, tt.devs) {
+				t.Errorf("got devices %v, want %v", devs, tt.devs)
+			}
+			cenv, err := NvidiaContainerEnv(env)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(cenv, tt.env) {
+				t.Errorf("got container environment %s, want %s", strings.Join(cenv, " "), strings.Join(tt.env, " "))
+			}
+		})
+	}
+}
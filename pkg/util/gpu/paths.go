@@ -112,7 +112,7 @@ func NvidiaPaths(configFilePath, userEnvPath string) ([]string, []string, error)
 		}
 	}
 
-	return paths(nvidiaFiles)
+	return paths(nvidiaFiles, nil)
 }
 
 // RocmPaths returns a list of rocm libraries/binaries that should be
@@ -129,12 +129,52 @@ func RocmPaths(configFilePath, userEnvPath string) ([]string, []string, error) {
 		return nil, nil, fmt.Errorf("could not read %s: %v", filepath.Base(configFilePath), err)
 	}
 
-	return paths(rocmFiles)
+	return paths(rocmFiles, rocmLibDirs())
+}
+
+// rocmLibDirs returns the ROCm installation directories, the libraries of
+// the ldconfig cache located in these directories are ROCm libraries.
+func rocmLibDirs() []string {
+	dirs, _ := filepath.Glob("/opt/rocm*")
+	if rocmPath := os.Getenv("ROCM_PATH"); rocmPath != "" {
+		dirs = append(dirs, rocmPath)
+	}
+
+	var libDirs []string
+	for _, d := range dirs {
+		resolved, err := filepath.EvalSymlinks(d)
+		if err != nil {
+			continue
+		}
+		if fi, err := os.Stat(resolved); err == nil && fi.IsDir() {
+			libDirs = append(libDirs, resolved)
+		}
+	}
+	return libDirs
+}
+
+// inDirs returns true if the resolved path of file is located in one of
+// the directories dirs.
+func inDirs(file string, dirs []string) bool {
+	if len(dirs) == 0 {
+		return false
+	}
+	resolved, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return false
+	}
+	for _, d := range dirs {
+		if strings.HasPrefix(resolved, d+"/") {
+			return true
+		}
+	}
+	return false
 }
 
 // paths handles generic library parsing functionality once the platform
-// specific libs/binaries have been identified
-func paths(gpuFileList []string) ([]string, []string, error) {
+// specific libs/binaries have been identified, the libraries of the
+// ldconfig cache located in the directories libDirs are also returned
+func paths(gpuFileList []string, libDirs []string) ([]string, []string, error) {
 	// walk through the ldconfig output and add entries which contain the filenames
 	// returned by nvidia-container-cli OR the nvliblist.conf file contents
 	ldConfig, err := exec.LookPath("ldconfig")
@@ -186,6 +226,26 @@ func paths(gpuFileList []string) ([]string, []string, error) {
 
 	var libraries []string
 	var binaries []string
+
+	for libPath, libName := range ldCache {
+		if _, ok := libs[libName]; ok || !inDirs(libPath, libDirs) {
+			continue
+		}
+		elib, err := elf.Open(libPath)
+		if err != nil {
+			sylog.Debugf("ignore library %s: %s", libName, err)
+			continue
+		}
+
+		if elib.Machine == machine {
+			libs[libName] = struct{}{}
+			libraries = append(libraries, libPath)
+		}
+
+		if err := elib.Close(); err != nil {
+			sylog.Warningf("Could not close ELIB: %v", err)
+		}
+	}
 	for _, file := range gpuFileList {
 		// if the file contains a ".so", treat it as a library
 		if strings.Contains(file, ".so") {
@@ -12,6 +12,8 @@ import (
 	"os"
 	"runtime"
 	"syscall"
+
+	"github.com/sylabs/singularity/pkg/sylog"
 )
 
 var setnsSysNo = map[string]uintptr{
@@ -51,6 +53,7 @@ func Enter(pid int, namespace string) error {
 		return fmt.Errorf("unsupported platform %s", runtime.GOARCH)
 	}
 
+	sylog.Tracef("setns(%s, %#x)", path, flag)
 	_, _, errSys := syscall.RawSyscall(ns, f.Fd(), flag, 0)
 	if errSys != 0 {
 		return errSys
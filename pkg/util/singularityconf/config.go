@@ -35,9 +35,11 @@ type File struct {
 	MountHostfs             bool     `default:"no" authorized:"yes,no" directive:"mount hostfs"`
 	UserBindControl         bool     `default:"yes" authorized:"yes,no" directive:"user bind control"`
 	EnableFusemount         bool     `default:"yes" authorized:"yes,no" directive:"enable fusemount"`
+	FusemountProfile        []string `directive:"fusemount profile"`
 	EnableUnderlay          bool     `default:"yes" authorized:"yes,no" directive:"enable underlay"`
 	MountSlave              bool     `default:"yes" authorized:"yes,no" directive:"mount slave"`
 	AllowContainerSquashfs  bool     `default:"yes" authorized:"yes,no" directive:"allow container squashfs"`
+	AllowContainerErofs     bool     `default:"yes" authorized:"yes,no" directive:"allow container erofs"`
 	AllowContainerExtfs     bool     `default:"yes" authorized:"yes,no" directive:"allow container extfs"`
 	AllowContainerDir       bool     `default:"yes" authorized:"yes,no" directive:"allow container dir"`
 	AllowContainerEncrypted bool     `default:"yes" authorized:"yes,no" directive:"allow container encrypted"`
@@ -60,7 +62,23 @@ type File struct {
 	MksquashfsProcs         uint     `default:"0" directive:"mksquashfs procs"`
 	MksquashfsMem           string   `directive:"mksquashfs mem"`
 	CryptsetupPath          string   `directive:"cryptsetup path"`
+	NvidiaContainerCliPath  string   `directive:"nvidia-container-cli path"`
+	LdconfigPath            string   `directive:"ldconfig path"`
 	ImageDriver             string   `directive:"image driver"`
+	LogForward              string   `default:"none" authorized:"none,syslog,journald" directive:"log forward"`
+	LogForwardLevel         string   `default:"warning" directive:"log forward level"`
+	LogTimestamps           string   `default:"no" authorized:"no,rfc3339,relative" directive:"log timestamps"`
+	LogFile                 string   `directive:"log file"`
+	LogFileMaxSize          uint     `default:"10" directive:"log file max size"`
+	LogFileMaxBackups       uint     `default:"3" directive:"log file max backups"`
+	OtlpEndpoint            string   `directive:"otlp endpoint"`
+	Color                   string   `default:"auto" authorized:"never,auto,always" directive:"color"`
+	AuditLog                string   `directive:"audit log"`
+	SignaturePolicy         string   `default:"none" authorized:"none,warn,enforce" directive:"signature policy"`
+	SignatureKeyring        string   `directive:"signature keyring"`
+	SignatureExemptPath     []string `directive:"signature exempt path"`
+	SignatureExemptURI      []string `directive:"signature exempt uri"`
+	SignatureExemptDigest   []string `directive:"signature exempt digest"`
 }
 
 const TemplateAsset = `# SINGULARITY.CONF
@@ -190,6 +208,19 @@ user bind control = {{ if eq .UserBindControl true }}yes{{ else }}no{{ end }}
 # command line option.
 enable fusemount = {{ if eq .EnableFusemount true }}yes{{ else }}no{{ end }}
 
+# FUSEMOUNT PROFILE: [STRING]
+# DEFAULT: Undefined
+# Define a FUSE mount profile that users can reference by name with the
+# --fusemount profile:<name> <mountpoint> command line option. The profile
+# name is followed by a --fusemount specification without its mount point,
+# a built-in driver (sshfs, s3fs, goofys, squashfuse) or a FUSE command.
+# NOTE: commas separate the directive values, use a -o option per FUSE option.
+#fusemount profile = scratch sshfs:storage.example.com:/scratch -o ro -o reconnect
+{{ range $profile := .FusemountProfile }}
+{{- if ne $profile "" -}}
+fusemount profile = {{$profile}}
+{{ end -}}
+{{ end }}
 # ENABLE OVERLAY: [yes/no/try/driver]
 # DEFAULT: try
 # Enabling this option will make it possible to specify bind paths to locations
@@ -253,11 +284,57 @@ sessiondir max size = {{ .SessiondirMaxSize }}
 {{- if eq $index 0 }}limit container paths = {{ else }}, {{ end }}{{$path}}
 {{- end }}
 
+# SIGNATURE POLICY: [none/warn/enforce]
+# DEFAULT: none
+# Verify the signature of the primary system partition of SIF images with the
+# public keys of the signature keyring before the image is mounted. If
+# 'enforce' is chosen, images which aren't signed by one of these keys, as
+# well as the images which can't be signed (sandbox, squashfs and ext3 images),
+# are refused unless they're exempted. If 'warn' is chosen, they're run with a
+# warning. The decisions are recorded in the audit log if set.
+signature policy = {{ .SignaturePolicy }}
+
+# SIGNATURE KEYRING: [STRING]
+# DEFAULT: Undefined
+# Path of the file holding the trusted public keys of the signature policy,
+# in binary or ascii armored format (eg: as written by
+# 'singularity key export'). It must be set when the signature policy isn't
+# 'none' and be owned by root.
+# signature keyring =
+{{ if ne .SignatureKeyring "" }}signature keyring = {{ .SignatureKeyring }}{{ end }}
+
+# SIGNATURE EXEMPT ${TYPE}: [STRING]
+# DEFAULT: Undefined
+# Exempt images from the signature policy by their path, by the URI they were
+# run from (eg: library://, docker://) or by the sha256 digest of the image
+# file. Paths and URIs are shell patterns, a trailing * also matches the
+# following path components. Unlike paths and digests, the URI is provided by
+# the user running the image, URI exemptions only apply to the container
+# images run by root.
+#signature exempt path = /opt/images/*
+#signature exempt uri = docker://docker.io/library/*
+#signature exempt digest = sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855
+{{ range $path := .SignatureExemptPath }}
+{{- if ne $path "" -}}
+signature exempt path = {{$path}}
+{{ end -}}
+{{ end -}}
+{{ range $uri := .SignatureExemptURI }}
+{{- if ne $uri "" -}}
+signature exempt uri = {{$uri}}
+{{ end -}}
+{{ end -}}
+{{ range $digest := .SignatureExemptDigest }}
+{{- if ne $digest "" -}}
+signature exempt digest = {{$digest}}
+{{ end -}}
+{{ end }}
 # ALLOW CONTAINER ${TYPE}: [BOOL]
 # DEFAULT: yes
 # This feature limits what kind of containers that Singularity will allow
 # users to use (note this does not apply for root).
 allow container squashfs = {{ if eq .AllowContainerSquashfs true }}yes{{ else }}no{{ end }}
+allow container erofs = {{ if eq .AllowContainerErofs true }}yes{{ else }}no{{ end }}
 allow container extfs = {{ if eq .AllowContainerExtfs true }}yes{{ else }}no{{ end }}
 allow container dir = {{ if eq .AllowContainerDir true }}yes{{ else }}no{{ end }}
 allow container encrypted = {{ if eq .AllowContainerEncrypted true }}yes{{ else }}no{{ end }}
@@ -335,6 +412,21 @@ mksquashfs procs = {{ .MksquashfsProcs }}
 # recorded at build time.
 # cryptsetup path =
 {{ if ne .CryptsetupPath "" }}cryptsetup path = {{ .CryptsetupPath }}{{ end }}
+
+# NVIDIA-CONTAINER-CLI PATH: [STRING]
+# DEFAULT: Undefined
+# Path to the nvidia-container-cli program setting up the GPUs with --nvccli,
+# if undefined it's searched in the standard system locations.
+# nvidia-container-cli path =
+{{ if ne .NvidiaContainerCliPath "" }}nvidia-container-cli path = {{ .NvidiaContainerCliPath }}{{ end }}
+
+# LDCONFIG PATH: [STRING]
+# DEFAULT: Undefined
+# Path to the host ldconfig program used by nvidia-container-cli with --nvccli
+# to update the libraries cache of the container, if undefined the default
+# of nvidia-container-cli is used.
+# ldconfig path =
+{{ if ne .LdconfigPath "" }}ldconfig path = {{ .LdconfigPath }}{{ end }}
 # SHARED LOOP DEVICES: [BOOL]
 # DEFAULT: no
 # Allow to share same images associated with loop devices to minimize loop
@@ -350,4 +442,79 @@ shared loop devices = {{ if eq .SharedLoopDevices true }}yes{{ else }}no{{ end }
 # If the driver name specified has not been registered via a plugin installation
 # the run-time will abort.
 image driver = {{ .ImageDriver }}
+
+# LOG FORWARD: [none/syslog/journald]
+# DEFAULT: none
+# Forward Singularity messages to the system log in addition to the terminal
+# output. If 'syslog' is chosen, messages are sent to the local syslog daemon,
+# if 'journald' is chosen, messages are sent to systemd-journald with the
+# SINGULARITY_LEVEL, SINGULARITY_UID and SINGULARITY_CONTAINER fields.
+log forward = {{ .LogForward }}
+
+# LOG FORWARD LEVEL: [STRING]
+# DEFAULT: warning
+# Minimum level of messages forwarded to the system log, one of fatal, error,
+# warning, info, verbose, verbose2, verbose3 or debug. Forwarding happens
+# independently of the verbosity requested on the command line.
+log forward level = {{ .LogForwardLevel }}
+
+# LOG TIMESTAMPS: [no/rfc3339/relative]
+# DEFAULT: no
+# Prepend a timestamp to every message. If 'rfc3339' is chosen, the wall-clock
+# time is displayed, if 'relative' is chosen, the time elapsed since the start
+# of the command is displayed. Users can override this option with the
+# SINGULARITY_LOG_TIMESTAMPS environment variable.
+log timestamps = {{ .LogTimestamps }}
+
+# LOG FILE: [STRING]
+# DEFAULT: Undefined
+# Duplicate all messages displayed by Singularity into this file, the file
+# must be writable by the users running Singularity. Users can set their own
+# log file with the SINGULARITY_LOGFILE environment variable which takes
+# precedence over this option.
+# log file =
+{{ if ne .LogFile "" }}log file = {{ .LogFile }}{{ end }}
+
+# LOG FILE MAX SIZE: [UINT]
+# DEFAULT: 10
+# Maximum size (in MB) of the log file before it is rotated, 0 disables the
+# rotation.
+log file max size = {{ .LogFileMaxSize }}
+
+# LOG FILE MAX BACKUPS: [UINT]
+# DEFAULT: 3
+# Number of rotated log files kept with a numbered suffix.
+log file max backups = {{ .LogFileMaxBackups }}
+
+# OTLP ENDPOINT: [STRING]
+# DEFAULT: Undefined
+# URL of an OpenTelemetry collector receiving traces with the OTLP/HTTP
+# protocol (eg: http://localhost:4318). When set, image pull, build, verify
+# and container execution steps are recorded as spans with the messages
+# displayed during each step attached as events. The standard
+# OTEL_EXPORTER_OTLP_ENDPOINT and OTEL_EXPORTER_OTLP_TRACES_ENDPOINT
+# environment variables take precedence over this option.
+# otlp endpoint =
+{{ if ne .OtlpEndpoint "" }}otlp endpoint = {{ .OtlpEndpoint }}{{ end }}
+
+# COLOR: [never/auto/always]
+# DEFAULT: auto
+# Display colored messages. If 'auto' is chosen, messages are colored only
+# when the error output is a terminal. Colors are always disabled by the
+# --nocolor option or when the NO_COLOR environment variable is set. Users
+# can change the level colors with the SINGULARITY_COLORS environment
+# variable, a colon separated list of level=SGR pairs where SGR are ANSI
+# select graphic rendition parameters (eg: "error=1;31:warning=35:info=36").
+color = {{ .Color }}
+
+# AUDIT LOG: [STRING]
+# DEFAULT: Undefined
+# Record security relevant events (setuid starter invocations, fakeroot
+# requests, ECL and signature policy decisions, signature verifications and
+# encrypted image unlocks) independently of the messages displayed to users.
+# The value is either 'auditd' to send records to the kernel audit subsystem,
+# or the absolute path of a file where records are appended, this file must
+# be owned by root and not writable by group or others.
+# audit log =
+{{ if ne .AuditLog "" }}audit log = {{ .AuditLog }}{{ end }}
 `
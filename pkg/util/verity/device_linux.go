@@ -0,0 +1,80 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package verity
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/singularity/internal/pkg/util/bin"
+	"github.com/sylabs/singularity/pkg/sylog"
+	"github.com/sylabs/singularity/pkg/util/fs/lock"
+)
+
+// veritysetup runs veritysetup as root with args.
+func veritysetup(args ...string) error {
+	path, err := bin.Veritysetup()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(path, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Credential: &syscall.Credential{Uid: 0, Gid: 0},
+	}
+	sylog.Debugf("Running %s %s", cmd.Path, strings.Join(cmd.Args, " "))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("veritysetup %s failed: %s: %v", args[0], strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// Open creates a verity device checking the blocks read from the data
+// device against the hash tree of the hash device, usually loop devices
+// of the partition and of its hash tree. It returns the name of the
+// device mapped in /dev/mapper.
+func Open(dataDev, hashDev string, p *Params) (string, error) {
+	fd, err := lock.Exclusive("/dev/mapper")
+	if err != nil {
+		return "", fmt.Errorf("unable to acquire lock on /dev/mapper")
+	}
+	defer lock.Release(fd)
+
+	name := "verity-" + uuid.NewV4().String()
+	args := []string{
+		"open",
+		"--no-superblock",
+		"--format=1",
+		"--hash=" + p.Algorithm,
+		fmt.Sprintf("--data-block-size=%d", p.DataBlockSize),
+		fmt.Sprintf("--hash-block-size=%d", p.HashBlockSize),
+		fmt.Sprintf("--data-blocks=%d", p.DataBlocks),
+		"--salt=" + p.Salt,
+		dataDev,
+		name,
+		hashDev,
+		p.RootHash,
+	}
+	if err := veritysetup(args...); err != nil {
+		return "", err
+	}
+	sylog.Debugf("Successfully opened verity device %s", name)
+	return name, nil
+}
+
+// Close removes the verity device name.
+func Close(name string) error {
+	fd, err := lock.Exclusive("/dev/mapper")
+	if err != nil {
+		return err
+	}
+	defer lock.Release(fd)
+
+	return veritysetup("close", name)
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package verity
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/sylabs/sif/pkg/sif"
+	"github.com/sylabs/singularity/pkg/sylog"
+)
+
+// AddToSIF adds the hash tree and its parameters to the squashfs system
+// partitions of the image which don't have one yet. They're added to
+// the group of the partition and linked to it, so the signature of the
+// group covers the root hash.
+func AddToSIF(fimg *sif.FileImage) error {
+	var parts []sif.Descriptor
+	for _, d := range fimg.DescrArr {
+		if !d.Used || d.Datatype != sif.DataPartition {
+			continue
+		}
+		if ptype, err := d.GetPartType(); err != nil || (ptype != sif.PartPrimSys && ptype != sif.PartSystem) {
+			continue
+		}
+		if fstype, err := d.GetFsType(); err != nil || fstype != sif.FsSquash {
+			continue
+		}
+		if _, _, err := FromSIF(fimg.DescrArr, fimg.Fp, d.ID); err == nil {
+			sylog.Debugf("Partition %d already has a dm-verity hash tree", d.ID)
+			continue
+		}
+		parts = append(parts, d)
+	}
+	if len(parts) == 0 {
+		return fmt.Errorf("no squashfs system partition without dm-verity hash tree found")
+	}
+
+	for _, d := range parts {
+		tree, p, err := HashTree(io.NewSectionReader(fimg.Fp, d.Fileoff, d.Filelen), d.Filelen)
+		if err != nil {
+			return fmt.Errorf("while computing hash tree of partition %d: %v", d.ID, err)
+		}
+		params, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+
+		objects := []struct {
+			datatype sif.Datatype
+			name     string
+			data     []byte
+		}{
+			{sif.DataGeneric, HashTreeName, tree},
+			{sif.DataGenericJSON, ParamsName, params},
+		}
+		for _, o := range objects {
+			input := sif.DescriptorInput{
+				Datatype: o.datatype,
+				Groupid:  d.Groupid,
+				Link:     d.ID,
+				Size:     int64(len(o.data)),
+				Fname:    o.name,
+				Fp:       bytes.NewReader(o.data),
+			}
+			if err := fimg.AddObject(input); err != nil {
+				return fmt.Errorf("while adding %s object: %v", o.name, err)
+			}
+		}
+		sylog.Verbosef("Added dm-verity hash tree of partition %d, root hash %s", d.ID, p.RootHash)
+	}
+	return nil
+}
+
+// FromSIF returns the parameters of the hash tree of the partition id
+// and the descriptor of the hash tree, r reads the image.
+func FromSIF(descrs []sif.Descriptor, r io.ReaderAt, id uint32) (*Params, *sif.Descriptor, error) {
+	var params, tree *sif.Descriptor
+	for i, d := range descrs {
+		if !d.Used || d.Link != id {
+			continue
+		}
+		switch {
+		case d.Datatype == sif.DataGeneric && d.GetName() == HashTreeName:
+			tree = &descrs[i]
+		case d.Datatype == sif.DataGenericJSON && d.GetName() == ParamsName:
+			params = &descrs[i]
+		}
+	}
+	if params == nil {
+		return nil, nil, fmt.Errorf("no dm-verity hash tree found for partition %d", id)
+	}
+
+	b := make([]byte, params.Filelen)
+	if _, err := r.ReadAt(b, params.Fileoff); err != nil {
+		return nil, nil, fmt.Errorf("while reading %s: %v", ParamsName, err)
+	}
+	p := new(Params)
+	if err := json.Unmarshal(b, p); err != nil {
+		return nil, nil, fmt.Errorf("while decoding %s: %v", ParamsName, err)
+	}
+	if tree == nil {
+		return nil, nil, fmt.Errorf("dm-verity hash tree of partition %d is missing", id)
+	}
+	p.HashOffset = uint64(tree.Fileoff)
+	p.HashSize = uint64(tree.Filelen)
+	return p, tree, nil
+}
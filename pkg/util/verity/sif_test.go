@@ -0,0 +1,86 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package verity
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	uuid "github.com/satori/go.uuid"
+	"github.com/sylabs/sif/pkg/sif"
+)
+
+func TestAddToSIF(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verity-sif-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	part := bytes.Repeat([]byte("rootfs"), 4*BlockSize)
+	input := sif.DescriptorInput{
+		Datatype: sif.DataPartition,
+		Groupid:  sif.DescrDefaultGroup,
+		Link:     sif.DescrUnusedLink,
+		Size:     int64(len(part)),
+		Fname:    "rootfs",
+		Fp:       bytes.NewReader(part),
+	}
+	if err := input.SetPartExtra(sif.FsSquash, sif.PartPrimSys, sif.GetSIFArch(runtime.GOARCH)); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "image.sif")
+	_, err = sif.CreateContainer(sif.CreateInfo{
+		Pathname:   path,
+		Launchstr:  sif.HdrLaunch,
+		Sifversion: sif.HdrVersion,
+		ID:         uuid.NewV4(),
+		InputDescr: []sif.DescriptorInput{input},
+	})
+	if err != nil {
+		t.Fatalf("while creating SIF image: %v", err)
+	}
+	// the image file is closed once created, reopen it for writing
+	fimg, err := sif.LoadContainer(path, false)
+	if err != nil {
+		t.Fatalf("while loading SIF image: %v", err)
+	}
+	defer fimg.UnloadContainer()
+
+	prim, _, err := fimg.GetPartPrimSys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := FromSIF(fimg.DescrArr, fimg.Fp, prim.ID); err == nil {
+		t.Errorf("unexpected hash tree before adding it")
+	}
+
+	if err := AddToSIF(&fimg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := AddToSIF(&fimg); err == nil {
+		t.Errorf("unexpected success for a partition with a hash tree")
+	}
+
+	p, tree, err := FromSIF(fimg.DescrArr, fimg.Fp, prim.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if tree.Groupid != prim.Groupid {
+		t.Errorf("unexpected hash tree group %x instead of %x", tree.Groupid, prim.Groupid)
+	}
+	if p.DataBlocks != int64(len(part)/BlockSize) {
+		t.Errorf("unexpected data blocks %d", p.DataBlocks)
+	}
+	if p.HashOffset != uint64(tree.Fileoff) || p.HashSize != BlockSize {
+		t.Errorf("unexpected hash tree location %d/%d", p.HashOffset, p.HashSize)
+	}
+}
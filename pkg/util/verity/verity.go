@@ -0,0 +1,131 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package verity creates the dm-verity hash trees protecting the root
+// filesystem partitions of SIF images and sets up the verity devices
+// checking them at read time.
+package verity
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+const (
+	// BlockSize is the size of the data and hash blocks.
+	BlockSize = 4096
+	// Algorithm is the hash algorithm of the hash tree.
+	Algorithm = "sha256"
+	// HashTreeName is the name of the SIF data object holding the hash
+	// tree of a partition.
+	HashTreeName = "verity"
+	// ParamsName is the name of the SIF JSON object holding the
+	// parameters of the hash tree of a partition.
+	ParamsName = "verity.json"
+
+	saltSize = 32
+)
+
+// Params are the dm-verity parameters of a hash tree, they're stored
+// along with it as veritysetup is used without superblock.
+type Params struct {
+	Algorithm     string `json:"algorithm"`
+	DataBlockSize int    `json:"dataBlockSize"`
+	HashBlockSize int    `json:"hashBlockSize"`
+	DataBlocks    int64  `json:"dataBlocks"`
+	Salt          string `json:"salt"`
+	RootHash      string `json:"rootHash"`
+	// HashOffset and HashSize locate the hash tree in the image, they're
+	// set by the runtime and not stored.
+	HashOffset uint64 `json:"hashOffset,omitempty"`
+	HashSize   uint64 `json:"hashSize,omitempty"`
+}
+
+// HashTree returns the dm-verity hash tree of the size bytes of data
+// read from r, along with its parameters. The size must be a multiple
+// of BlockSize, squashfs images are padded to 4KiB by mksquashfs, and
+// the data must hold at least two blocks.
+func HashTree(r io.ReaderAt, size int64) ([]byte, *Params, error) {
+	if size%BlockSize != 0 {
+		return nil, nil, fmt.Errorf("data size %d is not a multiple of %d bytes", size, BlockSize)
+	} else if size < 2*BlockSize {
+		return nil, nil, fmt.Errorf("data size %d is too small for a hash tree", size)
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, nil, fmt.Errorf("while generating salt: %v", err)
+	}
+
+	tree, root, err := hashTree(r, size, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p := &Params{
+		Algorithm:     Algorithm,
+		DataBlockSize: BlockSize,
+		HashBlockSize: BlockSize,
+		DataBlocks:    size / BlockSize,
+		Salt:          hex.EncodeToString(salt),
+		RootHash:      hex.EncodeToString(root),
+	}
+	return tree, p, nil
+}
+
+// hashBlock returns the salted hash of a block, the salt is prepended
+// with the version 1 format.
+func hashBlock(salt, block []byte) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write(block)
+	return h.Sum(nil)
+}
+
+// hashTree returns the hash tree and the root hash of the data. Each
+// level holds the hashes of the blocks of the level below padded to
+// whole blocks, the levels are stored from the top one down to the
+// hashes of the data blocks.
+func hashTree(r io.ReaderAt, size int64, salt []byte) ([]byte, []byte, error) {
+	var levels [][]byte
+
+	// the first level hashes the data blocks
+	var level []byte
+	block := make([]byte, BlockSize)
+	for off := int64(0); off < size; off += BlockSize {
+		if _, err := r.ReadAt(block, off); err != nil {
+			return nil, nil, fmt.Errorf("while reading data block at offset %d: %v", off, err)
+		}
+		level = append(level, hashBlock(salt, block)...)
+	}
+	level = padBlock(level)
+	levels = append(levels, level)
+
+	for len(level) > BlockSize {
+		var next []byte
+		for off := 0; off < len(level); off += BlockSize {
+			next = append(next, hashBlock(salt, level[off:off+BlockSize])...)
+		}
+		level = padBlock(next)
+		levels = append(levels, level)
+	}
+
+	var tree []byte
+	for i := len(levels) - 1; i >= 0; i-- {
+		tree = append(tree, levels[i]...)
+	}
+	return tree, hashBlock(salt, levels[len(levels)-1]), nil
+}
+
+// padBlock pads b with zeros to a whole number of blocks.
+func padBlock(b []byte) []byte {
+	if n := len(b) % BlockSize; n != 0 {
+		b = append(b, make([]byte, BlockSize-n)...)
+	}
+	return b
+}
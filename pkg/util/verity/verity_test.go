@@ -0,0 +1,76 @@
+// Copyright (c) 2020, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package verity
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+func TestHashTree(t *testing.T) {
+	tests := []struct {
+		name     string
+		size     int64
+		treeSize int
+		wantErr  bool
+	}{
+		{"Empty", 0, 0, true},
+		{"Unaligned", BlockSize + 1, 0, true},
+		{"SingleBlock", BlockSize, 0, true},
+		{"SingleLevel", 2 * BlockSize, BlockSize, false},
+		{"FullLevel", 128 * BlockSize, BlockSize, false},
+		// 129 hashes take 2 blocks hashed by the top level
+		{"TwoLevels", 129 * BlockSize, 3 * BlockSize, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := make([]byte, tt.size)
+			for i := range data {
+				data[i] = byte(i / BlockSize)
+			}
+
+			tree, p, err := HashTree(bytes.NewReader(data), tt.size)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("unexpected success")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tree) != tt.treeSize {
+				t.Errorf("unexpected hash tree size %d instead of %d", len(tree), tt.treeSize)
+			}
+			if p.DataBlocks != tt.size/BlockSize {
+				t.Errorf("unexpected data blocks %d", p.DataBlocks)
+			}
+
+			salt, err := hex.DecodeString(p.Salt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			h := sha256.New()
+			h.Write(salt)
+			h.Write(tree[:BlockSize])
+			if root := hex.EncodeToString(h.Sum(nil)); root != p.RootHash {
+				t.Errorf("root hash %s isn't the hash of the top block %s", p.RootHash, root)
+			}
+
+			// the last level holds the hash of the first data block
+			h.Reset()
+			h.Write(salt)
+			h.Write(data[:BlockSize])
+			leaves := tree[len(tree)-((int(p.DataBlocks)*sha256.Size+BlockSize-1)/BlockSize)*BlockSize:]
+			if !bytes.Equal(leaves[:sha256.Size], h.Sum(nil)) {
+				t.Errorf("unexpected hash of the first data block")
+			}
+		})
+	}
+}